@@ -5,6 +5,7 @@ set -e
 os_archs=(
     darwin/amd64
     freebsd/amd64
+    js/wasm
     linux/amd64
     solaris/amd64
     windows/amd64
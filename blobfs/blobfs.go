@@ -0,0 +1,514 @@
+// Package blobfs provides a billy filesystem stored in a bucket of blobs, to
+// use the same implementation with any object storage: Amazon S3, Google
+// Cloud Storage, Azure Blob Storage, or a local directory.
+//
+// The bucket is usually a *blob.Bucket of gocloud.dev, opened with
+// blob.OpenBucket from its URL, eg.: "s3://bucket?region=us-west-1",
+// "gs://bucket", "azblob://container" or "file:///path". The package
+// gopkg.in/src-d/go-billy.v4/blobfs/gocloud, a module of its own, provides
+// its Bucket and opens the filesystems from their URLs.
+//
+// Every file is a blob named by its path, eg.: "foo/bar.txt". The
+// directories are the common prefixes of the blobs, and the empty
+// directories are kept with an empty blob named by the path of the
+// directory with a trailing slash, eg.: "foo/", as done by most tools. The
+// buckets have no modes, symlinks, or atomic renames: the files have mode
+// 0644, the directories 0755, and renaming a directory copies all its
+// blobs.
+package blobfs // import "gopkg.in/src-d/go-billy.v4/blobfs"
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/internal/pathutil"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const defaultPageSize = 1000
+
+var (
+	// ErrNotEmpty is returned when removing a directory with files.
+	ErrNotEmpty = billy.ErrNotEmpty
+
+	errIsDir  = errors.New("is a directory")
+	errNotDir = errors.New("not a directory")
+)
+
+// Options holds the configuration of a blob filesystem.
+type Options struct {
+	// PageSize is the number of blobs listed in a request, 1000 by default.
+	PageSize int
+}
+
+// Blob is a filesystem stored in a bucket of blobs.
+type Blob struct {
+	bucket Bucket
+	opts   Options
+}
+
+// New returns a new filesystem stored in the given bucket.
+func New(bucket Bucket, opts Options) *Blob {
+	if opts.PageSize <= 0 {
+		opts.PageSize = defaultPageSize
+	}
+
+	return &Blob{bucket: bucket, opts: opts}
+}
+
+func (fs *Blob) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Blob) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file. The files opened for reading only are
+// streamed, the others are read on the first access, and kept in memory
+// until closed, when they are uploaded if modified. The new files are
+// created right away, so they can be found by other clients.
+func (fs *Blob) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	f, err := fs.openFile(pathutil.Relative(filename), clean(filename), flag)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+	}
+
+	return f, nil
+}
+
+func (fs *Blob) openFile(name, p string, flag int) (*file, error) {
+	fi, err := fs.stat(p)
+	switch {
+	case os.IsNotExist(err) && flag&os.O_CREATE != 0:
+		if err := fs.mkdirAll(path.Dir(p)); err != nil {
+			return nil, err
+		}
+
+		if err := fs.upload(key(p), nil); err != nil {
+			return nil, err
+		}
+
+		f := newFile(fs, name, p, flag, &fileInfo{name: path.Base(p), mode: 0644, modTime: time.Now()})
+		f.Loaded = true
+		return f, nil
+	case err != nil:
+		return nil, err
+	case flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, os.ErrExist
+	case fi.IsDir():
+		return nil, errIsDir
+	}
+
+	f := newFile(fs, name, p, flag, fi)
+	if isWrite(flag) && flag&os.O_TRUNC != 0 {
+		f.Loaded, f.Dirty = true, true
+	}
+
+	return f, nil
+}
+
+// upload writes the given blob.
+func (fs *Blob) upload(key string, content []byte) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := fs.bucket.NewWriter(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(content); err != nil {
+		// the write is aborted, keeping the previous content
+		cancel()
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
+// download reads the given blob.
+func (fs *Blob) download(key string) ([]byte, error) {
+	r, err := fs.bucket.NewRangeReader(context.Background(), key, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// list returns all the blobs with the given prefix, or the page with up to
+// max blobs if max isn't zero.
+func (fs *Blob) list(prefix, delimiter string, max int) ([]*ListObject, error) {
+	size := fs.opts.PageSize
+	if max > 0 {
+		size = max
+	}
+
+	var objs []*ListObject
+	var token []byte
+	for {
+		page, next, err := fs.bucket.ListPage(context.Background(), prefix, delimiter, token, size)
+		if err != nil {
+			return nil, err
+		}
+
+		objs = append(objs, page...)
+		if next == nil || max > 0 {
+			return objs, nil
+		}
+
+		token = next
+	}
+}
+
+// stat returns the file or directory of the given path, a directory being
+// either an empty blob named with a trailing slash, or a common prefix.
+func (fs *Blob) stat(p string) (*fileInfo, error) {
+	name := path.Base(p)
+	if p == "/" {
+		return &fileInfo{name: name, mode: os.ModeDir | 0755}, nil
+	}
+
+	ctx := context.Background()
+	a, err := fs.bucket.Attributes(ctx, key(p))
+	if err == nil {
+		return &fileInfo{name: name, size: a.Size, mode: 0644, modTime: a.ModTime}, nil
+	}
+
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	dir := &fileInfo{name: name, mode: os.ModeDir | 0755}
+	a, err = fs.bucket.Attributes(ctx, key(p)+"/")
+	if err == nil {
+		dir.modTime = a.ModTime
+		return dir, nil
+	}
+
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	objs, err := fs.list(key(p)+"/", "", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(objs) == 0 {
+		return nil, os.ErrNotExist
+	}
+
+	return dir, nil
+}
+
+func (fs *Blob) Stat(filename string) (os.FileInfo, error) {
+	fi, err := fs.stat(clean(filename))
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: filename, Err: err}
+	}
+
+	return fi, nil
+}
+
+// Lstat is equivalent to Stat, since the buckets have no symlinks.
+func (fs *Blob) Lstat(filename string) (os.FileInfo, error) {
+	return fs.Stat(filename)
+}
+
+// ReadDir lists the blobs and directories of the given directory, sorted by
+// name. A file hides a directory with the same name.
+func (fs *Blob) ReadDir(filename string) ([]os.FileInfo, error) {
+	infos, err := fs.readDir(clean(filename))
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: filename, Err: err}
+	}
+
+	return infos, nil
+}
+
+func (fs *Blob) readDir(p string) ([]os.FileInfo, error) {
+	fi, err := fs.stat(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if !fi.IsDir() {
+		return nil, errNotDir
+	}
+
+	prefix := dirPrefix(p)
+	objs, err := fs.list(prefix, "/", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]*fileInfo)
+	for _, obj := range objs {
+		name := strings.TrimSuffix(obj.Key[len(prefix):], "/")
+		if name == "" || obj.IsDir && files[name] != nil {
+			continue
+		}
+
+		fi := &fileInfo{name: name, size: obj.Size, mode: 0644, modTime: obj.ModTime}
+		if obj.IsDir {
+			fi.size, fi.mode = 0, os.ModeDir|0755
+		}
+
+		files[name] = fi
+	}
+
+	infos := make([]os.FileInfo, 0, len(files))
+	for _, fi := range files {
+		infos = append(infos, fi)
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+// MkdirAll creates the directory and any missing parent, with empty blobs.
+// The permissions are ignored.
+func (fs *Blob) MkdirAll(filename string, perm os.FileMode) error {
+	if err := fs.mkdirAll(clean(filename)); err != nil {
+		return &os.PathError{Op: "mkdir", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Blob) mkdirAll(p string) error {
+	dir := "/"
+	for _, name := range strings.Split(strings.Trim(p, "/"), "/") {
+		if name == "" {
+			continue
+		}
+
+		dir = path.Join(dir, name)
+		fi, err := fs.stat(dir)
+		switch {
+		case err == nil && !fi.IsDir():
+			return errNotDir
+		case err == nil:
+			continue
+		case !os.IsNotExist(err):
+			return err
+		}
+
+		if err := fs.upload(dirPrefix(dir), nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (fs *Blob) Remove(filename string) error {
+	if err := fs.remove(clean(filename)); err != nil {
+		return &os.PathError{Op: "remove", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Blob) remove(p string) error {
+	if p == "/" {
+		return errors.New("cannot remove the root")
+	}
+
+	fi, err := fs.stat(p)
+	if err != nil {
+		return err
+	}
+
+	if !fi.IsDir() {
+		return fs.bucket.Delete(context.Background(), key(p))
+	}
+
+	objs, err := fs.list(dirPrefix(p), "", 2)
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range objs {
+		if obj.Key != dirPrefix(p) {
+			return ErrNotEmpty
+		}
+	}
+
+	return fs.bucket.Delete(context.Background(), dirPrefix(p))
+}
+
+// Rename copies the given file, or all the blobs of the given directory, to
+// the new path, removing them afterwards. Since it's not atomic, other
+// clients may see both paths.
+func (fs *Blob) Rename(from, to string) error {
+	if err := fs.rename(clean(from), clean(to)); err != nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Blob) rename(from, to string) error {
+	if from == "/" || to == "/" {
+		return errors.New("cannot rename the root")
+	}
+
+	src, err := fs.stat(from)
+	if err != nil {
+		return err
+	}
+
+	if from == to {
+		return nil
+	}
+
+	if strings.HasPrefix(to, from+"/") {
+		return errors.New("cannot move a directory into itself")
+	}
+
+	dst, err := fs.stat(to)
+	switch {
+	case err == nil && dst.IsDir() != src.IsDir():
+		return os.ErrExist
+	case err == nil && dst.IsDir():
+		if err := fs.remove(to); err != nil {
+			return err
+		}
+	case err != nil && !os.IsNotExist(err):
+		return err
+	}
+
+	if err := fs.mkdirAll(path.Dir(to)); err != nil {
+		return err
+	}
+
+	if !src.IsDir() {
+		return fs.move(key(to), key(from))
+	}
+
+	objs, err := fs.list(dirPrefix(from), "", 0)
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range objs {
+		if err := fs.move(dirPrefix(to)+obj.Key[len(dirPrefix(from)):], obj.Key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// move copies a blob, and deletes the source.
+func (fs *Blob) move(dstKey, srcKey string) error {
+	ctx := context.Background()
+	if err := fs.bucket.Copy(ctx, dstKey, srcKey); err != nil {
+		return err
+	}
+
+	return fs.bucket.Delete(ctx, srcKey)
+}
+
+// Copy implements util.Copier, copying the blob of the given file in the
+// bucket, without downloading it. The missing parents of to are created.
+func (fs *Blob) Copy(from, to string) error {
+	if err := fs.copy(clean(from), clean(to)); err != nil {
+		return &os.LinkError{Op: "copy", Old: from, New: to, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Blob) copy(from, to string) error {
+	src, err := fs.stat(from)
+	if err != nil {
+		return err
+	}
+
+	if src.IsDir() {
+		return errIsDir
+	}
+
+	dst, err := fs.stat(to)
+	switch {
+	case err == nil && dst.IsDir():
+		return errIsDir
+	case err != nil && !os.IsNotExist(err):
+		return err
+	}
+
+	if err := fs.mkdirAll(path.Dir(to)); err != nil {
+		return err
+	}
+
+	return fs.bucket.Copy(context.Background(), key(to), key(from))
+}
+
+func (fs *Blob) Symlink(target, link string) error {
+	return billy.ErrNotSupported
+}
+
+func (fs *Blob) Readlink(link string) (string, error) {
+	return "", billy.ErrNotSupported
+}
+
+func (fs *Blob) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(fs, dir, prefix)
+}
+
+func (fs *Blob) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (fs *Blob) Chroot(p string) (billy.Filesystem, error) {
+	return chroot.New(fs, fs.Join(fs.Root(), p)), nil
+}
+
+func (fs *Blob) Root() string {
+	return string(filepath.Separator)
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Blob) Capabilities() billy.Capability {
+	return billy.WriteCapability | billy.ReadCapability |
+		billy.ReadAndWriteCapability | billy.SeekCapability |
+		billy.TruncateCapability
+}
+
+func clean(p string) string {
+	return path.Clean("/" + filepath.ToSlash(p))
+}
+
+// key returns the key of the blob of the given path.
+func key(p string) string {
+	return p[1:]
+}
+
+// dirPrefix returns the prefix of the keys of the blobs in the given
+// directory, also the key of its empty blob.
+func dirPrefix(p string) string {
+	if p == "/" {
+		return ""
+	}
+
+	return key(p) + "/"
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR) != 0
+}
@@ -0,0 +1,215 @@
+package blobfs
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&BlobSuite{})
+
+// BlobSuite runs the generic suites, except the symlinks one, since the
+// buckets don't support symlinks.
+type BlobSuite struct {
+	test.BasicSuite
+	test.DirSuite
+	test.TempFileSuite
+	test.ChrootSuite
+
+	FS     *Blob
+	bucket *memBucket
+}
+
+func (s *BlobSuite) SetUpTest(c *C) {
+	s.bucket = newMemBucket()
+	s.FS = New(s.bucket, Options{})
+
+	s.BasicSuite.FS = s.FS
+	s.DirSuite.FS = s.FS
+	s.TempFileSuite.FS = s.FS
+	s.ChrootSuite.FS = s.FS
+}
+
+func (s *BlobSuite) TestNew(c *C) {
+	c.Assert(s.FS.opts.PageSize, Equals, defaultPageSize)
+}
+
+func (s *BlobSuite) TestKeys(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar", []byte("foo"), 0644), IsNil)
+	c.Assert(s.FS.MkdirAll("qux", 0755), IsNil)
+
+	keys := make([]string, 0)
+	for key := range s.bucket.blobs {
+		keys = append(keys, key)
+	}
+
+	c.Assert(keys, HasLen, 3)
+	c.Assert(s.bucket.blobs["foo/"], NotNil)
+	c.Assert(string(s.bucket.blobs["foo/bar"].content), Equals, "foo")
+	c.Assert(s.bucket.blobs["qux/"], NotNil)
+}
+
+func (s *BlobSuite) TestImplicitDirs(c *C) {
+	s.bucket.blobs["foo/bar/baz"] = &memBlob{content: []byte("baz")}
+	s.bucket.blobs["foo/qux"] = &memBlob{content: []byte("qux")}
+
+	fi, err := s.FS.Stat("foo/bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+
+	infos, err := s.FS.ReadDir("foo")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 2)
+	c.Assert(infos[0].Name(), Equals, "bar")
+	c.Assert(infos[0].IsDir(), Equals, true)
+	c.Assert(infos[1].Name(), Equals, "qux")
+	c.Assert(infos[1].Size(), Equals, int64(3))
+
+	err = s.FS.Remove("foo/bar")
+	c.Assert(err.(*os.PathError).Err, Equals, ErrNotEmpty)
+
+	c.Assert(s.FS.Remove("foo/bar/baz"), IsNil)
+	_, err = s.FS.Stat("foo/bar")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *BlobSuite) TestReadDirPages(c *C) {
+	fs := New(s.bucket, Options{PageSize: 2})
+	for _, name := range []string{"a", "b", "c/d", "c/e", "f"} {
+		c.Assert(util.WriteFile(fs, name, nil, 0644), IsNil)
+	}
+
+	before := s.bucket.count("ListPage")
+	infos, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 4)
+	c.Assert(infos[2].Name(), Equals, "c")
+	c.Assert(s.bucket.count("ListPage")-before, Equals, 2)
+}
+
+func (s *BlobSuite) TestRenameDir(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar", []byte("bar"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "foo/qux/baz", []byte("baz"), 0644), IsNil)
+
+	c.Assert(s.FS.Rename("foo", "new/foo"), IsNil)
+	c.Assert(s.bucket.count("Copy"), Equals, 4)
+
+	c.Assert(test.ReadFile(c, s.FS, "new/foo/bar"), Equals, "bar")
+	c.Assert(test.ReadFile(c, s.FS, "new/foo/qux/baz"), Equals, "baz")
+
+	_, err := s.FS.Stat("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	err = s.FS.Rename("new", "new/foo/bar")
+	c.Assert(err, NotNil)
+}
+
+func (s *BlobSuite) TestRenameReplace(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "bar", []byte("bar"), 0644), IsNil)
+	c.Assert(s.FS.Rename("foo", "bar"), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "bar"), Equals, "foo")
+
+	c.Assert(s.FS.MkdirAll("qux", 0755), IsNil)
+	err := s.FS.Rename("bar", "qux")
+	c.Assert(os.IsExist(err.(*os.LinkError).Err), Equals, true)
+}
+
+func (s *BlobSuite) TestCopy(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+
+	c.Assert(util.CopyFile(s.FS, "bar/qux", s.FS, "foo", 0), IsNil)
+	c.Assert(s.bucket.count("Copy"), Equals, 1)
+	c.Assert(test.ReadFile(c, s.FS, "bar/qux"), Equals, "foo")
+
+	err := s.FS.Copy("bar", "baz")
+	c.Assert(err.(*os.LinkError).Err, Equals, errIsDir)
+	err = s.FS.Copy("foo", "bar")
+	c.Assert(err.(*os.LinkError).Err, Equals, errIsDir)
+	err = s.FS.Copy("missing", "baz")
+	c.Assert(os.IsNotExist(err.(*os.LinkError).Err), Equals, true)
+}
+
+func (s *BlobSuite) TestRemoveNotEmpty(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar", nil, 0644), IsNil)
+
+	err := s.FS.Remove("foo")
+	c.Assert(err.(*os.PathError).Err, Equals, ErrNotEmpty)
+}
+
+func (s *BlobSuite) TestStreaming(c *C) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	c.Assert(util.WriteFile(s.FS, "foo", content, 0644), IsNil)
+
+	f, err := s.FS.Open("foo")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	buf := make([]byte, 10)
+	for i := 0; i < 10; i++ {
+		_, err := io.ReadFull(f, buf)
+		c.Assert(err, IsNil)
+	}
+
+	c.Assert(s.bucket.count("NewRangeReader"), Equals, 1)
+
+	_, err = f.Seek(995, io.SeekStart)
+	c.Assert(err, IsNil)
+	n, err := f.Read(buf)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf[:n]), Equals, "56789")
+	c.Assert(s.bucket.count("NewRangeReader"), Equals, 2)
+
+	n, err = f.ReadAt(buf, 10)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf[:n]), Equals, "0123456789")
+
+	_, err = f.Read(buf)
+	c.Assert(err, Equals, io.EOF)
+}
+
+func (s *BlobSuite) TestAbortedWrite(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+
+	f, err := s.FS.OpenFile("foo", os.O_WRONLY|os.O_TRUNC, 0)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("bar"))
+	c.Assert(err, IsNil)
+
+	s.bucket.failWrite = errWrite
+	err = f.Close()
+	c.Assert(err.(*os.PathError).Err, Equals, errWrite)
+
+	s.bucket.failWrite = nil
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "foo")
+}
+
+// TestStat overrides the one of BasicSuite, since the buckets don't support
+// file modes.
+func (s *BlobSuite) TestStat(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar", []byte("foo"), 0644), IsNil)
+
+	fi, err := s.FS.Stat("foo/bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Name(), Equals, "bar")
+	c.Assert(fi.Size(), Equals, int64(3))
+	c.Assert(fi.ModTime().IsZero(), Equals, false)
+	c.Assert(fi.IsDir(), Equals, false)
+}
+
+func (s *BlobSuite) TestOpenFileWithModes(c *C) {
+	c.Skip("the buckets don't support file modes")
+}
+
+func (s *BlobSuite) TestSymlink(c *C) {
+	c.Assert(s.FS.Symlink("foo", "bar"), Equals, billy.ErrNotSupported)
+}
@@ -0,0 +1,46 @@
+package blobfs
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Bucket is a bucket of blobs, the subset of a *blob.Bucket of gocloud.dev
+// used by the filesystem. The errors of the blobs not found must satisfy
+// os.IsNotExist.
+type Bucket interface {
+	// Attributes returns the attributes of the given blob.
+	Attributes(ctx context.Context, key string) (*Attributes, error)
+	// NewRangeReader returns a reader of length bytes of the given blob,
+	// from the given offset, or up to the end if length is negative.
+	NewRangeReader(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	// NewWriter returns a writer of the given blob, replaced once the
+	// writer is closed. The write is aborted if the context is canceled
+	// before closing the writer.
+	NewWriter(ctx context.Context, key string) (io.WriteCloser, error)
+	// ListPage returns a page of the blobs whose keys start with the given
+	// prefix, sorted by key, and the token of the next page, nil if it's
+	// the last one. If the delimiter isn't empty, the keys with the
+	// delimiter after the prefix are grouped in directories, with the
+	// prefix up to the delimiter as key.
+	ListPage(ctx context.Context, prefix, delimiter string, pageToken []byte, pageSize int) ([]*ListObject, []byte, error)
+	// Copy copies the blob srcKey to dstKey, replacing it if it exists.
+	Copy(ctx context.Context, dstKey, srcKey string) error
+	// Delete deletes the given blob.
+	Delete(ctx context.Context, key string) error
+}
+
+// Attributes are the attributes of a blob.
+type Attributes struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// ListObject is a blob, or a directory, returned by Bucket.ListPage.
+type ListObject struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+	IsDir   bool
+}
@@ -0,0 +1,194 @@
+package blobfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memBucket is a Bucket kept in memory, counting the calls of every method.
+type memBucket struct {
+	sync.Mutex
+	blobs map[string]*memBlob
+	calls map[string]int
+
+	// failWrite makes the writes fail, if set.
+	failWrite error
+}
+
+type memBlob struct {
+	content []byte
+	modTime time.Time
+}
+
+func newMemBucket() *memBucket {
+	return &memBucket{
+		blobs: make(map[string]*memBlob),
+		calls: make(map[string]int),
+	}
+}
+
+func (b *memBucket) count(method string) int {
+	b.Lock()
+	defer b.Unlock()
+	return b.calls[method]
+}
+
+func (b *memBucket) get(key string) (*memBlob, error) {
+	blob, ok := b.blobs[key]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	return blob, nil
+}
+
+func (b *memBucket) Attributes(ctx context.Context, key string) (*Attributes, error) {
+	b.Lock()
+	defer b.Unlock()
+	b.calls["Attributes"]++
+
+	blob, err := b.get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Attributes{Size: int64(len(blob.content)), ModTime: blob.modTime}, nil
+}
+
+func (b *memBucket) NewRangeReader(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	b.Lock()
+	defer b.Unlock()
+	b.calls["NewRangeReader"]++
+
+	blob, err := b.get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	content := blob.content
+	if offset > int64(len(content)) {
+		offset = int64(len(content))
+	}
+
+	content = content[offset:]
+	if length >= 0 && length < int64(len(content)) {
+		content = content[:length]
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(content)), nil
+}
+
+func (b *memBucket) NewWriter(ctx context.Context, key string) (io.WriteCloser, error) {
+	b.Lock()
+	defer b.Unlock()
+	b.calls["NewWriter"]++
+
+	return &memWriter{ctx: ctx, bucket: b, key: key}, nil
+}
+
+type memWriter struct {
+	ctx    context.Context
+	bucket *memBucket
+	key    string
+	buf    bytes.Buffer
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	w.bucket.Lock()
+	err := w.bucket.failWrite
+	w.bucket.Unlock()
+
+	if err != nil {
+		return 0, err
+	}
+
+	return w.buf.Write(p)
+}
+
+func (w *memWriter) Close() error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+
+	w.bucket.Lock()
+	defer w.bucket.Unlock()
+	w.bucket.blobs[w.key] = &memBlob{content: w.buf.Bytes(), modTime: time.Now()}
+	return nil
+}
+
+func (b *memBucket) ListPage(ctx context.Context, prefix, delimiter string, pageToken []byte, pageSize int) ([]*ListObject, []byte, error) {
+	b.Lock()
+	defer b.Unlock()
+	b.calls["ListPage"]++
+
+	keys := make([]string, 0, len(b.blobs))
+	for key := range b.blobs {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	var objs []*ListObject
+	for _, key := range keys {
+		obj := &ListObject{Key: key, Size: int64(len(b.blobs[key].content)), ModTime: b.blobs[key].modTime}
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				obj = &ListObject{Key: key[:len(prefix)+i+len(delimiter)], IsDir: true}
+			}
+		}
+
+		if len(objs) != 0 && objs[len(objs)-1].Key == obj.Key {
+			continue
+		}
+
+		if obj.Key > string(pageToken) {
+			objs = append(objs, obj)
+		}
+	}
+
+	if pageSize <= 0 || len(objs) <= pageSize {
+		return objs, nil, nil
+	}
+
+	objs = objs[:pageSize]
+	return objs, []byte(objs[pageSize-1].Key), nil
+}
+
+func (b *memBucket) Copy(ctx context.Context, dstKey, srcKey string) error {
+	b.Lock()
+	defer b.Unlock()
+	b.calls["Copy"]++
+
+	blob, err := b.get(srcKey)
+	if err != nil {
+		return err
+	}
+
+	b.blobs[dstKey] = &memBlob{content: blob.content, modTime: time.Now()}
+	return nil
+}
+
+func (b *memBucket) Delete(ctx context.Context, key string) error {
+	b.Lock()
+	defer b.Unlock()
+	b.calls["Delete"]++
+
+	if _, err := b.get(key); err != nil {
+		return err
+	}
+
+	delete(b.blobs, key)
+	return nil
+}
+
+var errWrite = errors.New("write failed")
@@ -0,0 +1,205 @@
+package blobfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4/internal/memfile"
+)
+
+// file is a file of a blob filesystem. The files opened for reading only
+// stream their blob with range reads, the others download it on the first
+// access, keeping it in memory until closed, when it's uploaded if modified.
+type file struct {
+	*memfile.File
+
+	fs   *Blob
+	path string
+	info *fileInfo
+
+	// reader is the stream of the blob from its offset, reused while read
+	// sequentially.
+	reader io.ReadCloser
+	offset int64
+}
+
+func newFile(fs *Blob, name, p string, flag int, info *fileInfo) *file {
+	f := &file{fs: fs, path: p, info: info}
+	f.File = memfile.New(name, flag, f.load)
+	return f
+}
+
+func (f *file) load() ([]byte, error) {
+	content, err := f.fs.download(key(f.path))
+	if err != nil {
+		return nil, &os.PathError{Op: "read", Path: f.Name(), Err: err}
+	}
+
+	return content, nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if isWrite(f.Flag()) {
+		return f.File.Read(p)
+	}
+
+	if f.Closed {
+		return 0, os.ErrClosed
+	}
+
+	if f.Position >= f.info.size {
+		return 0, io.EOF
+	}
+
+	if f.reader != nil && f.offset != f.Position {
+		f.reader.Close()
+		f.reader = nil
+	}
+
+	if f.reader == nil {
+		r, err := f.fs.bucket.NewRangeReader(context.Background(), key(f.path), f.Position, -1)
+		if err != nil {
+			return 0, &os.PathError{Op: "read", Path: f.Name(), Err: err}
+		}
+
+		f.reader, f.offset = r, f.Position
+	}
+
+	n, err := f.reader.Read(p)
+	f.Position += int64(n)
+	f.offset = f.Position
+
+	if err == io.EOF && n != 0 {
+		err = nil
+	}
+
+	if err != nil && err != io.EOF {
+		err = &os.PathError{Op: "read", Path: f.Name(), Err: err}
+	}
+
+	return n, err
+}
+
+// ReadAt reads the given range of the blob with a new reader, unless the
+// file is opened for writing.
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if isWrite(f.Flag()) {
+		return f.File.ReadAt(p, off)
+	}
+
+	if f.Closed {
+		return 0, os.ErrClosed
+	}
+
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: f.Name(), Err: errors.New("negative offset")}
+	}
+
+	if off >= f.info.size {
+		return 0, io.EOF
+	}
+
+	r, err := f.fs.bucket.NewRangeReader(context.Background(), key(f.path), off, int64(len(p)))
+	if err != nil {
+		return 0, &os.PathError{Op: "readat", Path: f.Name(), Err: err}
+	}
+
+	defer r.Close()
+	n, err := io.ReadFull(r, p)
+	switch err {
+	case nil:
+		return n, nil
+	case io.ErrUnexpectedEOF, io.EOF:
+		return n, io.EOF
+	default:
+		return n, &os.PathError{Op: "readat", Path: f.Name(), Err: err}
+	}
+}
+
+// Seek seeks from the size of the blob, without downloading it, unless the
+// file is opened for writing.
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if whence == io.SeekEnd && !isWrite(f.Flag()) {
+		offset, whence = offset+f.info.size, io.SeekStart
+	}
+
+	return f.File.Seek(offset, whence)
+}
+
+// Close uploads the content of the file if it was modified. If the upload
+// fails, the blob keeps its previous content.
+func (f *file) Close() error {
+	if f.Closed {
+		return os.ErrClosed
+	}
+
+	f.Closed = true
+	defer func() { f.Content = nil }()
+	if f.reader != nil {
+		f.reader.Close()
+		f.reader = nil
+	}
+
+	if !f.Dirty {
+		return nil
+	}
+
+	if err := f.fs.upload(key(f.path), f.Content); err != nil {
+		return &os.PathError{Op: "close", Path: f.Name(), Err: err}
+	}
+
+	return nil
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	fi := *f.info
+	if f.Loaded {
+		fi.size = int64(len(f.Content))
+	}
+
+	return &fi, nil
+}
+
+// Lock is a no-op in blobfs.
+func (f *file) Lock() error {
+	return nil
+}
+
+// Unlock is a no-op in blobfs.
+func (f *file) Unlock() error {
+	return nil
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.size
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	return fi.mode
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.mode.IsDir()
+}
+
+func (*fileInfo) Sys() interface{} {
+	return nil
+}
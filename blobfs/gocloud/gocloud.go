@@ -0,0 +1,124 @@
+// Package gocloud provides the blobfs.Bucket of a *blob.Bucket of gocloud.dev,
+// to store a blobfs filesystem in any of the object stores it supports:
+// Amazon S3, Google Cloud Storage, Azure Blob Storage, or a local directory.
+//
+// It's a module of its own, so the dependencies of gocloud.dev are only
+// required by the programs using it. The drivers of the buckets opened by URL
+// must be registered by importing them, eg.:
+//
+//	import _ "gocloud.dev/blob/s3blob"
+package gocloud // import "gopkg.in/src-d/go-billy.v4/blobfs/gocloud"
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+	"gopkg.in/src-d/go-billy.v4/blobfs"
+)
+
+// Bucket is a blobfs.Bucket stored in a *blob.Bucket.
+type Bucket struct {
+	b *blob.Bucket
+}
+
+// NewBucket returns a new Bucket stored in the given *blob.Bucket.
+func NewBucket(b *blob.Bucket) *Bucket {
+	return &Bucket{b: b}
+}
+
+// Open returns a new filesystem stored in the bucket of the given URL, eg.:
+// "s3://bucket?region=us-west-1", "gs://bucket", "azblob://container" or
+// "file:///path", opened with blob.OpenBucket. The returned function closes
+// the bucket.
+func Open(ctx context.Context, url string, opts blobfs.Options) (*blobfs.Blob, func() error, error) {
+	b, err := blob.OpenBucket(ctx, url)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return blobfs.New(NewBucket(b), opts), b.Close, nil
+}
+
+// Attributes implements blobfs.Bucket.
+func (b *Bucket) Attributes(ctx context.Context, key string) (*blobfs.Attributes, error) {
+	attrs, err := b.b.Attributes(ctx, key)
+	if err != nil {
+		return nil, convertError(err)
+	}
+
+	return &blobfs.Attributes{Size: attrs.Size, ModTime: attrs.ModTime}, nil
+}
+
+// NewRangeReader implements blobfs.Bucket.
+func (b *Bucket) NewRangeReader(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	r, err := b.b.NewRangeReader(ctx, key, offset, length, nil)
+	if err != nil {
+		return nil, convertError(err)
+	}
+
+	return r, nil
+}
+
+// NewWriter implements blobfs.Bucket.
+func (b *Bucket) NewWriter(ctx context.Context, key string) (io.WriteCloser, error) {
+	w, err := b.b.NewWriter(ctx, key, nil)
+	if err != nil {
+		return nil, convertError(err)
+	}
+
+	return w, nil
+}
+
+// ListPage implements blobfs.Bucket.
+func (b *Bucket) ListPage(ctx context.Context, prefix, delimiter string, pageToken []byte, pageSize int) ([]*blobfs.ListObject, []byte, error) {
+	if pageToken == nil {
+		pageToken = blob.FirstPageToken
+	}
+
+	page, next, err := b.b.ListPage(ctx, pageToken, pageSize, &blob.ListOptions{
+		Prefix:    prefix,
+		Delimiter: delimiter,
+	})
+	if err != nil {
+		return nil, nil, convertError(err)
+	}
+
+	objs := make([]*blobfs.ListObject, len(page))
+	for i, obj := range page {
+		objs[i] = &blobfs.ListObject{
+			Key:     obj.Key,
+			Size:    obj.Size,
+			ModTime: obj.ModTime,
+			IsDir:   obj.IsDir,
+		}
+	}
+
+	if len(next) == 0 {
+		next = nil
+	}
+
+	return objs, next, nil
+}
+
+// Copy implements blobfs.Bucket.
+func (b *Bucket) Copy(ctx context.Context, dstKey, srcKey string) error {
+	return convertError(b.b.Copy(ctx, dstKey, srcKey, nil))
+}
+
+// Delete implements blobfs.Bucket.
+func (b *Bucket) Delete(ctx context.Context, key string) error {
+	return convertError(b.b.Delete(ctx, key))
+}
+
+// convertError returns os.ErrNotExist for the errors with the code
+// gcerrors.NotFound, as expected by blobfs.
+func convertError(err error) error {
+	if err != nil && gcerrors.Code(err) == gcerrors.NotFound {
+		return os.ErrNotExist
+	}
+
+	return err
+}
@@ -0,0 +1,70 @@
+package gocloud
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	_ "gocloud.dev/blob/fileblob"
+	"gocloud.dev/blob/memblob"
+	"gopkg.in/src-d/go-billy.v4/blobfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&GocloudSuite{})
+
+// GocloudSuite runs the generic suites over a bucket in memory, except the
+// symlinks one, since the buckets don't support symlinks.
+type GocloudSuite struct {
+	test.BasicSuite
+	test.DirSuite
+	test.TempFileSuite
+	test.ChrootSuite
+}
+
+func (s *GocloudSuite) SetUpTest(c *C) {
+	fs := blobfs.New(NewBucket(memblob.OpenBucket(nil)), blobfs.Options{PageSize: 2})
+
+	s.BasicSuite.FS = fs
+	s.DirSuite.FS = fs
+	s.TempFileSuite.FS = fs
+	s.ChrootSuite.FS = fs
+}
+
+func (s *GocloudSuite) TestOpen(c *C) {
+	fs, closeBucket, err := Open(context.Background(), "file://"+c.MkDir(), blobfs.Options{})
+	c.Assert(err, IsNil)
+	defer closeBucket()
+
+	c.Assert(fs.MkdirAll("foo/bar", 0755), IsNil)
+	fi, err := fs.Stat("foo/bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+
+	_, err = fs.Stat("missing")
+	c.Assert(err, NotNil)
+
+	_, _, err = Open(context.Background(), "unknown://bucket", blobfs.Options{})
+	c.Assert(err, NotNil)
+}
+
+// TestStat overrides the one of BasicSuite, since the buckets don't support
+// file modes.
+func (s *GocloudSuite) TestStat(c *C) {
+	c.Assert(util.WriteFile(s.BasicSuite.FS, "foo/bar", []byte("foo"), 0755), IsNil)
+
+	fi, err := s.BasicSuite.FS.Stat("foo/bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Name(), Equals, "bar")
+	c.Assert(fi.Size(), Equals, int64(3))
+	c.Assert(fi.Mode(), Equals, os.FileMode(0644))
+}
+
+func (s *GocloudSuite) TestOpenFileWithModes(c *C) {
+	c.Skip("the buckets don't support file modes")
+}
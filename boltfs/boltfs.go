@@ -0,0 +1,651 @@
+//go:build !js
+// +build !js
+
+// Package boltfs provides a billy filesystem stored in a bbolt database.
+//
+// The tree is kept in two buckets, one with the metadata of every file,
+// directory and symlink, keyed by its clean path, and other with the content
+// of the files and the targets of the symlinks. Every mutation is done in a
+// single transaction, and the files are written back when closed, so a crash
+// never leaves a file partially written or a directory partially renamed.
+//
+// It isn't built for js/wasm, where bbolt isn't supported.
+package boltfs // import "gopkg.in/src-d/go-billy.v4/boltfs"
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/internal/linkutil"
+	"gopkg.in/src-d/go-billy.v4/internal/memfile"
+	"gopkg.in/src-d/go-billy.v4/internal/pathutil"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const (
+	defaultBucket = "billy"
+	nodeSize      = 20
+)
+
+var (
+	metaBucket = []byte("meta")
+	dataBucket = []byte("data")
+)
+
+var (
+	// ErrNotEmpty is returned when removing a directory with files.
+	ErrNotEmpty = billy.ErrNotEmpty
+	// ErrCorrupted is returned when the metadata of a file stored in the
+	// database can't be decoded.
+	ErrCorrupted = errors.New("corrupted metadata")
+
+	errNotDir = errors.New("not a directory")
+)
+
+// Options holds the configuration of a Bolt filesystem.
+type Options struct {
+	// Bucket is the name of the top level bucket holding the filesystem,
+	// "billy" by default. Several filesystems can be stored in the same
+	// database using different buckets.
+	Bucket string
+}
+
+// Bolt is a filesystem stored in a bbolt database.
+type Bolt struct {
+	db     *bolt.DB
+	bucket []byte
+}
+
+// New returns a new filesystem stored in the given database, creating its
+// buckets if needed. The database is owned by the caller, who must close it
+// after closing every file.
+func New(db *bolt.DB, opts Options) (*Bolt, error) {
+	if opts.Bucket == "" {
+		opts.Bucket = defaultBucket
+	}
+
+	fs := &Bolt{db: db, bucket: []byte(opts.Bucket)}
+	err := db.Update(func(tx *bolt.Tx) error {
+		root, err := tx.CreateBucketIfNotExists(fs.bucket)
+		if err != nil {
+			return err
+		}
+
+		if _, err := root.CreateBucketIfNotExists(metaBucket); err != nil {
+			return err
+		}
+
+		_, err = root.CreateBucketIfNotExists(dataBucket)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return fs, nil
+}
+
+func (fs *Bolt) view(fn func(t *tree) error) error {
+	return fs.db.View(func(tx *bolt.Tx) error {
+		return fn(fs.tree(tx))
+	})
+}
+
+func (fs *Bolt) update(fn func(t *tree) error) error {
+	return fs.db.Update(func(tx *bolt.Tx) error {
+		return fn(fs.tree(tx))
+	})
+}
+
+func (fs *Bolt) tree(tx *bolt.Tx) *tree {
+	root := tx.Bucket(fs.bucket)
+	return &tree{meta: root.Bucket(metaBucket), data: root.Bucket(dataBucket)}
+}
+
+func (fs *Bolt) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Bolt) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (fs *Bolt) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	tx := fs.view
+	if flag&(os.O_CREATE|os.O_TRUNC) != 0 {
+		tx = fs.update
+	}
+
+	f := &file{File: memfile.New(pathutil.Relative(filename), flag, nil), fs: fs}
+	err := tx(func(t *tree) error {
+		key, n, err := t.follow(toKey(filename))
+		if os.IsNotExist(err) && flag&os.O_CREATE != 0 {
+			// the key is the target of the last link, even when it's
+			// dangling, so the target is created.
+			return f.create(t, key, perm)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+			return os.ErrExist
+		}
+
+		if n.mode.IsDir() {
+			return fmt.Errorf("cannot open directory: %s", filename)
+		}
+
+		f.key, f.mode = key, n.mode
+		if isWrite(flag) && flag&os.O_TRUNC != 0 {
+			n.size, n.modTime = 0, time.Now()
+			return t.putFile(key, n, nil)
+		}
+
+		f.Content = copyBytes(t.data.Get([]byte(key)))
+		return nil
+	})
+
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+	}
+
+	if flag&os.O_APPEND != 0 {
+		f.Position = int64(len(f.Content))
+	}
+
+	return f, nil
+}
+
+// store writes the content of a file closed after modified. If the file was
+// removed or replaced while open, the content is discarded.
+func (fs *Bolt) store(key string, content []byte) error {
+	return fs.update(func(t *tree) error {
+		n, err := t.get(key)
+		if err != nil || n == nil || !n.mode.IsRegular() {
+			return err
+		}
+
+		n.size, n.modTime = int64(len(content)), time.Now()
+		return t.putFile(key, n, content)
+	})
+}
+
+func (fs *Bolt) Stat(filename string) (os.FileInfo, error) {
+	return fs.stat("stat", filename, true)
+}
+
+func (fs *Bolt) Lstat(filename string) (os.FileInfo, error) {
+	return fs.stat("lstat", filename, false)
+}
+
+func (fs *Bolt) stat(op, filename string, follow bool) (os.FileInfo, error) {
+	var fi *fileInfo
+	err := fs.view(func(t *tree) error {
+		var n *node
+		var err error
+		if follow {
+			_, n, err = t.follow(toKey(filename))
+		} else {
+			n, err = t.lookup(toKey(filename))
+		}
+
+		if err != nil {
+			return err
+		}
+
+		// the name is the one of the stated file, even if it's a link.
+		fi = newFileInfo(filepath.Base(filename), n)
+		return nil
+	})
+
+	if err != nil {
+		return nil, &os.PathError{Op: op, Path: filename, Err: err}
+	}
+
+	return fi, nil
+}
+
+func (fs *Bolt) ReadDir(filename string) ([]os.FileInfo, error) {
+	var infos []os.FileInfo
+	err := fs.view(func(t *tree) error {
+		key, n, err := t.follow(toKey(filename))
+		if err != nil {
+			return err
+		}
+
+		if !n.mode.IsDir() {
+			return errNotDir
+		}
+
+		return t.children(key, func(name string, n *node) error {
+			infos = append(infos, newFileInfo(name, n))
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: filename, Err: err}
+	}
+
+	return infos, nil
+}
+
+func (fs *Bolt) MkdirAll(filename string, perm os.FileMode) error {
+	err := fs.update(func(t *tree) error {
+		return t.mkdirAll(toKey(filename), perm)
+	})
+
+	if err != nil {
+		return &os.PathError{Op: "mkdir", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+// Rename moves the given file, or directory with all its content, in a
+// single transaction.
+func (fs *Bolt) Rename(from, to string) error {
+	err := fs.update(func(t *tree) error {
+		return t.rename(toKey(from), toKey(to))
+	})
+
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Bolt) Remove(filename string) error {
+	err := fs.update(func(t *tree) error {
+		return t.remove(toKey(filename))
+	})
+
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Bolt) Symlink(target, link string) error {
+	err := fs.update(func(t *tree) error {
+		key := toKey(link)
+		n, err := t.get(key)
+		if err != nil {
+			return err
+		}
+
+		if n != nil {
+			return os.ErrExist
+		}
+
+		if err := t.mkdirAll(parent(key), 0755); err != nil {
+			return err
+		}
+
+		return t.putFile(key, &node{
+			mode:    os.ModeSymlink | 0777,
+			modTime: time.Now(),
+			size:    int64(len(target)),
+		}, []byte(target))
+	})
+
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Bolt) Readlink(link string) (string, error) {
+	var target string
+	err := fs.view(func(t *tree) error {
+		key := toKey(link)
+		n, err := t.lookup(key)
+		if err != nil {
+			return err
+		}
+
+		if !isSymlink(n.mode) {
+			return errors.New("not a symlink")
+		}
+
+		target = string(t.data.Get([]byte(key)))
+		return nil
+	})
+
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: link, Err: err}
+	}
+
+	return target, nil
+}
+
+func (fs *Bolt) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(fs, dir, prefix)
+}
+
+func (fs *Bolt) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (fs *Bolt) Chroot(p string) (billy.Filesystem, error) {
+	return chroot.New(fs, fs.Join(fs.Root(), p)), nil
+}
+
+func (fs *Bolt) Root() string {
+	return string(filepath.Separator)
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Bolt) Capabilities() billy.Capability {
+	return billy.WriteCapability | billy.ReadCapability |
+		billy.ReadAndWriteCapability | billy.SeekCapability |
+		billy.TruncateCapability
+}
+
+// node is the metadata of a file, directory or symlink, as stored in the
+// meta bucket.
+type node struct {
+	mode    os.FileMode
+	modTime time.Time
+	size    int64
+}
+
+func (n *node) encode() []byte {
+	b := make([]byte, nodeSize)
+	binary.BigEndian.PutUint32(b, uint32(n.mode))
+	binary.BigEndian.PutUint64(b[4:], uint64(n.modTime.UnixNano()))
+	binary.BigEndian.PutUint64(b[12:], uint64(n.size))
+	return b
+}
+
+func decodeNode(b []byte) (*node, error) {
+	if len(b) != nodeSize {
+		return nil, ErrCorrupted
+	}
+
+	return &node{
+		mode:    os.FileMode(binary.BigEndian.Uint32(b)),
+		modTime: time.Unix(0, int64(binary.BigEndian.Uint64(b[4:]))),
+		size:    int64(binary.BigEndian.Uint64(b[12:])),
+	}, nil
+}
+
+// tree gives access to the buckets of a filesystem within a transaction. The
+// keys are the clean paths without the leading separator, being the root the
+// empty key, which is never stored.
+type tree struct {
+	meta, data *bolt.Bucket
+}
+
+// get returns the node with the given key, or nil if it doesn't exist.
+func (t *tree) get(key string) (*node, error) {
+	if key == "" {
+		return &node{mode: os.ModeDir | 0755}, nil
+	}
+
+	v := t.meta.Get([]byte(key))
+	if v == nil {
+		return nil, nil
+	}
+
+	return decodeNode(v)
+}
+
+// lookup returns the node with the given key from the meta bucket, failing
+// with os.ErrNotExist if the key isn't there.
+func (t *tree) lookup(key string) (*node, error) {
+	n, err := t.get(key)
+	if err == nil && n == nil {
+		err = os.ErrNotExist
+	}
+
+	return n, err
+}
+
+// follow returns the node with the given key, reading the targets of the
+// links from the data bucket, where their content is stored. The key of the
+// last target is returned even if it doesn't exist.
+func (t *tree) follow(key string) (string, *node, error) {
+	var n *node
+	isLink := func(p string) (link bool, err error) {
+		n, err = t.lookup(p)
+		return err == nil && isSymlink(n.mode), err
+	}
+
+	key, err := linkutil.Follow(key, toKey, isLink, func(p string) (string, error) {
+		return string(t.data.Get([]byte(p))), nil
+	})
+	if err != nil {
+		return key, nil, err
+	}
+
+	return key, n, nil
+}
+
+func (t *tree) putFile(key string, n *node, content []byte) error {
+	if err := t.meta.Put([]byte(key), n.encode()); err != nil {
+		return err
+	}
+
+	return t.data.Put([]byte(key), content)
+}
+
+func (t *tree) delete(key string) error {
+	if err := t.meta.Delete([]byte(key)); err != nil {
+		return err
+	}
+
+	return t.data.Delete([]byte(key))
+}
+
+func (t *tree) mkdirAll(key string, perm os.FileMode) error {
+	if key == "" {
+		return nil
+	}
+
+	var dir string
+	for _, elem := range strings.Split(key, "/") {
+		dir = path.Join(dir, elem)
+		_, n, err := t.follow(dir)
+		if err == nil {
+			if !n.mode.IsDir() {
+				return errNotDir
+			}
+
+			continue
+		}
+
+		if !os.IsNotExist(err) {
+			return err
+		}
+
+		n = &node{mode: os.ModeDir | perm.Perm(), modTime: time.Now()}
+		if err := t.meta.Put([]byte(dir), n.encode()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// children calls fn with the name and node of every child of the given
+// directory, sorted by name. The descendants of the subdirectories are
+// skipped seeking past them.
+func (t *tree) children(key string, fn func(name string, n *node) error) error {
+	prefix := []byte(key + "/")
+	if key == "" {
+		prefix = nil
+	}
+
+	c := t.meta.Cursor()
+	k, v := c.Seek(prefix)
+	for k != nil && bytes.HasPrefix(k, prefix) {
+		name := string(k[len(prefix):])
+		if i := strings.IndexByte(name, '/'); i != -1 {
+			// '0' is the byte following '/', so it's the first key after
+			// every descendant of the child.
+			k, v = c.Seek(append(k[:len(prefix)+i:len(prefix)+i], '0'))
+			continue
+		}
+
+		n, err := decodeNode(v)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(name, n); err != nil {
+			return err
+		}
+
+		k, v = c.Next()
+	}
+
+	return nil
+}
+
+// descendants returns the keys of every node under the given directory.
+func (t *tree) descendants(key string) []string {
+	prefix := []byte(key + "/")
+
+	var keys []string
+	c := t.meta.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		keys = append(keys, string(k))
+	}
+
+	return keys
+}
+
+func (t *tree) isEmpty(key string) bool {
+	prefix := []byte(key + "/")
+	k, _ := t.meta.Cursor().Seek(prefix)
+	return k == nil || !bytes.HasPrefix(k, prefix)
+}
+
+func (t *tree) remove(key string) error {
+	if key == "" {
+		return errors.New("cannot remove the root")
+	}
+
+	n, err := t.lookup(key)
+	if err != nil {
+		return err
+	}
+
+	if n.mode.IsDir() && !t.isEmpty(key) {
+		return ErrNotEmpty
+	}
+
+	return t.delete(key)
+}
+
+func (t *tree) rename(from, to string) error {
+	if from == "" || to == "" {
+		return errors.New("cannot rename the root")
+	}
+
+	src, err := t.lookup(from)
+	if err != nil {
+		return err
+	}
+
+	if from == to {
+		return nil
+	}
+
+	if strings.HasPrefix(to, from+"/") {
+		return errors.New("cannot move a directory into itself")
+	}
+
+	dst, err := t.get(to)
+	if err != nil {
+		return err
+	}
+
+	if dst != nil {
+		if dst.mode.IsDir() != src.mode.IsDir() {
+			return os.ErrExist
+		}
+
+		if dst.mode.IsDir() && !t.isEmpty(to) {
+			return ErrNotEmpty
+		}
+	}
+
+	if err := t.mkdirAll(parent(to), 0755); err != nil {
+		return err
+	}
+
+	keys := append([]string{from}, t.descendants(from)...)
+	for _, key := range keys {
+		// the content is copied, since it's only valid until the
+		// transaction is modified.
+		n, err := t.lookup(key)
+		if err != nil {
+			return err
+		}
+
+		data := copyBytes(t.data.Get([]byte(key)))
+		if err := t.delete(key); err != nil {
+			return err
+		}
+
+		newKey := to + key[len(from):]
+		if n.mode.IsDir() {
+			err = t.meta.Put([]byte(newKey), n.encode())
+		} else {
+			err = t.putFile(newKey, n, data)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// toKey returns the key of the given path.
+func toKey(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(p)), "/")
+}
+
+func parent(key string) string {
+	if i := strings.LastIndexByte(key, '/'); i != -1 {
+		return key[:i]
+	}
+
+	return ""
+}
+
+func isSymlink(m os.FileMode) bool {
+	return m&os.ModeSymlink != 0
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR) != 0
+}
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+
+	return append([]byte{}, b...)
+}
@@ -0,0 +1,152 @@
+//go:build !js
+// +build !js
+
+package boltfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&BoltSuite{})
+
+type BoltSuite struct {
+	test.FilesystemSuite
+	path string
+	db   *bolt.DB
+}
+
+func (s *BoltSuite) SetUpTest(c *C) {
+	s.path = filepath.Join(c.MkDir(), "billy.db")
+	s.FilesystemSuite = test.NewFilesystemSuite(s.open(c))
+}
+
+func (s *BoltSuite) TearDownTest(c *C) {
+	c.Assert(s.db.Close(), IsNil)
+}
+
+func (s *BoltSuite) open(c *C) *Bolt {
+	var err error
+	// the writes aren't synced to disk, to speed up the tests.
+	s.db, err = bolt.Open(s.path, 0600, &bolt.Options{NoSync: true})
+	c.Assert(err, IsNil)
+
+	fs, err := New(s.db, Options{})
+	c.Assert(err, IsNil)
+	return fs
+}
+
+// reopen closes the database, opening it again.
+func (s *BoltSuite) reopen(c *C) *Bolt {
+	c.Assert(s.db.Close(), IsNil)
+	return s.open(c)
+}
+
+func (s *BoltSuite) TestPersistence(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar", []byte("foo"), 0640), IsNil)
+	c.Assert(s.FS.Symlink("bar", "foo/qux"), IsNil)
+
+	fs := s.reopen(c)
+	c.Assert(test.ReadFile(c, fs, "foo/qux"), Equals, "foo")
+
+	fi, err := fs.Stat("foo/bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode(), Equals, os.FileMode(0640))
+	c.Assert(fi.Size(), Equals, int64(3))
+
+	infos, err := fs.ReadDir("foo")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 2)
+	c.Assert(infos[0].Name(), Equals, "bar")
+	c.Assert(infos[1].Name(), Equals, "qux")
+	c.Assert(infos[1].Mode()&os.ModeSymlink, Not(Equals), os.FileMode(0))
+}
+
+func (s *BoltSuite) TestWriteOnClose(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+
+	f, err := s.FS.OpenFile("foo", os.O_WRONLY|os.O_APPEND, 0)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("bar"))
+	c.Assert(err, IsNil)
+
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "foo")
+	c.Assert(f.Close(), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "foobar")
+}
+
+func (s *BoltSuite) TestWriteAfterRemove(c *C) {
+	f, err := s.FS.Create("foo")
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("foo"))
+	c.Assert(err, IsNil)
+
+	c.Assert(s.FS.Remove("foo"), IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	_, err = s.FS.Stat("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *BoltSuite) TestRenameDir(c *C) {
+	for _, name := range []string{"foo/bar", "foo/bar/baz", "foo.txt", "foo0"} {
+		c.Assert(util.WriteFile(s.FS, name+"/qux", []byte(name), 0644), IsNil)
+	}
+
+	c.Assert(s.FS.Rename("foo", "new/foo"), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "new/foo/bar/baz/qux"), Equals, "foo/bar/baz")
+
+	infos, err := s.FS.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 3)
+	c.Assert(infos[0].Name(), Equals, "foo.txt")
+	c.Assert(infos[1].Name(), Equals, "foo0")
+	c.Assert(infos[2].Name(), Equals, "new")
+
+	infos, err = s.FS.ReadDir("new/foo/bar")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 2)
+	c.Assert(infos[0].Name(), Equals, "baz")
+	c.Assert(infos[1].Name(), Equals, "qux")
+
+	err = s.FS.Rename("new", "new/foo/new")
+	c.Assert(err, NotNil)
+}
+
+func (s *BoltSuite) TestRemoveNotEmpty(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar", []byte("foo"), 0644), IsNil)
+
+	err := s.FS.Remove("foo")
+	c.Assert(err.(*os.PathError).Err, Equals, ErrNotEmpty)
+}
+
+func (s *BoltSuite) TestBuckets(c *C) {
+	fs, err := New(s.db, Options{Bucket: "other"})
+	c.Assert(err, IsNil)
+
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(fs, "bar", []byte("bar"), 0644), IsNil)
+
+	_, err = fs.Stat("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	_, err = s.FS.Stat("bar")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *BoltSuite) TestSymlinkLoop(c *C) {
+	c.Assert(s.FS.Symlink("bar", "foo"), IsNil)
+	c.Assert(s.FS.Symlink("foo", "bar"), IsNil)
+
+	_, err := s.FS.Stat("foo")
+	c.Assert(err, ErrorMatches, ".*too many levels of symbolic links")
+}
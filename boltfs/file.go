@@ -0,0 +1,108 @@
+//go:build !js
+// +build !js
+
+package boltfs
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4/internal/memfile"
+)
+
+// file is a file of a Bolt filesystem. Its content is read when opened, and
+// kept in memory until closed, when it's written in a single transaction if
+// modified.
+type file struct {
+	*memfile.File
+
+	fs   *Bolt
+	key  string
+	mode os.FileMode
+}
+
+// create stores a new empty file with the given key, creating its parents.
+func (f *file) create(t *tree, key string, perm os.FileMode) error {
+	if err := t.mkdirAll(parent(key), 0755); err != nil {
+		return err
+	}
+
+	n := &node{mode: perm.Perm(), modTime: time.Now()}
+	if err := t.putFile(key, n, nil); err != nil {
+		return err
+	}
+
+	f.key, f.mode = key, n.mode
+	return nil
+}
+
+// Close writes the content of the file if it was modified.
+func (f *file) Close() error {
+	if f.Closed {
+		return os.ErrClosed
+	}
+
+	f.Closed = true
+
+	var err error
+	if f.Dirty {
+		err = f.fs.store(f.key, f.Content)
+	}
+
+	f.Content = nil
+	return err
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	return &fileInfo{
+		name: filepath.Base(f.Name()),
+		size: int64(len(f.Content)),
+		mode: f.mode,
+	}, nil
+}
+
+// Lock is a no-op in boltfs.
+func (f *file) Lock() error {
+	return nil
+}
+
+// Unlock is a no-op in boltfs.
+func (f *file) Unlock() error {
+	return nil
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func newFileInfo(name string, n *node) *fileInfo {
+	return &fileInfo{name: name, size: n.size, mode: n.mode, modTime: n.modTime}
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.size
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	return fi.mode
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.mode.IsDir()
+}
+
+func (*fileInfo) Sys() interface{} {
+	return nil
+}
@@ -0,0 +1,756 @@
+// Package casfs provides a content-addressable billy filesystem, stored in an
+// underlying filesystem.
+//
+// The content of every file is stored once as a blob, named by its SHA-256
+// hash, so identical files share the same blob. The directory tree is a
+// manifest mapping every path to its metadata and the hash of its content.
+// Every mutation is appended to a journal, replayed over the manifest when
+// loaded, and the manifest is rewritten once the journal holds more changes
+// than the tree has nodes, or on Compact. The files opened for writing are staged in a
+// temporary file and hashed on Close, while Rename and Remove only touch the
+// manifest.
+//
+// A snapshot is the manifest stored as a blob, so it's taken in constant time
+// with respect to the content. The blobs are never removed, so the snapshots
+// remain valid.
+package casfs // import "gopkg.in/src-d/go-billy.v4/casfs"
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/internal/fileutil"
+	"gopkg.in/src-d/go-billy.v4/internal/pathutil"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const (
+	blobsDir     = "blobs"
+	stagingDir   = "staging"
+	manifestName = "manifest"
+	journalName  = "manifest.journal"
+)
+
+var (
+	// ErrNotEmpty is returned when removing a directory with files.
+	ErrNotEmpty = billy.ErrNotEmpty
+	// ErrCorrupted is returned when the manifest, or a snapshot, can't be
+	// decoded.
+	ErrCorrupted = errors.New("corrupted manifest")
+	// ErrSnapshotNotFound is returned when the given snapshot doesn't exist.
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+
+	errNotDir = errors.New("not a directory")
+
+	// emptyHash is the hash of the empty content.
+	emptyHash = hash(nil)
+)
+
+// CAS is a content-addressable filesystem.
+type CAS struct {
+	storage  billy.Filesystem
+	readOnly bool
+
+	// m serializes the access to the nodes, the manifest and the journal.
+	m     sync.Mutex
+	nodes map[string]*node
+	// pending are the changes not written to the journal yet, and journaled
+	// the number of changes in the journal.
+	pending   []change
+	journaled int
+}
+
+// New returns a CAS filesystem stored at the given storage, loading the
+// manifest and replaying the journal if they exist.
+func New(storage billy.Filesystem) (*CAS, error) {
+	nodes := make(map[string]*node)
+	b, err := readFile(storage, manifestName)
+	switch {
+	case err == nil:
+		if nodes, err = decodeManifest(b); err != nil {
+			return nil, err
+		}
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+
+	fs := &CAS{storage: storage, nodes: nodes}
+	b, err = readFile(storage, journalName)
+	if os.IsNotExist(err) {
+		return fs, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	complete, err := replayJournal(nodes, b)
+	if err != nil {
+		return nil, err
+	}
+
+	// a change written partially would corrupt the ones appended after it.
+	if !complete {
+		if err := fs.compact(); err != nil {
+			return nil, err
+		}
+	}
+
+	fs.journaled = bytes.Count(b, []byte("\n"))
+	return fs, nil
+}
+
+// Snapshot stores the current manifest as a blob, and returns its hash, as
+// the id of the snapshot.
+func (fs *CAS) Snapshot() (string, error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	b, err := encodeManifest(fs.nodes)
+	if err != nil {
+		return "", err
+	}
+
+	h := hash(b)
+	return h, fs.putBlob(h, b)
+}
+
+// At returns a read-only filesystem with the state of the given snapshot.
+func (fs *CAS) At(snapshot string) (*CAS, error) {
+	nodes, err := fs.loadSnapshot(snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CAS{storage: fs.storage, readOnly: true, nodes: nodes}, nil
+}
+
+// Restore replaces the current state with the one of the given snapshot.
+// The files open for writing are discarded when closed if they don't exist
+// in the snapshot.
+func (fs *CAS) Restore(snapshot string) error {
+	if fs.readOnly {
+		return billy.ErrReadOnly
+	}
+
+	nodes, err := fs.loadSnapshot(snapshot)
+	if err != nil {
+		return err
+	}
+
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	fs.nodes = nodes
+	return fs.compact()
+}
+
+func (fs *CAS) loadSnapshot(snapshot string) (map[string]*node, error) {
+	b, err := readFile(fs.storage, blobPath(snapshot))
+	if os.IsNotExist(err) {
+		return nil, ErrSnapshotNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeManifest(b)
+}
+
+// Compact rewrites the manifest with the current tree, emptying the journal.
+func (fs *CAS) Compact() error {
+	if fs.readOnly {
+		return billy.ErrReadOnly
+	}
+
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	return fs.compact()
+}
+
+// commit appends the pending changes to the journal, compacting it instead
+// once it holds more changes than the tree has nodes, so the cost of the
+// rewrites is amortized over the changes.
+func (fs *CAS) commit() error {
+	if len(fs.pending) == 0 {
+		return nil
+	}
+
+	if fs.journaled+len(fs.pending) > len(fs.nodes) {
+		return fs.compact()
+	}
+
+	b, err := encodeChanges(fs.pending)
+	if err != nil {
+		return err
+	}
+
+	f, err := fs.storage.OpenFile(journalName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fs.journaled += len(fs.pending)
+	fs.pending = nil
+	return nil
+}
+
+// compact writes the manifest replacing the previous one atomically, and
+// removes the journal. Replaying the journal again over the new manifest, if
+// it isn't removed, leaves the same tree.
+func (fs *CAS) compact() error {
+	b, err := encodeManifest(fs.nodes)
+	if err != nil {
+		return err
+	}
+
+	if err := util.WriteFile(fs.storage, manifestName+".tmp", b, 0644); err != nil {
+		return err
+	}
+
+	if err := fs.storage.Rename(manifestName+".tmp", manifestName); err != nil {
+		return err
+	}
+
+	if err := fs.storage.Remove(journalName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	fs.journaled = 0
+	fs.pending = nil
+	return nil
+}
+
+// putBlob stores the given content with the given hash, if not stored yet.
+func (fs *CAS) putBlob(h string, content []byte) error {
+	if _, err := fs.storage.Stat(blobPath(h)); err == nil {
+		return nil
+	}
+
+	f, err := fs.stage()
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(content)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		fs.storage.Remove(f.Name())
+		return err
+	}
+
+	return fs.commitBlob(f.Name(), h)
+}
+
+// stage returns a new temporary file to write the content of a blob.
+func (fs *CAS) stage() (billy.File, error) {
+	return util.TempFile(fs.storage, stagingDir, "blob")
+}
+
+// commitBlob moves the given staged file to the blob with the given hash,
+// the staged file is removed instead if the blob already exists.
+func (fs *CAS) commitBlob(staged, h string) error {
+	name := blobPath(h)
+	if _, err := fs.storage.Stat(name); err == nil {
+		return fs.storage.Remove(staged)
+	}
+
+	if err := fs.storage.MkdirAll(path.Dir(name), 0755); err != nil {
+		return err
+	}
+
+	return fs.storage.Rename(staged, name)
+}
+
+// store sets the content of the given file to the given staged file, if it
+// still exists. The staged file is removed otherwise.
+func (fs *CAS) store(key, staged string) error {
+	h, size, err := fileutil.Hash(fs.storage, staged)
+	if err != nil {
+		return err
+	}
+
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	n := fs.get(key)
+	if n == nil || !n.Mode.IsRegular() {
+		return fs.storage.Remove(staged)
+	}
+
+	if err := fs.commitBlob(staged, h); err != nil {
+		return err
+	}
+
+	updated := *n
+	updated.Hash, updated.Size, updated.ModTime = h, size, time.Now()
+	fs.set(key, &updated)
+	return fs.commit()
+}
+
+func (fs *CAS) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *CAS) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file. The files opened only for reading read the
+// blob directly. Otherwise the content is staged in a temporary file, and
+// stored as a blob on Close if modified.
+func (fs *CAS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if fs.readOnly && (isWrite(flag) || flag&os.O_CREATE != 0) {
+		return nil, billy.ErrReadOnly
+	}
+
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	f, err := fs.openFile(toKey(filename), flag, perm)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+	}
+
+	f.name = pathutil.Relative(filename)
+	return f, nil
+}
+
+func (fs *CAS) openFile(key string, flag int, perm os.FileMode) (*file, error) {
+	key, n, err := fs.follow(key)
+	if os.IsNotExist(err) && flag&os.O_CREATE != 0 {
+		// the key is the target of the last link, even when it's dangling,
+		// so the target is created.
+		if err := fs.mkdirAll(parent(key), 0755); err != nil {
+			return nil, err
+		}
+
+		if err := fs.putBlob(emptyHash, nil); err != nil {
+			return nil, err
+		}
+
+		n = &node{Mode: perm.Perm(), ModTime: time.Now(), Hash: emptyHash}
+		fs.set(key, n)
+		if err := fs.commit(); err != nil {
+			return nil, err
+		}
+
+		return fs.openStaged(key, flag, nil)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+		return nil, os.ErrExist
+	}
+
+	if n.Mode.IsDir() {
+		return nil, fmt.Errorf("cannot open directory: %s", key)
+	}
+
+	if !isWrite(flag) {
+		blob, err := fs.storage.Open(blobPath(n.Hash))
+		if err != nil {
+			return nil, err
+		}
+
+		return &file{File: blob, flag: flag}, nil
+	}
+
+	if flag&os.O_TRUNC == 0 {
+		return fs.openStaged(key, flag, n)
+	}
+
+	if n.Size != 0 {
+		if err := fs.putBlob(emptyHash, nil); err != nil {
+			return nil, err
+		}
+
+		truncated := *n
+		truncated.Hash, truncated.Size, truncated.ModTime = emptyHash, 0, time.Now()
+		fs.set(key, &truncated)
+		if err := fs.commit(); err != nil {
+			return nil, err
+		}
+	}
+
+	return fs.openStaged(key, flag, nil)
+}
+
+// openStaged returns a file staged in a temporary file, with the content of
+// the given node, if any.
+func (fs *CAS) openStaged(key string, flag int, n *node) (*file, error) {
+	staged, err := fs.stage()
+	if err != nil {
+		return nil, err
+	}
+
+	if n != nil && n.Size != 0 {
+		if err := copyBlob(fs.storage, staged, n.Hash); err != nil {
+			staged.Close()
+			fs.storage.Remove(staged.Name())
+			return nil, err
+		}
+	}
+
+	return &file{File: staged, fs: fs, key: key, flag: flag}, nil
+}
+
+func (fs *CAS) Stat(filename string) (os.FileInfo, error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	_, n, err := fs.follow(toKey(filename))
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: filename, Err: err}
+	}
+
+	// the name is the one of the stated file, even if it's a link.
+	return newFileInfo(filepath.Base(filename), n), nil
+}
+
+func (fs *CAS) Lstat(filename string) (os.FileInfo, error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	n, err := fs.lookup(toKey(filename))
+	if err != nil {
+		return nil, &os.PathError{Op: "lstat", Path: filename, Err: err}
+	}
+
+	return newFileInfo(filepath.Base(filename), n), nil
+}
+
+func (fs *CAS) ReadDir(filename string) ([]os.FileInfo, error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	key, n, err := fs.follow(toKey(filename))
+	if err == nil && !n.Mode.IsDir() {
+		err = errNotDir
+	}
+
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: filename, Err: err}
+	}
+
+	var infos []os.FileInfo
+	for _, k := range fs.children(key) {
+		infos = append(infos, newFileInfo(path.Base(k), fs.nodes[k]))
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+
+	return infos, nil
+}
+
+func (fs *CAS) MkdirAll(filename string, perm os.FileMode) error {
+	if fs.readOnly {
+		return billy.ErrReadOnly
+	}
+
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	err := fs.mkdirAll(toKey(filename), perm)
+	if err == nil {
+		err = fs.commit()
+	}
+
+	if err != nil {
+		return &os.PathError{Op: "mkdir", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+// mkdirAll adds to the nodes the directories of the given path not found.
+func (fs *CAS) mkdirAll(key string, perm os.FileMode) error {
+	if key == "" {
+		return nil
+	}
+
+	var dir string
+	for _, elem := range strings.Split(key, "/") {
+		dir = path.Join(dir, elem)
+		_, n, err := fs.follow(dir)
+		if err == nil {
+			if !n.Mode.IsDir() {
+				return errNotDir
+			}
+
+			continue
+		}
+
+		if !os.IsNotExist(err) {
+			return err
+		}
+
+		fs.set(dir, &node{Mode: os.ModeDir | perm.Perm(), ModTime: time.Now()})
+	}
+
+	return nil
+}
+
+// Rename moves the given file, or directory with all its content, changing
+// only the manifest.
+func (fs *CAS) Rename(from, to string) error {
+	if fs.readOnly {
+		return billy.ErrReadOnly
+	}
+
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	if err := fs.rename(toKey(from), toKey(to)); err != nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *CAS) rename(from, to string) error {
+	if from == "" || to == "" {
+		return errors.New("cannot rename the root")
+	}
+
+	src, err := fs.lookup(from)
+	if err != nil {
+		return err
+	}
+
+	if from == to {
+		return nil
+	}
+
+	if strings.HasPrefix(to, from+"/") {
+		return errors.New("cannot move a directory into itself")
+	}
+
+	if dst := fs.get(to); dst != nil {
+		if dst.Mode.IsDir() != src.Mode.IsDir() {
+			return os.ErrExist
+		}
+
+		if dst.Mode.IsDir() && !fs.isEmpty(to) {
+			return ErrNotEmpty
+		}
+	}
+
+	if err := fs.mkdirAll(parent(to), 0755); err != nil {
+		return err
+	}
+
+	for _, key := range append(fs.descendants(from), from) {
+		n := fs.nodes[key]
+		fs.del(key)
+		fs.set(to+key[len(from):], n)
+	}
+
+	return fs.commit()
+}
+
+// Remove removes the given file or empty directory from the manifest, its
+// blob is kept.
+func (fs *CAS) Remove(filename string) error {
+	if fs.readOnly {
+		return billy.ErrReadOnly
+	}
+
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	if err := fs.remove(toKey(filename)); err != nil {
+		return &os.PathError{Op: "remove", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *CAS) remove(key string) error {
+	if key == "" {
+		return errors.New("cannot remove the root")
+	}
+
+	n, err := fs.lookup(key)
+	if err != nil {
+		return err
+	}
+
+	if n.Mode.IsDir() && !fs.isEmpty(key) {
+		return ErrNotEmpty
+	}
+
+	fs.del(key)
+	return fs.commit()
+}
+
+func (fs *CAS) Symlink(target, link string) error {
+	if fs.readOnly {
+		return billy.ErrReadOnly
+	}
+
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	key := toKey(link)
+	var err error
+	if fs.get(key) != nil {
+		err = os.ErrExist
+	}
+
+	if err == nil {
+		err = fs.mkdirAll(parent(key), 0755)
+	}
+
+	if err == nil {
+		fs.set(key, &node{
+			Mode:    os.ModeSymlink | 0777,
+			ModTime: time.Now(),
+			Size:    int64(len(target)),
+			Target:  target,
+		})
+
+		err = fs.commit()
+	}
+
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *CAS) Readlink(link string) (string, error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	n, err := fs.lookup(toKey(link))
+	if err == nil && n.Mode&os.ModeSymlink == 0 {
+		err = errors.New("not a symlink")
+	}
+
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: link, Err: err}
+	}
+
+	return n.Target, nil
+}
+
+func (fs *CAS) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(fs, dir, prefix)
+}
+
+func (fs *CAS) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (fs *CAS) Chroot(p string) (billy.Filesystem, error) {
+	return chroot.New(fs, fs.Join(fs.Root(), p)), nil
+}
+
+func (fs *CAS) Root() string {
+	return string(filepath.Separator)
+}
+
+// Capabilities implements the Capable interface.
+func (fs *CAS) Capabilities() billy.Capability {
+	if fs.readOnly {
+		return billy.ReadCapability | billy.SeekCapability
+	}
+
+	return billy.WriteCapability | billy.ReadCapability |
+		billy.ReadAndWriteCapability | billy.SeekCapability |
+		billy.TruncateCapability
+}
+
+// hash returns the hexadecimal SHA-256 hash of the given content.
+func hash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// copyBlob copies the content of the given blob to the given file, leaving
+// its position at the start.
+func copyBlob(fs billy.Basic, dst billy.File, h string) error {
+	blob, err := fs.Open(blobPath(h))
+	if err != nil {
+		return err
+	}
+
+	defer blob.Close()
+	if _, err := io.Copy(dst, blob); err != nil {
+		return err
+	}
+
+	_, err = dst.Seek(0, io.SeekStart)
+	return err
+}
+
+// blobPath returns the path of the blob with the given hash, under a
+// directory named by its first two characters.
+func blobPath(h string) string {
+	if len(h) < 3 {
+		return path.Join(blobsDir, h)
+	}
+
+	return path.Join(blobsDir, h[:2], h[2:])
+}
+
+func readFile(fs billy.Basic, filename string) ([]byte, error) {
+	f, err := fs.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+func toKey(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(p)), "/")
+}
+
+func parent(key string) string {
+	if i := strings.LastIndexByte(key, '/'); i != -1 {
+		return key[:i]
+	}
+
+	return ""
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR) != 0
+}
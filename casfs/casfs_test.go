@@ -0,0 +1,193 @@
+package casfs
+
+import (
+	"os"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type CASSuite struct {
+	test.FilesystemSuite
+	storage billy.Filesystem
+}
+
+var _ = Suite(&CASSuite{})
+
+func (s *CASSuite) SetUpTest(c *C) {
+	s.storage = memfs.New()
+	fs, err := New(s.storage)
+	c.Assert(err, IsNil)
+
+	s.FilesystemSuite = test.NewFilesystemSuite(fs)
+}
+
+func (s *CASSuite) reopen(c *C) *CAS {
+	fs, err := New(s.storage)
+	c.Assert(err, IsNil)
+
+	s.FS = fs
+	return fs
+}
+
+func (s *CASSuite) blobs(c *C) int {
+	var n int
+	dirs, err := s.storage.ReadDir(blobsDir)
+	c.Assert(err, IsNil)
+	for _, dir := range dirs {
+		blobs, err := s.storage.ReadDir(s.storage.Join(blobsDir, dir.Name()))
+		c.Assert(err, IsNil)
+		n += len(blobs)
+	}
+
+	return n
+}
+
+func (s *CASSuite) TestPersistence(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar", []byte("bar"), 0600), IsNil)
+	c.Assert(s.FS.MkdirAll("empty", 0755), IsNil)
+	c.Assert(s.FS.Symlink("foo/bar", "link"), IsNil)
+	c.Assert(s.FS.Rename("foo", "qux"), IsNil)
+
+	fs := s.reopen(c)
+	c.Assert(test.ReadFile(c, fs, "qux/bar"), Equals, "bar")
+
+	fi, err := fs.Stat("qux/bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode(), Equals, os.FileMode(0600))
+
+	fi, err = fs.Stat("empty")
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+
+	target, err := fs.Readlink("link")
+	c.Assert(err, IsNil)
+	c.Assert(target, Equals, "foo/bar")
+}
+
+func (s *CASSuite) TestJournal(c *C) {
+	for _, name := range []string{"foo", "bar", "baz", "qux"} {
+		c.Assert(util.WriteFile(s.FS, name, []byte(name), 0644), IsNil)
+	}
+
+	c.Assert(s.FS.(*CAS).Compact(), IsNil)
+	c.Assert(s.FS.Rename("foo", "dir/foo"), IsNil)
+	c.Assert(s.FS.Remove("bar"), IsNil)
+
+	_, err := s.storage.Stat(journalName)
+	c.Assert(err, IsNil)
+
+	fs := s.reopen(c)
+	c.Assert(test.ReadFile(c, fs, "dir/foo"), Equals, "foo")
+	_, err = fs.Stat("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+	_, err = fs.Stat("bar")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	c.Assert(fs.Compact(), IsNil)
+	_, err = s.storage.Stat(journalName)
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	fs = s.reopen(c)
+	c.Assert(test.ReadFile(c, fs, "dir/foo"), Equals, "foo")
+	c.Assert(test.ReadFile(c, fs, "qux"), Equals, "qux")
+}
+
+func (s *CASSuite) TestJournalPartialChange(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(s.FS.(*CAS).Compact(), IsNil)
+
+	journal := []byte(`{"key":"bar","node":{"mode":2147484141,"mtime":"2020-01-01T00:00:00Z"}}` + "\n" + `{"key":"foo"`)
+	c.Assert(util.WriteFile(s.storage, journalName, journal, 0644), IsNil)
+
+	fs := s.reopen(c)
+	fi, err := fs.Stat("bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+	c.Assert(test.ReadFile(c, fs, "foo"), Equals, "foo")
+
+	_, err = s.storage.Stat(journalName)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *CASSuite) TestDeduplication(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "bar/foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "qux", []byte("qux"), 0644), IsNil)
+
+	// the empty blob, written on create, foo and qux
+	c.Assert(s.blobs(c), Equals, 3)
+
+	staged, err := s.storage.ReadDir(stagingDir)
+	c.Assert(err, IsNil)
+	c.Assert(staged, HasLen, 0)
+}
+
+func (s *CASSuite) TestRenameRemoveManifest(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar", []byte("bar"), 0644), IsNil)
+	blobs := s.blobs(c)
+
+	c.Assert(s.FS.Rename("foo", "qux"), IsNil)
+	c.Assert(s.FS.Remove("qux/bar"), IsNil)
+	c.Assert(s.blobs(c), Equals, blobs)
+}
+
+func (s *CASSuite) TestSnapshot(c *C) {
+	fs := s.FS.(*CAS)
+	c.Assert(util.WriteFile(fs, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(fs, "bar/qux", []byte("qux"), 0644), IsNil)
+
+	id, err := fs.Snapshot()
+	c.Assert(err, IsNil)
+
+	same, err := fs.Snapshot()
+	c.Assert(err, IsNil)
+	c.Assert(same, Equals, id)
+
+	c.Assert(util.WriteFile(fs, "foo", []byte("modified"), 0644), IsNil)
+	c.Assert(fs.Remove("bar/qux"), IsNil)
+
+	snap, err := fs.At(id)
+	c.Assert(err, IsNil)
+	c.Assert(test.ReadFile(c, snap, "foo"), Equals, "foo")
+	c.Assert(test.ReadFile(c, snap, "bar/qux"), Equals, "qux")
+	c.Assert(snap.Remove("foo"), Equals, billy.ErrReadOnly)
+
+	_, err = snap.Create("new")
+	c.Assert(err, Equals, billy.ErrReadOnly)
+
+	c.Assert(fs.Restore(id), IsNil)
+	fs = s.reopen(c)
+	c.Assert(test.ReadFile(c, fs, "foo"), Equals, "foo")
+	c.Assert(test.ReadFile(c, fs, "bar/qux"), Equals, "qux")
+
+	_, err = fs.At("missing")
+	c.Assert(err, Equals, ErrSnapshotNotFound)
+}
+
+func (s *CASSuite) TestRemoveWhileOpen(c *C) {
+	f, err := s.FS.Create("foo")
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("foo"))
+	c.Assert(err, IsNil)
+
+	c.Assert(s.FS.Remove("foo"), IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	_, err = s.FS.Stat("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *CASSuite) TestCorrupted(c *C) {
+	c.Assert(util.WriteFile(s.storage, manifestName, []byte("foo"), 0644), IsNil)
+
+	_, err := New(s.storage)
+	c.Assert(err, Equals, ErrCorrupted)
+}
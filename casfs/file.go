@@ -0,0 +1,128 @@
+package casfs
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// file is a file of a CAS filesystem. The files opened only for reading
+// wrap their blob, the rest wrap a staged file, stored as a blob on Close
+// if modified.
+type file struct {
+	billy.File
+	fs   *CAS
+	name string
+	key  string
+	flag int
+
+	dirty    bool
+	isClosed bool
+}
+
+func (f *file) Name() string {
+	return f.name
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	if f.flag&os.O_WRONLY != 0 {
+		return 0, errors.New("read not supported")
+	}
+
+	return f.File.Read(p)
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	if f.flag&os.O_WRONLY != 0 {
+		return 0, errors.New("read not supported")
+	}
+
+	return f.File.ReadAt(p, off)
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	return f.File.Seek(offset, whence)
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	if !isWrite(f.flag) {
+		return 0, errors.New("write not supported")
+	}
+
+	if f.flag&os.O_APPEND != 0 {
+		if _, err := f.File.Seek(0, io.SeekEnd); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.File.Write(p)
+	if n > 0 {
+		f.dirty = true
+	}
+
+	return n, err
+}
+
+func (f *file) Truncate(size int64) error {
+	if f.isClosed {
+		return os.ErrClosed
+	}
+
+	if !isWrite(f.flag) {
+		return errors.New("truncate not supported")
+	}
+
+	f.dirty = true
+	return f.File.Truncate(size)
+}
+
+// Close stores the staged content as a blob if it was modified, and the
+// file still exists.
+func (f *file) Close() error {
+	if f.isClosed {
+		return os.ErrClosed
+	}
+
+	f.isClosed = true
+	if err := f.File.Close(); err != nil || !isWrite(f.flag) {
+		return err
+	}
+
+	if !f.dirty {
+		return f.fs.storage.Remove(f.File.Name())
+	}
+
+	if err := f.fs.store(f.key, f.File.Name()); err != nil {
+		return &os.PathError{Op: "close", Path: f.name, Err: err}
+	}
+
+	return nil
+}
+
+// Lock is a no-op in CAS.
+func (f *file) Lock() error {
+	return nil
+}
+
+// Unlock is a no-op in CAS.
+func (f *file) Unlock() error {
+	return nil
+}
@@ -0,0 +1,220 @@
+package casfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4/internal/linkutil"
+)
+
+const manifestVersion = 1
+
+// node is an entry of the manifest: a file, directory or symlink. The files
+// reference their content by its hash.
+type node struct {
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+	Size    int64       `json:"size,omitempty"`
+	Hash    string      `json:"hash,omitempty"`
+	Target  string      `json:"target,omitempty"`
+}
+
+// manifest is the encoded directory tree, with the nodes keyed by their path
+// without the leading slash. The keys are sorted when encoded, so the same
+// tree always has the same encoding, and hash.
+type manifest struct {
+	Version int              `json:"version"`
+	Nodes   map[string]*node `json:"nodes"`
+}
+
+func encodeManifest(nodes map[string]*node) ([]byte, error) {
+	return json.Marshal(&manifest{Version: manifestVersion, Nodes: nodes})
+}
+
+func decodeManifest(b []byte) (map[string]*node, error) {
+	var m manifest
+	if err := json.Unmarshal(b, &m); err != nil || m.Version != manifestVersion {
+		return nil, ErrCorrupted
+	}
+
+	if m.Nodes == nil {
+		m.Nodes = make(map[string]*node)
+	}
+
+	return m.Nodes, nil
+}
+
+// change is an entry of the journal: the new node of the given key, or nil if
+// it was removed.
+type change struct {
+	Key  string `json:"key"`
+	Node *node  `json:"node,omitempty"`
+}
+
+func encodeChanges(changes []change) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for i := range changes {
+		if err := enc.Encode(&changes[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// replayJournal applies to the given nodes the changes of the given journal.
+// It returns false if the last change was written partially, which is then
+// ignored.
+func replayJournal(nodes map[string]*node, b []byte) (bool, error) {
+	lines := bytes.Split(b, []byte("\n"))
+	for i, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+
+		var c change
+		if err := json.Unmarshal(line, &c); err != nil {
+			if i == len(lines)-1 {
+				return false, nil
+			}
+
+			return false, ErrCorrupted
+		}
+
+		if c.Node == nil {
+			delete(nodes, c.Key)
+		} else {
+			nodes[c.Key] = c.Node
+		}
+	}
+
+	return len(b) == 0 || b[len(b)-1] == '\n', nil
+}
+
+// set sets the node of the given key, recording the change.
+func (fs *CAS) set(key string, n *node) {
+	fs.nodes[key] = n
+	fs.pending = append(fs.pending, change{Key: key, Node: n})
+}
+
+// del removes the node of the given key, recording the change.
+func (fs *CAS) del(key string) {
+	delete(fs.nodes, key)
+	fs.pending = append(fs.pending, change{Key: key})
+}
+
+// get returns the node with the given key, or nil if it doesn't exist. The
+// root is the empty key, which is never stored.
+func (fs *CAS) get(key string) *node {
+	if key == "" {
+		return &node{Mode: os.ModeDir | 0755}
+	}
+
+	return fs.nodes[key]
+}
+
+// lookup returns the node of the manifest with the given key, failing with
+// os.ErrNotExist if the manifest doesn't have it.
+func (fs *CAS) lookup(key string) (*node, error) {
+	n := fs.get(key)
+	if n == nil {
+		return nil, os.ErrNotExist
+	}
+
+	return n, nil
+}
+
+// follow returns the node of the manifest with the given key, following the
+// links. The key of the last target is returned even if it doesn't exist.
+func (fs *CAS) follow(key string) (string, *node, error) {
+	var n *node
+	isLink := func(p string) (link bool, err error) {
+		n, err = fs.lookup(p)
+		return err == nil && n.Mode&os.ModeSymlink != 0, err
+	}
+
+	key, err := linkutil.Follow(key, toKey, isLink, func(string) (string, error) {
+		return n.Target, nil
+	})
+	if err != nil {
+		return key, nil, err
+	}
+
+	return key, n, nil
+}
+
+// children returns the keys of the direct children of the given directory.
+func (fs *CAS) children(key string) []string {
+	prefix := key + "/"
+	if key == "" {
+		prefix = ""
+	}
+
+	var keys []string
+	for k := range fs.nodes {
+		if strings.HasPrefix(k, prefix) && !strings.Contains(k[len(prefix):], "/") {
+			keys = append(keys, k)
+		}
+	}
+
+	return keys
+}
+
+// descendants returns the keys of everything under the given directory.
+func (fs *CAS) descendants(key string) []string {
+	var keys []string
+	for k := range fs.nodes {
+		if strings.HasPrefix(k, key+"/") {
+			keys = append(keys, k)
+		}
+	}
+
+	return keys
+}
+
+func (fs *CAS) isEmpty(key string) bool {
+	for k := range fs.nodes {
+		if strings.HasPrefix(k, key+"/") {
+			return false
+		}
+	}
+
+	return true
+}
+
+type fileInfo struct {
+	name string
+	node *node
+}
+
+func newFileInfo(name string, n *node) *fileInfo {
+	return &fileInfo{name: name, node: n}
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.node.Size
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	return fi.node.Mode
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.node.ModTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.node.Mode.IsDir()
+}
+
+func (*fileInfo) Sys() interface{} {
+	return nil
+}
@@ -0,0 +1,460 @@
+// Package davfs provides a billy filesystem over a WebDAV server.
+//
+// Stat and ReadDir are implemented with PROPFIND requests, the files are
+// read with GET and written with PUT, Rename uses MOVE and MkdirAll uses
+// MKCOL. File.Lock and File.Unlock take and release an exclusive write lock
+// on the server, with the LOCK and UNLOCK methods.
+package davfs // import "gopkg.in/src-d/go-billy.v4/davfs"
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/internal/pathutil"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const defaultLockTimeout = time.Hour
+
+var (
+	// ErrLocked is returned when the resource is locked by other client.
+	ErrLocked = errors.New("resource locked")
+	// ErrNotEmpty is returned when removing a directory with files.
+	ErrNotEmpty = billy.ErrNotEmpty
+)
+
+// Options holds the configuration of a WebDAV filesystem.
+type Options struct {
+	// Client sends the WebDAV requests to the server, http.DefaultClient if
+	// nil.
+	Client *http.Client
+	// Header is added to every request, eg.: to set authentication tokens.
+	Header http.Header
+	// Username and Password, if not empty, are used for basic
+	// authentication.
+	Username, Password string
+	// LockTimeout is the timeout requested for the locks taken by
+	// File.Lock, one hour by default.
+	LockTimeout time.Duration
+}
+
+// Dav is a filesystem over a WebDAV server.
+type Dav struct {
+	base *url.URL
+	opts Options
+}
+
+// New returns a new filesystem with the files found under the given base
+// URL of a WebDAV server.
+func New(baseURL string, opts Options) (*Dav, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme: %q", base.Scheme)
+	}
+
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+
+	if opts.LockTimeout <= 0 {
+		opts.LockTimeout = defaultLockTimeout
+	}
+
+	return &Dav{base: base, opts: opts}, nil
+}
+
+// url returns the URL of the given path, the directories end with a slash.
+func (fs *Dav) url(p string, dir bool) string {
+	u := *fs.base
+	u.Path = path.Join("/", u.Path, p)
+	if dir && !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+
+	u.RawPath = ""
+	return u.String()
+}
+
+// do performs a request over the given path, the response is returned only
+// if the status code is one of the expected ones.
+func (fs *Dav) do(method, p string, dir bool, header http.Header, body []byte, expected ...int) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, fs.url(p, dir), r)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range fs.opts.Header {
+		req.Header[k] = v
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	if fs.opts.Username != "" || fs.opts.Password != "" {
+		req.SetBasicAuth(fs.opts.Username, fs.opts.Password)
+	}
+
+	res, err := fs.opts.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, code := range expected {
+		if res.StatusCode == code {
+			return res, nil
+		}
+	}
+
+	res.Body.Close()
+	return nil, &os.PathError{Op: strings.ToLower(method), Path: p, Err: statusError(res)}
+}
+
+func statusError(res *http.Response) error {
+	switch res.StatusCode {
+	case http.StatusNotFound, http.StatusGone, http.StatusConflict:
+		return os.ErrNotExist
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return os.ErrPermission
+	case http.StatusPreconditionFailed:
+		return os.ErrExist
+	case http.StatusLocked:
+		return ErrLocked
+	default:
+		return fmt.Errorf("unexpected status: %s", res.Status)
+	}
+}
+
+func (fs *Dav) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Dav) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file. The content of the file is kept in memory,
+// it is downloaded on the first read, and uploaded on Close if modified.
+func (fs *Dav) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	p := clean(filename)
+	if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+		if err := fs.create(p, true); err != nil {
+			return nil, err
+		}
+
+		f := newFile(fs, pathutil.Relative(filename), p, flag)
+		f.Loaded = true
+		return f, nil
+	}
+
+	fi, err := fs.Stat(p)
+	switch {
+	case os.IsNotExist(err):
+		if flag&os.O_CREATE == 0 {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	case fi.IsDir():
+		return nil, &os.PathError{Op: "open", Path: filename, Err: errors.New("is a directory")}
+	}
+
+	f := newFile(fs, pathutil.Relative(filename), p, flag)
+	if fi == nil || flag&os.O_TRUNC != 0 {
+		// the file is created or truncated right away, so it can be found
+		// by other clients.
+		if err := fs.create(p, false); err != nil {
+			return nil, err
+		}
+
+		f.Loaded = true
+	}
+
+	return f, nil
+}
+
+// create writes an empty file, creating the parent directories if needed.
+func (fs *Dav) create(p string, exclusive bool) error {
+	err := fs.put(p, nil, exclusive, "")
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	// the parent directory is missing.
+	if err := fs.MkdirAll(path.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	return fs.put(p, nil, exclusive, "")
+}
+
+// put uploads the content of the given file, if exclusive is true the file
+// must not exist before.
+func (fs *Dav) put(p string, content []byte, exclusive bool, token string) error {
+	header := http.Header{}
+	if exclusive {
+		header.Set("If-None-Match", "*")
+	}
+
+	if token != "" {
+		header.Set("If", "("+token+")")
+	}
+
+	if content == nil {
+		content = []byte{}
+	}
+
+	res, err := fs.do(http.MethodPut, p, false, header, content,
+		http.StatusOK, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
+
+func (fs *Dav) get(p string) ([]byte, error) {
+	res, err := fs.do(http.MethodGet, p, false, nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+	buf := bytes.NewBuffer(nil)
+	if res.ContentLength > 0 {
+		buf.Grow(int(res.ContentLength))
+	}
+
+	_, err = buf.ReadFrom(res.Body)
+	return buf.Bytes(), err
+}
+
+func (fs *Dav) Stat(filename string) (os.FileInfo, error) {
+	p := clean(filename)
+	entries, err := fs.propfind(p, "0")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entries) == 0 {
+		return nil, &os.PathError{Op: "stat", Path: filename, Err: os.ErrNotExist}
+	}
+
+	fi := entries[0].info
+	if p == "/" {
+		fi.name = string(filepath.Separator)
+	}
+
+	return fi, nil
+}
+
+// Lstat is equivalent to Stat, since WebDAV has no concept of symlinks.
+func (fs *Dav) Lstat(filename string) (os.FileInfo, error) {
+	return fs.Stat(filename)
+}
+
+func (fs *Dav) ReadDir(filename string) ([]os.FileInfo, error) {
+	p := clean(filename)
+	entries, err := fs.propfind(p, "1")
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []os.FileInfo
+	for _, e := range entries {
+		if e.path == p {
+			if !e.info.dir {
+				return nil, &os.PathError{Op: "readdir", Path: filename, Err: errors.New("not a directory")}
+			}
+
+			continue
+		}
+
+		infos = append(infos, e.info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+
+	return infos, nil
+}
+
+func (fs *Dav) Rename(from, to string) error {
+	from, to = clean(from), clean(to)
+	fi, err := fs.Stat(from)
+	if err != nil {
+		return err
+	}
+
+	if err := fs.MkdirAll(path.Dir(to), 0755); err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	header.Set("Destination", fs.url(to, fi.IsDir()))
+	header.Set("Overwrite", "T")
+
+	res, err := fs.do("MOVE", from, fi.IsDir(), header, nil,
+		http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
+
+// Remove removes the given file or empty directory.
+func (fs *Dav) Remove(filename string) error {
+	p := clean(filename)
+	fi, err := fs.Stat(p)
+	if err != nil {
+		return err
+	}
+
+	if fi.IsDir() {
+		// DELETE is recursive on collections.
+		entries, err := fs.ReadDir(p)
+		if err != nil {
+			return err
+		}
+
+		if len(entries) != 0 {
+			return &os.PathError{Op: "remove", Path: filename, Err: ErrNotEmpty}
+		}
+	}
+
+	res, err := fs.do(http.MethodDelete, p, fi.IsDir(), nil, nil,
+		http.StatusOK, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
+
+// MkdirAll creates the directory and any missing parent with MKCOL requests,
+// the permissions are ignored.
+func (fs *Dav) MkdirAll(filename string, perm os.FileMode) error {
+	p := clean(filename)
+	if p == "/" {
+		return nil
+	}
+
+	fi, err := fs.Stat(p)
+	if err == nil {
+		if !fi.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: filename, Err: errors.New("not a directory")}
+		}
+
+		return nil
+	}
+
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := fs.MkdirAll(path.Dir(p), perm); err != nil {
+		return err
+	}
+
+	res, err := fs.do("MKCOL", p, true, nil, nil, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
+
+func (fs *Dav) Symlink(target, link string) error {
+	return billy.ErrNotSupported
+}
+
+func (fs *Dav) Readlink(link string) (string, error) {
+	return "", billy.ErrNotSupported
+}
+
+func (fs *Dav) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(fs, dir, prefix)
+}
+
+func (fs *Dav) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (fs *Dav) Chroot(p string) (billy.Filesystem, error) {
+	return chroot.New(fs, fs.Join(fs.Root(), p)), nil
+}
+
+func (fs *Dav) Root() string {
+	return string(filepath.Separator)
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Dav) Capabilities() billy.Capability {
+	return billy.WriteCapability | billy.ReadCapability |
+		billy.ReadAndWriteCapability | billy.SeekCapability |
+		billy.TruncateCapability | billy.LockCapability
+}
+
+// lock takes an exclusive write lock over the given file, returning the lock
+// token.
+func (fs *Dav) lock(p string) (string, error) {
+	header := http.Header{}
+	header.Set("Depth", "0")
+	header.Set("Timeout", "Second-"+strconv.Itoa(int(fs.opts.LockTimeout/time.Second)))
+	header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	res, err := fs.do("LOCK", p, false, header, []byte(lockInfo),
+		http.StatusOK, http.StatusCreated)
+	if err != nil {
+		return "", err
+	}
+
+	res.Body.Close()
+	token := res.Header.Get("Lock-Token")
+	if token == "" {
+		return "", fmt.Errorf("missing lock token for %q", p)
+	}
+
+	return token, nil
+}
+
+func (fs *Dav) unlock(p, token string) error {
+	header := http.Header{}
+	header.Set("Lock-Token", token)
+
+	res, err := fs.do("UNLOCK", p, false, header, nil,
+		http.StatusOK, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
+
+func clean(p string) string {
+	return path.Clean("/" + filepath.ToSlash(p))
+}
@@ -0,0 +1,183 @@
+package davfs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"golang.org/x/net/webdav"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&DavSuite{})
+
+// DavSuite runs the generic suites, except the symlinks one, since WebDAV
+// doesn't support symlinks.
+type DavSuite struct {
+	test.BasicSuite
+	test.DirSuite
+	test.TempFileSuite
+	test.ChrootSuite
+
+	FS     *Dav
+	server *httptest.Server
+}
+
+func (s *DavSuite) SetUpTest(c *C) {
+	s.server = httptest.NewServer(&webdav.Handler{
+		Prefix:     "/dav",
+		FileSystem: webdav.NewMemFS(),
+		LockSystem: webdav.NewMemLS(),
+	})
+
+	var err error
+	s.FS, err = New(s.server.URL+"/dav", Options{})
+	c.Assert(err, IsNil)
+
+	s.BasicSuite.FS = s.FS
+	s.DirSuite.FS = s.FS
+	s.TempFileSuite.FS = s.FS
+	s.ChrootSuite.FS = s.FS
+}
+
+func (s *DavSuite) TearDownTest(c *C) {
+	s.server.Close()
+}
+
+func (s *DavSuite) TestNew(c *C) {
+	_, err := New("ftp://example.com", Options{})
+	c.Assert(err, NotNil)
+}
+
+func (s *DavSuite) TestWriteAndRead(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar", []byte("hello world"), 0644), IsNil)
+
+	fi, err := s.FS.Stat("foo")
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+
+	f, err := s.FS.OpenFile("foo/bar", os.O_RDWR, 0)
+	c.Assert(err, IsNil)
+
+	buf := make([]byte, 5)
+	_, err = f.ReadAt(buf, 6)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf), Equals, "world")
+
+	_, err = f.Write([]byte("HELLO"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	c.Assert(test.ReadFile(c, s.FS, "foo/bar"), Equals, "HELLO world")
+}
+
+func (s *DavSuite) TestRemoveNotEmpty(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar", []byte("foo"), 0644), IsNil)
+
+	err := s.FS.Remove("foo")
+	c.Assert(err, NotNil)
+
+	c.Assert(s.FS.Remove("foo/bar"), IsNil)
+	c.Assert(s.FS.Remove("foo"), IsNil)
+
+	_, err = s.FS.Stat("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *DavSuite) TestRenameDir(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar", []byte("foo"), 0644), IsNil)
+	c.Assert(s.FS.Rename("foo", "qux/baz"), IsNil)
+
+	c.Assert(test.ReadFile(c, s.FS, "qux/baz/bar"), Equals, "foo")
+
+	_, err := s.FS.Stat("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *DavSuite) TestLock(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+
+	f, err := s.FS.OpenFile("foo", os.O_RDWR, 0)
+	c.Assert(err, IsNil)
+	c.Assert(f.Lock(), IsNil)
+
+	// other clients can't write while locked.
+	err = util.WriteFile(s.FS, "foo", []byte("bar"), 0644)
+	c.Assert(err, NotNil)
+	c.Assert(err.(*os.PathError).Err, Equals, ErrLocked)
+
+	_, err = f.Write([]byte("qux"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Unlock(), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "qux")
+
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("bar"), 0644), IsNil)
+	c.Assert(f.Close(), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "bar")
+}
+
+func (s *DavSuite) TestLockReleasedOnClose(c *C) {
+	f, err := s.FS.Create("foo")
+	c.Assert(err, IsNil)
+	c.Assert(f.Lock(), IsNil)
+	_, err = f.Write([]byte("foo"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("bar"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "bar")
+}
+
+func (s *DavSuite) TestBasicAuth(c *C) {
+	handler := &webdav.Handler{FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	fs, err := New(server.URL, Options{})
+	c.Assert(err, IsNil)
+	_, err = fs.Stat("/")
+	c.Assert(os.IsPermission(err), Equals, true)
+
+	fs, err = New(server.URL, Options{Username: "user", Password: "pass"})
+	c.Assert(err, IsNil)
+	c.Assert(util.WriteFile(fs, "foo", []byte("foo"), 0644), IsNil)
+}
+
+// TestStat overrides the one of BasicSuite, since WebDAV doesn't support file
+// modes.
+func (s *DavSuite) TestStat(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar", []byte("foo"), 0644), IsNil)
+
+	fi, err := s.FS.Stat("foo/bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Name(), Equals, "bar")
+	c.Assert(fi.Size(), Equals, int64(3))
+	c.Assert(fi.ModTime().IsZero(), Equals, false)
+	c.Assert(fi.IsDir(), Equals, false)
+}
+
+func (s *DavSuite) TestOpenFileWithModes(c *C) {
+	c.Skip("WebDAV doesn't support file modes")
+}
+
+func (s *DavSuite) TestSymlink(c *C) {
+	c.Assert(s.FS.Symlink("foo", "bar"), Equals, billy.ErrNotSupported)
+}
+
+func (s *DavSuite) TestCapabilities(c *C) {
+	c.Assert(billy.CapabilityCheck(s.FS, billy.LockCapability), Equals, true)
+}
@@ -0,0 +1,97 @@
+package davfs
+
+import (
+	"os"
+
+	"gopkg.in/src-d/go-billy.v4/internal/memfile"
+)
+
+// file is a file of a WebDAV server. Its content is downloaded on the first
+// read, and kept in memory until closed, when it's uploaded if modified.
+type file struct {
+	*memfile.File
+
+	fs   *Dav
+	path string
+
+	// token is the token of the lock taken by Lock, if any.
+	token string
+}
+
+func newFile(fs *Dav, name, p string, flag int) *file {
+	f := &file{fs: fs, path: p}
+	f.File = memfile.New(name, flag, f.load)
+	return f
+}
+
+func (f *file) load() ([]byte, error) {
+	return f.fs.get(f.path)
+}
+
+// Close uploads the content of the file if it was modified, and releases
+// the lock, if any.
+func (f *file) Close() error {
+	if f.Closed {
+		return os.ErrClosed
+	}
+
+	f.Closed = true
+
+	var err error
+	if f.Dirty {
+		err = f.fs.put(f.path, f.Content, false, f.token)
+	}
+
+	if f.token != "" {
+		if uerr := f.fs.unlock(f.path, f.token); err == nil {
+			err = uerr
+		}
+	}
+
+	f.Content = nil
+	return err
+}
+
+// Lock takes an exclusive write lock of the file on the server, the writes
+// of other clients fail until the lock is released with Unlock or Close.
+func (f *file) Lock() error {
+	if f.Closed {
+		return os.ErrClosed
+	}
+
+	if f.token != "" {
+		return nil
+	}
+
+	token, err := f.fs.lock(f.path)
+	if err != nil {
+		return err
+	}
+
+	f.token = token
+	return nil
+}
+
+// Unlock releases the lock taken by Lock, uploading first the content of the
+// file if modified, since the lock is required to write it.
+func (f *file) Unlock() error {
+	if f.Closed {
+		return os.ErrClosed
+	}
+
+	if f.token == "" {
+		return nil
+	}
+
+	if f.Dirty {
+		if err := f.fs.put(f.path, f.Content, false, f.token); err != nil {
+			return err
+		}
+
+		f.Dirty = false
+	}
+
+	err := f.fs.unlock(f.path, f.token)
+	f.token = ""
+	return err
+}
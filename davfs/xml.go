@@ -0,0 +1,129 @@
+package davfs
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:"><D:prop>
+<D:resourcetype/><D:getcontentlength/><D:getlastmodified/>
+</D:prop></D:propfind>`
+
+const lockInfo = `<?xml version="1.0" encoding="utf-8"?>
+<D:lockinfo xmlns:D="DAV:">
+<D:lockscope><D:exclusive/></D:lockscope>
+<D:locktype><D:write/></D:locktype>
+</D:lockinfo>`
+
+type multistatus struct {
+	Responses []response `xml:"DAV: response"`
+}
+
+type response struct {
+	Href      string     `xml:"DAV: href"`
+	Propstats []propstat `xml:"DAV: propstat"`
+}
+
+type propstat struct {
+	Status string `xml:"DAV: status"`
+	Prop   struct {
+		ContentLength string `xml:"DAV: getcontentlength"`
+		LastModified  string `xml:"DAV: getlastmodified"`
+		ResourceType  struct {
+			Collection *struct{} `xml:"DAV: collection"`
+		} `xml:"DAV: resourcetype"`
+	} `xml:"DAV: prop"`
+}
+
+// entry is a resource found by a PROPFIND request.
+type entry struct {
+	path string
+	info *fileInfo
+}
+
+// propfind returns the resources found at the given path with the given
+// depth, "0" for the resource itself or "1" to include its children.
+func (fs *Dav) propfind(p, depth string) ([]entry, error) {
+	header := http.Header{}
+	header.Set("Depth", depth)
+	header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	res, err := fs.do("PROPFIND", p, false, header, []byte(propfindBody), http.StatusMultiStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	var ms multistatus
+	if err := xml.NewDecoder(res.Body).Decode(&ms); err != nil {
+		return nil, err
+	}
+
+	base := strings.TrimSuffix(path.Clean("/"+fs.base.Path), "/")
+	var entries []entry
+	for _, r := range ms.Responses {
+		u, err := url.Parse(r.Href)
+		if err != nil {
+			return nil, err
+		}
+
+		p := path.Clean("/" + strings.TrimPrefix(path.Clean(u.Path), base))
+		info := &fileInfo{name: path.Base(p)}
+		for _, ps := range r.Propstats {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+
+			info.dir = ps.Prop.ResourceType.Collection != nil
+			info.size, _ = strconv.ParseInt(ps.Prop.ContentLength, 10, 64)
+			info.modTime, _ = http.ParseTime(ps.Prop.LastModified)
+		}
+
+		entries = append(entries, entry{path: p, info: info})
+	}
+
+	return entries, nil
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.size
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+
+	return 0644
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.dir
+}
+
+func (fi *fileInfo) Sys() interface{} {
+	return nil
+}
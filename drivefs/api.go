@@ -0,0 +1,372 @@
+package drivefs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	folderMimeType = "application/vnd.google-apps.folder"
+	fileFields     = "id,name,mimeType,size,modifiedTime,appProperties"
+	// modeProperty is the app property holding the permissions of a file.
+	modeProperty = "mode"
+	listFields   = "nextPageToken,files(" + fileFields + ")"
+)
+
+// driveFile is the metadata of a file or folder of Drive.
+type driveFile struct {
+	ID           string   `json:"id,omitempty"`
+	Name         string   `json:"name,omitempty"`
+	MimeType     string   `json:"mimeType,omitempty"`
+	Parents      []string `json:"parents,omitempty"`
+	Size         int64    `json:"size,omitempty,string"`
+	ModifiedTime string   `json:"modifiedTime,omitempty"`
+	// AppProperties are private to the application, holding the
+	// permissions.
+	AppProperties map[string]string `json:"appProperties,omitempty"`
+}
+
+func (f *driveFile) isDir() bool {
+	return f.MimeType == folderMimeType
+}
+
+type fileList struct {
+	NextPageToken string       `json:"nextPageToken"`
+	Files         []*driveFile `json:"files"`
+}
+
+// apiError is the body of the errors returned by the API.
+type apiError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// url returns the URL of the given path of the API, with the given query.
+func (fs *Drive) url(p string, query url.Values) string {
+	u := *fs.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + p
+	q := url.Values{}
+	q.Set("supportsAllDrives", "true")
+	for k, v := range query {
+		q[k] = v
+	}
+
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// do performs the given request, the response is returned only if the
+// status code is one of the expected ones.
+func (fs *Drive) do(req *http.Request, expected ...int) (*http.Response, error) {
+	res, err := fs.opts.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, code := range expected {
+		if res.StatusCode == code {
+			return res, nil
+		}
+	}
+
+	defer res.Body.Close()
+	return nil, statusError(res)
+}
+
+func statusError(res *http.Response) error {
+	switch res.StatusCode {
+	case http.StatusNotFound:
+		return os.ErrNotExist
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return os.ErrPermission
+	}
+
+	var e apiError
+	if err := json.NewDecoder(res.Body).Decode(&e); err == nil && e.Error.Message != "" {
+		return fmt.Errorf("drive: %s", e.Error.Message)
+	}
+
+	return fmt.Errorf("drive: unexpected status: %s", res.Status)
+}
+
+// call performs a request to the API with the given metadata as JSON body,
+// decoding the JSON response into v, if not nil.
+func (fs *Drive) call(method, p string, query url.Values, metadata, v interface{}) error {
+	var body io.Reader
+	if metadata != nil {
+		b, err := json.Marshal(metadata)
+		if err != nil {
+			return err
+		}
+
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, fs.url(p, query), body)
+	if err != nil {
+		return err
+	}
+
+	if metadata != nil {
+		req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	}
+
+	res, err := fs.do(req, http.StatusOK, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+
+	defer closeBody(res)
+	if v == nil {
+		return nil
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// closeBody closes the body of the given response, reading first what wasn't
+// decoded, such as the empty body of a 204, so the connection is reused.
+func closeBody(res *http.Response) {
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+}
+
+func (fs *Drive) get(id string) (*driveFile, error) {
+	q := url.Values{"fields": {fileFields}}
+	f := &driveFile{}
+	if err := fs.call(http.MethodGet, "/drive/v3/files/"+id, q, nil, f); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// list returns the files of the given folder matching the given name, or
+// all if empty, the oldest first.
+func (fs *Drive) list(parent, name string, limit int) ([]*driveFile, error) {
+	query := fmt.Sprintf("'%s' in parents and trashed = false", escape(parent))
+	if name != "" {
+		query += fmt.Sprintf(" and name = '%s'", escape(name))
+	}
+
+	pageSize := fs.opts.PageSize
+	if limit > 0 && limit < pageSize {
+		pageSize = limit
+	}
+
+	q := url.Values{
+		"q":                         {query},
+		"fields":                    {listFields},
+		"orderBy":                   {"createdTime"},
+		"pageSize":                  {strconv.Itoa(pageSize)},
+		"includeItemsFromAllDrives": {"true"},
+	}
+
+	var files []*driveFile
+	for {
+		var l fileList
+		if err := fs.call(http.MethodGet, "/drive/v3/files", q, nil, &l); err != nil {
+			return nil, err
+		}
+
+		files = append(files, l.Files...)
+		if l.NextPageToken == "" || (limit > 0 && len(files) >= limit) {
+			return files, nil
+		}
+
+		q.Set("pageToken", l.NextPageToken)
+	}
+}
+
+// create creates an empty file, or a folder, with the given permissions.
+func (fs *Drive) create(parent, name, mimeType string, perm os.FileMode) (*driveFile, error) {
+	metadata := &driveFile{
+		Name:     name,
+		MimeType: mimeType,
+		Parents:  []string{parent},
+		AppProperties: map[string]string{
+			modeProperty: strconv.FormatUint(uint64(perm&os.ModePerm), 8),
+		},
+	}
+
+	q := url.Values{"fields": {fileFields}}
+	f := &driveFile{}
+	if err := fs.call(http.MethodPost, "/drive/v3/files", q, metadata, f); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (fs *Drive) delete(id string) error {
+	return fs.call(http.MethodDelete, "/drive/v3/files/"+id, nil, nil, nil)
+}
+
+// download returns the content of the given file.
+func (fs *Drive) download(id string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, fs.url("/drive/v3/files/"+id, url.Values{"alt": {"media"}}), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := fs.do(req, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+	buf := bytes.NewBuffer(nil)
+	if res.ContentLength > 0 {
+		buf.Grow(int(res.ContentLength))
+	}
+
+	_, err = buf.ReadFrom(res.Body)
+	return buf.Bytes(), err
+}
+
+// upload replaces the content of the given file with a resumable upload,
+// sending the content in chunks of ChunkSize bytes. The chunks are resent
+// from the offset confirmed by the server.
+func (fs *Drive) upload(id string, content []byte) error {
+	u := fs.url("/upload/drive/v3/files/"+id, url.Values{"uploadType": {"resumable"}})
+	req, err := http.NewRequest(http.MethodPatch, u, strings.NewReader("{}"))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Upload-Content-Length", strconv.Itoa(len(content)))
+	res, err := fs.do(req, http.StatusOK)
+	if err != nil {
+		return err
+	}
+
+	res.Body.Close()
+	session := res.Header.Get("Location")
+	if session == "" {
+		return fmt.Errorf("drive: missing upload session")
+	}
+
+	var offset, retries int
+	for {
+		end := offset + fs.opts.ChunkSize
+		if end > len(content) {
+			end = len(content)
+		}
+
+		req, err := http.NewRequest(http.MethodPut, session, bytes.NewReader(content[offset:end]))
+		if err != nil {
+			return err
+		}
+
+		if len(content) == 0 {
+			req.Header.Set("Content-Range", "bytes */0")
+		} else {
+			req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, end-1, len(content)))
+		}
+
+		res, err := fs.do(req, http.StatusOK, http.StatusCreated, http.StatusPermanentRedirect)
+		if err != nil {
+			return err
+		}
+
+		res.Body.Close()
+		if res.StatusCode != http.StatusPermanentRedirect {
+			return nil
+		}
+
+		next := confirmed(res.Header.Get("Range"))
+		if next <= offset {
+			if retries++; retries > maxRetries {
+				return fmt.Errorf("drive: upload stalled at %d bytes", offset)
+			}
+		} else {
+			retries = 0
+		}
+
+		offset = next
+	}
+}
+
+// confirmed returns the number of bytes received by the server, from the
+// Range header of a "308 Resume Incomplete" response, eg.: "bytes=0-42".
+func confirmed(r string) int {
+	i := strings.LastIndex(r, "-")
+	if i < 0 {
+		return 0
+	}
+
+	n, err := strconv.Atoi(r[i+1:])
+	if err != nil {
+		return 0
+	}
+
+	return n + 1
+}
+
+// escape escapes the given string to be used in a query.
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s)
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+// newFileInfo returns the info of the given file, with the permissions of
+// its app properties, if any, since Drive has no permissions.
+func newFileInfo(name string, f *driveFile) *fileInfo {
+	fi := &fileInfo{name: name, size: f.Size, mode: 0644}
+	if f.isDir() {
+		fi.mode = 0755
+	}
+
+	if perm, err := strconv.ParseUint(f.AppProperties[modeProperty], 8, 32); err == nil {
+		fi.mode = os.FileMode(perm) & os.ModePerm
+	}
+
+	if f.isDir() {
+		fi.mode |= os.ModeDir
+	}
+
+	fi.modTime, _ = time.Parse(time.RFC3339, f.ModifiedTime)
+	return fi
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.size
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	return fi.mode
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.mode.IsDir()
+}
+
+func (*fileInfo) Sys() interface{} {
+	return nil
+}
@@ -0,0 +1,468 @@
+// Package drivefs provides a billy filesystem over Google Drive, with the
+// Drive API v3.
+//
+// Drive identifies the files by id, a folder may hold several files with the
+// same name. The paths are resolved looking up every name in its parent
+// folder, when a name is duplicated the oldest file is used, and the rest
+// are hidden. The ids of the folders are cached, the files are looked up on
+// every operation.
+//
+// The content of the files is kept in memory, it's downloaded on the first
+// read, and uploaded on Close if modified, with a resumable upload in chunks.
+package drivefs // import "gopkg.in/src-d/go-billy.v4/drivefs"
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/internal/pathutil"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const (
+	defaultEndpoint  = "https://www.googleapis.com"
+	defaultRootID    = "root"
+	defaultPageSize  = 1000
+	defaultChunkSize = 8 << 20
+	// chunkAlignment is the size every chunk must be a multiple of, except
+	// the last one.
+	chunkAlignment = 256 << 10
+	// maxRetries is the number of chunks sent without progress before
+	// failing an upload.
+	maxRetries = 3
+)
+
+var (
+	// ErrNotEmpty is returned when removing a directory with files.
+	ErrNotEmpty = billy.ErrNotEmpty
+
+	errIsDir  = errors.New("is a directory")
+	errNotDir = errors.New("not a directory")
+)
+
+// Options holds the configuration of a Drive filesystem.
+type Options struct {
+	// Client is the client used to perform the requests, it must add the
+	// credentials, eg.: a client of golang.org/x/oauth2. If nil
+	// http.DefaultClient is used.
+	Client *http.Client
+	// Endpoint is the base URL of the API, https://www.googleapis.com by
+	// default.
+	Endpoint string
+	// RootID is the id of the folder used as root, the root of My Drive by
+	// default. It may be the id of a shared drive.
+	RootID string
+	// PageSize is the number of files requested by page when listing a
+	// folder, 1000 by default.
+	PageSize int
+	// ChunkSize is the size of the chunks of the uploads, a multiple of
+	// 256KiB, 8MiB by default.
+	ChunkSize int
+}
+
+// Drive is a filesystem over Google Drive.
+type Drive struct {
+	endpoint *url.URL
+	opts     Options
+
+	mu      sync.Mutex
+	folders map[string]string
+}
+
+// New returns a new filesystem over Google Drive.
+func New(opts Options) (*Drive, error) {
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+
+	if opts.Endpoint == "" {
+		opts.Endpoint = defaultEndpoint
+	}
+
+	if opts.RootID == "" {
+		opts.RootID = defaultRootID
+	}
+
+	if opts.PageSize <= 0 {
+		opts.PageSize = defaultPageSize
+	}
+
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = defaultChunkSize
+	}
+
+	if opts.ChunkSize%chunkAlignment != 0 {
+		return nil, fmt.Errorf("chunk size must be a multiple of %d", chunkAlignment)
+	}
+
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Drive{
+		endpoint: endpoint,
+		opts:     opts,
+		folders:  map[string]string{"/": opts.RootID},
+	}, nil
+}
+
+// lookup returns the file of the given clean path.
+func (fs *Drive) lookup(p string) (*driveFile, error) {
+	if p == "/" {
+		return &driveFile{ID: fs.opts.RootID, MimeType: folderMimeType}, nil
+	}
+
+	parent, err := fs.folder(path.Dir(p))
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := fs.list(parent, path.Base(p), 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(files) == 0 {
+		return nil, os.ErrNotExist
+	}
+
+	return files[0], nil
+}
+
+// folder returns the id of the folder of the given clean path.
+func (fs *Drive) folder(p string) (string, error) {
+	fs.mu.Lock()
+	id, ok := fs.folders[p]
+	fs.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	f, err := fs.lookup(p)
+	if err != nil {
+		return "", err
+	}
+
+	if !f.isDir() {
+		return "", errNotDir
+	}
+
+	fs.mu.Lock()
+	fs.folders[p] = f.ID
+	fs.mu.Unlock()
+	return f.ID, nil
+}
+
+// forget removes the given path, and its children, from the cache of
+// folders.
+func (fs *Drive) forget(p string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	for cached := range fs.folders {
+		if cached == p || strings.HasPrefix(cached, p+"/") {
+			delete(fs.folders, cached)
+		}
+	}
+}
+
+func (fs *Drive) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Drive) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file, creating it if needed, and the missing
+// folders. The permissions are kept in the app properties of the file.
+func (fs *Drive) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	f, err := fs.openFile(pathutil.Relative(filename), clean(filename), flag, perm)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+	}
+
+	return f, nil
+}
+
+func (fs *Drive) openFile(name, p string, flag int, perm os.FileMode) (*file, error) {
+	df, err := fs.lookup(p)
+	switch {
+	case err == nil:
+		if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+			return nil, os.ErrExist
+		}
+
+		if df.isDir() {
+			return nil, errIsDir
+		}
+
+		f := newFile(fs, name, df.ID, flag)
+		if flag&os.O_TRUNC != 0 && isWrite(flag) {
+			if df.Size != 0 {
+				if err := fs.upload(df.ID, nil); err != nil {
+					return nil, err
+				}
+			}
+
+			f.Loaded = true
+		}
+
+		return f, nil
+	case os.IsNotExist(err) && flag&os.O_CREATE != 0:
+		if err := fs.mkdirAll(path.Dir(p), 0755); err != nil {
+			return nil, err
+		}
+
+		parent, err := fs.folder(path.Dir(p))
+		if err != nil {
+			return nil, err
+		}
+
+		df, err := fs.create(parent, path.Base(p), "", perm)
+		if err != nil {
+			return nil, err
+		}
+
+		f := newFile(fs, name, df.ID, flag)
+		f.Loaded = true
+		return f, nil
+	default:
+		return nil, err
+	}
+}
+
+func (fs *Drive) Stat(filename string) (os.FileInfo, error) {
+	p := clean(filename)
+	f, err := fs.lookup(p)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: filename, Err: err}
+	}
+
+	if p == "/" {
+		f, err = fs.get(f.ID)
+		if err != nil {
+			return nil, &os.PathError{Op: "stat", Path: filename, Err: err}
+		}
+
+		return newFileInfo(string(filepath.Separator), f), nil
+	}
+
+	return newFileInfo(path.Base(p), f), nil
+}
+
+// Lstat is equivalent to Stat, since Drive has no symlinks.
+func (fs *Drive) Lstat(filename string) (os.FileInfo, error) {
+	return fs.Stat(filename)
+}
+
+// ReadDir lists the files of the given folder, the files with the name of
+// an older file are skipped.
+func (fs *Drive) ReadDir(filename string) ([]os.FileInfo, error) {
+	infos, err := fs.readDir(clean(filename))
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: filename, Err: err}
+	}
+
+	return infos, nil
+}
+
+func (fs *Drive) readDir(p string) ([]os.FileInfo, error) {
+	id, err := fs.folder(p)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := fs.list(id, "", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(files))
+	var infos []os.FileInfo
+	for _, f := range files {
+		if seen[f.Name] {
+			continue
+		}
+
+		seen[f.Name] = true
+		infos = append(infos, newFileInfo(f.Name, f))
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+
+	return infos, nil
+}
+
+// Rename moves the file, replacing the destination if it's a file, and
+// creating the missing folders.
+func (fs *Drive) Rename(from, to string) error {
+	if err := fs.rename(clean(from), clean(to)); err != nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Drive) rename(from, to string) error {
+	f, err := fs.lookup(from)
+	if err != nil {
+		return err
+	}
+
+	if err := fs.mkdirAll(path.Dir(to), 0755); err != nil {
+		return err
+	}
+
+	target, err := fs.lookup(to)
+	switch {
+	case err == nil && target.ID == f.ID:
+		return nil
+	case err == nil && target.isDir():
+		return os.ErrExist
+	case err == nil:
+		if err := fs.delete(target.ID); err != nil {
+			return err
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	fromParent, err := fs.folder(path.Dir(from))
+	if err != nil {
+		return err
+	}
+
+	toParent, err := fs.folder(path.Dir(to))
+	if err != nil {
+		return err
+	}
+
+	q := url.Values{}
+	if fromParent != toParent {
+		q.Set("addParents", toParent)
+		q.Set("removeParents", fromParent)
+	}
+
+	fs.forget(from)
+	return fs.call(http.MethodPatch, "/drive/v3/files/"+f.ID, q, &driveFile{Name: path.Base(to)}, nil)
+}
+
+// Remove deletes the given file or empty folder permanently, skipping the
+// trash.
+func (fs *Drive) Remove(filename string) error {
+	if err := fs.remove(clean(filename)); err != nil {
+		return &os.PathError{Op: "remove", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Drive) remove(p string) error {
+	f, err := fs.lookup(p)
+	if err != nil {
+		return err
+	}
+
+	if f.isDir() {
+		children, err := fs.list(f.ID, "", 1)
+		if err != nil {
+			return err
+		}
+
+		if len(children) != 0 {
+			return ErrNotEmpty
+		}
+
+		fs.forget(p)
+	}
+
+	return fs.delete(f.ID)
+}
+
+// MkdirAll creates the folder and any missing parent.
+func (fs *Drive) MkdirAll(filename string, perm os.FileMode) error {
+	if err := fs.mkdirAll(clean(filename), perm); err != nil {
+		return &os.PathError{Op: "mkdir", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Drive) mkdirAll(p string, perm os.FileMode) error {
+	_, err := fs.folder(p)
+	if err == nil || !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := fs.mkdirAll(path.Dir(p), perm); err != nil {
+		return err
+	}
+
+	parent, err := fs.folder(path.Dir(p))
+	if err != nil {
+		return err
+	}
+
+	f, err := fs.create(parent, path.Base(p), folderMimeType, perm)
+	if err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	fs.folders[p] = f.ID
+	fs.mu.Unlock()
+	return nil
+}
+
+func (fs *Drive) Symlink(target, link string) error {
+	return billy.ErrNotSupported
+}
+
+func (fs *Drive) Readlink(link string) (string, error) {
+	return "", billy.ErrNotSupported
+}
+
+func (fs *Drive) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(fs, dir, prefix)
+}
+
+func (fs *Drive) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (fs *Drive) Chroot(p string) (billy.Filesystem, error) {
+	return chroot.New(fs, fs.Join(fs.Root(), p)), nil
+}
+
+func (fs *Drive) Root() string {
+	return string(filepath.Separator)
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Drive) Capabilities() billy.Capability {
+	return billy.WriteCapability | billy.ReadCapability |
+		billy.ReadAndWriteCapability | billy.SeekCapability |
+		billy.TruncateCapability
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR) != 0
+}
+
+func clean(p string) string {
+	return path.Clean("/" + filepath.ToSlash(p))
+}
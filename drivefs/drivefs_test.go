@@ -0,0 +1,170 @@
+package drivefs
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&DriveSuite{})
+
+// DriveSuite runs the generic suites, except the symlinks one, since Drive
+// doesn't support symlinks.
+type DriveSuite struct {
+	test.BasicSuite
+	test.DirSuite
+	test.TempFileSuite
+	test.ChrootSuite
+
+	FS     *Drive
+	server *server
+}
+
+func (s *DriveSuite) SetUpTest(c *C) {
+	s.server = newServer()
+	s.FS = s.newFS(c, Options{})
+
+	s.BasicSuite.FS = s.FS
+	s.DirSuite.FS = s.FS
+	s.TempFileSuite.FS = s.FS
+	s.ChrootSuite.FS = s.FS
+
+	// every temporary file is looked up by name with a listing before being
+	// created, and again to be removed, too many requests to run the default
+	// rounds with the race detector.
+	s.TempFileSuite.Rounds = 8
+}
+
+func (s *DriveSuite) TearDownTest(c *C) {
+	s.server.Close()
+}
+
+func (s *DriveSuite) newFS(c *C, opts Options) *Drive {
+	opts.Endpoint = s.server.URL
+	fs, err := New(opts)
+	c.Assert(err, IsNil)
+	return fs
+}
+
+func (s *DriveSuite) TestNewChunkSize(c *C) {
+	_, err := New(Options{ChunkSize: 1000})
+	c.Assert(err, ErrorMatches, "chunk size must be a multiple of 262144")
+}
+
+func (s *DriveSuite) TestRootID(c *C) {
+	folder := s.server.add("root", "folder", folderMimeType, nil)
+	s.server.add(folder.ID, "foo", "", []byte("foo"))
+
+	fs := s.newFS(c, Options{RootID: folder.ID})
+	c.Assert(test.ReadFile(c, fs, "foo"), Equals, "foo")
+}
+
+func (s *DriveSuite) TestDuplicateNames(c *C) {
+	s.server.add("root", "foo", "", []byte("first"))
+	s.server.add("root", "foo", "", []byte("second"))
+	s.server.add("root", "bar", folderMimeType, nil)
+	s.server.add("root", "bar", "", []byte("bar"))
+
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "first")
+
+	infos, err := s.FS.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 2)
+	c.Assert(infos[0].Name(), Equals, "bar")
+	c.Assert(infos[0].IsDir(), Equals, true)
+	c.Assert(infos[1].Name(), Equals, "foo")
+	c.Assert(infos[1].Size(), Equals, int64(5))
+
+	c.Assert(s.FS.Remove("foo"), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "second")
+}
+
+func (s *DriveSuite) TestEscapedNames(c *C) {
+	name := `it's a \ name`
+	c.Assert(util.WriteFile(s.FS, name, []byte("foo"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, name), Equals, "foo")
+}
+
+func (s *DriveSuite) TestResumableUpload(c *C) {
+	fs := s.newFS(c, Options{ChunkSize: chunkAlignment})
+	content := bytes.Repeat([]byte("0123456789"), 60000)
+	c.Assert(util.WriteFile(fs, "foo", content, 0644), IsNil)
+	c.Assert(s.server.count("PATCH /upload/drive/v3/files"), Equals, 1)
+	c.Assert(s.server.count("PUT /upload/sessions"), Equals, 3)
+	c.Assert(test.ReadFile(c, fs, "foo"), Equals, string(content))
+}
+
+func (s *DriveSuite) TestResumableUploadPartial(c *C) {
+	s.server.partial = true
+	fs := s.newFS(c, Options{ChunkSize: chunkAlignment})
+	content := bytes.Repeat([]byte("0123456789"), 60000)
+	c.Assert(util.WriteFile(fs, "foo", content, 0644), IsNil)
+	c.Assert(s.server.count("PUT /upload/sessions") > 3, Equals, true)
+	c.Assert(test.ReadFile(c, fs, "foo"), Equals, string(content))
+}
+
+func (s *DriveSuite) TestReadDirPages(c *C) {
+	fs := s.newFS(c, Options{PageSize: 2})
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		s.server.add("root", name, "", nil)
+	}
+
+	infos, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 5)
+	c.Assert(s.server.count("GET /drive/v3/files"), Equals, 3)
+}
+
+func (s *DriveSuite) TestFolderCache(c *C) {
+	c.Assert(util.WriteFile(s.FS, "a/b/c/foo", []byte("foo"), 0644), IsNil)
+
+	lists := s.server.count("GET /drive/v3/files")
+	for i := 0; i < 3; i++ {
+		_, err := s.FS.Stat("a/b/c/foo")
+		c.Assert(err, IsNil)
+	}
+
+	c.Assert(s.server.count("GET /drive/v3/files"), Equals, lists+3)
+
+	c.Assert(s.FS.Rename("a/b", "b"), IsNil)
+	_, err := s.FS.Stat("a/b/c/foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+	c.Assert(test.ReadFile(c, s.FS, "b/c/foo"), Equals, "foo")
+}
+
+func (s *DriveSuite) TestRenameMove(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar", []byte("bar"), 0644), IsNil)
+	c.Assert(s.FS.Rename("foo/bar", "qux/baz"), IsNil)
+
+	s.server.mu.Lock()
+	defer s.server.mu.Unlock()
+
+	var found bool
+	for _, f := range s.server.files {
+		if f.Name == "baz" {
+			found = true
+			c.Assert(s.server.files[f.Parents[0]].Name, Equals, "qux")
+		}
+	}
+
+	c.Assert(found, Equals, true)
+}
+
+func (s *DriveSuite) TestRemoveNotEmpty(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar", nil, 0644), IsNil)
+
+	err := s.FS.Remove("foo")
+	c.Assert(err.(*os.PathError).Err, Equals, ErrNotEmpty)
+}
+
+func (s *DriveSuite) TestSymlink(c *C) {
+	c.Assert(s.FS.Symlink("foo", "bar"), Equals, billy.ErrNotSupported)
+}
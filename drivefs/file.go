@@ -0,0 +1,61 @@
+package drivefs
+
+import (
+	"os"
+
+	"gopkg.in/src-d/go-billy.v4/internal/memfile"
+)
+
+// file is a file of Drive, identified by its id. Its content is downloaded
+// on the first read, and kept in memory until closed, when it's uploaded if
+// modified.
+type file struct {
+	*memfile.File
+
+	fs *Drive
+	id string
+}
+
+func newFile(fs *Drive, name, id string, flag int) *file {
+	f := &file{fs: fs, id: id}
+	f.File = memfile.New(name, flag, f.load)
+	return f
+}
+
+func (f *file) load() ([]byte, error) {
+	content, err := f.fs.download(f.id)
+	if err != nil {
+		return nil, &os.PathError{Op: "read", Path: f.Name(), Err: err}
+	}
+
+	return content, nil
+}
+
+// Close uploads the content of the file if it was modified.
+func (f *file) Close() error {
+	if f.Closed {
+		return os.ErrClosed
+	}
+
+	f.Closed = true
+	defer func() { f.Content = nil }()
+	if !f.Dirty {
+		return nil
+	}
+
+	if err := f.fs.upload(f.id, f.Content); err != nil {
+		return &os.PathError{Op: "close", Path: f.Name(), Err: err}
+	}
+
+	return nil
+}
+
+// Lock is a no-op in Drive.
+func (f *file) Lock() error {
+	return nil
+}
+
+// Unlock is a no-op in Drive.
+func (f *file) Unlock() error {
+	return nil
+}
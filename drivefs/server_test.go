@@ -0,0 +1,257 @@
+package drivefs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// server is a minimal Drive API v3 server, to test the client. It supports
+// the queries, requests and fields used by the client only.
+type server struct {
+	*httptest.Server
+	// partial makes the upload sessions receive half of every chunk, to
+	// test the resumption of the uploads.
+	partial bool
+
+	mu       sync.Mutex
+	next     int
+	files    map[string]*serverFile
+	sessions map[string]*uploadSession
+	requests map[string]int
+}
+
+type serverFile struct {
+	driveFile
+	created int
+	content []byte
+}
+
+type uploadSession struct {
+	id      string
+	content []byte
+}
+
+var queryRegexp = regexp.MustCompile(`^'((?:[^'\\]|\\.)*)' in parents and trashed = false(?: and name = '((?:[^'\\]|\\.)*)')?$`)
+
+func newServer() *server {
+	s := &server{
+		files:    make(map[string]*serverFile),
+		sessions: make(map[string]*uploadSession),
+		requests: make(map[string]int),
+	}
+
+	s.files["root"] = &serverFile{driveFile: driveFile{ID: "root", Name: "My Drive", MimeType: folderMimeType}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// count returns the number of requests with the given method and path,
+// without the ids, eg.: "GET /drive/v3/files".
+func (s *server) count(request string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests[request]
+}
+
+// add adds a file to the given folder, without checking the names.
+func (s *server) add(parent, name, mimeType string, content []byte) *serverFile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.create(&driveFile{Name: name, MimeType: mimeType, Parents: []string{parent}}, content)
+}
+
+func (s *server) create(metadata *driveFile, content []byte) *serverFile {
+	s.next++
+	f := &serverFile{driveFile: *metadata, created: s.next, content: content}
+	f.ID = fmt.Sprintf("id%d", s.next)
+	f.Size = int64(len(content))
+	f.ModifiedTime = time.Now().UTC().Format(time.RFC3339)
+	s.files[f.ID] = f
+	return f
+}
+
+func (s *server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	id := ""
+	if n := len(parts); n > 2 && parts[n-1] != "files" {
+		id, parts = parts[n-1], parts[:n-1]
+	}
+
+	route := r.Method + " /" + strings.Join(parts, "/")
+	s.requests[route]++
+	switch {
+	case route == "GET /drive/v3/files" && id == "":
+		s.list(w, r)
+	case route == "POST /drive/v3/files":
+		var metadata driveFile
+		if err := json.NewDecoder(r.Body).Decode(&metadata); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		writeJSON(w, &s.create(&metadata, nil).driveFile)
+	case route == "GET /drive/v3/files":
+		f, ok := s.file(w, id)
+		if ok && r.URL.Query().Get("alt") == "media" {
+			w.Write(f.content)
+		} else if ok {
+			writeJSON(w, &f.driveFile)
+		}
+	case route == "PATCH /drive/v3/files":
+		if f, ok := s.file(w, id); ok {
+			s.update(w, r, f)
+		}
+	case route == "DELETE /drive/v3/files":
+		if _, ok := s.file(w, id); ok {
+			delete(s.files, id)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	case route == "PATCH /upload/drive/v3/files":
+		if _, ok := s.file(w, id); ok {
+			s.next++
+			sid := strconv.Itoa(s.next)
+			s.sessions[sid] = &uploadSession{id: id}
+			w.Header().Set("Location", s.URL+"/upload/sessions/"+sid)
+		}
+	case route == "PUT /upload/sessions":
+		s.upload(w, r, id)
+	default:
+		writeError(w, http.StatusNotFound, "not found: "+route)
+	}
+}
+
+func (s *server) file(w http.ResponseWriter, id string) (*serverFile, bool) {
+	f, ok := s.files[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "File not found: "+id)
+	}
+
+	return f, ok
+}
+
+func (s *server) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	m := queryRegexp.FindStringSubmatch(q.Get("q"))
+	if m == nil || q.Get("orderBy") != "createdTime" {
+		writeError(w, http.StatusBadRequest, "invalid query: "+q.Get("q"))
+		return
+	}
+
+	parent, name := unescape(m[1]), unescape(m[2])
+	var files []*serverFile
+	for _, f := range s.files {
+		if len(f.Parents) == 1 && f.Parents[0] == parent && (m[2] == "" || f.Name == name) {
+			files = append(files, f)
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].created < files[j].created })
+
+	start, _ := strconv.Atoi(q.Get("pageToken"))
+	size, _ := strconv.Atoi(q.Get("pageSize"))
+	l := &fileList{}
+	for i := start; i < len(files) && i < start+size; i++ {
+		l.Files = append(l.Files, &files[i].driveFile)
+	}
+
+	if start+size < len(files) {
+		l.NextPageToken = strconv.Itoa(start + size)
+	}
+
+	writeJSON(w, l)
+}
+
+func (s *server) update(w http.ResponseWriter, r *http.Request, f *serverFile) {
+	var metadata driveFile
+	if err := json.NewDecoder(r.Body).Decode(&metadata); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if metadata.Name != "" {
+		f.Name = metadata.Name
+	}
+
+	q := r.URL.Query()
+	if add, remove := q.Get("addParents"), q.Get("removeParents"); add != "" {
+		if remove != f.Parents[0] {
+			writeError(w, http.StatusBadRequest, "invalid parent: "+remove)
+			return
+		}
+
+		f.Parents = []string{add}
+	}
+
+	writeJSON(w, &f.driveFile)
+}
+
+func (s *server) upload(w http.ResponseWriter, r *http.Request, sid string) {
+	u, ok := s.sessions[sid]
+	if !ok {
+		writeError(w, http.StatusNotFound, "session not found")
+		return
+	}
+
+	var start, end, total int
+	if _, err := fmt.Sscanf(r.Header.Get("Content-Range"), "bytes %d-%d/%d", &start, &end, &total); err != nil {
+		if r.Header.Get("Content-Range") != "bytes */0" {
+			writeError(w, http.StatusBadRequest, "invalid range")
+			return
+		}
+	}
+
+	chunk, _ := ioutil.ReadAll(r.Body)
+	if start != len(u.content) || len(chunk) != end-start+1 && total != 0 {
+		writeError(w, http.StatusBadRequest, "unexpected chunk")
+		return
+	}
+
+	if s.partial && len(chunk) > 1 {
+		chunk = chunk[:len(chunk)/2]
+	}
+
+	u.content = append(u.content, chunk...)
+	if len(u.content) < total {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(u.content)-1))
+		w.WriteHeader(http.StatusPermanentRedirect)
+		return
+	}
+
+	f, ok := s.file(w, u.id)
+	if ok {
+		f.content, f.Size = u.content, int64(len(u.content))
+		delete(s.sessions, sid)
+		writeJSON(w, &f.driveFile)
+	}
+}
+
+func unescape(s string) string {
+	return strings.NewReplacer(`\'`, `'`, `\\`, `\`).Replace(s)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	var e apiError
+	e.Error.Code, e.Error.Message = code, msg
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(&e)
+}
@@ -0,0 +1,362 @@
+package dropboxfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	fileTag   = "file"
+	folderTag = "folder"
+)
+
+// metadata is the metadata of a file or folder of Dropbox.
+type metadata struct {
+	Tag            string `json:".tag"`
+	Name           string `json:"name"`
+	PathDisplay    string `json:"path_display,omitempty"`
+	Size           int64  `json:"size,omitempty"`
+	ServerModified string `json:"server_modified,omitempty"`
+}
+
+func (m *metadata) isDir() bool {
+	return m.Tag == folderTag
+}
+
+type listResult struct {
+	Entries []*metadata `json:"entries"`
+	Cursor  string      `json:"cursor"`
+	HasMore bool        `json:"has_more"`
+}
+
+type uploadCursor struct {
+	SessionID string `json:"session_id"`
+	Offset    int    `json:"offset"`
+}
+
+type commitInfo struct {
+	Path       string `json:"path"`
+	Mode       string `json:"mode"`
+	Autorename bool   `json:"autorename"`
+	Mute       bool   `json:"mute"`
+}
+
+// apiError is the body of the errors returned by the API, with a 409
+// status code. The summary holds the tags of the error, separated by
+// slashes, eg.: "path/not_found/..".
+type apiError struct {
+	Summary string `json:"error_summary"`
+}
+
+// call performs a request to a RPC endpoint of the API, with the given
+// arguments as JSON body, decoding the JSON response into v, if not nil.
+func (fs *Dropbox) call(endpoint string, arg, v interface{}) error {
+	body := []byte("null")
+	if arg != nil {
+		var err error
+		if body, err = json.Marshal(arg); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fs.opts.APIEndpoint+"/2/"+endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	res, err := fs.do(req)
+	if err != nil {
+		return err
+	}
+
+	defer closeBody(res)
+	if v == nil {
+		return nil
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// content performs a request to a content endpoint of the API, with the
+// given arguments in the Dropbox-API-Arg header.
+func (fs *Dropbox) content(endpoint string, arg interface{}, body []byte) (*http.Response, error) {
+	header, err := apiArg(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fs.opts.ContentEndpoint+"/2/"+endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Dropbox-API-Arg", header)
+	return fs.do(req)
+}
+
+// do performs the given request with the credentials, the response is
+// returned only if succeeded.
+func (fs *Dropbox) do(req *http.Request) (*http.Response, error) {
+	if fs.opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+fs.opts.Token)
+	}
+
+	res, err := fs.opts.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusOK {
+		return res, nil
+	}
+
+	defer closeBody(res)
+	return nil, statusError(res)
+}
+
+func statusError(res *http.Response) error {
+	switch res.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return os.ErrPermission
+	case http.StatusConflict:
+		var e apiError
+		if err := json.NewDecoder(res.Body).Decode(&e); err != nil {
+			return fmt.Errorf("dropbox: unexpected status: %s", res.Status)
+		}
+
+		return summaryError(e.Summary)
+	}
+
+	msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+	if len(msg) == 0 {
+		return fmt.Errorf("dropbox: unexpected status: %s", res.Status)
+	}
+
+	return fmt.Errorf("dropbox: %s", bytes.TrimSpace(msg))
+}
+
+// summaryError returns the error of the given error summary, the known
+// tags are translated to the errors of the os package.
+func summaryError(summary string) error {
+	tags := strings.Split(summary, "/")
+	for _, tag := range tags {
+		switch tag {
+		case "not_found":
+			return os.ErrNotExist
+		case "conflict":
+			return os.ErrExist
+		case "not_folder":
+			return errNotDir
+		case "not_file":
+			return errIsDir
+		}
+	}
+
+	return fmt.Errorf("dropbox: %s", strings.TrimRight(summary, "./"))
+}
+
+// closeBody closes the body of the given response, reading first what wasn't
+// decoded, such as the results of each chunk of an upload session, so the
+// connection is reused for the next one.
+func closeBody(res *http.Response) {
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+}
+
+func (fs *Dropbox) metadata(p string) (*metadata, error) {
+	m := &metadata{}
+	arg := map[string]string{"path": p}
+	if err := fs.call("files/get_metadata", arg, m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// list returns the entries of the given folder, following the cursor until
+// the listing is complete.
+func (fs *Dropbox) list(p string) ([]*metadata, error) {
+	var l listResult
+	arg := map[string]interface{}{"path": p, "limit": fs.opts.PageSize}
+	if err := fs.call("files/list_folder", arg, &l); err != nil {
+		return nil, err
+	}
+
+	entries := l.Entries
+	for l.HasMore {
+		arg := map[string]string{"cursor": l.Cursor}
+		l = listResult{}
+		if err := fs.call("files/list_folder/continue", arg, &l); err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, l.Entries...)
+	}
+
+	return entries, nil
+}
+
+// isEmpty returns true if the given folder has no entries.
+func (fs *Dropbox) isEmpty(p string) (bool, error) {
+	var l listResult
+	arg := map[string]interface{}{"path": p, "limit": 1}
+	if err := fs.call("files/list_folder", arg, &l); err != nil {
+		return false, err
+	}
+
+	// the pages may be empty while the listing is not complete
+	for len(l.Entries) == 0 && l.HasMore {
+		arg := map[string]string{"cursor": l.Cursor}
+		l = listResult{}
+		if err := fs.call("files/list_folder/continue", arg, &l); err != nil {
+			return false, err
+		}
+	}
+
+	return len(l.Entries) == 0, nil
+}
+
+func (fs *Dropbox) download(p string) ([]byte, error) {
+	res, err := fs.content("files/download", map[string]string{"path": p}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer closeBody(res)
+	buf := bytes.NewBuffer(nil)
+	if res.ContentLength > 0 {
+		buf.Grow(int(res.ContentLength))
+	}
+
+	_, err = buf.ReadFrom(res.Body)
+	return buf.Bytes(), err
+}
+
+// upload writes the given content to the given path with an upload session,
+// sending the content in chunks of ChunkSize bytes. The mode is "add", to
+// fail if the file exists, or "overwrite".
+func (fs *Dropbox) upload(p string, content []byte, mode string) error {
+	first := content
+	if len(first) > fs.opts.ChunkSize {
+		first = first[:fs.opts.ChunkSize]
+	}
+
+	res, err := fs.content("files/upload_session/start", map[string]bool{"close": false}, first)
+	if err != nil {
+		return err
+	}
+
+	var start struct {
+		SessionID string `json:"session_id"`
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&start)
+	closeBody(res)
+	if err != nil {
+		return err
+	}
+
+	cursor := uploadCursor{SessionID: start.SessionID, Offset: len(first)}
+	for cursor.Offset < len(content) {
+		end := cursor.Offset + fs.opts.ChunkSize
+		if end > len(content) {
+			end = len(content)
+		}
+
+		arg := map[string]interface{}{"cursor": cursor, "close": end == len(content)}
+		res, err := fs.content("files/upload_session/append_v2", arg, content[cursor.Offset:end])
+		if err != nil {
+			return err
+		}
+
+		closeBody(res)
+		cursor.Offset = end
+	}
+
+	arg := map[string]interface{}{
+		"cursor": cursor,
+		"commit": &commitInfo{Path: p, Mode: mode, Mute: true},
+	}
+
+	res, err = fs.content("files/upload_session/finish", arg, nil)
+	if err != nil {
+		return err
+	}
+
+	closeBody(res)
+	return nil
+}
+
+// apiArg encodes the given value as JSON to be sent in the Dropbox-API-Arg
+// header, escaping the characters not allowed in a header.
+func apiArg(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	var buf strings.Builder
+	for _, r := range string(b) {
+		if r < 0x7f {
+			buf.WriteRune(r)
+			continue
+		}
+
+		for _, c := range utf16.Encode([]rune{r}) {
+			fmt.Fprintf(&buf, `\u%04x`, c)
+		}
+	}
+
+	return buf.String(), nil
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func newFileInfo(m *metadata) *fileInfo {
+	fi := &fileInfo{name: m.Name, size: m.Size, mode: 0644}
+	if m.isDir() {
+		fi.mode = os.ModeDir | 0755
+	}
+
+	fi.modTime, _ = time.Parse(time.RFC3339, m.ServerModified)
+	return fi
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.size
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	return fi.mode
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.mode.IsDir()
+}
+
+func (*fileInfo) Sys() interface{} {
+	return nil
+}
@@ -0,0 +1,351 @@
+// Package dropboxfs provides a billy filesystem over Dropbox, with the
+// Dropbox API v2.
+//
+// The files are written with upload sessions, sending the content in chunks,
+// the folders are listed following the cursors of list_folder, and Rename
+// uses the move endpoint. Dropbox paths are case insensitive, so are the
+// paths of the filesystem.
+//
+// The content of the files is kept in memory, it's downloaded on the first
+// read, and uploaded on Close if modified.
+package dropboxfs // import "gopkg.in/src-d/go-billy.v4/dropboxfs"
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/internal/pathutil"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const (
+	defaultAPIEndpoint     = "https://api.dropboxapi.com"
+	defaultContentEndpoint = "https://content.dropboxapi.com"
+	defaultPageSize        = 2000
+	defaultChunkSize       = 8 << 20
+	// maxChunkSize is the maximum size of the requests of the upload
+	// sessions.
+	maxChunkSize = 150 << 20
+)
+
+var (
+	// ErrNotEmpty is returned when removing a directory with files.
+	ErrNotEmpty = billy.ErrNotEmpty
+
+	errIsDir  = errors.New("is a directory")
+	errNotDir = errors.New("not a directory")
+)
+
+// Options holds the configuration of a Dropbox filesystem.
+type Options struct {
+	// Client sends the requests to the RPC and content endpoints of the
+	// API, http.DefaultClient if nil. The uploads are sent by chunks, a
+	// request each.
+	Client *http.Client
+	// Token is the OAuth 2 access token sent in every request, it may be
+	// empty if the Client adds the credentials.
+	Token string
+	// APIEndpoint and ContentEndpoint are the base URLs of the RPC and
+	// content endpoints of the API, https://api.dropboxapi.com and
+	// https://content.dropboxapi.com by default.
+	APIEndpoint, ContentEndpoint string
+	// PageSize is the approximate number of entries requested by page when
+	// listing a folder, 2000 by default.
+	PageSize int
+	// ChunkSize is the size of the chunks of the uploads, up to 150MiB,
+	// 8MiB by default.
+	ChunkSize int
+}
+
+// Dropbox is a filesystem over Dropbox.
+type Dropbox struct {
+	opts Options
+}
+
+// New returns a new filesystem over Dropbox.
+func New(opts Options) (*Dropbox, error) {
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+
+	if opts.APIEndpoint == "" {
+		opts.APIEndpoint = defaultAPIEndpoint
+	}
+
+	if opts.ContentEndpoint == "" {
+		opts.ContentEndpoint = defaultContentEndpoint
+	}
+
+	if opts.PageSize <= 0 {
+		opts.PageSize = defaultPageSize
+	}
+
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = defaultChunkSize
+	}
+
+	if opts.ChunkSize > maxChunkSize {
+		return nil, fmt.Errorf("chunk size must be at most %d", maxChunkSize)
+	}
+
+	opts.APIEndpoint = strings.TrimSuffix(opts.APIEndpoint, "/")
+	opts.ContentEndpoint = strings.TrimSuffix(opts.ContentEndpoint, "/")
+	return &Dropbox{opts: opts}, nil
+}
+
+func (fs *Dropbox) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Dropbox) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file, creating it if needed, and the missing
+// folders. The permissions are ignored.
+func (fs *Dropbox) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	f, err := fs.openFile(pathutil.Relative(filename), dropboxPath(filename), flag)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+	}
+
+	return f, nil
+}
+
+func (fs *Dropbox) openFile(name, p string, flag int) (*file, error) {
+	if p == "" {
+		return nil, errIsDir
+	}
+
+	m, err := fs.metadata(p)
+	switch {
+	case err == nil:
+		if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+			return nil, os.ErrExist
+		}
+
+		if m.isDir() {
+			return nil, errIsDir
+		}
+
+		f := newFile(fs, name, p, flag)
+		if flag&os.O_TRUNC != 0 && isWrite(flag) {
+			if m.Size != 0 {
+				if err := fs.upload(p, nil, "overwrite"); err != nil {
+					return nil, err
+				}
+			}
+
+			f.Loaded = true
+		}
+
+		return f, nil
+	case os.IsNotExist(err) && flag&os.O_CREATE != 0:
+		// the uploads create the missing folders
+		if err := fs.upload(p, nil, "add"); err != nil {
+			return nil, err
+		}
+
+		f := newFile(fs, name, p, flag)
+		f.Loaded = true
+		return f, nil
+	default:
+		return nil, err
+	}
+}
+
+func (fs *Dropbox) Stat(filename string) (os.FileInfo, error) {
+	p := dropboxPath(filename)
+	if p == "" {
+		return &fileInfo{name: string(filepath.Separator), mode: os.ModeDir | 0755}, nil
+	}
+
+	m, err := fs.metadata(p)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: filename, Err: err}
+	}
+
+	return newFileInfo(m), nil
+}
+
+// Lstat is equivalent to Stat, since Dropbox has no symlinks.
+func (fs *Dropbox) Lstat(filename string) (os.FileInfo, error) {
+	return fs.Stat(filename)
+}
+
+// ReadDir lists the entries of the given folder, following the cursor of
+// the listing until it's complete.
+func (fs *Dropbox) ReadDir(filename string) ([]os.FileInfo, error) {
+	entries, err := fs.list(dropboxPath(filename))
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: filename, Err: err}
+	}
+
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, m := range entries {
+		if m.Tag != fileTag && m.Tag != folderTag {
+			continue
+		}
+
+		infos = append(infos, newFileInfo(m))
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+
+	return infos, nil
+}
+
+// Rename moves the file with the move endpoint, replacing the destination
+// if it's a file. The missing folders are created by Dropbox.
+func (fs *Dropbox) Rename(from, to string) error {
+	if err := fs.rename(dropboxPath(from), dropboxPath(to)); err != nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Dropbox) rename(from, to string) error {
+	if from == to {
+		_, err := fs.metadata(from)
+		return err
+	}
+
+	target, err := fs.metadata(to)
+	switch {
+	case err == nil && target.isDir():
+		return os.ErrExist
+	case err == nil:
+		if err := fs.call("files/delete_v2", map[string]string{"path": to}, nil); err != nil {
+			return err
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	arg := map[string]interface{}{"from_path": from, "to_path": to, "autorename": false}
+	return fs.call("files/move_v2", arg, nil)
+}
+
+// Remove deletes the given file or empty folder.
+func (fs *Dropbox) Remove(filename string) error {
+	if err := fs.remove(dropboxPath(filename)); err != nil {
+		return &os.PathError{Op: "remove", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Dropbox) remove(p string) error {
+	if p == "" {
+		return ErrNotEmpty
+	}
+
+	m, err := fs.metadata(p)
+	if err != nil {
+		return err
+	}
+
+	// delete_v2 removes the folders recursively
+	if m.isDir() {
+		empty, err := fs.isEmpty(p)
+		if err != nil {
+			return err
+		}
+
+		if !empty {
+			return ErrNotEmpty
+		}
+	}
+
+	return fs.call("files/delete_v2", map[string]string{"path": p}, nil)
+}
+
+// MkdirAll creates the folder and any missing parent, the permissions are
+// ignored.
+func (fs *Dropbox) MkdirAll(filename string, perm os.FileMode) error {
+	if err := fs.mkdirAll(dropboxPath(filename)); err != nil {
+		return &os.PathError{Op: "mkdir", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Dropbox) mkdirAll(p string) error {
+	if p == "" {
+		return nil
+	}
+
+	arg := map[string]interface{}{"path": p, "autorename": false}
+	err := fs.call("files/create_folder_v2", arg, nil)
+	if !os.IsExist(err) {
+		return err
+	}
+
+	m, err := fs.metadata(p)
+	if err != nil {
+		return err
+	}
+
+	if !m.isDir() {
+		return errNotDir
+	}
+
+	return nil
+}
+
+func (fs *Dropbox) Symlink(target, link string) error {
+	return billy.ErrNotSupported
+}
+
+func (fs *Dropbox) Readlink(link string) (string, error) {
+	return "", billy.ErrNotSupported
+}
+
+func (fs *Dropbox) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(fs, dir, prefix)
+}
+
+func (fs *Dropbox) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (fs *Dropbox) Chroot(p string) (billy.Filesystem, error) {
+	return chroot.New(fs, fs.Join(fs.Root(), p)), nil
+}
+
+func (fs *Dropbox) Root() string {
+	return string(filepath.Separator)
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Dropbox) Capabilities() billy.Capability {
+	return billy.WriteCapability | billy.ReadCapability |
+		billy.ReadAndWriteCapability | billy.SeekCapability |
+		billy.TruncateCapability
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR) != 0
+}
+
+// dropboxPath returns the Dropbox path of the given path, an absolute path,
+// or empty for the root.
+func dropboxPath(p string) string {
+	p = path.Clean("/" + filepath.ToSlash(p))
+	if p == "/" {
+		return ""
+	}
+
+	return p
+}
@@ -0,0 +1,158 @@
+package dropboxfs
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&DropboxSuite{})
+
+// DropboxSuite runs the generic suites, except the symlinks one, since
+// Dropbox doesn't support symlinks.
+type DropboxSuite struct {
+	test.BasicSuite
+	test.DirSuite
+	test.TempFileSuite
+	test.ChrootSuite
+
+	FS     *Dropbox
+	server *server
+}
+
+func (s *DropboxSuite) SetUpTest(c *C) {
+	s.server = newServer()
+	s.FS = s.newFS(c, Options{})
+
+	s.BasicSuite.FS = s.FS
+	s.DirSuite.FS = s.FS
+	s.TempFileSuite.FS = s.FS
+	s.ChrootSuite.FS = s.FS
+
+	// every temporary file takes four requests to the server, too many to
+	// run the default rounds with the race detector.
+	s.TempFileSuite.Rounds = 8
+}
+
+func (s *DropboxSuite) TearDownTest(c *C) {
+	s.server.Close()
+}
+
+func (s *DropboxSuite) newFS(c *C, opts Options) *Dropbox {
+	opts.Token = testToken
+	opts.APIEndpoint = s.server.URL
+	opts.ContentEndpoint = s.server.URL + "/"
+	fs, err := New(opts)
+	c.Assert(err, IsNil)
+	return fs
+}
+
+func (s *DropboxSuite) TestNewChunkSize(c *C) {
+	_, err := New(Options{ChunkSize: 200 << 20})
+	c.Assert(err, ErrorMatches, "chunk size must be at most 157286400")
+}
+
+func (s *DropboxSuite) TestToken(c *C) {
+	fs, err := New(Options{APIEndpoint: s.server.URL, ContentEndpoint: s.server.URL})
+	c.Assert(err, IsNil)
+
+	_, err = fs.Stat("foo")
+	c.Assert(os.IsPermission(err), Equals, true)
+}
+
+func (s *DropboxSuite) TestChunkedUpload(c *C) {
+	fs := s.newFS(c, Options{ChunkSize: 1000})
+	content := bytes.Repeat([]byte("0123456789"), 350)
+	c.Assert(util.WriteFile(fs, "foo", content, 0644), IsNil)
+	c.Assert(s.server.count("files/upload_session/append_v2"), Equals, 3)
+	c.Assert(test.ReadFile(c, fs, "foo"), Equals, string(content))
+}
+
+func (s *DropboxSuite) TestNonASCIINames(c *C) {
+	name := "ñandú/😀.txt"
+	c.Assert(util.WriteFile(s.FS, name, []byte("foo"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, name), Equals, "foo")
+
+	fi, err := s.FS.Stat(name)
+	c.Assert(err, IsNil)
+	c.Assert(fi.Name(), Equals, "😀.txt")
+}
+
+func (s *DropboxSuite) TestReadDirCursor(c *C) {
+	fs := s.newFS(c, Options{PageSize: 2})
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		c.Assert(util.WriteFile(fs, name, nil, 0644), IsNil)
+	}
+
+	infos, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 5)
+	c.Assert(s.server.count("files/list_folder"), Equals, 1)
+	c.Assert(s.server.count("files/list_folder/continue"), Equals, 2)
+}
+
+func (s *DropboxSuite) TestRenameMove(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar", []byte("bar"), 0644), IsNil)
+	c.Assert(s.FS.Rename("foo/bar", "qux/baz"), IsNil)
+	c.Assert(s.server.count("files/move_v2"), Equals, 1)
+	c.Assert(test.ReadFile(c, s.FS, "qux/baz"), Equals, "bar")
+}
+
+func (s *DropboxSuite) TestRenameReplace(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "bar", []byte("bar"), 0644), IsNil)
+	c.Assert(s.FS.Rename("foo", "bar"), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "bar"), Equals, "foo")
+
+	c.Assert(s.FS.MkdirAll("qux", 0755), IsNil)
+	err := s.FS.Rename("bar", "qux")
+	c.Assert(os.IsExist(err.(*os.LinkError).Err), Equals, true)
+}
+
+func (s *DropboxSuite) TestRemoveNotEmpty(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar", nil, 0644), IsNil)
+
+	err := s.FS.Remove("foo")
+	c.Assert(err.(*os.PathError).Err, Equals, ErrNotEmpty)
+}
+
+// TestStat overrides the one of BasicSuite, since Dropbox doesn't support
+// file modes.
+func (s *DropboxSuite) TestStat(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar", []byte("foo"), 0644), IsNil)
+
+	fi, err := s.FS.Stat("foo/bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Name(), Equals, "bar")
+	c.Assert(fi.Size(), Equals, int64(3))
+	c.Assert(fi.ModTime().IsZero(), Equals, false)
+	c.Assert(fi.IsDir(), Equals, false)
+}
+
+// TestStatDir overrides the one of DirSuite, since Dropbox folders have no
+// modification time.
+func (s *DropboxSuite) TestStatDir(c *C) {
+	c.Assert(s.FS.MkdirAll("foo/bar", 0755), IsNil)
+
+	fi, err := s.FS.Stat("foo/bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Name(), Equals, "bar")
+	c.Assert(fi.Mode().IsDir(), Equals, true)
+	c.Assert(fi.IsDir(), Equals, true)
+}
+
+func (s *DropboxSuite) TestOpenFileWithModes(c *C) {
+	c.Skip("Dropbox doesn't support file modes")
+}
+
+func (s *DropboxSuite) TestSymlink(c *C) {
+	c.Assert(s.FS.Symlink("foo", "bar"), Equals, billy.ErrNotSupported)
+}
@@ -0,0 +1,60 @@
+package dropboxfs
+
+import (
+	"os"
+
+	"gopkg.in/src-d/go-billy.v4/internal/memfile"
+)
+
+// file is a file of Dropbox. Its content is downloaded on the first read,
+// and kept in memory until closed, when it's uploaded if modified.
+type file struct {
+	*memfile.File
+
+	fs   *Dropbox
+	path string
+}
+
+func newFile(fs *Dropbox, name, p string, flag int) *file {
+	f := &file{fs: fs, path: p}
+	f.File = memfile.New(name, flag, f.load)
+	return f
+}
+
+func (f *file) load() ([]byte, error) {
+	content, err := f.fs.download(f.path)
+	if err != nil {
+		return nil, &os.PathError{Op: "read", Path: f.Name(), Err: err}
+	}
+
+	return content, nil
+}
+
+// Close uploads the content of the file if it was modified.
+func (f *file) Close() error {
+	if f.Closed {
+		return os.ErrClosed
+	}
+
+	f.Closed = true
+	defer func() { f.Content = nil }()
+	if !f.Dirty {
+		return nil
+	}
+
+	if err := f.fs.upload(f.path, f.Content, "overwrite"); err != nil {
+		return &os.PathError{Op: "close", Path: f.Name(), Err: err}
+	}
+
+	return nil
+}
+
+// Lock is a no-op in Dropbox.
+func (f *file) Lock() error {
+	return nil
+}
+
+// Unlock is a no-op in Dropbox.
+func (f *file) Unlock() error {
+	return nil
+}
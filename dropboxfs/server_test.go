@@ -0,0 +1,340 @@
+package dropboxfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const testToken = "token"
+
+// server is a minimal Dropbox API v2 server over a memfs, to test the
+// client. It supports the endpoints and arguments used by the client only,
+// and the paths are case sensitive.
+type server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	fs       billy.Filesystem
+	next     int
+	sessions map[string][]byte
+	cursors  map[string]*listCursor
+	requests map[string]int
+}
+
+type listCursor struct {
+	entries []*metadata
+	limit   int
+}
+
+func newServer() *server {
+	s := &server{
+		fs:       memfs.New(),
+		sessions: make(map[string][]byte),
+		cursors:  make(map[string]*listCursor),
+		requests: make(map[string]int),
+	}
+
+	s.fs.MkdirAll("/", 0755)
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// count returns the number of requests to the given endpoint, eg.:
+// "files/list_folder".
+func (s *server) count(endpoint string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests[endpoint]
+}
+
+func (s *server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer "+testToken {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, "invalid_access_token")
+		return
+	}
+
+	endpoint := strings.TrimPrefix(r.URL.Path, "/2/")
+	s.requests[endpoint]++
+
+	body, _ := ioutil.ReadAll(r.Body)
+	raw := body
+	if arg := r.Header.Get("Dropbox-API-Arg"); arg != "" {
+		for _, c := range arg {
+			if c >= 0x7f {
+				writeText(w, http.StatusBadRequest, "invalid character in Dropbox-API-Arg")
+				return
+			}
+		}
+
+		raw = []byte(arg)
+	}
+
+	var arg struct {
+		Path     string          `json:"path"`
+		FromPath string          `json:"from_path"`
+		ToPath   string          `json:"to_path"`
+		Limit    int             `json:"limit"`
+		Cursor   json.RawMessage `json:"cursor"`
+		Commit   commitInfo      `json:"commit"`
+	}
+
+	if err := json.Unmarshal(raw, &arg); err != nil {
+		writeText(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	switch endpoint {
+	case "files/get_metadata":
+		if m, ok := s.metadata(w, arg.Path, "path"); ok {
+			writeJSON(w, m)
+		}
+	case "files/list_folder":
+		s.list(w, arg.Path, arg.Limit)
+	case "files/list_folder/continue":
+		var id string
+		json.Unmarshal(arg.Cursor, &id)
+		s.page(w, id)
+	case "files/create_folder_v2":
+		if _, err := s.fs.Stat(arg.Path); err == nil {
+			writeError(w, "path/conflict/folder/..")
+			return
+		}
+
+		if !s.checkParents(w, arg.Path) {
+			return
+		}
+
+		s.fs.MkdirAll(arg.Path, 0755)
+		m, _ := s.metadata(w, arg.Path, "path")
+		writeJSON(w, map[string]interface{}{"metadata": m})
+	case "files/delete_v2":
+		if m, ok := s.metadata(w, arg.Path, "path_lookup"); ok {
+			util.RemoveAll(s.fs, arg.Path)
+			writeJSON(w, map[string]interface{}{"metadata": m})
+		}
+	case "files/move_v2":
+		s.move(w, arg.FromPath, arg.ToPath)
+	case "files/download":
+		if m, ok := s.metadata(w, arg.Path, "path"); ok {
+			if m.isDir() {
+				writeError(w, "path/not_file/..")
+				return
+			}
+
+			f, _ := s.fs.Open(arg.Path)
+			io.Copy(w, f)
+			f.Close()
+		}
+	case "files/upload_session/start":
+		s.next++
+		id := strconv.Itoa(s.next)
+		s.sessions[id] = body
+		writeJSON(w, map[string]string{"session_id": id})
+	case "files/upload_session/append_v2":
+		if _, ok := s.session(w, arg.Cursor, body); ok {
+			writeJSON(w, nil)
+		}
+	case "files/upload_session/finish":
+		if id, ok := s.session(w, arg.Cursor, body); ok {
+			s.commit(w, id, &arg.Commit)
+		}
+	default:
+		writeText(w, http.StatusNotFound, "unknown endpoint: "+endpoint)
+	}
+}
+
+func (s *server) metadata(w http.ResponseWriter, p, tag string) (*metadata, bool) {
+	fi, err := s.fs.Stat(p)
+	if err != nil {
+		writeError(w, tag+"/not_found/..")
+		return nil, false
+	}
+
+	return newMetadata(p, fi), true
+}
+
+func newMetadata(p string, fi os.FileInfo) *metadata {
+	m := &metadata{Tag: fileTag, Name: fi.Name(), PathDisplay: p}
+	if fi.IsDir() {
+		m.Tag = folderTag
+		return m
+	}
+
+	m.Size = fi.Size()
+	m.ServerModified = fi.ModTime().UTC().Format(time.RFC3339)
+	return m
+}
+
+// checkParents writes an error if any parent of the given path is a file.
+func (s *server) checkParents(w http.ResponseWriter, p string) bool {
+	for dir := path.Dir(p); dir != "/"; dir = path.Dir(dir) {
+		if fi, err := s.fs.Stat(dir); err == nil && !fi.IsDir() {
+			writeError(w, "path/conflict/file/..")
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *server) list(w http.ResponseWriter, p string, limit int) {
+	if p == "" {
+		p = "/"
+	}
+
+	fi, err := s.fs.Stat(p)
+	if err != nil {
+		writeError(w, "path/not_found/..")
+		return
+	}
+
+	if !fi.IsDir() {
+		writeError(w, "path/not_folder/..")
+		return
+	}
+
+	infos, _ := s.fs.ReadDir(p)
+	c := &listCursor{limit: limit}
+	for _, fi := range infos {
+		c.entries = append(c.entries, newMetadata(path.Join(p, fi.Name()), fi))
+	}
+
+	sort.Slice(c.entries, func(i, j int) bool { return c.entries[i].Name < c.entries[j].Name })
+
+	s.next++
+	id := strconv.Itoa(s.next)
+	s.cursors[id] = c
+	s.page(w, id)
+}
+
+func (s *server) page(w http.ResponseWriter, id string) {
+	c, ok := s.cursors[id]
+	if !ok {
+		writeError(w, "reset/..")
+		return
+	}
+
+	n := c.limit
+	if n <= 0 || n > len(c.entries) {
+		n = len(c.entries)
+	}
+
+	l := &listResult{Entries: c.entries[:n], Cursor: id, HasMore: n < len(c.entries)}
+	c.entries = c.entries[n:]
+	if l.Entries == nil {
+		l.Entries = []*metadata{}
+	}
+
+	writeJSON(w, l)
+}
+
+func (s *server) move(w http.ResponseWriter, from, to string) {
+	if _, ok := s.metadata(w, from, "from_lookup"); !ok {
+		return
+	}
+
+	if _, err := s.fs.Stat(to); err == nil {
+		writeError(w, "to/conflict/file/..")
+		return
+	}
+
+	if !s.checkParents(w, to) {
+		return
+	}
+
+	s.fs.MkdirAll(path.Dir(to), 0755)
+	if err := s.fs.Rename(from, to); err != nil {
+		writeText(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	m, _ := s.metadata(w, to, "to")
+	writeJSON(w, map[string]interface{}{"metadata": m})
+}
+
+// session appends the given chunk to the session of the given cursor,
+// checking its offset.
+func (s *server) session(w http.ResponseWriter, raw json.RawMessage, chunk []byte) (string, bool) {
+	var c uploadCursor
+	json.Unmarshal(raw, &c)
+	content, ok := s.sessions[c.SessionID]
+	if !ok {
+		writeError(w, "lookup_failed/not_found/..")
+		return "", false
+	}
+
+	if c.Offset != len(content) {
+		writeError(w, "lookup_failed/incorrect_offset/..")
+		return "", false
+	}
+
+	s.sessions[c.SessionID] = append(content, chunk...)
+	return c.SessionID, true
+}
+
+func (s *server) commit(w http.ResponseWriter, id string, commit *commitInfo) {
+	content := s.sessions[id]
+	delete(s.sessions, id)
+
+	if fi, err := s.fs.Stat(commit.Path); err == nil {
+		if fi.IsDir() {
+			writeError(w, "path/conflict/folder/..")
+			return
+		}
+
+		if commit.Mode != "overwrite" {
+			writeError(w, "path/conflict/file/..")
+			return
+		}
+	}
+
+	if !s.checkParents(w, commit.Path) {
+		return
+	}
+
+	s.fs.MkdirAll(path.Dir(commit.Path), 0755)
+	if err := util.WriteFile(s.fs, commit.Path, content, 0644); err != nil {
+		writeText(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	m, _ := s.metadata(w, commit.Path, "path")
+	writeJSON(w, m)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, summary string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(&apiError{Summary: summary})
+}
+
+func writeText(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(code)
+	fmt.Fprint(w, msg)
+}
@@ -0,0 +1,221 @@
+//go:build go1.16
+// +build go1.16
+
+// Package embedfs provides a read-only billy filesystem over an io/fs
+// filesystem, such as embed.FS.
+//
+// The paths are translated to the io/fs rules: always relative to the root
+// and separated by forward slashes.
+package embedfs // import "gopkg.in/src-d/go-billy.v4/embedfs"
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+)
+
+// Embed is a read-only filesystem based on a fs.FS.
+type Embed struct {
+	fsys fs.FS
+}
+
+// New returns a new read-only filesystem from the given fs.FS, eg.: an
+// embed.FS. The directories are read with fs.ReadDir, so it is recommended
+// to implement fs.ReadDirFS.
+func New(fsys fs.FS) billy.Filesystem {
+	return chroot.New(&Embed{fsys: fsys}, string(filepath.Separator))
+}
+
+func (e *Embed) Create(filename string) (billy.File, error) {
+	return nil, billy.ErrReadOnly
+}
+
+func (e *Embed) Open(filename string) (billy.File, error) {
+	return e.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (e *Embed) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, billy.ErrReadOnly
+	}
+
+	f, err := e.fsys.Open(clean(filename))
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if fi.IsDir() {
+		f.Close()
+		return nil, &os.PathError{Op: "open", Path: filename, Err: errors.New("is a directory")}
+	}
+
+	return newFile(filename, f), nil
+}
+
+func (e *Embed) Stat(filename string) (os.FileInfo, error) {
+	return fs.Stat(e.fsys, clean(filename))
+}
+
+// Lstat is equivalent to Stat, since io/fs doesn't support symlinks.
+func (e *Embed) Lstat(filename string) (os.FileInfo, error) {
+	return e.Stat(filename)
+}
+
+func (e *Embed) ReadDir(filename string) ([]os.FileInfo, error) {
+	entries, err := fs.ReadDir(e.fsys, clean(filename))
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		fi, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		infos = append(infos, fi)
+	}
+
+	return infos, nil
+}
+
+func (e *Embed) Readlink(link string) (string, error) {
+	if _, err := e.Stat(link); err != nil {
+		return "", err
+	}
+
+	return "", &os.PathError{Op: "readlink", Path: link, Err: errors.New("not a symlink")}
+}
+
+func (e *Embed) Rename(from, to string) error {
+	return billy.ErrReadOnly
+}
+
+func (e *Embed) Remove(filename string) error {
+	return billy.ErrReadOnly
+}
+
+func (e *Embed) MkdirAll(filename string, perm os.FileMode) error {
+	return billy.ErrReadOnly
+}
+
+func (e *Embed) Symlink(target, link string) error {
+	return billy.ErrReadOnly
+}
+
+func (e *Embed) TempFile(dir, prefix string) (billy.File, error) {
+	return nil, billy.ErrReadOnly
+}
+
+func (e *Embed) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+// Capabilities implements the Capable interface.
+func (e *Embed) Capabilities() billy.Capability {
+	return billy.ReadCapability | billy.SeekCapability
+}
+
+// clean returns the given path as a valid io/fs path, relative to the root
+// and without any "." or ".." element.
+func clean(name string) string {
+	name = path.Clean("/" + filepath.ToSlash(name))
+	name = strings.TrimPrefix(name, "/")
+	if name == "" {
+		return "."
+	}
+
+	return name
+}
+
+// file is a read-only billy.File over a fs.File. Seek and ReadAt are
+// supported if the fs.File implements them, as the files of embed.FS do.
+type file struct {
+	name string
+	f    fs.File
+
+	isClosed bool
+}
+
+func newFile(name string, f fs.File) billy.File {
+	return &file{name: name, f: f}
+}
+
+func (f *file) Name() string {
+	return f.name
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	return f.f.Read(p)
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	r, ok := f.f.(io.ReaderAt)
+	if !ok {
+		return 0, billy.ErrNotSupported
+	}
+
+	return r.ReadAt(p, off)
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	s, ok := f.f.(io.Seeker)
+	if !ok {
+		return 0, billy.ErrNotSupported
+	}
+
+	return s.Seek(offset, whence)
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	return 0, billy.ErrReadOnly
+}
+
+func (f *file) Truncate(size int64) error {
+	return billy.ErrReadOnly
+}
+
+func (f *file) Close() error {
+	if f.isClosed {
+		return os.ErrClosed
+	}
+
+	f.isClosed = true
+	return f.f.Close()
+}
+
+// Lock is a no-op in embedfs.
+func (f *file) Lock() error {
+	return nil
+}
+
+// Unlock is a no-op in embedfs.
+func (f *file) Unlock() error {
+	return nil
+}
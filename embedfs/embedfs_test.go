@@ -0,0 +1,154 @@
+//go:build go1.16
+// +build go1.16
+
+package embedfs
+
+import (
+	"embed"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/test"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&EmbedSuite{})
+
+type EmbedSuite struct {
+	FS billy.Filesystem
+}
+
+//go:embed testdata
+var testdata embed.FS
+
+func (s *EmbedSuite) SetUpTest(c *C) {
+	fs, err := New(testdata).Chroot("testdata")
+	c.Assert(err, IsNil)
+	s.FS = fs
+}
+
+func (s *EmbedSuite) TestOpen(c *C) {
+	f, err := s.FS.Open("foo")
+	c.Assert(err, IsNil)
+	c.Assert(f.Name(), Equals, "foo")
+
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "hello world")
+	c.Assert(f.Close(), IsNil)
+}
+
+func (s *EmbedSuite) TestOpenPaths(c *C) {
+	for _, name := range []string{"/qux/bar", "qux/../qux/bar", "./qux/bar", s.FS.Join("qux", "bar")} {
+		c.Assert(test.ReadFile(c, s.FS, name), Equals, "bar")
+	}
+}
+
+func (s *EmbedSuite) TestOpenNotExists(c *C) {
+	_, err := s.FS.Open("nope")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	_, err = s.FS.Stat("nope")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *EmbedSuite) TestOpenDir(c *C) {
+	_, err := s.FS.Open("qux")
+	c.Assert(err, NotNil)
+}
+
+func (s *EmbedSuite) TestSeekAndReadAt(c *C) {
+	f, err := s.FS.Open("foo")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	buf := make([]byte, 5)
+	n, err := f.ReadAt(buf, 6)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf[:n]), Equals, "world")
+
+	pos, err := f.Seek(-5, io.SeekEnd)
+	c.Assert(err, IsNil)
+	c.Assert(pos, Equals, int64(6))
+
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "world")
+}
+
+func (s *EmbedSuite) TestStat(c *C) {
+	fi, err := s.FS.Stat("qux/bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Name(), Equals, "bar")
+	c.Assert(fi.Size(), Equals, int64(3))
+	c.Assert(fi.IsDir(), Equals, false)
+
+	fi, err = s.FS.Lstat("qux")
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+}
+
+func (s *EmbedSuite) TestReadDir(c *C) {
+	entries, err := s.FS.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 2)
+	c.Assert(entries[0].Name(), Equals, "foo")
+	c.Assert(entries[1].Name(), Equals, "qux")
+	c.Assert(entries[1].IsDir(), Equals, true)
+}
+
+func (s *EmbedSuite) TestReadlink(c *C) {
+	_, err := s.FS.Readlink("foo")
+	c.Assert(err, NotNil)
+
+	_, err = s.FS.Readlink("nope")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *EmbedSuite) TestMapFS(c *C) {
+	fs := New(fstest.MapFS{
+		"a/b/c": &fstest.MapFile{Data: []byte("c"), Mode: 0644},
+	})
+
+	c.Assert(test.ReadFile(c, fs, "a/b/c"), Equals, "c")
+
+	entries, err := fs.ReadDir("a")
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 1)
+	c.Assert(entries[0].IsDir(), Equals, true)
+
+	f, err := fs.Open("a/b/c")
+	c.Assert(err, IsNil)
+	_, err = f.Seek(0, io.SeekStart)
+	c.Assert(err, IsNil)
+}
+
+func (s *EmbedSuite) TestReadOnly(c *C) {
+	_, err := s.FS.Create("new")
+	c.Assert(err, Equals, billy.ErrReadOnly)
+
+	_, err = s.FS.OpenFile("foo", os.O_RDWR, 0)
+	c.Assert(err, Equals, billy.ErrReadOnly)
+
+	c.Assert(s.FS.Remove("foo"), Equals, billy.ErrReadOnly)
+	c.Assert(s.FS.Rename("foo", "bar"), Equals, billy.ErrReadOnly)
+	c.Assert(s.FS.MkdirAll("dir", 0755), Equals, billy.ErrReadOnly)
+	c.Assert(s.FS.Symlink("foo", "bar"), Equals, billy.ErrReadOnly)
+
+	f, err := s.FS.Open("foo")
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("foo"))
+	c.Assert(err, Equals, billy.ErrReadOnly)
+}
+
+func (s *EmbedSuite) TestCapabilities(c *C) {
+	c.Assert(billy.CapabilityCheck(s.FS, billy.WriteCapability), Equals, false)
+	c.Assert(billy.CapabilityCheck(s.FS, billy.SeekCapability), Equals, true)
+}
@@ -0,0 +1,385 @@
+// Package encryptfs provides a billy filesystem encrypting the content, and
+// optionally the names, of the files stored in another filesystem, to keep
+// them private from whoever has access to the storage, eg.: a cloud bucket.
+//
+// Every file starts with a plaintext header, holding a random id and the id
+// of the key used, followed by the content split in chunks of the same size,
+// each one encrypted with AES-256-GCM and its own random nonce. The chunks
+// are authenticated with the header, their index and whether they are the
+// last one, so they can't be altered, reordered, moved between files nor
+// dropped from the end. The content is read and written by chunks, so the
+// files are accessed randomly without decrypting them entirely.
+//
+// The names are encrypted deterministically, component by component, so the
+// files can be found by name, at the cost of revealing which files have the
+// same name. The sizes and the structure of the tree are never hidden.
+package encryptfs // import "gopkg.in/src-d/go-billy.v4/encryptfs"
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const (
+	// KeySize is the size of the keys, for AES-256.
+	KeySize = 32
+	// DefaultChunkSize is the default size of the chunks of the files.
+	DefaultChunkSize = 64 * 1024
+
+	minChunkSize = 16
+	maxChunkSize = 16 * 1024 * 1024
+
+	separator = string(filepath.Separator)
+)
+
+var (
+	// ErrCorrupted is returned when a file fails the authentication, or is
+	// malformed.
+	ErrCorrupted = errors.New("corrupted file")
+	// ErrInvalidName is returned when a name can't be decrypted.
+	ErrInvalidName = errors.New("invalid encrypted name")
+)
+
+// KeyProvider provides the keys used to encrypt the files. Every file records
+// the id of the key it was encrypted with, so the keys can be rotated,
+// encrypting the new files with a new key, as long as the old ones are still
+// provided.
+type KeyProvider interface {
+	// Key returns the key with the given id, of KeySize bytes.
+	Key(id string) ([]byte, error)
+	// Current returns the id of the key used to encrypt the new files, of
+	// up to 32 bytes.
+	Current() string
+}
+
+// StaticKey returns a KeyProvider with a single key, with the empty id.
+func StaticKey(key []byte) KeyProvider {
+	return staticKey(key)
+}
+
+type staticKey []byte
+
+func (k staticKey) Key(id string) ([]byte, error) {
+	if id != "" {
+		return nil, fmt.Errorf("unknown key %q", id)
+	}
+
+	return k, nil
+}
+
+func (k staticKey) Current() string {
+	return ""
+}
+
+// Options holds the configuration of an EncryptFS.
+type Options struct {
+	// ChunkSize is the size of the chunks of the files, from 16B to 16MiB,
+	// DefaultChunkSize if zero. It can't be changed once the files are
+	// written.
+	ChunkSize int
+	// EncryptNames encrypts the names of the files, directories and the
+	// targets of the symlinks, with the key with the empty id, which can't
+	// be rotated. The names encrypted are about 40 bytes longer, and should
+	// fit the limits of the underlying filesystem.
+	EncryptNames bool
+}
+
+// EncryptFS is a filesystem encrypting the files stored in the underlying
+// one.
+type EncryptFS struct {
+	underlying billy.Filesystem
+	keys       KeyProvider
+	opts       Options
+
+	// names encrypts the names, with the nonce derived from nonces and the
+	// name, nil if they aren't encrypted.
+	names  cipher.AEAD
+	nonces []byte
+}
+
+// New creates a new filesystem encrypting the files stored in the given one,
+// with the keys provided.
+func New(fs billy.Filesystem, keys KeyProvider, opts Options) (*EncryptFS, error) {
+	if opts.ChunkSize == 0 {
+		opts.ChunkSize = DefaultChunkSize
+	}
+
+	if opts.ChunkSize < minChunkSize || opts.ChunkSize > maxChunkSize {
+		return nil, fmt.Errorf("chunk size must be between %d and %d", minChunkSize, maxChunkSize)
+	}
+
+	if len(keys.Current()) > maxKeyIDSize {
+		return nil, fmt.Errorf("key id must be up to %d bytes", maxKeyIDSize)
+	}
+
+	efs := &EncryptFS{underlying: fs, keys: keys, opts: opts}
+	if !opts.EncryptNames {
+		return efs, nil
+	}
+
+	key, err := keys.Key("")
+	if err != nil {
+		return nil, err
+	}
+
+	if efs.names, err = newAEAD(derive(key, "names")); err != nil {
+		return nil, err
+	}
+
+	efs.nonces = derive(key, "nonces")
+	return efs, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes", KeySize)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// derive derives a key for the given purpose from the given one.
+func derive(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("billy encryptfs " + purpose))
+	return mac.Sum(nil)
+}
+
+// encryptName encrypts a single name, using as nonce a MAC of the name, so
+// it's always encrypted the same way.
+func (fs *EncryptFS) encryptName(name string) string {
+	mac := hmac.New(sha256.New, fs.nonces)
+	mac.Write([]byte(name))
+	nonce := mac.Sum(nil)[:fs.names.NonceSize()]
+
+	return base64.RawURLEncoding.EncodeToString(fs.names.Seal(nonce, nonce, []byte(name), nil))
+}
+
+func (fs *EncryptFS) decryptName(name string) (string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(name)
+	if err != nil || len(b) < fs.names.NonceSize() {
+		return "", ErrInvalidName
+	}
+
+	ns := fs.names.NonceSize()
+	plain, err := fs.names.Open(nil, b[:ns], b[ns:], nil)
+	if err != nil {
+		return "", ErrInvalidName
+	}
+
+	return string(plain), nil
+}
+
+// path returns the given path as stored in the underlying filesystem,
+// encrypting every name but the "." and ".." elements.
+func (fs *EncryptFS) path(p string) string {
+	if fs.names == nil {
+		return p
+	}
+
+	elems := strings.Split(filepath.Clean(p), separator)
+	for i, e := range elems {
+		if e != "" && e != "." && e != ".." {
+			elems[i] = fs.encryptName(e)
+		}
+	}
+
+	return strings.Join(elems, separator)
+}
+
+// plainPath decrypts a path encrypted with path.
+func (fs *EncryptFS) plainPath(p string) (string, error) {
+	if fs.names == nil {
+		return p, nil
+	}
+
+	elems := strings.Split(p, separator)
+	for i, e := range elems {
+		if e == "" || e == "." || e == ".." {
+			continue
+		}
+
+		var err error
+		if elems[i], err = fs.decryptName(e); err != nil {
+			return "", err
+		}
+	}
+
+	return strings.Join(elems, separator), nil
+}
+
+func (fs *EncryptFS) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *EncryptFS) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file, writing a new header if created or
+// truncated. The files open for writing are open for reading too in the
+// underlying filesystem, since the chunks are read before being written.
+func (fs *EncryptFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	p := fs.path(filename)
+	uflag := flag &^ os.O_APPEND
+	if isWrite(flag) {
+		uflag = uflag&^os.O_WRONLY | os.O_RDWR
+	}
+
+	uf, err := fs.underlying.OpenFile(p, uflag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &file{
+		fs:   fs,
+		f:    uf,
+		name: strings.TrimLeft(filename, separator),
+		flag: flag,
+	}
+
+	if err := f.init(); err != nil {
+		uf.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (fs *EncryptFS) Stat(filename string) (os.FileInfo, error) {
+	fi, err := fs.underlying.Stat(fs.path(filename))
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.fileInfo(fi, filepath.Base(filename)), nil
+}
+
+func (fs *EncryptFS) Lstat(filename string) (os.FileInfo, error) {
+	fi, err := fs.underlying.Lstat(fs.path(filename))
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.fileInfo(fi, filepath.Base(filename)), nil
+}
+
+// fileInfo returns the information of a file of the underlying filesystem,
+// with the given name and the size of its content, if a regular file.
+func (fs *EncryptFS) fileInfo(fi os.FileInfo, name string) os.FileInfo {
+	size := fi.Size()
+	if fi.Mode().IsRegular() {
+		size = contentSize(size, fs.opts.ChunkSize)
+	}
+
+	return &fileInfo{FileInfo: fi, name: name, size: size}
+}
+
+// ReadDir returns the entries of the given directory. The entries whose names
+// can't be decrypted, not written through the filesystem, are skipped.
+func (fs *EncryptFS) ReadDir(path string) ([]os.FileInfo, error) {
+	infos, err := fs.underlying.ReadDir(fs.path(path))
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]os.FileInfo, 0, len(infos))
+	for _, fi := range infos {
+		name := fi.Name()
+		if fs.names != nil {
+			if name, err = fs.decryptName(name); err != nil {
+				continue
+			}
+		}
+
+		result = append(result, fs.fileInfo(fi, name))
+	}
+
+	return result, nil
+}
+
+func (fs *EncryptFS) Rename(from, to string) error {
+	return fs.underlying.Rename(fs.path(from), fs.path(to))
+}
+
+func (fs *EncryptFS) Remove(filename string) error {
+	return fs.underlying.Remove(fs.path(filename))
+}
+
+func (fs *EncryptFS) MkdirAll(filename string, perm os.FileMode) error {
+	return fs.underlying.MkdirAll(fs.path(filename), perm)
+}
+
+func (fs *EncryptFS) Symlink(target, link string) error {
+	return fs.underlying.Symlink(fs.path(target), fs.path(link))
+}
+
+func (fs *EncryptFS) Readlink(link string) (string, error) {
+	target, err := fs.underlying.Readlink(fs.path(link))
+	if err != nil {
+		return "", err
+	}
+
+	plain, err := fs.plainPath(target)
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: link, Err: err}
+	}
+
+	return plain, nil
+}
+
+func (fs *EncryptFS) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(fs, dir, prefix)
+}
+
+func (fs *EncryptFS) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (fs *EncryptFS) Chroot(path string) (billy.Filesystem, error) {
+	return chroot.New(fs, fs.Join(fs.Root(), path)), nil
+}
+
+func (fs *EncryptFS) Root() string {
+	return separator
+}
+
+// Capabilities implements the Capable interface.
+func (fs *EncryptFS) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.underlying)
+}
+
+type fileInfo struct {
+	os.FileInfo
+	name string
+	size int64
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.size
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
+}
@@ -0,0 +1,221 @@
+package encryptfs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var testKey = bytes.Repeat([]byte{42}, KeySize)
+
+type EncryptFSSuite struct {
+	test.FilesystemSuite
+	underlying billy.Filesystem
+	opts       Options
+}
+
+var _ = Suite(&EncryptFSSuite{opts: Options{ChunkSize: 16}})
+var _ = Suite(&EncryptFSSuite{opts: Options{ChunkSize: 16, EncryptNames: true}})
+
+func (s *EncryptFSSuite) SetUpTest(c *C) {
+	s.underlying = memfs.New()
+	fs, err := New(s.underlying, StaticKey(testKey), s.opts)
+	c.Assert(err, IsNil)
+	s.FilesystemSuite = test.NewFilesystemSuite(fs)
+}
+
+func (s *EncryptFSSuite) TestEncrypted(c *C) {
+	c.Assert(util.WriteFile(s.FS, "secret.txt", []byte("password123"), 0644), IsNil)
+
+	infos, err := s.underlying.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 1)
+	c.Assert(infos[0].Name() == "secret.txt", Equals, !s.opts.EncryptNames)
+	c.Assert(infos[0].Size(), Equals, int64(headerSize+11+overhead))
+
+	content := test.ReadFile(c, s.underlying, infos[0].Name())
+	c.Assert(strings.Contains(content, "password123"), Equals, false)
+
+	fi, err := s.FS.Stat("secret.txt")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Name(), Equals, "secret.txt")
+	c.Assert(fi.Size(), Equals, int64(11))
+}
+
+func (s *EncryptFSSuite) TestRandomAccess(c *C) {
+	content := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	c.Assert(util.WriteFile(s.FS, "foo", content, 0644), IsNil)
+
+	f, err := s.FS.OpenFile("foo", os.O_RDWR, 0)
+	c.Assert(err, IsNil)
+
+	buf := make([]byte, 10)
+	_, err = f.ReadAt(buf, 12)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf), Equals, "cdefghijkl")
+
+	_, err = f.Seek(14, io.SeekStart)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("XXXX"))
+	c.Assert(err, IsNil)
+
+	// grows the file past the old last chunk, leaving a gap
+	_, err = f.Seek(50, io.SeekStart)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("end"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	expected := append([]byte("0123456789abcdXXXXijklmnopqrstuvwxyz"), make([]byte, 14)...)
+	expected = append(expected, "end"...)
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, string(expected))
+
+	f, err = s.FS.OpenFile("foo", os.O_RDWR, 0)
+	c.Assert(err, IsNil)
+	c.Assert(f.Truncate(20), IsNil)
+	c.Assert(f.Close(), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, string(expected[:20]))
+}
+
+func (s *EncryptFSSuite) TestTampered(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", bytes.Repeat([]byte("foo"), 20), 0644), IsNil)
+	name := s.underlyingName(c, "foo")
+
+	f, err := s.underlying.OpenFile(name, os.O_RDWR, 0)
+	c.Assert(err, IsNil)
+	_, err = f.Seek(headerSize+overhead+16+nonceSize+1, io.SeekStart)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte{0})
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	r, err := s.FS.Open("foo")
+	c.Assert(err, IsNil)
+	defer r.Close()
+
+	// the first chunk is still readable
+	buf := make([]byte, 16)
+	_, err = io.ReadFull(r, buf)
+	c.Assert(err, IsNil)
+
+	_, err = io.ReadFull(r, buf)
+	c.Assert(err.(*os.PathError).Err, Equals, ErrCorrupted)
+}
+
+func (s *EncryptFSSuite) TestTruncated(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", bytes.Repeat([]byte("foo"), 20), 0644), IsNil)
+	name := s.underlyingName(c, "foo")
+
+	// dropping the last chunk is detected
+	f, err := s.underlying.OpenFile(name, os.O_RDWR, 0)
+	c.Assert(err, IsNil)
+	c.Assert(f.Truncate(headerSize+3*(16+overhead)), IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	r, err := s.FS.Open("foo")
+	c.Assert(err, IsNil)
+	defer r.Close()
+
+	_, err = ioutil.ReadAll(r)
+	c.Assert(err.(*os.PathError).Err, Equals, ErrCorrupted)
+}
+
+func (s *EncryptFSSuite) TestWrongKey(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "bar", []byte("bar"), 0644), IsNil)
+
+	fs, err := New(s.underlying, StaticKey(bytes.Repeat([]byte{1}, KeySize)), Options{ChunkSize: 16})
+	c.Assert(err, IsNil)
+
+	f, err := fs.Open(s.underlyingName(c, "foo"))
+	c.Assert(err, IsNil)
+	_, err = ioutil.ReadAll(f)
+	c.Assert(err.(*os.PathError).Err, Equals, ErrCorrupted)
+	c.Assert(f.Close(), IsNil)
+}
+
+func (s *EncryptFSSuite) TestKeyRotation(c *C) {
+	keys := &rotating{keys: map[string][]byte{"": testKey}}
+	fs, err := New(s.underlying, keys, s.opts)
+	c.Assert(err, IsNil)
+	c.Assert(util.WriteFile(fs, "foo", []byte("foo"), 0644), IsNil)
+
+	keys.rotate("1", bytes.Repeat([]byte{1}, KeySize))
+	c.Assert(util.WriteFile(fs, "bar", []byte("bar"), 0644), IsNil)
+
+	c.Assert(test.ReadFile(c, fs, "foo"), Equals, "foo")
+	c.Assert(test.ReadFile(c, fs, "bar"), Equals, "bar")
+
+	delete(keys.keys, "")
+	_, err = fs.Open("bar")
+	c.Assert(err, IsNil)
+}
+
+func (s *EncryptFSSuite) TestChunkSizeMismatch(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+
+	opts := s.opts
+	opts.ChunkSize = 32
+	fs, err := New(s.underlying, StaticKey(testKey), opts)
+	c.Assert(err, IsNil)
+
+	_, err = fs.Open("foo")
+	c.Assert(err, ErrorMatches, ".*chunk size 16, expected 32")
+}
+
+func (s *EncryptFSSuite) TestSymlinkEncrypted(c *C) {
+	c.Assert(s.FS.Symlink("../dir/foo", "link"), IsNil)
+
+	target, err := s.underlying.Readlink(s.underlyingName(c, "link"))
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(target, "foo"), Equals, !s.opts.EncryptNames)
+	c.Assert(strings.HasPrefix(target, "../"), Equals, true)
+
+	target, err = s.FS.Readlink("link")
+	c.Assert(err, IsNil)
+	c.Assert(target, Equals, "../dir/foo")
+}
+
+// underlyingName returns the name of the given file in the root directory of
+// the underlying filesystem.
+func (s *EncryptFSSuite) underlyingName(c *C, name string) string {
+	fs := s.FS.(*EncryptFS)
+	return fs.path(name)
+}
+
+// rotating is a KeyProvider whose current key can be changed.
+type rotating struct {
+	keys    map[string][]byte
+	current string
+}
+
+func (r *rotating) Key(id string) ([]byte, error) {
+	key, ok := r.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", id)
+	}
+
+	return key, nil
+}
+
+func (r *rotating) Current() string {
+	return r.current
+}
+
+func (r *rotating) rotate(id string, key []byte) {
+	r.keys[id] = key
+	r.current = id
+}
@@ -0,0 +1,459 @@
+package encryptfs
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+const (
+	magic         = "BILLYENC"
+	formatVersion = 1
+	headerSize    = 64
+	maxKeyIDSize  = 32
+
+	nonceSize = 12
+	tagSize   = 16
+	overhead  = nonceSize + tagSize
+)
+
+// header is the plaintext header of a file, authenticated as additional data
+// of every chunk, so the chunks can't be moved between files.
+type header [headerSize]byte
+
+func newHeader(chunkSize int, keyID string) (header, error) {
+	var h header
+	copy(h[:], magic)
+	h[8] = formatVersion
+	h[9] = byte(len(keyID))
+	binary.BigEndian.PutUint32(h[12:], uint32(chunkSize))
+	copy(h[32:], keyID)
+
+	// the id of the file
+	_, err := io.ReadFull(rand.Reader, h[16:32])
+	return h, err
+}
+
+func (h header) chunkSize() int {
+	return int(binary.BigEndian.Uint32(h[12:]))
+}
+
+func (h header) keyID() string {
+	return string(h[32 : 32+int(h[9])])
+}
+
+func (h header) validate(chunkSize int) error {
+	if string(h[:8]) != magic {
+		return fmt.Errorf("%s: not an encrypted file", ErrCorrupted)
+	}
+
+	if h[8] != formatVersion {
+		return fmt.Errorf("unsupported encrypted file version: %d", h[8])
+	}
+
+	if h[9] > maxKeyIDSize {
+		return fmt.Errorf("%s: invalid key id", ErrCorrupted)
+	}
+
+	if cs := h.chunkSize(); cs != chunkSize {
+		return fmt.Errorf("%s: chunk size %d, expected %d", ErrCorrupted, cs, chunkSize)
+	}
+
+	return nil
+}
+
+// contentSize returns the size of the content of a file of the given size in
+// the underlying filesystem.
+func contentSize(size int64, chunkSize int) int64 {
+	if size <= headerSize {
+		return 0
+	}
+
+	size -= headerSize
+	slot := int64(chunkSize + overhead)
+	n := size / slot * int64(chunkSize)
+	if rest := size % slot; rest > overhead {
+		n += rest - overhead
+	}
+
+	return n
+}
+
+// file is an encrypted file. The chunks are decrypted as read, keeping the
+// last one, and encrypted again as written, so the underlying file is always
+// complete.
+type file struct {
+	fs   *EncryptFS
+	f    billy.File
+	name string
+	flag int
+
+	header header
+	// aead is nil for the empty files open for reading, which have no
+	// header.
+	aead cipher.AEAD
+	size int64
+
+	cached int64
+	cache  []byte
+
+	position int64
+	isClosed bool
+}
+
+// init reads the header of the file, or writes a new one if empty and open
+// for writing.
+func (f *file) init() error {
+	f.cached = -1
+
+	size, err := f.f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	if size == 0 {
+		if !isWrite(f.flag) {
+			return nil
+		}
+
+		return f.writeHeader()
+	}
+
+	if _, err := f.f.ReadAt(f.header[:], 0); err != nil {
+		if err == io.EOF {
+			err = fmt.Errorf("%s: truncated header", ErrCorrupted)
+		}
+
+		return &os.PathError{Op: "open", Path: f.name, Err: err}
+	}
+
+	if err := f.header.validate(f.fs.opts.ChunkSize); err != nil {
+		return &os.PathError{Op: "open", Path: f.name, Err: err}
+	}
+
+	key, err := f.fs.keys.Key(f.header.keyID())
+	if err != nil {
+		return err
+	}
+
+	if f.aead, err = newAEAD(key); err != nil {
+		return err
+	}
+
+	f.size = contentSize(size, f.fs.opts.ChunkSize)
+	return nil
+}
+
+func (f *file) writeHeader() error {
+	id := f.fs.keys.Current()
+	key, err := f.fs.keys.Key(id)
+	if err != nil {
+		return err
+	}
+
+	if f.aead, err = newAEAD(key); err != nil {
+		return err
+	}
+
+	if f.header, err = newHeader(f.fs.opts.ChunkSize, id); err != nil {
+		return err
+	}
+
+	return f.writeAt(f.header[:], 0)
+}
+
+func (f *file) chunkSize() int64 {
+	return int64(f.fs.opts.ChunkSize)
+}
+
+func (f *file) offset(j int64) int64 {
+	return headerSize + j*(f.chunkSize()+overhead)
+}
+
+// lastChunk returns the index of the last chunk of a file of the given size.
+func (f *file) lastChunk(size int64) int64 {
+	if size == 0 {
+		return 0
+	}
+
+	return (size - 1) / f.chunkSize()
+}
+
+func (f *file) additionalData(j int64, last bool) []byte {
+	ad := make([]byte, headerSize+9)
+	copy(ad, f.header[:])
+	binary.BigEndian.PutUint64(ad[headerSize:], uint64(j))
+	if last {
+		ad[headerSize+8] = 1
+	}
+
+	return ad
+}
+
+// readChunk returns the chunk with the given index of a file of the given
+// size, that must not be modified.
+func (f *file) readChunk(j, size int64) ([]byte, error) {
+	if j == f.cached {
+		return f.cache, nil
+	}
+
+	length := size - j*f.chunkSize()
+	if length > f.chunkSize() {
+		length = f.chunkSize()
+	}
+
+	buf := make([]byte, nonceSize+length+tagSize)
+	if _, err := f.f.ReadAt(buf, f.offset(j)); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrCorrupted
+		}
+
+		return nil, &os.PathError{Op: "read", Path: f.name, Err: err}
+	}
+
+	ad := f.additionalData(j, j == f.lastChunk(size))
+	chunk, err := f.aead.Open(buf[nonceSize:nonceSize], buf[:nonceSize], buf[nonceSize:], ad)
+	if err != nil {
+		return nil, &os.PathError{Op: "read", Path: f.name, Err: ErrCorrupted}
+	}
+
+	f.cached, f.cache = j, chunk
+	return chunk, nil
+}
+
+// writeChunk encrypts the given chunk with a new random nonce.
+func (f *file) writeChunk(j int64, chunk []byte, last bool) error {
+	buf := make([]byte, nonceSize, nonceSize+len(chunk)+tagSize)
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		return err
+	}
+
+	buf = f.aead.Seal(buf, buf, chunk, f.additionalData(j, last))
+	if err := f.writeAt(buf, f.offset(j)); err != nil {
+		return err
+	}
+
+	f.cached, f.cache = j, chunk
+	return nil
+}
+
+func (f *file) writeAt(p []byte, off int64) error {
+	if _, err := f.f.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+
+	_, err := f.f.Write(p)
+	return err
+}
+
+func (f *file) Name() string {
+	return f.name
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.position)
+	f.position += int64(n)
+
+	if err == io.EOF && n != 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	if f.flag&os.O_WRONLY != 0 {
+		return 0, errors.New("read not supported")
+	}
+
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: f.name, Err: errors.New("negative offset")}
+	}
+
+	cs := f.chunkSize()
+	var n int
+	for n < len(p) && off < f.size {
+		chunk, err := f.readChunk(off/cs, f.size)
+		if err != nil {
+			return n, err
+		}
+
+		c := copy(p[n:], chunk[off%cs:])
+		n += c
+		off += int64(c)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.position
+	case io.SeekEnd:
+		offset += f.size
+	}
+
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: errors.New("negative offset")}
+	}
+
+	f.position = offset
+	return f.position, nil
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	if !isWrite(f.flag) {
+		return 0, errors.New("write not supported")
+	}
+
+	if f.flag&os.O_APPEND != 0 {
+		f.position = f.size
+	}
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	size := f.size
+	if end := f.position + int64(len(p)); end > size {
+		size = end
+	}
+
+	if err := f.update(p, f.position, size); err != nil {
+		return 0, err
+	}
+
+	f.position += int64(len(p))
+	return len(p), nil
+}
+
+// update writes the given content at the given offset, growing the file to
+// the given size. When the file grows, the old last chunk is encrypted again,
+// since it isn't the last one anymore, along with the gap until the offset.
+func (f *file) update(p []byte, off, size int64) error {
+	cs := f.chunkSize()
+	old := f.size
+
+	first, last := off/cs, (off+int64(len(p))-1)/cs
+	if size > old {
+		if j := f.lastChunk(old); j < first {
+			first = j
+		}
+
+		last = f.lastChunk(size)
+	}
+
+	for j := first; j <= last; j++ {
+		start := j * cs
+		length := size - start
+		if length > cs {
+			length = cs
+		}
+
+		chunk := make([]byte, length)
+		if start < old {
+			prev, err := f.readChunk(j, old)
+			if err != nil {
+				return err
+			}
+
+			copy(chunk, prev)
+		}
+
+		if off < start+length && off+int64(len(p)) > start {
+			if off >= start {
+				copy(chunk[off-start:], p)
+			} else {
+				copy(chunk, p[start-off:])
+			}
+		}
+
+		if err := f.writeChunk(j, chunk, j == f.lastChunk(size)); err != nil {
+			return err
+		}
+	}
+
+	f.size = size
+	return nil
+}
+
+// Truncate changes the size of the file. When shrunk, the new last chunk is
+// encrypted again, since it's the last one now.
+func (f *file) Truncate(size int64) error {
+	if f.isClosed {
+		return os.ErrClosed
+	}
+
+	if !isWrite(f.flag) {
+		return errors.New("truncate not supported")
+	}
+
+	if size < 0 {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: errors.New("negative size")}
+	}
+
+	switch {
+	case size > f.size:
+		return f.update(nil, f.size, size)
+	case size == f.size:
+		return nil
+	case size == 0:
+		f.size, f.cached, f.cache = 0, -1, nil
+		return f.f.Truncate(headerSize)
+	}
+
+	j := f.lastChunk(size)
+	prev, err := f.readChunk(j, f.size)
+	if err != nil {
+		return err
+	}
+
+	chunk := append([]byte(nil), prev[:size-j*f.chunkSize()]...)
+	if err := f.writeChunk(j, chunk, true); err != nil {
+		return err
+	}
+
+	f.size = size
+	return f.f.Truncate(f.offset(j) + int64(len(chunk)) + overhead)
+}
+
+func (f *file) Close() error {
+	if f.isClosed {
+		return os.ErrClosed
+	}
+
+	f.isClosed = true
+	f.cache = nil
+	return f.f.Close()
+}
+
+func (f *file) Lock() error {
+	return f.f.Lock()
+}
+
+func (f *file) Unlock() error {
+	return f.f.Unlock()
+}
@@ -0,0 +1,329 @@
+package etcdfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// grpcUnauthenticated is the gRPC code of the invalid or expired tokens.
+const grpcUnauthenticated = 16
+
+var errTokenExpired = errors.New("etcd: invalid auth token")
+
+// keyValue is a key of etcd. The int64 fields are encoded as strings by the
+// gateway, as in the JSON mapping of protobuf, and omitted if zero.
+type keyValue struct {
+	Key            []byte `json:"key"`
+	Value          []byte `json:"value,omitempty"`
+	CreateRevision int64  `json:"create_revision,string,omitempty"`
+	ModRevision    int64  `json:"mod_revision,string,omitempty"`
+	Lease          int64  `json:"lease,string,omitempty"`
+}
+
+type responseHeader struct {
+	Revision int64 `json:"revision,string"`
+}
+
+type rangeRequest struct {
+	Key      []byte `json:"key"`
+	RangeEnd []byte `json:"range_end,omitempty"`
+	Limit    int64  `json:"limit,string,omitempty"`
+}
+
+type rangeResponse struct {
+	Header responseHeader `json:"header"`
+	Kvs    []*keyValue    `json:"kvs"`
+}
+
+type putRequest struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+	Lease int64  `json:"lease,string,omitempty"`
+}
+
+type deleteRangeRequest struct {
+	Key []byte `json:"key"`
+}
+
+// compare is a condition of a transaction. Only the revision of the target
+// is set, since they are a oneof of protobuf.
+type compare struct {
+	Target         string `json:"target"`
+	Result         string `json:"result"`
+	Key            []byte `json:"key"`
+	RangeEnd       []byte `json:"range_end,omitempty"`
+	CreateRevision *int64 `json:"create_revision,string,omitempty"`
+	ModRevision    *int64 `json:"mod_revision,string,omitempty"`
+}
+
+type requestOp struct {
+	RequestRange       *rangeRequest       `json:"request_range,omitempty"`
+	RequestPut         *putRequest         `json:"request_put,omitempty"`
+	RequestDeleteRange *deleteRangeRequest `json:"request_delete_range,omitempty"`
+}
+
+type txnRequest struct {
+	Compare []*compare   `json:"compare,omitempty"`
+	Success []*requestOp `json:"success,omitempty"`
+}
+
+type txnResponse struct {
+	Header    responseHeader `json:"header"`
+	Succeeded bool           `json:"succeeded"`
+	Responses []struct {
+		ResponseRange *rangeResponse `json:"response_range"`
+	} `json:"responses"`
+}
+
+// created is true if the given key was created at the given revision, or
+// doesn't exist if zero.
+func created(key []byte, rev int64) *compare {
+	return &compare{Target: "CREATE", Result: "EQUAL", Key: key, CreateRevision: &rev}
+}
+
+// exists is true if the given key exists.
+func exists(key []byte) *compare {
+	var rev int64
+	return &compare{Target: "CREATE", Result: "GREATER", Key: key, CreateRevision: &rev}
+}
+
+// modified is true if the given key was last modified at the given
+// revision, so it wasn't modified since read.
+func modified(key []byte, rev int64) *compare {
+	return &compare{Target: "MOD", Result: "EQUAL", Key: key, ModRevision: &rev}
+}
+
+// unmodified is true if no key of the given range was created or modified
+// after the given revision.
+func unmodified(key, end []byte, rev int64) *compare {
+	rev++
+	return &compare{Target: "MOD", Result: "LESS", Key: key, RangeEnd: end, ModRevision: &rev}
+}
+
+// empty is true if there is no key in the given range.
+func empty(key, end []byte) *compare {
+	var rev int64
+	return &compare{Target: "CREATE", Result: "EQUAL", Key: key, RangeEnd: end, CreateRevision: &rev}
+}
+
+func get(key, end []byte) *requestOp {
+	return &requestOp{RequestRange: &rangeRequest{Key: key, RangeEnd: end}}
+}
+
+func put(key, value []byte, lease int64) *requestOp {
+	return &requestOp{RequestPut: &putRequest{Key: key, Value: value, Lease: lease}}
+}
+
+func del(key []byte) *requestOp {
+	return &requestOp{RequestDeleteRange: &deleteRangeRequest{Key: key}}
+}
+
+// apiError is the body of the errors returned by the gateway.
+type apiError struct {
+	Message string `json:"message"`
+	Code    int    `json:"code"`
+}
+
+// call performs a request to the given endpoint of the gateway, eg.:
+// "kv/range", with the given body encoded as JSON, decoding the JSON
+// response into v.
+func (fs *Etcd) call(endpoint string, body, v interface{}) error {
+	res, err := fs.request(context.Background(), endpoint, body)
+	if err != nil {
+		return err
+	}
+
+	defer closeBody(res)
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// request performs a request to the given endpoint, authenticated if there
+// are credentials, the response is returned only if succeeded. The token is
+// requested again once if it expired.
+func (fs *Etcd) request(ctx context.Context, endpoint string, body interface{}) (*http.Response, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	for retry := true; ; retry = false {
+		token, err := fs.token()
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := fs.post(ctx, endpoint, b, token)
+		if err == errTokenExpired && retry {
+			fs.resetToken(token)
+			continue
+		}
+
+		return res, err
+	}
+}
+
+func (fs *Etcd) post(ctx context.Context, endpoint string, body []byte, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fs.endpoint+"/v3/"+endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	res, err := fs.opts.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusOK {
+		return res, nil
+	}
+
+	defer closeBody(res)
+	return nil, statusError(res, token != "")
+}
+
+func statusError(res *http.Response, authenticated bool) error {
+	var e apiError
+	if err := json.NewDecoder(res.Body).Decode(&e); err != nil || e.Message == "" {
+		return fmt.Errorf("etcd: unexpected status: %s", res.Status)
+	}
+
+	switch {
+	case e.Code == grpcUnauthenticated && authenticated:
+		return errTokenExpired
+	case res.StatusCode == http.StatusForbidden:
+		return os.ErrPermission
+	}
+
+	return fmt.Errorf("etcd: %s", e.Message)
+}
+
+// closeBody closes the body of the given response, reading first what the
+// decoder left of it. It isn't used with the streams of the watches once
+// started, never ending on their own, which are closed cancelling them.
+func closeBody(res *http.Response) {
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+}
+
+// token returns the token to authenticate the requests, requesting one if
+// needed, or empty if there are no credentials.
+func (fs *Etcd) token() (string, error) {
+	if fs.opts.Username == "" {
+		return "", nil
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.authToken != "" {
+		return fs.authToken, nil
+	}
+
+	b, err := json.Marshal(map[string]string{"name": fs.opts.Username, "password": fs.opts.Password})
+	if err != nil {
+		return "", err
+	}
+
+	res, err := fs.post(context.Background(), "auth/authenticate", b, "")
+	if err != nil {
+		return "", err
+	}
+
+	defer closeBody(res)
+	var auth struct {
+		Token string `json:"token"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&auth); err != nil {
+		return "", err
+	}
+
+	fs.authToken = auth.Token
+	return fs.authToken, nil
+}
+
+// resetToken forgets the given token, unless it was already requested again
+// by other request.
+func (fs *Etcd) resetToken(token string) {
+	fs.mu.Lock()
+	if fs.authToken == token {
+		fs.authToken = ""
+	}
+	fs.mu.Unlock()
+}
+
+// rangeKeys returns the keys of the given range, sorted.
+func (fs *Etcd) rangeKeys(key, end []byte, limit int64) ([]*keyValue, error) {
+	var res rangeResponse
+	if err := fs.call("kv/range", &rangeRequest{Key: key, RangeEnd: end, Limit: limit}, &res); err != nil {
+		return nil, err
+	}
+
+	return res.Kvs, nil
+}
+
+// txn performs a transaction with the given conditions, applying the given
+// operations only if all of them are true.
+func (fs *Etcd) txn(cmps []*compare, ops []*requestOp) (*txnResponse, error) {
+	res := &txnResponse{}
+	if err := fs.call("kv/txn", &txnRequest{Compare: cmps, Success: ops}, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// grant returns a new lease with the given time to live, rounded up to
+// seconds, or zero if ttl is zero.
+func (fs *Etcd) grant(ttl time.Duration) (int64, error) {
+	if ttl <= 0 {
+		return 0, nil
+	}
+
+	seconds := int64((ttl + time.Second - 1) / time.Second)
+	var res struct {
+		ID int64 `json:"ID,string"`
+	}
+
+	err := fs.call("lease/grant", map[string]string{"TTL": strconv.FormatInt(seconds, 10)}, &res)
+	return res.ID, err
+}
+
+// timeToLive returns the remaining time to live of the given lease, or a
+// negative duration if it expired.
+func (fs *Etcd) timeToLive(lease int64) (time.Duration, error) {
+	var res struct {
+		TTL int64 `json:"TTL,string"`
+	}
+
+	err := fs.call("lease/timetolive", map[string]string{"ID": strconv.FormatInt(lease, 10)}, &res)
+	return time.Duration(res.TTL) * time.Second, err
+}
+
+// prefixEnd returns the end of the range of the keys with the given prefix.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+
+	// all the keys from the prefix
+	return []byte{0}
+}
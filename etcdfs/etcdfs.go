@@ -0,0 +1,724 @@
+// Package etcdfs provides a billy filesystem stored in an etcd cluster, to
+// share small files between the processes of a distributed application,
+// eg.: configuration or coordination state.
+//
+// Every file, directory and symlink is a key holding its metadata and its
+// content, and the keys of the children of a directory share a prefix, so
+// they are listed with a single range. The writes are transactions
+// conditioned to the revisions of the keys read, so they are linearizable: a
+// write based on stale data is never applied, but done again with the new
+// data. The files can have a time to live, implemented with leases, and the
+// changes can be watched.
+//
+// The requests are made to the JSON gateway of the v3 API. etcd is meant for
+// small values: the size of the files is limited by the maximum size of the
+// requests of the server, 1.5MiB by default, and the number of files renamed
+// at once by the maximum number of operations of a transaction, 128 by
+// default.
+package etcdfs // import "gopkg.in/src-d/go-billy.v4/etcdfs"
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/internal/linkutil"
+	"gopkg.in/src-d/go-billy.v4/internal/pathutil"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const (
+	defaultPrefix = "billy"
+	// maxRetries is the number of times an operation is done again when
+	// its transaction fails due to concurrent modifications.
+	maxRetries = 16
+)
+
+var (
+	// ErrNotEmpty is returned when removing a directory with files.
+	ErrNotEmpty = billy.ErrNotEmpty
+
+	errNotDir   = errors.New("not a directory")
+	errConflict = errors.New("etcd: too many concurrent modifications")
+)
+
+// Options holds the configuration of an etcd filesystem.
+type Options struct {
+	// Client is the client used to perform the requests, if nil
+	// http.DefaultClient is used. It shouldn't have a timeout to Watch.
+	Client *http.Client
+	// Username and Password are used to request a token, if the username
+	// isn't empty, the token is requested again when it expires.
+	Username string
+	Password string
+	// Prefix is the prefix of the keys of the filesystem, "billy" by
+	// default. Several filesystems can be stored in the same cluster using
+	// different prefixes.
+	Prefix string
+	// TTL is the time to live of the new files and symlinks, rounded up to
+	// seconds, zero means they never expire. It can be changed for each
+	// file with SetTTL. The directories never expire.
+	TTL time.Duration
+}
+
+// Etcd is a filesystem stored in an etcd cluster.
+type Etcd struct {
+	endpoint string
+	opts     Options
+
+	mu        sync.Mutex
+	authToken string
+}
+
+// New returns a new filesystem stored in the etcd cluster of the given
+// endpoint, eg.: "http://localhost:2379". No request is made until the
+// first operation.
+func New(endpoint string, opts Options) (*Etcd, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme: %q", u.Scheme)
+	}
+
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+
+	if opts.Prefix == "" {
+		opts.Prefix = defaultPrefix
+	}
+
+	return &Etcd{endpoint: strings.TrimRight(endpoint, "/"), opts: opts}, nil
+}
+
+// dirPrefix returns the prefix of the keys of the children of the given
+// directory.
+func (fs *Etcd) dirPrefix(dir string) []byte {
+	return []byte(fs.opts.Prefix + dir + "\x00")
+}
+
+func (fs *Etcd) key(p string) []byte {
+	return append(fs.dirPrefix(path.Dir(p)), path.Base(p)...)
+}
+
+// path returns the path of the given key, or empty if it isn't a key of the
+// filesystem.
+func (fs *Etcd) path(key []byte) string {
+	k := strings.TrimPrefix(string(key), fs.opts.Prefix)
+	i := strings.LastIndexByte(k, 0)
+	if i < 0 || !strings.HasPrefix(k, "/") {
+		return ""
+	}
+
+	return path.Join(k[:i], k[i+1:])
+}
+
+// descendants returns the ranges of the keys of the descendants of the
+// given directory: the ones of its children, and the ones of the children
+// of its subdirectories.
+func (fs *Etcd) descendants(dir string) [][2][]byte {
+	children := fs.dirPrefix(dir)
+	sub := []byte(fs.opts.Prefix + dir + "/")
+	return [][2][]byte{{children, prefixEnd(children)}, {sub, prefixEnd(sub)}}
+}
+
+// atomically calls the given function until it returns true or an error. It
+// must return false if its transaction failed because the keys read were
+// modified in the meantime, so it's done again with the new values.
+func atomically(fn func() (bool, error)) error {
+	for i := 0; i < maxRetries; i++ {
+		ok, err := fn()
+		if err != nil || ok {
+			return err
+		}
+	}
+
+	return errConflict
+}
+
+func (fs *Etcd) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Etcd) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file. The content is read when opened, and kept
+// in memory until closed, when it's written if modified. The new files are
+// created right away, so they can be found by other clients.
+func (fs *Etcd) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	p, e, err := fs.resolve(clean(filename), true)
+	switch {
+	case os.IsNotExist(err) && flag&os.O_CREATE != 0:
+		// p is the target of the last link, even when it's dangling, so
+		// the target is created.
+		n := &node{mode: perm.Perm(), ttl: fs.opts.TTL}
+		rev, err := fs.create(p, n, nil)
+		if err != nil {
+			return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+		}
+
+		e = &entry{node: n, kv: &keyValue{CreateRevision: rev}}
+		return newFile(fs, pathutil.Relative(filename), p, flag, e), nil
+	case err != nil:
+		return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+	case flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrExist}
+	case e.mode.IsDir():
+		return nil, &os.PathError{Op: "open", Path: filename, Err: errors.New("is a directory")}
+	}
+
+	f := newFile(fs, pathutil.Relative(filename), p, flag, e)
+	if isWrite(flag) && flag&os.O_TRUNC != 0 {
+		f.Content = nil
+		if err := fs.store(p, f.rev, nil); err != nil {
+			return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+		}
+	}
+
+	return f, nil
+}
+
+// resolve returns the entry of the given path, following the links in the
+// path, and the last one if follow is true. The returned path is the one of
+// the entry, also when it doesn't exist, so it can be created. The entry and
+// its parent directories are read in a single transaction, so they are
+// consistent.
+func (fs *Etcd) resolve(p string, follow bool) (string, *entry, error) {
+	for links := 0; ; {
+		if p == "/" {
+			return p, rootEntry, nil
+		}
+
+		names := strings.Split(p[1:], "/")
+		ops := make([]*requestOp, len(names))
+		for i := range names {
+			ops[i] = get(fs.key("/"+path.Join(names[:i+1]...)), nil)
+		}
+
+		res, err := fs.txn(nil, ops)
+		if err != nil {
+			return p, nil, err
+		}
+
+		if len(res.Responses) != len(ops) {
+			return p, nil, errors.New("etcd: unexpected response")
+		}
+
+		var e *entry
+		for i := range names {
+			r := res.Responses[i].ResponseRange
+			if r == nil || len(r.Kvs) == 0 {
+				return p, nil, os.ErrNotExist
+			}
+
+			if e, err = decode(r.Kvs[0]); err != nil {
+				return p, nil, err
+			}
+
+			last := i == len(names)-1
+			if e.mode&os.ModeSymlink != 0 && (follow || !last) {
+				if links++; links > linkutil.MaxLinks {
+					return p, nil, linkutil.ErrTooManyLinks
+				}
+
+				target := filepath.ToSlash(string(e.content))
+				if !pathutil.IsAbs(target) {
+					target = path.Join("/", path.Join(names[:i]...), target)
+				}
+
+				p, e = clean(path.Join(append([]string{target}, names[i+1:]...)...)), nil
+				break
+			}
+
+			if !last && !e.mode.IsDir() {
+				return p, nil, errNotDir
+			}
+		}
+
+		if e != nil {
+			return p, e, nil
+		}
+	}
+}
+
+// create stores a new file, directory or symlink, creating its parents,
+// returning the revision of its creation. It fails with os.ErrExist if it
+// already exists, even if created by other client in the meantime.
+func (fs *Etcd) create(p string, n *node, content []byte) (int64, error) {
+	lease, err := fs.grant(n.ttl)
+	if err != nil {
+		return 0, err
+	}
+
+	perm := os.FileMode(0755)
+	if n.mode.IsDir() {
+		perm = n.mode.Perm()
+	}
+
+	var rev int64
+	err = atomically(func() (bool, error) {
+		dir := path.Dir(p)
+		if err := fs.mkdirAll(dir, perm); err != nil {
+			return false, err
+		}
+
+		key := fs.key(p)
+		cmps := []*compare{created(key, 0)}
+		if dir != "/" {
+			cmps = append(cmps, exists(fs.key(dir)))
+		}
+
+		n.modTime = time.Now()
+		res, err := fs.txn(cmps, []*requestOp{put(key, n.encode(content), lease)})
+		if err != nil {
+			return false, err
+		}
+
+		if res.Succeeded {
+			rev = res.Header.Revision
+			return true, nil
+		}
+
+		// either the node was created, or the parent removed
+		if _, _, err := fs.resolve(p, false); err == nil {
+			return false, os.ErrExist
+		}
+
+		return false, nil
+	})
+
+	return rev, err
+}
+
+// store writes the content of a file. If the file was removed, or replaced,
+// since it was created at the given revision, the content is discarded. The
+// time to live of the file starts again.
+func (fs *Etcd) store(p string, rev int64, content []byte) error {
+	key := fs.key(p)
+	return atomically(func() (bool, error) {
+		kvs, err := fs.rangeKeys(key, nil, 0)
+		if err != nil || len(kvs) == 0 || kvs[0].CreateRevision != rev {
+			return true, err
+		}
+
+		e, err := decode(kvs[0])
+		if err != nil {
+			return false, err
+		}
+
+		lease, err := fs.grant(e.ttl)
+		if err != nil {
+			return false, err
+		}
+
+		e.modTime = time.Now()
+		res, err := fs.txn(
+			[]*compare{modified(key, e.kv.ModRevision)},
+			[]*requestOp{put(key, e.encode(content), lease)},
+		)
+
+		if err != nil {
+			return false, err
+		}
+
+		return res.Succeeded, nil
+	})
+}
+
+func (fs *Etcd) Stat(filename string) (os.FileInfo, error) {
+	_, e, err := fs.resolve(clean(filename), true)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: filename, Err: err}
+	}
+
+	// the name is the one of the stated file, even if it's a link.
+	return newFileInfo(filepath.Base(filename), e), nil
+}
+
+func (fs *Etcd) Lstat(filename string) (os.FileInfo, error) {
+	_, e, err := fs.resolve(clean(filename), false)
+	if err != nil {
+		return nil, &os.PathError{Op: "lstat", Path: filename, Err: err}
+	}
+
+	return newFileInfo(filepath.Base(filename), e), nil
+}
+
+func (fs *Etcd) ReadDir(filename string) ([]os.FileInfo, error) {
+	infos, err := fs.readDir(clean(filename))
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: filename, Err: err}
+	}
+
+	return infos, nil
+}
+
+// readDir returns the children of the given directory, sorted by name.
+func (fs *Etcd) readDir(p string) ([]os.FileInfo, error) {
+	p, e, err := fs.resolve(p, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if !e.mode.IsDir() {
+		return nil, errNotDir
+	}
+
+	prefix := fs.dirPrefix(p)
+	kvs, err := fs.rangeKeys(prefix, prefixEnd(prefix), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []os.FileInfo
+	for _, kv := range kvs {
+		e, err := decode(kv)
+		if err != nil {
+			return nil, err
+		}
+
+		infos = append(infos, newFileInfo(string(kv.Key[len(prefix):]), e))
+	}
+
+	return infos, nil
+}
+
+func (fs *Etcd) MkdirAll(filename string, perm os.FileMode) error {
+	if err := fs.mkdirAll(clean(filename), perm); err != nil {
+		return &os.PathError{Op: "mkdir", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Etcd) mkdirAll(p string, perm os.FileMode) error {
+	return atomically(func() (bool, error) {
+		dir, e, err := fs.resolve(p, true)
+		switch {
+		case err == nil && !e.mode.IsDir():
+			return false, errNotDir
+		case err == nil:
+			return true, nil
+		case !os.IsNotExist(err):
+			return false, err
+		}
+
+		_, err = fs.create(dir, &node{mode: os.ModeDir | perm.Perm()}, nil)
+		if os.IsExist(err) {
+			// created by other client in the meantime
+			return false, nil
+		}
+
+		return err == nil, err
+	})
+}
+
+func (fs *Etcd) Remove(filename string) error {
+	if err := fs.remove(clean(filename)); err != nil {
+		return &os.PathError{Op: "remove", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Etcd) remove(p string) error {
+	if p == "/" {
+		return errors.New("cannot remove the root")
+	}
+
+	return atomically(func() (bool, error) {
+		p, e, err := fs.resolve(p, false)
+		if err != nil {
+			return false, err
+		}
+
+		key := fs.key(p)
+		cmps := []*compare{modified(key, e.kv.ModRevision)}
+		if e.mode.IsDir() {
+			cmp, err := fs.isEmpty(p)
+			if err != nil {
+				return false, err
+			}
+
+			cmps = append(cmps, cmp)
+		}
+
+		res, err := fs.txn(cmps, []*requestOp{del(key)})
+		if err != nil {
+			return false, err
+		}
+
+		return res.Succeeded, nil
+	})
+}
+
+// isEmpty returns the condition checking that the given directory is still
+// empty, or ErrNotEmpty if it isn't.
+func (fs *Etcd) isEmpty(dir string) (*compare, error) {
+	prefix := fs.dirPrefix(dir)
+	end := prefixEnd(prefix)
+	kvs, err := fs.rangeKeys(prefix, end, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(kvs) != 0 {
+		return nil, ErrNotEmpty
+	}
+
+	return empty(prefix, end), nil
+}
+
+// Rename moves the given file, or directory with all its content, in a
+// single transaction. The time to live of the files is kept.
+func (fs *Etcd) Rename(from, to string) error {
+	if err := fs.rename(clean(from), clean(to)); err != nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Etcd) rename(from, to string) error {
+	if from == "/" || to == "/" {
+		return errors.New("cannot rename the root")
+	}
+
+	return atomically(func() (bool, error) {
+		from, src, err := fs.resolve(from, false)
+		if err != nil {
+			return false, err
+		}
+
+		to, dst, err := fs.resolve(to, false)
+		if err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+
+		if from == to {
+			return true, nil
+		}
+
+		if strings.HasPrefix(to, from+"/") {
+			return false, errors.New("cannot move a directory into itself")
+		}
+
+		fromKey, toKey := fs.key(from), fs.key(to)
+		cmps := []*compare{modified(fromKey, src.kv.ModRevision), created(toKey, 0)}
+		if dst != nil {
+			if dst.mode.IsDir() != src.mode.IsDir() {
+				return false, os.ErrExist
+			}
+
+			cmps[1] = modified(toKey, dst.kv.ModRevision)
+			if dst.mode.IsDir() {
+				cmp, err := fs.isEmpty(to)
+				if err != nil {
+					return false, err
+				}
+
+				cmps = append(cmps, cmp)
+			}
+		}
+
+		toDir := path.Dir(to)
+		if err := fs.mkdirAll(toDir, 0755); err != nil {
+			return false, err
+		}
+
+		if toDir != "/" {
+			cmps = append(cmps, exists(fs.key(toDir)))
+		}
+
+		ops := []*requestOp{del(fromKey), put(toKey, src.kv.Value, src.kv.Lease)}
+		if src.mode.IsDir() {
+			moveCmps, moves, err := fs.moves(from, to)
+			if err != nil {
+				return false, err
+			}
+
+			cmps = append(cmps, moveCmps...)
+			ops = append(ops, moves...)
+		}
+
+		res, err := fs.txn(cmps, ops)
+		if err != nil {
+			return false, err
+		}
+
+		return res.Succeeded, nil
+	})
+}
+
+// moves returns the operations moving all the content of the given
+// directory to the new path, keeping the leases, and the conditions to
+// apply them: none of the moved keys was modified or removed, and no key was
+// added, since they were read.
+func (fs *Etcd) moves(from, to string) ([]*compare, []*requestOp, error) {
+	ranges := fs.descendants(from)
+	ops := make([]*requestOp, len(ranges))
+	for i, r := range ranges {
+		ops[i] = get(r[0], r[1])
+	}
+
+	res, err := fs.txn(nil, ops)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(res.Responses) != len(ops) {
+		return nil, nil, errors.New("etcd: unexpected response")
+	}
+
+	var cmps []*compare
+	var moves []*requestOp
+	for i, r := range ranges {
+		cmps = append(cmps, unmodified(r[0], r[1], res.Header.Revision))
+		if res.Responses[i].ResponseRange == nil {
+			continue
+		}
+
+		for _, kv := range res.Responses[i].ResponseRange.Kvs {
+			p := to + strings.TrimPrefix(fs.path(kv.Key), from)
+			cmps = append(cmps, modified(kv.Key, kv.ModRevision))
+			moves = append(moves, del(kv.Key), put(fs.key(p), kv.Value, kv.Lease))
+		}
+	}
+
+	return cmps, moves, nil
+}
+
+func (fs *Etcd) Symlink(target, link string) error {
+	p, _, err := fs.resolve(clean(link), false)
+	switch {
+	case err == nil:
+		err = os.ErrExist
+	case os.IsNotExist(err):
+		n := &node{mode: os.ModeSymlink | 0777, ttl: fs.opts.TTL}
+		_, err = fs.create(p, n, []byte(target))
+	}
+
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Etcd) Readlink(link string) (string, error) {
+	_, e, err := fs.resolve(clean(link), false)
+	if err == nil && e.mode&os.ModeSymlink == 0 {
+		err = errors.New("not a symlink")
+	}
+
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: link, Err: err}
+	}
+
+	return string(e.content), nil
+}
+
+// SetTTL sets the time to live of the given file or symlink, after which
+// it's removed, it's started again every time the file is written. Zero
+// means the file never expires.
+func (fs *Etcd) SetTTL(filename string, ttl time.Duration) error {
+	err := atomically(func() (bool, error) {
+		p, e, err := fs.resolve(clean(filename), false)
+		if err != nil {
+			return false, err
+		}
+
+		if e.mode.IsDir() {
+			return false, errors.New("directories can't expire")
+		}
+
+		lease, err := fs.grant(ttl)
+		if err != nil {
+			return false, err
+		}
+
+		key := fs.key(p)
+		e.ttl = ttl
+		res, err := fs.txn(
+			[]*compare{modified(key, e.kv.ModRevision)},
+			[]*requestOp{put(key, e.encode(e.content), lease)},
+		)
+
+		if err != nil {
+			return false, err
+		}
+
+		return res.Succeeded, nil
+	})
+
+	if err != nil {
+		return &os.PathError{Op: "setttl", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+// TTL returns the remaining time to live of the given file or symlink,
+// rounded up to seconds, or zero if it never expires.
+func (fs *Etcd) TTL(filename string) (time.Duration, error) {
+	_, e, err := fs.resolve(clean(filename), false)
+	var ttl time.Duration
+	if err == nil && e.kv.Lease != 0 {
+		ttl, err = fs.timeToLive(e.kv.Lease)
+		if err == nil && ttl < 0 {
+			// expired in the meantime
+			err = os.ErrNotExist
+		}
+	}
+
+	if err != nil {
+		return 0, &os.PathError{Op: "ttl", Path: filename, Err: err}
+	}
+
+	return ttl, nil
+}
+
+func (fs *Etcd) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(fs, dir, prefix)
+}
+
+func (fs *Etcd) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (fs *Etcd) Chroot(p string) (billy.Filesystem, error) {
+	return chroot.New(fs, fs.Join(fs.Root(), p)), nil
+}
+
+func (fs *Etcd) Root() string {
+	return string(filepath.Separator)
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Etcd) Capabilities() billy.Capability {
+	return billy.WriteCapability | billy.ReadCapability |
+		billy.ReadAndWriteCapability | billy.SeekCapability |
+		billy.TruncateCapability
+}
+
+func clean(p string) string {
+	return path.Clean("/" + filepath.ToSlash(p))
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR) != 0
+}
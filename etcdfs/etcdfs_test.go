@@ -0,0 +1,240 @@
+package etcdfs
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&EtcdSuite{})
+
+type EtcdSuite struct {
+	test.FilesystemSuite
+	server *server
+	etcd   *Etcd
+}
+
+func (s *EtcdSuite) SetUpTest(c *C) {
+	s.server = newServer()
+	s.etcd = s.newFS(c, Options{})
+	s.FilesystemSuite = test.NewFilesystemSuite(s.etcd)
+
+	// every temporary file takes several transactions of the KV API, too
+	// many to run the default rounds with the race detector.
+	s.TempFileSuite.Rounds = 8
+}
+
+func (s *EtcdSuite) TearDownTest(c *C) {
+	s.server.Close()
+}
+
+func (s *EtcdSuite) newFS(c *C, opts Options) *Etcd {
+	fs, err := New(s.server.URL, opts)
+	c.Assert(err, IsNil)
+	return fs
+}
+
+func (s *EtcdSuite) TestNew(c *C) {
+	_, err := New("redis://localhost", Options{})
+	c.Assert(err, ErrorMatches, "unsupported scheme.*")
+
+	fs, err := New("http://localhost:2379/", Options{})
+	c.Assert(err, IsNil)
+	c.Assert(fs.endpoint, Equals, "http://localhost:2379")
+	c.Assert(fs.opts.Prefix, Equals, "billy")
+}
+
+func (s *EtcdSuite) TestKeys(c *C) {
+	c.Assert(util.WriteFile(s.etcd, "foo/bar", []byte("foo"), 0644), IsNil)
+
+	c.Assert(s.server.get([]byte("billy/\x00foo"), nil, 0), HasLen, 1)
+	c.Assert(s.server.get([]byte("billy/foo\x00bar"), nil, 0), HasLen, 1)
+	c.Assert(s.etcd.path([]byte("billy/foo\x00bar")), Equals, "/foo/bar")
+	c.Assert(s.etcd.path([]byte("billy/foo")), Equals, "")
+}
+
+func (s *EtcdSuite) TestAuth(c *C) {
+	s.server.username, s.server.password = "root", "secret"
+
+	_, err := s.newFS(c, Options{}).Stat("foo")
+	c.Assert(err, ErrorMatches, ".*user name is empty")
+
+	_, err = s.newFS(c, Options{Username: "root", Password: "wrong"}).Stat("foo")
+	c.Assert(err, ErrorMatches, ".*authentication failed.*")
+
+	fs := s.newFS(c, Options{Username: "root", Password: "secret"})
+	c.Assert(util.WriteFile(fs, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(s.server.count("auth/authenticate"), Equals, 2)
+
+	s.server.expireTokens()
+	c.Assert(test.ReadFile(c, fs, "foo"), Equals, "foo")
+	c.Assert(s.server.count("auth/authenticate"), Equals, 3)
+}
+
+func (s *EtcdSuite) TestShared(c *C) {
+	other := s.newFS(c, Options{})
+
+	c.Assert(util.WriteFile(s.etcd, "foo/bar", []byte("foo"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, other, "foo/bar"), Equals, "foo")
+
+	c.Assert(other.Rename("foo", "qux"), IsNil)
+	c.Assert(test.ReadFile(c, s.etcd, "qux/bar"), Equals, "foo")
+}
+
+func (s *EtcdSuite) TestPrefix(c *C) {
+	other := s.newFS(c, Options{Prefix: "other"})
+
+	c.Assert(util.WriteFile(s.etcd, "foo", []byte("foo"), 0644), IsNil)
+
+	_, err := other.Stat("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *EtcdSuite) TestTTL(c *C) {
+	fs := s.newFS(c, Options{TTL: time.Minute})
+
+	c.Assert(util.WriteFile(fs, "foo/bar", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(fs, "foo/qux", []byte("foo"), 0644), IsNil)
+	c.Assert(fs.SetTTL("foo/qux", 0), IsNil)
+
+	ttl, err := fs.TTL("foo/bar")
+	c.Assert(err, IsNil)
+	c.Assert(ttl, Equals, time.Minute)
+
+	s.server.advance(30 * time.Second)
+	c.Assert(util.WriteFile(fs, "foo/bar", []byte("bar"), 0644), IsNil)
+
+	ttl, err = fs.TTL("foo/bar")
+	c.Assert(err, IsNil)
+	c.Assert(ttl, Equals, time.Minute)
+
+	s.server.advance(2 * time.Minute)
+
+	_, err = fs.Stat("foo/bar")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	infos, err := fs.ReadDir("foo")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 1)
+	c.Assert(infos[0].Name(), Equals, "qux")
+
+	ttl, err = fs.TTL("foo/qux")
+	c.Assert(err, IsNil)
+	c.Assert(ttl, Equals, time.Duration(0))
+
+	c.Assert(fs.SetTTL("foo", time.Minute), NotNil)
+}
+
+func (s *EtcdSuite) TestTTLKeptOnRename(c *C) {
+	c.Assert(util.WriteFile(s.etcd, "foo/bar", []byte("foo"), 0644), IsNil)
+	c.Assert(s.etcd.SetTTL("foo/bar", time.Minute), IsNil)
+	c.Assert(s.etcd.Rename("foo", "qux"), IsNil)
+
+	ttl, err := s.etcd.TTL("qux/bar")
+	c.Assert(err, IsNil)
+	c.Assert(ttl, Equals, time.Minute)
+}
+
+func (s *EtcdSuite) TestRemoveNotEmpty(c *C) {
+	c.Assert(util.WriteFile(s.etcd, "foo/bar", []byte("foo"), 0644), IsNil)
+
+	err := s.etcd.Remove("foo")
+	c.Assert(err.(*os.PathError).Err, Equals, ErrNotEmpty)
+}
+
+func (s *EtcdSuite) TestReplacedWhileOpen(c *C) {
+	f, err := s.etcd.Create("foo")
+	c.Assert(err, IsNil)
+
+	c.Assert(s.etcd.Remove("foo"), IsNil)
+	c.Assert(util.WriteFile(s.etcd, "foo", []byte("bar"), 0644), IsNil)
+
+	_, err = f.Write([]byte("foo"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	c.Assert(test.ReadFile(c, s.etcd, "foo"), Equals, "bar")
+}
+
+func (s *EtcdSuite) TestRenameRetried(c *C) {
+	c.Assert(util.WriteFile(s.etcd, "foo/bar", []byte("foo"), 0644), IsNil)
+
+	value := (&node{mode: 0644}).encode([]byte("qux"))
+	s.server.beforeTxn = func() {
+		s.server.putKey([]byte("billy/foo\x00qux"), value, 0)
+	}
+
+	c.Assert(s.etcd.Rename("foo", "baz"), IsNil)
+	c.Assert(test.ReadFile(c, s.etcd, "baz/bar"), Equals, "foo")
+	c.Assert(test.ReadFile(c, s.etcd, "baz/qux"), Equals, "qux")
+
+	_, err := s.etcd.Stat("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *EtcdSuite) TestConflict(c *C) {
+	c.Assert(util.WriteFile(s.etcd, "foo", []byte("foo"), 0644), IsNil)
+
+	var changes int
+	var change func()
+	change = func() {
+		if changes++; changes < maxRetries {
+			s.server.beforeTxn = change
+		}
+
+		s.server.putKey([]byte("billy/\x00foo"), (&node{mode: 0644}).encode(nil), 0)
+	}
+
+	s.server.beforeTxn = change
+	err := s.etcd.Remove("foo")
+	c.Assert(err, ErrorMatches, ".*too many concurrent modifications")
+	c.Assert(changes, Equals, maxRetries)
+}
+
+func (s *EtcdSuite) TestWatch(c *C) {
+	c.Assert(s.etcd.MkdirAll("foo", 0755), IsNil)
+
+	w, err := s.etcd.Watch("foo", false)
+	c.Assert(err, IsNil)
+	defer w.Close()
+
+	all, err := s.etcd.Watch("foo", true)
+	c.Assert(err, IsNil)
+	defer all.Close()
+
+	fs := s.newFS(c, Options{TTL: time.Second})
+	c.Assert(util.WriteFile(fs, "foobar/qux", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(fs, "foo/qux/bar", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(fs, "foo/bar", []byte("foo"), 0600), IsNil)
+	c.Assert(s.etcd.Remove("foo/bar"), IsNil)
+	s.server.advance(time.Minute)
+
+	c.Assert(<-w.Events, Equals, Event{Op: Write, Path: "foo/qux", Mode: os.ModeDir | 0755})
+	c.Assert(<-w.Events, Equals, Event{Op: Write, Path: "foo/bar", Mode: 0600})
+	c.Assert(<-w.Events, Equals, Event{Op: Write, Path: "foo/bar", Mode: 0600})
+	c.Assert(<-w.Events, Equals, Event{Op: Remove, Path: "foo/bar", Mode: 0600})
+
+	c.Assert(<-all.Events, Equals, Event{Op: Write, Path: "foo/qux", Mode: os.ModeDir | 0755})
+	c.Assert(<-all.Events, Equals, Event{Op: Write, Path: "foo/qux/bar", Mode: 0644})
+	c.Assert(<-all.Events, Equals, Event{Op: Write, Path: "foo/qux/bar", Mode: 0644})
+	c.Assert(<-all.Events, Equals, Event{Op: Write, Path: "foo/bar", Mode: 0600})
+	c.Assert(<-all.Events, Equals, Event{Op: Write, Path: "foo/bar", Mode: 0600})
+	c.Assert(<-all.Events, Equals, Event{Op: Remove, Path: "foo/bar", Mode: 0600})
+	c.Assert(<-all.Events, Equals, Event{Op: Remove, Path: "foo/qux/bar", Mode: 0644})
+
+	c.Assert(w.Close(), IsNil)
+	_, ok := <-w.Events
+	c.Assert(ok, Equals, false)
+	c.Assert(w.Err(), IsNil)
+
+	c.Assert(util.WriteFile(s.etcd, "qux", nil, 0644), IsNil)
+	_, err = s.etcd.Watch("qux", false)
+	c.Assert(err, ErrorMatches, ".*not a directory")
+}
@@ -0,0 +1,162 @@
+package etcdfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4/internal/memfile"
+)
+
+// headerSize is the size of the header of the values, holding the metadata
+// of the node before its content.
+const headerSize = 20
+
+// node is the metadata of a file, directory or symlink.
+type node struct {
+	mode    os.FileMode
+	modTime time.Time
+	// ttl is the time to live of the file, set again on every write.
+	ttl time.Duration
+}
+
+// encode returns the value of the key of the node, with the given content.
+func (n *node) encode(content []byte) []byte {
+	b := make([]byte, headerSize+len(content))
+	binary.BigEndian.PutUint32(b, uint32(n.mode))
+	binary.BigEndian.PutUint64(b[4:], uint64(n.modTime.UnixNano()))
+	binary.BigEndian.PutUint64(b[12:], uint64(n.ttl/time.Millisecond))
+	copy(b[headerSize:], content)
+	return b
+}
+
+// entry is a node read from etcd, with its key and content: the content of
+// a file or the target of a symlink.
+type entry struct {
+	*node
+	kv      *keyValue
+	content []byte
+}
+
+var rootEntry = &entry{node: &node{mode: os.ModeDir | 0755}, kv: &keyValue{}}
+
+func decode(kv *keyValue) (*entry, error) {
+	if len(kv.Value) < headerSize {
+		return nil, fmt.Errorf("etcd: invalid value of key %q", kv.Key)
+	}
+
+	return &entry{
+		node: &node{
+			mode:    os.FileMode(binary.BigEndian.Uint32(kv.Value)),
+			modTime: time.Unix(0, int64(binary.BigEndian.Uint64(kv.Value[4:]))),
+			ttl:     time.Duration(binary.BigEndian.Uint64(kv.Value[12:])) * time.Millisecond,
+		},
+		kv:      kv,
+		content: kv.Value[headerSize:],
+	}, nil
+}
+
+// file is a file of an etcd filesystem. Its content is read when opened, and
+// kept in memory until closed, when it's written if modified.
+type file struct {
+	*memfile.File
+
+	fs   *Etcd
+	path string
+	node *node
+	// rev is the revision of the creation of the file, it's written only
+	// if it's still the same file when closed.
+	rev int64
+}
+
+func newFile(fs *Etcd, name, p string, flag int, e *entry) *file {
+	f := &file{
+		File: memfile.New(name, flag, nil),
+		fs:   fs,
+		path: p,
+		node: e.node,
+		rev:  e.kv.CreateRevision,
+	}
+
+	f.Content = e.content
+	return f
+}
+
+// Close writes the content of the file if it was modified, unless the file
+// was removed or replaced in the meantime.
+func (f *file) Close() error {
+	if f.Closed {
+		return os.ErrClosed
+	}
+
+	f.Closed = true
+
+	var err error
+	if f.Dirty {
+		err = f.fs.store(f.path, f.rev, f.Content)
+	}
+
+	f.Content = nil
+	return err
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	return &fileInfo{
+		name:    filepath.Base(f.Name()),
+		size:    int64(len(f.Content)),
+		mode:    f.node.mode,
+		modTime: f.node.modTime,
+	}, nil
+}
+
+// Lock is a no-op in etcdfs.
+func (f *file) Lock() error {
+	return nil
+}
+
+// Unlock is a no-op in etcdfs.
+func (f *file) Unlock() error {
+	return nil
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func newFileInfo(name string, e *entry) *fileInfo {
+	fi := &fileInfo{name: name, mode: e.mode, modTime: e.modTime}
+	if !e.mode.IsDir() {
+		fi.size = int64(len(e.content))
+	}
+
+	return fi
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.size
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	return fi.mode
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.mode.IsDir()
+}
+
+func (*fileInfo) Sys() interface{} {
+	return nil
+}
@@ -0,0 +1,475 @@
+package etcdfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"time"
+)
+
+// server is a minimal etcd gateway with an in-memory store, to test the
+// client. It supports the requests used by the client only, with the same
+// revisions, transactions, leases and watch streams of etcd.
+type server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	now      time.Time
+	rev      int64
+	kvs      map[string]*keyValue
+	leases   map[int64]time.Time
+	lastID   int64
+	watchers map[*watcher]bool
+	requests map[string]int
+	closed   chan struct{}
+
+	// username and password enable the authentication, if not empty.
+	username, password string
+	tokens             map[string]bool
+	// beforeTxn is called before the next conditional transaction, to
+	// modify the keys in the meantime.
+	beforeTxn func()
+}
+
+type watcher struct {
+	key, end []byte
+	messages chan []byte
+}
+
+func newServer() *server {
+	s := &server{
+		now:      time.Now(),
+		kvs:      make(map[string]*keyValue),
+		leases:   make(map[int64]time.Time),
+		watchers: make(map[*watcher]bool),
+		requests: make(map[string]int),
+		tokens:   make(map[string]bool),
+		closed:   make(chan struct{}),
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+func (s *server) Close() {
+	close(s.closed)
+	s.Server.Close()
+}
+
+// advance moves forward the clock of the server, expiring the leases.
+func (s *server) advance(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.now = s.now.Add(d)
+	s.expire()
+}
+
+// count returns the number of requests to the given endpoint, eg.: "kv/txn".
+func (s *server) count(endpoint string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests[endpoint]
+}
+
+// expireTokens invalidates the tokens, as if they expired.
+func (s *server) expireTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens = make(map[string]bool)
+}
+
+// put stores the given key, as if it was written by other client.
+func (s *server) put(key string, value []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rev++
+	s.putKey([]byte(key), value, 0)
+}
+
+func (s *server) handle(w http.ResponseWriter, r *http.Request) {
+	endpoint := r.URL.Path[len("/v3/"):]
+
+	s.mu.Lock()
+	s.requests[endpoint]++
+	if s.username != "" && endpoint != "auth/authenticate" {
+		token := r.Header.Get("Authorization")
+		switch {
+		case token == "":
+			s.mu.Unlock()
+			writeError(w, http.StatusBadRequest, 3, "etcdserver: user name is empty")
+			return
+		case !s.tokens[token]:
+			s.mu.Unlock()
+			writeError(w, http.StatusUnauthorized, 16, "etcdserver: invalid auth token")
+			return
+		}
+	}
+
+	if endpoint == "watch" {
+		s.mu.Unlock()
+		s.watch(w, r)
+		return
+	}
+
+	defer s.mu.Unlock()
+	s.expire()
+
+	var handler func(*json.Decoder) (interface{}, error)
+	switch endpoint {
+	case "auth/authenticate":
+		handler = s.authenticate
+	case "kv/range":
+		handler = s.rangeKeys
+	case "kv/txn":
+		handler = s.txn
+	case "lease/grant":
+		handler = s.grant
+	case "lease/timetolive":
+		handler = s.timeToLive
+	default:
+		writeError(w, http.StatusNotFound, 5, "Not Found")
+		return
+	}
+
+	res, err := handler(json.NewDecoder(r.Body))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, 3, err.Error())
+		return
+	}
+
+	writeJSON(w, res)
+}
+
+func (s *server) header() responseHeader {
+	return responseHeader{Revision: s.rev}
+}
+
+func (s *server) authenticate(dec *json.Decoder) (interface{}, error) {
+	var req struct {
+		Name     string `json:"name"`
+		Password string `json:"password"`
+	}
+
+	if err := dec.Decode(&req); err != nil {
+		return nil, err
+	}
+
+	if req.Name != s.username || req.Password != s.password {
+		return nil, fmt.Errorf("etcdserver: authentication failed, invalid user ID or password")
+	}
+
+	token := fmt.Sprintf("token.%d", len(s.tokens)+s.requests["auth/authenticate"])
+	s.tokens[token] = true
+	return map[string]interface{}{"header": s.header(), "token": token}, nil
+}
+
+func (s *server) rangeKeys(dec *json.Decoder) (interface{}, error) {
+	req := &rangeRequest{}
+	if err := dec.Decode(req); err != nil {
+		return nil, err
+	}
+
+	return &rangeResponse{Header: s.header(), Kvs: s.get(req.Key, req.RangeEnd, req.Limit)}, nil
+}
+
+// get returns the keys of the given range, as etcd: a single key if end is
+// empty, or all the keys from key if end is "\x00".
+func (s *server) get(key, end []byte, limit int64) []*keyValue {
+	var kvs []*keyValue
+	for _, kv := range s.kvs {
+		if inRange(kv.Key, key, end) {
+			kvs = append(kvs, kv)
+		}
+	}
+
+	sort.Slice(kvs, func(i, j int) bool {
+		return bytes.Compare(kvs[i].Key, kvs[j].Key) < 0
+	})
+
+	if limit > 0 && int64(len(kvs)) > limit {
+		kvs = kvs[:limit]
+	}
+
+	return kvs
+}
+
+func inRange(k, key, end []byte) bool {
+	switch {
+	case len(end) == 0:
+		return bytes.Equal(k, key)
+	case bytes.Equal(end, []byte{0}):
+		return bytes.Compare(k, key) >= 0
+	default:
+		return bytes.Compare(k, key) >= 0 && bytes.Compare(k, end) < 0
+	}
+}
+
+func (s *server) txn(dec *json.Decoder) (interface{}, error) {
+	req := &txnRequest{}
+	if err := dec.Decode(req); err != nil {
+		return nil, err
+	}
+
+	if len(req.Compare) != 0 && s.beforeTxn != nil {
+		fn := s.beforeTxn
+		s.beforeTxn = nil
+		s.rev++
+		fn()
+	}
+
+	res := map[string]interface{}{}
+	for _, c := range req.Compare {
+		if !s.compare(c) {
+			res["header"] = s.header()
+			return res, nil
+		}
+	}
+
+	for _, op := range req.Success {
+		if op.RequestPut == nil && op.RequestDeleteRange == nil {
+			continue
+		}
+
+		if lease := op.RequestPut; lease != nil && lease.Lease != 0 {
+			if _, ok := s.leases[lease.Lease]; !ok {
+				return nil, fmt.Errorf("etcdserver: requested lease not found")
+			}
+		}
+	}
+
+	written := false
+	var responses []interface{}
+	for _, op := range req.Success {
+		switch {
+		case op.RequestRange != nil:
+			r := op.RequestRange
+			responses = append(responses, map[string]interface{}{
+				"response_range": &rangeResponse{Header: s.header(), Kvs: s.get(r.Key, r.RangeEnd, r.Limit)},
+			})
+
+			continue
+		case !written:
+			// all the writes of a transaction have the same revision
+			s.rev++
+			written = true
+		}
+
+		if p := op.RequestPut; p != nil {
+			s.putKey(p.Key, p.Value, p.Lease)
+			responses = append(responses, map[string]interface{}{"response_put": struct{}{}})
+			continue
+		}
+
+		s.deleteKey(op.RequestDeleteRange.Key)
+		responses = append(responses, map[string]interface{}{"response_delete_range": struct{}{}})
+	}
+
+	res["header"] = s.header()
+	res["succeeded"] = true
+	res["responses"] = responses
+	return res, nil
+}
+
+// compare evaluates the given condition, as etcd: it must be true for all
+// the keys of the range, and the revisions are zero if there are none.
+func (s *server) compare(c *compare) bool {
+	kvs := s.get(c.Key, c.RangeEnd, 0)
+	if len(kvs) == 0 {
+		kvs = []*keyValue{{}}
+	}
+
+	for _, kv := range kvs {
+		var v, target int64
+		switch c.Target {
+		case "CREATE":
+			v, target = kv.CreateRevision, *c.CreateRevision
+		case "MOD":
+			v, target = kv.ModRevision, *c.ModRevision
+		default:
+			return false
+		}
+
+		var ok bool
+		switch c.Result {
+		case "EQUAL":
+			ok = v == target
+		case "GREATER":
+			ok = v > target
+		case "LESS":
+			ok = v < target
+		}
+
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// putKey writes the given key, at the current revision.
+func (s *server) putKey(key, value []byte, lease int64) {
+	prev := s.kvs[string(key)]
+	kv := &keyValue{Key: key, Value: value, CreateRevision: s.rev, ModRevision: s.rev, Lease: lease}
+	if prev != nil {
+		kv.CreateRevision = prev.CreateRevision
+	}
+
+	s.kvs[string(key)] = kv
+	s.notify(&watchEvent{Kv: kv, PrevKv: prev})
+}
+
+func (s *server) deleteKey(key []byte) {
+	prev, ok := s.kvs[string(key)]
+	if !ok {
+		return
+	}
+
+	delete(s.kvs, string(key))
+	s.notify(&watchEvent{Type: "DELETE", Kv: &keyValue{Key: key, ModRevision: s.rev}, PrevKv: prev})
+}
+
+func (s *server) grant(dec *json.Decoder) (interface{}, error) {
+	var req struct {
+		TTL int64 `json:"TTL,string"`
+	}
+
+	if err := dec.Decode(&req); err != nil {
+		return nil, err
+	}
+
+	s.lastID++
+	s.leases[s.lastID] = s.now.Add(time.Duration(req.TTL) * time.Second)
+	return map[string]interface{}{
+		"header": s.header(),
+		"ID":     fmt.Sprint(s.lastID),
+		"TTL":    fmt.Sprint(req.TTL),
+	}, nil
+}
+
+func (s *server) timeToLive(dec *json.Decoder) (interface{}, error) {
+	var req struct {
+		ID int64 `json:"ID,string"`
+	}
+
+	if err := dec.Decode(&req); err != nil {
+		return nil, err
+	}
+
+	ttl := int64(-1)
+	if expires, ok := s.leases[req.ID]; ok {
+		ttl = int64(math.Ceil(expires.Sub(s.now).Seconds()))
+	}
+
+	return map[string]interface{}{"header": s.header(), "ID": fmt.Sprint(req.ID), "TTL": fmt.Sprint(ttl)}, nil
+}
+
+// expire removes the expired leases, and their keys.
+func (s *server) expire() {
+	for id, expires := range s.leases {
+		if expires.After(s.now) {
+			continue
+		}
+
+		delete(s.leases, id)
+		s.rev++
+		for _, kv := range s.get([]byte{0}, []byte{0}, 0) {
+			if kv.Lease == id {
+				s.deleteKey(kv.Key)
+			}
+		}
+	}
+}
+
+// notify sends the given event to the watchers of its key.
+func (s *server) notify(ev *watchEvent) {
+	for w := range s.watchers {
+		if !inRange(ev.Kv.Key, w.key, w.end) {
+			continue
+		}
+
+		msg, _ := json.Marshal(map[string]interface{}{"result": map[string]interface{}{
+			"header": s.header(),
+			"events": []*watchEvent{ev},
+		}})
+
+		w.messages <- msg
+	}
+}
+
+func (s *server) watch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		CreateRequest struct {
+			Key      []byte `json:"key"`
+			RangeEnd []byte `json:"range_end"`
+		} `json:"create_request"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, 3, err.Error())
+		return
+	}
+
+	wt := &watcher{
+		key:      req.CreateRequest.Key,
+		end:      req.CreateRequest.RangeEnd,
+		messages: make(chan []byte, 1024),
+	}
+
+	s.mu.Lock()
+	s.watchers[wt] = true
+	header := s.header()
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.watchers, wt)
+		s.mu.Unlock()
+	}()
+
+	created, _ := json.Marshal(map[string]interface{}{"result": map[string]interface{}{
+		"header":  header,
+		"created": true,
+	}})
+
+	flusher := w.(http.Flusher)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(created, '\n'))
+	flusher.Flush()
+
+	for {
+		select {
+		case msg := <-wt.messages:
+			w.Write(append(msg, '\n'))
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":   message,
+		"code":    code,
+		"message": message,
+	})
+}
@@ -0,0 +1,228 @@
+package etcdfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gopkg.in/src-d/go-billy.v4/internal/pathutil"
+)
+
+// Op is the kind of change of an Event.
+type Op int
+
+const (
+	// Write is a file, directory or symlink created or modified.
+	Write Op = iota + 1
+	// Remove is a file, directory or symlink removed, or expired.
+	Remove
+)
+
+func (op Op) String() string {
+	switch op {
+	case Write:
+		return "write"
+	case Remove:
+		return "remove"
+	default:
+		return fmt.Sprintf("op(%d)", int(op))
+	}
+}
+
+// Event is a change of the filesystem, made by any of its clients. A rename
+// is received as the removal and the creation of every node moved.
+type Event struct {
+	Op Op
+	// Path is the path of the file, directory or symlink changed.
+	Path string
+	// Mode is the mode of the node after the change, or before it if it
+	// was removed.
+	Mode os.FileMode
+}
+
+type watchEvent struct {
+	// Type is empty for the puts, since it's the default value.
+	Type   string    `json:"type"`
+	Kv     *keyValue `json:"kv"`
+	PrevKv *keyValue `json:"prev_kv"`
+}
+
+type watchMessage struct {
+	Result *struct {
+		Created      bool          `json:"created"`
+		Canceled     bool          `json:"canceled"`
+		CancelReason string        `json:"cancel_reason"`
+		Events       []*watchEvent `json:"events"`
+	} `json:"result"`
+	Error *apiError `json:"error"`
+}
+
+// Watcher receives the changes of a directory.
+type Watcher struct {
+	// Events receives the changes, in the order they were made. It's closed
+	// when the watcher is closed or fails.
+	Events <-chan Event
+
+	fs        *Etcd
+	dir       string
+	recursive bool
+	cancel    context.CancelFunc
+	done      chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+// Watch returns a watcher of the changes of the children of the given
+// directory, or of all its descendants if recursive. The changes made after
+// Watch returns are received, including the expiration of the files.
+func (fs *Etcd) Watch(dir string, recursive bool) (*Watcher, error) {
+	p, e, err := fs.resolve(clean(dir), true)
+	if err == nil && !e.mode.IsDir() {
+		err = errNotDir
+	}
+
+	if err != nil {
+		return nil, &os.PathError{Op: "watch", Path: dir, Err: err}
+	}
+
+	key := fs.dirPrefix(p)
+	if recursive {
+		// the keys of the siblings with the same prefix are skipped
+		key = []byte(fs.opts.Prefix + p)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	res, err := fs.request(ctx, "watch", map[string]interface{}{
+		"create_request": map[string]interface{}{
+			"key":       key,
+			"range_end": prefixEnd(key),
+			"prev_kv":   true,
+		},
+	})
+
+	if err != nil {
+		cancel()
+		return nil, &os.PathError{Op: "watch", Path: dir, Err: err}
+	}
+
+	dec := json.NewDecoder(res.Body)
+	if _, err := next(dec); err != nil {
+		cancel()
+		closeBody(res)
+		return nil, &os.PathError{Op: "watch", Path: dir, Err: err}
+	}
+
+	events := make(chan Event)
+	w := &Watcher{
+		Events:    events,
+		fs:        fs,
+		dir:       p,
+		recursive: recursive,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+
+	go w.run(ctx, res, dec, events)
+	return w, nil
+}
+
+// next returns the events of the next message of a watch stream.
+func next(dec *json.Decoder) ([]*watchEvent, error) {
+	var m watchMessage
+	if err := dec.Decode(&m); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case m.Error != nil:
+		return nil, fmt.Errorf("etcd: %s", m.Error.Message)
+	case m.Result == nil:
+		return nil, errors.New("etcd: unexpected response")
+	case m.Result.Canceled:
+		return nil, fmt.Errorf("etcd: watch canceled: %s", m.Result.CancelReason)
+	}
+
+	return m.Result.Events, nil
+}
+
+func (w *Watcher) run(ctx context.Context, res *http.Response, dec *json.Decoder, events chan<- Event) {
+	defer close(w.done)
+	defer close(events)
+	defer res.Body.Close()
+
+	for {
+		evs, err := next(dec)
+		if err != nil {
+			if ctx.Err() == nil {
+				w.mu.Lock()
+				w.err = err
+				w.mu.Unlock()
+			}
+
+			return
+		}
+
+		for _, ev := range evs {
+			e, ok := w.event(ev)
+			if !ok {
+				continue
+			}
+
+			select {
+			case events <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// event returns the Event of the given event of etcd, or false if it's not
+// a change of the directory watched.
+func (w *Watcher) event(ev *watchEvent) (Event, bool) {
+	if ev.Kv == nil {
+		return Event{}, false
+	}
+
+	p := w.fs.path(ev.Kv.Key)
+	if p == "" || w.recursive && w.dir != "/" && !strings.HasPrefix(p, w.dir+"/") {
+		return Event{}, false
+	}
+
+	e := Event{Op: Write, Path: filepath.FromSlash(pathutil.Relative(p))}
+	kv := ev.Kv
+	if ev.Type == "DELETE" {
+		e.Op, kv = Remove, ev.PrevKv
+	}
+
+	if kv != nil {
+		if n, err := decode(kv); err == nil {
+			e.Mode = n.mode
+		}
+	}
+
+	return e, true
+}
+
+// Close stops the watcher, closing its Events.
+func (w *Watcher) Close() error {
+	w.cancel()
+	<-w.done
+	return nil
+}
+
+// Err returns the error that closed the Events, or nil if the watcher was
+// closed.
+func (w *Watcher) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.err
+}
@@ -0,0 +1,51 @@
+// Command staticsite serves a static site from a tar archive, as an example
+// of a stack of billy filesystems served over HTTP:
+//
+//   - tarfs reads the files of the site from the archive, read-only.
+//   - cache keeps in memory the files most recently read from it.
+//   - preview overlays an in-memory filesystem over it, where the drafts
+//     uploaded with PUT and the files removed with DELETE are kept, without
+//     modifying the archive. GET /_changes renders them as a patch.
+//   - httpfs.Dir adapts the result to a http.FileSystem, served by a
+//     http.FileServer.
+//
+// Usage:
+//
+//	staticsite [-addr :8080] site.tar
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-addr :8080] site.tar\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer f.Close()
+	site, err := newSite(f)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("serving %s on %s", flag.Arg(0), *addr)
+	log.Fatal(http.ListenAndServe(*addr, site))
+}
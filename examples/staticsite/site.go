@@ -0,0 +1,136 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+
+	"gopkg.in/src-d/go-billy.v4/helper/cache"
+	"gopkg.in/src-d/go-billy.v4/helper/preview"
+	"gopkg.in/src-d/go-billy.v4/httpfs"
+	"gopkg.in/src-d/go-billy.v4/tarfs"
+)
+
+const (
+	changesPath = "/_changes"
+	// cacheSize is the maximum size of the files of the archive kept in
+	// memory.
+	cacheSize = 32 << 20
+	// maxDraftSize is the maximum size of the files uploaded with PUT.
+	maxDraftSize = 10 << 20
+)
+
+// site serves the files of a tar archive, with the drafts kept in memory.
+type site struct {
+	// mu guards drafts, the preview isn't safe for concurrent use.
+	mu     sync.RWMutex
+	drafts *preview.Preview
+	files  http.Handler
+}
+
+func newSite(archive io.ReaderAt) (*site, error) {
+	base, err := tarfs.New(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	drafts := preview.New(cache.New(base, cache.Options{Size: cacheSize}))
+	return &site{
+		drafts: drafts,
+		files:  http.FileServer(httpfs.Dir(drafts)),
+	}, nil
+}
+
+func (s *site) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := path.Clean("/" + r.URL.Path)
+	switch {
+	case p == changesPath && r.Method == http.MethodGet:
+		s.changes(w)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		s.files.ServeHTTP(w, r)
+	case r.Method == http.MethodPut:
+		s.put(w, r, p)
+	case r.Method == http.MethodDelete:
+		s.delete(w, p)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// changes renders the drafts as a patch of the archive.
+func (s *site) changes(w http.ResponseWriter) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+	if err := s.drafts.WritePatch(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (s *site) put(w http.ResponseWriter, r *http.Request, p string) {
+	content, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxDraftSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := http.StatusNoContent
+	if _, err := s.drafts.Stat(p); os.IsNotExist(err) {
+		status = http.StatusCreated
+	}
+
+	f, err := s.drafts.Create(p)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		httpError(w, err)
+		return
+	}
+
+	if err := f.Close(); err != nil {
+		httpError(w, err)
+		return
+	}
+
+	w.WriteHeader(status)
+}
+
+func (s *site) delete(w http.ResponseWriter, p string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.drafts.Remove(p); err != nil {
+		httpError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func httpError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case os.IsNotExist(err):
+		status = http.StatusNotFound
+	case os.IsExist(err):
+		status = http.StatusConflict
+	case os.IsPermission(err):
+		status = http.StatusForbidden
+	}
+
+	http.Error(w, err.Error(), status)
+}
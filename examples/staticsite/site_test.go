@@ -0,0 +1,136 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&SiteSuite{})
+
+type SiteSuite struct {
+	server *httptest.Server
+}
+
+func (s *SiteSuite) SetUpTest(c *C) {
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	for name, content := range map[string]string{
+		"index.html":     "<h1>Home</h1>\n",
+		"css/site.css":   "body { color: black; }\n",
+		"blog/first.txt": "first post\n",
+	} {
+		c.Assert(tw.WriteHeader(&tar.Header{
+			Name: name,
+			Mode: 0644,
+			Size: int64(len(content)),
+		}), IsNil)
+
+		_, err := tw.Write([]byte(content))
+		c.Assert(err, IsNil)
+	}
+
+	c.Assert(tw.Close(), IsNil)
+
+	site, err := newSite(bytes.NewReader(buf.Bytes()))
+	c.Assert(err, IsNil)
+	s.server = httptest.NewServer(site)
+}
+
+func (s *SiteSuite) TearDownTest(c *C) {
+	s.server.Close()
+}
+
+func (s *SiteSuite) do(c *C, method, path, body string, header http.Header) (*http.Response, string) {
+	req, err := http.NewRequest(method, s.server.URL+path, strings.NewReader(body))
+	c.Assert(err, IsNil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	defer res.Body.Close()
+
+	content, err := ioutil.ReadAll(res.Body)
+	c.Assert(err, IsNil)
+	return res, string(content)
+}
+
+func (s *SiteSuite) TestIndex(c *C) {
+	res, body := s.do(c, "GET", "/", "", nil)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	c.Assert(body, Equals, "<h1>Home</h1>\n")
+	c.Assert(res.Header.Get("Content-Type"), Equals, "text/html; charset=utf-8")
+}
+
+func (s *SiteSuite) TestFile(c *C) {
+	res, body := s.do(c, "GET", "/css/site.css", "", nil)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	c.Assert(body, Equals, "body { color: black; }\n")
+	c.Assert(res.Header.Get("Content-Type"), Equals, "text/css; charset=utf-8")
+
+	res, _ = s.do(c, "GET", "/missing.html", "", nil)
+	c.Assert(res.StatusCode, Equals, http.StatusNotFound)
+}
+
+func (s *SiteSuite) TestRange(c *C) {
+	res, body := s.do(c, "GET", "/blog/first.txt", "", http.Header{"Range": {"bytes=6-"}})
+	c.Assert(res.StatusCode, Equals, http.StatusPartialContent)
+	c.Assert(body, Equals, "post\n")
+}
+
+func (s *SiteSuite) TestDirListing(c *C) {
+	res, body := s.do(c, "GET", "/blog/", "", nil)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	c.Assert(strings.Contains(body, `<a href="first.txt">first.txt</a>`), Equals, true)
+}
+
+func (s *SiteSuite) TestDrafts(c *C) {
+	res, _ := s.do(c, "PUT", "/blog/second.txt", "second post\n", nil)
+	c.Assert(res.StatusCode, Equals, http.StatusCreated)
+
+	res, _ = s.do(c, "PUT", "/index.html", "<h1>New home</h1>\n", nil)
+	c.Assert(res.StatusCode, Equals, http.StatusNoContent)
+
+	res, _ = s.do(c, "DELETE", "/css/site.css", "", nil)
+	c.Assert(res.StatusCode, Equals, http.StatusNoContent)
+
+	_, body := s.do(c, "GET", "/blog/second.txt", "", nil)
+	c.Assert(body, Equals, "second post\n")
+
+	_, body = s.do(c, "GET", "/", "", nil)
+	c.Assert(body, Equals, "<h1>New home</h1>\n")
+
+	res, _ = s.do(c, "GET", "/css/site.css", "", nil)
+	c.Assert(res.StatusCode, Equals, http.StatusNotFound)
+
+	_, body = s.do(c, "GET", "/blog/", "", nil)
+	c.Assert(strings.Contains(body, `<a href="second.txt">second.txt</a>`), Equals, true)
+
+	res, body = s.do(c, "GET", changesPath, "", nil)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	c.Assert(strings.Contains(body, "+second post"), Equals, true)
+	c.Assert(strings.Contains(body, "-<h1>Home</h1>"), Equals, true)
+	c.Assert(strings.Contains(body, "+<h1>New home</h1>"), Equals, true)
+	c.Assert(strings.Contains(body, "-body { color: black; }"), Equals, true)
+}
+
+func (s *SiteSuite) TestDeleteMissing(c *C) {
+	res, _ := s.do(c, "DELETE", "/missing.html", "", nil)
+	c.Assert(res.StatusCode, Equals, http.StatusNotFound)
+}
+
+func (s *SiteSuite) TestMethodNotAllowed(c *C) {
+	res, _ := s.do(c, "POST", "/index.html", "", nil)
+	c.Assert(res.StatusCode, Equals, http.StatusMethodNotAllowed)
+	c.Assert(res.Header.Get("Allow"), Equals, "GET, HEAD, PUT, DELETE")
+}
@@ -11,6 +11,7 @@ var (
 	ErrReadOnly        = errors.New("read-only filesystem")
 	ErrNotSupported    = errors.New("feature not supported")
 	ErrCrossedBoundary = errors.New("chroot boundary crossed")
+	ErrNotEmpty        = errors.New("directory not empty")
 )
 
 // Capability holds the supported features of a billy filesystem. This does
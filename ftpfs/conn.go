@@ -0,0 +1,388 @@
+package ftpfs
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/textproto"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error is a negative reply of the FTP server.
+type Error struct {
+	Code int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s", e.Code, e.Msg)
+}
+
+// conn is a control connection to a FTP server.
+type conn struct {
+	host    string
+	timeout time.Duration
+	tls     *tls.Config
+
+	netConn  net.Conn
+	text     *textproto.Conn
+	features map[string]string
+	noEPSV   bool
+}
+
+// dial opens a control connection to the given address and logs in. If tls
+// is not nil the connection is secured, directly when implicit is true, or
+// with AUTH TLS otherwise, in both cases the data connections are secured
+// too.
+func dial(addr string, opts *Options, config *tls.Config, implicit bool) (*conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	nc, err := net.DialTimeout("tcp", addr, opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if implicit {
+		nc = tls.Client(nc, config)
+	}
+
+	c := &conn{host: host, timeout: opts.Timeout, tls: config}
+	c.setConn(nc)
+	if err := c.login(opts.Username, opts.Password, implicit); err != nil {
+		c.netConn.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *conn) setConn(nc net.Conn) {
+	c.netConn = nc
+	c.text = textproto.NewConn(nc)
+}
+
+func (c *conn) login(username, password string, implicit bool) error {
+	if _, _, err := c.response(220); err != nil {
+		return err
+	}
+
+	if c.tls != nil && !implicit {
+		if _, _, err := c.cmd(234, "AUTH TLS"); err != nil {
+			return err
+		}
+
+		c.setConn(tls.Client(c.netConn, c.tls))
+	}
+
+	code, _, err := c.cmd(0, "USER %s", username)
+	if err != nil {
+		return err
+	}
+
+	switch code {
+	case 230:
+	case 331:
+		if _, _, err := c.cmd(230, "PASS %s", password); err != nil {
+			return err
+		}
+	default:
+		return &Error{Code: code, Msg: "unexpected reply to USER"}
+	}
+
+	if c.tls != nil {
+		if _, _, err := c.cmd(200, "PBSZ 0"); err != nil {
+			return err
+		}
+
+		if _, _, err := c.cmd(200, "PROT P"); err != nil {
+			return err
+		}
+	}
+
+	if err := c.feat(); err != nil {
+		return err
+	}
+
+	_, _, err = c.cmd(200, "TYPE I")
+	return err
+}
+
+// feat reads the extensions supported by the server, some old servers
+// don't implement FEAT at all.
+func (c *conn) feat() error {
+	c.features = make(map[string]string)
+	_, msg, err := c.cmd(211, "FEAT")
+	if _, ok := err.(*Error); ok {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	lines := strings.Split(msg, "\n")
+	for _, line := range lines[1:] {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "211") {
+			continue
+		}
+
+		parts := strings.SplitN(line, " ", 2)
+		name, params := strings.ToUpper(parts[0]), ""
+		if len(parts) == 2 {
+			params = parts[1]
+		}
+
+		c.features[name] = params
+	}
+
+	return nil
+}
+
+// cmd sends a command and reads the reply, that must have the given code.
+// The expected code can be a single digit, to accept any reply of the same
+// class, or zero to accept any reply.
+func (c *conn) cmd(expected int, format string, args ...interface{}) (int, string, error) {
+	if err := c.netConn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, "", err
+	}
+
+	if _, err := c.text.Cmd(format, args...); err != nil {
+		return 0, "", err
+	}
+
+	return c.response(expected)
+}
+
+func (c *conn) response(expected int) (int, string, error) {
+	if err := c.netConn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, "", err
+	}
+
+	code, msg, err := c.text.ReadResponse(0)
+	if err != nil {
+		return code, msg, err
+	}
+
+	if !matchCode(code, expected) {
+		return code, msg, &Error{Code: code, Msg: msg}
+	}
+
+	return code, msg, nil
+}
+
+func matchCode(code, expected int) bool {
+	switch {
+	case expected == 0:
+		return true
+	case expected < 10:
+		return code/100 == expected
+	default:
+		return code == expected
+	}
+}
+
+// passive requests a passive data connection, returning the port where the
+// server is listening. EPSV is used when supported, falling back to PASV.
+func (c *conn) passive() (string, error) {
+	if !c.noEPSV {
+		_, msg, err := c.cmd(229, "EPSV")
+		if err == nil {
+			return parseEPSV(msg)
+		}
+
+		if _, ok := err.(*Error); !ok {
+			return "", err
+		}
+
+		c.noEPSV = true
+	}
+
+	_, msg, err := c.cmd(227, "PASV")
+	if err != nil {
+		return "", err
+	}
+
+	return parsePASV(msg)
+}
+
+// parseEPSV parses a reply as "Entering Extended Passive Mode (|||6446|)".
+func parseEPSV(msg string) (string, error) {
+	start, end := strings.Index(msg, "(|||"), strings.LastIndex(msg, "|)")
+	if start == -1 || end < start+4 {
+		return "", fmt.Errorf("invalid EPSV reply: %q", msg)
+	}
+
+	port := msg[start+4 : end]
+	if _, err := strconv.Atoi(port); err != nil {
+		return "", fmt.Errorf("invalid EPSV reply: %q", msg)
+	}
+
+	return port, nil
+}
+
+// parsePASV parses a reply as "Entering Passive Mode (h1,h2,h3,h4,p1,p2)",
+// the address is ignored since servers behind NAT usually report the
+// private one, the data connection is opened to the host of the control
+// connection instead.
+func parsePASV(msg string) (string, error) {
+	start, end := strings.Index(msg, "("), strings.LastIndex(msg, ")")
+	if start == -1 || end < start {
+		return "", fmt.Errorf("invalid PASV reply: %q", msg)
+	}
+
+	parts := strings.Split(msg[start+1:end], ",")
+	if len(parts) != 6 {
+		return "", fmt.Errorf("invalid PASV reply: %q", msg)
+	}
+
+	hi, err1 := strconv.Atoi(strings.TrimSpace(parts[4]))
+	lo, err2 := strconv.Atoi(strings.TrimSpace(parts[5]))
+	if err1 != nil || err2 != nil {
+		return "", fmt.Errorf("invalid PASV reply: %q", msg)
+	}
+
+	return strconv.Itoa(hi<<8 | lo), nil
+}
+
+// transfer opens a data connection and sends the given command, returning
+// the data connection once the server has accepted the transfer. When done,
+// the data connection must be closed and the final reply read with
+// response.
+func (c *conn) transfer(format string, args ...interface{}) (net.Conn, error) {
+	port, err := c.passive()
+	if err != nil {
+		return nil, err
+	}
+
+	dc, err := net.DialTimeout("tcp", net.JoinHostPort(c.host, port), c.timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.tls != nil {
+		dc = tls.Client(dc, c.tls)
+	}
+
+	if _, _, err := c.cmd(1, format, args...); err != nil {
+		dc.Close()
+		return nil, err
+	}
+
+	return dc, nil
+}
+
+// retrieve starts the download of the given file from the given offset.
+func (c *conn) retrieve(p string, offset int64) (net.Conn, error) {
+	if offset != 0 {
+		if _, _, err := c.cmd(350, "REST %d", offset); err != nil {
+			return nil, err
+		}
+	}
+
+	return c.transfer("RETR %s", p)
+}
+
+// store uploads the given content as the given file.
+func (c *conn) store(p string, content []byte) error {
+	dc, err := c.transfer("STOR %s", p)
+	if err != nil {
+		return err
+	}
+
+	if _, err := dc.Write(content); err != nil {
+		dc.Close()
+		c.response(0)
+		return err
+	}
+
+	if err := dc.Close(); err != nil {
+		return err
+	}
+
+	_, _, err = c.response(2)
+	return err
+}
+
+// list returns the entries of the given directory, with MLSD if supported
+// or LIST otherwise.
+func (c *conn) list(p string) ([]*fileInfo, error) {
+	_, mlsd := c.features["MLST"]
+	command := "LIST %s"
+	if mlsd {
+		command = "MLSD %s"
+	}
+
+	dc, err := c.transfer(command, p)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []*fileInfo
+	scanner := bufio.NewScanner(dc)
+	for scanner.Scan() {
+		var fi *fileInfo
+		if mlsd {
+			fi, err = parseMLSD(scanner.Text())
+		} else {
+			fi, err = parseList(scanner.Text(), time.Now())
+		}
+
+		if err != nil {
+			break
+		}
+
+		if fi != nil {
+			infos = append(infos, fi)
+		}
+	}
+
+	if err == nil {
+		err = scanner.Err()
+	}
+
+	dc.Close()
+	if _, _, rerr := c.response(2); err == nil {
+		err = rerr
+	}
+
+	return infos, err
+}
+
+// stat returns the entry of the given path with MLST, it must be supported
+// by the server.
+func (c *conn) stat(p string) (*fileInfo, error) {
+	_, msg, err := c.cmd(250, "MLST %s", p)
+	if err != nil {
+		return nil, err
+	}
+
+	// the entry is the only line of the reply starting with a space.
+	for _, line := range strings.Split(msg, "\n") {
+		if !strings.HasPrefix(line, " ") {
+			continue
+		}
+
+		fi, _, err := parseFacts(strings.TrimSpace(line))
+		if err != nil {
+			return nil, err
+		}
+
+		fi.name = path.Base(fi.name)
+		return fi, nil
+	}
+
+	return nil, fmt.Errorf("invalid MLST reply: %q", msg)
+}
+
+func (c *conn) close() error {
+	c.cmd(0, "QUIT")
+	return c.netConn.Close()
+}
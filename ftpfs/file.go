@@ -0,0 +1,219 @@
+package ftpfs
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+
+	"gopkg.in/src-d/go-billy.v4/internal/memfile"
+)
+
+// file is a file of a FTP server opened for writing. Its content is
+// downloaded on the first read, and kept in memory until closed, when it's
+// uploaded if modified.
+type file struct {
+	*memfile.File
+
+	fs   *FTP
+	path string
+}
+
+func newFile(fs *FTP, name, p string, flag int) *file {
+	f := &file{fs: fs, path: p}
+	f.File = memfile.New(name, flag, f.load)
+	return f
+}
+
+func (f *file) load() ([]byte, error) {
+	return ioutil.ReadAll(newReader(f.fs, f.Name(), f.path, 0))
+}
+
+// Close uploads the content of the file if it was modified.
+func (f *file) Close() error {
+	if f.Closed {
+		return os.ErrClosed
+	}
+
+	f.Closed = true
+
+	var err error
+	if f.Dirty {
+		err = f.fs.store(f.path, f.Content)
+	}
+
+	f.Content = nil
+	return err
+}
+
+// Lock is a no-op in FTP.
+func (f *file) Lock() error {
+	return nil
+}
+
+// Unlock is a no-op in FTP.
+func (f *file) Unlock() error {
+	return nil
+}
+
+// reader is a file of a FTP server opened only for reading. It's downloaded
+// as it's read, if the transfer is interrupted it's resumed from the current
+// position.
+type reader struct {
+	fs   *FTP
+	name string
+	path string
+	size int64
+
+	conn     *conn
+	data     net.Conn
+	eof      bool
+	position int64
+	isClosed bool
+}
+
+func newReader(fs *FTP, name, p string, size int64) *reader {
+	return &reader{fs: fs, name: name, path: p, size: size}
+}
+
+func (f *reader) Name() string {
+	return f.name
+}
+
+func (f *reader) Read(p []byte) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	if f.eof || len(p) == 0 {
+		return 0, io.EOF
+	}
+
+	for retries := 0; ; retries++ {
+		if f.data == nil {
+			c, dc, err := f.fs.retrieve(f.path, f.position)
+			if err != nil {
+				return 0, err
+			}
+
+			f.conn, f.data = c, dc
+		}
+
+		n, err := f.data.Read(p)
+		f.position += int64(n)
+		if err == nil {
+			return n, nil
+		}
+
+		ferr := f.stop()
+		if err == io.EOF && ferr == nil {
+			f.eof = true
+			if n != 0 {
+				return n, nil
+			}
+
+			return 0, io.EOF
+		}
+
+		if n != 0 {
+			// the transfer is resumed on the next read.
+			return n, nil
+		}
+
+		if retries == maxRetries {
+			if ferr != nil {
+				return 0, ferr
+			}
+
+			return 0, err
+		}
+	}
+}
+
+// stop closes the current transfer, if any.
+func (f *reader) stop() error {
+	if f.data == nil {
+		return nil
+	}
+
+	err := f.fs.finish(f.conn, f.data)
+	f.conn, f.data = nil, nil
+	return err
+}
+
+// ReadAt reads the given range with its own transfer, starting at the given
+// offset.
+func (f *reader) ReadAt(p []byte, off int64) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: f.name, Err: errors.New("negative offset")}
+	}
+
+	r := newReader(f.fs, f.name, f.path, f.size)
+	r.position = off
+	defer r.stop()
+
+	n, err := io.ReadFull(r, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+func (f *reader) Seek(offset int64, whence int) (int64, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.position
+	case io.SeekEnd:
+		offset += f.size
+	}
+
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: errors.New("negative offset")}
+	}
+
+	if offset != f.position {
+		// the error of the aborted transfer is meaningless.
+		f.stop()
+		f.position, f.eof = offset, false
+	}
+
+	return f.position, nil
+}
+
+func (f *reader) Write(p []byte) (int, error) {
+	return 0, errors.New("write not supported")
+}
+
+func (f *reader) Truncate(size int64) error {
+	return errors.New("truncate not supported")
+}
+
+func (f *reader) Close() error {
+	if f.isClosed {
+		return os.ErrClosed
+	}
+
+	f.isClosed = true
+	f.stop()
+	return nil
+}
+
+// Lock is a no-op in FTP.
+func (f *reader) Lock() error {
+	return nil
+}
+
+// Unlock is a no-op in FTP.
+func (f *reader) Unlock() error {
+	return nil
+}
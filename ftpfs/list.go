@@ -0,0 +1,229 @@
+package ftpfs
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseMLSD parses an entry of a MLSD listing, as described in RFC 3659,
+// eg.: "type=file;size=3;modify=20200102150405;UNIX.mode=0644; foo". The
+// entries of the directory itself and its parent return nil.
+func parseMLSD(line string) (*fileInfo, error) {
+	fi, kind, err := parseFacts(line)
+	if kind == "cdir" || kind == "pdir" {
+		return nil, err
+	}
+
+	return fi, err
+}
+
+// parseFacts parses a MLSD or MLST entry, returning also its type.
+func parseFacts(line string) (*fileInfo, string, error) {
+	i := strings.Index(line, " ")
+	if i == -1 {
+		return nil, "", fmt.Errorf("invalid MLSD entry: %q", line)
+	}
+
+	fi := &fileInfo{name: line[i+1:], mode: 0644}
+	var kind string
+	var hasMode bool
+	for _, fact := range strings.Split(line[:i], ";") {
+		parts := strings.SplitN(fact, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		value := parts[1]
+		switch strings.ToLower(parts[0]) {
+		case "type":
+			kind = strings.ToLower(value)
+			fi.dir = kind == "dir" || kind == "cdir" || kind == "pdir"
+		case "size":
+			fi.size, _ = strconv.ParseInt(value, 10, 64)
+		case "modify":
+			fi.modTime, _ = parseMLSDTime(value)
+		case "unix.mode":
+			mode, err := strconv.ParseUint(value, 8, 32)
+			if err == nil {
+				fi.mode, hasMode = os.FileMode(mode)&os.ModePerm, true
+			}
+		}
+	}
+
+	if fi.dir {
+		if !hasMode {
+			fi.mode = 0755
+		}
+
+		fi.mode |= os.ModeDir
+	}
+
+	return fi, kind, nil
+}
+
+func parseMLSDTime(value string) (time.Time, error) {
+	if i := strings.Index(value, "."); i != -1 {
+		value = value[:i]
+	}
+
+	return time.Parse("20060102150405", value)
+}
+
+// parseList parses an entry of a LIST listing. There is no standard format,
+// the ones of Unix ls, eg.: "-rw-r--r-- 1 owner group 3 Jan 02 15:04 foo",
+// and of MS-DOS, eg.: "01-02-06  03:04PM  3 foo", are supported. The entries
+// of the directory itself and its parent, and the total line, return nil.
+func parseList(line string, now time.Time) (*fileInfo, error) {
+	line = strings.TrimRight(line, "\r")
+	if line == "" || strings.HasPrefix(line, "total ") {
+		return nil, nil
+	}
+
+	var fi *fileInfo
+	var err error
+	if line[0] >= '0' && line[0] <= '9' {
+		fi, err = parseDOSList(line)
+	} else {
+		fi, err = parseUnixList(line, now)
+	}
+
+	if err != nil || fi.name == "." || fi.name == ".." {
+		return nil, err
+	}
+
+	return fi, nil
+}
+
+func parseUnixList(line string, now time.Time) (*fileInfo, error) {
+	fields, name := splitFields(line, 8)
+	if len(fields) != 8 || name == "" || len(fields[0]) < 10 {
+		return nil, fmt.Errorf("invalid LIST entry: %q", line)
+	}
+
+	fi := &fileInfo{name: name, mode: parsePerm(fields[0][1:10])}
+	switch fields[0][0] {
+	case 'd':
+		fi.dir = true
+		fi.mode |= os.ModeDir
+	case 'l':
+		if i := strings.Index(fi.name, " -> "); i != -1 {
+			fi.name = fi.name[:i]
+		}
+	}
+
+	var err error
+	if fi.size, err = strconv.ParseInt(fields[4], 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid LIST entry: %q", line)
+	}
+
+	date := fields[5] + " " + fields[6] + " " + fields[7]
+	if strings.Contains(fields[7], ":") {
+		fi.modTime, err = time.Parse("Jan _2 15:04 2006", date+" "+strconv.Itoa(now.Year()))
+		if err == nil && fi.modTime.After(now.AddDate(0, 0, 1)) {
+			// only the entries of the last six months have no year.
+			fi.modTime = fi.modTime.AddDate(-1, 0, 0)
+		}
+	} else {
+		fi.modTime, err = time.Parse("Jan _2 2006", date)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid LIST entry: %q", line)
+	}
+
+	return fi, nil
+}
+
+func parseDOSList(line string) (*fileInfo, error) {
+	fields, name := splitFields(line, 3)
+	if len(fields) != 3 || name == "" {
+		return nil, fmt.Errorf("invalid LIST entry: %q", line)
+	}
+
+	modTime, err := time.Parse("01-02-06 03:04PM", fields[0]+" "+fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid LIST entry: %q", line)
+	}
+
+	fi := &fileInfo{name: name, modTime: modTime, mode: 0644}
+	if fields[2] == "<DIR>" {
+		fi.dir, fi.mode = true, os.ModeDir|0755
+		return fi, nil
+	}
+
+	if fi.size, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid LIST entry: %q", line)
+	}
+
+	return fi, nil
+}
+
+// splitFields splits the first n space separated fields of the given line,
+// returning also the rest of the line, that can contain spaces.
+func splitFields(line string, n int) ([]string, string) {
+	var fields []string
+	for len(fields) < n {
+		line = strings.TrimLeft(line, " ")
+		i := strings.Index(line, " ")
+		if i == -1 {
+			if line != "" {
+				fields = append(fields, line)
+			}
+
+			return fields, ""
+		}
+
+		fields = append(fields, line[:i])
+		line = line[i:]
+	}
+
+	return fields, strings.TrimLeft(line, " ")
+}
+
+// parsePerm parses permissions as "rwxr-xr-x", the uppercase S and T flags
+// mean that the execution bit is not set.
+func parsePerm(perm string) os.FileMode {
+	var mode os.FileMode
+	for i, c := range perm {
+		if c != '-' && c != 'S' && c != 'T' {
+			mode |= 1 << uint(8-i)
+		}
+	}
+
+	return mode
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+	dir     bool
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.size
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	return fi.mode
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.dir
+}
+
+func (fi *fileInfo) Sys() interface{} {
+	return nil
+}
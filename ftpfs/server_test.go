@@ -0,0 +1,445 @@
+package ftpfs
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+// server is a minimal FTP server over a memfs, to test the client.
+type server struct {
+	fs    billy.Filesystem
+	ln    net.Listener
+	tls   *tls.Config
+	modes map[string]os.FileMode
+
+	// noMLSD, noEPSV and noChmod disable the corresponding commands.
+	noMLSD, noEPSV, noChmod bool
+	// drops is the number of downloads interrupted after sending dropAfter
+	// bytes.
+	drops     int
+	dropAfter int
+
+	mu       sync.Mutex
+	logins   int
+	commands []string
+}
+
+func newServer() (*server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &server{fs: memfs.New(), ln: ln, modes: make(map[string]os.FileMode)}
+	go s.serve()
+	return s, nil
+}
+
+func (s *server) URL() string {
+	return "ftp://" + s.ln.Addr().String()
+}
+
+func (s *server) Close() error {
+	return s.ln.Close()
+}
+
+func (s *server) count(command string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int
+	for _, c := range s.commands {
+		if c == command {
+			n++
+		}
+	}
+
+	return n
+}
+
+func (s *server) serve() {
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		go (&session{server: s}).serve(nc)
+	}
+}
+
+type session struct {
+	*server
+	nc      net.Conn
+	text    *textproto.Conn
+	passive net.Listener
+	private bool
+	offset  int64
+	rename  string
+}
+
+func (s *session) serve(nc net.Conn) {
+	s.nc, s.text = nc, textproto.NewConn(nc)
+	defer func() {
+		s.text.Close()
+		if s.passive != nil {
+			s.passive.Close()
+		}
+	}()
+
+	s.reply(220, "ready")
+	for {
+		line, err := s.text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		parts := strings.SplitN(line, " ", 2)
+		command, arg := strings.ToUpper(parts[0]), ""
+		if len(parts) == 2 {
+			arg = parts[1]
+		}
+
+		s.mu.Lock()
+		s.commands = append(s.commands, command)
+		s.mu.Unlock()
+
+		if command == "QUIT" {
+			s.reply(221, "bye")
+			return
+		}
+
+		s.handle(command, arg)
+	}
+}
+
+func (s *session) reply(code int, msg string) {
+	s.text.PrintfLine("%d %s", code, msg)
+}
+
+func (s *session) handle(command, arg string) {
+	switch command {
+	case "AUTH":
+		if s.tls == nil {
+			s.reply(502, "not implemented")
+			return
+		}
+
+		s.reply(234, "ok")
+		s.nc = tls.Server(s.nc, s.tls)
+		s.text = textproto.NewConn(s.nc)
+	case "USER":
+		s.reply(331, "password required")
+	case "PASS":
+		if arg != "pass" {
+			s.reply(530, "login incorrect")
+			return
+		}
+
+		s.mu.Lock()
+		s.logins++
+		s.mu.Unlock()
+		s.reply(230, "logged in")
+	case "PBSZ":
+		s.reply(200, "ok")
+	case "PROT":
+		s.private = arg == "P"
+		s.reply(200, "ok")
+	case "FEAT":
+		features := "211-Features:\r\n EPSV\r\n REST STREAM\r\n"
+		if !s.noMLSD {
+			features += " MLST type*;size*;modify*;UNIX.mode*;\r\n"
+		}
+
+		s.text.PrintfLine("%s211 End", features)
+	case "TYPE":
+		s.reply(200, "ok")
+	case "EPSV", "PASV":
+		s.listen(command)
+	case "REST":
+		s.offset, _ = strconv.ParseInt(arg, 10, 64)
+		s.reply(350, "restarting")
+	case "RETR":
+		s.retrieve(arg)
+	case "STOR":
+		s.store(arg)
+	case "MLSD", "LIST":
+		s.list(command, arg)
+	case "MLST":
+		s.stat(arg)
+	case "MKD":
+		s.simple(257, s.mkdir(arg))
+	case "RMD", "DELE":
+		s.simple(250, s.remove(command, arg))
+	case "RNFR":
+		if _, err := s.fs.Stat(arg); err != nil {
+			s.reply(550, err.Error())
+			return
+		}
+
+		s.rename = arg
+		s.reply(350, "ready")
+	case "RNTO":
+		s.simple(250, s.fs.Rename(s.rename, arg))
+	case "SITE":
+		s.site(arg)
+	default:
+		s.reply(502, "not implemented")
+	}
+}
+
+func (s *session) simple(code int, err error) {
+	if err != nil {
+		s.reply(550, err.Error())
+		return
+	}
+
+	s.reply(code, "ok")
+}
+
+func (s *session) listen(command string) {
+	if command == "EPSV" && s.noEPSV {
+		s.reply(502, "not implemented")
+		return
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		s.reply(425, err.Error())
+		return
+	}
+
+	s.passive = ln
+	port := ln.Addr().(*net.TCPAddr).Port
+	if command == "EPSV" {
+		s.reply(229, fmt.Sprintf("Entering Extended Passive Mode (|||%d|)", port))
+		return
+	}
+
+	// the address is wrong on purpose, the client must ignore it.
+	s.reply(227, fmt.Sprintf("Entering Passive Mode (10,0,0,1,%d,%d)", port>>8, port&0xff))
+}
+
+// transfer accepts the data connection and calls the given function with
+// it, replying with the given error if any.
+func (s *session) transfer(fn func(dc net.Conn) error) {
+	if s.passive == nil {
+		s.reply(425, "use PASV first")
+		return
+	}
+
+	ln := s.passive
+	s.passive = nil
+	defer ln.Close()
+
+	dc, err := ln.Accept()
+	if err != nil {
+		s.reply(425, err.Error())
+		return
+	}
+
+	if s.private {
+		dc = tls.Server(dc, s.tls)
+	}
+
+	s.reply(150, "opening data connection")
+	err = fn(dc)
+	dc.Close()
+	if err != nil {
+		s.reply(426, err.Error())
+		return
+	}
+
+	s.reply(226, "transfer complete")
+}
+
+func (s *session) retrieve(p string) {
+	offset := s.offset
+	s.offset = 0
+
+	f, err := s.fs.Open(p)
+	if err != nil {
+		s.reply(550, err.Error())
+		return
+	}
+
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		s.reply(550, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	drop := s.drops > 0
+	if drop {
+		s.drops--
+	}
+	s.mu.Unlock()
+
+	s.transfer(func(dc net.Conn) error {
+		if !drop {
+			_, err := io.Copy(dc, f)
+			return err
+		}
+
+		if _, err := io.CopyN(dc, f, int64(s.dropAfter)); err != nil && err != io.EOF {
+			return err
+		}
+
+		return fmt.Errorf("connection dropped")
+	})
+}
+
+func (s *session) store(p string) {
+	if err := s.parent(p); err != nil {
+		s.reply(550, err.Error())
+		return
+	}
+
+	s.transfer(func(dc net.Conn) error {
+		content, err := ioutil.ReadAll(dc)
+		if err != nil {
+			return err
+		}
+
+		return util.WriteFile(s.fs, p, content, 0644)
+	})
+}
+
+func (s *session) list(command, p string) {
+	infos, err := s.fs.ReadDir(p)
+	if err != nil {
+		s.reply(550, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	modes := make(map[string]os.FileMode, len(s.modes))
+	for k, v := range s.modes {
+		modes[k] = v
+	}
+	s.mu.Unlock()
+
+	s.transfer(func(dc net.Conn) error {
+		w := bufio.NewWriter(dc)
+		for _, fi := range infos {
+			mode := fi.Mode()
+			if m, ok := modes[path.Join(p, fi.Name())]; ok {
+				mode = mode&^os.ModePerm | m
+			}
+
+			if command == "LIST" {
+				fmt.Fprintf(w, "%s 1 owner group %d %s %s\r\n",
+					mode, fi.Size(), fi.ModTime().Format("Jan _2 15:04"), fi.Name())
+				continue
+			}
+
+			fmt.Fprintf(w, "%s %s\r\n", facts(fi, mode), fi.Name())
+		}
+
+		return w.Flush()
+	})
+}
+
+func (s *session) stat(p string) {
+	if s.noMLSD {
+		s.reply(502, "not implemented")
+		return
+	}
+
+	fi, err := s.fs.Stat(p)
+	if err != nil {
+		s.reply(550, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	mode, ok := s.modes[p]
+	s.mu.Unlock()
+	if !ok {
+		mode = fi.Mode()
+	}
+
+	s.text.PrintfLine("250-Listing %s\r\n %s %s\r\n250 End", p, facts(fi, mode), p)
+}
+
+func facts(fi os.FileInfo, mode os.FileMode) string {
+	kind := "file"
+	if fi.IsDir() {
+		kind = "dir"
+	}
+
+	return fmt.Sprintf("type=%s;size=%d;modify=%s;UNIX.mode=%04o;",
+		kind, fi.Size(), fi.ModTime().UTC().Format("20060102150405"),
+		mode&os.ModePerm)
+}
+
+// parent returns an error if the parent directory of the given path doesn't
+// exist, the root always exists.
+func (s *session) parent(p string) error {
+	dir := path.Dir(p)
+	if dir == "/" || dir == "." {
+		return nil
+	}
+
+	_, err := s.fs.Stat(dir)
+	return err
+}
+
+func (s *session) mkdir(p string) error {
+	if err := s.parent(p); err != nil {
+		return err
+	}
+
+	if _, err := s.fs.Stat(p); err == nil {
+		return os.ErrExist
+	}
+
+	return s.fs.MkdirAll(p, 0755)
+}
+
+func (s *session) remove(command, p string) error {
+	fi, err := s.fs.Stat(p)
+	if err != nil {
+		return err
+	}
+
+	if fi.IsDir() != (command == "RMD") {
+		return fmt.Errorf("wrong file type")
+	}
+
+	return s.fs.Remove(p)
+}
+
+func (s *session) site(arg string) {
+	parts := strings.SplitN(arg, " ", 3)
+	if s.noChmod || len(parts) != 3 || strings.ToUpper(parts[0]) != "CHMOD" {
+		s.reply(502, "not implemented")
+		return
+	}
+
+	mode, err := strconv.ParseUint(parts[1], 8, 32)
+	if err != nil {
+		s.reply(501, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	s.modes[parts[2]] = os.FileMode(mode)
+	s.mu.Unlock()
+	s.reply(200, "ok")
+}
@@ -0,0 +1,75 @@
+package gitfs
+
+import (
+	"bytes"
+	"os"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// file is a read-only billy.File over the content of a blob.
+type file struct {
+	name string
+	r    *bytes.Reader
+
+	isClosed bool
+}
+
+func newFile(name string, content []byte) billy.File {
+	return &file{name: name, r: bytes.NewReader(content)}
+}
+
+func (f *file) Name() string {
+	return f.name
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	return f.r.Read(p)
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	return f.r.ReadAt(p, off)
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	return f.r.Seek(offset, whence)
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	return 0, billy.ErrReadOnly
+}
+
+func (f *file) Truncate(size int64) error {
+	return billy.ErrReadOnly
+}
+
+func (f *file) Close() error {
+	if f.isClosed {
+		return os.ErrClosed
+	}
+
+	f.isClosed = true
+	return nil
+}
+
+// Lock is a no-op in gitfs.
+func (f *file) Lock() error {
+	return nil
+}
+
+// Unlock is a no-op in gitfs.
+func (f *file) Unlock() error {
+	return nil
+}
@@ -0,0 +1,408 @@
+// Package gitfs provides a read-only billy filesystem over the tree of a
+// commit of a git repository, without a checkout.
+//
+// The repository is read from its storage, a bare repository or the .git
+// directory of a working copy, supporting loose and packed objects and
+// references. The trees are decoded as they are traversed, and the blobs
+// are read when the files are opened. All the entries have the time of the
+// commit as modification time, and the submodules are shown as empty
+// directories.
+package gitfs // import "gopkg.in/src-d/go-billy.v4/gitfs"
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/internal/linkutil"
+)
+
+var (
+	// ErrInvalidRepository is returned when the storage doesn't contain a
+	// git repository.
+	ErrInvalidRepository = errors.New("not a git repository")
+	// ErrRevisionNotFound is returned when the given revision can't be
+	// resolved.
+	ErrRevisionNotFound = errors.New("revision not found")
+	// ErrObjectNotFound is returned when an object isn't in the repository.
+	ErrObjectNotFound = errors.New("object not found")
+	// ErrCorrupted is returned when an object, or a reference, can't be
+	// decoded.
+	ErrCorrupted = errors.New("corrupted repository")
+
+	errNotDir = errors.New("not a directory")
+)
+
+// Git is a read-only filesystem based on the tree of a git commit.
+type Git struct {
+	repo    *repository
+	root    *entry
+	modTime time.Time
+}
+
+// entry is an entry of a tree, the submodules are the directories without
+// a tree.
+type entry struct {
+	name      string
+	mode      os.FileMode
+	hash      hash
+	submodule bool
+}
+
+// New returns a new read-only filesystem with the tree of the given
+// revision of the repository stored in storage. The revision is a hash of a
+// commit, tag or tree, or the name of a reference, like "HEAD", "master" or
+// "refs/tags/v1.0.0".
+func New(storage billy.Filesystem, rev string) (billy.Filesystem, error) {
+	repo, err := openRepository(storage)
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := repo.resolveRevision(rev)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, commit, err := repo.commitTree(h)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := &Git{
+		repo: repo,
+		root: &entry{mode: os.ModeDir | 0755, hash: tree},
+	}
+
+	if commit != nil {
+		fs.modTime = commitTime(header(commit.data, "committer"))
+	}
+
+	if _, err := repo.typedObject(tree, treeObject); err != nil {
+		return nil, err
+	}
+
+	return chroot.New(fs, string(filepath.Separator)), nil
+}
+
+// commitTime decodes the time of an author or committer line: the name and
+// email followed by the Unix time and the offset from UTC, as "+hhmm".
+func commitTime(line string) time.Time {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return time.Time{}
+	}
+
+	sec, err := strconv.ParseInt(fields[len(fields)-2], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+
+	t := time.Unix(sec, 0)
+	tz := fields[len(fields)-1]
+	offset, err := strconv.Atoi(tz)
+	if err != nil || len(tz) != 5 {
+		return t
+	}
+
+	if offset < 0 {
+		offset = -offset
+	}
+
+	seconds := (offset/100*60 + offset%100) * 60
+	if tz[0] == '-' {
+		seconds = -seconds
+	}
+
+	return t.In(time.FixedZone("", seconds))
+}
+
+func (fs *Git) Create(filename string) (billy.File, error) {
+	return nil, billy.ErrReadOnly
+}
+
+func (fs *Git) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (fs *Git) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if isWrite(flag) {
+		return nil, billy.ErrReadOnly
+	}
+
+	e, err := fs.resolve(filename, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.mode.IsDir() {
+		return nil, fmt.Errorf("cannot open directory: %s", filename)
+	}
+
+	blob, err := fs.repo.typedObject(e.hash, blobObject)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+	}
+
+	return newFile(filename, blob.data), nil
+}
+
+func (fs *Git) Stat(filename string) (os.FileInfo, error) {
+	return fs.stat(filename, true)
+}
+
+func (fs *Git) Lstat(filename string) (os.FileInfo, error) {
+	return fs.stat(filename, false)
+}
+
+func (fs *Git) stat(filename string, follow bool) (os.FileInfo, error) {
+	e, err := fs.resolve(filename, follow)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := fs.newFileInfo(path.Base(clean(filename)), e)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: filename, Err: err}
+	}
+
+	return fi, nil
+}
+
+func (fs *Git) ReadDir(filename string) ([]os.FileInfo, error) {
+	e, err := fs.resolve(filename, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if !e.mode.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: filename, Err: errNotDir}
+	}
+
+	children, err := fs.readDir(e)
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: filename, Err: err}
+	}
+
+	var entries []os.FileInfo
+	for _, child := range children {
+		fi, err := fs.newFileInfo(child.name, child)
+		if err != nil {
+			return nil, &os.PathError{Op: "readdir", Path: filename, Err: err}
+		}
+
+		entries = append(entries, fi)
+	}
+
+	return entries, nil
+}
+
+func (fs *Git) Readlink(link string) (string, error) {
+	e, err := fs.resolve(link, false)
+	if err != nil {
+		return "", err
+	}
+
+	if e.mode&os.ModeSymlink == 0 {
+		return "", &os.PathError{Op: "readlink", Path: link, Err: errors.New("not a symlink")}
+	}
+
+	target, err := fs.readlink(e)
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: link, Err: err}
+	}
+
+	return filepath.FromSlash(target), nil
+}
+
+func (fs *Git) Rename(from, to string) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *Git) Remove(filename string) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *Git) MkdirAll(filename string, perm os.FileMode) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *Git) Symlink(target, link string) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *Git) TempFile(dir, prefix string) (billy.File, error) {
+	return nil, billy.ErrReadOnly
+}
+
+func (fs *Git) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Git) Capabilities() billy.Capability {
+	return billy.ReadCapability | billy.SeekCapability
+}
+
+// resolve returns the entry of the given filename, following the symlinks
+// found in any of the parent directories, and in the file itself if follow
+// is true.
+func (fs *Git) resolve(filename string, follow bool) (*entry, error) {
+	_, e, err := linkutil.Resolve(clean(filename), follow, fs.root, fs.child, fs.linkTarget)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: filename, Err: err}
+	}
+
+	return e.(*entry), nil
+}
+
+// child returns the entry with the given name in the directory dir, and if
+// it's a link, to resolve the paths with linkutil.Resolve.
+func (fs *Git) child(dir interface{}, name string) (interface{}, bool, error) {
+	d := dir.(*entry)
+	if !d.mode.IsDir() {
+		return nil, false, errNotDir
+	}
+
+	e, err := fs.lookup(d, name)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return e, e.mode&os.ModeSymlink != 0, nil
+}
+
+func (fs *Git) linkTarget(link interface{}) (string, error) {
+	return fs.readlink(link.(*entry))
+}
+
+func (fs *Git) lookup(dir *entry, name string) (*entry, error) {
+	entries, err := fs.readDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].name >= name })
+	if i == len(entries) || entries[i].name != name {
+		return nil, os.ErrNotExist
+	}
+
+	return entries[i], nil
+}
+
+// readDir returns the entries of the given directory, sorted by name
+// instead of the tree order, where the directories sort as if they had a
+// trailing slash.
+func (fs *Git) readDir(dir *entry) ([]*entry, error) {
+	if dir.submodule {
+		return nil, nil
+	}
+
+	tree, err := fs.repo.tree(dir.hash)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*entry, 0, len(tree))
+	for _, te := range tree {
+		e := &entry{name: te.name, hash: te.hash}
+		switch te.mode & 0170000 {
+		case 0040000:
+			e.mode = os.ModeDir | 0755
+		case 0120000:
+			e.mode = os.ModeSymlink | 0777
+		case 0160000:
+			e.mode, e.submodule = os.ModeDir|0755, true
+		default:
+			e.mode = 0644
+			if te.mode&0111 != 0 {
+				e.mode = 0755
+			}
+		}
+
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+	return entries, nil
+}
+
+func (fs *Git) readlink(e *entry) (string, error) {
+	blob, err := fs.repo.typedObject(e.hash, blobObject)
+	if err != nil {
+		return "", err
+	}
+
+	return string(blob.data), nil
+}
+
+type fileInfo struct {
+	name    string
+	mode    os.FileMode
+	size    int64
+	modTime time.Time
+}
+
+// newFileInfo returns the os.FileInfo of the given entry, reading the size
+// of its blob.
+func (fs *Git) newFileInfo(name string, e *entry) (os.FileInfo, error) {
+	if name == "" || name == "." {
+		name = string(filepath.Separator)
+	}
+
+	fi := &fileInfo{name: name, mode: e.mode, modTime: fs.modTime}
+	if e.mode.IsDir() {
+		return fi, nil
+	}
+
+	_, size, err := fs.repo.size(e.hash)
+	if err != nil {
+		return nil, err
+	}
+
+	fi.size = size
+	return fi, nil
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.size
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	return fi.mode
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.mode.IsDir()
+}
+
+func (fi *fileInfo) Sys() interface{} {
+	return nil
+}
+
+// clean returns the given path relative to the root of the tree, using
+// forward slashes as separator, and "" for the root itself.
+func clean(name string) string {
+	name = path.Clean("/" + filepath.ToSlash(name))
+	return strings.TrimPrefix(name, "/")
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_APPEND|os.O_TRUNC) != 0
+}
@@ -0,0 +1,266 @@
+package gitfs
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&GitSuite{})
+
+type GitSuite struct {
+	repo   *testRepository
+	first  hash
+	second hash
+	tree   hash
+}
+
+var large = strings.Repeat("0123456789abcdef", 1000)
+
+// SetUpTest writes a repository with two commits, the objects of the first
+// one packed, and the ones of the second one loose.
+func (s *GitSuite) SetUpTest(c *C) {
+	r := &testRepository{c: c, storage: memfs.New()}
+	s.repo = r
+
+	foo := r.blob("hello world", true)
+	bar := r.blob("bar", true)
+	a := r.blob("a", true)
+	big := r.blob(large, true)
+	deltaBlob := r.putDelta([]byte(large+"delta"), big, false)
+	refBlob := r.putDelta([]byte(large+"ref"), big, true)
+	baz := r.tree(true, testTreeEntry{"100644", "a", a})
+	qux := r.tree(true,
+		testTreeEntry{"100755", "bar", bar},
+		testTreeEntry{"40000", "baz", baz},
+	)
+
+	root := r.tree(true,
+		testTreeEntry{"120000", "dirlink", r.blob("qux", true)},
+		testTreeEntry{"100644", "foo", foo},
+		testTreeEntry{"100644", "large", big},
+		testTreeEntry{"100644", "large-delta", deltaBlob},
+		testTreeEntry{"100644", "large-ref", refBlob},
+		testTreeEntry{"120000", "link", r.blob("qux/bar", true)},
+		testTreeEntry{"40000", "qux", qux},
+		testTreeEntry{"160000", "sub", foo},
+	)
+
+	s.first = r.commit(root, "1000000000 +0200", true)
+	r.writePack()
+
+	s.tree = r.tree(false, testTreeEntry{"100644", "foo", r.blob("modified", false)})
+	s.second = r.commit(s.tree, "1500000000 -0130", false)
+
+	r.ref("HEAD", "ref: refs/heads/master")
+	r.ref("refs/heads/master", s.second.String())
+	r.ref("packed-refs", strings.Join([]string{
+		"# pack-refs with: peeled fully-peeled sorted",
+		s.first.String() + " refs/heads/old",
+		r.tag(s.first, "v1", false).String() + " refs/tags/v1",
+		"^" + s.first.String(),
+	}, "\n"))
+}
+
+func (s *GitSuite) open(c *C, rev string) billy.Filesystem {
+	fs, err := New(s.repo.storage, rev)
+	c.Assert(err, IsNil)
+	return fs
+}
+
+func (s *GitSuite) TestRevisions(c *C) {
+	for _, rev := range []string{"", "HEAD", "master", "refs/heads/master", s.second.String(), s.tree.String()} {
+		c.Assert(test.ReadFile(c, s.open(c, rev), "foo"), Equals, "modified", Commentf("%s", rev))
+	}
+
+	for _, rev := range []string{"old", "heads/old", "v1", "refs/tags/v1", s.first.String()} {
+		c.Assert(test.ReadFile(c, s.open(c, rev), "foo"), Equals, "hello world", Commentf("%s", rev))
+	}
+
+	_, err := New(s.repo.storage, "nope")
+	c.Assert(err, Equals, ErrRevisionNotFound)
+
+	_, err = New(s.repo.storage, "config")
+	c.Assert(err, Equals, ErrRevisionNotFound)
+
+	_, err = New(s.repo.storage, strings.Repeat("0", 40))
+	c.Assert(err, Equals, ErrObjectNotFound)
+
+	_, err = New(memfs.New(), "HEAD")
+	c.Assert(err, Equals, ErrInvalidRepository)
+}
+
+func (s *GitSuite) TestOpen(c *C) {
+	fs := s.open(c, "old")
+	f, err := fs.Open("foo")
+	c.Assert(err, IsNil)
+	c.Assert(f.Name(), Equals, "foo")
+
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "hello world")
+	c.Assert(f.Close(), IsNil)
+
+	_, err = fs.Open("nope")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	_, err = fs.Open("qux")
+	c.Assert(err, NotNil)
+}
+
+func (s *GitSuite) TestDeltas(c *C) {
+	fs := s.open(c, "old")
+	c.Assert(test.ReadFile(c, fs, "large"), Equals, large)
+	c.Assert(test.ReadFile(c, fs, "large-delta"), Equals, large+"delta")
+	c.Assert(test.ReadFile(c, fs, "large-ref"), Equals, large+"ref")
+
+	fi, err := fs.Stat("large-delta")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Size(), Equals, int64(len(large)+5))
+
+	fi, err = fs.Stat("large-ref")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Size(), Equals, int64(len(large)+3))
+}
+
+func (s *GitSuite) TestSeekAndReadAt(c *C) {
+	f, err := s.open(c, "old").Open("foo")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	buf := make([]byte, 5)
+	n, err := f.ReadAt(buf, 6)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf[:n]), Equals, "world")
+
+	pos, err := f.Seek(-5, io.SeekEnd)
+	c.Assert(err, IsNil)
+	c.Assert(pos, Equals, int64(6))
+
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "world")
+}
+
+func (s *GitSuite) TestStat(c *C) {
+	fs := s.open(c, "old")
+	fi, err := fs.Stat("qux/bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Name(), Equals, "bar")
+	c.Assert(fi.Size(), Equals, int64(3))
+	c.Assert(fi.Mode(), Equals, os.FileMode(0755))
+	c.Assert(fi.ModTime().Equal(time.Unix(1000000000, 0)), Equals, true)
+	_, offset := fi.ModTime().Zone()
+	c.Assert(offset, Equals, 2*3600)
+
+	fi, err = fs.Stat("foo")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode(), Equals, os.FileMode(0644))
+
+	fi, err = fs.Stat("qux/baz")
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+
+	fi, err = fs.Stat("sub")
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+
+	fi, err = fs.Stat("/")
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+
+	fi, err = s.open(c, "HEAD").Stat("foo")
+	c.Assert(err, IsNil)
+	_, offset = fi.ModTime().Zone()
+	c.Assert(offset, Equals, -90*60)
+}
+
+func (s *GitSuite) TestSymlinks(c *C) {
+	fs := s.open(c, "old")
+	fi, err := fs.Lstat("link")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode()&os.ModeSymlink, Not(Equals), os.FileMode(0))
+	c.Assert(fi.Size(), Equals, int64(7))
+
+	fi, err = fs.Stat("link")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Name(), Equals, "link")
+	c.Assert(fi.Size(), Equals, int64(3))
+
+	target, err := fs.Readlink("link")
+	c.Assert(err, IsNil)
+	c.Assert(target, Equals, fs.Join("qux", "bar"))
+
+	c.Assert(test.ReadFile(c, fs, "dirlink/baz/a"), Equals, "a")
+
+	_, err = fs.Readlink("foo")
+	c.Assert(err, NotNil)
+}
+
+func (s *GitSuite) TestReadDir(c *C) {
+	fs := s.open(c, "old")
+	entries, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+
+	var names []string
+	for _, fi := range entries {
+		names = append(names, fi.Name())
+	}
+
+	c.Assert(names, DeepEquals, []string{
+		"dirlink", "foo", "large", "large-delta", "large-ref", "link", "qux", "sub",
+	})
+
+	entries, err = fs.ReadDir("dirlink")
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 2)
+	c.Assert(entries[0].Name(), Equals, "bar")
+	c.Assert(entries[1].Name(), Equals, "baz")
+	c.Assert(entries[1].IsDir(), Equals, true)
+
+	entries, err = fs.ReadDir("sub")
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 0)
+
+	_, err = fs.ReadDir("foo")
+	c.Assert(err, NotNil)
+}
+
+func (s *GitSuite) TestChroot(c *C) {
+	fs, err := s.open(c, "old").Chroot("qux")
+	c.Assert(err, IsNil)
+	c.Assert(test.ReadFile(c, fs, "baz/a"), Equals, "a")
+}
+
+func (s *GitSuite) TestReadOnly(c *C) {
+	fs := s.open(c, "HEAD")
+	_, err := fs.Create("new")
+	c.Assert(err, Equals, billy.ErrReadOnly)
+
+	_, err = fs.OpenFile("foo", os.O_RDWR, 0)
+	c.Assert(err, Equals, billy.ErrReadOnly)
+
+	c.Assert(fs.Remove("foo"), Equals, billy.ErrReadOnly)
+	c.Assert(fs.Rename("foo", "bar"), Equals, billy.ErrReadOnly)
+	c.Assert(fs.MkdirAll("dir", 0755), Equals, billy.ErrReadOnly)
+	c.Assert(fs.Symlink("foo", "bar"), Equals, billy.ErrReadOnly)
+
+	f, err := fs.Open("foo")
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("foo"))
+	c.Assert(err, Equals, billy.ErrReadOnly)
+
+	c.Assert(billy.CapabilityCheck(fs, billy.WriteCapability), Equals, false)
+	c.Assert(billy.CapabilityCheck(fs, billy.ReadCapability), Equals, true)
+}
@@ -0,0 +1,372 @@
+package gitfs
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+const (
+	hashSize = 20
+
+	maxCachedObjects = 256
+	maxCachedSize    = 1 << 20
+	maxDeltaDepth    = 64
+	maxPeelDepth     = 16
+)
+
+// object types, as stored in the packfiles.
+const (
+	commitObject = iota + 1
+	treeObject
+	blobObject
+	tagObject
+	_
+	ofsDeltaObject
+	refDeltaObject
+)
+
+var objectTypes = map[string]int{
+	"commit": commitObject,
+	"tree":   treeObject,
+	"blob":   blobObject,
+	"tag":    tagObject,
+}
+
+// hash is a SHA-1 object name.
+type hash [hashSize]byte
+
+func parseHash(s string) (hash, bool) {
+	var h hash
+	if len(s) != hex.EncodedLen(hashSize) {
+		return h, false
+	}
+
+	if _, err := hex.Decode(h[:], []byte(s)); err != nil {
+		return h, false
+	}
+
+	return h, true
+}
+
+func (h hash) String() string {
+	return hex.EncodeToString(h[:])
+}
+
+type object struct {
+	typ  int
+	data []byte
+}
+
+// repository reads the objects of a git repository, loose or packed, from
+// its storage.
+type repository struct {
+	storage billy.Filesystem
+	packs   []*pack
+
+	mu      sync.Mutex
+	objects map[hash]*object
+}
+
+func openRepository(storage billy.Filesystem) (*repository, error) {
+	fi, err := storage.Stat("objects")
+	if err != nil || !fi.IsDir() {
+		return nil, ErrInvalidRepository
+	}
+
+	r := &repository{storage: storage, objects: make(map[hash]*object)}
+
+	dir := path.Join("objects", "pack")
+	files, err := storage.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	for _, fi := range files {
+		name := fi.Name()
+		if !strings.HasPrefix(name, "pack-") || !strings.HasSuffix(name, ".idx") {
+			continue
+		}
+
+		b, err := readFile(storage, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		idx, err := parseIndex(b)
+		if err != nil {
+			return nil, err
+		}
+
+		name = strings.TrimSuffix(name, ".idx") + ".pack"
+		r.packs = append(r.packs, &pack{name: path.Join(dir, name), index: idx})
+	}
+
+	return r, nil
+}
+
+// object returns the object with the given hash, failing with
+// ErrObjectNotFound if it doesn't exist.
+func (r *repository) object(h hash) (*object, error) {
+	r.mu.Lock()
+	o, ok := r.objects[h]
+	r.mu.Unlock()
+	if ok {
+		return o, nil
+	}
+
+	o, err := r.readLoose(h)
+	if os.IsNotExist(err) {
+		o, err = r.readPacked(h)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	r.cache(h, o)
+	return o, nil
+}
+
+// typedObject is like object, but fails with ErrCorrupted if the object
+// isn't of the given type.
+func (r *repository) typedObject(h hash, typ int) (*object, error) {
+	o, err := r.object(h)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.typ != typ {
+		return nil, ErrCorrupted
+	}
+
+	return o, nil
+}
+
+// size returns the type and size of an object, without reading the whole
+// content if possible.
+func (r *repository) size(h hash) (int, int64, error) {
+	r.mu.Lock()
+	o, ok := r.objects[h]
+	r.mu.Unlock()
+	if ok {
+		return o.typ, int64(len(o.data)), nil
+	}
+
+	typ, size, err := r.looseHeader(h)
+	if !os.IsNotExist(err) {
+		return typ, size, err
+	}
+
+	for _, p := range r.packs {
+		if off, ok := p.index.find(h); ok {
+			return r.packedHeader(p, off)
+		}
+	}
+
+	return 0, 0, ErrObjectNotFound
+}
+
+func (r *repository) cache(h hash, o *object) {
+	if len(o.data) > maxCachedSize {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.objects) >= maxCachedObjects {
+		r.objects = make(map[hash]*object)
+	}
+
+	r.objects[h] = o
+}
+
+func loosePath(h hash) string {
+	s := h.String()
+	return path.Join("objects", s[:2], s[2:])
+}
+
+func (r *repository) openLoose(h hash) (billy.File, *bufio.Reader, int, int64, error) {
+	f, err := r.storage.Open(loosePath(h))
+	if err != nil {
+		return nil, nil, 0, 0, err
+	}
+
+	zr, err := zlib.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, 0, 0, ErrCorrupted
+	}
+
+	br := bufio.NewReader(zr)
+	header, err := br.ReadString(0)
+	if err != nil {
+		f.Close()
+		return nil, nil, 0, 0, ErrCorrupted
+	}
+
+	fields := strings.Fields(strings.TrimSuffix(header, "\x00"))
+	if len(fields) != 2 {
+		f.Close()
+		return nil, nil, 0, 0, ErrCorrupted
+	}
+
+	typ := objectTypes[fields[0]]
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if typ == 0 || err != nil || size < 0 {
+		f.Close()
+		return nil, nil, 0, 0, ErrCorrupted
+	}
+
+	return f, br, typ, size, nil
+}
+
+func (r *repository) looseHeader(h hash) (int, int64, error) {
+	f, _, typ, size, err := r.openLoose(h)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return typ, size, f.Close()
+}
+
+func (r *repository) readLoose(h hash) (*object, error) {
+	f, br, typ, size, err := r.openLoose(h)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	data := make([]byte, size)
+	if _, err := io.ReadFull(br, data); err != nil {
+		return nil, ErrCorrupted
+	}
+
+	return &object{typ: typ, data: data}, nil
+}
+
+func (r *repository) readPacked(h hash) (*object, error) {
+	for _, p := range r.packs {
+		off, ok := p.index.find(h)
+		if !ok {
+			continue
+		}
+
+		f, err := r.storage.Open(p.name)
+		if err != nil {
+			return nil, err
+		}
+
+		defer f.Close()
+		return r.readPackEntry(p, f, off, 0)
+	}
+
+	return nil, ErrObjectNotFound
+}
+
+// commitTree returns the tree of the given commit, peeling the tags, and
+// the time of the commit.
+func (r *repository) commitTree(h hash) (hash, *object, error) {
+	for i := 0; i < maxPeelDepth; i++ {
+		o, err := r.object(h)
+		if err != nil {
+			return h, nil, err
+		}
+
+		switch o.typ {
+		case treeObject:
+			return h, nil, nil
+		case commitObject, tagObject:
+			key := "tree"
+			if o.typ == tagObject {
+				key = "object"
+			}
+
+			var ok bool
+			if h, ok = parseHash(header(o.data, key)); !ok {
+				return h, nil, ErrCorrupted
+			}
+
+			if o.typ == commitObject {
+				return h, o, nil
+			}
+		default:
+			return h, nil, fmt.Errorf("%s is not a commit or a tree", h)
+		}
+	}
+
+	return h, nil, ErrCorrupted
+}
+
+// header returns the value of the given header of a commit or tag.
+func header(data []byte, key string) string {
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			break
+		}
+
+		if strings.HasPrefix(line, key+" ") {
+			return line[len(key)+1:]
+		}
+	}
+
+	return ""
+}
+
+// treeEntry is an entry of a tree object.
+type treeEntry struct {
+	name string
+	mode uint32
+	hash hash
+}
+
+func (r *repository) tree(h hash) ([]treeEntry, error) {
+	o, err := r.typedObject(h, treeObject)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []treeEntry
+	data := o.data
+	for len(data) != 0 {
+		sp := bytes.IndexByte(data, ' ')
+		nul := bytes.IndexByte(data, 0)
+		if sp <= 0 || nul < sp || nul+1+hashSize > len(data) {
+			return nil, ErrCorrupted
+		}
+
+		mode, err := strconv.ParseUint(string(data[:sp]), 8, 32)
+		if err != nil {
+			return nil, ErrCorrupted
+		}
+
+		e := treeEntry{name: string(data[sp+1 : nul]), mode: uint32(mode)}
+		copy(e.hash[:], data[nul+1:])
+		entries = append(entries, e)
+		data = data[nul+1+hashSize:]
+	}
+
+	return entries, nil
+}
+
+func readFile(fs billy.Basic, filename string) ([]byte, error) {
+	f, err := fs.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
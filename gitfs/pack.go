@@ -0,0 +1,361 @@
+package gitfs
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"sort"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+var indexMagic = []byte{0xff, 't', 'O', 'c'}
+
+// pack is a packfile, with its index loaded in memory.
+type pack struct {
+	name  string
+	index *index
+}
+
+// index is a pack index, version 1 or 2, mapping the hashes of the objects
+// in the pack to their offsets.
+type index struct {
+	fanout  [256]uint32
+	hashes  []byte
+	offsets []uint64
+}
+
+func parseIndex(b []byte) (*index, error) {
+	idx := &index{}
+
+	version := 1
+	if bytes.HasPrefix(b, indexMagic) {
+		if len(b) < 8 || binary.BigEndian.Uint32(b[4:]) != 2 {
+			return nil, ErrCorrupted
+		}
+
+		version, b = 2, b[8:]
+	}
+
+	if len(b) < len(idx.fanout)*4 {
+		return nil, ErrCorrupted
+	}
+
+	for i := range idx.fanout {
+		idx.fanout[i] = binary.BigEndian.Uint32(b[i*4:])
+	}
+
+	b = b[len(idx.fanout)*4:]
+	n := int(idx.fanout[255])
+	idx.offsets = make([]uint64, n)
+
+	if version == 1 {
+		if len(b) < n*(4+hashSize) {
+			return nil, ErrCorrupted
+		}
+
+		idx.hashes = make([]byte, n*hashSize)
+		for i := 0; i < n; i++ {
+			entry := b[i*(4+hashSize):]
+			idx.offsets[i] = uint64(binary.BigEndian.Uint32(entry))
+			copy(idx.hashes[i*hashSize:], entry[4:4+hashSize])
+		}
+
+		return idx, nil
+	}
+
+	// the hashes, the CRCs and the 31 bits offsets, the ones with the most
+	// significant bit set index the table of 64 bits offsets.
+	if len(b) < n*(hashSize+8) {
+		return nil, ErrCorrupted
+	}
+
+	idx.hashes = b[:n*hashSize]
+	offsets := b[n*(hashSize+4):]
+	large := offsets[n*4:]
+	for i := 0; i < n; i++ {
+		off := binary.BigEndian.Uint32(offsets[i*4:])
+		if off&0x80000000 == 0 {
+			idx.offsets[i] = uint64(off)
+			continue
+		}
+
+		j := int(off&0x7fffffff) * 8
+		if j+8 > len(large) {
+			return nil, ErrCorrupted
+		}
+
+		idx.offsets[i] = binary.BigEndian.Uint64(large[j:])
+	}
+
+	return idx, nil
+}
+
+// find returns the offset of the object with the given hash in the pack.
+func (idx *index) find(h hash) (int64, bool) {
+	var lo int
+	if h[0] != 0 {
+		lo = int(idx.fanout[h[0]-1])
+	}
+
+	hi := int(idx.fanout[h[0]])
+	if lo > hi || hi > len(idx.offsets) {
+		return 0, false
+	}
+
+	i := lo + sort.Search(hi-lo, func(i int) bool {
+		return bytes.Compare(idx.hashAt(lo+i), h[:]) >= 0
+	})
+
+	if i == hi || !bytes.Equal(idx.hashAt(i), h[:]) {
+		return 0, false
+	}
+
+	return int64(idx.offsets[i]), true
+}
+
+func (idx *index) hashAt(i int) []byte {
+	return idx.hashes[i*hashSize : (i+1)*hashSize]
+}
+
+// packEntry is the header of an entry of a packfile, followed by its
+// zlib-compressed content.
+type packEntry struct {
+	typ  int
+	size int64
+	// base is the offset of the base of ofs-deltas, or the hash of the
+	// base of ref-deltas.
+	base     int64
+	baseHash hash
+
+	r *bufio.Reader
+}
+
+func readPackEntryHeader(f billy.File, off int64) (*packEntry, error) {
+	r := bufio.NewReader(io.NewSectionReader(f, off, 1<<62))
+	c, err := r.ReadByte()
+	if err != nil {
+		return nil, ErrCorrupted
+	}
+
+	e := &packEntry{typ: int(c>>4) & 7, size: int64(c & 0x0f), r: r}
+	for shift := uint(4); c&0x80 != 0; shift += 7 {
+		if c, err = r.ReadByte(); err != nil || shift > 56 {
+			return nil, ErrCorrupted
+		}
+
+		e.size |= int64(c&0x7f) << shift
+	}
+
+	switch e.typ {
+	case ofsDeltaObject:
+		if c, err = r.ReadByte(); err != nil {
+			return nil, ErrCorrupted
+		}
+
+		rel := int64(c & 0x7f)
+		for c&0x80 != 0 {
+			if c, err = r.ReadByte(); err != nil || rel > 1<<55 {
+				return nil, ErrCorrupted
+			}
+
+			rel = (rel+1)<<7 | int64(c&0x7f)
+		}
+
+		if rel <= 0 || rel > off {
+			return nil, ErrCorrupted
+		}
+
+		e.base = off - rel
+	case refDeltaObject:
+		if _, err := io.ReadFull(r, e.baseHash[:]); err != nil {
+			return nil, ErrCorrupted
+		}
+	case commitObject, treeObject, blobObject, tagObject:
+	default:
+		return nil, ErrCorrupted
+	}
+
+	return e, nil
+}
+
+// content inflates the content of the entry, with the size of the header.
+func (e *packEntry) content() ([]byte, error) {
+	zr, err := zlib.NewReader(e.r)
+	if err != nil {
+		return nil, ErrCorrupted
+	}
+
+	data := make([]byte, e.size)
+	if _, err := io.ReadFull(zr, data); err != nil {
+		return nil, ErrCorrupted
+	}
+
+	return data, nil
+}
+
+// readPackEntry returns the object at the given offset of the pack,
+// applying the deltas to their base.
+func (r *repository) readPackEntry(p *pack, f billy.File, off int64, depth int) (*object, error) {
+	if depth > maxDeltaDepth {
+		return nil, ErrCorrupted
+	}
+
+	e, err := readPackEntryHeader(f, off)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := e.content()
+	if err != nil {
+		return nil, err
+	}
+
+	var base *object
+	switch e.typ {
+	case ofsDeltaObject:
+		base, err = r.readPackEntry(p, f, e.base, depth+1)
+	case refDeltaObject:
+		base, err = r.object(e.baseHash)
+	default:
+		return &object{typ: e.typ, data: data}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = applyDelta(base.data, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &object{typ: base.typ, data: data}, nil
+}
+
+// packedHeader returns the type and size of the object at the given offset
+// of the pack, taking the size of the deltas from their header.
+func (r *repository) packedHeader(p *pack, off int64) (int, int64, error) {
+	f, err := r.storage.Open(p.name)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer f.Close()
+	return r.packEntryHeader(f, off, 0)
+}
+
+func (r *repository) packEntryHeader(f billy.File, off int64, depth int) (int, int64, error) {
+	if depth > maxDeltaDepth {
+		return 0, 0, ErrCorrupted
+	}
+
+	e, err := readPackEntryHeader(f, off)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if e.typ != ofsDeltaObject && e.typ != refDeltaObject {
+		return e.typ, e.size, nil
+	}
+
+	zr, err := zlib.NewReader(e.r)
+	if err != nil {
+		return 0, 0, ErrCorrupted
+	}
+
+	br := bufio.NewReader(zr)
+	if _, err := binary.ReadUvarint(br); err != nil {
+		return 0, 0, ErrCorrupted
+	}
+
+	size, err := binary.ReadUvarint(br)
+	if err != nil {
+		return 0, 0, ErrCorrupted
+	}
+
+	var typ int
+	if e.typ == refDeltaObject {
+		typ, _, err = r.size(e.baseHash)
+	} else {
+		typ, _, err = r.packEntryHeader(f, e.base, depth+1)
+	}
+
+	return typ, int64(size), err
+}
+
+// applyDelta applies a delta to its base: the sizes of the base and the
+// result, followed by instructions copying ranges of the base or inserting
+// new data.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	r := bytes.NewReader(delta)
+	baseSize, err := binary.ReadUvarint(r)
+	if err != nil || baseSize != uint64(len(base)) {
+		return nil, ErrCorrupted
+	}
+
+	size, err := binary.ReadUvarint(r)
+	if err != nil || size > 1<<40 {
+		return nil, ErrCorrupted
+	}
+
+	capacity := size
+	if capacity > maxCachedSize {
+		capacity = maxCachedSize
+	}
+
+	out := make([]byte, 0, capacity)
+	for {
+		op, err := r.ReadByte()
+		if err == io.EOF {
+			break
+		}
+
+		switch {
+		case op&0x80 != 0:
+			var off, n uint64
+			for i := uint(0); i < 7; i++ {
+				if op&(1<<i) == 0 {
+					continue
+				}
+
+				b, err := r.ReadByte()
+				if err != nil {
+					return nil, ErrCorrupted
+				}
+
+				if i < 4 {
+					off |= uint64(b) << (8 * i)
+				} else {
+					n |= uint64(b) << (8 * (i - 4))
+				}
+			}
+
+			if n == 0 {
+				n = 0x10000
+			}
+
+			if off+n > uint64(len(base)) {
+				return nil, ErrCorrupted
+			}
+
+			out = append(out, base[off:off+n]...)
+		case op != 0:
+			start := len(out)
+			out = append(out, make([]byte, op)...)
+			if _, err := io.ReadFull(r, out[start:]); err != nil {
+				return nil, ErrCorrupted
+			}
+		default:
+			return nil, ErrCorrupted
+		}
+	}
+
+	if uint64(len(out)) != size {
+		return nil, ErrCorrupted
+	}
+
+	return out, nil
+}
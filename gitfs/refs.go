@@ -0,0 +1,115 @@
+package gitfs
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strings"
+)
+
+const maxSymrefDepth = 5
+
+// refPrefixes are the prefixes tried, in order, to expand a short reference
+// name, the same way git does.
+var refPrefixes = []string{"", "refs/", "refs/tags/", "refs/heads/", "refs/remotes/"}
+
+// resolveRevision returns the hash of the given revision: a full hash, or
+// the name of a reference, full or short.
+func (r *repository) resolveRevision(rev string) (hash, error) {
+	if h, ok := parseHash(rev); ok {
+		return h, nil
+	}
+
+	if rev == "" {
+		rev = "HEAD"
+	}
+
+	for _, prefix := range refPrefixes {
+		if prefix == "" && !isRootRef(rev) {
+			continue
+		}
+
+		h, err := r.resolveRef(prefix+rev, 0)
+		if err == nil || err != ErrRevisionNotFound {
+			return h, err
+		}
+	}
+
+	return hash{}, ErrRevisionNotFound
+}
+
+// isRootRef returns true if the given name is a reference stored at the root
+// of the repository, like HEAD, or a full name.
+func isRootRef(name string) bool {
+	return strings.HasPrefix(name, "refs/") || strings.ToUpper(name) == name
+}
+
+// resolveRef returns the hash of the given reference, loose or packed,
+// following the symbolic references.
+func (r *repository) resolveRef(name string, depth int) (hash, error) {
+	if depth > maxSymrefDepth || strings.Contains(name, "..") {
+		return hash{}, ErrRevisionNotFound
+	}
+
+	fi, err := r.storage.Stat(name)
+	if os.IsNotExist(err) || err == nil && fi.IsDir() {
+		return r.packedRef(name)
+	}
+
+	if err != nil {
+		return hash{}, err
+	}
+
+	b, err := readFile(r.storage, name)
+	if err != nil {
+		return hash{}, err
+	}
+
+	line := strings.TrimSpace(string(b))
+	if strings.HasPrefix(line, "ref: ") {
+		return r.resolveRef(strings.TrimPrefix(line, "ref: "), depth+1)
+	}
+
+	h, ok := parseHash(line)
+	if !ok {
+		return hash{}, ErrCorrupted
+	}
+
+	return h, nil
+}
+
+// packedRef looks up the given reference in the packed-refs file, where the
+// lines are the hash followed by the name, or the peeled value of the tag
+// in the previous line.
+func (r *repository) packedRef(name string) (hash, error) {
+	b, err := readFile(r.storage, "packed-refs")
+	if os.IsNotExist(err) {
+		return hash{}, ErrRevisionNotFound
+	}
+
+	if err != nil {
+		return hash{}, err
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line := s.Text()
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "^") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[1] != name {
+			continue
+		}
+
+		h, ok := parseHash(fields[0])
+		if !ok {
+			return hash{}, ErrCorrupted
+		}
+
+		return h, nil
+	}
+
+	return hash{}, ErrRevisionNotFound
+}
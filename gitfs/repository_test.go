@@ -0,0 +1,249 @@
+package gitfs
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"path"
+	"sort"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+var typeNames = map[int]string{
+	commitObject: "commit",
+	treeObject:   "tree",
+	blobObject:   "blob",
+	tagObject:    "tag",
+}
+
+func objectHash(typ int, data []byte) hash {
+	var h hash
+	s := sha1.New()
+	fmt.Fprintf(s, "%s %d\x00", typeNames[typ], len(data))
+	s.Write(data)
+	copy(h[:], s.Sum(nil))
+	return h
+}
+
+func deflate(data []byte) []byte {
+	buf := bytes.NewBuffer(nil)
+	w := zlib.NewWriter(buf)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+// testRepository writes the objects of a test repository, loose or in a
+// packfile written by writePack.
+type testRepository struct {
+	c       *C
+	storage billy.Filesystem
+	packed  []*testObject
+}
+
+type testObject struct {
+	hash hash
+	typ  int
+	data []byte
+	// base is the index of the base of the object, stored as an ofs-delta,
+	// or as a ref-delta if refDelta is set.
+	base     int
+	refDelta bool
+}
+
+// put stores an object, in the packfile if pack is set, returning its hash.
+func (r *testRepository) put(typ int, data []byte, pack bool) hash {
+	h := objectHash(typ, data)
+	if pack {
+		r.packed = append(r.packed, &testObject{hash: h, typ: typ, data: data, base: -1})
+		return h
+	}
+
+	header := fmt.Sprintf("%s %d\x00", typeNames[typ], len(data))
+	content := deflate(append([]byte(header), data...))
+	r.c.Assert(util.WriteFile(r.storage, loosePath(h), content, 0644), IsNil)
+	return h
+}
+
+// putDelta stores a blob in the packfile as a delta of the blob with the
+// given hash, which has to be packed before.
+func (r *testRepository) putDelta(data []byte, base hash, refDelta bool) hash {
+	h := r.put(blobObject, data, true)
+	for i, o := range r.packed {
+		if o.hash == base {
+			r.packed[len(r.packed)-1].base = i
+			r.packed[len(r.packed)-1].refDelta = refDelta
+		}
+	}
+
+	return h
+}
+
+func (r *testRepository) blob(content string, pack bool) hash {
+	return r.put(blobObject, []byte(content), pack)
+}
+
+type testTreeEntry struct {
+	mode string
+	name string
+	hash hash
+}
+
+func (r *testRepository) tree(pack bool, entries ...testTreeEntry) hash {
+	buf := bytes.NewBuffer(nil)
+	for _, e := range entries {
+		fmt.Fprintf(buf, "%s %s\x00", e.mode, e.name)
+		buf.Write(e.hash[:])
+	}
+
+	return r.put(treeObject, buf.Bytes(), pack)
+}
+
+func (r *testRepository) commit(tree hash, when string, pack bool) hash {
+	data := fmt.Sprintf("tree %s\nauthor foo <foo@example.com> %s\ncommitter foo <foo@example.com> %s\n\nmessage\n", tree, when, when)
+	return r.put(commitObject, []byte(data), pack)
+}
+
+func (r *testRepository) tag(object hash, name string, pack bool) hash {
+	data := fmt.Sprintf("object %s\ntype commit\ntag %s\ntagger foo <foo@example.com> 0 +0000\n\nmessage\n", object, name)
+	return r.put(tagObject, []byte(data), pack)
+}
+
+func (r *testRepository) ref(name, value string) {
+	r.c.Assert(util.WriteFile(r.storage, name, []byte(value+"\n"), 0644), IsNil)
+}
+
+// writePack writes the packed objects in a packfile and its index, the
+// version 2 one.
+func (r *testRepository) writePack() {
+	pack := bytes.NewBuffer(nil)
+	pack.WriteString("PACK")
+	binary.Write(pack, binary.BigEndian, uint32(2))
+	binary.Write(pack, binary.BigEndian, uint32(len(r.packed)))
+
+	offsets := make([]int, len(r.packed))
+	crcs := make([]uint32, len(r.packed))
+	for i, o := range r.packed {
+		offsets[i] = pack.Len()
+
+		typ, data := o.typ, o.data
+		var extra []byte
+		if o.base != -1 {
+			base := r.packed[o.base]
+			data = delta(base.data, o.data)
+			if o.refDelta {
+				typ, extra = refDeltaObject, base.hash[:]
+			} else {
+				typ, extra = ofsDeltaObject, ofsOffset(offsets[i]-offsets[o.base])
+			}
+		}
+
+		entry := packHeader(typ, len(data))
+		entry = append(entry, extra...)
+		entry = append(entry, deflate(data)...)
+		crcs[i] = crc32.ChecksumIEEE(entry)
+		pack.Write(entry)
+	}
+
+	sum := sha1.Sum(pack.Bytes())
+	pack.Write(sum[:])
+
+	order := make([]int, len(r.packed))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.Slice(order, func(i, j int) bool {
+		return bytes.Compare(r.packed[order[i]].hash[:], r.packed[order[j]].hash[:]) < 0
+	})
+
+	idx := bytes.NewBuffer(nil)
+	idx.Write(indexMagic)
+	binary.Write(idx, binary.BigEndian, uint32(2))
+	for b := 0; b < 256; b++ {
+		var n uint32
+		for _, o := range r.packed {
+			if int(o.hash[0]) <= b {
+				n++
+			}
+		}
+
+		binary.Write(idx, binary.BigEndian, n)
+	}
+
+	for _, i := range order {
+		idx.Write(r.packed[i].hash[:])
+	}
+
+	for _, i := range order {
+		binary.Write(idx, binary.BigEndian, crcs[i])
+	}
+
+	for _, i := range order {
+		binary.Write(idx, binary.BigEndian, uint32(offsets[i]))
+	}
+
+	idx.Write(sum[:])
+	idxSum := sha1.Sum(idx.Bytes())
+	idx.Write(idxSum[:])
+
+	name := path.Join("objects", "pack", fmt.Sprintf("pack-%x", sum))
+	r.c.Assert(util.WriteFile(r.storage, name+".pack", pack.Bytes(), 0644), IsNil)
+	r.c.Assert(util.WriteFile(r.storage, name+".idx", idx.Bytes(), 0644), IsNil)
+}
+
+func packHeader(typ, size int) []byte {
+	header := []byte{byte(typ<<4) | byte(size&0x0f)}
+	for size >>= 4; size != 0; size >>= 7 {
+		header[len(header)-1] |= 0x80
+		header = append(header, byte(size&0x7f))
+	}
+
+	return header
+}
+
+func ofsOffset(rel int) []byte {
+	b := []byte{byte(rel & 0x7f)}
+	for rel >>= 7; rel != 0; rel >>= 7 {
+		rel--
+		b = append([]byte{0x80 | byte(rel&0x7f)}, b...)
+	}
+
+	return b
+}
+
+// delta returns a delta copying the common prefix of base and target, and
+// inserting the rest of the target.
+func delta(base, target []byte) []byte {
+	var n int
+	for n < len(base) && n < len(target) && n < 0xffff && base[n] == target[n] {
+		n++
+	}
+
+	var size [binary.MaxVarintLen64]byte
+	d := append([]byte(nil), size[:binary.PutUvarint(size[:], uint64(len(base)))]...)
+	d = append(d, size[:binary.PutUvarint(size[:], uint64(len(target)))]...)
+	if n != 0 {
+		d = append(d, 0x80|0x10|0x20, byte(n), byte(n>>8))
+	}
+
+	for rest := target[n:]; len(rest) != 0; {
+		chunk := rest
+		if len(chunk) > 0x7f {
+			chunk = chunk[:0x7f]
+		}
+
+		d = append(d, byte(len(chunk)))
+		d = append(d, chunk...)
+		rest = rest[len(chunk):]
+	}
+
+	return d
+}
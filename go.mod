@@ -1,7 +1,23 @@
 module gopkg.in/src-d/go-billy.v4
 
+go 1.18
+
 require (
-	github.com/kr/pretty v0.1.0 // indirect
-	golang.org/x/sys v0.0.0-20190726091711-fc99dfbffb4e
+	github.com/hirochachacha/go-smb2 v1.1.0
+	github.com/klauspost/compress v1.16.7
+	github.com/syndtr/goleveldb v1.0.0
+	github.com/ulikunitz/xz v0.5.12
+	go.etcd.io/bbolt v1.3.6
+	golang.org/x/crypto v0.0.0-20200728195943-123391ffb6de
+	golang.org/x/net v0.0.0-20190724013045-ca1201d0de80
+	golang.org/x/sys v0.10.0
+	golang.org/x/time v0.9.0
 	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127
 )
+
+require (
+	github.com/geoffgarside/ber v1.1.0 // indirect
+	github.com/golang/snappy v0.0.0-20180518054509-2e65f85255db // indirect
+	github.com/kr/pretty v0.1.0 // indirect
+	github.com/kr/text v0.1.0 // indirect
+)
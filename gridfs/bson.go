@@ -0,0 +1,319 @@
+package gridfs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+var errBSON = errors.New("gridfs: invalid BSON document")
+
+// element is a field of a BSON document.
+type element struct {
+	key   string
+	value interface{}
+}
+
+// document is a BSON document, the order of the fields is kept since the
+// name of a command must be its first field. The values are float64,
+// string, document, []interface{}, []byte, objectID, bool, time.Time, nil,
+// int32 or int64.
+type document []element
+
+// get returns the value of the given field, or nil if it doesn't exist.
+func (d document) get(key string) interface{} {
+	for _, e := range d {
+		if e.key == key {
+			return e.value
+		}
+	}
+
+	return nil
+}
+
+func (d document) str(key string) string {
+	s, _ := d.get(key).(string)
+	return s
+}
+
+func (d document) doc(key string) document {
+	v, _ := d.get(key).(document)
+	return v
+}
+
+func (d document) array(key string) []interface{} {
+	v, _ := d.get(key).([]interface{})
+	return v
+}
+
+func (d document) int(key string) int64 {
+	return toInt(d.get(key))
+}
+
+// toInt returns the given number as an int64, whatever its BSON type.
+func toInt(v interface{}) int64 {
+	switch n := v.(type) {
+	case int32:
+		return int64(n)
+	case int64:
+		return n
+	case float64:
+		return int64(n)
+	default:
+		return 0
+	}
+}
+
+// objectID is a BSON ObjectId, unique and increasing in a process.
+type objectID [12]byte
+
+var (
+	processUnique   [5]byte
+	objectIDCounter uint32
+)
+
+func init() {
+	var b [8]byte
+	rand.Read(processUnique[:])
+	rand.Read(b[:])
+	objectIDCounter = binary.BigEndian.Uint32(b[:])
+}
+
+func newObjectID() objectID {
+	var id objectID
+	binary.BigEndian.PutUint32(id[:], uint32(time.Now().Unix()))
+	copy(id[4:9], processUnique[:])
+	n := atomic.AddUint32(&objectIDCounter, 1)
+	id[9], id[10], id[11] = byte(n>>16), byte(n>>8), byte(n)
+	return id
+}
+
+func (id objectID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// marshal returns the BSON encoding of the given document.
+func marshal(d document) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeDocument(&buf, d, false); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeDocument(buf *bytes.Buffer, d document, array bool) error {
+	start := buf.Len()
+	buf.Write([]byte{0, 0, 0, 0})
+	for i, e := range d {
+		key := e.key
+		if array {
+			key = fmt.Sprint(i)
+		}
+
+		if err := writeElement(buf, key, e.value); err != nil {
+			return err
+		}
+	}
+
+	buf.WriteByte(0)
+	binary.LittleEndian.PutUint32(buf.Bytes()[start:], uint32(buf.Len()-start))
+	return nil
+}
+
+func writeElement(buf *bytes.Buffer, key string, value interface{}) error {
+	var b [8]byte
+	head := func(t byte) {
+		buf.WriteByte(t)
+		buf.WriteString(key)
+		buf.WriteByte(0)
+	}
+
+	switch v := value.(type) {
+	case float64:
+		head(0x01)
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+		buf.Write(b[:])
+	case string:
+		head(0x02)
+		binary.LittleEndian.PutUint32(b[:], uint32(len(v)+1))
+		buf.Write(b[:4])
+		buf.WriteString(v)
+		buf.WriteByte(0)
+	case document:
+		head(0x03)
+		return writeDocument(buf, v, false)
+	case []interface{}:
+		head(0x04)
+		d := make(document, len(v))
+		for i, item := range v {
+			d[i].value = item
+		}
+
+		return writeDocument(buf, d, true)
+	case []byte:
+		head(0x05)
+		binary.LittleEndian.PutUint32(b[:], uint32(len(v)))
+		buf.Write(b[:4])
+		buf.WriteByte(0)
+		buf.Write(v)
+	case objectID:
+		head(0x07)
+		buf.Write(v[:])
+	case bool:
+		head(0x08)
+		if v {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	case time.Time:
+		head(0x09)
+		binary.LittleEndian.PutUint64(b[:], uint64(v.UnixNano()/int64(time.Millisecond)))
+		buf.Write(b[:])
+	case nil:
+		head(0x0A)
+	case int32:
+		head(0x10)
+		binary.LittleEndian.PutUint32(b[:], uint32(v))
+		buf.Write(b[:4])
+	case int:
+		head(0x12)
+		binary.LittleEndian.PutUint64(b[:], uint64(v))
+		buf.Write(b[:])
+	case int64:
+		head(0x12)
+		binary.LittleEndian.PutUint64(b[:], uint64(v))
+		buf.Write(b[:])
+	default:
+		return fmt.Errorf("gridfs: unsupported BSON type %T", value)
+	}
+
+	return nil
+}
+
+// unmarshal decodes the given BSON document.
+func unmarshal(b []byte) (document, error) {
+	d, rest, err := readDocument(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rest) != 0 {
+		return nil, errBSON
+	}
+
+	return d, nil
+}
+
+// readDocument decodes the document at the beginning of b, returning the
+// rest.
+func readDocument(b []byte) (document, []byte, error) {
+	if len(b) < 5 {
+		return nil, nil, errBSON
+	}
+
+	size := int(binary.LittleEndian.Uint32(b))
+	if size < 5 || size > len(b) || b[size-1] != 0 {
+		return nil, nil, errBSON
+	}
+
+	rest := b[size:]
+	b = b[4 : size-1]
+
+	var d document
+	for len(b) != 0 {
+		t := b[0]
+		i := bytes.IndexByte(b[1:], 0)
+		if i < 0 {
+			return nil, nil, errBSON
+		}
+
+		key := string(b[1 : i+1])
+		value, next, err := readValue(t, b[i+2:])
+		if err != nil {
+			return nil, nil, err
+		}
+
+		d = append(d, element{key: key, value: value})
+		b = next
+	}
+
+	return d, rest, nil
+}
+
+// fixedSizes are the sizes of the values of the BSON types with a fixed size.
+var fixedSizes = map[byte]int{0x01: 8, 0x07: 12, 0x08: 1, 0x09: 8, 0x10: 4, 0x11: 8, 0x12: 8}
+
+func readValue(t byte, b []byte) (interface{}, []byte, error) {
+	if n, ok := fixedSizes[t]; ok && len(b) < n {
+		return nil, nil, errBSON
+	}
+
+	switch t {
+	case 0x01:
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), b[8:], nil
+	case 0x02:
+		if len(b) < 4 {
+			return nil, nil, errBSON
+		}
+
+		n := int(binary.LittleEndian.Uint32(b))
+		if n < 1 || len(b) < 4+n || b[3+n] != 0 {
+			return nil, nil, errBSON
+		}
+
+		return string(b[4 : 3+n]), b[4+n:], nil
+	case 0x03:
+		return readDocument(b)
+	case 0x04:
+		d, rest, err := readDocument(b)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		values := make([]interface{}, len(d))
+		for i, e := range d {
+			values[i] = e.value
+		}
+
+		return values, rest, nil
+	case 0x05:
+		if len(b) < 5 {
+			return nil, nil, errBSON
+		}
+
+		n := int(binary.LittleEndian.Uint32(b))
+		if n < 0 || len(b) < 5+n {
+			return nil, nil, errBSON
+		}
+
+		return append([]byte(nil), b[5:5+n]...), b[5+n:], nil
+	case 0x07:
+		var id objectID
+		copy(id[:], b)
+		return id, b[12:], nil
+	case 0x08:
+		return b[0] != 0, b[1:], nil
+	case 0x09:
+		ms := int64(binary.LittleEndian.Uint64(b))
+		return time.Unix(0, ms*int64(time.Millisecond)), b[8:], nil
+	case 0x0A:
+		return nil, b, nil
+	case 0x10:
+		return int32(binary.LittleEndian.Uint32(b)), b[4:], nil
+	case 0x11:
+		// timestamps, only used internally by the server
+		return int64(binary.LittleEndian.Uint64(b)), b[8:], nil
+	case 0x12:
+		return int64(binary.LittleEndian.Uint64(b)), b[8:], nil
+	default:
+		return nil, nil, fmt.Errorf("gridfs: unsupported BSON type 0x%02x", t)
+	}
+}
@@ -0,0 +1,241 @@
+package gridfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"time"
+)
+
+var errInvalidFile = errors.New("gridfs: invalid file document")
+
+// fileDoc is a document of the files collection, a revision of a file or a
+// directory.
+type fileDoc struct {
+	id         interface{}
+	filename   string
+	length     int64
+	chunkSize  int64
+	uploadDate time.Time
+	mode       os.FileMode
+	dir        bool
+}
+
+// newFileDoc returns a new empty revision of the file at the given path.
+func (fs *GridFS) newFileDoc(p string, mode os.FileMode) *fileDoc {
+	return &fileDoc{
+		id:         newObjectID(),
+		filename:   filename(p),
+		chunkSize:  int64(fs.opts.ChunkSize),
+		uploadDate: time.Now().Truncate(time.Millisecond),
+		mode:       mode,
+	}
+}
+
+// encode returns the document, with the parent directory and mode in its
+// metadata.
+func (d *fileDoc) encode() document {
+	metadata := document{
+		{"parent", filename(path.Dir("/" + d.filename))},
+		{"mode", int64(d.mode)},
+	}
+
+	if d.dir {
+		metadata = append(metadata, element{"directory", true})
+	}
+
+	return document{
+		{"_id", d.id},
+		{"length", d.length},
+		{"chunkSize", int32(d.chunkSize)},
+		{"uploadDate", d.uploadDate},
+		{"filename", d.filename},
+		{"metadata", metadata},
+	}
+}
+
+// parseFileDoc parses a document of the files collection. The files
+// uploaded by other clients, without mode, are regular files with mode
+// 0644.
+func parseFileDoc(doc document) (*fileDoc, error) {
+	d := &fileDoc{
+		id:        doc.get("_id"),
+		filename:  doc.str("filename"),
+		length:    doc.int("length"),
+		chunkSize: doc.int("chunkSize"),
+	}
+
+	d.uploadDate, _ = doc.get("uploadDate").(time.Time)
+	metadata := doc.doc("metadata")
+	d.dir, _ = metadata.get("directory").(bool)
+	d.mode = os.FileMode(metadata.int("mode"))
+
+	switch {
+	case d.mode == 0 && d.dir:
+		d.mode = os.ModeDir | 0755
+	case d.mode == 0:
+		d.mode = 0644
+	}
+
+	if d.length < 0 || d.chunkSize <= 0 && d.length != 0 || d.chunkSize > maxChunkSize {
+		return nil, errInvalidFile
+	}
+
+	return d, nil
+}
+
+func (fs *GridFS) filesCollection() string {
+	return fs.opts.Bucket + ".files"
+}
+
+func (fs *GridFS) chunksCollection() string {
+	return fs.opts.Bucket + ".chunks"
+}
+
+// find returns all the documents of the given collection matching the
+// filter, in the given order, if any, up to limit documents if not zero.
+func (fs *GridFS) find(coll string, filter, sort document, limit int) ([]document, error) {
+	cmd := document{{"find", coll}, {"filter", filter}}
+	if sort != nil {
+		cmd = append(cmd, element{"sort", sort})
+	}
+
+	if limit > 0 {
+		cmd = append(cmd, element{"limit", int64(limit)}, element{"singleBatch", true})
+	}
+
+	reply, err := fs.command(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor := reply.doc("cursor")
+	batch := cursor.array("firstBatch")
+	var docs []document
+	for {
+		for _, v := range batch {
+			d, ok := v.(document)
+			if !ok {
+				return nil, errProtocol
+			}
+
+			docs = append(docs, d)
+		}
+
+		id := cursor.int("id")
+		if id == 0 {
+			return docs, nil
+		}
+
+		reply, err = fs.command(document{{"getMore", id}, {"collection", coll}})
+		if err != nil {
+			return nil, err
+		}
+
+		cursor = reply.doc("cursor")
+		batch = cursor.array("nextBatch")
+	}
+}
+
+// write runs a command modifying the bucket, creating its indexes first.
+func (fs *GridFS) write(cmd document) error {
+	if err := fs.ensureIndexes(); err != nil {
+		return err
+	}
+
+	_, err := fs.command(cmd)
+	return err
+}
+
+func (fs *GridFS) insert(coll string, doc document) error {
+	return fs.write(document{{"insert", coll}, {"documents", []interface{}{doc}}})
+}
+
+func (fs *GridFS) delete(coll string, filter document) error {
+	return fs.write(document{{"delete", coll}, {"deletes", []interface{}{
+		document{{"q", filter}, {"limit", int32(0)}},
+	}}})
+}
+
+// ensureIndexes creates the indexes of the bucket, the ones created by the
+// other GridFS clients, and the one listing the directories, once.
+func (fs *GridFS) ensureIndexes() error {
+	fs.mu.Lock()
+	indexed := fs.indexed
+	fs.mu.Unlock()
+
+	if indexed {
+		return nil
+	}
+
+	indexes := []struct {
+		coll  string
+		index document
+	}{
+		{fs.filesCollection(), document{
+			{"key", document{{"filename", int32(1)}, {"uploadDate", int32(1)}}},
+			{"name", "filename_1_uploadDate_1"},
+		}},
+		{fs.filesCollection(), document{
+			{"key", document{{"metadata.parent", int32(1)}, {"filename", int32(1)}}},
+			{"name", "metadata.parent_1_filename_1"},
+		}},
+		{fs.chunksCollection(), document{
+			{"key", document{{"files_id", int32(1)}, {"n", int32(1)}}},
+			{"name", "files_id_1_n_1"},
+			{"unique", true},
+		}},
+	}
+
+	for _, i := range indexes {
+		if _, err := fs.command(document{
+			{"createIndexes", i.coll},
+			{"indexes", []interface{}{i.index}},
+		}); err != nil {
+			return err
+		}
+	}
+
+	fs.mu.Lock()
+	fs.indexed = true
+	fs.mu.Unlock()
+	return nil
+}
+
+// readChunk returns the nth chunk of the given file.
+func (fs *GridFS) readChunk(id interface{}, n int64) ([]byte, error) {
+	docs, err := fs.find(fs.chunksCollection(), document{{"files_id", id}, {"n", int32(n)}}, nil, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("gridfs: missing chunk %d", n)
+	}
+
+	data, ok := docs[0].get("data").([]byte)
+	if !ok {
+		return nil, errInvalidFile
+	}
+
+	return data, nil
+}
+
+// writeChunk writes the nth chunk of the given file, replacing it if it
+// was already written.
+func (fs *GridFS) writeChunk(id interface{}, n int64, data []byte) error {
+	return fs.write(document{{"update", fs.chunksCollection()}, {"updates", []interface{}{document{
+		{"q", document{{"files_id", id}, {"n", int32(n)}}},
+		{"u", document{{"files_id", id}, {"n", int32(n)}, {"data", data}}},
+		{"upsert", true},
+	}}}})
+}
+
+// truncateChunks removes the chunks of the given file from the nth one.
+func (fs *GridFS) truncateChunks(id interface{}, n int64) error {
+	return fs.delete(fs.chunksCollection(), document{
+		{"files_id", id},
+		{"n", document{{"$gte", int32(n)}}},
+	})
+}
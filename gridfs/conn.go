@@ -0,0 +1,250 @@
+package gridfs
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// opMsg is the opcode of the OP_MSG messages of the wire protocol.
+const opMsg = 2013
+
+// maxMessageSize is the maximum size of the messages of the server.
+const maxMessageSize = 48 << 20
+
+var (
+	errProtocol = errors.New("gridfs: protocol error")
+	errAuth     = errors.New("gridfs: invalid signature of the server")
+)
+
+// Error is an error returned by the server.
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("gridfs: %s (code %d)", e.Message, e.Code)
+}
+
+// conn is a connection to a MongoDB server, speaking the OP_MSG protocol.
+type conn struct {
+	netConn   net.Conn
+	r         *bufio.Reader
+	timeout   time.Duration
+	requestID int32
+}
+
+// dial opens a new connection, authenticating with SCRAM-SHA-256 if there
+// are credentials.
+func dial(addr string, opts *Options) (*conn, error) {
+	nc, err := net.DialTimeout("tcp", addr, opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.TLS != nil {
+		nc = tls.Client(nc, opts.TLS)
+	}
+
+	c := &conn{netConn: nc, r: bufio.NewReader(nc), timeout: opts.Timeout}
+	if opts.Username != "" {
+		if err := c.authenticate(opts); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// command runs the given command in the given database, returning its
+// reply. The errors of the command, and the first error of the writes, are
+// returned as an *Error.
+func (c *conn) command(db string, cmd document) (document, error) {
+	body, err := marshal(append(cmd, element{"$db", db}))
+	if err != nil {
+		return nil, err
+	}
+
+	c.requestID++
+	msg := make([]byte, 21, 21+len(body))
+	binary.LittleEndian.PutUint32(msg, uint32(21+len(body)))
+	binary.LittleEndian.PutUint32(msg[4:], uint32(c.requestID))
+	binary.LittleEndian.PutUint32(msg[12:], opMsg)
+	// flags and the kind of the section, a body, are zero
+	msg = append(msg, body...)
+
+	if c.timeout > 0 {
+		c.netConn.SetDeadline(time.Now().Add(c.timeout))
+	}
+
+	if _, err := c.netConn.Write(msg); err != nil {
+		return nil, err
+	}
+
+	reply, err := c.receive()
+	if err != nil {
+		return nil, err
+	}
+
+	if toInt(reply.get("ok")) != 1 {
+		return nil, &Error{Code: int(reply.int("code")), Message: reply.str("errmsg")}
+	}
+
+	if errs := reply.array("writeErrors"); len(errs) != 0 {
+		e, _ := errs[0].(document)
+		return nil, &Error{Code: int(e.int("code")), Message: e.str("errmsg")}
+	}
+
+	return reply, nil
+}
+
+// receive reads an OP_MSG message, returning its body.
+func (c *conn) receive() (document, error) {
+	var header [21]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return nil, err
+	}
+
+	size := int(binary.LittleEndian.Uint32(header[:]))
+	if binary.LittleEndian.Uint32(header[12:]) != opMsg || size < len(header) || size > maxMessageSize {
+		return nil, errProtocol
+	}
+
+	if responseTo := int32(binary.LittleEndian.Uint32(header[8:])); responseTo != c.requestID {
+		return nil, errProtocol
+	}
+
+	// the checksum flag, the only one expected, adds 4 bytes after the body
+	if flags := binary.LittleEndian.Uint32(header[16:]); flags&^1 != 0 || header[20] != 0 {
+		return nil, errProtocol
+	}
+
+	body := make([]byte, size-len(header))
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return nil, err
+	}
+
+	d, rest, err := readDocument(body)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rest) != 0 && len(rest) != 4 {
+		return nil, errProtocol
+	}
+
+	return d, nil
+}
+
+func (c *conn) close() error {
+	return c.netConn.Close()
+}
+
+// authenticate performs the SCRAM-SHA-256 conversation, RFC 7677.
+func (c *conn) authenticate(opts *Options) error {
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return err
+	}
+
+	user := strings.NewReplacer("=", "=3D", ",", "=2C").Replace(opts.Username)
+	clientNonce := base64.StdEncoding.EncodeToString(nonce[:])
+	clientFirst := "n=" + user + ",r=" + clientNonce
+
+	reply, err := c.command(opts.AuthSource, document{
+		{"saslStart", int32(1)},
+		{"mechanism", "SCRAM-SHA-256"},
+		{"payload", []byte("n,," + clientFirst)},
+		{"options", document{{"skipEmptyExchange", true}}},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	serverFirst, _ := reply.get("payload").([]byte)
+	fields := scramFields(string(serverFirst))
+	salt, err := base64.StdEncoding.DecodeString(fields["s"])
+	if err != nil {
+		return errProtocol
+	}
+
+	iterations, err := strconv.Atoi(fields["i"])
+	if err != nil || iterations <= 0 || !strings.HasPrefix(fields["r"], clientNonce) {
+		return errProtocol
+	}
+
+	clientFinal := "c=biws,r=" + fields["r"]
+	authMessage := clientFirst + "," + string(serverFirst) + "," + clientFinal
+	clientProof, serverSignature := scramProof(opts.Password, salt, iterations, authMessage)
+
+	reply, err = c.command(opts.AuthSource, document{
+		{"saslContinue", int32(1)},
+		{"conversationId", reply.get("conversationId")},
+		{"payload", []byte(clientFinal + ",p=" + base64.StdEncoding.EncodeToString(clientProof))},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	serverFinal, _ := reply.get("payload").([]byte)
+	signature, err := base64.StdEncoding.DecodeString(scramFields(string(serverFinal))["v"])
+	if err != nil || !hmac.Equal(signature, serverSignature) {
+		return errAuth
+	}
+
+	if done, _ := reply.get("done").(bool); !done {
+		return errProtocol
+	}
+
+	return nil
+}
+
+// scramProof returns the proof of the client and the expected signature of
+// the server, for the given password and authentication message.
+func scramProof(password string, salt []byte, iterations int, authMessage string) (proof, signature []byte) {
+	salted := pbkdf2.Key([]byte(password), salt, iterations, sha256.Size, sha256.New)
+	clientKey := hmacSHA256(salted, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+
+	proof = hmacSHA256(storedKey[:], authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+
+	return proof, hmacSHA256(hmacSHA256(salted, "Server Key"), authMessage)
+}
+
+func hmacSHA256(key []byte, msg string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(msg))
+	return h.Sum(nil)
+}
+
+// scramFields parses the attributes of a SCRAM message, eg.: "r=...,s=...".
+func scramFields(msg string) map[string]string {
+	fields := make(map[string]string)
+	for _, f := range strings.Split(msg, ",") {
+		if len(f) > 2 && f[1] == '=' {
+			fields[f[:1]] = f[2:]
+		}
+	}
+
+	return fields
+}
@@ -0,0 +1,429 @@
+package gridfs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// file is a file of a GridFS filesystem. Its content is streamed a chunk at
+// a time: the chunk accessed is kept in memory, and written when another
+// one is accessed. The chunks written belong to a new revision, uploaded
+// when the file is closed, copying the chunks not written from the previous
+// one.
+type file struct {
+	fs   *GridFS
+	name string
+	path string
+	flag int
+	doc  *fileDoc
+
+	// base is the revision read, nil if truncated.
+	base *fileDoc
+	// valid is the length of the content of base still valid, once
+	// truncated.
+	valid int64
+	// rev is the revision written, nil if the file isn't writable.
+	rev *fileDoc
+	// written are the lengths of the chunks of rev written.
+	written  map[int64]int64
+	size     int64
+	modified bool
+
+	// chunk is the index of the chunk in data, or -1.
+	chunk int64
+	data  []byte
+	dirty bool
+
+	position int64
+	isClosed bool
+}
+
+func newFile(fs *GridFS, name, p string, flag int, d *fileDoc) *file {
+	f := &file{
+		fs:    fs,
+		name:  name,
+		path:  p,
+		flag:  flag,
+		doc:   d,
+		base:  d,
+		valid: d.length,
+		size:  d.length,
+		chunk: -1,
+	}
+
+	if !isWrite(flag) {
+		return f
+	}
+
+	f.rev = &fileDoc{id: newObjectID(), filename: d.filename, chunkSize: d.chunkSize, mode: d.mode}
+	if d.length == 0 {
+		f.rev.chunkSize = int64(fs.opts.ChunkSize)
+	}
+
+	f.written = make(map[int64]int64)
+	if flag&os.O_TRUNC != 0 && d.length != 0 {
+		f.base, f.valid, f.size, f.modified = nil, 0, 0, true
+	}
+
+	return f
+}
+
+func (f *file) Name() string {
+	return f.name
+}
+
+func (f *file) chunkSize() int64 {
+	if f.rev != nil {
+		return f.rev.chunkSize
+	}
+
+	return f.base.chunkSize
+}
+
+// load loads the nth chunk, writing the one loaded before if modified.
+func (f *file) load(n int64) error {
+	if f.chunk == n {
+		return nil
+	}
+
+	if err := f.flush(); err != nil {
+		return err
+	}
+
+	data, err := f.readChunk(n)
+	if err != nil {
+		return err
+	}
+
+	f.chunk, f.data = n, data
+	return nil
+}
+
+// readChunk returns the nth chunk, from the new revision if written, or
+// else from the base one, fit to the size of the file.
+func (f *file) readChunk(n int64) ([]byte, error) {
+	cs := f.chunkSize()
+	start := n * cs
+	size := f.size - start
+	switch {
+	case size < 0:
+		size = 0
+	case size > cs:
+		size = cs
+	}
+
+	var data []byte
+	var err error
+	switch {
+	case f.written[n] != 0:
+		data, err = f.fs.readChunk(f.rev.id, n)
+	case f.base != nil && start < f.valid:
+		data, err = f.fs.readChunk(f.base.id, n)
+		if err == nil && int64(len(data)) > f.valid-start {
+			data = data[:f.valid-start]
+		}
+	}
+
+	if err != nil {
+		return nil, &os.PathError{Op: "read", Path: f.name, Err: err}
+	}
+
+	if int64(len(data)) > size {
+		return data[:size], nil
+	}
+
+	return append(data, make([]byte, size-int64(len(data)))...), nil
+}
+
+// flush writes the chunk loaded, if modified.
+func (f *file) flush() error {
+	if !f.dirty {
+		return nil
+	}
+
+	if err := f.fs.writeChunk(f.rev.id, f.chunk, f.data); err != nil {
+		return &os.PathError{Op: "write", Path: f.name, Err: err}
+	}
+
+	f.written[f.chunk] = int64(len(f.data))
+	f.dirty = false
+	return nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.position)
+	f.position += int64(n)
+
+	if err == io.EOF && n != 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	if f.flag&os.O_WRONLY != 0 {
+		return 0, errors.New("read not supported")
+	}
+
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: f.name, Err: errors.New("negative offset")}
+	}
+
+	var n int
+	for n < len(p) && off < f.size {
+		cs := f.chunkSize()
+		if err := f.load(off / cs); err != nil {
+			return n, err
+		}
+
+		c := copy(p[n:], f.data[off%cs:])
+		n += c
+		off += int64(c)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.position
+	case io.SeekEnd:
+		offset += f.size
+	}
+
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: errors.New("negative offset")}
+	}
+
+	f.position = offset
+	return f.position, nil
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	if !isWrite(f.flag) {
+		return 0, errors.New("write not supported")
+	}
+
+	if f.flag&os.O_APPEND != 0 {
+		f.position = f.size
+	}
+
+	cs := f.chunkSize()
+	var n int
+	for n < len(p) {
+		if err := f.load(f.position / cs); err != nil {
+			return n, err
+		}
+
+		off := f.position % cs
+		end := off + int64(len(p)-n)
+		if end > cs {
+			end = cs
+		}
+
+		if int64(len(f.data)) < end {
+			f.data = append(f.data, make([]byte, end-int64(len(f.data)))...)
+		}
+
+		c := copy(f.data[off:end], p[n:])
+		n += c
+		f.position += int64(c)
+		f.dirty, f.modified = true, true
+		if f.position > f.size {
+			f.size = f.position
+		}
+	}
+
+	return n, nil
+}
+
+func (f *file) Truncate(size int64) error {
+	if f.isClosed {
+		return os.ErrClosed
+	}
+
+	if !isWrite(f.flag) {
+		return errors.New("truncate not supported")
+	}
+
+	if size < 0 {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: errors.New("negative size")}
+	}
+
+	if err := f.truncate(size); err != nil {
+		return err
+	}
+
+	f.modified = true
+	return nil
+}
+
+func (f *file) truncate(size int64) error {
+	if size >= f.size {
+		// the chunks are fit to the new size when loaded again
+		if err := f.flush(); err != nil {
+			return err
+		}
+
+		f.chunk, f.data, f.size = -1, nil, size
+		return nil
+	}
+
+	cs := f.chunkSize()
+	last := size / cs
+	if err := f.load(last); err != nil {
+		return err
+	}
+
+	f.data = f.data[:size%cs]
+	f.dirty = len(f.data) != 0
+	for n := range f.written {
+		if n > last || n == last && !f.dirty {
+			delete(f.written, n)
+		}
+	}
+
+	if f.valid > size {
+		f.valid = size
+	}
+
+	f.size = size
+	return nil
+}
+
+// Close uploads the new revision of the file if it was modified, removing
+// the previous ones.
+func (f *file) Close() error {
+	if f.isClosed {
+		return os.ErrClosed
+	}
+
+	f.isClosed = true
+
+	var err error
+	if f.modified {
+		if err = f.commit(); err != nil {
+			f.fs.truncateChunks(f.rev.id, 0)
+		}
+	}
+
+	f.data = nil
+	return err
+}
+
+func (f *file) commit() error {
+	if err := f.flush(); err != nil {
+		return err
+	}
+
+	cs := f.chunkSize()
+	chunks := (f.size + cs - 1) / cs
+	for n := int64(0); n < chunks; n++ {
+		size := f.size - n*cs
+		if size > cs {
+			size = cs
+		}
+
+		if f.written[n] == size {
+			continue
+		}
+
+		data, err := f.readChunk(n)
+		if err != nil {
+			return err
+		}
+
+		if err := f.fs.writeChunk(f.rev.id, n, data); err != nil {
+			return &os.PathError{Op: "write", Path: f.name, Err: err}
+		}
+	}
+
+	// the chunks written before a truncate
+	if err := f.fs.truncateChunks(f.rev.id, chunks); err != nil {
+		return &os.PathError{Op: "write", Path: f.name, Err: err}
+	}
+
+	f.rev.length = f.size
+	f.rev.uploadDate = time.Now().Truncate(time.Millisecond)
+	if err := f.fs.insert(f.fs.filesCollection(), f.rev.encode()); err != nil {
+		return &os.PathError{Op: "write", Path: f.name, Err: err}
+	}
+
+	if err := f.fs.removeRevisions(f.rev.filename, f.rev); err != nil {
+		return &os.PathError{Op: "write", Path: f.name, Err: err}
+	}
+
+	return nil
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	fi := newFileInfo(filepath.Base(f.name), f.doc)
+	fi.size = f.size
+	return fi, nil
+}
+
+// Lock is a no-op in gridfs.
+func (f *file) Lock() error {
+	return nil
+}
+
+// Unlock is a no-op in gridfs.
+func (f *file) Unlock() error {
+	return nil
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func newFileInfo(name string, d *fileDoc) *fileInfo {
+	return &fileInfo{name: name, size: d.length, mode: d.mode, modTime: d.uploadDate}
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.size
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	return fi.mode
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.mode.IsDir()
+}
+
+func (*fileInfo) Sys() interface{} {
+	return nil
+}
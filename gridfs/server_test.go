@@ -0,0 +1,492 @@
+package gridfs
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// server is a minimal in-memory MongoDB server, to test the client. It only
+// supports the OP_MSG messages, the SCRAM-SHA-256 authentication, and the
+// commands and query operators used by the filesystem, without indexes.
+type server struct {
+	ln                 net.Listener
+	username, password string
+	batchSize          int
+
+	mu          sync.Mutex
+	collections map[string][]document
+	cursors     map[int64][]document
+	lastCursor  int64
+	commands    []string
+}
+
+func newServer() (*server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &server{
+		ln:          ln,
+		batchSize:   101,
+		collections: make(map[string][]document),
+		cursors:     make(map[int64][]document),
+	}
+
+	go s.serve()
+	return s, nil
+}
+
+func (s *server) URL() string {
+	return "mongodb://" + s.ln.Addr().String() + "/test"
+}
+
+func (s *server) Close() error {
+	return s.ln.Close()
+}
+
+func (s *server) count(command string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int
+	for _, c := range s.commands {
+		if c == command {
+			n++
+		}
+	}
+
+	return n
+}
+
+// find returns the documents of the given collection, eg.: "test.fs.files",
+// matching the given filter.
+func (s *server) find(coll string, filter document) []document {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var docs []document
+	for _, d := range s.collections[coll] {
+		if match(d, filter) {
+			docs = append(docs, d)
+		}
+	}
+
+	return docs
+}
+
+// insert inserts the given document, as done by other clients.
+func (s *server) insert(coll string, d document) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.collections[coll] = append(s.collections[coll], d)
+}
+
+func (s *server) serve() {
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		go s.session(nc)
+	}
+}
+
+// conversation is the state of a SCRAM conversation.
+type conversation struct {
+	salt        []byte
+	nonce       string
+	authMessage string
+}
+
+func (s *server) session(nc net.Conn) {
+	defer nc.Close()
+
+	r := bufio.NewReader(nc)
+	authenticated := s.username == ""
+
+	var conv *conversation
+	for {
+		var header [21]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return
+		}
+
+		body := make([]byte, binary.LittleEndian.Uint32(header[:])-21)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return
+		}
+
+		cmd, err := unmarshal(body)
+		if err != nil || len(cmd) == 0 {
+			return
+		}
+
+		name := cmd[0].key
+		s.mu.Lock()
+		s.commands = append(s.commands, name)
+		s.mu.Unlock()
+
+		var reply document
+		switch {
+		case name == "saslStart":
+			reply, conv = s.saslStart(cmd)
+		case name == "saslContinue":
+			reply = s.saslContinue(cmd, conv)
+			authenticated = toInt(reply.get("ok")) == 1
+		case !authenticated:
+			reply = errorReply(13, "command "+name+" requires authentication")
+		default:
+			s.mu.Lock()
+			reply = s.exec(cmd.str("$db"), cmd)
+			s.mu.Unlock()
+		}
+
+		out, err := marshal(reply)
+		if err != nil {
+			panic(err)
+		}
+
+		msg := make([]byte, 21, 21+len(out))
+		binary.LittleEndian.PutUint32(msg, uint32(21+len(out)))
+		copy(msg[8:12], header[4:8])
+		binary.LittleEndian.PutUint32(msg[12:], opMsg)
+		if _, err := nc.Write(append(msg, out...)); err != nil {
+			return
+		}
+	}
+}
+
+func errorReply(code int32, msg string) document {
+	return document{{"ok", float64(0)}, {"errmsg", msg}, {"code", code}}
+}
+
+func (s *server) saslStart(cmd document) (document, *conversation) {
+	payload, _ := cmd.get("payload").([]byte)
+	if cmd.str("mechanism") != "SCRAM-SHA-256" || !bytes.HasPrefix(payload, []byte("n,,")) {
+		return errorReply(2, "invalid SASL request"), nil
+	}
+
+	clientFirst := string(payload[3:])
+	fields := scramFields(clientFirst)
+	if fields["n"] != s.username {
+		return errorReply(18, "Authentication failed."), nil
+	}
+
+	nonce := make([]byte, 18)
+	rand.Read(nonce)
+	conv := &conversation{salt: make([]byte, 16)}
+	rand.Read(conv.salt)
+	conv.nonce = fields["r"] + base64.StdEncoding.EncodeToString(nonce)
+
+	serverFirst := "r=" + conv.nonce + ",s=" + base64.StdEncoding.EncodeToString(conv.salt) + ",i=4096"
+	conv.authMessage = clientFirst + "," + serverFirst
+	return document{
+		{"conversationId", int32(1)},
+		{"done", false},
+		{"payload", []byte(serverFirst)},
+		{"ok", float64(1)},
+	}, conv
+}
+
+func (s *server) saslContinue(cmd document, conv *conversation) document {
+	payload, _ := cmd.get("payload").([]byte)
+	if conv == nil {
+		return errorReply(17, "No SASL session state found")
+	}
+
+	msg := string(payload)
+	i := strings.LastIndex(msg, ",p=")
+	if i < 0 || msg[:i] != "c=biws,r="+conv.nonce {
+		return errorReply(18, "Authentication failed.")
+	}
+
+	proof, signature := scramProof(s.password, conv.salt, 4096, conv.authMessage+","+msg[:i])
+	if msg[i+3:] != base64.StdEncoding.EncodeToString(proof) {
+		return errorReply(18, "Authentication failed.")
+	}
+
+	return document{
+		{"conversationId", int32(1)},
+		{"done", true},
+		{"payload", []byte("v=" + base64.StdEncoding.EncodeToString(signature))},
+		{"ok", float64(1)},
+	}
+}
+
+func (s *server) exec(db string, cmd document) document {
+	name := cmd[0].key
+	ok := document{{"ok", float64(1)}}
+	switch name {
+	case "find":
+		coll := db + "." + cmd.str("find")
+		var docs []document
+		for _, d := range s.collections[coll] {
+			if match(d, cmd.doc("filter")) {
+				docs = append(docs, d)
+			}
+		}
+
+		sortDocuments(docs, cmd.doc("sort"))
+		if limit := int(cmd.int("limit")); limit > 0 && len(docs) > limit {
+			docs = docs[:limit]
+		}
+
+		return s.batch(docs, coll, "firstBatch")
+	case "getMore":
+		id := cmd.int("getMore")
+		docs, found := s.cursors[id]
+		if !found {
+			return errorReply(43, "cursor id not found")
+		}
+
+		delete(s.cursors, id)
+		return s.batch(docs, db+"."+cmd.str("collection"), "nextBatch")
+	case "insert":
+		coll := db + "." + cmd.str("insert")
+		for _, v := range cmd.array("documents") {
+			s.collections[coll] = append(s.collections[coll], v.(document))
+		}
+
+		return append(ok, element{"n", int32(len(cmd.array("documents")))})
+	case "update":
+		coll := db + "." + cmd.str("update")
+		var n int32
+		for _, v := range cmd.array("updates") {
+			n += s.update(coll, v.(document))
+		}
+
+		return append(ok, element{"n", n})
+	case "delete":
+		coll := db + "." + cmd.str("delete")
+		var n int32
+		for _, v := range cmd.array("deletes") {
+			var kept []document
+			for _, d := range s.collections[coll] {
+				if match(d, v.(document).doc("q")) {
+					n++
+				} else {
+					kept = append(kept, d)
+				}
+			}
+
+			s.collections[coll] = kept
+		}
+
+		return append(ok, element{"n", n})
+	case "createIndexes":
+		return ok
+	default:
+		return errorReply(59, "no such command: '"+name+"'")
+	}
+}
+
+// batch returns a reply with a batch of the given documents, and a cursor
+// for the rest.
+func (s *server) batch(docs []document, coll, field string) document {
+	var id int64
+	if len(docs) > s.batchSize {
+		s.lastCursor++
+		id = s.lastCursor
+		s.cursors[id] = docs[s.batchSize:]
+		docs = docs[:s.batchSize]
+	}
+
+	batch := make([]interface{}, len(docs))
+	for i, d := range docs {
+		batch[i] = d
+	}
+
+	return document{
+		{"cursor", document{{"id", id}, {"ns", coll}, {field, batch}}},
+		{"ok", float64(1)},
+	}
+}
+
+func (s *server) update(coll string, u document) int32 {
+	filter, update := u.doc("q"), u.doc("u")
+	multi, _ := u.get("multi").(bool)
+	upsert, _ := u.get("upsert").(bool)
+
+	var n int32
+	docs := s.collections[coll]
+	for i, d := range docs {
+		if !match(d, filter) {
+			continue
+		}
+
+		docs[i] = apply(d, update)
+		if n++; !multi {
+			break
+		}
+	}
+
+	if n == 0 && upsert {
+		d := document{{"_id", newObjectID()}}
+		for _, e := range filter {
+			if _, isDoc := e.value.(document); !isDoc && !strings.HasPrefix(e.key, "$") {
+				d = append(d, e)
+			}
+		}
+
+		s.collections[coll] = append(docs, apply(d, update))
+		n = 1
+	}
+
+	return n
+}
+
+// apply returns the document with the given update applied, either a
+// replacement document or a $set.
+func apply(d, update document) document {
+	set := update.doc("$set")
+	if set == nil {
+		return append(document{{"_id", d.get("_id")}}, update...)
+	}
+
+	d = append(document(nil), d...)
+	for _, e := range set {
+		d = setPath(d, strings.Split(e.key, "."), e.value)
+	}
+
+	return d
+}
+
+func setPath(d document, path []string, value interface{}) document {
+	if len(path) > 1 {
+		value = setPath(append(document(nil), d.doc(path[0])...), path[1:], value)
+	}
+
+	for i, e := range d {
+		if e.key == path[0] {
+			d[i].value = value
+			return d
+		}
+	}
+
+	return append(d, element{path[0], value})
+}
+
+// lookup returns the value of the given dotted path.
+func lookup(d document, path string) interface{} {
+	parts := strings.Split(path, ".")
+	for _, p := range parts[:len(parts)-1] {
+		d = d.doc(p)
+	}
+
+	return d.get(parts[len(parts)-1])
+}
+
+// match returns true if the document matches the filter, with equalities
+// and the operators $in, $ne, $gte, $lt and $lte.
+func match(d, filter document) bool {
+	for _, e := range filter {
+		v := lookup(d, e.key)
+		ops, isOps := e.value.(document)
+		if !isOps || len(ops) == 0 || !strings.HasPrefix(ops[0].key, "$") {
+			if compare(v, e.value) != 0 {
+				return false
+			}
+
+			continue
+		}
+
+		for _, op := range ops {
+			c := compare(v, op.value)
+			var ok bool
+			switch op.key {
+			case "$in":
+				for _, item := range op.value.([]interface{}) {
+					ok = ok || compare(v, item) == 0
+				}
+			case "$ne":
+				ok = c != 0
+			case "$gte":
+				ok = c == 0 || c == 1
+			case "$lt":
+				ok = c == -1
+			case "$lte":
+				ok = c == 0 || c == -1
+			default:
+				panic("unsupported operator " + op.key)
+			}
+
+			if !ok {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// compare returns -1, 0 or 1 comparing two values of the same type, the
+// numbers being all of the same type, or 2 if they can't be compared.
+func compare(a, b interface{}) int {
+	switch a := a.(type) {
+	case int32, int64, float64:
+		switch b.(type) {
+		case int32, int64, float64:
+			return compareInts(toInt(a), toInt(b))
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b)
+		}
+	case objectID:
+		if b, ok := b.(objectID); ok {
+			return bytes.Compare(a[:], b[:])
+		}
+	case time.Time:
+		if b, ok := b.(time.Time); ok {
+			return compareInts(a.UnixNano(), b.UnixNano())
+		}
+	case bool:
+		if b, ok := b.(bool); ok && a == b {
+			return 0
+		}
+	case nil:
+		if b == nil {
+			return 0
+		}
+	}
+
+	return 2
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func sortDocuments(docs []document, by document) {
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, e := range by {
+			c := compare(lookup(docs[i], e.key), lookup(docs[j], e.key))
+			if c == 0 || c == 2 {
+				continue
+			}
+
+			return c == -int(toInt(e.value))
+		}
+
+		return false
+	})
+}
@@ -0,0 +1,232 @@
+package grpcfs
+
+import (
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// file is a file opened in the server, identified by its handle. Every
+// operation is a request to the server, where the position is kept.
+type file struct {
+	fs       *GRPC
+	name     string
+	handle   uint64
+	isClosed bool
+}
+
+func (f *file) Name() string {
+	return f.name
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	n, eof, err := f.read(&readRequest{Handle: f.handle, Size: int64(len(p))}, p)
+	if err != nil {
+		return n, pathError("read", f.name, err)
+	}
+
+	if n == 0 && eof && len(p) != 0 {
+		return 0, io.EOF
+	}
+
+	return n, nil
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	req := &readRequest{Handle: f.handle, Size: int64(len(p)), Offset: off, At: true}
+	n, _, err := f.read(req, p)
+	if err != nil {
+		return n, pathError("readat", f.name, err)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// read copies to p the chunks streamed by the server, returning if the end
+// of the file was reached.
+func (f *file) read(req *readRequest, p []byte) (n int, eof bool, err error) {
+	s, done, err := f.fs.stream(readStream)
+	if err != nil {
+		return 0, false, err
+	}
+
+	defer done()
+	if err := s.SendMsg(req); err != nil {
+		return 0, false, err
+	}
+
+	if err := s.CloseSend(); err != nil {
+		return 0, false, err
+	}
+
+	for {
+		c := new(chunk)
+		err := s.RecvMsg(c)
+		if err == io.EOF {
+			return n, eof, nil
+		}
+
+		if err != nil {
+			return n, eof, err
+		}
+
+		n += copy(p[n:], c.Data)
+		eof = eof || c.EOF
+	}
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	n, err := f.write(p)
+	if err != nil {
+		return n, pathError("write", f.name, err)
+	}
+
+	if n < len(p) {
+		return n, io.ErrShortWrite
+	}
+
+	return n, nil
+}
+
+// write sends p to the server in chunks, in a single stream.
+func (f *file) write(p []byte) (int, error) {
+	s, done, err := f.fs.stream(writeStream)
+	if err != nil {
+		return 0, err
+	}
+
+	defer done()
+	for first := true; first || len(p) != 0; first = false {
+		data := p
+		if len(data) > chunkSize {
+			data = data[:chunkSize]
+		}
+
+		if err := s.SendMsg(&writeRequest{Handle: f.handle, Data: data}); err != nil {
+			break
+		}
+
+		p = p[len(data):]
+	}
+
+	// the errors sending are returned when receiving the response.
+	if err := s.CloseSend(); err != nil {
+		return 0, err
+	}
+
+	resp := new(writeResponse)
+	if err := s.RecvMsg(resp); err != nil {
+		return 0, err
+	}
+
+	return int(resp.Written), nil
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	req := &seekRequest{Handle: f.handle, Offset: offset, Whence: int64(whence)}
+	resp := new(seekResponse)
+	if err := f.fs.invoke("Seek", req, resp); err != nil {
+		return 0, pathError("seek", f.name, err)
+	}
+
+	return resp.Position, nil
+}
+
+func (f *file) Truncate(size int64) error {
+	if f.isClosed {
+		return os.ErrClosed
+	}
+
+	req := &truncateRequest{Handle: f.handle, Size: size}
+	if err := f.fs.invoke("Truncate", req, new(emptyMsg)); err != nil {
+		return pathError("truncate", f.name, err)
+	}
+
+	return nil
+}
+
+func (f *file) Lock() error {
+	return f.call("Lock")
+}
+
+func (f *file) Unlock() error {
+	return f.call("Unlock")
+}
+
+func (f *file) Close() error {
+	if f.isClosed {
+		return os.ErrClosed
+	}
+
+	f.isClosed = true
+	return f.call("Close")
+}
+
+func (f *file) call(name string) error {
+	if err := f.fs.invoke(name, &handleMsg{Handle: f.handle}, new(emptyMsg)); err != nil {
+		return pathError(strings.ToLower(name), f.name, err)
+	}
+
+	return nil
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func newFileInfo(m *fileInfoMsg) *fileInfo {
+	return &fileInfo{
+		name:    m.Name,
+		size:    m.Size,
+		mode:    os.FileMode(m.Mode),
+		modTime: time.Unix(0, m.ModTime),
+	}
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.size
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	return fi.mode
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.mode.IsDir()
+}
+
+func (*fileInfo) Sys() interface{} {
+	return nil
+}
@@ -0,0 +1,24 @@
+module gopkg.in/src-d/go-billy.v4/grpcfs
+
+go 1.23.0
+
+require (
+	google.golang.org/grpc v1.75.0
+	google.golang.org/protobuf v1.36.6
+	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127
+	gopkg.in/src-d/go-billy.v4 v4.3.2
+)
+
+require (
+	github.com/kr/pretty v0.3.1 // indirect
+	github.com/kr/text v0.2.0 // indirect
+	github.com/rogpeppe/go-internal v1.10.0 // indirect
+	golang.org/x/net v0.41.0 // indirect
+	golang.org/x/sys v0.33.0 // indirect
+	golang.org/x/text v0.26.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20250707201910-8d1bb00bc6a7 // indirect
+)
+
+// the module is developed along with the packages of the tree, and tested
+// against them.
+replace gopkg.in/src-d/go-billy.v4 => ..
@@ -0,0 +1,268 @@
+// Package grpcfs provides a billy filesystem accessed through gRPC, and the
+// server exposing any billy filesystem, allowing to access it across process
+// and machine boundaries. The protocol is defined in grpcfs.proto.
+package grpcfs // import "gopkg.in/src-d/go-billy.v4/grpcfs"
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+// Options holds the configuration of a GRPC filesystem.
+type Options struct {
+	// Timeout is the maximum duration of every request, without limit if 0.
+	Timeout time.Duration
+}
+
+// GRPC is a filesystem accessed through gRPC, served by a Server.
+type GRPC struct {
+	cc   grpc.ClientConnInterface
+	opts Options
+
+	capsOnce sync.Once
+	caps     billy.Capability
+}
+
+// New returns a new filesystem served through the given connection, usually
+// a *grpc.ClientConn.
+func New(cc grpc.ClientConnInterface, opts Options) *GRPC {
+	return &GRPC{cc: cc, opts: opts}
+}
+
+func (fs *GRPC) context() (context.Context, context.CancelFunc) {
+	if fs.opts.Timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+
+	return context.WithTimeout(context.Background(), fs.opts.Timeout)
+}
+
+func (fs *GRPC) invoke(name string, req, resp message) error {
+	ctx, cancel := fs.context()
+	defer cancel()
+
+	return fs.cc.Invoke(ctx, method(name), req, resp, grpc.CallContentSubtype(codecName))
+}
+
+// stream starts the given stream, the returned function must be called once
+// finished with it.
+func (fs *GRPC) stream(i int) (grpc.ClientStream, context.CancelFunc, error) {
+	ctx, cancel := fs.context()
+	desc := &serviceDesc.Streams[i]
+	s, err := fs.cc.NewStream(ctx, desc, method(desc.StreamName), grpc.CallContentSubtype(codecName))
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
+	return s, cancel, nil
+}
+
+func (fs *GRPC) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *GRPC) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (fs *GRPC) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	req := &openRequest{Path: filename, Flag: toWireFlag(flag), Mode: uint32(perm)}
+	resp := new(handleMsg)
+	if err := fs.invoke("Open", req, resp); err != nil {
+		return nil, pathError("open", filename, err)
+	}
+
+	return &file{fs: fs, name: relative(filename), handle: resp.Handle}, nil
+}
+
+func (fs *GRPC) Stat(filename string) (os.FileInfo, error) {
+	resp := new(fileInfoMsg)
+	if err := fs.invoke("Stat", &pathRequest{Path: filename}, resp); err != nil {
+		return nil, pathError("stat", filename, err)
+	}
+
+	return newFileInfo(resp), nil
+}
+
+func (fs *GRPC) Lstat(filename string) (os.FileInfo, error) {
+	resp := new(fileInfoMsg)
+	if err := fs.invoke("Lstat", &pathRequest{Path: filename}, resp); err != nil {
+		return nil, pathError("lstat", filename, err)
+	}
+
+	return newFileInfo(resp), nil
+}
+
+func (fs *GRPC) ReadDir(path string) ([]os.FileInfo, error) {
+	infos, err := fs.readDir(path)
+	if err != nil {
+		return nil, pathError("readdir", path, err)
+	}
+
+	return infos, nil
+}
+
+func (fs *GRPC) readDir(path string) ([]os.FileInfo, error) {
+	s, done, err := fs.stream(readDirStream)
+	if err != nil {
+		return nil, err
+	}
+
+	defer done()
+	if err := s.SendMsg(&pathRequest{Path: path}); err != nil {
+		return nil, err
+	}
+
+	if err := s.CloseSend(); err != nil {
+		return nil, err
+	}
+
+	var infos []os.FileInfo
+	for {
+		fi := new(fileInfoMsg)
+		err := s.RecvMsg(fi)
+		if err == io.EOF {
+			return infos, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		infos = append(infos, newFileInfo(fi))
+	}
+}
+
+func (fs *GRPC) MkdirAll(filename string, perm os.FileMode) error {
+	req := &mkdirAllRequest{Path: filename, Mode: uint32(perm)}
+	if err := fs.invoke("MkdirAll", req, new(emptyMsg)); err != nil {
+		return pathError("mkdir", filename, err)
+	}
+
+	return nil
+}
+
+func (fs *GRPC) Rename(from, to string) error {
+	if err := fs.invoke("Rename", &renameRequest{From: from, To: to}, new(emptyMsg)); err != nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: fromStatus(err)}
+	}
+
+	return nil
+}
+
+func (fs *GRPC) Remove(filename string) error {
+	if err := fs.invoke("Remove", &pathRequest{Path: filename}, new(emptyMsg)); err != nil {
+		return pathError("remove", filename, err)
+	}
+
+	return nil
+}
+
+func (fs *GRPC) Symlink(target, link string) error {
+	req := &symlinkRequest{Target: target, Link: link}
+	if err := fs.invoke("Symlink", req, new(emptyMsg)); err != nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: fromStatus(err)}
+	}
+
+	return nil
+}
+
+func (fs *GRPC) Readlink(link string) (string, error) {
+	resp := new(readlinkResponse)
+	if err := fs.invoke("Readlink", &pathRequest{Path: link}, resp); err != nil {
+		return "", pathError("readlink", link, err)
+	}
+
+	return resp.Target, nil
+}
+
+// ChunkHash implements util.ChunkHasher, hashing the chunk in the server, so
+// the files can be compared without transferring them.
+func (fs *GRPC) ChunkHash(filename string, offset, length int64) ([]byte, error) {
+	req := &chunkHashRequest{Path: filename, Offset: offset, Length: length}
+	resp := new(chunkHashResponse)
+	if err := fs.invoke("ChunkHash", req, resp); err != nil {
+		return nil, pathError("hash", filename, err)
+	}
+
+	return resp.Hash, nil
+}
+
+func (fs *GRPC) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(fs, dir, prefix)
+}
+
+func (fs *GRPC) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (fs *GRPC) Chroot(path string) (billy.Filesystem, error) {
+	return chroot.New(fs, fs.Join(fs.Root(), path)), nil
+}
+
+func (fs *GRPC) Root() string {
+	return string(filepath.Separator)
+}
+
+// Capabilities implements the Capable interface, returning the capabilities
+// of the served filesystem, requested only once. If the request fails, the
+// default capabilities are returned.
+func (fs *GRPC) Capabilities() billy.Capability {
+	fs.capsOnce.Do(func() {
+		resp := new(capabilitiesResponse)
+		if err := fs.invoke("Capabilities", new(emptyMsg), resp); err != nil {
+			fs.caps = billy.DefaultCapabilities
+			return
+		}
+
+		fs.caps = billy.Capability(resp.Capabilities)
+	})
+
+	return fs.caps
+}
+
+// relative returns the given path without the leading separators, as the
+// name of a file.
+func relative(p string) string {
+	return strings.TrimLeft(p, string(filepath.Separator))
+}
+
+func pathError(op, path string, err error) error {
+	return &os.PathError{Op: op, Path: path, Err: fromStatus(err)}
+}
+
+// fromStatus converts the gRPC errors to the errors of the filesystem,
+// according to their codes.
+func fromStatus(err error) error {
+	s, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+
+	switch s.Code() {
+	case codes.NotFound:
+		return os.ErrNotExist
+	case codes.AlreadyExists:
+		return os.ErrExist
+	case codes.PermissionDenied:
+		return os.ErrPermission
+	case codes.Unimplemented:
+		return billy.ErrNotSupported
+	default:
+		return errors.New(s.Message())
+	}
+}
@@ -0,0 +1,234 @@
+package grpcfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&GRPCSuite{})
+
+type GRPCSuite struct {
+	test.FilesystemSuite
+	server *grpc.Server
+	ln     *bufconn.Listener
+	conn   *grpc.ClientConn
+	local  *openFS
+	remote *GRPC
+}
+
+func (s *GRPCSuite) SetUpTest(c *C) {
+	s.ln = bufconn.Listen(1024 * 1024)
+	s.local = &openFS{Filesystem: memfs.New()}
+	srv := NewServer(s.local)
+	s.server = grpc.NewServer(srv.ServerOption())
+	srv.Register(s.server)
+	go s.server.Serve(s.ln)
+
+	s.conn = s.dial(c)
+	s.remote = New(s.conn, Options{})
+	s.FilesystemSuite = test.NewFilesystemSuite(s.remote)
+}
+
+func (s *GRPCSuite) dial(c *C) *grpc.ClientConn {
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return s.ln.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	c.Assert(err, IsNil)
+	return conn
+}
+
+func (s *GRPCSuite) TearDownTest(c *C) {
+	s.conn.Close()
+	s.server.Stop()
+}
+
+func (s *GRPCSuite) TestLargeFile(c *C) {
+	content := bytes.Repeat([]byte("0123456789"), chunkSize/3)
+	c.Assert(util.WriteFile(s.remote, "foo", content, 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.local, "foo"), Equals, string(content))
+	c.Assert(test.ReadFile(c, s.remote, "foo"), Equals, string(content))
+
+	f, err := s.remote.Open("foo")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	buf := make([]byte, chunkSize*2)
+	n, err := f.ReadAt(buf, int64(len(content)-chunkSize-1))
+	c.Assert(err, NotNil)
+	c.Assert(n, Equals, chunkSize+1)
+	c.Assert(buf[:n], DeepEquals, content[len(content)-chunkSize-1:])
+}
+
+func (s *GRPCSuite) TestErrors(c *C) {
+	_, err := s.remote.Stat("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+	c.Assert(err.(*os.PathError).Op, Equals, "stat")
+
+	c.Assert(s.local.MkdirAll("foo/bar", 0755), IsNil)
+	err = s.remote.Remove("foo")
+	c.Assert(err, ErrorMatches, "remove foo: .*contains files")
+}
+
+func (s *GRPCSuite) TestChunkHash(c *C) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	c.Assert(util.WriteFile(s.local, "foo", content, 0644), IsNil)
+
+	hash, err := s.remote.ChunkHash("foo", 10, 100)
+	c.Assert(err, IsNil)
+	expected := sha256.Sum256(content[10:110])
+	c.Assert(hash, DeepEquals, expected[:])
+
+	hash, err = s.remote.ChunkHash("foo", 990, 100)
+	c.Assert(err, IsNil)
+	expected = sha256.Sum256(content[990:])
+	c.Assert(hash, DeepEquals, expected[:])
+
+	_, err = s.remote.ChunkHash("missing", 0, 100)
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	_, err = s.remote.ChunkHash("foo", -1, 100)
+	c.Assert(err, NotNil)
+
+	other := memfs.New()
+	c.Assert(util.WriteFile(other, "foo", content, 0644), IsNil)
+	equal, err := util.EqualChunks(s.remote, "foo", other, "foo", 128)
+	c.Assert(err, IsNil)
+	c.Assert(equal, Equals, true)
+}
+
+func (s *GRPCSuite) TestCapabilities(c *C) {
+	c.Assert(s.remote.Capabilities(), Equals, billy.Capabilities(s.local))
+}
+
+func (s *GRPCSuite) TestHandlesReleased(c *C) {
+	c.Assert(util.WriteFile(s.remote, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.remote, "foo"), Equals, "foo")
+}
+
+func (s *GRPCSuite) TestInvalidRead(c *C) {
+	c.Assert(util.WriteFile(s.local, "foo", []byte("foo"), 0644), IsNil)
+	f, err := s.remote.Open("foo")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	h := f.(*file).handle
+	for _, req := range []*readRequest{
+		{Handle: h, Size: -5},
+		{Handle: h, Size: 1, Offset: -1, At: true},
+	} {
+		stream, cancel, err := s.remote.stream(readStream)
+		c.Assert(err, IsNil)
+		c.Assert(stream.SendMsg(req), IsNil)
+		c.Assert(stream.CloseSend(), IsNil)
+		err = stream.RecvMsg(new(chunk))
+		cancel()
+		c.Assert(status.Code(err), Equals, codes.InvalidArgument)
+	}
+
+	// the server is still serving
+	c.Assert(test.ReadFile(c, s.remote, "foo"), Equals, "foo")
+}
+
+func (s *GRPCSuite) TestHandlesScoped(c *C) {
+	c.Assert(util.WriteFile(s.local, "foo", []byte("secret"), 0644), IsNil)
+	f, err := s.remote.Open("foo")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	other := s.dial(c)
+	remote := New(other, Options{})
+
+	// the handle isn't valid in another connection
+	err = remote.invoke("Seek", &seekRequest{Handle: f.(*file).handle}, new(seekResponse))
+	c.Assert(status.Code(err), Equals, codes.FailedPrecondition)
+
+	g, err := remote.Open("foo")
+	c.Assert(err, IsNil)
+	c.Assert(g.(*file).handle, Equals, f.(*file).handle)
+	c.Assert(s.local.opened(), Equals, 2)
+
+	// the files left open are closed when the connection ends
+	c.Assert(other.Close(), IsNil)
+	for i := 0; i < 100 && s.local.opened() != 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	c.Assert(s.local.opened(), Equals, 1)
+}
+
+func (s *GRPCSuite) TestCodec(c *C) {
+	in := &readRequest{Handle: 1 << 40, Size: -1, Offset: 42, At: true}
+	b, err := codec{}.Marshal(in)
+	c.Assert(err, IsNil)
+
+	out := new(readRequest)
+	c.Assert(codec{}.Unmarshal(b, out), IsNil)
+	c.Assert(out, DeepEquals, in)
+
+	// the unknown fields are skipped.
+	out2 := new(handleMsg)
+	c.Assert(codec{}.Unmarshal(b, out2), IsNil)
+	c.Assert(out2.Handle, Equals, in.Handle)
+}
+
+// openFS is a filesystem counting the files open.
+type openFS struct {
+	billy.Filesystem
+
+	mu   sync.Mutex
+	open int
+}
+
+func (fs *openFS) opened() int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.open
+}
+
+func (fs *openFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	f, err := fs.Filesystem.OpenFile(filename, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.mu.Lock()
+	fs.open++
+	fs.mu.Unlock()
+	return &openFile{File: f, fs: fs}, nil
+}
+
+type openFile struct {
+	billy.File
+	fs *openFS
+}
+
+func (f *openFile) Close() error {
+	f.fs.mu.Lock()
+	f.fs.open--
+	f.fs.mu.Unlock()
+	return f.File.Close()
+}
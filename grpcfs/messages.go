@@ -0,0 +1,389 @@
+package grpcfs
+
+import (
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// codecName is the content-subtype of the messages, encoded as protocol
+// buffers by codec.
+const codecName = "grpcfs"
+
+func init() {
+	encoding.RegisterCodec(codec{})
+}
+
+// codec encodes the messages of grpcfs.proto as protocol buffers, without
+// the need of generated code.
+type codec struct{}
+
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(message)
+	if !ok {
+		return nil, fmt.Errorf("grpcfs: unexpected message %T", v)
+	}
+
+	var b []byte
+	for _, f := range m.fields() {
+		b = f.append(b)
+	}
+
+	return b, nil
+}
+
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(message)
+	if !ok {
+		return fmt.Errorf("grpcfs: unexpected message %T", v)
+	}
+
+	fields := m.fields()
+	for len(data) != 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+
+		data = data[n:]
+		if f := findField(fields, num); f != nil {
+			n = f.consume(typ, data)
+		} else {
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+
+		data = data[n:]
+	}
+
+	return nil
+}
+
+func (codec) Name() string {
+	return codecName
+}
+
+// message is implemented by the messages of grpcfs.proto.
+type message interface {
+	// fields returns the fields of the message, pointing to the values.
+	fields() []field
+}
+
+// field is a field of a message, value is a pointer to a string, []byte,
+// bool, int64, uint32 or uint64.
+type field struct {
+	num   protowire.Number
+	value interface{}
+}
+
+func findField(fields []field, num protowire.Number) *field {
+	for i := range fields {
+		if fields[i].num == num {
+			return &fields[i]
+		}
+	}
+
+	return nil
+}
+
+// append appends the field to b, omitting the zero values as in proto3.
+func (f field) append(b []byte) []byte {
+	switch v := f.value.(type) {
+	case *string:
+		if *v != "" {
+			b = protowire.AppendTag(b, f.num, protowire.BytesType)
+			b = protowire.AppendString(b, *v)
+		}
+	case *[]byte:
+		if len(*v) != 0 {
+			b = protowire.AppendTag(b, f.num, protowire.BytesType)
+			b = protowire.AppendBytes(b, *v)
+		}
+	case *bool:
+		if *v {
+			b = protowire.AppendTag(b, f.num, protowire.VarintType)
+			b = protowire.AppendVarint(b, protowire.EncodeBool(*v))
+		}
+	case *int64:
+		if *v != 0 {
+			b = protowire.AppendTag(b, f.num, protowire.VarintType)
+			b = protowire.AppendVarint(b, uint64(*v))
+		}
+	case *uint32:
+		if *v != 0 {
+			b = protowire.AppendTag(b, f.num, protowire.VarintType)
+			b = protowire.AppendVarint(b, uint64(*v))
+		}
+	case *uint64:
+		if *v != 0 {
+			b = protowire.AppendTag(b, f.num, protowire.VarintType)
+			b = protowire.AppendVarint(b, *v)
+		}
+	}
+
+	return b
+}
+
+// consume reads the value of the field from b, returning the number of
+// bytes read or a negative number on error. The values of a wrong type are
+// skipped, as unknown fields.
+func (f field) consume(typ protowire.Type, b []byte) int {
+	switch v := f.value.(type) {
+	case *string:
+		if typ == protowire.BytesType {
+			s, n := protowire.ConsumeString(b)
+			*v = s
+			return n
+		}
+	case *[]byte:
+		if typ == protowire.BytesType {
+			s, n := protowire.ConsumeBytes(b)
+			*v = append((*v)[:0], s...)
+			return n
+		}
+	default:
+		if typ == protowire.VarintType {
+			x, n := protowire.ConsumeVarint(b)
+			f.setVarint(x)
+			return n
+		}
+	}
+
+	return protowire.ConsumeFieldValue(f.num, typ, b)
+}
+
+func (f field) setVarint(x uint64) {
+	switch v := f.value.(type) {
+	case *bool:
+		*v = protowire.DecodeBool(x)
+	case *int64:
+		*v = int64(x)
+	case *uint32:
+		*v = uint32(x)
+	case *uint64:
+		*v = x
+	}
+}
+
+type emptyMsg struct{}
+
+func (*emptyMsg) fields() []field { return nil }
+
+type capabilitiesResponse struct {
+	Capabilities uint64
+}
+
+func (m *capabilitiesResponse) fields() []field {
+	return []field{{1, &m.Capabilities}}
+}
+
+type pathRequest struct {
+	Path string
+}
+
+func (m *pathRequest) fields() []field {
+	return []field{{1, &m.Path}}
+}
+
+type fileInfoMsg struct {
+	Name    string
+	Size    int64
+	Mode    uint32
+	ModTime int64
+}
+
+func (m *fileInfoMsg) fields() []field {
+	return []field{{1, &m.Name}, {2, &m.Size}, {3, &m.Mode}, {4, &m.ModTime}}
+}
+
+func newFileInfoMsg(fi os.FileInfo) *fileInfoMsg {
+	return &fileInfoMsg{
+		Name:    fi.Name(),
+		Size:    fi.Size(),
+		Mode:    uint32(fi.Mode()),
+		ModTime: fi.ModTime().UnixNano(),
+	}
+}
+
+type mkdirAllRequest struct {
+	Path string
+	Mode uint32
+}
+
+func (m *mkdirAllRequest) fields() []field {
+	return []field{{1, &m.Path}, {2, &m.Mode}}
+}
+
+type renameRequest struct {
+	From, To string
+}
+
+func (m *renameRequest) fields() []field {
+	return []field{{1, &m.From}, {2, &m.To}}
+}
+
+type symlinkRequest struct {
+	Target, Link string
+}
+
+func (m *symlinkRequest) fields() []field {
+	return []field{{1, &m.Target}, {2, &m.Link}}
+}
+
+type readlinkResponse struct {
+	Target string
+}
+
+func (m *readlinkResponse) fields() []field {
+	return []field{{1, &m.Target}}
+}
+
+type chunkHashRequest struct {
+	Path           string
+	Offset, Length int64
+}
+
+func (m *chunkHashRequest) fields() []field {
+	return []field{{1, &m.Path}, {2, &m.Offset}, {3, &m.Length}}
+}
+
+type chunkHashResponse struct {
+	Hash []byte
+}
+
+func (m *chunkHashResponse) fields() []field {
+	return []field{{1, &m.Hash}}
+}
+
+type openRequest struct {
+	Path string
+	Flag int64
+	Mode uint32
+}
+
+func (m *openRequest) fields() []field {
+	return []field{{1, &m.Path}, {2, &m.Flag}, {3, &m.Mode}}
+}
+
+type handleMsg struct {
+	Handle uint64
+}
+
+func (m *handleMsg) fields() []field {
+	return []field{{1, &m.Handle}}
+}
+
+type readRequest struct {
+	Handle uint64
+	Size   int64
+	Offset int64
+	At     bool
+}
+
+func (m *readRequest) fields() []field {
+	return []field{{1, &m.Handle}, {2, &m.Size}, {3, &m.Offset}, {4, &m.At}}
+}
+
+type chunk struct {
+	Data []byte
+	EOF  bool
+}
+
+func (m *chunk) fields() []field {
+	return []field{{1, &m.Data}, {2, &m.EOF}}
+}
+
+type writeRequest struct {
+	Handle uint64
+	Data   []byte
+}
+
+func (m *writeRequest) fields() []field {
+	return []field{{1, &m.Handle}, {2, &m.Data}}
+}
+
+type writeResponse struct {
+	Written int64
+}
+
+func (m *writeResponse) fields() []field {
+	return []field{{1, &m.Written}}
+}
+
+type seekRequest struct {
+	Handle uint64
+	Offset int64
+	Whence int64
+}
+
+func (m *seekRequest) fields() []field {
+	return []field{{1, &m.Handle}, {2, &m.Offset}, {3, &m.Whence}}
+}
+
+type seekResponse struct {
+	Position int64
+}
+
+func (m *seekResponse) fields() []field {
+	return []field{{1, &m.Position}}
+}
+
+type truncateRequest struct {
+	Handle uint64
+	Size   int64
+}
+
+func (m *truncateRequest) fields() []field {
+	return []field{{1, &m.Handle}, {2, &m.Size}}
+}
+
+// The flags of open(2) in Linux, used in the protocol.
+const (
+	wireWronly = 0x1
+	wireRdwr   = 0x2
+	wireCreate = 0x40
+	wireExcl   = 0x80
+	wireTrunc  = 0x200
+	wireAppend = 0x400
+	wireSync   = 0x101000
+)
+
+var wireFlags = []struct {
+	os   int
+	wire int64
+}{
+	{os.O_WRONLY, wireWronly},
+	{os.O_RDWR, wireRdwr},
+	{os.O_CREATE, wireCreate},
+	{os.O_EXCL, wireExcl},
+	{os.O_TRUNC, wireTrunc},
+	{os.O_APPEND, wireAppend},
+	{os.O_SYNC, wireSync},
+}
+
+func toWireFlag(flag int) int64 {
+	var w int64
+	for _, f := range wireFlags {
+		if flag&f.os == f.os {
+			w |= f.wire
+		}
+	}
+
+	return w
+}
+
+func fromWireFlag(w int64) int {
+	var flag int
+	for _, f := range wireFlags {
+		if w&f.wire == f.wire {
+			flag |= f.os
+		}
+	}
+
+	return flag
+}
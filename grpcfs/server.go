@@ -0,0 +1,438 @@
+package grpcfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/internal/errutil"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const (
+	serviceName = "grpcfs.Filesystem"
+
+	// chunkSize is the maximum size of the data of a message.
+	chunkSize = 64 * 1024
+)
+
+var (
+	errUnknownHandle = errors.New("unknown handle")
+	errNoConn        = status.Error(codes.FailedPrecondition,
+		"grpcfs: the gRPC server wasn't created with Server.ServerOption")
+)
+
+// Server exposes a billy.Filesystem through gRPC, serving the Filesystem
+// service defined in grpcfs.proto. The files are kept open until closed by
+// the clients, or until their connection ends.
+//
+// The handles of the files are only valid in the connection opening them, so
+// the clients can't access the files of the others. This requires the gRPC
+// server to be created with the option returned by ServerOption, otherwise
+// the files can't be open.
+type Server struct {
+	fs billy.Filesystem
+}
+
+// NewServer returns a new Server exposing the given filesystem.
+func NewServer(fs billy.Filesystem) *Server {
+	return &Server{fs: fs}
+}
+
+// ServerOption returns the option to give to grpc.NewServer, tracking the
+// connections to scope the handles of the files to them, and to close the
+// files left open when they end.
+func (s *Server) ServerOption() grpc.ServerOption {
+	return grpc.StatsHandler(connHandler{})
+}
+
+// Register registers the Filesystem service in the given gRPC server.
+func (s *Server) Register(gs *grpc.Server) {
+	gs.RegisterService(&serviceDesc, s)
+}
+
+// conn holds the files open by a connection.
+type conn struct {
+	mu    sync.Mutex
+	next  uint64
+	files map[uint64]billy.File
+	// closed is set when the connection ends, so no more files are open.
+	closed bool
+}
+
+type connKey struct{}
+
+// connHandler is a stats.Handler attaching a conn to the context of every
+// connection, and closing its files when it ends.
+type connHandler struct{}
+
+func (connHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return context.WithValue(ctx, connKey{}, &conn{files: make(map[uint64]billy.File)})
+}
+
+func (connHandler) HandleConn(ctx context.Context, s stats.ConnStats) {
+	if _, ok := s.(*stats.ConnEnd); !ok {
+		return
+	}
+
+	c, ok := ctx.Value(connKey{}).(*conn)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	files := c.files
+	c.files, c.closed = nil, true
+	c.mu.Unlock()
+
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+func (connHandler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (connHandler) HandleRPC(context.Context, stats.RPCStats) {}
+
+func connFrom(ctx context.Context) (*conn, error) {
+	c, ok := ctx.Value(connKey{}).(*conn)
+	if !ok {
+		return nil, errNoConn
+	}
+
+	return c, nil
+}
+
+func (s *Server) file(ctx context.Context, h uint64) (billy.File, error) {
+	c, err := connFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f, ok := c.files[h]
+	if !ok {
+		return nil, errUnknownHandle
+	}
+
+	return f, nil
+}
+
+// invalidArgument returns an error rejecting a request.
+func invalidArgument(msg string) error {
+	return status.Error(codes.InvalidArgument, "grpcfs: "+msg)
+}
+
+func (s *Server) capabilities(context.Context, message) (message, error) {
+	return &capabilitiesResponse{Capabilities: uint64(billy.Capabilities(s.fs))}, nil
+}
+
+func (s *Server) stat(_ context.Context, m message) (message, error) {
+	req := m.(*pathRequest)
+	fi, err := s.fs.Stat(req.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return newFileInfoMsg(fi), nil
+}
+
+func (s *Server) lstat(_ context.Context, m message) (message, error) {
+	req := m.(*pathRequest)
+	fi, err := s.fs.Lstat(req.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return newFileInfoMsg(fi), nil
+}
+
+func (s *Server) readDir(m message, stream grpc.ServerStream) error {
+	req := m.(*pathRequest)
+	infos, err := s.fs.ReadDir(req.Path)
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range infos {
+		if err := stream.SendMsg(newFileInfoMsg(fi)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) mkdirAll(_ context.Context, m message) (message, error) {
+	req := m.(*mkdirAllRequest)
+	return &emptyMsg{}, s.fs.MkdirAll(req.Path, os.FileMode(req.Mode))
+}
+
+func (s *Server) rename(_ context.Context, m message) (message, error) {
+	req := m.(*renameRequest)
+	return &emptyMsg{}, s.fs.Rename(req.From, req.To)
+}
+
+func (s *Server) remove(_ context.Context, m message) (message, error) {
+	req := m.(*pathRequest)
+	return &emptyMsg{}, s.fs.Remove(req.Path)
+}
+
+func (s *Server) symlink(_ context.Context, m message) (message, error) {
+	req := m.(*symlinkRequest)
+	return &emptyMsg{}, s.fs.Symlink(req.Target, req.Link)
+}
+
+func (s *Server) readlink(_ context.Context, m message) (message, error) {
+	req := m.(*pathRequest)
+	target, err := s.fs.Readlink(req.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &readlinkResponse{Target: target}, nil
+}
+
+// chunkHash hashes the chunk with the ChunkHasher of the filesystem if it
+// implements it, or reading it otherwise.
+func (s *Server) chunkHash(_ context.Context, m message) (message, error) {
+	req := m.(*chunkHashRequest)
+	if req.Offset < 0 || req.Length < 0 {
+		return nil, invalidArgument("negative offset or length")
+	}
+
+	if h, ok := s.fs.(util.ChunkHasher); ok {
+		hash, err := h.ChunkHash(req.Path, req.Offset, req.Length)
+		if err != nil {
+			return nil, err
+		}
+
+		return &chunkHashResponse{Hash: hash}, nil
+	}
+
+	f, err := s.fs.Open(req.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, req.Offset, req.Length)); err != nil {
+		return nil, err
+	}
+
+	return &chunkHashResponse{Hash: h.Sum(nil)}, nil
+}
+
+func (s *Server) open(ctx context.Context, m message) (message, error) {
+	req := m.(*openRequest)
+	c, err := connFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := s.fs.OpenFile(req.Path, fromWireFlag(req.Flag), os.FileMode(req.Mode))
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		f.Close()
+		return nil, status.Error(codes.Canceled, "grpcfs: connection closed")
+	}
+
+	c.next++
+	c.files[c.next] = f
+	return &handleMsg{Handle: c.next}, nil
+}
+
+// read streams the requested bytes in chunks, flagging the last one if the
+// end of the file is reached.
+func (s *Server) read(m message, stream grpc.ServerStream) error {
+	req := m.(*readRequest)
+	if req.Size < 0 {
+		return invalidArgument("negative size")
+	}
+
+	if req.At && req.Offset < 0 {
+		return invalidArgument("negative offset")
+	}
+
+	f, err := s.file(stream.Context(), req.Handle)
+	if err != nil {
+		return err
+	}
+
+	if req.Size == 0 {
+		return stream.SendMsg(&chunk{})
+	}
+
+	buf := make([]byte, chunkSize)
+	for left := req.Size; ; {
+		if left < int64(len(buf)) {
+			buf = buf[:left]
+		}
+
+		var n int
+		if req.At {
+			n, err = f.ReadAt(buf, req.Offset)
+			req.Offset += int64(n)
+		} else {
+			n, err = io.ReadFull(f, buf)
+		}
+
+		left -= int64(n)
+		eof := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !eof {
+			return err
+		}
+
+		if err := stream.SendMsg(&chunk{Data: buf[:n], EOF: eof}); err != nil {
+			return err
+		}
+
+		if eof || left == 0 {
+			return nil
+		}
+	}
+}
+
+func (s *Server) write(stream grpc.ServerStream) error {
+	var f billy.File
+	var written int64
+	for {
+		req := new(writeRequest)
+		err := stream.RecvMsg(req)
+		if err == io.EOF {
+			return stream.SendMsg(&writeResponse{Written: written})
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if f == nil {
+			if f, err = s.file(stream.Context(), req.Handle); err != nil {
+				return err
+			}
+		}
+
+		n, err := f.Write(req.Data)
+		written += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) seek(ctx context.Context, m message) (message, error) {
+	req := m.(*seekRequest)
+	f, err := s.file(ctx, req.Handle)
+	if err != nil {
+		return nil, err
+	}
+
+	pos, err := f.Seek(req.Offset, int(req.Whence))
+	if err != nil {
+		return nil, err
+	}
+
+	return &seekResponse{Position: pos}, nil
+}
+
+func (s *Server) truncate(ctx context.Context, m message) (message, error) {
+	req := m.(*truncateRequest)
+	if req.Size < 0 {
+		return nil, invalidArgument("negative size")
+	}
+
+	f, err := s.file(ctx, req.Handle)
+	if err != nil {
+		return nil, err
+	}
+
+	return &emptyMsg{}, f.Truncate(req.Size)
+}
+
+func (s *Server) lock(ctx context.Context, m message) (message, error) {
+	req := m.(*handleMsg)
+	f, err := s.file(ctx, req.Handle)
+	if err != nil {
+		return nil, err
+	}
+
+	return &emptyMsg{}, f.Lock()
+}
+
+func (s *Server) unlock(ctx context.Context, m message) (message, error) {
+	req := m.(*handleMsg)
+	f, err := s.file(ctx, req.Handle)
+	if err != nil {
+		return nil, err
+	}
+
+	return &emptyMsg{}, f.Unlock()
+}
+
+func (s *Server) close(ctx context.Context, m message) (message, error) {
+	req := m.(*handleMsg)
+	c, err := connFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	f, ok := c.files[req.Handle]
+	delete(c.files, req.Handle)
+	c.mu.Unlock()
+
+	if !ok {
+		return nil, errUnknownHandle
+	}
+
+	return &emptyMsg{}, f.Close()
+}
+
+// toStatus converts the errors of the filesystem to gRPC errors, with the
+// codes recognized by the client.
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	code := codes.Unknown
+	switch {
+	case os.IsNotExist(err):
+		code = codes.NotFound
+	case os.IsExist(err):
+		code = codes.AlreadyExists
+	case os.IsPermission(err):
+		code = codes.PermissionDenied
+	case errutil.Underlying(err) == billy.ErrNotSupported:
+		code = codes.Unimplemented
+	case err == errUnknownHandle:
+		code = codes.FailedPrecondition
+	}
+
+	return status.Error(code, err.Error())
+}
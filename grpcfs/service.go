@@ -0,0 +1,100 @@
+package grpcfs
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// serviceDesc describes the Filesystem service of grpcfs.proto.
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		unary("Capabilities", newEmpty, (*Server).capabilities),
+		unary("Stat", newPathRequest, (*Server).stat),
+		unary("Lstat", newPathRequest, (*Server).lstat),
+		unary("MkdirAll", func() message { return new(mkdirAllRequest) }, (*Server).mkdirAll),
+		unary("Rename", func() message { return new(renameRequest) }, (*Server).rename),
+		unary("Remove", newPathRequest, (*Server).remove),
+		unary("Symlink", func() message { return new(symlinkRequest) }, (*Server).symlink),
+		unary("Readlink", newPathRequest, (*Server).readlink),
+		unary("ChunkHash", func() message { return new(chunkHashRequest) }, (*Server).chunkHash),
+		unary("Open", func() message { return new(openRequest) }, (*Server).open),
+		unary("Seek", func() message { return new(seekRequest) }, (*Server).seek),
+		unary("Truncate", func() message { return new(truncateRequest) }, (*Server).truncate),
+		unary("Lock", newHandle, (*Server).lock),
+		unary("Unlock", newHandle, (*Server).unlock),
+		unary("Close", newHandle, (*Server).close),
+	},
+	Streams: []grpc.StreamDesc{
+		serverStream("ReadDir", newPathRequest, (*Server).readDir),
+		serverStream("Read", func() message { return new(readRequest) }, (*Server).read),
+		{
+			StreamName:    "Write",
+			ClientStreams: true,
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				return toStatus(srv.(*Server).write(stream))
+			},
+		},
+	},
+	Metadata: "grpcfs.proto",
+}
+
+// Indexes of the streams in serviceDesc, used by the client.
+const (
+	readDirStream = iota
+	readStream
+	writeStream
+)
+
+func newEmpty() message       { return new(emptyMsg) }
+func newPathRequest() message { return new(pathRequest) }
+func newHandle() message      { return new(handleMsg) }
+
+// unary returns the description of an unary method, calling fn with the
+// request decoded in the message returned by newReq.
+func unary(name string, newReq func() message, fn func(*Server, context.Context, message) (message, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := newReq()
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				resp, err := fn(srv.(*Server), ctx, req.(message))
+				return resp, toStatus(err)
+			}
+
+			if interceptor == nil {
+				return handler(ctx, req)
+			}
+
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: method(name)}
+			return interceptor(ctx, req, info, handler)
+		},
+	}
+}
+
+// serverStream returns the description of a method streaming the response,
+// calling fn with the request decoded in the message returned by newReq.
+func serverStream(name string, newReq func() message, fn func(*Server, message, grpc.ServerStream) error) grpc.StreamDesc {
+	return grpc.StreamDesc{
+		StreamName:    name,
+		ServerStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			req := newReq()
+			if err := stream.RecvMsg(req); err != nil {
+				return err
+			}
+
+			return toStatus(fn(srv.(*Server), req, stream))
+		},
+	}
+}
+
+func method(name string) string {
+	return "/" + serviceName + "/" + name
+}
@@ -0,0 +1,229 @@
+// Package appendonly provides a helper preventing the files of a filesystem
+// from being changed once written, other than by appending to them, eg.: for
+// write-once storage of audit logs or object databases.
+package appendonly
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// ErrImmutable is returned, as the error of an *os.PathError or an
+// *os.LinkError, by the operations that would change the content written.
+var ErrImmutable = errors.New("file is append-only")
+
+// AppendOnly is a helper passing every operation through to the underlying
+// filesystem, but failing with ErrImmutable the ones changing the existing
+// files other than by appending to them: writing anywhere before their end,
+// truncating them to a smaller size, and removing or replacing them.
+//
+// The files created through it, and the empty files and directories, may
+// still be renamed to a path not existing, so they can be written to a
+// temporary file first. The empty directories may be removed.
+type AppendOnly struct {
+	billy.Filesystem
+
+	mu sync.Mutex
+	// created are the clean paths of the files created through the helper.
+	created map[string]bool
+}
+
+// New creates a new filesystem wrapping up the given one, whose files can
+// only be created or appended to through it.
+func New(fs billy.Filesystem) *AppendOnly {
+	return &AppendOnly{Filesystem: fs, created: make(map[string]bool)}
+}
+
+func (fs *AppendOnly) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *AppendOnly) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file, failing if truncating a file not empty.
+func (fs *AppendOnly) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if flag&os.O_TRUNC != 0 {
+		fi, err := fs.Filesystem.Stat(filename)
+		if err == nil && fi.Size() != 0 {
+			return nil, &os.PathError{Op: "open", Path: filename, Err: ErrImmutable}
+		}
+	}
+
+	var create bool
+	if flag&os.O_CREATE != 0 {
+		_, err := fs.Filesystem.Lstat(filename)
+		create = os.IsNotExist(err)
+	}
+
+	f, err := fs.Filesystem.OpenFile(filename, flag, perm)
+	if err == nil && create {
+		fs.setCreated(filename, true)
+	}
+
+	if err != nil || flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return f, err
+	}
+
+	return &file{File: f, append: flag&os.O_APPEND != 0}, nil
+}
+
+func (fs *AppendOnly) TempFile(dir, prefix string) (billy.File, error) {
+	f, err := fs.Filesystem.TempFile(dir, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.setCreated(f.Name(), true)
+	return &file{File: f}, nil
+}
+
+// Rename renames the given file, failing if replacing another, or if it's a
+// file not empty, or a directory not empty, not created through the helper.
+func (fs *AppendOnly) Rename(from, to string) error {
+	fi, err := fs.Filesystem.Lstat(from)
+	if err != nil {
+		return err
+	}
+
+	if !fs.renamable(from, fi) {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: ErrImmutable}
+	}
+
+	if _, err := fs.Filesystem.Lstat(to); err == nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: ErrImmutable}
+	}
+
+	if err := fs.Filesystem.Rename(from, to); err != nil {
+		return err
+	}
+
+	if fs.isCreated(from) {
+		fs.setCreated(from, false)
+		fs.setCreated(to, true)
+	}
+
+	return nil
+}
+
+// renamable returns whether the given file can be renamed.
+func (fs *AppendOnly) renamable(p string, fi os.FileInfo) bool {
+	if fi.IsDir() {
+		infos, err := fs.Filesystem.ReadDir(p)
+		return err == nil && len(infos) == 0
+	}
+
+	return (fi.Mode().IsRegular() && fi.Size() == 0) || fs.isCreated(p)
+}
+
+func (fs *AppendOnly) isCreated(p string) bool {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.created[clean(p)]
+}
+
+func (fs *AppendOnly) setCreated(p string, created bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if created {
+		fs.created[clean(p)] = true
+	} else {
+		delete(fs.created, clean(p))
+	}
+}
+
+// clean returns the given path, clean and absolute, to be compared.
+func clean(p string) string {
+	return filepath.Clean(string(filepath.Separator) + p)
+}
+
+// Remove removes the given directory, failing if it's not one.
+func (fs *AppendOnly) Remove(filename string) error {
+	fi, err := fs.Filesystem.Lstat(filename)
+	if err == nil && !fi.IsDir() {
+		return &os.PathError{Op: "remove", Path: filename, Err: ErrImmutable}
+	}
+
+	return fs.Filesystem.Remove(filename)
+}
+
+// Chroot returns the given directory of the underlying filesystem, also
+// append-only.
+func (fs *AppendOnly) Chroot(path string) (billy.Filesystem, error) {
+	chroot, err := fs.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(chroot), nil
+}
+
+// Capabilities implements the Capable interface.
+func (fs *AppendOnly) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.Filesystem)
+}
+
+// file is a file open for writing, which can only be written at its end.
+type file struct {
+	billy.File
+	// append is true if the file is always written at its end.
+	append bool
+}
+
+// Write writes to the file, failing if not at its end.
+func (f *file) Write(p []byte) (int, error) {
+	if f.append {
+		return f.File.Write(p)
+	}
+
+	size, pos, err := f.position()
+	if err != nil {
+		return 0, err
+	}
+
+	if pos < size {
+		return 0, &os.PathError{Op: "write", Path: f.Name(), Err: ErrImmutable}
+	}
+
+	return f.File.Write(p)
+}
+
+// Truncate changes the size of the file, failing if made smaller.
+func (f *file) Truncate(size int64) error {
+	current, _, err := f.position()
+	if err != nil {
+		return err
+	}
+
+	if size < current {
+		return &os.PathError{Op: "truncate", Path: f.Name(), Err: ErrImmutable}
+	}
+
+	return f.File.Truncate(size)
+}
+
+// position returns the size of the file and the current offset, seeking to
+// the end and back, so the writes made by others are noticed.
+func (f *file) position() (size, pos int64, err error) {
+	if pos, err = f.File.Seek(0, io.SeekCurrent); err != nil {
+		return 0, 0, err
+	}
+
+	if size, err = f.File.Seek(0, io.SeekEnd); err != nil {
+		return 0, 0, err
+	}
+
+	if _, err = f.File.Seek(pos, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+
+	return size, pos, nil
+}
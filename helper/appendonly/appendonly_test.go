@@ -0,0 +1,135 @@
+package appendonly
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&AppendOnlySuite{})
+
+type AppendOnlySuite struct {
+	underlying billy.Filesystem
+	FS         billy.Filesystem
+}
+
+func (s *AppendOnlySuite) SetUpTest(c *C) {
+	s.underlying = memfs.New()
+	c.Assert(util.WriteFile(s.underlying, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(s.underlying.MkdirAll("dir", 0755), IsNil)
+
+	s.FS = New(s.underlying)
+}
+
+func (s *AppendOnlySuite) TestCreate(c *C) {
+	c.Assert(util.WriteFile(s.FS, "bar", []byte("bar"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "bar"), Equals, "bar")
+
+	f, err := s.FS.Create("empty")
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	// the empty files can be truncated, as nothing is lost
+	f, err = s.FS.Create("empty")
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	_, err = s.FS.Create("foo")
+	c.Assert(err.(*os.PathError).Err, Equals, ErrImmutable)
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "foo")
+}
+
+func (s *AppendOnlySuite) TestAppend(c *C) {
+	f, err := s.FS.OpenFile("foo", os.O_WRONLY|os.O_APPEND, 0)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("bar"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	f, err = s.FS.OpenFile("foo", os.O_RDWR, 0)
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	_, err = f.Write([]byte("qux"))
+	c.Assert(err.(*os.PathError).Err, Equals, ErrImmutable)
+
+	_, err = f.Seek(0, io.SeekEnd)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("qux"))
+	c.Assert(err, IsNil)
+
+	_, err = f.Seek(3, io.SeekStart)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("baz"))
+	c.Assert(err.(*os.PathError).Err, Equals, ErrImmutable)
+
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "foobarqux")
+}
+
+func (s *AppendOnlySuite) TestTruncate(c *C) {
+	f, err := s.FS.OpenFile("foo", os.O_RDWR, 0)
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	c.Assert(f.Truncate(1).(*os.PathError).Err, Equals, ErrImmutable)
+	c.Assert(f.Truncate(5), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "foo\x00\x00")
+}
+
+func (s *AppendOnlySuite) TestRemove(c *C) {
+	c.Assert(s.FS.Remove("foo").(*os.PathError).Err, Equals, ErrImmutable)
+	c.Assert(s.FS.Remove("dir"), IsNil)
+	c.Assert(os.IsNotExist(s.FS.Remove("missing")), Equals, true)
+}
+
+func (s *AppendOnlySuite) TestRename(c *C) {
+	tmp, err := s.FS.TempFile("", "tmp")
+	c.Assert(err, IsNil)
+	_, err = tmp.Write([]byte("bar"))
+	c.Assert(err, IsNil)
+	c.Assert(tmp.Close(), IsNil)
+
+	err = s.FS.Rename(tmp.Name(), "foo")
+	c.Assert(err.(*os.LinkError).Err, Equals, ErrImmutable)
+
+	c.Assert(s.FS.Rename(tmp.Name(), "bar"), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "bar"), Equals, "bar")
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "foo")
+}
+
+func (s *AppendOnlySuite) TestRenameExisting(c *C) {
+	c.Assert(util.WriteFile(s.underlying, "dir/bar", []byte("bar"), 0644), IsNil)
+	c.Assert(s.underlying.MkdirAll("empty", 0755), IsNil)
+	c.Assert(util.WriteFile(s.underlying, "zero", nil, 0644), IsNil)
+
+	err := s.FS.Rename("foo", "new")
+	c.Assert(err.(*os.LinkError).Err, Equals, ErrImmutable)
+	err = s.FS.Rename("dir", "new")
+	c.Assert(err.(*os.LinkError).Err, Equals, ErrImmutable)
+	c.Assert(test.ReadFile(c, s.FS, "dir/bar"), Equals, "bar")
+
+	c.Assert(s.FS.Rename("empty", "new"), IsNil)
+	c.Assert(s.FS.Rename("zero", "new/zero"), IsNil)
+
+	c.Assert(util.WriteFile(s.FS, "created", []byte("created"), 0644), IsNil)
+	c.Assert(s.FS.Rename("created", "new/created"), IsNil)
+	c.Assert(s.FS.Rename("/new/created", "renamed"), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "renamed"), Equals, "created")
+}
+
+func (s *AppendOnlySuite) TestChroot(c *C) {
+	c.Assert(util.WriteFile(s.underlying, "dir/foo", []byte("foo"), 0644), IsNil)
+
+	chroot, err := s.FS.Chroot("dir")
+	c.Assert(err, IsNil)
+	c.Assert(chroot.Remove("foo").(*os.PathError).Err, Equals, ErrImmutable)
+}
@@ -0,0 +1,336 @@
+// Package atime provides a helper recording the last time the files of a
+// filesystem were accessed, eg.: to remove the least recently used files of a
+// cache on filesystems not keeping the access times.
+package atime
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const separator = string(filepath.Separator)
+
+// Options holds the configuration of an ATime.
+type Options struct {
+	// Index is the name of the file, in the root of the underlying
+	// filesystem, where the access times are kept by Save, and loaded from
+	// by New. If empty, they are only kept in memory.
+	Index string
+	// Clock returns the time recorded for the accesses, time.Now if nil.
+	Clock func() time.Time
+}
+
+// Entry is a file with the last time it was accessed.
+type Entry struct {
+	// Path is the path of the file, relative to the root of the ATime
+	// created by New.
+	Path string
+	// Size is the size of the file, in bytes.
+	Size int64
+	// AccessTime is the last time the file was accessed, or modified if
+	// never accessed through the helper.
+	AccessTime time.Time
+}
+
+// ATime is a helper passing every operation through to the underlying
+// filesystem, recording the last time every file is accessed: every time it's
+// open or created, for reading or writing. The access times of the files
+// renamed are kept, and the ones of the files removed are forgotten.
+type ATime struct {
+	billy.Filesystem
+	s *state
+	// dir is the directory the root was moved to by Chroot, as the access
+	// times are kept by their paths from the original root.
+	dir string
+}
+
+// state is the state shared by an ATime and its chroots.
+type state struct {
+	fs    billy.Filesystem
+	index string
+	clock func() time.Time
+
+	m     sync.Mutex
+	times map[string]time.Time
+}
+
+// New creates a new filesystem recording the access times of the files of
+// the given one, loading the index if it exists.
+func New(fs billy.Filesystem, opts Options) (*ATime, error) {
+	if opts.Clock == nil {
+		opts.Clock = time.Now
+	}
+
+	s := &state{
+		fs:    fs,
+		index: opts.Index,
+		clock: opts.Clock,
+		times: make(map[string]time.Time),
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	return &ATime{Filesystem: fs, s: s}, nil
+}
+
+func (s *state) load() error {
+	if s.index == "" {
+		return nil
+	}
+
+	f, err := s.fs.Open(s.index)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(content, &s.times)
+}
+
+// Save writes the access times to the index, replacing it once written. It
+// does nothing if there is no index.
+func (fs *ATime) Save() error {
+	if fs.s.index == "" {
+		return nil
+	}
+
+	fs.s.m.Lock()
+	content, err := json.Marshal(fs.s.times)
+	fs.s.m.Unlock()
+	if err != nil {
+		return err
+	}
+
+	f, err := util.TempFile(fs.s.fs, separator, fs.s.index)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		fs.s.fs.Remove(f.Name())
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		fs.s.fs.Remove(f.Name())
+		return err
+	}
+
+	return fs.s.fs.Rename(f.Name(), fs.s.index)
+}
+
+// AccessTime returns the last time the given file was accessed, or modified
+// if never accessed through the helper.
+func (fs *ATime) AccessTime(filename string) (time.Time, error) {
+	fs.s.m.Lock()
+	t, ok := fs.s.times[fs.path(filename)]
+	fs.s.m.Unlock()
+	if ok {
+		return t, nil
+	}
+
+	fi, err := fs.Filesystem.Stat(filename)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return fi.ModTime(), nil
+}
+
+// Entries returns the files below the given directory, the least recently
+// accessed first, eg.: to remove them until the directory is small enough.
+func (fs *ATime) Entries(dir string) ([]Entry, error) {
+	fs.s.m.Lock()
+	times := make(map[string]time.Time, len(fs.s.times))
+	for p, t := range fs.s.times {
+		times[p] = t
+	}
+	fs.s.m.Unlock()
+
+	var entries []Entry
+	err := util.Walk(fs, dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+
+		t, ok := times[fs.path(p)]
+		if !ok {
+			t = fi.ModTime()
+		}
+
+		entries = append(entries, Entry{Path: p, Size: fi.Size(), AccessTime: t})
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].AccessTime.Equal(entries[j].AccessTime) {
+			return entries[i].Path < entries[j].Path
+		}
+
+		return entries[i].AccessTime.Before(entries[j].AccessTime)
+	})
+
+	return entries, nil
+}
+
+// touch records the given file as accessed now.
+func (fs *ATime) touch(filename string) {
+	fs.s.m.Lock()
+	defer fs.s.m.Unlock()
+
+	fs.s.times[fs.path(filename)] = fs.s.clock()
+}
+
+// path returns the given path as recorded, relative to the root of the
+// underlying filesystem.
+func (fs *ATime) path(p string) string {
+	return filepath.Join(separator, fs.dir, p)
+}
+
+func (fs *ATime) isIndex(p string) bool {
+	return fs.s.index != "" && fs.path(p) == filepath.Join(separator, fs.s.index)
+}
+
+func (fs *ATime) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *ATime) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (fs *ATime) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if fs.isIndex(filename) {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrPermission}
+	}
+
+	f, err := fs.Filesystem.OpenFile(filename, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.touch(filename)
+	return f, nil
+}
+
+func (fs *ATime) TempFile(dir, prefix string) (billy.File, error) {
+	f, err := fs.Filesystem.TempFile(dir, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.touch(f.Name())
+	return f, nil
+}
+
+// ReadDir returns the entries of the given directory, but the index.
+func (fs *ATime) ReadDir(path string) ([]os.FileInfo, error) {
+	infos, err := fs.Filesystem.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, fi := range infos {
+		if fs.isIndex(filepath.Join(path, fi.Name())) {
+			return append(infos[:i], infos[i+1:]...), nil
+		}
+	}
+
+	return infos, nil
+}
+
+// Rename renames the given file, keeping the access times of it and of the
+// files below it, and forgetting the ones of the files replaced.
+func (fs *ATime) Rename(from, to string) error {
+	if fs.isIndex(from) || fs.isIndex(to) {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: os.ErrPermission}
+	}
+
+	if err := fs.Filesystem.Rename(from, to); err != nil {
+		return err
+	}
+
+	fs.s.m.Lock()
+	defer fs.s.m.Unlock()
+
+	src, dst := fs.path(from), fs.path(to)
+	moved := make(map[string]time.Time)
+	for p, t := range fs.s.times {
+		switch {
+		case isBelow(p, src):
+			moved[dst+p[len(src):]] = t
+		case !isBelow(p, dst):
+			continue
+		}
+
+		delete(fs.s.times, p)
+	}
+
+	for p, t := range moved {
+		fs.s.times[p] = t
+	}
+
+	return nil
+}
+
+func (fs *ATime) Remove(filename string) error {
+	if fs.isIndex(filename) {
+		return &os.PathError{Op: "remove", Path: filename, Err: os.ErrPermission}
+	}
+
+	if err := fs.Filesystem.Remove(filename); err != nil {
+		return err
+	}
+
+	fs.s.m.Lock()
+	delete(fs.s.times, fs.path(filename))
+	fs.s.m.Unlock()
+	return nil
+}
+
+// Chroot returns the given directory of the underlying filesystem, sharing
+// the access times.
+func (fs *ATime) Chroot(path string) (billy.Filesystem, error) {
+	chroot, err := fs.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ATime{Filesystem: chroot, s: fs.s, dir: fs.path(path)}, nil
+}
+
+// Capabilities implements the Capable interface.
+func (fs *ATime) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.Filesystem)
+}
+
+// isBelow returns whether the given path is the given directory, or below it.
+func isBelow(p, dir string) bool {
+	return p == dir || strings.HasPrefix(p, dir+separator)
+}
@@ -0,0 +1,126 @@
+package atime
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&ATimeSuite{})
+
+type ATimeSuite struct {
+	test.FilesystemSuite
+	FS  *ATime
+	now time.Time
+}
+
+func (s *ATimeSuite) SetUpTest(c *C) {
+	s.now = time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	var err error
+	s.FS, err = New(memfs.New(), Options{
+		Index: ".atime",
+		Clock: s.clock,
+	})
+	c.Assert(err, IsNil)
+
+	s.FilesystemSuite = test.NewFilesystemSuite(s.FS)
+}
+
+// clock returns a time a minute later on every call.
+func (s *ATimeSuite) clock() time.Time {
+	s.now = s.now.Add(time.Minute)
+	return s.now
+}
+
+func (s *ATimeSuite) TestAccessTime(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "bar", []byte("bar"), 0644), IsNil)
+
+	t, err := s.FS.AccessTime("foo")
+	c.Assert(err, IsNil)
+	c.Assert(t, Equals, time.Date(2018, 1, 2, 3, 5, 5, 0, time.UTC))
+
+	f, err := s.FS.Open("foo")
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	t, err = s.FS.AccessTime("foo")
+	c.Assert(err, IsNil)
+	c.Assert(t, Equals, time.Date(2018, 1, 2, 3, 7, 5, 0, time.UTC))
+
+	_, err = s.FS.AccessTime("qux")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *ATimeSuite) TestEntries(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "dir/bar", []byte("bar"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "dir/baz", []byte("bazqux"), 0644), IsNil)
+
+	f, err := s.FS.Open("foo")
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	entries, err := s.FS.Entries("/")
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 3)
+	c.Assert(entries[0].Path, Equals, "/dir/bar")
+	c.Assert(entries[1].Path, Equals, "/dir/baz")
+	c.Assert(entries[1].Size, Equals, int64(6))
+	c.Assert(entries[2].Path, Equals, "/foo")
+
+	entries, err = s.FS.Entries("dir")
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 2)
+}
+
+func (s *ATimeSuite) TestRenameAndRemove(c *C) {
+	c.Assert(util.WriteFile(s.FS, "dir/foo", []byte("foo"), 0644), IsNil)
+	expected, err := s.FS.AccessTime("dir/foo")
+	c.Assert(err, IsNil)
+
+	c.Assert(s.FS.Rename("dir", "qux"), IsNil)
+	t, err := s.FS.AccessTime("qux/foo")
+	c.Assert(err, IsNil)
+	c.Assert(t, Equals, expected)
+
+	c.Assert(s.FS.Remove("qux/foo"), IsNil)
+	c.Assert(s.FS.s.times, HasLen, 0)
+}
+
+func (s *ATimeSuite) TestSave(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(s.FS.Save(), IsNil)
+
+	_, err := s.FS.Open(".atime")
+	c.Assert(os.IsPermission(err), Equals, true)
+
+	infos, err := s.FS.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 1)
+
+	fs, err := New(s.FS.Filesystem, Options{Index: ".atime"})
+	c.Assert(err, IsNil)
+
+	t, err := fs.AccessTime("foo")
+	c.Assert(err, IsNil)
+	c.Assert(t.Equal(time.Date(2018, 1, 2, 3, 5, 5, 0, time.UTC)), Equals, true)
+}
+
+func (s *ATimeSuite) TestChroot(c *C) {
+	chroot, err := s.FS.Chroot("dir")
+	c.Assert(err, IsNil)
+	c.Assert(util.WriteFile(chroot, "foo", []byte("foo"), 0644), IsNil)
+
+	_, ok := s.FS.s.times["/dir/foo"]
+	c.Assert(ok, Equals, true)
+}
@@ -0,0 +1,391 @@
+// Package audit provides a helper recording the changes made to a filesystem
+// in a tamper-evident log, eg.: to know who changed what and when, as
+// required by some compliance regimes.
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/internal/fileutil"
+)
+
+// DefaultLogName is the name of the log used if none is given.
+const DefaultLogName = "audit.log"
+
+// ErrTampered is returned when verifying a log changed since written.
+var ErrTampered = errors.New("audit log tampered")
+
+// Options holds the configuration of an Audit.
+type Options struct {
+	// Log is the filesystem where the log is kept, which should be a
+	// different one than audited. Required.
+	Log billy.Filesystem
+	// LogName is the name of the log in Log, DefaultLogName if empty.
+	LogName string
+	// Actor is the user recorded as making the changes, see Audit.As.
+	Actor string
+	// Clock returns the time recorded for the changes, time.Now if nil.
+	Clock func() time.Time
+}
+
+// Entry is a change recorded in the log, a JSON object per line. Every entry
+// holds the sum of the previous one, so none can be changed or removed
+// without changing every entry after it, but the last ones.
+type Entry struct {
+	// Seq is the position of the entry in the log, starting at 1.
+	Seq int64 `json:"seq"`
+	// Time is the time of the change.
+	Time time.Time `json:"time"`
+	// Actor is the user making the change.
+	Actor string `json:"actor,omitempty"`
+	// Op is the operation: "write", "rename", "remove", "mkdirall" or
+	// "symlink".
+	Op string `json:"op"`
+	// Path is the path changed, relative to the root of the filesystem
+	// audited.
+	Path string `json:"path"`
+	// To is the new path, when renaming, or the target, for symlinks.
+	To string `json:"to,omitempty"`
+	// Hash is the SHA-256 of the content of the file once written, in hex.
+	Hash string `json:"hash,omitempty"`
+	// Prev is the sum of the previous entry, empty for the first one.
+	Prev string `json:"prev"`
+	// Sum is the SHA-256 of the entry, without it, in hex.
+	Sum string `json:"sum"`
+}
+
+// sum returns the sum of the entry, computed over its JSON without it.
+func (e Entry) sum() (string, error) {
+	e.Sum = ""
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:]), nil
+}
+
+// Audit is a helper passing every operation through to the underlying
+// filesystem, appending an Entry to the log for every successful change. The
+// files open for writing are recorded once closed, with the hash of their
+// content.
+//
+// The log is only tamper-evident: it can be changed, or truncated, by anyone
+// able to write it, but not without being noticed by Verify, as long as the
+// sum of the last entry is kept elsewhere.
+type Audit struct {
+	billy.Filesystem
+	l     *auditLog
+	actor string
+	// dir is the directory the root was moved to by Chroot, as the entries
+	// of the log have the paths from the root of the filesystem audited.
+	dir string
+}
+
+// auditLog is the log shared by an Audit, its files and its chroots.
+type auditLog struct {
+	fs    billy.Filesystem
+	name  string
+	clock func() time.Time
+
+	m    sync.Mutex
+	seq  int64
+	last string
+}
+
+// New creates a new filesystem recording the changes made to the given one in
+// the log given by the options, which is continued if it exists.
+func New(fs billy.Filesystem, opts Options) (*Audit, error) {
+	if opts.Log == nil {
+		return nil, errors.New("audit: no log given")
+	}
+
+	if opts.LogName == "" {
+		opts.LogName = DefaultLogName
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = time.Now
+	}
+
+	l := &auditLog{fs: opts.Log, name: opts.LogName, clock: opts.Clock}
+	last, err := lastEntry(opts.Log, opts.LogName)
+	if err != nil {
+		return nil, err
+	}
+
+	if last != nil {
+		l.seq, l.last = last.Seq, last.Sum
+	}
+
+	return &Audit{Filesystem: fs, l: l, actor: opts.Actor}, nil
+}
+
+// As returns the filesystem recording the changes as made by the given actor,
+// sharing the log, eg.: to audit the requests of every user of a service.
+func (fs *Audit) As(actor string) *Audit {
+	return &Audit{Filesystem: fs.Filesystem, l: fs.l, actor: actor, dir: fs.dir}
+}
+
+// Last returns the sum of the last entry appended, to be kept elsewhere to
+// notice the log being truncated.
+func (fs *Audit) Last() string {
+	fs.l.m.Lock()
+	defer fs.l.m.Unlock()
+
+	return fs.l.last
+}
+
+// record appends an entry to the log for the given change.
+func (fs *Audit) record(op, path, to, hash string) error {
+	l := fs.l
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	e := Entry{
+		Seq:   l.seq + 1,
+		Time:  l.clock().UTC(),
+		Actor: fs.actor,
+		Op:    op,
+		Path:  fs.path(path),
+		To:    to,
+		Hash:  hash,
+		Prev:  l.last,
+	}
+
+	var err error
+	if e.Sum, err = e.sum(); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	f, err := l.fs.OpenFile(l.name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+
+	if s, ok := f.(interface{ Sync() error }); ok {
+		if err := s.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	l.seq, l.last = e.Seq, e.Sum
+	return nil
+}
+
+// path returns the given path relative to the root of the filesystem audited.
+func (fs *Audit) path(p string) string {
+	return filepath.Join(string(filepath.Separator), fs.dir, p)
+}
+
+func (fs *Audit) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// OpenFile opens the given file, recording it as written once closed if open
+// for writing.
+func (fs *Audit) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	f, err := fs.Filesystem.OpenFile(filename, flag, perm)
+	if err != nil || !isWrite(flag) {
+		return f, err
+	}
+
+	return &file{File: f, fs: fs, name: filename}, nil
+}
+
+func (fs *Audit) TempFile(dir, prefix string) (billy.File, error) {
+	f, err := fs.Filesystem.TempFile(dir, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return &file{File: f, fs: fs, name: f.Name()}, nil
+}
+
+func (fs *Audit) Rename(from, to string) error {
+	if err := fs.Filesystem.Rename(from, to); err != nil {
+		return err
+	}
+
+	return fs.record("rename", from, fs.path(to), "")
+}
+
+func (fs *Audit) Remove(filename string) error {
+	if err := fs.Filesystem.Remove(filename); err != nil {
+		return err
+	}
+
+	return fs.record("remove", filename, "", "")
+}
+
+func (fs *Audit) MkdirAll(filename string, perm os.FileMode) error {
+	if err := fs.Filesystem.MkdirAll(filename, perm); err != nil {
+		return err
+	}
+
+	return fs.record("mkdirall", filename, "", "")
+}
+
+func (fs *Audit) Symlink(target, link string) error {
+	if err := fs.Filesystem.Symlink(target, link); err != nil {
+		return err
+	}
+
+	return fs.record("symlink", link, target, "")
+}
+
+// Chroot returns the given directory of the underlying filesystem, recording
+// its changes with the paths relative to the root of this one.
+func (fs *Audit) Chroot(path string) (billy.Filesystem, error) {
+	chroot, err := fs.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Audit{
+		Filesystem: chroot,
+		l:          fs.l,
+		actor:      fs.actor,
+		dir:        filepath.Join(fs.dir, path),
+	}, nil
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Audit) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.Filesystem)
+}
+
+// file is a file open for writing, recorded once closed.
+type file struct {
+	billy.File
+	fs   *Audit
+	name string
+}
+
+func (f *file) Close() error {
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+
+	hash, _, err := fileutil.Hash(f.fs.Filesystem, f.name)
+	if err != nil {
+		return err
+	}
+
+	return f.fs.record("write", f.name, "", hash)
+}
+
+// Verify checks the given log, returning the number of entries found valid,
+// and ErrTampered if any entry was changed, removed or added out of order.
+// The truncation of the log is only noticed comparing the sum of the last
+// entry with the one kept elsewhere, see Audit.Last.
+func Verify(fs billy.Basic, name string) (int, error) {
+	n := 0
+	err := readLog(fs, name, func(e *Entry, prev *Entry) error {
+		if err := check(e, prev); err != nil {
+			return err
+		}
+
+		n++
+		return nil
+	})
+
+	return n, err
+}
+
+func check(e, prev *Entry) error {
+	sum, err := e.sum()
+	if err != nil {
+		return err
+	}
+
+	if sum != e.Sum {
+		return ErrTampered
+	}
+
+	if prev == nil {
+		if e.Seq != 1 || e.Prev != "" {
+			return ErrTampered
+		}
+
+		return nil
+	}
+
+	if e.Seq != prev.Seq+1 || e.Prev != prev.Sum {
+		return ErrTampered
+	}
+
+	return nil
+}
+
+// lastEntry returns the last entry of the given log, nil if missing or empty.
+func lastEntry(fs billy.Basic, name string) (*Entry, error) {
+	var last *Entry
+	err := readLog(fs, name, func(e *Entry, _ *Entry) error {
+		last = e
+		return nil
+	})
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	return last, err
+}
+
+// readLog calls fn for every entry of the given log, along with the previous
+// one, ErrTampered if any can't be decoded.
+func readLog(fs billy.Basic, name string, fn func(e, prev *Entry) error) error {
+	f, err := fs.Open(name)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	var prev *Entry
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		e := &Entry{}
+		if err := json.Unmarshal(s.Bytes(), e); err != nil {
+			return ErrTampered
+		}
+
+		if err := fn(e, prev); err != nil {
+			return err
+		}
+
+		prev = e
+	}
+
+	return s.Err()
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
+}
@@ -0,0 +1,146 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&AuditSuite{})
+
+type AuditSuite struct {
+	test.FilesystemSuite
+	log billy.Filesystem
+}
+
+func (s *AuditSuite) SetUpTest(c *C) {
+	s.log = memfs.New()
+	fs, err := New(memfs.New(), Options{Log: s.log})
+	c.Assert(err, IsNil)
+
+	s.FilesystemSuite = test.NewFilesystemSuite(fs)
+}
+
+func (s *AuditSuite) TestRecord(c *C) {
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	fs, err := New(memfs.New(), Options{
+		Log:   s.log,
+		Actor: "alice",
+		Clock: func() time.Time { return now },
+	})
+	c.Assert(err, IsNil)
+
+	c.Assert(util.WriteFile(fs, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(fs.As("bob").Rename("foo", "bar"), IsNil)
+	chroot, err := fs.Chroot("dir")
+	c.Assert(err, IsNil)
+	c.Assert(chroot.Symlink("target", "link"), IsNil)
+	_, err = fs.Open("bar")
+	c.Assert(err, IsNil)
+
+	entries := readEntries(c, s.log)
+	c.Assert(entries, HasLen, 3)
+
+	c.Assert(entries[0].Seq, Equals, int64(1))
+	c.Assert(entries[0].Time.Equal(now), Equals, true)
+	c.Assert(entries[0].Actor, Equals, "alice")
+	c.Assert(entries[0].Op, Equals, "write")
+	c.Assert(entries[0].Path, Equals, "/foo")
+	c.Assert(entries[0].Hash, Equals, "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae")
+	c.Assert(entries[0].Prev, Equals, "")
+
+	c.Assert(entries[1].Actor, Equals, "bob")
+	c.Assert(entries[1].Op, Equals, "rename")
+	c.Assert(entries[1].To, Equals, "/bar")
+	c.Assert(entries[1].Prev, Equals, entries[0].Sum)
+
+	c.Assert(entries[2].Op, Equals, "symlink")
+	c.Assert(entries[2].Path, Equals, "/dir/link")
+	c.Assert(entries[2].To, Equals, "target")
+	c.Assert(fs.Last(), Equals, entries[2].Sum)
+
+	n, err := Verify(s.log, DefaultLogName)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 3)
+}
+
+func (s *AuditSuite) TestContinue(c *C) {
+	fs, err := New(memfs.New(), Options{Log: s.log})
+	c.Assert(err, IsNil)
+	c.Assert(fs.MkdirAll("foo", 0755), IsNil)
+
+	fs, err = New(memfs.New(), Options{Log: s.log})
+	c.Assert(err, IsNil)
+	c.Assert(fs.MkdirAll("bar", 0755), IsNil)
+
+	entries := readEntries(c, s.log)
+	c.Assert(entries, HasLen, 2)
+	c.Assert(entries[1].Seq, Equals, int64(2))
+	c.Assert(entries[1].Prev, Equals, entries[0].Sum)
+
+	n, err := Verify(s.log, DefaultLogName)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 2)
+}
+
+func (s *AuditSuite) TestVerifyTampered(c *C) {
+	fs, err := New(memfs.New(), Options{Log: s.log})
+	c.Assert(err, IsNil)
+	c.Assert(fs.MkdirAll("foo", 0755), IsNil)
+	c.Assert(fs.MkdirAll("bar", 0755), IsNil)
+	c.Assert(fs.MkdirAll("qux", 0755), IsNil)
+
+	content := readContent(c, s.log)
+	lines := strings.SplitAfter(content, "\n")
+
+	changed := strings.Replace(content, `"/bar"`, `"/baz"`, 1)
+	c.Assert(util.WriteFile(s.log, DefaultLogName, []byte(changed), 0644), IsNil)
+	n, err := Verify(s.log, DefaultLogName)
+	c.Assert(err, Equals, ErrTampered)
+	c.Assert(n, Equals, 1)
+
+	removed := lines[0] + lines[2]
+	c.Assert(util.WriteFile(s.log, DefaultLogName, []byte(removed), 0644), IsNil)
+	n, err = Verify(s.log, DefaultLogName)
+	c.Assert(err, Equals, ErrTampered)
+	c.Assert(n, Equals, 1)
+}
+
+func readEntries(c *C, log billy.Filesystem) []*Entry {
+	f, err := log.Open(DefaultLogName)
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	var entries []*Entry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		e := &Entry{}
+		c.Assert(json.Unmarshal(scanner.Bytes(), e), IsNil)
+		entries = append(entries, e)
+	}
+
+	c.Assert(scanner.Err(), IsNil)
+	return entries
+}
+
+func readContent(c *C, log billy.Filesystem) string {
+	f, err := log.Open(DefaultLogName)
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	return string(content)
+}
@@ -0,0 +1,362 @@
+// Package cache provides a helper keeping in memory, or in a local
+// filesystem, the content and the metadata of the files read from a slow
+// filesystem, eg.: served over the network.
+package cache
+
+import (
+	"container/list"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const (
+	separator = string(filepath.Separator)
+
+	// DefaultSize is the size of the cache used if none is given.
+	DefaultSize = 64 << 20
+)
+
+// Options holds the configuration of a Cache.
+type Options struct {
+	// Size is the maximum size of the content of the files kept, DefaultSize
+	// if 0. The least recently read files are evicted when exceeded, and the
+	// files larger than it are never cached.
+	Size int64
+	// TTL is the time the metadata and the content of a file are kept since
+	// they are read, until invalidated if 0.
+	TTL time.Duration
+	// Storage is the filesystem where the content of the files is kept,
+	// memory if nil. It should be a filesystem used only by the cache.
+	Storage billy.Filesystem
+}
+
+// Cache is a helper caching the results of Stat and Lstat, and the content of
+// the files open for reading, of the underlying filesystem. Every change made
+// through the cache invalidates the files involved, but not the links to
+// them; the changes made by others must be reported with Invalidate.
+type Cache struct {
+	billy.Filesystem
+	opts Options
+
+	m      sync.Mutex
+	stats  map[string]*stat
+	lstats map[string]*stat
+	files  map[string]*list.Element
+	lru    *list.List
+	used   int64
+	next   int
+}
+
+type stat struct {
+	fi      os.FileInfo
+	expires time.Time
+}
+
+// entry is the content of a cached file, in memory or in the storage.
+type entry struct {
+	path    string
+	content []byte
+	stored  string
+	size    int64
+	expires time.Time
+}
+
+// New creates a new cache of the given filesystem.
+func New(fs billy.Filesystem, opts Options) *Cache {
+	if opts.Size == 0 {
+		opts.Size = DefaultSize
+	}
+
+	return &Cache{
+		Filesystem: fs,
+		opts:       opts,
+		stats:      make(map[string]*stat),
+		lstats:     make(map[string]*stat),
+		files:      make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+func (c *Cache) Create(filename string) (billy.File, error) {
+	return c.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (c *Cache) Open(filename string) (billy.File, error) {
+	return c.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file, from the cache if open for reading. The
+// files open for writing are invalidated when opened and closed.
+func (c *Cache) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if flag != os.O_RDONLY {
+		c.Invalidate(filename)
+		f, err := c.Filesystem.OpenFile(filename, flag, perm)
+		if err != nil {
+			return nil, err
+		}
+
+		return &writeFile{File: f, cache: c, path: filename}, nil
+	}
+
+	if f, err := c.openCached(filename); f != nil || err != nil {
+		return f, err
+	}
+
+	fi, err := c.Stat(filename)
+	if err != nil || fi.IsDir() || fi.Size() > c.opts.Size {
+		return c.Filesystem.OpenFile(filename, flag, perm)
+	}
+
+	content, err := readAll(c.Filesystem, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.add(filename, content); err != nil {
+		return nil, err
+	}
+
+	return newFile(filename, content), nil
+}
+
+// openCached opens the given file from the cache, returning nil if missing.
+func (c *Cache) openCached(filename string) (billy.File, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	elem, ok := c.files[clean(filename)]
+	if !ok {
+		return nil, nil
+	}
+
+	e := elem.Value.(*entry)
+	if c.expired(e.expires) {
+		c.evict(elem)
+		return nil, nil
+	}
+
+	c.lru.MoveToFront(elem)
+	if e.stored == "" {
+		return newFile(filename, e.content), nil
+	}
+
+	f, err := c.opts.Storage.Open(e.stored)
+	if err != nil {
+		return nil, err
+	}
+
+	return &storedFile{File: f, name: filename}, nil
+}
+
+// add adds the given content to the cache, evicting the least recently read
+// files if needed.
+func (c *Cache) add(filename string, content []byte) error {
+	e := &entry{path: clean(filename), size: int64(len(content))}
+	if c.opts.TTL != 0 {
+		e.expires = time.Now().Add(c.opts.TTL)
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.opts.Storage == nil {
+		e.content = content
+	} else {
+		c.next++
+		e.stored = fmt.Sprint(c.next)
+		if err := util.WriteFile(c.opts.Storage, e.stored, content, 0600); err != nil {
+			return err
+		}
+	}
+
+	if elem, ok := c.files[e.path]; ok {
+		c.evict(elem)
+	}
+
+	for c.used+e.size > c.opts.Size && c.lru.Len() != 0 {
+		c.evict(c.lru.Back())
+	}
+
+	c.files[e.path] = c.lru.PushFront(e)
+	c.used += e.size
+	return nil
+}
+
+// evict removes the given file from the cache. It must be called with the
+// lock held.
+func (c *Cache) evict(elem *list.Element) {
+	e := c.lru.Remove(elem).(*entry)
+	delete(c.files, e.path)
+	c.used -= e.size
+
+	if e.stored != "" {
+		c.opts.Storage.Remove(e.stored)
+	}
+}
+
+func (c *Cache) expired(expires time.Time) bool {
+	return !expires.IsZero() && time.Now().After(expires)
+}
+
+func (c *Cache) Stat(filename string) (os.FileInfo, error) {
+	return c.stat(filename, false)
+}
+
+func (c *Cache) Lstat(filename string) (os.FileInfo, error) {
+	return c.stat(filename, true)
+}
+
+func (c *Cache) stat(filename string, lstat bool) (os.FileInfo, error) {
+	key, stats := clean(filename), c.stats
+	if lstat {
+		stats = c.lstats
+	}
+
+	c.m.Lock()
+	s, ok := stats[key]
+	if ok && !c.expired(s.expires) {
+		c.m.Unlock()
+		return s.fi, nil
+	}
+
+	c.m.Unlock()
+
+	var fi os.FileInfo
+	var err error
+	if lstat {
+		fi, err = c.Filesystem.Lstat(filename)
+	} else {
+		fi, err = c.Filesystem.Stat(filename)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	s = &stat{fi: fi}
+	if c.opts.TTL != 0 {
+		s.expires = time.Now().Add(c.opts.TTL)
+	}
+
+	c.m.Lock()
+	stats[key] = s
+	c.m.Unlock()
+	return fi, nil
+}
+
+// Invalidate removes from the cache the given file, and everything below it.
+// It must be called when the underlying filesystem is modified by others.
+func (c *Cache) Invalidate(filename string) {
+	p := clean(filename)
+	below := func(path string) bool {
+		return path == p || strings.HasPrefix(path, p+separator) || p == separator
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	for _, stats := range []map[string]*stat{c.stats, c.lstats} {
+		for path := range stats {
+			if below(path) {
+				delete(stats, path)
+			}
+		}
+	}
+
+	for path, elem := range c.files {
+		if below(path) {
+			c.evict(elem)
+		}
+	}
+}
+
+// InvalidateAll empties the cache.
+func (c *Cache) InvalidateAll() {
+	c.Invalidate(separator)
+}
+
+func (c *Cache) Rename(from, to string) error {
+	defer c.Invalidate(to)
+	defer c.Invalidate(from)
+	return c.Filesystem.Rename(from, to)
+}
+
+func (c *Cache) Remove(filename string) error {
+	defer c.Invalidate(filename)
+	return c.Filesystem.Remove(filename)
+}
+
+func (c *Cache) MkdirAll(filename string, perm os.FileMode) error {
+	defer c.Invalidate(filename)
+	return c.Filesystem.MkdirAll(filename, perm)
+}
+
+func (c *Cache) Symlink(target, link string) error {
+	defer c.Invalidate(link)
+	return c.Filesystem.Symlink(target, link)
+}
+
+func (c *Cache) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(c, dir, prefix)
+}
+
+func (c *Cache) Chroot(path string) (billy.Filesystem, error) {
+	return chroot.New(c, c.Join(c.Root(), path)), nil
+}
+
+func (c *Cache) Root() string {
+	return separator
+}
+
+// Capabilities implements the Capable interface.
+func (c *Cache) Capabilities() billy.Capability {
+	return billy.Capabilities(c.Filesystem)
+}
+
+// writeFile is a file open for writing, invalidated again when closed, since
+// it could be read while written.
+type writeFile struct {
+	billy.File
+	cache *Cache
+	path  string
+}
+
+func (f *writeFile) Close() error {
+	defer f.cache.Invalidate(f.path)
+	return f.File.Close()
+}
+
+// storedFile is the content of a cached file open from the storage.
+type storedFile struct {
+	billy.File
+	name string
+}
+
+func (f *storedFile) Name() string {
+	return f.name
+}
+
+// clean returns the given path as an absolute clean path, the form used as
+// key of the cache.
+func clean(p string) string {
+	return filepath.Join(separator, p)
+}
+
+func readAll(fs billy.Basic, filename string) ([]byte, error) {
+	f, err := fs.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
@@ -0,0 +1,167 @@
+package cache
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&CacheSuite{})
+
+type CacheSuite struct {
+	test.FilesystemSuite
+	underlying *counting
+	cache      *Cache
+}
+
+// counting is a filesystem counting the files open and stated.
+type counting struct {
+	billy.Filesystem
+	opens, stats int
+}
+
+func (fs *counting) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	fs.opens++
+	return fs.Filesystem.OpenFile(filename, flag, perm)
+}
+
+func (fs *counting) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (fs *counting) Stat(filename string) (os.FileInfo, error) {
+	fs.stats++
+	return fs.Filesystem.Stat(filename)
+}
+
+func (s *CacheSuite) SetUpTest(c *C) {
+	s.underlying = &counting{Filesystem: memfs.New()}
+	s.cache = New(s.underlying, Options{})
+	s.FilesystemSuite = test.NewFilesystemSuite(s.cache)
+}
+
+func (s *CacheSuite) TestCachedRead(c *C) {
+	c.Assert(util.WriteFile(s.underlying, "foo", []byte("foo"), 0644), IsNil)
+	s.underlying.opens = 0
+
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "foo")
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "foo")
+	c.Assert(s.underlying.opens, Equals, 1)
+	c.Assert(s.underlying.stats, Equals, 1)
+
+	fi, err := s.FS.Stat("/foo")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Size(), Equals, int64(3))
+	c.Assert(s.underlying.stats, Equals, 1)
+}
+
+func (s *CacheSuite) TestWriteInvalidates(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "foo")
+
+	f, err := s.FS.OpenFile("foo", os.O_WRONLY|os.O_APPEND, 0)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("bar"))
+	c.Assert(err, IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "foobar")
+	c.Assert(f.Close(), IsNil)
+
+	fi, err := s.FS.Stat("foo")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Size(), Equals, int64(6))
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "foobar")
+}
+
+func (s *CacheSuite) TestRenameInvalidates(c *C) {
+	c.Assert(util.WriteFile(s.FS, "dir/foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "bar", []byte("bar"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "dir/foo"), Equals, "foo")
+	c.Assert(test.ReadFile(c, s.FS, "bar"), Equals, "bar")
+
+	c.Assert(s.FS.Rename("bar", "dir/foo"), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "dir/foo"), Equals, "bar")
+
+	c.Assert(s.FS.Remove("dir/foo"), IsNil)
+	_, err := s.FS.Stat("dir/foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *CacheSuite) TestInvalidate(c *C) {
+	c.Assert(util.WriteFile(s.underlying, "dir/foo", []byte("foo"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "dir/foo"), Equals, "foo")
+
+	c.Assert(util.WriteFile(s.underlying, "dir/foo", []byte("bar"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "dir/foo"), Equals, "foo")
+
+	s.cache.Invalidate("dir")
+	c.Assert(test.ReadFile(c, s.FS, "dir/foo"), Equals, "bar")
+
+	c.Assert(util.WriteFile(s.underlying, "dir/foo", []byte("qux"), 0644), IsNil)
+	s.cache.InvalidateAll()
+	c.Assert(test.ReadFile(c, s.FS, "dir/foo"), Equals, "qux")
+}
+
+func (s *CacheSuite) TestEviction(c *C) {
+	s.cache = New(s.underlying, Options{Size: 6})
+	c.Assert(util.WriteFile(s.underlying, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.underlying, "bar", []byte("bar"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.underlying, "qux", []byte("qux"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.underlying, "large", []byte("too large"), 0644), IsNil)
+
+	c.Assert(test.ReadFile(c, s.cache, "foo"), Equals, "foo")
+	c.Assert(test.ReadFile(c, s.cache, "bar"), Equals, "bar")
+	c.Assert(test.ReadFile(c, s.cache, "foo"), Equals, "foo")
+	c.Assert(test.ReadFile(c, s.cache, "qux"), Equals, "qux")
+	c.Assert(s.cache.used, Equals, int64(6))
+
+	// bar was the least recently read
+	s.underlying.opens = 0
+	c.Assert(test.ReadFile(c, s.cache, "foo"), Equals, "foo")
+	c.Assert(s.underlying.opens, Equals, 0)
+	c.Assert(test.ReadFile(c, s.cache, "bar"), Equals, "bar")
+	c.Assert(s.underlying.opens, Equals, 1)
+
+	c.Assert(test.ReadFile(c, s.cache, "large"), Equals, "too large")
+	c.Assert(test.ReadFile(c, s.cache, "large"), Equals, "too large")
+	c.Assert(s.underlying.opens, Equals, 3)
+	c.Assert(s.cache.used, Equals, int64(6))
+}
+
+func (s *CacheSuite) TestTTL(c *C) {
+	s.cache = New(s.underlying, Options{TTL: time.Millisecond})
+	c.Assert(util.WriteFile(s.underlying, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.cache, "foo"), Equals, "foo")
+
+	c.Assert(util.WriteFile(s.underlying, "foo", []byte("bar"), 0644), IsNil)
+	time.Sleep(10 * time.Millisecond)
+	c.Assert(test.ReadFile(c, s.cache, "foo"), Equals, "bar")
+}
+
+func (s *CacheSuite) TestStorage(c *C) {
+	storage := memfs.New()
+	s.cache = New(s.underlying, Options{Size: 3, Storage: storage})
+	c.Assert(util.WriteFile(s.underlying, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.underlying, "bar", []byte("bar"), 0644), IsNil)
+
+	c.Assert(test.ReadFile(c, s.cache, "foo"), Equals, "foo")
+	c.Assert(test.ReadFile(c, s.cache, "foo"), Equals, "foo")
+	c.Assert(test.ReadFile(c, s.cache, "bar"), Equals, "bar")
+
+	f, err := s.cache.Open("bar")
+	c.Assert(err, IsNil)
+	c.Assert(f.Name(), Equals, "bar")
+	c.Assert(f.Close(), IsNil)
+
+	infos, err := storage.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 1)
+}
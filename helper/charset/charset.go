@@ -0,0 +1,237 @@
+// Package charset provides a helper translating the names of the files
+// between the UTF-8 used by the callers and the encoding of a filesystem,
+// eg.: trees written with ISO 8859-1 names by legacy systems.
+package charset
+
+import (
+	"os"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// Charset is a helper that encodes the names given to the underlying
+// filesystem and decodes the names returned by it.
+//
+// The bytes of the stored names that can't be decoded are presented as
+// runes of the EscapeBase range, and written back as the original bytes,
+// so every file can be accessed. The names with characters that can't be
+// encoded fail with ErrUnencodable.
+type Charset struct {
+	underlying billy.Filesystem
+	enc        Encoding
+}
+
+// New creates a new filesystem wrapping up the given 'fs', where the names
+// are stored with the given encoding.
+func New(fs billy.Filesystem, enc Encoding) billy.Filesystem {
+	return &Charset{underlying: fs, enc: enc}
+}
+
+func (fs *Charset) encode(op, name string) (string, error) {
+	encoded, err := Encode(fs.enc, name)
+	if err != nil {
+		return "", &os.PathError{Op: op, Path: name, Err: err}
+	}
+
+	return encoded, nil
+}
+
+func (fs *Charset) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Charset) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (fs *Charset) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	name, err := fs.encode("open", filename)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := fs.underlying.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.newFile(f), nil
+}
+
+func (fs *Charset) Stat(filename string) (os.FileInfo, error) {
+	name, err := fs.encode("stat", filename)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := fs.underlying.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.newFileInfo(fi), nil
+}
+
+func (fs *Charset) Lstat(filename string) (os.FileInfo, error) {
+	name, err := fs.encode("lstat", filename)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := fs.underlying.Lstat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.newFileInfo(fi), nil
+}
+
+func (fs *Charset) Rename(from, to string) error {
+	encodedFrom, err := fs.encode("rename", from)
+	if err != nil {
+		return err
+	}
+
+	encodedTo, err := fs.encode("rename", to)
+	if err != nil {
+		return err
+	}
+
+	return fs.underlying.Rename(encodedFrom, encodedTo)
+}
+
+func (fs *Charset) Remove(filename string) error {
+	name, err := fs.encode("remove", filename)
+	if err != nil {
+		return err
+	}
+
+	return fs.underlying.Remove(name)
+}
+
+func (fs *Charset) Join(elem ...string) string {
+	return fs.underlying.Join(elem...)
+}
+
+func (fs *Charset) TempFile(dir, prefix string) (billy.File, error) {
+	encodedDir, err := fs.encode("tempfile", dir)
+	if err != nil {
+		return nil, err
+	}
+
+	encodedPrefix, err := fs.encode("tempfile", prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := fs.underlying.TempFile(encodedDir, encodedPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.newFile(f), nil
+}
+
+func (fs *Charset) ReadDir(path string) ([]os.FileInfo, error) {
+	name, err := fs.encode("readdir", path)
+	if err != nil {
+		return nil, err
+	}
+
+	infos, err := fs.underlying.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, fi := range infos {
+		infos[i] = fs.newFileInfo(fi)
+	}
+
+	return infos, nil
+}
+
+func (fs *Charset) MkdirAll(filename string, perm os.FileMode) error {
+	name, err := fs.encode("mkdir", filename)
+	if err != nil {
+		return err
+	}
+
+	return fs.underlying.MkdirAll(name, perm)
+}
+
+func (fs *Charset) Symlink(target, link string) error {
+	encodedTarget, err := fs.encode("symlink", target)
+	if err != nil {
+		return err
+	}
+
+	encodedLink, err := fs.encode("symlink", link)
+	if err != nil {
+		return err
+	}
+
+	return fs.underlying.Symlink(encodedTarget, encodedLink)
+}
+
+func (fs *Charset) Readlink(link string) (string, error) {
+	name, err := fs.encode("readlink", link)
+	if err != nil {
+		return "", err
+	}
+
+	target, err := fs.underlying.Readlink(name)
+	if err != nil {
+		return "", err
+	}
+
+	return Decode(fs.enc, target), nil
+}
+
+func (fs *Charset) Chroot(path string) (billy.Filesystem, error) {
+	name, err := fs.encode("chroot", path)
+	if err != nil {
+		return nil, err
+	}
+
+	chroot, err := fs.underlying.Chroot(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(chroot, fs.enc), nil
+}
+
+func (fs *Charset) Root() string {
+	return Decode(fs.enc, fs.underlying.Root())
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Charset) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.underlying)
+}
+
+func (fs *Charset) newFile(f billy.File) billy.File {
+	return &file{File: f, name: Decode(fs.enc, f.Name())}
+}
+
+func (fs *Charset) newFileInfo(fi os.FileInfo) os.FileInfo {
+	return &fileInfo{FileInfo: fi, name: Decode(fs.enc, fi.Name())}
+}
+
+type file struct {
+	billy.File
+	name string
+}
+
+func (f *file) Name() string {
+	return f.name
+}
+
+type fileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
@@ -0,0 +1,107 @@
+package charset
+
+import (
+	"os"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&CharsetSuite{})
+
+type CharsetSuite struct {
+	test.FilesystemSuite
+	underlying billy.Filesystem
+}
+
+func (s *CharsetSuite) SetUpTest(c *C) {
+	s.underlying = memfs.New()
+	s.FilesystemSuite = test.NewFilesystemSuite(New(s.underlying, Latin1))
+}
+
+func (s *CharsetSuite) TestWrite(c *C) {
+	c.Assert(util.WriteFile(s.FS, "café/naïve", []byte("foo"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.underlying, "caf\xe9/na\xefve"), Equals, "foo")
+
+	f, err := s.FS.Open("café/naïve")
+	c.Assert(err, IsNil)
+	c.Assert(f.Name(), Equals, "café/naïve")
+	c.Assert(f.Close(), IsNil)
+}
+
+func (s *CharsetSuite) TestRead(c *C) {
+	c.Assert(util.WriteFile(s.underlying, "foo/na\xefve", []byte("foo"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "foo/naïve"), Equals, "foo")
+
+	infos, err := s.FS.ReadDir("foo")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 1)
+	c.Assert(infos[0].Name(), Equals, "naïve")
+
+	fi, err := s.FS.Stat("foo/naïve")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Name(), Equals, "naïve")
+}
+
+func (s *CharsetSuite) TestUnencodable(c *C) {
+	err := util.WriteFile(s.FS, "日本", []byte("foo"), 0644)
+	c.Assert(err, NotNil)
+	c.Assert(err.(*os.PathError).Err, Equals, ErrUnencodable)
+
+	_, err = s.FS.Stat("foo\xff")
+	c.Assert(err.(*os.PathError).Err, Equals, ErrUnencodable)
+}
+
+func (s *CharsetSuite) TestEscape(c *C) {
+	fs := New(s.underlying, UTF8)
+	c.Assert(util.WriteFile(s.underlying, "foo\xffbar", []byte("foo"), 0644), IsNil)
+
+	infos, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 1)
+
+	name := infos[0].Name()
+	c.Assert(name, Equals, "foo"+string(EscapeBase+0xff)+"bar")
+	c.Assert(test.ReadFile(c, fs, name), Equals, "foo")
+
+	c.Assert(fs.Rename(name, "qux"), IsNil)
+	c.Assert(test.ReadFile(c, s.underlying, "qux"), Equals, "foo")
+}
+
+func (s *CharsetSuite) TestSymlinkTarget(c *C) {
+	c.Assert(s.FS.Symlink("café", "link"), IsNil)
+
+	target, err := s.underlying.Readlink("link")
+	c.Assert(err, IsNil)
+	c.Assert(target, Equals, "caf\xe9")
+
+	target, err = s.FS.Readlink("link")
+	c.Assert(err, IsNil)
+	c.Assert(target, Equals, "café")
+}
+
+func (s *CharsetSuite) TestChrootEncoded(c *C) {
+	c.Assert(util.WriteFile(s.underlying, "caf\xe9/foo", []byte("foo"), 0644), IsNil)
+
+	fs, err := s.FS.Chroot("café")
+	c.Assert(err, IsNil)
+	c.Assert(test.ReadFile(c, fs, "foo"), Equals, "foo")
+}
+
+func (s *CharsetSuite) TestWindows1252(c *C) {
+	c.Assert(Decode(Windows1252, "\x80 \xe9\x81"), Equals, "€ é"+string(EscapeBase+0x81))
+
+	encoded, err := Encode(Windows1252, "€ é"+string(EscapeBase+0x81))
+	c.Assert(err, IsNil)
+	c.Assert(encoded, Equals, "\x80 \xe9\x81")
+
+	_, err = Encode(Latin1, "€")
+	c.Assert(err, Equals, ErrUnencodable)
+}
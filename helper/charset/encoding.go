@@ -0,0 +1,170 @@
+package charset
+
+import (
+	"errors"
+	"unicode/utf8"
+)
+
+// EscapeBase is the first rune of the range used to present the bytes that
+// can't be decoded, the byte b is presented as the rune EscapeBase+b. The
+// range is in the supplementary private use area, so it's not expected to
+// be found in real names.
+const EscapeBase rune = 0x10FF00
+
+// ErrUnencodable is returned when a name has characters that can't be
+// represented in the encoding of the underlying filesystem.
+var ErrUnencodable = errors.New("name not representable in the encoding")
+
+// Encoding is a character encoding of the names of a filesystem. It must be
+// a superset of ASCII, at least for the separators.
+type Encoding interface {
+	// DecodeRune decodes the first character of p, returning it and its
+	// size in bytes, a zero size means that p doesn't start with a valid
+	// character.
+	DecodeRune(p []byte) (r rune, size int)
+	// AppendRune appends the encoding of r to p, returning false if r can't
+	// be encoded.
+	AppendRune(p []byte, r rune) ([]byte, bool)
+}
+
+// Table is a single byte Encoding, defined by the rune of each byte.
+type Table struct {
+	runes [256]rune
+	bytes map[rune]byte
+}
+
+// NewTable returns a single byte Encoding from the rune of each byte, the
+// bytes without a character must be -1.
+func NewTable(runes [256]rune) *Table {
+	t := &Table{runes: runes, bytes: make(map[rune]byte, 256)}
+	for b, r := range runes {
+		if r >= 0 {
+			t.bytes[r] = byte(b)
+		}
+	}
+
+	return t
+}
+
+// DecodeRune implements the Encoding interface.
+func (t *Table) DecodeRune(p []byte) (rune, int) {
+	if len(p) == 0 || t.runes[p[0]] < 0 {
+		return 0, 0
+	}
+
+	return t.runes[p[0]], 1
+}
+
+// AppendRune implements the Encoding interface.
+func (t *Table) AppendRune(p []byte, r rune) ([]byte, bool) {
+	b, ok := t.bytes[r]
+	if !ok {
+		return p, false
+	}
+
+	return append(p, b), true
+}
+
+var (
+	// Latin1 is the ISO 8859-1 encoding.
+	Latin1 = NewTable(latin1())
+	// Windows1252 is the Windows code page 1252, a superset of ISO 8859-1
+	// used by default in western Windows systems.
+	Windows1252 = NewTable(windows1252())
+	// UTF8 is the UTF-8 encoding, useful to escape the invalid sequences of
+	// names that should be UTF-8.
+	UTF8 Encoding = utf8Encoding{}
+)
+
+func latin1() [256]rune {
+	var runes [256]rune
+	for i := range runes {
+		runes[i] = rune(i)
+	}
+
+	return runes
+}
+
+func windows1252() [256]rune {
+	runes := latin1()
+	copy(runes[0x80:0xa0], []rune{
+		0x20ac, -1, 0x201a, 0x0192, 0x201e, 0x2026, 0x2020, 0x2021,
+		0x02c6, 0x2030, 0x0160, 0x2039, 0x0152, -1, 0x017d, -1,
+		-1, 0x2018, 0x2019, 0x201c, 0x201d, 0x2022, 0x2013, 0x2014,
+		0x02dc, 0x2122, 0x0161, 0x203a, 0x0153, -1, 0x017e, 0x0178,
+	})
+
+	return runes
+}
+
+type utf8Encoding struct{}
+
+func (utf8Encoding) DecodeRune(p []byte) (rune, int) {
+	r, size := utf8.DecodeRune(p)
+	if r == utf8.RuneError && size <= 1 {
+		return 0, 0
+	}
+
+	return r, size
+}
+
+func (utf8Encoding) AppendRune(p []byte, r rune) ([]byte, bool) {
+	if !utf8.ValidRune(r) {
+		return p, false
+	}
+
+	var buf [utf8.UTFMax]byte
+	n := utf8.EncodeRune(buf[:], r)
+	return append(p, buf[:n]...), true
+}
+
+// Decode returns the given name, in the given encoding, as UTF-8. The bytes
+// that can't be decoded are escaped in the EscapeBase range.
+func Decode(enc Encoding, name string) string {
+	p := []byte(name)
+	buf := make([]byte, 0, len(p))
+	for len(p) != 0 {
+		r, size := enc.DecodeRune(p)
+		if size == 0 {
+			r, size = EscapeBase+rune(p[0]), 1
+		}
+
+		buf = appendUTF8(buf, r)
+		p = p[size:]
+	}
+
+	return string(buf)
+}
+
+// Encode returns the given UTF-8 name in the given encoding, the runes in
+// the EscapeBase range are written back as the bytes they escape. It fails
+// with ErrUnencodable if the name has characters not supported by the
+// encoding, or it's not valid UTF-8.
+func Encode(enc Encoding, name string) (string, error) {
+	buf := make([]byte, 0, len(name))
+	for i, r := range name {
+		if r == utf8.RuneError {
+			if _, size := utf8.DecodeRuneInString(name[i:]); size == 1 {
+				return "", ErrUnencodable
+			}
+		}
+
+		if r >= EscapeBase && r <= EscapeBase+0xff {
+			buf = append(buf, byte(r-EscapeBase))
+			continue
+		}
+
+		var ok bool
+		if buf, ok = enc.AppendRune(buf, r); !ok {
+			return "", ErrUnencodable
+		}
+	}
+
+	return string(buf), nil
+}
+
+func appendUTF8(p []byte, r rune) []byte {
+	var buf [utf8.UTFMax]byte
+	n := utf8.EncodeRune(buf[:], r)
+	return append(p, buf[:n]...)
+}
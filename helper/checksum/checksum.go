@@ -0,0 +1,334 @@
+// Package checksum provides a helper recording the checksum of the files
+// written to a filesystem, to notice them corrupted when read, eg.: to keep
+// caches on unreliable disks.
+package checksum
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/internal/fileutil"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+// DefaultManifest is the name of the manifest used if none is given.
+const DefaultManifest = ".checksums"
+
+const separator = string(filepath.Separator)
+
+// ErrChecksumMismatch is returned when opening a file whose content doesn't
+// match the checksum recorded when written.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Options holds the configuration of a Checksum.
+type Options struct {
+	// Manifest is the name of the file, in the root of the underlying
+	// filesystem, where the checksums are kept, DefaultManifest if empty.
+	Manifest string
+}
+
+// Checksum is a helper passing every operation through to the underlying
+// filesystem, recording the SHA-256 of every file once written and closed,
+// and verifying it every time the file is open, failing with
+// ErrChecksumMismatch if it doesn't match.
+//
+// The checksums are kept in a JSON manifest in the underlying filesystem,
+// which is hidden, and rewritten every time a file is written, renamed or
+// removed. The files written without the helper aren't verified.
+type Checksum struct {
+	billy.Filesystem
+	m *manifest
+	// dir is the directory the root was moved to by Chroot, as the manifest
+	// keeps the paths from the root of the underlying filesystem.
+	dir string
+}
+
+// manifest holds the checksums shared by a Checksum and its chroots.
+type manifest struct {
+	fs   billy.Filesystem
+	name string
+
+	m    sync.Mutex
+	sums map[string]string
+}
+
+// New creates a new filesystem recording and verifying the checksums of the
+// files of the given one, loading the manifest if it exists.
+func New(fs billy.Filesystem, opts Options) (*Checksum, error) {
+	if opts.Manifest == "" {
+		opts.Manifest = DefaultManifest
+	}
+
+	m := &manifest{fs: fs, name: opts.Manifest, sums: make(map[string]string)}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+
+	return &Checksum{Filesystem: fs, m: m}, nil
+}
+
+func (m *manifest) load() error {
+	f, err := m.fs.Open(m.name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(content, &m.sums)
+}
+
+// save writes the manifest, replacing it once written. It must be called
+// with the lock held.
+func (m *manifest) save() error {
+	content, err := json.Marshal(m.sums)
+	if err != nil {
+		return err
+	}
+
+	f, err := util.TempFile(m.fs, separator, m.name)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		m.fs.Remove(f.Name())
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		m.fs.Remove(f.Name())
+		return err
+	}
+
+	return m.fs.Rename(f.Name(), m.name)
+}
+
+// set records the checksum of the given file, removing it if empty.
+func (m *manifest) set(p, sum string) error {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	if sum == "" {
+		if _, ok := m.sums[p]; !ok {
+			return nil
+		}
+
+		delete(m.sums, p)
+	} else {
+		m.sums[p] = sum
+	}
+
+	return m.save()
+}
+
+func (m *manifest) get(p string) (string, bool) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	sum, ok := m.sums[p]
+	return sum, ok
+}
+
+// move moves the checksums of the given file, and of the files below it,
+// forgetting the ones replaced.
+func (m *manifest) move(from, to string) error {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	moved := make(map[string]string)
+	changed := false
+	for p, sum := range m.sums {
+		switch {
+		case isBelow(p, from):
+			moved[to+p[len(from):]] = sum
+		case !isBelow(p, to):
+			continue
+		}
+
+		delete(m.sums, p)
+		changed = true
+	}
+
+	for p, sum := range moved {
+		m.sums[p] = sum
+	}
+
+	if !changed {
+		return nil
+	}
+
+	return m.save()
+}
+
+// path returns the given path as recorded, relative to the root of the
+// underlying filesystem.
+func (fs *Checksum) path(p string) string {
+	return filepath.Join(separator, fs.dir, p)
+}
+
+func (fs *Checksum) isManifest(p string) bool {
+	return fs.path(p) == filepath.Join(separator, fs.m.name)
+}
+
+func (fs *Checksum) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Checksum) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file, verifying its content unless truncated. The
+// files open for writing are recorded once closed.
+func (fs *Checksum) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if fs.isManifest(filename) {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrPermission}
+	}
+
+	if flag&os.O_TRUNC == 0 {
+		if err := fs.verify(filename); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := fs.Filesystem.OpenFile(filename, flag, perm)
+	if err != nil || !isWrite(flag) {
+		return f, err
+	}
+
+	return &file{File: f, fs: fs, name: filename}, nil
+}
+
+// verify checks the content of the given file, if recorded.
+func (fs *Checksum) verify(filename string) error {
+	expected, ok := fs.m.get(fs.path(filename))
+	if !ok {
+		return nil
+	}
+
+	sum, _, err := fileutil.Hash(fs.Filesystem, filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if sum != expected {
+		return &os.PathError{Op: "open", Path: filename, Err: ErrChecksumMismatch}
+	}
+
+	return nil
+}
+
+func (fs *Checksum) TempFile(dir, prefix string) (billy.File, error) {
+	f, err := fs.Filesystem.TempFile(dir, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return &file{File: f, fs: fs, name: f.Name()}, nil
+}
+
+// ReadDir returns the entries of the given directory, but the manifest.
+func (fs *Checksum) ReadDir(path string) ([]os.FileInfo, error) {
+	infos, err := fs.Filesystem.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, fi := range infos {
+		if fs.isManifest(filepath.Join(path, fi.Name())) {
+			return append(infos[:i], infos[i+1:]...), nil
+		}
+	}
+
+	return infos, nil
+}
+
+func (fs *Checksum) Rename(from, to string) error {
+	if fs.isManifest(from) || fs.isManifest(to) {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: os.ErrPermission}
+	}
+
+	if err := fs.Filesystem.Rename(from, to); err != nil {
+		return err
+	}
+
+	return fs.m.move(fs.path(from), fs.path(to))
+}
+
+func (fs *Checksum) Remove(filename string) error {
+	if fs.isManifest(filename) {
+		return &os.PathError{Op: "remove", Path: filename, Err: os.ErrPermission}
+	}
+
+	if err := fs.Filesystem.Remove(filename); err != nil {
+		return err
+	}
+
+	return fs.m.set(fs.path(filename), "")
+}
+
+// Chroot returns the given directory of the underlying filesystem, sharing
+// the manifest.
+func (fs *Checksum) Chroot(path string) (billy.Filesystem, error) {
+	chroot, err := fs.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Checksum{Filesystem: chroot, m: fs.m, dir: filepath.Join(fs.dir, path)}, nil
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Checksum) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.Filesystem)
+}
+
+// file is a file open for writing, whose checksum is recorded once closed.
+type file struct {
+	billy.File
+	fs   *Checksum
+	name string
+}
+
+func (f *file) Close() error {
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+
+	sum, _, err := fileutil.Hash(f.fs.Filesystem, f.name)
+	if err != nil {
+		return err
+	}
+
+	return f.fs.m.set(f.fs.path(f.name), sum)
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
+}
+
+// isBelow returns whether the given path is the given directory, or below it.
+func isBelow(p, dir string) bool {
+	return p == dir || strings.HasPrefix(p, dir+separator)
+}
@@ -0,0 +1,89 @@
+package checksum
+
+import (
+	"os"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&ChecksumSuite{})
+
+type ChecksumSuite struct {
+	test.FilesystemSuite
+	underlying billy.Filesystem
+	fs         *Checksum
+}
+
+func (s *ChecksumSuite) SetUpTest(c *C) {
+	s.underlying = memfs.New()
+
+	var err error
+	s.fs, err = New(s.underlying, Options{})
+	c.Assert(err, IsNil)
+
+	s.FilesystemSuite = test.NewFilesystemSuite(s.fs)
+}
+
+func (s *ChecksumSuite) TestMismatch(c *C) {
+	c.Assert(util.WriteFile(s.fs, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.fs, "foo"), Equals, "foo")
+
+	c.Assert(util.WriteFile(s.underlying, "foo", []byte("bar"), 0644), IsNil)
+	_, err := s.fs.Open("foo")
+	c.Assert(err, NotNil)
+	c.Assert(err.(*os.PathError).Err, Equals, ErrChecksumMismatch)
+
+	// the file can be overwritten
+	c.Assert(util.WriteFile(s.fs, "foo", []byte("qux"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.fs, "foo"), Equals, "qux")
+}
+
+func (s *ChecksumSuite) TestNotRecorded(c *C) {
+	c.Assert(util.WriteFile(s.underlying, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.fs, "foo"), Equals, "foo")
+}
+
+func (s *ChecksumSuite) TestRename(c *C) {
+	c.Assert(util.WriteFile(s.fs, "dir/foo", []byte("foo"), 0644), IsNil)
+	c.Assert(s.fs.Rename("dir", "new"), IsNil)
+
+	c.Assert(util.WriteFile(s.underlying, "new/foo", []byte("bar"), 0644), IsNil)
+	_, err := s.fs.Open("new/foo")
+	c.Assert(err.(*os.PathError).Err, Equals, ErrChecksumMismatch)
+}
+
+func (s *ChecksumSuite) TestChroot(c *C) {
+	chroot, err := s.fs.Chroot("dir")
+	c.Assert(err, IsNil)
+	c.Assert(util.WriteFile(chroot, "foo", []byte("foo"), 0644), IsNil)
+
+	c.Assert(util.WriteFile(s.underlying, "dir/foo", []byte("bar"), 0644), IsNil)
+	_, err = s.fs.Open("dir/foo")
+	c.Assert(err.(*os.PathError).Err, Equals, ErrChecksumMismatch)
+}
+
+func (s *ChecksumSuite) TestManifest(c *C) {
+	c.Assert(util.WriteFile(s.fs, "foo", []byte("foo"), 0644), IsNil)
+
+	infos, err := s.fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 1)
+	c.Assert(infos[0].Name(), Equals, "foo")
+
+	// the checksums are kept across instances
+	c.Assert(util.WriteFile(s.underlying, "foo", []byte("bar"), 0644), IsNil)
+	fs, err := New(s.underlying, Options{})
+	c.Assert(err, IsNil)
+	_, err = fs.Open("foo")
+	c.Assert(err.(*os.PathError).Err, Equals, ErrChecksumMismatch)
+
+	c.Assert(s.fs.Remove(DefaultManifest), NotNil)
+}
@@ -240,3 +240,13 @@ func newFile(fs billy.Filesystem, f billy.File, filename string) billy.File {
 func (f *file) Name() string {
 	return f.name
 }
+
+// Sync commits the content of the file to the storage, if supported by the
+// underlying file, as done by *os.File.
+func (f *file) Sync() error {
+	if s, ok := f.File.(interface{ Sync() error }); ok {
+		return s.Sync()
+	}
+
+	return nil
+}
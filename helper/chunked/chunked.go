@@ -0,0 +1,420 @@
+// Package chunked provides a helper splitting the large files of a filesystem
+// into chunks of a fixed size, eg.: to store files larger than the objects
+// supported by a blob store.
+package chunked
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/internal/linkutil"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const (
+	// DefaultChunkSize is the size of the chunks used if none is given.
+	DefaultChunkSize = 64 << 20
+	// suffix is the suffix of the chunks, followed by their index.
+	suffix = ".chunk."
+)
+
+var (
+	// ErrReservedName is returned when creating a file with the name of a
+	// chunk.
+	ErrReservedName = errors.New("name reserved for chunks")
+	// ErrTooManyLinks is returned when too many symlinks are followed to
+	// find the chunks of a file.
+	ErrTooManyLinks = linkutil.ErrTooManyLinks
+)
+
+// Options holds the configuration of a Chunked.
+type Options struct {
+	// ChunkSize is the size of the chunks, DefaultChunkSize if zero. The
+	// files up to this size are stored as they are.
+	ChunkSize int64
+}
+
+// Chunked is a helper storing the files larger than the chunk size as a
+// sequence of chunks, named after the file with the ".chunk." suffix and the
+// index of the chunk, eg.: "foo.chunk.000", and presenting them as a single
+// file, which can be read and written at any offset.
+//
+// The files open for writing keep the chunks changed in memory, and store
+// them as soon as they are completely written, or on Close. The chunks of a
+// file are stored one by one, so the file isn't changed atomically.
+type Chunked struct {
+	billy.Filesystem
+	size int64
+}
+
+// New creates a new filesystem splitting the large files of the given one
+// into chunks.
+func New(fs billy.Filesystem, opts Options) *Chunked {
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = DefaultChunkSize
+	}
+
+	return &Chunked{Filesystem: fs, size: opts.ChunkSize}
+}
+
+// chunkName returns the name of the given chunk of a file.
+func chunkName(filename string, i int) string {
+	return fmt.Sprintf("%s%s%03d", filename, suffix, i)
+}
+
+// parseChunk returns the name of the file and the index of the given chunk,
+// false if it isn't a chunk.
+func parseChunk(name string) (string, int, bool) {
+	i := strings.LastIndex(name, suffix)
+	if i <= 0 {
+		return "", 0, false
+	}
+
+	digits := name[i+len(suffix):]
+	if len(digits) < 3 || strings.Trim(digits, "0123456789") != "" {
+		return "", 0, false
+	}
+
+	n, err := strconv.Atoi(digits)
+	if err != nil {
+		return "", 0, false
+	}
+
+	return name[:i], n, true
+}
+
+// layout is the way a file is stored.
+type layout struct {
+	// path is the path of the file stored, or of its chunks, the target if
+	// the file is a symlink followed.
+	path string
+	// plain is true if the file is stored as it is.
+	plain bool
+	// sizes are the sizes of the chunks, if split into chunks.
+	sizes []int64
+	// fi is the info of the file stored, or of its first chunk.
+	fi os.FileInfo
+	// modTime is the last time any chunk was changed.
+	modTime time.Time
+}
+
+func (l *layout) size() int64 {
+	if l.plain {
+		return l.fi.Size()
+	}
+
+	var size int64
+	for _, s := range l.sizes {
+		size += s
+	}
+
+	return size
+}
+
+// layout returns the way the given file is stored, following the symlink if
+// follow is true. It fails with the error of the file if not found.
+func (fs *Chunked) layout(filename string, follow bool) (*layout, error) {
+	path := filename
+	for links := 0; ; links++ {
+		l, target, err := fs.resolve(path, follow)
+		if err != nil || target == "" {
+			if l != nil {
+				l.path = path
+			}
+
+			return l, err
+		}
+
+		if links == linkutil.MaxLinks {
+			return nil, &os.PathError{Op: "stat", Path: filename, Err: ErrTooManyLinks}
+		}
+
+		path = target
+	}
+}
+
+// resolve returns the way the given file is stored or, if following the
+// symlinks and it's a symlink to a file split into chunks, its target.
+func (fs *Chunked) resolve(filename string, follow bool) (*layout, string, error) {
+	stat := fs.Filesystem.Lstat
+	if follow {
+		stat = fs.Filesystem.Stat
+	}
+
+	fi, err := stat(filename)
+	if err == nil {
+		return &layout{plain: true, fi: fi, modTime: fi.ModTime()}, "", nil
+	}
+
+	if !os.IsNotExist(err) {
+		return nil, "", err
+	}
+
+	if follow {
+		if fi, lerr := fs.Filesystem.Lstat(filename); lerr == nil && fi.Mode()&os.ModeSymlink != 0 {
+			target, err := fs.Filesystem.Readlink(filename)
+			if err != nil {
+				return nil, "", err
+			}
+
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(filename), target)
+			}
+
+			return nil, target, nil
+		}
+	}
+
+	l := &layout{}
+	for i := 0; ; i++ {
+		chunk, cerr := fs.Filesystem.Stat(chunkName(filename, i))
+		if os.IsNotExist(cerr) {
+			break
+		}
+
+		if cerr != nil {
+			return nil, "", cerr
+		}
+
+		if i == 0 {
+			l.fi = chunk
+		}
+
+		if chunk.ModTime().After(l.modTime) {
+			l.modTime = chunk.ModTime()
+		}
+
+		l.sizes = append(l.sizes, chunk.Size())
+	}
+
+	if len(l.sizes) == 0 {
+		return nil, "", err
+	}
+
+	return l, "", nil
+}
+
+func (fs *Chunked) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Chunked) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file. It fails with ErrReservedName if creating a
+// file with the name of a chunk.
+func (fs *Chunked) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	l, err := fs.layout(filename, true)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if l == nil {
+		if flag&os.O_CREATE == 0 {
+			return nil, err
+		}
+
+		if _, _, ok := parseChunk(filepath.Base(filename)); ok {
+			return nil, &os.PathError{Op: "open", Path: filename, Err: ErrReservedName}
+		}
+	} else if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrExist}
+	}
+
+	write := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	if l != nil && l.plain && (!write || l.fi.IsDir()) {
+		return fs.Filesystem.OpenFile(filename, flag, perm)
+	}
+
+	if l == nil {
+		// the file is created right away, so it's found by other operations
+		f, err := fs.Filesystem.OpenFile(filename, flag|os.O_CREATE, perm)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := f.Close(); err != nil {
+			return nil, err
+		}
+
+		if l, err = fs.layout(filename, true); err != nil {
+			return nil, err
+		}
+	}
+
+	return newFile(fs, filename, flag, l)
+}
+
+func (fs *Chunked) Stat(filename string) (os.FileInfo, error) {
+	return fs.stat(filename, true)
+}
+
+func (fs *Chunked) Lstat(filename string) (os.FileInfo, error) {
+	return fs.stat(filename, false)
+}
+
+func (fs *Chunked) stat(filename string, follow bool) (os.FileInfo, error) {
+	l, err := fs.layout(filename, follow)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.plain {
+		return l.fi, nil
+	}
+
+	return &fileInfo{
+		FileInfo: l.fi,
+		name:     filepath.Base(filename),
+		size:     l.size(),
+		modTime:  l.modTime,
+	}, nil
+}
+
+// ReadDir returns the files of the given directory, presenting the chunks of
+// every file as the file.
+func (fs *Chunked) ReadDir(path string) ([]os.FileInfo, error) {
+	infos, err := fs.Filesystem.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []os.FileInfo
+	chunked := make(map[string]bool)
+	for _, fi := range infos {
+		name, _, ok := parseChunk(fi.Name())
+		if !ok {
+			result = append(result, fi)
+			continue
+		}
+
+		if chunked[name] {
+			continue
+		}
+
+		chunked[name] = true
+		fi, err := fs.stat(filepath.Join(path, name), false)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, fi)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name() < result[j].Name()
+	})
+
+	return result, nil
+}
+
+// Rename renames the given file, along with all its chunks.
+func (fs *Chunked) Rename(from, to string) error {
+	if _, _, ok := parseChunk(filepath.Base(to)); ok {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: ErrReservedName}
+	}
+
+	l, err := fs.layout(from, false)
+	if err != nil {
+		return err
+	}
+
+	if l.plain {
+		if err := fs.Filesystem.Rename(from, to); err != nil {
+			return err
+		}
+
+		return fs.removeChunks(to, 0)
+	}
+
+	for i := range l.sizes {
+		if err := fs.Filesystem.Rename(chunkName(from, i), chunkName(to, i)); err != nil {
+			return err
+		}
+	}
+
+	if err := fs.removeChunks(to, len(l.sizes)); err != nil {
+		return err
+	}
+
+	if err := fs.Filesystem.Remove(to); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Remove removes the given file, along with all its chunks.
+func (fs *Chunked) Remove(filename string) error {
+	l, err := fs.layout(filename, false)
+	if err != nil {
+		return err
+	}
+
+	if l.plain {
+		return fs.Filesystem.Remove(filename)
+	}
+
+	return fs.removeChunks(filename, 0)
+}
+
+// removeChunks removes the chunks of the given file from the given one on.
+func (fs *Chunked) removeChunks(filename string, from int) error {
+	for i := from; ; i++ {
+		err := fs.Filesystem.Remove(chunkName(filename, i))
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (fs *Chunked) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(fs, dir, prefix)
+}
+
+// Chroot returns the given directory of the underlying filesystem, splitting
+// its files into chunks of the same size.
+func (fs *Chunked) Chroot(path string) (billy.Filesystem, error) {
+	chroot, err := fs.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Chunked{Filesystem: chroot, size: fs.size}, nil
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Chunked) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.Filesystem)
+}
+
+type fileInfo struct {
+	os.FileInfo
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.size
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.modTime
+}
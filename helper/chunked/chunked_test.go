@@ -0,0 +1,155 @@
+package chunked
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&ChunkedSuite{})
+
+type ChunkedSuite struct {
+	test.FilesystemSuite
+	underlying billy.Filesystem
+}
+
+func (s *ChunkedSuite) SetUpTest(c *C) {
+	s.underlying = memfs.New()
+	s.FilesystemSuite = test.NewFilesystemSuite(New(s.underlying, Options{ChunkSize: 4}))
+}
+
+func (s *ChunkedSuite) TestWriteSplit(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("0123456789"), 0644), IsNil)
+
+	_, err := s.underlying.Stat("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+	c.Assert(test.ReadFile(c, s.underlying, "foo.chunk.000"), Equals, "0123")
+	c.Assert(test.ReadFile(c, s.underlying, "foo.chunk.001"), Equals, "4567")
+	c.Assert(test.ReadFile(c, s.underlying, "foo.chunk.002"), Equals, "89")
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "0123456789")
+
+	fi, err := s.FS.Stat("foo")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Name(), Equals, "foo")
+	c.Assert(fi.Size(), Equals, int64(10))
+
+	infos, err := s.FS.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 1)
+	c.Assert(infos[0].Name(), Equals, "foo")
+	c.Assert(infos[0].Size(), Equals, int64(10))
+}
+
+func (s *ChunkedSuite) TestWriteSmall(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("0123456789"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("012"), 0644), IsNil)
+
+	c.Assert(test.ReadFile(c, s.underlying, "foo"), Equals, "012")
+	_, err := s.underlying.Stat("foo.chunk.000")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *ChunkedSuite) TestReadAt(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("0123456789"), 0644), IsNil)
+
+	f, err := s.FS.Open("foo")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	buf := make([]byte, 5)
+	n, err := f.ReadAt(buf, 3)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf[:n]), Equals, "34567")
+
+	n, err = f.ReadAt(buf, 7)
+	c.Assert(err, Equals, io.EOF)
+	c.Assert(string(buf[:n]), Equals, "789")
+
+	pos, err := f.Seek(-4, io.SeekEnd)
+	c.Assert(err, IsNil)
+	c.Assert(pos, Equals, int64(6))
+
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "6789")
+}
+
+func (s *ChunkedSuite) TestWriteAt(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("0123456789"), 0644), IsNil)
+
+	f, err := s.FS.OpenFile("foo", os.O_RDWR, 0)
+	c.Assert(err, IsNil)
+
+	_, err = f.(io.WriterAt).WriteAt([]byte("abcdef"), 3)
+	c.Assert(err, IsNil)
+	_, err = f.(io.WriterAt).WriteAt([]byte("xy"), 14)
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "012abcdef9\x00\x00\x00\x00xy")
+	c.Assert(test.ReadFile(c, s.underlying, "foo.chunk.002"), Equals, "f9\x00\x00")
+	c.Assert(test.ReadFile(c, s.underlying, "foo.chunk.003"), Equals, "\x00\x00xy")
+}
+
+func (s *ChunkedSuite) TestTruncate(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("0123456789"), 0644), IsNil)
+
+	f, err := s.FS.OpenFile("foo", os.O_RDWR, 0)
+	c.Assert(err, IsNil)
+	c.Assert(f.Truncate(5), IsNil)
+	c.Assert(f.Truncate(9), IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "01234\x00\x00\x00\x00")
+	_, err = s.underlying.Stat("foo.chunk.003")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *ChunkedSuite) TestLarge(c *C) {
+	fs := New(s.underlying, Options{ChunkSize: 1000})
+	content := strings.Repeat("0123456789", 1234)
+
+	f, err := fs.Create("foo")
+	c.Assert(err, IsNil)
+	_, err = io.Copy(f, struct{ io.Reader }{strings.NewReader(content)})
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	infos, err := s.underlying.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 13)
+	c.Assert(test.ReadFile(c, fs, "foo"), Equals, content)
+}
+
+func (s *ChunkedSuite) TestRenameAndRemove(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("0123456789"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "bar", []byte("0123456789abcdef"), 0644), IsNil)
+
+	c.Assert(s.FS.Rename("foo", "bar"), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "bar"), Equals, "0123456789")
+	_, err := s.underlying.Stat("bar.chunk.003")
+	c.Assert(os.IsNotExist(err), Equals, true)
+	_, err = s.FS.Stat("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	c.Assert(s.FS.Remove("bar"), IsNil)
+	infos, err := s.underlying.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 0)
+}
+
+func (s *ChunkedSuite) TestReservedName(c *C) {
+	_, err := s.FS.Create("foo.chunk.000")
+	c.Assert(err.(*os.PathError).Err, Equals, ErrReservedName)
+}
@@ -0,0 +1,562 @@
+package chunked
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// file is a file split into chunks, or open for writing. The content of the
+// file is read from the way it was stored when open, the origin, but for the
+// chunks changed since then, kept in memory until stored.
+type file struct {
+	fs *Chunked
+	// name is the name of the file, and path the path of the file stored,
+	// or of its chunks.
+	name string
+	path string
+	flag int
+	// perm is the mode of the chunks created.
+	perm os.FileMode
+
+	m sync.Mutex
+	// plain is true if the origin is stored as it is, and sizes are the
+	// sizes of its chunks otherwise.
+	plain bool
+	sizes []int64
+	// valid is the size of the origin still valid, the content after it was
+	// truncated away.
+	valid int64
+	size  int64
+	pos   int64
+	// dirty are the chunks changed, and flushed the chunks stored since the
+	// file was open.
+	dirty   map[int][]byte
+	flushed map[int]bool
+	changed bool
+	closed  bool
+
+	// handle is the last chunk open for reading, the origin if index is -1.
+	handle billy.File
+	index  int
+}
+
+func newFile(fs *Chunked, filename string, flag int, l *layout) (*file, error) {
+	f := &file{
+		fs:      fs,
+		name:    filename,
+		path:    l.path,
+		flag:    flag,
+		perm:    l.fi.Mode().Perm(),
+		plain:   l.plain,
+		sizes:   l.sizes,
+		size:    l.size(),
+		dirty:   make(map[int][]byte),
+		flushed: make(map[int]bool),
+	}
+
+	f.valid = f.size
+	if flag&os.O_TRUNC != 0 && f.isWrite() {
+		f.valid, f.size, f.changed = 0, 0, true
+	}
+
+	// the name is the one given by the underlying filesystem, but for the
+	// symlinks to files split into chunks
+	index := -1
+	if !f.plain {
+		index = 0
+	}
+
+	h, err := f.open(index)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.path == filename {
+		f.name = strings.TrimSuffix(h.Name(), chunkName("", 0))
+	}
+
+	return f, nil
+}
+
+func (f *file) isWrite() bool {
+	return f.flag&(os.O_WRONLY|os.O_RDWR) != 0
+}
+
+func (f *file) Name() string {
+	return f.name
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if f.flag&os.O_WRONLY != 0 {
+		return 0, errors.New("read not supported")
+	}
+
+	n, err := f.readAt(p, f.pos)
+	f.pos += int64(n)
+	return n, err
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if f.flag&os.O_WRONLY != 0 {
+		return 0, errors.New("read not supported")
+	}
+
+	n, err := f.readAt(p, off)
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+func (f *file) readAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+
+	if off >= f.size {
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > f.size-off {
+		p = p[:f.size-off]
+	}
+
+	var n int
+	for n < len(p) {
+		i, within := f.locate(off + int64(n))
+		chunk := p[n:]
+		if int64(len(chunk)) > f.fs.size-within {
+			chunk = chunk[:f.fs.size-within]
+		}
+
+		if buf, ok := f.dirty[i]; ok {
+			// the content written after the end of the chunk is a hole
+			if within > int64(len(buf)) {
+				within = int64(len(buf))
+			}
+
+			zero(chunk[copy(chunk, buf[within:]):])
+		} else if err := f.readOrigin(chunk, i, within); err != nil {
+			return n, err
+		}
+
+		n += len(chunk)
+	}
+
+	return n, nil
+}
+
+// locate returns the chunk of the given offset, and the offset within it.
+func (f *file) locate(off int64) (int, int64) {
+	return int(off / f.fs.size), off % f.fs.size
+}
+
+// readOrigin reads the given chunk, as stored, at the given offset within
+// it, filling with zeros the content not stored, or truncated.
+func (f *file) readOrigin(p []byte, i int, within int64) error {
+	off := int64(i)*f.fs.size + within
+	limit := int64(len(p))
+	if !f.flushed[i] {
+		if limit = f.valid - off; limit < 0 {
+			limit = 0
+		}
+
+		if limit > int64(len(p)) {
+			limit = int64(len(p))
+		}
+	}
+
+	zero(p[limit:])
+	if limit == 0 {
+		return nil
+	}
+
+	index, at := i, within
+	if f.plain && !f.flushed[i] {
+		index, at = -1, off
+	} else if !f.flushed[i] && i >= len(f.sizes) {
+		zero(p)
+		return nil
+	}
+
+	h, err := f.open(index)
+	if err != nil {
+		return err
+	}
+
+	n, err := h.ReadAt(p[:limit], at)
+	if err == io.EOF {
+		err = nil
+	}
+
+	zero(p[n:limit])
+	return err
+}
+
+// open returns the given chunk open for reading, the origin if -1.
+func (f *file) open(index int) (billy.File, error) {
+	if f.handle != nil && f.index == index {
+		return f.handle, nil
+	}
+
+	if err := f.release(); err != nil {
+		return nil, err
+	}
+
+	name := f.path
+	if index >= 0 {
+		name = chunkName(f.path, index)
+	}
+
+	h, err := f.fs.Filesystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	f.handle, f.index = h, index
+	return h, nil
+}
+
+// release closes the chunk open for reading, if any.
+func (f *file) release() error {
+	if f.handle == nil {
+		return nil
+	}
+
+	err := f.handle.Close()
+	f.handle = nil
+	return err
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if f.flag&os.O_APPEND != 0 {
+		f.pos = f.size
+	}
+
+	n, err := f.writeAt(p, f.pos)
+	f.pos += int64(n)
+	return n, err
+}
+
+func (f *file) WriteAt(p []byte, off int64) (int, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	return f.writeAt(p, off)
+}
+
+func (f *file) writeAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+
+	if !f.isWrite() {
+		return 0, errors.New("write not supported")
+	}
+
+	var n int
+	for n < len(p) {
+		i, within := f.locate(off + int64(n))
+		buf, err := f.load(i)
+		if err != nil {
+			return n, err
+		}
+
+		end := within + int64(len(p)-n)
+		if end > f.fs.size {
+			end = f.fs.size
+		}
+
+		if end > int64(len(buf)) {
+			buf = append(buf, make([]byte, end-int64(len(buf)))...)
+		}
+
+		n += copy(buf[within:end], p[n:])
+		f.dirty[i] = buf
+	}
+
+	f.changed = true
+	if end := off + int64(n); end > f.size {
+		f.size = end
+	}
+
+	current, _ := f.locate(off + int64(n))
+	return n, f.flush(current)
+}
+
+// load returns the content of the given chunk, reading it into memory if not
+// changed yet.
+func (f *file) load(i int) ([]byte, error) {
+	if buf, ok := f.dirty[i]; ok {
+		return buf, nil
+	}
+
+	length := f.length(i)
+	buf := make([]byte, length, f.fs.size)
+	if err := f.readOrigin(buf, i, 0); err != nil {
+		return nil, err
+	}
+
+	f.dirty[i] = buf
+	return buf, nil
+}
+
+// length returns the size of the given chunk.
+func (f *file) length(i int) int64 {
+	length := f.size - int64(i)*f.fs.size
+	switch {
+	case length < 0:
+		return 0
+	case length > f.fs.size:
+		return f.fs.size
+	}
+
+	return length
+}
+
+// flush stores the chunks completely written before the given one, if the
+// file is split into chunks.
+func (f *file) flush(current int) error {
+	if f.size <= f.fs.size {
+		return nil
+	}
+
+	for i, buf := range f.dirty {
+		if i >= current || int64(len(buf)) != f.fs.size {
+			continue
+		}
+
+		if err := f.store(chunkName(f.path, i), buf); err != nil {
+			return err
+		}
+
+		delete(f.dirty, i)
+		f.flushed[i] = true
+	}
+
+	return nil
+}
+
+// store writes the given content to the given file of the underlying
+// filesystem.
+func (f *file) store(name string, content []byte) error {
+	if f.handle != nil && f.index >= 0 && chunkName(f.path, f.index) == name {
+		if err := f.release(); err != nil {
+			return err
+		}
+	}
+
+	w, err := f.fs.Filesystem.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(content); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.size
+	}
+
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: os.ErrInvalid}
+	}
+
+	f.pos = offset
+	return f.pos, nil
+}
+
+func (f *file) Truncate(size int64) error {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if f.closed {
+		return os.ErrClosed
+	}
+
+	if !f.isWrite() {
+		return errors.New("truncate not supported")
+	}
+
+	if size < f.size {
+		last, within := f.locate(size)
+		for i := range f.dirty {
+			if i > last || i == last && within == 0 {
+				delete(f.dirty, i)
+			}
+		}
+
+		for i := range f.flushed {
+			if i > last || i == last && within == 0 {
+				delete(f.flushed, i)
+			}
+		}
+
+		if within != 0 {
+			buf, err := f.load(last)
+			if err != nil {
+				return err
+			}
+
+			if int64(len(buf)) > within {
+				f.dirty[last] = buf[:within]
+			}
+		}
+
+		if size < f.valid {
+			f.valid = size
+		}
+	}
+
+	f.size, f.changed = size, true
+	return nil
+}
+
+// Close stores the changes, as a single file if it fits in a chunk, or split
+// into chunks otherwise, removing the chunks not used anymore.
+func (f *file) Close() error {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if f.closed {
+		return os.ErrClosed
+	}
+
+	f.closed = true
+	err := f.commit()
+	if rerr := f.release(); err == nil {
+		err = rerr
+	}
+
+	return err
+}
+
+func (f *file) commit() error {
+	if !f.changed {
+		return nil
+	}
+
+	if f.size <= f.fs.size {
+		content, err := f.chunk(0)
+		if err != nil {
+			return err
+		}
+
+		if err := f.release(); err != nil {
+			return err
+		}
+
+		if err := f.store(f.path, content); err != nil {
+			return err
+		}
+
+		return f.fs.removeChunks(f.path, 0)
+	}
+
+	count := int((f.size + f.fs.size - 1) / f.fs.size)
+	for i := 0; i < count; i++ {
+		if !f.stale(i) {
+			continue
+		}
+
+		content, err := f.chunk(i)
+		if err != nil {
+			return err
+		}
+
+		if err := f.store(chunkName(f.path, i), content); err != nil {
+			return err
+		}
+	}
+
+	if err := f.release(); err != nil {
+		return err
+	}
+
+	if err := f.fs.removeChunks(f.path, count); err != nil {
+		return err
+	}
+
+	if !f.plain {
+		return nil
+	}
+
+	return f.fs.Filesystem.Remove(f.path)
+}
+
+// stale returns true if the given chunk isn't stored as it should be.
+func (f *file) stale(i int) bool {
+	if _, ok := f.dirty[i]; ok {
+		return true
+	}
+
+	if f.flushed[i] {
+		return false
+	}
+
+	length := f.length(i)
+	return f.plain || i >= len(f.sizes) || f.sizes[i] != length ||
+		int64(i)*f.fs.size+length > f.valid
+}
+
+// chunk returns the content of the given chunk.
+func (f *file) chunk(i int) ([]byte, error) {
+	length := f.length(i)
+	if buf, ok := f.dirty[i]; ok {
+		if int64(len(buf)) < length {
+			buf = append(buf, make([]byte, length-int64(len(buf)))...)
+		}
+
+		return buf[:length], nil
+	}
+
+	buf := make([]byte, length)
+	return buf, f.readOrigin(buf, i, 0)
+}
+
+// Lock is a no-op in chunked.
+func (f *file) Lock() error {
+	return nil
+}
+
+// Unlock is a no-op in chunked.
+func (f *file) Unlock() error {
+	return nil
+}
+
+func zero(p []byte) {
+	for i := range p {
+		p[i] = 0
+	}
+}
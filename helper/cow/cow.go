@@ -0,0 +1,271 @@
+// Package cow provides a helper making a read-only filesystem writable by
+// copying its files to a scratch filesystem before modifying them, eg.: to run
+// tools modifying files in place over the content of an archive.
+//
+// Unlike the overlay helper, no whiteouts are kept: the files of the base
+// can't be removed or renamed, so the scratch filesystem only holds the
+// copies of the files written and the new files.
+package cow
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/internal/fileutil"
+	"gopkg.in/src-d/go-billy.v4/internal/linkutil"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+var errNotDir = errors.New("not a directory")
+
+// COW is a helper that reads the files from a base filesystem, which is never
+// modified, until they are opened for writing, copying them first to a
+// scratch filesystem, where every new file is created.
+type COW struct {
+	base    billy.Filesystem
+	scratch billy.Filesystem
+}
+
+// New creates a new filesystem reading the files from base, and writing them
+// to scratch.
+func New(base, scratch billy.Filesystem) *COW {
+	return &COW{base: base, scratch: scratch}
+}
+
+func (fs *COW) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *COW) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file, following the links, so the file copied to
+// the scratch filesystem is the target, and not the link.
+func (fs *COW) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	p, err := fs.follow(filename)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+	}
+
+	if fs.inScratch(p) {
+		return fs.scratch.OpenFile(p, flag, perm)
+	}
+
+	fi, err := fs.base.Lstat(p)
+	if os.IsNotExist(err) {
+		return fs.create(p, flag, perm)
+	}
+
+	if err != nil || fi.IsDir() || !isWrite(flag) {
+		return fs.base.OpenFile(p, flag, perm)
+	}
+
+	if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrExist}
+	}
+
+	return fs.copyUp(p, fi, flag)
+}
+
+// follow returns the path of the given file, following the links through
+// both filesystems. The returned path is the one of the target, also when the
+// target doesn't exist.
+func (fs *COW) follow(filename string) (string, error) {
+	return linkutil.Follow(filename, filepath.FromSlash, func(p string) (bool, error) {
+		fi, err := fs.Lstat(p)
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+
+		return err == nil && fi.Mode()&os.ModeSymlink != 0, err
+	}, fs.Readlink)
+}
+
+// create creates in the scratch filesystem a file missing in both.
+func (fs *COW) create(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if flag&os.O_CREATE == 0 {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrNotExist}
+	}
+
+	if err := fs.checkParent(filename); err != nil {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+	}
+
+	return fs.scratch.OpenFile(filename, flag, perm)
+}
+
+// copyUp copies the given file of the base to the scratch filesystem, opening
+// it. The content isn't copied if it's going to be truncated.
+func (fs *COW) copyUp(filename string, fi os.FileInfo, flag int) (billy.File, error) {
+	perm := fi.Mode().Perm()
+	if flag&os.O_TRUNC == 0 {
+		if err := fileutil.Copy(fs.base, filename, fs.scratch, filename, perm); err != nil {
+			return nil, err
+		}
+	}
+
+	return fs.scratch.OpenFile(filename, flag|os.O_CREATE, perm)
+}
+
+// checkParent returns an error if the parent of the given file is a file in
+// the base, since it would be created as a directory in the scratch
+// filesystem.
+func (fs *COW) checkParent(filename string) error {
+	fi, err := fs.base.Stat(filepath.Dir(filename))
+	if err == nil && !fi.IsDir() {
+		return errNotDir
+	}
+
+	return nil
+}
+
+func (fs *COW) inScratch(filename string) bool {
+	_, err := fs.scratch.Lstat(filename)
+	return err == nil
+}
+
+func (fs *COW) inBase(filename string) bool {
+	_, err := fs.base.Lstat(filename)
+	return err == nil
+}
+
+// source returns the filesystem where the given file lives.
+func (fs *COW) source(filename string) billy.Filesystem {
+	if fs.inScratch(filename) {
+		return fs.scratch
+	}
+
+	return fs.base
+}
+
+func (fs *COW) Stat(filename string) (os.FileInfo, error) {
+	return fs.source(filename).Stat(filename)
+}
+
+func (fs *COW) Lstat(filename string) (os.FileInfo, error) {
+	return fs.source(filename).Lstat(filename)
+}
+
+// ReadDir returns the entries of the given directory in both filesystems,
+// the ones of the scratch filesystem taking precedence.
+func (fs *COW) ReadDir(path string) ([]os.FileInfo, error) {
+	entries := make(map[string]os.FileInfo)
+	infos, err := fs.base.ReadDir(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	for _, fi := range infos {
+		entries[fi.Name()] = fi
+	}
+
+	scratch, serr := fs.scratch.ReadDir(path)
+	if serr != nil && !os.IsNotExist(serr) {
+		return nil, serr
+	}
+
+	if err != nil && serr != nil {
+		return nil, err
+	}
+
+	for _, fi := range scratch {
+		entries[fi.Name()] = fi
+	}
+
+	infos = make([]os.FileInfo, 0, len(entries))
+	for _, fi := range entries {
+		infos = append(infos, fi)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+
+	return infos, nil
+}
+
+func (fs *COW) MkdirAll(filename string, perm os.FileMode) error {
+	fi, err := fs.base.Stat(filename)
+	if err == nil {
+		if !fi.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: filename, Err: errNotDir}
+		}
+
+		return nil
+	}
+
+	return fs.scratch.MkdirAll(filename, perm)
+}
+
+// Remove removes the given file from the scratch filesystem. The files of the
+// base can't be removed.
+func (fs *COW) Remove(filename string) error {
+	if fs.inBase(filename) {
+		return billy.ErrReadOnly
+	}
+
+	return fs.scratch.Remove(filename)
+}
+
+// Rename renames the given file of the scratch filesystem, replacing the file
+// of the base with the new name, if any. The files of the base can't be
+// renamed.
+func (fs *COW) Rename(from, to string) error {
+	if fs.inBase(from) {
+		return billy.ErrReadOnly
+	}
+
+	if err := fs.checkParent(to); err != nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
+
+	return fs.scratch.Rename(from, to)
+}
+
+func (fs *COW) Symlink(target, link string) error {
+	if fs.inBase(link) {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: os.ErrExist}
+	}
+
+	if err := fs.checkParent(link); err != nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: err}
+	}
+
+	return fs.scratch.Symlink(target, link)
+}
+
+func (fs *COW) Readlink(link string) (string, error) {
+	return fs.source(link).Readlink(link)
+}
+
+func (fs *COW) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(fs, dir, prefix)
+}
+
+func (fs *COW) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (fs *COW) Chroot(path string) (billy.Filesystem, error) {
+	return chroot.New(fs, fs.Join(fs.Root(), path)), nil
+}
+
+func (fs *COW) Root() string {
+	return string(filepath.Separator)
+}
+
+// Capabilities implements the Capable interface.
+func (fs *COW) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.scratch)
+}
+
+// isWrite returns true if opening a file with the given flag may modify it,
+// so a file of the base must be copied first.
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR|os.O_TRUNC|os.O_CREATE|os.O_APPEND) != 0
+}
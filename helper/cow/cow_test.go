@@ -0,0 +1,141 @@
+package cow
+
+import (
+	"os"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&COWSuite{})
+
+type COWSuite struct {
+	test.FilesystemSuite
+	base    billy.Filesystem
+	scratch billy.Filesystem
+}
+
+func (s *COWSuite) SetUpTest(c *C) {
+	s.base = memfs.New()
+	s.scratch = memfs.New()
+	s.FilesystemSuite = test.NewFilesystemSuite(New(s.base, s.scratch))
+}
+
+func (s *COWSuite) TestCopyOnWrite(c *C) {
+	c.Assert(util.WriteFile(s.base, "foo", []byte("foo"), 0600), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "foo")
+
+	_, err := s.scratch.Stat("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	f, err := s.FS.OpenFile("foo", os.O_WRONLY|os.O_APPEND, 0)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("bar"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "foobar")
+	c.Assert(test.ReadFile(c, s.base, "foo"), Equals, "foo")
+
+	fi, err := s.scratch.Stat("foo")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode().Perm(), Equals, os.FileMode(0600))
+}
+
+func (s *COWSuite) TestTruncateNotCopied(c *C) {
+	c.Assert(util.WriteFile(s.base, "foo", []byte("foo"), 0644), IsNil)
+
+	_, err := s.FS.OpenFile("foo", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	c.Assert(os.IsExist(err), Equals, true)
+
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("bar"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "bar")
+	c.Assert(test.ReadFile(c, s.base, "foo"), Equals, "foo")
+}
+
+func (s *COWSuite) TestTruncateReadOnly(c *C) {
+	c.Assert(util.WriteFile(s.base, "foo", []byte("foo"), 0644), IsNil)
+
+	f, err := s.FS.OpenFile("foo", os.O_RDONLY|os.O_TRUNC, 0)
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	c.Assert(test.ReadFile(c, s.base, "foo"), Equals, "foo")
+	_, err = s.scratch.Stat("foo")
+	c.Assert(err, IsNil)
+}
+
+func (s *COWSuite) TestWriteThroughSymlink(c *C) {
+	c.Assert(util.WriteFile(s.base, "target", []byte("orig"), 0644), IsNil)
+	c.Assert(s.base.Symlink("target", "link"), IsNil)
+
+	c.Assert(util.WriteFile(s.FS, "link", []byte("new"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "target"), Equals, "new")
+	c.Assert(test.ReadFile(c, s.FS, "link"), Equals, "new")
+	c.Assert(test.ReadFile(c, s.base, "target"), Equals, "orig")
+
+	fi, err := s.FS.Lstat("link")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode()&os.ModeSymlink, Not(Equals), os.FileMode(0))
+}
+
+func (s *COWSuite) TestReadDirMerged(c *C) {
+	c.Assert(util.WriteFile(s.base, "dir/a", nil, 0644), IsNil)
+	c.Assert(util.WriteFile(s.base, "dir/b", []byte("b"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "dir/b", nil, 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "dir/c", nil, 0644), IsNil)
+
+	infos, err := s.FS.ReadDir("dir")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 3)
+	c.Assert(infos[0].Name(), Equals, "a")
+	c.Assert(infos[1].Name(), Equals, "b")
+	c.Assert(infos[1].Size(), Equals, int64(0))
+	c.Assert(infos[2].Name(), Equals, "c")
+}
+
+func (s *COWSuite) TestBaseReadOnly(c *C) {
+	c.Assert(util.WriteFile(s.base, "foo", nil, 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+
+	c.Assert(s.FS.Remove("foo"), Equals, billy.ErrReadOnly)
+	c.Assert(s.FS.Rename("foo", "bar"), Equals, billy.ErrReadOnly)
+
+	err := s.FS.Symlink("bar", "foo")
+	c.Assert(os.IsExist(err), Equals, true)
+
+	err = util.WriteFile(s.FS, "foo/bar", nil, 0644)
+	c.Assert(err, NotNil)
+}
+
+func (s *COWSuite) TestRenameOverBase(c *C) {
+	c.Assert(util.WriteFile(s.base, "foo", []byte("foo"), 0644), IsNil)
+
+	f, err := s.FS.TempFile("", "foo")
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("bar"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	c.Assert(s.FS.Rename(f.Name(), "foo"), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "bar")
+	c.Assert(test.ReadFile(c, s.base, "foo"), Equals, "foo")
+}
+
+func (s *COWSuite) TestMkdirAll(c *C) {
+	c.Assert(util.WriteFile(s.base, "dir/foo", nil, 0644), IsNil)
+	c.Assert(s.FS.MkdirAll("dir", 0755), IsNil)
+
+	_, err := s.scratch.Stat("dir")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	err = s.FS.MkdirAll("dir/foo", 0755)
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,620 @@
+// Package dedup provides a helper storing a single copy of the files with the
+// same content, eg.: to shrink trees of fixtures, or caches of clones, with
+// many copies of the same files.
+package dedup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/internal/fileutil"
+	"gopkg.in/src-d/go-billy.v4/internal/linkutil"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+// MetaDir is the directory, in the root of the underlying filesystem, where
+// the contents are stored. It's hidden, and can't be changed.
+const MetaDir = ".dedup"
+
+const separator = string(filepath.Separator)
+
+var (
+	objectsDir = filepath.Join(separator, MetaDir, "objects")
+	tmpDir     = filepath.Join(separator, MetaDir, "tmp")
+)
+
+// ErrInvalidPointer is returned when a regular file of the underlying
+// filesystem isn't a pointer to a content, eg.: if not written through the
+// helper.
+var ErrInvalidPointer = errors.New("invalid content pointer")
+
+var errReadNotSupported = errors.New("read not supported")
+
+// Dedup is a helper storing every content written once, whatever the number
+// of files with it. The files of the underlying filesystem are pointers to
+// the contents, holding their SHA-256, and the contents are stored in MetaDir
+// while pointed by any file, counting the pointers to them.
+//
+// The files are written to a temporary file, stored as a content when closed,
+// so the changes to a file open for writing are seen only once closed.
+type Dedup struct {
+	underlying billy.Filesystem
+
+	m sync.Mutex
+	// refs are the number of pointers to every content.
+	refs map[string]int
+}
+
+// New creates a new filesystem storing the files of the given one without
+// duplicates. The contents are counted, discarding the ones not pointed by
+// any file, and the temporary files left behind.
+func New(fs billy.Filesystem) (*Dedup, error) {
+	d := &Dedup{underlying: fs, refs: make(map[string]int)}
+	if err := util.RemoveAll(fs, tmpDir); err != nil {
+		return nil, err
+	}
+
+	if err := d.count(separator); err != nil {
+		return nil, err
+	}
+
+	infos, err := fs.ReadDir(objectsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	for _, fi := range infos {
+		if d.refs[fi.Name()] > 0 {
+			continue
+		}
+
+		if err := fs.Remove(filepath.Join(objectsDir, fi.Name())); err != nil {
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+// count counts the pointers below the given directory.
+func (fs *Dedup) count(dir string) error {
+	infos, err := fs.underlying.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range infos {
+		p := filepath.Join(dir, fi.Name())
+		switch {
+		case isMeta(p):
+			continue
+		case fi.IsDir():
+			err = fs.count(p)
+		case fi.Mode().IsRegular():
+			var hash string
+			if hash, err = fs.pointer(p); err == nil {
+				fs.refs[hash]++
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pointer returns the content pointed by the given file.
+func (fs *Dedup) pointer(p string) (string, error) {
+	f, err := fs.underlying.Open(p)
+	if err != nil {
+		return "", err
+	}
+
+	defer f.Close()
+
+	b, err := ioutil.ReadAll(io.LimitReader(f, sha256.Size*2+1))
+	if err != nil {
+		return "", err
+	}
+
+	hash := string(b)
+	if _, err := hex.DecodeString(hash); err != nil || len(b) != sha256.Size*2 {
+		return "", &os.PathError{Op: "open", Path: p, Err: ErrInvalidPointer}
+	}
+
+	return hash, nil
+}
+
+func object(hash string) string {
+	return filepath.Join(objectsDir, hash)
+}
+
+// store stores the given temporary file as a content, or discards it if
+// already stored. It must be called with the lock held.
+func (fs *Dedup) store(tmp, hash string) error {
+	if _, err := fs.underlying.Stat(object(hash)); err == nil {
+		return fs.underlying.Remove(tmp)
+	}
+
+	if err := fs.underlying.MkdirAll(objectsDir, 0755); err != nil {
+		return err
+	}
+
+	return fs.underlying.Rename(tmp, object(hash))
+}
+
+// link points the given file to the given content, releasing the one pointed
+// before. It must be called with the lock held.
+func (fs *Dedup) link(p, hash string) error {
+	old, err := fs.pointer(p)
+	if err != nil {
+		return err
+	}
+
+	if err := util.WriteFile(fs.underlying, p, []byte(hash), 0); err != nil {
+		return err
+	}
+
+	fs.refs[hash]++
+	return fs.release(old)
+}
+
+// release discards a pointer to the given content, removing it if it was the
+// last one. It must be called with the lock held.
+func (fs *Dedup) release(hash string) error {
+	fs.refs[hash]--
+	if fs.refs[hash] > 0 {
+		return nil
+	}
+
+	delete(fs.refs, hash)
+	return fs.underlying.Remove(object(hash))
+}
+
+// emptyContent returns the empty content, storing it if needed. It must be
+// called with the lock held.
+func (fs *Dedup) emptyContent() (string, error) {
+	h := sha256.Sum256(nil)
+	hash := hex.EncodeToString(h[:])
+	if _, err := fs.underlying.Stat(object(hash)); err == nil {
+		return hash, nil
+	}
+
+	if err := fs.underlying.MkdirAll(objectsDir, 0755); err != nil {
+		return "", err
+	}
+
+	return hash, util.WriteFile(fs.underlying, object(hash), nil, 0644)
+}
+
+func (fs *Dedup) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Dedup) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file. The files open for writing are written to a
+// temporary file, holding the current content unless truncated, and the ones
+// created are pointed to the empty content until closed.
+func (fs *Dedup) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	p := clean(filename)
+	if isMeta(p) {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrPermission}
+	}
+
+	if !isWrite(flag) {
+		return fs.openContent(filename, p)
+	}
+
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	if err := fs.create(p, flag, perm); err != nil {
+		return nil, err
+	}
+
+	if err := fs.underlying.MkdirAll(tmpDir, 0755); err != nil {
+		return nil, err
+	}
+
+	tmp, err := util.TempFile(fs.underlying, tmpDir, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if flag&os.O_TRUNC == 0 {
+		if err := fs.copyContent(p, tmp); err != nil {
+			tmp.Close()
+			fs.underlying.Remove(tmp.Name())
+			return nil, err
+		}
+	}
+
+	return &file{
+		File:   tmp,
+		fs:     fs,
+		name:   strings.TrimLeft(filename, separator),
+		path:   p,
+		append: flag&os.O_APPEND != 0,
+		read:   flag&(os.O_WRONLY|os.O_RDWR) != os.O_WRONLY,
+	}, nil
+}
+
+// create creates the given file, if missing, pointing to the empty content.
+// It must be called with the lock held.
+func (fs *Dedup) create(p string, flag int, perm os.FileMode) error {
+	fi, err := fs.underlying.Stat(p)
+	if err == nil {
+		if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+			return &os.PathError{Op: "open", Path: p, Err: os.ErrExist}
+		}
+
+		if fi.IsDir() {
+			return &os.PathError{Op: "open", Path: p, Err: errors.New("is a directory")}
+		}
+
+		return nil
+	}
+
+	if !os.IsNotExist(err) || flag&os.O_CREATE == 0 {
+		return err
+	}
+
+	hash, err := fs.emptyContent()
+	if err != nil {
+		return err
+	}
+
+	if err := util.WriteFile(fs.underlying, p, []byte(hash), perm); err != nil {
+		return err
+	}
+
+	fs.refs[hash]++
+	return nil
+}
+
+func (fs *Dedup) openContent(filename, p string) (billy.File, error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	hash, err := fs.pointer(p)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := fs.underlying.Open(object(hash))
+	if err != nil {
+		return nil, err
+	}
+
+	return &file{File: f, name: strings.TrimLeft(filename, separator), read: true}, nil
+}
+
+// copyContent copies the content pointed by the given file to the given one.
+// It must be called with the lock held.
+func (fs *Dedup) copyContent(p string, dst billy.File) error {
+	hash, err := fs.pointer(p)
+	if err != nil {
+		return err
+	}
+
+	src, err := fs.underlying.Open(object(hash))
+	if err != nil {
+		return err
+	}
+
+	defer src.Close()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		return err
+	}
+
+	_, err = dst.Seek(0, io.SeekStart)
+	return err
+}
+
+// commit stores the given temporary file as the content of the given one.
+// The content is discarded if the file was removed meanwhile.
+func (fs *Dedup) commit(p, tmp string) error {
+	hash, _, err := fileutil.Hash(fs.underlying, tmp)
+	if err != nil {
+		fs.underlying.Remove(tmp)
+		return err
+	}
+
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	if _, err := fs.underlying.Lstat(p); os.IsNotExist(err) {
+		return fs.underlying.Remove(tmp)
+	}
+
+	if err := fs.store(tmp, hash); err != nil {
+		return err
+	}
+
+	fs.refs[hash]++
+	err = fs.link(p, hash)
+	if rerr := fs.release(hash); err == nil {
+		err = rerr
+	}
+
+	return err
+}
+
+func (fs *Dedup) Stat(filename string) (os.FileInfo, error) {
+	fi, err := fs.underlying.Stat(clean(filename))
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.fileInfo(clean(filename), fi)
+}
+
+func (fs *Dedup) Lstat(filename string) (os.FileInfo, error) {
+	fi, err := fs.underlying.Lstat(clean(filename))
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.fileInfo(clean(filename), fi)
+}
+
+// fileInfo returns the information of a file of the underlying filesystem,
+// with the size of its content, if a pointer.
+func (fs *Dedup) fileInfo(p string, fi os.FileInfo) (os.FileInfo, error) {
+	if !fi.Mode().IsRegular() {
+		return fi, nil
+	}
+
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	hash, err := fs.pointer(p)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := fs.underlying.Stat(object(hash))
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileInfo{FileInfo: fi, size: content.Size()}, nil
+}
+
+// ReadDir returns the entries of the given directory, but MetaDir.
+func (fs *Dedup) ReadDir(path string) ([]os.FileInfo, error) {
+	dir, err := fs.resolve(clean(path))
+	if err != nil {
+		return nil, err
+	}
+
+	infos, err := fs.underlying.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]os.FileInfo, 0, len(infos))
+	for _, fi := range infos {
+		p := filepath.Join(dir, fi.Name())
+		if isMeta(p) {
+			continue
+		}
+
+		if fi, err = fs.fileInfo(p, fi); err != nil {
+			return nil, err
+		}
+
+		result = append(result, fi)
+	}
+
+	return result, nil
+}
+
+// resolve returns the given path following the symlinks, if the last element
+// is one, so the pointers in a directory can be read.
+func (fs *Dedup) resolve(p string) (string, error) {
+	p, err := linkutil.Follow(p, clean, func(p string) (bool, error) {
+		fi, err := fs.underlying.Lstat(p)
+		return err == nil && fi.Mode()&os.ModeSymlink != 0, nil
+	}, fs.underlying.Readlink)
+
+	switch {
+	case err == linkutil.ErrTooManyLinks:
+		return "", &os.PathError{Op: "readdir", Path: p, Err: err}
+	case err != nil:
+		return "", err
+	}
+
+	return p, nil
+}
+
+// Rename renames the given file, releasing the content pointed by the file
+// replaced, if any.
+func (fs *Dedup) Rename(from, to string) error {
+	if isMeta(clean(from)) || isMeta(clean(to)) {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: os.ErrPermission}
+	}
+
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	replaced := ""
+	if fi, err := fs.underlying.Lstat(clean(to)); err == nil && fi.Mode().IsRegular() {
+		replaced, _ = fs.pointer(clean(to))
+	}
+
+	if err := fs.underlying.Rename(clean(from), clean(to)); err != nil {
+		return err
+	}
+
+	if replaced == "" {
+		return nil
+	}
+
+	return fs.release(replaced)
+}
+
+// Remove removes the given file, releasing the content pointed by it.
+func (fs *Dedup) Remove(filename string) error {
+	p := clean(filename)
+	if isMeta(p) {
+		return &os.PathError{Op: "remove", Path: filename, Err: os.ErrPermission}
+	}
+
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	fi, err := fs.underlying.Lstat(p)
+	if err != nil {
+		return err
+	}
+
+	hash := ""
+	if fi.Mode().IsRegular() {
+		hash, _ = fs.pointer(p)
+	}
+
+	if err := fs.underlying.Remove(p); err != nil {
+		return err
+	}
+
+	if hash == "" {
+		return nil
+	}
+
+	return fs.release(hash)
+}
+
+func (fs *Dedup) MkdirAll(filename string, perm os.FileMode) error {
+	if isMeta(clean(filename)) {
+		return &os.PathError{Op: "mkdir", Path: filename, Err: os.ErrPermission}
+	}
+
+	return fs.underlying.MkdirAll(clean(filename), perm)
+}
+
+func (fs *Dedup) Symlink(target, link string) error {
+	if isMeta(clean(link)) {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: os.ErrPermission}
+	}
+
+	return fs.underlying.Symlink(target, clean(link))
+}
+
+func (fs *Dedup) Readlink(link string) (string, error) {
+	return fs.underlying.Readlink(clean(link))
+}
+
+func (fs *Dedup) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(fs, dir, prefix)
+}
+
+func (fs *Dedup) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (fs *Dedup) Chroot(path string) (billy.Filesystem, error) {
+	return chroot.New(fs, fs.Join(fs.Root(), path)), nil
+}
+
+func (fs *Dedup) Root() string {
+	return separator
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Dedup) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.underlying)
+}
+
+// file is a file open from the filesystem: a content, if open for reading,
+// or a temporary file to be stored once closed, if open for writing.
+type file struct {
+	billy.File
+	fs     *Dedup
+	name   string
+	path   string
+	append bool
+	read   bool
+}
+
+func (f *file) Name() string {
+	return f.name
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if !f.read {
+		return 0, errReadNotSupported
+	}
+
+	return f.File.Read(p)
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if !f.read {
+		return 0, errReadNotSupported
+	}
+
+	return f.File.ReadAt(p, off)
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	if f.append {
+		if _, err := f.File.Seek(0, io.SeekEnd); err != nil {
+			return 0, err
+		}
+	}
+
+	return f.File.Write(p)
+}
+
+func (f *file) Close() error {
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+
+	if f.fs == nil {
+		return nil
+	}
+
+	return f.fs.commit(f.path, f.File.Name())
+}
+
+type fileInfo struct {
+	os.FileInfo
+	size int64
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.size
+}
+
+// clean returns the given path as an absolute clean path, the form used in
+// the underlying filesystem.
+func clean(p string) string {
+	return filepath.Join(separator, p)
+}
+
+// isMeta returns whether the given path is MetaDir, or below it.
+func isMeta(p string) bool {
+	dir := filepath.Join(separator, MetaDir)
+	return p == dir || strings.HasPrefix(p, dir+separator)
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
+}
@@ -0,0 +1,113 @@
+package dedup
+
+import (
+	"os"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&DedupSuite{})
+
+type DedupSuite struct {
+	test.FilesystemSuite
+	underlying billy.Filesystem
+	fs         *Dedup
+}
+
+func (s *DedupSuite) SetUpTest(c *C) {
+	s.underlying = memfs.New()
+
+	var err error
+	s.fs, err = New(s.underlying)
+	c.Assert(err, IsNil)
+
+	s.FilesystemSuite = test.NewFilesystemSuite(s.fs)
+}
+
+func (s *DedupSuite) objects(c *C) int {
+	infos, err := s.underlying.ReadDir(objectsDir)
+	if os.IsNotExist(err) {
+		return 0
+	}
+
+	c.Assert(err, IsNil)
+	return len(infos)
+}
+
+func (s *DedupSuite) TestDeduplicated(c *C) {
+	c.Assert(util.WriteFile(s.fs, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.fs, "dir/bar", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.fs, "qux", []byte("qux"), 0644), IsNil)
+	c.Assert(s.objects(c), Equals, 2)
+
+	c.Assert(test.ReadFile(c, s.fs, "dir/bar"), Equals, "foo")
+	fi, err := s.fs.Stat("dir/bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Size(), Equals, int64(3))
+
+	// the content is kept while pointed by any file
+	c.Assert(s.fs.Remove("foo"), IsNil)
+	c.Assert(s.objects(c), Equals, 2)
+	c.Assert(s.fs.Rename("qux", "dir/bar"), IsNil)
+	c.Assert(s.objects(c), Equals, 1)
+	c.Assert(test.ReadFile(c, s.fs, "dir/bar"), Equals, "qux")
+}
+
+func (s *DedupSuite) TestOverwrite(c *C) {
+	c.Assert(util.WriteFile(s.fs, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.fs, "bar", []byte("foo"), 0644), IsNil)
+
+	f, err := s.fs.OpenFile("bar", os.O_WRONLY|os.O_APPEND, 0)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("bar"))
+	c.Assert(err, IsNil)
+
+	// the changes are seen once closed
+	c.Assert(test.ReadFile(c, s.fs, "bar"), Equals, "foo")
+	c.Assert(f.Close(), IsNil)
+
+	c.Assert(test.ReadFile(c, s.fs, "foo"), Equals, "foo")
+	c.Assert(test.ReadFile(c, s.fs, "bar"), Equals, "foobar")
+	c.Assert(s.objects(c), Equals, 2)
+
+	c.Assert(util.WriteFile(s.fs, "foo", []byte("foobar"), 0644), IsNil)
+	c.Assert(s.objects(c), Equals, 1)
+}
+
+func (s *DedupSuite) TestHidden(c *C) {
+	c.Assert(util.WriteFile(s.fs, "foo", []byte("foo"), 0644), IsNil)
+
+	infos, err := s.fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 1)
+
+	c.Assert(s.fs.Remove(MetaDir), NotNil)
+	_, err = s.fs.Create(MetaDir + "/foo")
+	c.Assert(err, NotNil)
+}
+
+func (s *DedupSuite) TestNew(c *C) {
+	c.Assert(util.WriteFile(s.fs, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.fs, "bar", []byte("foo"), 0644), IsNil)
+
+	// a content no longer pointed, eg.: if interrupted, is discarded
+	c.Assert(s.underlying.Remove("bar"), IsNil)
+	c.Assert(s.underlying.Remove("foo"), IsNil)
+	c.Assert(util.WriteFile(s.fs, "qux", []byte("qux"), 0644), IsNil)
+
+	fs, err := New(s.underlying)
+	c.Assert(err, IsNil)
+	c.Assert(s.objects(c), Equals, 1)
+	c.Assert(test.ReadFile(c, fs, "qux"), Equals, "qux")
+
+	c.Assert(fs.Remove("qux"), IsNil)
+	c.Assert(s.objects(c), Equals, 0)
+}
@@ -0,0 +1,474 @@
+// Package dryrun provides a helper recording the changes made to a filesystem
+// instead of applying them, so they can be reviewed and replayed later, eg.:
+// for the "what would change" mode of command line tools.
+package dryrun
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/internal/errutil"
+)
+
+const separator = string(filepath.Separator)
+
+// Op is the kind of operation of a plan.
+type Op string
+
+// The operations recorded in a plan.
+const (
+	// OpOpen is a file created, or truncated, by opening it.
+	OpOpen     Op = "open"
+	OpWrite    Op = "write"
+	OpTruncate Op = "truncate"
+	OpRemove   Op = "remove"
+	OpRename   Op = "rename"
+	OpMkdirAll Op = "mkdirall"
+	OpSymlink  Op = "symlink"
+)
+
+// Operation is a change made to the filesystem, not applied.
+type Operation struct {
+	Op Op
+	// Path is the path of the file changed, relative to the root of the
+	// DryRun created by New.
+	Path string
+	// To is the new path of a file renamed, or the target of a link.
+	To string
+	// Flag and Mode are the flags and the mode a file was open with, and
+	// Mode the one of the directories created.
+	Flag int
+	Mode os.FileMode
+	// Offset is the offset a file was written at, and Data the content.
+	Offset int64
+	Data   []byte
+	// Size is the size a file was truncated to.
+	Size int64
+}
+
+func (o Operation) String() string {
+	switch o.Op {
+	case OpWrite:
+		return fmt.Sprintf("write %s (%d bytes at %d)", o.Path, len(o.Data), o.Offset)
+	case OpTruncate:
+		return fmt.Sprintf("truncate %s to %d bytes", o.Path, o.Size)
+	case OpRename:
+		return fmt.Sprintf("rename %s to %s", o.Path, o.To)
+	case OpSymlink:
+		return fmt.Sprintf("symlink %s to %s", o.Path, o.To)
+	case OpOpen, OpMkdirAll:
+		return fmt.Sprintf("%s %s (%s)", o.Op, o.Path, o.Mode)
+	default:
+		return fmt.Sprintf("%s %s", o.Op, o.Path)
+	}
+}
+
+// DryRun is a helper passing the reads through to the underlying filesystem,
+// but recording in a plan the operations changing it, which are never
+// applied, so they can be inspected with Plan, and applied with Replay.
+//
+// The reads always see the underlying filesystem unchanged: the files
+// created can be written but not found, and the files written are read with
+// their original content. Only the operations changing the filesystem take
+// into account the ones recorded before, so a file created can be renamed or
+// removed, as well as the paths renamed to.
+type DryRun struct {
+	billy.Filesystem
+	s *state
+	// dir is the directory the root was moved to by Chroot, as the operations
+	// are recorded with their paths from the original root.
+	dir string
+}
+
+// state is the state shared by a DryRun, its files and its chroots.
+type state struct {
+	m    sync.Mutex
+	plan []Operation
+	next int
+	// paths are the paths created, true, or removed, false, by the plan.
+	paths map[string]bool
+}
+
+// New creates a new filesystem recording the changes made to the given one,
+// without applying them.
+func New(fs billy.Filesystem) *DryRun {
+	return &DryRun{Filesystem: fs, s: &state{paths: make(map[string]bool)}}
+}
+
+// Plan returns the operations recorded, in the order they were made.
+func (fs *DryRun) Plan() []Operation {
+	fs.s.m.Lock()
+	defer fs.s.m.Unlock()
+
+	return append([]Operation(nil), fs.s.plan...)
+}
+
+// Reset discards the operations recorded.
+func (fs *DryRun) Reset() {
+	fs.s.m.Lock()
+	defer fs.s.m.Unlock()
+
+	fs.s.plan = nil
+	fs.s.paths = make(map[string]bool)
+}
+
+// record records the given operation, creating or removing the given paths.
+func (s *state) record(o Operation, created []string, removed ...string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.plan = append(s.plan, o)
+	for _, p := range created {
+		s.paths[p] = true
+	}
+
+	for _, p := range removed {
+		s.paths[p] = false
+	}
+}
+
+// lookup returns if the given path exists once the plan applied, true or
+// false, or if it's unknown by the plan.
+func (s *state) lookup(p string) (exists, known bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if exists, ok := s.paths[p]; ok {
+		return exists, true
+	}
+
+	for dir := filepath.Dir(p); dir != separator; dir = filepath.Dir(dir) {
+		if exists, ok := s.paths[dir]; ok {
+			// the files below a directory created are unknown
+			return false, !exists
+		}
+	}
+
+	return false, false
+}
+
+// stat returns the information of the given file, from the given function of
+// the underlying filesystem, nil if it was created by the plan. It fails with
+// os.ErrNotExist if it doesn't exist once the plan applied.
+func (fs *DryRun) stat(op, filename string, stat func(string) (os.FileInfo, error)) (os.FileInfo, error) {
+	exists, known := fs.s.lookup(fs.path(filename))
+	if known && exists {
+		return nil, nil
+	}
+
+	if known {
+		return nil, &os.PathError{Op: op, Path: filename, Err: os.ErrNotExist}
+	}
+
+	return stat(filename)
+}
+
+// Replay applies the given operations, as returned by Plan, to the given
+// filesystem, stopping at the first failing.
+func Replay(fs billy.Filesystem, plan []Operation) error {
+	for _, o := range plan {
+		if err := replay(fs, o); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func replay(fs billy.Filesystem, o Operation) error {
+	switch o.Op {
+	case OpOpen:
+		f, err := fs.OpenFile(o.Path, o.Flag, o.Mode)
+		if err != nil {
+			return err
+		}
+
+		return f.Close()
+	case OpWrite, OpTruncate:
+		f, err := fs.OpenFile(o.Path, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+
+		if o.Op == OpTruncate {
+			err = f.Truncate(o.Size)
+		} else if _, err = f.Seek(o.Offset, io.SeekStart); err == nil {
+			_, err = f.Write(o.Data)
+		}
+
+		if err != nil {
+			f.Close()
+			return err
+		}
+
+		return f.Close()
+	case OpRemove:
+		return fs.Remove(o.Path)
+	case OpRename:
+		return fs.Rename(o.Path, o.To)
+	case OpMkdirAll:
+		return fs.MkdirAll(o.Path, o.Mode)
+	case OpSymlink:
+		return fs.Symlink(o.To, o.Path)
+	}
+
+	return fmt.Errorf("unknown operation %q", o.Op)
+}
+
+// path returns the given path relative to the root of the underlying
+// filesystem.
+func (fs *DryRun) path(p string) string {
+	return filepath.Join(separator, fs.dir, p)
+}
+
+func (fs *DryRun) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *DryRun) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file, recording its creation or truncation, and
+// returning a file recording the writes if open for writing.
+func (fs *DryRun) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if !isWrite(flag) {
+		return fs.Filesystem.OpenFile(filename, flag, perm)
+	}
+
+	f := &file{s: fs.s, name: filename, path: fs.path(filename), append: flag&os.O_APPEND != 0}
+
+	fi, err := fs.stat("open", filename, fs.Filesystem.Stat)
+	exists := err == nil
+	switch {
+	case exists && fi != nil && fi.IsDir():
+		return nil, &os.PathError{Op: "open", Path: filename, Err: fmt.Errorf("is a directory")}
+	case exists && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrExist}
+	case os.IsNotExist(err) && flag&os.O_CREATE == 0:
+		return nil, err
+	case err != nil && !os.IsNotExist(err):
+		return nil, err
+	}
+
+	if fi != nil && flag&os.O_TRUNC == 0 {
+		if f.r, err = fs.Filesystem.Open(filename); err != nil {
+			return nil, err
+		}
+
+		f.size = fi.Size()
+	}
+
+	if flag&(os.O_CREATE|os.O_TRUNC) != 0 {
+		fs.s.record(Operation{Op: OpOpen, Path: f.path, Flag: flag, Mode: perm}, []string{f.path})
+	}
+
+	return f, nil
+}
+
+// TempFile records the creation of a temporary file, with a name not
+// existing in the underlying filesystem.
+func (fs *DryRun) TempFile(dir, prefix string) (billy.File, error) {
+	for {
+		fs.s.m.Lock()
+		fs.s.next++
+		name := filepath.Join(dir, prefix+strconv.Itoa(fs.s.next))
+		fs.s.m.Unlock()
+
+		if _, err := fs.stat("tempfile", name, fs.Filesystem.Lstat); os.IsNotExist(err) {
+			return fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Rename records the rename of the given file, if it exists.
+func (fs *DryRun) Rename(from, to string) error {
+	if _, err := fs.stat("rename", from, fs.Filesystem.Lstat); err != nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: errutil.Underlying(err)}
+	}
+
+	o := Operation{Op: OpRename, Path: fs.path(from), To: fs.path(to)}
+	fs.s.record(o, []string{o.To}, o.Path)
+	return nil
+}
+
+// Remove records the removal of the given file, if it exists.
+func (fs *DryRun) Remove(filename string) error {
+	if _, err := fs.stat("remove", filename, fs.Filesystem.Lstat); err != nil {
+		return err
+	}
+
+	o := Operation{Op: OpRemove, Path: fs.path(filename)}
+	fs.s.record(o, nil, o.Path)
+	return nil
+}
+
+// MkdirAll records the creation of the given directory, if it doesn't exist.
+func (fs *DryRun) MkdirAll(filename string, perm os.FileMode) error {
+	fi, err := fs.stat("mkdir", filename, fs.Filesystem.Stat)
+	if err == nil && (fi == nil || fi.IsDir()) {
+		return nil
+	}
+
+	if err == nil {
+		return &os.PathError{Op: "mkdir", Path: filename, Err: fmt.Errorf("not a directory")}
+	}
+
+	o := Operation{Op: OpMkdirAll, Path: fs.path(filename), Mode: perm}
+	fs.s.record(o, []string{o.Path})
+	return nil
+}
+
+// Symlink records the creation of the given link, if it doesn't exist.
+func (fs *DryRun) Symlink(target, link string) error {
+	if _, err := fs.stat("symlink", link, fs.Filesystem.Lstat); err == nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: os.ErrExist}
+	}
+
+	o := Operation{Op: OpSymlink, Path: fs.path(link), To: target}
+	fs.s.record(o, []string{o.Path})
+	return nil
+}
+
+// Chroot returns the given directory of the underlying filesystem, recording
+// the changes in the same plan.
+func (fs *DryRun) Chroot(path string) (billy.Filesystem, error) {
+	chroot, err := fs.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DryRun{Filesystem: chroot, s: fs.s, dir: fs.path(path)}, nil
+}
+
+// Capabilities implements the Capable interface.
+func (fs *DryRun) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.Filesystem)
+}
+
+// file is a file open for writing, recording the writes and truncates, and
+// reading the original content of the file, if any.
+type file struct {
+	s    *state
+	name string
+	path string
+	// r is the original file, nil if created or truncated.
+	r billy.File
+
+	append    bool
+	pos, size int64
+	closed    bool
+}
+
+func (f *file) Name() string {
+	return f.name
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.pos)
+	f.pos += int64(n)
+	return n, err
+}
+
+// ReadAt reads the original content of the file, up to its current size.
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+
+	if f.r == nil || off >= f.size {
+		return 0, io.EOF
+	}
+
+	if max := f.size - off; int64(len(p)) > max {
+		p = p[:max]
+	}
+
+	return f.r.ReadAt(p, off)
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+
+	if f.append {
+		f.pos = f.size
+	}
+
+	f.s.record(Operation{
+		Op:     OpWrite,
+		Path:   f.path,
+		Offset: f.pos,
+		Data:   append([]byte(nil), p...),
+	}, nil)
+
+	f.pos += int64(len(p))
+	if f.pos > f.size {
+		f.size = f.pos
+	}
+
+	return len(p), nil
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.size
+	}
+
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: os.ErrInvalid}
+	}
+
+	f.pos = offset
+	return offset, nil
+}
+
+func (f *file) Truncate(size int64) error {
+	if f.closed {
+		return os.ErrClosed
+	}
+
+	f.s.record(Operation{Op: OpTruncate, Path: f.path, Size: size}, nil)
+	f.size = size
+	return nil
+}
+
+func (f *file) Close() error {
+	if f.closed {
+		return os.ErrClosed
+	}
+
+	f.closed = true
+	if f.r != nil {
+		return f.r.Close()
+	}
+
+	return nil
+}
+
+func (f *file) Lock() error {
+	return nil
+}
+
+func (f *file) Unlock() error {
+	return nil
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
+}
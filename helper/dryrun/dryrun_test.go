@@ -0,0 +1,144 @@
+package dryrun
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&DryRunSuite{})
+
+type DryRunSuite struct {
+	underlying billy.Filesystem
+	FS         *DryRun
+}
+
+func (s *DryRunSuite) SetUpTest(c *C) {
+	s.underlying = memfs.New()
+	c.Assert(util.WriteFile(s.underlying, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(s.underlying.MkdirAll("dir", 0755), IsNil)
+
+	s.FS = New(s.underlying)
+}
+
+func (s *DryRunSuite) TestPlan(c *C) {
+	c.Assert(util.WriteFile(s.FS, "bar", []byte("bar"), 0644), IsNil)
+	c.Assert(s.FS.MkdirAll("qux/baz", 0755), IsNil)
+	c.Assert(s.FS.Rename("foo", "qux/foo"), IsNil)
+	c.Assert(s.FS.Remove("dir"), IsNil)
+	c.Assert(s.FS.Symlink("foo", "link"), IsNil)
+
+	plan := s.FS.Plan()
+	c.Assert(plan, HasLen, 6)
+	c.Assert(plan[0].String(), Equals, "open /bar (-rw-r--r--)")
+	c.Assert(plan[1].String(), Equals, "write /bar (3 bytes at 0)")
+	c.Assert(plan[2].String(), Equals, "mkdirall /qux/baz (-rwxr-xr-x)")
+	c.Assert(plan[3].String(), Equals, "rename /foo to /qux/foo")
+	c.Assert(plan[4].String(), Equals, "remove /dir")
+	c.Assert(plan[5].String(), Equals, "symlink /link to foo")
+
+	// nothing is applied
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "foo")
+	_, err := s.underlying.Stat("bar")
+	c.Assert(os.IsNotExist(err), Equals, true)
+	_, err = s.underlying.Stat("dir")
+	c.Assert(err, IsNil)
+
+	s.FS.Reset()
+	c.Assert(s.FS.Plan(), HasLen, 0)
+}
+
+func (s *DryRunSuite) TestFailures(c *C) {
+	c.Assert(os.IsNotExist(s.FS.Remove("missing")), Equals, true)
+	err := s.FS.Rename("missing", "bar")
+	c.Assert(os.IsNotExist(err.(*os.LinkError).Err), Equals, true)
+
+	_, err = s.FS.OpenFile("missing", os.O_WRONLY, 0)
+	c.Assert(os.IsNotExist(err), Equals, true)
+	_, err = s.FS.OpenFile("foo", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	c.Assert(os.IsExist(err), Equals, true)
+	c.Assert(s.FS.MkdirAll("foo", 0755), NotNil)
+	c.Assert(s.FS.Plan(), HasLen, 0)
+
+	// the operations recorded are taken into account
+	c.Assert(s.FS.Rename("foo", "bar"), IsNil)
+	c.Assert(os.IsNotExist(s.FS.Remove("foo")), Equals, true)
+	c.Assert(s.FS.Remove("bar"), IsNil)
+	c.Assert(s.FS.Remove("dir"), IsNil)
+	_, err = s.FS.OpenFile("dir/foo", os.O_WRONLY, 0)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *DryRunSuite) TestFile(c *C) {
+	f, err := s.FS.OpenFile("foo", os.O_RDWR, 0)
+	c.Assert(err, IsNil)
+
+	_, err = f.Seek(0, io.SeekEnd)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("bar"))
+	c.Assert(err, IsNil)
+
+	// the original content is read
+	_, err = f.Seek(0, io.SeekStart)
+	c.Assert(err, IsNil)
+	b, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(b), Equals, "foo")
+
+	c.Assert(f.Truncate(2), IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	f, err = s.FS.OpenFile("foo", os.O_WRONLY|os.O_APPEND, 0)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("qux"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	plan := s.FS.Plan()
+	c.Assert(plan, HasLen, 3)
+	c.Assert(plan[0].Offset, Equals, int64(3))
+	c.Assert(plan[1].Size, Equals, int64(2))
+	c.Assert(plan[2].Offset, Equals, int64(3))
+}
+
+func (s *DryRunSuite) TestReplay(c *C) {
+	c.Assert(util.WriteFile(s.FS, "bar", []byte("bar"), 0644), IsNil)
+	f, err := s.FS.OpenFile("foo", os.O_WRONLY|os.O_APPEND, 0)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("qux"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	tmp, err := s.FS.TempFile("dir", "tmp")
+	c.Assert(err, IsNil)
+	_, err = tmp.Write([]byte("baz"))
+	c.Assert(err, IsNil)
+	c.Assert(tmp.Close(), IsNil)
+	c.Assert(s.FS.Rename(tmp.Name(), "dir/baz"), IsNil)
+
+	chroot, err := s.FS.Chroot("dir")
+	c.Assert(err, IsNil)
+	c.Assert(chroot.MkdirAll("sub", 0755), IsNil)
+
+	c.Assert(Replay(s.underlying, s.FS.Plan()), IsNil)
+	c.Assert(test.ReadFile(c, s.underlying, "bar"), Equals, "bar")
+	c.Assert(test.ReadFile(c, s.underlying, "foo"), Equals, "fooqux")
+	c.Assert(test.ReadFile(c, s.underlying, "dir/baz"), Equals, "baz")
+
+	fi, err := s.underlying.Stat("dir/sub")
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+
+	err = Replay(s.underlying, []Operation{{Op: OpRemove, Path: "missing"}})
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
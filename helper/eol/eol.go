@@ -0,0 +1,205 @@
+// Package eol provides a helper converting the line endings of text files
+// between the ones stored in a filesystem and the ones used by the callers.
+package eol
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+)
+
+// LineEnding is a line terminator.
+type LineEnding string
+
+const (
+	// LF is the line ending used by Unix systems.
+	LF LineEnding = "\n"
+	// CRLF is the line ending used by Windows systems.
+	CRLF LineEnding = "\r\n"
+)
+
+// binaryCheckSize is the amount of bytes inspected to detect binary content,
+// the same used by git.
+const binaryCheckSize = 8000
+
+// Options holds the configuration of the conversion.
+type Options struct {
+	// Patterns are the shell patterns, as in filepath.Match, of the files to
+	// convert. A pattern without separators is matched against the base name
+	// of the file, and against the full path otherwise. If empty, every file
+	// is converted.
+	Patterns []string
+	// Stored is the line ending of the files in the underlying filesystem,
+	// LF by default.
+	Stored LineEnding
+	// Presented is the line ending of the files read or written through the
+	// helper, CRLF by default.
+	Presented LineEnding
+}
+
+// EOL is a helper that converts the line endings of the files matching the
+// configured patterns. The content is converted when the file is read and
+// written, binary files are detected and never converted.
+//
+// The files being converted are fully loaded in memory when opened, and the
+// content written is stored on Close. The sizes reported by Stat and ReadDir
+// are the ones of the stored files.
+type EOL struct {
+	billy.Filesystem
+	opts Options
+}
+
+// New creates a new filesystem wrapping up the given 'fs', converting the
+// line endings as configured by opts.
+func New(fs billy.Filesystem, opts Options) billy.Filesystem {
+	if opts.Stored == "" {
+		opts.Stored = LF
+	}
+
+	if opts.Presented == "" {
+		opts.Presented = CRLF
+	}
+
+	return &EOL{Filesystem: fs, opts: opts}
+}
+
+// Match returns true if the given file is converted.
+func (h *EOL) Match(filename string) bool {
+	if len(h.opts.Patterns) == 0 {
+		return true
+	}
+
+	p := strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(filename)), "/")
+	for _, pattern := range h.opts.Patterns {
+		pattern = filepath.ToSlash(pattern)
+
+		name := p
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(p)
+		}
+
+		if ok, _ := path.Match(strings.TrimPrefix(pattern, "/"), name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (h *EOL) Create(filename string) (billy.File, error) {
+	return h.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (h *EOL) Open(filename string) (billy.File, error) {
+	return h.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (h *EOL) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if !h.Match(filename) || h.opts.Stored == h.opts.Presented {
+		return h.Filesystem.OpenFile(filename, flag, perm)
+	}
+
+	f, err := h.Filesystem.OpenFile(filename, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	var content []byte
+	if flag&os.O_TRUNC == 0 {
+		content, err = h.read(filename)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		if err := f.Close(); err != nil {
+			return nil, err
+		}
+
+		return newFile(filename, content, flag, nil), nil
+	}
+
+	return newFile(filename, content, flag, func(content []byte) error {
+		return h.write(f, content)
+	}), nil
+}
+
+// read returns the content of the file converted to the presented line
+// ending.
+func (h *EOL) read(filename string) ([]byte, error) {
+	f, err := h.Filesystem.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return Convert(content, h.opts.Presented), nil
+}
+
+// write stores the content converted to the stored line ending into f, and
+// closes it.
+func (h *EOL) write(f billy.File, content []byte) error {
+	content = Convert(content, h.opts.Stored)
+
+	err := f.Truncate(0)
+	if err == nil {
+		_, err = f.Seek(0, 0)
+	}
+
+	if err == nil {
+		_, err = f.Write(content)
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+func (h *EOL) Chroot(p string) (billy.Filesystem, error) {
+	return chroot.New(h, h.Join(h.Root(), p)), nil
+}
+
+// Capabilities implements the Capable interface.
+func (h *EOL) Capabilities() billy.Capability {
+	return billy.Capabilities(h.Filesystem)
+}
+
+// IsBinary returns true if the content looks like binary data, containing a
+// NUL byte in its first 8000 bytes.
+func IsBinary(content []byte) bool {
+	if len(content) > binaryCheckSize {
+		content = content[:binaryCheckSize]
+	}
+
+	return bytes.IndexByte(content, 0) != -1
+}
+
+// Convert returns the given content with all its line endings converted to
+// the given one. Binary content is returned untouched.
+func Convert(content []byte, to LineEnding) []byte {
+	if IsBinary(content) {
+		return content
+	}
+
+	content = bytes.Replace(content, []byte(CRLF), []byte(LF), -1)
+	if to == CRLF {
+		content = bytes.Replace(content, []byte(LF), []byte(CRLF), -1)
+	}
+
+	return content
+}
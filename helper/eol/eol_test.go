@@ -0,0 +1,97 @@
+package eol
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&EOLSuite{})
+
+type EOLSuite struct {
+	test.FilesystemSuite
+	underlying billy.Filesystem
+}
+
+func (s *EOLSuite) SetUpTest(c *C) {
+	s.underlying = memfs.New()
+	s.FilesystemSuite = test.NewFilesystemSuite(New(s.underlying, Options{
+		Patterns: []string{"*.txt", "docs/*"},
+	}))
+}
+
+func (s *EOLSuite) TestRead(c *C) {
+	c.Assert(util.WriteFile(s.underlying, "foo.txt", []byte("foo\nbar\r\nbaz\n"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "foo.txt"), Equals, "foo\r\nbar\r\nbaz\r\n")
+
+	f, err := s.FS.Open("foo.txt")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	pos, err := f.Seek(-5, io.SeekEnd)
+	c.Assert(err, IsNil)
+	c.Assert(pos, Equals, int64(10))
+
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "baz\r\n")
+}
+
+func (s *EOLSuite) TestWrite(c *C) {
+	c.Assert(util.WriteFile(s.FS, "docs/foo", []byte("foo\r\nbar\n"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.underlying, "docs/foo"), Equals, "foo\nbar\n")
+	c.Assert(test.ReadFile(c, s.FS, "docs/foo"), Equals, "foo\r\nbar\r\n")
+
+	f, err := s.FS.OpenFile("docs/foo", os.O_WRONLY|os.O_APPEND, 0)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("baz\r\n"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	c.Assert(test.ReadFile(c, s.underlying, "docs/foo"), Equals, "foo\nbar\nbaz\n")
+}
+
+func (s *EOLSuite) TestBinary(c *C) {
+	binary := "foo\n\x00bar\r\n"
+	c.Assert(util.WriteFile(s.underlying, "foo.txt", []byte(binary), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "foo.txt"), Equals, binary)
+
+	c.Assert(util.WriteFile(s.FS, "bar.txt", []byte(binary), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.underlying, "bar.txt"), Equals, binary)
+}
+
+func (s *EOLSuite) TestNotMatching(c *C) {
+	c.Assert(util.WriteFile(s.underlying, "foo.bin", []byte("foo\n"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "foo.bin"), Equals, "foo\n")
+
+	c.Assert(util.WriteFile(s.FS, "qux/foo", []byte("foo\r\n"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.underlying, "qux/foo"), Equals, "foo\r\n")
+}
+
+func (s *EOLSuite) TestMatch(c *C) {
+	h := New(memfs.New(), Options{Patterns: []string{"*.txt", "/docs/*.md"}}).(*EOL)
+	c.Assert(h.Match("foo.txt"), Equals, true)
+	c.Assert(h.Match("/a/b/foo.txt"), Equals, true)
+	c.Assert(h.Match("docs/foo.md"), Equals, true)
+	c.Assert(h.Match("other/docs/foo.md"), Equals, false)
+	c.Assert(h.Match("foo.md"), Equals, false)
+
+	c.Assert(New(memfs.New(), Options{}).(*EOL).Match("foo"), Equals, true)
+}
+
+func (s *EOLSuite) TestToLF(c *C) {
+	fs := New(s.underlying, Options{Stored: CRLF, Presented: LF})
+	c.Assert(util.WriteFile(fs, "foo", []byte("foo\nbar\n"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.underlying, "foo"), Equals, "foo\r\nbar\r\n")
+	c.Assert(test.ReadFile(c, fs, "foo"), Equals, "foo\nbar\n")
+}
@@ -0,0 +1,45 @@
+package eol
+
+import (
+	"os"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/internal/memfile"
+)
+
+// file is an in-memory billy.File holding the converted content of a file.
+// The content is handed to commit on Close, when the file is writable.
+type file struct {
+	*memfile.File
+
+	commit func([]byte) error
+}
+
+func newFile(name string, content []byte, flag int, commit func([]byte) error) billy.File {
+	f := &file{File: memfile.New(name, flag, nil), commit: commit}
+	f.Content = content
+	return f
+}
+
+func (f *file) Close() error {
+	if f.Closed {
+		return os.ErrClosed
+	}
+
+	f.Closed = true
+	if f.commit == nil {
+		return nil
+	}
+
+	return f.commit(f.Content)
+}
+
+// Lock is a no-op in eol.
+func (f *file) Lock() error {
+	return nil
+}
+
+// Unlock is a no-op in eol.
+func (f *file) Unlock() error {
+	return nil
+}
@@ -0,0 +1,251 @@
+// Package fallback provides a helper reading from a primary filesystem and,
+// when it fails, from one or more mirrors, eg.: to layer artifact caches.
+package fallback
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+// Options holds the configuration of a Fallback.
+type Options struct {
+	// Mirrors are the filesystems read, in order, when the primary fails.
+	Mirrors []billy.Filesystem
+	// Backfill copies to the primary the files open from a mirror, when
+	// missing in the primary, so they are read from it the next time.
+	Backfill bool
+	// Fallback reports if the given error, returned by the primary or by a
+	// mirror, allows reading from the next mirror. Any error does if nil.
+	Fallback func(err error) bool
+}
+
+// Fallback is a helper reading from the underlying filesystem, the primary,
+// and falling back to the mirrors, in order, when it fails. Only the files
+// open for reading, and the results of Stat, Lstat, ReadDir and Readlink,
+// are read from the mirrors; any change is made only to the primary.
+//
+// If every filesystem fails, the error of the primary is returned. The
+// content of the files back-filled is copied following the links of the
+// mirror, and any failure back-filling them is ignored.
+type Fallback struct {
+	billy.Filesystem
+	opts Options
+}
+
+// New creates a new filesystem reading from the given primary, falling back
+// to the mirrors of the options.
+func New(primary billy.Filesystem, opts Options) *Fallback {
+	return &Fallback{Filesystem: primary, opts: opts}
+}
+
+func (fs *Fallback) fallback(err error) bool {
+	if fs.opts.Fallback == nil {
+		return true
+	}
+
+	return fs.opts.Fallback(err)
+}
+
+// read calls fn with the primary and, while it fails with an error allowing
+// it, with every mirror.
+func (fs *Fallback) read(fn func(billy.Filesystem) error) error {
+	err := fn(fs.Filesystem)
+	if err == nil || !fs.fallback(err) {
+		return err
+	}
+
+	for _, m := range fs.opts.Mirrors {
+		merr := fn(m)
+		if merr == nil || !fs.fallback(merr) {
+			return merr
+		}
+	}
+
+	return err
+}
+
+func (fs *Fallback) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Fallback) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file of the primary, falling back to the mirrors
+// if open only for reading.
+func (fs *Fallback) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if isWrite(flag) {
+		return fs.Filesystem.OpenFile(filename, flag, perm)
+	}
+
+	var f billy.File
+	primary, missing := true, false
+	err := fs.read(func(m billy.Filesystem) (err error) {
+		f, err = m.OpenFile(filename, flag, perm)
+		if primary {
+			primary, missing = false, os.IsNotExist(err)
+			return err
+		}
+
+		if err != nil || !fs.opts.Backfill || !missing {
+			return err
+		}
+
+		f, err = fs.backfill(m, filename, f)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// backfill copies to the primary the given file, open from the given mirror,
+// returning it open from the primary, or the one given if failed.
+func (fs *Fallback) backfill(m billy.Filesystem, filename string, f billy.File) (billy.File, error) {
+	if err := fs.copy(m, filename, f); err != nil {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		return f, nil
+	}
+
+	f.Close()
+	return fs.Filesystem.Open(filename)
+}
+
+// copy copies the content of the given file to the primary, through a
+// temporary file renamed once written.
+func (fs *Fallback) copy(m billy.Filesystem, filename string, src billy.File) error {
+	fi, err := m.Stat(filename)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(filename)
+	if err := fs.Filesystem.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := util.TempFile(fs.Filesystem, dir, "."+filepath.Base(filename)+".fallback")
+	if err != nil {
+		return err
+	}
+
+	name := filepath.Join(dir, filepath.Base(tmp.Name()))
+	_, err = io.Copy(tmp, src)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = fs.Filesystem.Rename(name, filename)
+	}
+
+	if err != nil {
+		fs.Filesystem.Remove(name)
+		return err
+	}
+
+	// the permissions of the temporary files are fixed
+	if c, ok := fs.Filesystem.(billy.Change); ok {
+		return c.Chmod(filename, fi.Mode().Perm())
+	}
+
+	return nil
+}
+
+func (fs *Fallback) Stat(filename string) (os.FileInfo, error) {
+	var fi os.FileInfo
+	err := fs.read(func(m billy.Filesystem) (err error) {
+		fi, err = m.Stat(filename)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return fi, nil
+}
+
+func (fs *Fallback) Lstat(filename string) (os.FileInfo, error) {
+	var fi os.FileInfo
+	err := fs.read(func(m billy.Filesystem) (err error) {
+		fi, err = m.Lstat(filename)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return fi, nil
+}
+
+// ReadDir reads the given directory of the first filesystem not failing,
+// without merging the entries of the others.
+func (fs *Fallback) ReadDir(path string) ([]os.FileInfo, error) {
+	var infos []os.FileInfo
+	err := fs.read(func(m billy.Filesystem) (err error) {
+		infos, err = m.ReadDir(path)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return infos, nil
+}
+
+func (fs *Fallback) Readlink(link string) (string, error) {
+	var target string
+	err := fs.read(func(m billy.Filesystem) (err error) {
+		target, err = m.Readlink(link)
+		return err
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return target, nil
+}
+
+// Chroot returns the given directory of the primary, falling back to the same
+// directory of the mirrors.
+func (fs *Fallback) Chroot(path string) (billy.Filesystem, error) {
+	primary, err := fs.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := fs.opts
+	opts.Mirrors = make([]billy.Filesystem, len(fs.opts.Mirrors))
+	for i, m := range fs.opts.Mirrors {
+		if opts.Mirrors[i], err = m.Chroot(path); err != nil {
+			return nil, err
+		}
+	}
+
+	return New(primary, opts), nil
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Fallback) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.Filesystem)
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
+}
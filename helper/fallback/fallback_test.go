@@ -0,0 +1,139 @@
+package fallback
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/faultfs"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&FallbackSuite{})
+
+type FallbackSuite struct {
+	test.FilesystemSuite
+	primary, first, second billy.Filesystem
+}
+
+func (s *FallbackSuite) SetUpTest(c *C) {
+	s.primary = memfs.New()
+	s.first = memfs.New()
+	s.second = memfs.New()
+
+	s.FilesystemSuite = test.NewFilesystemSuite(New(s.primary, Options{
+		Mirrors:  []billy.Filesystem{memfs.New()},
+		Backfill: true,
+	}))
+}
+
+func (s *FallbackSuite) TestFallback(c *C) {
+	fs := New(s.primary, Options{Mirrors: []billy.Filesystem{s.first, s.second}})
+	c.Assert(util.WriteFile(s.primary, "foo", []byte("primary"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.first, "foo", []byte("first"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.first, "bar", []byte("first"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.second, "bar", []byte("second"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.second, "qux/baz", []byte("second"), 0644), IsNil)
+	c.Assert(s.second.Symlink("bar", "link"), IsNil)
+
+	c.Assert(test.ReadFile(c, fs, "foo"), Equals, "primary")
+	c.Assert(test.ReadFile(c, fs, "bar"), Equals, "first")
+	c.Assert(test.ReadFile(c, fs, "qux/baz"), Equals, "second")
+
+	fi, err := fs.Stat("qux")
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+
+	target, err := fs.Readlink("link")
+	c.Assert(err, IsNil)
+	c.Assert(target, Equals, "bar")
+
+	_, err = fs.Open("missing")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	// nothing is back-filled unless asked
+	_, err = s.primary.Stat("bar")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *FallbackSuite) TestWrite(c *C) {
+	fs := New(s.primary, Options{Mirrors: []billy.Filesystem{s.first}})
+	c.Assert(util.WriteFile(s.first, "foo", []byte("first"), 0644), IsNil)
+
+	c.Assert(util.WriteFile(fs, "foo", []byte("primary"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, fs, "foo"), Equals, "primary")
+	c.Assert(test.ReadFile(c, s.first, "foo"), Equals, "first")
+
+	// the files open for writing are never read from the mirrors
+	c.Assert(util.WriteFile(s.first, "bar", []byte("first"), 0644), IsNil)
+	_, err := fs.OpenFile("bar", os.O_RDWR, 0)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *FallbackSuite) TestBackfill(c *C) {
+	fs := New(s.primary, Options{Mirrors: []billy.Filesystem{s.first}, Backfill: true})
+	c.Assert(util.WriteFile(s.first, "qux/foo", []byte("first"), 0600), IsNil)
+
+	c.Assert(test.ReadFile(c, fs, "qux/foo"), Equals, "first")
+	c.Assert(test.ReadFile(c, s.primary, "qux/foo"), Equals, "first")
+
+	fi, err := s.primary.Stat("qux/foo")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode().Perm(), Equals, os.FileMode(0600))
+
+	infos, err := s.primary.ReadDir("qux")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 1)
+
+	// the primary is read from then on
+	c.Assert(util.WriteFile(s.first, "qux/foo", []byte("changed"), 0600), IsNil)
+	c.Assert(test.ReadFile(c, fs, "qux/foo"), Equals, "first")
+}
+
+func (s *FallbackSuite) TestBackfillPrimaryFailing(c *C) {
+	faults := faultfs.New(s.primary)
+	fs := New(faults, Options{Mirrors: []billy.Filesystem{s.first}, Backfill: true})
+	c.Assert(util.WriteFile(s.primary, "foo", []byte("primary"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.first, "foo", []byte("first"), 0644), IsNil)
+
+	faults.Add(faultfs.Rule{Op: faultfs.OpOpen, Nth: 1, Err: errors.New("unavailable")})
+	c.Assert(test.ReadFile(c, fs, "foo"), Equals, "first")
+
+	// only the files missing are back-filled
+	c.Assert(test.ReadFile(c, s.primary, "foo"), Equals, "primary")
+}
+
+func (s *FallbackSuite) TestFallbackOption(c *C) {
+	fs := New(s.primary, Options{
+		Mirrors:  []billy.Filesystem{s.first, s.second},
+		Fallback: os.IsNotExist,
+	})
+
+	faults := faultfs.New(s.first)
+	fs.opts.Mirrors[0] = faults
+	c.Assert(util.WriteFile(s.second, "foo", []byte("second"), 0644), IsNil)
+
+	errUnavailable := errors.New("unavailable")
+	faults.Add(faultfs.Rule{Op: faultfs.OpStat, Err: errUnavailable})
+	_, err := fs.Stat("foo")
+	c.Assert(err.(*os.PathError).Err, Equals, errUnavailable)
+
+	c.Assert(test.ReadFile(c, fs, "foo"), Equals, "second")
+}
+
+func (s *FallbackSuite) TestChroot(c *C) {
+	fs := New(s.primary, Options{Mirrors: []billy.Filesystem{s.first}, Backfill: true})
+	c.Assert(util.WriteFile(s.first, "qux/foo", []byte("first"), 0644), IsNil)
+
+	chroot, err := fs.Chroot("qux")
+	c.Assert(err, IsNil)
+	c.Assert(test.ReadFile(c, chroot, "foo"), Equals, "first")
+	c.Assert(test.ReadFile(c, s.primary, "qux/foo"), Equals, "first")
+}
@@ -0,0 +1,336 @@
+// Package faultfs provides a helper failing chosen operations of a
+// filesystem, to test the handling of the errors of the code using it.
+package faultfs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+const separator = string(filepath.Separator)
+
+// Op is an operation made to a filesystem, or to a file open from it.
+type Op string
+
+// The operations that can fail. Create, Open and OpenFile are all OpOpen.
+const (
+	OpOpen     Op = "open"
+	OpStat     Op = "stat"
+	OpLstat    Op = "lstat"
+	OpReadDir  Op = "readdir"
+	OpRename   Op = "rename"
+	OpRemove   Op = "remove"
+	OpMkdirAll Op = "mkdirall"
+	OpSymlink  Op = "symlink"
+	OpReadlink Op = "readlink"
+	OpTempFile Op = "tempfile"
+	OpChroot   Op = "chroot"
+
+	OpRead     Op = "read"
+	OpWrite    Op = "write"
+	OpSeek     Op = "seek"
+	OpTruncate Op = "truncate"
+	OpClose    Op = "close"
+	OpLock     Op = "lock"
+	OpUnlock   Op = "unlock"
+)
+
+// Rule describes the calls failing, eg.: the third write to any "*.pack"
+// file failing with syscall.ENOSPC:
+//
+//	fs.Add(faultfs.Rule{Op: faultfs.OpWrite, Path: "*.pack", Nth: 3, Err: syscall.ENOSPC})
+type Rule struct {
+	// Op is the operation failing, every one if empty. Read includes
+	// ReadAt.
+	Op Op
+	// Path is the pattern, as in filepath.Match, of the paths failing,
+	// every one if empty. The patterns without separators are matched with
+	// the name of the files, the others with their whole path, relative to
+	// the root. The paths of the operations involving two, as Rename, are
+	// the first one, and of the files, the one they were open with.
+	Path string
+	// Nth is the number of the call matching the rule that fails, counted
+	// from 1, every one if 0.
+	Nth int
+	// Err is the error returned, as the error of an *os.PathError.
+	Err error
+}
+
+// FaultFS is a helper failing the calls to the underlying filesystem, and to
+// the files open from it, matching any of the rules added. The calls are
+// counted by every rule they match, even if failed by another one.
+type FaultFS struct {
+	billy.Filesystem
+	s *state
+}
+
+// state is the state shared by a FaultFS, its files and its chroots.
+type state struct {
+	m     sync.Mutex
+	rules []*rule
+}
+
+type rule struct {
+	Rule
+	calls int
+}
+
+// New creates a new filesystem failing the calls to the given one, once
+// added the rules.
+func New(fs billy.Filesystem) *FaultFS {
+	return &FaultFS{Filesystem: fs, s: &state{}}
+}
+
+// Add adds the given rule, taking precedence over the ones added before.
+func (fs *FaultFS) Add(r Rule) {
+	fs.s.m.Lock()
+	defer fs.s.m.Unlock()
+
+	fs.s.rules = append([]*rule{{Rule: r}}, fs.s.rules...)
+}
+
+// Reset removes every rule.
+func (fs *FaultFS) Reset() {
+	fs.s.m.Lock()
+	defer fs.s.m.Unlock()
+
+	fs.s.rules = nil
+}
+
+// fault returns the error of the given call, if failing.
+func (s *state) fault(op Op, path string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	var err error
+	for _, r := range s.rules {
+		if !r.match(op, path) {
+			continue
+		}
+
+		r.calls++
+		if err == nil && (r.Nth == 0 || r.Nth == r.calls) {
+			err = &os.PathError{Op: string(op), Path: path, Err: r.Err}
+		}
+	}
+
+	return err
+}
+
+func (r *rule) match(op Op, path string) bool {
+	if r.Op != "" && r.Op != op {
+		return false
+	}
+
+	if r.Path == "" {
+		return true
+	}
+
+	path = strings.TrimPrefix(filepath.Clean(separator+path), separator)
+	if !strings.Contains(r.Path, separator) {
+		path = filepath.Base(path)
+	}
+
+	matched, _ := filepath.Match(strings.TrimPrefix(r.Path, separator), path)
+	return matched
+}
+
+func (fs *FaultFS) Create(filename string) (billy.File, error) {
+	if err := fs.s.fault(OpOpen, filename); err != nil {
+		return nil, err
+	}
+
+	return fs.file(fs.Filesystem.Create(filename))
+}
+
+func (fs *FaultFS) Open(filename string) (billy.File, error) {
+	if err := fs.s.fault(OpOpen, filename); err != nil {
+		return nil, err
+	}
+
+	return fs.file(fs.Filesystem.Open(filename))
+}
+
+func (fs *FaultFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if err := fs.s.fault(OpOpen, filename); err != nil {
+		return nil, err
+	}
+
+	return fs.file(fs.Filesystem.OpenFile(filename, flag, perm))
+}
+
+func (fs *FaultFS) TempFile(dir, prefix string) (billy.File, error) {
+	if err := fs.s.fault(OpTempFile, dir); err != nil {
+		return nil, err
+	}
+
+	return fs.file(fs.Filesystem.TempFile(dir, prefix))
+}
+
+func (fs *FaultFS) file(f billy.File, err error) (billy.File, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	return &file{File: f, s: fs.s}, nil
+}
+
+func (fs *FaultFS) Stat(filename string) (os.FileInfo, error) {
+	if err := fs.s.fault(OpStat, filename); err != nil {
+		return nil, err
+	}
+
+	return fs.Filesystem.Stat(filename)
+}
+
+func (fs *FaultFS) Lstat(filename string) (os.FileInfo, error) {
+	if err := fs.s.fault(OpLstat, filename); err != nil {
+		return nil, err
+	}
+
+	return fs.Filesystem.Lstat(filename)
+}
+
+func (fs *FaultFS) ReadDir(path string) ([]os.FileInfo, error) {
+	if err := fs.s.fault(OpReadDir, path); err != nil {
+		return nil, err
+	}
+
+	return fs.Filesystem.ReadDir(path)
+}
+
+func (fs *FaultFS) Rename(from, to string) error {
+	if err := fs.s.fault(OpRename, from); err != nil {
+		return err
+	}
+
+	return fs.Filesystem.Rename(from, to)
+}
+
+func (fs *FaultFS) Remove(filename string) error {
+	if err := fs.s.fault(OpRemove, filename); err != nil {
+		return err
+	}
+
+	return fs.Filesystem.Remove(filename)
+}
+
+func (fs *FaultFS) MkdirAll(filename string, perm os.FileMode) error {
+	if err := fs.s.fault(OpMkdirAll, filename); err != nil {
+		return err
+	}
+
+	return fs.Filesystem.MkdirAll(filename, perm)
+}
+
+func (fs *FaultFS) Symlink(target, link string) error {
+	if err := fs.s.fault(OpSymlink, link); err != nil {
+		return err
+	}
+
+	return fs.Filesystem.Symlink(target, link)
+}
+
+func (fs *FaultFS) Readlink(link string) (string, error) {
+	if err := fs.s.fault(OpReadlink, link); err != nil {
+		return "", err
+	}
+
+	return fs.Filesystem.Readlink(link)
+}
+
+// Chroot returns the given directory of the underlying filesystem, sharing
+// the rules with this filesystem. The paths of its calls are relative to it.
+func (fs *FaultFS) Chroot(path string) (billy.Filesystem, error) {
+	if err := fs.s.fault(OpChroot, path); err != nil {
+		return nil, err
+	}
+
+	chroot, err := fs.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FaultFS{Filesystem: chroot, s: fs.s}, nil
+}
+
+// Capabilities implements the Capable interface.
+func (fs *FaultFS) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.Filesystem)
+}
+
+// file is a file failing the calls matching the rules of its filesystem.
+type file struct {
+	billy.File
+	s *state
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if err := f.s.fault(OpRead, f.Name()); err != nil {
+		return 0, err
+	}
+
+	return f.File.Read(p)
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if err := f.s.fault(OpRead, f.Name()); err != nil {
+		return 0, err
+	}
+
+	return f.File.ReadAt(p, off)
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	if err := f.s.fault(OpWrite, f.Name()); err != nil {
+		return 0, err
+	}
+
+	return f.File.Write(p)
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if err := f.s.fault(OpSeek, f.Name()); err != nil {
+		return 0, err
+	}
+
+	return f.File.Seek(offset, whence)
+}
+
+func (f *file) Truncate(size int64) error {
+	if err := f.s.fault(OpTruncate, f.Name()); err != nil {
+		return err
+	}
+
+	return f.File.Truncate(size)
+}
+
+// Close closes the underlying file even if failed, so no file is leaked.
+func (f *file) Close() error {
+	err := f.s.fault(OpClose, f.Name())
+	if cerr := f.File.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+func (f *file) Lock() error {
+	if err := f.s.fault(OpLock, f.Name()); err != nil {
+		return err
+	}
+
+	return f.File.Lock()
+}
+
+func (f *file) Unlock() error {
+	if err := f.s.fault(OpUnlock, f.Name()); err != nil {
+		return err
+	}
+
+	return f.File.Unlock()
+}
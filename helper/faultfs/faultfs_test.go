@@ -0,0 +1,102 @@
+package faultfs
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&FaultFSSuite{})
+
+type FaultFSSuite struct {
+	test.FilesystemSuite
+	fault *FaultFS
+}
+
+func (s *FaultFSSuite) SetUpTest(c *C) {
+	s.fault = New(memfs.New())
+	s.FilesystemSuite = test.NewFilesystemSuite(s.fault)
+}
+
+func (s *FaultFSSuite) TestNth(c *C) {
+	s.fault.Add(Rule{Op: OpWrite, Path: "*.pack", Nth: 3, Err: syscall.ENOSPC})
+
+	f, err := s.FS.Create("objects/foo.pack")
+	c.Assert(err, IsNil)
+	for i := 0; i < 2; i++ {
+		_, err = f.Write([]byte("foo"))
+		c.Assert(err, IsNil)
+	}
+
+	_, err = f.Write([]byte("foo"))
+	c.Assert(err.(*os.PathError).Err, Equals, syscall.ENOSPC)
+	c.Assert(err.(*os.PathError).Op, Equals, "write")
+
+	_, err = f.Write([]byte("foo"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	// the writes to other files aren't counted
+	c.Assert(util.WriteFile(s.FS, "foo.idx", []byte("foo"), 0644), IsNil)
+}
+
+func (s *FaultFSSuite) TestPath(c *C) {
+	s.fault.Add(Rule{Op: OpStat, Path: "refs/*", Err: syscall.EPERM})
+	c.Assert(util.WriteFile(s.FS, "refs/heads", []byte("foo"), 0644), IsNil)
+
+	_, err := s.FS.Stat("/refs/heads")
+	c.Assert(os.IsPermission(err), Equals, true)
+	_, err = s.FS.Stat("refs/heads")
+	c.Assert(os.IsPermission(err), Equals, true)
+
+	_, err = s.FS.Stat("refs")
+	c.Assert(err, IsNil)
+	_, err = s.FS.Lstat("refs/heads")
+	c.Assert(err, IsNil)
+}
+
+func (s *FaultFSSuite) TestPrecedence(c *C) {
+	errFirst, errLast := errors.New("first"), errors.New("last")
+	s.fault.Add(Rule{Err: errFirst})
+	s.fault.Add(Rule{Op: OpRemove, Nth: 2, Err: errLast})
+
+	err := s.FS.Remove("foo")
+	c.Assert(err.(*os.PathError).Err, Equals, errFirst)
+	err = s.FS.Remove("foo")
+	c.Assert(err.(*os.PathError).Err, Equals, errLast)
+
+	s.fault.Reset()
+	c.Assert(s.FS.MkdirAll("foo", 0755), IsNil)
+}
+
+func (s *FaultFSSuite) TestChroot(c *C) {
+	s.fault.Add(Rule{Op: OpOpen, Path: "foo", Err: syscall.EIO})
+
+	fs, err := s.FS.Chroot("dir")
+	c.Assert(err, IsNil)
+
+	_, err = fs.Create("foo")
+	c.Assert(err.(*os.PathError).Err, Equals, syscall.EIO)
+	_, err = fs.Create("bar")
+	c.Assert(err, IsNil)
+}
+
+func (s *FaultFSSuite) TestClose(c *C) {
+	s.fault.Add(Rule{Op: OpClose, Err: syscall.EIO})
+
+	f, err := s.FS.Create("foo")
+	c.Assert(err, IsNil)
+	c.Assert(f.Close().(*os.PathError).Err, Equals, syscall.EIO)
+
+	s.fault.Reset()
+	c.Assert(f.Close(), NotNil)
+}
@@ -0,0 +1,365 @@
+// Package filterfs provides a helper confining the access to a filesystem to
+// the paths matching some patterns, eg.: to hand plugins only the files they
+// need, where a chroot isn't enough.
+package filterfs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/internal/linkutil"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const separator = string(filepath.Separator)
+
+// Options holds the configuration of a FilterFS. The patterns are the ones of
+// filepath.Match, matched with the whole path relative to the root, without
+// the leading separator, eg.: "src/*.go". A pattern matching a directory
+// matches everything below it.
+type Options struct {
+	// Allow are the patterns of the paths allowed, every one if empty.
+	Allow []string
+	// Deny are the patterns of the paths denied, even if allowed.
+	Deny []string
+}
+
+// FilterFS is a helper passing the operations to the paths allowed through
+// to the underlying filesystem. The paths not allowed are hidden: reading
+// them fails as if missing, and writing them fails with os.ErrPermission.
+// The parents of the paths allowed, if not denied, can be listed, but only
+// show the entries allowed, or leading to them.
+//
+// The symlinks are followed to check their targets too, so they can't be
+// used to escape the filter.
+type FilterFS struct {
+	billy.Filesystem
+	opts Options
+	// dir is the directory the root was moved to by Chroot, as the patterns
+	// are matched with the paths from the original root.
+	dir string
+}
+
+// New creates a new filesystem confined to the paths of the given one allowed
+// by the options, failing if any pattern is malformed.
+func New(fs billy.Filesystem, opts Options) (*FilterFS, error) {
+	for _, pattern := range append(opts.Allow, opts.Deny...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	return &FilterFS{Filesystem: fs, opts: opts}, nil
+}
+
+// allowed returns whether the given path, relative to the root of the
+// underlying filesystem, can be read and written.
+func (fs *FilterFS) allowed(p string) bool {
+	if p == "" {
+		return len(fs.opts.Allow) == 0
+	}
+
+	return (len(fs.opts.Allow) == 0 || matchAny(fs.opts.Allow, p)) &&
+		!matchAny(fs.opts.Deny, p)
+}
+
+// visible returns whether the given path can be read, being allowed or a
+// parent of a path allowed.
+func (fs *FilterFS) visible(p string) bool {
+	if p == "" || fs.allowed(p) {
+		return true
+	}
+
+	if matchAny(fs.opts.Deny, p) {
+		return false
+	}
+
+	elems := strings.Split(p, separator)
+	for _, pattern := range fs.opts.Allow {
+		if isParent(elems, strings.Split(pattern, separator)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// deniedBelow returns whether any of the deny patterns may match a path below
+// the given one.
+func (fs *FilterFS) deniedBelow(p string) bool {
+	var elems []string
+	if p != "" {
+		elems = strings.Split(p, separator)
+	}
+
+	for _, pattern := range fs.opts.Deny {
+		if isParent(elems, strings.Split(pattern, separator)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchAny returns whether the given path, or any of its parents, matches any
+// of the given patterns.
+func matchAny(patterns []string, p string) bool {
+	for ; p != "." && p != separator && p != ""; p = filepath.Dir(p) {
+		for _, pattern := range patterns {
+			if ok, _ := filepath.Match(pattern, p); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// isParent returns whether the given path may be a parent of the paths
+// matching the given pattern, both split in elements.
+func isParent(elems, pattern []string) bool {
+	if len(elems) >= len(pattern) {
+		return false
+	}
+
+	for i, e := range elems {
+		if ok, _ := filepath.Match(pattern[i], e); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// check checks whether the given path, and the one it resolves to, can be
+// read or written, failing as if missing, when reading, or with
+// os.ErrPermission, when writing. The last element isn't followed if nofollow.
+func (fs *FilterFS) check(op, p string, write, nofollow bool) error {
+	resolved, err := fs.resolve(p, nofollow)
+	if err != nil {
+		return err
+	}
+
+	for _, rel := range []string{fs.rel(p), fs.rel(resolved)} {
+		if write && !fs.allowed(rel) {
+			return &os.PathError{Op: op, Path: p, Err: os.ErrPermission}
+		}
+
+		if !write && !fs.visible(rel) {
+			return &os.PathError{Op: op, Path: p, Err: os.ErrNotExist}
+		}
+	}
+
+	return nil
+}
+
+// rel returns the given path, of the filesystem chrooted to, relative to the
+// root of the underlying filesystem as matched with the patterns.
+func (fs *FilterFS) rel(p string) string {
+	return strings.TrimLeft(filepath.Join(separator, fs.dir, p), separator)
+}
+
+// resolve returns the given path following every symlink in it, but the last
+// element if nofollow. The missing elements are kept as given.
+func (fs *FilterFS) resolve(p string, nofollow bool) (string, error) {
+	resolved := separator
+	rest := split(p)
+	for links := 0; len(rest) > 0; {
+		name := rest[0]
+		rest = rest[1:]
+
+		next := filepath.Join(resolved, name)
+		fi, err := fs.Filesystem.Lstat(next)
+		if err != nil {
+			return filepath.Join(append([]string{next}, rest...)...), nil
+		}
+
+		if fi.Mode()&os.ModeSymlink == 0 || (nofollow && len(rest) == 0) {
+			resolved = next
+			continue
+		}
+
+		if links++; links > linkutil.MaxLinks {
+			return "", &os.PathError{Op: "stat", Path: p, Err: linkutil.ErrTooManyLinks}
+		}
+
+		target, err := fs.Filesystem.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(resolved, target)
+		}
+
+		rest = append(split(target), rest...)
+		resolved = separator
+	}
+
+	return resolved, nil
+}
+
+func split(p string) []string {
+	p = strings.TrimLeft(filepath.Join(separator, p), separator)
+	if p == "" {
+		return nil
+	}
+
+	return strings.Split(p, separator)
+}
+
+func (fs *FilterFS) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *FilterFS) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file, if allowed. Only the files allowed can be
+// read, not their parents.
+func (fs *FilterFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	err := fs.check("open", filename, isWrite(flag), false)
+	if err == nil && !isWrite(flag) {
+		// the parents shown aren't allowed to be read
+		err = fs.check("open", filename, true, false)
+		if err != nil {
+			err = &os.PathError{Op: "open", Path: filename, Err: os.ErrNotExist}
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.Filesystem.OpenFile(filename, flag, perm)
+}
+
+func (fs *FilterFS) Stat(filename string) (os.FileInfo, error) {
+	if err := fs.check("stat", filename, false, false); err != nil {
+		return nil, err
+	}
+
+	return fs.Filesystem.Stat(filename)
+}
+
+func (fs *FilterFS) Lstat(filename string) (os.FileInfo, error) {
+	if err := fs.check("lstat", filename, false, true); err != nil {
+		return nil, err
+	}
+
+	return fs.Filesystem.Lstat(filename)
+}
+
+// ReadDir returns the entries of the given directory that are visible.
+func (fs *FilterFS) ReadDir(path string) ([]os.FileInfo, error) {
+	if err := fs.check("readdir", path, false, false); err != nil {
+		return nil, err
+	}
+
+	infos, err := fs.Filesystem.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]os.FileInfo, 0, len(infos))
+	for _, fi := range infos {
+		if fs.visible(fs.rel(filepath.Join(path, fi.Name()))) {
+			result = append(result, fi)
+		}
+	}
+
+	return result, nil
+}
+
+func (fs *FilterFS) Readlink(link string) (string, error) {
+	if err := fs.check("readlink", link, false, true); err != nil {
+		return "", err
+	}
+
+	return fs.Filesystem.Readlink(link)
+}
+
+func (fs *FilterFS) Rename(from, to string) error {
+	if err := fs.check("rename", from, true, true); err != nil {
+		return err
+	}
+
+	if err := fs.check("rename", to, true, true); err != nil {
+		return err
+	}
+
+	// moving a directory moves its contents too: the paths denied below from
+	// would be exposed at to, and the ones allowed hidden below to.
+	if fi, err := fs.Filesystem.Lstat(from); err == nil && fi.IsDir() {
+		for _, p := range []string{from, to} {
+			resolved, err := fs.resolve(p, true)
+			if err != nil {
+				return err
+			}
+
+			if fs.deniedBelow(fs.rel(p)) || fs.deniedBelow(fs.rel(resolved)) {
+				return &os.LinkError{
+					Op: "rename", Old: from, New: to, Err: os.ErrPermission,
+				}
+			}
+		}
+	}
+
+	return fs.Filesystem.Rename(from, to)
+}
+
+func (fs *FilterFS) Remove(filename string) error {
+	if err := fs.check("remove", filename, true, true); err != nil {
+		return err
+	}
+
+	return fs.Filesystem.Remove(filename)
+}
+
+func (fs *FilterFS) MkdirAll(filename string, perm os.FileMode) error {
+	if err := fs.check("mkdir", filename, true, false); err != nil {
+		return err
+	}
+
+	return fs.Filesystem.MkdirAll(filename, perm)
+}
+
+func (fs *FilterFS) Symlink(target, link string) error {
+	if err := fs.check("symlink", link, true, true); err != nil {
+		return err
+	}
+
+	return fs.Filesystem.Symlink(target, link)
+}
+
+// TempFile creates a temporary file, if its name is allowed.
+func (fs *FilterFS) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(fs, dir, prefix)
+}
+
+// Chroot returns the given directory of the underlying filesystem, confined to
+// the same paths.
+func (fs *FilterFS) Chroot(path string) (billy.Filesystem, error) {
+	if err := fs.check("chroot", path, false, false); err != nil {
+		return nil, err
+	}
+
+	chroot, err := fs.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FilterFS{Filesystem: chroot, opts: fs.opts, dir: filepath.Join(fs.dir, path)}, nil
+}
+
+// Capabilities implements the Capable interface.
+func (fs *FilterFS) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.Filesystem)
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
+}
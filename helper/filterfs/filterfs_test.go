@@ -0,0 +1,139 @@
+package filterfs
+
+import (
+	"os"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+	"sort"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&FilterFSSuite{})
+
+type FilterFSSuite struct {
+	test.FilesystemSuite
+	underlying billy.Filesystem
+}
+
+func (s *FilterFSSuite) SetUpTest(c *C) {
+	s.underlying = memfs.New()
+	fs, err := New(s.underlying, Options{Deny: []string{"denied"}})
+	c.Assert(err, IsNil)
+
+	s.FilesystemSuite = test.NewFilesystemSuite(fs)
+}
+
+func (s *FilterFSSuite) newFilter(c *C, opts Options) *FilterFS {
+	for _, name := range []string{"src/foo.go", "src/foo.c", "src/vendor/bar.go", "secret", "qux.go"} {
+		c.Assert(util.WriteFile(s.underlying, name, []byte(name), 0644), IsNil)
+	}
+
+	fs, err := New(s.underlying, opts)
+	c.Assert(err, IsNil)
+	return fs
+}
+
+func (s *FilterFSSuite) TestHidden(c *C) {
+	fs := s.newFilter(c, Options{
+		Allow: []string{"src/*.go", "src/vendor"},
+		Deny:  []string{"src/vendor/*.go"},
+	})
+
+	c.Assert(test.ReadFile(c, fs, "src/foo.go"), Equals, "src/foo.go")
+
+	for _, name := range []string{"src/foo.c", "src/vendor/bar.go", "secret", "qux.go"} {
+		_, err := fs.Open(name)
+		c.Assert(os.IsNotExist(err), Equals, true, Commentf("%s", name))
+		_, err = fs.Stat(name)
+		c.Assert(os.IsNotExist(err), Equals, true, Commentf("%s", name))
+	}
+
+	infos, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 1)
+	c.Assert(infos[0].Name(), Equals, "src")
+
+	infos, err = fs.ReadDir("src")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 2)
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	c.Assert(infos[0].Name(), Equals, "foo.go")
+	c.Assert(infos[1].Name(), Equals, "vendor")
+
+	// the parents can be listed, but not read
+	_, err = fs.Open("src")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *FilterFSSuite) TestWrite(c *C) {
+	fs := s.newFilter(c, Options{Allow: []string{"src/*.go"}})
+
+	c.Assert(util.WriteFile(fs, "src/new.go", []byte("new"), 0644), IsNil)
+
+	err := util.WriteFile(fs, "src/new.c", []byte("new"), 0644)
+	c.Assert(os.IsPermission(err), Equals, true)
+	c.Assert(os.IsPermission(fs.Remove("secret")), Equals, true)
+	c.Assert(os.IsPermission(fs.Rename("src/foo.go", "foo.go")), Equals, true)
+	c.Assert(os.IsPermission(fs.MkdirAll("dir", 0755)), Equals, true)
+
+	_, err = s.underlying.Stat("src/new.c")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *FilterFSSuite) TestRenameDirDenied(c *C) {
+	fs := s.newFilter(c, Options{Deny: []string{"src/vendor", "lib/*.go"}})
+
+	err := fs.Rename("src", "pkg")
+	c.Assert(os.IsPermission(err), Equals, true)
+
+	_, err = fs.Open("pkg/vendor/bar.go")
+	c.Assert(os.IsNotExist(err), Equals, true)
+	_, err = s.underlying.Stat("src/vendor/bar.go")
+	c.Assert(err, IsNil)
+
+	c.Assert(fs.MkdirAll("dir/sub", 0755), IsNil)
+	c.Assert(util.WriteFile(fs, "dir/sub/foo.go", []byte("foo"), 0644), IsNil)
+
+	err = fs.Rename("dir/sub", "lib")
+	c.Assert(os.IsPermission(err), Equals, true)
+	c.Assert(fs.Rename("dir/sub", "other"), IsNil)
+	c.Assert(fs.Rename("src/foo.go", "lib.go"), IsNil)
+}
+
+func (s *FilterFSSuite) TestSymlink(c *C) {
+	fs := s.newFilter(c, Options{Allow: []string{"src"}})
+
+	c.Assert(fs.Symlink("../secret", "src/link"), IsNil)
+	_, err := fs.Open("src/link")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	target, err := fs.Readlink("src/link")
+	c.Assert(err, IsNil)
+	c.Assert(target, Equals, "../secret")
+
+	c.Assert(s.underlying.Symlink("/", "src/root"), IsNil)
+	_, err = fs.Open("src/root/secret")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *FilterFSSuite) TestChroot(c *C) {
+	fs := s.newFilter(c, Options{Allow: []string{"src/*.go"}})
+
+	chroot, err := fs.Chroot("src")
+	c.Assert(err, IsNil)
+	c.Assert(test.ReadFile(c, chroot, "foo.go"), Equals, "src/foo.go")
+	_, err = chroot.Open("foo.c")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *FilterFSSuite) TestBadPattern(c *C) {
+	_, err := New(s.underlying, Options{Allow: []string{"["}})
+	c.Assert(err, NotNil)
+}
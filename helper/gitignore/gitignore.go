@@ -0,0 +1,270 @@
+// Package gitignore provides a helper hiding the files ignored by the
+// .gitignore files of a filesystem, eg.: to walk a worktree as git does.
+package gitignore
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+)
+
+// DefaultFileName is the name of the pattern files read if none is given.
+const DefaultFileName = ".gitignore"
+
+// Options holds the configuration of a GitIgnore.
+type Options struct {
+	// Patterns are patterns applied before the ones of the pattern files,
+	// relative to the root, eg.: the ones of .git/info/exclude.
+	Patterns []string
+	// FileName is the name of the pattern files, DefaultFileName if empty.
+	FileName string
+}
+
+// GitIgnore is a helper passing every operation through to the underlying
+// filesystem, hiding the entries ignored from ReadDir, and so from anything
+// walking the filesystem.
+//
+// The patterns are the ones of gitignore: every directory may hold a pattern
+// file, whose patterns are relative to it, and override the ones of its
+// parents. Blank lines and the ones starting with "#" are skipped, a leading
+// "!" negates the pattern, a trailing "/" matches only directories, the
+// patterns with a "/" are matched relative to the directory of the file, and
+// the others with the names at any depth, "**" matches any number of
+// directories, and a file can't be included again if a parent is ignored.
+//
+// The pattern files are read every time needed, so their changes take effect
+// right away.
+type GitIgnore struct {
+	billy.Filesystem
+	opts Options
+}
+
+// New creates a new filesystem hiding the files ignored of the given one.
+func New(fs billy.Filesystem, opts Options) *GitIgnore {
+	if opts.FileName == "" {
+		opts.FileName = DefaultFileName
+	}
+
+	return &GitIgnore{Filesystem: fs, opts: opts}
+}
+
+// pattern is a parsed pattern, of a pattern file in dir.
+type pattern struct {
+	dir      []string
+	elems    []string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// parse parses a line of a pattern file, returning false if it holds no pattern.
+func parse(line string, dir []string) (*pattern, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	if line == "" || line[0] == '#' {
+		return nil, false
+	}
+
+	// the trailing spaces are ignored, unless escaped
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+
+	p := &pattern{dir: dir}
+	switch {
+	case line[0] == '!':
+		p.negate = true
+		line = line[1:]
+	case strings.HasPrefix(line, "\\!"), strings.HasPrefix(line, "\\#"):
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+
+	if line == "" {
+		return nil, false
+	}
+
+	p.anchored = strings.Contains(line, "/")
+	p.elems = strings.Split(strings.TrimPrefix(line, "/"), "/")
+	return p, true
+}
+
+// match returns whether the pattern matches the given path, split in
+// elements.
+func (p *pattern) match(elems []string, isDir bool) bool {
+	if p.dirOnly && !isDir || len(elems) <= len(p.dir) {
+		return false
+	}
+
+	for i, e := range p.dir {
+		if elems[i] != e {
+			return false
+		}
+	}
+
+	rel := elems[len(p.dir):]
+	if !p.anchored {
+		ok, _ := path.Match(p.elems[0], rel[len(rel)-1])
+		return ok
+	}
+
+	return matchElems(p.elems, rel)
+}
+
+func matchElems(pattern, elems []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			if len(pattern) == 1 {
+				return len(elems) > 0
+			}
+
+			for i := 0; i <= len(elems); i++ {
+				if matchElems(pattern[1:], elems[i:]) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		if len(elems) == 0 {
+			return false
+		}
+
+		if ok, _ := path.Match(pattern[0], elems[0]); !ok {
+			return false
+		}
+
+		pattern, elems = pattern[1:], elems[1:]
+	}
+
+	return len(elems) == 0
+}
+
+// ignored returns whether the given path is ignored by the given patterns,
+// the last one matching taking precedence.
+func ignored(patterns []*pattern, elems []string, isDir bool) bool {
+	for i := len(patterns) - 1; i >= 0; i-- {
+		if patterns[i].match(elems, isDir) {
+			return !patterns[i].negate
+		}
+	}
+
+	return false
+}
+
+// patterns returns the patterns applying to the entries of the given
+// directory, split in elements, appending the ones of its pattern file to the
+// given ones, of its parent.
+func (fs *GitIgnore) patterns(parent []*pattern, dir []string) ([]*pattern, error) {
+	f, err := fs.Filesystem.Open(filepath.Join(append(dir[:len(dir):len(dir)], fs.opts.FileName)...))
+	if os.IsNotExist(err) {
+		return parent, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	patterns := append([]*pattern(nil), parent...)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if p, ok := parse(s.Text(), dir); ok {
+			patterns = append(patterns, p)
+		}
+	}
+
+	return patterns, s.Err()
+}
+
+// global returns the patterns given by the options.
+func (fs *GitIgnore) global() []*pattern {
+	var patterns []*pattern
+	for _, line := range fs.opts.Patterns {
+		if p, ok := parse(line, nil); ok {
+			patterns = append(patterns, p)
+		}
+	}
+
+	return patterns
+}
+
+// Ignored returns whether the given path is ignored, or any of its parents.
+// The path is a directory if isDir, since some patterns only match
+// directories.
+func (fs *GitIgnore) Ignored(filename string, isDir bool) (bool, error) {
+	elems := split(filename)
+	patterns := fs.global()
+	for i := range elems {
+		var err error
+		if patterns, err = fs.patterns(patterns, elems[:i]); err != nil {
+			return false, err
+		}
+
+		if ignored(patterns, elems[:i+1], isDir || i < len(elems)-1) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// ReadDir returns the entries of the given directory not ignored.
+func (fs *GitIgnore) ReadDir(path string) ([]os.FileInfo, error) {
+	infos, err := fs.Filesystem.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := split(path)
+	patterns := fs.global()
+	for i := range dir {
+		if patterns, err = fs.patterns(patterns, dir[:i]); err != nil {
+			return nil, err
+		}
+	}
+
+	if patterns, err = fs.patterns(patterns, dir); err != nil {
+		return nil, err
+	}
+
+	result := make([]os.FileInfo, 0, len(infos))
+	for _, fi := range infos {
+		elems := append(dir[:len(dir):len(dir)], fi.Name())
+		if !ignored(patterns, elems, fi.IsDir()) {
+			result = append(result, fi)
+		}
+	}
+
+	return result, nil
+}
+
+// Chroot returns the given directory, applying the pattern files of its
+// parents too.
+func (fs *GitIgnore) Chroot(path string) (billy.Filesystem, error) {
+	return chroot.New(fs, path), nil
+}
+
+// Capabilities implements the Capable interface.
+func (fs *GitIgnore) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.Filesystem)
+}
+
+func split(p string) []string {
+	p = strings.Trim(filepath.ToSlash(filepath.Join("/", p)), "/")
+	if p == "" {
+		return nil
+	}
+
+	return strings.Split(p, "/")
+}
@@ -0,0 +1,125 @@
+package gitignore
+
+import (
+	"sort"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&GitIgnoreSuite{})
+
+type GitIgnoreSuite struct {
+	test.FilesystemSuite
+	underlying billy.Filesystem
+	fs         *GitIgnore
+}
+
+func (s *GitIgnoreSuite) SetUpTest(c *C) {
+	s.underlying = memfs.New()
+	s.fs = New(s.underlying, Options{})
+	s.FilesystemSuite = test.NewFilesystemSuite(s.fs)
+}
+
+func (s *GitIgnoreSuite) write(c *C, files map[string]string) {
+	for name, content := range files {
+		c.Assert(util.WriteFile(s.underlying, name, []byte(content), 0644), IsNil)
+	}
+}
+
+func (s *GitIgnoreSuite) names(c *C, fs billy.Filesystem, dir string) []string {
+	infos, err := fs.ReadDir(dir)
+	c.Assert(err, IsNil)
+
+	var names []string
+	for _, fi := range infos {
+		names = append(names, fi.Name())
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+func (s *GitIgnoreSuite) TestReadDir(c *C) {
+	s.write(c, map[string]string{
+		".gitignore":           "# comment\n*.log\n!keep.log\nbuild/\n/root.txt\ndocs/**/*.tmp\n",
+		"a.log":                "",
+		"keep.log":             "",
+		"root.txt":             "",
+		"build/out":            "",
+		"src/build":            "",
+		"src/root.txt":         "",
+		"src/b.log":            "",
+		"src/.gitignore":       "!b.log\n*.go\n",
+		"src/main.go":          "",
+		"docs/a/b/c.tmp":       "",
+		"docs/c.tmp":           "",
+		"docs/a/b/readme":      "",
+		"other/build/artifact": "",
+	})
+
+	c.Assert(s.names(c, s.fs, "/"), DeepEquals, []string{
+		".gitignore", "docs", "keep.log", "other", "src",
+	})
+
+	c.Assert(s.names(c, s.fs, "src"), DeepEquals, []string{
+		".gitignore", "b.log", "build", "root.txt",
+	})
+
+	c.Assert(s.names(c, s.fs, "docs"), DeepEquals, []string{"a"})
+	c.Assert(s.names(c, s.fs, "docs/a/b"), DeepEquals, []string{"readme"})
+	c.Assert(s.names(c, s.fs, "other"), HasLen, 0)
+
+	// the files ignored can still be read
+	_, err := s.fs.Stat("a.log")
+	c.Assert(err, IsNil)
+}
+
+func (s *GitIgnoreSuite) TestIgnored(c *C) {
+	s.write(c, map[string]string{
+		".gitignore": "vendor\n!vendor/keep\n",
+	})
+
+	for path, expected := range map[string]bool{
+		"vendor":      true,
+		"vendor/keep": true,
+		"src/vendor":  true,
+		"src/main.go": false,
+		"/":           false,
+	} {
+		ignored, err := s.fs.Ignored(path, false)
+		c.Assert(err, IsNil)
+		c.Assert(ignored, Equals, expected, Commentf("%s", path))
+	}
+}
+
+func (s *GitIgnoreSuite) TestPatterns(c *C) {
+	s.write(c, map[string]string{
+		"foo":     "",
+		"bar":     "",
+		"dir/foo": "",
+	})
+
+	fs := New(s.underlying, Options{Patterns: []string{"/foo"}})
+	c.Assert(s.names(c, fs, "/"), DeepEquals, []string{"bar", "dir"})
+	c.Assert(s.names(c, fs, "dir"), DeepEquals, []string{"foo"})
+}
+
+func (s *GitIgnoreSuite) TestChroot(c *C) {
+	s.write(c, map[string]string{
+		".gitignore":  "*.log\n",
+		"dir/foo.log": "",
+		"dir/foo":     "",
+	})
+
+	chroot, err := s.fs.Chroot("dir")
+	c.Assert(err, IsNil)
+	c.Assert(s.names(c, chroot, "/"), DeepEquals, []string{"foo"})
+}
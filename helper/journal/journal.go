@@ -0,0 +1,468 @@
+// Package journal provides a helper recording the intent of the operations
+// made in several steps, to complete or undo them after a crash, eg.: for the
+// filesystems emulating Rename by copying and removing.
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+// DefaultJournal is the name of the journal used if none is given.
+const DefaultJournal = ".journal"
+
+const separator = string(filepath.Separator)
+
+// The operations and their steps recorded.
+const (
+	opRename    = "rename"
+	opRemoveAll = "removeall"
+
+	stepBegin  = "begin"
+	stepCopied = "copied"
+	stepDone   = "done"
+)
+
+// Options holds the configuration of a Journal.
+type Options struct {
+	// Journal is the name of the file, in the root of the underlying
+	// filesystem, where the operations are recorded, DefaultJournal if
+	// empty.
+	Journal string
+	// CopyRename emulates every Rename by copying and removing. If false,
+	// Rename is emulated only if the underlying one fails with
+	// billy.ErrNotSupported.
+	CopyRename bool
+}
+
+// Journal is a helper making RemoveAll, and Rename when emulated, in several
+// steps recorded in a journal, so they can be completed or undone if
+// interrupted. Every other operation is passed through to the underlying
+// filesystem.
+//
+// A rename interrupted while copying is undone, removing the copy, and one
+// interrupted while removing the source is completed, as is a RemoveAll.
+// The file replaced by a rename isn't restored when undone. The operations
+// interrupted by a crash are recovered by New, and the ones failing right
+// away, or by the next operation recorded if that fails too. The operations
+// recorded are made one at a time, and the journal, which is hidden, is
+// removed once they are done.
+type Journal struct {
+	billy.Filesystem
+	s *state
+	// dir is the directory the root was moved to by Chroot, as the journal
+	// records the paths from the root of the underlying filesystem.
+	dir string
+}
+
+// state is the state shared by a Journal and its chroots.
+type state struct {
+	fs   billy.Filesystem
+	name string
+	copy bool
+
+	m sync.Mutex
+	// pending is true if the journal may hold an operation not done.
+	pending bool
+}
+
+// record is a line of the journal, a step of an operation.
+type record struct {
+	Op   string `json:"op"`
+	Step string `json:"step"`
+	Path string `json:"path"`
+	To   string `json:"to,omitempty"`
+}
+
+// New creates a new filesystem recording the operations made in several
+// steps to the given one, completing or undoing the ones interrupted.
+func New(fs billy.Filesystem, opts Options) (*Journal, error) {
+	if opts.Journal == "" {
+		opts.Journal = DefaultJournal
+	}
+
+	s := &state{fs: fs, name: opts.Journal, copy: opts.CopyRename, pending: true}
+	if _, err := s.recover(); err != nil {
+		return nil, err
+	}
+
+	return &Journal{Filesystem: fs, s: s}, nil
+}
+
+// recover completes or undoes the last operation of the journal, if not done,
+// removing the journal. It returns if the operation was completed. It must be
+// called with the lock held.
+func (s *state) recover() (bool, error) {
+	if !s.pending {
+		return false, nil
+	}
+
+	last, err := s.last()
+	if err != nil {
+		return false, err
+	}
+
+	var completed bool
+	if last != nil {
+		if completed, err = s.resume(last); err != nil {
+			return false, err
+		}
+	}
+
+	if err := s.fs.Remove(s.name); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	s.pending = false
+	return completed, nil
+}
+
+// last returns the last step recorded, nil if none. A last line not complete
+// is ignored, since the step it records wasn't started.
+func (s *state) last() (*record, error) {
+	f, err := s.fs.Open(s.name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	var last *record
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return last, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		var rec record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return nil, err
+		}
+
+		last = &rec
+	}
+}
+
+// resume completes or undoes the operation of the given step, returning if
+// completed.
+func (s *state) resume(r *record) (bool, error) {
+	switch {
+	case r.Step == stepDone:
+		return false, nil
+	case r.Op == opRename && r.Step == stepBegin:
+		return false, util.RemoveAll(s.fs, r.To)
+	case r.Op == opRename && r.Step == stepCopied:
+		return true, util.RemoveAll(s.fs, r.Path)
+	case r.Op == opRemoveAll:
+		return true, util.RemoveAll(s.fs, r.Path)
+	}
+
+	return false, nil
+}
+
+// write records the given step, replacing the journal if it's the first one.
+func (s *state) write(r record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	flag := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if r.Step == stepBegin {
+		flag |= os.O_TRUNC
+	}
+
+	f, err := s.fs.OpenFile(s.name, flag, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+
+	if sf, ok := f.(interface{ Sync() error }); ok {
+		if err := sf.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	return f.Close()
+}
+
+// run makes the given operation, recording its steps: fn is called with a
+// function recording the next one. If fn fails, the operation is completed or
+// undone right away, and if that fails too, by the next call to recover.
+func (s *state) run(r record, fn func(step func(string) error) error) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if _, err := s.recover(); err != nil {
+		return err
+	}
+
+	r.Step = stepBegin
+	if err := s.write(r); err != nil {
+		return err
+	}
+
+	s.pending = true
+	err := fn(func(step string) error {
+		r.Step = step
+		return s.write(r)
+	})
+
+	if err != nil {
+		if completed, rerr := s.recover(); rerr == nil && completed {
+			return nil
+		}
+
+		return err
+	}
+
+	r.Step = stepDone
+	if err := s.write(r); err != nil {
+		return err
+	}
+
+	s.pending = false
+	s.fs.Remove(s.name)
+	return nil
+}
+
+// path returns the given path as recorded, relative to the root of the
+// underlying filesystem.
+func (fs *Journal) path(p string) string {
+	return filepath.Join(separator, fs.dir, p)
+}
+
+func (fs *Journal) isJournal(p string) bool {
+	return fs.path(p) == filepath.Join(separator, fs.s.name)
+}
+
+func (fs *Journal) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Journal) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (fs *Journal) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if fs.isJournal(filename) {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrPermission}
+	}
+
+	return fs.Filesystem.OpenFile(filename, flag, perm)
+}
+
+// ReadDir reads the given directory, hiding the journal.
+func (fs *Journal) ReadDir(path string) ([]os.FileInfo, error) {
+	infos, err := fs.Filesystem.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var visible []os.FileInfo
+	for _, fi := range infos {
+		if fs.isJournal(filepath.Join(path, fi.Name())) {
+			continue
+		}
+
+		visible = append(visible, fi)
+	}
+
+	return visible, nil
+}
+
+// Rename renames the given file, copying and removing it if emulated.
+func (fs *Journal) Rename(from, to string) error {
+	if fs.isJournal(from) || fs.isJournal(to) {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: os.ErrPermission}
+	}
+
+	if !fs.s.copy {
+		err := fs.Filesystem.Rename(from, to)
+		if !isNotSupported(err) {
+			return err
+		}
+	}
+
+	return fs.copyRename(from, to)
+}
+
+// copyRename renames the given file by copying it, and then removing it.
+func (fs *Journal) copyRename(from, to string) error {
+	// the paths are checked first by the filesystem chrooted to, if any
+	if _, err := fs.Filesystem.Lstat(to); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if _, err := fs.Filesystem.Lstat(from); os.IsNotExist(err) {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: os.ErrNotExist}
+	} else if err != nil {
+		return err
+	}
+
+	src, dst := fs.path(from), fs.path(to)
+
+	if src == dst {
+		return nil
+	}
+
+	if isBelow(dst, src) {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: os.ErrInvalid}
+	}
+
+	if fi, err := fs.s.fs.Lstat(dst); err == nil && fi.IsDir() {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: os.ErrExist}
+	}
+
+	r := record{Op: opRename, Path: src, To: dst}
+	return fs.s.run(r, func(step func(string) error) error {
+		if err := fs.s.fs.Remove(dst); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		if err := copyAll(fs.s.fs, src, dst); err != nil {
+			return err
+		}
+
+		if err := step(stepCopied); err != nil {
+			return err
+		}
+
+		return util.RemoveAll(fs.s.fs, src)
+	})
+}
+
+func (fs *Journal) Remove(filename string) error {
+	if fs.isJournal(filename) {
+		return &os.PathError{Op: "remove", Path: filename, Err: os.ErrPermission}
+	}
+
+	return fs.Filesystem.Remove(filename)
+}
+
+// RemoveAll removes the given path and any children it contains, completing
+// it if interrupted. It's used by util.RemoveAll.
+func (fs *Journal) RemoveAll(path string) error {
+	if fs.isJournal(path) {
+		return &os.PathError{Op: "removeall", Path: path, Err: os.ErrPermission}
+	}
+
+	p := fs.path(path)
+	return fs.s.run(record{Op: opRemoveAll, Path: p}, func(func(string) error) error {
+		return util.RemoveAll(fs.s.fs, p)
+	})
+}
+
+// Chroot returns the given directory of the underlying filesystem, recording
+// its operations in the same journal.
+func (fs *Journal) Chroot(path string) (billy.Filesystem, error) {
+	chroot, err := fs.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Journal{Filesystem: chroot, s: fs.s, dir: fs.path(path)}, nil
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Journal) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.Filesystem)
+}
+
+// copyAll copies the given file, directory or link, recursively.
+func copyAll(fs billy.Filesystem, from, to string) error {
+	fi, err := fs.Lstat(from)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		target, err := fs.Readlink(from)
+		if err != nil {
+			return err
+		}
+
+		return fs.Symlink(target, to)
+	case fi.IsDir():
+		if err := fs.MkdirAll(to, fi.Mode().Perm()); err != nil {
+			return err
+		}
+
+		infos, err := fs.ReadDir(from)
+		if err != nil {
+			return err
+		}
+
+		for _, fi := range infos {
+			name := fi.Name()
+			if err := copyAll(fs, filepath.Join(from, name), filepath.Join(to, name)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	default:
+		return copyFile(fs, from, to, fi.Mode().Perm())
+	}
+}
+
+func copyFile(fs billy.Filesystem, from, to string, perm os.FileMode) error {
+	src, err := fs.Open(from)
+	if err != nil {
+		return err
+	}
+
+	defer src.Close()
+
+	dst, err := fs.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+
+	return dst.Close()
+}
+
+func isNotSupported(err error) bool {
+	switch e := err.(type) {
+	case *os.LinkError:
+		err = e.Err
+	case *os.PathError:
+		err = e.Err
+	}
+
+	return err == billy.ErrNotSupported
+}
+
+// isBelow returns if p is, or is below, dir.
+func isBelow(p, dir string) bool {
+	return p == dir || dir == separator || strings.HasPrefix(p, dir+separator)
+}
@@ -0,0 +1,195 @@
+package journal
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/faultfs"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&JournalSuite{})
+
+type JournalSuite struct {
+	test.FilesystemSuite
+	underlying billy.Filesystem
+}
+
+func (s *JournalSuite) SetUpTest(c *C) {
+	s.underlying = memfs.New()
+
+	fs, err := New(memfs.New(), Options{CopyRename: true})
+	c.Assert(err, IsNil)
+	s.FilesystemSuite = test.NewFilesystemSuite(fs)
+}
+
+// noRename is a filesystem without Rename.
+type noRename struct {
+	billy.Filesystem
+}
+
+func (fs *noRename) Rename(from, to string) error {
+	return &os.LinkError{Op: "rename", Old: from, New: to, Err: billy.ErrNotSupported}
+}
+
+func (s *JournalSuite) writeJournal(c *C, content string) {
+	c.Assert(util.WriteFile(s.underlying, DefaultJournal, []byte(content), 0600), IsNil)
+}
+
+func (s *JournalSuite) assertNoJournal(c *C) {
+	_, err := s.underlying.Stat(DefaultJournal)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *JournalSuite) TestCopyRename(c *C) {
+	fs, err := New(&noRename{s.underlying}, Options{})
+	c.Assert(err, IsNil)
+
+	c.Assert(util.WriteFile(fs, "qux/foo", []byte("foo"), 0644), IsNil)
+	c.Assert(fs.Symlink("foo", "qux/link"), IsNil)
+	c.Assert(fs.Rename("qux", "bar"), IsNil)
+
+	c.Assert(test.ReadFile(c, fs, "bar/foo"), Equals, "foo")
+	c.Assert(test.ReadFile(c, fs, "bar/link"), Equals, "foo")
+
+	_, err = fs.Stat("qux")
+	c.Assert(os.IsNotExist(err), Equals, true)
+	s.assertNoJournal(c)
+
+	err = fs.Rename("missing", "baz")
+	c.Assert(os.IsNotExist(err.(*os.LinkError).Err), Equals, true)
+}
+
+func (s *JournalSuite) TestRecoverCopying(c *C) {
+	c.Assert(util.WriteFile(s.underlying, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.underlying, "bar", []byte("f"), 0644), IsNil)
+	s.writeJournal(c, `{"op":"rename","step":"begin","path":"/foo","to":"/bar"}`+"\n")
+
+	fs, err := New(s.underlying, Options{})
+	c.Assert(err, IsNil)
+	c.Assert(test.ReadFile(c, fs, "foo"), Equals, "foo")
+
+	_, err = fs.Stat("bar")
+	c.Assert(os.IsNotExist(err), Equals, true)
+	s.assertNoJournal(c)
+}
+
+func (s *JournalSuite) TestRecoverCopied(c *C) {
+	c.Assert(util.WriteFile(s.underlying, "foo/qux", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.underlying, "bar/qux", []byte("foo"), 0644), IsNil)
+	s.writeJournal(c, `{"op":"rename","step":"begin","path":"/foo","to":"/bar"}`+"\n"+
+		`{"op":"rename","step":"copied","path":"/foo","to":"/bar"}`+"\n")
+
+	fs, err := New(s.underlying, Options{})
+	c.Assert(err, IsNil)
+	c.Assert(test.ReadFile(c, fs, "bar/qux"), Equals, "foo")
+
+	_, err = fs.Stat("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+	s.assertNoJournal(c)
+}
+
+func (s *JournalSuite) TestRecoverTorn(c *C) {
+	c.Assert(util.WriteFile(s.underlying, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.underlying, "bar", []byte("foo"), 0644), IsNil)
+	s.writeJournal(c, `{"op":"rename","step":"begin","path":"/foo","to":"/bar"}`+"\n"+
+		`{"op":"rename","step":"cop`)
+
+	// the step not recorded whole wasn't started, so the rename is undone
+	fs, err := New(s.underlying, Options{})
+	c.Assert(err, IsNil)
+	c.Assert(test.ReadFile(c, fs, "foo"), Equals, "foo")
+
+	_, err = fs.Stat("bar")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *JournalSuite) TestRecoverDone(c *C) {
+	c.Assert(util.WriteFile(s.underlying, "foo", []byte("foo"), 0644), IsNil)
+	s.writeJournal(c, `{"op":"removeall","step":"begin","path":"/foo"}`+"\n"+
+		`{"op":"removeall","step":"done","path":"/foo"}`+"\n")
+
+	fs, err := New(s.underlying, Options{})
+	c.Assert(err, IsNil)
+	c.Assert(test.ReadFile(c, fs, "foo"), Equals, "foo")
+	s.assertNoJournal(c)
+}
+
+func (s *JournalSuite) TestRecoverRemoveAll(c *C) {
+	c.Assert(util.WriteFile(s.underlying, "foo/bar/qux", []byte("foo"), 0644), IsNil)
+	s.writeJournal(c, `{"op":"removeall","step":"begin","path":"/foo"}`+"\n")
+
+	fs, err := New(s.underlying, Options{})
+	c.Assert(err, IsNil)
+
+	_, err = fs.Stat("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *JournalSuite) TestFailed(c *C) {
+	faults := faultfs.New(s.underlying)
+	fs, err := New(faults, Options{CopyRename: true})
+	c.Assert(err, IsNil)
+	c.Assert(util.WriteFile(fs, "foo", []byte("foo"), 0644), IsNil)
+
+	// the source failing to be removed once is removed when recovered
+	errUnavailable := errors.New("unavailable")
+	faults.Add(faultfs.Rule{Op: faultfs.OpRemove, Path: "/foo", Nth: 1, Err: errUnavailable})
+	c.Assert(fs.Rename("foo", "bar"), IsNil)
+
+	_, err = fs.Stat("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+	c.Assert(test.ReadFile(c, fs, "bar"), Equals, "foo")
+	s.assertNoJournal(c)
+
+	// the ones failing again are recovered by the next operation
+	faults.Add(faultfs.Rule{Op: faultfs.OpRemove, Path: "/bar", Err: errUnavailable})
+	c.Assert(fs.Rename("bar", "baz"), NotNil)
+	c.Assert(test.ReadFile(c, s.underlying, DefaultJournal), Matches, `(?s).*"copied".*`)
+
+	faults.Reset()
+	c.Assert(util.RemoveAll(fs, "missing"), IsNil)
+	_, err = fs.Stat("bar")
+	c.Assert(os.IsNotExist(err), Equals, true)
+	c.Assert(test.ReadFile(c, fs, "baz"), Equals, "foo")
+	s.assertNoJournal(c)
+}
+
+func (s *JournalSuite) TestHidden(c *C) {
+	s.writeJournal(c, "")
+	fs, err := New(s.underlying, Options{})
+	c.Assert(err, IsNil)
+
+	// the journal is only written while recording an operation
+	c.Assert(util.WriteFile(s.underlying, DefaultJournal, nil, 0600), IsNil)
+	infos, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 0)
+
+	_, err = fs.Open(DefaultJournal)
+	c.Assert(os.IsPermission(err), Equals, true)
+	c.Assert(os.IsPermission(fs.Remove(DefaultJournal)), Equals, true)
+	c.Assert(os.IsPermission(fs.Rename(DefaultJournal, "foo").(*os.LinkError).Err), Equals, true)
+}
+
+func (s *JournalSuite) TestChroot(c *C) {
+	fs, err := New(s.underlying, Options{CopyRename: true})
+	c.Assert(err, IsNil)
+
+	chroot, err := fs.Chroot("qux")
+	c.Assert(err, IsNil)
+	c.Assert(util.WriteFile(chroot, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(chroot.Rename("foo", "bar"), IsNil)
+	c.Assert(test.ReadFile(c, s.underlying, "qux/bar"), Equals, "foo")
+
+	// the journal is only at the root of the underlying filesystem
+	c.Assert(util.WriteFile(chroot, DefaultJournal, []byte("foo"), 0644), IsNil)
+}
@@ -0,0 +1,265 @@
+// Package latency provides a helper delaying the operations made to a
+// filesystem, to simulate a slow one, eg.: served over the network, without
+// the need of a real one.
+package latency
+
+import (
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// Distribution is a distribution of delays.
+type Distribution interface {
+	// Sample returns a delay, using the given source of randomness.
+	Sample(r *rand.Rand) time.Duration
+}
+
+// Fixed returns a Distribution always returning the given delay.
+func Fixed(d time.Duration) Distribution {
+	return fixed(d)
+}
+
+type fixed time.Duration
+
+func (d fixed) Sample(*rand.Rand) time.Duration {
+	return time.Duration(d)
+}
+
+// Uniform returns a Distribution returning delays uniformly distributed
+// between min, inclusive, and max, exclusive.
+func Uniform(min, max time.Duration) Distribution {
+	return &uniform{min: min, max: max}
+}
+
+type uniform struct {
+	min, max time.Duration
+}
+
+func (d *uniform) Sample(r *rand.Rand) time.Duration {
+	if d.max <= d.min {
+		return d.min
+	}
+
+	return d.min + time.Duration(r.Int63n(int64(d.max-d.min)))
+}
+
+// Normal returns a Distribution returning delays normally distributed, with
+// the given mean and standard deviation, never negative.
+func Normal(mean, stddev time.Duration) Distribution {
+	return &normal{mean: mean, stddev: stddev}
+}
+
+type normal struct {
+	mean, stddev time.Duration
+}
+
+func (d *normal) Sample(r *rand.Rand) time.Duration {
+	delay := d.mean + time.Duration(r.NormFloat64()*float64(d.stddev))
+	if delay < 0 {
+		return 0
+	}
+
+	return delay
+}
+
+// Options holds the configuration of a Latency.
+type Options struct {
+	// Default is the distribution of the delays of the operations without
+	// their own, no delay if nil.
+	Default Distribution
+	// Ops are the distributions of the delays of every operation, by its
+	// name: "open" (including Create), "stat", "lstat", "readdir",
+	// "rename", "remove", "mkdirall", "symlink", "readlink", "tempfile",
+	// and for the files "read" (including ReadAt), "write", "truncate" and
+	// "close".
+	Ops map[string]Distribution
+	// PerByte is the delay added per byte read or written, simulating the
+	// bandwidth.
+	PerByte time.Duration
+	// OutlierRate is the probability, from 0 to 1, of an operation being an
+	// outlier, delayed further by a delay of Outlier.
+	OutlierRate float64
+	// Outlier is the distribution of the delays added to the outliers.
+	Outlier Distribution
+	// Seed is the seed of the source of randomness, so the delays are
+	// reproducible.
+	Seed int64
+	// Sleep is the function used to wait the delays, time.Sleep if nil.
+	Sleep func(time.Duration)
+}
+
+// Latency is a helper delaying every operation made to the underlying
+// filesystem, and to the files open from it, by delays sampled from the
+// distributions given. The seek, lock and unlock of the files aren't
+// delayed.
+type Latency struct {
+	billy.Filesystem
+	s *state
+}
+
+// state is the state shared by a Latency, its files and its chroots.
+type state struct {
+	opts Options
+
+	m sync.Mutex
+	r *rand.Rand
+}
+
+// New creates a new filesystem delaying the operations made to the given one.
+func New(fs billy.Filesystem, opts Options) *Latency {
+	if opts.Sleep == nil {
+		opts.Sleep = time.Sleep
+	}
+
+	return &Latency{Filesystem: fs, s: &state{
+		opts: opts,
+		r:    rand.New(rand.NewSource(opts.Seed)),
+	}}
+}
+
+// delay waits the delay of the given operation, transferring n bytes.
+func (s *state) delay(op string, n int) {
+	d, ok := s.opts.Ops[op]
+	if !ok {
+		d = s.opts.Default
+	}
+
+	s.m.Lock()
+	var delay time.Duration
+	if d != nil {
+		delay = d.Sample(s.r)
+	}
+
+	if s.opts.OutlierRate > 0 && s.opts.Outlier != nil && s.r.Float64() < s.opts.OutlierRate {
+		delay += s.opts.Outlier.Sample(s.r)
+	}
+
+	s.m.Unlock()
+
+	delay += time.Duration(n) * s.opts.PerByte
+	if delay > 0 {
+		s.opts.Sleep(delay)
+	}
+}
+
+func (fs *Latency) Create(filename string) (billy.File, error) {
+	fs.s.delay("open", 0)
+	return fs.file(fs.Filesystem.Create(filename))
+}
+
+func (fs *Latency) Open(filename string) (billy.File, error) {
+	fs.s.delay("open", 0)
+	return fs.file(fs.Filesystem.Open(filename))
+}
+
+func (fs *Latency) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	fs.s.delay("open", 0)
+	return fs.file(fs.Filesystem.OpenFile(filename, flag, perm))
+}
+
+func (fs *Latency) TempFile(dir, prefix string) (billy.File, error) {
+	fs.s.delay("tempfile", 0)
+	return fs.file(fs.Filesystem.TempFile(dir, prefix))
+}
+
+func (fs *Latency) file(f billy.File, err error) (billy.File, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	return &file{File: f, s: fs.s}, nil
+}
+
+func (fs *Latency) Stat(filename string) (os.FileInfo, error) {
+	fs.s.delay("stat", 0)
+	return fs.Filesystem.Stat(filename)
+}
+
+func (fs *Latency) Lstat(filename string) (os.FileInfo, error) {
+	fs.s.delay("lstat", 0)
+	return fs.Filesystem.Lstat(filename)
+}
+
+func (fs *Latency) ReadDir(path string) ([]os.FileInfo, error) {
+	fs.s.delay("readdir", 0)
+	return fs.Filesystem.ReadDir(path)
+}
+
+func (fs *Latency) Rename(from, to string) error {
+	fs.s.delay("rename", 0)
+	return fs.Filesystem.Rename(from, to)
+}
+
+func (fs *Latency) Remove(filename string) error {
+	fs.s.delay("remove", 0)
+	return fs.Filesystem.Remove(filename)
+}
+
+func (fs *Latency) MkdirAll(filename string, perm os.FileMode) error {
+	fs.s.delay("mkdirall", 0)
+	return fs.Filesystem.MkdirAll(filename, perm)
+}
+
+func (fs *Latency) Symlink(target, link string) error {
+	fs.s.delay("symlink", 0)
+	return fs.Filesystem.Symlink(target, link)
+}
+
+func (fs *Latency) Readlink(link string) (string, error) {
+	fs.s.delay("readlink", 0)
+	return fs.Filesystem.Readlink(link)
+}
+
+// Chroot returns the given directory of the underlying filesystem, delaying
+// its operations as this filesystem.
+func (fs *Latency) Chroot(path string) (billy.Filesystem, error) {
+	chroot, err := fs.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Latency{Filesystem: chroot, s: fs.s}, nil
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Latency) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.Filesystem)
+}
+
+// file is a file delaying its reads and writes. The reads are delayed once
+// done, since their size isn't known until then.
+type file struct {
+	billy.File
+	s *state
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	f.s.delay("read", n)
+	return n, err
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(p, off)
+	f.s.delay("read", n)
+	return n, err
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	f.s.delay("write", len(p))
+	return f.File.Write(p)
+}
+
+func (f *file) Truncate(size int64) error {
+	f.s.delay("truncate", 0)
+	return f.File.Truncate(size)
+}
+
+func (f *file) Close() error {
+	f.s.delay("close", 0)
+	return f.File.Close()
+}
@@ -0,0 +1,106 @@
+package latency
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&LatencySuite{})
+
+type LatencySuite struct {
+	test.FilesystemSuite
+	delays []time.Duration
+}
+
+func (s *LatencySuite) SetUpTest(c *C) {
+	s.delays = nil
+	s.FilesystemSuite = test.NewFilesystemSuite(New(memfs.New(), Options{
+		Default: Uniform(0, time.Microsecond),
+		Sleep:   func(time.Duration) {},
+	}))
+}
+
+func (s *LatencySuite) sleep(d time.Duration) {
+	s.delays = append(s.delays, d)
+}
+
+func (s *LatencySuite) TestOps(c *C) {
+	fs := New(memfs.New(), Options{
+		Default: Fixed(time.Millisecond),
+		Ops:     map[string]Distribution{"stat": Fixed(time.Second)},
+		PerByte: time.Microsecond,
+		Sleep:   s.sleep,
+	})
+
+	c.Assert(util.WriteFile(fs, "foo", []byte("foo"), 0644), IsNil)
+	_, err := fs.Stat("foo")
+	c.Assert(err, IsNil)
+
+	c.Assert(s.delays, DeepEquals, []time.Duration{
+		time.Millisecond,
+		time.Millisecond + 3*time.Microsecond,
+		time.Millisecond,
+		time.Second,
+	})
+}
+
+func (s *LatencySuite) TestOutliers(c *C) {
+	fs := New(memfs.New(), Options{
+		OutlierRate: 0.1,
+		Outlier:     Fixed(time.Second),
+		Seed:        42,
+		Sleep:       s.sleep,
+	})
+
+	for i := 0; i < 1000; i++ {
+		c.Assert(fs.MkdirAll("foo", 0755), IsNil)
+	}
+
+	c.Assert(len(s.delays) > 50 && len(s.delays) < 150, Equals, true)
+	outliers := s.delays
+
+	// the same seed delays the same operations
+	s.delays = nil
+	fs = New(memfs.New(), Options{
+		OutlierRate: 0.1,
+		Outlier:     Fixed(time.Second),
+		Seed:        42,
+		Sleep:       s.sleep,
+	})
+
+	for i := 0; i < 1000; i++ {
+		c.Assert(fs.MkdirAll("foo", 0755), IsNil)
+	}
+
+	c.Assert(s.delays, DeepEquals, outliers)
+}
+
+func (s *LatencySuite) TestChroot(c *C) {
+	fs := New(memfs.New(), Options{Default: Fixed(time.Millisecond), Sleep: s.sleep})
+	chroot, err := fs.Chroot("dir")
+	c.Assert(err, IsNil)
+
+	c.Assert(chroot.MkdirAll("foo", 0755), IsNil)
+	c.Assert(s.delays, HasLen, 1)
+}
+
+func (s *LatencySuite) TestDistributions(c *C) {
+	r := rand.New(rand.NewSource(0))
+	for i := 0; i < 1000; i++ {
+		d := Uniform(time.Millisecond, 2*time.Millisecond).Sample(r)
+		c.Assert(d >= time.Millisecond && d < 2*time.Millisecond, Equals, true)
+
+		c.Assert(Normal(0, time.Second).Sample(r) >= 0, Equals, true)
+	}
+
+	c.Assert(Uniform(time.Second, time.Second).Sample(r), Equals, time.Second)
+}
@@ -0,0 +1,213 @@
+// Package leakcheck provides a helper tracking the files open in a filesystem,
+// with the stack trace of the code opening them, eg.: to find where the
+// files leaked through several layers of wrappers are open.
+package leakcheck
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+const (
+	separator = string(filepath.Separator)
+	maxFrames = 32
+)
+
+// Handle is a file open.
+type Handle struct {
+	// Name is the path of the file, relative to the root of the LeakCheck
+	// created by New.
+	Name string
+	// Flag is the flag the file was open with.
+	Flag int
+	// Time is the time the file was open.
+	Time time.Time
+	// Stack is the stack trace of the code opening the file, one function
+	// and its location per line, the innermost first.
+	Stack string
+}
+
+func (h Handle) String() string {
+	return fmt.Sprintf("%s open at %s by:\n%s", h.Name, h.Time.Format(time.RFC3339), h.Stack)
+}
+
+// Options holds the configuration of a LeakCheck.
+type Options struct {
+	// Leaked is called with the files garbage collected without being
+	// closed, from the goroutine running the finalizers, nothing is done if
+	// nil.
+	Leaked func(Handle)
+}
+
+// LeakCheck is a helper passing every operation through to the underlying
+// filesystem, tracking the files open through it until closed, returned by
+// OpenHandles.
+type LeakCheck struct {
+	billy.Filesystem
+	s *state
+	// dir is the directory the root was moved to by Chroot, as the handles
+	// are reported with their paths from the original root.
+	dir string
+}
+
+// state is the state shared by a LeakCheck, its files and its chroots.
+type state struct {
+	leaked func(Handle)
+
+	m       sync.Mutex
+	next    uint64
+	handles map[uint64]Handle
+}
+
+// New creates a new filesystem tracking the files open in the given one.
+func New(fs billy.Filesystem, opts Options) *LeakCheck {
+	return &LeakCheck{Filesystem: fs, s: &state{
+		leaked:  opts.Leaked,
+		handles: make(map[uint64]Handle),
+	}}
+}
+
+// OpenHandles returns the files open and not closed yet, the least recently
+// open first.
+func (fs *LeakCheck) OpenHandles() []Handle {
+	fs.s.m.Lock()
+	defer fs.s.m.Unlock()
+
+	handles := make([]Handle, 0, len(fs.s.handles))
+	ids := make([]uint64, 0, len(fs.s.handles))
+	for id := range fs.s.handles {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		handles = append(handles, fs.s.handles[id])
+	}
+
+	return handles
+}
+
+// track tracks the given file, open with the given path and flag.
+func (fs *LeakCheck) track(f billy.File, flag int) billy.File {
+	h := Handle{
+		Name:  fs.path(f.Name()),
+		Flag:  flag,
+		Time:  time.Now(),
+		Stack: stack(3),
+	}
+
+	fs.s.m.Lock()
+	fs.s.next++
+	id := fs.s.next
+	fs.s.handles[id] = h
+	fs.s.m.Unlock()
+
+	tracked := &file{File: f, s: fs.s, id: id}
+	runtime.SetFinalizer(tracked, (*file).finalize)
+	return tracked
+}
+
+// stack returns the stack trace of the caller, skipping the given number of
+// frames.
+func stack(skip int) string {
+	pcs := make([]uintptr, maxFrames)
+	n := runtime.Callers(skip+1, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var b strings.Builder
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&b, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+
+	return b.String()
+}
+
+// path returns the given path relative to the root of the underlying
+// filesystem.
+func (fs *LeakCheck) path(p string) string {
+	return filepath.Join(separator, fs.dir, p)
+}
+
+func (fs *LeakCheck) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *LeakCheck) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (fs *LeakCheck) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	f, err := fs.Filesystem.OpenFile(filename, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.track(f, flag), nil
+}
+
+func (fs *LeakCheck) TempFile(dir, prefix string) (billy.File, error) {
+	f, err := fs.Filesystem.TempFile(dir, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.track(f, os.O_RDWR|os.O_CREATE|os.O_EXCL), nil
+}
+
+// Chroot returns the given directory of the underlying filesystem, tracking
+// its files along with the others.
+func (fs *LeakCheck) Chroot(path string) (billy.Filesystem, error) {
+	chroot, err := fs.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LeakCheck{Filesystem: chroot, s: fs.s, dir: fs.path(path)}, nil
+}
+
+// Capabilities implements the Capable interface.
+func (fs *LeakCheck) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.Filesystem)
+}
+
+// file is a file tracked until closed. The handle isn't referenced by the
+// state, so it can be garbage collected if leaked.
+type file struct {
+	billy.File
+	s  *state
+	id uint64
+}
+
+// Close closes the file, untracking it even if failed.
+func (f *file) Close() error {
+	f.s.m.Lock()
+	delete(f.s.handles, f.id)
+	f.s.m.Unlock()
+
+	runtime.SetFinalizer(f, nil)
+	return f.File.Close()
+}
+
+// finalize reports the file if garbage collected without being closed.
+func (f *file) finalize() {
+	f.s.m.Lock()
+	h, ok := f.s.handles[f.id]
+	delete(f.s.handles, f.id)
+	f.s.m.Unlock()
+
+	if ok && f.s.leaked != nil {
+		f.s.leaked(h)
+	}
+}
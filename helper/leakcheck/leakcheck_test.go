@@ -0,0 +1,110 @@
+package leakcheck
+
+import (
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&LeakCheckSuite{})
+
+type LeakCheckSuite struct {
+	test.FilesystemSuite
+	FS     *LeakCheck
+	leaked chan Handle
+}
+
+func (s *LeakCheckSuite) SetUpTest(c *C) {
+	leaked := make(chan Handle, 10)
+	s.leaked = leaked
+	s.FS = New(memfs.New(), Options{Leaked: func(h Handle) {
+		// the files leaked by the suite are ignored
+		if strings.HasPrefix(h.Name, "/leak") {
+			leaked <- h
+		}
+	}})
+
+	s.FilesystemSuite = test.NewFilesystemSuite(s.FS)
+}
+
+func (s *LeakCheckSuite) TestOpenHandles(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(s.FS.OpenHandles(), HasLen, 0)
+
+	foo, err := s.FS.Open("foo")
+	c.Assert(err, IsNil)
+	bar, err := s.FS.Create("bar")
+	c.Assert(err, IsNil)
+
+	handles := s.FS.OpenHandles()
+	c.Assert(handles, HasLen, 2)
+	c.Assert(handles[0].Name, Equals, "/foo")
+	c.Assert(handles[0].Flag, Equals, os.O_RDONLY)
+	c.Assert(handles[1].Name, Equals, "/bar")
+	c.Assert(strings.Contains(handles[1].Stack, "TestOpenHandles"), Equals, true)
+	c.Assert(strings.Contains(handles[1].Stack, "LeakCheck).track"), Equals, false)
+
+	c.Assert(foo.Close(), IsNil)
+	handles = s.FS.OpenHandles()
+	c.Assert(handles, HasLen, 1)
+	c.Assert(handles[0].Name, Equals, "/bar")
+
+	c.Assert(bar.Close(), IsNil)
+	c.Assert(s.FS.OpenHandles(), HasLen, 0)
+}
+
+func (s *LeakCheckSuite) TestLeaked(c *C) {
+	func() {
+		_, err := s.FS.Create("leak")
+		c.Assert(err, IsNil)
+
+		f, err := s.FS.Create("leak-closed")
+		c.Assert(err, IsNil)
+		c.Assert(f.Close(), IsNil)
+	}()
+
+	var h Handle
+	timeout := time.After(5 * time.Second)
+	for h.Name == "" {
+		runtime.GC()
+
+		select {
+		case h = <-s.leaked:
+		case <-time.After(10 * time.Millisecond):
+		case <-timeout:
+			c.Fatal("timeout waiting for the leaked file")
+		}
+	}
+
+	c.Assert(h.Name, Equals, "/leak")
+	c.Assert(s.FS.OpenHandles(), HasLen, 0)
+
+	select {
+	case h := <-s.leaked:
+		c.Fatalf("unexpected leak of %s", h.Name)
+	default:
+	}
+}
+
+func (s *LeakCheckSuite) TestChroot(c *C) {
+	chroot, err := s.FS.Chroot("dir")
+	c.Assert(err, IsNil)
+
+	f, err := chroot.TempFile("", "tmp")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	handles := s.FS.OpenHandles()
+	c.Assert(handles, HasLen, 1)
+	c.Assert(strings.HasPrefix(handles[0].Name, "/dir/tmp"), Equals, true)
+}
@@ -0,0 +1,233 @@
+// Package limitfs provides a helper bounding the concurrency of the access to
+// a filesystem, eg.: to not overload a server throttling or failing beyond a
+// number of simultaneous requests.
+package limitfs
+
+import (
+	"os"
+	"sync"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// Options holds the configuration of a LimitFS.
+type Options struct {
+	// MaxOps is the maximum number of operations in progress at once,
+	// unlimited if 0.
+	MaxOps int
+	// MaxFiles is the maximum number of files open at once, unlimited if 0.
+	MaxFiles int
+}
+
+// LimitFS is a helper bounding the number of operations in progress, and of
+// files open, at once in the underlying filesystem, making the operations
+// exceeding them wait until any other ends, or any file is closed.
+//
+// The locks of the files aren't counted as operations, since they may wait
+// long for another process. A goroutine opening more files than MaxFiles
+// without closing any waits forever.
+type LimitFS struct {
+	billy.Filesystem
+	s *state
+}
+
+// state is the state shared by a LimitFS, its files and its chroots.
+type state struct {
+	ops   semaphore
+	files semaphore
+}
+
+// semaphore is a counting semaphore, unlimited if nil.
+type semaphore chan struct{}
+
+func newSemaphore(n int) semaphore {
+	if n <= 0 {
+		return nil
+	}
+
+	return make(semaphore, n)
+}
+
+func (s semaphore) acquire() {
+	if s != nil {
+		s <- struct{}{}
+	}
+}
+
+func (s semaphore) release() {
+	if s != nil {
+		<-s
+	}
+}
+
+// New creates a new filesystem bounding the concurrency of the access to the
+// given one.
+func New(fs billy.Filesystem, opts Options) *LimitFS {
+	return &LimitFS{Filesystem: fs, s: &state{
+		ops:   newSemaphore(opts.MaxOps),
+		files: newSemaphore(opts.MaxFiles),
+	}}
+}
+
+func (fs *LimitFS) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *LimitFS) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file, once the number of files open allows it.
+func (fs *LimitFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	fs.s.files.acquire()
+	fs.s.ops.acquire()
+	f, err := fs.Filesystem.OpenFile(filename, flag, perm)
+	fs.s.ops.release()
+	return fs.file(f, err)
+}
+
+// TempFile creates a temporary file, once the number of files open allows it.
+func (fs *LimitFS) TempFile(dir, prefix string) (billy.File, error) {
+	fs.s.files.acquire()
+	fs.s.ops.acquire()
+	f, err := fs.Filesystem.TempFile(dir, prefix)
+	fs.s.ops.release()
+	return fs.file(f, err)
+}
+
+func (fs *LimitFS) file(f billy.File, err error) (billy.File, error) {
+	if err != nil {
+		fs.s.files.release()
+		return nil, err
+	}
+
+	return &file{File: f, s: fs.s}, nil
+}
+
+func (fs *LimitFS) Stat(filename string) (os.FileInfo, error) {
+	fs.s.ops.acquire()
+	defer fs.s.ops.release()
+
+	return fs.Filesystem.Stat(filename)
+}
+
+func (fs *LimitFS) Lstat(filename string) (os.FileInfo, error) {
+	fs.s.ops.acquire()
+	defer fs.s.ops.release()
+
+	return fs.Filesystem.Lstat(filename)
+}
+
+func (fs *LimitFS) ReadDir(path string) ([]os.FileInfo, error) {
+	fs.s.ops.acquire()
+	defer fs.s.ops.release()
+
+	return fs.Filesystem.ReadDir(path)
+}
+
+func (fs *LimitFS) Rename(from, to string) error {
+	fs.s.ops.acquire()
+	defer fs.s.ops.release()
+
+	return fs.Filesystem.Rename(from, to)
+}
+
+func (fs *LimitFS) Remove(filename string) error {
+	fs.s.ops.acquire()
+	defer fs.s.ops.release()
+
+	return fs.Filesystem.Remove(filename)
+}
+
+func (fs *LimitFS) MkdirAll(filename string, perm os.FileMode) error {
+	fs.s.ops.acquire()
+	defer fs.s.ops.release()
+
+	return fs.Filesystem.MkdirAll(filename, perm)
+}
+
+func (fs *LimitFS) Symlink(target, link string) error {
+	fs.s.ops.acquire()
+	defer fs.s.ops.release()
+
+	return fs.Filesystem.Symlink(target, link)
+}
+
+func (fs *LimitFS) Readlink(link string) (string, error) {
+	fs.s.ops.acquire()
+	defer fs.s.ops.release()
+
+	return fs.Filesystem.Readlink(link)
+}
+
+// Chroot returns the given directory of the underlying filesystem, sharing
+// the limits.
+func (fs *LimitFS) Chroot(path string) (billy.Filesystem, error) {
+	fs.s.ops.acquire()
+	chroot, err := fs.Filesystem.Chroot(path)
+	fs.s.ops.release()
+	if err != nil {
+		return nil, err
+	}
+
+	return &LimitFS{Filesystem: chroot, s: fs.s}, nil
+}
+
+// Capabilities implements the Capable interface.
+func (fs *LimitFS) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.Filesystem)
+}
+
+// file is a file counting its operations, releasing its place among the files
+// open once closed.
+type file struct {
+	billy.File
+	s      *state
+	closed sync.Once
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	f.s.ops.acquire()
+	defer f.s.ops.release()
+
+	return f.File.Read(p)
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	f.s.ops.acquire()
+	defer f.s.ops.release()
+
+	return f.File.ReadAt(p, off)
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	f.s.ops.acquire()
+	defer f.s.ops.release()
+
+	return f.File.Write(p)
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	f.s.ops.acquire()
+	defer f.s.ops.release()
+
+	return f.File.Seek(offset, whence)
+}
+
+func (f *file) Truncate(size int64) error {
+	f.s.ops.acquire()
+	defer f.s.ops.release()
+
+	return f.File.Truncate(size)
+}
+
+// Close closes the file, releasing its place among the files open even if
+// failed.
+func (f *file) Close() error {
+	f.s.ops.acquire()
+	err := f.File.Close()
+	f.s.ops.release()
+
+	f.closed.Do(f.s.files.release)
+	return err
+}
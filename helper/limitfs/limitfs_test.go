@@ -0,0 +1,104 @@
+package limitfs
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&LimitFSSuite{})
+
+type LimitFSSuite struct {
+	test.FilesystemSuite
+}
+
+func (s *LimitFSSuite) SetUpTest(c *C) {
+	// the suite keeps many files open, so only the operations are limited
+	s.FilesystemSuite = test.NewFilesystemSuite(New(memfs.New(), Options{MaxOps: 1}))
+}
+
+// counting is a filesystem counting the stats in progress.
+type counting struct {
+	billy.Filesystem
+	current, max int32
+}
+
+func (fs *counting) Stat(filename string) (os.FileInfo, error) {
+	n := atomic.AddInt32(&fs.current, 1)
+	defer atomic.AddInt32(&fs.current, -1)
+
+	for {
+		max := atomic.LoadInt32(&fs.max)
+		if n <= max || atomic.CompareAndSwapInt32(&fs.max, max, n) {
+			break
+		}
+	}
+
+	time.Sleep(time.Millisecond)
+	return fs.Filesystem.Stat(filename)
+}
+
+func (s *LimitFSSuite) TestMaxOps(c *C) {
+	underlying := &counting{Filesystem: memfs.New()}
+	fs := New(underlying, Options{MaxOps: 3})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fs.Stat("foo")
+		}()
+	}
+
+	wg.Wait()
+	c.Assert(underlying.max <= 3, Equals, true)
+}
+
+func (s *LimitFSSuite) TestMaxFiles(c *C) {
+	fs := New(memfs.New(), Options{MaxFiles: 2})
+	c.Assert(util.WriteFile(fs, "foo", []byte("foo"), 0644), IsNil)
+
+	first, err := fs.Open("foo")
+	c.Assert(err, IsNil)
+	second, err := fs.Open("foo")
+	c.Assert(err, IsNil)
+
+	opened := make(chan billy.File)
+	go func() {
+		f, _ := fs.Open("foo")
+		opened <- f
+	}()
+
+	select {
+	case <-opened:
+		c.Fatal("open while at the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	c.Assert(first.Close(), IsNil)
+	third := <-opened
+	c.Assert(third, NotNil)
+
+	// the files closed twice release their place once, as the ones failed
+	c.Assert(first.Close(), NotNil)
+	c.Assert(second.Close(), IsNil)
+	_, err = fs.Open("missing")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	f, err := fs.Open("foo")
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+	c.Assert(third.Close(), IsNil)
+}
@@ -0,0 +1,265 @@
+// Package logfs provides a helper logging the operations made to a
+// filesystem, eg.: to debug the programs misusing it in production.
+package logfs
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// Logger is the interface of the loggers used, met by *slog.Logger and by
+// logr.Logger among others. The operations are logged as a message followed
+// by pairs of keys and values.
+type Logger interface {
+	Info(msg string, keysAndValues ...interface{})
+}
+
+// Options holds the configuration of a LogFS.
+type Options struct {
+	// Logger is the logger used, if nil one printing the operations with the
+	// standard logger of the log package.
+	Logger Logger
+	// Sample is the rate of the successful operations logged, one out of
+	// every Sample, all of them if 0 or 1. The failed operations are always
+	// logged.
+	Sample uint64
+	// Redact returns the given path as logged, eg.: hiding the names of the
+	// users, as given if nil.
+	Redact func(path string) string
+}
+
+// LogFS is a helper logging every operation made to the underlying
+// filesystem, and to the files open from it, with the keys "op", "path",
+// "duration" and "error", if failed. The operations involving two paths log
+// the second one with the key "to".
+type LogFS struct {
+	billy.Filesystem
+	l *logger
+}
+
+// logger is the state shared by a LogFS, its files and its chroots.
+type logger struct {
+	opts Options
+	n    uint64
+}
+
+// New creates a new filesystem logging the operations made to the given one.
+func New(fs billy.Filesystem, opts Options) *LogFS {
+	if opts.Logger == nil {
+		opts.Logger = stdLogger{}
+	}
+
+	return &LogFS{Filesystem: fs, l: &logger{opts: opts}}
+}
+
+// log logs an operation started at the given time, if sampled.
+func (l *logger) log(op, path string, start time.Time, err error, keysAndValues ...interface{}) {
+	duration := time.Since(start)
+	if err == nil && l.opts.Sample > 1 && atomic.AddUint64(&l.n, 1)%l.opts.Sample != 1 {
+		return
+	}
+
+	kv := []interface{}{"op", op, "path", l.redact(path), "duration", duration}
+	kv = append(kv, keysAndValues...)
+	if err != nil {
+		kv = append(kv, "error", l.redactError(err))
+	}
+
+	l.opts.Logger.Info("billy operation", kv...)
+}
+
+func (l *logger) redact(path string) string {
+	if l.opts.Redact == nil {
+		return path
+	}
+
+	return l.opts.Redact(path)
+}
+
+// redactError returns the given error with its paths redacted, since the
+// ones returned by the filesystems usually contain them.
+func (l *logger) redactError(err error) error {
+	if l.opts.Redact == nil {
+		return err
+	}
+
+	switch e := err.(type) {
+	case *os.PathError:
+		return &os.PathError{Op: e.Op, Path: l.redact(e.Path), Err: e.Err}
+	case *os.LinkError:
+		return &os.LinkError{Op: e.Op, Old: l.redact(e.Old), New: l.redact(e.New), Err: e.Err}
+	}
+
+	return err
+}
+
+// stdLogger is a Logger printing the messages, followed by their keys and
+// values as key=value, with the standard logger.
+type stdLogger struct{}
+
+func (stdLogger) Info(msg string, keysAndValues ...interface{}) {
+	var b strings.Builder
+	b.WriteString(msg)
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		fmt.Fprintf(&b, " %v=%q", keysAndValues[i], fmt.Sprint(keysAndValues[i+1]))
+	}
+
+	log.Print(b.String())
+}
+
+func (fs *LogFS) Create(filename string) (billy.File, error) {
+	start := time.Now()
+	f, err := fs.Filesystem.Create(filename)
+	fs.l.log("create", filename, start, err)
+	return fs.file(f, err)
+}
+
+func (fs *LogFS) Open(filename string) (billy.File, error) {
+	start := time.Now()
+	f, err := fs.Filesystem.Open(filename)
+	fs.l.log("open", filename, start, err)
+	return fs.file(f, err)
+}
+
+func (fs *LogFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	start := time.Now()
+	f, err := fs.Filesystem.OpenFile(filename, flag, perm)
+	fs.l.log("open", filename, start, err, "flag", flag, "perm", perm)
+	return fs.file(f, err)
+}
+
+func (fs *LogFS) TempFile(dir, prefix string) (billy.File, error) {
+	start := time.Now()
+	f, err := fs.Filesystem.TempFile(dir, prefix)
+	fs.l.log("tempfile", dir, start, err)
+	return fs.file(f, err)
+}
+
+func (fs *LogFS) file(f billy.File, err error) (billy.File, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	return &file{File: f, l: fs.l}, nil
+}
+
+func (fs *LogFS) Stat(filename string) (os.FileInfo, error) {
+	start := time.Now()
+	fi, err := fs.Filesystem.Stat(filename)
+	fs.l.log("stat", filename, start, err)
+	return fi, err
+}
+
+func (fs *LogFS) Lstat(filename string) (os.FileInfo, error) {
+	start := time.Now()
+	fi, err := fs.Filesystem.Lstat(filename)
+	fs.l.log("lstat", filename, start, err)
+	return fi, err
+}
+
+func (fs *LogFS) ReadDir(path string) ([]os.FileInfo, error) {
+	start := time.Now()
+	infos, err := fs.Filesystem.ReadDir(path)
+	fs.l.log("readdir", path, start, err)
+	return infos, err
+}
+
+func (fs *LogFS) Rename(from, to string) error {
+	start := time.Now()
+	err := fs.Filesystem.Rename(from, to)
+	fs.l.log("rename", from, start, err, "to", fs.l.redact(to))
+	return err
+}
+
+func (fs *LogFS) Remove(filename string) error {
+	start := time.Now()
+	err := fs.Filesystem.Remove(filename)
+	fs.l.log("remove", filename, start, err)
+	return err
+}
+
+func (fs *LogFS) MkdirAll(filename string, perm os.FileMode) error {
+	start := time.Now()
+	err := fs.Filesystem.MkdirAll(filename, perm)
+	fs.l.log("mkdirall", filename, start, err, "perm", perm)
+	return err
+}
+
+func (fs *LogFS) Symlink(target, link string) error {
+	start := time.Now()
+	err := fs.Filesystem.Symlink(target, link)
+	fs.l.log("symlink", link, start, err, "to", fs.l.redact(target))
+	return err
+}
+
+func (fs *LogFS) Readlink(link string) (string, error) {
+	start := time.Now()
+	target, err := fs.Filesystem.Readlink(link)
+	fs.l.log("readlink", link, start, err)
+	return target, err
+}
+
+// Chroot returns the given directory of the underlying filesystem, logging
+// its operations with the paths relative to it.
+func (fs *LogFS) Chroot(path string) (billy.Filesystem, error) {
+	start := time.Now()
+	chroot, err := fs.Filesystem.Chroot(path)
+	fs.l.log("chroot", path, start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LogFS{Filesystem: chroot, l: fs.l}, nil
+}
+
+// Capabilities implements the Capable interface.
+func (fs *LogFS) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.Filesystem)
+}
+
+// file is a file logging its operations, but seeking.
+type file struct {
+	billy.File
+	l *logger
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := f.File.Read(p)
+	f.l.log("read", f.Name(), start, err, "bytes", n)
+	return n, err
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	start := time.Now()
+	n, err := f.File.ReadAt(p, off)
+	f.l.log("readat", f.Name(), start, err, "offset", off, "bytes", n)
+	return n, err
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := f.File.Write(p)
+	f.l.log("write", f.Name(), start, err, "bytes", n)
+	return n, err
+}
+
+func (f *file) Truncate(size int64) error {
+	start := time.Now()
+	err := f.File.Truncate(size)
+	f.l.log("truncate", f.Name(), start, err, "size", size)
+	return err
+}
+
+func (f *file) Close() error {
+	start := time.Now()
+	err := f.File.Close()
+	f.l.log("close", f.Name(), start, err)
+	return err
+}
@@ -0,0 +1,141 @@
+package logfs
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&LogFSSuite{})
+
+type LogFSSuite struct {
+	test.FilesystemSuite
+	logger *recorder
+}
+
+// recorder is a logger recording the operations logged.
+type recorder struct {
+	entries []map[string]interface{}
+}
+
+func (r *recorder) Info(msg string, keysAndValues ...interface{}) {
+	entry := make(map[string]interface{})
+	for i := 0; i < len(keysAndValues); i += 2 {
+		entry[keysAndValues[i].(string)] = keysAndValues[i+1]
+	}
+
+	r.entries = append(r.entries, entry)
+}
+
+func (r *recorder) ops() []string {
+	var ops []string
+	for _, e := range r.entries {
+		ops = append(ops, e["op"].(string))
+	}
+
+	return ops
+}
+
+func (s *LogFSSuite) SetUpTest(c *C) {
+	s.logger = &recorder{}
+	s.FilesystemSuite = test.NewFilesystemSuite(New(memfs.New(), Options{
+		Logger: s.logger,
+	}))
+}
+
+func (s *LogFSSuite) TestLog(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(s.FS.Rename("foo", "bar"), IsNil)
+	c.Assert(s.logger.ops(), DeepEquals, []string{"open", "write", "close", "rename"})
+
+	e := s.logger.entries[1]
+	c.Assert(e["path"], Equals, "foo")
+	c.Assert(e["bytes"], Equals, 3)
+	_, ok := e["duration"]
+	c.Assert(ok, Equals, true)
+	_, ok = e["error"]
+	c.Assert(ok, Equals, false)
+
+	e = s.logger.entries[3]
+	c.Assert(e["path"], Equals, "foo")
+	c.Assert(e["to"], Equals, "bar")
+}
+
+func (s *LogFSSuite) TestLogError(c *C) {
+	_, err := s.FS.Stat("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	c.Assert(s.logger.entries, HasLen, 1)
+	c.Assert(s.logger.entries[0]["error"], Equals, err)
+}
+
+func (s *LogFSSuite) TestSample(c *C) {
+	s.FS = New(memfs.New(), Options{Logger: s.logger, Sample: 3})
+	for i := 0; i < 6; i++ {
+		c.Assert(s.FS.MkdirAll("foo", 0755), IsNil)
+	}
+
+	_, err := s.FS.Stat("bar")
+	c.Assert(err, NotNil)
+
+	c.Assert(s.logger.ops(), DeepEquals, []string{"mkdirall", "mkdirall", "stat"})
+}
+
+func (s *LogFSSuite) TestRedact(c *C) {
+	s.FS = New(memfs.New(), Options{Logger: s.logger, Redact: func(path string) string {
+		return strings.Replace(path, "secret", "***", -1)
+	}})
+
+	c.Assert(s.FS.MkdirAll("secret", 0755), IsNil)
+	c.Assert(s.FS.Symlink("secret", "link"), IsNil)
+
+	c.Assert(s.logger.entries[0]["path"], Equals, "***")
+	c.Assert(s.logger.entries[1]["path"], Equals, "link")
+	c.Assert(s.logger.entries[1]["to"], Equals, "***")
+}
+
+func (s *LogFSSuite) TestChroot(c *C) {
+	fs, err := s.FS.Chroot("dir")
+	c.Assert(err, IsNil)
+	c.Assert(fs.MkdirAll("foo", 0755), IsNil)
+
+	c.Assert(s.logger.ops(), DeepEquals, []string{"chroot", "mkdirall"})
+	c.Assert(s.logger.entries[1]["path"], Equals, "foo")
+}
+
+func (s *LogFSSuite) TestRedactError(c *C) {
+	s.FS = New(memfs.New(), Options{Logger: s.logger, Redact: func(path string) string {
+		return strings.Replace(path, "secret", "***", -1)
+	}})
+
+	c.Assert(util.WriteFile(s.FS, "secret", nil, 0644), IsNil)
+	_, err := s.FS.Readlink("secret")
+	c.Assert(err, ErrorMatches, "readlink .*secret: not a symlink")
+
+	e := s.logger.entries[len(s.logger.entries)-1]
+	c.Assert(e["op"], Equals, "readlink")
+	c.Assert(e["error"], ErrorMatches, `readlink [^s]*\*\*\*: not a symlink`)
+}
+
+func (s *LogFSSuite) TestDefaultLogger(c *C) {
+	buf := bytes.NewBuffer(nil)
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+
+	s.FS = New(memfs.New(), Options{})
+	c.Assert(s.FS.Remove("foo"), NotNil)
+
+	line := buf.String()
+	c.Assert(strings.Contains(line, `billy operation op="remove" path="foo"`), Equals, true)
+	c.Assert(strings.Contains(line, "error="), Equals, true)
+}
@@ -0,0 +1,178 @@
+// Package maxsize provides a helper bounding the size of the files written to
+// a filesystem, eg.: to protect a service storing untrusted inputs from
+// decompression bombs filling its storage.
+package maxsize
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// ErrTooLarge is returned, as the error of an *os.PathError, by the writes
+// that would grow a file beyond the limit.
+var ErrTooLarge = errors.New("file too large")
+
+// MaxSize is a helper passing every operation through to the underlying
+// filesystem, but failing with ErrTooLarge the writes of the files, and the
+// truncates, that would make them larger than the limit. The writes failing
+// write nothing, so the files are never larger than the limit.
+//
+// The files already larger than the limit can still be read, and written
+// within the limit.
+type MaxSize struct {
+	billy.Filesystem
+	limit int64
+}
+
+// New creates a new filesystem wrapping up the given one, whose files can't
+// be made larger than limit bytes through it.
+func New(fs billy.Filesystem, limit int64) *MaxSize {
+	return &MaxSize{Filesystem: fs, limit: limit}
+}
+
+func (fs *MaxSize) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *MaxSize) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (fs *MaxSize) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	f, err := fs.Filesystem.OpenFile(filename, flag, perm)
+	if err != nil || flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return f, err
+	}
+
+	return &file{File: f, limit: fs.limit, append: flag&os.O_APPEND != 0}, nil
+}
+
+func (fs *MaxSize) TempFile(dir, prefix string) (billy.File, error) {
+	f, err := fs.Filesystem.TempFile(dir, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return &file{File: f, limit: fs.limit}, nil
+}
+
+// Chroot returns the given directory of the underlying filesystem, with the
+// same limit.
+func (fs *MaxSize) Chroot(path string) (billy.Filesystem, error) {
+	chroot, err := fs.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(chroot, fs.limit), nil
+}
+
+// Capabilities implements the Capable interface.
+func (fs *MaxSize) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.Filesystem)
+}
+
+// file is a file open for writing, which can't grow beyond the limit.
+type file struct {
+	billy.File
+	limit int64
+	// append is true if the file is always written at its end.
+	append bool
+}
+
+// Write writes to the file, failing if it would grow beyond the limit.
+func (f *file) Write(p []byte) (int, error) {
+	off, err := f.offset()
+	if err != nil {
+		return 0, err
+	}
+
+	if off+int64(len(p)) > f.limit {
+		return 0, f.tooLarge("write")
+	}
+
+	return f.File.Write(p)
+}
+
+// WriteAt writes to the file at the given offset, failing if it would grow
+// beyond the limit, or with billy.ErrNotSupported if the underlying file
+// doesn't implement io.WriterAt.
+func (f *file) WriteAt(p []byte, off int64) (int, error) {
+	w, ok := f.File.(io.WriterAt)
+	if !ok {
+		return 0, &os.PathError{Op: "writeat", Path: f.Name(), Err: billy.ErrNotSupported}
+	}
+
+	if off+int64(len(p)) > f.limit {
+		return 0, f.tooLarge("writeat")
+	}
+
+	return w.WriteAt(p, off)
+}
+
+// ReadFrom copies to the file the content of the given reader, implementing
+// io.ReaderFrom, so io.Copy doesn't read more than the room left. It fails
+// if the reader has more, once the room is filled.
+func (f *file) ReadFrom(r io.Reader) (int64, error) {
+	off, err := f.offset()
+	if err != nil {
+		return 0, err
+	}
+
+	room := f.limit - off
+	if room < 0 {
+		room = 0
+	}
+
+	n, err := io.Copy(f.File, io.LimitReader(r, room))
+	if err != nil || n < room {
+		return n, err
+	}
+
+	var b [1]byte
+	if m, _ := io.ReadFull(r, b[:]); m != 0 {
+		return n, f.tooLarge("write")
+	}
+
+	return n, nil
+}
+
+// Truncate changes the size of the file, failing if beyond the limit.
+func (f *file) Truncate(size int64) error {
+	if size > f.limit {
+		return f.tooLarge("truncate")
+	}
+
+	return f.File.Truncate(size)
+}
+
+// offset returns the offset of the next write, the end of the file if it's
+// open for appending.
+func (f *file) offset() (int64, error) {
+	if !f.append {
+		return f.File.Seek(0, io.SeekCurrent)
+	}
+
+	pos, err := f.File.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+
+	size, err := f.File.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := f.File.Seek(pos, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	return size, nil
+}
+
+func (f *file) tooLarge(op string) error {
+	return &os.PathError{Op: op, Path: f.Name(), Err: ErrTooLarge}
+}
@@ -0,0 +1,165 @@
+package maxsize
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&MaxSizeSuite{})
+
+type MaxSizeSuite struct {
+	test.FilesystemSuite
+	FS billy.Filesystem
+}
+
+func (s *MaxSizeSuite) SetUpTest(c *C) {
+	s.FilesystemSuite = test.NewFilesystemSuite(New(memfs.New(), 1<<20))
+	s.FS = New(memfs.New(), 8)
+}
+
+func isTooLarge(err error) bool {
+	e, ok := err.(*os.PathError)
+	return ok && e.Err == ErrTooLarge
+}
+
+func (s *MaxSizeSuite) TestWrite(c *C) {
+	f, err := s.FS.Create("foo")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	_, err = f.Write([]byte("foobar"))
+	c.Assert(err, IsNil)
+
+	n, err := f.Write([]byte("qux"))
+	c.Assert(n, Equals, 0)
+	c.Assert(isTooLarge(err), Equals, true)
+
+	_, err = f.Write([]byte("qu"))
+	c.Assert(err, IsNil)
+
+	// overwriting within the limit is allowed
+	_, err = f.Seek(0, io.SeekStart)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("bar"))
+	c.Assert(err, IsNil)
+
+	_, err = f.Seek(6, io.SeekStart)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("qux"))
+	c.Assert(isTooLarge(err), Equals, true)
+
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "barbarqu")
+}
+
+func (s *MaxSizeSuite) TestAppend(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foobar"), 0644), IsNil)
+
+	f, err := s.FS.OpenFile("foo", os.O_WRONLY|os.O_APPEND, 0)
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	_, err = f.Write([]byte("qux"))
+	c.Assert(isTooLarge(err), Equals, true)
+	_, err = f.Write([]byte("qu"))
+	c.Assert(err, IsNil)
+}
+
+func (s *MaxSizeSuite) TestTruncate(c *C) {
+	f, err := s.FS.Create("foo")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	c.Assert(isTooLarge(f.Truncate(9)), Equals, true)
+	c.Assert(f.Truncate(8), IsNil)
+}
+
+func (s *MaxSizeSuite) TestCopy(c *C) {
+	f, err := s.FS.Create("foo")
+	c.Assert(err, IsNil)
+
+	// the reader is wrapped, so io.Copy doesn't use its WriteTo method
+	r := strings.NewReader(strings.Repeat("x", 1<<20))
+	n, err := io.Copy(f, struct{ io.Reader }{r})
+	c.Assert(isTooLarge(err), Equals, true)
+	c.Assert(n, Equals, int64(8))
+	c.Assert(r.Len(), Equals, 1<<20-9)
+	c.Assert(f.Close(), IsNil)
+
+	f, err = s.FS.Create("bar")
+	c.Assert(err, IsNil)
+	n, err = io.Copy(f, bytes.NewBufferString("foobar"))
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, int64(6))
+	c.Assert(f.Close(), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "bar"), Equals, "foobar")
+}
+
+func (s *MaxSizeSuite) TestWriteAt(c *C) {
+	fs := New(&writerAtFS{memfs.New()}, 8)
+	f, err := fs.Create("foo")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	w := f.(io.WriterAt)
+	_, err = w.WriteAt([]byte("foo"), 5)
+	c.Assert(err, IsNil)
+	_, err = w.WriteAt([]byte("foo"), 6)
+	c.Assert(isTooLarge(err), Equals, true)
+
+	f, err = s.FS.Create("foo")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	_, err = f.(io.WriterAt).WriteAt([]byte("foo"), 0)
+	c.Assert(err.(*os.PathError).Err, Equals, billy.ErrNotSupported)
+}
+
+func (s *MaxSizeSuite) TestChroot(c *C) {
+	chroot, err := s.FS.Chroot("dir")
+	c.Assert(err, IsNil)
+
+	err = util.WriteFile(chroot, "foo", []byte("foobarqux"), 0644)
+	c.Assert(isTooLarge(err), Equals, true)
+}
+
+// writerAtFS is a filesystem whose files implement io.WriterAt.
+type writerAtFS struct {
+	billy.Filesystem
+}
+
+func (fs *writerAtFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	f, err := fs.Filesystem.OpenFile(filename, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &writerAtFile{f}, nil
+}
+
+func (fs *writerAtFS) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+type writerAtFile struct {
+	billy.File
+}
+
+func (f *writerAtFile) WriteAt(p []byte, off int64) (int, error) {
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	return f.Write(p)
+}
@@ -0,0 +1,274 @@
+// Package metrics provides a helper instrumenting a filesystem with Prometheus
+// metrics, eg.: to monitor the filesystem of a long-running service.
+package metrics
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// DefaultNamespace is the namespace of the metrics used if none is given.
+const DefaultNamespace = "billy"
+
+// Options holds the configuration of a Metrics.
+type Options struct {
+	// Backend is the value of the backend label of every metric, telling
+	// apart the filesystems instrumented in the same process.
+	Backend string
+	// Namespace is the namespace of the metrics, DefaultNamespace if empty.
+	Namespace string
+	// Buckets are the buckets of the latency histogram, in seconds,
+	// prometheus.DefBuckets if nil.
+	Buckets []float64
+}
+
+// Metrics is a helper recording the number of operations made to the
+// underlying filesystem, and to the files open from it, the number of them
+// failed, their latency and the bytes read and written. It's a
+// prometheus.Collector, so it must be registered to export the metrics:
+//
+//	fs := metrics.New(osfs.New("/data"), metrics.Options{Backend: "data"})
+//	prometheus.MustRegister(fs)
+//
+// The end of the files, io.EOF, isn't counted as an error.
+type Metrics struct {
+	billy.Filesystem
+	c *collectors
+}
+
+type collectors struct {
+	operations *prometheus.CounterVec
+	errors     *prometheus.CounterVec
+	duration   *prometheus.HistogramVec
+	read       prometheus.Counter
+	written    prometheus.Counter
+}
+
+// New creates a new filesystem recording the metrics of the given one.
+func New(fs billy.Filesystem, opts Options) *Metrics {
+	if opts.Namespace == "" {
+		opts.Namespace = DefaultNamespace
+	}
+
+	if opts.Buckets == nil {
+		opts.Buckets = prometheus.DefBuckets
+	}
+
+	labels := prometheus.Labels{"backend": opts.Backend}
+	return &Metrics{Filesystem: fs, c: &collectors{
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Name:        "operations_total",
+			Help:        "Number of operations made, by operation.",
+			ConstLabels: labels,
+		}, []string{"operation"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Name:        "errors_total",
+			Help:        "Number of operations failed, by operation.",
+			ConstLabels: labels,
+		}, []string{"operation"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   opts.Namespace,
+			Name:        "operation_duration_seconds",
+			Help:        "Latency of the operations, by operation.",
+			ConstLabels: labels,
+			Buckets:     opts.Buckets,
+		}, []string{"operation"}),
+		read: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Name:        "read_bytes_total",
+			Help:        "Number of bytes read from the files.",
+			ConstLabels: labels,
+		}),
+		written: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Name:        "written_bytes_total",
+			Help:        "Number of bytes written to the files.",
+			ConstLabels: labels,
+		}),
+	}}
+}
+
+// Describe implements the prometheus.Collector interface.
+func (fs *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	fs.c.operations.Describe(ch)
+	fs.c.errors.Describe(ch)
+	fs.c.duration.Describe(ch)
+	fs.c.read.Describe(ch)
+	fs.c.written.Describe(ch)
+}
+
+// Collect implements the prometheus.Collector interface.
+func (fs *Metrics) Collect(ch chan<- prometheus.Metric) {
+	fs.c.operations.Collect(ch)
+	fs.c.errors.Collect(ch)
+	fs.c.duration.Collect(ch)
+	fs.c.read.Collect(ch)
+	fs.c.written.Collect(ch)
+}
+
+// observe records an operation started at the given time.
+func (c *collectors) observe(op string, start time.Time, err error) {
+	c.operations.WithLabelValues(op).Inc()
+	c.duration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	if err != nil && err != io.EOF {
+		c.errors.WithLabelValues(op).Inc()
+	}
+}
+
+func (fs *Metrics) Create(filename string) (billy.File, error) {
+	start := time.Now()
+	f, err := fs.Filesystem.Create(filename)
+	fs.c.observe("create", start, err)
+	return fs.file(f, err)
+}
+
+func (fs *Metrics) Open(filename string) (billy.File, error) {
+	start := time.Now()
+	f, err := fs.Filesystem.Open(filename)
+	fs.c.observe("open", start, err)
+	return fs.file(f, err)
+}
+
+func (fs *Metrics) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	start := time.Now()
+	f, err := fs.Filesystem.OpenFile(filename, flag, perm)
+	fs.c.observe("open", start, err)
+	return fs.file(f, err)
+}
+
+func (fs *Metrics) TempFile(dir, prefix string) (billy.File, error) {
+	start := time.Now()
+	f, err := fs.Filesystem.TempFile(dir, prefix)
+	fs.c.observe("tempfile", start, err)
+	return fs.file(f, err)
+}
+
+func (fs *Metrics) file(f billy.File, err error) (billy.File, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	return &file{File: f, c: fs.c}, nil
+}
+
+func (fs *Metrics) Stat(filename string) (os.FileInfo, error) {
+	start := time.Now()
+	fi, err := fs.Filesystem.Stat(filename)
+	fs.c.observe("stat", start, err)
+	return fi, err
+}
+
+func (fs *Metrics) Lstat(filename string) (os.FileInfo, error) {
+	start := time.Now()
+	fi, err := fs.Filesystem.Lstat(filename)
+	fs.c.observe("lstat", start, err)
+	return fi, err
+}
+
+func (fs *Metrics) ReadDir(path string) ([]os.FileInfo, error) {
+	start := time.Now()
+	infos, err := fs.Filesystem.ReadDir(path)
+	fs.c.observe("readdir", start, err)
+	return infos, err
+}
+
+func (fs *Metrics) Rename(from, to string) error {
+	start := time.Now()
+	err := fs.Filesystem.Rename(from, to)
+	fs.c.observe("rename", start, err)
+	return err
+}
+
+func (fs *Metrics) Remove(filename string) error {
+	start := time.Now()
+	err := fs.Filesystem.Remove(filename)
+	fs.c.observe("remove", start, err)
+	return err
+}
+
+func (fs *Metrics) MkdirAll(filename string, perm os.FileMode) error {
+	start := time.Now()
+	err := fs.Filesystem.MkdirAll(filename, perm)
+	fs.c.observe("mkdirall", start, err)
+	return err
+}
+
+func (fs *Metrics) Symlink(target, link string) error {
+	start := time.Now()
+	err := fs.Filesystem.Symlink(target, link)
+	fs.c.observe("symlink", start, err)
+	return err
+}
+
+func (fs *Metrics) Readlink(link string) (string, error) {
+	start := time.Now()
+	target, err := fs.Filesystem.Readlink(link)
+	fs.c.observe("readlink", start, err)
+	return target, err
+}
+
+// Chroot returns the given directory of the underlying filesystem, recording
+// its metrics along with the ones of this filesystem.
+func (fs *Metrics) Chroot(path string) (billy.Filesystem, error) {
+	chroot, err := fs.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Metrics{Filesystem: chroot, c: fs.c}, nil
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Metrics) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.Filesystem)
+}
+
+// file is a file recording the metrics of its reads and writes.
+type file struct {
+	billy.File
+	c *collectors
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := f.File.Read(p)
+	f.c.observe("read", start, err)
+	f.c.read.Add(float64(n))
+	return n, err
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	start := time.Now()
+	n, err := f.File.ReadAt(p, off)
+	f.c.observe("read", start, err)
+	f.c.read.Add(float64(n))
+	return n, err
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := f.File.Write(p)
+	f.c.observe("write", start, err)
+	f.c.written.Add(float64(n))
+	return n, err
+}
+
+func (f *file) Truncate(size int64) error {
+	start := time.Now()
+	err := f.File.Truncate(size)
+	f.c.observe("truncate", start, err)
+	return err
+}
+
+func (f *file) Close() error {
+	start := time.Now()
+	err := f.File.Close()
+	f.c.observe("close", start, err)
+	return err
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"os"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&MetricsSuite{})
+
+type MetricsSuite struct {
+	test.FilesystemSuite
+	metrics *Metrics
+}
+
+func (s *MetricsSuite) SetUpTest(c *C) {
+	s.metrics = New(memfs.New(), Options{Backend: "memory"})
+	s.FilesystemSuite = test.NewFilesystemSuite(s.metrics)
+}
+
+func (s *MetricsSuite) TestOperations(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "foo")
+	_, err := s.FS.Stat("foo")
+	c.Assert(err, IsNil)
+
+	operations := s.metrics.c.operations
+	c.Assert(testutil.ToFloat64(operations.WithLabelValues("open")), Equals, 2.0)
+	c.Assert(testutil.ToFloat64(operations.WithLabelValues("write")), Equals, 1.0)
+	c.Assert(testutil.ToFloat64(operations.WithLabelValues("close")), Equals, 2.0)
+	c.Assert(testutil.ToFloat64(operations.WithLabelValues("stat")), Equals, 1.0)
+	c.Assert(testutil.CollectAndCount(s.metrics.c.duration), Equals, 5)
+}
+
+func (s *MetricsSuite) TestErrors(c *C) {
+	_, err := s.FS.Open("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+	c.Assert(s.FS.Remove("foo"), NotNil)
+
+	// the end of the file isn't an error
+	c.Assert(util.WriteFile(s.FS, "foo", nil, 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "")
+
+	errors := s.metrics.c.errors
+	c.Assert(testutil.ToFloat64(errors.WithLabelValues("open")), Equals, 1.0)
+	c.Assert(testutil.ToFloat64(errors.WithLabelValues("remove")), Equals, 1.0)
+	c.Assert(testutil.ToFloat64(errors.WithLabelValues("read")), Equals, 0.0)
+}
+
+func (s *MetricsSuite) TestBytes(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "foo")
+
+	f, err := s.FS.Open("foo")
+	c.Assert(err, IsNil)
+	_, err = f.ReadAt(make([]byte, 2), 1)
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	c.Assert(testutil.ToFloat64(s.metrics.c.read), Equals, 5.0)
+	c.Assert(testutil.ToFloat64(s.metrics.c.written), Equals, 3.0)
+}
+
+func (s *MetricsSuite) TestChroot(c *C) {
+	fs, err := s.FS.Chroot("dir")
+	c.Assert(err, IsNil)
+	c.Assert(util.WriteFile(fs, "foo", []byte("foo"), 0644), IsNil)
+
+	operations := s.metrics.c.operations
+	c.Assert(testutil.ToFloat64(operations.WithLabelValues("open")), Equals, 1.0)
+	c.Assert(testutil.ToFloat64(s.metrics.c.written), Equals, 3.0)
+}
+
+func (s *MetricsSuite) TestRegister(c *C) {
+	other := New(memfs.New(), Options{Backend: "other"})
+
+	registry := prometheus.NewPedanticRegistry()
+	c.Assert(registry.Register(s.metrics), IsNil)
+	c.Assert(registry.Register(other), IsNil)
+
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(other, "foo", []byte("foo"), 0644), IsNil)
+
+	count, err := testutil.GatherAndCount(registry, "billy_written_bytes_total")
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, 2)
+}
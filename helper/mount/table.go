@@ -0,0 +1,454 @@
+package mount
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+var (
+	// ErrMountPoint is returned when removing or renaming a mount point.
+	ErrMountPoint = errors.New("mount point busy")
+	// ErrNotMounted is returned when unmounting a path without filesystem.
+	ErrNotMounted = errors.New("not mounted")
+)
+
+// Table is a helper composing several filesystems, mounted at different paths
+// over a root filesystem, as the mount table of an operating system. Every
+// path is handled by the filesystem mounted at its longest parent, and the
+// files are renamed across filesystems by copying and removing them.
+type Table struct {
+	root billy.Filesystem
+
+	m      sync.RWMutex
+	mounts map[string]billy.Filesystem
+}
+
+// NewTable creates a new mount table with the given root filesystem, mounted
+// at "/".
+func NewTable(root billy.Filesystem) *Table {
+	return &Table{
+		root:   root,
+		mounts: make(map[string]billy.Filesystem),
+	}
+}
+
+// Mount mounts the given filesystem at mountpoint, which doesn't need to
+// exist in the filesystem below it. It fails if the path is already a mount
+// point.
+func (t *Table) Mount(mountpoint string, fs billy.Filesystem) error {
+	p := cleanPath(mountpoint)
+
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	if _, ok := t.mounts[p]; ok || p == "." {
+		return &os.PathError{Op: "mount", Path: mountpoint, Err: ErrMountPoint}
+	}
+
+	t.mounts[p] = fs
+	return nil
+}
+
+// Unmount removes the filesystem mounted at mountpoint, uncovering the files
+// below it.
+func (t *Table) Unmount(mountpoint string) error {
+	p := cleanPath(mountpoint)
+
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	if _, ok := t.mounts[p]; !ok {
+		return &os.PathError{Op: "unmount", Path: mountpoint, Err: ErrNotMounted}
+	}
+
+	delete(t.mounts, p)
+	return nil
+}
+
+// resolve returns the filesystem handling the given path, its path in it,
+// and the mount point of the filesystem.
+func (t *Table) resolve(path string) (billy.Filesystem, string, string) {
+	p := cleanPath(path)
+
+	t.m.RLock()
+	defer t.m.RUnlock()
+
+	for dir := p; dir != "."; dir = filepath.Dir(dir) {
+		if fs, ok := t.mounts[dir]; ok {
+			rel, _ := filepath.Rel(dir, p)
+			return fs, toFSPath(rel), dir
+		}
+	}
+
+	return t.root, toFSPath(p), "."
+}
+
+// children returns the names of the entries of the given directory leading to
+// a mount point.
+func (t *Table) children(p string) map[string]bool {
+	t.m.RLock()
+	defer t.m.RUnlock()
+
+	names := make(map[string]bool)
+	for mountpoint := range t.mounts {
+		rel, err := filepath.Rel(p, mountpoint)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+separator) {
+			continue
+		}
+
+		names[strings.SplitN(rel, separator, 2)[0]] = true
+	}
+
+	return names
+}
+
+func (t *Table) isMountpoint(p string) bool {
+	t.m.RLock()
+	defer t.m.RUnlock()
+
+	_, ok := t.mounts[p]
+	return ok
+}
+
+func (t *Table) Create(filename string) (billy.File, error) {
+	return t.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (t *Table) Open(filename string) (billy.File, error) {
+	return t.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (t *Table) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	fs, p, _ := t.resolve(filename)
+	f, err := fs.OpenFile(p, flag, perm)
+	return wrapFile(f, filename), err
+}
+
+// Stat returns the given file. The mount points, and the directories leading
+// to them, are directories even if they don't exist below them.
+func (t *Table) Stat(filename string) (os.FileInfo, error) {
+	fs, p, _ := t.resolve(filename)
+	fi, err := fs.Stat(p)
+	return t.virtualDir(filename, fi, err)
+}
+
+func (t *Table) Lstat(filename string) (os.FileInfo, error) {
+	fs, p, _ := t.resolve(filename)
+	fi, err := fs.Lstat(p)
+	return t.virtualDir(filename, fi, err)
+}
+
+// virtualDir returns a directory in place of the given file, if it's a mount
+// point or leads to one.
+func (t *Table) virtualDir(filename string, fi os.FileInfo, err error) (os.FileInfo, error) {
+	p := cleanPath(filename)
+	if !t.isMountpoint(p) && (err == nil || len(t.children(p)) == 0) {
+		return fi, err
+	}
+
+	if err == nil && fi.IsDir() {
+		return fi, nil
+	}
+
+	return newDirInfo(filepath.Base(p)), nil
+}
+
+// ReadDir returns the entries of the given directory, with the ones leading to
+// a mount point as directories, replacing the files below them.
+func (t *Table) ReadDir(path string) ([]os.FileInfo, error) {
+	fs, p, _ := t.resolve(path)
+	infos, err := fs.ReadDir(p)
+
+	children := t.children(cleanPath(path))
+	if len(children) == 0 {
+		return infos, err
+	}
+
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	entries := make(map[string]os.FileInfo)
+	for _, fi := range infos {
+		entries[fi.Name()] = fi
+	}
+
+	for name := range children {
+		if fi, ok := entries[name]; !ok || !fi.IsDir() {
+			entries[name] = newDirInfo(name)
+		}
+	}
+
+	infos = make([]os.FileInfo, 0, len(entries))
+	for _, fi := range entries {
+		infos = append(infos, fi)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+
+	return infos, nil
+}
+
+func (t *Table) MkdirAll(filename string, perm os.FileMode) error {
+	fs, p, _ := t.resolve(filename)
+	return fs.MkdirAll(p, perm)
+}
+
+func (t *Table) Remove(filename string) error {
+	if t.isMountpoint(cleanPath(filename)) {
+		return &os.PathError{Op: "remove", Path: filename, Err: ErrMountPoint}
+	}
+
+	fs, p, _ := t.resolve(filename)
+	return fs.Remove(p)
+}
+
+// Rename renames the given file, or directory with all its content. Across
+// filesystems, it's copied to the new one and removed from the old one,
+// replacing only a file or an empty directory, as rename(2) does. The copy is
+// made with a temporary name, so the file replaced is kept if it fails.
+func (t *Table) Rename(from, to string) error {
+	if t.isMountpoint(cleanPath(from)) || t.isMountpoint(cleanPath(to)) {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: ErrMountPoint}
+	}
+
+	fromFS, fromPath, fromMount := t.resolve(from)
+	toFS, toPath, toMount := t.resolve(to)
+	if fromMount == toMount {
+		return fromFS.Rename(fromPath, toPath)
+	}
+
+	fi, err := fromFS.Lstat(fromPath)
+	if err != nil {
+		return err
+	}
+
+	old, err := toFS.Lstat(toPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if old != nil {
+		if err := canReplace(toFS, toPath, fi, old); err != nil {
+			return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+		}
+	}
+
+	tmp, err := tempName(toFS, toPath)
+	if err != nil {
+		return err
+	}
+
+	if err := copyTree(fromFS, fromPath, toFS, tmp, fi); err != nil {
+		util.RemoveAll(toFS, tmp)
+		return err
+	}
+
+	// not every filesystem renames a directory over an empty one
+	if old != nil && old.IsDir() {
+		if err := toFS.Remove(toPath); err != nil {
+			util.RemoveAll(toFS, tmp)
+			return err
+		}
+	}
+
+	if err := toFS.Rename(tmp, toPath); err != nil {
+		util.RemoveAll(toFS, tmp)
+		return err
+	}
+
+	return util.RemoveAll(fromFS, fromPath)
+}
+
+// canReplace returns the error of rename(2) when the file fi is renamed over
+// the existing file old at the given path, if it can't be replaced.
+func canReplace(fs billy.Filesystem, p string, fi, old os.FileInfo) error {
+	switch {
+	case fi.IsDir() && !old.IsDir():
+		return syscall.ENOTDIR
+	case !fi.IsDir() && old.IsDir():
+		return syscall.EISDIR
+	case old.IsDir():
+		infos, err := fs.ReadDir(p)
+		if err != nil {
+			return err
+		}
+
+		if len(infos) != 0 {
+			return syscall.ENOTEMPTY
+		}
+	}
+
+	return nil
+}
+
+// tempName returns a name not in use next to the given path, to copy a file
+// there before renaming it.
+func tempName(fs billy.Filesystem, p string) (string, error) {
+	f, err := util.TempFile(fs, filepath.Dir(p), "."+filepath.Base(p)+".")
+	if err != nil {
+		return "", err
+	}
+
+	name := f.Name()
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	return name, fs.Remove(name)
+}
+
+// Symlink creates a link to the given target, that must be in the same
+// filesystem. The absolute targets are made relative to its mount point.
+func (t *Table) Symlink(target, link string) error {
+	fs, p, mountpoint := t.resolve(link)
+
+	resolved := target
+	if !filepath.IsAbs(target) {
+		resolved = filepath.Join(filepath.Dir(cleanPath(link)), target)
+	}
+
+	if _, _, m := t.resolve(resolved); m != mountpoint {
+		return fmt.Errorf("invalid symlink, target is crossing filesystems")
+	}
+
+	if filepath.IsAbs(target) {
+		rel, _ := filepath.Rel(mountpoint, cleanPath(target))
+		target = filepath.Join(separator, rel)
+	}
+
+	return fs.Symlink(target, p)
+}
+
+// Readlink returns the target of the given link, with the absolute targets
+// made relative to the root again.
+func (t *Table) Readlink(link string) (string, error) {
+	fs, p, mountpoint := t.resolve(link)
+	target, err := fs.Readlink(p)
+	if err != nil || mountpoint == "." || !filepath.IsAbs(target) {
+		return target, err
+	}
+
+	return filepath.Join(separator, mountpoint, target), nil
+}
+
+func (t *Table) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(t, dir, prefix)
+}
+
+func (t *Table) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (t *Table) Chroot(path string) (billy.Filesystem, error) {
+	return chroot.New(t, t.Join(t.Root(), path)), nil
+}
+
+func (t *Table) Root() string {
+	return separator
+}
+
+// Capabilities implements the Capable interface, returning the capabilities
+// shared by all the filesystems mounted.
+func (t *Table) Capabilities() billy.Capability {
+	t.m.RLock()
+	defer t.m.RUnlock()
+
+	c := billy.Capabilities(t.root)
+	for _, fs := range t.mounts {
+		c &= billy.Capabilities(fs)
+	}
+
+	return c
+}
+
+// copyTree copies the given file, or directory with all its content, to
+// another filesystem.
+func copyTree(src billy.Filesystem, from string, dst billy.Filesystem, to string, fi os.FileInfo) error {
+	switch {
+	case fi.IsDir():
+		if err := dst.MkdirAll(to, fi.Mode().Perm()); err != nil {
+			return err
+		}
+
+		infos, err := src.ReadDir(from)
+		if err != nil {
+			return err
+		}
+
+		for _, fi := range infos {
+			err := copyTree(src, src.Join(from, fi.Name()), dst, dst.Join(to, fi.Name()), fi)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	case fi.Mode()&os.ModeSymlink != 0:
+		target, err := src.Readlink(from)
+		if err != nil {
+			return err
+		}
+
+		return dst.Symlink(target, to)
+	default:
+		return copyPath(src, dst, from, to)
+	}
+}
+
+// toFSPath returns the given path relative to a mount point, as given to its
+// filesystem.
+func toFSPath(p string) string {
+	if p == "." {
+		return separator
+	}
+
+	return p
+}
+
+// dirInfo is a mount point, or a directory leading to one, missing in the
+// filesystem below it.
+type dirInfo struct {
+	name string
+}
+
+func newDirInfo(name string) *dirInfo {
+	return &dirInfo{name: name}
+}
+
+func (fi *dirInfo) Name() string {
+	return fi.name
+}
+
+func (*dirInfo) Size() int64 {
+	return 0
+}
+
+func (*dirInfo) Mode() os.FileMode {
+	return os.ModeDir | 0755
+}
+
+func (*dirInfo) ModTime() time.Time {
+	return time.Time{}
+}
+
+func (*dirInfo) IsDir() bool {
+	return true
+}
+
+func (*dirInfo) Sys() interface{} {
+	return nil
+}
@@ -0,0 +1,195 @@
+package mount
+
+import (
+	"os"
+	"syscall"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&TableSuite{})
+
+type TableSuite struct {
+	test.FilesystemSuite
+	table *Table
+	root  billy.Filesystem
+	tmp   billy.Filesystem
+	cache billy.Filesystem
+}
+
+func (s *TableSuite) SetUpTest(c *C) {
+	s.root = memfs.New()
+	s.tmp = memfs.New()
+	s.cache = memfs.New()
+
+	s.table = NewTable(s.root)
+	c.Assert(s.table.Mount("/tmp", s.tmp), IsNil)
+	c.Assert(s.table.Mount("/var/cache", s.cache), IsNil)
+
+	// the suite runs in a mount point, as its tests expect an empty root.
+	fs, err := s.table.Chroot("tmp")
+	c.Assert(err, IsNil)
+	s.FilesystemSuite = test.NewFilesystemSuite(fs)
+}
+
+func (s *TableSuite) TestRouting(c *C) {
+	c.Assert(util.WriteFile(s.table, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.table, "tmp/bar", []byte("bar"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.table, "/var/cache/dir/baz", []byte("baz"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.table, "var/log", []byte("log"), 0644), IsNil)
+
+	c.Assert(test.ReadFile(c, s.root, "foo"), Equals, "foo")
+	c.Assert(test.ReadFile(c, s.tmp, "bar"), Equals, "bar")
+	c.Assert(test.ReadFile(c, s.cache, "dir/baz"), Equals, "baz")
+	c.Assert(test.ReadFile(c, s.root, "var/log"), Equals, "log")
+
+	f, err := s.table.Open("var/cache/dir/baz")
+	c.Assert(err, IsNil)
+	c.Assert(f.Name(), Equals, "var/cache/dir/baz")
+	c.Assert(f.Close(), IsNil)
+}
+
+func (s *TableSuite) TestReadDirMountPoints(c *C) {
+	c.Assert(util.WriteFile(s.root, "foo", nil, 0644), IsNil)
+	c.Assert(util.WriteFile(s.root, "tmp", nil, 0644), IsNil)
+
+	infos, err := s.table.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 3)
+	c.Assert(infos[0].Name(), Equals, "foo")
+	c.Assert(infos[1].Name(), Equals, "tmp")
+	c.Assert(infos[1].IsDir(), Equals, true)
+	c.Assert(infos[2].Name(), Equals, "var")
+	c.Assert(infos[2].IsDir(), Equals, true)
+
+	infos, err = s.table.ReadDir("var")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 1)
+	c.Assert(infos[0].Name(), Equals, "cache")
+
+	for _, name := range []string{"tmp", "var", "var/cache"} {
+		fi, err := s.table.Stat(name)
+		c.Assert(err, IsNil)
+		c.Assert(fi.IsDir(), Equals, true)
+	}
+}
+
+func (s *TableSuite) TestRenameAcrossMounts(c *C) {
+	c.Assert(util.WriteFile(s.table, "tmp/dir/foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.table, "tmp/dir/sub/bar", []byte("bar"), 0644), IsNil)
+	c.Assert(s.table.Symlink("foo", "tmp/dir/link"), IsNil)
+
+	c.Assert(s.table.Rename("tmp/dir", "var/cache/dir"), IsNil)
+	c.Assert(test.ReadFile(c, s.cache, "dir/foo"), Equals, "foo")
+	c.Assert(test.ReadFile(c, s.cache, "dir/sub/bar"), Equals, "bar")
+	c.Assert(test.ReadFile(c, s.table, "var/cache/dir/link"), Equals, "foo")
+
+	_, err := s.tmp.Stat("dir")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	c.Assert(s.table.Rename("var/cache/dir/foo", "foo"), IsNil)
+	c.Assert(test.ReadFile(c, s.root, "foo"), Equals, "foo")
+}
+
+func (s *TableSuite) TestRenameAcrossMountsOverExisting(c *C) {
+	c.Assert(util.WriteFile(s.table, "tmp/foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.table, "tmp/dir/bar", []byte("bar"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.table, "var/cache/file", []byte("file"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.table, "var/cache/full/qux", []byte("qux"), 0644), IsNil)
+	c.Assert(s.table.MkdirAll("var/cache/empty", 0755), IsNil)
+
+	err := s.table.Rename("tmp/foo", "var/cache/full")
+	c.Assert(err.(*os.LinkError).Err, Equals, syscall.EISDIR)
+
+	err = s.table.Rename("tmp/dir", "var/cache/file")
+	c.Assert(err.(*os.LinkError).Err, Equals, syscall.ENOTDIR)
+
+	err = s.table.Rename("tmp/dir", "var/cache/full")
+	c.Assert(err.(*os.LinkError).Err, Equals, syscall.ENOTEMPTY)
+
+	c.Assert(test.ReadFile(c, s.cache, "full/qux"), Equals, "qux")
+	c.Assert(test.ReadFile(c, s.cache, "file"), Equals, "file")
+
+	c.Assert(s.table.Rename("tmp/foo", "var/cache/file"), IsNil)
+	c.Assert(test.ReadFile(c, s.cache, "file"), Equals, "foo")
+
+	c.Assert(s.table.Rename("tmp/dir", "var/cache/empty"), IsNil)
+	c.Assert(test.ReadFile(c, s.cache, "empty/bar"), Equals, "bar")
+
+	infos, err := s.cache.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 3)
+}
+
+func (s *TableSuite) TestRenameAcrossMountsFailed(c *C) {
+	c.Assert(util.WriteFile(s.table, "tmp/foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.table, "var/cache/foo", []byte("cache"), 0644), IsNil)
+	c.Assert(s.table.Unmount("tmp"), IsNil)
+	c.Assert(s.table.Mount("tmp", &failingOpen{s.tmp}), IsNil)
+
+	c.Assert(s.table.Rename("tmp/foo", "var/cache/foo"), NotNil)
+	c.Assert(test.ReadFile(c, s.cache, "foo"), Equals, "cache")
+	c.Assert(test.ReadFile(c, s.tmp, "foo"), Equals, "foo")
+
+	infos, err := s.cache.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 1)
+}
+
+// failingOpen is a filesystem whose files can't be open.
+type failingOpen struct {
+	billy.Filesystem
+}
+
+func (fs *failingOpen) Open(filename string) (billy.File, error) {
+	return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrPermission}
+}
+
+func (s *TableSuite) TestMountPointBusy(c *C) {
+	err := s.table.Remove("tmp")
+	c.Assert(err.(*os.PathError).Err, Equals, ErrMountPoint)
+
+	err = s.table.Rename("tmp", "foo")
+	c.Assert(err.(*os.LinkError).Err, Equals, ErrMountPoint)
+
+	err = s.table.Mount("tmp/", memfs.New())
+	c.Assert(err.(*os.PathError).Err, Equals, ErrMountPoint)
+
+	err = s.table.Mount("/", memfs.New())
+	c.Assert(err.(*os.PathError).Err, Equals, ErrMountPoint)
+}
+
+func (s *TableSuite) TestUnmount(c *C) {
+	c.Assert(util.WriteFile(s.root, "tmp/foo", []byte("root"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.tmp, "foo", []byte("tmp"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.table, "tmp/foo"), Equals, "tmp")
+
+	c.Assert(s.table.Unmount("tmp"), IsNil)
+	c.Assert(test.ReadFile(c, s.table, "tmp/foo"), Equals, "root")
+
+	err := s.table.Unmount("tmp")
+	c.Assert(err.(*os.PathError).Err, Equals, ErrNotMounted)
+}
+
+func (s *TableSuite) TestSymlinkCrossing(c *C) {
+	c.Assert(util.WriteFile(s.table, "tmp/foo", []byte("foo"), 0644), IsNil)
+
+	c.Assert(s.table.Symlink("/tmp/foo", "tmp/dir/link"), IsNil)
+	c.Assert(test.ReadFile(c, s.table, "tmp/dir/link"), Equals, "foo")
+
+	target, err := s.table.Readlink("tmp/dir/link")
+	c.Assert(err, IsNil)
+	c.Assert(target, Equals, "/tmp/foo")
+
+	err = s.table.Symlink("../foo", "tmp/link")
+	c.Assert(err, ErrorMatches, ".*crossing filesystems")
+}
+
+func (s *TableSuite) TestCapabilities(c *C) {
+	c.Assert(s.table.Capabilities(), Equals, billy.Capabilities(s.root))
+}
@@ -0,0 +1,365 @@
+// Package notify provides a helper publishing the changes made to a
+// filesystem to subscribers in the same process, eg.: so the caches built
+// from its files are invalidated as soon as they change, without relying on
+// the notifications of the operating system.
+package notify
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+const separator = string(filepath.Separator)
+
+// Op is the kind of change of an Event.
+type Op int
+
+const (
+	// Create is a file created, or truncated, when opened.
+	Create Op = iota + 1
+	// Write is a file written.
+	Write
+	// Truncate is a file truncated.
+	Truncate
+	// Remove is a file, directory or symlink removed.
+	Remove
+	// Rename is a file, directory or symlink renamed.
+	Rename
+	// Mkdir is a directory created, with any missing parents.
+	Mkdir
+	// Symlink is a symlink created.
+	Symlink
+)
+
+func (op Op) String() string {
+	switch op {
+	case Create:
+		return "create"
+	case Write:
+		return "write"
+	case Truncate:
+		return "truncate"
+	case Remove:
+		return "remove"
+	case Rename:
+		return "rename"
+	case Mkdir:
+		return "mkdir"
+	case Symlink:
+		return "symlink"
+	default:
+		return fmt.Sprintf("op(%d)", int(op))
+	}
+}
+
+// Event is a change made through the filesystem.
+type Event struct {
+	Op Op
+	// Path is the path of the file changed, relative to the root of the
+	// Notify created by New, or the new path of a file renamed.
+	Path string
+	// OldPath is the path a file was renamed from.
+	OldPath string
+	// Size is the number of bytes written, or the size a file was truncated
+	// to.
+	Size int64
+	// Time is the time the change was made.
+	Time time.Time
+}
+
+// Options holds the configuration of a Notify.
+type Options struct {
+	// Clock returns the time of the events, time.Now if nil.
+	Clock func() time.Time
+}
+
+// Notify is a helper passing every operation through to the underlying
+// filesystem, publishing an Event to the subscribers for every change made
+// successfully, once made. The changes made to the underlying filesystem
+// directly, or by other processes, aren't noticed.
+type Notify struct {
+	billy.Filesystem
+	s *state
+	// dir is the directory the root was moved to by Chroot, prefixed to the
+	// paths of the events so every subscriber gets the same ones.
+	dir string
+}
+
+// state is the state shared by a Notify, its files and its chroots.
+type state struct {
+	clock func() time.Time
+
+	m    sync.Mutex
+	subs map[*Subscription]bool
+}
+
+// New creates a new filesystem publishing the changes made to the given one.
+func New(fs billy.Filesystem, opts Options) *Notify {
+	if opts.Clock == nil {
+		opts.Clock = time.Now
+	}
+
+	return &Notify{Filesystem: fs, s: &state{
+		clock: opts.Clock,
+		subs:  make(map[*Subscription]bool),
+	}}
+}
+
+// Subscription receives the events of the changes made to a path.
+type Subscription struct {
+	// Events receives the events, in the order the changes were made. It's
+	// closed when the subscription is closed.
+	Events <-chan Event
+
+	s      *state
+	path   string
+	events chan Event
+	ready  chan struct{}
+	done   chan struct{}
+	close  sync.Once
+
+	m     sync.Mutex
+	queue []Event
+}
+
+// Subscribe returns a subscription to the changes of the given path, and of
+// everything below it. The events are queued without bound, so the changes
+// are never delayed by the subscribers, and must be received until the
+// subscription is closed.
+func (fs *Notify) Subscribe(path string) *Subscription {
+	events := make(chan Event)
+	sub := &Subscription{
+		Events: events,
+		s:      fs.s,
+		path:   fs.path(path),
+		events: events,
+		ready:  make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+
+	fs.s.m.Lock()
+	fs.s.subs[sub] = true
+	fs.s.m.Unlock()
+
+	go sub.run()
+	return sub
+}
+
+// Close stops the subscription, discarding the events not received yet.
+func (sub *Subscription) Close() error {
+	sub.s.m.Lock()
+	delete(sub.s.subs, sub)
+	sub.s.m.Unlock()
+
+	sub.close.Do(func() { close(sub.done) })
+	return nil
+}
+
+func (sub *Subscription) push(e Event) {
+	sub.m.Lock()
+	sub.queue = append(sub.queue, e)
+	sub.m.Unlock()
+
+	select {
+	case sub.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (sub *Subscription) run() {
+	defer close(sub.events)
+
+	for {
+		sub.m.Lock()
+		queue := sub.queue
+		sub.queue = nil
+		sub.m.Unlock()
+
+		for _, e := range queue {
+			select {
+			case sub.events <- e:
+			case <-sub.done:
+				return
+			}
+		}
+
+		select {
+		case <-sub.ready:
+		case <-sub.done:
+			return
+		}
+	}
+}
+
+// matches returns if the given event is of the path subscribed to, or of any
+// of its parents removed or renamed, moving it as well.
+func (sub *Subscription) matches(e Event) bool {
+	if isBelow(e.Path, sub.path) {
+		return true
+	}
+
+	switch e.Op {
+	case Remove:
+		return isBelow(sub.path, e.Path)
+	case Rename:
+		return isBelow(sub.path, e.Path) || isBelow(e.OldPath, sub.path) ||
+			isBelow(sub.path, e.OldPath)
+	}
+
+	return false
+}
+
+// publish publishes the given event to the subscribers of its paths.
+func (s *state) publish(e Event) {
+	e.Time = s.clock()
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for sub := range s.subs {
+		if sub.matches(e) {
+			sub.push(e)
+		}
+	}
+}
+
+// path returns the given path relative to the root of the underlying
+// filesystem.
+func (fs *Notify) path(p string) string {
+	return filepath.Join(separator, fs.dir, p)
+}
+
+func (fs *Notify) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Notify) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file, publishing its creation if created or
+// truncated.
+func (fs *Notify) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	f, err := fs.Filesystem.OpenFile(filename, flag, perm)
+	if err != nil || flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) == 0 {
+		return f, err
+	}
+
+	p := fs.path(filename)
+	if flag&(os.O_CREATE|os.O_TRUNC) != 0 {
+		fs.s.publish(Event{Op: Create, Path: p})
+	}
+
+	return &file{File: f, s: fs.s, path: p}, nil
+}
+
+func (fs *Notify) TempFile(dir, prefix string) (billy.File, error) {
+	f, err := fs.Filesystem.TempFile(dir, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	p := fs.path(f.Name())
+	fs.s.publish(Event{Op: Create, Path: p})
+	return &file{File: f, s: fs.s, path: p}, nil
+}
+
+func (fs *Notify) Rename(from, to string) error {
+	if err := fs.Filesystem.Rename(from, to); err != nil {
+		return err
+	}
+
+	fs.s.publish(Event{Op: Rename, Path: fs.path(to), OldPath: fs.path(from)})
+	return nil
+}
+
+func (fs *Notify) Remove(filename string) error {
+	if err := fs.Filesystem.Remove(filename); err != nil {
+		return err
+	}
+
+	fs.s.publish(Event{Op: Remove, Path: fs.path(filename)})
+	return nil
+}
+
+func (fs *Notify) MkdirAll(filename string, perm os.FileMode) error {
+	if err := fs.Filesystem.MkdirAll(filename, perm); err != nil {
+		return err
+	}
+
+	fs.s.publish(Event{Op: Mkdir, Path: fs.path(filename)})
+	return nil
+}
+
+func (fs *Notify) Symlink(target, link string) error {
+	if err := fs.Filesystem.Symlink(target, link); err != nil {
+		return err
+	}
+
+	fs.s.publish(Event{Op: Symlink, Path: fs.path(link)})
+	return nil
+}
+
+// Chroot returns the given directory of the underlying filesystem, publishing
+// its changes to the same subscribers.
+func (fs *Notify) Chroot(path string) (billy.Filesystem, error) {
+	chroot, err := fs.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Notify{Filesystem: chroot, s: fs.s, dir: fs.path(path)}, nil
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Notify) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.Filesystem)
+}
+
+// file is a file open for writing, publishing its writes and truncates.
+type file struct {
+	billy.File
+	s    *state
+	path string
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	if n > 0 {
+		f.s.publish(Event{Op: Write, Path: f.path, Size: int64(n)})
+	}
+
+	return n, err
+}
+
+// ReadFrom copies the given reader to the file, publishing a single event,
+// so io.Copy doesn't publish one for every chunk.
+func (f *file) ReadFrom(r io.Reader) (int64, error) {
+	n, err := io.Copy(f.File, r)
+	if n > 0 {
+		f.s.publish(Event{Op: Write, Path: f.path, Size: n})
+	}
+
+	return n, err
+}
+
+func (f *file) Truncate(size int64) error {
+	if err := f.File.Truncate(size); err != nil {
+		return err
+	}
+
+	f.s.publish(Event{Op: Truncate, Path: f.path, Size: size})
+	return nil
+}
+
+// isBelow returns if p is, or is below, dir.
+func isBelow(p, dir string) bool {
+	return p == dir || dir == separator || strings.HasPrefix(p, dir+separator)
+}
@@ -0,0 +1,164 @@
+package notify
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&NotifySuite{})
+
+type NotifySuite struct {
+	test.FilesystemSuite
+	FS *Notify
+}
+
+func (s *NotifySuite) SetUpTest(c *C) {
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.FS = New(memfs.New(), Options{Clock: func() time.Time { return now }})
+	s.FilesystemSuite = test.NewFilesystemSuite(s.FS)
+}
+
+// receive returns the next n events of the given subscription.
+func receive(c *C, sub *Subscription, n int) []Event {
+	var events []Event
+	for len(events) < n {
+		select {
+		case e := <-sub.Events:
+			events = append(events, e)
+		case <-time.After(5 * time.Second):
+			c.Fatalf("timeout waiting for event %d", len(events))
+		}
+	}
+
+	return events
+}
+
+// assertNone asserts that the given subscription has no event pending.
+func assertNone(c *C, sub *Subscription) {
+	select {
+	case e := <-sub.Events:
+		c.Fatalf("unexpected event %v", e)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func (s *NotifySuite) TestEvents(c *C) {
+	sub := s.FS.Subscribe("/")
+	defer sub.Close()
+
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(s.FS.MkdirAll("dir", 0755), IsNil)
+	c.Assert(s.FS.Rename("foo", "dir/foo"), IsNil)
+	c.Assert(s.FS.Symlink("dir/foo", "link"), IsNil)
+	c.Assert(s.FS.Remove("link"), IsNil)
+
+	f, err := s.FS.OpenFile("dir/foo", os.O_RDWR, 0)
+	c.Assert(err, IsNil)
+	c.Assert(f.Truncate(1), IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	// the files open for reading and the failures aren't published
+	_, err = s.FS.Open("dir/foo")
+	c.Assert(err, IsNil)
+	c.Assert(s.FS.Remove("missing"), NotNil)
+
+	events := receive(c, sub, 7)
+	c.Assert(events[0].Op, Equals, Create)
+	c.Assert(events[0].Path, Equals, "/foo")
+	c.Assert(events[1].Op, Equals, Write)
+	c.Assert(events[1].Size, Equals, int64(3))
+	c.Assert(events[1].Time.Year(), Equals, 2018)
+	c.Assert(events[2].Op, Equals, Mkdir)
+	c.Assert(events[3].Op, Equals, Rename)
+	c.Assert(events[3].Path, Equals, "/dir/foo")
+	c.Assert(events[3].OldPath, Equals, "/foo")
+	c.Assert(events[4].Op, Equals, Symlink)
+	c.Assert(events[5].Op, Equals, Remove)
+	c.Assert(events[5].Path, Equals, "/link")
+	c.Assert(events[6].Op, Equals, Truncate)
+	c.Assert(events[6].Size, Equals, int64(1))
+	assertNone(c, sub)
+}
+
+func (s *NotifySuite) TestSubscribePath(c *C) {
+	sub := s.FS.Subscribe("dir/foo")
+	defer sub.Close()
+
+	c.Assert(util.WriteFile(s.FS, "dir/bar", []byte("bar"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "dir/foobar", []byte("bar"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "dir/foo/qux", []byte("qux"), 0644), IsNil)
+	c.Assert(s.FS.Rename("dir", "other"), IsNil)
+
+	events := receive(c, sub, 3)
+	c.Assert(events[0].Path, Equals, "/dir/foo/qux")
+	c.Assert(events[1].Op, Equals, Write)
+	c.Assert(events[2].Op, Equals, Rename)
+	c.Assert(events[2].OldPath, Equals, "/dir")
+	assertNone(c, sub)
+}
+
+func (s *NotifySuite) TestCopy(c *C) {
+	sub := s.FS.Subscribe("/")
+	defer sub.Close()
+
+	f, err := s.FS.Create("foo")
+	c.Assert(err, IsNil)
+	_, err = io.Copy(f, struct{ io.Reader }{strings.NewReader(strings.Repeat("x", 1<<16))})
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	events := receive(c, sub, 2)
+	c.Assert(events[1].Op, Equals, Write)
+	c.Assert(events[1].Size, Equals, int64(1<<16))
+	assertNone(c, sub)
+}
+
+func (s *NotifySuite) TestClose(c *C) {
+	sub := s.FS.Subscribe("/")
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(sub.Close(), IsNil)
+
+	// the events aren't queued once closed
+	for i := 0; i < 10; i++ {
+		c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+	}
+
+	n := 0
+	for range sub.Events {
+		n++
+	}
+
+	c.Assert(n <= 2, Equals, true)
+}
+
+func (s *NotifySuite) TestChroot(c *C) {
+	sub := s.FS.Subscribe("dir")
+	defer sub.Close()
+
+	chroot, err := s.FS.Chroot("dir")
+	c.Assert(err, IsNil)
+	c.Assert(chroot.MkdirAll("foo", 0755), IsNil)
+
+	chrootSub := chroot.(*Notify).Subscribe("foo")
+	defer chrootSub.Close()
+	c.Assert(s.FS.Remove("dir/foo"), IsNil)
+
+	events := receive(c, sub, 2)
+	c.Assert(events[0].Op, Equals, Mkdir)
+	c.Assert(events[0].Path, Equals, "/dir/foo")
+	c.Assert(events[1].Op, Equals, Remove)
+
+	events = receive(c, chrootSub, 1)
+	c.Assert(events[0].Path, Equals, "/dir/foo")
+}
@@ -0,0 +1,580 @@
+// Package overlay provides a helper presenting a filesystem merged from an
+// upper writable filesystem and a lower one, which is never modified, as the
+// union mounts of the operating systems, eg.: to run sandboxed builds over a
+// read-only source tree.
+package overlay
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/internal/errutil"
+	"gopkg.in/src-d/go-billy.v4/internal/fileutil"
+	"gopkg.in/src-d/go-billy.v4/internal/linkutil"
+	"gopkg.in/src-d/go-billy.v4/internal/pathutil"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+// WhiteoutDir is the directory of the upper filesystem where the whiteouts
+// are kept. It's hidden from the overlay.
+const WhiteoutDir = string(filepath.Separator) + ".whiteouts"
+
+const separator = string(filepath.Separator)
+
+var (
+	// ErrNotEmpty is returned when removing a directory with files.
+	ErrNotEmpty = billy.ErrNotEmpty
+
+	errNotDir = errors.New("not a directory")
+)
+
+// Overlay is a helper that merges an upper filesystem over a lower one. The
+// files are read from the upper filesystem if they exist there, or from the
+// lower otherwise, and every change is written to the upper: the files of the
+// lower are copied up when opened for writing, and the removed ones are
+// recorded as whiteouts, hiding them and everything below them.
+//
+// The whiteouts are empty files kept in the upper filesystem, under the
+// .whiteouts directory, mirroring the paths removed, so an overlay created
+// again over the same filesystems presents the same view.
+type Overlay struct {
+	upper billy.Filesystem
+	lower billy.Filesystem
+}
+
+// New creates a new overlay of the given upper filesystem over the lower
+// one.
+func New(upper, lower billy.Filesystem) *Overlay {
+	return &Overlay{upper: upper, lower: lower}
+}
+
+func (fs *Overlay) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Overlay) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (fs *Overlay) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	p, fi, err := fs.follow(clean(filename))
+	exists := err == nil
+	if err != nil && (!os.IsNotExist(err) || flag&os.O_CREATE == 0) {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+	}
+
+	if exists && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrExist}
+	}
+
+	if exists && fi.IsDir() {
+		return nil, fmt.Errorf("cannot open directory: %s", filename)
+	}
+
+	var f billy.File
+	switch {
+	case exists && (fs.inUpper(p) || !isWrite(flag)):
+		f, err = fs.source(p).OpenFile(p, flag, perm)
+	case exists:
+		f, err = fs.copyUp(p, fi, flag)
+	default:
+		if err := fs.checkParents(p); err != nil {
+			return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+		}
+
+		f, err = fs.upper.OpenFile(p, flag, perm)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &file{File: f, name: pathutil.Relative(filename)}, nil
+}
+
+// copyUp copies to the upper filesystem the given file of the lower, opening
+// it.
+func (fs *Overlay) copyUp(p string, fi os.FileInfo, flag int) (billy.File, error) {
+	flag |= os.O_CREATE
+	if flag&os.O_TRUNC != 0 {
+		return fs.upper.OpenFile(p, flag, fi.Mode().Perm())
+	}
+
+	if err := fileutil.Copy(fs.lower, p, fs.upper, p, fi.Mode().Perm()); err != nil {
+		return nil, err
+	}
+
+	return fs.upper.OpenFile(p, flag, fi.Mode().Perm())
+}
+
+func (fs *Overlay) Stat(filename string) (os.FileInfo, error) {
+	_, fi, err := fs.follow(clean(filename))
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: filename, Err: err}
+	}
+
+	// the name is the one of the stated file, even if it's a link.
+	return &fileInfo{FileInfo: fi, name: filepath.Base(filename)}, nil
+}
+
+func (fs *Overlay) Lstat(filename string) (os.FileInfo, error) {
+	fi, err := fs.lstat(clean(filename))
+	if err != nil {
+		return nil, &os.PathError{Op: "lstat", Path: filename, Err: err}
+	}
+
+	return fi, nil
+}
+
+func (fs *Overlay) lstat(p string) (os.FileInfo, error) {
+	if isReserved(p) {
+		return nil, os.ErrNotExist
+	}
+
+	fi, err := fs.upper.Lstat(p)
+	if !os.IsNotExist(err) {
+		return fi, errutil.Underlying(err)
+	}
+
+	if fs.hidden(p) {
+		return nil, os.ErrNotExist
+	}
+
+	fi, err = fs.lower.Lstat(p)
+	return fi, errutil.Underlying(err)
+}
+
+// follow returns the given file, following the links. The returned path is
+// the one of the target, also when the target doesn't exist.
+func (fs *Overlay) follow(p string) (string, os.FileInfo, error) {
+	var fi os.FileInfo
+	isLink := func(p string) (link bool, err error) {
+		fi, err = fs.lstat(p)
+		return err == nil && fi.Mode()&os.ModeSymlink != 0, err
+	}
+
+	p, err := linkutil.Follow(p, clean, isLink, fs.readlink)
+	if err != nil {
+		return p, nil, err
+	}
+
+	return p, fi, nil
+}
+
+// hidden returns true if the given file of the lower filesystem, or any of
+// its parents, has a whiteout.
+func (fs *Overlay) hidden(p string) bool {
+	for {
+		if fs.isWhiteout(p) {
+			return true
+		}
+
+		if p == separator {
+			return false
+		}
+
+		p = filepath.Dir(p)
+	}
+}
+
+// isWhiteout returns true if the given file has a whiteout. The directories
+// of the whiteouts only hold the ones of the files below them.
+func (fs *Overlay) isWhiteout(p string) bool {
+	fi, err := fs.upper.Lstat(filepath.Join(WhiteoutDir, p))
+	return err == nil && !fi.IsDir()
+}
+
+// whiteout records the given file of the lower filesystem as removed. The
+// whiteouts below it are merged into its own.
+func (fs *Overlay) whiteout(p string) error {
+	w := filepath.Join(WhiteoutDir, p)
+	if err := util.RemoveAll(fs.upper, w); err != nil {
+		return err
+	}
+
+	if err := fs.upper.MkdirAll(filepath.Dir(w), 0755); err != nil {
+		return err
+	}
+
+	return util.WriteFile(fs.upper, w, nil, 0644)
+}
+
+// Whiteouts returns the absolute paths of the files of the lower filesystem
+// removed, sorted. The files below them aren't included.
+func (fs *Overlay) Whiteouts() ([]string, error) {
+	var paths []string
+	var walk func(w string) error
+	walk = func(w string) error {
+		infos, err := fs.upper.ReadDir(w)
+		if err != nil {
+			return err
+		}
+
+		for _, fi := range infos {
+			child := filepath.Join(w, fi.Name())
+			if !fi.IsDir() {
+				paths = append(paths, strings.TrimPrefix(child, WhiteoutDir))
+				continue
+			}
+
+			if err := walk(child); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if _, err := fs.upper.Lstat(WhiteoutDir); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err := walk(WhiteoutDir); err != nil {
+		return nil, err
+	}
+
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func (fs *Overlay) inUpper(p string) bool {
+	_, err := fs.upper.Lstat(p)
+	return err == nil
+}
+
+// source returns the filesystem where the given file lives.
+func (fs *Overlay) source(p string) billy.Filesystem {
+	if fs.inUpper(p) {
+		return fs.upper
+	}
+
+	return fs.lower
+}
+
+// checkParents returns an error if the given file can't be created in the
+// upper filesystem: if it's reserved, or any of its parents is not a
+// directory, since they would be created as directories, hiding the files of
+// the lower.
+func (fs *Overlay) checkParents(p string) error {
+	if isReserved(p) {
+		return os.ErrPermission
+	}
+
+	for dir := filepath.Dir(p); dir != separator; dir = filepath.Dir(dir) {
+		_, fi, err := fs.follow(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if !fi.IsDir() {
+			return errNotDir
+		}
+	}
+
+	return nil
+}
+
+func (fs *Overlay) ReadDir(path string) ([]os.FileInfo, error) {
+	infos, err := fs.readDir(clean(path))
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: path, Err: err}
+	}
+
+	return infos, nil
+}
+
+func (fs *Overlay) readDir(p string) ([]os.FileInfo, error) {
+	p, fi, err := fs.follow(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if !fi.IsDir() {
+		return nil, errNotDir
+	}
+
+	entries := make(map[string]os.FileInfo)
+	if fs.inUpper(p) {
+		infos, err := fs.upper.ReadDir(p)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, fi := range infos {
+			entries[fi.Name()] = fi
+		}
+	}
+
+	if !fs.hidden(p) {
+		infos, err := fs.lower.ReadDir(p)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		for _, fi := range infos {
+			if _, ok := entries[fi.Name()]; ok || fs.isWhiteout(filepath.Join(p, fi.Name())) {
+				continue
+			}
+
+			entries[fi.Name()] = fi
+		}
+	}
+
+	if p == separator {
+		delete(entries, filepath.Base(WhiteoutDir))
+	}
+
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, fi := range entries {
+		infos = append(infos, fi)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+
+	return infos, nil
+}
+
+func (fs *Overlay) MkdirAll(filename string, perm os.FileMode) error {
+	p := clean(filename)
+	_, fi, err := fs.follow(p)
+	if err == nil {
+		if !fi.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: filename, Err: errNotDir}
+		}
+
+		return nil
+	}
+
+	if err := fs.checkParents(p); err != nil {
+		return &os.PathError{Op: "mkdir", Path: filename, Err: err}
+	}
+
+	return fs.upper.MkdirAll(p, perm)
+}
+
+func (fs *Overlay) Remove(filename string) error {
+	if err := fs.remove(clean(filename)); err != nil {
+		return &os.PathError{Op: "remove", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Overlay) remove(p string) error {
+	fi, err := fs.lstat(p)
+	if err != nil {
+		return err
+	}
+
+	if fi.IsDir() {
+		infos, err := fs.readDir(p)
+		if err != nil {
+			return err
+		}
+
+		if len(infos) != 0 {
+			return ErrNotEmpty
+		}
+	}
+
+	return fs.removeAll(p)
+}
+
+// removeAll removes the given file, and everything below it, from the upper
+// filesystem, and records a whiteout if it's visible in the lower.
+func (fs *Overlay) removeAll(p string) error {
+	if err := util.RemoveAll(fs.upper, p); err != nil {
+		return err
+	}
+
+	if fs.hidden(p) {
+		return nil
+	}
+
+	if _, err := fs.lower.Lstat(p); err != nil {
+		return nil
+	}
+
+	return fs.whiteout(p)
+}
+
+// Rename copies the given file, or directory with all its content, to the
+// upper filesystem with the new name, and removes the old one.
+func (fs *Overlay) Rename(from, to string) error {
+	if err := fs.rename(clean(from), clean(to)); err != nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Overlay) rename(from, to string) error {
+	fi, err := fs.lstat(from)
+	if err != nil {
+		return err
+	}
+
+	if from == to {
+		return nil
+	}
+
+	if strings.HasPrefix(to, from+separator) {
+		return errors.New("cannot move a directory into itself")
+	}
+
+	if dst, err := fs.lstat(to); err == nil {
+		if dst.IsDir() != fi.IsDir() {
+			return os.ErrExist
+		}
+
+		if err := fs.remove(to); err != nil {
+			return err
+		}
+	}
+
+	if err := fs.checkParents(to); err != nil {
+		return err
+	}
+
+	if err := fs.copyTree(from, to, fi); err != nil {
+		return err
+	}
+
+	return fs.removeAll(from)
+}
+
+// copyTree copies to the upper filesystem the given file, or directory with
+// all its content, as seen in the overlay.
+func (fs *Overlay) copyTree(from, to string, fi os.FileInfo) error {
+	switch {
+	case fi.IsDir():
+		if err := fs.upper.MkdirAll(to, fi.Mode().Perm()); err != nil {
+			return err
+		}
+
+		infos, err := fs.readDir(from)
+		if err != nil {
+			return err
+		}
+
+		for _, fi := range infos {
+			err := fs.copyTree(filepath.Join(from, fi.Name()), filepath.Join(to, fi.Name()), fi)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	case fi.Mode()&os.ModeSymlink != 0:
+		target, err := fs.readlink(from)
+		if err != nil {
+			return err
+		}
+
+		return fs.upper.Symlink(target, to)
+	default:
+		return fileutil.Copy(fs.source(from), from, fs.upper, to, fi.Mode().Perm())
+	}
+}
+
+func (fs *Overlay) Symlink(target, link string) error {
+	p := clean(link)
+	_, err := fs.lstat(p)
+	if err == nil {
+		err = os.ErrExist
+	} else if os.IsNotExist(err) {
+		err = fs.checkParents(p)
+	}
+
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: err}
+	}
+
+	return fs.upper.Symlink(target, p)
+}
+
+func (fs *Overlay) Readlink(link string) (string, error) {
+	target, err := fs.readlink(clean(link))
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: link, Err: err}
+	}
+
+	return target, nil
+}
+
+func (fs *Overlay) readlink(p string) (string, error) {
+	if isReserved(p) || !fs.inUpper(p) && fs.hidden(p) {
+		return "", os.ErrNotExist
+	}
+
+	target, err := fs.source(p).Readlink(p)
+	return target, errutil.Underlying(err)
+}
+
+func (fs *Overlay) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(fs, dir, prefix)
+}
+
+func (fs *Overlay) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (fs *Overlay) Chroot(path string) (billy.Filesystem, error) {
+	return chroot.New(fs, fs.Join(fs.Root(), path)), nil
+}
+
+func (fs *Overlay) Root() string {
+	return separator
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Overlay) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.upper)
+}
+
+type file struct {
+	billy.File
+	name string
+}
+
+func (f *file) Name() string {
+	return f.name
+}
+
+type fileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+// clean returns the given path as an absolute clean path.
+func clean(p string) string {
+	return filepath.Join(separator, p)
+}
+
+// isReserved returns true if the given clean path is the directory of the
+// whiteouts, or is below it.
+func isReserved(p string) bool {
+	return p == WhiteoutDir || strings.HasPrefix(p, WhiteoutDir+separator)
+}
+
+// isWrite returns true if opening a file with the given flag may modify it,
+// so a file of the lower must be copied up first.
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR|os.O_TRUNC|os.O_CREATE|os.O_APPEND) != 0
+}
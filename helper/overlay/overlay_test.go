@@ -0,0 +1,198 @@
+package overlay
+
+import (
+	"os"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/internal/linkutil"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&OverlaySuite{})
+
+type OverlaySuite struct {
+	test.FilesystemSuite
+	upper billy.Filesystem
+	lower billy.Filesystem
+}
+
+func (s *OverlaySuite) SetUpTest(c *C) {
+	s.upper = memfs.New()
+	s.lower = memfs.New()
+	s.FilesystemSuite = test.NewFilesystemSuite(New(s.upper, s.lower))
+}
+
+func (s *OverlaySuite) TestLowerUntouched(c *C) {
+	c.Assert(util.WriteFile(s.lower, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.lower, "bar/baz", []byte("baz"), 0644), IsNil)
+
+	f, err := s.FS.OpenFile("foo", os.O_WRONLY|os.O_APPEND, 0)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("bar"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	c.Assert(s.FS.Remove("bar/baz"), IsNil)
+	c.Assert(util.WriteFile(s.FS, "qux", []byte("qux"), 0644), IsNil)
+
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "foobar")
+	c.Assert(test.ReadFile(c, s.lower, "foo"), Equals, "foo")
+	c.Assert(test.ReadFile(c, s.lower, "bar/baz"), Equals, "baz")
+	c.Assert(test.ReadFile(c, s.upper, "foo"), Equals, "foobar")
+	c.Assert(test.ReadFile(c, s.upper, "qux"), Equals, "qux")
+
+	_, err = s.FS.Stat("bar/baz")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	_, err = s.lower.Stat("qux")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *OverlaySuite) TestCopyUpOnWriteOnly(c *C) {
+	c.Assert(util.WriteFile(s.lower, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "foo")
+
+	_, err := s.upper.Stat("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	f, err := s.FS.OpenFile("foo", os.O_RDWR, 0)
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	fi, err := s.upper.Stat("foo")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode().Perm(), Equals, os.FileMode(0644))
+}
+
+func (s *OverlaySuite) TestTruncateReadOnly(c *C) {
+	c.Assert(util.WriteFile(s.lower, "foo", []byte("foo"), 0644), IsNil)
+
+	f, err := s.FS.OpenFile("foo", os.O_RDONLY|os.O_TRUNC, 0)
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	c.Assert(test.ReadFile(c, s.lower, "foo"), Equals, "foo")
+	_, err = s.upper.Stat("foo")
+	c.Assert(err, IsNil)
+}
+
+func (s *OverlaySuite) TestReadDirMerged(c *C) {
+	c.Assert(util.WriteFile(s.lower, "dir/a", nil, 0644), IsNil)
+	c.Assert(util.WriteFile(s.lower, "dir/b", nil, 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "dir/c", nil, 0644), IsNil)
+	c.Assert(s.FS.Remove("dir/a"), IsNil)
+
+	infos, err := s.FS.ReadDir("dir")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 2)
+	c.Assert(infos[0].Name(), Equals, "b")
+	c.Assert(infos[1].Name(), Equals, "c")
+}
+
+func (s *OverlaySuite) TestRemoveAndRecreateDir(c *C) {
+	c.Assert(util.WriteFile(s.lower, "dir/a", nil, 0644), IsNil)
+	c.Assert(util.WriteFile(s.lower, "dir/b", nil, 0644), IsNil)
+	c.Assert(s.FS.Remove("dir/a"), IsNil)
+	c.Assert(util.RemoveAll(s.FS, "dir"), IsNil)
+	c.Assert(util.WriteFile(s.FS, "dir/b", nil, 0644), IsNil)
+	c.Assert(s.FS.Remove("dir/b"), IsNil)
+
+	infos, err := s.FS.ReadDir("dir")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 0)
+}
+
+func (s *OverlaySuite) TestWhiteoutsPersisted(c *C) {
+	c.Assert(util.WriteFile(s.lower, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.lower, "dir/bar", []byte("bar"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.lower, "baz", []byte("baz"), 0644), IsNil)
+
+	c.Assert(s.FS.Remove("foo"), IsNil)
+	c.Assert(util.RemoveAll(s.FS, "dir"), IsNil)
+	c.Assert(s.FS.Rename("baz", "qux"), IsNil)
+
+	fs := New(s.upper, s.lower)
+	whiteouts, err := fs.Whiteouts()
+	c.Assert(err, IsNil)
+	c.Assert(whiteouts, DeepEquals, []string{"/baz", "/dir", "/foo"})
+
+	for _, name := range []string{"foo", "dir", "dir/bar", "baz"} {
+		_, err := fs.Lstat(name)
+		c.Assert(os.IsNotExist(err), Equals, true)
+	}
+
+	c.Assert(test.ReadFile(c, fs, "qux"), Equals, "baz")
+
+	infos, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 1)
+	c.Assert(infos[0].Name(), Equals, "qux")
+}
+
+func (s *OverlaySuite) TestWhiteoutDirReserved(c *C) {
+	c.Assert(util.WriteFile(s.lower, "foo", nil, 0644), IsNil)
+	c.Assert(s.FS.Remove("foo"), IsNil)
+
+	_, err := s.FS.Stat(".whiteouts/foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	_, err = s.FS.Create(".whiteouts/bar")
+	c.Assert(os.IsPermission(err), Equals, true)
+
+	err = s.FS.MkdirAll(".whiteouts", 0755)
+	c.Assert(os.IsPermission(err), Equals, true)
+}
+
+func (s *OverlaySuite) TestRenameDir(c *C) {
+	c.Assert(util.WriteFile(s.lower, "dir/a", []byte("a"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.lower, "dir/sub/b", []byte("b"), 0644), IsNil)
+	c.Assert(s.FS.Rename("dir", "new"), IsNil)
+
+	c.Assert(test.ReadFile(c, s.FS, "new/sub/b"), Equals, "b")
+	_, err := s.FS.Stat("dir")
+	c.Assert(os.IsNotExist(err), Equals, true)
+	c.Assert(test.ReadFile(c, s.lower, "dir/sub/b"), Equals, "b")
+}
+
+func (s *OverlaySuite) TestCreateOverFile(c *C) {
+	c.Assert(util.WriteFile(s.lower, "foo", nil, 0644), IsNil)
+
+	err := util.WriteFile(s.FS, "foo/bar", nil, 0644)
+	c.Assert(err, NotNil)
+}
+
+func (s *OverlaySuite) TestNotExistInLower(c *C) {
+	fs := New(memfs.New(), osfs.New(c.MkDir()))
+
+	_, err := fs.Stat("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	_, err = fs.Readlink("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	err = fs.Remove("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *OverlaySuite) TestRemoveNotEmpty(c *C) {
+	c.Assert(util.WriteFile(s.lower, "foo/bar", nil, 0644), IsNil)
+
+	err := s.FS.Remove("foo")
+	c.Assert(err.(*os.PathError).Err, Equals, ErrNotEmpty)
+}
+
+func (s *OverlaySuite) TestSymlinkLoop(c *C) {
+	c.Assert(s.lower.Symlink("bar", "foo"), IsNil)
+	c.Assert(s.FS.Symlink("foo", "bar"), IsNil)
+
+	_, err := s.FS.Stat("foo")
+	c.Assert(err.(*os.PathError).Err, Equals, linkutil.ErrTooManyLinks)
+}
@@ -0,0 +1,460 @@
+// Package permfs provides a helper enforcing the permissions of the files of
+// a filesystem for a given user, eg.: to test the handling of the access
+// denied on filesystems not enforcing them, as memfs.
+package permfs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+const separator = string(filepath.Separator)
+
+// Access is a set of permissions, as the bits of the modes of the files.
+type Access uint8
+
+// The permissions: reading, writing and executing a file, or listing,
+// changing and searching a directory.
+const (
+	Execute Access = 1 << iota
+	Write
+	Read
+)
+
+// Rule is an entry of an access control list, granting or denying some
+// permissions to some users of the paths matching a pattern.
+type Rule struct {
+	// Path is the pattern, as in filepath.Match, matched with the whole path
+	// relative to the root, without the leading separator, eg.: "src/*.go".
+	// A pattern matching a directory matches everything below it.
+	Path string
+	// Uids and Gids are the users, and the groups of users, the rule
+	// applies to, everyone if both empty.
+	Uids []int
+	Gids []int
+	// Allow are the permissions granted, besides the ones of the mode.
+	Allow Access
+	// Deny are the permissions denied, even if granted.
+	Deny Access
+}
+
+// Options holds the configuration of a PermFS.
+type Options struct {
+	// Uid and Gid are the user, and its primary group, accessing the files.
+	// The user 0 is granted every permission not denied by the rules.
+	Uid int
+	Gid int
+	// Groups are the supplementary groups of the user.
+	Groups []int
+	// Owner returns the user and the group owning the given file, relative
+	// to the root. If nil, they are taken from the Uid and Gid fields of the
+	// value returned by Sys, if any, as for osfs, and otherwise every file is
+	// owned by the user.
+	Owner func(path string, fi os.FileInfo) (uid, gid int)
+	// Rules are the access control list, checked besides the modes.
+	Rules []Rule
+}
+
+// PermFS is a helper checking the modes of the files of the underlying
+// filesystem, and the rules given, before every operation, failing with
+// syscall.EACCES, as the error of an *os.PathError or an *os.LinkError, if
+// the user isn't allowed to make it, following POSIX: every directory
+// leading to a path must be searchable, the files read and written must be
+// readable and writable, and the directories whose entries are created,
+// removed or renamed must be writable and searchable, and if sticky, the
+// entries owned by the user, unless owning the directory.
+//
+// The directories leading to a path are checked following the links, but
+// the ones leading to their targets aren't. Nothing is checked once open.
+type PermFS struct {
+	billy.Filesystem
+	s *state
+	// dir is the directory the root was moved to by Chroot, as the permissions
+	// are checked with the paths from the original root.
+	dir string
+}
+
+// state is the state shared by a PermFS and its chroots.
+type state struct {
+	fs   billy.Filesystem
+	opts Options
+	// root is true if the user is allowed everything, being root without
+	// rules, so nothing is checked.
+	root bool
+}
+
+// New creates a new filesystem enforcing the permissions of the given one
+// for the user of the options, failing if any pattern is malformed.
+func New(fs billy.Filesystem, opts Options) (*PermFS, error) {
+	for _, r := range opts.Rules {
+		if _, err := filepath.Match(r.Path, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	root := opts.Uid == 0 && len(opts.Rules) == 0
+	return &PermFS{Filesystem: fs, s: &state{fs: fs, opts: opts, root: root}}, nil
+}
+
+// owner returns the owner of the given file.
+func (s *state) owner(p string, fi os.FileInfo) (uid, gid int) {
+	if s.opts.Owner != nil {
+		return s.opts.Owner(p, fi)
+	}
+
+	v := reflect.ValueOf(fi.Sys())
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+
+	if v.Kind() == reflect.Struct {
+		u, g := v.FieldByName("Uid"), v.FieldByName("Gid")
+		if isUint(u) && isUint(g) {
+			return int(u.Uint()), int(g.Uint())
+		}
+	}
+
+	return s.opts.Uid, s.opts.Gid
+}
+
+func isUint(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+
+	return false
+}
+
+func (s *state) inGroup(gid int) bool {
+	if gid == s.opts.Gid {
+		return true
+	}
+
+	for _, g := range s.opts.Groups {
+		if g == gid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowed returns whether the user has the given permissions on the given
+// file, relative to the root.
+func (s *state) allowed(p string, fi os.FileInfo, want Access) bool {
+	var granted Access
+	uid, gid := s.owner(p, fi)
+	mode := fi.Mode().Perm()
+	switch {
+	case s.opts.Uid == 0:
+		granted = Read | Write | Execute
+	case s.opts.Uid == uid:
+		granted = Access(mode >> 6)
+	case s.inGroup(gid):
+		granted = Access(mode >> 3)
+	default:
+		granted = Access(mode)
+	}
+
+	allow, deny := s.rules(p)
+	granted = (granted | allow) & (Read | Write | Execute)
+	return want&^granted == 0 && want&deny == 0
+}
+
+// rules returns the permissions granted and denied by the rules matching the
+// given path and the user.
+func (s *state) rules(p string) (allow, deny Access) {
+	p = strings.TrimPrefix(p, separator)
+	for _, r := range s.opts.Rules {
+		if !s.applies(r) || !match(r.Path, p) {
+			continue
+		}
+
+		allow |= r.Allow
+		deny |= r.Deny
+	}
+
+	return allow, deny
+}
+
+func (s *state) applies(r Rule) bool {
+	if len(r.Uids) == 0 && len(r.Gids) == 0 {
+		return true
+	}
+
+	for _, uid := range r.Uids {
+		if uid == s.opts.Uid {
+			return true
+		}
+	}
+
+	for _, gid := range r.Gids {
+		if s.inGroup(gid) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// match returns whether the given path, or any of its parents, matches the
+// given pattern.
+func match(pattern, p string) bool {
+	for ; p != "." && p != separator && p != ""; p = filepath.Dir(p) {
+		if ok, _ := filepath.Match(pattern, p); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// search returns whether every directory leading to the given path, relative
+// to the root, can be searched. The ones missing aren't checked.
+func (s *state) search(p string) bool {
+	if s.root {
+		return true
+	}
+
+	for _, dir := range parents(p) {
+		fi, err := s.fs.Stat(dir)
+		if err != nil {
+			continue
+		}
+
+		if !s.allowed(dir, fi, Execute) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parents returns the directories leading to the given path, from the root.
+func parents(p string) []string {
+	if p == separator {
+		return nil
+	}
+
+	var dirs []string
+	for dir := filepath.Dir(p); ; dir = filepath.Dir(dir) {
+		dirs = append([]string{dir}, dirs...)
+		if dir == separator {
+			return dirs
+		}
+	}
+}
+
+// check returns whether the given path, relative to the root, can be reached,
+// and if it exists, the user has the given permissions on it, following the
+// links.
+func (s *state) check(p string, want Access) bool {
+	if s.root {
+		return true
+	}
+
+	if !s.search(p) {
+		return false
+	}
+
+	fi, err := s.fs.Stat(p)
+	return err != nil || s.allowed(p, fi, want)
+}
+
+// change returns whether the entry of the given path, relative to the root,
+// can be created, removed or renamed.
+func (s *state) change(p string) bool {
+	if s.root {
+		return true
+	}
+
+	dir := filepath.Dir(p)
+	if !s.check(dir, Write|Execute) {
+		return false
+	}
+
+	parent, err := s.fs.Stat(dir)
+	if err != nil || parent.Mode()&os.ModeSticky == 0 || s.opts.Uid == 0 {
+		return true
+	}
+
+	fi, err := s.fs.Lstat(p)
+	if err != nil {
+		return true
+	}
+
+	uid, _ := s.owner(p, fi)
+	puid, _ := s.owner(dir, parent)
+	return uid == s.opts.Uid || puid == s.opts.Uid
+}
+
+// path returns the given path relative to the root of the underlying
+// filesystem.
+func (fs *PermFS) path(p string) string {
+	return filepath.Join(separator, fs.dir, p)
+}
+
+func denied(op, path string) error {
+	return &os.PathError{Op: op, Path: path, Err: syscall.EACCES}
+}
+
+func (fs *PermFS) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *PermFS) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file, if it can be read or written as the flags
+// ask, or if missing and created, its directory can be changed.
+func (fs *PermFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	p := fs.path(filename)
+	if fs.s.root {
+		return fs.Filesystem.OpenFile(filename, flag, perm)
+	}
+
+	if !fs.s.search(p) {
+		return nil, denied("open", filename)
+	}
+
+	fi, err := fs.s.fs.Stat(p)
+	switch {
+	case err == nil && !fs.s.allowed(p, fi, access(flag)):
+		return nil, denied("open", filename)
+	case os.IsNotExist(err) && flag&os.O_CREATE != 0 && !fs.s.change(p):
+		return nil, denied("open", filename)
+	}
+
+	return fs.Filesystem.OpenFile(filename, flag, perm)
+}
+
+// access returns the permissions needed to open a file with the given flags.
+func access(flag int) Access {
+	var want Access
+	if flag&os.O_WRONLY == 0 {
+		want |= Read
+	}
+
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_TRUNC|os.O_APPEND) != 0 {
+		want |= Write
+	}
+
+	return want
+}
+
+func (fs *PermFS) TempFile(dir, prefix string) (billy.File, error) {
+	if !fs.s.check(fs.path(dir), Write|Execute) {
+		return nil, denied("open", dir)
+	}
+
+	return fs.Filesystem.TempFile(dir, prefix)
+}
+
+func (fs *PermFS) Stat(filename string) (os.FileInfo, error) {
+	if !fs.s.search(fs.path(filename)) {
+		return nil, denied("stat", filename)
+	}
+
+	return fs.Filesystem.Stat(filename)
+}
+
+func (fs *PermFS) Lstat(filename string) (os.FileInfo, error) {
+	if !fs.s.search(fs.path(filename)) {
+		return nil, denied("lstat", filename)
+	}
+
+	return fs.Filesystem.Lstat(filename)
+}
+
+// ReadDir reads the given directory, if it can be read.
+func (fs *PermFS) ReadDir(path string) ([]os.FileInfo, error) {
+	if !fs.s.check(fs.path(path), Read) {
+		return nil, denied("readdir", path)
+	}
+
+	return fs.Filesystem.ReadDir(path)
+}
+
+func (fs *PermFS) Readlink(link string) (string, error) {
+	if !fs.s.search(fs.path(link)) {
+		return "", denied("readlink", link)
+	}
+
+	return fs.Filesystem.Readlink(link)
+}
+
+func (fs *PermFS) Rename(from, to string) error {
+	if !fs.s.change(fs.path(from)) || !fs.s.change(fs.path(to)) {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: syscall.EACCES}
+	}
+
+	return fs.Filesystem.Rename(from, to)
+}
+
+func (fs *PermFS) Remove(filename string) error {
+	if !fs.s.change(fs.path(filename)) {
+		return denied("remove", filename)
+	}
+
+	return fs.Filesystem.Remove(filename)
+}
+
+// MkdirAll creates the given directory, if the deepest one existing leading to
+// it can be changed.
+func (fs *PermFS) MkdirAll(filename string, perm os.FileMode) error {
+	p := fs.path(filename)
+	for dir := p; ; dir = filepath.Dir(dir) {
+		if _, err := fs.s.fs.Stat(dir); err == nil {
+			if dir != p && !fs.s.check(dir, Write|Execute) {
+				return denied("mkdir", filename)
+			}
+
+			break
+		}
+
+		if dir == separator {
+			break
+		}
+	}
+
+	if !fs.s.search(p) {
+		return denied("mkdir", filename)
+	}
+
+	return fs.Filesystem.MkdirAll(filename, perm)
+}
+
+func (fs *PermFS) Symlink(target, link string) error {
+	if !fs.s.change(fs.path(link)) {
+		return denied("symlink", link)
+	}
+
+	return fs.Filesystem.Symlink(target, link)
+}
+
+// Chroot returns the given directory of the underlying filesystem, if it can
+// be searched, enforcing the permissions in it too.
+func (fs *PermFS) Chroot(path string) (billy.Filesystem, error) {
+	if !fs.s.check(fs.path(path), Execute) {
+		return nil, denied("chroot", path)
+	}
+
+	chroot, err := fs.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PermFS{Filesystem: chroot, s: fs.s, dir: fs.path(path)}, nil
+}
+
+// Capabilities implements the Capable interface.
+func (fs *PermFS) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.Filesystem)
+}
@@ -0,0 +1,216 @@
+package permfs
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&PermFSSuite{})
+
+type PermFSSuite struct {
+	test.FilesystemSuite
+	underlying billy.Filesystem
+	owners     map[string]int
+}
+
+func (s *PermFSSuite) SetUpTest(c *C) {
+	// memfs creates the missing parents of the files with their mode, so
+	// osfs is used, owned by the user running the tests
+	fs, err := New(osfs.New(c.MkDir()), Options{Uid: os.Getuid(), Gid: os.Getgid()})
+	c.Assert(err, IsNil)
+	s.FilesystemSuite = test.NewFilesystemSuite(fs)
+
+	s.underlying = memfs.New()
+	s.owners = make(map[string]int)
+	c.Assert(s.underlying.MkdirAll("/", 0755), IsNil)
+}
+
+// new returns a filesystem for the given user, whose files are owned by the
+// users of owners, root if missing, and by the group of the same id.
+func (s *PermFSSuite) new(c *C, uid int, rules ...Rule) *PermFS {
+	fs, err := New(s.underlying, Options{
+		Uid: uid,
+		Gid: uid,
+		Owner: func(path string, fi os.FileInfo) (int, int) {
+			return s.owners[path], s.owners[path]
+		},
+		Rules: rules,
+	})
+
+	c.Assert(err, IsNil)
+	return fs
+}
+
+func (s *PermFSSuite) write(c *C, name string, perm os.FileMode, uid int) {
+	c.Assert(util.WriteFile(s.underlying, name, []byte("foo"), perm), IsNil)
+	s.owners["/"+name] = uid
+}
+
+func (s *PermFSSuite) mkdir(c *C, name string, perm os.FileMode, uid int) {
+	c.Assert(s.underlying.MkdirAll(name, perm), IsNil)
+	s.owners["/"+name] = uid
+}
+
+func isDenied(err error) bool {
+	switch e := err.(type) {
+	case *os.PathError:
+		return e.Err == syscall.EACCES
+	case *os.LinkError:
+		return e.Err == syscall.EACCES
+	}
+
+	return false
+}
+
+func (s *PermFSSuite) TestModes(c *C) {
+	s.write(c, "mine", 0600, 1000)
+	s.write(c, "group", 0640, 2000)
+	s.owners["/group"] = 1000
+	s.write(c, "others", 0604, 2000)
+	s.write(c, "private", 0600, 2000)
+
+	fs := s.new(c, 1000)
+	_, err := fs.OpenFile("mine", os.O_RDWR, 0)
+	c.Assert(err, IsNil)
+	_, err = fs.Open("others")
+	c.Assert(err, IsNil)
+
+	_, err = fs.OpenFile("others", os.O_WRONLY, 0)
+	c.Assert(isDenied(err), Equals, true)
+	_, err = fs.Open("private")
+	c.Assert(isDenied(err), Equals, true)
+	c.Assert(os.IsPermission(err), Equals, true)
+
+	// root is allowed everything
+	_, err = s.new(c, 0).OpenFile("private", os.O_RDWR, 0)
+	c.Assert(err, IsNil)
+}
+
+func (s *PermFSSuite) TestGroup(c *C) {
+	c.Assert(util.WriteFile(s.underlying, "foo", []byte("foo"), 0640), IsNil)
+	fs, err := New(s.underlying, Options{
+		Uid:    1000,
+		Gid:    1000,
+		Groups: []int{3000},
+		Owner: func(path string, fi os.FileInfo) (int, int) {
+			return 2000, 3000
+		},
+	})
+
+	c.Assert(err, IsNil)
+	_, err = fs.Open("foo")
+	c.Assert(err, IsNil)
+	_, err = fs.OpenFile("foo", os.O_WRONLY, 0)
+	c.Assert(isDenied(err), Equals, true)
+}
+
+func (s *PermFSSuite) TestSearch(c *C) {
+	s.mkdir(c, "dir", 0700, 2000)
+	s.write(c, "dir/foo", 0644, 1000)
+
+	fs := s.new(c, 1000)
+	_, err := fs.Stat("dir")
+	c.Assert(err, IsNil)
+	_, err = fs.Stat("dir/foo")
+	c.Assert(isDenied(err), Equals, true)
+	_, err = fs.Open("dir/foo")
+	c.Assert(isDenied(err), Equals, true)
+	_, err = fs.ReadDir("dir")
+	c.Assert(isDenied(err), Equals, true)
+
+	_, err = fs.Chroot("dir")
+	c.Assert(isDenied(err), Equals, true)
+}
+
+func (s *PermFSSuite) TestChangeDir(c *C) {
+	s.owners["/"] = 1000
+	s.mkdir(c, "dir", 0755, 2000)
+	s.write(c, "dir/foo", 0666, 1000)
+
+	fs := s.new(c, 1000)
+	_, err := fs.Create("dir/bar")
+	c.Assert(isDenied(err), Equals, true)
+	c.Assert(isDenied(fs.Remove("dir/foo")), Equals, true)
+	c.Assert(isDenied(fs.Rename("dir/foo", "foo")), Equals, true)
+	c.Assert(isDenied(fs.Symlink("foo", "dir/link")), Equals, true)
+	c.Assert(isDenied(fs.MkdirAll("dir/qux/baz", 0755)), Equals, true)
+	_, err = fs.TempFile("dir", "tmp")
+	c.Assert(isDenied(err), Equals, true)
+
+	// the files can still be written
+	f, err := fs.OpenFile("dir/foo", os.O_WRONLY|os.O_TRUNC, 0)
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	c.Assert(fs.MkdirAll("qux/baz", 0755), IsNil)
+}
+
+func (s *PermFSSuite) TestSticky(c *C) {
+	s.mkdir(c, "tmp", 0777|os.ModeSticky, 0)
+	s.write(c, "tmp/mine", 0644, 1000)
+	s.write(c, "tmp/others", 0666, 2000)
+
+	fs := s.new(c, 1000)
+	c.Assert(isDenied(fs.Remove("tmp/others")), Equals, true)
+	c.Assert(isDenied(fs.Rename("tmp/others", "tmp/bar")), Equals, true)
+	c.Assert(fs.Remove("tmp/mine"), IsNil)
+
+	_, err := fs.Create("tmp/bar")
+	c.Assert(err, IsNil)
+}
+
+func (s *PermFSSuite) TestRules(c *C) {
+	s.write(c, "foo", 0600, 2000)
+	s.mkdir(c, "secret", 0777, 1000)
+	s.write(c, "secret/bar", 0666, 1000)
+
+	fs := s.new(c, 1000,
+		Rule{Path: "foo", Uids: []int{1000}, Allow: Read},
+		Rule{Path: "secret", Deny: Read | Write},
+		Rule{Path: "secret/bar", Gids: []int{3000}, Allow: Read},
+	)
+
+	f, err := fs.Open("foo")
+	c.Assert(err, IsNil)
+	b, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(b), Equals, "foo")
+	c.Assert(f.Close(), IsNil)
+
+	_, err = fs.OpenFile("foo", os.O_WRONLY, 0)
+	c.Assert(isDenied(err), Equals, true)
+
+	// the rules denying apply below the directories matched
+	_, err = fs.Open("secret/bar")
+	c.Assert(isDenied(err), Equals, true)
+	_, err = fs.ReadDir("secret")
+	c.Assert(isDenied(err), Equals, true)
+	_, err = s.new(c, 0, Rule{Path: "secret", Deny: Read}).Open("secret/bar")
+	c.Assert(isDenied(err), Equals, true)
+
+	_, err = New(s.underlying, Options{Rules: []Rule{{Path: "["}}})
+	c.Assert(err, NotNil)
+}
+
+func (s *PermFSSuite) TestChroot(c *C) {
+	s.mkdir(c, "dir", 0755, 1000)
+	s.write(c, "dir/foo", 0600, 2000)
+
+	chroot, err := s.new(c, 1000).Chroot("dir")
+	c.Assert(err, IsNil)
+
+	_, err = chroot.Open("foo")
+	c.Assert(isDenied(err), Equals, true)
+}
@@ -0,0 +1,244 @@
+package preview
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/internal/fileutil"
+	"gopkg.in/src-d/go-billy.v4/internal/pathutil"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+// Action is the kind of change made to a file.
+type Action int
+
+const (
+	// Added means that the file doesn't exist in the base.
+	Added Action = iota + 1
+	// Modified means that the content, the mode or the target of the link
+	// differ from the base.
+	Modified
+	// Deleted means that the file was removed from the base.
+	Deleted
+)
+
+func (a Action) String() string {
+	switch a {
+	case Added:
+		return "added"
+	case Modified:
+		return "modified"
+	case Deleted:
+		return "deleted"
+	default:
+		return "unknown"
+	}
+}
+
+// Change is a change made to a file or symlink of the base. The directories
+// are not reported, as in version control systems.
+type Change struct {
+	// Path is the path of the file, relative to the root.
+	Path   string
+	Action Action
+}
+
+// Changes returns the changes made to the base, sorted by path. The files
+// written with their original content are not reported.
+func (fs *Preview) Changes() ([]Change, error) {
+	candidates := make(map[string]bool)
+	collect := func(p string, fi os.FileInfo) error {
+		if !fi.IsDir() {
+			candidates[p] = true
+		}
+
+		return nil
+	}
+
+	if err := walk(fs.upper, separator, collect); err != nil {
+		return nil, err
+	}
+
+	for p := range fs.removed {
+		if err := walk(fs.base, p, collect); err != nil {
+			return nil, err
+		}
+	}
+
+	paths := make([]string, 0, len(candidates))
+	for p := range candidates {
+		paths = append(paths, p)
+	}
+
+	sort.Strings(paths)
+
+	var changes []Change
+	for _, p := range paths {
+		action, err := fs.action(p)
+		if err != nil {
+			return nil, err
+		}
+
+		if action != 0 {
+			changes = append(changes, Change{Path: pathutil.Relative(p), Action: action})
+		}
+	}
+
+	return changes, nil
+}
+
+// action returns the change made to the given file, or zero if none.
+func (fs *Preview) action(p string) (Action, error) {
+	old, err := lstatFile(fs.base, p)
+	if err != nil {
+		return 0, err
+	}
+
+	new, err := lstatFile(fs, p)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case old == nil && new == nil:
+		return 0, nil
+	case old == nil:
+		return Added, nil
+	case new == nil:
+		return Deleted, nil
+	}
+
+	if old.Mode() != new.Mode() {
+		return Modified, nil
+	}
+
+	var equal bool
+	if old.Mode()&os.ModeSymlink != 0 {
+		var oldTarget, newTarget string
+		if oldTarget, err = fs.base.Readlink(p); err != nil {
+			return 0, err
+		}
+
+		if newTarget, err = fs.readlink(p); err != nil {
+			return 0, err
+		}
+
+		equal = oldTarget == newTarget
+	} else if equal, err = util.Equal(fs.base, p, fs, p); err != nil {
+		return 0, err
+	}
+
+	if equal {
+		return 0, nil
+	}
+
+	return Modified, nil
+}
+
+// Materialize applies the changes to the base and discards them from the
+// preview. The removed files are removed first, and then every file and
+// directory in memory is written to the base.
+func (fs *Preview) Materialize() error {
+	removed := make([]string, 0, len(fs.removed))
+	for p := range fs.removed {
+		removed = append(removed, p)
+	}
+
+	sort.Strings(removed)
+	for _, p := range removed {
+		if err := util.RemoveAll(fs.base, p); err != nil {
+			return err
+		}
+	}
+
+	err := walk(fs.upper, separator, func(p string, fi os.FileInfo) error {
+		switch {
+		case fi.IsDir():
+			return fs.base.MkdirAll(p, fi.Mode().Perm())
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := fs.upper.Readlink(p)
+			if err != nil {
+				return err
+			}
+
+			if err := fs.base.Remove(p); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+
+			return fs.base.Symlink(target, p)
+		default:
+			return fileutil.Copy(fs.upper, p, fs.base, p, fi.Mode().Perm())
+		}
+	})
+
+	if err != nil {
+		return err
+	}
+
+	fs.Reset()
+	return nil
+}
+
+// lstatFile returns the given file, or nil if it doesn't exist or it's a
+// directory.
+func lstatFile(fs billy.Filesystem, p string) (os.FileInfo, error) {
+	fi, err := fs.Lstat(p)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if fi.IsDir() {
+		return nil, nil
+	}
+
+	return fi, nil
+}
+
+// walk calls fn with every file under the given path, including itself
+// except for the root, the directories before their content. A missing path
+// is not an error.
+func walk(fs billy.Filesystem, p string, fn func(p string, fi os.FileInfo) error) error {
+	if p == separator {
+		return walkDir(fs, p, fn)
+	}
+
+	fi, err := fs.Lstat(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return walkInfo(fs, p, fi, fn)
+}
+
+func walkInfo(fs billy.Filesystem, p string, fi os.FileInfo, fn func(p string, fi os.FileInfo) error) error {
+	if err := fn(p, fi); err != nil || !fi.IsDir() {
+		return err
+	}
+
+	return walkDir(fs, p, fn)
+}
+
+func walkDir(fs billy.Filesystem, p string, fn func(p string, fi os.FileInfo) error) error {
+	infos, err := fs.ReadDir(p)
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range infos {
+		if err := walkInfo(fs, filepath.Join(p, fi.Name()), fi, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,285 @@
+package preview
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+const (
+	devNull = "/dev/null"
+	context = 3
+)
+
+// WritePatch writes the changes as a unified diff, with the paths prefixed
+// by "a/" and "b/" as git does, so it can be applied with "patch -p1" or
+// "git apply". The links are rendered as files with their target as
+// content, and the binary files, the ones containing a NUL byte, only as
+// a line saying they differ.
+func (fs *Preview) WritePatch(w io.Writer) error {
+	changes, err := fs.Changes()
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, ch := range changes {
+		p := clean(ch.Path)
+		oldName, newName := "a/"+filepath.ToSlash(ch.Path), "b/"+filepath.ToSlash(ch.Path)
+
+		var old, new []byte
+		if ch.Action == Added {
+			oldName = devNull
+		} else if old, err = content(fs.base, p); err != nil {
+			return err
+		}
+
+		if ch.Action == Deleted {
+			newName = devNull
+		} else if new, err = content(fs, p); err != nil {
+			return err
+		}
+
+		writeDiff(bw, oldName, newName, old, new)
+	}
+
+	return bw.Flush()
+}
+
+// content returns the content of the given file, or the target if it's a
+// link.
+func content(fs billy.Filesystem, p string) ([]byte, error) {
+	fi, err := fs.Lstat(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := fs.Readlink(p)
+		return []byte(target), err
+	}
+
+	f, err := fs.Open(p)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+func writeDiff(w *bufio.Writer, oldName, newName string, old, new []byte) {
+	if bytes.IndexByte(old, 0) != -1 || bytes.IndexByte(new, 0) != -1 {
+		fmt.Fprintf(w, "Binary files %s and %s differ\n", oldName, newName)
+		return
+	}
+
+	edits := diffLines(splitLines(old), splitLines(new))
+	if len(edits) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", oldName, newName)
+	for _, h := range hunks(edits) {
+		fmt.Fprintf(w, "@@ -%s +%s @@\n",
+			formatRange(h.oldStart, h.oldLines), formatRange(h.newStart, h.newLines))
+
+		for _, e := range h.edits {
+			w.WriteByte(e.op)
+			w.WriteString(e.line)
+			if !strings.HasSuffix(e.line, "\n") {
+				w.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+	}
+}
+
+// formatRange formats the range of a hunk, as the number of the first line
+// and the amount of lines, omitted if one. An empty range starts at the
+// line before it.
+func formatRange(start, lines int) string {
+	if lines == 0 {
+		start--
+	}
+
+	if lines == 1 {
+		return fmt.Sprint(start)
+	}
+
+	return fmt.Sprintf("%d,%d", start, lines)
+}
+
+// splitLines splits the given content in lines, keeping the line endings.
+func splitLines(content []byte) []string {
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+// edit is a line of a diff, the op is ' ' for the lines found in both
+// versions, '-' for the removed ones and '+' for the added ones.
+type edit struct {
+	op   byte
+	line string
+}
+
+// diffLines returns the shortest edit script turning a into b, using the
+// Myers algorithm, or nil if they are equal.
+func diffLines(a, b []string) []edit {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+
+	// trace holds the furthest points of each diagonal after every step, to
+	// backtrack the path once the end is reached.
+	var trace [][]int
+	for d := 0; d <= n+m; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+
+			v[offset+k] = x
+			if x >= n && y >= m {
+				if d == 0 {
+					return nil
+				}
+
+				return backtrack(trace, a, b, offset)
+			}
+		}
+	}
+
+	return nil
+}
+
+func backtrack(trace [][]int, a, b []string, offset int) []edit {
+	var edits []edit
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+
+		var prev int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prev = k + 1
+		} else {
+			prev = k - 1
+		}
+
+		prevX := v[offset+prev]
+		prevY := prevX - prev
+		for x > prevX && y > prevY {
+			edits = append(edits, edit{' ', a[x-1]})
+			x, y = x-1, y-1
+		}
+
+		if x == prevX {
+			edits = append(edits, edit{'+', b[y-1]})
+			y--
+		} else {
+			edits = append(edits, edit{'-', a[x-1]})
+			x--
+		}
+	}
+
+	for ; x > 0; x-- {
+		edits = append(edits, edit{' ', a[x-1]})
+	}
+
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+
+	return edits
+}
+
+type hunk struct {
+	oldStart, oldLines int
+	newStart, newLines int
+	edits              []edit
+}
+
+// hunks groups the given edits in hunks, with up to three lines of context
+// around the changes. The changes closer than twice the context are merged
+// in the same hunk.
+func hunks(edits []edit) []hunk {
+	var result []hunk
+	var oldLine, newLine int
+	advance := func(edits []edit) {
+		for _, e := range edits {
+			if e.op != '+' {
+				oldLine++
+			}
+
+			if e.op != '-' {
+				newLine++
+			}
+		}
+	}
+
+	done := 0
+	for i := 0; i < len(edits); {
+		if edits[i].op == ' ' {
+			i++
+			continue
+		}
+
+		start := i - context
+		if start < done {
+			start = done
+		}
+
+		end := i
+		for {
+			for end < len(edits) && edits[end].op != ' ' {
+				end++
+			}
+
+			next := end
+			for next < len(edits) && edits[next].op == ' ' {
+				next++
+			}
+
+			if next == len(edits) || next-end > 2*context {
+				break
+			}
+
+			end = next
+		}
+
+		stop := end + context
+		if stop > len(edits) {
+			stop = len(edits)
+		}
+
+		advance(edits[done:start])
+		h := hunk{oldStart: oldLine + 1, newStart: newLine + 1, edits: edits[start:stop]}
+		advance(h.edits)
+		h.oldLines, h.newLines = oldLine+1-h.oldStart, newLine+1-h.newStart
+
+		result = append(result, h)
+		done, i = stop, stop
+	}
+
+	return result
+}
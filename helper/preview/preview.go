@@ -0,0 +1,496 @@
+// Package preview provides a helper capturing in memory the changes made to
+// a filesystem, so they can be reviewed, rendered as a patch, and applied or
+// discarded, eg.: by editors and refactoring tools.
+package preview
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/internal/errutil"
+	"gopkg.in/src-d/go-billy.v4/internal/fileutil"
+	"gopkg.in/src-d/go-billy.v4/internal/linkutil"
+	"gopkg.in/src-d/go-billy.v4/internal/pathutil"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const separator = string(filepath.Separator)
+
+var (
+	// ErrNotEmpty is returned when removing a directory with files.
+	ErrNotEmpty = billy.ErrNotEmpty
+
+	errNotDir = errors.New("not a directory")
+)
+
+// Preview is a helper that overlays an in-memory filesystem over a base
+// filesystem, which is never modified until Materialize is called. The files
+// of the base are copied to memory when opened for writing, and the removed
+// ones are recorded, hiding them and everything below them.
+type Preview struct {
+	base    billy.Filesystem
+	upper   billy.Filesystem
+	removed map[string]bool
+}
+
+// New creates a new preview of the given base filesystem, without changes.
+func New(base billy.Filesystem) *Preview {
+	fs := &Preview{base: base}
+	fs.Reset()
+	return fs
+}
+
+// Reset discards every change made to the preview.
+func (fs *Preview) Reset() {
+	fs.upper = memfs.New()
+	fs.removed = make(map[string]bool)
+}
+
+func (fs *Preview) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Preview) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (fs *Preview) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	p, fi, err := fs.follow(clean(filename))
+	exists := err == nil
+	if err != nil && (!os.IsNotExist(err) || flag&os.O_CREATE == 0) {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+	}
+
+	if exists && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrExist}
+	}
+
+	if exists && fi.IsDir() {
+		return nil, fmt.Errorf("cannot open directory: %s", filename)
+	}
+
+	var f billy.File
+	switch {
+	case exists && (fs.inUpper(p) || !isWrite(flag)):
+		f, err = fs.source(p).OpenFile(p, flag, perm)
+	case exists:
+		f, err = fs.copyUp(p, fi, flag)
+	default:
+		if err := fs.checkParents(p); err != nil {
+			return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+		}
+
+		f, err = fs.upper.OpenFile(p, flag, perm)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &file{File: f, name: pathutil.Relative(filename)}, nil
+}
+
+// copyUp copies to memory the given file of the base, opening it.
+func (fs *Preview) copyUp(p string, fi os.FileInfo, flag int) (billy.File, error) {
+	flag |= os.O_CREATE
+	if flag&os.O_TRUNC != 0 {
+		return fs.upper.OpenFile(p, flag, fi.Mode().Perm())
+	}
+
+	if err := fileutil.Copy(fs.base, p, fs.upper, p, fi.Mode().Perm()); err != nil {
+		return nil, err
+	}
+
+	return fs.upper.OpenFile(p, flag, fi.Mode().Perm())
+}
+
+func (fs *Preview) Stat(filename string) (os.FileInfo, error) {
+	_, fi, err := fs.follow(clean(filename))
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: filename, Err: err}
+	}
+
+	// the name is the one of the stated file, even if it's a link.
+	return &fileInfo{FileInfo: fi, name: filepath.Base(filename)}, nil
+}
+
+func (fs *Preview) Lstat(filename string) (os.FileInfo, error) {
+	fi, err := fs.lstat(clean(filename))
+	if err != nil {
+		return nil, &os.PathError{Op: "lstat", Path: filename, Err: err}
+	}
+
+	return fi, nil
+}
+
+func (fs *Preview) lstat(p string) (os.FileInfo, error) {
+	fi, err := fs.upper.Lstat(p)
+	if !os.IsNotExist(err) {
+		return fi, errutil.Underlying(err)
+	}
+
+	if fs.hidden(p) {
+		return nil, os.ErrNotExist
+	}
+
+	fi, err = fs.base.Lstat(p)
+	return fi, errutil.Underlying(err)
+}
+
+// follow returns the given file, following the links. The returned path is
+// the one of the target, also when the target doesn't exist.
+func (fs *Preview) follow(p string) (string, os.FileInfo, error) {
+	var fi os.FileInfo
+	isLink := func(p string) (link bool, err error) {
+		fi, err = fs.lstat(p)
+		return err == nil && fi.Mode()&os.ModeSymlink != 0, err
+	}
+
+	p, err := linkutil.Follow(p, clean, isLink, fs.readlink)
+	if err != nil {
+		return p, nil, err
+	}
+
+	return p, fi, nil
+}
+
+// hidden returns true if the given file of the base, or any of its parents,
+// was removed.
+func (fs *Preview) hidden(p string) bool {
+	for {
+		if fs.removed[p] {
+			return true
+		}
+
+		if p == separator {
+			return false
+		}
+
+		p = filepath.Dir(p)
+	}
+}
+
+func (fs *Preview) inUpper(p string) bool {
+	_, err := fs.upper.Lstat(p)
+	return err == nil
+}
+
+// source returns the filesystem where the given file lives.
+func (fs *Preview) source(p string) billy.Filesystem {
+	if fs.inUpper(p) {
+		return fs.upper
+	}
+
+	return fs.base
+}
+
+// checkParents returns an error if any of the parents of the given file is
+// not a directory, since they would be created in memory as directories,
+// hiding the files of the base.
+func (fs *Preview) checkParents(p string) error {
+	for dir := filepath.Dir(p); dir != separator; dir = filepath.Dir(dir) {
+		_, fi, err := fs.follow(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if !fi.IsDir() {
+			return errNotDir
+		}
+	}
+
+	return nil
+}
+
+func (fs *Preview) ReadDir(path string) ([]os.FileInfo, error) {
+	infos, err := fs.readDir(clean(path))
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: path, Err: err}
+	}
+
+	return infos, nil
+}
+
+func (fs *Preview) readDir(p string) ([]os.FileInfo, error) {
+	p, fi, err := fs.follow(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if !fi.IsDir() {
+		return nil, errNotDir
+	}
+
+	entries := make(map[string]os.FileInfo)
+	if fs.inUpper(p) {
+		infos, err := fs.upper.ReadDir(p)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, fi := range infos {
+			entries[fi.Name()] = fi
+		}
+	}
+
+	if !fs.hidden(p) {
+		infos, err := fs.base.ReadDir(p)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		for _, fi := range infos {
+			if _, ok := entries[fi.Name()]; ok || fs.removed[filepath.Join(p, fi.Name())] {
+				continue
+			}
+
+			entries[fi.Name()] = fi
+		}
+	}
+
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, fi := range entries {
+		infos = append(infos, fi)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+
+	return infos, nil
+}
+
+func (fs *Preview) MkdirAll(filename string, perm os.FileMode) error {
+	p := clean(filename)
+	_, fi, err := fs.follow(p)
+	if err == nil {
+		if !fi.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: filename, Err: errNotDir}
+		}
+
+		return nil
+	}
+
+	if err := fs.checkParents(p); err != nil {
+		return &os.PathError{Op: "mkdir", Path: filename, Err: err}
+	}
+
+	return fs.upper.MkdirAll(p, perm)
+}
+
+func (fs *Preview) Remove(filename string) error {
+	if err := fs.remove(clean(filename)); err != nil {
+		return &os.PathError{Op: "remove", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Preview) remove(p string) error {
+	fi, err := fs.lstat(p)
+	if err != nil {
+		return err
+	}
+
+	if fi.IsDir() {
+		infos, err := fs.readDir(p)
+		if err != nil {
+			return err
+		}
+
+		if len(infos) != 0 {
+			return ErrNotEmpty
+		}
+	}
+
+	return fs.removeAll(p)
+}
+
+// removeAll removes the given file, and everything below it, from memory,
+// and records it as removed if it's in the base.
+func (fs *Preview) removeAll(p string) error {
+	if err := util.RemoveAll(fs.upper, p); err != nil {
+		return err
+	}
+
+	if _, err := fs.base.Lstat(p); err == nil {
+		fs.removed[p] = true
+	}
+
+	return nil
+}
+
+// Rename copies the given file, or directory with all its content, to
+// memory with the new name, and removes the old one.
+func (fs *Preview) Rename(from, to string) error {
+	if err := fs.rename(clean(from), clean(to)); err != nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Preview) rename(from, to string) error {
+	fi, err := fs.lstat(from)
+	if err != nil {
+		return err
+	}
+
+	if from == to {
+		return nil
+	}
+
+	if strings.HasPrefix(to, from+separator) {
+		return errors.New("cannot move a directory into itself")
+	}
+
+	if dst, err := fs.lstat(to); err == nil {
+		if dst.IsDir() != fi.IsDir() {
+			return os.ErrExist
+		}
+
+		if err := fs.remove(to); err != nil {
+			return err
+		}
+	}
+
+	if err := fs.checkParents(to); err != nil {
+		return err
+	}
+
+	if err := fs.copyTree(from, to, fi); err != nil {
+		return err
+	}
+
+	return fs.removeAll(from)
+}
+
+// copyTree copies to memory the given file, or directory with all its
+// content, as seen in the preview.
+func (fs *Preview) copyTree(from, to string, fi os.FileInfo) error {
+	switch {
+	case fi.IsDir():
+		if err := fs.upper.MkdirAll(to, fi.Mode().Perm()); err != nil {
+			return err
+		}
+
+		infos, err := fs.readDir(from)
+		if err != nil {
+			return err
+		}
+
+		for _, fi := range infos {
+			err := fs.copyTree(filepath.Join(from, fi.Name()), filepath.Join(to, fi.Name()), fi)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	case fi.Mode()&os.ModeSymlink != 0:
+		target, err := fs.readlink(from)
+		if err != nil {
+			return err
+		}
+
+		return fs.upper.Symlink(target, to)
+	default:
+		return fileutil.Copy(fs.source(from), from, fs.upper, to, fi.Mode().Perm())
+	}
+}
+
+func (fs *Preview) Symlink(target, link string) error {
+	p := clean(link)
+	_, err := fs.lstat(p)
+	if err == nil {
+		err = os.ErrExist
+	} else if os.IsNotExist(err) {
+		err = fs.checkParents(p)
+	}
+
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: err}
+	}
+
+	return fs.upper.Symlink(target, p)
+}
+
+func (fs *Preview) Readlink(link string) (string, error) {
+	target, err := fs.readlink(clean(link))
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: link, Err: err}
+	}
+
+	return target, nil
+}
+
+func (fs *Preview) readlink(p string) (string, error) {
+	if !fs.inUpper(p) && fs.hidden(p) {
+		return "", os.ErrNotExist
+	}
+
+	target, err := fs.source(p).Readlink(p)
+	return target, errutil.Underlying(err)
+}
+
+func (fs *Preview) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(fs, dir, prefix)
+}
+
+func (fs *Preview) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (fs *Preview) Chroot(path string) (billy.Filesystem, error) {
+	return chroot.New(fs, fs.Join(fs.Root(), path)), nil
+}
+
+func (fs *Preview) Root() string {
+	return separator
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Preview) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.upper)
+}
+
+type file struct {
+	billy.File
+	name string
+}
+
+func (f *file) Name() string {
+	return f.name
+}
+
+type fileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+// clean returns the given path as an absolute clean path, the form used to
+// record the removed files.
+func clean(p string) string {
+	return filepath.Join(separator, p)
+}
+
+// isWrite returns true if opening a file with the given flag may modify it,
+// so a file of the base must be copied up first.
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR|os.O_TRUNC|os.O_CREATE|os.O_APPEND) != 0
+}
@@ -0,0 +1,229 @@
+package preview
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&PreviewSuite{})
+
+type PreviewSuite struct {
+	test.FilesystemSuite
+	base    billy.Filesystem
+	preview *Preview
+}
+
+func (s *PreviewSuite) SetUpTest(c *C) {
+	s.base = memfs.New()
+	s.preview = New(s.base)
+	s.FilesystemSuite = test.NewFilesystemSuite(s.preview)
+}
+
+func (s *PreviewSuite) TestBaseUntouched(c *C) {
+	c.Assert(util.WriteFile(s.base, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.base, "bar/baz", []byte("baz"), 0644), IsNil)
+
+	f, err := s.FS.OpenFile("foo", os.O_WRONLY|os.O_APPEND, 0)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("bar"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	c.Assert(s.FS.Remove("bar/baz"), IsNil)
+	c.Assert(util.WriteFile(s.FS, "qux", []byte("qux"), 0644), IsNil)
+
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "foobar")
+	c.Assert(test.ReadFile(c, s.base, "foo"), Equals, "foo")
+	c.Assert(test.ReadFile(c, s.base, "bar/baz"), Equals, "baz")
+
+	_, err = s.FS.Stat("bar/baz")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	_, err = s.base.Stat("qux")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *PreviewSuite) TestTruncateReadOnly(c *C) {
+	c.Assert(util.WriteFile(s.base, "foo", []byte("foo"), 0644), IsNil)
+
+	f, err := s.FS.OpenFile("foo", os.O_RDONLY|os.O_TRUNC, 0)
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "")
+	c.Assert(test.ReadFile(c, s.base, "foo"), Equals, "foo")
+}
+
+func (s *PreviewSuite) TestReadDirMerged(c *C) {
+	c.Assert(util.WriteFile(s.base, "dir/a", nil, 0644), IsNil)
+	c.Assert(util.WriteFile(s.base, "dir/b", nil, 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "dir/c", nil, 0644), IsNil)
+	c.Assert(s.FS.Remove("dir/a"), IsNil)
+
+	infos, err := s.FS.ReadDir("dir")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 2)
+	c.Assert(infos[0].Name(), Equals, "b")
+	c.Assert(infos[1].Name(), Equals, "c")
+}
+
+func (s *PreviewSuite) TestRemoveAndRecreateDir(c *C) {
+	c.Assert(util.WriteFile(s.base, "dir/a", nil, 0644), IsNil)
+	c.Assert(util.RemoveAll(s.FS, "dir"), IsNil)
+	c.Assert(util.WriteFile(s.FS, "dir/b", nil, 0644), IsNil)
+
+	infos, err := s.FS.ReadDir("dir")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 1)
+	c.Assert(infos[0].Name(), Equals, "b")
+}
+
+func (s *PreviewSuite) TestRenameDir(c *C) {
+	c.Assert(util.WriteFile(s.base, "dir/a", []byte("a"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.base, "dir/sub/b", []byte("b"), 0644), IsNil)
+	c.Assert(s.FS.Rename("dir", "new"), IsNil)
+
+	c.Assert(test.ReadFile(c, s.FS, "new/sub/b"), Equals, "b")
+	_, err := s.FS.Stat("dir")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	changes, err := s.preview.Changes()
+	c.Assert(err, IsNil)
+	c.Assert(changes, DeepEquals, []Change{
+		{Path: "dir/a", Action: Deleted},
+		{Path: "dir/sub/b", Action: Deleted},
+		{Path: "new/a", Action: Added},
+		{Path: "new/sub/b", Action: Added},
+	})
+}
+
+func (s *PreviewSuite) TestCreateOverFile(c *C) {
+	c.Assert(util.WriteFile(s.base, "foo", nil, 0644), IsNil)
+
+	err := util.WriteFile(s.FS, "foo/bar", nil, 0644)
+	c.Assert(err, NotNil)
+}
+
+func (s *PreviewSuite) TestChanges(c *C) {
+	c.Assert(util.WriteFile(s.base, "modified", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.base, "same", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.base, "deleted", []byte("foo"), 0644), IsNil)
+	c.Assert(s.base.Symlink("same", "link"), IsNil)
+
+	c.Assert(util.WriteFile(s.FS, "modified", []byte("bar"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "same", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "added", []byte("foo"), 0644), IsNil)
+	c.Assert(s.FS.Remove("deleted"), IsNil)
+	c.Assert(s.FS.Remove("link"), IsNil)
+	c.Assert(s.FS.Symlink("modified", "link"), IsNil)
+
+	changes, err := s.preview.Changes()
+	c.Assert(err, IsNil)
+	c.Assert(changes, DeepEquals, []Change{
+		{Path: "added", Action: Added},
+		{Path: "deleted", Action: Deleted},
+		{Path: "link", Action: Modified},
+		{Path: "modified", Action: Modified},
+	})
+}
+
+func (s *PreviewSuite) TestWritePatch(c *C) {
+	c.Assert(util.WriteFile(s.base, "foo", []byte("1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.base, "bar", []byte("bar\n"), 0644), IsNil)
+
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n11\n"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "new", []byte("new"), 0644), IsNil)
+	c.Assert(s.FS.Remove("bar"), IsNil)
+
+	buf := bytes.NewBuffer(nil)
+	c.Assert(s.preview.WritePatch(buf), IsNil)
+	c.Assert(buf.String(), Equals, ""+
+		"--- a/bar\n"+
+		"+++ /dev/null\n"+
+		"@@ -1 +0,0 @@\n"+
+		"-bar\n"+
+		"--- a/foo\n"+
+		"+++ b/foo\n"+
+		"@@ -1,6 +1,6 @@\n"+
+		" 1\n"+
+		" 2\n"+
+		"-3\n"+
+		"+three\n"+
+		" 4\n"+
+		" 5\n"+
+		" 6\n"+
+		"@@ -9,4 +9,3 @@\n"+
+		" 9\n"+
+		" 10\n"+
+		" 11\n"+
+		"-12\n"+
+		"--- /dev/null\n"+
+		"+++ b/new\n"+
+		"@@ -0,0 +1 @@\n"+
+		"+new\n"+
+		"\\ No newline at end of file\n",
+	)
+}
+
+func (s *PreviewSuite) TestWritePatchBinary(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo\x00"), 0644), IsNil)
+
+	buf := bytes.NewBuffer(nil)
+	c.Assert(s.preview.WritePatch(buf), IsNil)
+	c.Assert(buf.String(), Equals, "Binary files /dev/null and b/foo differ\n")
+}
+
+func (s *PreviewSuite) TestMaterialize(c *C) {
+	c.Assert(util.WriteFile(s.base, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.base, "dir/bar", []byte("bar"), 0644), IsNil)
+
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("qux"), 0644), IsNil)
+	c.Assert(util.RemoveAll(s.FS, "dir"), IsNil)
+	c.Assert(util.WriteFile(s.FS, "dir", []byte("dir"), 0644), IsNil)
+	c.Assert(s.FS.Symlink("foo", "link"), IsNil)
+
+	c.Assert(s.preview.Materialize(), IsNil)
+	c.Assert(test.ReadFile(c, s.base, "foo"), Equals, "qux")
+	c.Assert(test.ReadFile(c, s.base, "dir"), Equals, "dir")
+	c.Assert(test.ReadFile(c, s.base, "link"), Equals, "qux")
+
+	changes, err := s.preview.Changes()
+	c.Assert(err, IsNil)
+	c.Assert(changes, HasLen, 0)
+}
+
+func (s *PreviewSuite) TestReset(c *C) {
+	c.Assert(util.WriteFile(s.base, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("bar"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "bar", []byte("bar"), 0644), IsNil)
+
+	s.preview.Reset()
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "foo")
+
+	_, err := s.FS.Stat("bar")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *PreviewSuite) TestNotExistInBase(c *C) {
+	fs := New(osfs.New(c.MkDir()))
+
+	_, err := fs.Stat("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	_, err = fs.Readlink("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	err = fs.Remove("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
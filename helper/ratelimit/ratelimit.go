@@ -0,0 +1,188 @@
+// Package ratelimit provides a helper limiting the rate of the operations
+// made to a filesystem and the bandwidth used reading and writing its files,
+// eg.: to avoid saturating a storage shared with others.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"os"
+
+	"golang.org/x/time/rate"
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// Options holds the configuration of a RateLimit. Every limit allows bursts
+// of up to a second after being idle.
+type Options struct {
+	// Ops is the maximum number of operations per second made to the
+	// filesystem, not counting the reads and writes of the files, unlimited
+	// if 0.
+	Ops float64
+	// ReadBandwidth is the maximum number of bytes per second read from the
+	// files, unlimited if 0.
+	ReadBandwidth float64
+	// WriteBandwidth is the maximum number of bytes per second written to
+	// the files, unlimited if 0.
+	WriteBandwidth float64
+}
+
+// RateLimit is a helper delaying the operations made to the underlying
+// filesystem, and the reads and writes of the files open from it, to keep
+// them within the limits given, using token buckets. The limits are shared
+// by every file and chroot.
+type RateLimit struct {
+	billy.Filesystem
+	l *limiters
+}
+
+type limiters struct {
+	ops, read, write *rate.Limiter
+}
+
+// New creates a new filesystem limiting the rate of the operations made to
+// the given one.
+func New(fs billy.Filesystem, opts Options) *RateLimit {
+	return &RateLimit{Filesystem: fs, l: &limiters{
+		ops:   newLimiter(opts.Ops),
+		read:  newLimiter(opts.ReadBandwidth),
+		write: newLimiter(opts.WriteBandwidth),
+	}}
+}
+
+func newLimiter(limit float64) *rate.Limiter {
+	if limit == 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+
+	burst := int(math.Min(math.Ceil(limit), math.MaxInt32))
+	return rate.NewLimiter(rate.Limit(limit), burst)
+}
+
+// wait waits until the given limiter allows n events, split in bursts if
+// needed.
+func wait(l *rate.Limiter, n int) {
+	if l.Limit() == rate.Inf {
+		return
+	}
+
+	for n > 0 {
+		burst := n
+		if burst > l.Burst() {
+			burst = l.Burst()
+		}
+
+		// it only fails if the burst is exceeded, or the context canceled
+		l.WaitN(context.Background(), burst)
+		n -= burst
+	}
+}
+
+func (fs *RateLimit) Create(filename string) (billy.File, error) {
+	wait(fs.l.ops, 1)
+	return fs.file(fs.Filesystem.Create(filename))
+}
+
+func (fs *RateLimit) Open(filename string) (billy.File, error) {
+	wait(fs.l.ops, 1)
+	return fs.file(fs.Filesystem.Open(filename))
+}
+
+func (fs *RateLimit) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	wait(fs.l.ops, 1)
+	return fs.file(fs.Filesystem.OpenFile(filename, flag, perm))
+}
+
+func (fs *RateLimit) TempFile(dir, prefix string) (billy.File, error) {
+	wait(fs.l.ops, 1)
+	return fs.file(fs.Filesystem.TempFile(dir, prefix))
+}
+
+func (fs *RateLimit) file(f billy.File, err error) (billy.File, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	return &file{File: f, l: fs.l}, nil
+}
+
+func (fs *RateLimit) Stat(filename string) (os.FileInfo, error) {
+	wait(fs.l.ops, 1)
+	return fs.Filesystem.Stat(filename)
+}
+
+func (fs *RateLimit) Lstat(filename string) (os.FileInfo, error) {
+	wait(fs.l.ops, 1)
+	return fs.Filesystem.Lstat(filename)
+}
+
+func (fs *RateLimit) ReadDir(path string) ([]os.FileInfo, error) {
+	wait(fs.l.ops, 1)
+	return fs.Filesystem.ReadDir(path)
+}
+
+func (fs *RateLimit) Rename(from, to string) error {
+	wait(fs.l.ops, 1)
+	return fs.Filesystem.Rename(from, to)
+}
+
+func (fs *RateLimit) Remove(filename string) error {
+	wait(fs.l.ops, 1)
+	return fs.Filesystem.Remove(filename)
+}
+
+func (fs *RateLimit) MkdirAll(filename string, perm os.FileMode) error {
+	wait(fs.l.ops, 1)
+	return fs.Filesystem.MkdirAll(filename, perm)
+}
+
+func (fs *RateLimit) Symlink(target, link string) error {
+	wait(fs.l.ops, 1)
+	return fs.Filesystem.Symlink(target, link)
+}
+
+func (fs *RateLimit) Readlink(link string) (string, error) {
+	wait(fs.l.ops, 1)
+	return fs.Filesystem.Readlink(link)
+}
+
+// Chroot returns the given directory of the underlying filesystem, sharing
+// the limits with this filesystem.
+func (fs *RateLimit) Chroot(path string) (billy.Filesystem, error) {
+	chroot, err := fs.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RateLimit{Filesystem: chroot, l: fs.l}, nil
+}
+
+// Capabilities implements the Capable interface.
+func (fs *RateLimit) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.Filesystem)
+}
+
+// file is a file limiting the bandwidth used reading and writing it. The
+// reads are delayed once done, since their size isn't known until then, and
+// the writes before being done.
+type file struct {
+	billy.File
+	l *limiters
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	wait(f.l.read, n)
+	return n, err
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(p, off)
+	wait(f.l.read, n)
+	return n, err
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	wait(f.l.write, len(p))
+	return f.File.Write(p)
+}
@@ -0,0 +1,85 @@
+package ratelimit
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&RateLimitSuite{})
+
+type RateLimitSuite struct {
+	test.FilesystemSuite
+}
+
+func (s *RateLimitSuite) SetUpTest(c *C) {
+	s.FilesystemSuite = test.NewFilesystemSuite(New(memfs.New(), Options{
+		Ops:            1e6,
+		ReadBandwidth:  1e9,
+		WriteBandwidth: 1e9,
+	}))
+}
+
+func (s *RateLimitSuite) TestOps(c *C) {
+	fs := New(memfs.New(), Options{Ops: 100})
+
+	start := time.Now()
+	for i := 0; i < 150; i++ {
+		c.Assert(fs.MkdirAll("foo", 0755), IsNil)
+	}
+
+	// the first 100 are allowed right away
+	c.Assert(time.Since(start) >= 450*time.Millisecond, Equals, true)
+}
+
+func (s *RateLimitSuite) TestReadBandwidth(c *C) {
+	fs := New(memfs.New(), Options{ReadBandwidth: 1000})
+	c.Assert(util.WriteFile(fs, "foo", make([]byte, 1500), 0644), IsNil)
+
+	start := time.Now()
+	f, err := fs.Open("foo")
+	c.Assert(err, IsNil)
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(content, HasLen, 1500)
+	c.Assert(f.Close(), IsNil)
+
+	c.Assert(time.Since(start) >= 450*time.Millisecond, Equals, true)
+}
+
+func (s *RateLimitSuite) TestWriteBandwidth(c *C) {
+	fs := New(memfs.New(), Options{WriteBandwidth: 1000})
+
+	start := time.Now()
+	c.Assert(util.WriteFile(fs, "foo", make([]byte, 1500), 0644), IsNil)
+	c.Assert(time.Since(start) >= 450*time.Millisecond, Equals, true)
+
+	// the limits are shared by the chroots
+	chroot, err := fs.Chroot("dir")
+	c.Assert(err, IsNil)
+
+	start = time.Now()
+	c.Assert(util.WriteFile(chroot, "foo", make([]byte, 500), 0644), IsNil)
+	c.Assert(time.Since(start) >= 450*time.Millisecond, Equals, true)
+}
+
+func (s *RateLimitSuite) TestUnlimited(c *C) {
+	fs := New(memfs.New(), Options{})
+
+	start := time.Now()
+	c.Assert(util.WriteFile(fs, "foo", make([]byte, 1<<20), 0644), IsNil)
+	for i := 0; i < 1000; i++ {
+		_, err := fs.Stat("foo")
+		c.Assert(err, IsNil)
+	}
+
+	c.Assert(time.Since(start) < time.Second, Equals, true)
+}
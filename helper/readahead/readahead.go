@@ -0,0 +1,539 @@
+// Package readahead provides a helper prefetching in the background the
+// content of the files read sequentially from a slow filesystem, eg.: served
+// over the network, so the latency of the reads is hidden.
+package readahead
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+const (
+	separator = string(filepath.Separator)
+
+	// DefaultChunkSize is the size of the chunks prefetched used if none is
+	// given.
+	DefaultChunkSize = 256 << 10
+	// DefaultChunks is the number of chunks prefetched ahead used if none is
+	// given.
+	DefaultChunks = 4
+	// DefaultBudget is the maximum size of the chunks prefetched used if
+	// none is given.
+	DefaultBudget = 32 << 20
+)
+
+// Options holds the configuration of a ReadAhead.
+type Options struct {
+	// ChunkSize is the size of the chunks the files are prefetched by,
+	// DefaultChunkSize if 0.
+	ChunkSize int
+	// Chunks is the number of chunks prefetched ahead of a file read
+	// sequentially, DefaultChunks if 0.
+	Chunks int
+	// Budget is the maximum size of the chunks prefetched and not read yet,
+	// of every file, DefaultBudget if 0. No chunk is prefetched once
+	// exceeded, until any is read.
+	Budget int64
+	// Siblings is the number of files of a directory read whose first chunk
+	// is prefetched, so the files are prefetched while walking a tree, none
+	// if 0.
+	Siblings int
+}
+
+// ReadAhead is a helper passing every operation through to the underlying
+// filesystem, but prefetching the chunks following the ones read from the
+// files open for reading, once read sequentially twice, using another file
+// open in the background. The chunks behind the ones read are discarded.
+//
+// The first chunks of the files of the directories read are prefetched with
+// the Siblings option, and discarded on any change made through the helper,
+// or the oldest first when the budget is exceeded. The changes made by others
+// aren't noticed, so the content read may be stale.
+type ReadAhead struct {
+	billy.Filesystem
+	s *state
+	// dir is the directory the root was moved to by Chroot, as the chunks
+	// prefetched are shared with the helper it was created from.
+	dir string
+}
+
+// state is the state shared by a ReadAhead, its files and its chroots.
+type state struct {
+	opts Options
+
+	m     sync.Mutex
+	used  int64
+	heads map[string]*chunk
+	// order are the paths of the first chunks prefetched, the oldest
+	// first.
+	order []string
+}
+
+// New creates a new filesystem prefetching the files read from the given one.
+func New(fs billy.Filesystem, opts Options) *ReadAhead {
+	if opts.ChunkSize == 0 {
+		opts.ChunkSize = DefaultChunkSize
+	}
+
+	if opts.Chunks == 0 {
+		opts.Chunks = DefaultChunks
+	}
+
+	if opts.Budget == 0 {
+		opts.Budget = DefaultBudget
+	}
+
+	return &ReadAhead{Filesystem: fs, s: &state{
+		opts:  opts,
+		heads: make(map[string]*chunk),
+	}}
+}
+
+// reserve reserves the size of a chunk from the budget, returning false if
+// exceeded.
+func (s *state) reserve() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	size := int64(s.opts.ChunkSize)
+	if s.used+size > s.opts.Budget {
+		return false
+	}
+
+	s.used += size
+	return true
+}
+
+// release releases the size of a chunk to the budget.
+func (s *state) release() {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.used -= int64(s.opts.ChunkSize)
+}
+
+// reserveHead reserves the size of a chunk from the budget, discarding the
+// oldest first chunks prefetched if exceeded, and records the given one.
+func (s *state) reserveHead(p string, c *chunk) bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	size := int64(s.opts.ChunkSize)
+	for s.used+size > s.opts.Budget {
+		if len(s.order) == 0 {
+			return false
+		}
+
+		oldest := s.order[0]
+		s.order = s.order[1:]
+		if _, ok := s.heads[oldest]; ok {
+			delete(s.heads, oldest)
+			s.used -= size
+		}
+	}
+
+	if _, ok := s.heads[p]; ok {
+		return false
+	}
+
+	if len(s.order) > 2*len(s.heads) {
+		// the paths of the chunks taken are compacted
+		order := s.order[:0]
+		for _, p := range s.order {
+			if _, ok := s.heads[p]; ok {
+				order = append(order, p)
+			}
+		}
+
+		s.order = order
+	}
+
+	s.used += size
+	s.heads[p] = c
+	s.order = append(s.order, p)
+	return true
+}
+
+// takeHead returns the first chunk prefetched of the given file, if any.
+func (s *state) takeHead(p string) *chunk {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	c := s.heads[p]
+	delete(s.heads, p)
+	return c
+}
+
+// invalidate discards the first chunks prefetched.
+func (s *state) invalidate() {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for p := range s.heads {
+		delete(s.heads, p)
+		s.used -= int64(s.opts.ChunkSize)
+	}
+
+	s.order = nil
+}
+
+// chunk is a part of a file prefetched, or being prefetched.
+type chunk struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+func newChunk() *chunk {
+	return &chunk{done: make(chan struct{})}
+}
+
+func (c *chunk) fetch(r io.ReaderAt, off int64, size int) {
+	c.data = make([]byte, size)
+	n, err := r.ReadAt(c.data, off)
+	c.data, c.err = c.data[:n], err
+	close(c.done)
+}
+
+// eof returns true if the chunk is prefetched and is the last of the file.
+func (c *chunk) eof() bool {
+	select {
+	case <-c.done:
+		return c.err == io.EOF
+	default:
+		return false
+	}
+}
+
+// path returns the given path relative to the root of the underlying
+// filesystem.
+func (fs *ReadAhead) path(p string) string {
+	return filepath.Join(separator, fs.dir, p)
+}
+
+func (fs *ReadAhead) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *ReadAhead) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file, prefetching it if open for reading.
+func (fs *ReadAhead) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if flag != os.O_RDONLY {
+		fs.s.invalidate()
+		return fs.Filesystem.OpenFile(filename, flag, perm)
+	}
+
+	f, err := fs.Filesystem.OpenFile(filename, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	rf := &file{
+		File:   f,
+		s:      fs.s,
+		fs:     fs.Filesystem,
+		name:   filename,
+		last:   -1,
+		chunks: make(map[int64]*chunk),
+	}
+
+	if c := fs.s.takeHead(fs.path(filename)); c != nil {
+		rf.chunks[0] = c
+	}
+
+	return rf, nil
+}
+
+// ReadDir reads the given directory, prefetching the first chunk of its first
+// files with the Siblings option.
+func (fs *ReadAhead) ReadDir(path string) ([]os.FileInfo, error) {
+	infos, err := fs.Filesystem.ReadDir(path)
+	if err != nil || fs.s.opts.Siblings == 0 {
+		return infos, err
+	}
+
+	n := 0
+	for _, fi := range infos {
+		if n == fs.s.opts.Siblings {
+			break
+		}
+
+		if !fi.Mode().IsRegular() || fi.Size() == 0 {
+			continue
+		}
+
+		name := filepath.Join(path, fi.Name())
+		c := newChunk()
+		if !fs.s.reserveHead(fs.path(name), c) {
+			continue
+		}
+
+		go fs.fetchHead(c, name)
+		n++
+	}
+
+	return infos, nil
+}
+
+func (fs *ReadAhead) fetchHead(c *chunk, name string) {
+	f, err := fs.Filesystem.Open(name)
+	if err != nil {
+		c.err = err
+		close(c.done)
+		return
+	}
+
+	defer f.Close()
+	c.fetch(f, 0, fs.s.opts.ChunkSize)
+}
+
+func (fs *ReadAhead) TempFile(dir, prefix string) (billy.File, error) {
+	fs.s.invalidate()
+	return fs.Filesystem.TempFile(dir, prefix)
+}
+
+func (fs *ReadAhead) Rename(from, to string) error {
+	fs.s.invalidate()
+	return fs.Filesystem.Rename(from, to)
+}
+
+func (fs *ReadAhead) Remove(filename string) error {
+	fs.s.invalidate()
+	return fs.Filesystem.Remove(filename)
+}
+
+func (fs *ReadAhead) Symlink(target, link string) error {
+	fs.s.invalidate()
+	return fs.Filesystem.Symlink(target, link)
+}
+
+// Chroot returns the given directory of the underlying filesystem, sharing
+// the budget.
+func (fs *ReadAhead) Chroot(path string) (billy.Filesystem, error) {
+	chroot, err := fs.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReadAhead{Filesystem: chroot, s: fs.s, dir: fs.path(path)}, nil
+}
+
+// Capabilities implements the Capable interface.
+func (fs *ReadAhead) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.Filesystem)
+}
+
+// file is a file open for reading, prefetching the chunks ahead of the ones
+// read sequentially. Its reads are made with ReadAt, keeping the offset.
+type file struct {
+	billy.File
+	s    *state
+	fs   billy.Filesystem
+	name string
+
+	m      sync.Mutex
+	pos    int64
+	last   int64
+	seq    int
+	chunks map[int64]*chunk
+	closed bool
+
+	// ahead is the file the chunks are prefetched from, open once needed.
+	ahead      billy.File
+	aheadErr   error
+	aheadMutex sync.Mutex
+	fetching   sync.WaitGroup
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	n, err := f.read(p, f.pos)
+	f.pos += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+
+	return n, err
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	return f.read(p, off)
+}
+
+// Seek sets the offset of the file, for the next reads.
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if whence == io.SeekCurrent {
+		offset, whence = f.pos+offset, io.SeekStart
+	}
+
+	pos, err := f.File.Seek(offset, whence)
+	if err == nil {
+		f.pos = pos
+	}
+
+	return pos, err
+}
+
+// read reads from the given offset, from the chunks prefetched if any,
+// prefetching the ones ahead if read sequentially.
+func (f *file) read(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+
+	if off == f.last {
+		f.seq++
+	} else {
+		f.seq = 0
+	}
+
+	size := int64(f.s.opts.ChunkSize)
+	f.discard(off / size)
+	if f.seq > 0 {
+		f.prefetch(off / size)
+	}
+
+	var n int
+	for n < len(p) {
+		m, ok, err := f.readChunk(p[n:], off+int64(n))
+		if !ok {
+			m, err = f.File.ReadAt(p[n:], off+int64(n))
+		}
+
+		n += m
+		if err != nil || !ok {
+			f.last = off + int64(n)
+			return n, err
+		}
+	}
+
+	f.last = off + int64(n)
+	return n, nil
+}
+
+// readChunk reads from the given offset from the chunk prefetched containing
+// it, returning false if there's none.
+func (f *file) readChunk(p []byte, off int64) (int, bool, error) {
+	size := int64(f.s.opts.ChunkSize)
+	i := off / size
+	c, ok := f.chunks[i]
+	if !ok {
+		return 0, false, nil
+	}
+
+	<-c.done
+	if c.err != nil && c.err != io.EOF {
+		f.drop(i)
+		return 0, false, nil
+	}
+
+	start := int(off - i*size)
+	if start >= len(c.data) {
+		if c.err == io.EOF {
+			return 0, true, io.EOF
+		}
+
+		return 0, false, nil
+	}
+
+	n := copy(p, c.data[start:])
+	if start+n == len(c.data) && c.err == io.EOF {
+		return n, true, io.EOF
+	}
+
+	return n, true, nil
+}
+
+// prefetch prefetches the chunks following the given one, within the budget.
+func (f *file) prefetch(i int64) {
+	size := int64(f.s.opts.ChunkSize)
+	for next := i + 1; next <= i+int64(f.s.opts.Chunks); next++ {
+		if c, ok := f.chunks[next-1]; ok && c.eof() {
+			return
+		}
+
+		if _, ok := f.chunks[next]; ok {
+			continue
+		}
+
+		if !f.s.reserve() {
+			return
+		}
+
+		c := newChunk()
+		f.chunks[next] = c
+		f.fetching.Add(1)
+		go f.fetch(c, next*size)
+	}
+}
+
+func (f *file) fetch(c *chunk, off int64) {
+	defer f.fetching.Done()
+
+	f.aheadMutex.Lock()
+	defer f.aheadMutex.Unlock()
+
+	if f.ahead == nil && f.aheadErr == nil {
+		f.ahead, f.aheadErr = f.fs.Open(f.name)
+	}
+
+	if f.aheadErr != nil {
+		c.err = f.aheadErr
+		close(c.done)
+		return
+	}
+
+	c.fetch(f.ahead, off, f.s.opts.ChunkSize)
+}
+
+// discard discards the chunks before the given one.
+func (f *file) discard(i int64) {
+	for j := range f.chunks {
+		if j < i {
+			f.drop(j)
+		}
+	}
+}
+
+func (f *file) drop(i int64) {
+	delete(f.chunks, i)
+	f.s.release()
+}
+
+// Close closes the file, once the chunks being prefetched are.
+func (f *file) Close() error {
+	f.m.Lock()
+	if f.closed {
+		f.m.Unlock()
+		return os.ErrClosed
+	}
+
+	f.closed = true
+	for i := range f.chunks {
+		f.drop(i)
+	}
+
+	f.m.Unlock()
+
+	f.fetching.Wait()
+	if f.ahead != nil {
+		f.ahead.Close()
+	}
+
+	return f.File.Close()
+}
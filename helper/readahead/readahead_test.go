@@ -0,0 +1,205 @@
+package readahead
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&ReadAheadSuite{})
+
+type ReadAheadSuite struct {
+	test.FilesystemSuite
+	underlying *countingFS
+	content    string
+}
+
+func (s *ReadAheadSuite) SetUpTest(c *C) {
+	fs := New(memfs.New(), Options{ChunkSize: 16, Chunks: 2})
+	s.FilesystemSuite = test.NewFilesystemSuite(fs)
+
+	s.underlying = &countingFS{Filesystem: memfs.New(), opens: make(map[string]int)}
+	s.content = strings.Repeat("0123456789", 10)
+	c.Assert(util.WriteFile(s.underlying, "foo", []byte(s.content), 0644), IsNil)
+	c.Assert(util.WriteFile(s.underlying, "bar", []byte("bar"), 0644), IsNil)
+}
+
+func (s *ReadAheadSuite) TestSequential(c *C) {
+	fs := New(s.underlying, Options{ChunkSize: 8, Chunks: 2})
+	f, err := fs.Open("foo")
+	c.Assert(err, IsNil)
+
+	var content []byte
+	buf := make([]byte, 5)
+	for {
+		n, err := f.Read(buf)
+		content = append(content, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+
+		c.Assert(err, IsNil)
+	}
+
+	c.Assert(string(content), Equals, s.content)
+
+	// the file is read from the file open to prefetch it, but for the first
+	// reads
+	c.Assert(s.underlying.opens["foo"], Equals, 2)
+	c.Assert(s.underlying.reads() < 4, Equals, true)
+
+	c.Assert(f.Close(), IsNil)
+	c.Assert(fs.s.used, Equals, int64(0))
+}
+
+func (s *ReadAheadSuite) TestRandom(c *C) {
+	fs := New(s.underlying, Options{ChunkSize: 8, Chunks: 2})
+	f, err := fs.Open("foo")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	buf := make([]byte, 10)
+	for _, off := range []int64{50, 10, 90, 0} {
+		_, err := f.ReadAt(buf, off)
+		c.Assert(err, IsNil)
+		c.Assert(string(buf), Equals, s.content[off:off+10])
+	}
+
+	// no read is sequential
+	c.Assert(s.underlying.opens["foo"], Equals, 1)
+
+	_, err = f.Seek(95, io.SeekStart)
+	c.Assert(err, IsNil)
+	b, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(b), Equals, s.content[95:])
+
+	n, err := f.ReadAt(buf, 100)
+	c.Assert(n, Equals, 0)
+	c.Assert(err, Equals, io.EOF)
+}
+
+func (s *ReadAheadSuite) TestBudget(c *C) {
+	fs := New(s.underlying, Options{ChunkSize: 8, Chunks: 4, Budget: 16})
+	f, err := fs.Open("foo")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	buf := make([]byte, 4)
+	for i := 0; i < 2; i++ {
+		_, err := f.Read(buf)
+		c.Assert(err, IsNil)
+	}
+
+	c.Assert(fs.s.used, Equals, int64(16))
+	b, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(b), Equals, s.content[8:])
+}
+
+func (s *ReadAheadSuite) TestSiblings(c *C) {
+	fs := New(s.underlying, Options{ChunkSize: 8, Siblings: 10})
+	infos, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 2)
+	c.Assert(fs.s.heads, HasLen, 2)
+
+	c.Assert(test.ReadFile(c, fs, "bar"), Equals, "bar")
+	c.Assert(test.ReadFile(c, fs, "foo"), Equals, s.content)
+	c.Assert(fs.s.heads, HasLen, 0)
+	c.Assert(fs.s.used, Equals, int64(0))
+
+	// the first chunks are discarded on any change
+	_, err = fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(util.WriteFile(fs, "bar", []byte("qux"), 0644), IsNil)
+	c.Assert(fs.s.heads, HasLen, 0)
+	c.Assert(test.ReadFile(c, fs, "bar"), Equals, "qux")
+}
+
+func (s *ReadAheadSuite) TestChroot(c *C) {
+	c.Assert(util.WriteFile(s.underlying, "dir/foo", []byte(s.content), 0644), IsNil)
+
+	fs := New(s.underlying, Options{ChunkSize: 8, Siblings: 10})
+	chroot, err := fs.Chroot("dir")
+	c.Assert(err, IsNil)
+
+	_, err = chroot.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(fs.s.heads["/dir/foo"], NotNil)
+	c.Assert(test.ReadFile(c, chroot, "foo"), Equals, s.content)
+}
+
+// countingFS is a filesystem counting the files open, and the reads of the
+// first file open of every path.
+type countingFS struct {
+	billy.Filesystem
+
+	m     sync.Mutex
+	opens map[string]int
+	first *countingFile
+}
+
+func (fs *countingFS) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (fs *countingFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	f, err := fs.Filesystem.OpenFile(filename, flag, perm)
+	if err != nil || flag != os.O_RDONLY {
+		return f, err
+	}
+
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	fs.opens[filename]++
+	cf := &countingFile{File: f, fs: fs}
+	if fs.first == nil {
+		fs.first = cf
+	}
+
+	return cf, nil
+}
+
+func (fs *countingFS) Chroot(path string) (billy.Filesystem, error) {
+	chroot, err := fs.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &countingFS{Filesystem: chroot, opens: make(map[string]int)}, nil
+}
+
+func (fs *countingFS) reads() int {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	return fs.first.reads
+}
+
+type countingFile struct {
+	billy.File
+	fs    *countingFS
+	reads int
+}
+
+func (f *countingFile) ReadAt(p []byte, off int64) (int, error) {
+	f.fs.m.Lock()
+	f.reads++
+	f.fs.m.Unlock()
+
+	return f.File.ReadAt(p, off)
+}
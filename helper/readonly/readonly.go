@@ -0,0 +1,98 @@
+// Package readonly provides a helper preventing any change to a filesystem,
+// eg.: to safely hand it to untrusted plugins.
+package readonly
+
+import (
+	"os"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// ReadOnly is a helper passing the reads through to the underlying
+// filesystem, returning billy.ErrReadOnly for every operation that would
+// modify it, including writing to the files open.
+type ReadOnly struct {
+	billy.Filesystem
+}
+
+// New creates a new filesystem wrapping up the given one, which can only be
+// read through it.
+func New(fs billy.Filesystem) *ReadOnly {
+	return &ReadOnly{Filesystem: fs}
+}
+
+func (fs *ReadOnly) Create(filename string) (billy.File, error) {
+	return nil, billy.ErrReadOnly
+}
+
+func (fs *ReadOnly) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file for reading, failing if any flag other than
+// os.O_RDONLY is given, since all the others may modify it.
+func (fs *ReadOnly) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if isWrite(flag) {
+		return nil, billy.ErrReadOnly
+	}
+
+	f, err := fs.Filesystem.OpenFile(filename, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &file{File: f}, nil
+}
+
+func (fs *ReadOnly) Rename(from, to string) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *ReadOnly) Remove(filename string) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *ReadOnly) MkdirAll(filename string, perm os.FileMode) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *ReadOnly) Symlink(target, link string) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *ReadOnly) TempFile(dir, prefix string) (billy.File, error) {
+	return nil, billy.ErrReadOnly
+}
+
+// Chroot returns the given directory of the underlying filesystem, also read
+// only.
+func (fs *ReadOnly) Chroot(path string) (billy.Filesystem, error) {
+	chroot, err := fs.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(chroot), nil
+}
+
+// Capabilities implements the Capable interface.
+func (fs *ReadOnly) Capabilities() billy.Capability {
+	c := billy.Capabilities(fs.Filesystem)
+	return c &^ (billy.WriteCapability | billy.ReadAndWriteCapability | billy.TruncateCapability)
+}
+
+type file struct {
+	billy.File
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	return 0, billy.ErrReadOnly
+}
+
+func (f *file) Truncate(size int64) error {
+	return billy.ErrReadOnly
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
+}
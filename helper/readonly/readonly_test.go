@@ -0,0 +1,88 @@
+package readonly
+
+import (
+	"os"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&ReadOnlySuite{})
+
+type ReadOnlySuite struct {
+	underlying billy.Filesystem
+	FS         billy.Filesystem
+}
+
+func (s *ReadOnlySuite) SetUpTest(c *C) {
+	s.underlying = memfs.New()
+	c.Assert(util.WriteFile(s.underlying, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.underlying, "dir/bar", []byte("bar"), 0644), IsNil)
+	c.Assert(s.underlying.Symlink("foo", "link"), IsNil)
+
+	s.FS = New(s.underlying)
+}
+
+func (s *ReadOnlySuite) TestRead(c *C) {
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "foo")
+	c.Assert(test.ReadFile(c, s.FS, "link"), Equals, "foo")
+
+	fi, err := s.FS.Stat("dir/bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Size(), Equals, int64(3))
+
+	infos, err := s.FS.ReadDir("dir")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 1)
+
+	target, err := s.FS.Readlink("link")
+	c.Assert(err, IsNil)
+	c.Assert(target, Equals, "foo")
+}
+
+func (s *ReadOnlySuite) TestReadOnly(c *C) {
+	_, err := s.FS.Create("new")
+	c.Assert(err, Equals, billy.ErrReadOnly)
+
+	for _, flag := range []int{os.O_WRONLY, os.O_RDWR, os.O_RDONLY | os.O_CREATE, os.O_RDONLY | os.O_TRUNC} {
+		_, err = s.FS.OpenFile("foo", flag, 0)
+		c.Assert(err, Equals, billy.ErrReadOnly)
+	}
+
+	c.Assert(s.FS.Remove("foo"), Equals, billy.ErrReadOnly)
+	c.Assert(s.FS.Rename("foo", "bar"), Equals, billy.ErrReadOnly)
+	c.Assert(s.FS.MkdirAll("new", 0755), Equals, billy.ErrReadOnly)
+	c.Assert(s.FS.Symlink("foo", "new"), Equals, billy.ErrReadOnly)
+
+	_, err = s.FS.TempFile("", "foo")
+	c.Assert(err, Equals, billy.ErrReadOnly)
+
+	f, err := s.FS.Open("foo")
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("bar"))
+	c.Assert(err, Equals, billy.ErrReadOnly)
+	c.Assert(f.Truncate(0), Equals, billy.ErrReadOnly)
+	c.Assert(f.Close(), IsNil)
+
+	c.Assert(test.ReadFile(c, s.underlying, "foo"), Equals, "foo")
+}
+
+func (s *ReadOnlySuite) TestChroot(c *C) {
+	fs, err := s.FS.Chroot("dir")
+	c.Assert(err, IsNil)
+	c.Assert(test.ReadFile(c, fs, "bar"), Equals, "bar")
+	c.Assert(fs.Remove("bar"), Equals, billy.ErrReadOnly)
+}
+
+func (s *ReadOnlySuite) TestCapabilities(c *C) {
+	c.Assert(billy.CapabilityCheck(s.FS, billy.ReadCapability), Equals, true)
+	c.Assert(billy.CapabilityCheck(s.FS, billy.WriteCapability), Equals, false)
+	c.Assert(billy.CapabilityCheck(s.FS, billy.TruncateCapability), Equals, false)
+}
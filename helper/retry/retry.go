@@ -0,0 +1,337 @@
+// Package retry provides a helper retrying the operations of a filesystem
+// failing with transient errors, eg.: to hide the hiccups of the filesystems
+// served over the network from their users.
+package retry
+
+import (
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+const (
+	// DefaultAttempts is the number of attempts used if none is given.
+	DefaultAttempts = 3
+	// DefaultDelay is the delay before the first retry used if none is
+	// given.
+	DefaultDelay = 100 * time.Millisecond
+	// DefaultMaxDelay is the maximum delay between attempts used if none is
+	// given.
+	DefaultMaxDelay = 5 * time.Second
+)
+
+// Options holds the configuration of a Retry.
+type Options struct {
+	// Attempts is the maximum number of attempts of every operation,
+	// DefaultAttempts if 0.
+	Attempts int
+	// Delay is the delay before the first retry, DefaultDelay if 0. The
+	// delay before every other retry is the previous one times Multiplier,
+	// up to MaxDelay.
+	Delay time.Duration
+	// MaxDelay is the maximum delay between attempts, DefaultMaxDelay if 0.
+	MaxDelay time.Duration
+	// Multiplier is the factor between consecutive delays, 2 if 0.
+	Multiplier float64
+	// Jitter is the fraction, from 0 to 1, of every delay chosen randomly,
+	// to spread the retries of many clients failing at once.
+	Jitter float64
+	// Retryable returns whether the given error of the given operation is
+	// transient, and so worth retrying, Transient if nil. The names of the
+	// operations are the ones of Idempotent.
+	Retryable func(op string, err error) bool
+	// NonIdempotent makes the operations that may not be idempotent be
+	// retried too, see Idempotent.
+	NonIdempotent bool
+	// Sleep is the function used to wait the delays, time.Sleep if nil.
+	Sleep func(time.Duration)
+}
+
+// Idempotent returns whether the given operation can be retried safely, since
+// repeating it has the same effect as making it once: "open" (including
+// Create and OpenFile, unless exclusive or appending), "stat", "lstat",
+// "readdir", "readlink", "mkdirall", "chroot", and for the files "readat"
+// and "truncate". The others, "open" exclusive or appending, "rename",
+// "remove", "symlink" and "tempfile", may fail or have a different effect if
+// the first attempt succeeded but failed to report it.
+//
+// The reads, writes and seeks of the files, moving their offset, and their
+// closes, are never retried.
+func Idempotent(op string) bool {
+	switch op {
+	case "open", "stat", "lstat", "readdir", "readlink", "mkdirall", "chroot",
+		"readat", "truncate":
+		return true
+	default:
+		return false
+	}
+}
+
+// Transient returns whether the given error is known to be transient: a
+// timeout of the network, a connection reset, refused or aborted, a system
+// call interrupted or temporarily unavailable, or a response cut short. Any
+// other error, such as the ones about the files, is returned right away. The
+// transient errors of a given filesystem, eg.: the HTTP 503 responses of a
+// server, can be added with Options.Retryable.
+func Transient(op string, err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var nerr net.Error
+	if errors.As(err, &nerr) && nerr.Timeout() {
+		return true
+	}
+
+	for _, target := range transientErrors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// transientErrors are the errors retried by Transient.
+var transientErrors = []error{
+	syscall.ECONNRESET,
+	syscall.ECONNREFUSED,
+	syscall.ECONNABORTED,
+	syscall.ETIMEDOUT,
+	syscall.EPIPE,
+	syscall.EAGAIN,
+	syscall.EINTR,
+	io.ErrUnexpectedEOF,
+}
+
+// Retry is a helper retrying the operations of the underlying filesystem,
+// and of the files open from it, failing with transient errors, waiting an
+// exponentially growing delay between attempts. The last error is returned
+// once the attempts are exhausted.
+type Retry struct {
+	billy.Filesystem
+	s *state
+}
+
+// state is the state shared by a Retry, its files and its chroots.
+type state struct {
+	opts Options
+
+	m sync.Mutex
+	r *rand.Rand
+}
+
+// New creates a new filesystem retrying the operations of the given one.
+func New(fs billy.Filesystem, opts Options) *Retry {
+	if opts.Attempts <= 0 {
+		opts.Attempts = DefaultAttempts
+	}
+
+	if opts.Delay == 0 {
+		opts.Delay = DefaultDelay
+	}
+
+	if opts.MaxDelay == 0 {
+		opts.MaxDelay = DefaultMaxDelay
+	}
+
+	if opts.Multiplier == 0 {
+		opts.Multiplier = 2
+	}
+
+	if opts.Retryable == nil {
+		opts.Retryable = Transient
+	}
+
+	if opts.Sleep == nil {
+		opts.Sleep = time.Sleep
+	}
+
+	return &Retry{Filesystem: fs, s: &state{
+		opts: opts,
+		r:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}}
+}
+
+// do calls fn until succeeded, or failed with an error not retryable, up to
+// the attempts given. The operations not idempotent are called once, unless
+// allowed by the options.
+func (s *state) do(op string, idempotent bool, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= s.opts.Attempts ||
+			!(idempotent || s.opts.NonIdempotent) || !s.opts.Retryable(op, err) {
+			return err
+		}
+
+		s.opts.Sleep(s.delay(attempt))
+	}
+}
+
+// delay returns the delay after the given attempt.
+func (s *state) delay(attempt int) time.Duration {
+	d := float64(s.opts.Delay) * math.Pow(s.opts.Multiplier, float64(attempt-1))
+	if d > float64(s.opts.MaxDelay) {
+		d = float64(s.opts.MaxDelay)
+	}
+
+	if s.opts.Jitter > 0 {
+		s.m.Lock()
+		d -= d * s.opts.Jitter * s.r.Float64()
+		s.m.Unlock()
+	}
+
+	return time.Duration(d)
+}
+
+func (fs *Retry) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Retry) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (fs *Retry) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	var f billy.File
+	idempotent := flag&(os.O_EXCL|os.O_APPEND) == 0
+	err := fs.s.do("open", idempotent, func() (err error) {
+		f, err = fs.Filesystem.OpenFile(filename, flag, perm)
+		return err
+	})
+
+	return fs.file(f, err)
+}
+
+func (fs *Retry) TempFile(dir, prefix string) (billy.File, error) {
+	var f billy.File
+	err := fs.s.do("tempfile", false, func() (err error) {
+		f, err = fs.Filesystem.TempFile(dir, prefix)
+		return err
+	})
+
+	return fs.file(f, err)
+}
+
+func (fs *Retry) file(f billy.File, err error) (billy.File, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	return &file{File: f, s: fs.s}, nil
+}
+
+func (fs *Retry) Stat(filename string) (os.FileInfo, error) {
+	var fi os.FileInfo
+	err := fs.s.do("stat", true, func() (err error) {
+		fi, err = fs.Filesystem.Stat(filename)
+		return err
+	})
+
+	return fi, err
+}
+
+func (fs *Retry) Lstat(filename string) (os.FileInfo, error) {
+	var fi os.FileInfo
+	err := fs.s.do("lstat", true, func() (err error) {
+		fi, err = fs.Filesystem.Lstat(filename)
+		return err
+	})
+
+	return fi, err
+}
+
+func (fs *Retry) ReadDir(path string) ([]os.FileInfo, error) {
+	var infos []os.FileInfo
+	err := fs.s.do("readdir", true, func() (err error) {
+		infos, err = fs.Filesystem.ReadDir(path)
+		return err
+	})
+
+	return infos, err
+}
+
+func (fs *Retry) Readlink(link string) (string, error) {
+	var target string
+	err := fs.s.do("readlink", true, func() (err error) {
+		target, err = fs.Filesystem.Readlink(link)
+		return err
+	})
+
+	return target, err
+}
+
+func (fs *Retry) Rename(from, to string) error {
+	return fs.s.do("rename", false, func() error {
+		return fs.Filesystem.Rename(from, to)
+	})
+}
+
+func (fs *Retry) Remove(filename string) error {
+	return fs.s.do("remove", false, func() error {
+		return fs.Filesystem.Remove(filename)
+	})
+}
+
+func (fs *Retry) MkdirAll(filename string, perm os.FileMode) error {
+	return fs.s.do("mkdirall", true, func() error {
+		return fs.Filesystem.MkdirAll(filename, perm)
+	})
+}
+
+func (fs *Retry) Symlink(target, link string) error {
+	return fs.s.do("symlink", false, func() error {
+		return fs.Filesystem.Symlink(target, link)
+	})
+}
+
+// Chroot returns the given directory of the underlying filesystem, retrying
+// its operations too.
+func (fs *Retry) Chroot(path string) (billy.Filesystem, error) {
+	var chroot billy.Filesystem
+	err := fs.s.do("chroot", true, func() (err error) {
+		chroot, err = fs.Filesystem.Chroot(path)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Retry{Filesystem: chroot, s: fs.s}, nil
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Retry) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.Filesystem)
+}
+
+// file is a file retrying its operations not moving the offset.
+type file struct {
+	billy.File
+	s *state
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	var n int
+	err := f.s.do("readat", true, func() (err error) {
+		n, err = f.File.ReadAt(p, off)
+		return err
+	})
+
+	return n, err
+}
+
+func (f *file) Truncate(size int64) error {
+	return f.s.do("truncate", true, func() error {
+		return f.File.Truncate(size)
+	})
+}
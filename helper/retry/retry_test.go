@@ -0,0 +1,174 @@
+package retry
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/faultfs"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&RetrySuite{})
+
+var errTransient error = syscall.ECONNRESET
+
+type RetrySuite struct {
+	test.FilesystemSuite
+	faults *faultfs.FaultFS
+	delays []time.Duration
+}
+
+func (s *RetrySuite) SetUpTest(c *C) {
+	s.delays = nil
+	s.faults = faultfs.New(memfs.New())
+	s.FilesystemSuite = test.NewFilesystemSuite(New(s.faults, Options{Sleep: s.sleep}))
+}
+
+func (s *RetrySuite) sleep(d time.Duration) {
+	s.delays = append(s.delays, d)
+}
+
+func (s *RetrySuite) TestRetried(c *C) {
+	fs := New(s.faults, Options{Attempts: 4, Delay: time.Second, MaxDelay: 3 * time.Second, Sleep: s.sleep})
+	c.Assert(util.WriteFile(fs, "foo", []byte("foo"), 0644), IsNil)
+
+	s.faults.Add(faultfs.Rule{Op: faultfs.OpStat, Nth: 1, Err: errTransient})
+	s.faults.Add(faultfs.Rule{Op: faultfs.OpStat, Nth: 2, Err: errTransient})
+	s.faults.Add(faultfs.Rule{Op: faultfs.OpStat, Nth: 3, Err: errTransient})
+
+	fi, err := fs.Stat("foo")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Size(), Equals, int64(3))
+	c.Assert(s.delays, DeepEquals, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second})
+}
+
+func (s *RetrySuite) TestExhausted(c *C) {
+	fs := New(s.faults, Options{Sleep: s.sleep})
+	s.faults.Add(faultfs.Rule{Op: faultfs.OpReadDir, Err: errTransient})
+
+	_, err := fs.ReadDir("/")
+	c.Assert(err.(*os.PathError).Err, Equals, errTransient)
+	c.Assert(s.delays, HasLen, DefaultAttempts-1)
+}
+
+func (s *RetrySuite) TestNotTransient(c *C) {
+	fs := New(s.faults, Options{Sleep: s.sleep})
+
+	_, err := fs.Stat("missing")
+	c.Assert(os.IsNotExist(err), Equals, true)
+	c.Assert(s.delays, HasLen, 0)
+}
+
+func (s *RetrySuite) TestTransient(c *C) {
+	for _, err := range []error{
+		syscall.ECONNRESET,
+		&os.PathError{Op: "stat", Path: "foo", Err: syscall.ECONNREFUSED},
+		&net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.EAGAIN)},
+		netError{timeout: true},
+		io.ErrUnexpectedEOF,
+	} {
+		c.Assert(Transient("stat", err), Equals, true, Commentf("%v", err))
+	}
+
+	for _, err := range []error{
+		nil,
+		io.EOF,
+		os.ErrNotExist,
+		&os.PathError{Op: "open", Path: "foo", Err: os.ErrPermission},
+		billy.ErrReadOnly,
+		billy.ErrNotSupported,
+		errors.New("connection reset"),
+		netError{timeout: false},
+	} {
+		c.Assert(Transient("stat", err), Equals, false, Commentf("%v", err))
+	}
+}
+
+func (s *RetrySuite) TestNonIdempotent(c *C) {
+	fs := New(s.faults, Options{Sleep: s.sleep})
+	c.Assert(util.WriteFile(fs, "foo", []byte("foo"), 0644), IsNil)
+
+	s.faults.Add(faultfs.Rule{Op: faultfs.OpRename, Nth: 1, Err: errTransient})
+	c.Assert(fs.Rename("foo", "bar"), NotNil)
+	c.Assert(s.delays, HasLen, 0)
+
+	fs = New(s.faults, Options{NonIdempotent: true, Sleep: s.sleep})
+	s.faults.Reset()
+	s.faults.Add(faultfs.Rule{Op: faultfs.OpRename, Nth: 1, Err: errTransient})
+	c.Assert(fs.Rename("foo", "bar"), IsNil)
+	c.Assert(s.delays, HasLen, 1)
+}
+
+func (s *RetrySuite) TestRetryable(c *C) {
+	var ops []string
+	fs := New(s.faults, Options{
+		Retryable: func(op string, err error) bool {
+			ops = append(ops, op)
+			return false
+		},
+		Sleep: s.sleep,
+	})
+
+	s.faults.Add(faultfs.Rule{Op: faultfs.OpOpen, Err: errTransient})
+	_, err := fs.Open("foo")
+	c.Assert(err, NotNil)
+	c.Assert(ops, DeepEquals, []string{"open"})
+	c.Assert(s.delays, HasLen, 0)
+}
+
+func (s *RetrySuite) TestFile(c *C) {
+	fs := New(s.faults, Options{Sleep: s.sleep})
+	c.Assert(util.WriteFile(fs, "foo", []byte("foo"), 0644), IsNil)
+
+	f, err := fs.Open("foo")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	s.faults.Add(faultfs.Rule{Op: faultfs.OpRead, Nth: 1, Err: errTransient})
+	b := make([]byte, 3)
+	n, err := f.ReadAt(b, 0)
+	c.Assert(err, IsNil)
+	c.Assert(string(b[:n]), Equals, "foo")
+
+	s.faults.Add(faultfs.Rule{Op: faultfs.OpRead, Nth: 1, Err: errTransient})
+	_, err = f.Read(b)
+	c.Assert(err, NotNil)
+	c.Assert(s.delays, HasLen, 1)
+}
+
+func (s *RetrySuite) TestJitter(c *C) {
+	fs := New(s.faults, Options{Attempts: 10, Delay: time.Second, Jitter: 0.5, Sleep: s.sleep})
+	s.faults.Add(faultfs.Rule{Op: faultfs.OpLstat, Err: errTransient})
+
+	_, err := fs.Lstat("foo")
+	c.Assert(err, NotNil)
+	for i, d := range s.delays {
+		max := time.Second << uint(i)
+		if max > DefaultMaxDelay {
+			max = DefaultMaxDelay
+		}
+
+		c.Assert(d > max/2 && d <= max, Equals, true, Commentf("%d: %s", i, d))
+	}
+}
+
+// netError is a net.Error, a timeout or not.
+type netError struct {
+	timeout bool
+}
+
+func (e netError) Error() string   { return "network error" }
+func (e netError) Timeout() bool   { return e.timeout }
+func (e netError) Temporary() bool { return true }
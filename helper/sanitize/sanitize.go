@@ -0,0 +1,349 @@
+// Package sanitize provides a helper keeping the names of the files created in
+// a filesystem valid on Windows, eg.: so a repository written on Linux can be
+// checked out on Windows.
+package sanitize
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+const (
+	separator = string(filepath.Separator)
+
+	// forbidden are the characters not allowed in the names on Windows,
+	// besides the control characters.
+	forbidden = `<>:"\|?*`
+
+	// DefaultMaxName is the maximum length of a name used if none is given.
+	DefaultMaxName = 255
+	// DefaultMaxPath is the maximum length of a path used if none is given,
+	// the MAX_PATH of Windows.
+	DefaultMaxPath = 260
+)
+
+var (
+	// ErrInvalidName is returned, as the error of an *os.PathError or an
+	// *os.LinkError, when creating a file whose name is invalid on Windows
+	// with the Reject mode.
+	ErrInvalidName = errors.New("invalid name on windows")
+	// ErrNameTooLong is returned, as the error of an *os.PathError or an
+	// *os.LinkError, when creating a file whose name, or path, is longer
+	// than the limits.
+	ErrNameTooLong = errors.New("name too long")
+)
+
+// Mode is the way the names invalid on Windows are handled.
+type Mode int
+
+const (
+	// Reject fails the creation of the files with names invalid on Windows,
+	// still allowing to access the existing ones.
+	Reject Mode = iota
+	// Rewrite replaces the forbidden characters, and the trailing dots and
+	// spaces, of every name by underscores, and prefixes the reserved
+	// device names by one, on every operation, so a file can be accessed by
+	// the name it was created with.
+	Rewrite
+)
+
+// Options holds the configuration of a Sanitize.
+type Options struct {
+	// Mode is the way the names invalid on Windows are handled, Reject by
+	// default.
+	Mode Mode
+	// MaxName is the maximum length, in characters, of the name of a file,
+	// DefaultMaxName if 0.
+	MaxName int
+	// MaxPath is the maximum length, in characters, of the path of a file
+	// relative to the root of the filesystem, DefaultMaxPath if 0. The path
+	// of the directory the files are checked out to on Windows also counts
+	// towards MAX_PATH, so it may need to be lower.
+	MaxPath int
+}
+
+// Sanitize is a helper checking, or rewriting, the names of the files created
+// in the underlying filesystem, so they are valid on Windows: not using the
+// characters forbidden, the reserved device names as CON or LPT1, even with
+// an extension, or trailing dots or spaces. The creation of the files whose
+// names or paths are too long fails with ErrNameTooLong in any mode.
+//
+// The names differing only in case, allowed on Linux but not on Windows,
+// aren't detected.
+type Sanitize struct {
+	billy.Filesystem
+	opts Options
+	// dir is the directory the root was moved to by Chroot, counted in the
+	// length of the paths checked against MaxPath.
+	dir string
+}
+
+// New creates a new filesystem wrapping up the given one, keeping the names
+// of the files created through it valid on Windows.
+func New(fs billy.Filesystem, opts Options) *Sanitize {
+	if opts.MaxName == 0 {
+		opts.MaxName = DefaultMaxName
+	}
+
+	if opts.MaxPath == 0 {
+		opts.MaxPath = DefaultMaxPath
+	}
+
+	return &Sanitize{Filesystem: fs, opts: opts}
+}
+
+// Valid returns if the given name, of a single file, is valid on Windows.
+func Valid(name string) bool {
+	return Clean(name) == name
+}
+
+// Clean returns the given name, of a single file, rewritten to be valid on
+// Windows, as done by the Rewrite mode.
+func Clean(name string) string {
+	runes := []rune(replaceForbidden(name))
+	for i := len(runes) - 1; i >= 0 && (runes[i] == '.' || runes[i] == ' '); i-- {
+		runes[i] = '_'
+	}
+
+	name = string(runes)
+	if isReserved(name) {
+		return "_" + name
+	}
+
+	return name
+}
+
+// replaceForbidden returns the given name with the characters forbidden
+// replaced by underscores.
+func replaceForbidden(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 32 || strings.ContainsRune(forbidden, r) {
+			return '_'
+		}
+
+		return r
+	}, name)
+}
+
+// isReserved returns if the given name is a device name of Windows, which is
+// reserved whatever the case or extension.
+func isReserved(name string) bool {
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		name = name[:i]
+	}
+
+	name = strings.ToUpper(strings.TrimRight(name, " "))
+	switch name {
+	case "CON", "PRN", "AUX", "NUL":
+		return true
+	}
+
+	if len(name) == 4 && (strings.HasPrefix(name, "COM") || strings.HasPrefix(name, "LPT")) {
+		return name[3] >= '1' && name[3] <= '9'
+	}
+
+	return false
+}
+
+// name returns the given path with its names rewritten, with the Rewrite
+// mode. If create is true, it fails if the path isn't valid to create a
+// file.
+func (fs *Sanitize) name(path string, create bool) (string, error) {
+	names := strings.Split(path, separator)
+	for i, name := range names {
+		if name == "" || name == "." || name == ".." || Valid(name) {
+			continue
+		}
+
+		if fs.opts.Mode == Rewrite {
+			names[i] = Clean(name)
+		} else if create {
+			return "", ErrInvalidName
+		}
+	}
+
+	path = strings.Join(names, separator)
+	if create && !fs.fits(path) {
+		return "", ErrNameTooLong
+	}
+
+	return path, nil
+}
+
+// fits returns if the names of the given path, and the whole path relative to
+// the root of the underlying filesystem, are within the limits.
+func (fs *Sanitize) fits(path string) bool {
+	full := strings.TrimPrefix(filepath.Join(separator, fs.dir, path), separator)
+	if utf8.RuneCountInString(full) > fs.opts.MaxPath {
+		return false
+	}
+
+	for _, name := range strings.Split(full, separator) {
+		if utf8.RuneCountInString(name) > fs.opts.MaxName {
+			return false
+		}
+	}
+
+	return true
+}
+
+// pathError returns the name of the given path, or an *os.PathError.
+func (fs *Sanitize) pathError(op, path string, create bool) (string, error) {
+	name, err := fs.name(path, create)
+	if err != nil {
+		return "", &os.PathError{Op: op, Path: path, Err: err}
+	}
+
+	return name, nil
+}
+
+func (fs *Sanitize) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Sanitize) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file, checking its name if it may be created.
+func (fs *Sanitize) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	name, err := fs.pathError("open", filename, flag&os.O_CREATE != 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.Filesystem.OpenFile(name, flag, perm)
+}
+
+func (fs *Sanitize) Stat(filename string) (os.FileInfo, error) {
+	name, err := fs.pathError("stat", filename, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.Filesystem.Stat(name)
+}
+
+func (fs *Sanitize) Lstat(filename string) (os.FileInfo, error) {
+	name, err := fs.pathError("lstat", filename, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.Filesystem.Lstat(name)
+}
+
+func (fs *Sanitize) ReadDir(path string) ([]os.FileInfo, error) {
+	name, err := fs.pathError("readdir", path, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.Filesystem.ReadDir(name)
+}
+
+// TempFile creates a temporary file, checking the name of the directory and
+// the characters of the prefix, the only ones that could make the name of the
+// file invalid.
+func (fs *Sanitize) TempFile(dir, prefix string) (billy.File, error) {
+	name, err := fs.pathError("tempfile", dir, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if clean := replaceForbidden(prefix); clean != prefix {
+		if fs.opts.Mode != Rewrite {
+			return nil, &os.PathError{Op: "tempfile", Path: prefix, Err: ErrInvalidName}
+		}
+
+		prefix = clean
+	}
+
+	return fs.Filesystem.TempFile(name, prefix)
+}
+
+func (fs *Sanitize) Rename(from, to string) error {
+	fromName, err := fs.name(from, false)
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
+
+	toName, err := fs.name(to, true)
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
+
+	return fs.Filesystem.Rename(fromName, toName)
+}
+
+func (fs *Sanitize) Remove(filename string) error {
+	name, err := fs.pathError("remove", filename, false)
+	if err != nil {
+		return err
+	}
+
+	return fs.Filesystem.Remove(name)
+}
+
+func (fs *Sanitize) MkdirAll(filename string, perm os.FileMode) error {
+	name, err := fs.pathError("mkdir", filename, true)
+	if err != nil {
+		return err
+	}
+
+	return fs.Filesystem.MkdirAll(name, perm)
+}
+
+// Symlink creates the given link, checking its name. The target is
+// rewritten, with the Rewrite mode, so it still leads to the same file.
+func (fs *Sanitize) Symlink(target, link string) error {
+	name, err := fs.pathError("symlink", link, true)
+	if err != nil {
+		return err
+	}
+
+	if target, err = fs.name(target, false); err != nil {
+		return &os.PathError{Op: "symlink", Path: link, Err: err}
+	}
+
+	return fs.Filesystem.Symlink(target, name)
+}
+
+func (fs *Sanitize) Readlink(link string) (string, error) {
+	name, err := fs.pathError("readlink", link, false)
+	if err != nil {
+		return "", err
+	}
+
+	return fs.Filesystem.Readlink(name)
+}
+
+// Chroot returns the given directory of the underlying filesystem, checking
+// the names of the files created below it as well.
+func (fs *Sanitize) Chroot(path string) (billy.Filesystem, error) {
+	name, err := fs.pathError("chroot", path, false)
+	if err != nil {
+		return nil, err
+	}
+
+	chroot, err := fs.Filesystem.Chroot(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Sanitize{
+		Filesystem: chroot,
+		opts:       fs.opts,
+		dir:        filepath.Join(separator, fs.dir, name),
+	}, nil
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Sanitize) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.Filesystem)
+}
@@ -0,0 +1,124 @@
+package sanitize
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&SanitizeSuite{})
+
+type SanitizeSuite struct {
+	test.FilesystemSuite
+	underlying billy.Filesystem
+}
+
+func (s *SanitizeSuite) SetUpTest(c *C) {
+	// the suite nests directories deeper than MAX_PATH allows
+	fs := New(memfs.New(), Options{MaxPath: 4096})
+	s.FilesystemSuite = test.NewFilesystemSuite(fs)
+	s.underlying = memfs.New()
+}
+
+func errOf(err error) error {
+	switch e := err.(type) {
+	case *os.PathError:
+		return e.Err
+	case *os.LinkError:
+		return e.Err
+	}
+
+	return err
+}
+
+func (s *SanitizeSuite) TestClean(c *C) {
+	for name, expected := range map[string]string{
+		"foo":          "foo",
+		"foo.txt":      "foo.txt",
+		"a:b":          "a_b",
+		"a<b>c?*":      "a_b_c__",
+		"a\\b|\"c\x01": "a_b__c_",
+		"foo.":         "foo_",
+		"foo. ":        "foo__",
+		"CON":          "_CON",
+		"con.txt":      "_con.txt",
+		"Com1":         "_Com1",
+		"lpt9.tar.gz":  "_lpt9.tar.gz",
+		"COM0":         "COM0",
+		"CONSOLE":      "CONSOLE",
+		".git":         ".git",
+	} {
+		c.Assert(Clean(name), Equals, expected, Commentf("name: %q", name))
+		c.Assert(Valid(name), Equals, name == expected)
+	}
+}
+
+func (s *SanitizeSuite) TestReject(c *C) {
+	c.Assert(util.WriteFile(s.underlying, "a:b", []byte("foo"), 0644), IsNil)
+	fs := New(s.underlying, Options{})
+
+	for _, name := range []string{"foo:bar", "dir/NUL", "foo.", "dir./foo"} {
+		_, err := fs.Create(name)
+		c.Assert(errOf(err), Equals, ErrInvalidName, Commentf("name: %q", name))
+	}
+
+	c.Assert(errOf(fs.MkdirAll("foo?", 0755)), Equals, ErrInvalidName)
+	c.Assert(errOf(fs.Symlink("foo", "aux")), Equals, ErrInvalidName)
+	c.Assert(errOf(fs.Rename("a:b", "c:d")), Equals, ErrInvalidName)
+	_, err := fs.TempFile("", "foo*")
+	c.Assert(errOf(err), Equals, ErrInvalidName)
+
+	// the existing files can still be accessed
+	_, err = fs.Stat("a:b")
+	c.Assert(err, IsNil)
+	c.Assert(fs.Rename("a:b", "a_b"), IsNil)
+}
+
+func (s *SanitizeSuite) TestRewrite(c *C) {
+	fs := New(s.underlying, Options{Mode: Rewrite})
+	c.Assert(util.WriteFile(fs, "dir./foo:bar", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(fs, "con", []byte("foo"), 0644), IsNil)
+
+	_, err := s.underlying.Stat("dir_/foo_bar")
+	c.Assert(err, IsNil)
+	_, err = s.underlying.Stat("_con")
+	c.Assert(err, IsNil)
+
+	// the files are accessed by the names they were created with
+	_, err = fs.Stat("dir./foo:bar")
+	c.Assert(err, IsNil)
+	c.Assert(fs.Symlink("dir./foo:bar", "link"), IsNil)
+	target, err := fs.Readlink("link")
+	c.Assert(err, IsNil)
+	c.Assert(target, Equals, "dir_/foo_bar")
+
+	f, err := fs.TempFile("dir.", "foo?")
+	c.Assert(err, IsNil)
+	c.Assert(strings.HasPrefix(f.Name(), "dir_/foo_"), Equals, true)
+	c.Assert(f.Close(), IsNil)
+}
+
+func (s *SanitizeSuite) TestTooLong(c *C) {
+	fs := New(s.underlying, Options{Mode: Rewrite, MaxName: 8, MaxPath: 16})
+
+	_, err := fs.Create("foobarqux")
+	c.Assert(errOf(err), Equals, ErrNameTooLong)
+	_, err = fs.Create("foo/bar/qux/baz/a")
+	c.Assert(errOf(err), Equals, ErrNameTooLong)
+	_, err = fs.Create("foo/bar/qux/baz")
+	c.Assert(err, IsNil)
+
+	chroot, err := fs.Chroot("foo/bar")
+	c.Assert(err, IsNil)
+	c.Assert(errOf(chroot.MkdirAll("qux/baz/a", 0755)), Equals, ErrNameTooLong)
+	c.Assert(errOf(chroot.MkdirAll("qux:", 0755)), IsNil)
+}
@@ -0,0 +1,419 @@
+// Package shadow provides a helper backing up the files of a filesystem before
+// they are first changed in a session, to recover their previous versions,
+// eg.: for tools editing the files in place.
+package shadow
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/internal/fileutil"
+)
+
+const (
+	separator = string(filepath.Separator)
+	// layout is the layout of the names of the sessions, sorting as their
+	// times and valid on any filesystem.
+	layout = "20060102T150405.000000000Z"
+)
+
+// ErrNotFound is returned when restoring a file not backed up in a session.
+var ErrNotFound = errors.New("backup not found")
+
+// Options holds the configuration of a Shadow.
+type Options struct {
+	// Backup is the filesystem where the files are backed up, a directory
+	// per session. It should be a filesystem used only by the helper.
+	Backup billy.Filesystem
+	// Clock returns the time a session starts, time.Now if nil.
+	Clock func() time.Time
+}
+
+// Version is a previous version of a file, backed up in a session.
+type Version struct {
+	// Session is the name of the session.
+	Session string
+	// Time is the time the session started.
+	Time time.Time
+	// Size is the size of the file, in bytes.
+	Size int64
+	// Mode is the mode of the file.
+	Mode os.FileMode
+}
+
+// Shadow is a helper passing every operation through to the underlying
+// filesystem, copying every regular file and symlink to the backup
+// filesystem right before its first change in the current session: open
+// truncating it, written, truncated, renamed, overwritten by a rename or
+// removed. The files created in the session aren't backed up.
+type Shadow struct {
+	billy.Filesystem
+	s *state
+	// dir is the directory the root was moved to by Chroot, as the backups
+	// are stored with their paths from the original root.
+	dir string
+}
+
+// state is the state shared by a Shadow, its files and its chroots.
+type state struct {
+	// fs is the underlying filesystem, not chrooted.
+	fs    billy.Filesystem
+	store billy.Filesystem
+	clock func() time.Time
+
+	m       sync.Mutex
+	session string
+	// seen are the paths already backed up, or created, in the session.
+	seen map[string]bool
+}
+
+// New creates a new filesystem backing up the files of the given one to
+// opts.Backup, starting a session.
+func New(fs billy.Filesystem, opts Options) *Shadow {
+	if opts.Clock == nil {
+		opts.Clock = time.Now
+	}
+
+	s := &state{fs: fs, store: opts.Backup, clock: opts.Clock}
+	s.start()
+	return &Shadow{Filesystem: fs, s: s}
+}
+
+// NewSession starts a new session, so the files are backed up again before
+// their next change, returning its name.
+func (fs *Shadow) NewSession() string {
+	fs.s.m.Lock()
+	defer fs.s.m.Unlock()
+
+	fs.s.start()
+	return fs.s.session
+}
+
+// Session returns the name of the current session.
+func (fs *Shadow) Session() string {
+	fs.s.m.Lock()
+	defer fs.s.m.Unlock()
+
+	return fs.s.session
+}
+
+// start starts a new session, named after its time, made unique if two
+// sessions start at the same time. It must be called with the lock held.
+func (s *state) start() {
+	last := s.session
+	t := s.clock().UTC()
+	for {
+		s.session = t.Format(layout)
+		if _, err := s.store.Lstat(s.session); s.session != last && os.IsNotExist(err) {
+			break
+		}
+
+		t = t.Add(time.Nanosecond)
+	}
+
+	s.seen = make(map[string]bool)
+}
+
+// Versions returns the previous versions of the given file backed up, the
+// oldest first.
+func (fs *Shadow) Versions(filename string) ([]Version, error) {
+	sessions, err := fs.s.store.ReadDir(separator)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Name() < sessions[j].Name()
+	})
+
+	p := fs.path(filename)
+	var versions []Version
+	for _, session := range sessions {
+		t, err := time.Parse(layout, session.Name())
+		if err != nil || !session.IsDir() {
+			continue
+		}
+
+		fi, err := fs.s.store.Lstat(filepath.Join(session.Name(), p))
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		versions = append(versions, Version{
+			Session: session.Name(),
+			Time:    t,
+			Size:    fi.Size(),
+			Mode:    fi.Mode(),
+		})
+	}
+
+	return versions, nil
+}
+
+// Restore restores the given file as backed up in the given session,
+// backing up the current version first. It fails with ErrNotFound if the
+// file wasn't backed up in the session.
+func (fs *Shadow) Restore(filename, session string) error {
+	src := filepath.Join(session, fs.path(filename))
+	fi, err := fs.s.store.Lstat(src)
+	if os.IsNotExist(err) {
+		return &os.PathError{Op: "restore", Path: filename, Err: ErrNotFound}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := fs.s.store.Readlink(src)
+		if err != nil {
+			return err
+		}
+
+		if err := fs.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		return fs.Symlink(target, filename)
+	}
+
+	if err := fs.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+
+	return fileutil.Copy(fs.s.store, src, fs, filename, fi.Mode().Perm())
+}
+
+// save backs up the given file, unless already done in the session. It must
+// be called with the lock held.
+func (s *state) save(p string) error {
+	if s.seen[p] {
+		return nil
+	}
+
+	fi, err := s.fs.Lstat(p)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err == nil {
+		if err := s.copy(p, fi); err != nil {
+			return err
+		}
+	}
+
+	s.seen[p] = true
+	return nil
+}
+
+// saveTree backs up the given file and, if a directory, everything below it.
+// It must be called with the lock held.
+func (s *state) saveTree(p string) error {
+	fi, err := s.fs.Lstat(p)
+	if err != nil || !fi.IsDir() {
+		return s.save(p)
+	}
+
+	infos, err := s.fs.ReadDir(p)
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range infos {
+		if err := s.saveTree(filepath.Join(p, fi.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copy copies the given file to the directory of the session. Only regular
+// files and symlinks are copied.
+func (s *state) copy(p string, fi os.FileInfo) error {
+	dst := filepath.Join(s.session, p)
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		target, err := s.fs.Readlink(p)
+		if err != nil {
+			return err
+		}
+
+		if err := s.store.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+
+		return s.store.Symlink(target, dst)
+	case fi.Mode().IsRegular():
+		if err := s.store.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+
+		return fileutil.Copy(s.fs, p, s.store, dst, fi.Mode().Perm())
+	}
+
+	return nil
+}
+
+// path returns the given path relative to the root of the underlying
+// filesystem.
+func (fs *Shadow) path(p string) string {
+	return filepath.Join(separator, fs.dir, p)
+}
+
+// save backs up the given file, unless already done in the session.
+func (fs *Shadow) save(p string, tree bool) error {
+	fs.s.m.Lock()
+	defer fs.s.m.Unlock()
+
+	if tree {
+		return fs.s.saveTree(fs.path(p))
+	}
+
+	return fs.s.save(fs.path(p))
+}
+
+func (fs *Shadow) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Shadow) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file. The file is backed up right away if open
+// truncating or creating it, and before its first write or truncate
+// otherwise.
+func (fs *Shadow) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if !isWrite(flag) {
+		return fs.Filesystem.OpenFile(filename, flag, perm)
+	}
+
+	if flag&(os.O_TRUNC|os.O_CREATE) != 0 {
+		if err := fs.save(filename, false); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := fs.Filesystem.OpenFile(filename, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &file{File: f, fs: fs, name: filename}, nil
+}
+
+// TempFile creates a temporary file, which isn't backed up since it's created
+// in the session.
+func (fs *Shadow) TempFile(dir, prefix string) (billy.File, error) {
+	f, err := fs.Filesystem.TempFile(dir, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.s.m.Lock()
+	fs.s.seen[fs.path(f.Name())] = true
+	fs.s.m.Unlock()
+	return f, nil
+}
+
+func (fs *Shadow) Rename(from, to string) error {
+	if err := fs.save(from, true); err != nil {
+		return err
+	}
+
+	if err := fs.save(to, true); err != nil {
+		return err
+	}
+
+	return fs.Filesystem.Rename(from, to)
+}
+
+// Symlink creates the given symlink, which isn't backed up since it's created
+// in the session.
+func (fs *Shadow) Symlink(target, link string) error {
+	fs.s.m.Lock()
+	defer fs.s.m.Unlock()
+
+	if err := fs.Filesystem.Symlink(target, link); err != nil {
+		return err
+	}
+
+	fs.s.seen[fs.path(link)] = true
+	return nil
+}
+
+func (fs *Shadow) Remove(filename string) error {
+	if err := fs.save(filename, false); err != nil {
+		return err
+	}
+
+	return fs.Filesystem.Remove(filename)
+}
+
+// Chroot returns the given directory of the underlying filesystem, backing up
+// its files in the same sessions, by their paths relative to the root of the
+// Shadow created by New.
+func (fs *Shadow) Chroot(path string) (billy.Filesystem, error) {
+	chroot, err := fs.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Shadow{Filesystem: chroot, s: fs.s, dir: fs.path(path)}, nil
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Shadow) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.Filesystem)
+}
+
+// file is a file open for writing, backed up before its first change.
+type file struct {
+	billy.File
+	fs   *Shadow
+	name string
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	if err := f.fs.save(f.name, false); err != nil {
+		return 0, err
+	}
+
+	return f.File.Write(p)
+}
+
+func (f *file) WriteAt(p []byte, off int64) (int, error) {
+	w, ok := f.File.(io.WriterAt)
+	if !ok {
+		return 0, billy.ErrNotSupported
+	}
+
+	if err := f.fs.save(f.name, false); err != nil {
+		return 0, err
+	}
+
+	return w.WriteAt(p, off)
+}
+
+func (f *file) Truncate(size int64) error {
+	if err := f.fs.save(f.name, false); err != nil {
+		return err
+	}
+
+	return f.File.Truncate(size)
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
+}
@@ -0,0 +1,123 @@
+package shadow
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&ShadowSuite{})
+
+type ShadowSuite struct {
+	test.FilesystemSuite
+	FS     *Shadow
+	backup billy.Filesystem
+	now    time.Time
+}
+
+func (s *ShadowSuite) SetUpTest(c *C) {
+	s.now = time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	s.backup = memfs.New()
+	s.FS = New(memfs.New(), Options{
+		Backup: s.backup,
+		Clock:  func() time.Time { return s.now },
+	})
+
+	s.FilesystemSuite = test.NewFilesystemSuite(s.FS)
+}
+
+func (s *ShadowSuite) TestBackup(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(s.FS.Session(), Equals, "20180102T030405.000000000Z")
+
+	// created in the session
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("bar"), 0644), IsNil)
+	_, err := s.backup.Stat("20180102T030405.000000000Z/foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	s.now = s.now.Add(time.Hour)
+	c.Assert(s.FS.NewSession(), Equals, "20180102T040405.000000000Z")
+
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("baz"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("qux"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.backup, "20180102T040405.000000000Z/foo"), Equals, "bar")
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "qux")
+}
+
+func (s *ShadowSuite) TestBackupOnWrite(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+	session := s.FS.NewSession()
+	c.Assert(session, Equals, "20180102T030405.000000001Z")
+
+	f, err := s.FS.OpenFile("foo", os.O_RDWR, 0)
+	c.Assert(err, IsNil)
+	_, err = s.backup.Stat(session + "/foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	_, err = f.Write([]byte("bar"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+	c.Assert(test.ReadFile(c, s.backup, session+"/foo"), Equals, "foo")
+}
+
+func (s *ShadowSuite) TestRemoveAndRename(c *C) {
+	c.Assert(util.WriteFile(s.FS, "dir/foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "bar", []byte("bar"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "baz", []byte("baz"), 0644), IsNil)
+	session := s.FS.NewSession()
+
+	c.Assert(s.FS.Rename("dir", "qux"), IsNil)
+	c.Assert(s.FS.Rename("bar", "baz"), IsNil)
+	c.Assert(s.FS.Remove("baz"), IsNil)
+
+	c.Assert(test.ReadFile(c, s.backup, session+"/dir/foo"), Equals, "foo")
+	c.Assert(test.ReadFile(c, s.backup, session+"/bar"), Equals, "bar")
+	c.Assert(test.ReadFile(c, s.backup, session+"/baz"), Equals, "baz")
+}
+
+func (s *ShadowSuite) TestVersionsAndRestore(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("v1"), 0600), IsNil)
+	for _, content := range []string{"v2", "v3"} {
+		s.now = s.now.Add(time.Hour)
+		s.FS.NewSession()
+		c.Assert(util.WriteFile(s.FS, "foo", []byte(content), 0644), IsNil)
+	}
+
+	versions, err := s.FS.Versions("foo")
+	c.Assert(err, IsNil)
+	c.Assert(versions, HasLen, 2)
+	c.Assert(versions[0].Session, Equals, "20180102T040405.000000000Z")
+	c.Assert(versions[0].Time.Equal(s.now.Add(-time.Hour)), Equals, true)
+	c.Assert(versions[0].Size, Equals, int64(2))
+	c.Assert(versions[1].Session, Equals, "20180102T050405.000000000Z")
+
+	c.Assert(s.FS.Restore("foo", versions[0].Session), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "v1")
+	c.Assert(test.ReadFile(c, s.backup, versions[1].Session+"/foo"), Equals, "v2")
+
+	err = s.FS.Restore("bar", versions[0].Session)
+	c.Assert(err.(*os.PathError).Err, Equals, ErrNotFound)
+}
+
+func (s *ShadowSuite) TestChroot(c *C) {
+	c.Assert(util.WriteFile(s.FS, "dir/foo", []byte("foo"), 0644), IsNil)
+	session := s.FS.NewSession()
+
+	chroot, err := s.FS.Chroot("dir")
+	c.Assert(err, IsNil)
+	c.Assert(util.WriteFile(chroot, "foo", []byte("bar"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.backup, session+"/dir/foo"), Equals, "foo")
+
+	versions, err := chroot.(*Shadow).Versions("foo")
+	c.Assert(err, IsNil)
+	c.Assert(versions, HasLen, 1)
+}
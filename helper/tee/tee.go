@@ -0,0 +1,567 @@
+// Package tee provides a helper making every change to a filesystem also to
+// one or more replicas, eg.: to keep a live copy of a working tree in a
+// second backend.
+package tee
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const separator = string(filepath.Separator)
+
+// Policy is the way the changes failing in a replica are handled.
+type Policy int
+
+const (
+	// FailFast returns the error of the first replica failing, without
+	// making the change to the next ones. The paths changed are kept pending
+	// in the replicas not changed, until copied by Flush.
+	FailFast Policy = iota
+	// Queue ignores the errors of the replicas, keeping the paths changed
+	// pending in the ones failing. They are copied before the next change
+	// made to the replica, or by Flush.
+	Queue
+)
+
+// Options holds the configuration of a Tee.
+type Options struct {
+	// Replicas are the filesystems where every change is made too, in order.
+	Replicas []billy.Filesystem
+	// Policy is the way the changes failing in a replica are handled,
+	// FailFast by default.
+	Policy Policy
+}
+
+// Tee is a helper making every change, first to the underlying filesystem, the
+// primary, and then to every replica. Everything is read from the primary.
+//
+// A change failing in the primary is never made to the replicas. The paths
+// changed by the ones failing in a replica are kept pending, and copied from
+// the primary when retried, so the replica ends matching it, even if changed
+// outside the helper in between. A file open for writing stops being written
+// to a replica once failed, and is copied when retried after closed.
+type Tee struct {
+	billy.Filesystem
+	replicas []billy.Filesystem
+	dir      string
+	s        *state
+}
+
+// state is the state shared by a Tee, its files and its chroots.
+type state struct {
+	policy   Policy
+	primary  billy.Filesystem
+	replicas []billy.Filesystem
+
+	m       sync.Mutex
+	pending []map[string]bool
+}
+
+// New creates a new filesystem making every change to the given one, and to
+// the replicas of the options.
+func New(fs billy.Filesystem, opts Options) *Tee {
+	s := &state{
+		policy:   opts.Policy,
+		primary:  fs,
+		replicas: opts.Replicas,
+		pending:  make([]map[string]bool, len(opts.Replicas)),
+	}
+
+	for i := range s.pending {
+		s.pending[i] = make(map[string]bool)
+	}
+
+	return &Tee{Filesystem: fs, replicas: opts.Replicas, s: s}
+}
+
+// Flush copies the paths pending in every replica, returning the first error
+// found. The paths failing are kept pending.
+func (fs *Tee) Flush() error {
+	fs.s.m.Lock()
+	defer fs.s.m.Unlock()
+
+	var first error
+	for i := range fs.s.replicas {
+		if err := fs.s.flush(i); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
+
+// Pending returns the paths pending in the given replica, by its index in the
+// options, relative to the root of the Tee created by New.
+func (fs *Tee) Pending(replica int) []string {
+	fs.s.m.Lock()
+	defer fs.s.m.Unlock()
+
+	var paths []string
+	for p := range fs.s.pending[replica] {
+		paths = append(paths, p)
+	}
+
+	sort.Strings(paths)
+	return paths
+}
+
+// apply calls fn for the given replica, keeping the given paths pending if
+// failed. With the Queue policy, the paths already pending are copied first,
+// and fn isn't called if they cover the given ones.
+func (s *state) apply(i int, fn func() error, paths ...string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.policy == Queue && len(s.pending[i]) != 0 {
+		covered := s.covered(i, paths)
+		if err := s.flush(i); err != nil {
+			s.mark(i, paths)
+			return err
+		}
+
+		if covered {
+			return nil
+		}
+	}
+
+	if err := fn(); err != nil {
+		s.mark(i, paths)
+		return err
+	}
+
+	return nil
+}
+
+// skip keeps the given paths pending in the given replica, without changing
+// it.
+func (s *state) skip(i int, paths ...string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.mark(i, paths)
+}
+
+func (s *state) mark(i int, paths []string) {
+	for _, p := range paths {
+		s.pending[i][p] = true
+	}
+}
+
+// covered returns if every given path is, or is below, a path pending in the
+// given replica.
+func (s *state) covered(i int, paths []string) bool {
+	for _, p := range paths {
+		var found bool
+		for pending := range s.pending[i] {
+			if isBelow(p, pending) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// flush copies from the primary the paths pending in the given replica, in
+// order, stopping at the first failing.
+func (s *state) flush(i int) error {
+	var paths []string
+	for p := range s.pending[i] {
+		paths = append(paths, p)
+	}
+
+	sort.Strings(paths)
+	for _, p := range paths {
+		if err := resync(s.primary, s.replicas[i], p); err != nil {
+			return err
+		}
+
+		delete(s.pending[i], p)
+	}
+
+	return nil
+}
+
+// replicate calls fn with every replica, and its index, following the policy.
+func (fs *Tee) replicate(fn func(int, billy.Filesystem) error, paths ...string) error {
+	for i, r := range fs.replicas {
+		i, r := i, r
+		err := fs.s.apply(i, func() error { return fn(i, r) }, paths...)
+		if err == nil || fs.s.policy == Queue {
+			continue
+		}
+
+		for j := i + 1; j < len(fs.replicas); j++ {
+			fs.s.skip(j, paths...)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// path returns the given path relative to the root of the Tee created by New.
+func (fs *Tee) path(p string) string {
+	return filepath.Join(separator, fs.dir, p)
+}
+
+func (fs *Tee) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// OpenFile opens the given file of the primary, and of the replicas if open
+// for writing.
+func (fs *Tee) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	f, err := fs.Filesystem.OpenFile(filename, flag, perm)
+	if err != nil || !isWrite(flag) {
+		return f, err
+	}
+
+	return fs.open(f, filename, func(r billy.Filesystem) (billy.File, error) {
+		return r.OpenFile(filename, flag, perm)
+	})
+}
+
+// TempFile creates a temporary file in the primary, and a file with the same
+// name in the replicas.
+func (fs *Tee) TempFile(dir, prefix string) (billy.File, error) {
+	f, err := fs.Filesystem.TempFile(dir, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	name := filepath.Join(dir, filepath.Base(f.Name()))
+	return fs.open(f, name, func(r billy.Filesystem) (billy.File, error) {
+		return r.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	})
+}
+
+// open opens, in every replica, the given file already open in the primary.
+func (fs *Tee) open(f billy.File, filename string, open func(billy.Filesystem) (billy.File, error)) (billy.File, error) {
+	tf := &file{File: f, s: fs.s, path: fs.path(filename)}
+	tf.replicas = make([]billy.File, len(fs.replicas))
+
+	err := fs.replicate(func(i int, r billy.Filesystem) (err error) {
+		tf.replicas[i], err = open(r)
+		return err
+	}, tf.path)
+
+	if err != nil {
+		tf.closeReplicas()
+		f.Close()
+		return nil, err
+	}
+
+	return tf, nil
+}
+
+func (fs *Tee) Rename(from, to string) error {
+	if err := fs.Filesystem.Rename(from, to); err != nil {
+		return err
+	}
+
+	return fs.replicate(func(_ int, r billy.Filesystem) error {
+		return r.Rename(from, to)
+	}, fs.path(from), fs.path(to))
+}
+
+func (fs *Tee) Remove(filename string) error {
+	if err := fs.Filesystem.Remove(filename); err != nil {
+		return err
+	}
+
+	return fs.replicate(func(_ int, r billy.Filesystem) error {
+		return r.Remove(filename)
+	}, fs.path(filename))
+}
+
+func (fs *Tee) MkdirAll(filename string, perm os.FileMode) error {
+	if err := fs.Filesystem.MkdirAll(filename, perm); err != nil {
+		return err
+	}
+
+	return fs.replicate(func(_ int, r billy.Filesystem) error {
+		return r.MkdirAll(filename, perm)
+	}, fs.path(filename))
+}
+
+func (fs *Tee) Symlink(target, link string) error {
+	if err := fs.Filesystem.Symlink(target, link); err != nil {
+		return err
+	}
+
+	return fs.replicate(func(_ int, r billy.Filesystem) error {
+		return r.Symlink(target, link)
+	}, fs.path(link))
+}
+
+// Chroot returns the given directory of the primary, making the changes to
+// the same directory of the replicas, and sharing the paths pending.
+func (fs *Tee) Chroot(path string) (billy.Filesystem, error) {
+	primary, err := fs.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+
+	replicas := make([]billy.Filesystem, len(fs.replicas))
+	for i, r := range fs.replicas {
+		if replicas[i], err = r.Chroot(path); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Tee{
+		Filesystem: primary,
+		replicas:   replicas,
+		dir:        fs.path(path),
+		s:          fs.s,
+	}, nil
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Tee) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.Filesystem)
+}
+
+// file is a file open for writing, writing every change to the same file of
+// the replicas, as long as not failed.
+type file struct {
+	billy.File
+	s        *state
+	path     string
+	replicas []billy.File
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	pos, err := f.File.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := f.File.Write(p)
+	if n == 0 {
+		return n, err
+	}
+
+	rerr := f.replicate(func(r billy.File) error {
+		if _, err := r.Seek(pos, io.SeekStart); err != nil {
+			return err
+		}
+
+		_, err := r.Write(p[:n])
+		return err
+	})
+
+	if err == nil {
+		err = rerr
+	}
+
+	return n, err
+}
+
+func (f *file) Truncate(size int64) error {
+	if err := f.File.Truncate(size); err != nil {
+		return err
+	}
+
+	return f.replicate(func(r billy.File) error {
+		return r.Truncate(size)
+	})
+}
+
+// Close closes the file, and its replicas. The replicas failed before are
+// kept pending, so the file is copied whole once closed.
+func (f *file) Close() error {
+	err := f.File.Close()
+	for i, r := range f.replicas {
+		if r == nil {
+			f.s.skip(i, f.path)
+			continue
+		}
+
+		cerr := r.Close()
+		if cerr == nil {
+			continue
+		}
+
+		f.s.skip(i, f.path)
+		if err == nil && f.s.policy == FailFast {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// replicate calls fn with every replica not failed yet, following the policy.
+// The replicas failing, and the next ones if FailFast, are closed.
+func (f *file) replicate(fn func(billy.File) error) error {
+	var first error
+	for i, r := range f.replicas {
+		if r == nil {
+			continue
+		}
+
+		if first == nil || f.s.policy == Queue {
+			err := f.s.apply(i, func() error { return fn(r) }, f.path)
+			if err == nil {
+				continue
+			}
+
+			if first == nil && f.s.policy == FailFast {
+				first = err
+			}
+		} else {
+			f.s.skip(i, f.path)
+		}
+
+		r.Close()
+		f.replicas[i] = nil
+	}
+
+	return first
+}
+
+func (f *file) closeReplicas() {
+	for _, r := range f.replicas {
+		if r != nil {
+			r.Close()
+		}
+	}
+}
+
+// resync makes the given path of dst match the one of src, recursively.
+func resync(src, dst billy.Filesystem, p string) error {
+	fi, err := src.Lstat(p)
+	if os.IsNotExist(err) {
+		return util.RemoveAll(dst, p)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if dfi, err := dst.Lstat(p); err == nil && dfi.Mode()&os.ModeType != fi.Mode()&os.ModeType {
+		if err := util.RemoveAll(dst, p); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		return resyncLink(src, dst, p)
+	case fi.IsDir():
+		return resyncDir(src, dst, p, fi.Mode().Perm())
+	default:
+		return copyFile(src, dst, p, fi.Mode().Perm())
+	}
+}
+
+func resyncLink(src, dst billy.Filesystem, p string) error {
+	target, err := src.Readlink(p)
+	if err != nil {
+		return err
+	}
+
+	if err := dst.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := dst.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	return dst.Symlink(target, p)
+}
+
+func resyncDir(src, dst billy.Filesystem, p string, perm os.FileMode) error {
+	if err := dst.MkdirAll(p, perm); err != nil {
+		return err
+	}
+
+	infos, err := src.ReadDir(p)
+	if err != nil {
+		return err
+	}
+
+	names := make(map[string]bool, len(infos))
+	for _, fi := range infos {
+		names[fi.Name()] = true
+		if err := resync(src, dst, filepath.Join(p, fi.Name())); err != nil {
+			return err
+		}
+	}
+
+	infos, err = dst.ReadDir(p)
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range infos {
+		if names[fi.Name()] {
+			continue
+		}
+
+		if err := util.RemoveAll(dst, filepath.Join(p, fi.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func copyFile(src, dst billy.Filesystem, p string, perm os.FileMode) error {
+	r, err := src.Open(p)
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	if err := dst.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	w, err := dst.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	if c, ok := dst.(billy.Change); ok {
+		return c.Chmod(p, perm)
+	}
+
+	return nil
+}
+
+// isBelow returns if p is, or is below, dir.
+func isBelow(p, dir string) bool {
+	return p == dir || dir == separator || strings.HasPrefix(p, dir+separator)
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
+}
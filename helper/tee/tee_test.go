@@ -0,0 +1,184 @@
+package tee
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/faultfs"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&TeeSuite{})
+
+var errUnavailable = errors.New("unavailable")
+
+type TeeSuite struct {
+	test.FilesystemSuite
+	primary billy.Filesystem
+	first   *faultfs.FaultFS
+	second  *faultfs.FaultFS
+}
+
+func (s *TeeSuite) SetUpTest(c *C) {
+	s.primary = memfs.New()
+	s.first = faultfs.New(memfs.New())
+	s.second = faultfs.New(memfs.New())
+
+	s.FilesystemSuite = test.NewFilesystemSuite(New(memfs.New(), Options{
+		Replicas: []billy.Filesystem{memfs.New()},
+	}))
+}
+
+func (s *TeeSuite) new(policy Policy) *Tee {
+	return New(s.primary, Options{
+		Replicas: []billy.Filesystem{s.first, s.second},
+		Policy:   policy,
+	})
+}
+
+func (s *TeeSuite) TestReplicate(c *C) {
+	fs := s.new(FailFast)
+	c.Assert(util.WriteFile(fs, "qux/foo", []byte("foo"), 0644), IsNil)
+	c.Assert(fs.Rename("qux/foo", "qux/bar"), IsNil)
+	c.Assert(fs.Symlink("bar", "qux/link"), IsNil)
+	c.Assert(fs.MkdirAll("baz", 0755), IsNil)
+
+	f, err := fs.OpenFile("qux/bar", os.O_RDWR, 0)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("b"))
+	c.Assert(err, IsNil)
+	_, err = f.Seek(2, 0)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("rqux"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Truncate(4), IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	tmp, err := fs.TempFile("baz", "tmp")
+	c.Assert(err, IsNil)
+	c.Assert(tmp.Close(), IsNil)
+	c.Assert(fs.Remove(tmp.Name()), IsNil)
+
+	for _, r := range []billy.Filesystem{s.primary, s.first, s.second} {
+		c.Assert(test.ReadFile(c, r, "qux/bar"), Equals, "borq")
+		c.Assert(test.ReadFile(c, r, "qux/link"), Equals, "borq")
+
+		_, err := r.Stat("qux/foo")
+		c.Assert(os.IsNotExist(err), Equals, true)
+
+		infos, err := r.ReadDir("baz")
+		c.Assert(err, IsNil)
+		c.Assert(infos, HasLen, 0)
+	}
+
+	c.Assert(fs.Pending(0), HasLen, 0)
+	c.Assert(fs.Pending(1), HasLen, 0)
+}
+
+func (s *TeeSuite) TestFailFast(c *C) {
+	fs := s.new(FailFast)
+	c.Assert(util.WriteFile(fs, "foo", []byte("foo"), 0644), IsNil)
+
+	s.first.Add(faultfs.Rule{Op: faultfs.OpRename, Nth: 1, Err: errUnavailable})
+	err := fs.Rename("foo", "bar")
+	c.Assert(err.(*os.PathError).Err, Equals, errUnavailable)
+
+	// the next replicas are not changed
+	c.Assert(test.ReadFile(c, s.second, "foo"), Equals, "foo")
+	c.Assert(fs.Pending(0), DeepEquals, []string{"/bar", "/foo"})
+	c.Assert(fs.Pending(1), DeepEquals, []string{"/bar", "/foo"})
+
+	c.Assert(fs.Flush(), IsNil)
+	for _, r := range []billy.Filesystem{s.first, s.second} {
+		c.Assert(test.ReadFile(c, r, "bar"), Equals, "foo")
+		_, err := r.Stat("foo")
+		c.Assert(os.IsNotExist(err), Equals, true)
+	}
+
+	c.Assert(fs.Pending(0), HasLen, 0)
+}
+
+func (s *TeeSuite) TestFailFastPrimary(c *C) {
+	fs := s.new(FailFast)
+	c.Assert(fs.Remove("missing"), NotNil)
+	c.Assert(fs.Pending(0), HasLen, 0)
+}
+
+func (s *TeeSuite) TestQueue(c *C) {
+	fs := s.new(Queue)
+
+	s.first.Add(faultfs.Rule{Op: faultfs.OpOpen, Nth: 1, Err: errUnavailable})
+	c.Assert(util.WriteFile(fs, "qux/foo", []byte("foo"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.second, "qux/foo"), Equals, "foo")
+
+	_, err := s.first.Stat("qux/foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+	c.Assert(fs.Pending(0), DeepEquals, []string{"/qux/foo"})
+
+	// the paths pending are copied before the next change
+	c.Assert(fs.MkdirAll("bar", 0755), IsNil)
+	c.Assert(test.ReadFile(c, s.first, "qux/foo"), Equals, "foo")
+	c.Assert(fs.Pending(0), HasLen, 0)
+
+	_, err = s.first.Stat("bar")
+	c.Assert(err, IsNil)
+}
+
+func (s *TeeSuite) TestQueueFile(c *C) {
+	fs := s.new(Queue)
+
+	f, err := fs.Create("foo")
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("foo"))
+	c.Assert(err, IsNil)
+
+	s.second.Add(faultfs.Rule{Op: faultfs.OpWrite, Nth: 1, Err: errUnavailable})
+	_, err = f.Write([]byte("bar"))
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("baz"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	c.Assert(test.ReadFile(c, s.first, "foo"), Equals, "foobarbaz")
+	c.Assert(test.ReadFile(c, s.second, "foo"), Equals, "foo")
+	c.Assert(fs.Pending(1), DeepEquals, []string{"/foo"})
+
+	c.Assert(fs.Flush(), IsNil)
+	c.Assert(test.ReadFile(c, s.second, "foo"), Equals, "foobarbaz")
+}
+
+func (s *TeeSuite) TestQueueUnavailable(c *C) {
+	fs := s.new(Queue)
+	s.first.Add(faultfs.Rule{Err: errUnavailable})
+
+	c.Assert(util.WriteFile(fs, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(fs.MkdirAll("bar", 0755), IsNil)
+	c.Assert(fs.Flush().(*os.PathError).Err, Equals, errUnavailable)
+	c.Assert(fs.Pending(0), DeepEquals, []string{"/bar", "/foo"})
+
+	s.first.Reset()
+	c.Assert(fs.Flush(), IsNil)
+	c.Assert(test.ReadFile(c, s.first, "foo"), Equals, "foo")
+}
+
+func (s *TeeSuite) TestChroot(c *C) {
+	fs := s.new(Queue)
+	chroot, err := fs.Chroot("qux")
+	c.Assert(err, IsNil)
+
+	s.first.Add(faultfs.Rule{Op: faultfs.OpOpen, Nth: 1, Err: errUnavailable})
+	c.Assert(util.WriteFile(chroot, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.second, "qux/foo"), Equals, "foo")
+	c.Assert(fs.Pending(0), DeepEquals, []string{"/qux/foo"})
+
+	c.Assert(fs.Flush(), IsNil)
+	c.Assert(test.ReadFile(c, s.first, "qux/foo"), Equals, "foo")
+}
@@ -0,0 +1,319 @@
+// Package timeout provides a helper bounding the time taken by the operations
+// of a filesystem, eg.: to not hang forever on an unresponsive network share.
+package timeout
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// ErrTimeout is returned, as the error of an *os.PathError, by the operations
+// not completed in time.
+var ErrTimeout = errors.New("operation timed out")
+
+// Options holds the configuration of a Timeout.
+type Options struct {
+	// Default is the timeout of the operations without their own, none if
+	// 0.
+	Default time.Duration
+	// Ops are the timeouts of every operation, by its name: "open"
+	// (including Create), "stat", "lstat", "readdir", "rename", "remove",
+	// "mkdirall", "symlink", "readlink", "tempfile", "chroot", and for the
+	// files "read" (including ReadAt), "write", "seek", "truncate", "close",
+	// "lock" and "unlock". A timeout of 0 disables the default one.
+	Ops map[string]time.Duration
+}
+
+// Timeout is a helper failing with ErrTimeout the operations of the
+// underlying filesystem, and of the files open from it, not completed before
+// their timeout.
+//
+// The operations timed out can't be aborted, so they are left running in the
+// background: the files open late are closed, and the data read or written
+// is copied, so the buffers given can be reused right away. Any other effect
+// of an operation timed out, as a file renamed, may still happen later, so
+// the underlying filesystem must be safe for concurrent use.
+type Timeout struct {
+	billy.Filesystem
+	opts Options
+}
+
+// New creates a new filesystem bounding the time taken by the operations of
+// the given one.
+func New(fs billy.Filesystem, opts Options) *Timeout {
+	return &Timeout{Filesystem: fs, opts: opts}
+}
+
+// timeout returns the timeout of the given operation, 0 if none.
+func (fs *Timeout) timeout(op string) time.Duration {
+	if d, ok := fs.opts.Ops[op]; ok {
+		return d
+	}
+
+	return fs.opts.Default
+}
+
+// call calls fn, waiting up to the timeout of the given operation. If timed
+// out, undo is called once fn returns, if succeeded.
+func (fs *Timeout) call(op, path string, fn func() error, undo func()) error {
+	d := fs.timeout(op)
+	if d <= 0 {
+		return fn()
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		if undo != nil {
+			go func() {
+				if err := <-done; err == nil {
+					undo()
+				}
+			}()
+		}
+
+		return &os.PathError{Op: op, Path: path, Err: ErrTimeout}
+	}
+}
+
+func (fs *Timeout) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Timeout) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (fs *Timeout) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	var f billy.File
+	err := fs.call("open", filename, func() (err error) {
+		f, err = fs.Filesystem.OpenFile(filename, flag, perm)
+		return err
+	}, func() { f.Close() })
+
+	return fs.file(&f, err)
+}
+
+func (fs *Timeout) TempFile(dir, prefix string) (billy.File, error) {
+	var f billy.File
+	err := fs.call("tempfile", dir, func() (err error) {
+		f, err = fs.Filesystem.TempFile(dir, prefix)
+		return err
+	}, func() { f.Close() })
+
+	return fs.file(&f, err)
+}
+
+// file wraps the given file, which mustn't be read if err isn't nil, since it
+// may be still being open.
+func (fs *Timeout) file(f *billy.File, err error) (billy.File, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	return &file{File: *f, fs: fs}, nil
+}
+
+func (fs *Timeout) Stat(filename string) (os.FileInfo, error) {
+	var fi os.FileInfo
+	err := fs.call("stat", filename, func() (err error) {
+		fi, err = fs.Filesystem.Stat(filename)
+		return err
+	}, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return fi, nil
+}
+
+func (fs *Timeout) Lstat(filename string) (os.FileInfo, error) {
+	var fi os.FileInfo
+	err := fs.call("lstat", filename, func() (err error) {
+		fi, err = fs.Filesystem.Lstat(filename)
+		return err
+	}, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return fi, nil
+}
+
+func (fs *Timeout) ReadDir(path string) ([]os.FileInfo, error) {
+	var infos []os.FileInfo
+	err := fs.call("readdir", path, func() (err error) {
+		infos, err = fs.Filesystem.ReadDir(path)
+		return err
+	}, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return infos, nil
+}
+
+func (fs *Timeout) Readlink(link string) (string, error) {
+	var target string
+	err := fs.call("readlink", link, func() (err error) {
+		target, err = fs.Filesystem.Readlink(link)
+		return err
+	}, nil)
+
+	if err != nil {
+		return "", err
+	}
+
+	return target, nil
+}
+
+func (fs *Timeout) Rename(from, to string) error {
+	return fs.call("rename", from, func() error {
+		return fs.Filesystem.Rename(from, to)
+	}, nil)
+}
+
+func (fs *Timeout) Remove(filename string) error {
+	return fs.call("remove", filename, func() error {
+		return fs.Filesystem.Remove(filename)
+	}, nil)
+}
+
+func (fs *Timeout) MkdirAll(filename string, perm os.FileMode) error {
+	return fs.call("mkdirall", filename, func() error {
+		return fs.Filesystem.MkdirAll(filename, perm)
+	}, nil)
+}
+
+func (fs *Timeout) Symlink(target, link string) error {
+	return fs.call("symlink", link, func() error {
+		return fs.Filesystem.Symlink(target, link)
+	}, nil)
+}
+
+// Chroot returns the given directory of the underlying filesystem, bounding
+// the time taken by its operations too.
+func (fs *Timeout) Chroot(path string) (billy.Filesystem, error) {
+	var chroot billy.Filesystem
+	err := fs.call("chroot", path, func() (err error) {
+		chroot, err = fs.Filesystem.Chroot(path)
+		return err
+	}, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Timeout{Filesystem: chroot, opts: fs.opts}, nil
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Timeout) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.Filesystem)
+}
+
+// file is a file bounding the time taken by its operations. The data read and
+// written is copied only if the operation may time out.
+type file struct {
+	billy.File
+	fs *Timeout
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	return f.read(p, func(b []byte) (int, error) {
+		return f.File.Read(b)
+	})
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	return f.read(p, func(b []byte) (int, error) {
+		return f.File.ReadAt(b, off)
+	})
+}
+
+// read reads to a copy of the given buffer, copied back only if not timed
+// out.
+func (f *file) read(p []byte, fn func([]byte) (int, error)) (int, error) {
+	if f.fs.timeout("read") <= 0 {
+		return fn(p)
+	}
+
+	var n int
+	var rerr error
+	b := make([]byte, len(p))
+	err := f.fs.call("read", f.Name(), func() error {
+		n, rerr = fn(b)
+		return nil
+	}, nil)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return copy(p, b[:n]), rerr
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	if f.fs.timeout("write") <= 0 {
+		return f.File.Write(p)
+	}
+
+	var n int
+	var werr error
+	b := append([]byte(nil), p...)
+	err := f.fs.call("write", f.Name(), func() error {
+		n, werr = f.File.Write(b)
+		return nil
+	}, nil)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return n, werr
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	var n int64
+	err := f.fs.call("seek", f.Name(), func() (err error) {
+		n, err = f.File.Seek(offset, whence)
+		return err
+	}, nil)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+func (f *file) Truncate(size int64) error {
+	return f.fs.call("truncate", f.Name(), func() error {
+		return f.File.Truncate(size)
+	}, nil)
+}
+
+func (f *file) Close() error {
+	return f.fs.call("close", f.Name(), f.File.Close, nil)
+}
+
+func (f *file) Lock() error {
+	return f.fs.call("lock", f.Name(), f.File.Lock, func() { f.File.Unlock() })
+}
+
+func (f *file) Unlock() error {
+	return f.fs.call("unlock", f.Name(), f.File.Unlock, nil)
+}
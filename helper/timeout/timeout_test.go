@@ -0,0 +1,78 @@
+package timeout
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4/helper/latency"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&TimeoutSuite{})
+
+type TimeoutSuite struct {
+	test.FilesystemSuite
+}
+
+func (s *TimeoutSuite) SetUpTest(c *C) {
+	s.FilesystemSuite = test.NewFilesystemSuite(New(memfs.New(), Options{
+		Default: time.Minute,
+	}))
+}
+
+func (s *TimeoutSuite) TestTimeout(c *C) {
+	// the operations timed out keep running, so osfs is used, being safe for
+	// concurrent use
+	slow := latency.New(osfs.New(c.MkDir()), latency.Options{Default: latency.Fixed(100 * time.Millisecond)})
+	fs := New(slow, Options{
+		Default: 10 * time.Millisecond,
+		Ops:     map[string]time.Duration{"mkdirall": 0, "stat": time.Minute},
+	})
+
+	start := time.Now()
+	_, err := fs.Create("foo")
+	c.Assert(err, NotNil)
+	c.Assert(err.(*os.PathError).Err, Equals, ErrTimeout)
+	c.Assert(time.Since(start) < 100*time.Millisecond, Equals, true)
+
+	// the operations without timeout are waited for
+	c.Assert(fs.MkdirAll("dir", 0755), IsNil)
+	_, err = fs.Stat("dir")
+	c.Assert(err, IsNil)
+}
+
+func (s *TimeoutSuite) TestFile(c *C) {
+	underlying := osfs.New(c.MkDir())
+	c.Assert(util.WriteFile(underlying, "foo", []byte("foo"), 0644), IsNil)
+
+	fs := New(latency.New(underlying, latency.Options{
+		Ops: map[string]latency.Distribution{"read": latency.Fixed(100 * time.Millisecond)},
+	}), Options{Ops: map[string]time.Duration{"read": 10 * time.Millisecond}})
+
+	f, err := fs.Open("foo")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	b := make([]byte, 3)
+	_, err = f.Read(b)
+	c.Assert(err.(*os.PathError).Err, Equals, ErrTimeout)
+	c.Assert(b, DeepEquals, make([]byte, 3))
+}
+
+func (s *TimeoutSuite) TestChroot(c *C) {
+	slow := latency.New(osfs.New(c.MkDir()), latency.Options{Default: latency.Fixed(100 * time.Millisecond)})
+	fs := New(slow, Options{Default: 10 * time.Millisecond, Ops: map[string]time.Duration{"chroot": 0}})
+
+	chroot, err := fs.Chroot("dir")
+	c.Assert(err, IsNil)
+	_, err = chroot.ReadDir("/")
+	c.Assert(err.(*os.PathError).Err, Equals, ErrTimeout)
+}
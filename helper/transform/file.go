@@ -0,0 +1,223 @@
+package transform
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// chunkSize is the size of the chunks read from the stored files.
+const chunkSize = 32 << 10
+
+// chain returns a Converter applying the given ones in order.
+func chain(converters []Converter) Converter {
+	return func(chunk []byte, eof bool) ([]byte, error) {
+		var err error
+		for _, c := range converters {
+			if chunk, err = c(chunk, eof); err != nil {
+				return nil, err
+			}
+		}
+
+		return chunk, nil
+	}
+}
+
+// reader is a file open for reading, decoding its content as read.
+type reader struct {
+	billy.File
+	transforms []Transform
+
+	decode  Converter
+	pending []byte
+	pos     int64
+	eof     bool
+	err     error
+	closed  bool
+}
+
+func newReader(f billy.File, transforms []Transform) *reader {
+	r := &reader{File: f, transforms: transforms}
+	r.reset()
+	return r
+}
+
+func (r *reader) reset() {
+	decoders := make([]Converter, len(r.transforms))
+	for i, t := range r.transforms {
+		decoders[i] = t.Decoder()
+	}
+
+	r.decode = chain(decoders)
+	r.pending, r.pos, r.eof, r.err = nil, 0, false, nil
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if r.closed {
+		return 0, os.ErrClosed
+	}
+
+	for len(r.pending) == 0 && !r.eof {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		r.fill()
+	}
+
+	if len(r.pending) == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	r.pos += int64(n)
+	return n, nil
+}
+
+// fill reads and decodes the next chunk of the stored file.
+func (r *reader) fill() {
+	chunk := make([]byte, chunkSize)
+	n, err := r.File.Read(chunk)
+	switch err {
+	case nil:
+	case io.EOF:
+		r.eof = true
+	default:
+		r.err = err
+		return
+	}
+
+	decoded, err := r.decode(chunk[:n], r.eof)
+	if err != nil {
+		r.err, r.eof = err, false
+		return
+	}
+
+	r.pending = append(r.pending, decoded...)
+}
+
+func (r *reader) ReadAt(p []byte, off int64) (int, error) {
+	return 0, &os.PathError{Op: "readat", Path: r.Name(), Err: billy.ErrNotSupported}
+}
+
+// Seek returns the current offset, or rewinds the file. Any other seek fails
+// with billy.ErrNotSupported.
+func (r *reader) Seek(offset int64, whence int) (int64, error) {
+	if r.closed {
+		return 0, os.ErrClosed
+	}
+
+	switch {
+	case whence == io.SeekCurrent && offset == 0:
+		return r.pos, nil
+	case whence == io.SeekStart && offset == 0:
+		if _, err := r.File.Seek(0, io.SeekStart); err != nil {
+			return 0, err
+		}
+
+		r.reset()
+		return 0, nil
+	}
+
+	return 0, &os.PathError{Op: "seek", Path: r.Name(), Err: billy.ErrNotSupported}
+}
+
+func (r *reader) Write(p []byte) (int, error) {
+	return 0, errors.New("write not supported")
+}
+
+func (r *reader) Truncate(size int64) error {
+	return errors.New("truncate not supported")
+}
+
+func (r *reader) Close() error {
+	if r.closed {
+		return os.ErrClosed
+	}
+
+	r.closed = true
+	return r.File.Close()
+}
+
+// writer is a file open for writing, encoding its content as written.
+type writer struct {
+	billy.File
+	encode Converter
+	pos    int64
+	closed bool
+}
+
+func newWriter(f billy.File, transforms []Transform) *writer {
+	encoders := make([]Converter, len(transforms))
+	for i, t := range transforms {
+		encoders[len(transforms)-1-i] = t.Encoder()
+	}
+
+	return &writer{File: f, encode: chain(encoders)}
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+
+	encoded, err := w.encode(p, false)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := w.File.Write(encoded); err != nil {
+		return 0, err
+	}
+
+	w.pos += int64(len(p))
+	return len(p), nil
+}
+
+func (w *writer) Read(p []byte) (int, error) {
+	return 0, errors.New("read not supported")
+}
+
+func (w *writer) ReadAt(p []byte, off int64) (int, error) {
+	return 0, errors.New("read not supported")
+}
+
+// Seek returns the current offset, any other seek fails with
+// billy.ErrNotSupported.
+func (w *writer) Seek(offset int64, whence int) (int64, error) {
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+
+	if whence == io.SeekCurrent && offset == 0 || whence == io.SeekStart && offset == w.pos {
+		return w.pos, nil
+	}
+
+	return 0, &os.PathError{Op: "seek", Path: w.Name(), Err: billy.ErrNotSupported}
+}
+
+func (w *writer) Truncate(size int64) error {
+	return &os.PathError{Op: "truncate", Path: w.Name(), Err: billy.ErrNotSupported}
+}
+
+// Close stores the content kept by the encoders, and closes the file.
+func (w *writer) Close() error {
+	if w.closed {
+		return os.ErrClosed
+	}
+
+	w.closed = true
+	encoded, err := w.encode(nil, true)
+	if err == nil {
+		_, err = w.File.Write(encoded)
+	}
+
+	if cerr := w.File.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
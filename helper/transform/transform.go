@@ -0,0 +1,162 @@
+// Package transform provides a helper converting the content of the files of a
+// filesystem, while read and written, between the way it's stored and the way
+// it's presented, eg.: to convert the line endings of the text files as git
+// does with core.autocrlf.
+package transform
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+const separator = string(filepath.Separator)
+
+// Converter converts the content of a file by chunks, as read or written. The
+// last chunk is flagged by eof, and may be empty. It may keep part of a chunk
+// to convert it along with the next one, eg.: a line ending split between
+// them, returning the content converted so far.
+type Converter func(chunk []byte, eof bool) ([]byte, error)
+
+// Transform is a conversion of the content of the files.
+type Transform interface {
+	// Decoder returns a new Converter, for a single file, from the content
+	// stored to the content presented.
+	Decoder() Converter
+	// Encoder returns a new Converter, for a single file, from the content
+	// presented to the content stored.
+	Encoder() Converter
+}
+
+// Rule is the transforms of the files matching the patterns.
+type Rule struct {
+	// Patterns are the shell patterns, as in filepath.Match, of the files.
+	// A pattern without separators is matched against the base name of the
+	// file, and against the full path otherwise. If empty, every file
+	// matches.
+	Patterns []string
+	// Transforms are the transforms applied to the content stored, in order,
+	// to present it. They are applied in the reverse order to store the
+	// content written.
+	Transforms []Transform
+}
+
+// Options holds the configuration of a Transformer.
+type Options struct {
+	// Rules are the transforms of the files, the first rule matching a file
+	// applies.
+	Rules []Rule
+}
+
+// Transformer is a helper converting the content of the files matching any
+// rule, streaming it, as read and written. Since the size of the content may
+// change, the files open for reading can only be read sequentially, or
+// rewound, and the files open for writing must be truncated, and can only be
+// written sequentially. Opening them otherwise fails with
+// billy.ErrNotSupported.
+//
+// The sizes reported by Stat and ReadDir are the ones of the stored files.
+type Transformer struct {
+	billy.Filesystem
+	opts Options
+	// dir is the directory the root was moved to by Chroot, as the patterns
+	// of the rules are matched with the paths from the original root.
+	dir string
+}
+
+// New creates a new filesystem wrapping up the given one, converting the
+// content of its files as configured by opts.
+func New(fs billy.Filesystem, opts Options) *Transformer {
+	return &Transformer{Filesystem: fs, opts: opts}
+}
+
+// Match returns the transforms of the given file, nil if none.
+func (fs *Transformer) Match(filename string) []Transform {
+	p := filepath.ToSlash(strings.TrimPrefix(filepath.Join(separator, fs.dir, filename), separator))
+	for _, rule := range fs.opts.Rules {
+		if match(rule.Patterns, p) {
+			return rule.Transforms
+		}
+	}
+
+	return nil
+}
+
+func match(patterns []string, p string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		pattern = filepath.ToSlash(pattern)
+
+		name := p
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(p)
+		}
+
+		if ok, _ := path.Match(strings.TrimPrefix(pattern, "/"), name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (fs *Transformer) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Transformer) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file, converting its content if matching any rule.
+// It fails with billy.ErrNotSupported if open for writing without truncating
+// it.
+func (fs *Transformer) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	transforms := fs.Match(filename)
+	if len(transforms) == 0 {
+		return fs.Filesystem.OpenFile(filename, flag, perm)
+	}
+
+	write := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	if write && (flag&os.O_TRUNC == 0 || flag&os.O_APPEND != 0) {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: billy.ErrNotSupported}
+	}
+
+	f, err := fs.Filesystem.OpenFile(filename, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	if write {
+		return newWriter(f, transforms), nil
+	}
+
+	return newReader(f, transforms), nil
+}
+
+// Chroot returns the given directory of the underlying filesystem, converting
+// the content of its files by the same rules, matched against the paths
+// relative to the root of the Transformer created by New.
+func (fs *Transformer) Chroot(path string) (billy.Filesystem, error) {
+	chroot, err := fs.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Transformer{
+		Filesystem: chroot,
+		opts:       fs.opts,
+		dir:        filepath.Join(separator, fs.dir, path),
+	}, nil
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Transformer) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.Filesystem)
+}
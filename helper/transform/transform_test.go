@@ -0,0 +1,153 @@
+package transform
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/charset"
+	"gopkg.in/src-d/go-billy.v4/helper/eol"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&TransformSuite{})
+
+type TransformSuite struct {
+	test.FilesystemSuite
+	underlying billy.Filesystem
+	FS         *Transformer
+}
+
+func (s *TransformSuite) SetUpTest(c *C) {
+	s.underlying = memfs.New()
+	s.FS = New(s.underlying, Options{Rules: []Rule{{
+		Patterns:   []string{"*.txt", "docs/*"},
+		Transforms: []Transform{EOL(eol.LF, eol.CRLF)},
+	}, {
+		Patterns:   []string{"*.latin1"},
+		Transforms: []Transform{StripBOM(), Charset(charset.Latin1), EOL(eol.LF, eol.CRLF)},
+	}}})
+
+	s.FilesystemSuite = test.NewFilesystemSuite(s.FS)
+}
+
+func (s *TransformSuite) TestRead(c *C) {
+	c.Assert(util.WriteFile(s.underlying, "foo.txt", []byte("foo\nbar\r\nbaz\n"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "foo.txt"), Equals, "foo\r\nbar\r\nbaz\r\n")
+}
+
+func (s *TransformSuite) TestReadLarge(c *C) {
+	content := strings.Repeat("foo\r\nbar\n", chunkSize/5)
+	c.Assert(util.WriteFile(s.underlying, "foo.txt", []byte(content), 0644), IsNil)
+
+	expected := strings.Replace(content, "bar\n", "bar\r\n", -1)
+	c.Assert(test.ReadFile(c, s.FS, "foo.txt"), Equals, expected)
+}
+
+func (s *TransformSuite) TestReadBinary(c *C) {
+	content := "foo\x00bar\nbaz\r\n"
+	c.Assert(util.WriteFile(s.underlying, "foo.txt", []byte(content), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "foo.txt"), Equals, content)
+}
+
+func (s *TransformSuite) TestWrite(c *C) {
+	f, err := s.FS.Create("docs/foo")
+	c.Assert(err, IsNil)
+
+	// the CRLF is split between writes
+	for _, chunk := range []string{"foo\r", "\nbar\r", "\n"} {
+		_, err = f.Write([]byte(chunk))
+		c.Assert(err, IsNil)
+	}
+
+	c.Assert(f.Close(), IsNil)
+	c.Assert(test.ReadFile(c, s.underlying, "docs/foo"), Equals, "foo\nbar\n")
+	c.Assert(test.ReadFile(c, s.FS, "docs/foo"), Equals, "foo\r\nbar\r\n")
+}
+
+func (s *TransformSuite) TestWriteNotTruncated(c *C) {
+	c.Assert(util.WriteFile(s.underlying, "foo.txt", []byte("foo\n"), 0644), IsNil)
+
+	_, err := s.FS.OpenFile("foo.txt", os.O_WRONLY|os.O_APPEND, 0)
+	c.Assert(err, NotNil)
+	c.Assert(err.(*os.PathError).Err, Equals, billy.ErrNotSupported)
+
+	_, err = s.FS.OpenFile("foo.txt", os.O_RDWR, 0)
+	c.Assert(err.(*os.PathError).Err, Equals, billy.ErrNotSupported)
+}
+
+func (s *TransformSuite) TestSeek(c *C) {
+	c.Assert(util.WriteFile(s.underlying, "foo.txt", []byte("foo\nbar\n"), 0644), IsNil)
+
+	f, err := s.FS.Open("foo.txt")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(f, buf)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf), Equals, "foo\r\n")
+
+	pos, err := f.Seek(0, io.SeekCurrent)
+	c.Assert(err, IsNil)
+	c.Assert(pos, Equals, int64(5))
+
+	_, err = f.Seek(2, io.SeekStart)
+	c.Assert(err.(*os.PathError).Err, Equals, billy.ErrNotSupported)
+
+	pos, err = f.Seek(0, io.SeekStart)
+	c.Assert(err, IsNil)
+	c.Assert(pos, Equals, int64(0))
+
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "foo\r\nbar\r\n")
+}
+
+func (s *TransformSuite) TestCharset(c *C) {
+	c.Assert(util.WriteFile(s.underlying, "foo.latin1", []byte("\xef\xbb\xbfcaf\xe9\n"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "foo.latin1"), Equals, "café\r\n")
+
+	f, err := s.FS.Create("bar.latin1")
+	c.Assert(err, IsNil)
+	// the é is split between writes
+	for _, chunk := range []string{"d\xc3", "\xa9j\xc3\xa0 vu\r\n"} {
+		_, err = f.Write([]byte(chunk))
+		c.Assert(err, IsNil)
+	}
+
+	c.Assert(f.Close(), IsNil)
+	c.Assert(test.ReadFile(c, s.underlying, "bar.latin1"), Equals, "d\xe9j\xe0 vu\n")
+
+	err = util.WriteFile(s.FS, "baz.latin1", []byte("\xe2\x82\xac"), 0644)
+	c.Assert(err, Equals, charset.ErrUnencodable)
+}
+
+func (s *TransformSuite) TestStripBOM(c *C) {
+	fs := New(s.underlying, Options{Rules: []Rule{{
+		Transforms: []Transform{StripBOM()},
+	}}})
+
+	c.Assert(util.WriteFile(s.underlying, "foo", []byte("\xef\xbb\xbffoo"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, fs, "foo"), Equals, "foo")
+
+	c.Assert(util.WriteFile(s.underlying, "bar", []byte("\xef\xbb"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, fs, "bar"), Equals, "\xef\xbb")
+}
+
+func (s *TransformSuite) TestChroot(c *C) {
+	c.Assert(util.WriteFile(s.underlying, "docs/foo", []byte("foo\n"), 0644), IsNil)
+
+	chroot, err := s.FS.Chroot("docs")
+	c.Assert(err, IsNil)
+	c.Assert(test.ReadFile(c, chroot, "foo"), Equals, "foo\r\n")
+}
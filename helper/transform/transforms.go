@@ -0,0 +1,184 @@
+package transform
+
+import (
+	"bytes"
+	"unicode/utf8"
+
+	"gopkg.in/src-d/go-billy.v4/helper/charset"
+	"gopkg.in/src-d/go-billy.v4/helper/eol"
+)
+
+// binaryCheckSize is the amount of bytes inspected to detect binary content,
+// the same used by eol.IsBinary.
+const binaryCheckSize = 8000
+
+var bom = []byte{0xef, 0xbb, 0xbf}
+
+// EOL returns a Transform converting the line endings of the text files, from
+// stored to presented when read, and back when written. The files detected
+// as binary by eol.IsBinary are left untouched.
+func EOL(stored, presented eol.LineEnding) Transform {
+	return &eolTransform{stored: stored, presented: presented}
+}
+
+type eolTransform struct {
+	stored, presented eol.LineEnding
+}
+
+func (t *eolTransform) Decoder() Converter {
+	return newEOLConverter(t.presented)
+}
+
+func (t *eolTransform) Encoder() Converter {
+	return newEOLConverter(t.stored)
+}
+
+// newEOLConverter returns a Converter converting the line endings to the
+// given one. The content is held until enough is seen to detect whether it's
+// binary, and a trailing CR is held until the next chunk.
+func newEOLConverter(to eol.LineEnding) Converter {
+	var buf []byte
+	var checked, binary bool
+
+	return func(chunk []byte, eof bool) ([]byte, error) {
+		buf = append(buf, chunk...)
+		if !checked {
+			if len(buf) < binaryCheckSize && !eof {
+				return nil, nil
+			}
+
+			checked, binary = true, eol.IsBinary(buf)
+		}
+
+		if binary {
+			out := buf
+			buf = nil
+			return out, nil
+		}
+
+		var held []byte
+		if !eof && len(buf) != 0 && buf[len(buf)-1] == '\r' {
+			buf, held = buf[:len(buf)-1], []byte{'\r'}
+		}
+
+		out := eol.Convert(buf, to)
+		buf = held
+		return out, nil
+	}
+}
+
+// StripBOM returns a Transform removing the UTF-8 byte order mark from the
+// start of the files when read. The content written is stored as is.
+func StripBOM() Transform {
+	return stripBOM{}
+}
+
+type stripBOM struct{}
+
+func (stripBOM) Decoder() Converter {
+	var buf []byte
+	var checked bool
+
+	return func(chunk []byte, eof bool) ([]byte, error) {
+		if checked {
+			return chunk, nil
+		}
+
+		buf = append(buf, chunk...)
+		if len(buf) < len(bom) && !eof {
+			return nil, nil
+		}
+
+		checked = true
+		return bytes.TrimPrefix(buf, bom), nil
+	}
+}
+
+func (stripBOM) Encoder() Converter {
+	return func(chunk []byte, eof bool) ([]byte, error) {
+		return chunk, nil
+	}
+}
+
+// Charset returns a Transform transcoding the content of the files, stored in
+// the given encoding, to UTF-8 when read, and back when written. The bytes
+// that can't be decoded are presented escaped in the charset.EscapeBase
+// range, as charset.Decode does, so they are stored back untouched. Writing
+// characters not supported by the encoding, or not valid UTF-8, fails with
+// charset.ErrUnencodable.
+func Charset(enc charset.Encoding) Transform {
+	return &charsetTransform{enc: enc}
+}
+
+type charsetTransform struct {
+	enc charset.Encoding
+}
+
+func (t *charsetTransform) Decoder() Converter {
+	var buf []byte
+
+	return func(chunk []byte, eof bool) ([]byte, error) {
+		buf = append(buf, chunk...)
+
+		var out []byte
+		p := buf
+		for len(p) != 0 {
+			r, size := t.enc.DecodeRune(p)
+			if size == 0 {
+				// it may be the start of a character split between chunks
+				if !eof && len(p) < utf8.UTFMax {
+					break
+				}
+
+				r, size = charset.EscapeBase+rune(p[0]), 1
+			}
+
+			out = appendUTF8(out, r)
+			p = p[size:]
+		}
+
+		buf = append(buf[:0], p...)
+		return out, nil
+	}
+}
+
+func (t *charsetTransform) Encoder() Converter {
+	var buf []byte
+
+	return func(chunk []byte, eof bool) ([]byte, error) {
+		buf = append(buf, chunk...)
+
+		var out []byte
+		p := buf
+		for len(p) != 0 {
+			if !eof && !utf8.FullRune(p) {
+				break
+			}
+
+			r, size := utf8.DecodeRune(p)
+			if r == utf8.RuneError && size == 1 {
+				return nil, charset.ErrUnencodable
+			}
+
+			p = p[size:]
+			if r >= charset.EscapeBase && r <= charset.EscapeBase+0xff {
+				out = append(out, byte(r-charset.EscapeBase))
+				continue
+			}
+
+			var ok bool
+			if out, ok = t.enc.AppendRune(out, r); !ok {
+				return nil, charset.ErrUnencodable
+			}
+		}
+
+		buf = append(buf[:0], p...)
+		return out, nil
+	}
+}
+
+func appendUTF8(p []byte, r rune) []byte {
+	var buf [utf8.UTFMax]byte
+	n := utf8.EncodeRune(buf[:], r)
+	return append(p, buf[:n]...)
+}
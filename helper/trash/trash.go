@@ -0,0 +1,393 @@
+// Package trash provides a helper moving the files removed from a filesystem
+// to a hidden trash, from where they can be restored, eg.: to protect the
+// users of interactive tools from losing data by accident.
+package trash
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+// DefaultDir is the name of the trash used if none is given.
+const DefaultDir = ".trash"
+
+const (
+	separator = string(filepath.Separator)
+
+	// itemExt and infoExt are the extensions of the file removed, and of the
+	// file with the information about it, of every item of the trash.
+	itemExt = ".item"
+	infoExt = ".info"
+)
+
+// Options holds the configuration of a Trash.
+type Options struct {
+	// Dir is the name of the directory, in the root of the underlying
+	// filesystem, where the files removed are kept, DefaultDir if empty.
+	Dir string
+	// Clock returns the time recorded for the files removed, time.Now if
+	// nil.
+	Clock func() time.Time
+}
+
+// Item is a file, directory or link in the trash.
+type Item struct {
+	// Path is the path the item was removed from, relative to the root of
+	// the Trash created by New.
+	Path string `json:"path"`
+	// Time is the time the item was removed.
+	Time time.Time `json:"time"`
+
+	id string
+}
+
+// Trash is a helper moving the files, and empty directories, removed from the
+// underlying filesystem to a hidden directory, from where they can be
+// restored with Restore, until purged by Empty. RemoveAll, used by
+// util.RemoveAll, moves whole directories as a single item.
+//
+// The trash is in the same filesystem, so the files are moved by Rename, and
+// their space isn't freed until purged.
+type Trash struct {
+	billy.Filesystem
+	s *state
+	// dir is the directory the root was moved to by Chroot, as the items
+	// are restored to their paths from the original root.
+	dir string
+}
+
+// state is the state shared by a Trash and its chroots.
+type state struct {
+	fs    billy.Filesystem
+	dir   string
+	clock func() time.Time
+
+	m    sync.Mutex
+	next int
+}
+
+// New creates a new filesystem moving to a trash the files removed from the
+// given one.
+func New(fs billy.Filesystem, opts Options) *Trash {
+	if opts.Dir == "" {
+		opts.Dir = DefaultDir
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = time.Now
+	}
+
+	return &Trash{Filesystem: fs, s: &state{fs: fs, dir: opts.Dir, clock: opts.Clock}}
+}
+
+// Items returns the items in the trash, the least recently removed first.
+func (fs *Trash) Items() ([]Item, error) {
+	fs.s.m.Lock()
+	defer fs.s.m.Unlock()
+
+	return fs.s.items()
+}
+
+// Restore moves back the item removed the last from the given path. It fails
+// with os.ErrNotExist if there's none, and with os.ErrExist if the path
+// exists.
+func (fs *Trash) Restore(path string) error {
+	fs.s.m.Lock()
+	defer fs.s.m.Unlock()
+
+	if _, err := fs.Filesystem.Lstat(path); err == nil {
+		return &os.PathError{Op: "restore", Path: path, Err: os.ErrExist}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	items, err := fs.s.items()
+	if err != nil {
+		return err
+	}
+
+	p := fs.path(path)
+	for i := len(items) - 1; i >= 0; i-- {
+		if items[i].Path != p {
+			continue
+		}
+
+		if err := fs.s.fs.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return err
+		}
+
+		name := filepath.Join(fs.s.dir, items[i].id)
+		if err := fs.s.fs.Rename(name+itemExt, p); err != nil {
+			return err
+		}
+
+		return fs.s.fs.Remove(name + infoExt)
+	}
+
+	return &os.PathError{Op: "restore", Path: path, Err: os.ErrNotExist}
+}
+
+// Empty purges the items removed longer than the given duration ago, every
+// one if 0.
+func (fs *Trash) Empty(olderThan time.Duration) error {
+	fs.s.m.Lock()
+	defer fs.s.m.Unlock()
+
+	items, err := fs.s.items()
+	if err != nil {
+		return err
+	}
+
+	limit := fs.s.clock().Add(-olderThan)
+	for _, item := range items {
+		if olderThan != 0 && !item.Time.Before(limit) {
+			continue
+		}
+
+		name := filepath.Join(fs.s.dir, item.id)
+		if err := util.RemoveAll(fs.s.fs, name+itemExt); err != nil {
+			return err
+		}
+
+		if err := fs.s.fs.Remove(name + infoExt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// items returns the items in the trash, sorted by time. It must be called
+// with the lock held.
+func (s *state) items() ([]Item, error) {
+	infos, err := s.fs.ReadDir(s.dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var items []Item
+	for _, fi := range infos {
+		if !strings.HasSuffix(fi.Name(), infoExt) {
+			continue
+		}
+
+		item, err := s.item(strings.TrimSuffix(fi.Name(), infoExt))
+		if os.IsNotExist(err) {
+			// not moved to the trash yet
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, item)
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].Time.Equal(items[j].Time) {
+			return items[i].id < items[j].id
+		}
+
+		return items[i].Time.Before(items[j].Time)
+	})
+
+	return items, nil
+}
+
+func (s *state) item(id string) (Item, error) {
+	name := filepath.Join(s.dir, id)
+	if _, err := s.fs.Lstat(name + itemExt); err != nil {
+		return Item{}, err
+	}
+
+	f, err := s.fs.Open(name + infoExt)
+	if err != nil {
+		return Item{}, err
+	}
+
+	defer f.Close()
+
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return Item{}, err
+	}
+
+	var item Item
+	if err := json.Unmarshal(content, &item); err != nil {
+		return Item{}, err
+	}
+
+	item.id = id
+	return item, nil
+}
+
+// trash moves the given path to a new item of the trash.
+func (s *state) trash(p string) error {
+	s.m.Lock()
+	now := s.clock()
+	s.next++
+	id := fmt.Sprintf("%020d-%d", now.UnixNano(), s.next)
+	s.m.Unlock()
+
+	content, err := json.Marshal(Item{Path: p, Time: now})
+	if err != nil {
+		return err
+	}
+
+	name := filepath.Join(s.dir, id)
+	if err := util.WriteFile(s.fs, name+infoExt, content, 0644); err != nil {
+		s.fs.Remove(name + infoExt)
+		return err
+	}
+
+	if err := s.fs.Rename(p, name+itemExt); err != nil {
+		s.fs.Remove(name + infoExt)
+		return err
+	}
+
+	return nil
+}
+
+// path returns the given path relative to the root of the underlying
+// filesystem.
+func (fs *Trash) path(p string) string {
+	return filepath.Join(separator, fs.dir, p)
+}
+
+// isTrash returns if the given path is the trash, or is in it.
+func (fs *Trash) isTrash(p string) bool {
+	return isBelow(fs.path(p), filepath.Join(separator, fs.s.dir))
+}
+
+func (fs *Trash) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Trash) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (fs *Trash) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if fs.isTrash(filename) {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrPermission}
+	}
+
+	return fs.Filesystem.OpenFile(filename, flag, perm)
+}
+
+// ReadDir reads the given directory, hiding the trash.
+func (fs *Trash) ReadDir(path string) ([]os.FileInfo, error) {
+	infos, err := fs.Filesystem.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var visible []os.FileInfo
+	for _, fi := range infos {
+		if fs.isTrash(filepath.Join(path, fi.Name())) {
+			continue
+		}
+
+		visible = append(visible, fi)
+	}
+
+	return visible, nil
+}
+
+func (fs *Trash) Rename(from, to string) error {
+	if fs.isTrash(from) || fs.isTrash(to) {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: os.ErrPermission}
+	}
+
+	return fs.Filesystem.Rename(from, to)
+}
+
+func (fs *Trash) MkdirAll(filename string, perm os.FileMode) error {
+	if fs.isTrash(filename) {
+		return &os.PathError{Op: "mkdir", Path: filename, Err: os.ErrPermission}
+	}
+
+	return fs.Filesystem.MkdirAll(filename, perm)
+}
+
+func (fs *Trash) Symlink(target, link string) error {
+	if fs.isTrash(link) {
+		return &os.PathError{Op: "symlink", Path: link, Err: os.ErrPermission}
+	}
+
+	return fs.Filesystem.Symlink(target, link)
+}
+
+// Remove moves the given file, or empty directory, to the trash.
+func (fs *Trash) Remove(filename string) error {
+	if fs.isTrash(filename) {
+		return &os.PathError{Op: "remove", Path: filename, Err: os.ErrPermission}
+	}
+
+	fi, err := fs.Filesystem.Lstat(filename)
+	if err != nil {
+		// the filesystem returns its own error
+		return fs.Filesystem.Remove(filename)
+	}
+
+	if fi.IsDir() {
+		infos, err := fs.Filesystem.ReadDir(filename)
+		if err != nil {
+			return err
+		}
+
+		if len(infos) != 0 {
+			return fs.Filesystem.Remove(filename)
+		}
+	}
+
+	return fs.s.trash(fs.path(filename))
+}
+
+// RemoveAll moves the given path, and any children it contains, to the trash
+// as a single item. It's used by util.RemoveAll.
+func (fs *Trash) RemoveAll(path string) error {
+	if fs.isTrash(path) {
+		return &os.PathError{Op: "removeall", Path: path, Err: os.ErrPermission}
+	}
+
+	if _, err := fs.Filesystem.Lstat(path); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return fs.s.trash(fs.path(path))
+}
+
+// Chroot returns the given directory of the underlying filesystem, moving the
+// files removed to the same trash.
+func (fs *Trash) Chroot(path string) (billy.Filesystem, error) {
+	chroot, err := fs.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Trash{Filesystem: chroot, s: fs.s, dir: fs.path(path)}, nil
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Trash) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.Filesystem)
+}
+
+// isBelow returns if p is, or is below, dir.
+func isBelow(p, dir string) bool {
+	return p == dir || dir == separator || strings.HasPrefix(p, dir+separator)
+}
@@ -0,0 +1,148 @@
+package trash
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&TrashSuite{})
+
+type TrashSuite struct {
+	test.FilesystemSuite
+	underlying billy.Filesystem
+	now        time.Time
+}
+
+func (s *TrashSuite) SetUpTest(c *C) {
+	s.underlying = memfs.New()
+	s.now = time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	// the suite removes many files, moved by Rename, which is slow in memfs
+	// as the trash grows
+	s.FilesystemSuite = test.NewFilesystemSuite(New(osfs.New(c.MkDir()), Options{}))
+}
+
+func (s *TrashSuite) new() *Trash {
+	return New(s.underlying, Options{Clock: func() time.Time { return s.now }})
+}
+
+func (s *TrashSuite) TestRemoveAndRestore(c *C) {
+	fs := s.new()
+	c.Assert(util.WriteFile(fs, "qux/foo", []byte("first"), 0644), IsNil)
+	c.Assert(fs.Remove("qux/foo"), IsNil)
+
+	s.now = s.now.Add(time.Hour)
+	c.Assert(util.WriteFile(fs, "qux/foo", []byte("second"), 0644), IsNil)
+	c.Assert(fs.Remove("qux/foo"), IsNil)
+
+	_, err := fs.Stat("qux/foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	items, err := fs.Items()
+	c.Assert(err, IsNil)
+	c.Assert(items, HasLen, 2)
+	c.Assert(items[0].Path, Equals, "/qux/foo")
+	c.Assert(items[0].Time.Equal(s.now.Add(-time.Hour)), Equals, true)
+
+	// the item removed the last is restored first
+	c.Assert(fs.Restore("qux/foo"), IsNil)
+	c.Assert(test.ReadFile(c, fs, "qux/foo"), Equals, "second")
+
+	err = fs.Restore("qux/foo")
+	c.Assert(os.IsExist(err), Equals, true)
+
+	c.Assert(fs.Remove("qux/foo"), IsNil)
+	c.Assert(fs.Restore("qux/foo"), IsNil)
+	c.Assert(test.ReadFile(c, fs, "qux/foo"), Equals, "second")
+
+	c.Assert(util.RemoveAll(fs, "qux"), IsNil)
+	c.Assert(fs.Restore("qux/foo"), IsNil)
+	c.Assert(test.ReadFile(c, fs, "qux/foo"), Equals, "first")
+
+	err = fs.Restore("missing")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *TrashSuite) TestRemoveAll(c *C) {
+	fs := s.new()
+	c.Assert(util.WriteFile(fs, "qux/foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(fs, "qux/bar/baz", []byte("baz"), 0644), IsNil)
+	c.Assert(util.RemoveAll(fs, "qux"), IsNil)
+	c.Assert(util.RemoveAll(fs, "missing"), IsNil)
+
+	items, err := fs.Items()
+	c.Assert(err, IsNil)
+	c.Assert(items, HasLen, 1)
+
+	c.Assert(fs.Restore("qux"), IsNil)
+	c.Assert(test.ReadFile(c, fs, "qux/foo"), Equals, "foo")
+	c.Assert(test.ReadFile(c, fs, "qux/bar/baz"), Equals, "baz")
+
+	// the directories not empty are removed only by RemoveAll
+	c.Assert(fs.Remove("qux"), NotNil)
+}
+
+func (s *TrashSuite) TestEmpty(c *C) {
+	fs := s.new()
+	c.Assert(util.WriteFile(fs, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(fs.Remove("foo"), IsNil)
+
+	s.now = s.now.Add(2 * time.Hour)
+	c.Assert(util.WriteFile(fs, "bar", []byte("bar"), 0644), IsNil)
+	c.Assert(fs.Remove("bar"), IsNil)
+
+	s.now = s.now.Add(time.Hour)
+	c.Assert(fs.Empty(2*time.Hour), IsNil)
+
+	items, err := fs.Items()
+	c.Assert(err, IsNil)
+	c.Assert(items, HasLen, 1)
+	c.Assert(items[0].Path, Equals, "/bar")
+
+	c.Assert(fs.Empty(0), IsNil)
+	items, err = fs.Items()
+	c.Assert(err, IsNil)
+	c.Assert(items, HasLen, 0)
+}
+
+func (s *TrashSuite) TestHidden(c *C) {
+	fs := s.new()
+	c.Assert(util.WriteFile(fs, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(fs.Remove("foo"), IsNil)
+
+	infos, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 0)
+
+	_, err = fs.Open(DefaultDir)
+	c.Assert(os.IsPermission(err), Equals, true)
+	c.Assert(os.IsPermission(fs.Remove(DefaultDir)), Equals, true)
+	c.Assert(os.IsPermission(util.RemoveAll(fs, DefaultDir+"/foo")), Equals, true)
+}
+
+func (s *TrashSuite) TestChroot(c *C) {
+	fs := s.new()
+	chroot, err := fs.Chroot("qux")
+	c.Assert(err, IsNil)
+
+	c.Assert(util.WriteFile(chroot, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(chroot.Remove("foo"), IsNil)
+
+	items, err := fs.Items()
+	c.Assert(err, IsNil)
+	c.Assert(items, HasLen, 1)
+	c.Assert(items[0].Path, Equals, "/qux/foo")
+
+	c.Assert(chroot.(*Trash).Restore("foo"), IsNil)
+	c.Assert(test.ReadFile(c, fs, "qux/foo"), Equals, "foo")
+}
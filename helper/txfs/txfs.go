@@ -0,0 +1,227 @@
+// Package txfs provides a helper staging the changes made to a filesystem,
+// to apply them together once complete, or discard them, eg.: to update a
+// set of files without leaving them half written.
+package txfs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/overlay"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const separator = string(filepath.Separator)
+
+// Options holds the configuration of a Tx.
+type Options struct {
+	// Staging is the filesystem where the changes are staged, memory if
+	// nil. It should be a filesystem used only by the helper.
+	Staging billy.Filesystem
+}
+
+// Tx is a transaction over the underlying filesystem. Every change is staged
+// in an overlay over it, presenting the underlying filesystem as changed,
+// until applied by Commit, or discarded by Rollback. Both start a new
+// transaction.
+//
+// Every file is replaced atomically when committed: it's written to a
+// temporary file, synced to the storage if supported, as by osfs, and renamed
+// over the old one. The transaction as a whole is applied on a best-effort
+// basis, and an interrupted commit may leave only some files changed, but is
+// kept staged, so it can be committed again.
+//
+// It's not safe for concurrent use, and the files must be closed before
+// committing or rolling back.
+type Tx struct {
+	*overlay.Overlay
+	underlying billy.Filesystem
+	staging    billy.Filesystem
+}
+
+// New starts a new transaction over the given filesystem.
+func New(fs billy.Filesystem, opts Options) *Tx {
+	if opts.Staging == nil {
+		opts.Staging = memfs.New()
+	}
+
+	return &Tx{
+		Overlay:    overlay.New(opts.Staging, fs),
+		underlying: fs,
+		staging:    opts.Staging,
+	}
+}
+
+// Commit applies the changes staged to the underlying filesystem: first
+// removing the files removed, and then creating the directories and writing
+// the files and symlinks changed.
+func (tx *Tx) Commit() error {
+	whiteouts, err := tx.Whiteouts()
+	if err != nil {
+		return err
+	}
+
+	for _, p := range whiteouts {
+		if err := util.RemoveAll(tx.underlying, p); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.apply(separator); err != nil {
+		return err
+	}
+
+	return tx.Rollback()
+}
+
+// apply applies the changes staged below the given directory.
+func (tx *Tx) apply(dir string) error {
+	infos, err := tx.staging.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range infos {
+		p := filepath.Join(dir, fi.Name())
+		if p == overlay.WhiteoutDir {
+			continue
+		}
+
+		switch {
+		case fi.IsDir():
+			if err := tx.underlying.MkdirAll(p, fi.Mode().Perm()); err != nil {
+				return err
+			}
+
+			err = tx.apply(p)
+		case fi.Mode()&os.ModeSymlink != 0:
+			err = tx.symlink(p)
+		default:
+			err = tx.replace(p, fi.Mode().Perm())
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (tx *Tx) symlink(p string) error {
+	target, err := tx.staging.Readlink(p)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.underlying.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return tx.underlying.Symlink(target, p)
+}
+
+// replace replaces the given file of the underlying filesystem with the
+// staged one, writing it first to a temporary file in the same directory.
+func (tx *Tx) replace(p string, perm os.FileMode) error {
+	dir := filepath.Dir(p)
+	tmp, err := util.TempFile(tx.underlying, dir, "."+filepath.Base(p)+".tx")
+	if err != nil {
+		return err
+	}
+
+	name := filepath.Join(dir, filepath.Base(tmp.Name()))
+	if err := tx.write(p, tmp); err != nil {
+		tx.underlying.Remove(name)
+		return err
+	}
+
+	if err := tx.underlying.Rename(name, p); err != nil {
+		tx.underlying.Remove(name)
+		return err
+	}
+
+	// the permissions of the temporary files are fixed
+	if err := chmod(tx.underlying, p, perm); err != nil {
+		return err
+	}
+
+	syncDir(tx.underlying, dir)
+	return nil
+}
+
+// write copies the staged file to the given one, syncing and closing it.
+func (tx *Tx) write(p string, dst billy.File) error {
+	src, err := tx.staging.Open(p)
+	if err != nil {
+		dst.Close()
+		return err
+	}
+
+	defer src.Close()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+
+	if err := sync(dst); err != nil {
+		dst.Close()
+		return err
+	}
+
+	return dst.Close()
+}
+
+// Rollback discards the changes staged.
+func (tx *Tx) Rollback() error {
+	infos, err := tx.staging.ReadDir(separator)
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range infos {
+		if err := util.RemoveAll(tx.staging, fi.Name()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sync commits the content of the given file to the storage, if supported.
+func sync(f billy.File) error {
+	if s, ok := f.(interface{ Sync() error }); ok {
+		return s.Sync()
+	}
+
+	return nil
+}
+
+// syncDir commits the entries of the given directory to the storage, if
+// supported, so the renames are durable. It's best-effort, since not every
+// system can sync directories.
+func syncDir(fs billy.Filesystem, dir string) {
+	f, err := fs.Open(dir)
+	if err != nil {
+		return
+	}
+
+	sync(f)
+	f.Close()
+}
+
+// chmod changes the permissions of the given file, if supported by the
+// filesystem. Otherwise, the file keeps the permissions of the temporary
+// files.
+func chmod(fs billy.Filesystem, p string, perm os.FileMode) error {
+	c, ok := fs.(billy.Change)
+	if !ok {
+		return nil
+	}
+
+	return c.Chmod(p, perm)
+}
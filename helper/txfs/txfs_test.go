@@ -0,0 +1,99 @@
+package txfs
+
+import (
+	"os"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&TxSuite{})
+
+type TxSuite struct {
+	test.FilesystemSuite
+	underlying billy.Filesystem
+	tx         *Tx
+}
+
+func (s *TxSuite) SetUpTest(c *C) {
+	s.underlying = memfs.New()
+	s.tx = New(s.underlying, Options{})
+	s.FilesystemSuite = test.NewFilesystemSuite(s.tx)
+}
+
+func (s *TxSuite) TestCommit(c *C) {
+	c.Assert(util.WriteFile(s.underlying, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.underlying, "dir/bar", []byte("bar"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.underlying, "qux", []byte("qux"), 0644), IsNil)
+
+	c.Assert(util.WriteFile(s.tx, "foo", []byte("changed"), 0644), IsNil)
+	c.Assert(s.tx.Rename("dir", "new"), IsNil)
+	c.Assert(s.tx.Remove("qux"), IsNil)
+	c.Assert(s.tx.Symlink("foo", "link"), IsNil)
+
+	// nothing is applied until committed
+	c.Assert(test.ReadFile(c, s.underlying, "foo"), Equals, "foo")
+	c.Assert(test.ReadFile(c, s.underlying, "dir/bar"), Equals, "bar")
+	c.Assert(test.ReadFile(c, s.tx, "new/bar"), Equals, "bar")
+
+	c.Assert(s.tx.Commit(), IsNil)
+
+	c.Assert(test.ReadFile(c, s.underlying, "foo"), Equals, "changed")
+	c.Assert(test.ReadFile(c, s.underlying, "new/bar"), Equals, "bar")
+	c.Assert(test.ReadFile(c, s.underlying, "link"), Equals, "changed")
+	_, err := s.underlying.Stat("dir")
+	c.Assert(os.IsNotExist(err), Equals, true)
+	_, err = s.underlying.Stat("qux")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	infos, err := s.underlying.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 3)
+
+	// a new transaction starts once committed
+	c.Assert(util.WriteFile(s.tx, "foo", []byte("again"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.underlying, "foo"), Equals, "changed")
+}
+
+func (s *TxSuite) TestRollback(c *C) {
+	c.Assert(util.WriteFile(s.underlying, "foo", []byte("foo"), 0644), IsNil)
+
+	c.Assert(util.WriteFile(s.tx, "foo", []byte("changed"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.tx, "bar", []byte("bar"), 0644), IsNil)
+	c.Assert(s.tx.Rollback(), IsNil)
+
+	c.Assert(test.ReadFile(c, s.tx, "foo"), Equals, "foo")
+	_, err := s.tx.Stat("bar")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	c.Assert(s.tx.Commit(), IsNil)
+	c.Assert(test.ReadFile(c, s.underlying, "foo"), Equals, "foo")
+	_, err = s.underlying.Stat("bar")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *TxSuite) TestCommitOS(c *C) {
+	fs := osfs.New(c.MkDir())
+	c.Assert(util.WriteFile(fs, "dir/foo", []byte("foo"), 0644), IsNil)
+
+	tx := New(fs, Options{})
+	c.Assert(util.WriteFile(tx, "dir/foo", []byte("changed"), 0644), IsNil)
+	c.Assert(util.WriteFile(tx, "bar", []byte("bar"), 0644), IsNil)
+	c.Assert(tx.Commit(), IsNil)
+
+	c.Assert(test.ReadFile(c, fs, "dir/foo"), Equals, "changed")
+	c.Assert(test.ReadFile(c, fs, "bar"), Equals, "bar")
+
+	// no temporary file is left behind
+	infos, err := fs.ReadDir("dir")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 1)
+}
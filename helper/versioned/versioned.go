@@ -0,0 +1,445 @@
+// Package versioned provides a helper keeping the previous versions of the
+// files of a filesystem, to read it as it was at any snapshot taken, eg.: to
+// undo changes or to back it up consistently while in use.
+package versioned
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const separator = string(filepath.Separator)
+
+// ErrNotFound is returned when reading a snapshot never taken, or released.
+var ErrNotFound = errors.New("snapshot not found")
+
+// Options holds the configuration of a Versioned.
+type Options struct {
+	// Storage is the filesystem where the previous versions of the files
+	// are kept, memory if nil. It should be a filesystem used only by the
+	// helper.
+	Storage billy.Filesystem
+}
+
+// Versioned is a helper passing every operation through to the underlying
+// filesystem, keeping the state of the files changed since the last snapshot
+// taken, so every snapshot can be read as it was when taken.
+//
+// The state of a file is kept the first time it's changed after a snapshot,
+// copying its content before any write, so only the files changed take
+// space. The changes made directly to the underlying filesystem aren't
+// noticed, and the files renamed while open are kept with their old name.
+type Versioned struct {
+	underlying billy.Filesystem
+	storage    billy.Filesystem
+
+	m sync.Mutex
+	// snapshots are the snapshots not released, sorted by id.
+	snapshots []*snapshot
+	last      int
+	stored    int
+}
+
+// snapshot holds the state when taken of the files changed since then, until
+// the next snapshot.
+type snapshot struct {
+	id      int
+	records map[string]*record
+}
+
+// record is the state of a file when a snapshot was taken.
+type record struct {
+	// fi is nil if the file didn't exist.
+	fi *fileInfo
+	// stored is the name of the copy of the content, for regular files.
+	stored string
+	// target is the target, for symlinks.
+	target string
+}
+
+// New creates a new versioned filesystem over the given one.
+func New(fs billy.Filesystem, opts Options) *Versioned {
+	if opts.Storage == nil {
+		opts.Storage = memfs.New()
+	}
+
+	return &Versioned{underlying: fs, storage: opts.Storage}
+}
+
+// Snapshot takes a snapshot of the filesystem, returning its id.
+func (fs *Versioned) Snapshot() int {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	fs.last++
+	fs.snapshots = append(fs.snapshots, &snapshot{
+		id:      fs.last,
+		records: make(map[string]*record),
+	})
+
+	return fs.last
+}
+
+// Release discards the given snapshot, keeping only the state of the files
+// needed by the snapshots taken before it.
+func (fs *Versioned) Release(id int) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	i := fs.index(id)
+	if i < 0 {
+		return ErrNotFound
+	}
+
+	for p, r := range fs.snapshots[i].records {
+		// the previous snapshot lacks the files not changed since then,
+		// so their state is the same when both were taken.
+		if i > 0 {
+			if _, ok := fs.snapshots[i-1].records[p]; !ok {
+				fs.snapshots[i-1].records[p] = r
+				continue
+			}
+		}
+
+		if r.stored != "" {
+			fs.storage.Remove(r.stored)
+		}
+	}
+
+	fs.snapshots = append(fs.snapshots[:i], fs.snapshots[i+1:]...)
+	return nil
+}
+
+// Snapshots returns the ids of the snapshots not released.
+func (fs *Versioned) Snapshots() []int {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	ids := make([]int, len(fs.snapshots))
+	for i, s := range fs.snapshots {
+		ids[i] = s.id
+	}
+
+	return ids
+}
+
+// index returns the index of the given snapshot, -1 if not found. It must be
+// called with the lock held.
+func (fs *Versioned) index(id int) int {
+	for i, s := range fs.snapshots {
+		if s.id == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// At returns the filesystem as it was when the given snapshot was taken,
+// which can't be changed. Its operations return ErrNotFound if the snapshot
+// isn't found, or once released.
+func (fs *Versioned) At(id int) billy.Filesystem {
+	return &view{fs: fs, id: id}
+}
+
+// preserve keeps the state of the given file in the last snapshot, unless
+// already kept. It must be called with the lock held.
+func (fs *Versioned) preserve(p string) error {
+	if len(fs.snapshots) == 0 {
+		return nil
+	}
+
+	s := fs.snapshots[len(fs.snapshots)-1]
+	if _, ok := s.records[p]; ok {
+		return nil
+	}
+
+	r, err := fs.capture(p)
+	if err != nil {
+		return err
+	}
+
+	s.records[p] = r
+	return nil
+}
+
+// preserveTree keeps the state of the given file and, if a directory, of
+// everything below it. It must be called with the lock held.
+func (fs *Versioned) preserveTree(p string) error {
+	if err := fs.preserve(p); err != nil {
+		return err
+	}
+
+	fi, err := fs.underlying.Lstat(p)
+	if err != nil || !fi.IsDir() {
+		return nil
+	}
+
+	infos, err := fs.underlying.ReadDir(p)
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range infos {
+		if err := fs.preserveTree(filepath.Join(p, fi.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// capture returns the current state of the given file.
+func (fs *Versioned) capture(p string) (*record, error) {
+	fi, err := fs.underlying.Lstat(p)
+	if os.IsNotExist(err) {
+		return &record{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	r := &record{fi: &fileInfo{
+		name:    fi.Name(),
+		size:    fi.Size(),
+		mode:    fi.Mode(),
+		modTime: fi.ModTime(),
+	}}
+
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		r.target, err = fs.underlying.Readlink(p)
+	case fi.Mode().IsRegular():
+		fs.stored++
+		r.stored = fmt.Sprint(fs.stored)
+		err = copyFile(fs.underlying, p, fs.storage, r.stored)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (fs *Versioned) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Versioned) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file. The files open for writing keep their state
+// before every write, if a snapshot was taken since the last one.
+func (fs *Versioned) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if !isWrite(flag) {
+		return fs.underlying.OpenFile(filename, flag, perm)
+	}
+
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	p := clean(filename)
+	if err := fs.preserve(p); err != nil {
+		return nil, err
+	}
+
+	f, err := fs.underlying.OpenFile(filename, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &file{File: f, fs: fs, path: p}, nil
+}
+
+func (fs *Versioned) Stat(filename string) (os.FileInfo, error) {
+	return fs.underlying.Stat(filename)
+}
+
+func (fs *Versioned) Lstat(filename string) (os.FileInfo, error) {
+	return fs.underlying.Lstat(filename)
+}
+
+func (fs *Versioned) ReadDir(path string) ([]os.FileInfo, error) {
+	return fs.underlying.ReadDir(path)
+}
+
+func (fs *Versioned) Readlink(link string) (string, error) {
+	return fs.underlying.Readlink(link)
+}
+
+func (fs *Versioned) Rename(from, to string) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	if err := fs.preserveTree(clean(from)); err != nil {
+		return err
+	}
+
+	if err := fs.preserveTree(clean(to)); err != nil {
+		return err
+	}
+
+	return fs.underlying.Rename(from, to)
+}
+
+func (fs *Versioned) Remove(filename string) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	if err := fs.preserve(clean(filename)); err != nil {
+		return err
+	}
+
+	return fs.underlying.Remove(filename)
+}
+
+// MkdirAll creates the given directory, keeping the state of all its parents,
+// since any of them may be created.
+func (fs *Versioned) MkdirAll(filename string, perm os.FileMode) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	for p := clean(filename); p != separator; p = filepath.Dir(p) {
+		if err := fs.preserve(p); err != nil {
+			return err
+		}
+	}
+
+	return fs.underlying.MkdirAll(filename, perm)
+}
+
+func (fs *Versioned) Symlink(target, link string) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	if err := fs.preserve(clean(link)); err != nil {
+		return err
+	}
+
+	return fs.underlying.Symlink(target, link)
+}
+
+func (fs *Versioned) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(fs, dir, prefix)
+}
+
+func (fs *Versioned) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (fs *Versioned) Chroot(path string) (billy.Filesystem, error) {
+	return chroot.New(fs, fs.Join(fs.Root(), path)), nil
+}
+
+func (fs *Versioned) Root() string {
+	return separator
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Versioned) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.underlying)
+}
+
+// file is a file open for writing, keeping its state before being changed.
+type file struct {
+	billy.File
+	fs   *Versioned
+	path string
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	f.fs.m.Lock()
+	defer f.fs.m.Unlock()
+
+	if err := f.fs.preserve(f.path); err != nil {
+		return 0, err
+	}
+
+	return f.File.Write(p)
+}
+
+func (f *file) Truncate(size int64) error {
+	f.fs.m.Lock()
+	defer f.fs.m.Unlock()
+
+	if err := f.fs.preserve(f.path); err != nil {
+		return err
+	}
+
+	return f.File.Truncate(size)
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.size
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	return fi.mode
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.mode.IsDir()
+}
+
+func (*fileInfo) Sys() interface{} {
+	return nil
+}
+
+func copyFile(src billy.Basic, from string, dst billy.Basic, to string) error {
+	r, err := src.Open(from)
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	w, err := dst.Create(to)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
+// clean returns the given path as an absolute clean path, the form used to
+// record the files.
+func clean(p string) string {
+	return filepath.Join(separator, p)
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
+}
@@ -0,0 +1,144 @@
+package versioned
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&VersionedSuite{})
+
+type VersionedSuite struct {
+	test.FilesystemSuite
+	versioned *Versioned
+}
+
+func (s *VersionedSuite) SetUpTest(c *C) {
+	s.versioned = New(memfs.New(), Options{})
+	s.FilesystemSuite = test.NewFilesystemSuite(s.versioned)
+
+	// every change made by the suite is kept
+	s.versioned.Snapshot()
+}
+
+func (s *VersionedSuite) TestAt(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "bar", []byte("bar"), 0644), IsNil)
+	id := s.versioned.Snapshot()
+
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("changed"), 0644), IsNil)
+	c.Assert(s.FS.Remove("bar"), IsNil)
+	c.Assert(util.WriteFile(s.FS, "qux", []byte("qux"), 0644), IsNil)
+
+	at := s.versioned.At(id)
+	c.Assert(test.ReadFile(c, at, "foo"), Equals, "foo")
+	c.Assert(test.ReadFile(c, at, "bar"), Equals, "bar")
+	_, err := at.Stat("qux")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	fi, err := at.Stat("foo")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Size(), Equals, int64(3))
+
+	infos, err := at.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 2)
+	c.Assert(infos[0].Name(), Equals, "bar")
+	c.Assert(infos[1].Name(), Equals, "foo")
+
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "changed")
+}
+
+func (s *VersionedSuite) TestSeveralSnapshots(c *C) {
+	first := s.versioned.Snapshot()
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("1"), 0644), IsNil)
+	second := s.versioned.Snapshot()
+	third := s.versioned.Snapshot()
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("3"), 0644), IsNil)
+
+	_, err := s.versioned.At(first).Stat("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+	c.Assert(test.ReadFile(c, s.versioned.At(second), "foo"), Equals, "1")
+	c.Assert(test.ReadFile(c, s.versioned.At(third), "foo"), Equals, "1")
+
+	// the state kept by the snapshot released is still needed by the first
+	c.Assert(s.versioned.Release(third), IsNil)
+	c.Assert(test.ReadFile(c, s.versioned.At(second), "foo"), Equals, "1")
+	c.Assert(s.versioned.Release(second), IsNil)
+	_, err = s.versioned.At(first).Stat("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	_, err = s.versioned.At(second).Stat("foo")
+	c.Assert(err, Equals, ErrNotFound)
+	c.Assert(s.versioned.Release(second), Equals, ErrNotFound)
+	c.Assert(s.versioned.Snapshots(), DeepEquals, []int{1, first})
+}
+
+func (s *VersionedSuite) TestOpenAcrossSnapshot(c *C) {
+	f, err := s.FS.Create("foo")
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("foo"))
+	c.Assert(err, IsNil)
+
+	id := s.versioned.Snapshot()
+	_, err = f.Write([]byte("bar"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	c.Assert(test.ReadFile(c, s.versioned.At(id), "foo"), Equals, "foo")
+}
+
+func (s *VersionedSuite) TestOpenWhileChanged(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+	id := s.versioned.Snapshot()
+
+	f, err := s.versioned.At(id).Open("foo")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("bar"), 0644), IsNil)
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "foo")
+}
+
+func (s *VersionedSuite) TestRenameDir(c *C) {
+	c.Assert(util.WriteFile(s.FS, "dir/foo", []byte("foo"), 0644), IsNil)
+	c.Assert(s.FS.Symlink("foo", "dir/link"), IsNil)
+	id := s.versioned.Snapshot()
+
+	c.Assert(s.FS.Rename("dir", "new"), IsNil)
+	c.Assert(util.WriteFile(s.FS, "new/foo", []byte("bar"), 0644), IsNil)
+
+	at := s.versioned.At(id)
+	c.Assert(test.ReadFile(c, at, "dir/link"), Equals, "foo")
+	_, err := at.Stat("new")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	infos, err := at.ReadDir("dir")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 2)
+
+	target, err := at.Readlink("dir/link")
+	c.Assert(err, IsNil)
+	c.Assert(target, Equals, "foo")
+}
+
+func (s *VersionedSuite) TestReadOnly(c *C) {
+	at := s.versioned.At(s.versioned.Snapshot())
+
+	_, err := at.Create("foo")
+	c.Assert(err, Equals, billy.ErrReadOnly)
+	c.Assert(at.MkdirAll("foo", 0755), Equals, billy.ErrReadOnly)
+	_, err = at.OpenFile("foo", os.O_WRONLY, 0)
+	c.Assert(err, Equals, billy.ErrReadOnly)
+}
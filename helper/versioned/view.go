@@ -0,0 +1,285 @@
+package versioned
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/internal/linkutil"
+)
+
+// view is the read-only filesystem as it was when a snapshot was taken. The
+// state of a file is the one kept by the first snapshot since then changing
+// it, or the current one if none did.
+type view struct {
+	fs *Versioned
+	id int
+}
+
+// lookup returns the state of the given file kept by the snapshots, false if
+// not changed since the snapshot was taken. It must be called with the lock
+// held.
+func (v *view) lookup(p string) (*record, bool, error) {
+	i := v.fs.index(v.id)
+	if i < 0 {
+		return nil, false, ErrNotFound
+	}
+
+	for _, s := range v.fs.snapshots[i:] {
+		if r, ok := s.records[p]; ok {
+			return r, true, nil
+		}
+	}
+
+	return nil, false, nil
+}
+
+// lstat must be called with the lock held.
+func (v *view) lstat(p string) (os.FileInfo, error) {
+	r, ok, err := v.lookup(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return v.fs.underlying.Lstat(p)
+	}
+
+	if r.fi == nil {
+		return nil, &os.PathError{Op: "lstat", Path: p, Err: os.ErrNotExist}
+	}
+
+	return r.fi, nil
+}
+
+// resolve returns the given path following the symlinks, if the last element
+// is one. It must be called with the lock held.
+func (v *view) resolve(p string) (string, os.FileInfo, error) {
+	var fi os.FileInfo
+	isLink := func(p string) (link bool, err error) {
+		fi, err = v.lstat(p)
+		return err == nil && fi.Mode()&os.ModeSymlink != 0, err
+	}
+
+	p, err := linkutil.Follow(p, clean, isLink, v.readlink)
+	switch {
+	case err == linkutil.ErrTooManyLinks:
+		return "", nil, &os.PathError{Op: "stat", Path: p, Err: err}
+	case err != nil:
+		return "", nil, err
+	}
+
+	return p, fi, nil
+}
+
+// readlink must be called with the lock held.
+func (v *view) readlink(p string) (string, error) {
+	r, ok, err := v.lookup(p)
+	if err != nil {
+		return "", err
+	}
+
+	if !ok {
+		return v.fs.underlying.Readlink(p)
+	}
+
+	if r.fi == nil {
+		return "", &os.PathError{Op: "readlink", Path: p, Err: os.ErrNotExist}
+	}
+
+	if r.fi.Mode()&os.ModeSymlink == 0 {
+		return "", &os.PathError{Op: "readlink", Path: p, Err: errors.New("not a symlink")}
+	}
+
+	return r.target, nil
+}
+
+func (v *view) Create(filename string) (billy.File, error) {
+	return nil, billy.ErrReadOnly
+}
+
+func (v *view) Open(filename string) (billy.File, error) {
+	return v.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file for reading. The files not changed are kept
+// in the last snapshot when open, so they can't change while read.
+func (v *view) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if isWrite(flag) {
+		return nil, billy.ErrReadOnly
+	}
+
+	v.fs.m.Lock()
+	defer v.fs.m.Unlock()
+
+	p, fi, err := v.resolve(clean(filename))
+	if err != nil {
+		return nil, err
+	}
+
+	if fi.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: errors.New("is a directory")}
+	}
+
+	r, ok, err := v.lookup(p)
+	if err == nil && !ok {
+		if err = v.fs.preserve(p); err == nil {
+			r, _, err = v.lookup(p)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := v.fs.storage.Open(r.stored)
+	if err != nil {
+		return nil, err
+	}
+
+	return &readOnlyFile{File: f, name: filename}, nil
+}
+
+func (v *view) Stat(filename string) (os.FileInfo, error) {
+	v.fs.m.Lock()
+	defer v.fs.m.Unlock()
+
+	_, fi, err := v.resolve(clean(filename))
+	if err != nil {
+		return nil, err
+	}
+
+	return renamed(fi, filepath.Base(filename)), nil
+}
+
+func (v *view) Lstat(filename string) (os.FileInfo, error) {
+	v.fs.m.Lock()
+	defer v.fs.m.Unlock()
+
+	return v.lstat(clean(filename))
+}
+
+func (v *view) Readlink(link string) (string, error) {
+	v.fs.m.Lock()
+	defer v.fs.m.Unlock()
+
+	return v.readlink(clean(link))
+}
+
+// ReadDir returns the entries of the given directory, from the current ones
+// and the ones kept by the snapshots.
+func (v *view) ReadDir(path string) ([]os.FileInfo, error) {
+	v.fs.m.Lock()
+	defer v.fs.m.Unlock()
+
+	dir, fi, err := v.resolve(clean(path))
+	if err != nil {
+		return nil, err
+	}
+
+	if !fi.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: path, Err: errors.New("not a directory")}
+	}
+
+	names := make(map[string]bool)
+	if infos, err := v.fs.underlying.ReadDir(dir); err == nil {
+		for _, fi := range infos {
+			names[fi.Name()] = true
+		}
+	}
+
+	for _, s := range v.fs.snapshots[v.fs.index(v.id):] {
+		for p := range s.records {
+			if p != separator && filepath.Dir(p) == dir {
+				names[filepath.Base(p)] = true
+			}
+		}
+	}
+
+	var infos []os.FileInfo
+	for name := range names {
+		fi, err := v.lstat(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		infos = append(infos, fi)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+
+	return infos, nil
+}
+
+func (v *view) Rename(from, to string) error {
+	return billy.ErrReadOnly
+}
+
+func (v *view) Remove(filename string) error {
+	return billy.ErrReadOnly
+}
+
+func (v *view) MkdirAll(filename string, perm os.FileMode) error {
+	return billy.ErrReadOnly
+}
+
+func (v *view) Symlink(target, link string) error {
+	return billy.ErrReadOnly
+}
+
+func (v *view) TempFile(dir, prefix string) (billy.File, error) {
+	return nil, billy.ErrReadOnly
+}
+
+func (v *view) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (v *view) Chroot(path string) (billy.Filesystem, error) {
+	return chroot.New(v, v.Join(v.Root(), path)), nil
+}
+
+func (v *view) Root() string {
+	return separator
+}
+
+// Capabilities implements the Capable interface.
+func (v *view) Capabilities() billy.Capability {
+	return billy.ReadCapability | billy.SeekCapability
+}
+
+// readOnlyFile is a file kept by a snapshot, open with the name given.
+type readOnlyFile struct {
+	billy.File
+	name string
+}
+
+func (f *readOnlyFile) Name() string {
+	return f.name
+}
+
+func (f *readOnlyFile) Write(p []byte) (int, error) {
+	return 0, billy.ErrReadOnly
+}
+
+func (f *readOnlyFile) Truncate(size int64) error {
+	return billy.ErrReadOnly
+}
+
+func renamed(fi os.FileInfo, name string) os.FileInfo {
+	return &fileInfo{
+		name:    name,
+		size:    fi.Size(),
+		mode:    fi.Mode(),
+		modTime: fi.ModTime(),
+	}
+}
@@ -0,0 +1,378 @@
+// Package virtual provides a helper adding to a filesystem files generated on
+// demand, eg.: a VERSION file or an index computed from the other files,
+// never written to the underlying storage.
+package virtual
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+const separator = string(filepath.Separator)
+
+// Generator returns the content of a virtual file. It's called every time the
+// file is opened or stat'ed, so the content is always up to date.
+type Generator func() ([]byte, error)
+
+// Options holds the configuration of a Virtual filesystem.
+type Options struct {
+	// Files are the generators of the virtual files, by their path relative
+	// to the root of the underlying filesystem.
+	Files map[string]Generator
+	// Mode is the mode of the virtual files, 0444 if 0.
+	Mode os.FileMode
+}
+
+// Virtual is a helper passing every operation through to the underlying
+// filesystem, but for the virtual files, which are read-only and shadow any
+// file at the same path. The directories leading to them are listed even if
+// missing in the underlying filesystem.
+//
+// The modification time of the virtual files is the time their content was
+// generated.
+type Virtual struct {
+	billy.Filesystem
+	s *state
+	// dir is the directory the root was moved to by Chroot, as the virtual
+	// files are registered with their paths from the original root.
+	dir string
+}
+
+// state is the state shared by a Virtual filesystem and its chroots.
+type state struct {
+	files map[string]Generator
+	// dirs are the directories leading to the virtual files.
+	dirs map[string]bool
+	mode os.FileMode
+}
+
+// New creates a new filesystem wrapping up the given one, adding to it the
+// virtual files of the given options.
+func New(fs billy.Filesystem, opts Options) *Virtual {
+	if opts.Mode == 0 {
+		opts.Mode = 0444
+	}
+
+	s := &state{
+		files: make(map[string]Generator),
+		dirs:  make(map[string]bool),
+		mode:  opts.Mode.Perm(),
+	}
+
+	for path, gen := range opts.Files {
+		p := filepath.Join(separator, path)
+		s.files[p] = gen
+
+		for dir := filepath.Dir(p); dir != separator; dir = filepath.Dir(dir) {
+			s.dirs[dir] = true
+		}
+	}
+
+	return &Virtual{Filesystem: fs, s: s}
+}
+
+// path returns the given path relative to the root of the underlying
+// filesystem.
+func (fs *Virtual) path(p string) string {
+	return filepath.Join(separator, fs.dir, p)
+}
+
+// isVirtual returns if the given path is a virtual file, or is below one.
+func (fs *Virtual) isVirtual(filename string) bool {
+	for p := fs.path(filename); p != separator; p = filepath.Dir(p) {
+		if _, ok := fs.s.files[p]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// generate returns the content and the information of the given virtual
+// file.
+func (s *state) generate(p string) ([]byte, os.FileInfo, error) {
+	content, err := s.files[p]()
+	if err != nil {
+		return nil, nil, &os.PathError{Op: "generate", Path: p, Err: err}
+	}
+
+	return content, &fileInfo{
+		name:    filepath.Base(p),
+		size:    int64(len(content)),
+		mode:    s.mode,
+		modTime: time.Now(),
+	}, nil
+}
+
+func (fs *Virtual) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Virtual) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file, generating its content if it's a virtual
+// one, which can't be open for writing.
+func (fs *Virtual) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	p := fs.path(filename)
+	if _, ok := fs.s.files[p]; !ok {
+		return fs.Filesystem.OpenFile(filename, flag, perm)
+	}
+
+	if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrExist}
+	}
+
+	if isWrite(flag) {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrPermission}
+	}
+
+	content, _, err := fs.s.generate(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return &file{name: filename, Reader: bytes.NewReader(content)}, nil
+}
+
+func (fs *Virtual) Stat(filename string) (os.FileInfo, error) {
+	fi, err := fs.Filesystem.Stat(filename)
+	return fs.stat(filename, fi, err)
+}
+
+func (fs *Virtual) Lstat(filename string) (os.FileInfo, error) {
+	fi, err := fs.Filesystem.Lstat(filename)
+	return fs.stat(filename, fi, err)
+}
+
+// stat returns the information of the given virtual file, or directory
+// leading to one, in place of the given one.
+func (fs *Virtual) stat(filename string, fi os.FileInfo, err error) (os.FileInfo, error) {
+	p := fs.path(filename)
+	if _, ok := fs.s.files[p]; ok {
+		_, fi, err := fs.s.generate(p)
+		return fi, err
+	}
+
+	if !fs.s.dirs[p] || (err == nil && fi.IsDir()) {
+		return fi, err
+	}
+
+	return &fileInfo{name: filepath.Base(p), mode: os.ModeDir | 0755}, nil
+}
+
+// ReadDir returns the entries of the given directory, with the virtual files
+// and the directories leading to them.
+func (fs *Virtual) ReadDir(path string) ([]os.FileInfo, error) {
+	infos, err := fs.Filesystem.ReadDir(path)
+
+	p := fs.path(path)
+	if !fs.s.dirs[p] && p != separator {
+		return infos, err
+	}
+
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	entries := make(map[string]os.FileInfo)
+	for _, fi := range infos {
+		entries[fi.Name()] = fi
+	}
+
+	for child := range fs.s.files {
+		if filepath.Dir(child) != p {
+			continue
+		}
+
+		_, fi, err := fs.s.generate(child)
+		if err != nil {
+			return nil, err
+		}
+
+		entries[fi.Name()] = fi
+	}
+
+	for child := range fs.s.dirs {
+		if filepath.Dir(child) != p {
+			continue
+		}
+
+		name := filepath.Base(child)
+		if fi, ok := entries[name]; !ok || !fi.IsDir() {
+			entries[name] = &fileInfo{name: name, mode: os.ModeDir | 0755}
+		}
+	}
+
+	infos = make([]os.FileInfo, 0, len(entries))
+	for _, fi := range entries {
+		infos = append(infos, fi)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+
+	return infos, nil
+}
+
+func (fs *Virtual) MkdirAll(filename string, perm os.FileMode) error {
+	if fs.isVirtual(filename) {
+		return &os.PathError{Op: "mkdir", Path: filename, Err: os.ErrPermission}
+	}
+
+	return fs.Filesystem.MkdirAll(filename, perm)
+}
+
+func (fs *Virtual) Rename(from, to string) error {
+	if fs.isVirtual(from) || fs.isVirtual(to) {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: os.ErrPermission}
+	}
+
+	return fs.Filesystem.Rename(from, to)
+}
+
+func (fs *Virtual) Remove(filename string) error {
+	if fs.isVirtual(filename) {
+		return &os.PathError{Op: "remove", Path: filename, Err: os.ErrPermission}
+	}
+
+	return fs.Filesystem.Remove(filename)
+}
+
+func (fs *Virtual) Symlink(target, link string) error {
+	if fs.isVirtual(link) {
+		return &os.PathError{Op: "symlink", Path: link, Err: os.ErrPermission}
+	}
+
+	return fs.Filesystem.Symlink(target, link)
+}
+
+// Readlink returns the target of the given link, failing with os.ErrInvalid
+// for the virtual files, as they are never links.
+func (fs *Virtual) Readlink(link string) (string, error) {
+	if _, ok := fs.s.files[fs.path(link)]; ok {
+		return "", &os.PathError{Op: "readlink", Path: link, Err: os.ErrInvalid}
+	}
+
+	return fs.Filesystem.Readlink(link)
+}
+
+// Chroot returns the given directory of the underlying filesystem, with the
+// virtual files below it.
+func (fs *Virtual) Chroot(path string) (billy.Filesystem, error) {
+	chroot, err := fs.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Virtual{Filesystem: chroot, s: fs.s, dir: fs.path(path)}, nil
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Virtual) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.Filesystem)
+}
+
+// file is a virtual file open for reading, holding the content generated.
+type file struct {
+	*bytes.Reader
+	name   string
+	closed bool
+}
+
+func (f *file) Name() string {
+	return f.name
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+
+	return f.Reader.Read(p)
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+
+	return f.Reader.ReadAt(p, off)
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+
+	return f.Reader.Seek(offset, whence)
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrPermission}
+}
+
+func (f *file) Truncate(size int64) error {
+	return &os.PathError{Op: "truncate", Path: f.name, Err: os.ErrPermission}
+}
+
+func (f *file) Close() error {
+	if f.closed {
+		return os.ErrClosed
+	}
+
+	f.closed = true
+	return nil
+}
+
+func (f *file) Lock() error {
+	return nil
+}
+
+func (f *file) Unlock() error {
+	return nil
+}
+
+// fileInfo is a virtual file, or a directory leading to one missing in the
+// underlying filesystem.
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.size
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	return fi.mode
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.mode.IsDir()
+}
+
+func (*fileInfo) Sys() interface{} {
+	return nil
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
+}
@@ -0,0 +1,153 @@
+package virtual
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&VirtualSuite{})
+
+type VirtualSuite struct {
+	test.FilesystemSuite
+	underlying billy.Filesystem
+	calls      int
+}
+
+func (s *VirtualSuite) SetUpTest(c *C) {
+	s.FilesystemSuite = test.NewFilesystemSuite(New(memfs.New(), Options{}))
+
+	s.underlying = memfs.New()
+	s.calls = 0
+}
+
+func (s *VirtualSuite) new() *Virtual {
+	return New(s.underlying, Options{Files: map[string]Generator{
+		"VERSION": func() ([]byte, error) {
+			s.calls++
+			return []byte("v1.0.0"), nil
+		},
+		"meta/index": func() ([]byte, error) {
+			return []byte("foo\nbar\n"), nil
+		},
+		"broken": func() ([]byte, error) {
+			return nil, errors.New("foo")
+		},
+	}})
+}
+
+func (s *VirtualSuite) TestOpen(c *C) {
+	fs := s.new()
+	c.Assert(test.ReadFile(c, fs, "VERSION"), Equals, "v1.0.0")
+	c.Assert(test.ReadFile(c, fs, "meta/index"), Equals, "foo\nbar\n")
+	c.Assert(test.ReadFile(c, fs, "VERSION"), Equals, "v1.0.0")
+	c.Assert(s.calls, Equals, 2)
+
+	_, err := fs.Open("broken")
+	c.Assert(err, NotNil)
+
+	_, err = s.underlying.Stat("VERSION")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *VirtualSuite) TestShadow(c *C) {
+	c.Assert(util.WriteFile(s.underlying, "VERSION", []byte("foo"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.new(), "VERSION"), Equals, "v1.0.0")
+}
+
+func (s *VirtualSuite) TestReadOnly(c *C) {
+	fs := s.new()
+	_, err := fs.Create("VERSION")
+	c.Assert(os.IsPermission(err), Equals, true)
+	_, err = fs.OpenFile("VERSION", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	c.Assert(os.IsExist(err), Equals, true)
+
+	f, err := fs.Open("VERSION")
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("foo"))
+	c.Assert(os.IsPermission(err), Equals, true)
+	c.Assert(f.Close(), IsNil)
+	_, err = f.Read(make([]byte, 1))
+	c.Assert(err, Equals, os.ErrClosed)
+
+	c.Assert(os.IsPermission(fs.Remove("VERSION")), Equals, true)
+	c.Assert(os.IsPermission(fs.Remove("meta/index")), Equals, true)
+	c.Assert(os.IsPermission(fs.MkdirAll("VERSION/foo", 0755)), Equals, true)
+	c.Assert(os.IsPermission(fs.Symlink("foo", "VERSION")), Equals, true)
+
+	c.Assert(util.WriteFile(fs, "foo", []byte("foo"), 0644), IsNil)
+	err = fs.Rename("foo", "VERSION")
+	c.Assert(os.IsPermission(err.(*os.LinkError).Err), Equals, true)
+	err = fs.Rename("VERSION", "foo")
+	c.Assert(os.IsPermission(err.(*os.LinkError).Err), Equals, true)
+}
+
+func (s *VirtualSuite) TestStat(c *C) {
+	fs := s.new()
+	fi, err := fs.Stat("VERSION")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Name(), Equals, "VERSION")
+	c.Assert(fi.Size(), Equals, int64(6))
+	c.Assert(fi.Mode(), Equals, os.FileMode(0444))
+
+	fi, err = fs.Lstat("meta")
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+
+	_, err = fs.Stat("broken")
+	c.Assert(err, NotNil)
+	_, err = fs.Stat("missing")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *VirtualSuite) TestReadDir(c *C) {
+	c.Assert(util.WriteFile(s.underlying, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.underlying, "VERSION", []byte("foo"), 0644), IsNil)
+
+	fs := New(s.underlying, Options{Files: map[string]Generator{
+		"VERSION": func() ([]byte, error) {
+			return []byte("v1.0.0"), nil
+		},
+		"meta/index": func() ([]byte, error) {
+			return nil, nil
+		},
+	}})
+
+	infos, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 3)
+	c.Assert(infos[0].Name(), Equals, "VERSION")
+	c.Assert(infos[0].Size(), Equals, int64(6))
+	c.Assert(infos[1].Name(), Equals, "foo")
+	c.Assert(infos[2].Name(), Equals, "meta")
+	c.Assert(infos[2].IsDir(), Equals, true)
+
+	infos, err = fs.ReadDir("meta")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 1)
+	c.Assert(infos[0].Name(), Equals, "index")
+
+	// the real files are listed along with the virtual ones
+	c.Assert(util.WriteFile(fs, "meta/bar", []byte("bar"), 0644), IsNil)
+	infos, err = fs.ReadDir("meta")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 2)
+}
+
+func (s *VirtualSuite) TestChroot(c *C) {
+	chroot, err := s.new().Chroot("meta")
+	c.Assert(err, IsNil)
+	c.Assert(test.ReadFile(c, chroot, "index"), Equals, "foo\nbar\n")
+
+	_, err = chroot.Stat("VERSION")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
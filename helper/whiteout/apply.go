@@ -0,0 +1,143 @@
+package whiteout
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/internal/fileutil"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+// Flatten copies the files of the union to the given filesystem, as a single
+// layer without whiteouts.
+func (fs *Union) Flatten(dst billy.Filesystem) error {
+	return copyDir(fs, dst, separator)
+}
+
+// Apply applies the given layer, with whiteouts as defined by the OCI image
+// layer specification, to the given filesystem: the files hidden by the
+// whiteouts and opaque directories of the layer are removed from it, and the
+// other files of the layer are copied over it.
+func Apply(dst, layer billy.Filesystem) error {
+	return applyDir(dst, layer, separator)
+}
+
+func applyDir(dst, layer billy.Filesystem, dir string) error {
+	infos, err := layer.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	// the whiteouts are applied first, since they only hide the files of
+	// the lower layers
+	for _, fi := range infos {
+		name := fi.Name()
+		switch {
+		case name == Opaque:
+			if err := clearDir(dst, dir); err != nil {
+				return err
+			}
+		case strings.HasPrefix(name, Prefix):
+			err := util.RemoveAll(dst, filepath.Join(dir, strings.TrimPrefix(name, Prefix)))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, fi := range infos {
+		if strings.HasPrefix(fi.Name(), Prefix) {
+			continue
+		}
+
+		p := filepath.Join(dir, fi.Name())
+		if cur, err := dst.Lstat(p); err == nil && !(cur.IsDir() && fi.IsDir()) {
+			if err := util.RemoveAll(dst, p); err != nil {
+				return err
+			}
+		}
+
+		if fi.IsDir() {
+			if err := dst.MkdirAll(p, fi.Mode().Perm()); err != nil {
+				return err
+			}
+
+			if err := applyDir(dst, layer, p); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err := copyEntry(layer, dst, p, fi); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// clearDir removes everything in the given directory.
+func clearDir(fs billy.Filesystem, dir string) error {
+	infos, err := fs.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range infos {
+		if err := util.RemoveAll(fs, filepath.Join(dir, fi.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copyDir copies the content of the given directory between filesystems.
+func copyDir(src, dst billy.Filesystem, dir string) error {
+	infos, err := src.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range infos {
+		p := filepath.Join(dir, fi.Name())
+		if !fi.IsDir() {
+			if err := copyEntry(src, dst, p, fi); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err := dst.MkdirAll(p, fi.Mode().Perm()); err != nil {
+			return err
+		}
+
+		if err := copyDir(src, dst, p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copyEntry copies the given file or symlink between filesystems.
+func copyEntry(src, dst billy.Filesystem, p string, fi os.FileInfo) error {
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return fileutil.Copy(src, p, dst, p, fi.Mode().Perm())
+	}
+
+	target, err := src.Readlink(p)
+	if err != nil {
+		return err
+	}
+
+	return dst.Symlink(target, p)
+}
@@ -0,0 +1,678 @@
+// Package whiteout provides a helper presenting a filesystem merged from the
+// layers of a container image, recording the changes in an upper layer with
+// the whiteouts defined by the OCI image layer specification, eg.: to build
+// the layers of an image, or to flatten them.
+package whiteout
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/internal/errutil"
+	"gopkg.in/src-d/go-billy.v4/internal/fileutil"
+	"gopkg.in/src-d/go-billy.v4/internal/linkutil"
+	"gopkg.in/src-d/go-billy.v4/internal/pathutil"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const (
+	// Prefix is the prefix of the whiteouts, followed by the name of the file
+	// removed, in the same directory.
+	Prefix = ".wh."
+	// Opaque is the name of the marker making a directory opaque, hiding the
+	// files of the lower layers in it.
+	Opaque = Prefix + Prefix + ".opq"
+)
+
+const separator = string(filepath.Separator)
+
+var (
+	// ErrNotEmpty is returned when removing a directory with files.
+	ErrNotEmpty = billy.ErrNotEmpty
+	// ErrReservedName is returned when creating a file whose name starts
+	// with the prefix of the whiteouts.
+	ErrReservedName = errors.New("name reserved for whiteouts")
+
+	errNotDir = errors.New("not a directory")
+)
+
+// Union is a helper that merges the layers of a container image, as defined
+// by the OCI image layer specification. The files are read from the topmost
+// layer they are found in, unless hidden by a whiteout, a file named after
+// the removed one prefixed by ".wh.", or by an opaque directory, with a
+// ".wh..wh..opq" file, in a higher layer. The whiteouts are hidden from the
+// union, and their names can't be used.
+//
+// Every change is written to the upper layer, which holds the changes from
+// the lower layers as the OCI image layer changeset: the files of the lower
+// layers are copied up when opened for writing, the removed ones are recorded
+// as whiteouts, and the directories created replacing removed ones are made
+// opaque.
+//
+// The symlinks are followed only as the last element of the paths, the paths
+// through symlinks to directories aren't found.
+type Union struct {
+	// layers are the layers from the top down, the upper first.
+	layers []billy.Filesystem
+}
+
+// New creates a new union of the given upper layer over the lower ones, given
+// in the order they are applied, the base layer first, as in the manifest of
+// an image.
+func New(upper billy.Filesystem, lowers ...billy.Filesystem) *Union {
+	layers := []billy.Filesystem{upper}
+	for i := len(lowers) - 1; i >= 0; i-- {
+		layers = append(layers, lowers[i])
+	}
+
+	return &Union{layers: layers}
+}
+
+// entry is a file found in the layers.
+type entry struct {
+	fi os.FileInfo
+	// layer is the index of the topmost layer the file is found in.
+	layer int
+	// dirs are the indexes of the layers merged, from the top down, if it's
+	// a directory.
+	dirs []int
+}
+
+// lookup returns the given file as seen in the union.
+func (fs *Union) lookup(p string) (*entry, error) {
+	if isReserved(p) {
+		return nil, os.ErrNotExist
+	}
+
+	e := &entry{}
+	for i, l := range fs.layers {
+		fi, err := l.Lstat(separator)
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if e.fi == nil {
+			e.fi, e.layer = fi, i
+		}
+
+		e.dirs = append(e.dirs, i)
+		if isOpaque(l, separator) {
+			break
+		}
+	}
+
+	if e.fi == nil {
+		return nil, os.ErrNotExist
+	}
+
+	dir := separator
+	for _, name := range strings.Split(pathutil.Relative(p), separator) {
+		if name == "" {
+			continue
+		}
+
+		if !e.fi.IsDir() {
+			return nil, os.ErrNotExist
+		}
+
+		var err error
+		if e, err = fs.child(e.dirs, dir, name); err != nil {
+			return nil, err
+		}
+
+		dir = filepath.Join(dir, name)
+	}
+
+	return e, nil
+}
+
+// child returns the given file of the directory merged from the given layers.
+func (fs *Union) child(dirs []int, dir, name string) (*entry, error) {
+	p := filepath.Join(dir, name)
+	e := &entry{}
+	for _, i := range dirs {
+		l := fs.layers[i]
+		fi, err := l.Lstat(p)
+		if os.IsNotExist(err) {
+			if isWhiteout(l, p) {
+				break
+			}
+
+			continue
+		}
+
+		if err != nil {
+			return nil, errutil.Underlying(err)
+		}
+
+		if e.fi == nil {
+			e.fi, e.layer = fi, i
+		}
+
+		// the files hide the ones of the lower layers, and the directories
+		// of the lower layers are hidden by the files of the upper ones.
+		if !fi.IsDir() {
+			break
+		}
+
+		e.dirs = append(e.dirs, i)
+		if isOpaque(l, p) {
+			break
+		}
+	}
+
+	if e.fi == nil {
+		return nil, os.ErrNotExist
+	}
+
+	return e, nil
+}
+
+// follow returns the given file, following the links. The returned path is
+// the one of the target, also when the target doesn't exist.
+func (fs *Union) follow(p string) (string, *entry, error) {
+	var e *entry
+	isLink := func(p string) (link bool, err error) {
+		e, err = fs.lookup(p)
+		return err == nil && e.fi.Mode()&os.ModeSymlink != 0, err
+	}
+
+	p, err := linkutil.Follow(p, clean, isLink, func(p string) (string, error) {
+		target, err := fs.layers[e.layer].Readlink(p)
+		return target, errutil.Underlying(err)
+	})
+	if err != nil {
+		return p, nil, err
+	}
+
+	return p, e, nil
+}
+
+func (fs *Union) upper() billy.Filesystem {
+	return fs.layers[0]
+}
+
+func (fs *Union) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Union) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (fs *Union) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	p, e, err := fs.follow(clean(filename))
+	exists := err == nil
+	if err != nil && (!os.IsNotExist(err) || flag&os.O_CREATE == 0) {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+	}
+
+	if exists && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrExist}
+	}
+
+	if exists && e.fi.IsDir() {
+		return nil, fmt.Errorf("cannot open directory: %s", filename)
+	}
+
+	var f billy.File
+	switch {
+	case exists && (e.layer == 0 || !isWrite(flag)):
+		f, err = fs.layers[e.layer].OpenFile(p, flag, perm)
+	case exists:
+		f, err = fs.copyUp(p, e, flag)
+	default:
+		if err := fs.prepare(p); err != nil {
+			return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+		}
+
+		f, err = fs.upper().OpenFile(p, flag, perm)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &file{File: f, name: pathutil.Relative(filename)}, nil
+}
+
+// copyUp copies to the upper layer the given file of a lower one, opening it.
+func (fs *Union) copyUp(p string, e *entry, flag int) (billy.File, error) {
+	if err := fs.makeDirs(filepath.Dir(p), 0755); err != nil {
+		return nil, err
+	}
+
+	perm := e.fi.Mode().Perm()
+	flag |= os.O_CREATE
+	if flag&os.O_TRUNC != 0 {
+		return fs.upper().OpenFile(p, flag, perm)
+	}
+
+	if err := fileutil.Copy(fs.layers[e.layer], p, fs.upper(), p, perm); err != nil {
+		return nil, err
+	}
+
+	return fs.upper().OpenFile(p, flag, perm)
+}
+
+// prepare makes the upper layer ready to create the given file: creating its
+// parents, and removing its whiteout.
+func (fs *Union) prepare(p string) error {
+	if strings.HasPrefix(filepath.Base(p), Prefix) {
+		return ErrReservedName
+	}
+
+	if err := fs.makeDirs(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	_, err := fs.unwhiteout(p)
+	return err
+}
+
+// makeDirs creates in the upper layer the given directory and its parents,
+// with the mode they have in the union, or the given one if not found.
+func (fs *Union) makeDirs(p string, perm os.FileMode) error {
+	if p == separator {
+		return nil
+	}
+
+	if strings.HasPrefix(filepath.Base(p), Prefix) {
+		return ErrReservedName
+	}
+
+	if err := fs.makeDirs(filepath.Dir(p), perm); err != nil {
+		return err
+	}
+
+	e, err := fs.lookup(p)
+	switch {
+	case os.IsNotExist(err):
+		return fs.mkdir(p, perm)
+	case err != nil:
+		return err
+	case !e.fi.IsDir():
+		return errNotDir
+	case e.layer == 0:
+		return nil
+	}
+
+	return fs.upper().MkdirAll(p, e.fi.Mode().Perm())
+}
+
+// mkdir creates the given directory in the upper layer, making it opaque if
+// it replaces a removed one.
+func (fs *Union) mkdir(p string, perm os.FileMode) error {
+	removed, err := fs.unwhiteout(p)
+	if err != nil {
+		return err
+	}
+
+	if err := fs.upper().MkdirAll(p, perm); err != nil {
+		return err
+	}
+
+	if !removed {
+		return nil
+	}
+
+	return util.WriteFile(fs.upper(), filepath.Join(p, Opaque), nil, 0644)
+}
+
+// whiteout records the given file of the lower layers as removed.
+func (fs *Union) whiteout(p string) error {
+	if err := fs.makeDirs(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	return util.WriteFile(fs.upper(), whiteoutPath(p), nil, 0644)
+}
+
+// unwhiteout removes the whiteout of the given file from the upper layer,
+// returning true if found.
+func (fs *Union) unwhiteout(p string) (bool, error) {
+	err := fs.upper().Remove(whiteoutPath(p))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (fs *Union) Stat(filename string) (os.FileInfo, error) {
+	_, e, err := fs.follow(clean(filename))
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: filename, Err: err}
+	}
+
+	// the name is the one of the stated file, even if it's a link.
+	return &fileInfo{FileInfo: e.fi, name: filepath.Base(filename)}, nil
+}
+
+func (fs *Union) Lstat(filename string) (os.FileInfo, error) {
+	e, err := fs.lookup(clean(filename))
+	if err != nil {
+		return nil, &os.PathError{Op: "lstat", Path: filename, Err: err}
+	}
+
+	return e.fi, nil
+}
+
+func (fs *Union) ReadDir(path string) ([]os.FileInfo, error) {
+	infos, err := fs.readDir(clean(path))
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: path, Err: err}
+	}
+
+	return infos, nil
+}
+
+func (fs *Union) readDir(p string) ([]os.FileInfo, error) {
+	p, e, err := fs.follow(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if !e.fi.IsDir() {
+		return nil, errNotDir
+	}
+
+	entries := make(map[string]os.FileInfo)
+	removed := make(map[string]bool)
+	for _, i := range e.dirs {
+		infos, err := fs.layers[i].ReadDir(p)
+		if err != nil {
+			return nil, err
+		}
+
+		// the whiteouts hide only the files of the lower layers
+		var whiteouts []string
+		for _, fi := range infos {
+			name := fi.Name()
+			if strings.HasPrefix(name, Prefix) {
+				whiteouts = append(whiteouts, strings.TrimPrefix(name, Prefix))
+				continue
+			}
+
+			if _, ok := entries[name]; ok || removed[name] {
+				continue
+			}
+
+			entries[name] = fi
+		}
+
+		for _, name := range whiteouts {
+			removed[name] = true
+		}
+	}
+
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, fi := range entries {
+		infos = append(infos, fi)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+
+	return infos, nil
+}
+
+func (fs *Union) MkdirAll(filename string, perm os.FileMode) error {
+	p := clean(filename)
+	_, e, err := fs.follow(p)
+	if err == nil {
+		if !e.fi.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: filename, Err: errNotDir}
+		}
+
+		return nil
+	}
+
+	if err := fs.makeDirs(p, perm); err != nil {
+		return &os.PathError{Op: "mkdir", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Union) Remove(filename string) error {
+	if err := fs.remove(clean(filename)); err != nil {
+		return &os.PathError{Op: "remove", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Union) remove(p string) error {
+	e, err := fs.lookup(p)
+	if err != nil {
+		return err
+	}
+
+	if e.fi.IsDir() {
+		infos, err := fs.readDir(p)
+		if err != nil {
+			return err
+		}
+
+		if len(infos) != 0 {
+			return ErrNotEmpty
+		}
+	}
+
+	return fs.removeAll(p)
+}
+
+// removeAll removes the given file, and everything below it, from the upper
+// layer, and records a whiteout if still found in the lower ones.
+func (fs *Union) removeAll(p string) error {
+	if err := util.RemoveAll(fs.upper(), p); err != nil {
+		return err
+	}
+
+	if _, err := fs.lookup(p); err != nil {
+		return nil
+	}
+
+	return fs.whiteout(p)
+}
+
+// Rename copies the given file, or directory with all its content, to the
+// upper layer with the new name, and removes the old one.
+func (fs *Union) Rename(from, to string) error {
+	if err := fs.rename(clean(from), clean(to)); err != nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Union) rename(from, to string) error {
+	e, err := fs.lookup(from)
+	if err != nil {
+		return err
+	}
+
+	if from == to {
+		return nil
+	}
+
+	if strings.HasPrefix(to, from+separator) {
+		return errors.New("cannot move a directory into itself")
+	}
+
+	if dst, err := fs.lookup(to); err == nil {
+		if dst.fi.IsDir() != e.fi.IsDir() {
+			return os.ErrExist
+		}
+
+		if err := fs.remove(to); err != nil {
+			return err
+		}
+	}
+
+	if err := fs.copyTree(from, to, e); err != nil {
+		return err
+	}
+
+	return fs.removeAll(from)
+}
+
+// copyTree copies to the upper layer the given file, or directory with all
+// its content, as seen in the union.
+func (fs *Union) copyTree(from, to string, e *entry) error {
+	if e.fi.IsDir() {
+		if err := fs.makeDirs(to, e.fi.Mode().Perm()); err != nil {
+			return err
+		}
+
+		infos, err := fs.readDir(from)
+		if err != nil {
+			return err
+		}
+
+		for _, fi := range infos {
+			child, err := fs.lookup(filepath.Join(from, fi.Name()))
+			if err != nil {
+				return err
+			}
+
+			err = fs.copyTree(filepath.Join(from, fi.Name()), filepath.Join(to, fi.Name()), child)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if err := fs.prepare(to); err != nil {
+		return err
+	}
+
+	if e.fi.Mode()&os.ModeSymlink != 0 {
+		target, err := fs.layers[e.layer].Readlink(from)
+		if err != nil {
+			return err
+		}
+
+		return fs.upper().Symlink(target, to)
+	}
+
+	return fileutil.Copy(fs.layers[e.layer], from, fs.upper(), to, e.fi.Mode().Perm())
+}
+
+func (fs *Union) Symlink(target, link string) error {
+	p := clean(link)
+	_, err := fs.lookup(p)
+	if err == nil {
+		err = os.ErrExist
+	} else if os.IsNotExist(err) {
+		err = fs.prepare(p)
+	}
+
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: err}
+	}
+
+	return fs.upper().Symlink(target, p)
+}
+
+func (fs *Union) Readlink(link string) (string, error) {
+	p := clean(link)
+	e, err := fs.lookup(p)
+	if err == nil {
+		var target string
+		target, err = fs.layers[e.layer].Readlink(p)
+		if err == nil {
+			return target, nil
+		}
+	}
+
+	return "", &os.PathError{Op: "readlink", Path: link, Err: errutil.Underlying(err)}
+}
+
+func (fs *Union) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(fs, dir, prefix)
+}
+
+func (fs *Union) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (fs *Union) Chroot(path string) (billy.Filesystem, error) {
+	return chroot.New(fs, fs.Join(fs.Root(), path)), nil
+}
+
+func (fs *Union) Root() string {
+	return separator
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Union) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.upper())
+}
+
+type file struct {
+	billy.File
+	name string
+}
+
+func (f *file) Name() string {
+	return f.name
+}
+
+type fileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+// whiteoutPath returns the path of the whiteout of the given file.
+func whiteoutPath(p string) string {
+	return filepath.Join(filepath.Dir(p), Prefix+filepath.Base(p))
+}
+
+// isWhiteout returns true if the given file has a whiteout in the given layer.
+func isWhiteout(l billy.Filesystem, p string) bool {
+	_, err := l.Lstat(whiteoutPath(p))
+	return err == nil
+}
+
+// isOpaque returns true if the given directory is opaque in the given layer.
+func isOpaque(l billy.Filesystem, dir string) bool {
+	_, err := l.Lstat(filepath.Join(dir, Opaque))
+	return err == nil
+}
+
+// clean returns the given path as an absolute clean path.
+func clean(p string) string {
+	return filepath.Join(separator, p)
+}
+
+// isReserved returns true if any element of the given clean path starts with
+// the prefix of the whiteouts.
+func isReserved(p string) bool {
+	return strings.HasPrefix(filepath.Base(p), Prefix) ||
+		strings.Contains(p, separator+Prefix)
+}
+
+// isWrite returns true if opening a file with the given flag may modify it,
+// so a file of a lower layer must be copied up first.
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR|os.O_TRUNC|os.O_CREATE|os.O_APPEND) != 0
+}
@@ -0,0 +1,175 @@
+package whiteout
+
+import (
+	"os"
+	"sort"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&WhiteoutSuite{})
+
+type WhiteoutSuite struct {
+	test.FilesystemSuite
+	upper billy.Filesystem
+	// base is the first layer applied, and top the last one.
+	base billy.Filesystem
+	top  billy.Filesystem
+	FS   *Union
+}
+
+func (s *WhiteoutSuite) SetUpTest(c *C) {
+	s.upper = memfs.New()
+	s.base = memfs.New()
+	s.top = memfs.New()
+	s.FS = New(s.upper, s.base, s.top)
+	s.FilesystemSuite = test.NewFilesystemSuite(s.FS)
+}
+
+func (s *WhiteoutSuite) TestLayers(c *C) {
+	c.Assert(util.WriteFile(s.base, "foo", []byte("base"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.base, "bar", []byte("bar"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.base, "dir/baz", []byte("baz"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.top, "foo", []byte("top"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.top, ".wh.bar", nil, 0644), IsNil)
+	c.Assert(util.WriteFile(s.top, "dir/qux", []byte("qux"), 0644), IsNil)
+
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "top")
+	_, err := s.FS.Stat("bar")
+	c.Assert(os.IsNotExist(err), Equals, true)
+	_, err = s.FS.Stat(".wh.bar")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	c.Assert(readDir(c, s.FS, "/"), DeepEquals, []string{"dir", "foo"})
+	c.Assert(readDir(c, s.FS, "dir"), DeepEquals, []string{"baz", "qux"})
+}
+
+func (s *WhiteoutSuite) TestOpaque(c *C) {
+	c.Assert(util.WriteFile(s.base, "dir/foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.top, "dir/bar", []byte("bar"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.top, "dir/.wh..wh..opq", nil, 0644), IsNil)
+
+	c.Assert(readDir(c, s.FS, "dir"), DeepEquals, []string{"bar"})
+	_, err := s.FS.Stat("dir/foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *WhiteoutSuite) TestRemove(c *C) {
+	c.Assert(util.WriteFile(s.base, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.base, "dir/bar", []byte("bar"), 0644), IsNil)
+
+	c.Assert(s.FS.Remove("foo"), IsNil)
+	c.Assert(s.FS.Remove("dir/bar"), IsNil)
+
+	_, err := s.upper.Stat(".wh.foo")
+	c.Assert(err, IsNil)
+	_, err = s.upper.Stat("dir/.wh.bar")
+	c.Assert(err, IsNil)
+	c.Assert(readDir(c, s.FS, "/"), DeepEquals, []string{"dir"})
+
+	// recreating a removed file only removes its whiteout
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("new"), 0644), IsNil)
+	_, err = s.upper.Stat(".wh.foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "new")
+}
+
+func (s *WhiteoutSuite) TestRecreateDir(c *C) {
+	c.Assert(util.WriteFile(s.base, "dir/foo", []byte("foo"), 0644), IsNil)
+
+	c.Assert(s.FS.Remove("dir/foo"), IsNil)
+	c.Assert(s.FS.Remove("dir"), IsNil)
+	_, err := s.upper.Stat(".wh.dir")
+	c.Assert(err, IsNil)
+	_, err = s.upper.Stat("dir")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	c.Assert(util.WriteFile(s.FS, "dir/bar", []byte("bar"), 0644), IsNil)
+	_, err = s.upper.Stat("dir/.wh..wh..opq")
+	c.Assert(err, IsNil)
+	c.Assert(readDir(c, s.FS, "dir"), DeepEquals, []string{"bar"})
+}
+
+func (s *WhiteoutSuite) TestCopyUp(c *C) {
+	c.Assert(util.WriteFile(s.top, "dir/foo", []byte("foo"), 0600), IsNil)
+
+	f, err := s.FS.OpenFile("dir/foo", os.O_WRONLY|os.O_APPEND, 0)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("bar"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	c.Assert(test.ReadFile(c, s.upper, "dir/foo"), Equals, "foobar")
+	c.Assert(test.ReadFile(c, s.top, "dir/foo"), Equals, "foo")
+
+	fi, err := s.upper.Stat("dir/foo")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode().Perm(), Equals, os.FileMode(0600))
+}
+
+func (s *WhiteoutSuite) TestTruncateReadOnly(c *C) {
+	c.Assert(util.WriteFile(s.top, "foo", []byte("foo"), 0644), IsNil)
+
+	f, err := s.FS.OpenFile("foo", os.O_RDONLY|os.O_TRUNC, 0)
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	c.Assert(test.ReadFile(c, s.top, "foo"), Equals, "foo")
+	c.Assert(test.ReadFile(c, s.upper, "foo"), Equals, "")
+}
+
+func (s *WhiteoutSuite) TestReservedName(c *C) {
+	_, err := s.FS.Create(".wh.foo")
+	c.Assert(err.(*os.PathError).Err, Equals, ErrReservedName)
+
+	err = s.FS.MkdirAll("dir/.wh.foo", 0755)
+	c.Assert(err.(*os.PathError).Err, Equals, ErrReservedName)
+}
+
+func (s *WhiteoutSuite) TestFlattenAndApply(c *C) {
+	c.Assert(util.WriteFile(s.base, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.base, "dir/bar", []byte("bar"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.top, "baz", []byte("baz"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.top, "dir/.wh..wh..opq", nil, 0644), IsNil)
+	c.Assert(util.WriteFile(s.top, "dir/qux", []byte("qux"), 0644), IsNil)
+	c.Assert(s.FS.Remove("foo"), IsNil)
+	c.Assert(s.top.Symlink("baz", "link"), IsNil)
+
+	flat := memfs.New()
+	c.Assert(s.FS.Flatten(flat), IsNil)
+
+	applied := memfs.New()
+	for _, layer := range []billy.Filesystem{s.base, s.top, s.upper} {
+		c.Assert(Apply(applied, layer), IsNil)
+	}
+
+	for _, fs := range []billy.Filesystem{flat, applied} {
+		c.Assert(readDir(c, fs, "/"), DeepEquals, []string{"baz", "dir", "link"})
+		c.Assert(readDir(c, fs, "dir"), DeepEquals, []string{"qux"})
+
+		target, err := fs.Readlink("link")
+		c.Assert(err, IsNil)
+		c.Assert(target, Equals, "baz")
+	}
+}
+
+func readDir(c *C, fs billy.Filesystem, dir string) []string {
+	infos, err := fs.ReadDir(dir)
+	c.Assert(err, IsNil)
+
+	names := make([]string, 0, len(infos))
+	for _, fi := range infos {
+		names = append(names, fi.Name())
+	}
+
+	sort.Strings(names)
+	return names
+}
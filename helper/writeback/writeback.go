@@ -0,0 +1,685 @@
+// Package writeback provides a helper writing the files to a local staging
+// filesystem, and copying them in the background to the underlying one, eg.:
+// to hide the latency of a filesystem served over the network.
+package writeback
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/internal/fileutil"
+	"gopkg.in/src-d/go-billy.v4/internal/linkutil"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const (
+	separator = string(filepath.Separator)
+)
+
+var (
+	// ErrNotEmpty is returned when removing a directory with files.
+	ErrNotEmpty = billy.ErrNotEmpty
+	// ErrClosed is returned when changing a filesystem already closed.
+	ErrClosed = errors.New("write-back filesystem closed")
+
+	errNotDir = errors.New("not a directory")
+)
+
+// WriteBack is a helper staging the files open for writing, which are copied
+// to the underlying filesystem in the background once closed. The files
+// staged are read from the staging filesystem until copied, and every other
+// change is made directly to the underlying filesystem.
+//
+// The errors copying the files are returned by Flush and WaitIdle, and the
+// files are kept staged until copied by a later Flush. The access to both
+// filesystems is serialized, so they don't need to be safe for concurrent
+// use.
+type WriteBack struct {
+	underlying billy.Filesystem
+	staging    billy.Filesystem
+
+	// u serializes the access to the underlying filesystem, shared with the
+	// copies in the background. It's always locked after m.
+	u sync.Mutex
+
+	m      sync.Mutex
+	idle   *sync.Cond
+	wake   chan struct{}
+	files  map[string]*staged
+	queue  []string
+	busy   bool
+	err    error
+	closed bool
+}
+
+// staged is the state of a file in the staging filesystem.
+type staged struct {
+	// open is the number of files open for writing.
+	open int
+	// version is increased every time the file is closed, so a copy made
+	// while it's written again is not taken as the last one.
+	version int
+}
+
+// Options holds the configuration of a WriteBack.
+type Options struct {
+	// Staging is the filesystem where the files are written, memory if nil.
+	// It should be a filesystem used only by the helper.
+	Staging billy.Filesystem
+}
+
+// New creates a new write-back filesystem over the given one. It must be
+// closed to stop copying the files in the background.
+func New(fs billy.Filesystem, opts Options) *WriteBack {
+	if opts.Staging == nil {
+		opts.Staging = memfs.New()
+	}
+
+	wb := &WriteBack{
+		underlying: fs,
+		staging:    opts.Staging,
+		wake:       make(chan struct{}, 1),
+		files:      make(map[string]*staged),
+	}
+
+	wb.idle = sync.NewCond(&wb.m)
+	go wb.run()
+	return wb
+}
+
+// Flush copies every file staged, including the ones that failed before,
+// waiting for them. The files still open are not copied. It returns the
+// first error found since the last call to Flush or WaitIdle.
+func (fs *WriteBack) Flush() error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	if !fs.closed {
+		for p, s := range fs.files {
+			if s.open == 0 {
+				fs.queue = append(fs.queue, p)
+			}
+		}
+
+		fs.signal()
+	}
+
+	return fs.waitIdle()
+}
+
+// WaitIdle waits until the files closed are copied. It returns the first
+// error found since the last call to Flush or WaitIdle.
+func (fs *WriteBack) WaitIdle() error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	return fs.waitIdle()
+}
+
+// waitIdle waits until the queue is empty, returning the error found, if
+// any. It must be called with the lock held.
+func (fs *WriteBack) waitIdle() error {
+	for len(fs.queue) != 0 || fs.busy {
+		fs.idle.Wait()
+	}
+
+	err := fs.err
+	fs.err = nil
+	return err
+}
+
+// Close flushes the files staged and stops copying them in the background.
+// The files still open are copied when closed, and the filesystem can't be
+// changed anymore.
+func (fs *WriteBack) Close() error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	if fs.closed {
+		return ErrClosed
+	}
+
+	for p, s := range fs.files {
+		if s.open == 0 {
+			fs.queue = append(fs.queue, p)
+		}
+	}
+
+	fs.signal()
+	err := fs.waitIdle()
+	fs.closed = true
+	close(fs.wake)
+	return err
+}
+
+// signal wakes up the copy of the files queued. It must be called with the
+// lock held, before closing.
+func (fs *WriteBack) signal() {
+	select {
+	case fs.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run copies the files queued, until closed.
+func (fs *WriteBack) run() {
+	for range fs.wake {
+		fs.m.Lock()
+		for len(fs.queue) != 0 {
+			p := fs.queue[0]
+			fs.queue = fs.queue[1:]
+
+			s, ok := fs.files[p]
+			if !ok || s.open != 0 {
+				continue
+			}
+
+			// the content is read with m held, so the file isn't written
+			// meanwhile, and written with u held, so no change is made to
+			// the underlying filesystem until then.
+			version := s.version
+			content, perm, err := fs.read(p)
+			if err == nil {
+				fs.busy = true
+				fs.u.Lock()
+				fs.m.Unlock()
+
+				err = util.WriteFile(fs.underlying, p, content, perm)
+
+				fs.u.Unlock()
+				fs.m.Lock()
+				fs.busy = false
+			}
+
+			if err != nil {
+				if fs.err == nil {
+					fs.err = err
+				}
+
+				continue
+			}
+
+			if s, ok := fs.files[p]; ok && s.version == version && s.open == 0 {
+				fs.unstage(p)
+			}
+		}
+
+		fs.idle.Broadcast()
+		fs.m.Unlock()
+	}
+}
+
+// read returns the content and the permissions of the given staged file. It
+// must be called with the lock held.
+func (fs *WriteBack) read(p string) ([]byte, os.FileMode, error) {
+	fi, err := fs.staging.Stat(p)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	f, err := fs.staging.Open(p)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer f.Close()
+	content, err := ioutil.ReadAll(f)
+	return content, fi.Mode().Perm(), err
+}
+
+// unstage removes the given file from the staging filesystem. It must be
+// called with the lock held.
+func (fs *WriteBack) unstage(p string) {
+	fs.staging.Remove(p)
+	delete(fs.files, p)
+}
+
+func (fs *WriteBack) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *WriteBack) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file. The files open for writing are staged,
+// copying first their content if not truncated.
+func (fs *WriteBack) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	fs.lock()
+	defer fs.unlock()
+
+	p := fs.resolve(filename)
+
+	s, isStaged := fs.files[p]
+	if !isWrite(flag) {
+		if isStaged {
+			return fs.open(fs.staging, p, filename, flag, perm)
+		}
+
+		return fs.underlying.OpenFile(filename, flag, perm)
+	}
+
+	if fs.closed {
+		return nil, ErrClosed
+	}
+
+	if isStaged && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrExist}
+	}
+
+	if !isStaged {
+		var err error
+		if s, err = fs.stage(p, flag, perm); err != nil {
+			return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+		}
+	}
+
+	f, err := fs.open(fs.staging, p, filename, flag, perm)
+	if err != nil {
+		if !isStaged {
+			fs.unstage(p)
+		}
+
+		return nil, err
+	}
+
+	s.open++
+	return &file{File: f, fs: fs, path: p}, nil
+}
+
+// stage copies the given file of the underlying filesystem to the staging
+// one, or creates it empty if it's going to be truncated, creating its parent
+// directories in the underlying filesystem. It must be called with the lock
+// held.
+func (fs *WriteBack) stage(p string, flag int, perm os.FileMode) (*staged, error) {
+	fi, err := fs.underlying.Stat(p)
+	switch {
+	case err == nil && fi.IsDir():
+		return nil, errors.New("is a directory")
+	case err == nil && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, os.ErrExist
+	case err == nil && flag&os.O_TRUNC == 0:
+		err = fileutil.Copy(fs.underlying, p, fs.staging, p, fi.Mode().Perm())
+	case err == nil:
+		err = util.WriteFile(fs.staging, p, nil, fi.Mode().Perm())
+	case os.IsNotExist(err) && flag&os.O_CREATE != 0:
+		if err = fs.underlying.MkdirAll(filepath.Dir(p), 0755); err == nil {
+			err = util.WriteFile(fs.staging, p, nil, perm)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	s := &staged{}
+	fs.files[p] = s
+	return s, nil
+}
+
+func (fs *WriteBack) open(src billy.Filesystem, p, filename string, flag int, perm os.FileMode) (billy.File, error) {
+	f, err := src.OpenFile(p, flag&^os.O_EXCL, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &namedFile{File: f, name: strings.TrimLeft(filename, separator)}, nil
+}
+
+// resolve returns the clean path of the given file, following the symlinks
+// of the underlying filesystem, since they may point to a staged file. It
+// must be called with the locks held.
+func (fs *WriteBack) resolve(filename string) string {
+	p, _ := linkutil.Follow(clean(filename), clean, func(p string) (bool, error) {
+		if _, ok := fs.files[p]; ok {
+			return false, nil
+		}
+
+		fi, err := fs.underlying.Lstat(p)
+		return err == nil && fi.Mode()&os.ModeSymlink != 0, nil
+	}, fs.underlying.Readlink)
+
+	return p
+}
+
+// release queues the given file to be copied, when closed the last file open
+// for writing it. Once the filesystem is closed, it's copied right away.
+func (fs *WriteBack) release(p string) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	s, ok := fs.files[p]
+	if !ok {
+		// removed while open
+		return nil
+	}
+
+	s.open--
+	s.version++
+	if s.open != 0 {
+		return nil
+	}
+
+	if !fs.closed {
+		fs.queue = append(fs.queue, p)
+		fs.signal()
+		return nil
+	}
+
+	content, perm, err := fs.read(p)
+	if err != nil {
+		return err
+	}
+
+	fs.u.Lock()
+	defer fs.u.Unlock()
+
+	if err := util.WriteFile(fs.underlying, p, content, perm); err != nil {
+		return err
+	}
+
+	fs.unstage(p)
+	return nil
+}
+
+func (fs *WriteBack) Stat(filename string) (os.FileInfo, error) {
+	fs.lock()
+	defer fs.unlock()
+
+	p := fs.resolve(filename)
+	if _, ok := fs.files[p]; ok {
+		fi, err := fs.staging.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+
+		return &fileInfo{FileInfo: fi, name: filepath.Base(filename)}, nil
+	}
+
+	return fs.underlying.Stat(filename)
+}
+
+func (fs *WriteBack) Lstat(filename string) (os.FileInfo, error) {
+	fs.lock()
+	defer fs.unlock()
+
+	return fs.source(filename).Lstat(filename)
+}
+
+// source returns the filesystem where the given file is read from. It must be
+// called with the locks held.
+func (fs *WriteBack) source(filename string) billy.Filesystem {
+	if _, ok := fs.files[clean(filename)]; ok {
+		return fs.staging
+	}
+
+	return fs.underlying
+}
+
+// lock locks the access to both filesystems.
+func (fs *WriteBack) lock() {
+	fs.m.Lock()
+	fs.u.Lock()
+}
+
+func (fs *WriteBack) unlock() {
+	fs.u.Unlock()
+	fs.m.Unlock()
+}
+
+// ReadDir returns the entries of the given directory, with the files staged
+// taking precedence.
+func (fs *WriteBack) ReadDir(path string) ([]os.FileInfo, error) {
+	fs.lock()
+	defer fs.unlock()
+
+	infos, err := fs.underlying.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := fs.resolve(path)
+
+	entries := make(map[string]os.FileInfo)
+	for _, fi := range infos {
+		entries[fi.Name()] = fi
+	}
+
+	for p := range fs.files {
+		if filepath.Dir(p) != dir {
+			continue
+		}
+
+		fi, err := fs.staging.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+
+		entries[fi.Name()] = fi
+	}
+
+	infos = make([]os.FileInfo, 0, len(entries))
+	for _, fi := range entries {
+		infos = append(infos, fi)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+
+	return infos, nil
+}
+
+func (fs *WriteBack) MkdirAll(filename string, perm os.FileMode) error {
+	fs.lock()
+	defer fs.unlock()
+
+	if fs.closed {
+		return ErrClosed
+	}
+
+	for p := clean(filename); p != separator; p = filepath.Dir(p) {
+		if _, ok := fs.files[p]; ok {
+			return &os.PathError{Op: "mkdir", Path: filename, Err: errNotDir}
+		}
+	}
+
+	return fs.underlying.MkdirAll(filename, perm)
+}
+
+// Remove removes the given file, discarding it if staged.
+func (fs *WriteBack) Remove(filename string) error {
+	p := clean(filename)
+
+	fs.lock()
+	defer fs.unlock()
+
+	if fs.closed {
+		return ErrClosed
+	}
+
+	if fs.stagedBelow(p) {
+		return &os.PathError{Op: "remove", Path: filename, Err: ErrNotEmpty}
+	}
+
+	_, isStaged := fs.files[p]
+	if isStaged {
+		fs.unstage(p)
+	}
+
+	err := fs.underlying.Remove(filename)
+	if isStaged && os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// Rename renames the given file, or directory, in the underlying filesystem,
+// and the files staged below it.
+func (fs *WriteBack) Rename(from, to string) error {
+	src, dst := clean(from), clean(to)
+
+	fs.lock()
+	defer fs.unlock()
+
+	if fs.closed {
+		return ErrClosed
+	}
+
+	if src == dst {
+		return nil
+	}
+
+	for p := range fs.files {
+		if p == dst || strings.HasPrefix(p, dst+separator) {
+			fs.unstage(p)
+		}
+	}
+
+	var moved bool
+	for p, s := range fs.files {
+		if p != src && !strings.HasPrefix(p, src+separator) {
+			continue
+		}
+
+		np := dst + strings.TrimPrefix(p, src)
+		if err := fs.staging.MkdirAll(filepath.Dir(np), 0755); err != nil {
+			return err
+		}
+
+		if err := fs.staging.Rename(p, np); err != nil {
+			return err
+		}
+
+		delete(fs.files, p)
+		fs.files[np] = s
+		moved = true
+
+		// the copy queued with the old name is discarded
+		if s.open == 0 {
+			fs.queue = append(fs.queue, np)
+			fs.signal()
+		}
+	}
+
+	if _, err := fs.underlying.Lstat(from); os.IsNotExist(err) && moved {
+		return fs.underlying.MkdirAll(filepath.Dir(dst), 0755)
+	}
+
+	return fs.underlying.Rename(from, to)
+}
+
+// stagedBelow returns true if any file below the given path is staged. It
+// must be called with the lock held.
+func (fs *WriteBack) stagedBelow(p string) bool {
+	for staged := range fs.files {
+		if strings.HasPrefix(staged, p+separator) || p == separator {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (fs *WriteBack) Symlink(target, link string) error {
+	fs.lock()
+	defer fs.unlock()
+
+	if fs.closed {
+		return ErrClosed
+	}
+
+	if _, ok := fs.files[clean(link)]; ok {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: os.ErrExist}
+	}
+
+	return fs.underlying.Symlink(target, link)
+}
+
+func (fs *WriteBack) Readlink(link string) (string, error) {
+	fs.lock()
+	defer fs.unlock()
+
+	return fs.source(link).Readlink(link)
+}
+
+func (fs *WriteBack) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(fs, dir, prefix)
+}
+
+func (fs *WriteBack) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (fs *WriteBack) Chroot(path string) (billy.Filesystem, error) {
+	return chroot.New(fs, fs.Join(fs.Root(), path)), nil
+}
+
+func (fs *WriteBack) Root() string {
+	return separator
+}
+
+// Capabilities implements the Capable interface.
+func (fs *WriteBack) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.underlying) & billy.Capabilities(fs.staging)
+}
+
+// file is a staged file open for writing.
+type file struct {
+	billy.File
+	fs       *WriteBack
+	path     string
+	isClosed bool
+}
+
+func (f *file) Close() error {
+	if f.isClosed {
+		return os.ErrClosed
+	}
+
+	f.isClosed = true
+	err := f.File.Close()
+	if err := f.fs.release(f.path); err != nil {
+		return err
+	}
+
+	return err
+}
+
+type namedFile struct {
+	billy.File
+	name string
+}
+
+func (f *namedFile) Name() string {
+	return f.name
+}
+
+// fileInfo is the information of a staged file read through a symlink.
+type fileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+// clean returns the given path as an absolute clean path, the form used to
+// record the files staged.
+func clean(p string) string {
+	return filepath.Join(separator, p)
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
+}
@@ -0,0 +1,170 @@
+package writeback
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&WriteBackSuite{})
+
+type WriteBackSuite struct {
+	test.FilesystemSuite
+	underlying *failing
+	staging    billy.Filesystem
+	wb         *WriteBack
+}
+
+var errUnavailable = errors.New("unavailable")
+
+// failing is a filesystem failing to open the files for writing while
+// unavailable.
+type failing struct {
+	billy.Filesystem
+
+	m           sync.Mutex
+	unavailable bool
+}
+
+func (fs *failing) setUnavailable(unavailable bool) {
+	fs.m.Lock()
+	fs.unavailable = unavailable
+	fs.m.Unlock()
+}
+
+func (fs *failing) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	fs.m.Lock()
+	unavailable := fs.unavailable
+	fs.m.Unlock()
+
+	if unavailable && flag != os.O_RDONLY {
+		return nil, errUnavailable
+	}
+
+	return fs.Filesystem.OpenFile(filename, flag, perm)
+}
+
+func (fs *failing) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (s *WriteBackSuite) SetUpTest(c *C) {
+	s.underlying = &failing{Filesystem: memfs.New()}
+	s.staging = memfs.New()
+	s.wb = New(s.underlying, Options{Staging: s.staging})
+	s.FilesystemSuite = test.NewFilesystemSuite(s.wb)
+}
+
+func (s *WriteBackSuite) TearDownTest(c *C) {
+	s.underlying.setUnavailable(false)
+	s.wb.Close()
+}
+
+func (s *WriteBackSuite) TestWriteBack(c *C) {
+	c.Assert(util.WriteFile(s.FS, "dir/foo", []byte("foo"), 0600), IsNil)
+	c.Assert(s.wb.WaitIdle(), IsNil)
+
+	c.Assert(test.ReadFile(c, s.underlying, "dir/foo"), Equals, "foo")
+	fi, err := s.underlying.Stat("dir/foo")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode().Perm(), Equals, os.FileMode(0600))
+
+	_, err = s.staging.Stat("dir/foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *WriteBackSuite) TestAppend(c *C) {
+	c.Assert(util.WriteFile(s.underlying, "foo", []byte("foo"), 0644), IsNil)
+
+	f, err := s.FS.OpenFile("foo", os.O_WRONLY|os.O_APPEND, 0)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("bar"))
+	c.Assert(err, IsNil)
+	c.Assert(test.ReadFile(c, s.underlying, "foo"), Equals, "foo")
+	c.Assert(f.Close(), IsNil)
+
+	c.Assert(s.wb.WaitIdle(), IsNil)
+	c.Assert(test.ReadFile(c, s.underlying, "foo"), Equals, "foobar")
+}
+
+func (s *WriteBackSuite) TestFlushError(c *C) {
+	s.underlying.setUnavailable(true)
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(s.wb.WaitIdle(), Equals, errUnavailable)
+	c.Assert(s.wb.WaitIdle(), IsNil)
+
+	// read from the staging filesystem meanwhile
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "foo")
+	infos, err := s.FS.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 1)
+
+	c.Assert(s.wb.Flush(), Equals, errUnavailable)
+
+	s.underlying.setUnavailable(false)
+	c.Assert(s.wb.Flush(), IsNil)
+	c.Assert(test.ReadFile(c, s.underlying, "foo"), Equals, "foo")
+}
+
+func (s *WriteBackSuite) TestRemoveStaged(c *C) {
+	s.underlying.setUnavailable(true)
+	c.Assert(util.WriteFile(s.FS, "dir/foo", []byte("foo"), 0644), IsNil)
+
+	err := s.FS.Remove("dir")
+	c.Assert(err.(*os.PathError).Err, Equals, ErrNotEmpty)
+
+	c.Assert(s.FS.Remove("dir/foo"), IsNil)
+	_, err = s.FS.Stat("dir/foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	s.underlying.setUnavailable(false)
+	c.Assert(s.wb.Flush(), IsNil)
+	_, err = s.underlying.Stat("dir/foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *WriteBackSuite) TestRenameStaged(c *C) {
+	c.Assert(util.WriteFile(s.underlying, "dir/bar", []byte("bar"), 0644), IsNil)
+	s.underlying.setUnavailable(true)
+	c.Assert(util.WriteFile(s.FS, "dir/foo", []byte("foo"), 0644), IsNil)
+
+	c.Assert(s.FS.Rename("dir", "new"), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "new/foo"), Equals, "foo")
+	c.Assert(test.ReadFile(c, s.FS, "new/bar"), Equals, "bar")
+
+	s.underlying.setUnavailable(false)
+	c.Assert(s.wb.Flush(), IsNil)
+	c.Assert(test.ReadFile(c, s.underlying, "new/foo"), Equals, "foo")
+
+	_, err := s.underlying.Stat("dir")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *WriteBackSuite) TestClose(c *C) {
+	f, err := s.FS.Create("foo")
+	c.Assert(err, IsNil)
+	c.Assert(util.WriteFile(s.FS, "bar", []byte("bar"), 0644), IsNil)
+
+	c.Assert(s.wb.Close(), IsNil)
+	c.Assert(test.ReadFile(c, s.underlying, "bar"), Equals, "bar")
+
+	// the files still open are copied when closed
+	_, err = f.Write([]byte("foo"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+	c.Assert(test.ReadFile(c, s.underlying, "foo"), Equals, "foo")
+
+	_, err = s.FS.Create("qux")
+	c.Assert(err, Equals, ErrClosed)
+	c.Assert(s.wb.Close(), Equals, ErrClosed)
+}
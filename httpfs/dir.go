@@ -0,0 +1,127 @@
+package httpfs
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+var (
+	errNotDir = errors.New("not a directory")
+	errIsDir  = errors.New("is a directory")
+)
+
+// Dir returns a http.FileSystem serving the files of the given filesystem,
+// to be used with http.FileServer as a http.Dir. The files must support
+// Seek to be served.
+func Dir(fs billy.Filesystem) http.FileSystem {
+	return &dir{fs: fs}
+}
+
+type dir struct {
+	fs billy.Filesystem
+}
+
+func (d *dir) Open(name string) (http.File, error) {
+	p := path.Clean("/" + name)
+	fi, err := d.fs.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if fi.IsDir() {
+		return &httpDir{fs: d.fs, path: p, fi: fi}, nil
+	}
+
+	f, err := d.fs.Open(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return &httpFile{File: f, fi: fi}, nil
+}
+
+// httpFile is a regular file served by Dir.
+type httpFile struct {
+	billy.File
+	fi os.FileInfo
+}
+
+func (f *httpFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, &os.PathError{Op: "readdir", Path: f.Name(), Err: errNotDir}
+}
+
+func (f *httpFile) Stat() (os.FileInfo, error) {
+	return f.fi, nil
+}
+
+// httpDir is a directory served by Dir, its entries are read on the first
+// call to Readdir.
+type httpDir struct {
+	fs      billy.Filesystem
+	path    string
+	fi      os.FileInfo
+	entries []os.FileInfo
+	read    bool
+}
+
+func (d *httpDir) Read(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "read", Path: d.path, Err: errIsDir}
+}
+
+func (d *httpDir) Seek(offset int64, whence int) (int64, error) {
+	if offset == 0 && whence == io.SeekStart {
+		d.entries, d.read = nil, false
+		return 0, nil
+	}
+
+	return 0, &os.PathError{Op: "seek", Path: d.path, Err: errIsDir}
+}
+
+// Readdir returns the next count entries of the directory, sorted by name,
+// or all the remaining ones if count is zero or negative.
+func (d *httpDir) Readdir(count int) ([]os.FileInfo, error) {
+	if !d.read {
+		entries, err := d.fs.ReadDir(d.path)
+		if err != nil {
+			return nil, err
+		}
+
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Name() < entries[j].Name()
+		})
+
+		d.entries, d.read = entries, true
+	}
+
+	if count <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	if count > len(d.entries) {
+		count = len(d.entries)
+	}
+
+	entries := d.entries[:count]
+	d.entries = d.entries[count:]
+	return entries, nil
+}
+
+func (d *httpDir) Stat() (os.FileInfo, error) {
+	return d.fi, nil
+}
+
+func (d *httpDir) Close() error {
+	return nil
+}
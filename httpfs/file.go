@@ -0,0 +1,194 @@
+package httpfs
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// file is a read-only billy.File over a HTTP resource, whose size is -1 when
+// unknown. The sequential reads share a single GET request, that is reopened
+// with a Range header when the position changes. Every ReadAt performs its
+// own Range request.
+type file struct {
+	fs   *HTTP
+	name string
+	size int64
+
+	body     io.ReadCloser
+	bpos     int64
+	position int64
+	isClosed bool
+}
+
+func newFile(fs *HTTP, name string, size int64) billy.File {
+	return &file{fs: fs, name: name, size: size}
+}
+
+func (f *file) Name() string {
+	return f.name
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	if f.size >= 0 && f.position >= f.size {
+		return 0, io.EOF
+	}
+
+	if f.body == nil || f.bpos != f.position {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.body.Read(p)
+	f.bpos += int64(n)
+	f.position += int64(n)
+	return n, err
+}
+
+func (f *file) open() error {
+	f.closeBody()
+
+	body, err := f.get(f.position, -1)
+	if err != nil {
+		return err
+	}
+
+	f.body, f.bpos = body, f.position
+	return nil
+}
+
+// get requests the content of the file between the offsets from and to, to
+// being inclusive, or -1 for the end of the file. If the server doesn't
+// support Range requests the content before from is discarded.
+func (f *file) get(from, to int64) (io.ReadCloser, error) {
+	var header http.Header
+	if from > 0 || to >= 0 {
+		header = byteRange(from, to)
+	}
+
+	res, err := f.fs.do(http.MethodGet, clean(f.name), false, header)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusPartialContent && from > 0 {
+		if _, err := io.CopyN(ioutil.Discard, res.Body, from); err != nil {
+			res.Body.Close()
+			return nil, err
+		}
+	}
+
+	return res.Body, nil
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: f.name, Err: errors.New("negative offset")}
+	}
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	to := off + int64(len(p)) - 1
+	if f.size >= 0 {
+		if off >= f.size {
+			return 0, io.EOF
+		}
+
+		if to >= f.size {
+			to = f.size - 1
+		}
+	}
+
+	body, err := f.get(off, to)
+	if err != nil {
+		return 0, err
+	}
+
+	defer body.Close()
+	n, err := io.ReadFull(body, p[:to-off+1])
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.position
+	case io.SeekEnd:
+		if f.size < 0 {
+			return 0, &os.PathError{Op: "seek", Path: f.name, Err: errors.New("unknown size")}
+		}
+
+		offset += f.size
+	}
+
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: errors.New("negative offset")}
+	}
+
+	f.position = offset
+	return f.position, nil
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	return 0, billy.ErrReadOnly
+}
+
+func (f *file) Truncate(size int64) error {
+	return billy.ErrReadOnly
+}
+
+func (f *file) Close() error {
+	if f.isClosed {
+		return os.ErrClosed
+	}
+
+	f.isClosed = true
+	return f.closeBody()
+}
+
+func (f *file) closeBody() error {
+	if f.body == nil {
+		return nil
+	}
+
+	err := f.body.Close()
+	f.body = nil
+	return err
+}
+
+// Lock is a no-op in httpfs.
+func (f *file) Lock() error {
+	return nil
+}
+
+// Unlock is a no-op in httpfs.
+func (f *file) Unlock() error {
+	return nil
+}
@@ -0,0 +1,341 @@
+// Package httpfs provides a read-only billy filesystem over a HTTP(S) static
+// file server.
+//
+// Files are read with GET requests, using Range requests to implement Seek
+// and ReadAt. Stat is implemented with HEAD requests, whose results are
+// cached. Optionally, ReadDir is implemented parsing the HTML index pages
+// generated by most of the static file servers.
+//
+// Conversely, Dir serves any billy filesystem with http.FileServer.
+package httpfs // import "gopkg.in/src-d/go-billy.v4/httpfs"
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+)
+
+// ErrIndexDisabled is returned by ReadDir when the index parsing is not
+// enabled.
+var ErrIndexDisabled = errors.New("directory index parsing disabled")
+
+// Options holds the configuration of a HTTP filesystem.
+type Options struct {
+	// Client sends the HEAD and GET requests of the files and directories,
+	// http.DefaultClient if nil. The bodies of the files are streamed while
+	// read, so its Timeout bounds the read of a whole file.
+	Client *http.Client
+	// Header is added to every request, eg.: to set authentication tokens.
+	Header http.Header
+	// CacheTTL is the time the result of a HEAD request is cached, zero
+	// means the results never expire, and a negative value disables the
+	// cache.
+	CacheTTL time.Duration
+	// ParseIndex enables ReadDir, parsing the links of the HTML index
+	// returned by the server for the directory URLs.
+	ParseIndex bool
+}
+
+// HTTP is a read-only filesystem over a HTTP server.
+type HTTP struct {
+	base *url.URL
+	opts Options
+
+	m     sync.Mutex
+	cache map[string]*cached
+}
+
+type cached struct {
+	fi      os.FileInfo
+	err     error
+	expires time.Time
+}
+
+// New returns a new read-only filesystem with the files found under the
+// given base URL.
+func New(baseURL string, opts Options) (billy.Filesystem, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme: %q", base.Scheme)
+	}
+
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+
+	fs := &HTTP{base: base, opts: opts, cache: make(map[string]*cached)}
+	return chroot.New(fs, string(filepath.Separator)), nil
+}
+
+// url returns the URL of the given path, the directories end with a slash.
+func (fs *HTTP) url(p string, dir bool) string {
+	u := *fs.base
+	u.Path = path.Join("/", u.Path, p)
+	if dir && !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+
+	u.RawPath = ""
+	return u.String()
+}
+
+func (fs *HTTP) do(method, p string, dir bool, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, fs.url(p, dir), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range fs.opts.Header {
+		req.Header[k] = v
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	res, err := fs.opts.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res, nil
+	}
+
+	res.Body.Close()
+	return nil, &os.PathError{Op: strings.ToLower(method), Path: p, Err: statusError(res)}
+}
+
+func statusError(res *http.Response) error {
+	switch res.StatusCode {
+	case http.StatusNotFound, http.StatusGone:
+		return os.ErrNotExist
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return os.ErrPermission
+	default:
+		return fmt.Errorf("unexpected status: %s", res.Status)
+	}
+}
+
+func (fs *HTTP) Create(filename string) (billy.File, error) {
+	return nil, billy.ErrReadOnly
+}
+
+func (fs *HTTP) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (fs *HTTP) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, billy.ErrReadOnly
+	}
+
+	fi, err := fs.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if fi.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: errors.New("is a directory")}
+	}
+
+	return newFile(fs, filename, fi.Size()), nil
+}
+
+// Stat returns the information of the given file, based on the response of
+// a HEAD request.
+func (fs *HTTP) Stat(filename string) (os.FileInfo, error) {
+	p := clean(filename)
+	if p == "/" {
+		return &fileInfo{name: string(filepath.Separator), dir: true}, nil
+	}
+
+	fs.m.Lock()
+	c, ok := fs.cache[p]
+	fs.m.Unlock()
+
+	if ok && (c.expires.IsZero() || time.Now().Before(c.expires)) {
+		return c.fi, c.err
+	}
+
+	fi, err := fs.head(p)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if fs.opts.CacheTTL >= 0 {
+		c := &cached{fi: fi, err: err}
+		if fs.opts.CacheTTL > 0 {
+			c.expires = time.Now().Add(fs.opts.CacheTTL)
+		}
+
+		fs.m.Lock()
+		fs.cache[p] = c
+		fs.m.Unlock()
+	}
+
+	return fi, err
+}
+
+func (fs *HTTP) head(p string) (os.FileInfo, error) {
+	res, err := fs.do(http.MethodHead, p, false, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res.Body.Close()
+
+	fi := &fileInfo{name: path.Base(p), size: res.ContentLength}
+	if t, err := http.ParseTime(res.Header.Get("Last-Modified")); err == nil {
+		fi.modTime = t
+	}
+
+	// the servers redirect the directories to its URL with a trailing slash.
+	if strings.HasSuffix(res.Request.URL.Path, "/") {
+		fi.dir, fi.size = true, 0
+	}
+
+	return fi, nil
+}
+
+// Lstat is equivalent to Stat, since HTTP has no concept of symlinks.
+func (fs *HTTP) Lstat(filename string) (os.FileInfo, error) {
+	return fs.Stat(filename)
+}
+
+// ReadDir returns the entries of the directory, parsing the HTML index
+// returned by the server. It requires Options.ParseIndex.
+func (fs *HTTP) ReadDir(filename string) ([]os.FileInfo, error) {
+	if !fs.opts.ParseIndex {
+		return nil, ErrIndexDisabled
+	}
+
+	p := clean(filename)
+	res, err := fs.do(http.MethodGet, p, true, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+	links, err := parseIndex(res.Body, res.Request.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []os.FileInfo
+	for _, l := range links {
+		if l.dir {
+			entries = append(entries, &fileInfo{name: l.name, dir: true})
+			continue
+		}
+
+		fi, err := fs.Stat(path.Join(p, l.name))
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, fi)
+	}
+
+	return entries, nil
+}
+
+func (fs *HTTP) Readlink(link string) (string, error) {
+	if _, err := fs.Stat(link); err != nil {
+		return "", err
+	}
+
+	return "", &os.PathError{Op: "readlink", Path: link, Err: errors.New("not a symlink")}
+}
+
+func (fs *HTTP) Rename(from, to string) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *HTTP) Remove(filename string) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *HTTP) MkdirAll(filename string, perm os.FileMode) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *HTTP) Symlink(target, link string) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *HTTP) TempFile(dir, prefix string) (billy.File, error) {
+	return nil, billy.ErrReadOnly
+}
+
+func (fs *HTTP) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+// Capabilities implements the Capable interface.
+func (fs *HTTP) Capabilities() billy.Capability {
+	return billy.ReadCapability | billy.SeekCapability
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.size
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0555
+	}
+
+	return 0444
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.dir
+}
+
+func (fi *fileInfo) Sys() interface{} {
+	return nil
+}
+
+func byteRange(from, to int64) http.Header {
+	r := "bytes=" + strconv.FormatInt(from, 10) + "-"
+	if to >= 0 {
+		r += strconv.FormatInt(to, 10)
+	}
+
+	return http.Header{"Range": []string{r}}
+}
+
+func clean(p string) string {
+	return path.Clean("/" + filepath.ToSlash(p))
+}
@@ -0,0 +1,347 @@
+package httpfs
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&HTTPSuite{})
+
+type HTTPSuite struct {
+	FS     billy.Filesystem
+	server *httptest.Server
+	heads  int32
+	ranges int32
+}
+
+func (s *HTTPSuite) SetUpTest(c *C) {
+	dir := c.MkDir()
+	c.Assert(os.MkdirAll(filepath.Join(dir, "files", "qux", "baz"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "files", "foo"), []byte("hello world"), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "files", "qux", "bar"), []byte("bar"), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "files", "with space"), []byte("space"), 0644), IsNil)
+
+	atomic.StoreInt32(&s.heads, 0)
+	atomic.StoreInt32(&s.ranges, 0)
+
+	files := http.FileServer(http.Dir(dir))
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			atomic.AddInt32(&s.heads, 1)
+		}
+
+		if r.Header.Get("Range") != "" {
+			atomic.AddInt32(&s.ranges, 1)
+		}
+
+		if r.URL.Query().Get("norange") != "" {
+			r.Header.Del("Range")
+		}
+
+		files.ServeHTTP(w, r)
+	}))
+
+	var err error
+	s.FS, err = New(s.server.URL+"/files", Options{ParseIndex: true})
+	c.Assert(err, IsNil)
+}
+
+func (s *HTTPSuite) TearDownTest(c *C) {
+	s.server.Close()
+}
+
+func (s *HTTPSuite) TestNew(c *C) {
+	_, err := New("ftp://example.com", Options{})
+	c.Assert(err, NotNil)
+}
+
+func (s *HTTPSuite) TestOpen(c *C) {
+	f, err := s.FS.Open("foo")
+	c.Assert(err, IsNil)
+	c.Assert(f.Name(), Equals, "foo")
+
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "hello world")
+	c.Assert(f.Close(), IsNil)
+
+	c.Assert(test.ReadFile(c, s.FS, "with space"), Equals, "space")
+}
+
+func (s *HTTPSuite) TestOpenNotExists(c *C) {
+	_, err := s.FS.Open("nope")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *HTTPSuite) TestOpenDir(c *C) {
+	_, err := s.FS.Open("qux")
+	c.Assert(err, NotNil)
+}
+
+func (s *HTTPSuite) TestSeekAndReadAt(c *C) {
+	f, err := s.FS.Open("foo")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	buf := make([]byte, 5)
+	n, err := f.ReadAt(buf, 6)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf[:n]), Equals, "world")
+
+	pos, err := f.Seek(-5, io.SeekEnd)
+	c.Assert(err, IsNil)
+	c.Assert(pos, Equals, int64(6))
+
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "world")
+
+	n, err = f.ReadAt(buf, 8)
+	c.Assert(err, Equals, io.EOF)
+	c.Assert(string(buf[:n]), Equals, "rld")
+
+	c.Assert(atomic.LoadInt32(&s.ranges), Equals, int32(3))
+}
+
+func (s *HTTPSuite) TestNoRangeSupport(c *C) {
+	fs, err := New(s.server.URL+"/files?norange=1", Options{})
+	c.Assert(err, IsNil)
+
+	f, err := fs.Open("foo")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	buf := make([]byte, 5)
+	n, err := f.ReadAt(buf, 6)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf[:n]), Equals, "world")
+
+	_, err = f.Seek(6, io.SeekStart)
+	c.Assert(err, IsNil)
+
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "world")
+}
+
+func (s *HTTPSuite) TestStat(c *C) {
+	fi, err := s.FS.Stat("qux/bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Name(), Equals, "bar")
+	c.Assert(fi.Size(), Equals, int64(3))
+	c.Assert(fi.IsDir(), Equals, false)
+	c.Assert(fi.ModTime().IsZero(), Equals, false)
+
+	fi, err = s.FS.Stat("qux")
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+
+	fi, err = s.FS.Stat("/")
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+}
+
+func (s *HTTPSuite) TestStatCache(c *C) {
+	for i := 0; i < 3; i++ {
+		_, err := s.FS.Stat("foo")
+		c.Assert(err, IsNil)
+
+		_, err = s.FS.Stat("nope")
+		c.Assert(os.IsNotExist(err), Equals, true)
+	}
+
+	c.Assert(atomic.LoadInt32(&s.heads), Equals, int32(2))
+
+	fs, err := New(s.server.URL+"/files", Options{CacheTTL: -1})
+	c.Assert(err, IsNil)
+
+	for i := 0; i < 3; i++ {
+		_, err := fs.Stat("foo")
+		c.Assert(err, IsNil)
+	}
+
+	c.Assert(atomic.LoadInt32(&s.heads), Equals, int32(5))
+}
+
+func (s *HTTPSuite) TestReadDir(c *C) {
+	entries, err := s.FS.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 3)
+	c.Assert(entries[0].Name(), Equals, "foo")
+	c.Assert(entries[0].Size(), Equals, int64(11))
+	c.Assert(entries[1].Name(), Equals, "qux")
+	c.Assert(entries[1].IsDir(), Equals, true)
+	c.Assert(entries[2].Name(), Equals, "with space")
+
+	entries, err = s.FS.ReadDir("qux")
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 2)
+	c.Assert(entries[0].Name(), Equals, "bar")
+	c.Assert(entries[1].Name(), Equals, "baz")
+	c.Assert(entries[1].IsDir(), Equals, true)
+
+	_, err = s.FS.ReadDir("nope")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *HTTPSuite) TestReadDirDisabled(c *C) {
+	fs, err := New(s.server.URL, Options{})
+	c.Assert(err, IsNil)
+
+	_, err = fs.ReadDir("/")
+	c.Assert(err, Equals, ErrIndexDisabled)
+}
+
+func (s *HTTPSuite) TestParseIndex(c *C) {
+	dir, err := http.NewRequest("GET", "http://example.com/pub/", nil)
+	c.Assert(err, IsNil)
+
+	links, err := parseIndex(strings.NewReader(`
+		<a href="?C=N;O=D">Name</a>
+		<a href="../">Parent Directory</a>
+		<A HREF='foo'>foo</A>
+		<a class="dir" href="bar/">bar/</a>
+		<a href=/pub/baz>baz</a>
+		<a href="/other/qux">qux</a>
+		<a href="http://other.com/pub/qux">qux</a>
+		<a href="a%20b&amp;c">a b&amp;c</a>
+		<a href="foo">foo again</a>
+	`), dir.URL)
+	c.Assert(err, IsNil)
+	c.Assert(links, DeepEquals, []link{
+		{name: "foo"},
+		{name: "bar", dir: true},
+		{name: "baz"},
+		{name: "a b&c"},
+	})
+}
+
+func (s *HTTPSuite) TestReadOnly(c *C) {
+	_, err := s.FS.Create("new")
+	c.Assert(err, Equals, billy.ErrReadOnly)
+
+	_, err = s.FS.OpenFile("foo", os.O_RDWR, 0)
+	c.Assert(err, Equals, billy.ErrReadOnly)
+
+	c.Assert(s.FS.Remove("foo"), Equals, billy.ErrReadOnly)
+	c.Assert(s.FS.Rename("foo", "bar"), Equals, billy.ErrReadOnly)
+	c.Assert(s.FS.MkdirAll("dir", 0755), Equals, billy.ErrReadOnly)
+	c.Assert(s.FS.Symlink("foo", "bar"), Equals, billy.ErrReadOnly)
+
+	f, err := s.FS.Open("foo")
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("foo"))
+	c.Assert(err, Equals, billy.ErrReadOnly)
+}
+
+func (s *HTTPSuite) TestCapabilities(c *C) {
+	c.Assert(billy.CapabilityCheck(s.FS, billy.WriteCapability), Equals, false)
+	c.Assert(billy.CapabilityCheck(s.FS, billy.SeekCapability), Equals, true)
+}
+
+var _ = Suite(&DirSuite{})
+
+type DirSuite struct {
+	FS     billy.Filesystem
+	server *httptest.Server
+}
+
+func (s *DirSuite) SetUpTest(c *C) {
+	s.FS = memfs.New()
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("hello world"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "qux/bar", []byte("bar"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "qux/baz", []byte("baz"), 0644), IsNil)
+
+	s.server = httptest.NewServer(http.FileServer(Dir(s.FS)))
+}
+
+func (s *DirSuite) TearDownTest(c *C) {
+	s.server.Close()
+}
+
+func (s *DirSuite) TestServe(c *C) {
+	fs, err := New(s.server.URL, Options{ParseIndex: true})
+	c.Assert(err, IsNil)
+
+	c.Assert(test.ReadFile(c, fs, "foo"), Equals, "hello world")
+	c.Assert(test.ReadFile(c, fs, "qux/bar"), Equals, "bar")
+
+	f, err := fs.Open("foo")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	buf := make([]byte, 5)
+	_, err = f.ReadAt(buf, 6)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf), Equals, "world")
+
+	infos, err := fs.ReadDir("qux")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 2)
+	c.Assert(infos[0].Name(), Equals, "bar")
+	c.Assert(infos[1].Name(), Equals, "baz")
+
+	_, err = fs.Stat("missing")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *DirSuite) TestReaddir(c *C) {
+	f, err := Dir(s.FS).Open("/qux")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	fi, err := f.Stat()
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+
+	infos, err := f.Readdir(1)
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 1)
+	c.Assert(infos[0].Name(), Equals, "bar")
+
+	infos, err = f.Readdir(5)
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 1)
+	c.Assert(infos[0].Name(), Equals, "baz")
+
+	_, err = f.Readdir(1)
+	c.Assert(err, Equals, io.EOF)
+
+	_, err = f.Seek(0, io.SeekStart)
+	c.Assert(err, IsNil)
+	infos, err = f.Readdir(0)
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 2)
+}
+
+func (s *DirSuite) TestOpenFile(c *C) {
+	f, err := Dir(s.FS).Open("foo")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	fi, err := f.Stat()
+	c.Assert(err, IsNil)
+	c.Assert(fi.Size(), Equals, int64(11))
+
+	_, err = f.Readdir(0)
+	c.Assert(err, NotNil)
+
+	_, err = Dir(s.FS).Open("missing")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
@@ -0,0 +1,67 @@
+package httpfs
+
+import (
+	"html"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// maxIndexSize is the maximum size of an index page that is parsed.
+const maxIndexSize = 16 << 20
+
+var hrefRegexp = regexp.MustCompile(`(?i)<a\s[^>]*?href\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+
+type link struct {
+	name string
+	dir  bool
+}
+
+// parseIndex returns the children of the directory at the given URL, linked
+// from the HTML index page read from r. Links to other directories, hosts,
+// sorting queries and similar are ignored.
+func parseIndex(r io.Reader, dir *url.URL) ([]link, error) {
+	content, err := ioutil.ReadAll(io.LimitReader(r, maxIndexSize))
+	if err != nil {
+		return nil, err
+	}
+
+	base := *dir
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+
+	var links []link
+	seen := make(map[string]bool)
+	for _, m := range hrefRegexp.FindAllStringSubmatch(string(content), -1) {
+		href := html.UnescapeString(m[1] + m[2] + m[3])
+		u, err := url.Parse(href)
+		if err != nil || u.Path == "" {
+			continue
+		}
+
+		target := base.ResolveReference(u)
+		if target.Scheme != base.Scheme || target.Host != base.Host {
+			continue
+		}
+
+		dir := strings.HasSuffix(target.Path, "/")
+		p := strings.TrimSuffix(target.Path, "/")
+		if path.Dir(p) != path.Clean(base.Path) {
+			continue
+		}
+
+		name := path.Base(p)
+		if seen[name] {
+			continue
+		}
+
+		seen[name] = true
+		links = append(links, link{name: name, dir: dir})
+	}
+
+	return links, nil
+}
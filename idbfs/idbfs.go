@@ -0,0 +1,546 @@
+// Package idbfs provides a billy filesystem stored in IndexedDB, for the
+// applications compiled to WebAssembly, to keep their files in the browser
+// across sessions.
+//
+// The database has two object stores, one with the metadata of every file,
+// directory and symlink, keyed by its clean path and indexed by the path of
+// its directory, and other with the content of the files and the targets of
+// the symlinks. Every mutation is done in a single transaction, and the
+// files are written back when closed, so closing the page never leaves a
+// file partially written or a directory partially renamed.
+//
+// New is only available with GOOS=js and GOARCH=wasm. The requests to
+// IndexedDB are asynchronous, the methods of the filesystem block until
+// they complete, so they must not be called from the goroutine of a
+// function called by JavaScript, as done by js.FuncOf, since it blocks the
+// event loop. Call them from another goroutine instead.
+package idbfs // import "gopkg.in/src-d/go-billy.v4/idbfs"
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/internal/linkutil"
+	"gopkg.in/src-d/go-billy.v4/internal/memfile"
+	"gopkg.in/src-d/go-billy.v4/internal/pathutil"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+var (
+	// ErrNotEmpty is returned when removing a directory with files.
+	ErrNotEmpty = billy.ErrNotEmpty
+
+	errNotDir = errors.New("not a directory")
+)
+
+// store runs the transactions of a filesystem.
+type store interface {
+	// view runs fn in a read-only transaction.
+	view(fn func(tx txn) error) error
+	// update runs fn in a read-write transaction, aborted if fn fails.
+	update(fn func(tx txn) error) error
+}
+
+// txn is a transaction over the object stores of a filesystem. The keys
+// are the clean paths without the leading separator, being the root the
+// empty key, which is never stored.
+type txn interface {
+	// get returns the node with the given key, or nil if it doesn't exist.
+	get(key string) (*node, error)
+	// data returns the content of the given file, or target of the given
+	// symlink.
+	data(key string) ([]byte, error)
+	// put stores the given node, and its data if it isn't a directory.
+	put(key string, n *node, data []byte) error
+	// delete deletes the given node and its data.
+	delete(key string) error
+	// children returns the names and nodes of the children of the given
+	// directory, sorted by name.
+	children(key string) ([]string, []*node, error)
+	// descendants returns the keys of every node under the given
+	// directory.
+	descendants(key string) ([]string, error)
+}
+
+// IndexedDB is a filesystem stored in an IndexedDB database.
+type IndexedDB struct {
+	s store
+}
+
+func (fs *IndexedDB) view(fn func(t *tree) error) error {
+	return fs.s.view(func(tx txn) error {
+		return fn(&tree{tx})
+	})
+}
+
+func (fs *IndexedDB) update(fn func(t *tree) error) error {
+	return fs.s.update(func(tx txn) error {
+		return fn(&tree{tx})
+	})
+}
+
+func (fs *IndexedDB) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *IndexedDB) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (fs *IndexedDB) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	tx := fs.view
+	if flag&(os.O_CREATE|os.O_TRUNC) != 0 {
+		tx = fs.update
+	}
+
+	f := &file{File: memfile.New(pathutil.Relative(filename), flag, nil), fs: fs}
+	err := tx(func(t *tree) error {
+		key, n, err := t.follow(toKey(filename))
+		if os.IsNotExist(err) && flag&os.O_CREATE != 0 {
+			// the key is the target of the last link, even when it's
+			// dangling, so the target is created.
+			return f.create(t, key, perm)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+			return os.ErrExist
+		}
+
+		if n.mode.IsDir() {
+			return fmt.Errorf("cannot open directory: %s", filename)
+		}
+
+		f.key, f.mode = key, n.mode
+		if isWrite(flag) && flag&os.O_TRUNC != 0 {
+			n.size, n.modTime = 0, time.Now()
+			return t.put(key, n, nil)
+		}
+
+		f.Content, err = t.data(key)
+		return err
+	})
+
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+	}
+
+	if flag&os.O_APPEND != 0 {
+		f.Position = int64(len(f.Content))
+	}
+
+	return f, nil
+}
+
+// store writes the content of a file closed after modified. If the file was
+// removed or replaced while open, the content is discarded.
+func (fs *IndexedDB) store(key string, content []byte) error {
+	return fs.update(func(t *tree) error {
+		n, err := t.get(key)
+		if err != nil || n == nil || !n.mode.IsRegular() {
+			return err
+		}
+
+		n.size, n.modTime = int64(len(content)), time.Now()
+		return t.put(key, n, content)
+	})
+}
+
+func (fs *IndexedDB) Stat(filename string) (os.FileInfo, error) {
+	return fs.stat("stat", filename, true)
+}
+
+func (fs *IndexedDB) Lstat(filename string) (os.FileInfo, error) {
+	return fs.stat("lstat", filename, false)
+}
+
+func (fs *IndexedDB) stat(op, filename string, follow bool) (os.FileInfo, error) {
+	var fi *fileInfo
+	err := fs.view(func(t *tree) error {
+		var n *node
+		var err error
+		if follow {
+			_, n, err = t.follow(toKey(filename))
+		} else {
+			n, err = t.lookup(toKey(filename))
+		}
+
+		if err != nil {
+			return err
+		}
+
+		// the name is the one of the stated file, even if it's a link.
+		fi = newFileInfo(filepath.Base(filename), n)
+		return nil
+	})
+
+	if err != nil {
+		return nil, &os.PathError{Op: op, Path: filename, Err: err}
+	}
+
+	return fi, nil
+}
+
+func (fs *IndexedDB) ReadDir(filename string) ([]os.FileInfo, error) {
+	var infos []os.FileInfo
+	err := fs.view(func(t *tree) error {
+		key, n, err := t.follow(toKey(filename))
+		if err != nil {
+			return err
+		}
+
+		if !n.mode.IsDir() {
+			return errNotDir
+		}
+
+		names, nodes, err := t.children(key)
+		if err != nil {
+			return err
+		}
+
+		for i, name := range names {
+			infos = append(infos, newFileInfo(name, nodes[i]))
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: filename, Err: err}
+	}
+
+	return infos, nil
+}
+
+func (fs *IndexedDB) MkdirAll(filename string, perm os.FileMode) error {
+	err := fs.update(func(t *tree) error {
+		return t.mkdirAll(toKey(filename), perm)
+	})
+
+	if err != nil {
+		return &os.PathError{Op: "mkdir", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+// Rename moves the given file, or directory with all its content, in a
+// single transaction.
+func (fs *IndexedDB) Rename(from, to string) error {
+	err := fs.update(func(t *tree) error {
+		return t.rename(toKey(from), toKey(to))
+	})
+
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *IndexedDB) Remove(filename string) error {
+	err := fs.update(func(t *tree) error {
+		return t.remove(toKey(filename))
+	})
+
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *IndexedDB) Symlink(target, link string) error {
+	err := fs.update(func(t *tree) error {
+		key := toKey(link)
+		n, err := t.get(key)
+		if err != nil {
+			return err
+		}
+
+		if n != nil {
+			return os.ErrExist
+		}
+
+		if err := t.mkdirAll(parent(key), 0755); err != nil {
+			return err
+		}
+
+		return t.put(key, &node{
+			mode:    os.ModeSymlink | 0777,
+			modTime: time.Now(),
+			size:    int64(len(target)),
+		}, []byte(target))
+	})
+
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *IndexedDB) Readlink(link string) (string, error) {
+	var target []byte
+	err := fs.view(func(t *tree) error {
+		key := toKey(link)
+		n, err := t.lookup(key)
+		if err != nil {
+			return err
+		}
+
+		if !isSymlink(n.mode) {
+			return errors.New("not a symlink")
+		}
+
+		target, err = t.data(key)
+		return err
+	})
+
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: link, Err: err}
+	}
+
+	return string(target), nil
+}
+
+func (fs *IndexedDB) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(fs, dir, prefix)
+}
+
+func (fs *IndexedDB) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (fs *IndexedDB) Chroot(p string) (billy.Filesystem, error) {
+	return chroot.New(fs, fs.Join(fs.Root(), p)), nil
+}
+
+func (fs *IndexedDB) Root() string {
+	return string(filepath.Separator)
+}
+
+// Capabilities implements the Capable interface.
+func (fs *IndexedDB) Capabilities() billy.Capability {
+	return billy.WriteCapability | billy.ReadCapability |
+		billy.ReadAndWriteCapability | billy.SeekCapability |
+		billy.TruncateCapability
+}
+
+// node is the metadata of a file, directory or symlink, as stored in the
+// metadata object store.
+type node struct {
+	mode    os.FileMode
+	modTime time.Time
+	size    int64
+}
+
+// tree implements the operations of the filesystem within a transaction.
+type tree struct {
+	txn
+}
+
+// get is like the one of txn, returning the node of the root.
+func (t *tree) get(key string) (*node, error) {
+	if key == "" {
+		return &node{mode: os.ModeDir | 0755}, nil
+	}
+
+	return t.txn.get(key)
+}
+
+// lookup returns the node with the given key from the metadata of the
+// transaction, failing with os.ErrNotExist if there is none.
+func (t *tree) lookup(key string) (*node, error) {
+	n, err := t.get(key)
+	if err == nil && n == nil {
+		err = os.ErrNotExist
+	}
+
+	return n, err
+}
+
+// follow reads the nodes of the links starting at key within the transaction
+// of the tree, with the targets taken from their data, until a node which
+// isn't a link. The key of the last node is returned even when it's missing.
+func (t *tree) follow(key string) (string, *node, error) {
+	var n *node
+	isLink := func(p string) (link bool, err error) {
+		n, err = t.lookup(p)
+		return err == nil && isSymlink(n.mode), err
+	}
+
+	key, err := linkutil.Follow(key, toKey, isLink, func(p string) (string, error) {
+		data, err := t.data(p)
+		return string(data), err
+	})
+	if err != nil {
+		return key, nil, err
+	}
+
+	return key, n, nil
+}
+
+func (t *tree) mkdirAll(key string, perm os.FileMode) error {
+	if key == "" {
+		return nil
+	}
+
+	var dir string
+	for _, elem := range strings.Split(key, "/") {
+		dir = path.Join(dir, elem)
+		_, n, err := t.follow(dir)
+		if err == nil {
+			if !n.mode.IsDir() {
+				return errNotDir
+			}
+
+			continue
+		}
+
+		if !os.IsNotExist(err) {
+			return err
+		}
+
+		n = &node{mode: os.ModeDir | perm.Perm(), modTime: time.Now()}
+		if err := t.put(dir, n, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *tree) isEmpty(key string) (bool, error) {
+	names, _, err := t.children(key)
+	return len(names) == 0, err
+}
+
+func (t *tree) remove(key string) error {
+	if key == "" {
+		return errors.New("cannot remove the root")
+	}
+
+	n, err := t.lookup(key)
+	if err != nil {
+		return err
+	}
+
+	if n.mode.IsDir() {
+		empty, err := t.isEmpty(key)
+		if err != nil {
+			return err
+		}
+
+		if !empty {
+			return ErrNotEmpty
+		}
+	}
+
+	return t.delete(key)
+}
+
+func (t *tree) rename(from, to string) error {
+	if from == "" || to == "" {
+		return errors.New("cannot rename the root")
+	}
+
+	src, err := t.lookup(from)
+	if err != nil {
+		return err
+	}
+
+	if from == to {
+		return nil
+	}
+
+	if strings.HasPrefix(to, from+"/") {
+		return errors.New("cannot move a directory into itself")
+	}
+
+	dst, err := t.get(to)
+	if err != nil {
+		return err
+	}
+
+	if dst != nil {
+		if dst.mode.IsDir() != src.mode.IsDir() {
+			return os.ErrExist
+		}
+
+		if dst.mode.IsDir() {
+			empty, err := t.isEmpty(to)
+			if err != nil {
+				return err
+			}
+
+			if !empty {
+				return ErrNotEmpty
+			}
+		}
+	}
+
+	if err := t.mkdirAll(parent(to), 0755); err != nil {
+		return err
+	}
+
+	keys, err := t.descendants(from)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range append([]string{from}, keys...) {
+		n, err := t.lookup(key)
+		if err != nil {
+			return err
+		}
+
+		var data []byte
+		if !n.mode.IsDir() {
+			if data, err = t.data(key); err != nil {
+				return err
+			}
+		}
+
+		if err := t.delete(key); err != nil {
+			return err
+		}
+
+		if err := t.put(to+key[len(from):], n, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// toKey returns the key of the given path.
+func toKey(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(p)), "/")
+}
+
+func parent(key string) string {
+	if i := strings.LastIndexByte(key, '/'); i != -1 {
+		return key[:i]
+	}
+
+	return ""
+}
+
+func isSymlink(m os.FileMode) bool {
+	return m&os.ModeSymlink != 0
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR) != 0
+}
@@ -0,0 +1,115 @@
+package idbfs
+
+import (
+	"os"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&IndexedDBSuite{})
+
+// IndexedDBSuite runs the generic suites over a store kept in memory, since
+// IndexedDB is only available in the browser.
+type IndexedDBSuite struct {
+	test.FilesystemSuite
+	store *memStore
+}
+
+func (s *IndexedDBSuite) SetUpTest(c *C) {
+	s.store = newMemStore()
+	s.FilesystemSuite = test.NewFilesystemSuite(&IndexedDB{s: s.store})
+}
+
+func (s *IndexedDBSuite) TestParents(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar/baz", []byte("foo"), 0644), IsNil)
+	c.Assert(s.FS.Symlink("bar/baz", "foo/qux"), IsNil)
+
+	c.Assert(s.store.meta["foo"].parent, Equals, "")
+	c.Assert(s.store.meta["foo/bar"].parent, Equals, "foo")
+	c.Assert(s.store.meta["foo/bar/baz"].parent, Equals, "foo/bar")
+	c.Assert(string(s.store.data["foo/bar/baz"]), Equals, "foo")
+	c.Assert(string(s.store.data["foo/qux"]), Equals, "bar/baz")
+
+	_, ok := s.store.data["foo/bar"]
+	c.Assert(ok, Equals, false)
+}
+
+func (s *IndexedDBSuite) TestWriteOnClose(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+
+	f, err := s.FS.OpenFile("foo", os.O_WRONLY|os.O_APPEND, 0)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("bar"))
+	c.Assert(err, IsNil)
+
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "foo")
+	c.Assert(f.Close(), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "foobar")
+}
+
+func (s *IndexedDBSuite) TestWriteAfterRemove(c *C) {
+	f, err := s.FS.Create("foo")
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("foo"))
+	c.Assert(err, IsNil)
+
+	c.Assert(s.FS.Remove("foo"), IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	_, err = s.FS.Stat("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *IndexedDBSuite) TestRenameDir(c *C) {
+	for _, name := range []string{"foo/bar", "foo/bar/baz", "foo.txt", "foo0"} {
+		c.Assert(util.WriteFile(s.FS, name+"/qux", []byte(name), 0644), IsNil)
+	}
+
+	c.Assert(s.FS.Rename("foo", "new/foo"), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "new/foo/bar/baz/qux"), Equals, "foo/bar/baz")
+	c.Assert(s.store.meta["new/foo/bar"].parent, Equals, "new/foo")
+
+	infos, err := s.FS.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 3)
+	c.Assert(infos[0].Name(), Equals, "foo.txt")
+	c.Assert(infos[1].Name(), Equals, "foo0")
+	c.Assert(infos[2].Name(), Equals, "new")
+
+	err = s.FS.Rename("new", "new/foo/new")
+	c.Assert(err, NotNil)
+}
+
+func (s *IndexedDBSuite) TestRenameAborted(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "qux", []byte("qux"), 0644), IsNil)
+
+	// the parent of the destination isn't a directory
+	err := s.FS.Rename("foo", "qux/foo")
+	c.Assert(err, NotNil)
+	c.Assert(s.store.aborted, Equals, 1)
+
+	c.Assert(test.ReadFile(c, s.FS, "foo/bar"), Equals, "foo")
+	c.Assert(test.ReadFile(c, s.FS, "qux"), Equals, "qux")
+}
+
+func (s *IndexedDBSuite) TestRemoveNotEmpty(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar", []byte("foo"), 0644), IsNil)
+
+	err := s.FS.Remove("foo")
+	c.Assert(err.(*os.PathError).Err, Equals, ErrNotEmpty)
+}
+
+func (s *IndexedDBSuite) TestSymlinkLoop(c *C) {
+	c.Assert(s.FS.Symlink("bar", "foo"), IsNil)
+	c.Assert(s.FS.Symlink("foo", "bar"), IsNil)
+
+	_, err := s.FS.Stat("foo")
+	c.Assert(err, ErrorMatches, ".*too many levels of symbolic links")
+}
@@ -0,0 +1,269 @@
+//go:build js && wasm
+// +build js,wasm
+
+package idbfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"syscall/js"
+	"time"
+)
+
+const (
+	version     = 1
+	metaStore   = "meta"
+	dataStore   = "data"
+	parentIndex = "parent"
+)
+
+// New returns a new filesystem stored in the IndexedDB database with the
+// given name, creating it if it doesn't exist.
+func New(name string) (*IndexedDB, error) {
+	factory := js.Global().Get("indexedDB")
+	if factory.IsUndefined() {
+		return nil, errors.New("indexeddb: not available")
+	}
+
+	req := factory.Call("open", name, version)
+	upgrade := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		db := req.Get("result")
+		meta := db.Call("createObjectStore", metaStore)
+		meta.Call("createIndex", parentIndex, "parent")
+		db.Call("createObjectStore", dataStore)
+		return nil
+	})
+
+	defer upgrade.Release()
+	req.Set("onupgradeneeded", upgrade)
+
+	db, err := await(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &IndexedDB{s: &database{db: db}}, nil
+}
+
+// await waits for the given request, returning its result.
+func await(req js.Value) (js.Value, error) {
+	done := make(chan error, 1)
+	success := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		done <- nil
+		return nil
+	})
+
+	failure := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		// the transaction isn't aborted by the error, but when fn fails
+		args[0].Call("preventDefault")
+		done <- domError(req.Get("error"))
+		return nil
+	})
+
+	defer success.Release()
+	defer failure.Release()
+	req.Set("onsuccess", success)
+	req.Set("onerror", failure)
+
+	if err := <-done; err != nil {
+		return js.Undefined(), err
+	}
+
+	return req.Get("result"), nil
+}
+
+func domError(v js.Value) error {
+	if v.IsNull() || v.IsUndefined() {
+		return errors.New("indexeddb: aborted")
+	}
+
+	if v.Get("name").String() == "QuotaExceededError" {
+		return fmt.Errorf("indexeddb: quota exceeded: %s", v.Get("message").String())
+	}
+
+	return fmt.Errorf("indexeddb: %s", v.Get("message").String())
+}
+
+// database is the store of an IndexedDB database.
+type database struct {
+	db js.Value
+}
+
+func (d *database) view(fn func(tx txn) error) error {
+	return d.transaction("readonly", fn)
+}
+
+func (d *database) update(fn func(tx txn) error) error {
+	return d.transaction("readwrite", fn)
+}
+
+// transaction runs fn in a transaction, waiting for it to complete. The
+// requests are made as soon as the previous one succeeds, before returning
+// to the event loop, so the transaction is kept active.
+func (d *database) transaction(mode string, fn func(tx txn) error) error {
+	tx := d.db.Call("transaction", []interface{}{metaStore, dataStore}, mode)
+
+	done := make(chan error, 1)
+	complete := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		done <- nil
+		return nil
+	})
+
+	abort := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		done <- domError(tx.Get("error"))
+		return nil
+	})
+
+	defer complete.Release()
+	defer abort.Release()
+	tx.Set("oncomplete", complete)
+	tx.Set("onabort", abort)
+
+	if err := fn(&transaction{
+		metaStore: tx.Call("objectStore", metaStore),
+		dataStore: tx.Call("objectStore", dataStore),
+	}); err != nil {
+		tx.Call("abort")
+		<-done
+		return err
+	}
+
+	if tx.Get("commit").Truthy() {
+		tx.Call("commit")
+	}
+
+	return <-done
+}
+
+// transaction is a txn of IndexedDB. The metadata of the nodes is stored as
+// objects, with the key of its directory as parent, the mode, the
+// modification time in milliseconds, and the size. The content is stored as
+// an Uint8Array.
+type transaction struct {
+	metaStore, dataStore js.Value
+}
+
+func (t *transaction) get(key string) (*node, error) {
+	v, err := await(t.metaStore.Call("get", key))
+	if err != nil || v.IsUndefined() {
+		return nil, err
+	}
+
+	return decodeNode(v), nil
+}
+
+func (t *transaction) data(key string) ([]byte, error) {
+	v, err := await(t.dataStore.Call("get", key))
+	if err != nil || v.IsUndefined() {
+		return nil, err
+	}
+
+	b := make([]byte, v.Get("length").Int())
+	js.CopyBytesToGo(b, v)
+	return b, nil
+}
+
+func (t *transaction) put(key string, n *node, data []byte) error {
+	v := js.Global().Get("Object").New()
+	v.Set("parent", parent(key))
+	v.Set("mode", uint32(n.mode))
+	v.Set("modTime", float64(n.modTime.UnixNano())/1e6)
+	v.Set("size", n.size)
+	if _, err := await(t.metaStore.Call("put", v, key)); err != nil {
+		return err
+	}
+
+	if n.mode.IsDir() {
+		return nil
+	}
+
+	b := js.Global().Get("Uint8Array").New(len(data))
+	js.CopyBytesToJS(b, data)
+	_, err := await(t.dataStore.Call("put", b, key))
+	return err
+}
+
+func (t *transaction) delete(key string) error {
+	if _, err := await(t.metaStore.Call("delete", key)); err != nil {
+		return err
+	}
+
+	_, err := await(t.dataStore.Call("delete", key))
+	return err
+}
+
+// children reads the keys and values of the parent index, both sorted by
+// key.
+func (t *transaction) children(key string) ([]string, []*node, error) {
+	index := t.metaStore.Call("index", parentIndex)
+	only := js.Global().Get("IDBKeyRange").Call("only", key)
+
+	keys, err := await(index.Call("getAllKeys", only))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	values, err := await(index.Call("getAll", only))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	prefix := key + "/"
+	if key == "" {
+		prefix = ""
+	}
+
+	c := &children{
+		names: make([]string, keys.Length()),
+		nodes: make([]*node, keys.Length()),
+	}
+
+	for i := range c.names {
+		c.names[i] = keys.Index(i).String()[len(prefix):]
+		c.nodes[i] = decodeNode(values.Index(i))
+	}
+
+	// the keys are sorted by UTF-16 code units
+	sort.Sort(c)
+	return c.names, c.nodes, nil
+}
+
+// descendants reads the keys between "key/" and "key0", since '0' is the
+// character following '/'.
+func (t *transaction) descendants(key string) ([]string, error) {
+	bound := js.Global().Get("IDBKeyRange").Call("bound", key+"/", key+"0", false, true)
+	keys, err := await(t.metaStore.Call("getAllKeys", bound))
+	if err != nil {
+		return nil, err
+	}
+
+	descendants := make([]string, keys.Length())
+	for i := range descendants {
+		descendants[i] = keys.Index(i).String()
+	}
+
+	return descendants, nil
+}
+
+func decodeNode(v js.Value) *node {
+	return &node{
+		mode:    os.FileMode(v.Get("mode").Int()),
+		modTime: time.Unix(0, int64(v.Get("modTime").Float()*1e6)),
+		size:    int64(v.Get("size").Float()),
+	}
+}
+
+type children struct {
+	names []string
+	nodes []*node
+}
+
+func (c *children) Len() int           { return len(c.names) }
+func (c *children) Less(i, j int) bool { return c.names[i] < c.names[j] }
+
+func (c *children) Swap(i, j int) {
+	c.names[i], c.names[j] = c.names[j], c.names[i]
+	c.nodes[i], c.nodes[j] = c.nodes[j], c.nodes[i]
+}
@@ -0,0 +1,130 @@
+package idbfs
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// memStore is a store kept in memory, as the object stores of IndexedDB:
+// the metadata of the nodes with the key of their parent, and the data.
+// The updates work on a copy, replacing the state if they succeed.
+type memStore struct {
+	m    sync.Mutex
+	meta map[string]*memNode
+	data map[string][]byte
+
+	// aborted is the number of updates aborted.
+	aborted int
+}
+
+type memNode struct {
+	parent string
+	node   node
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		meta: make(map[string]*memNode),
+		data: make(map[string][]byte),
+	}
+}
+
+func (s *memStore) view(fn func(tx txn) error) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return fn(&memTxn{meta: s.meta, content: s.data})
+}
+
+func (s *memStore) update(fn func(tx txn) error) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	tx := &memTxn{
+		meta:    make(map[string]*memNode, len(s.meta)),
+		content: make(map[string][]byte, len(s.data)),
+	}
+
+	for k, v := range s.meta {
+		tx.meta[k] = v
+	}
+
+	for k, v := range s.data {
+		tx.content[k] = v
+	}
+
+	if err := fn(tx); err != nil {
+		s.aborted++
+		return err
+	}
+
+	s.meta, s.data = tx.meta, tx.content
+	return nil
+}
+
+type memTxn struct {
+	meta    map[string]*memNode
+	content map[string][]byte
+}
+
+func (t *memTxn) get(key string) (*node, error) {
+	v, ok := t.meta[key]
+	if !ok {
+		return nil, nil
+	}
+
+	n := v.node
+	return &n, nil
+}
+
+func (t *memTxn) data(key string) ([]byte, error) {
+	return append([]byte(nil), t.content[key]...), nil
+}
+
+func (t *memTxn) put(key string, n *node, data []byte) error {
+	t.meta[key] = &memNode{parent: parent(key), node: *n}
+	if !n.mode.IsDir() {
+		t.content[key] = append([]byte(nil), data...)
+	}
+
+	return nil
+}
+
+func (t *memTxn) delete(key string) error {
+	delete(t.meta, key)
+	delete(t.content, key)
+	return nil
+}
+
+func (t *memTxn) children(key string) ([]string, []*node, error) {
+	var keys []string
+	for k, v := range t.meta {
+		if v.parent == key {
+			keys = append(keys, k)
+		}
+	}
+
+	sort.Strings(keys)
+	names := make([]string, len(keys))
+	nodes := make([]*node, len(keys))
+	for i, k := range keys {
+		names[i] = k[strings.LastIndexByte(k, '/')+1:]
+		n := t.meta[k].node
+		nodes[i] = &n
+	}
+
+	return names, nodes, nil
+}
+
+func (t *memTxn) descendants(key string) ([]string, error) {
+	var keys []string
+	for k := range t.meta {
+		if strings.HasPrefix(k, key+"/") {
+			keys = append(keys, k)
+		}
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
@@ -0,0 +1,18 @@
+// Package errutil provides the helpers on the errors shared by the
+// filesystems wrapping other filesystems.
+package errutil // import "gopkg.in/src-d/go-billy.v4/internal/errutil"
+
+import "os"
+
+// Underlying returns the error wrapped by the errors of the filesystems, so
+// they aren't wrapped twice.
+func Underlying(err error) error {
+	switch err := err.(type) {
+	case *os.PathError:
+		return err.Err
+	case *os.LinkError:
+		return err.Err
+	}
+
+	return err
+}
@@ -0,0 +1,55 @@
+// Package fileutil provides the helpers on the content of the files shared by
+// the filesystems, such as copying up a file before writing it, or hashing
+// it.
+package fileutil // import "gopkg.in/src-d/go-billy.v4/internal/fileutil"
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// Copy copies the content of the file from of src to the file to of dst,
+// creating it with the given permissions, or truncating it if it exists.
+func Copy(src billy.Basic, from string, dst billy.Basic, to string, perm os.FileMode) error {
+	r, err := src.Open(from)
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	w, err := dst.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
+// Hash returns the hex encoded SHA-256 sum of the content of the given file,
+// and its size.
+func Hash(fs billy.Basic, filename string) (string, int64, error) {
+	f, err := fs.Open(filename)
+	if err != nil {
+		return "", 0, err
+	}
+
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
@@ -0,0 +1,124 @@
+// Package linkutil follows the symbolic links of the filesystems storing them
+// on their own, instead of relying on the ones of an underlying filesystem.
+package linkutil // import "gopkg.in/src-d/go-billy.v4/internal/linkutil"
+
+import (
+	"errors"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-billy.v4/internal/pathutil"
+)
+
+// MaxLinks is the maximum number of links followed resolving a path.
+const MaxLinks = 255
+
+// ErrTooManyLinks is returned when resolving a path requires to follow more
+// than MaxLinks links, usually because of a loop.
+var ErrTooManyLinks = errors.New("too many levels of symbolic links")
+
+// Follow follows the links from the file at p, returning the path of the
+// first file not being a link. isLink reports if the file at the given path
+// is a link, and readlink returns its target, joined to the directory of the
+// link if relative, and cleaned with clean. The returned path is the one of
+// the last target reached, also on error, such as when it doesn't exist.
+func Follow(
+	p string, clean func(string) string,
+	isLink func(p string) (bool, error), readlink func(p string) (string, error),
+) (string, error) {
+	for i := 0; ; i++ {
+		link, err := isLink(p)
+		if err != nil || !link {
+			return p, err
+		}
+
+		if i == MaxLinks {
+			return p, ErrTooManyLinks
+		}
+
+		target, err := readlink(p)
+		if err != nil {
+			return p, err
+		}
+
+		p = clean(Join(p, target))
+	}
+}
+
+// Join returns the path of the given target of the link at p, as a slash
+// separated path: the target itself if absolute, or the target relative to
+// the directory of the link.
+func Join(p, target string) string {
+	target = filepath.ToSlash(target)
+	if pathutil.IsAbs(target) {
+		return target
+	}
+
+	return path.Join(path.Dir(filepath.ToSlash(p)), target)
+}
+
+// LookupFunc returns the file with the given name in the directory dir, and
+// true if it's a link.
+type LookupFunc func(dir interface{}, name string) (file interface{}, link bool, err error)
+
+// TargetFunc returns the target of the given link.
+type TargetFunc func(link interface{}) (string, error)
+
+// Resolve returns the file at the given path of a tree walked element by
+// element from root, such as the one of an archive, and the path of the file
+// once followed the links found in any of its parent directories, and in the
+// file itself if follow is true. The paths are clean, slash separated and
+// relative to the root, being the root itself the empty path.
+func Resolve(name string, follow bool, root interface{}, lookup LookupFunc, target TargetFunc) (string, interface{}, error) {
+	return resolve(name, follow, root, lookup, target, 0)
+}
+
+func resolve(name string, follow bool, root interface{}, lookup LookupFunc, target TargetFunc, depth int) (string, interface{}, error) {
+	if depth > MaxLinks {
+		return "", nil, ErrTooManyLinks
+	}
+
+	if name == "" {
+		return name, root, nil
+	}
+
+	dir, d, err := resolve(Parent(name), true, root, lookup, target, depth)
+	if err != nil {
+		return "", nil, err
+	}
+
+	base := path.Base(name)
+	f, link, err := lookup(d, base)
+	if err != nil {
+		return "", nil, err
+	}
+
+	name = path.Join(dir, base)
+	if !follow || !link {
+		return name, f, nil
+	}
+
+	t, err := target(f)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return resolve(clean(Join(name, t)), true, root, lookup, target, depth+1)
+}
+
+// clean returns the given path clean and relative to the root.
+func clean(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// Parent returns the directory of the given name, relative to the root, as
+// the empty name for the files at the root.
+func Parent(name string) string {
+	dir := path.Dir(name)
+	if dir == "." {
+		return ""
+	}
+
+	return dir
+}
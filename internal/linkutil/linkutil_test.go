@@ -0,0 +1,122 @@
+package linkutil
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type LinkutilSuite struct{}
+
+var _ = Suite(&LinkutilSuite{})
+
+// tree is a directory tree: directories are trees, links are strings, and
+// regular files are nil.
+type tree map[string]interface{}
+
+func (t tree) lookup(dir interface{}, name string) (interface{}, bool, error) {
+	d, ok := dir.(tree)
+	if !ok {
+		return nil, false, os.ErrNotExist
+	}
+
+	f, ok := d[name]
+	if !ok {
+		return nil, false, os.ErrNotExist
+	}
+
+	_, link := f.(string)
+	return f, link, nil
+}
+
+func (t tree) target(link interface{}) (string, error) {
+	return link.(string), nil
+}
+
+// links is a flat set of links, by path.
+type links map[string]string
+
+func (l links) isLink(p string) (bool, error) {
+	_, ok := l[p]
+	return ok, nil
+}
+
+func (l links) readlink(p string) (string, error) {
+	return l[p], nil
+}
+
+func (s *LinkutilSuite) TestJoin(c *C) {
+	c.Assert(Join("a/b", "c"), Equals, "a/c")
+	c.Assert(Join("a/b", "../c"), Equals, "c")
+	c.Assert(Join("a/b", "/c"), Equals, "/c")
+	c.Assert(Join("b", "c"), Equals, "c")
+}
+
+func (s *LinkutilSuite) TestParent(c *C) {
+	c.Assert(Parent("a/b/c"), Equals, "a/b")
+	c.Assert(Parent("a"), Equals, "")
+	c.Assert(Parent(""), Equals, "")
+}
+
+func (s *LinkutilSuite) TestFollow(c *C) {
+	l := links{"/a": "b", "/b": "/c/d", "/c/d": "../e"}
+	p, err := Follow("/a", path.Clean, l.isLink, l.readlink)
+	c.Assert(err, IsNil)
+	c.Assert(p, Equals, "/e")
+
+	p, err = Follow("/e", path.Clean, l.isLink, l.readlink)
+	c.Assert(err, IsNil)
+	c.Assert(p, Equals, "/e")
+}
+
+func (s *LinkutilSuite) TestFollowLoop(c *C) {
+	l := links{"/a": "b", "/b": "a"}
+	_, err := Follow("/a", path.Clean, l.isLink, l.readlink)
+	c.Assert(err, Equals, ErrTooManyLinks)
+}
+
+func (s *LinkutilSuite) TestResolve(c *C) {
+	t := tree{
+		"dir":  tree{"file": nil, "up": "../link"},
+		"link": "dir",
+	}
+
+	p, f, err := Resolve("link/file", true, t, t.lookup, t.target)
+	c.Assert(err, IsNil)
+	c.Assert(p, Equals, "dir/file")
+	c.Assert(f, IsNil)
+
+	p, f, err = Resolve("dir/up", false, t, t.lookup, t.target)
+	c.Assert(err, IsNil)
+	c.Assert(p, Equals, "dir/up")
+	c.Assert(f, Equals, "../link")
+
+	p, _, err = Resolve("dir/up/up/file", true, t, t.lookup, t.target)
+	c.Assert(err, IsNil)
+	c.Assert(p, Equals, "dir/file")
+
+	p, f, err = Resolve("", true, t, t.lookup, t.target)
+	c.Assert(err, IsNil)
+	c.Assert(p, Equals, "")
+	c.Assert(f, NotNil)
+}
+
+func (s *LinkutilSuite) TestResolveNotExist(c *C) {
+	t := tree{"link": "missing"}
+	_, _, err := Resolve("link/file", true, t, t.lookup, t.target)
+	c.Assert(err, Equals, os.ErrNotExist)
+}
+
+func (s *LinkutilSuite) TestResolveLoop(c *C) {
+	t := tree{"a": "b", "b": "a"}
+	_, _, err := Resolve("a", true, t, t.lookup, t.target)
+	c.Assert(err, Equals, ErrTooManyLinks)
+
+	p, _, err := Resolve("a", false, t, t.lookup, t.target)
+	c.Assert(err, IsNil)
+	c.Assert(p, Equals, "a")
+}
@@ -0,0 +1,203 @@
+// Package memfile implements the files keeping their content in memory while
+// open, shared by the filesystems and helpers reading and writing the files
+// as a whole.
+package memfile // import "gopkg.in/src-d/go-billy.v4/internal/memfile"
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// File is a file holding its content in memory, implementing the reads,
+// writes, seeks and truncates of billy.File on it. The filesystems embed it in
+// their files, implementing Close, to store the content if Dirty, Lock and
+// Unlock.
+type File struct {
+	// Content is the content of the file, once Loaded. It's written in place,
+	// so it must not be shared with other files if writable.
+	Content []byte
+	// Loaded is true if Content holds the content of the file, otherwise it's
+	// loaded on the first access.
+	Loaded bool
+	// Dirty is true if the content was written or truncated.
+	Dirty bool
+	// Position is the offset of the next read or write.
+	Position int64
+	// Closed is true once the file is closed, the operations fail with
+	// os.ErrClosed.
+	Closed bool
+
+	name string
+	flag int
+	load func() ([]byte, error)
+}
+
+// New returns a file with the given name, opened with flag, loading its
+// content with load on the first access. If load is nil, the content is the
+// one set in Content.
+func New(name string, flag int, load func() ([]byte, error)) *File {
+	return &File{name: name, flag: flag, load: load, Loaded: load == nil}
+}
+
+// Name returns the name of the file.
+func (f *File) Name() string {
+	return f.name
+}
+
+// Flag returns the flag the file was opened with.
+func (f *File) Flag() int {
+	return f.flag
+}
+
+// Load loads the content of the file, if not loaded yet.
+func (f *File) Load() error {
+	if f.Loaded {
+		return nil
+	}
+
+	content, err := f.load()
+	if err != nil {
+		return err
+	}
+
+	f.Content, f.Loaded = content, true
+	return nil
+}
+
+func (f *File) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.Position)
+	f.Position += int64(n)
+
+	if err == io.EOF && n != 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if f.Closed {
+		return 0, os.ErrClosed
+	}
+
+	if f.flag&os.O_WRONLY != 0 {
+		return 0, errors.New("read not supported")
+	}
+
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: f.name, Err: errors.New("negative offset")}
+	}
+
+	if err := f.Load(); err != nil {
+		return 0, err
+	}
+
+	if off >= int64(len(f.Content)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, f.Content[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	if f.Closed {
+		return 0, os.ErrClosed
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.Position
+	case io.SeekEnd:
+		if err := f.Load(); err != nil {
+			return 0, err
+		}
+
+		offset += int64(len(f.Content))
+	}
+
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: errors.New("negative offset")}
+	}
+
+	f.Position = offset
+	return f.Position, nil
+}
+
+func (f *File) Write(p []byte) (int, error) {
+	if f.Closed {
+		return 0, os.ErrClosed
+	}
+
+	if !f.writable() {
+		return 0, errors.New("write not supported")
+	}
+
+	if err := f.Load(); err != nil {
+		return 0, err
+	}
+
+	if f.flag&os.O_APPEND != 0 {
+		f.Position = int64(len(f.Content))
+	}
+
+	end := f.Position + int64(len(p))
+	if end > int64(len(f.Content)) {
+		f.resize(end)
+	}
+
+	copy(f.Content[f.Position:], p)
+	f.Position = end
+	f.Dirty = true
+	return len(p), nil
+}
+
+func (f *File) Truncate(size int64) error {
+	if f.Closed {
+		return os.ErrClosed
+	}
+
+	if !f.writable() {
+		return errors.New("truncate not supported")
+	}
+
+	if size < 0 {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: errors.New("negative size")}
+	}
+
+	if err := f.Load(); err != nil {
+		return err
+	}
+
+	f.resize(size)
+	f.Dirty = true
+	return nil
+}
+
+func (f *File) writable() bool {
+	return f.flag&(os.O_WRONLY|os.O_RDWR) != 0
+}
+
+// resize sets the size of the content, filling it with zeros when growing,
+// doubling its capacity when exceeded so writing a file piece by piece isn't
+// quadratic.
+func (f *File) resize(size int64) {
+	old := int64(len(f.Content))
+	if size <= int64(cap(f.Content)) {
+		f.Content = f.Content[:size]
+		for i := old; i < size; i++ {
+			f.Content[i] = 0
+		}
+
+		return
+	}
+
+	content := make([]byte, size, size*2)
+	copy(content, f.Content)
+	f.Content = content
+}
@@ -0,0 +1,87 @@
+package memfile
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type MemfileSuite struct{}
+
+var _ = Suite(&MemfileSuite{})
+
+func (s *MemfileSuite) TestLoad(c *C) {
+	var loads int
+	f := New("foo", os.O_RDWR, func() ([]byte, error) {
+		loads++
+		return []byte("foo"), nil
+	})
+
+	c.Assert(f.Loaded, Equals, false)
+	n, err := f.Seek(0, io.SeekEnd)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, int64(3))
+
+	_, err = f.Write([]byte("bar"))
+	c.Assert(err, IsNil)
+	c.Assert(string(f.Content), Equals, "foobar")
+	c.Assert(f.Dirty, Equals, true)
+	c.Assert(loads, Equals, 1)
+}
+
+func (s *MemfileSuite) TestLoadError(c *C) {
+	errLoad := errors.New("load")
+	f := New("foo", os.O_RDONLY, func() ([]byte, error) {
+		return nil, errLoad
+	})
+
+	_, err := f.Read(make([]byte, 1))
+	c.Assert(err, Equals, errLoad)
+	c.Assert(f.Loaded, Equals, false)
+}
+
+func (s *MemfileSuite) TestReadOnly(c *C) {
+	f := New("foo", os.O_RDONLY, nil)
+	f.Content = []byte("foo")
+
+	_, err := f.Write([]byte("bar"))
+	c.Assert(err, NotNil)
+	c.Assert(f.Truncate(0), NotNil)
+
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "foo")
+	c.Assert(f.Dirty, Equals, false)
+}
+
+func (s *MemfileSuite) TestTruncate(c *C) {
+	f := New("foo", os.O_RDWR, nil)
+	_, err := f.Write([]byte("foobar"))
+	c.Assert(err, IsNil)
+
+	c.Assert(f.Truncate(3), IsNil)
+	c.Assert(f.Truncate(6), IsNil)
+	c.Assert(string(f.Content), Equals, "foo\x00\x00\x00")
+
+	err = f.Truncate(-1)
+	c.Assert(err, FitsTypeOf, &os.PathError{})
+}
+
+func (s *MemfileSuite) TestClosed(c *C) {
+	f := New("foo", os.O_RDWR, nil)
+	f.Closed = true
+
+	_, err := f.Read(make([]byte, 1))
+	c.Assert(err, Equals, os.ErrClosed)
+	_, err = f.Write([]byte("foo"))
+	c.Assert(err, Equals, os.ErrClosed)
+	_, err = f.Seek(0, io.SeekStart)
+	c.Assert(err, Equals, os.ErrClosed)
+	c.Assert(f.Truncate(0), Equals, os.ErrClosed)
+}
@@ -0,0 +1,22 @@
+// Package pathutil provides the helpers on paths shared by the filesystems
+// storing the files by their path.
+package pathutil // import "gopkg.in/src-d/go-billy.v4/internal/pathutil"
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+const separator = string(filepath.Separator)
+
+// Relative returns the given path without the leading separators, as the name
+// of a file.
+func Relative(p string) string {
+	return strings.TrimLeft(p, separator)
+}
+
+// IsAbs returns true if the given target of a link is absolute, either as a
+// path of the host or starting by a separator.
+func IsAbs(target string) bool {
+	return filepath.IsAbs(target) || strings.HasPrefix(filepath.ToSlash(target), "/")
+}
@@ -0,0 +1,278 @@
+package ipfsfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	directoryType = "directory"
+
+	// directoryEntry is the type of the directories, in the entries of
+	// files/ls and the links of ls.
+	directoryEntry = 1
+)
+
+// stat is the result of files/stat.
+type stat struct {
+	Hash string `json:"Hash"`
+	Size int64  `json:"Size"`
+	Type string `json:"Type"`
+}
+
+func (s *stat) isDir() bool {
+	return s.Type == directoryType
+}
+
+// entry is an entry of the result of files/ls, or a link of ls.
+type entry struct {
+	Name string `json:"Name"`
+	Type int    `json:"Type"`
+	Size int64  `json:"Size"`
+	Hash string `json:"Hash"`
+}
+
+func (e *entry) isDir() bool {
+	return e.Type == directoryEntry
+}
+
+// apiError is the body of the errors returned by the API.
+type apiError struct {
+	Message string `json:"Message"`
+	Code    int    `json:"Code"`
+	Type    string `json:"Type"`
+}
+
+// call performs a request to the given command of the API, with the given
+// arguments and body, decoding the JSON response into v, if not nil.
+func (fs *IPFS) call(cmd string, args url.Values, body io.Reader, contentType string, v interface{}) error {
+	res, err := fs.request(cmd, args, body, contentType)
+	if err != nil {
+		return err
+	}
+
+	defer closeBody(res)
+	if v == nil {
+		return nil
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// request performs a request to the given command of the API, the response
+// is returned only if succeeded. The commands are always requested with
+// POST.
+func (fs *IPFS) request(cmd string, args url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	u := fs.opts.Endpoint + "/api/v0/" + cmd + "?" + args.Encode()
+	req, err := http.NewRequest(http.MethodPost, u, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	res, err := fs.opts.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusOK {
+		return res, nil
+	}
+
+	defer closeBody(res)
+	return nil, statusError(res)
+}
+
+func statusError(res *http.Response) error {
+	switch res.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return os.ErrPermission
+	}
+
+	var e apiError
+	if err := json.NewDecoder(res.Body).Decode(&e); err != nil || e.Message == "" {
+		return fmt.Errorf("ipfs: unexpected status: %s", res.Status)
+	}
+
+	return messageError(e.Message)
+}
+
+// messageError returns the error of the given error message, the known
+// messages are translated to the errors of the os package.
+func messageError(msg string) error {
+	switch {
+	case strings.Contains(msg, "does not exist"),
+		strings.Contains(msg, "no link named"):
+		return os.ErrNotExist
+	case strings.Contains(msg, "already has entry by that name"):
+		return os.ErrExist
+	case strings.Contains(msg, "not a directory"):
+		return errNotDir
+	case strings.Contains(msg, "is a directory"):
+		return errIsDir
+	}
+
+	return fmt.Errorf("ipfs: %s", msg)
+}
+
+// closeBody closes the body of the given response once read what's needed,
+// such as the first bytes of a file with files/read, discarding the rest to
+// reuse the connection with the daemon.
+func closeBody(res *http.Response) {
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+}
+
+// stat returns the stat of the given path, of MFS or under /ipfs.
+func (fs *IPFS) stat(p string) (*stat, error) {
+	s := &stat{}
+	if err := fs.call("files/stat", url.Values{"arg": {p}}, nil, "", s); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// list returns the entries of the given directory.
+func (fs *IPFS) list(p string) ([]*entry, error) {
+	if !fs.readOnly {
+		var l struct {
+			Entries []*entry `json:"Entries"`
+		}
+
+		args := url.Values{"arg": {p}, "long": {"true"}}
+		if err := fs.call("files/ls", args, nil, "", &l); err != nil {
+			return nil, err
+		}
+
+		return l.Entries, nil
+	}
+
+	// files/ls only lists MFS, the immutable paths are listed with ls
+	var l struct {
+		Objects []struct {
+			Links []*entry `json:"Links"`
+		} `json:"Objects"`
+	}
+
+	args := url.Values{"arg": {p}, "resolve-type": {"true"}, "size": {"true"}}
+	if err := fs.call("ls", args, nil, "", &l); err != nil {
+		return nil, err
+	}
+
+	var entries []*entry
+	for _, o := range l.Objects {
+		entries = append(entries, o.Links...)
+	}
+
+	return entries, nil
+}
+
+// read reads up to len(b) bytes of the given file at the given offset.
+func (fs *IPFS) read(p string, b []byte, off int64) (int, error) {
+	args := url.Values{"arg": {p}, "offset": {strconv.FormatInt(off, 10)}}
+	cmd := "files/read"
+	if fs.readOnly {
+		cmd = "cat"
+		args.Set("length", strconv.Itoa(len(b)))
+	} else {
+		args.Set("count", strconv.Itoa(len(b)))
+	}
+
+	res, err := fs.request(cmd, args, nil, "")
+	if err != nil {
+		return 0, err
+	}
+
+	defer closeBody(res)
+	n, err := io.ReadFull(res.Body, b)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+// write writes the given content to the given file of MFS, at the given
+// offset. The file, and its parents, are created if missing, and truncated
+// before writing if truncate is true.
+func (fs *IPFS) write(p string, content []byte, off int64, truncate bool) error {
+	body := bytes.NewBuffer(nil)
+	w := multipart.NewWriter(body)
+	part, err := w.CreateFormFile("data", "data")
+	if err != nil {
+		return err
+	}
+
+	if _, err := part.Write(content); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	args := url.Values{
+		"arg":      {p},
+		"offset":   {strconv.FormatInt(off, 10)},
+		"create":   {"true"},
+		"parents":  {"true"},
+		"truncate": {strconv.FormatBool(truncate)},
+	}
+
+	return fs.call("files/write", args, body, w.FormDataContentType(), nil)
+}
+
+type fileInfo struct {
+	name string
+	size int64
+	mode os.FileMode
+}
+
+func newFileInfo(name string, size int64, dir bool) *fileInfo {
+	fi := &fileInfo{name: name, size: size, mode: 0644}
+	if dir {
+		fi.mode = os.ModeDir | 0755
+	}
+
+	return fi
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.size
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	return fi.mode
+}
+
+// ModTime returns the zero time, since UnixFS doesn't store the
+// modification time.
+func (fi *fileInfo) ModTime() time.Time {
+	return time.Time{}
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.mode.IsDir()
+}
+
+func (*fileInfo) Sys() interface{} {
+	return nil
+}
@@ -0,0 +1,182 @@
+package ipfsfs
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// file is a file of IPFS, every read and write is a request at the given
+// offset, nothing is buffered.
+type file struct {
+	fs   *IPFS
+	name string
+	path string
+	flag int
+
+	size     int64
+	position int64
+	isClosed bool
+}
+
+func newFile(fs *IPFS, p string, flag int, size int64) *file {
+	return &file{fs: fs, path: p, flag: flag, size: size}
+}
+
+func (f *file) Name() string {
+	return f.name
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.position)
+	f.position += int64(n)
+
+	if err == io.EOF && n != 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	if f.flag&os.O_WRONLY != 0 {
+		return 0, errors.New("read not supported")
+	}
+
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: f.name, Err: errors.New("negative offset")}
+	}
+
+	if off >= f.size {
+		return 0, io.EOF
+	}
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	n, err := f.fs.read(f.path, p, off)
+	if err != nil && err != io.EOF {
+		return n, &os.PathError{Op: "read", Path: f.name, Err: err}
+	}
+
+	return n, err
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.position
+	case io.SeekEnd:
+		offset += f.size
+	}
+
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: errors.New("negative offset")}
+	}
+
+	f.position = offset
+	return f.position, nil
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	if !isWrite(f.flag) {
+		return 0, errors.New("write not supported")
+	}
+
+	if f.flag&os.O_APPEND != 0 {
+		f.position = f.size
+	}
+
+	if err := f.writeAt(p, f.position); err != nil {
+		return 0, err
+	}
+
+	f.position += int64(len(p))
+	return len(p), nil
+}
+
+// writeAt writes the given content at the given offset, filling with zeros
+// the gap from the end of the file, since files/write doesn't accept
+// offsets past the end.
+func (f *file) writeAt(p []byte, off int64) error {
+	if off > f.size {
+		p = append(make([]byte, off-f.size), p...)
+		off = f.size
+	}
+
+	if len(p) == 0 {
+		return nil
+	}
+
+	if err := f.fs.write(f.path, p, off, false); err != nil {
+		return &os.PathError{Op: "write", Path: f.name, Err: err}
+	}
+
+	if end := off + int64(len(p)); end > f.size {
+		f.size = end
+	}
+
+	return nil
+}
+
+// Truncate changes the size of the file. files/write only truncates to zero,
+// so the remaining content is read and written back when shrinking.
+func (f *file) Truncate(size int64) error {
+	if f.isClosed {
+		return os.ErrClosed
+	}
+
+	if !isWrite(f.flag) {
+		return errors.New("truncate not supported")
+	}
+
+	if size >= f.size {
+		return f.writeAt(nil, size)
+	}
+
+	content := make([]byte, size)
+	if size != 0 {
+		if _, err := f.fs.read(f.path, content, 0); err != nil && err != io.EOF {
+			return &os.PathError{Op: "truncate", Path: f.name, Err: err}
+		}
+	}
+
+	if err := f.fs.write(f.path, content, 0, true); err != nil {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: err}
+	}
+
+	f.size = size
+	return nil
+}
+
+func (f *file) Close() error {
+	if f.isClosed {
+		return os.ErrClosed
+	}
+
+	f.isClosed = true
+	return nil
+}
+
+// Lock is a no-op in IPFS.
+func (f *file) Lock() error {
+	return nil
+}
+
+// Unlock is a no-op in IPFS.
+func (f *file) Unlock() error {
+	return nil
+}
@@ -0,0 +1,346 @@
+// Package ipfsfs provides a billy filesystem over IPFS, with the Mutable File
+// System (MFS) of the RPC API of a node, eg.: Kubo.
+//
+// The files are read and written in place, at the offsets requested, with
+// files/read and files/write, so they aren't kept in memory. Any CID can be
+// mounted as a read-only filesystem with Mount, and the CID of any path of
+// MFS is returned by CID.
+package ipfsfs // import "gopkg.in/src-d/go-billy.v4/ipfsfs"
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/internal/pathutil"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const defaultEndpoint = "http://127.0.0.1:5001"
+
+var (
+	// ErrNotEmpty is returned when removing a directory with files.
+	ErrNotEmpty = billy.ErrNotEmpty
+
+	errIsDir  = errors.New("is a directory")
+	errNotDir = errors.New("not a directory")
+)
+
+// Options holds the configuration of an IPFS filesystem.
+type Options struct {
+	// Client sends the calls to the RPC API of the node, all of them POST
+	// requests, http.DefaultClient if nil.
+	Client *http.Client
+	// Endpoint is the base URL of the RPC API, http://127.0.0.1:5001 by
+	// default.
+	Endpoint string
+}
+
+// IPFS is a filesystem over the MFS of an IPFS node, or over an immutable
+// CID.
+type IPFS struct {
+	opts     Options
+	base     string
+	readOnly bool
+}
+
+// New returns a new filesystem over the MFS of an IPFS node.
+func New(opts Options) *IPFS {
+	return newIPFS(opts, "", false)
+}
+
+// Mount returns a new read-only filesystem with the files of the given CID.
+func Mount(cid string, opts Options) *IPFS {
+	return newIPFS(opts, "/ipfs/"+cid, true)
+}
+
+func newIPFS(opts Options, base string, readOnly bool) *IPFS {
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+
+	if opts.Endpoint == "" {
+		opts.Endpoint = defaultEndpoint
+	}
+
+	opts.Endpoint = strings.TrimSuffix(opts.Endpoint, "/")
+	return &IPFS{opts: opts, base: base, readOnly: readOnly}
+}
+
+// path returns the path of the API of the given filename.
+func (fs *IPFS) path(filename string) string {
+	p := path.Clean("/" + filepath.ToSlash(filename))
+	if fs.base == "" {
+		return p
+	}
+
+	return strings.TrimSuffix(fs.base+p, "/")
+}
+
+// CID returns the CID of the given file or directory.
+func (fs *IPFS) CID(filename string) (string, error) {
+	s, err := fs.stat(fs.path(filename))
+	if err != nil {
+		return "", &os.PathError{Op: "stat", Path: filename, Err: err}
+	}
+
+	return s.Hash, nil
+}
+
+func (fs *IPFS) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *IPFS) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file, creating it if needed, and the missing
+// directories. The permissions are ignored.
+func (fs *IPFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if fs.readOnly && (isWrite(flag) || flag&os.O_CREATE != 0) {
+		return nil, billy.ErrReadOnly
+	}
+
+	f, err := fs.openFile(fs.path(filename), flag)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+	}
+
+	f.name = pathutil.Relative(filename)
+	return f, nil
+}
+
+func (fs *IPFS) openFile(p string, flag int) (*file, error) {
+	s, err := fs.stat(p)
+	switch {
+	case err == nil:
+		if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+			return nil, os.ErrExist
+		}
+
+		if s.isDir() {
+			return nil, errIsDir
+		}
+
+		f := newFile(fs, p, flag, s.Size)
+		if flag&os.O_TRUNC != 0 && isWrite(flag) && s.Size != 0 {
+			if err := fs.write(p, nil, 0, true); err != nil {
+				return nil, err
+			}
+
+			f.size = 0
+		}
+
+		return f, nil
+	case os.IsNotExist(err) && flag&os.O_CREATE != 0:
+		if err := fs.write(p, nil, 0, false); err != nil {
+			return nil, err
+		}
+
+		return newFile(fs, p, flag, 0), nil
+	default:
+		return nil, err
+	}
+}
+
+func (fs *IPFS) Stat(filename string) (os.FileInfo, error) {
+	p := fs.path(filename)
+	s, err := fs.stat(p)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: filename, Err: err}
+	}
+
+	name := path.Base(p)
+	if p == fs.base || p == "/" {
+		name = string(filepath.Separator)
+	}
+
+	return newFileInfo(name, s.Size, s.isDir()), nil
+}
+
+// Lstat is equivalent to Stat, the symlinks are not supported.
+func (fs *IPFS) Lstat(filename string) (os.FileInfo, error) {
+	return fs.Stat(filename)
+}
+
+func (fs *IPFS) ReadDir(filename string) ([]os.FileInfo, error) {
+	entries, err := fs.list(fs.path(filename))
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: filename, Err: err}
+	}
+
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		infos = append(infos, newFileInfo(e.Name, e.Size, e.isDir()))
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+
+	return infos, nil
+}
+
+// Rename moves the file with files/mv, replacing the destination if it's a
+// file, and creating the missing directories.
+func (fs *IPFS) Rename(from, to string) error {
+	if fs.readOnly {
+		return billy.ErrReadOnly
+	}
+
+	if err := fs.rename(fs.path(from), fs.path(to)); err != nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *IPFS) rename(from, to string) error {
+	if from == to {
+		_, err := fs.stat(from)
+		return err
+	}
+
+	if _, err := fs.stat(from); err != nil {
+		return err
+	}
+
+	// files/mv moves the file into the destination if it's a directory
+	target, err := fs.stat(to)
+	switch {
+	case err == nil && target.isDir():
+		return os.ErrExist
+	case err == nil:
+		if err := fs.call("files/rm", url.Values{"arg": {to}}, nil, "", nil); err != nil {
+			return err
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	if err := fs.mkdirAll(path.Dir(to)); err != nil {
+		return err
+	}
+
+	return fs.call("files/mv", url.Values{"arg": {from, to}}, nil, "", nil)
+}
+
+// Remove deletes the given file or empty directory.
+func (fs *IPFS) Remove(filename string) error {
+	if fs.readOnly {
+		return billy.ErrReadOnly
+	}
+
+	if err := fs.remove(fs.path(filename)); err != nil {
+		return &os.PathError{Op: "remove", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *IPFS) remove(p string) error {
+	s, err := fs.stat(p)
+	if err != nil {
+		return err
+	}
+
+	args := url.Values{"arg": {p}}
+	if s.isDir() {
+		entries, err := fs.list(p)
+		if err != nil {
+			return err
+		}
+
+		if len(entries) != 0 {
+			return ErrNotEmpty
+		}
+
+		// files/rm requires recursive to remove directories, even if empty
+		args.Set("recursive", "true")
+	}
+
+	return fs.call("files/rm", args, nil, "", nil)
+}
+
+// MkdirAll creates the directory and any missing parent, the permissions
+// are ignored.
+func (fs *IPFS) MkdirAll(filename string, perm os.FileMode) error {
+	if fs.readOnly {
+		return billy.ErrReadOnly
+	}
+
+	if err := fs.mkdirAll(fs.path(filename)); err != nil {
+		return &os.PathError{Op: "mkdir", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *IPFS) mkdirAll(p string) error {
+	s, err := fs.stat(p)
+	switch {
+	case err == nil && s.isDir():
+		return nil
+	case err == nil:
+		return errNotDir
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	return fs.call("files/mkdir", url.Values{"arg": {p}, "parents": {"true"}}, nil, "", nil)
+}
+
+func (fs *IPFS) Symlink(target, link string) error {
+	if fs.readOnly {
+		return billy.ErrReadOnly
+	}
+
+	return billy.ErrNotSupported
+}
+
+func (fs *IPFS) Readlink(link string) (string, error) {
+	return "", billy.ErrNotSupported
+}
+
+func (fs *IPFS) TempFile(dir, prefix string) (billy.File, error) {
+	if fs.readOnly {
+		return nil, billy.ErrReadOnly
+	}
+
+	return util.TempFile(fs, dir, prefix)
+}
+
+func (fs *IPFS) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (fs *IPFS) Chroot(p string) (billy.Filesystem, error) {
+	return chroot.New(fs, fs.Join(fs.Root(), p)), nil
+}
+
+func (fs *IPFS) Root() string {
+	return string(filepath.Separator)
+}
+
+// Capabilities implements the Capable interface.
+func (fs *IPFS) Capabilities() billy.Capability {
+	if fs.readOnly {
+		return billy.ReadCapability | billy.SeekCapability
+	}
+
+	return billy.WriteCapability | billy.ReadCapability |
+		billy.ReadAndWriteCapability | billy.SeekCapability |
+		billy.TruncateCapability
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR) != 0
+}
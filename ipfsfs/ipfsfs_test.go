@@ -0,0 +1,176 @@
+package ipfsfs
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&IPFSSuite{})
+
+// IPFSSuite runs the generic suites, except the symlinks one, since MFS
+// doesn't support symlinks.
+type IPFSSuite struct {
+	test.BasicSuite
+	test.DirSuite
+	test.TempFileSuite
+	test.ChrootSuite
+
+	FS     *IPFS
+	server *server
+}
+
+func (s *IPFSSuite) SetUpTest(c *C) {
+	s.server = newServer()
+	s.FS = New(Options{Endpoint: s.server.URL})
+
+	s.BasicSuite.FS = s.FS
+	s.DirSuite.FS = s.FS
+	s.TempFileSuite.FS = s.FS
+	s.ChrootSuite.FS = s.FS
+
+	// every temporary file takes several calls to the RPC API of the node,
+	// too many to run the default rounds with the race detector.
+	s.TempFileSuite.Rounds = 8
+}
+
+func (s *IPFSSuite) TearDownTest(c *C) {
+	s.server.Close()
+}
+
+func (s *IPFSSuite) TestWriteInPlace(c *C) {
+	f, err := s.FS.Create("foo")
+	c.Assert(err, IsNil)
+
+	_, err = f.Write([]byte("foo"))
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("bar"))
+	c.Assert(err, IsNil)
+	c.Assert(s.server.count("files/write"), Equals, 3)
+
+	_, err = f.Seek(10, io.SeekStart)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("qux"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "foobar\x00\x00\x00\x00qux")
+}
+
+func (s *IPFSSuite) TestTruncateShrink(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foobar"), 0644), IsNil)
+
+	f, err := s.FS.OpenFile("foo", os.O_RDWR, 0)
+	c.Assert(err, IsNil)
+	c.Assert(f.Truncate(3), IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "foo")
+}
+
+func (s *IPFSSuite) TestCID(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "bar/foo", []byte("foo"), 0644), IsNil)
+
+	foo, err := s.FS.CID("foo")
+	c.Assert(err, IsNil)
+	bar, err := s.FS.CID("bar/foo")
+	c.Assert(err, IsNil)
+	c.Assert(foo, Equals, bar)
+
+	_, err = s.FS.CID("qux")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *IPFSSuite) TestMount(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar", []byte("bar"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "foo/qux/baz", []byte("baz"), 0644), IsNil)
+
+	cid, err := s.FS.CID("foo")
+	c.Assert(err, IsNil)
+
+	// the mounted CID is immutable
+	c.Assert(util.WriteFile(s.FS, "foo/bar", []byte("modified"), 0644), IsNil)
+
+	fs := Mount(cid, Options{Endpoint: s.server.URL})
+	c.Assert(test.ReadFile(c, fs, "bar"), Equals, "bar")
+	c.Assert(test.ReadFile(c, fs, "qux/baz"), Equals, "baz")
+	c.Assert(s.server.count("cat"), Equals, 2)
+
+	infos, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 2)
+	c.Assert(infos[0].Name(), Equals, "bar")
+	c.Assert(infos[0].Size(), Equals, int64(3))
+	c.Assert(infos[1].Name(), Equals, "qux")
+	c.Assert(infos[1].IsDir(), Equals, true)
+
+	fi, err := fs.Stat("/")
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+
+	_, err = fs.Create("foo")
+	c.Assert(err, Equals, billy.ErrReadOnly)
+	c.Assert(fs.Remove("bar"), Equals, billy.ErrReadOnly)
+	c.Assert(fs.Rename("bar", "foo"), Equals, billy.ErrReadOnly)
+	c.Assert(fs.MkdirAll("foo", 0755), Equals, billy.ErrReadOnly)
+	c.Assert(billy.CapabilityCheck(fs, billy.WriteCapability), Equals, false)
+}
+
+func (s *IPFSSuite) TestRenameReplace(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "bar", []byte("bar"), 0644), IsNil)
+	c.Assert(s.FS.Rename("foo", "bar"), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "bar"), Equals, "foo")
+
+	c.Assert(s.FS.MkdirAll("qux", 0755), IsNil)
+	err := s.FS.Rename("bar", "qux")
+	c.Assert(os.IsExist(err.(*os.LinkError).Err), Equals, true)
+}
+
+func (s *IPFSSuite) TestRemoveNotEmpty(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar", nil, 0644), IsNil)
+
+	err := s.FS.Remove("foo")
+	c.Assert(err.(*os.PathError).Err, Equals, ErrNotEmpty)
+}
+
+// TestStat overrides the one of BasicSuite, since UnixFS doesn't store file
+// modes nor modification times.
+func (s *IPFSSuite) TestStat(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar", []byte("foo"), 0644), IsNil)
+
+	fi, err := s.FS.Stat("foo/bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Name(), Equals, "bar")
+	c.Assert(fi.Size(), Equals, int64(3))
+	c.Assert(fi.IsDir(), Equals, false)
+}
+
+// TestStatDir overrides the one of DirSuite, since UnixFS doesn't store
+// modification times.
+func (s *IPFSSuite) TestStatDir(c *C) {
+	c.Assert(s.FS.MkdirAll("foo/bar", 0755), IsNil)
+
+	fi, err := s.FS.Stat("foo/bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Name(), Equals, "bar")
+	c.Assert(fi.Mode().IsDir(), Equals, true)
+	c.Assert(fi.IsDir(), Equals, true)
+}
+
+func (s *IPFSSuite) TestOpenFileWithModes(c *C) {
+	c.Skip("UnixFS doesn't support file modes")
+}
+
+func (s *IPFSSuite) TestSymlink(c *C) {
+	c.Assert(s.FS.Symlink("foo", "bar"), Equals, billy.ErrNotSupported)
+}
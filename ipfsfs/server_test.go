@@ -0,0 +1,384 @@
+package ipfsfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+// server is a minimal RPC API of an IPFS node over a memfs, to test the
+// client. It supports the commands and options used by the client only.
+// The CIDs are fake, the hashes of the content, and the trees of the
+// directories are copied when their CID is requested, so they can be
+// resolved later under /ipfs.
+type server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	mfs      billy.Filesystem
+	trees    map[string]billy.Filesystem
+	requests map[string]int
+}
+
+func newServer() *server {
+	s := &server{
+		mfs:      newMemfs(),
+		trees:    make(map[string]billy.Filesystem),
+		requests: make(map[string]int),
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+func newMemfs() billy.Filesystem {
+	fs := memfs.New()
+	fs.MkdirAll("/", 0755)
+	return fs
+}
+
+// count returns the number of requests to the given command, eg.:
+// "files/write".
+func (s *server) count(cmd string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests[cmd]
+}
+
+func (s *server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	cmd := strings.TrimPrefix(r.URL.Path, "/api/v0/")
+	s.requests[cmd]++
+
+	q := r.URL.Query()
+	args := q["arg"]
+	if len(args) == 0 {
+		writeError(w, "argument \"path\" is required")
+		return
+	}
+
+	p := args[0]
+	switch cmd {
+	case "files/stat":
+		s.stat(w, p)
+	case "files/ls":
+		s.ls(w, s.mfs, p, false)
+	case "ls":
+		fs, rest, ok := s.resolve(w, p, true)
+		if ok {
+			s.ls(w, fs, rest, true)
+		}
+	case "files/read":
+		s.read(w, s.mfs, p, q.Get("offset"), q.Get("count"))
+	case "cat":
+		fs, rest, ok := s.resolve(w, p, true)
+		if ok {
+			s.read(w, fs, rest, q.Get("offset"), q.Get("length"))
+		}
+	case "files/write":
+		s.write(w, r, p)
+	case "files/mkdir":
+		if _, err := s.mfs.Stat(p); err == nil {
+			if q.Get("parents") != "true" {
+				writeError(w, "file already exists")
+			}
+
+			return
+		}
+
+		if err := s.mfs.MkdirAll(p, 0755); err != nil {
+			writeError(w, err.Error())
+		}
+	case "files/rm":
+		fi, err := s.mfs.Stat(p)
+		if err != nil {
+			writeError(w, "file does not exist")
+			return
+		}
+
+		if fi.IsDir() && q.Get("recursive") != "true" {
+			writeError(w, p+" is a directory, use -r to remove directories")
+			return
+		}
+
+		util.RemoveAll(s.mfs, p)
+	case "files/mv":
+		s.move(w, p, args[1])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "404 page not found")
+	}
+}
+
+// resolve returns the filesystem and the path of the given /ipfs path.
+func (s *server) resolve(w http.ResponseWriter, p string, immutable bool) (billy.Filesystem, string, bool) {
+	if !strings.HasPrefix(p, "/ipfs/") {
+		if immutable {
+			writeError(w, "invalid path "+p)
+			return nil, "", false
+		}
+
+		return s.mfs, p, true
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(p, "/ipfs/"), "/", 2)
+	fs, ok := s.trees[parts[0]]
+	if !ok {
+		writeError(w, "block was not found locally (offline)")
+		return nil, "", false
+	}
+
+	if len(parts) == 1 {
+		return fs, "/", true
+	}
+
+	return fs, "/" + parts[1], true
+}
+
+func (s *server) stat(w http.ResponseWriter, p string) {
+	fs, p, ok := s.resolve(w, p, false)
+	if !ok {
+		return
+	}
+
+	fi, err := fs.Stat(p)
+	if err != nil {
+		writeError(w, "file does not exist")
+		return
+	}
+
+	res := &stat{Hash: s.hash(fs, p, fi), Type: "file"}
+	if fi.IsDir() {
+		res.Type = directoryType
+	} else {
+		res.Size = fi.Size()
+	}
+
+	writeJSON(w, res)
+}
+
+// hash returns the fake CID of the given file or directory, the trees of the
+// directories are copied to be resolved later.
+func (s *server) hash(fs billy.Filesystem, p string, fi os.FileInfo) string {
+	h := sha256.New()
+	if !fi.IsDir() {
+		f, _ := fs.Open(p)
+		io.Copy(h, f)
+		f.Close()
+		return "bafk" + hex.EncodeToString(h.Sum(nil))[:32]
+	}
+
+	infos, _ := fs.ReadDir(p)
+	for _, child := range infos {
+		fmt.Fprintf(h, "%s:%s\n", child.Name(), s.hash(fs, path.Join(p, child.Name()), child))
+	}
+
+	cid := "bafy" + hex.EncodeToString(h.Sum(nil))[:32]
+	if _, ok := s.trees[cid]; !ok {
+		tree := newMemfs()
+		copyTree(fs, p, tree, "/")
+		s.trees[cid] = tree
+	}
+
+	return cid
+}
+
+func copyTree(src billy.Filesystem, srcPath string, dst billy.Filesystem, dstPath string) {
+	infos, _ := src.ReadDir(srcPath)
+	for _, fi := range infos {
+		from, to := path.Join(srcPath, fi.Name()), path.Join(dstPath, fi.Name())
+		if fi.IsDir() {
+			dst.MkdirAll(to, 0755)
+			copyTree(src, from, dst, to)
+			continue
+		}
+
+		content, _ := readAll(src, from)
+		util.WriteFile(dst, to, content, 0644)
+	}
+}
+
+func readAll(fs billy.Filesystem, p string) ([]byte, error) {
+	f, err := fs.Open(p)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+func (s *server) ls(w http.ResponseWriter, fs billy.Filesystem, p string, links bool) {
+	fi, err := fs.Stat(p)
+	if err != nil {
+		writeError(w, "file does not exist")
+		return
+	}
+
+	if !fi.IsDir() {
+		writeError(w, p+" is not a directory")
+		return
+	}
+
+	infos, _ := fs.ReadDir(p)
+	entries := []*entry{}
+	for _, fi := range infos {
+		e := &entry{Name: fi.Name(), Size: fi.Size(), Hash: s.hash(fs, path.Join(p, fi.Name()), fi)}
+		switch {
+		case fi.IsDir():
+			e.Type, e.Size = directoryEntry, 0
+		case links:
+			e.Type = 2
+		}
+
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	if links {
+		writeJSON(w, map[string]interface{}{
+			"Objects": []interface{}{map[string]interface{}{"Hash": "", "Links": entries}},
+		})
+
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{"Entries": entries})
+}
+
+func (s *server) read(w http.ResponseWriter, fs billy.Filesystem, p, offset, count string) {
+	fi, err := fs.Stat(p)
+	if err != nil {
+		writeError(w, "file does not exist")
+		return
+	}
+
+	if fi.IsDir() {
+		writeError(w, p+" is a directory")
+		return
+	}
+
+	off, _ := strconv.ParseInt(offset, 10, 64)
+	if off > fi.Size() {
+		writeError(w, fmt.Sprintf("offset was past end of file (%d > %d)", off, fi.Size()))
+		return
+	}
+
+	f, _ := fs.Open(p)
+	defer f.Close()
+	f.Seek(off, io.SeekStart)
+
+	var r io.Reader = f
+	if count != "" {
+		n, _ := strconv.ParseInt(count, 10, 64)
+		r = io.LimitReader(f, n)
+	}
+
+	io.Copy(w, r)
+}
+
+func (s *server) write(w http.ResponseWriter, r *http.Request, p string) {
+	q := r.URL.Query()
+	part, _, err := r.FormFile("data")
+	if err != nil {
+		writeError(w, "file argument 'data' is required")
+		return
+	}
+
+	content, _ := ioutil.ReadAll(part)
+
+	fi, err := s.mfs.Stat(p)
+	switch {
+	case err == nil && fi.IsDir():
+		writeError(w, p+" is a directory")
+		return
+	case err != nil && q.Get("create") != "true":
+		writeError(w, "file does not exist")
+		return
+	case err != nil && q.Get("parents") == "true":
+		if err := s.mfs.MkdirAll(path.Dir(p), 0755); err != nil {
+			writeError(w, err.Error())
+			return
+		}
+	}
+
+	off, _ := strconv.ParseInt(q.Get("offset"), 10, 64)
+	if err == nil && off > fi.Size() {
+		writeError(w, fmt.Sprintf("offset was past end of file (%d > %d)", off, fi.Size()))
+		return
+	}
+
+	flag := os.O_WRONLY | os.O_CREATE
+	if q.Get("truncate") == "true" {
+		flag |= os.O_TRUNC
+	}
+
+	f, err := s.mfs.OpenFile(p, flag, 0644)
+	if err != nil {
+		writeError(w, err.Error())
+		return
+	}
+
+	defer f.Close()
+	f.Seek(off, io.SeekStart)
+	f.Write(content)
+}
+
+func (s *server) move(w http.ResponseWriter, from, to string) {
+	if _, err := s.mfs.Stat(from); err != nil {
+		writeError(w, "file does not exist")
+		return
+	}
+
+	if fi, err := s.mfs.Stat(to); err == nil {
+		if !fi.IsDir() {
+			writeError(w, "directory already has entry by that name")
+			return
+		}
+
+		to = path.Join(to, path.Base(from))
+	}
+
+	if _, err := s.mfs.Stat(path.Dir(to)); err != nil {
+		writeError(w, "file does not exist")
+		return
+	}
+
+	if err := s.mfs.Rename(from, to); err != nil {
+		writeError(w, err.Error())
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(&apiError{Message: msg, Type: "error"})
+}
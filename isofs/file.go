@@ -0,0 +1,128 @@
+package isofs
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// file is a read-only billy.File over the extents of an ISO 9660 entry,
+// read directly from the image since they are never compressed.
+type file struct {
+	fs    *ISO
+	name  string
+	entry *entry
+	size  int64
+
+	position int64
+	isClosed bool
+}
+
+func newFile(fs *ISO, name string, e *entry) billy.File {
+	return &file{fs: fs, name: name, entry: e, size: e.size()}
+}
+
+func (f *file) Name() string {
+	return f.name
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.position)
+	f.position += int64(n)
+
+	if err == io.EOF && n != 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: f.name, Err: errors.New("negative offset")}
+	}
+
+	var n int
+	for _, ext := range f.entry.extents {
+		if n == len(p) {
+			break
+		}
+
+		if off >= ext.size {
+			off -= ext.size
+			continue
+		}
+
+		chunk := p[n:]
+		if int64(len(chunk)) > ext.size-off {
+			chunk = chunk[:ext.size-off]
+		}
+
+		c, err := f.fs.r.ReadAt(chunk, ext.start+off)
+		n += c
+		if err != nil && c != len(chunk) {
+			return n, &os.PathError{Op: "read", Path: f.name, Err: readError(err)}
+		}
+
+		off = 0
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.position
+	case io.SeekEnd:
+		offset += f.size
+	}
+
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: errors.New("negative offset")}
+	}
+
+	f.position = offset
+	return f.position, nil
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	return 0, billy.ErrReadOnly
+}
+
+func (f *file) Truncate(size int64) error {
+	return billy.ErrReadOnly
+}
+
+func (f *file) Close() error {
+	if f.isClosed {
+		return os.ErrClosed
+	}
+
+	f.isClosed = true
+	return nil
+}
+
+// Lock is a no-op in isofs.
+func (f *file) Lock() error {
+	return nil
+}
+
+// Unlock is a no-op in isofs.
+func (f *file) Unlock() error {
+	return nil
+}
@@ -0,0 +1,447 @@
+package isofs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	sectorSize     = 2048
+	firstSector    = 16
+	maxDescriptors = 64
+
+	primaryDescriptor       = 1
+	supplementaryDescriptor = 2
+	terminatorDescriptor    = 255
+
+	recordHeaderSize = 33
+	rootRecordOffset = 156
+	blockSizeOffset  = 128
+	escapesOffset    = 88
+
+	flagDir         = 0x02
+	flagAssociated  = 0x04
+	flagMultiExtent = 0x80
+
+	maxContinuations = 16
+)
+
+var (
+	standardID    = []byte("CD001")
+	jolietEscapes = [][]byte{[]byte("%/@"), []byte("%/C"), []byte("%/E")}
+	selfRecord    = "\x00"
+	parentRecord  = "\x01"
+)
+
+// volume is a volume descriptor of the image, the primary or the Joliet one.
+type volume struct {
+	blockSize int64
+	root      []byte
+}
+
+// readVolumes reads the volume descriptors, returning the primary volume,
+// and the Joliet one if any.
+func readVolumes(r io.ReaderAt) (primary, joliet *volume, err error) {
+	for i := 0; i < maxDescriptors; i++ {
+		d := make([]byte, sectorSize)
+		if _, err := r.ReadAt(d, int64(firstSector+i)*sectorSize); err != nil {
+			return nil, nil, ErrInvalidImage
+		}
+
+		if !bytes.Equal(d[1:6], standardID) {
+			return nil, nil, ErrInvalidImage
+		}
+
+		v := &volume{
+			blockSize: int64(binary.LittleEndian.Uint16(d[blockSizeOffset:])),
+			root:      d[rootRecordOffset : rootRecordOffset+34],
+		}
+
+		switch d[0] {
+		case primaryDescriptor:
+			if primary == nil {
+				primary = v
+			}
+		case supplementaryDescriptor:
+			if joliet == nil && isJoliet(d[escapesOffset:escapesOffset+32]) {
+				joliet = v
+			}
+		case terminatorDescriptor:
+			if primary == nil {
+				return nil, nil, ErrInvalidImage
+			}
+
+			for _, v := range []*volume{primary, joliet} {
+				if v != nil && (v.blockSize < 512 || v.blockSize > sectorSize) {
+					return nil, nil, ErrCorrupted
+				}
+			}
+
+			return primary, joliet, nil
+		}
+	}
+
+	return nil, nil, ErrInvalidImage
+}
+
+func isJoliet(escapes []byte) bool {
+	for _, e := range jolietEscapes {
+		if bytes.HasPrefix(escapes, e) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// extent is a contiguous region of the image holding the data of a file.
+type extent struct {
+	start int64
+	size  int64
+}
+
+// entry is a decoded directory record, with the Rock Ridge extensions
+// applied if any.
+type entry struct {
+	name    string
+	mode    os.FileMode
+	modTime time.Time
+	extents []extent
+	target  string
+
+	flags byte
+	// relocated is set in the directories moved by Rock Ridge to keep the
+	// tree under the depth limit, they are reached through childLink.
+	relocated bool
+	childLink int64
+}
+
+func (e *entry) isDir() bool {
+	return e.mode.IsDir()
+}
+
+func (e *entry) isSymlink() bool {
+	return e.mode&os.ModeSymlink != 0
+}
+
+func (e *entry) size() int64 {
+	if e.isSymlink() {
+		return int64(len(e.target))
+	}
+
+	if e.isDir() {
+		return 0
+	}
+
+	var size int64
+	for _, ext := range e.extents {
+		size += ext.size
+	}
+
+	return size
+}
+
+// parseRecord decodes a directory record, the record length is checked by
+// the caller.
+func (fs *ISO) parseRecord(rec []byte) (*entry, error) {
+	nameLen := int(rec[32])
+	if recordHeaderSize+nameLen > len(rec) {
+		return nil, ErrCorrupted
+	}
+
+	e := &entry{
+		flags:   rec[25],
+		modTime: recordTime(rec[18:25]),
+		extents: []extent{{
+			start: int64(binary.LittleEndian.Uint32(rec[2:])) * fs.blockSize,
+			size:  int64(binary.LittleEndian.Uint32(rec[10:])),
+		}},
+	}
+
+	e.mode = 0444
+	if e.flags&flagDir != 0 {
+		e.mode = os.ModeDir | 0555
+	}
+
+	name := rec[recordHeaderSize : recordHeaderSize+nameLen]
+	e.name = fs.decodeName(name)
+
+	if !fs.rockRidge {
+		return e, nil
+	}
+
+	off := recordHeaderSize + nameLen
+	if nameLen%2 == 0 {
+		off++
+	}
+
+	off += fs.suspSkip
+	if off < len(rec) {
+		if err := fs.parseSUSP(e, rec[off:]); err != nil {
+			return nil, err
+		}
+	}
+
+	return e, nil
+}
+
+func (fs *ISO) decodeName(name []byte) string {
+	s := string(name)
+	if s == selfRecord || s == parentRecord {
+		return s
+	}
+
+	if fs.joliet {
+		u := make([]uint16, len(name)/2)
+		for i := range u {
+			u[i] = binary.BigEndian.Uint16(name[i*2:])
+		}
+
+		s = string(utf16.Decode(u))
+	}
+
+	if i := strings.LastIndexByte(s, ';'); i != -1 {
+		s = s[:i]
+	}
+
+	if len(s) > 1 {
+		s = strings.TrimSuffix(s, ".")
+	}
+
+	return s
+}
+
+// rockRidge holds the Rock Ridge entries spanning several system use
+// entries, or areas.
+type rockRidge struct {
+	name          []byte
+	hasName       bool
+	target        []string
+	linkContinues bool
+}
+
+// parseSUSP applies the Rock Ridge entries of the given system use area to
+// the entry, following the continuation areas.
+func (fs *ISO) parseSUSP(e *entry, area []byte) error {
+	var rr rockRidge
+	for i := 0; area != nil; i++ {
+		if i > maxContinuations {
+			return ErrCorrupted
+		}
+
+		var err error
+		if area, err = fs.parseArea(e, &rr, area); err != nil {
+			return err
+		}
+	}
+
+	if rr.hasName {
+		e.name = string(rr.name)
+	}
+
+	if rr.target != nil {
+		e.target = strings.Join(rr.target, "/")
+		if e.target == "" {
+			e.target = "/"
+		}
+	}
+
+	return nil
+}
+
+// parseArea parses the entries of a system use area, returning the
+// continuation area if any.
+func (fs *ISO) parseArea(e *entry, rr *rockRidge, area []byte) ([]byte, error) {
+	var next []byte
+	for len(area) >= 4 {
+		sig, size := string(area[:2]), int(area[2])
+		if size < 4 || size > len(area) {
+			break
+		}
+
+		data := area[4:size]
+		area = area[size:]
+
+		switch sig {
+		case "NM":
+			if len(data) >= 1 && data[0]&0x06 == 0 {
+				rr.name = append(rr.name, data[1:]...)
+				rr.hasName = true
+			}
+		case "PX":
+			if len(data) >= 4 {
+				e.mode = posixMode(binary.LittleEndian.Uint32(data))
+			}
+		case "SL":
+			if len(data) >= 1 {
+				rr.target, rr.linkContinues = appendLink(rr.target, data[1:], rr.linkContinues)
+			}
+		case "TF":
+			if t, ok := modificationTime(data); ok {
+				e.modTime = t
+			}
+		case "CL":
+			if len(data) >= 4 {
+				e.childLink = int64(binary.LittleEndian.Uint32(data)) * fs.blockSize
+			}
+		case "RE":
+			e.relocated = true
+		case "CE":
+			if len(data) < 24 {
+				return nil, ErrCorrupted
+			}
+
+			block := int64(binary.LittleEndian.Uint32(data))
+			offset := int64(binary.LittleEndian.Uint32(data[8:]))
+			length := int64(binary.LittleEndian.Uint32(data[16:]))
+			if length > sectorSize {
+				return nil, ErrCorrupted
+			}
+
+			next = make([]byte, length)
+			if _, err := fs.r.ReadAt(next, block*fs.blockSize+offset); err != nil {
+				return nil, readError(err)
+			}
+		case "ST":
+			return next, nil
+		}
+	}
+
+	return next, nil
+}
+
+// appendLink appends the components of a SL entry to the ones of the
+// target decoded so far. A component continued in the next one is appended
+// to the last one.
+func appendLink(target []string, data []byte, continues bool) ([]string, bool) {
+	for len(data) >= 2 {
+		flags, size := data[0], int(data[1])
+		if 2+size > len(data) {
+			break
+		}
+
+		var c string
+		switch {
+		case flags&0x02 != 0:
+			c = "."
+		case flags&0x04 != 0:
+			c = ".."
+		case flags&0x08 != 0:
+			c = ""
+		default:
+			c = string(data[2 : 2+size])
+		}
+
+		if continues && len(target) != 0 {
+			target[len(target)-1] += c
+		} else {
+			target = append(target, c)
+		}
+
+		continues = flags&0x01 != 0
+		data = data[2+size:]
+	}
+
+	if target == nil {
+		target = []string{}
+	}
+
+	return target, continues
+}
+
+func posixMode(m uint32) os.FileMode {
+	mode := os.FileMode(m & 0777)
+	if m&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+
+	if m&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+
+	if m&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+
+	switch m & 0170000 {
+	case 0040000:
+		mode |= os.ModeDir
+	case 0120000:
+		mode |= os.ModeSymlink
+	case 0060000:
+		mode |= os.ModeDevice
+	case 0020000:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case 0010000:
+		mode |= os.ModeNamedPipe
+	case 0140000:
+		mode |= os.ModeSocket
+	}
+
+	return mode
+}
+
+// modificationTime returns the modification time of a TF entry, stored
+// after the creation time if present, in the short or the long form.
+func modificationTime(data []byte) (time.Time, bool) {
+	if len(data) < 1 || data[0]&0x02 == 0 {
+		return time.Time{}, false
+	}
+
+	size := 7
+	if data[0]&0x80 != 0 {
+		size = 17
+	}
+
+	off := 1
+	if data[0]&0x01 != 0 {
+		off += size
+	}
+
+	if off+size > len(data) {
+		return time.Time{}, false
+	}
+
+	if size == 7 {
+		return recordTime(data[off : off+size]), true
+	}
+
+	return longTime(data[off : off+size]), true
+}
+
+// recordTime decodes the 7 bytes form of the dates: the years since 1900,
+// month, day, hour, minute, second and the offset from GMT in 15 minutes
+// intervals.
+func recordTime(b []byte) time.Time {
+	if b[0] == 0 && b[1] == 0 && b[2] == 0 {
+		return time.Time{}
+	}
+
+	zone := time.FixedZone("", int(int8(b[6]))*15*60)
+	return time.Date(1900+int(b[0]), time.Month(b[1]), int(b[2]),
+		int(b[3]), int(b[4]), int(b[5]), 0, zone)
+}
+
+// longTime decodes the 17 bytes form of the dates, as digits followed by
+// the offset from GMT.
+func longTime(b []byte) time.Time {
+	digits := func(from, to int) int {
+		n, _ := strconv.Atoi(string(b[from:to]))
+		return n
+	}
+
+	year := digits(0, 4)
+	if year == 0 {
+		return time.Time{}
+	}
+
+	zone := time.FixedZone("", int(int8(b[16]))*15*60)
+	return time.Date(year, time.Month(digits(4, 6)), digits(6, 8),
+		digits(8, 10), digits(10, 12), digits(12, 14), digits(14, 16)*1e7, zone)
+}
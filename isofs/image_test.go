@@ -0,0 +1,371 @@
+package isofs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+const testExtentSize = 3 * sectorSize
+
+var (
+	testRecordTime = []byte{101, 9, 9, 1, 46, 40, 0}
+	testModifyTime = []byte{110, 1, 2, 3, 4, 5, 4}
+)
+
+// imageEntry is a file, directory or symlink to be written in a test image,
+// the content of the symlinks is their target.
+type imageEntry struct {
+	name    string
+	mode    os.FileMode
+	content string
+}
+
+type imageNode struct {
+	imageEntry
+	children []*imageNode
+
+	// extents holds the first block and the size of every extent of the
+	// files, or of the directory in each tree.
+	extents []extent
+	dirs    [2]extent
+}
+
+// imageWriter writes a minimal ISO 9660 image: the volume descriptors, the
+// content of the files, split in extents of testExtentSize, and then the
+// directories of the primary tree and the Joliet one. With Rock Ridge the
+// primary tree has mangled names, so only the NM entries give the real ones.
+type imageWriter struct {
+	rockRidge bool
+	joliet    bool
+
+	root  *imageNode
+	image []byte
+	next  uint32
+}
+
+func buildImage(rockRidge, joliet bool, entries ...imageEntry) []byte {
+	w := &imageWriter{
+		rockRidge: rockRidge,
+		joliet:    joliet,
+		root:      &imageNode{imageEntry: imageEntry{mode: os.ModeDir | 0755}},
+		next:      firstSector + 3,
+	}
+
+	for _, e := range entries {
+		w.add(e)
+	}
+
+	w.allocateFiles(w.root)
+	trees := 1
+	if joliet {
+		trees = 2
+	}
+
+	for tree := 0; tree < trees; tree++ {
+		w.allocateDir(w.root, tree)
+	}
+
+	w.image = make([]byte, int(w.next)*sectorSize)
+	w.writeFiles(w.root)
+	for tree := 0; tree < trees; tree++ {
+		w.writeDir(w.root, w.root, tree)
+	}
+
+	w.writeDescriptor(firstSector, primaryDescriptor, 0)
+	next := firstSector + 1
+	if joliet {
+		w.writeDescriptor(next, supplementaryDescriptor, 1)
+		next++
+	}
+
+	w.writeDescriptor(next, terminatorDescriptor, 0)
+	return w.image
+}
+
+func (w *imageWriter) add(e imageEntry) {
+	dir := w.root
+	parts := strings.Split(e.name, "/")
+	for _, part := range parts[:len(parts)-1] {
+		dir = dir.child(imageEntry{name: part, mode: os.ModeDir | 0755})
+	}
+
+	e.name = parts[len(parts)-1]
+	dir.child(e).imageEntry = e
+}
+
+func (n *imageNode) child(e imageEntry) *imageNode {
+	for _, child := range n.children {
+		if child.name == e.name {
+			return child
+		}
+	}
+
+	child := &imageNode{imageEntry: e}
+	n.children = append(n.children, child)
+	sort.Slice(n.children, func(i, j int) bool {
+		return n.children[i].name < n.children[j].name
+	})
+
+	return child
+}
+
+func (w *imageWriter) allocateFiles(n *imageNode) {
+	for _, child := range n.children {
+		w.allocateFiles(child)
+	}
+
+	if !n.mode.IsRegular() {
+		return
+	}
+
+	content := n.content
+	for {
+		size := len(content)
+		if size > testExtentSize {
+			size = testExtentSize
+		}
+
+		n.extents = append(n.extents, extent{start: int64(w.next), size: int64(size)})
+		w.next += uint32((size + sectorSize - 1) / sectorSize)
+
+		content = content[size:]
+		if len(content) == 0 {
+			return
+		}
+	}
+}
+
+func (w *imageWriter) allocateDir(n *imageNode, tree int) {
+	if !n.mode.IsDir() {
+		return
+	}
+
+	size := len(pack(w.records(n, n, tree)))
+	n.dirs[tree] = extent{start: int64(w.next), size: int64(size)}
+	w.next += uint32(size / sectorSize)
+
+	for _, child := range n.children {
+		w.allocateDir(child, tree)
+	}
+}
+
+func (w *imageWriter) writeFiles(n *imageNode) {
+	for _, child := range n.children {
+		w.writeFiles(child)
+	}
+
+	content := n.content
+	for _, ext := range n.extents {
+		copy(w.image[ext.start*sectorSize:], content[:ext.size])
+		content = content[ext.size:]
+	}
+}
+
+func (w *imageWriter) writeDir(n, parent *imageNode, tree int) {
+	if !n.mode.IsDir() {
+		return
+	}
+
+	copy(w.image[n.dirs[tree].start*sectorSize:], pack(w.records(n, parent, tree)))
+	for _, child := range n.children {
+		w.writeDir(child, n, tree)
+	}
+}
+
+func (w *imageWriter) writeDescriptor(sector int, typ byte, tree int) {
+	d := w.image[sector*sectorSize:]
+	d[0] = typ
+	copy(d[1:6], standardID)
+	d[6] = 1
+
+	if typ == terminatorDescriptor {
+		return
+	}
+
+	bothEndian32(d[80:], w.next)
+	binary.LittleEndian.PutUint16(d[128:], sectorSize)
+	binary.BigEndian.PutUint16(d[130:], sectorSize)
+	if typ == supplementaryDescriptor {
+		copy(d[escapesOffset:], "%/E")
+	}
+
+	root := record([]byte(selfRecord), w.root.dirs[tree], flagDir, nil)
+	copy(d[rootRecordOffset:], root)
+	d[881] = 1
+}
+
+// records returns the records of a directory, "." and ".." first.
+func (w *imageWriter) records(n, parent *imageNode, tree int) [][]byte {
+	rr := w.rockRidge && tree == 0
+
+	var dot []byte
+	if rr && n == w.root {
+		dot = []byte{'S', 'P', 7, 1, 0xbe, 0xef, 0}
+	}
+
+	if rr {
+		dot = append(dot, px(n.mode)...)
+	}
+
+	records := [][]byte{
+		record([]byte(selfRecord), n.dirs[tree], flagDir, dot),
+		record([]byte(parentRecord), parent.dirs[tree], flagDir, nil),
+	}
+
+	for i, child := range n.children {
+		var suffix string
+		if !child.mode.IsDir() {
+			suffix = ";1"
+		}
+
+		var name []byte
+		switch {
+		case tree == 1:
+			name = ucs2(child.name + suffix)
+		case rr:
+			name = []byte(fmt.Sprintf("F%03d%s", i, suffix))
+		default:
+			name = []byte(strings.ToUpper(child.name))
+			if !child.mode.IsDir() && !bytes.Contains(name, []byte(".")) {
+				name = append(name, '.')
+			}
+
+			name = append(name, suffix...)
+		}
+
+		var su []byte
+		if rr {
+			su = append(su, 'N', 'M', byte(5+len(child.name)), 1, 0)
+			su = append(su, child.name...)
+			su = append(su, px(child.mode)...)
+			su = append(su, 'T', 'F', 12, 1, 0x02)
+			su = append(su, testModifyTime...)
+			if child.mode&os.ModeSymlink != 0 {
+				su = append(su, sl(child.content)...)
+			}
+		}
+
+		if child.mode.IsDir() {
+			records = append(records, record(name, child.dirs[tree], flagDir, su))
+			continue
+		}
+
+		extents := child.extents
+		if len(extents) == 0 {
+			extents = []extent{{}}
+		}
+
+		for j, ext := range extents {
+			var flags byte
+			if j != len(extents)-1 {
+				flags = flagMultiExtent
+			}
+
+			records = append(records, record(name, ext, flags, su))
+		}
+	}
+
+	return records
+}
+
+// pack lays out the records in blocks, without crossing their boundaries.
+func pack(records [][]byte) []byte {
+	var dir []byte
+	for _, rec := range records {
+		if len(dir)%sectorSize+len(rec) > sectorSize {
+			dir = append(dir, make([]byte, sectorSize-len(dir)%sectorSize)...)
+		}
+
+		dir = append(dir, rec...)
+	}
+
+	if len(dir)%sectorSize != 0 {
+		dir = append(dir, make([]byte, sectorSize-len(dir)%sectorSize)...)
+	}
+
+	return dir
+}
+
+func record(name []byte, ext extent, flags byte, su []byte) []byte {
+	size := recordHeaderSize + len(name)
+	if len(name)%2 == 0 {
+		size++
+	}
+
+	rec := make([]byte, size, size+len(su)+1)
+	bothEndian32(rec[2:], uint32(ext.start))
+	bothEndian32(rec[10:], uint32(ext.size))
+	copy(rec[18:25], testRecordTime)
+	rec[25] = flags
+	binary.LittleEndian.PutUint16(rec[28:], 1)
+	binary.BigEndian.PutUint16(rec[30:], 1)
+	rec[32] = byte(len(name))
+	copy(rec[recordHeaderSize:], name)
+
+	rec = append(rec, su...)
+	if len(rec)%2 != 0 {
+		rec = append(rec, 0)
+	}
+
+	rec[0] = byte(len(rec))
+	return rec
+}
+
+func px(mode os.FileMode) []byte {
+	m := uint32(mode.Perm())
+	switch {
+	case mode.IsDir():
+		m |= 0040000
+	case mode&os.ModeSymlink != 0:
+		m |= 0120000
+	default:
+		m |= 0100000
+	}
+
+	e := []byte{'P', 'X', 36, 1}
+	for _, v := range []uint32{m, 1, 0, 0} {
+		field := make([]byte, 8)
+		bothEndian32(field, v)
+		e = append(e, field...)
+	}
+
+	return e
+}
+
+func sl(target string) []byte {
+	var components []byte
+	for i, c := range strings.Split(target, "/") {
+		switch {
+		case c == "" && i == 0:
+			components = append(components, 0x08, 0)
+		case c == ".":
+			components = append(components, 0x02, 0)
+		case c == "..":
+			components = append(components, 0x04, 0)
+		default:
+			components = append(components, 0, byte(len(c)))
+			components = append(components, c...)
+		}
+	}
+
+	return append([]byte{'S', 'L', byte(5 + len(components)), 1, 0}, components...)
+}
+
+func ucs2(s string) []byte {
+	var b []byte
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(u>>8), byte(u))
+	}
+
+	return b
+}
+
+func bothEndian32(b []byte, v uint32) {
+	binary.LittleEndian.PutUint32(b, v)
+	binary.BigEndian.PutUint32(b[4:], v)
+}
@@ -0,0 +1,447 @@
+// Package isofs provides a read-only billy filesystem over an ISO 9660
+// image.
+//
+// The Rock Ridge extensions are used when present, providing the long names,
+// modes, modification times and symlinks, and the relocated directories are
+// shown at their original location. Otherwise, the Joliet names are used if
+// the image has a Joliet volume, or the plain ISO 9660 names, without the
+// version suffix. The files spanning several extents are supported. UDF
+// images are read through their ISO 9660 bridge, if any.
+package isofs // import "gopkg.in/src-d/go-billy.v4/isofs"
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/internal/linkutil"
+)
+
+const maxDirSize = 16 << 20
+
+var (
+	// ErrInvalidImage is returned when the image isn't an ISO 9660 image.
+	ErrInvalidImage = errors.New("not an iso9660 image")
+	// ErrCorrupted is returned when a structure of the image can't be
+	// decoded.
+	ErrCorrupted = errors.New("corrupted iso9660 image")
+
+	errNotDir = errors.New("not a directory")
+)
+
+// ISO is a read-only filesystem based on an ISO 9660 image.
+type ISO struct {
+	r         io.ReaderAt
+	blockSize int64
+	rockRidge bool
+	suspSkip  int
+	joliet    bool
+	root      *entry
+
+	mu   sync.Mutex
+	dirs map[int64][]*entry
+}
+
+// New returns a new read-only filesystem from the ISO 9660 image readable
+// from r.
+func New(r io.ReaderAt) (billy.Filesystem, error) {
+	primary, joliet, err := readVolumes(r)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := &ISO{
+		r:         r,
+		blockSize: primary.blockSize,
+		dirs:      make(map[int64][]*entry),
+	}
+
+	if fs.root, err = fs.parseRecord(primary.root); err != nil {
+		return nil, err
+	}
+
+	dot, err := fs.readRecord(fs.root.extents[0].start)
+	if err != nil {
+		return nil, err
+	}
+
+	if skip, ok := rockRidgeSkip(dot); ok {
+		fs.rockRidge, fs.suspSkip = true, skip
+		fs.root, err = fs.parseRecord(dot)
+	} else if joliet != nil {
+		fs.joliet, fs.blockSize = true, joliet.blockSize
+		fs.root, err = fs.parseRecord(joliet.root)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !fs.root.isDir() {
+		return nil, ErrCorrupted
+	}
+
+	return chroot.New(fs, string(filepath.Separator)), nil
+}
+
+// rockRidgeSkip returns the bytes to skip in every system use area, if the
+// given "." record of the root directory has the SUSP indicator.
+func rockRidgeSkip(dot []byte) (int, bool) {
+	off := recordHeaderSize + int(dot[32])
+	if off+7 > len(dot) {
+		return 0, false
+	}
+
+	sp := dot[off : off+7]
+	if string(sp[:2]) != "SP" || sp[2] != 7 || sp[4] != 0xbe || sp[5] != 0xef {
+		return 0, false
+	}
+
+	return int(sp[6]), true
+}
+
+func (fs *ISO) Create(filename string) (billy.File, error) {
+	return nil, billy.ErrReadOnly
+}
+
+func (fs *ISO) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (fs *ISO) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if isWrite(flag) {
+		return nil, billy.ErrReadOnly
+	}
+
+	e, err := fs.resolve(filename, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.isDir() {
+		return nil, fmt.Errorf("cannot open directory: %s", filename)
+	}
+
+	return newFile(fs, filename, e), nil
+}
+
+func (fs *ISO) Stat(filename string) (os.FileInfo, error) {
+	e, err := fs.resolve(filename, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return newFileInfo(path.Base(clean(filename)), e), nil
+}
+
+func (fs *ISO) Lstat(filename string) (os.FileInfo, error) {
+	e, err := fs.resolve(filename, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return newFileInfo(path.Base(clean(filename)), e), nil
+}
+
+func (fs *ISO) ReadDir(filename string) ([]os.FileInfo, error) {
+	e, err := fs.resolve(filename, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if !e.isDir() {
+		return nil, &os.PathError{Op: "readdir", Path: filename, Err: errNotDir}
+	}
+
+	children, err := fs.readDir(e)
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: filename, Err: err}
+	}
+
+	var entries []os.FileInfo
+	for _, child := range children {
+		entries = append(entries, newFileInfo(child.name, child))
+	}
+
+	return entries, nil
+}
+
+func (fs *ISO) Readlink(link string) (string, error) {
+	e, err := fs.resolve(link, false)
+	if err != nil {
+		return "", err
+	}
+
+	if !e.isSymlink() {
+		return "", &os.PathError{Op: "readlink", Path: link, Err: errors.New("not a symlink")}
+	}
+
+	return filepath.FromSlash(e.target), nil
+}
+
+func (fs *ISO) Rename(from, to string) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *ISO) Remove(filename string) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *ISO) MkdirAll(filename string, perm os.FileMode) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *ISO) Symlink(target, link string) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *ISO) TempFile(dir, prefix string) (billy.File, error) {
+	return nil, billy.ErrReadOnly
+}
+
+func (fs *ISO) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+// Capabilities implements the Capable interface.
+func (fs *ISO) Capabilities() billy.Capability {
+	return billy.ReadCapability | billy.SeekCapability
+}
+
+// resolve returns the entry of the given filename, following the symlinks
+// found in any of the parent directories, and in the file itself if follow
+// is true.
+func (fs *ISO) resolve(filename string, follow bool) (*entry, error) {
+	_, e, err := linkutil.Resolve(clean(filename), follow, fs.root, fs.child, linkTarget)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: filename, Err: err}
+	}
+
+	return e.(*entry), nil
+}
+
+// child returns the entry with the given name in the directory dir, and if
+// it's a link, to resolve the paths with linkutil.Resolve.
+func (fs *ISO) child(dir interface{}, name string) (interface{}, bool, error) {
+	d := dir.(*entry)
+	if !d.isDir() {
+		return nil, false, errNotDir
+	}
+
+	e, err := fs.lookup(d, name)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return e, e.isSymlink(), nil
+}
+
+func linkTarget(link interface{}) (string, error) {
+	return link.(*entry).target, nil
+}
+
+func (fs *ISO) lookup(dir *entry, name string) (*entry, error) {
+	entries, err := fs.readDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].name >= name })
+	if i == len(entries) || entries[i].name != name {
+		return nil, os.ErrNotExist
+	}
+
+	return entries[i], nil
+}
+
+// readDir returns the entries of the given directory sorted by name, the
+// directories are cached since they are decoded on every lookup.
+func (fs *ISO) readDir(dir *entry) ([]*entry, error) {
+	ext := dir.extents[0]
+
+	fs.mu.Lock()
+	entries, ok := fs.dirs[ext.start]
+	fs.mu.Unlock()
+	if ok {
+		return entries, nil
+	}
+
+	if ext.size > maxDirSize {
+		return nil, ErrCorrupted
+	}
+
+	data := make([]byte, ext.size)
+	if _, err := fs.r.ReadAt(data, ext.start); err != nil {
+		return nil, readError(err)
+	}
+
+	entries, err := fs.parseDir(data)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+
+	fs.mu.Lock()
+	fs.dirs[ext.start] = entries
+	fs.mu.Unlock()
+
+	return entries, nil
+}
+
+// parseDir decodes the records of a directory, which never cross a block
+// boundary, the rest of the block being zero-filled. The records of a file
+// spanning several extents are merged.
+func (fs *ISO) parseDir(data []byte) ([]*entry, error) {
+	var entries []*entry
+	var multi *entry
+	for pos := int64(0); pos < int64(len(data)); {
+		size := int64(data[pos])
+		if size == 0 {
+			pos = (pos/fs.blockSize + 1) * fs.blockSize
+			continue
+		}
+
+		if size <= recordHeaderSize || pos+size > int64(len(data)) {
+			return nil, ErrCorrupted
+		}
+
+		e, err := fs.parseRecord(data[pos : pos+size])
+		if err != nil {
+			return nil, err
+		}
+
+		pos += size
+		if multi != nil {
+			multi.extents = append(multi.extents, e.extents...)
+			if e.flags&flagMultiExtent == 0 {
+				multi = nil
+			}
+
+			continue
+		}
+
+		if e.flags&flagMultiExtent != 0 {
+			multi = e
+		}
+
+		if e.name == selfRecord || e.name == parentRecord ||
+			e.flags&flagAssociated != 0 || e.relocated {
+			continue
+		}
+
+		if e.childLink != 0 {
+			if err := fs.relocate(e); err != nil {
+				return nil, err
+			}
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// relocate points the entry to the directory relocated by Rock Ridge, taking
+// its extent from its "." record.
+func (fs *ISO) relocate(e *entry) error {
+	rec, err := fs.readRecord(e.childLink)
+	if err != nil {
+		return err
+	}
+
+	dot, err := fs.parseRecord(rec)
+	if err != nil {
+		return err
+	}
+
+	e.extents = dot.extents
+	e.mode = e.mode&^os.ModeType | os.ModeDir
+	return nil
+}
+
+// readRecord reads the directory record at the given offset of the image.
+func (fs *ISO) readRecord(off int64) ([]byte, error) {
+	var size [1]byte
+	if _, err := fs.r.ReadAt(size[:], off); err != nil {
+		return nil, readError(err)
+	}
+
+	if size[0] <= recordHeaderSize {
+		return nil, ErrCorrupted
+	}
+
+	rec := make([]byte, size[0])
+	if _, err := fs.r.ReadAt(rec, off); err != nil {
+		return nil, readError(err)
+	}
+
+	return rec, nil
+}
+
+type fileInfo struct {
+	name  string
+	entry *entry
+}
+
+func newFileInfo(name string, e *entry) os.FileInfo {
+	if name == "" || name == "." {
+		name = string(filepath.Separator)
+	}
+
+	return &fileInfo{name: name, entry: e}
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.entry.size()
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	return fi.entry.mode
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.entry.modTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.entry.isDir()
+}
+
+func (fi *fileInfo) Sys() interface{} {
+	return nil
+}
+
+// clean returns the given path relative to the root of the image, using
+// forward slashes as separator, and "" for the root itself.
+func clean(name string) string {
+	name = path.Clean("/" + filepath.ToSlash(name))
+	return strings.TrimPrefix(name, "/")
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_APPEND|os.O_TRUNC) != 0
+}
+
+// readError turns the short reads into ErrCorrupted, since every structure
+// read is expected to be fully inside of the image.
+func readError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrCorrupted
+	}
+
+	return err
+}
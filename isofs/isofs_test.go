@@ -0,0 +1,265 @@
+package isofs
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/test"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&ISOSuite{})
+
+type ISOSuite struct {
+	FS billy.Filesystem
+}
+
+var large = strings.Repeat("0123456789abcdef", 1000)
+
+var testEntries = []imageEntry{
+	{"foo", 0644, "hello world"},
+	{"qux/bar", 0600, "bar"},
+	{"qux/baz/a", 0644, "a"},
+	{"large.bin", 0644, large},
+	{"empty", 0644, ""},
+	{"link", os.ModeSymlink | 0777, "qux/bar"},
+	{"dirlink", os.ModeSymlink | 0777, "qux"},
+	{"abslink", os.ModeSymlink | 0777, "/qux/baz"},
+	{"qux/parentlink", os.ModeSymlink | 0777, "../foo"},
+}
+
+func (s *ISOSuite) SetUpTest(c *C) {
+	var err error
+	s.FS, err = New(bytes.NewReader(buildImage(true, true, testEntries...)))
+	c.Assert(err, IsNil)
+}
+
+func (s *ISOSuite) TestOpen(c *C) {
+	f, err := s.FS.Open("foo")
+	c.Assert(err, IsNil)
+	c.Assert(f.Name(), Equals, "foo")
+
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "hello world")
+	c.Assert(f.Close(), IsNil)
+}
+
+func (s *ISOSuite) TestOpenNotExists(c *C) {
+	_, err := s.FS.Open("nope")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	_, err = s.FS.Open("foo/bar")
+	c.Assert(err, NotNil)
+}
+
+func (s *ISOSuite) TestOpenDir(c *C) {
+	_, err := s.FS.Open("qux")
+	c.Assert(err, NotNil)
+}
+
+func (s *ISOSuite) TestMultiExtent(c *C) {
+	c.Assert(test.ReadFile(c, s.FS, "large.bin"), Equals, large)
+	c.Assert(test.ReadFile(c, s.FS, "empty"), Equals, "")
+
+	fi, err := s.FS.Stat("large.bin")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Size(), Equals, int64(len(large)))
+}
+
+func (s *ISOSuite) TestSeekAndReadAt(c *C) {
+	f, err := s.FS.Open("large.bin")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	buf := make([]byte, 32)
+	n, err := f.ReadAt(buf, testExtentSize-10)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf[:n]), Equals, large[testExtentSize-10:testExtentSize+22])
+
+	n, err = f.ReadAt(buf, 0)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf[:n]), Equals, large[:32])
+
+	pos, err := f.Seek(-5, io.SeekEnd)
+	c.Assert(err, IsNil)
+	c.Assert(pos, Equals, int64(len(large)-5))
+
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, large[len(large)-5:])
+
+	n, err = f.ReadAt(buf, int64(len(large)-3))
+	c.Assert(err, Equals, io.EOF)
+	c.Assert(string(buf[:n]), Equals, "def")
+}
+
+func (s *ISOSuite) TestStat(c *C) {
+	fi, err := s.FS.Stat("qux/bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Name(), Equals, "bar")
+	c.Assert(fi.Size(), Equals, int64(3))
+	c.Assert(fi.Mode(), Equals, os.FileMode(0600))
+	c.Assert(fi.IsDir(), Equals, false)
+
+	modTime := time.Date(2010, 1, 2, 3, 4, 5, 0, time.FixedZone("", 3600))
+	c.Assert(fi.ModTime().Equal(modTime), Equals, true)
+
+	fi, err = s.FS.Stat("qux/baz")
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+	c.Assert(fi.Mode(), Equals, os.ModeDir|0755)
+
+	fi, err = s.FS.Stat("/")
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+}
+
+func (s *ISOSuite) TestSymlinks(c *C) {
+	fi, err := s.FS.Lstat("link")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode()&os.ModeSymlink, Not(Equals), os.FileMode(0))
+
+	fi, err = s.FS.Stat("link")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Name(), Equals, "link")
+	c.Assert(fi.Size(), Equals, int64(3))
+
+	target, err := s.FS.Readlink("link")
+	c.Assert(err, IsNil)
+	c.Assert(target, Equals, s.FS.Join("qux", "bar"))
+
+	target, err = s.FS.Readlink("abslink")
+	c.Assert(err, IsNil)
+	c.Assert(target, Equals, s.FS.Join("/", "qux", "baz"))
+
+	c.Assert(test.ReadFile(c, s.FS, "dirlink/baz/a"), Equals, "a")
+	c.Assert(test.ReadFile(c, s.FS, "abslink/a"), Equals, "a")
+	c.Assert(test.ReadFile(c, s.FS, "qux/parentlink"), Equals, "hello world")
+
+	_, err = s.FS.Readlink("foo")
+	c.Assert(err, NotNil)
+}
+
+func (s *ISOSuite) TestReadDir(c *C) {
+	entries, err := s.FS.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(names(entries), DeepEquals, []string{
+		"abslink", "dirlink", "empty", "foo", "large.bin", "link", "qux",
+	})
+
+	entries, err = s.FS.ReadDir("dirlink")
+	c.Assert(err, IsNil)
+	c.Assert(names(entries), DeepEquals, []string{"bar", "baz", "parentlink"})
+	c.Assert(entries[1].IsDir(), Equals, true)
+
+	_, err = s.FS.ReadDir("foo")
+	c.Assert(err, NotNil)
+}
+
+func (s *ISOSuite) TestReadDirMany(c *C) {
+	var entries []imageEntry
+	for i := 0; i < 500; i++ {
+		entries = append(entries, imageEntry{
+			strings.Repeat("x", 20+i%50) + string(rune('a'+i%26)) + string(rune('0'+i/26)),
+			0644, "",
+		})
+	}
+
+	fs, err := New(bytes.NewReader(buildImage(true, false, entries...)))
+	c.Assert(err, IsNil)
+
+	infos, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 500)
+
+	_, err = fs.Stat(entries[499].name)
+	c.Assert(err, IsNil)
+}
+
+func (s *ISOSuite) TestJoliet(c *C) {
+	fs, err := New(bytes.NewReader(buildImage(false, true, testEntries[:4]...)))
+	c.Assert(err, IsNil)
+
+	entries, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(names(entries), DeepEquals, []string{"foo", "large.bin", "qux"})
+	c.Assert(entries[0].Mode(), Equals, os.FileMode(0444))
+	c.Assert(entries[2].Mode(), Equals, os.ModeDir|0555)
+
+	c.Assert(test.ReadFile(c, fs, "qux/baz/a"), Equals, "a")
+	c.Assert(test.ReadFile(c, fs, "large.bin"), Equals, large)
+}
+
+func (s *ISOSuite) TestPlain(c *C) {
+	fs, err := New(bytes.NewReader(buildImage(false, false, testEntries[:4]...)))
+	c.Assert(err, IsNil)
+
+	entries, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(names(entries), DeepEquals, []string{"FOO", "LARGE.BIN", "QUX"})
+
+	c.Assert(test.ReadFile(c, fs, "QUX/BAZ/A"), Equals, "a")
+
+	fi, err := fs.Stat("FOO")
+	c.Assert(err, IsNil)
+	c.Assert(fi.ModTime().Equal(time.Date(2001, 9, 9, 1, 46, 40, 0, time.UTC)), Equals, true)
+}
+
+func (s *ISOSuite) TestChroot(c *C) {
+	fs, err := s.FS.Chroot("qux")
+	c.Assert(err, IsNil)
+
+	fi, err := fs.Stat("bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Size(), Equals, int64(3))
+}
+
+func (s *ISOSuite) TestReadOnly(c *C) {
+	_, err := s.FS.Create("new")
+	c.Assert(err, Equals, billy.ErrReadOnly)
+
+	_, err = s.FS.OpenFile("foo", os.O_RDWR, 0)
+	c.Assert(err, Equals, billy.ErrReadOnly)
+
+	c.Assert(s.FS.Remove("foo"), Equals, billy.ErrReadOnly)
+	c.Assert(s.FS.Rename("foo", "bar"), Equals, billy.ErrReadOnly)
+	c.Assert(s.FS.MkdirAll("dir", 0755), Equals, billy.ErrReadOnly)
+	c.Assert(s.FS.Symlink("foo", "bar"), Equals, billy.ErrReadOnly)
+
+	f, err := s.FS.Open("foo")
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("foo"))
+	c.Assert(err, Equals, billy.ErrReadOnly)
+}
+
+func (s *ISOSuite) TestCapabilities(c *C) {
+	c.Assert(billy.CapabilityCheck(s.FS, billy.WriteCapability), Equals, false)
+	c.Assert(billy.CapabilityCheck(s.FS, billy.ReadCapability), Equals, true)
+}
+
+func (s *ISOSuite) TestInvalid(c *C) {
+	_, err := New(bytes.NewReader([]byte("foo")))
+	c.Assert(err, Equals, ErrInvalidImage)
+
+	_, err = New(bytes.NewReader(make([]byte, 64*sectorSize)))
+	c.Assert(err, Equals, ErrInvalidImage)
+}
+
+func names(entries []os.FileInfo) []string {
+	var names []string
+	for _, fi := range entries {
+		names = append(names, fi.Name())
+	}
+
+	return names
+}
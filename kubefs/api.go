@@ -0,0 +1,397 @@
+package kubefs
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+const mergePatch = "application/merge-patch+json"
+
+// kind is a kind of object mapped to a directory of the root.
+type kind struct {
+	// resource is the name of the resource of the API, also used as name of
+	// the directory.
+	resource string
+	name     string
+	// binary is true if all the values are base64 encoded in data, as in
+	// Secrets, instead of split in data and binaryData, as in ConfigMaps.
+	binary bool
+	perm   os.FileMode
+}
+
+var (
+	configMaps = &kind{resource: "configmaps", name: "ConfigMap", perm: 0644}
+	secrets    = &kind{resource: "secrets", name: "Secret", binary: true, perm: 0600}
+
+	kinds = []*kind{configMaps, secrets}
+)
+
+func kindByResource(resource string) *kind {
+	for _, k := range kinds {
+		if k.resource == resource {
+			return k
+		}
+	}
+
+	return nil
+}
+
+// object is a ConfigMap or a Secret, data holds the values as strings in the
+// ConfigMaps, and base64 encoded in the Secrets.
+type object struct {
+	APIVersion string            `json:"apiVersion,omitempty"`
+	Kind       string            `json:"kind,omitempty"`
+	Metadata   objectMeta        `json:"metadata"`
+	Immutable  bool              `json:"immutable,omitempty"`
+	Data       map[string]string `json:"data,omitempty"`
+	BinaryData map[string]string `json:"binaryData,omitempty"`
+}
+
+type objectMeta struct {
+	Name              string `json:"name"`
+	Namespace         string `json:"namespace,omitempty"`
+	CreationTimestamp string `json:"creationTimestamp,omitempty"`
+}
+
+type objectList struct {
+	Items    []*object `json:"items"`
+	Metadata struct {
+		Continue string `json:"continue"`
+	} `json:"metadata"`
+}
+
+// status is the body of the errors returned by the API.
+type status struct {
+	Message string `json:"message"`
+	Reason  string `json:"reason"`
+}
+
+// values returns the decoded values of the given object of the given kind.
+func (k *kind) values(o *object) (map[string][]byte, error) {
+	values := make(map[string][]byte, len(o.Data)+len(o.BinaryData))
+	for key, v := range o.Data {
+		if !k.binary {
+			values[key] = []byte(v)
+			continue
+		}
+
+		b, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("kubernetes: invalid value of %q: %s", key, err)
+		}
+
+		values[key] = b
+	}
+
+	for key, v := range o.BinaryData {
+		b, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("kubernetes: invalid value of %q: %s", key, err)
+		}
+
+		values[key] = b
+	}
+
+	return values, nil
+}
+
+// patch returns a JSON merge patch setting the given values, or removing
+// them if nil. The values of the ConfigMaps are stored in data if they are
+// valid UTF-8, or in binaryData otherwise.
+func (k *kind) patch(values map[string][]byte) map[string]interface{} {
+	data := make(map[string]interface{})
+	binaryData := make(map[string]interface{})
+	for key, v := range values {
+		switch {
+		case v == nil:
+			data[key], binaryData[key] = nil, nil
+		case k.binary:
+			data[key] = base64.StdEncoding.EncodeToString(v)
+		case utf8.Valid(v):
+			data[key], binaryData[key] = string(v), nil
+		default:
+			data[key], binaryData[key] = nil, base64.StdEncoding.EncodeToString(v)
+		}
+	}
+
+	if k.binary {
+		return map[string]interface{}{"data": data}
+	}
+
+	return map[string]interface{}{"data": data, "binaryData": binaryData}
+}
+
+// newObject returns a new object of the given kind with the given values.
+func (k *kind) newObject(name string, values map[string][]byte) *object {
+	o := &object{APIVersion: "v1", Kind: k.name, Metadata: objectMeta{Name: name}}
+	for key, v := range values {
+		if k.binary || !utf8.Valid(v) {
+			if o.BinaryData == nil {
+				o.BinaryData = make(map[string]string)
+			}
+
+			o.BinaryData[key] = base64.StdEncoding.EncodeToString(v)
+			continue
+		}
+
+		if o.Data == nil {
+			o.Data = make(map[string]string)
+		}
+
+		o.Data[key] = string(v)
+	}
+
+	if k.binary {
+		// Secrets have no binaryData, their data is always base64 encoded
+		o.Data, o.BinaryData = o.BinaryData, nil
+	}
+
+	return o
+}
+
+// keys returns the sorted keys of the given object.
+func keys(o *object) []string {
+	var keys []string
+	for key := range o.Data {
+		keys = append(keys, key)
+	}
+
+	for key := range o.BinaryData {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+func hasKey(o *object, key string) bool {
+	_, inData := o.Data[key]
+	_, inBinaryData := o.BinaryData[key]
+	return inData || inBinaryData
+}
+
+// call performs a request to the given path of the API, relative to the
+// resources of the namespace, with the given body encoded as JSON, decoding
+// the JSON response into v, if not nil.
+func (fs *Kube) call(method, p string, query url.Values, contentType string, body, v interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+
+		r = bytes.NewReader(b)
+	}
+
+	u := fs.opts.Endpoint + "/api/v1/namespaces/" + url.PathEscape(fs.opts.Namespace) + "/" + p
+	if len(query) != 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(method, u, r)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	res, err := fs.do(req)
+	if err != nil {
+		return err
+	}
+
+	defer closeBody(res)
+	if v == nil {
+		return nil
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// do performs the given request with the credentials, the response is
+// returned only if succeeded.
+func (fs *Kube) do(req *http.Request) (*http.Response, error) {
+	token, err := fs.bearerToken()
+	if err != nil {
+		return nil, err
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := fs.opts.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res, nil
+	}
+
+	defer closeBody(res)
+	return nil, statusError(res)
+}
+
+func statusError(res *http.Response) error {
+	switch res.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return os.ErrPermission
+	case http.StatusNotFound:
+		return os.ErrNotExist
+	}
+
+	var s status
+	if err := json.NewDecoder(res.Body).Decode(&s); err != nil || s.Message == "" {
+		return fmt.Errorf("kubernetes: unexpected status: %s", res.Status)
+	}
+
+	if s.Reason == "AlreadyExists" {
+		return os.ErrExist
+	}
+
+	return fmt.Errorf("kubernetes: %s", s.Message)
+}
+
+// closeBody closes the body of the given response, reading the part of the
+// object left by the decoder so the connection to the API server is reused.
+func closeBody(res *http.Response) {
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+}
+
+func (fs *Kube) get(k *kind, name string) (*object, error) {
+	o := &object{}
+	if err := fs.call(http.MethodGet, k.resource+"/"+url.PathEscape(name), nil, "", nil, o); err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+// list returns the objects of the given kind, following the continue token
+// until the listing is complete.
+func (fs *Kube) list(k *kind) ([]*object, error) {
+	var objects []*object
+	query := url.Values{"limit": {strconv.Itoa(fs.opts.PageSize)}}
+	for {
+		var l objectList
+		if err := fs.call(http.MethodGet, k.resource, query, "", nil, &l); err != nil {
+			return nil, err
+		}
+
+		objects = append(objects, l.Items...)
+		if l.Metadata.Continue == "" {
+			return objects, nil
+		}
+
+		query.Set("continue", l.Metadata.Continue)
+	}
+}
+
+func (fs *Kube) create(k *kind, name string, values map[string][]byte) error {
+	return fs.call(http.MethodPost, k.resource, nil, "application/json", k.newObject(name, values), nil)
+}
+
+// update sets the given values of the object, or removes them if nil, with a
+// merge patch, so the other values aren't modified.
+func (fs *Kube) update(k *kind, name string, values map[string][]byte) error {
+	return fs.call(http.MethodPatch, k.resource+"/"+url.PathEscape(name), nil, mergePatch, k.patch(values), nil)
+}
+
+func (fs *Kube) delete(k *kind, name string) error {
+	return fs.call(http.MethodDelete, k.resource+"/"+url.PathEscape(name), nil, "", nil, nil)
+}
+
+// put sets the given value of the object, creating the object if it doesn't
+// exist.
+func (fs *Kube) put(k *kind, name, key string, value []byte) error {
+	if value == nil {
+		value = []byte{}
+	}
+
+	values := map[string][]byte{key: value}
+	err := fs.update(k, name, values)
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	err = fs.create(k, name, values)
+	if os.IsExist(err) {
+		// created concurrently, since the update
+		return fs.update(k, name, values)
+	}
+
+	return err
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func newDirInfo(name string, o *object) *fileInfo {
+	fi := &fileInfo{name: name, mode: os.ModeDir | 0755}
+	if o != nil {
+		fi.modTime = creationTime(o)
+	}
+
+	return fi
+}
+
+func newFileInfo(k *kind, o *object, key string, size int64) *fileInfo {
+	mode := k.perm
+	if o.Immutable {
+		mode &^= 0222
+	}
+
+	return &fileInfo{name: key, size: size, mode: mode, modTime: creationTime(o)}
+}
+
+// creationTime returns the creation time of the object, since the API
+// doesn't keep the time of the last modification.
+func creationTime(o *object) time.Time {
+	t, _ := time.Parse(time.RFC3339, o.Metadata.CreationTimestamp)
+	return t
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.size
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	return fi.mode
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.mode.IsDir()
+}
+
+func (*fileInfo) Sys() interface{} {
+	return nil
+}
@@ -0,0 +1,49 @@
+package kubefs
+
+import (
+	"os"
+
+	"gopkg.in/src-d/go-billy.v4/internal/memfile"
+)
+
+// file is a key of an object. Its content is read when opened, and kept in
+// memory until closed, when it's written back if modified.
+type file struct {
+	*memfile.File
+
+	fs  *Kube
+	loc *location
+}
+
+func newFile(fs *Kube, l *location, name string, flag int) *file {
+	return &file{File: memfile.New(name, flag, nil), fs: fs, loc: l}
+}
+
+// Close writes the content of the key if it was modified.
+func (f *file) Close() error {
+	if f.Closed {
+		return os.ErrClosed
+	}
+
+	f.Closed = true
+	defer func() { f.Content = nil }()
+	if !f.Dirty {
+		return nil
+	}
+
+	if err := f.fs.put(f.loc.kind, f.loc.name, f.loc.key, f.Content); err != nil {
+		return &os.PathError{Op: "close", Path: f.Name(), Err: err}
+	}
+
+	return nil
+}
+
+// Lock is a no-op in Kubernetes.
+func (f *file) Lock() error {
+	return nil
+}
+
+// Unlock is a no-op in Kubernetes.
+func (f *file) Unlock() error {
+	return nil
+}
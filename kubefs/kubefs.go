@@ -0,0 +1,603 @@
+// Package kubefs provides a billy filesystem over the ConfigMaps and Secrets
+// of a Kubernetes namespace, with the REST API of the cluster.
+//
+// The root has two directories, configmaps and secrets, with a directory by
+// object, holding a file by key. The values of the ConfigMaps are written in
+// data if they are valid UTF-8, and in binaryData otherwise. The filesystem
+// is read-only, unless write-back is enabled with Options.Writable, then the
+// changes are applied with merge patches, so only the modified keys are
+// sent, and the objects are created and deleted with their directories.
+//
+// The content of the files is kept in memory, it's read when opened, and
+// written on Close if modified.
+package kubefs // import "gopkg.in/src-d/go-billy.v4/kubefs"
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/internal/pathutil"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const (
+	defaultEndpoint  = "https://kubernetes.default.svc"
+	defaultNamespace = "default"
+	defaultPageSize  = 500
+	// tokenRefresh is how often the token file is read again, since the
+	// tokens of the service accounts are rotated.
+	tokenRefresh = time.Minute
+)
+
+// serviceAccountDir is where the credentials of the service account are
+// mounted in the pods.
+var serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+var (
+	// ErrNotEmpty is returned when removing an object with keys.
+	ErrNotEmpty = billy.ErrNotEmpty
+
+	errIsDir  = errors.New("is a directory")
+	errNotDir = errors.New("not a directory")
+)
+
+// Options holds the configuration of a Kubernetes filesystem.
+type Options struct {
+	// Client sends the requests to the API server, http.DefaultClient if
+	// nil. It must trust the certificate of the server, as the one of the
+	// options returned by InClusterOptions.
+	Client *http.Client
+	// Endpoint is the base URL of the API server,
+	// https://kubernetes.default.svc by default.
+	Endpoint string
+	// Token is the bearer token sent in every request, it may be empty if
+	// the Client adds the credentials.
+	Token string
+	// TokenFile is a file with the bearer token, read again every minute,
+	// it takes precedence over Token.
+	TokenFile string
+	// Namespace is the namespace of the objects, "default" by default.
+	Namespace string
+	// PageSize is the number of objects requested by page when listing,
+	// 500 by default.
+	PageSize int
+	// Writable enables the write-back of the changes through the API,
+	// otherwise the filesystem is read-only.
+	Writable bool
+}
+
+// InClusterOptions returns the options to access the API from a pod, with
+// the credentials of its service account, in its namespace.
+func InClusterOptions() (Options, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return Options{}, errors.New("kubernetes: not running in a cluster")
+	}
+
+	ca, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return Options{}, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return Options{}, errors.New("kubernetes: invalid CA certificate")
+	}
+
+	namespace, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+	if err != nil {
+		return Options{}, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+
+	return Options{
+		Client:    &http.Client{Transport: transport},
+		Endpoint:  "https://" + net.JoinHostPort(host, port),
+		TokenFile: filepath.Join(serviceAccountDir, "token"),
+		Namespace: strings.TrimSpace(string(namespace)),
+	}, nil
+}
+
+// Kube is a filesystem over the ConfigMaps and Secrets of a namespace.
+type Kube struct {
+	opts Options
+
+	mu        sync.Mutex
+	token     string
+	tokenRead time.Time
+}
+
+// New returns a new filesystem over the ConfigMaps and Secrets of a
+// namespace.
+func New(opts Options) *Kube {
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+
+	if opts.Endpoint == "" {
+		opts.Endpoint = defaultEndpoint
+	}
+
+	if opts.Namespace == "" {
+		opts.Namespace = defaultNamespace
+	}
+
+	if opts.PageSize <= 0 {
+		opts.PageSize = defaultPageSize
+	}
+
+	opts.Endpoint = strings.TrimSuffix(opts.Endpoint, "/")
+	return &Kube{opts: opts}
+}
+
+// bearerToken returns the token to authenticate the requests, reading the
+// token file if it wasn't read recently.
+func (fs *Kube) bearerToken() (string, error) {
+	if fs.opts.TokenFile == "" {
+		return fs.opts.Token, nil
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if !fs.tokenRead.IsZero() && time.Since(fs.tokenRead) < tokenRefresh {
+		return fs.token, nil
+	}
+
+	b, err := ioutil.ReadFile(fs.opts.TokenFile)
+	if err != nil {
+		return "", err
+	}
+
+	fs.token, fs.tokenRead = strings.TrimSpace(string(b)), time.Now()
+	return fs.token, nil
+}
+
+// location is a path of the filesystem: the root, the directory of a kind,
+// an object or one of its keys, depending on its depth.
+type location struct {
+	kind  *kind
+	name  string
+	key   string
+	depth int
+}
+
+func parse(filename string) (*location, error) {
+	p := strings.Trim(path.Clean("/"+filepath.ToSlash(filename)), "/")
+	if p == "" {
+		return &location{}, nil
+	}
+
+	parts := strings.Split(p, "/")
+	l := &location{kind: kindByResource(parts[0]), depth: len(parts)}
+	if l.kind == nil {
+		return nil, os.ErrNotExist
+	}
+
+	if l.depth > 1 {
+		l.name = parts[1]
+	}
+
+	if l.depth > 2 {
+		l.key = parts[2]
+	}
+
+	return l, nil
+}
+
+func (fs *Kube) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Kube) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given key, creating it if needed, and its object. The
+// permissions are ignored.
+func (fs *Kube) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if !fs.opts.Writable && (isWrite(flag) || flag&os.O_CREATE != 0) {
+		return nil, billy.ErrReadOnly
+	}
+
+	f, err := fs.openFile(filename, flag)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+	}
+
+	return f, nil
+}
+
+func (fs *Kube) openFile(filename string, flag int) (*file, error) {
+	l, err := parse(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case l.depth < 2:
+		return nil, errIsDir
+	case l.depth == 2:
+		if _, err := fs.get(l.kind, l.name); err != nil {
+			return nil, err
+		}
+
+		return nil, errIsDir
+	case l.depth > 3:
+		return nil, os.ErrNotExist
+	}
+
+	f := newFile(fs, l, pathutil.Relative(filename), flag)
+	o, err := fs.get(l.kind, l.name)
+	switch {
+	case err == nil && hasKey(o, l.key):
+		if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+			return nil, os.ErrExist
+		}
+
+		values, err := l.kind.values(o)
+		if err != nil {
+			return nil, err
+		}
+
+		f.Content = values[l.key]
+		if flag&os.O_TRUNC != 0 && isWrite(flag) && len(f.Content) != 0 {
+			if err := fs.put(l.kind, l.name, l.key, nil); err != nil {
+				return nil, err
+			}
+
+			f.Content = nil
+		}
+
+		return f, nil
+	case (err == nil || os.IsNotExist(err)) && flag&os.O_CREATE != 0:
+		if err := fs.put(l.kind, l.name, l.key, nil); err != nil {
+			return nil, err
+		}
+
+		return f, nil
+	case err == nil:
+		return nil, os.ErrNotExist
+	default:
+		return nil, err
+	}
+}
+
+func (fs *Kube) Stat(filename string) (os.FileInfo, error) {
+	fi, err := fs.stat(filename)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: filename, Err: err}
+	}
+
+	return fi, nil
+}
+
+func (fs *Kube) stat(filename string) (os.FileInfo, error) {
+	l, err := parse(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	switch l.depth {
+	case 0:
+		return newDirInfo(string(filepath.Separator), nil), nil
+	case 1:
+		return newDirInfo(l.kind.resource, nil), nil
+	case 2, 3:
+	default:
+		return nil, os.ErrNotExist
+	}
+
+	o, err := fs.get(l.kind, l.name)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.depth == 2 {
+		return newDirInfo(l.name, o), nil
+	}
+
+	values, err := l.kind.values(o)
+	if err != nil {
+		return nil, err
+	}
+
+	value, ok := values[l.key]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	return newFileInfo(l.kind, o, l.key, int64(len(value))), nil
+}
+
+// Lstat is equivalent to Stat, the symlinks are not supported.
+func (fs *Kube) Lstat(filename string) (os.FileInfo, error) {
+	return fs.Stat(filename)
+}
+
+// ReadDir lists the kinds in the root, the objects of a kind, or the keys
+// of an object.
+func (fs *Kube) ReadDir(filename string) ([]os.FileInfo, error) {
+	infos, err := fs.readDir(filename)
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: filename, Err: err}
+	}
+
+	return infos, nil
+}
+
+func (fs *Kube) readDir(filename string) ([]os.FileInfo, error) {
+	l, err := parse(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	switch l.depth {
+	case 0:
+		var infos []os.FileInfo
+		for _, k := range kinds {
+			infos = append(infos, newDirInfo(k.resource, nil))
+		}
+
+		return infos, nil
+	case 1:
+		objects, err := fs.list(l.kind)
+		if err != nil {
+			return nil, err
+		}
+
+		infos := make([]os.FileInfo, 0, len(objects))
+		for _, o := range objects {
+			infos = append(infos, newDirInfo(o.Metadata.Name, o))
+		}
+
+		return infos, nil
+	case 2:
+		o, err := fs.get(l.kind, l.name)
+		if err != nil {
+			return nil, err
+		}
+
+		values, err := l.kind.values(o)
+		if err != nil {
+			return nil, err
+		}
+
+		var infos []os.FileInfo
+		for _, key := range keys(o) {
+			infos = append(infos, newFileInfo(l.kind, o, key, int64(len(values[key]))))
+		}
+
+		return infos, nil
+	}
+
+	if _, err := fs.stat(filename); err != nil {
+		return nil, err
+	}
+
+	return nil, errNotDir
+}
+
+// Rename moves a key, to another key of the same or any other object, or
+// an object, copying it to a new object before deleting it. The key of the
+// destination is replaced if it exists, but not the object.
+func (fs *Kube) Rename(from, to string) error {
+	if !fs.opts.Writable {
+		return billy.ErrReadOnly
+	}
+
+	if err := fs.rename(from, to); err != nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Kube) rename(from, to string) error {
+	src, err := parse(from)
+	if err != nil {
+		return err
+	}
+
+	dst, err := parse(to)
+	if err != nil {
+		return err
+	}
+
+	if src.depth < 2 || src.depth > 3 || src.depth != dst.depth {
+		return billy.ErrNotSupported
+	}
+
+	o, err := fs.get(src.kind, src.name)
+	if err != nil {
+		return err
+	}
+
+	values, err := src.kind.values(o)
+	if err != nil {
+		return err
+	}
+
+	if *src == *dst {
+		if src.depth == 3 && !hasKey(o, src.key) {
+			return os.ErrNotExist
+		}
+
+		return nil
+	}
+
+	if src.depth == 2 {
+		if err := fs.create(dst.kind, dst.name, values); err != nil {
+			return err
+		}
+
+		return fs.delete(src.kind, src.name)
+	}
+
+	value, ok := values[src.key]
+	if !ok {
+		return os.ErrNotExist
+	}
+
+	if src.kind == dst.kind && src.name == dst.name {
+		return fs.update(src.kind, src.name, map[string][]byte{dst.key: value, src.key: nil})
+	}
+
+	if err := fs.put(dst.kind, dst.name, dst.key, value); err != nil {
+		return err
+	}
+
+	return fs.update(src.kind, src.name, map[string][]byte{src.key: nil})
+}
+
+// Remove deletes the given key, or object without keys.
+func (fs *Kube) Remove(filename string) error {
+	if !fs.opts.Writable {
+		return billy.ErrReadOnly
+	}
+
+	if err := fs.remove(filename); err != nil {
+		return &os.PathError{Op: "remove", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Kube) remove(filename string) error {
+	l, err := parse(filename)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case l.depth < 2:
+		// the directories of the kinds always exist
+		return billy.ErrNotSupported
+	case l.depth > 3:
+		return os.ErrNotExist
+	}
+
+	o, err := fs.get(l.kind, l.name)
+	if err != nil {
+		return err
+	}
+
+	if l.depth == 2 {
+		if len(keys(o)) != 0 {
+			return ErrNotEmpty
+		}
+
+		return fs.delete(l.kind, l.name)
+	}
+
+	if !hasKey(o, l.key) {
+		return os.ErrNotExist
+	}
+
+	return fs.update(l.kind, l.name, map[string][]byte{l.key: nil})
+}
+
+// MkdirAll creates the given object, without keys. The objects can't have
+// directories, and the permissions are ignored.
+func (fs *Kube) MkdirAll(filename string, perm os.FileMode) error {
+	if !fs.opts.Writable {
+		return billy.ErrReadOnly
+	}
+
+	if err := fs.mkdirAll(filename); err != nil {
+		return &os.PathError{Op: "mkdir", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Kube) mkdirAll(filename string) error {
+	l, err := parse(filename)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case l.depth < 2:
+		return nil
+	case l.depth > 2:
+		if _, err := fs.stat(filename); err == nil {
+			return errNotDir
+		}
+
+		return billy.ErrNotSupported
+	}
+
+	_, err = fs.get(l.kind, l.name)
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	err = fs.create(l.kind, l.name, nil)
+	if os.IsExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+func (fs *Kube) Symlink(target, link string) error {
+	if !fs.opts.Writable {
+		return billy.ErrReadOnly
+	}
+
+	return billy.ErrNotSupported
+}
+
+func (fs *Kube) Readlink(link string) (string, error) {
+	return "", billy.ErrNotSupported
+}
+
+func (fs *Kube) TempFile(dir, prefix string) (billy.File, error) {
+	if !fs.opts.Writable {
+		return nil, billy.ErrReadOnly
+	}
+
+	return util.TempFile(fs, dir, prefix)
+}
+
+func (fs *Kube) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (fs *Kube) Chroot(p string) (billy.Filesystem, error) {
+	return chroot.New(fs, fs.Join(fs.Root(), p)), nil
+}
+
+func (fs *Kube) Root() string {
+	return string(filepath.Separator)
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Kube) Capabilities() billy.Capability {
+	if !fs.opts.Writable {
+		return billy.ReadCapability | billy.SeekCapability
+	}
+
+	return billy.WriteCapability | billy.ReadCapability |
+		billy.ReadAndWriteCapability | billy.SeekCapability |
+		billy.TruncateCapability
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR) != 0
+}
@@ -0,0 +1,346 @@
+package kubefs
+
+import (
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&KubeSuite{})
+
+type KubeSuite struct {
+	FS     *Kube
+	server *server
+}
+
+func (s *KubeSuite) SetUpTest(c *C) {
+	s.server = newServer(false)
+	s.FS = s.newFS(Options{Writable: true})
+
+	s.server.add(configMaps.resource, &object{
+		Metadata:   objectMeta{Name: "app"},
+		Data:       map[string]string{"config.yaml": "debug: true"},
+		BinaryData: map[string]string{"logo.png": base64.StdEncoding.EncodeToString([]byte{0x89, 'P', 'N', 'G'})},
+	})
+
+	s.server.add(configMaps.resource, &object{
+		Metadata:  objectMeta{Name: "frozen"},
+		Data:      map[string]string{"foo": "bar"},
+		Immutable: true,
+	})
+
+	s.server.add(secrets.resource, &object{
+		Metadata: objectMeta{Name: "db"},
+		Data:     map[string]string{"password": base64.StdEncoding.EncodeToString([]byte("s3cr3t"))},
+	})
+}
+
+func (s *KubeSuite) TearDownTest(c *C) {
+	s.server.Close()
+}
+
+func (s *KubeSuite) newFS(opts Options) *Kube {
+	opts.Endpoint = s.server.URL
+	opts.Namespace = testNamespace
+	return New(opts)
+}
+
+func (s *KubeSuite) TestReadDir(c *C) {
+	entries, err := s.FS.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(names(entries), DeepEquals, []string{"configmaps", "secrets"})
+
+	entries, err = s.FS.ReadDir("configmaps")
+	c.Assert(err, IsNil)
+	c.Assert(names(entries), DeepEquals, []string{"app", "frozen"})
+	c.Assert(entries[0].IsDir(), Equals, true)
+	c.Assert(entries[0].ModTime().Equal(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)), Equals, true)
+
+	entries, err = s.FS.ReadDir("configmaps/app")
+	c.Assert(err, IsNil)
+	c.Assert(names(entries), DeepEquals, []string{"config.yaml", "logo.png"})
+	c.Assert(entries[0].Size(), Equals, int64(11))
+	c.Assert(entries[1].Size(), Equals, int64(4))
+
+	_, err = s.FS.ReadDir("configmaps/app/config.yaml")
+	c.Assert(err, NotNil)
+
+	_, err = s.FS.ReadDir("pods")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *KubeSuite) TestReadDirPages(c *C) {
+	fs := s.newFS(Options{PageSize: 1})
+	entries, err := fs.ReadDir("configmaps")
+	c.Assert(err, IsNil)
+	c.Assert(names(entries), DeepEquals, []string{"app", "frozen"})
+	c.Assert(s.server.count("GET"), Equals, 2)
+}
+
+func (s *KubeSuite) TestRead(c *C) {
+	c.Assert(test.ReadFile(c, s.FS, "configmaps/app/config.yaml"), Equals, "debug: true")
+	c.Assert(test.ReadFile(c, s.FS, "configmaps/app/logo.png"), Equals, "\x89PNG")
+	c.Assert(test.ReadFile(c, s.FS, "secrets/db/password"), Equals, "s3cr3t")
+
+	_, err := s.FS.Open("configmaps/app/nope")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	_, err = s.FS.Open("configmaps/nope/foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	_, err = s.FS.Open("configmaps/app")
+	c.Assert(err, NotNil)
+}
+
+func (s *KubeSuite) TestStat(c *C) {
+	fi, err := s.FS.Stat("secrets/db/password")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Name(), Equals, "password")
+	c.Assert(fi.Size(), Equals, int64(6))
+	c.Assert(fi.Mode(), Equals, os.FileMode(0600))
+
+	fi, err = s.FS.Stat("configmaps/app/config.yaml")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode(), Equals, os.FileMode(0644))
+
+	fi, err = s.FS.Stat("configmaps/frozen/foo")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode(), Equals, os.FileMode(0444))
+
+	fi, err = s.FS.Stat("secrets")
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+
+	fi, err = s.FS.Stat("/")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Name(), Equals, string(filepath.Separator))
+
+	for _, name := range []string{"pods", "configmaps/nope", "configmaps/app/nope", "configmaps/app/config.yaml/foo"} {
+		_, err = s.FS.Stat(name)
+		c.Assert(os.IsNotExist(err), Equals, true, Commentf("%s", name))
+	}
+}
+
+func (s *KubeSuite) TestWrite(c *C) {
+	c.Assert(util.WriteFile(s.FS, "configmaps/app/config.yaml", []byte("debug: false"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "configmaps/app/data.bin", []byte{0xff, 0x00}, 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "configmaps/app/logo.png", []byte("text now"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "secrets/db/user", []byte("admin"), 0644), IsNil)
+
+	o := s.server.object(configMaps.resource, "app")
+	c.Assert(o.Data, DeepEquals, map[string]string{"config.yaml": "debug: false", "logo.png": "text now"})
+	c.Assert(o.BinaryData, DeepEquals, map[string]string{"data.bin": "/wA="})
+
+	o = s.server.object(secrets.resource, "db")
+	c.Assert(o.Data["user"], Equals, base64.StdEncoding.EncodeToString([]byte("admin")))
+	c.Assert(o.Data["password"], Equals, base64.StdEncoding.EncodeToString([]byte("s3cr3t")))
+
+	c.Assert(test.ReadFile(c, s.FS, "configmaps/app/data.bin"), Equals, "\xff\x00")
+}
+
+func (s *KubeSuite) TestWriteOnClose(c *C) {
+	f, err := s.FS.OpenFile("configmaps/app/config.yaml", os.O_RDWR|os.O_APPEND, 0)
+	c.Assert(err, IsNil)
+
+	_, err = f.Write([]byte("\nverbose: true"))
+	c.Assert(err, IsNil)
+	c.Assert(s.server.count("PATCH"), Equals, 0)
+	c.Assert(f.Close(), IsNil)
+	c.Assert(s.server.count("PATCH"), Equals, 1)
+
+	c.Assert(test.ReadFile(c, s.FS, "configmaps/app/config.yaml"), Equals, "debug: true\nverbose: true")
+}
+
+func (s *KubeSuite) TestCreate(c *C) {
+	f, err := s.FS.Create("secrets/new/token")
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("foo"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	o := s.server.object(secrets.resource, "new")
+	c.Assert(o, NotNil)
+	c.Assert(o.Data, DeepEquals, map[string]string{"token": "Zm9v"})
+
+	_, err = s.FS.OpenFile("secrets/new/token", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0)
+	c.Assert(os.IsExist(err), Equals, true)
+
+	_, err = s.FS.Create("configmaps/app")
+	c.Assert(err, NotNil)
+}
+
+func (s *KubeSuite) TestImmutable(c *C) {
+	err := util.WriteFile(s.FS, "configmaps/frozen/foo", []byte("baz"), 0644)
+	c.Assert(err, ErrorMatches, ".*immutable.*")
+	c.Assert(test.ReadFile(c, s.FS, "configmaps/frozen/foo"), Equals, "bar")
+}
+
+func (s *KubeSuite) TestMkdirAllAndRemove(c *C) {
+	c.Assert(s.FS.MkdirAll("configmaps/empty", 0755), IsNil)
+	c.Assert(s.FS.MkdirAll("configmaps/empty", 0755), IsNil)
+	c.Assert(s.FS.MkdirAll("configmaps", 0755), IsNil)
+	c.Assert(s.server.object(configMaps.resource, "empty"), NotNil)
+
+	c.Assert(s.FS.MkdirAll("configmaps/app/dir", 0755), ErrorMatches, ".*not supported")
+	_, err := s.FS.ReadDir("configmaps/app/dir")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	err = s.FS.MkdirAll("configmaps/app/config.yaml", 0755)
+	c.Assert(err, ErrorMatches, ".*not a directory")
+
+	c.Assert(s.FS.Remove("configmaps/empty"), IsNil)
+	c.Assert(s.server.object(configMaps.resource, "empty"), IsNil)
+
+	c.Assert(s.FS.Remove("secrets/db"), ErrorMatches, ".*directory not empty")
+	c.Assert(s.FS.Remove("secrets/db/password"), IsNil)
+	c.Assert(s.FS.Remove("secrets/db"), IsNil)
+
+	err = s.FS.Remove("configmaps/app/nope")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	c.Assert(s.FS.Remove("configmaps"), NotNil)
+}
+
+func (s *KubeSuite) TestRename(c *C) {
+	c.Assert(s.FS.Rename("configmaps/app/config.yaml", "configmaps/app/app.yaml"), IsNil)
+	c.Assert(s.server.count("PATCH"), Equals, 1)
+
+	entries, err := s.FS.ReadDir("configmaps/app")
+	c.Assert(err, IsNil)
+	c.Assert(names(entries), DeepEquals, []string{"app.yaml", "logo.png"})
+
+	c.Assert(s.FS.Rename("configmaps/app/logo.png", "secrets/db/logo.png"), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "secrets/db/logo.png"), Equals, "\x89PNG")
+
+	c.Assert(s.FS.Rename("configmaps/app", "configmaps/renamed"), IsNil)
+	c.Assert(s.server.object(configMaps.resource, "app"), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "configmaps/renamed/app.yaml"), Equals, "debug: true")
+
+	err = s.FS.Rename("configmaps/renamed", "configmaps/frozen")
+	c.Assert(err, NotNil)
+
+	err = s.FS.Rename("configmaps/renamed/nope", "configmaps/renamed/foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	err = s.FS.Rename("configmaps/renamed/app.yaml", "configmaps/foo")
+	c.Assert(err, NotNil)
+}
+
+func (s *KubeSuite) TestReadOnly(c *C) {
+	fs := s.newFS(Options{})
+	c.Assert(test.ReadFile(c, fs, "configmaps/app/config.yaml"), Equals, "debug: true")
+
+	_, err := fs.Create("configmaps/app/new")
+	c.Assert(err, Equals, billy.ErrReadOnly)
+
+	_, err = fs.OpenFile("configmaps/app/config.yaml", os.O_RDWR, 0)
+	c.Assert(err, Equals, billy.ErrReadOnly)
+
+	c.Assert(fs.Remove("configmaps/app/config.yaml"), Equals, billy.ErrReadOnly)
+	c.Assert(fs.Rename("configmaps/app", "configmaps/foo"), Equals, billy.ErrReadOnly)
+	c.Assert(fs.MkdirAll("configmaps/foo", 0755), Equals, billy.ErrReadOnly)
+
+	c.Assert(billy.CapabilityCheck(fs, billy.WriteCapability), Equals, false)
+	c.Assert(billy.CapabilityCheck(fs, billy.ReadCapability), Equals, true)
+	c.Assert(s.server.count("PATCH")+s.server.count("POST"), Equals, 0)
+}
+
+func (s *KubeSuite) TestChroot(c *C) {
+	fs, err := s.FS.Chroot("configmaps/app")
+	c.Assert(err, IsNil)
+	c.Assert(test.ReadFile(c, fs, "config.yaml"), Equals, "debug: true")
+}
+
+func (s *KubeSuite) TestToken(c *C) {
+	s.server.token = "token"
+	_, err := s.FS.Stat("configmaps/app")
+	c.Assert(os.IsPermission(err), Equals, true)
+
+	fs := s.newFS(Options{Token: "token"})
+	_, err = fs.Stat("configmaps/app")
+	c.Assert(err, IsNil)
+
+	dir, err := ioutil.TempDir("", "kubefs")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	tokenFile := filepath.Join(dir, "token")
+	c.Assert(ioutil.WriteFile(tokenFile, []byte("token\n"), 0600), IsNil)
+
+	fs = s.newFS(Options{TokenFile: tokenFile})
+	_, err = fs.Stat("configmaps/app")
+	c.Assert(err, IsNil)
+}
+
+func (s *KubeSuite) TestInClusterOptions(c *C) {
+	srv := newServer(true)
+	defer srv.Close()
+	srv.token = "token"
+	srv.add(configMaps.resource, &object{Metadata: objectMeta{Name: "foo"}})
+
+	dir, err := ioutil.TempDir("", "kubefs")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "ca.crt"), ca, 0600), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "token"), []byte("token"), 0600), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "namespace"), []byte(testNamespace), 0600), IsNil)
+
+	defer func(old string) { serviceAccountDir = old }(serviceAccountDir)
+	serviceAccountDir = dir
+
+	host, port, err := net.SplitHostPort(srv.Listener.Addr().String())
+	c.Assert(err, IsNil)
+	defer setenv("KUBERNETES_SERVICE_HOST", host)()
+	defer setenv("KUBERNETES_SERVICE_PORT", port)()
+
+	opts, err := InClusterOptions()
+	c.Assert(err, IsNil)
+	c.Assert(opts.Namespace, Equals, testNamespace)
+
+	entries, err := New(opts).ReadDir("configmaps")
+	c.Assert(err, IsNil)
+	c.Assert(names(entries), DeepEquals, []string{"foo"})
+
+	restore := setenv("KUBERNETES_SERVICE_HOST", "")
+	defer restore()
+	_, err = InClusterOptions()
+	c.Assert(err, NotNil)
+}
+
+// setenv sets the given environment variable, returning a function to
+// restore its value.
+func setenv(key, value string) func() {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	return func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+func names(entries []os.FileInfo) []string {
+	var names []string
+	for _, fi := range entries {
+		names = append(names, fi.Name())
+	}
+
+	return names
+}
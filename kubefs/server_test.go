@@ -0,0 +1,268 @@
+package kubefs
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	testNamespace = "test"
+	testTime      = "2018-01-01T00:00:00Z"
+)
+
+// server is a minimal API server with the ConfigMaps and Secrets of a
+// namespace, to test the client. It supports the requests used by the client
+// only, and validates the values the same way Kubernetes does.
+type server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	token    string
+	objects  map[string]map[string]*object
+	requests map[string]int
+}
+
+func newServer(tls bool) *server {
+	s := &server{
+		objects: map[string]map[string]*object{
+			configMaps.resource: make(map[string]*object),
+			secrets.resource:    make(map[string]*object),
+		},
+		requests: make(map[string]int),
+	}
+
+	if tls {
+		s.Server = httptest.NewTLSServer(http.HandlerFunc(s.handle))
+	} else {
+		s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	}
+
+	return s
+}
+
+// add stores the given object, of the given resource.
+func (s *server) add(resource string, o *object) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o.Metadata.CreationTimestamp = testTime
+	s.objects[resource][o.Metadata.Name] = o
+}
+
+// object returns the stored object, or nil if it doesn't exist.
+func (s *server) object(resource, name string) *object {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.objects[resource][name]
+}
+
+// count returns the number of requests with the given method, eg.: "PATCH".
+func (s *server) count(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests[method]
+}
+
+func (s *server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests[r.Method]++
+	if s.token != "" && r.Header.Get("Authorization") != "Bearer "+s.token {
+		writeStatus(w, http.StatusUnauthorized, "Unauthorized", "Unauthorized")
+		return
+	}
+
+	prefix := "/api/v1/namespaces/" + testNamespace + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		writeStatus(w, http.StatusNotFound, "NotFound", "the server could not find the requested resource")
+		return
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, prefix), "/", 2)
+	objects, ok := s.objects[parts[0]]
+	if !ok {
+		writeStatus(w, http.StatusNotFound, "NotFound", "the server could not find the requested resource")
+		return
+	}
+
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			s.list(w, r, objects)
+		case http.MethodPost:
+			s.create(w, r, parts[0], objects)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+
+		return
+	}
+
+	name := parts[1]
+	o, ok := objects[name]
+	if !ok {
+		writeStatus(w, http.StatusNotFound, "NotFound", fmt.Sprintf("%s %q not found", parts[0], name))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, o)
+	case http.MethodPatch:
+		s.patch(w, r, parts[0], o)
+	case http.MethodDelete:
+		delete(objects, name)
+		writeStatus(w, http.StatusOK, "", "")
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *server) list(w http.ResponseWriter, r *http.Request, objects map[string]*object) {
+	var names []string
+	for name := range objects {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	start, _ := strconv.Atoi(r.URL.Query().Get("continue"))
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	var l objectList
+	for i := start; i < len(names); i++ {
+		if limit != 0 && len(l.Items) == limit {
+			l.Metadata.Continue = strconv.Itoa(i)
+			break
+		}
+
+		l.Items = append(l.Items, objects[names[i]])
+	}
+
+	writeJSON(w, http.StatusOK, &l)
+}
+
+func (s *server) create(w http.ResponseWriter, r *http.Request, resource string, objects map[string]*object) {
+	o := &object{}
+	if err := json.NewDecoder(r.Body).Decode(o); err != nil {
+		writeStatus(w, http.StatusBadRequest, "BadRequest", err.Error())
+		return
+	}
+
+	if _, ok := objects[o.Metadata.Name]; ok {
+		writeStatus(w, http.StatusConflict, "AlreadyExists", fmt.Sprintf("%s %q already exists", resource, o.Metadata.Name))
+		return
+	}
+
+	if err := validate(resource, o); err != nil {
+		writeStatus(w, http.StatusUnprocessableEntity, "Invalid", err.Error())
+		return
+	}
+
+	o.Metadata.Namespace = testNamespace
+	o.Metadata.CreationTimestamp = testTime
+	objects[o.Metadata.Name] = o
+	writeJSON(w, http.StatusCreated, o)
+}
+
+func (s *server) patch(w http.ResponseWriter, r *http.Request, resource string, o *object) {
+	if r.Header.Get("Content-Type") != mergePatch {
+		writeStatus(w, http.StatusUnsupportedMediaType, "UnsupportedMediaType", "unsupported patch type")
+		return
+	}
+
+	if o.Immutable {
+		writeStatus(w, http.StatusUnprocessableEntity, "Invalid", "field is immutable when `immutable` is set")
+		return
+	}
+
+	var p map[string]map[string]*string
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		writeStatus(w, http.StatusBadRequest, "BadRequest", err.Error())
+		return
+	}
+
+	patched := *o
+	patched.Data = mergeValues(o.Data, p["data"])
+	patched.BinaryData = mergeValues(o.BinaryData, p["binaryData"])
+	if err := validate(resource, &patched); err != nil {
+		writeStatus(w, http.StatusUnprocessableEntity, "Invalid", err.Error())
+		return
+	}
+
+	*o = patched
+	writeJSON(w, http.StatusOK, o)
+}
+
+func mergeValues(values map[string]string, patch map[string]*string) map[string]string {
+	merged := make(map[string]string)
+	for k, v := range values {
+		merged[k] = v
+	}
+
+	for k, v := range patch {
+		if v == nil {
+			delete(merged, k)
+			continue
+		}
+
+		merged[k] = *v
+	}
+
+	return merged
+}
+
+func validate(resource string, o *object) error {
+	if o.Metadata.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+
+	for key := range o.BinaryData {
+		if _, ok := o.Data[key]; ok {
+			return fmt.Errorf("duplicate of key present in data: %s", key)
+		}
+	}
+
+	check := []map[string]string{o.BinaryData}
+	if resource == secrets.resource {
+		if len(o.BinaryData) != 0 {
+			return fmt.Errorf("unknown field binaryData")
+		}
+
+		check = append(check, o.Data)
+	}
+
+	for _, values := range check {
+		for key, v := range values {
+			if _, err := base64.StdEncoding.DecodeString(v); err != nil {
+				return fmt.Errorf("illegal base64 data for %s", key)
+			}
+		}
+	}
+
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeStatus(w http.ResponseWriter, code int, reason, message string) {
+	writeJSON(w, code, map[string]interface{}{
+		"kind":    "Status",
+		"reason":  reason,
+		"message": message,
+		"code":    code,
+	})
+}
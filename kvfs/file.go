@@ -0,0 +1,206 @@
+//go:build !js
+// +build !js
+
+package kvfs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+
+	"gopkg.in/src-d/go-billy.v4/internal/memfile"
+)
+
+// file is a file of a KV filesystem opened for writing, its content is kept
+// in memory until closed, when it's written if modified.
+type file struct {
+	*memfile.File
+
+	fs   *KV
+	key  string
+	mode os.FileMode
+}
+
+// Close writes the content of the file if it was modified.
+func (f *file) Close() error {
+	if f.Closed {
+		return os.ErrClosed
+	}
+
+	f.Closed = true
+
+	var err error
+	if f.Dirty {
+		err = f.fs.store(f.key, f.Content)
+	}
+
+	f.Content = nil
+	return err
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	return &fileInfo{
+		name:    filepath.Base(f.Name()),
+		size:    int64(len(f.Content)),
+		mode:    f.mode,
+		modTime: time.Now(),
+	}, nil
+}
+
+// Lock is a no-op in kvfs.
+func (f *file) Lock() error {
+	return nil
+}
+
+// Unlock is a no-op in kvfs.
+func (f *file) Unlock() error {
+	return nil
+}
+
+// reader is a file of a KV filesystem opened only for reading, it reads the
+// blocks as needed from a snapshot of the database, released when closed.
+type reader struct {
+	name string
+	key  string
+	node *node
+	snap *leveldb.Snapshot
+
+	position int64
+	isClosed bool
+}
+
+func (r *reader) Name() string {
+	return r.name
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.ReadAt(p, r.position)
+	r.position += int64(n)
+
+	if err == io.EOF && n != 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+func (r *reader) ReadAt(p []byte, off int64) (int, error) {
+	if r.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: r.name, Err: errors.New("negative offset")}
+	}
+
+	var n int
+	for n < len(p) && off < r.node.size {
+		size := int64(r.node.blockSize)
+		block, err := r.snap.Get(blockKey(r.key, int(off/size)), nil)
+		if err != nil {
+			return n, &os.PathError{Op: "read", Path: r.name, Err: err}
+		}
+
+		c := copy(p[n:], block[off%size:])
+		n += c
+		off += int64(c)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (r *reader) Seek(offset int64, whence int) (int64, error) {
+	if r.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.position
+	case io.SeekEnd:
+		offset += r.node.size
+	}
+
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: r.name, Err: errors.New("negative offset")}
+	}
+
+	r.position = offset
+	return r.position, nil
+}
+
+func (r *reader) Write(p []byte) (int, error) {
+	return 0, errors.New("write not supported")
+}
+
+func (r *reader) Truncate(size int64) error {
+	return errors.New("truncate not supported")
+}
+
+// Close releases the snapshot of the database.
+func (r *reader) Close() error {
+	if r.isClosed {
+		return os.ErrClosed
+	}
+
+	r.isClosed = true
+	r.snap.Release()
+	return nil
+}
+
+func (r *reader) Stat() (os.FileInfo, error) {
+	return newFileInfo(filepath.Base(r.name), r.node), nil
+}
+
+// Lock is a no-op in kvfs.
+func (r *reader) Lock() error {
+	return nil
+}
+
+// Unlock is a no-op in kvfs.
+func (r *reader) Unlock() error {
+	return nil
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func newFileInfo(name string, n *node) *fileInfo {
+	return &fileInfo{name: name, size: n.size, mode: n.mode, modTime: n.modTime}
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.size
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	return fi.mode
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.mode.IsDir()
+}
+
+func (*fileInfo) Sys() interface{} {
+	return nil
+}
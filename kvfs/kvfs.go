@@ -0,0 +1,731 @@
+//go:build !js
+// +build !js
+
+// Package kvfs provides a billy filesystem stored in a LevelDB database,
+// suited for large amounts of small files, eg.: loose objects of a
+// repository.
+//
+// The metadata of every file, directory and symlink is stored with its path
+// as key, so the listings of the directories are read with prefix
+// iterators. The content of the files is split in blocks, each one stored
+// with the path and the number of the block as key. Every mutation is
+// written in a single batch, so it's applied atomically.
+//
+// It isn't built for js/wasm, where LevelDB isn't supported.
+package kvfs // import "gopkg.in/src-d/go-billy.v4/kvfs"
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	ldbutil "github.com/syndtr/goleveldb/leveldb/util"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/internal/linkutil"
+	"gopkg.in/src-d/go-billy.v4/internal/memfile"
+	"gopkg.in/src-d/go-billy.v4/internal/pathutil"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const (
+	defaultBlockSize = 64 * 1024
+	nodeSize         = 24
+
+	metaPrefix  = 'm'
+	blockPrefix = 'b'
+)
+
+var (
+	// ErrNotEmpty is returned when removing a directory with files.
+	ErrNotEmpty = billy.ErrNotEmpty
+	// ErrCorrupted is returned when the metadata of a file stored in the
+	// database can't be decoded.
+	ErrCorrupted = errors.New("corrupted metadata")
+
+	errNotDir = errors.New("not a directory")
+)
+
+// Options holds the configuration of a KV filesystem.
+type Options struct {
+	// BlockSize is the size of the blocks the content of the files written
+	// is split in, 64KiB by default. The files already stored keep the
+	// block size they were written with.
+	BlockSize int
+}
+
+// KV is a filesystem stored in a LevelDB database.
+type KV struct {
+	db   *leveldb.DB
+	opts Options
+
+	// m serializes the mutations, since they read the state before
+	// writing a batch.
+	m sync.Mutex
+}
+
+// New returns a new filesystem stored in the given database. The database
+// is owned by the caller, who must close it after closing every file.
+func New(db *leveldb.DB, opts Options) *KV {
+	if opts.BlockSize <= 0 {
+		opts.BlockSize = defaultBlockSize
+	}
+
+	return &KV{db: db, opts: opts}
+}
+
+func (fs *KV) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *KV) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file. The files opened only for reading read the
+// blocks as needed, from a snapshot of the database taken when opened.
+// Otherwise the content is kept in memory, and written when closed if
+// modified.
+func (fs *KV) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	name := pathutil.Relative(filename)
+	if !isWrite(flag) && flag&os.O_CREATE == 0 {
+		f, err := fs.openReader(name, toKey(filename))
+		if err != nil {
+			return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+		}
+
+		return f, nil
+	}
+
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	f, err := fs.openFile(name, toKey(filename), flag, perm)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+	}
+
+	return f, nil
+}
+
+func (fs *KV) openReader(name, key string) (billy.File, error) {
+	snap, err := fs.db.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	key, n, err := follow(snap, key)
+	if err == nil && n.mode.IsDir() {
+		err = fmt.Errorf("cannot open directory: %s", name)
+	}
+
+	if err != nil {
+		snap.Release()
+		return nil, err
+	}
+
+	return &reader{name: name, key: key, node: n, snap: snap}, nil
+}
+
+func (fs *KV) openFile(name, key string, flag int, perm os.FileMode) (billy.File, error) {
+	key, n, err := follow(fs.db, key)
+	if os.IsNotExist(err) && flag&os.O_CREATE != 0 {
+		// the key is the target of the last link, even when it's dangling,
+		// so the target is created.
+		n = &node{mode: perm.Perm(), modTime: time.Now(), blockSize: fs.opts.BlockSize}
+		b := new(leveldb.Batch)
+		if err := fs.mkdirAll(b, parent(key), 0755); err != nil {
+			return nil, err
+		}
+
+		b.Put(metaKey(key), n.encode())
+		if err := fs.db.Write(b, nil); err != nil {
+			return nil, err
+		}
+
+		return &file{File: memfile.New(name, flag, nil), fs: fs, key: key, mode: n.mode}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+		return nil, os.ErrExist
+	}
+
+	if n.mode.IsDir() {
+		return nil, fmt.Errorf("cannot open directory: %s", name)
+	}
+
+	f := &file{File: memfile.New(name, flag, nil), fs: fs, key: key, mode: n.mode}
+	if isWrite(flag) && flag&os.O_TRUNC != 0 {
+		truncated := *n
+		truncated.modTime = time.Now()
+		truncated.blockSize = fs.opts.BlockSize
+
+		b := new(leveldb.Batch)
+		fs.putFile(b, key, n, &truncated, nil)
+		return f, fs.db.Write(b, nil)
+	}
+
+	if f.Content, err = readContent(fs.db, key, n); err != nil {
+		return nil, err
+	}
+
+	if flag&os.O_APPEND != 0 {
+		f.Position = int64(len(f.Content))
+	}
+
+	return f, nil
+}
+
+// store writes the content of a file closed after modified. If the file was
+// removed or replaced while open, the content is discarded.
+func (fs *KV) store(key string, content []byte) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	old, err := get(fs.db, key)
+	if err != nil || old == nil || !old.mode.IsRegular() {
+		return err
+	}
+
+	n := *old
+	n.modTime = time.Now()
+	n.blockSize = fs.opts.BlockSize
+
+	b := new(leveldb.Batch)
+	fs.putFile(b, key, old, &n, content)
+	return fs.db.Write(b, nil)
+}
+
+// putFile writes to the batch the node and the content of a file, removing
+// the blocks of the old version not overwritten.
+func (fs *KV) putFile(b *leveldb.Batch, key string, old, n *node, content []byte) {
+	n.size = int64(len(content))
+	b.Put(metaKey(key), n.encode())
+
+	var blocks int
+	for ; len(content) != 0; blocks++ {
+		size := n.blockSize
+		if size > len(content) {
+			size = len(content)
+		}
+
+		b.Put(blockKey(key, blocks), content[:size])
+		content = content[size:]
+	}
+
+	if old != nil {
+		for i := blocks; i < old.blocks(); i++ {
+			b.Delete(blockKey(key, i))
+		}
+	}
+}
+
+// deleteFile writes to the batch the deletion of the given node.
+func (fs *KV) deleteFile(b *leveldb.Batch, key string, n *node) {
+	b.Delete(metaKey(key))
+	for i := 0; i < n.blocks(); i++ {
+		b.Delete(blockKey(key, i))
+	}
+}
+
+func (fs *KV) Stat(filename string) (os.FileInfo, error) {
+	_, n, err := follow(fs.db, toKey(filename))
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: filename, Err: err}
+	}
+
+	// the name is the one of the stated file, even if it's a link.
+	return newFileInfo(filepath.Base(filename), n), nil
+}
+
+func (fs *KV) Lstat(filename string) (os.FileInfo, error) {
+	n, err := lookup(fs.db, toKey(filename))
+	if err != nil {
+		return nil, &os.PathError{Op: "lstat", Path: filename, Err: err}
+	}
+
+	return newFileInfo(filepath.Base(filename), n), nil
+}
+
+func (fs *KV) ReadDir(filename string) ([]os.FileInfo, error) {
+	var infos []os.FileInfo
+	key, n, err := follow(fs.db, toKey(filename))
+	if err == nil && !n.mode.IsDir() {
+		err = errNotDir
+	}
+
+	if err == nil {
+		err = fs.children(key, func(name string, n *node) {
+			infos = append(infos, newFileInfo(name, n))
+		})
+	}
+
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: filename, Err: err}
+	}
+
+	return infos, nil
+}
+
+// children calls fn with the name and node of every child of the given
+// directory, sorted by name. The descendants of the subdirectories are
+// skipped seeking past them.
+func (fs *KV) children(key string, fn func(name string, n *node)) error {
+	prefix := metaKey(key)
+	if key != "" {
+		prefix = append(prefix, '/')
+	}
+
+	it := fs.db.NewIterator(ldbutil.BytesPrefix(prefix), nil)
+	defer it.Release()
+
+	for ok := it.First(); ok; {
+		name := string(it.Key()[len(prefix):])
+		if i := strings.IndexByte(name, '/'); i != -1 {
+			// '0' is the byte following '/', so it's the first key after
+			// every descendant of the child.
+			ok = it.Seek(append(append(prefix[:len(prefix):len(prefix)], name[:i]...), '0'))
+			continue
+		}
+
+		n, err := decodeNode(it.Value())
+		if err != nil {
+			return err
+		}
+
+		fn(name, n)
+		ok = it.Next()
+	}
+
+	return it.Error()
+}
+
+// descendants returns the keys and nodes of everything under the given
+// directory.
+func (fs *KV) descendants(key string) ([]string, []*node, error) {
+	prefix := append(metaKey(key), '/')
+	it := fs.db.NewIterator(ldbutil.BytesPrefix(prefix), nil)
+	defer it.Release()
+
+	var keys []string
+	var nodes []*node
+	for it.Next() {
+		n, err := decodeNode(it.Value())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		keys = append(keys, string(it.Key()[1:]))
+		nodes = append(nodes, n)
+	}
+
+	return keys, nodes, it.Error()
+}
+
+func (fs *KV) isEmpty(key string) (bool, error) {
+	prefix := append(metaKey(key), '/')
+	it := fs.db.NewIterator(ldbutil.BytesPrefix(prefix), nil)
+	defer it.Release()
+
+	return !it.First(), it.Error()
+}
+
+func (fs *KV) MkdirAll(filename string, perm os.FileMode) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	b := new(leveldb.Batch)
+	err := fs.mkdirAll(b, toKey(filename), perm)
+	if err == nil {
+		err = fs.db.Write(b, nil)
+	}
+
+	if err != nil {
+		return &os.PathError{Op: "mkdir", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+// mkdirAll writes to the batch the directories of the given path not
+// found.
+func (fs *KV) mkdirAll(b *leveldb.Batch, key string, perm os.FileMode) error {
+	if key == "" {
+		return nil
+	}
+
+	var dir string
+	for _, elem := range strings.Split(key, "/") {
+		dir = path.Join(dir, elem)
+		_, n, err := follow(fs.db, dir)
+		if err == nil {
+			if !n.mode.IsDir() {
+				return errNotDir
+			}
+
+			continue
+		}
+
+		if !os.IsNotExist(err) {
+			return err
+		}
+
+		n = &node{mode: os.ModeDir | perm.Perm(), modTime: time.Now()}
+		b.Put(metaKey(dir), n.encode())
+	}
+
+	return nil
+}
+
+// Rename moves the given file, or directory with all its content, in a
+// single batch.
+func (fs *KV) Rename(from, to string) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	if err := fs.rename(toKey(from), toKey(to)); err != nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *KV) rename(from, to string) error {
+	if from == "" || to == "" {
+		return errors.New("cannot rename the root")
+	}
+
+	src, err := lookup(fs.db, from)
+	if err != nil {
+		return err
+	}
+
+	if from == to {
+		return nil
+	}
+
+	if strings.HasPrefix(to, from+"/") {
+		return errors.New("cannot move a directory into itself")
+	}
+
+	b := new(leveldb.Batch)
+	dst, err := get(fs.db, to)
+	if err != nil {
+		return err
+	}
+
+	if dst != nil {
+		if dst.mode.IsDir() != src.mode.IsDir() {
+			return os.ErrExist
+		}
+
+		if dst.mode.IsDir() {
+			empty, err := fs.isEmpty(to)
+			if err != nil {
+				return err
+			}
+
+			if !empty {
+				return ErrNotEmpty
+			}
+		}
+
+		fs.deleteFile(b, to, dst)
+	}
+
+	if err := fs.mkdirAll(b, parent(to), 0755); err != nil {
+		return err
+	}
+
+	keys, nodes, err := fs.descendants(from)
+	if err != nil {
+		return err
+	}
+
+	keys, nodes = append([]string{from}, keys...), append([]*node{src}, nodes...)
+	for i, key := range keys {
+		if err := fs.move(b, key, to+key[len(from):], nodes[i]); err != nil {
+			return err
+		}
+	}
+
+	return fs.db.Write(b, nil)
+}
+
+// move writes to the batch the move of the given node and its blocks.
+func (fs *KV) move(b *leveldb.Batch, from, to string, n *node) error {
+	meta, err := fs.db.Get(metaKey(from), nil)
+	if err != nil {
+		return err
+	}
+
+	b.Delete(metaKey(from))
+	b.Put(metaKey(to), meta)
+	for i := 0; i < n.blocks(); i++ {
+		block, err := fs.db.Get(blockKey(from, i), nil)
+		if err != nil {
+			return err
+		}
+
+		b.Delete(blockKey(from, i))
+		b.Put(blockKey(to, i), block)
+	}
+
+	return nil
+}
+
+func (fs *KV) Remove(filename string) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	if err := fs.remove(toKey(filename)); err != nil {
+		return &os.PathError{Op: "remove", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *KV) remove(key string) error {
+	if key == "" {
+		return errors.New("cannot remove the root")
+	}
+
+	n, err := lookup(fs.db, key)
+	if err != nil {
+		return err
+	}
+
+	if n.mode.IsDir() {
+		empty, err := fs.isEmpty(key)
+		if err != nil {
+			return err
+		}
+
+		if !empty {
+			return ErrNotEmpty
+		}
+	}
+
+	b := new(leveldb.Batch)
+	fs.deleteFile(b, key, n)
+	return fs.db.Write(b, nil)
+}
+
+func (fs *KV) Symlink(target, link string) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	key := toKey(link)
+	n, err := get(fs.db, key)
+	if err == nil && n != nil {
+		err = os.ErrExist
+	}
+
+	b := new(leveldb.Batch)
+	if err == nil {
+		err = fs.mkdirAll(b, parent(key), 0755)
+	}
+
+	if err == nil {
+		n = &node{mode: os.ModeSymlink | 0777, modTime: time.Now(), target: target}
+		n.size = int64(len(target))
+		b.Put(metaKey(key), n.encode())
+		err = fs.db.Write(b, nil)
+	}
+
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *KV) Readlink(link string) (string, error) {
+	n, err := lookup(fs.db, toKey(link))
+	if err == nil && n.mode&os.ModeSymlink == 0 {
+		err = errors.New("not a symlink")
+	}
+
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: link, Err: err}
+	}
+
+	return n.target, nil
+}
+
+func (fs *KV) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(fs, dir, prefix)
+}
+
+func (fs *KV) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (fs *KV) Chroot(p string) (billy.Filesystem, error) {
+	return chroot.New(fs, fs.Join(fs.Root(), p)), nil
+}
+
+func (fs *KV) Root() string {
+	return string(filepath.Separator)
+}
+
+// Capabilities implements the Capable interface.
+func (fs *KV) Capabilities() billy.Capability {
+	return billy.WriteCapability | billy.ReadCapability |
+		billy.ReadAndWriteCapability | billy.SeekCapability |
+		billy.TruncateCapability
+}
+
+// getter is implemented by the database and its snapshots.
+type getter interface {
+	Get(key []byte, ro *opt.ReadOptions) ([]byte, error)
+}
+
+// get returns the node with the given key, or nil if it doesn't exist. The
+// root is the empty key, which is never stored.
+func get(g getter, key string) (*node, error) {
+	if key == "" {
+		return &node{mode: os.ModeDir | 0755}, nil
+	}
+
+	v, err := g.Get(metaKey(key), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeNode(v)
+}
+
+// lookup returns the node with the given key from the database or one of its
+// snapshots, failing with os.ErrNotExist if the key isn't there.
+func lookup(g getter, key string) (*node, error) {
+	n, err := get(g, key)
+	if err == nil && n == nil {
+		err = os.ErrNotExist
+	}
+
+	return n, err
+}
+
+// follow returns the node with the given key, following the links read from
+// g, so the ones of a snapshot are consistent. The key of the last target is
+// returned even if it doesn't exist.
+func follow(g getter, key string) (string, *node, error) {
+	var n *node
+	isLink := func(p string) (link bool, err error) {
+		n, err = lookup(g, p)
+		return err == nil && n.mode&os.ModeSymlink != 0, err
+	}
+
+	key, err := linkutil.Follow(key, toKey, isLink, func(string) (string, error) {
+		return n.target, nil
+	})
+	if err != nil {
+		return key, nil, err
+	}
+
+	return key, n, nil
+}
+
+// readContent reads all the blocks of a file.
+func readContent(g getter, key string, n *node) ([]byte, error) {
+	content := make([]byte, 0, n.size)
+	for i := 0; i < n.blocks(); i++ {
+		block, err := g.Get(blockKey(key, i), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		content = append(content, block...)
+	}
+
+	return content, nil
+}
+
+// node is the metadata of a file, directory or symlink.
+type node struct {
+	mode      os.FileMode
+	modTime   time.Time
+	size      int64
+	blockSize int
+	target    string
+}
+
+// blocks returns the number of blocks of the content.
+func (n *node) blocks() int {
+	if n.size == 0 || n.blockSize == 0 {
+		return 0
+	}
+
+	return int((n.size + int64(n.blockSize) - 1) / int64(n.blockSize))
+}
+
+func (n *node) encode() []byte {
+	b := make([]byte, nodeSize, nodeSize+len(n.target))
+	binary.BigEndian.PutUint32(b, uint32(n.mode))
+	binary.BigEndian.PutUint64(b[4:], uint64(n.modTime.UnixNano()))
+	binary.BigEndian.PutUint64(b[12:], uint64(n.size))
+	binary.BigEndian.PutUint32(b[20:], uint32(n.blockSize))
+	return append(b, n.target...)
+}
+
+func decodeNode(b []byte) (*node, error) {
+	if len(b) < nodeSize {
+		return nil, ErrCorrupted
+	}
+
+	return &node{
+		mode:      os.FileMode(binary.BigEndian.Uint32(b)),
+		modTime:   time.Unix(0, int64(binary.BigEndian.Uint64(b[4:]))),
+		size:      int64(binary.BigEndian.Uint64(b[12:])),
+		blockSize: int(binary.BigEndian.Uint32(b[20:])),
+		target:    string(b[nodeSize:]),
+	}, nil
+}
+
+// metaKey returns the key of the metadata of the given path.
+func metaKey(key string) []byte {
+	return append([]byte{metaPrefix}, key...)
+}
+
+// blockKey returns the key of the given block of a file, the path and the
+// number are separated by a NUL byte, since it can't be found in paths.
+func blockKey(key string, i int) []byte {
+	b := make([]byte, 0, len(key)+6)
+	b = append(append(append(b, blockPrefix), key...), 0)
+	return append(b, byte(i>>24), byte(i>>16), byte(i>>8), byte(i))
+}
+
+// toKey returns the key of the given path, the clean path without the
+// leading separator.
+func toKey(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(p)), "/")
+}
+
+func parent(key string) string {
+	if i := strings.LastIndexByte(key, '/'); i != -1 {
+		return key[:i]
+	}
+
+	return ""
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR) != 0
+}
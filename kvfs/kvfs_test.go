@@ -0,0 +1,152 @@
+//go:build !js
+// +build !js
+
+package kvfs
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&KVSuite{})
+
+type KVSuite struct {
+	test.FilesystemSuite
+	db *leveldb.DB
+	kv *KV
+}
+
+func (s *KVSuite) SetUpTest(c *C) {
+	var err error
+	s.db, err = leveldb.Open(storage.NewMemStorage(), nil)
+	c.Assert(err, IsNil)
+
+	s.kv = New(s.db, Options{})
+	s.FilesystemSuite = test.NewFilesystemSuite(s.kv)
+}
+
+func (s *KVSuite) TearDownTest(c *C) {
+	c.Assert(s.db.Close(), IsNil)
+}
+
+func (s *KVSuite) TestPersistence(c *C) {
+	dir := filepath.Join(c.MkDir(), "db")
+	db, err := leveldb.OpenFile(dir, nil)
+	c.Assert(err, IsNil)
+
+	fs := New(db, Options{})
+	c.Assert(util.WriteFile(fs, "foo/bar", []byte("foo"), 0644), IsNil)
+	c.Assert(fs.Symlink("bar", "foo/qux"), IsNil)
+	c.Assert(db.Close(), IsNil)
+
+	db, err = leveldb.OpenFile(dir, nil)
+	c.Assert(err, IsNil)
+	defer db.Close()
+
+	fs = New(db, Options{})
+	c.Assert(test.ReadFile(c, fs, "foo/qux"), Equals, "foo")
+
+	fi, err := fs.Stat("foo/bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode(), Equals, os.FileMode(0644))
+}
+
+func (s *KVSuite) TestBlocks(c *C) {
+	fs := New(s.db, Options{BlockSize: 4})
+	content := []byte("0123456789abcdefg")
+	c.Assert(util.WriteFile(fs, "foo", content, 0644), IsNil)
+	c.Assert(s.blocks(c, "foo"), Equals, 5)
+
+	f, err := fs.Open("foo")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	buf := make([]byte, 7)
+	n, err := f.(io.ReaderAt).ReadAt(buf, 3)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf[:n]), Equals, "3456789")
+
+	n, err = f.(io.ReaderAt).ReadAt(buf, 14)
+	c.Assert(err, Equals, io.EOF)
+	c.Assert(string(buf[:n]), Equals, "efg")
+
+	c.Assert(util.WriteFile(fs, "foo", content[:5], 0644), IsNil)
+	c.Assert(s.blocks(c, "foo"), Equals, 2)
+
+	// the files keep the block size they were written with.
+	c.Assert(test.ReadFile(c, s.kv, "foo"), Equals, "01234")
+}
+
+func (s *KVSuite) TestSnapshot(c *C) {
+	c.Assert(util.WriteFile(s.kv, "foo", []byte("foo"), 0644), IsNil)
+
+	f, err := s.kv.Open("foo")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	c.Assert(util.WriteFile(s.kv, "foo", []byte("bar"), 0644), IsNil)
+	c.Assert(s.kv.Remove("foo"), IsNil)
+
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "foo")
+}
+
+func (s *KVSuite) TestRenameDir(c *C) {
+	fs := New(s.db, Options{BlockSize: 2})
+	c.Assert(util.WriteFile(fs, "foo/bar/baz", []byte("baz"), 0644), IsNil)
+	c.Assert(util.WriteFile(fs, "foo/qux", []byte("qux"), 0644), IsNil)
+	c.Assert(util.WriteFile(fs, "foo0", []byte("foo0"), 0644), IsNil)
+
+	c.Assert(fs.Rename("foo", "new/foo"), IsNil)
+	c.Assert(test.ReadFile(c, fs, "new/foo/bar/baz"), Equals, "baz")
+	c.Assert(test.ReadFile(c, fs, "new/foo/qux"), Equals, "qux")
+	c.Assert(test.ReadFile(c, fs, "foo0"), Equals, "foo0")
+	c.Assert(s.blocks(c, "foo/qux"), Equals, 0)
+
+	infos, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 2)
+	c.Assert(infos[0].Name(), Equals, "foo0")
+	c.Assert(infos[1].Name(), Equals, "new")
+}
+
+func (s *KVSuite) TestRemoveNotEmpty(c *C) {
+	c.Assert(util.WriteFile(s.kv, "foo/bar", []byte("foo"), 0644), IsNil)
+
+	err := s.kv.Remove("foo")
+	c.Assert(err.(*os.PathError).Err, Equals, ErrNotEmpty)
+}
+
+func (s *KVSuite) TestCorrupted(c *C) {
+	c.Assert(s.db.Put(metaKey("foo"), []byte("foo"), nil), IsNil)
+
+	_, err := s.kv.Stat("foo")
+	c.Assert(err.(*os.PathError).Err, Equals, ErrCorrupted)
+}
+
+// blocks returns the number of blocks stored of the given file.
+func (s *KVSuite) blocks(c *C, key string) int {
+	var n int
+	for {
+		ok, err := s.db.Has(blockKey(key, n), nil)
+		c.Assert(err, IsNil)
+		if !ok {
+			return n
+		}
+
+		n++
+	}
+}
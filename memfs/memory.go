@@ -70,7 +70,7 @@ func (fs *Memory) resolveLink(fullpath string, f *file) (target string, isLink b
 		return fullpath, false
 	}
 
-	target = string(f.content.bytes)
+	target = f.content.String()
 	if !isAbs(target) {
 		target = fs.Join(filepath.Dir(fullpath), target)
 	}
@@ -149,11 +149,29 @@ func (fs *Memory) getTempFilename(dir, prefix string) string {
 }
 
 func (fs *Memory) Rename(from, to string) error {
-	return fs.s.Rename(from, to)
+	old, replaced := fs.s.Get(to)
+	if err := fs.s.Rename(from, to); err != nil {
+		return err
+	}
+
+	if replaced && !old.mode.IsDir() && old != fs.s.MustGet(to) {
+		old.content.release()
+	}
+
+	return nil
 }
 
 func (fs *Memory) Remove(filename string) error {
-	return fs.s.Remove(filename)
+	f, has := fs.s.Get(filename)
+	if err := fs.s.Remove(filename); err != nil {
+		return err
+	}
+
+	if has && !f.mode.IsDir() {
+		f.content.release()
+	}
+
+	return nil
 }
 
 func (fs *Memory) Join(elem ...string) string {
@@ -187,7 +205,7 @@ func (fs *Memory) Readlink(link string) (string, error) {
 		}
 	}
 
-	return string(f.content.bytes), nil
+	return f.content.String(), nil
 }
 
 // Capabilities implements the Capable interface.
@@ -276,17 +294,15 @@ func (f *file) Close() error {
 	}
 
 	f.isClosed = true
+	if f.content.spill != nil {
+		f.content.spill.close(f.content)
+	}
+
 	return nil
 }
 
 func (f *file) Truncate(size int64) error {
-	if size < int64(len(f.content.bytes)) {
-		f.content.bytes = f.content.bytes[:size]
-	} else if more := int(size) - len(f.content.bytes); more > 0 {
-		f.content.bytes = append(f.content.bytes, make([]byte, more)...)
-	}
-
-	return nil
+	return f.content.Resize(size)
 }
 
 func (f *file) Duplicate(filename string, mode os.FileMode, flag int) billy.File {
@@ -297,6 +313,10 @@ func (f *file) Duplicate(filename string, mode os.FileMode, flag int) billy.File
 		flag:    flag,
 	}
 
+	if new.content.spill != nil {
+		new.content.spill.open(new.content)
+	}
+
 	if isAppend(flag) {
 		new.position = int64(new.content.Len())
 	}
@@ -357,13 +377,53 @@ func (*fileInfo) Sys() interface{} {
 }
 
 func (c *content) Truncate() {
+	if c.spill != nil {
+		c.spill.truncate(c)
+		return
+	}
+
 	c.bytes = make([]byte, 0)
 }
 
+func (c *content) Resize(size int64) error {
+	if c.spill != nil {
+		return c.spill.resize(c, size)
+	}
+
+	c.resize(size)
+	return nil
+}
+
+func (c *content) resize(size int64) {
+	if size < int64(len(c.bytes)) {
+		c.bytes = c.bytes[:size]
+	} else if more := int(size) - len(c.bytes); more > 0 {
+		c.bytes = append(c.bytes, make([]byte, more)...)
+	}
+}
+
 func (c *content) Len() int {
+	if c.spill != nil {
+		return c.spill.len(c)
+	}
+
 	return len(c.bytes)
 }
 
+// String returns the whole content, used as the target of the symlinks.
+func (c *content) String() string {
+	b := make([]byte, c.Len())
+	n, _ := c.ReadAt(b, 0)
+	return string(b[:n])
+}
+
+// release releases the content of a removed or replaced file.
+func (c *content) release() {
+	if c.spill != nil {
+		c.spill.remove(c)
+	}
+}
+
 func isCreate(flag int) bool {
 	return flag&os.O_CREATE != 0
 }
@@ -1,11 +1,18 @@
 package memfs
 
 import (
+	"bytes"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
 
 	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/osfs"
 	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
 
 	. "gopkg.in/check.v1"
 )
@@ -44,3 +51,197 @@ func (s *MemorySuite) TestNegativeOffsets(c *C) {
 	_, err = f.Write(buf)
 	c.Assert(err, ErrorMatches, "writeat negative: negative offset")
 }
+
+func (s *MemorySuite) TestRenameEmptyDir(c *C) {
+	c.Assert(s.FS.MkdirAll("foo", 0755), IsNil)
+	c.Assert(s.FS.Rename("foo", "bar"), IsNil)
+	c.Assert(util.WriteFile(s.FS, "bar/qux", []byte("qux"), 0644), IsNil)
+
+	infos, err := s.FS.ReadDir("bar")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 1)
+}
+
+type SpillSuite struct {
+	test.FilesystemSuite
+	spill *countingFS
+}
+
+var _ = Suite(&SpillSuite{})
+
+func (s *SpillSuite) SetUpTest(c *C) {
+	s.spill = &countingFS{Filesystem: osfs.New(c.MkDir())}
+	fs, err := NewWithOptions(Options{
+		Threshold: 16,
+		Budget:    64,
+		Spill:     s.spill,
+	})
+	c.Assert(err, IsNil)
+	s.FilesystemSuite = test.NewFilesystemSuite(fs)
+}
+
+func (s *SpillSuite) spilled(c *C) int {
+	infos, err := s.spill.ReadDir("/")
+	c.Assert(err, IsNil)
+	return len(infos)
+}
+
+func (s *SpillSuite) TestThreshold(c *C) {
+	c.Assert(util.WriteFile(s.FS, "small", []byte("foo"), 0644), IsNil)
+	c.Assert(s.spilled(c), Equals, 0)
+
+	f, err := s.FS.Create("large")
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("0123456789"))
+	c.Assert(err, IsNil)
+	c.Assert(s.spilled(c), Equals, 0)
+	_, err = f.Write([]byte("0123456789"))
+	c.Assert(err, IsNil)
+	c.Assert(s.spilled(c), Equals, 1)
+	c.Assert(f.Truncate(5), IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	fi, err := s.FS.Stat("large")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Size(), Equals, int64(5))
+	c.Assert(test.ReadFile(c, s.FS, "large"), Equals, "01234")
+
+	c.Assert(s.FS.Remove("large"), IsNil)
+	c.Assert(s.spilled(c), Equals, 0)
+}
+
+func (s *SpillSuite) TestBudget(c *C) {
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		c.Assert(util.WriteFile(s.FS, name, bytes.Repeat([]byte(name), 16), 0644), IsNil)
+	}
+
+	// the least recently used file, "a", is moved to disk.
+	c.Assert(s.spilled(c), Equals, 1)
+	c.Assert(test.ReadFile(c, s.FS, "b"), Equals, strings.Repeat("b", 16))
+	c.Assert(util.WriteFile(s.FS, "f", []byte("f"), 0644), IsNil)
+	c.Assert(s.spilled(c), Equals, 2)
+
+	// reading "b" made "c" the least recently used.
+	c.Assert(test.ReadFile(c, s.FS, "c"), Equals, strings.Repeat("c", 16))
+	c.Assert(test.ReadFile(c, s.FS, "a"), Equals, strings.Repeat("a", 16))
+
+	c.Assert(util.WriteFile(s.FS, "b", []byte("b"), 0644), IsNil)
+	c.Assert(s.FS.Rename("b", "c"), IsNil)
+	c.Assert(s.spilled(c), Equals, 1)
+}
+
+func (s *SpillSuite) TestRemoveOpen(c *C) {
+	content := strings.Repeat("0123456789", 4)
+	c.Assert(util.WriteFile(s.FS, "foo", []byte(content), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "bar", []byte(content), 0644), IsNil)
+	c.Assert(s.spilled(c), Equals, 2)
+
+	foo, err := s.FS.Open("foo")
+	c.Assert(err, IsNil)
+	bar, err := s.FS.Open("bar")
+	c.Assert(err, IsNil)
+
+	c.Assert(s.FS.Remove("foo"), IsNil)
+	c.Assert(util.WriteFile(s.FS, "qux", []byte("qux"), 0644), IsNil)
+	c.Assert(s.FS.Rename("qux", "bar"), IsNil)
+	c.Assert(s.spilled(c), Equals, 2)
+
+	for _, f := range []billy.File{foo, bar} {
+		read, err := ioutil.ReadAll(f)
+		c.Assert(err, IsNil)
+		c.Assert(string(read), Equals, content)
+		c.Assert(f.Close(), IsNil)
+	}
+
+	c.Assert(s.spilled(c), Equals, 0)
+}
+
+func (s *SpillSuite) TestReadSpilled(c *C) {
+	content := strings.Repeat("0123456789", 4)
+	c.Assert(util.WriteFile(s.FS, "foo", []byte(content), 0644), IsNil)
+	c.Assert(s.spilled(c), Equals, 1)
+
+	opens := s.spill.opens
+	for i := 0; i < 3; i++ {
+		c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, content)
+	}
+
+	// the file on disk is kept open, instead of opened by each read.
+	c.Assert(s.spill.opens, Equals, opens)
+}
+
+func (s *SpillSuite) TestReadSpilledConcurrently(c *C) {
+	content := strings.Repeat("0123456789", 4)
+	for _, name := range []string{"foo", "bar"} {
+		c.Assert(util.WriteFile(s.FS, name, []byte(content), 0644), IsNil)
+	}
+
+	errs := make(chan error)
+	for i := 0; i < 8; i++ {
+		name := []string{"foo", "bar"}[i%2]
+		go func() {
+			f, err := s.FS.Open(name)
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			defer f.Close()
+			for off := 0; off < len(content); off += 10 {
+				b := make([]byte, 10)
+				if _, err := f.ReadAt(b, int64(off)); err != nil {
+					errs <- err
+					return
+				}
+
+				if string(b) != content[off:off+10] {
+					errs <- fmt.Errorf("read %q at %d", b, off)
+					return
+				}
+			}
+
+			errs <- nil
+		}()
+	}
+
+	for i := 0; i < 8; i++ {
+		c.Assert(<-errs, IsNil)
+	}
+}
+
+func (s *SpillSuite) TestSharedSpill(c *C) {
+	other, err := NewWithOptions(Options{Threshold: 16, Spill: s.spill})
+	c.Assert(err, IsNil)
+
+	for _, fs := range []billy.Filesystem{s.FS, other} {
+		c.Assert(util.WriteFile(fs, "large", bytes.Repeat([]byte("a"), 32), 0644), IsNil)
+	}
+
+	c.Assert(s.spilled(c), Equals, 2)
+	c.Assert(test.ReadFile(c, s.FS, "large"), Equals, strings.Repeat("a", 32))
+	c.Assert(test.ReadFile(c, other, "large"), Equals, strings.Repeat("a", 32))
+}
+
+func (s *SpillSuite) TestRequiresSpill(c *C) {
+	_, err := NewWithOptions(Options{Threshold: 16})
+	c.Assert(err, ErrorMatches, "spill filesystem is required")
+
+	fs, err := NewWithOptions(Options{})
+	c.Assert(err, IsNil)
+	c.Assert(fs, NotNil)
+}
+
+// countingFS counts the files opened in the filesystem.
+type countingFS struct {
+	billy.Filesystem
+	opens int
+}
+
+func (fs *countingFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	fs.opens++
+	return fs.Filesystem.OpenFile(filename, flag, perm)
+}
+
+func (fs *countingFS) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
@@ -0,0 +1,268 @@
+package memfs
+
+import (
+	"container/list"
+	"errors"
+	"io"
+	"sync"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+// Options holds the configuration of a Memory filesystem moving the content
+// of the files to disk.
+type Options struct {
+	// Threshold is the size from which the content of a file is moved to
+	// disk, without limit if 0.
+	Threshold int64
+	// Budget is the maximum amount of memory used by the content of the
+	// files, without limit if 0. When exceeded, the content of the least
+	// recently used files is moved to disk.
+	Budget int64
+	// Spill is the filesystem where the content is moved to, required if
+	// Threshold or Budget is set. The files created in it are removed once
+	// not used anymore, so the caller owns it, and can remove it once done
+	// with the filesystem, eg.: a temporary directory. It may be shared by
+	// several filesystems.
+	Spill billy.Filesystem
+}
+
+// NewWithOptions returns a new Memory filesystem keeping the small and
+// recently used files in memory, and moving the content of the large or
+// cold ones to disk according to the given options. The directories, the
+// symlinks and the metadata are always kept in memory.
+//
+// The content of a removed or replaced file is released once its last open
+// handle is closed. It returns an error if Threshold or Budget is set without
+// Spill.
+func NewWithOptions(opts Options) (billy.Filesystem, error) {
+	if opts.Spill == nil && (opts.Threshold > 0 || opts.Budget > 0) {
+		return nil, errors.New("spill filesystem is required")
+	}
+
+	fs := &Memory{s: newStorage()}
+	fs.s.spill = &spiller{opts: opts, lru: list.New()}
+	return chroot.New(fs, string(separator)), nil
+}
+
+// spiller keeps the account of the memory used by the content of the files,
+// and moves it to disk when needed. It's shared by all the contents of a
+// filesystem, and its mutex guards them, except the reads of the contents on
+// disk, done holding only the mutex of the content.
+type spiller struct {
+	opts Options
+
+	mu   sync.Mutex
+	used int64
+	lru  *list.List
+}
+
+func (s *spiller) writeAt(c *content, p []byte, off int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c.disk == "" && s.exceeds(off+int64(len(p))) {
+		if err := s.evict(c); err != nil {
+			return 0, err
+		}
+	}
+
+	if c.disk != "" {
+		return s.writeDisk(c, p, off)
+	}
+
+	prev := len(c.bytes)
+	n, err := c.writeAt(p, off)
+	return n, s.grow(c, len(c.bytes)-prev, err)
+}
+
+func (s *spiller) readAt(c *content, b []byte, off int64) (int, error) {
+	s.mu.Lock()
+	if c.disk == "" {
+		defer s.mu.Unlock()
+		s.touch(c)
+		return c.readAt(b, off)
+	}
+
+	c.mu.RLock()
+	s.mu.Unlock()
+	defer c.mu.RUnlock()
+
+	return c.file.ReadAt(b, off)
+}
+
+func (s *spiller) resize(c *content, size int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c.disk == "" && s.exceeds(size) {
+		if err := s.evict(c); err != nil {
+			return err
+		}
+	}
+
+	if c.disk == "" {
+		prev := len(c.bytes)
+		c.resize(size)
+		return s.grow(c, len(c.bytes)-prev, nil)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.file.Truncate(size); err != nil {
+		return err
+	}
+
+	c.size = size
+	return nil
+}
+
+// truncate empties the content, moving it back to memory.
+func (s *spiller) truncate(c *content) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.release(c)
+	c.bytes = make([]byte, 0)
+	s.touch(c)
+}
+
+func (s *spiller) len(c *content) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c.disk != "" {
+		return int(c.size)
+	}
+
+	return len(c.bytes)
+}
+
+// open accounts a new open handle of the content.
+func (s *spiller) open(c *content) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c.handles++
+}
+
+// close accounts a closed handle of the content, releasing it if it was
+// removed and this was the last one.
+func (s *spiller) close(c *content) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c.handles--
+	if c.removed && c.handles == 0 {
+		s.release(c)
+		c.spill = nil
+	}
+}
+
+// remove releases the content of a removed or replaced file, which is not
+// accounted anymore, once it has no open handles.
+func (s *spiller) remove(c *content) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c.handles > 0 {
+		c.removed = true
+		return
+	}
+
+	s.release(c)
+	c.spill = nil
+}
+
+// release deletes the content from disk or from the account of memory.
+func (s *spiller) release(c *content) {
+	if c.disk != "" {
+		c.mu.Lock()
+		c.file.Close()
+		s.opts.Spill.Remove(c.disk)
+		c.disk, c.file, c.size = "", nil, 0
+		c.mu.Unlock()
+		return
+	}
+
+	if c.elem != nil {
+		s.lru.Remove(c.elem)
+		c.elem = nil
+	}
+
+	s.used -= int64(len(c.bytes))
+}
+
+func (s *spiller) exceeds(size int64) bool {
+	return s.opts.Threshold > 0 && size > s.opts.Threshold
+}
+
+// grow accounts the given growth of the content, moving the least recently
+// used contents to disk while the budget is exceeded.
+func (s *spiller) grow(c *content, delta int, err error) error {
+	s.used += int64(delta)
+	s.touch(c)
+
+	for s.opts.Budget > 0 && s.used > s.opts.Budget && s.lru.Len() != 0 {
+		if err := s.evict(s.lru.Back().Value.(*content)); err != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+// touch marks the content as the most recently used.
+func (s *spiller) touch(c *content) {
+	if c.elem == nil {
+		c.elem = s.lru.PushFront(c)
+		return
+	}
+
+	s.lru.MoveToFront(c.elem)
+}
+
+// evict moves the content to a new file in the spill filesystem, kept open
+// until the content is released. The file has a random name, since the spill
+// filesystem may be shared by several filesystems.
+func (s *spiller) evict(c *content) error {
+	f, err := util.TempFile(s.opts.Spill, ".", "spill-")
+	if err != nil {
+		return err
+	}
+
+	name := f.Name()
+
+	if _, err := f.Write(c.bytes); err != nil {
+		f.Close()
+		s.opts.Spill.Remove(name)
+		return err
+	}
+
+	size := int64(len(c.bytes))
+	s.release(c)
+
+	c.mu.Lock()
+	c.bytes, c.disk, c.file, c.size = nil, name, f, size
+	c.mu.Unlock()
+	return nil
+}
+
+func (s *spiller) writeDisk(c *content, p []byte, off int64) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := c.file.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	n, err := c.file.Write(p)
+	if end := off + int64(n); end > c.size {
+		c.size = end
+	}
+
+	return n, err
+}
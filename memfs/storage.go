@@ -1,16 +1,22 @@
 package memfs
 
 import (
+	"container/list"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
+
+	"gopkg.in/src-d/go-billy.v4"
 )
 
 type storage struct {
 	files    map[string]*file
 	children map[string]map[string]*file
+	// spill moves the content of the files to disk, if set.
+	spill *spiller
 }
 
 func newStorage() *storage {
@@ -41,7 +47,7 @@ func (s *storage) New(path string, mode os.FileMode, flag int) (*file, error) {
 
 	f := &file{
 		name:    name,
-		content: &content{name: name},
+		content: &content{name: name, spill: s.spill},
 		mode:    mode,
 		flag:    flag,
 	}
@@ -136,7 +142,9 @@ func (s *storage) Rename(from, to string) error {
 func (s *storage) move(from, to string) error {
 	s.files[to] = s.files[from]
 	s.files[to].name = filepath.Base(to)
-	s.children[to] = s.children[from]
+	if children, ok := s.children[from]; ok {
+		s.children[to] = children
+	}
 
 	defer func() {
 		delete(s.children, from)
@@ -174,6 +182,26 @@ func clean(path string) string {
 type content struct {
 	name  string
 	bytes []byte
+
+	// spill is set when the content may be moved to disk.
+	spill *spiller
+	// elem is the element of the content in the list of the spiller, while
+	// in memory.
+	elem *list.Element
+	// disk is the name of the file in the spill filesystem holding the
+	// content, once moved to disk, file is kept open to access it, and size
+	// is its size.
+	disk string
+	file billy.File
+	size int64
+	// mu guards file, so it's read without holding the mutex of the spiller,
+	// and isn't written or released meanwhile.
+	mu sync.RWMutex
+	// handles is the number of open handles of the content, and removed is
+	// set once its file is removed or replaced, to release it when the
+	// last handle is closed.
+	handles int
+	removed bool
 }
 
 func (c *content) WriteAt(p []byte, off int64) (int, error) {
@@ -185,6 +213,14 @@ func (c *content) WriteAt(p []byte, off int64) (int, error) {
 		}
 	}
 
+	if c.spill != nil {
+		return c.spill.writeAt(c, p, off)
+	}
+
+	return c.writeAt(p, off)
+}
+
+func (c *content) writeAt(p []byte, off int64) (int, error) {
 	prev := len(c.bytes)
 
 	diff := int(off) - prev
@@ -209,6 +245,14 @@ func (c *content) ReadAt(b []byte, off int64) (n int, err error) {
 		}
 	}
 
+	if c.spill != nil {
+		return c.spill.readAt(c, b, off)
+	}
+
+	return c.readAt(b, off)
+}
+
+func (c *content) readAt(b []byte, off int64) (n int, err error) {
 	size := int64(len(c.bytes))
 	if off >= size {
 		return 0, io.EOF
@@ -0,0 +1,214 @@
+package nfsfs
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// file is a file of the server, identified by its handle. NFS has no open
+// state, the position is kept by the client, sent as the offset of every
+// read and write.
+type file struct {
+	fs       *NFS
+	name     string
+	fh       []byte
+	flag     int
+	position int64
+	isClosed bool
+}
+
+func (f *file) Name() string {
+	return f.name
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	n, eof, err := f.read(p, f.position)
+	f.position += int64(n)
+	if err != nil {
+		return n, &os.PathError{Op: "read", Path: f.name, Err: err}
+	}
+
+	if n == 0 && len(p) != 0 && eof {
+		return 0, io.EOF
+	}
+
+	return n, nil
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	var n int
+	for n < len(p) {
+		m, eof, err := f.read(p[n:], off+int64(n))
+		n += m
+		if err != nil {
+			return n, &os.PathError{Op: "readat", Path: f.name, Err: err}
+		}
+
+		if m == 0 || (eof && n < len(p)) {
+			return n, io.EOF
+		}
+	}
+
+	return n, nil
+}
+
+// read reads up to rsize bytes at the given offset with READ.
+func (f *file) read(p []byte, off int64) (int, bool, error) {
+	if f.flag&os.O_WRONLY != 0 {
+		return 0, false, os.ErrPermission
+	}
+
+	if len(p) > int(f.fs.rsize) {
+		p = p[:f.fs.rsize]
+	}
+
+	e := &encoder{}
+	e.opaque(f.fh)
+	e.u64(uint64(off))
+	e.u32(uint32(len(p)))
+	d, err := f.fs.call(procRead, e)
+	if err != nil {
+		return 0, false, err
+	}
+
+	status := d.u32()
+	decodePostOpAttr(d)
+	if err := statusError(status); err != nil {
+		return 0, false, err
+	}
+
+	d.u32()
+	eof := d.bool()
+	n := copy(p, d.opaque())
+	return n, eof, d.err
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	if !isWrite(f.flag) {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrPermission}
+	}
+
+	if f.flag&os.O_APPEND != 0 {
+		a, err := f.fs.getattr(f.fh)
+		if err != nil {
+			return 0, &os.PathError{Op: "write", Path: f.name, Err: err}
+		}
+
+		f.position = int64(a.size)
+	}
+
+	var n int
+	for n < len(p) {
+		m, err := f.write(p[n:], f.position)
+		n += m
+		f.position += int64(m)
+		if err != nil {
+			return n, &os.PathError{Op: "write", Path: f.name, Err: err}
+		}
+
+		if m == 0 {
+			return n, io.ErrShortWrite
+		}
+	}
+
+	return n, nil
+}
+
+// write writes up to wsize bytes at the given offset with WRITE, stable
+// with FILE_SYNC.
+func (f *file) write(p []byte, off int64) (int, error) {
+	if len(p) > int(f.fs.wsize) {
+		p = p[:f.fs.wsize]
+	}
+
+	e := &encoder{}
+	e.opaque(f.fh)
+	e.u64(uint64(off))
+	e.u32(uint32(len(p)))
+	e.u32(fileSync)
+	e.opaque(p)
+	d, err := f.fs.call(procWrite, e)
+	if err != nil {
+		return 0, err
+	}
+
+	status := d.u32()
+	skipWcc(d)
+	if err := statusError(status); err != nil {
+		return 0, err
+	}
+
+	n := int(d.u32())
+	return n, d.err
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.position
+	case io.SeekEnd:
+		a, err := f.fs.getattr(f.fh)
+		if err != nil {
+			return 0, &os.PathError{Op: "seek", Path: f.name, Err: err}
+		}
+
+		offset += int64(a.size)
+	}
+
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: errors.New("negative position")}
+	}
+
+	f.position = offset
+	return f.position, nil
+}
+
+// Truncate changes the size of the file with SETATTR.
+func (f *file) Truncate(size int64) error {
+	if f.isClosed {
+		return os.ErrClosed
+	}
+
+	s := uint64(size)
+	if err := f.fs.setattr(f.fh, &sattr{size: &s}); err != nil {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: err}
+	}
+
+	return nil
+}
+
+// Lock is a no-op in NFS.
+func (f *file) Lock() error {
+	return nil
+}
+
+// Unlock is a no-op in NFS.
+func (f *file) Unlock() error {
+	return nil
+}
+
+func (f *file) Close() error {
+	if f.isClosed {
+		return os.ErrClosed
+	}
+
+	f.isClosed = true
+	return nil
+}
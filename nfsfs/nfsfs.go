@@ -0,0 +1,748 @@
+// Package nfsfs provides a billy filesystem over a NFSv3 export, with a pure
+// Go client, so the exports can be used without mounting them, eg.: in
+// containers without the privileges to do it.
+//
+// The client speaks ONC RPC over TCP, with AUTH_UNIX credentials. The ports
+// of the MOUNT and NFS services are asked to the portmapper, unless given.
+// The paths are resolved with LOOKUP from the root of the export, resolving
+// the symbolic links in the client. The writes are synchronous, with
+// FILE_SYNC, so no COMMIT is needed.
+package nfsfs // import "gopkg.in/src-d/go-billy.v4/nfsfs"
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/internal/linkutil"
+	"gopkg.in/src-d/go-billy.v4/internal/pathutil"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const (
+	defaultPortmapPort = 111
+	defaultTimeout     = 30 * time.Second
+	defaultIOSize      = 64 * 1024
+)
+
+var (
+	// ErrNotEmpty is returned when removing a directory with files.
+	ErrNotEmpty = billy.ErrNotEmpty
+
+	errIsDir   = errors.New("is a directory")
+	errNotDir  = errors.New("not a directory")
+	errNotLink = errors.New("not a symlink")
+)
+
+// Options holds the configuration of a NFS filesystem.
+type Options struct {
+	// UID and GID are the ids of the user sent in the AUTH_UNIX credentials,
+	// root by default.
+	UID, GID uint32
+	// MachineName is the name of the client sent in the AUTH_UNIX
+	// credentials, the host name by default.
+	MachineName string
+	// MountPort and NFSPort are the TCP ports of the MOUNT and NFS services,
+	// asked to the portmapper if zero.
+	MountPort, NFSPort int
+	// Timeout of the connections and of the calls, 30 seconds by default.
+	Timeout time.Duration
+}
+
+// NFS is a filesystem over a NFSv3 export.
+type NFS struct {
+	export  string
+	mount   *client
+	nfs     *client
+	root    *node
+	rsize   uint32
+	wsize   uint32
+	timeout time.Duration
+}
+
+// node is a file of the server, identified by its handle.
+type node struct {
+	fh   []byte
+	attr *attr
+}
+
+// Dial mounts the given export of the NFS server at the given address, eg.:
+// "nfs.example.com" or "nfs.example.com:111", with the port of the
+// portmapper.
+func Dial(addr, export string, opts Options) (*NFS, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = addr, strconv.Itoa(defaultPortmapPort)
+	}
+
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+
+	if opts.MachineName == "" {
+		opts.MachineName, _ = os.Hostname()
+	}
+
+	a := &auth{machineName: opts.MachineName, uid: opts.UID, gid: opts.GID}
+	if opts.MountPort == 0 || opts.NFSPort == 0 {
+		pm, err := dialRPC(net.JoinHostPort(host, port), a, opts.Timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		if opts.MountPort == 0 {
+			opts.MountPort, err = pm.getPort(mountProgram, mountVersion)
+		}
+
+		if err == nil && opts.NFSPort == 0 {
+			opts.NFSPort, err = pm.getPort(nfsProgram, nfsVersion)
+		}
+
+		pm.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fs := &NFS{export: export, timeout: opts.Timeout}
+	fs.mount, err = dialRPC(net.JoinHostPort(host, strconv.Itoa(opts.MountPort)), a, opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fs.mnt(); err != nil {
+		fs.mount.Close()
+		return nil, err
+	}
+
+	fs.nfs, err = dialRPC(net.JoinHostPort(host, strconv.Itoa(opts.NFSPort)), a, opts.Timeout)
+	if err == nil {
+		err = fs.fsinfo()
+	}
+
+	if err != nil {
+		fs.Close()
+		return nil, err
+	}
+
+	return fs, nil
+}
+
+// mnt mounts the export, getting the handle of its root.
+func (fs *NFS) mnt() error {
+	e := &encoder{}
+	e.str(fs.export)
+	d, err := fs.mount.call(mountProgram, mountVersion, procMount, e)
+	if err != nil {
+		return err
+	}
+
+	if err := statusError(d.u32()); err != nil {
+		return &os.PathError{Op: "mount", Path: fs.export, Err: err}
+	}
+
+	fs.root = &node{fh: d.opaque()}
+	return d.err
+}
+
+// fsinfo reads the attributes of the root and the preferred sizes of the
+// reads and writes.
+func (fs *NFS) fsinfo() error {
+	e := &encoder{}
+	e.opaque(fs.root.fh)
+	d, err := fs.call(procFsinfo, e)
+	if err != nil {
+		return err
+	}
+
+	if err := statusError(d.u32()); err != nil {
+		return err
+	}
+
+	fs.root.attr = decodePostOpAttr(d)
+
+	rtmax, rtpref, _ := d.u32(), d.u32(), d.u32()
+	wtmax, wtpref, _ := d.u32(), d.u32(), d.u32()
+	fs.rsize, fs.wsize = ioSize(rtmax, rtpref), ioSize(wtmax, wtpref)
+	return d.err
+}
+
+func ioSize(max, pref uint32) uint32 {
+	size := pref
+	if size == 0 {
+		size = defaultIOSize
+	}
+
+	if max != 0 && size > max {
+		size = max
+	}
+
+	return size
+}
+
+// Close unmounts the export and closes the connections.
+func (fs *NFS) Close() error {
+	if fs.nfs != nil {
+		fs.nfs.Close()
+	}
+
+	e := &encoder{}
+	e.str(fs.export)
+	_, err := fs.mount.call(mountProgram, mountVersion, procUnmount, e)
+	fs.mount.Close()
+	return err
+}
+
+// call calls the given procedure of NFS. The status of the results is left
+// to be read by the caller, since some results start by attributes.
+func (fs *NFS) call(proc uint32, args *encoder) (*decoder, error) {
+	return fs.nfs.call(nfsProgram, nfsVersion, proc, args)
+}
+
+// lookupName looks up the given name in the directory.
+func (fs *NFS) lookupName(dir *node, name string) (*node, error) {
+	e := &encoder{}
+	e.opaque(dir.fh)
+	e.str(name)
+	d, err := fs.call(procLookup, e)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := statusError(d.u32()); err != nil {
+		return nil, err
+	}
+
+	n := &node{fh: d.opaque(), attr: decodePostOpAttr(d)}
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	if n.attr == nil {
+		if n.attr, err = fs.getattr(n.fh); err != nil {
+			return nil, err
+		}
+	}
+
+	return n, nil
+}
+
+func (fs *NFS) getattr(fh []byte) (*attr, error) {
+	e := &encoder{}
+	e.opaque(fh)
+	d, err := fs.call(procGetattr, e)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := statusError(d.u32()); err != nil {
+		return nil, err
+	}
+
+	a := decodeAttr(d)
+	return a, d.err
+}
+
+func (fs *NFS) setattr(fh []byte, s *sattr) error {
+	e := &encoder{}
+	e.opaque(fh)
+	s.encode(e)
+	// no guard.
+	e.bool(false)
+	d, err := fs.call(procSetattr, e)
+	if err != nil {
+		return err
+	}
+
+	return statusError(d.u32())
+}
+
+// lookup returns the file of the given path, resolving the symbolic links
+// found, including the last element if follow is true.
+func (fs *NFS) lookup(p string, follow bool) (*node, error) {
+	_, n, err := linkutil.Resolve(path.Join(split(p)...), follow, fs.root, fs.child, fs.linkTarget)
+	if err != nil {
+		return nil, err
+	}
+
+	current := n.(*node)
+	if current.attr == nil {
+		a, err := fs.getattr(current.fh)
+		if err != nil {
+			return nil, err
+		}
+
+		current = &node{fh: current.fh, attr: a}
+	}
+
+	return current, nil
+}
+
+// child looks up the file with the given name in the directory dir, and
+// reports if it's a link, to resolve the paths with linkutil.Resolve.
+func (fs *NFS) child(dir interface{}, name string) (interface{}, bool, error) {
+	d := dir.(*node)
+	if d.attr != nil && d.attr.typ != typeDir {
+		return nil, false, errNotDir
+	}
+
+	n, err := fs.lookupName(d, name)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return n, n.attr.typ == typeLnk, nil
+}
+
+func (fs *NFS) linkTarget(link interface{}) (string, error) {
+	return fs.readlink(link.(*node).fh)
+}
+
+// parent returns the parent directory of the given path, and the name of
+// the file in it.
+func (fs *NFS) parent(p string) (*node, string, error) {
+	names := split(p)
+	if len(names) == 0 {
+		return nil, "", os.ErrExist
+	}
+
+	dir, err := fs.lookup(path.Join(names[:len(names)-1]...), true)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if dir.attr.typ != typeDir {
+		return nil, "", errNotDir
+	}
+
+	return dir, names[len(names)-1], nil
+}
+
+func (fs *NFS) readlink(fh []byte) (string, error) {
+	e := &encoder{}
+	e.opaque(fh)
+	d, err := fs.call(procReadlink, e)
+	if err != nil {
+		return "", err
+	}
+
+	status := d.u32()
+	decodePostOpAttr(d)
+	if err := statusError(status); err != nil {
+		return "", err
+	}
+
+	target := d.str()
+	return target, d.err
+}
+
+// create calls the given procedure creating a file, returning the new file.
+func (fs *NFS) create(proc uint32, p string, args func(e *encoder)) (*node, error) {
+	dir, name, err := fs.parent(p)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &encoder{}
+	e.opaque(dir.fh)
+	e.str(name)
+	args(e)
+	d, err := fs.call(proc, e)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := statusError(d.u32()); err != nil {
+		return nil, err
+	}
+
+	n := &node{}
+	if d.bool() {
+		n.fh = d.opaque()
+	}
+
+	n.attr = decodePostOpAttr(d)
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	// the handle is optional in the results, so it's looked up if missing.
+	if n.fh == nil || n.attr == nil {
+		return fs.lookupName(dir, name)
+	}
+
+	return n, nil
+}
+
+func (fs *NFS) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *NFS) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file, creating it with CREATE, and also the
+// missing directories, if needed. NFS has no open state, so the file is only
+// looked up.
+func (fs *NFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	f, err := fs.openFile(filename, flag, perm)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+	}
+
+	return f, nil
+}
+
+func (fs *NFS) openFile(filename string, flag int, perm os.FileMode) (*file, error) {
+	n, err := fs.lookup(filename, true)
+	switch {
+	case err == nil:
+		if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+			return nil, os.ErrExist
+		}
+
+		if n.attr.typ == typeDir && isWrite(flag) {
+			return nil, errIsDir
+		}
+
+		if flag&os.O_TRUNC != 0 && isWrite(flag) && n.attr.size != 0 {
+			var size uint64
+			if err := fs.setattr(n.fh, &sattr{size: &size}); err != nil {
+				return nil, err
+			}
+		}
+	case os.IsNotExist(err) && flag&os.O_CREATE != 0:
+		if err := fs.mkdirAll(path.Dir(filepath.ToSlash(filename)), 0755); err != nil {
+			return nil, err
+		}
+
+		how := uint32(createUnchecked)
+		if flag&os.O_EXCL != 0 {
+			how = createGuarded
+		}
+
+		mode := uint32(perm & os.ModePerm)
+		n, err = fs.create(procCreate, filename, func(e *encoder) {
+			e.u32(how)
+			(&sattr{mode: &mode}).encode(e)
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	return &file{fs: fs, name: pathutil.Relative(filename), fh: n.fh, flag: flag}, nil
+}
+
+func (fs *NFS) Stat(filename string) (os.FileInfo, error) {
+	n, err := fs.lookup(filename, true)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: filename, Err: err}
+	}
+
+	return &fileInfo{name: path.Base(filepath.ToSlash(filename)), attr: n.attr}, nil
+}
+
+func (fs *NFS) Lstat(filename string) (os.FileInfo, error) {
+	n, err := fs.lookup(filename, false)
+	if err != nil {
+		return nil, &os.PathError{Op: "lstat", Path: filename, Err: err}
+	}
+
+	return &fileInfo{name: path.Base(filepath.ToSlash(filename)), attr: n.attr}, nil
+}
+
+// ReadDir reads the directory with READDIRPLUS, returning the files sorted
+// by name.
+func (fs *NFS) ReadDir(filename string) ([]os.FileInfo, error) {
+	infos, err := fs.readDir(filename)
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: filename, Err: err}
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+
+	return infos, nil
+}
+
+func (fs *NFS) readDir(filename string) ([]os.FileInfo, error) {
+	dir, err := fs.lookup(filename, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if dir.attr.typ != typeDir {
+		return nil, errNotDir
+	}
+
+	var infos []os.FileInfo
+	var cookie uint64
+	verifier := make([]byte, 8)
+	for {
+		e := &encoder{}
+		e.opaque(dir.fh)
+		e.u64(cookie)
+		e.fixed(verifier)
+		e.u32(fs.rsize)
+		e.u32(fs.rsize)
+		d, err := fs.call(procReaddirplus, e)
+		if err != nil {
+			return nil, err
+		}
+
+		status := d.u32()
+		decodePostOpAttr(d)
+		if err := statusError(status); err != nil {
+			return nil, err
+		}
+
+		copy(verifier, d.fixed(8))
+		for d.bool() {
+			d.u64()
+			name := d.str()
+			cookie = d.u64()
+			a := decodePostOpAttr(d)
+			if d.bool() {
+				d.opaque()
+			}
+
+			if d.err != nil {
+				return nil, d.err
+			}
+
+			if name == "." || name == ".." {
+				continue
+			}
+
+			if a == nil {
+				n, err := fs.lookupName(dir, name)
+				if err != nil {
+					return nil, err
+				}
+
+				a = n.attr
+			}
+
+			infos = append(infos, &fileInfo{name: name, attr: a})
+		}
+
+		if eof := d.bool(); eof || d.err != nil {
+			return infos, d.err
+		}
+	}
+}
+
+// MkdirAll creates the directory and any missing parent with MKDIR.
+func (fs *NFS) MkdirAll(filename string, perm os.FileMode) error {
+	if err := fs.mkdirAll(filename, perm); err != nil {
+		return &os.PathError{Op: "mkdir", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *NFS) mkdirAll(p string, perm os.FileMode) error {
+	names := split(p)
+	for i := range names {
+		current := path.Join(names[:i+1]...)
+		n, err := fs.lookup(current, true)
+		if err == nil {
+			if n.attr.typ != typeDir {
+				return errNotDir
+			}
+
+			continue
+		}
+
+		if !os.IsNotExist(err) {
+			return err
+		}
+
+		mode := uint32(perm & os.ModePerm)
+		_, err = fs.create(procMkdir, current, func(e *encoder) {
+			(&sattr{mode: &mode}).encode(e)
+		})
+
+		if err != nil && !os.IsExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Rename renames the file with RENAME, creating the missing directories of
+// the destination.
+func (fs *NFS) Rename(from, to string) error {
+	if err := fs.rename(from, to); err != nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *NFS) rename(from, to string) error {
+	fromDir, fromName, err := fs.parent(from)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fs.lookupName(fromDir, fromName); err != nil {
+		return err
+	}
+
+	if err := fs.mkdirAll(path.Dir(filepath.ToSlash(to)), 0755); err != nil {
+		return err
+	}
+
+	toDir, toName, err := fs.parent(to)
+	if err != nil {
+		return err
+	}
+
+	e := &encoder{}
+	e.opaque(fromDir.fh)
+	e.str(fromName)
+	e.opaque(toDir.fh)
+	e.str(toName)
+	d, err := fs.call(procRename, e)
+	if err != nil {
+		return err
+	}
+
+	return statusError(d.u32())
+}
+
+// Remove removes the given file with REMOVE, or directory with RMDIR.
+func (fs *NFS) Remove(filename string) error {
+	if err := fs.remove(filename); err != nil {
+		return &os.PathError{Op: "remove", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *NFS) remove(p string) error {
+	dir, name, err := fs.parent(p)
+	if err != nil {
+		return err
+	}
+
+	n, err := fs.lookupName(dir, name)
+	if err != nil {
+		return err
+	}
+
+	proc := uint32(procRemove)
+	if n.attr.typ == typeDir {
+		proc = procRmdir
+	}
+
+	e := &encoder{}
+	e.opaque(dir.fh)
+	e.str(name)
+	d, err := fs.call(proc, e)
+	if err != nil {
+		return err
+	}
+
+	return statusError(d.u32())
+}
+
+// Symlink creates a symbolic link with SYMLINK, creating the missing
+// directories.
+func (fs *NFS) Symlink(target, link string) error {
+	if err := fs.symlink(target, link); err != nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *NFS) symlink(target, link string) error {
+	if _, err := fs.lookup(link, false); err == nil {
+		return os.ErrExist
+	}
+
+	if err := fs.mkdirAll(path.Dir(filepath.ToSlash(link)), 0755); err != nil {
+		return err
+	}
+
+	mode := uint32(0777)
+	_, err := fs.create(procSymlink, link, func(e *encoder) {
+		(&sattr{mode: &mode}).encode(e)
+		e.str(target)
+	})
+
+	return err
+}
+
+func (fs *NFS) Readlink(link string) (string, error) {
+	n, err := fs.lookup(link, false)
+	if err == nil && n.attr.typ != typeLnk {
+		err = errNotLink
+	}
+
+	var target string
+	if err == nil {
+		target, err = fs.readlink(n.fh)
+	}
+
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: link, Err: err}
+	}
+
+	return target, nil
+}
+
+func (fs *NFS) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(fs, dir, prefix)
+}
+
+func (fs *NFS) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (fs *NFS) Chroot(p string) (billy.Filesystem, error) {
+	return chroot.New(fs, fs.Join(fs.Root(), p)), nil
+}
+
+func (fs *NFS) Root() string {
+	return string(filepath.Separator)
+}
+
+// Capabilities implements the Capable interface.
+func (fs *NFS) Capabilities() billy.Capability {
+	return billy.WriteCapability | billy.ReadCapability |
+		billy.ReadAndWriteCapability | billy.SeekCapability |
+		billy.TruncateCapability
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR) != 0
+}
+
+// split returns the names of the elements of the given path, relative to
+// the root of the export.
+func split(p string) []string {
+	p = path.Clean("/" + filepath.ToSlash(p))
+	if p == "/" {
+		return nil
+	}
+
+	return strings.Split(p[1:], "/")
+}
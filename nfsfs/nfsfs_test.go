@@ -0,0 +1,202 @@
+package nfsfs
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4/internal/linkutil"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&NFSSuite{})
+
+type NFSSuite struct {
+	test.FilesystemSuite
+	FS     *NFS
+	server *server
+}
+
+func (s *NFSSuite) SetUpTest(c *C) {
+	s.server = newServer(c)
+	s.FS = s.newFS(c, Options{})
+	s.FilesystemSuite = test.NewFilesystemSuite(s.FS)
+}
+
+func (s *NFSSuite) TearDownTest(c *C) {
+	s.FS.Close()
+	s.server.Close()
+}
+
+func (s *NFSSuite) newFS(c *C, opts Options) *NFS {
+	fs, err := Dial(s.server.addr(), s.server.export, opts)
+	c.Assert(err, IsNil)
+	return fs
+}
+
+func (s *NFSSuite) TestDial(c *C) {
+	c.Assert(s.server.count("portmap.3"), Equals, 2)
+	c.Assert(s.server.count("mount.1"), Equals, 1)
+	c.Assert(s.server.count("nfs.19"), Equals, 1)
+	c.Assert(s.FS.rsize, Equals, uint32(4096))
+	c.Assert(s.FS.wsize, Equals, uint32(4096))
+}
+
+func (s *NFSSuite) TestDialPorts(c *C) {
+	fs := s.newFS(c, Options{MountPort: s.server.port(), NFSPort: s.server.port()})
+	c.Assert(s.server.count("portmap.3"), Equals, 2)
+	c.Assert(fs.Close(), IsNil)
+	c.Assert(s.server.count("mount.3"), Equals, 1)
+}
+
+func (s *NFSSuite) TestDialExportNotFound(c *C) {
+	_, err := Dial(s.server.addr(), "/foo", Options{})
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *NFSSuite) TestReadDirPages(c *C) {
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("foo/%d", i)
+		c.Assert(util.WriteFile(s.FS, name, nil, 0644), IsNil)
+	}
+
+	infos, err := s.FS.ReadDir("foo")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 10)
+	for i, fi := range infos {
+		c.Assert(fi.Name(), Equals, fmt.Sprint(i))
+		c.Assert(fi.Mode(), Equals, os.FileMode(0644))
+	}
+
+	// "." and ".." are returned too, four entries every call.
+	c.Assert(s.server.count("nfs.17"), Equals, 3)
+}
+
+func (s *NFSSuite) TestLargeFile(c *C) {
+	content := bytes.Repeat([]byte("0123456789"), 3000)
+	c.Assert(util.WriteFile(s.FS, "foo", content, 0644), IsNil)
+	c.Assert(s.server.count("nfs.7"), Equals, 8)
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, string(content))
+
+	f, err := s.FS.Open("foo")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	buf := make([]byte, 10000)
+	n, err := f.ReadAt(buf, 25000)
+	c.Assert(err, NotNil)
+	c.Assert(n, Equals, 5000)
+	c.Assert(buf[:n], DeepEquals, content[25000:])
+}
+
+func (s *NFSSuite) TestSymlinkLoop(c *C) {
+	c.Assert(s.FS.Symlink("bar", "foo"), IsNil)
+	c.Assert(s.FS.Symlink("foo", "bar"), IsNil)
+
+	_, err := s.FS.Stat("foo")
+	c.Assert(err.(*os.PathError).Err, Equals, linkutil.ErrTooManyLinks)
+
+	fi, err := s.FS.Lstat("foo")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode()&os.ModeSymlink, Not(Equals), os.FileMode(0))
+}
+
+func (s *NFSSuite) TestRenameDirAcrossDirs(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar/qux", []byte("foo"), 0644), IsNil)
+	c.Assert(s.FS.Rename("foo/bar", "baz/bar"), IsNil)
+
+	_, err := s.FS.Stat("foo/bar")
+	c.Assert(os.IsNotExist(err), Equals, true)
+	c.Assert(test.ReadFile(c, s.FS, "baz/bar/qux"), Equals, "foo")
+}
+
+func (s *NFSSuite) TestRemoveNotEmpty(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar", nil, 0644), IsNil)
+
+	err := s.FS.Remove("foo")
+	c.Assert(err.(*os.PathError).Err, Equals, ErrNotEmpty)
+}
+
+func (s *NFSSuite) TestStaleHandle(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+
+	f, err := s.FS.Open("foo")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	s.server.mu.Lock()
+	delete(s.server.handles, s.server.ids["/foo"])
+	s.server.mu.Unlock()
+
+	_, err = ioutil.ReadAll(f)
+	c.Assert(err.(*os.PathError).Err, DeepEquals, &Error{Status: statusStale})
+	c.Assert(err, ErrorMatches, "read foo: stale file handle")
+}
+
+func (s *NFSSuite) TestConcurrent(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f, err := s.FS.Open("foo")
+			if err == nil {
+				_, err = ioutil.ReadAll(f)
+				f.Close()
+			}
+
+			errs <- err
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		c.Assert(err, IsNil)
+	}
+}
+
+func (s *NFSSuite) TestClosed(c *C) {
+	c.Assert(s.FS.Close(), IsNil)
+	c.Assert(s.server.count("mount.3"), Equals, 1)
+
+	_, err := s.FS.Stat("foo")
+	c.Assert(err.(*os.PathError).Err, Equals, errClosed)
+}
+
+func (s *NFSSuite) TestStatusError(c *C) {
+	c.Assert(statusError(statusOK), IsNil)
+	c.Assert(statusError(statusNoEnt), Equals, os.ErrNotExist)
+	c.Assert(statusError(statusExist), Equals, os.ErrExist)
+	c.Assert(statusError(statusAcces), Equals, os.ErrPermission)
+	c.Assert(statusError(statusNotEmpty), Equals, ErrNotEmpty)
+	c.Assert(statusError(statusIO), ErrorMatches, "i/o error")
+	c.Assert(statusError(12345), ErrorMatches, "nfs error 12345")
+}
+
+func (s *NFSSuite) TestRecord(c *C) {
+	buf := bytes.NewBuffer(nil)
+	// a record of two fragments.
+	buf.Write([]byte{0, 0, 0, 3, 'f', 'o', 'o'})
+	buf.Write([]byte{0x80, 0, 0, 3, 'b', 'a', 'r'})
+
+	record, err := readRecord(buf)
+	c.Assert(err, IsNil)
+	c.Assert(string(record), Equals, "foobar")
+
+	c.Assert(writeRecord(buf, []byte("qux")), IsNil)
+	record, err = readRecord(buf)
+	c.Assert(err, IsNil)
+	c.Assert(string(record), Equals, "qux")
+}
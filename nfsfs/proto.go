@@ -0,0 +1,280 @@
+package nfsfs
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// The procedures of NFSv3 and MOUNTv3.
+const (
+	procGetattr     = 1
+	procSetattr     = 2
+	procLookup      = 3
+	procReadlink    = 5
+	procRead        = 6
+	procWrite       = 7
+	procCreate      = 8
+	procMkdir       = 9
+	procSymlink     = 10
+	procRemove      = 12
+	procRmdir       = 13
+	procRename      = 14
+	procReaddirplus = 17
+	procFsinfo      = 19
+
+	procMount   = 1
+	procUnmount = 3
+)
+
+// The types of the files.
+const (
+	typeReg  = 1
+	typeDir  = 2
+	typeBlk  = 3
+	typeChr  = 4
+	typeLnk  = 5
+	typeSock = 6
+	typeFifo = 7
+)
+
+const (
+	createUnchecked = 0
+	createGuarded   = 1
+
+	fileSync = 2
+
+	// maxHandleSize is the maximum size of the file handles of NFSv3.
+	maxHandleSize = 64
+)
+
+// The status of the results of NFSv3 recognized by the client.
+const (
+	statusOK       = 0
+	statusPerm     = 1
+	statusNoEnt    = 2
+	statusIO       = 5
+	statusAcces    = 13
+	statusExist    = 17
+	statusNotDir   = 20
+	statusIsDir    = 21
+	statusInval    = 22
+	statusNotEmpty = 66
+	statusStale    = 70
+	statusNotSupp  = 10004
+)
+
+var statusMessages = map[uint32]string{
+	statusPerm:     "not owner",
+	statusNoEnt:    "no such file or directory",
+	statusIO:       "i/o error",
+	statusAcces:    "permission denied",
+	statusExist:    "file exists",
+	statusNotDir:   "not a directory",
+	statusIsDir:    "is a directory",
+	statusInval:    "invalid argument",
+	statusNotEmpty: "directory not empty",
+	statusStale:    "stale file handle",
+	statusNotSupp:  "operation not supported",
+}
+
+// Error is an error status returned by the server, that doesn't match any
+// of the errors of the os package.
+type Error struct {
+	// Status is the nfsstat3 or mountstat3 code.
+	Status uint32
+}
+
+func (e *Error) Error() string {
+	if msg, ok := statusMessages[e.Status]; ok {
+		return msg
+	}
+
+	return fmt.Sprintf("nfs error %d", e.Status)
+}
+
+// statusError returns the error of the given status, nil if statusOK.
+func statusError(status uint32) error {
+	switch status {
+	case statusOK:
+		return nil
+	case statusNoEnt:
+		return os.ErrNotExist
+	case statusExist:
+		return os.ErrExist
+	case statusPerm, statusAcces:
+		return os.ErrPermission
+	case statusNotEmpty:
+		return ErrNotEmpty
+	}
+
+	return &Error{Status: status}
+}
+
+// attr holds the attributes of a file, a fattr3.
+type attr struct {
+	typ    uint32
+	mode   uint32
+	nlink  uint32
+	uid    uint32
+	gid    uint32
+	size   uint64
+	used   uint64
+	rdev   uint64
+	fsid   uint64
+	fileid uint64
+	atime  time.Time
+	mtime  time.Time
+	ctime  time.Time
+}
+
+func (a *attr) encode(e *encoder) {
+	e.u32(a.typ)
+	e.u32(a.mode)
+	e.u32(a.nlink)
+	e.u32(a.uid)
+	e.u32(a.gid)
+	e.u64(a.size)
+	e.u64(a.used)
+	e.u64(a.rdev)
+	e.u64(a.fsid)
+	e.u64(a.fileid)
+	encodeTime(e, a.atime)
+	encodeTime(e, a.mtime)
+	encodeTime(e, a.ctime)
+}
+
+func decodeAttr(d *decoder) *attr {
+	return &attr{
+		typ:    d.u32(),
+		mode:   d.u32(),
+		nlink:  d.u32(),
+		uid:    d.u32(),
+		gid:    d.u32(),
+		size:   d.u64(),
+		used:   d.u64(),
+		rdev:   d.u64(),
+		fsid:   d.u64(),
+		fileid: d.u64(),
+		atime:  decodeTime(d),
+		mtime:  decodeTime(d),
+		ctime:  decodeTime(d),
+	}
+}
+
+// decodePostOpAttr decodes a post_op_attr, returning nil if absent.
+func decodePostOpAttr(d *decoder) *attr {
+	if !d.bool() {
+		return nil
+	}
+
+	return decodeAttr(d)
+}
+
+// skipWcc skips a wcc_data.
+func skipWcc(d *decoder) {
+	if d.bool() {
+		d.u64()
+		d.u64()
+		d.u64()
+	}
+
+	decodePostOpAttr(d)
+}
+
+func encodeTime(e *encoder, t time.Time) {
+	e.u32(uint32(t.Unix()))
+	e.u32(uint32(t.Nanosecond()))
+}
+
+func decodeTime(d *decoder) time.Time {
+	return time.Unix(int64(d.u32()), int64(d.u32()))
+}
+
+// sattr holds the attributes to set in a file, a sattr3. Only the mode and
+// the size are supported.
+type sattr struct {
+	mode *uint32
+	size *uint64
+}
+
+func (s *sattr) encode(e *encoder) {
+	e.bool(s.mode != nil)
+	if s.mode != nil {
+		e.u32(*s.mode)
+	}
+
+	// uid and gid.
+	e.bool(false)
+	e.bool(false)
+
+	e.bool(s.size != nil)
+	if s.size != nil {
+		e.u64(*s.size)
+	}
+
+	// atime and mtime aren't changed.
+	e.u32(0)
+	e.u32(0)
+}
+
+func fileMode(a *attr) os.FileMode {
+	mode := os.FileMode(a.mode) & os.ModePerm
+	switch a.typ {
+	case typeDir:
+		mode |= os.ModeDir
+	case typeLnk:
+		mode |= os.ModeSymlink
+	case typeBlk:
+		mode |= os.ModeDevice
+	case typeChr:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case typeSock:
+		mode |= os.ModeSocket
+	case typeFifo:
+		mode |= os.ModeNamedPipe
+	}
+
+	if a.mode&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+
+	if a.mode&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+
+	if a.mode&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+
+	return mode
+}
+
+type fileInfo struct {
+	name string
+	attr *attr
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return int64(fi.attr.size)
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	return fileMode(fi.attr)
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.attr.mtime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.attr.typ == typeDir
+}
+
+func (*fileInfo) Sys() interface{} {
+	return nil
+}
@@ -0,0 +1,297 @@
+package nfsfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// The programs of ONC RPC used.
+const (
+	portmapProgram = 100000
+	portmapVersion = 2
+	mountProgram   = 100005
+	mountVersion   = 3
+	nfsProgram     = 100003
+	nfsVersion     = 3
+)
+
+const (
+	msgCall  = 0
+	msgReply = 1
+
+	replyAccepted = 0
+	replyDenied   = 1
+
+	authNone = 0
+	authUnix = 1
+
+	// lastFragment is the bit of the record mark of the last fragment.
+	lastFragment = 1 << 31
+	// maxRecordSize is the maximum size of the records read.
+	maxRecordSize = 16 << 20
+)
+
+var (
+	errClosed = errors.New("connection closed")
+
+	acceptErrors = map[uint32]error{
+		1: errors.New("rpc: program unavailable"),
+		2: errors.New("rpc: program version mismatch"),
+		3: errors.New("rpc: procedure unavailable"),
+		4: errors.New("rpc: garbage arguments"),
+		5: errors.New("rpc: system error"),
+	}
+
+	errRPCMismatch = errors.New("rpc: version mismatch")
+	errAuth        = errors.New("rpc: authentication error")
+)
+
+// auth holds the AUTH_UNIX credentials of the calls.
+type auth struct {
+	machineName string
+	uid, gid    uint32
+}
+
+func (a *auth) encode(e *encoder) {
+	body := &encoder{}
+	body.u32(uint32(time.Now().Unix()))
+	body.str(a.machineName)
+	body.u32(a.uid)
+	body.u32(a.gid)
+	body.u32(0)
+
+	e.u32(authUnix)
+	e.opaque(body.b)
+}
+
+// readRecord reads a record, joining its fragments.
+func readRecord(r io.Reader) ([]byte, error) {
+	var record []byte
+	for {
+		var mark [4]byte
+		if _, err := io.ReadFull(r, mark[:]); err != nil {
+			return nil, err
+		}
+
+		m := binary.BigEndian.Uint32(mark[:])
+		size := m &^ lastFragment
+		if len(record)+int(size) > maxRecordSize {
+			return nil, fmt.Errorf("record too large: %d", len(record)+int(size))
+		}
+
+		fragment := make([]byte, size)
+		if _, err := io.ReadFull(r, fragment); err != nil {
+			return nil, err
+		}
+
+		record = append(record, fragment...)
+		if m&lastFragment != 0 {
+			return record, nil
+		}
+	}
+}
+
+// writeRecord writes a record in a single fragment.
+func writeRecord(w io.Writer, record []byte) error {
+	e := &encoder{b: make([]byte, 0, 4+len(record))}
+	e.u32(lastFragment | uint32(len(record)))
+	e.b = append(e.b, record...)
+
+	_, err := w.Write(e.b)
+	return err
+}
+
+type reply struct {
+	d   *decoder
+	err error
+}
+
+// client is a ONC RPC client over TCP, the calls are sent concurrently,
+// matching the replies by their xids.
+type client struct {
+	conn    net.Conn
+	auth    *auth
+	timeout time.Duration
+
+	wmu sync.Mutex
+
+	mu    sync.Mutex
+	xid   uint32
+	calls map[uint32]chan reply
+	err   error
+}
+
+func dialRPC(addr string, a *auth, timeout time.Duration) (*client, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &client{
+		conn:    conn,
+		auth:    a,
+		timeout: timeout,
+		xid:     uint32(time.Now().UnixNano()),
+		calls:   make(map[uint32]chan reply),
+	}
+
+	go c.read()
+	return c, nil
+}
+
+// call calls the given procedure, returning the decoder of its results.
+func (c *client) call(prog, vers, proc uint32, args *encoder) (*decoder, error) {
+	ch := make(chan reply, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+
+	c.xid++
+	xid := c.xid
+	c.calls[xid] = ch
+	c.mu.Unlock()
+
+	e := &encoder{}
+	e.u32(xid)
+	e.u32(msgCall)
+	e.u32(2)
+	e.u32(prog)
+	e.u32(vers)
+	e.u32(proc)
+	c.auth.encode(e)
+	e.u32(authNone)
+	e.u32(0)
+	if args != nil {
+		e.b = append(e.b, args.b...)
+	}
+
+	c.wmu.Lock()
+	err := writeRecord(c.conn, e.b)
+	c.wmu.Unlock()
+	if err != nil {
+		c.fail(err)
+	}
+
+	var timeout <-chan time.Time
+	if c.timeout > 0 {
+		t := time.NewTimer(c.timeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	select {
+	case r := <-ch:
+		return r.d, r.err
+	case <-timeout:
+		c.mu.Lock()
+		delete(c.calls, xid)
+		c.mu.Unlock()
+		return nil, fmt.Errorf("rpc: timeout after %s", c.timeout)
+	}
+}
+
+func (c *client) read() {
+	for {
+		record, err := readRecord(c.conn)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+
+		d := &decoder{b: record}
+		xid := d.u32()
+		c.mu.Lock()
+		ch := c.calls[xid]
+		delete(c.calls, xid)
+		c.mu.Unlock()
+
+		if ch != nil {
+			err := parseReply(d)
+			ch <- reply{d: d, err: err}
+		}
+	}
+}
+
+// parseReply reads the header of a reply, returning an error if the call
+// wasn't successful.
+func parseReply(d *decoder) error {
+	if d.u32() != msgReply {
+		return errors.New("rpc: unexpected message")
+	}
+
+	if d.u32() == replyDenied {
+		if d.u32() == 0 {
+			return errRPCMismatch
+		}
+
+		return errAuth
+	}
+
+	// the verifier of the server is ignored.
+	d.u32()
+	d.opaque()
+	if stat := d.u32(); stat != 0 {
+		if err, ok := acceptErrors[stat]; ok {
+			return err
+		}
+
+		return fmt.Errorf("rpc: unexpected status %d", stat)
+	}
+
+	return d.err
+}
+
+// fail closes the connection, failing the pending and following calls with
+// the given error.
+func (c *client) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return
+	}
+
+	c.err = err
+	for xid, ch := range c.calls {
+		ch <- reply{err: err}
+		delete(c.calls, xid)
+	}
+
+	c.conn.Close()
+}
+
+func (c *client) Close() error {
+	c.fail(errClosed)
+	return nil
+}
+
+// getPort asks the portmapper for the TCP port of the given program.
+func (c *client) getPort(prog, vers uint32) (int, error) {
+	e := &encoder{}
+	e.u32(prog)
+	e.u32(vers)
+	e.u32(6)
+	e.u32(0)
+	d, err := c.call(portmapProgram, portmapVersion, 3, e)
+	if err != nil {
+		return 0, err
+	}
+
+	port := d.u32()
+	if d.err != nil {
+		return 0, d.err
+	}
+
+	if port == 0 {
+		return 0, fmt.Errorf("program %d not registered", prog)
+	}
+
+	return int(port), nil
+}
@@ -0,0 +1,568 @@
+package nfsfs
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+
+	. "gopkg.in/check.v1"
+)
+
+// server is a minimal portmapper, MOUNT and NFSv3 server over a memfs, to
+// test the client. All the programs are served in the same port, and the
+// handles are ids of the paths of the files.
+type server struct {
+	fs     billy.Filesystem
+	ln     net.Listener
+	export string
+	// entries is the maximum number of entries of every READDIRPLUS reply.
+	entries int
+	// iosize is the maximum size of the reads and writes.
+	iosize uint32
+
+	mu      sync.Mutex
+	next    uint64
+	handles map[uint64]string
+	ids     map[string]uint64
+	calls   map[string]int
+}
+
+func newServer(c *C) *server {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+
+	s := &server{
+		fs:      memfs.New(),
+		ln:      ln,
+		export:  "/export",
+		entries: 4,
+		iosize:  4096,
+		handles: make(map[uint64]string),
+		ids:     make(map[string]uint64),
+		calls:   make(map[string]int),
+	}
+
+	// memfs has no root until a file is created.
+	c.Assert(s.fs.MkdirAll("/", 0755), IsNil)
+
+	go s.accept()
+	return s
+}
+
+func (s *server) addr() string {
+	return s.ln.Addr().String()
+}
+
+func (s *server) port() int {
+	_, port, _ := net.SplitHostPort(s.addr())
+	p, _ := strconv.Atoi(port)
+	return p
+}
+
+func (s *server) Close() error {
+	return s.ln.Close()
+}
+
+// count returns the number of calls to the given procedure, eg.:
+// "nfs.17".
+func (s *server) count(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.calls[name]
+}
+
+func (s *server) accept() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		go s.serve(conn)
+	}
+}
+
+func (s *server) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		record, err := readRecord(conn)
+		if err != nil {
+			return
+		}
+
+		d := &decoder{b: record}
+		xid, _, _ := d.u32(), d.u32(), d.u32()
+		prog, _, proc := d.u32(), d.u32(), d.u32()
+		d.u32()
+		d.opaque()
+		d.u32()
+		d.opaque()
+
+		e := &encoder{}
+		e.u32(xid)
+		e.u32(msgReply)
+		e.u32(replyAccepted)
+		e.u32(authNone)
+		e.u32(0)
+
+		s.mu.Lock()
+		s.calls[programName(prog)+"."+strconv.Itoa(int(proc))]++
+		if !s.handle(prog, proc, d, e) {
+			// PROC_UNAVAIL, replacing the results.
+			e.b = e.b[:20]
+			e.u32(3)
+		}
+		s.mu.Unlock()
+
+		if err := writeRecord(conn, e.b); err != nil {
+			return
+		}
+	}
+}
+
+func programName(prog uint32) string {
+	switch prog {
+	case portmapProgram:
+		return "portmap"
+	case mountProgram:
+		return "mount"
+	}
+
+	return "nfs"
+}
+
+func (s *server) handle(prog, proc uint32, d *decoder, e *encoder) bool {
+	switch {
+	case prog == portmapProgram && proc == 3:
+		e.u32(0)
+		if p := d.u32(); p == mountProgram || p == nfsProgram {
+			e.u32(uint32(s.port()))
+		} else {
+			e.u32(0)
+		}
+	case prog == mountProgram && proc == procMount:
+		e.u32(0)
+		if d.str() != s.export {
+			e.u32(statusNoEnt)
+			break
+		}
+
+		e.u32(statusOK)
+		e.opaque(s.handleOf("/"))
+		e.u32(1)
+		e.u32(authUnix)
+	case prog == mountProgram && proc == procUnmount:
+		e.u32(0)
+	case prog == nfsProgram:
+		e.u32(0)
+		return s.nfs(proc, d, e)
+	default:
+		return false
+	}
+
+	return true
+}
+
+func (s *server) handleOf(p string) []byte {
+	id, ok := s.ids[p]
+	if !ok {
+		s.next++
+		id = s.next
+		s.ids[p], s.handles[id] = id, p
+	}
+
+	fh := make([]byte, 8)
+	binary.BigEndian.PutUint64(fh, id)
+	return fh
+}
+
+func (s *server) path(fh []byte) (string, error) {
+	if len(fh) != 8 {
+		return "", &Error{Status: statusStale}
+	}
+
+	p, ok := s.handles[binary.BigEndian.Uint64(fh)]
+	if !ok {
+		return "", &Error{Status: statusStale}
+	}
+
+	return p, nil
+}
+
+// rename updates the paths of the handles of the renamed file and its
+// children.
+func (s *server) rename(from, to string) {
+	for p, id := range s.ids {
+		if p != from && !strings.HasPrefix(p, from+"/") {
+			continue
+		}
+
+		delete(s.ids, p)
+		p = to + strings.TrimPrefix(p, from)
+		s.ids[p], s.handles[id] = id, p
+	}
+}
+
+func (s *server) attr(p string) (*attr, error) {
+	fi, err := s.fs.Lstat(p)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &attr{
+		typ:   typeReg,
+		mode:  uint32(fi.Mode() & os.ModePerm),
+		nlink: 1,
+		size:  uint64(fi.Size()),
+		atime: fi.ModTime(),
+		mtime: fi.ModTime(),
+		ctime: fi.ModTime(),
+	}
+
+	switch {
+	case fi.IsDir():
+		a.typ = typeDir
+	case fi.Mode()&os.ModeSymlink != 0:
+		a.typ = typeLnk
+	}
+
+	a.fileid = binary.BigEndian.Uint64(s.handleOf(p))
+	return a, nil
+}
+
+func (s *server) postOpAttr(p string, e *encoder) {
+	a, err := s.attr(p)
+	e.bool(err == nil)
+	if err == nil {
+		a.encode(e)
+	}
+}
+
+func status(err error) uint32 {
+	switch {
+	case err == nil:
+		return statusOK
+	case os.IsNotExist(err):
+		return statusNoEnt
+	case os.IsExist(err):
+		return statusExist
+	}
+
+	if err, ok := err.(*Error); ok {
+		return err.Status
+	}
+
+	return statusIO
+}
+
+func (s *server) nfs(proc uint32, d *decoder, e *encoder) bool {
+	var p string
+	var err error
+	switch proc {
+	case procGetattr, procSetattr, procLookup, procReadlink, procRead,
+		procWrite, procCreate, procMkdir, procSymlink, procRemove,
+		procRmdir, procRename, procReaddirplus, procFsinfo:
+		p, err = s.path(d.opaque())
+	default:
+		return false
+	}
+
+	if err == nil {
+		err = s.call(proc, p, d, e)
+	}
+
+	if err != nil {
+		// the results of the errors are replaced by the status followed by
+		// empty attributes, that matches any procedure used.
+		e.b = e.b[:24]
+		e.u32(status(err))
+		e.u32(0)
+		e.u32(0)
+	}
+
+	return true
+}
+
+func (s *server) call(proc uint32, p string, d *decoder, e *encoder) error {
+	switch proc {
+	case procGetattr:
+		a, err := s.attr(p)
+		if err != nil {
+			return err
+		}
+
+		e.u32(statusOK)
+		a.encode(e)
+	case procSetattr:
+		if d.bool() {
+			d.u32()
+		}
+
+		d.bool()
+		d.bool()
+		if d.bool() {
+			if err := s.truncate(p, int64(d.u64())); err != nil {
+				return err
+			}
+		}
+
+		e.u32(statusOK)
+		e.bool(false)
+		s.postOpAttr(p, e)
+	case procLookup:
+		name := path.Join(p, d.str())
+		if _, err := s.fs.Lstat(name); err != nil {
+			return err
+		}
+
+		e.u32(statusOK)
+		e.opaque(s.handleOf(name))
+		s.postOpAttr(name, e)
+		s.postOpAttr(p, e)
+	case procReadlink:
+		target, err := s.fs.Readlink(p)
+		if err != nil {
+			return err
+		}
+
+		e.u32(statusOK)
+		s.postOpAttr(p, e)
+		e.str(target)
+	case procRead:
+		return s.read(p, int64(d.u64()), d.u32(), e)
+	case procWrite:
+		return s.write(p, d, e)
+	case procCreate, procMkdir, procSymlink:
+		return s.create(proc, p, d, e)
+	case procRemove, procRmdir:
+		name := path.Join(p, d.str())
+		fi, err := s.fs.Lstat(name)
+		if err != nil {
+			return err
+		}
+
+		if fi.IsDir() != (proc == procRmdir) {
+			if fi.IsDir() {
+				return &Error{Status: statusIsDir}
+			}
+
+			return &Error{Status: statusNotDir}
+		}
+
+		if infos, _ := s.fs.ReadDir(name); len(infos) != 0 {
+			return &Error{Status: statusNotEmpty}
+		}
+
+		if err := s.fs.Remove(name); err != nil {
+			return err
+		}
+
+		e.u32(statusOK)
+		e.bool(false)
+		s.postOpAttr(p, e)
+	case procRename:
+		from := path.Join(p, d.str())
+		toDir, err := s.path(d.opaque())
+		if err != nil {
+			return err
+		}
+
+		to := path.Join(toDir, d.str())
+		if err := s.fs.Rename(from, to); err != nil {
+			return err
+		}
+
+		s.rename(from, to)
+		e.u32(statusOK)
+		e.bool(false)
+		e.bool(false)
+		e.bool(false)
+		e.bool(false)
+	case procReaddirplus:
+		return s.readdirplus(p, d.u64(), e)
+	case procFsinfo:
+		e.u32(statusOK)
+		s.postOpAttr(p, e)
+		e.u32(s.iosize)
+		e.u32(s.iosize)
+		e.u32(1)
+		e.u32(s.iosize)
+		e.u32(s.iosize)
+		e.u32(1)
+		e.u32(s.iosize)
+		e.u64(1 << 62)
+		e.u32(0)
+		e.u32(1)
+		e.u32(0)
+	}
+
+	return nil
+}
+
+func (s *server) truncate(p string, size int64) error {
+	f, err := s.fs.OpenFile(p, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+	return f.Truncate(size)
+}
+
+func (s *server) read(p string, offset int64, count uint32, e *encoder) error {
+	if count > s.iosize {
+		count = s.iosize
+	}
+
+	f, err := s.fs.Open(p)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+	buf := make([]byte, count)
+	n, err := f.ReadAt(buf, offset)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	e.u32(statusOK)
+	s.postOpAttr(p, e)
+	e.u32(uint32(n))
+	e.bool(err == io.EOF)
+	e.opaque(buf[:n])
+	return nil
+}
+
+func (s *server) write(p string, d *decoder, e *encoder) error {
+	offset, _, _ := d.u64(), d.u32(), d.u32()
+	data := d.opaque()
+	if len(data) > int(s.iosize) {
+		data = data[:s.iosize]
+	}
+
+	f, err := s.fs.OpenFile(p, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+	if _, err := f.Seek(int64(offset), io.SeekStart); err != nil {
+		return err
+	}
+
+	n, err := f.Write(data)
+	if err != nil {
+		return err
+	}
+
+	e.u32(statusOK)
+	e.bool(false)
+	s.postOpAttr(p, e)
+	e.u32(uint32(n))
+	e.u32(fileSync)
+	e.fixed(make([]byte, 8))
+	return nil
+}
+
+func (s *server) create(proc uint32, dir string, d *decoder, e *encoder) error {
+	name := path.Join(dir, d.str())
+	_, err := s.fs.Lstat(name)
+	exists := err == nil
+
+	how := uint32(createGuarded)
+	if proc == procCreate {
+		how = d.u32()
+	}
+
+	var mode os.FileMode = 0644
+	if d.bool() {
+		mode = os.FileMode(d.u32())
+	}
+
+	switch {
+	case exists && (proc != procCreate || how != createUnchecked):
+		return os.ErrExist
+	case exists:
+	case proc == procCreate:
+		var f billy.File
+		f, err = s.fs.OpenFile(name, os.O_RDWR|os.O_CREATE, mode)
+		if err == nil {
+			err = f.Close()
+		}
+	case proc == procMkdir:
+		err = s.fs.MkdirAll(name, mode)
+	case proc == procSymlink:
+		d.bool()
+		d.bool()
+		if d.bool() {
+			d.u64()
+		}
+
+		d.u32()
+		d.u32()
+		err = s.fs.Symlink(d.str(), name)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	e.u32(statusOK)
+	e.bool(true)
+	e.opaque(s.handleOf(name))
+	s.postOpAttr(name, e)
+	e.bool(false)
+	s.postOpAttr(dir, e)
+	return nil
+}
+
+// readdirplus returns up to entries files from the given cookie, the index
+// of the next file.
+func (s *server) readdirplus(p string, cookie uint64, e *encoder) error {
+	infos, err := s.fs.ReadDir(p)
+	if err != nil {
+		return err
+	}
+
+	names := []string{".", ".."}
+	for _, fi := range infos {
+		names = append(names, fi.Name())
+	}
+
+	sort.Strings(names[2:])
+
+	e.u32(statusOK)
+	s.postOpAttr(p, e)
+	e.fixed(make([]byte, 8))
+	i := int(cookie)
+	for ; i < len(names) && i < int(cookie)+s.entries; i++ {
+		name := path.Join(p, names[i])
+		e.bool(true)
+		e.u64(uint64(i))
+		e.str(names[i])
+		e.u64(uint64(i + 1))
+		// the attributes of the even files are left to be looked up.
+		if i%2 == 0 {
+			e.bool(false)
+		} else {
+			s.postOpAttr(name, e)
+		}
+
+		e.bool(false)
+	}
+
+	e.bool(false)
+	e.bool(i >= len(names))
+	return nil
+}
@@ -0,0 +1,111 @@
+package nfsfs
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errShortMessage = errors.New("short message")
+
+// encoder encodes XDR values, in big-endian and padded to four bytes.
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) u32(v uint32) {
+	e.b = append(e.b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (e *encoder) u64(v uint64) {
+	e.u32(uint32(v >> 32))
+	e.u32(uint32(v))
+}
+
+func (e *encoder) bool(v bool) {
+	if v {
+		e.u32(1)
+	} else {
+		e.u32(0)
+	}
+}
+
+// fixed appends an opaque value of fixed size.
+func (e *encoder) fixed(p []byte) {
+	e.b = append(e.b, p...)
+	if pad := len(p) % 4; pad != 0 {
+		e.b = append(e.b, make([]byte, 4-pad)...)
+	}
+}
+
+// opaque appends an opaque value of variable size.
+func (e *encoder) opaque(p []byte) {
+	e.u32(uint32(len(p)))
+	e.fixed(p)
+}
+
+func (e *encoder) str(s string) {
+	e.opaque([]byte(s))
+}
+
+// decoder decodes XDR values, the first error is kept in err and the
+// following reads return zero values.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil || n < 0 || len(d.b) < n {
+		if d.err == nil {
+			d.err = errShortMessage
+		}
+
+		return make([]byte, 8)
+	}
+
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b
+}
+
+func (d *decoder) u32() uint32 {
+	return binary.BigEndian.Uint32(d.next(4))
+}
+
+func (d *decoder) u64() uint64 {
+	return binary.BigEndian.Uint64(d.next(8))
+}
+
+func (d *decoder) bool() bool {
+	return d.u32() != 0
+}
+
+func (d *decoder) fixed(n int) []byte {
+	b := d.next(n)
+	if pad := n % 4; pad != 0 {
+		d.next(4 - pad)
+	}
+
+	if d.err != nil {
+		return nil
+	}
+
+	return b[:n]
+}
+
+func (d *decoder) opaque() []byte {
+	n := d.u32()
+	if d.err != nil || n > uint32(len(d.b)) {
+		if d.err == nil {
+			d.err = errShortMessage
+		}
+
+		return nil
+	}
+
+	return d.fixed(int(n))
+}
+
+func (d *decoder) str() string {
+	return string(d.opaque())
+}
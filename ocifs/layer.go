@@ -0,0 +1,188 @@
+package ocifs
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"gopkg.in/src-d/go-billy.v4/internal/linkutil"
+)
+
+const (
+	whiteoutPrefix = ".wh."
+	opaqueWhiteout = ".wh..wh..opq"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// decompress returns the tar archive of a layer, detecting its compression
+// from its first bytes.
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, zstdMagic):
+		d, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+
+		return d.IOReadCloser(), nil
+	default:
+		return ioutil.NopCloser(br), nil
+	}
+}
+
+// apply stacks the given layer on top of the current tree.
+func (fs *Image) apply(layer int, r io.Reader) error {
+	rc, err := decompress(r)
+	if err != nil {
+		return err
+	}
+
+	defer rc.Close()
+	tr := tar.NewReader(rc)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := fs.add(layer, h, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func (fs *Image) add(layer int, h *tar.Header, tr *tar.Reader) error {
+	name := clean(h.Name)
+	if name == "" {
+		return nil
+	}
+
+	base := path.Base(name)
+	switch {
+	case base == opaqueWhiteout:
+		dir := fs.mkdirAll(linkutil.Parent(name), layer)
+		for child, n := range dir.children {
+			if n.layer < layer {
+				delete(dir.children, child)
+			}
+		}
+
+		return nil
+	case strings.HasPrefix(base, whiteoutPrefix):
+		if dir := fs.lookup(linkutil.Parent(name)); dir != nil && dir.dir {
+			delete(dir.children, strings.TrimPrefix(base, whiteoutPrefix))
+		}
+
+		return nil
+	}
+
+	n := &node{header: h, layer: layer}
+	switch h.Typeflag {
+	case tar.TypeDir:
+		n.dir, n.children = true, make(map[string]*node)
+	case tar.TypeReg, tar.TypeRegA, tar.TypeGNUSparse:
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+
+		n.data = data
+	case tar.TypeLink:
+		target := fs.lookup(clean(h.Linkname))
+		if target == nil || target.dir {
+			return fmt.Errorf("invalid hard link %q to %q", h.Name, h.Linkname)
+		}
+
+		n.header, n.data = linkHeader(h, target.header), target.data
+	case tar.TypeSymlink:
+	default:
+		// devices, fifos and other special files are not supported.
+		return nil
+	}
+
+	dir := fs.mkdirAll(linkutil.Parent(name), layer)
+	if old, ok := dir.children[base]; ok && old.dir && n.dir {
+		// a directory of a lower layer is merged with the new one, only its
+		// metadata is updated.
+		old.header, old.layer = h, layer
+		return nil
+	}
+
+	dir.children[base] = n
+	return nil
+}
+
+// lookup returns the node with the given name, without following symlinks,
+// or nil if it doesn't exist.
+func (fs *Image) lookup(name string) *node {
+	n := fs.root
+	for _, part := range strings.Split(name, "/") {
+		if part == "" {
+			continue
+		}
+
+		if !n.dir {
+			return nil
+		}
+
+		if n = n.children[part]; n == nil {
+			return nil
+		}
+	}
+
+	return n
+}
+
+// mkdirAll returns the directory with the given name, creating it and its
+// parents if needed, replacing any non-directory in the way, and marking all
+// of them as part of the given layer.
+func (fs *Image) mkdirAll(name string, layer int) *node {
+	n := fs.root
+	n.layer = layer
+	for _, part := range strings.Split(name, "/") {
+		if part == "" {
+			continue
+		}
+
+		child, ok := n.children[part]
+		if !ok || !child.dir {
+			child = newDir(layer)
+			n.children[part] = child
+		}
+
+		child.layer = layer
+		n = child
+	}
+
+	return n
+}
+
+func linkHeader(link, target *tar.Header) *tar.Header {
+	h := *target
+	h.Name = link.Name
+	return &h
+}
@@ -0,0 +1,228 @@
+package ocifs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+const (
+	refNameAnnotation = "org.opencontainers.image.ref.name"
+
+	mediaTypeIndex        = "application/vnd.oci.image.index.v1+json"
+	mediaTypeManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	maxIndexDepth         = 8
+)
+
+var (
+	// ErrInvalidLayout is returned when the storage contains neither an OCI
+	// image layout nor the content of a docker save archive.
+	ErrInvalidLayout = errors.New("invalid image layout")
+	// ErrImageNotFound is returned when the layout doesn't contain the image
+	// with the given reference.
+	ErrImageNotFound = errors.New("image not found")
+)
+
+// descriptor is a reference to a blob of an OCI image layout.
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Annotations map[string]string `json:"annotations"`
+	Platform    *struct {
+		OS           string `json:"os"`
+		Architecture string `json:"architecture"`
+	} `json:"platform"`
+}
+
+type index struct {
+	Manifests []descriptor `json:"manifests"`
+}
+
+type manifest struct {
+	Layers []descriptor `json:"layers"`
+}
+
+// dockerManifest is an entry of the manifest.json file of a docker save
+// archive.
+type dockerManifest struct {
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// NewFromLayout returns a new read-only filesystem with the image stored in
+// the given filesystem, an OCI image layout or the extracted content of a
+// docker save archive. The image is the one with the given reference, its
+// "org.opencontainers.image.ref.name" annotation or one of its tags, or the
+// first one if ref is empty. For multi-platform images, the manifest for the
+// current architecture is used if any.
+func NewFromLayout(storage billy.Filesystem, ref string) (billy.Filesystem, error) {
+	layers, err := layoutLayers(storage, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	var readers []io.Reader
+	for _, name := range layers {
+		f, err := storage.Open(name)
+		if err != nil {
+			return nil, err
+		}
+
+		defer f.Close()
+		readers = append(readers, f)
+	}
+
+	return New(readers...)
+}
+
+// layoutLayers returns the filenames of the layers of the image with the
+// given reference, from the base one.
+func layoutLayers(storage billy.Filesystem, ref string) ([]string, error) {
+	var idx index
+	err := readJSON(storage, "index.json", &idx)
+	if os.IsNotExist(err) {
+		return dockerLayers(storage, ref)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range idx.Manifests {
+		if ref != "" && d.Annotations[refNameAnnotation] != ref {
+			continue
+		}
+
+		m, err := readManifest(storage, d, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		var layers []string
+		for _, l := range m.Layers {
+			name, err := blobPath(l.Digest)
+			if err != nil {
+				return nil, err
+			}
+
+			layers = append(layers, name)
+		}
+
+		return layers, nil
+	}
+
+	return nil, ErrImageNotFound
+}
+
+// readManifest reads the manifest of the given descriptor, selecting the one
+// for the current platform if it is an index.
+func readManifest(storage billy.Filesystem, d descriptor, depth int) (*manifest, error) {
+	if depth > maxIndexDepth {
+		return nil, ErrInvalidLayout
+	}
+
+	name, err := blobPath(d.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.MediaType != mediaTypeIndex && d.MediaType != mediaTypeManifestList {
+		m := &manifest{}
+		return m, readJSON(storage, name, m)
+	}
+
+	var idx index
+	if err := readJSON(storage, name, &idx); err != nil {
+		return nil, err
+	}
+
+	if len(idx.Manifests) == 0 {
+		return nil, ErrImageNotFound
+	}
+
+	selected := idx.Manifests[0]
+	for _, m := range idx.Manifests {
+		if m.Platform != nil && m.Platform.OS == "linux" && m.Platform.Architecture == runtime.GOARCH {
+			selected = m
+			break
+		}
+	}
+
+	return readManifest(storage, selected, depth+1)
+}
+
+// dockerLayers returns the layers of an image of a docker save archive.
+func dockerLayers(storage billy.Filesystem, ref string) ([]string, error) {
+	var manifests []dockerManifest
+	err := readJSON(storage, "manifest.json", &manifests)
+	if os.IsNotExist(err) {
+		return nil, ErrInvalidLayout
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range manifests {
+		if ref != "" && !hasTag(m.RepoTags, ref) {
+			continue
+		}
+
+		var layers []string
+		for _, l := range m.Layers {
+			name := clean(l)
+			if name == "" {
+				return nil, ErrInvalidLayout
+			}
+
+			layers = append(layers, name)
+		}
+
+		return layers, nil
+	}
+
+	return nil, ErrImageNotFound
+}
+
+func hasTag(tags []string, ref string) bool {
+	for _, t := range tags {
+		if t == ref {
+			return true
+		}
+	}
+
+	return false
+}
+
+// blobPath returns the filename of the blob with the given digest, as
+// "<algorithm>:<hex>".
+func blobPath(digest string) (string, error) {
+	parts := strings.SplitN(digest, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" ||
+		strings.ContainsAny(digest, "/\\") || strings.Contains(digest, "..") {
+		return "", fmt.Errorf("invalid digest %q", digest)
+	}
+
+	return path.Join("blobs", parts[0], parts[1]), nil
+}
+
+func readJSON(storage billy.Filesystem, name string, v interface{}) error {
+	f, err := storage.Open(name)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(v); err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,277 @@
+// Package ocifs provides a read-only billy filesystem over the layers of an
+// OCI or Docker container image, stacked the way a container runtime does.
+//
+// The layers are tar archives, plain or compressed with gzip or zstd, applied
+// in order on top of each other: a later layer replaces the entries of the
+// lower ones, a whiteout file (".wh.<name>") removes an entry, and an opaque
+// whiteout (".wh..wh..opq") hides the content of its directory in the lower
+// layers. The whiteout files aren't shown in the filesystem.
+//
+// The layers are read sequentially, keeping in memory the content of the
+// files that are visible at the end.
+package ocifs // import "gopkg.in/src-d/go-billy.v4/ocifs"
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/internal/linkutil"
+)
+
+var errNotDir = errors.New("not a directory")
+
+// Image is a read-only filesystem based on the stacked layers of a container
+// image.
+type Image struct {
+	root *node
+}
+
+// node is an entry of the merged tree, the directories without a header are
+// the implicit ones, parents of an entry but without one of their own. The
+// layer is the last one where the node, or any of its descendants, was added.
+type node struct {
+	header   *tar.Header
+	data     []byte
+	dir      bool
+	layer    int
+	children map[string]*node
+}
+
+func newDir(layer int) *node {
+	return &node{dir: true, layer: layer, children: make(map[string]*node)}
+}
+
+// New returns a new read-only filesystem with the given layers stacked, the
+// first one being the base layer.
+func New(layers ...io.Reader) (billy.Filesystem, error) {
+	fs := &Image{root: newDir(0)}
+	for i, r := range layers {
+		if err := fs.apply(i+1, r); err != nil {
+			return nil, fmt.Errorf("layer %d: %s", i, err)
+		}
+	}
+
+	return chroot.New(fs, string(filepath.Separator)), nil
+}
+
+func (fs *Image) Create(filename string) (billy.File, error) {
+	return nil, billy.ErrReadOnly
+}
+
+func (fs *Image) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (fs *Image) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if isWrite(flag) {
+		return nil, billy.ErrReadOnly
+	}
+
+	n, err := fs.resolve(filename, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.dir {
+		return nil, fmt.Errorf("cannot open directory: %s", filename)
+	}
+
+	return newFile(filename, n.data), nil
+}
+
+func (fs *Image) Stat(filename string) (os.FileInfo, error) {
+	n, err := fs.resolve(filename, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return newFileInfo(path.Base(clean(filename)), n), nil
+}
+
+func (fs *Image) Lstat(filename string) (os.FileInfo, error) {
+	n, err := fs.resolve(filename, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return newFileInfo(path.Base(clean(filename)), n), nil
+}
+
+func (fs *Image) ReadDir(filename string) ([]os.FileInfo, error) {
+	n, err := fs.resolve(filename, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if !n.dir {
+		return nil, &os.PathError{Op: "readdir", Path: filename, Err: errNotDir}
+	}
+
+	names := make([]string, 0, len(n.children))
+	for name := range n.children {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	entries := make([]os.FileInfo, 0, len(names))
+	for _, name := range names {
+		entries = append(entries, newFileInfo(name, n.children[name]))
+	}
+
+	return entries, nil
+}
+
+func (fs *Image) Readlink(link string) (string, error) {
+	n, err := fs.resolve(link, false)
+	if err != nil {
+		return "", err
+	}
+
+	if n.header == nil || n.header.Typeflag != tar.TypeSymlink {
+		return "", &os.PathError{Op: "readlink", Path: link, Err: errors.New("not a symlink")}
+	}
+
+	return filepath.FromSlash(n.header.Linkname), nil
+}
+
+func (fs *Image) Rename(from, to string) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *Image) Remove(filename string) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *Image) MkdirAll(filename string, perm os.FileMode) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *Image) Symlink(target, link string) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *Image) TempFile(dir, prefix string) (billy.File, error) {
+	return nil, billy.ErrReadOnly
+}
+
+func (fs *Image) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Image) Capabilities() billy.Capability {
+	return billy.ReadCapability | billy.SeekCapability
+}
+
+// resolve returns the node of the given filename, following the symlinks
+// found in any of the parent directories, and in the file itself if follow
+// is true.
+func (fs *Image) resolve(filename string, follow bool) (*node, error) {
+	_, n, err := linkutil.Resolve(clean(filename), follow, fs.root, child, linkTarget)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: filename, Err: err}
+	}
+
+	return n.(*node), nil
+}
+
+// child returns the node with the given name in the directory dir of the
+// merged layers, and if it's a link, to resolve the paths with
+// linkutil.Resolve.
+func child(dir interface{}, name string) (interface{}, bool, error) {
+	d := dir.(*node)
+	if !d.dir {
+		return nil, false, errNotDir
+	}
+
+	n, ok := d.children[name]
+	if !ok {
+		return nil, false, os.ErrNotExist
+	}
+
+	return n, n.header != nil && n.header.Typeflag == tar.TypeSymlink, nil
+}
+
+func linkTarget(link interface{}) (string, error) {
+	return link.(*node).header.Linkname, nil
+}
+
+type fileInfo struct {
+	name string
+	n    *node
+}
+
+func newFileInfo(name string, n *node) os.FileInfo {
+	if name == "" || name == "." {
+		name = string(filepath.Separator)
+	}
+
+	return &fileInfo{name: name, n: n}
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	if fi.n.dir {
+		return 0
+	}
+
+	if fi.n.header.Typeflag == tar.TypeSymlink {
+		return int64(len(fi.n.header.Linkname))
+	}
+
+	return int64(len(fi.n.data))
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.n.header == nil {
+		return os.ModeDir | 0755
+	}
+
+	return fi.n.header.FileInfo().Mode()
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	if fi.n.header == nil {
+		return time.Time{}
+	}
+
+	return fi.n.header.ModTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.n.dir
+}
+
+// Sys returns the *tar.Header of the entry, or nil for the root and the
+// implicit directories.
+func (fi *fileInfo) Sys() interface{} {
+	if fi.n.header == nil {
+		return nil
+	}
+
+	return fi.n.header
+}
+
+// clean returns the given path relative to the root of the image, using
+// forward slashes as separator, and "" for the root itself.
+func clean(name string) string {
+	name = path.Clean("/" + filepath.ToSlash(name))
+	return strings.TrimPrefix(name, "/")
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_APPEND|os.O_TRUNC) != 0
+}
@@ -0,0 +1,349 @@
+package ocifs
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&OCISuite{})
+
+type OCISuite struct {
+	layers [][]byte
+	FS     billy.Filesystem
+}
+
+var mtime = time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type testEntry struct {
+	header  *tar.Header
+	content string
+}
+
+func reg(name, content string) testEntry {
+	return testEntry{&tar.Header{Name: name, Mode: 0644, Typeflag: tar.TypeReg}, content}
+}
+
+func dir(name string) testEntry {
+	return testEntry{&tar.Header{Name: name, Mode: 0755, Typeflag: tar.TypeDir}, ""}
+}
+
+func symlink(name, target string) testEntry {
+	return testEntry{&tar.Header{Name: name, Mode: 0777, Typeflag: tar.TypeSymlink, Linkname: target}, ""}
+}
+
+func hardlink(name, target string) testEntry {
+	return testEntry{&tar.Header{Name: name, Typeflag: tar.TypeLink, Linkname: target}, ""}
+}
+
+func buildLayer(c *C, entries ...testEntry) []byte {
+	buf := bytes.NewBuffer(nil)
+	w := tar.NewWriter(buf)
+	for _, e := range entries {
+		e.header.ModTime = mtime
+		e.header.Size = int64(len(e.content))
+		c.Assert(w.WriteHeader(e.header), IsNil)
+		_, err := io.WriteString(w, e.content)
+		c.Assert(err, IsNil)
+	}
+
+	c.Assert(w.Close(), IsNil)
+	return buf.Bytes()
+}
+
+func gzipLayer(c *C, layer []byte) []byte {
+	buf := bytes.NewBuffer(nil)
+	w := gzip.NewWriter(buf)
+	_, err := w.Write(layer)
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+	return buf.Bytes()
+}
+
+func zstdLayer(c *C, layer []byte) []byte {
+	w, err := zstd.NewWriter(nil)
+	c.Assert(err, IsNil)
+	defer w.Close()
+	return w.EncodeAll(layer, nil)
+}
+
+// SetUpTest builds three layers: a gzip one as base, a zstd one with
+// whiteouts, and a plain one replacing entries of the lower ones.
+func (s *OCISuite) SetUpTest(c *C) {
+	s.layers = [][]byte{
+		gzipLayer(c, buildLayer(c,
+			dir("etc/"),
+			reg("etc/passwd", "root"),
+			reg("etc/hosts", "localhost"),
+			dir("var/cache/"),
+			reg("var/cache/a", "a"),
+			reg("var/cache/b", "b"),
+			reg("usr/bin/app", "v1"),
+			reg("usr/lib/old", "old"),
+			symlink("bin", "usr/bin"),
+			reg("removed/file", "removed"),
+		)),
+		zstdLayer(c, buildLayer(c,
+			reg(".wh.removed", ""),
+			reg("etc/.wh.hosts", ""),
+			reg("var/cache/.wh..wh..opq", ""),
+			reg("var/cache/c", "c"),
+			reg("usr/bin/app", "v2"),
+		)),
+		buildLayer(c,
+			hardlink("usr/bin/app-link", "usr/bin/app"),
+			reg("usr/lib", "not a directory"),
+			dir("etc/"),
+			reg("removed", "back as a file"),
+		),
+	}
+
+	var readers []io.Reader
+	for _, l := range s.layers {
+		readers = append(readers, bytes.NewReader(l))
+	}
+
+	var err error
+	s.FS, err = New(readers...)
+	c.Assert(err, IsNil)
+}
+
+func (s *OCISuite) TestOpen(c *C) {
+	f, err := s.FS.Open("etc/passwd")
+	c.Assert(err, IsNil)
+	c.Assert(f.Name(), Equals, "etc/passwd")
+
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "root")
+	c.Assert(f.Close(), IsNil)
+
+	_, err = s.FS.Open("nope")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	_, err = s.FS.Open("etc")
+	c.Assert(err, NotNil)
+}
+
+func (s *OCISuite) TestReplaced(c *C) {
+	c.Assert(test.ReadFile(c, s.FS, "usr/bin/app"), Equals, "v2")
+	c.Assert(test.ReadFile(c, s.FS, "bin/app"), Equals, "v2")
+	c.Assert(test.ReadFile(c, s.FS, "usr/bin/app-link"), Equals, "v2")
+	c.Assert(test.ReadFile(c, s.FS, "usr/lib"), Equals, "not a directory")
+	c.Assert(test.ReadFile(c, s.FS, "removed"), Equals, "back as a file")
+
+	fi, err := s.FS.Stat("usr/bin/app-link")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode(), Equals, os.FileMode(0644))
+	c.Assert(fi.Size(), Equals, int64(2))
+}
+
+func (s *OCISuite) TestWhiteouts(c *C) {
+	_, err := s.FS.Stat("etc/hosts")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	_, err = s.FS.Stat("removed/file")
+	c.Assert(err, NotNil)
+
+	_, err = s.FS.Stat("etc/.wh.hosts")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	entries, err := s.FS.ReadDir("var/cache")
+	c.Assert(err, IsNil)
+	c.Assert(names(entries), DeepEquals, []string{"c"})
+}
+
+func (s *OCISuite) TestSeekAndReadAt(c *C) {
+	f, err := s.FS.Open("removed")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	buf := make([]byte, 4)
+	n, err := f.ReadAt(buf, 8)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf[:n]), Equals, "a fi")
+
+	pos, err := f.Seek(-4, io.SeekEnd)
+	c.Assert(err, IsNil)
+	c.Assert(pos, Equals, int64(10))
+
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "file")
+}
+
+func (s *OCISuite) TestStat(c *C) {
+	fi, err := s.FS.Stat("etc/passwd")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Name(), Equals, "passwd")
+	c.Assert(fi.Size(), Equals, int64(4))
+	c.Assert(fi.Mode(), Equals, os.FileMode(0644))
+	c.Assert(fi.ModTime().Equal(mtime), Equals, true)
+	c.Assert(fi.Sys(), FitsTypeOf, &tar.Header{})
+
+	fi, err = s.FS.Stat("usr")
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+	c.Assert(fi.Sys(), IsNil)
+
+	fi, err = s.FS.Stat("/")
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+}
+
+func (s *OCISuite) TestSymlinks(c *C) {
+	fi, err := s.FS.Lstat("bin")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode()&os.ModeSymlink, Not(Equals), os.FileMode(0))
+	c.Assert(fi.Size(), Equals, int64(7))
+
+	fi, err = s.FS.Stat("bin")
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+
+	target, err := s.FS.Readlink("bin")
+	c.Assert(err, IsNil)
+	c.Assert(target, Equals, s.FS.Join("usr", "bin"))
+
+	_, err = s.FS.Readlink("etc/passwd")
+	c.Assert(err, NotNil)
+}
+
+func (s *OCISuite) TestReadDir(c *C) {
+	entries, err := s.FS.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(names(entries), DeepEquals, []string{"bin", "etc", "removed", "usr", "var"})
+
+	entries, err = s.FS.ReadDir("bin")
+	c.Assert(err, IsNil)
+	c.Assert(names(entries), DeepEquals, []string{"app", "app-link"})
+
+	_, err = s.FS.ReadDir("etc/passwd")
+	c.Assert(err, NotNil)
+}
+
+func (s *OCISuite) TestChroot(c *C) {
+	fs, err := s.FS.Chroot("usr")
+	c.Assert(err, IsNil)
+	c.Assert(test.ReadFile(c, fs, "bin/app"), Equals, "v2")
+}
+
+func (s *OCISuite) TestInvalidLayer(c *C) {
+	_, err := New(bytes.NewReader(buildLayer(c, hardlink("link", "nope"))))
+	c.Assert(err, NotNil)
+
+	_, err = New(bytes.NewReader([]byte{0x1f, 0x8b, 0, 0}))
+	c.Assert(err, NotNil)
+}
+
+func (s *OCISuite) TestLayout(c *C) {
+	storage := memfs.New()
+	blob := func(content []byte) string {
+		digest := fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+		c.Assert(util.WriteFile(storage, path.Join("blobs", "sha256", digest[7:]), content, 0644), IsNil)
+		return digest
+	}
+
+	var layers []string
+	for _, l := range s.layers {
+		layers = append(layers, fmt.Sprintf(`{"digest": %q}`, blob(l)))
+	}
+
+	m := blob([]byte(fmt.Sprintf(`{"layers": [%s, %s, %s]}`, layers[0], layers[1], layers[2])))
+	other := blob([]byte(fmt.Sprintf(`{"layers": [%s]}`, layers[0])))
+	platforms := blob([]byte(fmt.Sprintf(`{"manifests": [
+		{"digest": %q, "platform": {"os": "linux", "architecture": "none"}},
+		{"digest": %q, "platform": {"os": "linux", "architecture": %q}}
+	]}`, other, m, runtime.GOARCH)))
+
+	c.Assert(util.WriteFile(storage, "index.json", []byte(fmt.Sprintf(`{"manifests": [
+		{"digest": %q, "annotations": {%q: "base"}},
+		{"mediaType": %q, "digest": %q, "annotations": {%q: "latest"}}
+	]}`, other, refNameAnnotation, mediaTypeIndex, platforms, refNameAnnotation)), 0644), IsNil)
+
+	fs, err := NewFromLayout(storage, "latest")
+	c.Assert(err, IsNil)
+	c.Assert(test.ReadFile(c, fs, "usr/bin/app"), Equals, "v2")
+
+	fs, err = NewFromLayout(storage, "")
+	c.Assert(err, IsNil)
+	c.Assert(test.ReadFile(c, fs, "usr/bin/app"), Equals, "v1")
+
+	_, err = NewFromLayout(storage, "nope")
+	c.Assert(err, Equals, ErrImageNotFound)
+
+	_, err = NewFromLayout(memfs.New(), "")
+	c.Assert(err, Equals, ErrInvalidLayout)
+}
+
+func (s *OCISuite) TestDockerArchive(c *C) {
+	storage := memfs.New()
+	for i, l := range s.layers {
+		c.Assert(util.WriteFile(storage, fmt.Sprintf("%d/layer.tar", i), l, 0644), IsNil)
+	}
+
+	c.Assert(util.WriteFile(storage, "manifest.json", []byte(`[
+		{"RepoTags": ["app:v1"], "Layers": ["0/layer.tar"]},
+		{"RepoTags": ["app:v2"], "Layers": ["0/layer.tar", "1/layer.tar", "2/layer.tar"]}
+	]`), 0644), IsNil)
+
+	fs, err := NewFromLayout(storage, "app:v2")
+	c.Assert(err, IsNil)
+	c.Assert(test.ReadFile(c, fs, "usr/bin/app"), Equals, "v2")
+
+	fs, err = NewFromLayout(storage, "")
+	c.Assert(err, IsNil)
+	c.Assert(test.ReadFile(c, fs, "usr/bin/app"), Equals, "v1")
+
+	_, err = NewFromLayout(storage, "app:v3")
+	c.Assert(err, Equals, ErrImageNotFound)
+}
+
+func (s *OCISuite) TestReadOnly(c *C) {
+	_, err := s.FS.Create("new")
+	c.Assert(err, Equals, billy.ErrReadOnly)
+
+	_, err = s.FS.OpenFile("etc/passwd", os.O_RDWR, 0)
+	c.Assert(err, Equals, billy.ErrReadOnly)
+
+	c.Assert(s.FS.Remove("etc/passwd"), Equals, billy.ErrReadOnly)
+	c.Assert(s.FS.Rename("etc/passwd", "foo"), Equals, billy.ErrReadOnly)
+	c.Assert(s.FS.MkdirAll("dir", 0755), Equals, billy.ErrReadOnly)
+	c.Assert(s.FS.Symlink("foo", "bar"), Equals, billy.ErrReadOnly)
+
+	f, err := s.FS.Open("etc/passwd")
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("foo"))
+	c.Assert(err, Equals, billy.ErrReadOnly)
+
+	c.Assert(billy.CapabilityCheck(s.FS, billy.WriteCapability), Equals, false)
+	c.Assert(billy.CapabilityCheck(s.FS, billy.ReadCapability), Equals, true)
+}
+
+func names(entries []os.FileInfo) []string {
+	var names []string
+	for _, fi := range entries {
+		names = append(names, fi.Name())
+	}
+
+	return names
+}
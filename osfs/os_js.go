@@ -0,0 +1,20 @@
+//go:build js
+// +build js
+
+package osfs
+
+// Lock does nothing, since there is no file locking in js/wasm.
+func (f *file) Lock() error {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	return nil
+}
+
+// Unlock does nothing, since there is no file locking in js/wasm.
+func (f *file) Unlock() error {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package osfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// ErrNoAppName is returned when an empty application name is given to
+// resolve a base directory.
+var ErrNoAppName = errors.New("empty application name")
+
+// Expand expands a leading "~" or "~user" to the home directory of the
+// current or the given user, and the environment variables, as "$VAR" or
+// "${VAR}", of the given path. Undefined variables are an error, since
+// expanding them to an empty string could change the meaning of the path.
+func Expand(path string) (string, error) {
+	var home string
+	if strings.HasPrefix(path, "~") {
+		separators := "/"
+		if runtime.GOOS == "windows" {
+			separators = `/\`
+		}
+
+		i := strings.IndexAny(path, separators)
+		if i == -1 {
+			i = len(path)
+		}
+
+		var err error
+		if home, err = homeDir(path[1:i]); err != nil {
+			return "", err
+		}
+
+		path = path[i:]
+	}
+
+	var undefined []string
+	path = os.Expand(path, func(name string) string {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			undefined = append(undefined, name)
+		}
+
+		return value
+	})
+
+	if len(undefined) != 0 {
+		return "", fmt.Errorf("undefined environment variables: %s", strings.Join(undefined, ", "))
+	}
+
+	return filepath.Clean(home + path), nil
+}
+
+func homeDir(username string) (string, error) {
+	if username == "" {
+		return os.UserHomeDir()
+	}
+
+	u, err := user.Lookup(username)
+	if err != nil {
+		return "", err
+	}
+
+	return u.HomeDir, nil
+}
+
+// NewExpanded returns a new OS filesystem with its base in the given path,
+// expanded with Expand.
+func NewExpanded(path string) (billy.Filesystem, error) {
+	base, err := Expand(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(base), nil
+}
+
+// ConfigDir returns a new OS filesystem with its base in the configuration
+// directory of the given application. It's $XDG_CONFIG_HOME/app if set, or
+// the default of each platform: ~/.config/app on Unix systems,
+// ~/Library/Application Support/app on macOS and %AppData%\app on Windows.
+//
+// The directory is not created until a file is written.
+func ConfigDir(app string) (billy.Filesystem, error) {
+	return newBaseDir(app, "XDG_CONFIG_HOME", func() (string, error) {
+		if runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+			return os.UserConfigDir()
+		}
+
+		return homeSubdir(".config")
+	})
+}
+
+// CacheDir returns a new OS filesystem with its base in the cache directory
+// of the given application. It's $XDG_CACHE_HOME/app if set, or the default
+// of each platform: ~/.cache/app on Unix systems, ~/Library/Caches/app on
+// macOS and %LocalAppData%\app on Windows.
+//
+// The directory is not created until a file is written.
+func CacheDir(app string) (billy.Filesystem, error) {
+	return newBaseDir(app, "XDG_CACHE_HOME", func() (string, error) {
+		if runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+			return os.UserCacheDir()
+		}
+
+		return homeSubdir(".cache")
+	})
+}
+
+// DataDir returns a new OS filesystem with its base in the data directory
+// of the given application. It's $XDG_DATA_HOME/app if set, or the default
+// of each platform: ~/.local/share/app on Unix systems,
+// ~/Library/Application Support/app on macOS and %AppData%\app on Windows.
+//
+// The directory is not created until a file is written.
+func DataDir(app string) (billy.Filesystem, error) {
+	return newBaseDir(app, "XDG_DATA_HOME", func() (string, error) {
+		if runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+			// the data and configuration directories are the same.
+			return os.UserConfigDir()
+		}
+
+		return homeSubdir(filepath.Join(".local", "share"))
+	})
+}
+
+// newBaseDir returns the directory of the application under the directory
+// of the given XDG variable. As the XDG specification says, the variable is
+// ignored if it's not an absolute path, using the default directory instead.
+func newBaseDir(app, variable string, defaultDir func() (string, error)) (billy.Filesystem, error) {
+	if app == "" {
+		return nil, ErrNoAppName
+	}
+
+	dir := os.Getenv(variable)
+	if !filepath.IsAbs(dir) {
+		var err error
+		if dir, err = defaultDir(); err != nil {
+			return nil, err
+		}
+	}
+
+	return New(filepath.Join(dir, app)), nil
+}
+
+func homeSubdir(name string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, name), nil
+}
@@ -0,0 +1,134 @@
+package osfs
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"gopkg.in/src-d/go-billy.v4"
+
+	. "gopkg.in/check.v1"
+)
+
+type ResolveSuite struct {
+	env map[string]*string
+}
+
+var _ = Suite(&ResolveSuite{})
+
+func (s *ResolveSuite) SetUpTest(c *C) {
+	s.env = make(map[string]*string)
+	s.setenv("HOME", "/home/foo")
+	s.setenv("XDG_CONFIG_HOME", "")
+	s.setenv("XDG_CACHE_HOME", "")
+	s.setenv("XDG_DATA_HOME", "")
+}
+
+func (s *ResolveSuite) TearDownTest(c *C) {
+	for name, value := range s.env {
+		if value == nil {
+			os.Unsetenv(name)
+			continue
+		}
+
+		os.Setenv(name, *value)
+	}
+}
+
+// setenv sets the given variable, saving its original value to restore it
+// after the test.
+func (s *ResolveSuite) setenv(name, value string) {
+	if _, ok := s.env[name]; !ok {
+		if old, ok := os.LookupEnv(name); ok {
+			s.env[name] = &old
+		} else {
+			s.env[name] = nil
+		}
+	}
+
+	os.Setenv(name, value)
+}
+
+func (s *ResolveSuite) TestExpand(c *C) {
+	if runtime.GOOS == "windows" {
+		c.Skip("HOME is not used on Windows")
+	}
+
+	s.setenv("FOO", "foo")
+	for input, expected := range map[string]string{
+		"~":                "/home/foo",
+		"~/":               "/home/foo",
+		"~/bar":            "/home/foo/bar",
+		"~/$FOO/${FOO}bar": "/home/foo/foo/foobar",
+		"/qux/$FOO":        "/qux/foo",
+		"bar/~":            "bar/~",
+	} {
+		path, err := Expand(input)
+		c.Assert(err, IsNil)
+		c.Assert(path, Equals, expected, Commentf("input: %s", input))
+	}
+}
+
+func (s *ResolveSuite) TestExpandUndefined(c *C) {
+	os.Unsetenv("GO_BILLY_UNDEFINED")
+	_, err := Expand("/foo/$GO_BILLY_UNDEFINED")
+	c.Assert(err, ErrorMatches, ".*GO_BILLY_UNDEFINED")
+}
+
+func (s *ResolveSuite) TestExpandUnknownUser(c *C) {
+	_, err := Expand("~go-billy-unknown-user/foo")
+	c.Assert(err, NotNil)
+}
+
+func (s *ResolveSuite) TestNewExpanded(c *C) {
+	dir := c.MkDir()
+	s.setenv("GO_BILLY_DIR", dir)
+
+	fs, err := NewExpanded("$GO_BILLY_DIR/foo")
+	c.Assert(err, IsNil)
+	c.Assert(fs.Root(), Equals, filepath.Join(dir, "foo"))
+}
+
+func (s *ResolveSuite) TestBaseDirs(c *C) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		c.Skip("XDG defaults are only used on Unix systems")
+	}
+
+	for _, t := range []struct {
+		fn       func(string) (billy.Filesystem, error)
+		expected string
+	}{
+		{ConfigDir, "/home/foo/.config/app"},
+		{CacheDir, "/home/foo/.cache/app"},
+		{DataDir, "/home/foo/.local/share/app"},
+	} {
+		fs, err := t.fn("app")
+		c.Assert(err, IsNil)
+		c.Assert(fs.Root(), Equals, t.expected)
+	}
+}
+
+func (s *ResolveSuite) TestBaseDirsXDG(c *C) {
+	if runtime.GOOS == "windows" {
+		c.Skip("the test paths are not absolute on Windows")
+	}
+
+	s.setenv("XDG_CONFIG_HOME", "/xdg/config")
+	s.setenv("XDG_CACHE_HOME", "/xdg/cache")
+	s.setenv("XDG_DATA_HOME", "relative/is/ignored")
+
+	fs, err := ConfigDir("app")
+	c.Assert(err, IsNil)
+	c.Assert(fs.Root(), Equals, filepath.Join("/xdg/config", "app"))
+
+	fs, err = CacheDir("app")
+	c.Assert(err, IsNil)
+	c.Assert(fs.Root(), Equals, filepath.Join("/xdg/cache", "app"))
+
+	fs, err = DataDir("app")
+	c.Assert(err, IsNil)
+	c.Assert(fs.Root(), Not(Equals), filepath.Join("relative/is/ignored", "app"))
+
+	_, err = DataDir("")
+	c.Assert(err, Equals, ErrNoAppName)
+}
@@ -0,0 +1,435 @@
+package p9fs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	version  = "9P2000"
+	versionU = "9P2000.u"
+
+	noTag = 0xFFFF
+	noFid = 0xFFFFFFFF
+
+	// ioHeaderSize is the size of the header of the Tread and Twrite
+	// messages, the data sent in a message is limited to msize minus it.
+	ioHeaderSize = 24
+	// maxWalk is the maximum number of names walked in a single Twalk.
+	maxWalk = 16
+)
+
+// The types of the messages, the response to every T-message is the
+// following R-message or Rerror.
+const (
+	tversion = 100
+	rversion = 101
+	tattach  = 104
+	rattach  = 105
+	rerror   = 107
+	twalk    = 110
+	rwalk    = 111
+	topen    = 112
+	ropen    = 113
+	tcreate  = 114
+	rcreate  = 115
+	tread    = 116
+	rread    = 117
+	twrite   = 118
+	rwrite   = 119
+	tclunk   = 120
+	rclunk   = 121
+	tremove  = 122
+	rremove  = 123
+	tstat    = 124
+	rstat    = 125
+	twstat   = 126
+	rwstat   = 127
+)
+
+// The modes of Topen and Tcreate.
+const (
+	oread  = 0
+	owrite = 1
+	ordwr  = 2
+	otrunc = 0x10
+)
+
+// The bits of the type of a qid.
+const (
+	qtDir     = 0x80
+	qtSymlink = 0x02
+)
+
+var (
+	errClosed       = errors.New("connection closed")
+	errShortMessage = errors.New("short message")
+)
+
+// Error is an error returned by the server in a Rerror message, that
+// doesn't match any of the errors of the os package.
+type Error struct {
+	// Name is the description of the error.
+	Name string
+	// Errno is the Unix error number, only sent by the 9P2000.u servers.
+	Errno uint32
+}
+
+func (e *Error) Error() string {
+	return e.Name
+}
+
+// The Unix error numbers recognized from the 9P2000.u servers, as defined
+// in Linux.
+const (
+	eperm     = 1
+	enoent    = 2
+	eio       = 5
+	eexist    = 17
+	eacces    = 13
+	enotdir   = 20
+	enotempty = 39
+)
+
+// toError converts a Rerror to the matching error of the os package, by its
+// Unix error number or by its description otherwise.
+func toError(e *Error) error {
+	switch e.Errno {
+	case enoent:
+		return os.ErrNotExist
+	case eexist:
+		return os.ErrExist
+	case eperm, eacces:
+		return os.ErrPermission
+	case enotempty:
+		return ErrNotEmpty
+	}
+
+	name := strings.ToLower(e.Name)
+	switch {
+	case strings.Contains(name, "does not exist"),
+		strings.Contains(name, "not found"),
+		strings.Contains(name, "no such file"):
+		return os.ErrNotExist
+	case strings.Contains(name, "already exists"),
+		strings.Contains(name, "file exists"):
+		return os.ErrExist
+	case strings.Contains(name, "permission denied"):
+		return os.ErrPermission
+	case strings.Contains(name, "not empty"):
+		return ErrNotEmpty
+	}
+
+	return e
+}
+
+type qid struct {
+	typ     uint8
+	version uint32
+	path    uint64
+}
+
+// encoder encodes the body of a message, in little-endian.
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) u8(v uint8) {
+	e.b = append(e.b, v)
+}
+
+func (e *encoder) u16(v uint16) {
+	e.b = append(e.b, byte(v), byte(v>>8))
+}
+
+func (e *encoder) u32(v uint32) {
+	e.b = append(e.b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func (e *encoder) u64(v uint64) {
+	e.u32(uint32(v))
+	e.u32(uint32(v >> 32))
+}
+
+func (e *encoder) str(s string) {
+	e.u16(uint16(len(s)))
+	e.b = append(e.b, s...)
+}
+
+func (e *encoder) data(p []byte) {
+	e.u32(uint32(len(p)))
+	e.b = append(e.b, p...)
+}
+
+func (e *encoder) qid(q qid) {
+	e.u8(q.typ)
+	e.u32(q.version)
+	e.u64(q.path)
+}
+
+// decoder decodes the body of a message, the first error is kept in err and
+// the following reads return zero values.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil || n < 0 || len(d.b) < n {
+		if d.err == nil {
+			d.err = errShortMessage
+		}
+
+		return make([]byte, 8)
+	}
+
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b
+}
+
+func (d *decoder) u8() uint8 {
+	return d.next(1)[0]
+}
+
+func (d *decoder) u16() uint16 {
+	return binary.LittleEndian.Uint16(d.next(2))
+}
+
+func (d *decoder) u32() uint32 {
+	return binary.LittleEndian.Uint32(d.next(4))
+}
+
+func (d *decoder) u64() uint64 {
+	return binary.LittleEndian.Uint64(d.next(8))
+}
+
+func (d *decoder) str() string {
+	n := d.u16()
+	if d.err != nil {
+		return ""
+	}
+
+	return string(d.next(int(n)))
+}
+
+func (d *decoder) data() []byte {
+	n := d.u32()
+	if d.err != nil {
+		return nil
+	}
+
+	return d.next(int(n))
+}
+
+func (d *decoder) qid() qid {
+	return qid{typ: d.u8(), version: d.u32(), path: d.u64()}
+}
+
+// readMsg reads a message, returning its type, tag and body.
+func readMsg(r io.Reader, msize uint32) (uint8, uint16, []byte, error) {
+	var header [7]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, 0, nil, err
+	}
+
+	size := binary.LittleEndian.Uint32(header[:4])
+	if size < uint32(len(header)) || size > msize {
+		return 0, 0, nil, fmt.Errorf("invalid message size: %d", size)
+	}
+
+	body := make([]byte, size-uint32(len(header)))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, 0, nil, err
+	}
+
+	return header[4], binary.LittleEndian.Uint16(header[5:]), body, nil
+}
+
+// writeMsg writes a message with the given type, tag and body.
+func writeMsg(w io.Writer, typ uint8, tag uint16, body []byte) error {
+	e := &encoder{b: make([]byte, 0, 7+len(body))}
+	e.u32(uint32(7 + len(body)))
+	e.u8(typ)
+	e.u16(tag)
+	e.b = append(e.b, body...)
+
+	_, err := w.Write(e.b)
+	return err
+}
+
+type response struct {
+	typ uint8
+	d   *decoder
+	err error
+}
+
+// conn is a connection to a 9P server, the requests are sent concurrently,
+// matching the responses by their tags.
+type conn struct {
+	rwc   io.ReadWriteCloser
+	msize uint32
+	dotu  bool
+
+	wmu sync.Mutex
+
+	mu      sync.Mutex
+	tags    map[uint16]chan response
+	nextTag uint16
+	err     error
+}
+
+// newConn negotiates the version of the protocol, preferring 9P2000.u, and
+// starts reading the responses.
+func newConn(rwc io.ReadWriteCloser, msize uint32) (*conn, error) {
+	e := &encoder{}
+	e.u32(msize)
+	e.str(versionU)
+	if err := writeMsg(rwc, tversion, noTag, e.b); err != nil {
+		return nil, err
+	}
+
+	typ, _, body, err := readMsg(rwc, msize)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &decoder{b: body}
+	if typ == rerror {
+		return nil, &Error{Name: d.str()}
+	}
+
+	if typ != rversion {
+		return nil, fmt.Errorf("unexpected message type: %d", typ)
+	}
+
+	size, v := d.u32(), d.str()
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	if v != version && v != versionU {
+		return nil, fmt.Errorf("unsupported version: %q", v)
+	}
+
+	if size < msize {
+		msize = size
+	}
+
+	if msize <= ioHeaderSize {
+		return nil, fmt.Errorf("invalid message size: %d", msize)
+	}
+
+	c := &conn{
+		rwc:   rwc,
+		msize: msize,
+		dotu:  v == versionU,
+		tags:  make(map[uint16]chan response),
+	}
+
+	go c.read()
+	return c, nil
+}
+
+// rpc sends a request with the given type and body, returning the body of
+// the response. Rerror is returned as an error.
+func (c *conn) rpc(typ uint8, e *encoder) (*decoder, error) {
+	ch := make(chan response, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+
+	tag := c.nextTag
+	for _, used := c.tags[tag]; used || tag == noTag; _, used = c.tags[tag] {
+		tag++
+	}
+
+	c.nextTag = tag + 1
+	c.tags[tag] = ch
+	c.mu.Unlock()
+
+	c.wmu.Lock()
+	err := writeMsg(c.rwc, typ, tag, e.b)
+	c.wmu.Unlock()
+	if err != nil {
+		c.fail(err)
+	}
+
+	r := <-ch
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	if r.typ == rerror {
+		return nil, c.error(r.d)
+	}
+
+	if r.typ != typ+1 {
+		return nil, fmt.Errorf("unexpected message type: %d", r.typ)
+	}
+
+	return r.d, nil
+}
+
+func (c *conn) error(d *decoder) error {
+	e := &Error{Name: d.str()}
+	if c.dotu {
+		e.Errno = d.u32()
+	}
+
+	if d.err != nil {
+		return d.err
+	}
+
+	return toError(e)
+}
+
+func (c *conn) read() {
+	for {
+		typ, tag, body, err := readMsg(c.rwc, c.msize)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+
+		c.mu.Lock()
+		ch := c.tags[tag]
+		delete(c.tags, tag)
+		c.mu.Unlock()
+
+		if ch != nil {
+			ch <- response{typ: typ, d: &decoder{b: body}}
+		}
+	}
+}
+
+// fail closes the connection, failing the pending and following requests
+// with the given error.
+func (c *conn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return
+	}
+
+	c.err = err
+	for tag, ch := range c.tags {
+		ch <- response{err: err}
+		delete(c.tags, tag)
+	}
+
+	c.rwc.Close()
+}
+
+func (c *conn) Close() error {
+	c.fail(errClosed)
+	return nil
+}
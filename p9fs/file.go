@@ -0,0 +1,193 @@
+package p9fs
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// file is a file opened in the server, identified by its fid. The position
+// is kept by the client, sent as the offset of every read and write.
+type file struct {
+	fs       *P9
+	name     string
+	fid      uint32
+	iounit   uint32
+	flag     int
+	position int64
+	isClosed bool
+}
+
+func (f *file) Name() string {
+	return f.name
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	n, err := f.read(p, f.position)
+	f.position += int64(n)
+	if err != nil {
+		return n, &os.PathError{Op: "read", Path: f.name, Err: err}
+	}
+
+	if n == 0 && len(p) != 0 {
+		return 0, io.EOF
+	}
+
+	return n, nil
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	var n int
+	for n < len(p) {
+		m, err := f.read(p[n:], off+int64(n))
+		n += m
+		if err != nil {
+			return n, &os.PathError{Op: "readat", Path: f.name, Err: err}
+		}
+
+		if m == 0 {
+			return n, io.EOF
+		}
+	}
+
+	return n, nil
+}
+
+// read reads up to iounit bytes at the given offset with Tread.
+func (f *file) read(p []byte, off int64) (int, error) {
+	if len(p) > int(f.iounit) {
+		p = p[:f.iounit]
+	}
+
+	e := &encoder{}
+	e.u32(f.fid)
+	e.u64(uint64(off))
+	e.u32(uint32(len(p)))
+	d, err := f.fs.conn.rpc(tread, e)
+	if err != nil {
+		return 0, err
+	}
+
+	data := d.data()
+	return copy(p, data), d.err
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	if f.flag&os.O_APPEND != 0 {
+		d, err := f.fs.stat(f.fid)
+		if err != nil {
+			return 0, &os.PathError{Op: "write", Path: f.name, Err: err}
+		}
+
+		f.position = int64(d.length)
+	}
+
+	var n int
+	for n < len(p) {
+		m, err := f.write(p[n:], f.position)
+		n += m
+		f.position += int64(m)
+		if err != nil {
+			return n, &os.PathError{Op: "write", Path: f.name, Err: err}
+		}
+
+		if m == 0 {
+			return n, io.ErrShortWrite
+		}
+	}
+
+	return n, nil
+}
+
+// write writes up to iounit bytes at the given offset with Twrite.
+func (f *file) write(p []byte, off int64) (int, error) {
+	if len(p) > int(f.iounit) {
+		p = p[:f.iounit]
+	}
+
+	e := &encoder{}
+	e.u32(f.fid)
+	e.u64(uint64(off))
+	e.data(p)
+	d, err := f.fs.conn.rpc(twrite, e)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(d.u32()), d.err
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.position
+	case io.SeekEnd:
+		d, err := f.fs.stat(f.fid)
+		if err != nil {
+			return 0, &os.PathError{Op: "seek", Path: f.name, Err: err}
+		}
+
+		offset += int64(d.length)
+	}
+
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: errors.New("negative position")}
+	}
+
+	f.position = offset
+	return f.position, nil
+}
+
+// Truncate changes the length of the file with Twstat.
+func (f *file) Truncate(size int64) error {
+	if f.isClosed {
+		return os.ErrClosed
+	}
+
+	d := newWstat()
+	d.length = uint64(size)
+	if err := f.fs.wstat(f.fid, d); err != nil {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: err}
+	}
+
+	return nil
+}
+
+// Lock is a no-op in P9.
+func (f *file) Lock() error {
+	return nil
+}
+
+// Unlock is a no-op in P9.
+func (f *file) Unlock() error {
+	return nil
+}
+
+func (f *file) Close() error {
+	if f.isClosed {
+		return os.ErrClosed
+	}
+
+	f.isClosed = true
+	if err := f.fs.clunk(f.fid); err != nil {
+		return &os.PathError{Op: "close", Path: f.name, Err: err}
+	}
+
+	return nil
+}
@@ -0,0 +1,790 @@
+// Package p9fs provides a billy filesystem over a 9P server, such as the
+// file servers of Plan 9, the Plan 9 shares of WSL2 or the virtfs exports of
+// QEMU.
+//
+// The 9P2000.u extension is negotiated when supported by the server, adding
+// the symbolic links, otherwise the plain 9P2000 protocol is used and
+// Symlink and Readlink return billy.ErrNotSupported. The symbolic links are
+// resolved by the client, walking the paths from the root of the attached
+// tree.
+//
+// 9P can only rename a file in its own directory, so the files moved to
+// another directory are copied and removed instead.
+package p9fs // import "gopkg.in/src-d/go-billy.v4/p9fs"
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/internal/linkutil"
+	"gopkg.in/src-d/go-billy.v4/internal/pathutil"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const (
+	defaultUser  = "nobody"
+	defaultMSize = 64*1024 + ioHeaderSize
+)
+
+var (
+	// ErrNotEmpty is returned when removing a directory with files.
+	ErrNotEmpty = billy.ErrNotEmpty
+
+	errNotDir  = errors.New("not a directory")
+	errNotLink = errors.New("not a symlink")
+)
+
+// Options holds the configuration of a 9P filesystem.
+type Options struct {
+	// User is the name of the user attaching to the server, "nobody" by
+	// default.
+	User string
+	// Aname is the name of the file tree to attach to, the default one of
+	// the server if empty.
+	Aname string
+	// MSize is the maximum size of the messages, 64KiB plus the size of the
+	// headers by default. The server may lower it.
+	MSize uint32
+}
+
+// P9 is a filesystem over a 9P server.
+type P9 struct {
+	conn *conn
+	root uint32
+
+	mu      sync.Mutex
+	nextFid uint32
+	free    []uint32
+}
+
+// Dial connects to the 9P server at the given address and returns a new
+// filesystem with the file tree attached.
+func Dial(network, addr string, opts Options) (*P9, error) {
+	c, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(c, opts)
+}
+
+// New returns a new filesystem over the given connection to a 9P server,
+// negotiating the version of the protocol and attaching the file tree. The
+// connection is closed by Close.
+func New(rwc io.ReadWriteCloser, opts Options) (*P9, error) {
+	if opts.User == "" {
+		opts.User = defaultUser
+	}
+
+	if opts.MSize == 0 {
+		opts.MSize = defaultMSize
+	}
+
+	c, err := newConn(rwc, opts.MSize)
+	if err != nil {
+		rwc.Close()
+		return nil, err
+	}
+
+	fs := &P9{conn: c}
+	fs.root = fs.newFid()
+
+	e := &encoder{}
+	e.u32(fs.root)
+	e.u32(noFid)
+	e.str(opts.User)
+	e.str(opts.Aname)
+	if c.dotu {
+		e.u32(noFid)
+	}
+
+	if _, err := c.rpc(tattach, e); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return fs, nil
+}
+
+// Close clunks the attached tree and closes the connection.
+func (fs *P9) Close() error {
+	fs.clunk(fs.root)
+	return fs.conn.Close()
+}
+
+func (fs *P9) newFid() uint32 {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if n := len(fs.free); n != 0 {
+		fid := fs.free[n-1]
+		fs.free = fs.free[:n-1]
+		return fid
+	}
+
+	fid := fs.nextFid
+	fs.nextFid++
+	return fid
+}
+
+// releaseFid allows to reuse a fid, once clunked or if it wasn't used.
+func (fs *P9) releaseFid(fid uint32) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.free = append(fs.free, fid)
+}
+
+func (fs *P9) clunk(fid uint32) error {
+	e := &encoder{}
+	e.u32(fid)
+	_, err := fs.conn.rpc(tclunk, e)
+	// the fid is released even if Tclunk fails.
+	fs.releaseFid(fid)
+	return err
+}
+
+// walkNames walks the given names from the root, in messages of up to
+// maxWalk names. It returns the qids of the walked names and, only if all
+// of them were walked, a new fid for the last one, noFid otherwise.
+func (fs *P9) walkNames(names []string) (uint32, []qid, error) {
+	fid, from := fs.newFid(), fs.root
+	var qids []qid
+	for first := true; first || len(names) != 0; first = false {
+		n := len(names)
+		if n > maxWalk {
+			n = maxWalk
+		}
+
+		e := &encoder{}
+		e.u32(from)
+		e.u32(fid)
+		e.u16(uint16(n))
+		for _, name := range names[:n] {
+			e.str(name)
+		}
+
+		d, err := fs.conn.rpc(twalk, e)
+		var walked []qid
+		if err == nil {
+			walked = make([]qid, d.u16())
+			for i := range walked {
+				walked[i] = d.qid()
+			}
+
+			err = d.err
+		}
+
+		qids = append(qids, walked...)
+		if err != nil || len(walked) != n {
+			// fid was only walked if it was already the origin.
+			if from == fid {
+				fs.clunk(fid)
+			} else {
+				fs.releaseFid(fid)
+			}
+
+			// the first name not found is returned as an error instead of
+			// an empty walk.
+			if err != nil && !os.IsNotExist(err) {
+				return noFid, nil, err
+			}
+
+			return noFid, qids, nil
+		}
+
+		names, from = names[n:], fid
+	}
+
+	return fid, qids, nil
+}
+
+// walk returns a new fid for the given path, resolving the symbolic links
+// found, including the last element if follow is true.
+func (fs *P9) walk(p string, follow bool) (uint32, error) {
+	names := split(p)
+	for i := 0; i < linkutil.MaxLinks; i++ {
+		fid, qids, err := fs.walkNames(names)
+		if err != nil {
+			return noFid, err
+		}
+
+		link := -1
+		for j, q := range qids {
+			if q.typ&qtSymlink != 0 && (follow || j < len(names)-1) {
+				link = j
+				break
+			}
+		}
+
+		if link == -1 {
+			if fid == noFid {
+				return noFid, os.ErrNotExist
+			}
+
+			return fid, nil
+		}
+
+		if fid != noFid {
+			fs.clunk(fid)
+		}
+
+		target, err := fs.readlink(names[:link+1])
+		if err != nil {
+			return noFid, err
+		}
+
+		if !path.IsAbs(target) {
+			target = path.Join(path.Join(names[:link]...), target)
+		}
+
+		names = append(split(target), names[link+1:]...)
+	}
+
+	return noFid, linkutil.ErrTooManyLinks
+}
+
+// readlink returns the target of the symbolic link with the given names,
+// without symbolic links.
+func (fs *P9) readlink(names []string) (string, error) {
+	fid, _, err := fs.walkNames(names)
+	if err != nil {
+		return "", err
+	}
+
+	if fid == noFid {
+		return "", os.ErrNotExist
+	}
+
+	defer fs.clunk(fid)
+	d, err := fs.stat(fid)
+	if err != nil {
+		return "", err
+	}
+
+	if d.mode&dmSymlink == 0 {
+		return "", errNotLink
+	}
+
+	return d.extension, nil
+}
+
+func (fs *P9) stat(fid uint32) (*dir, error) {
+	e := &encoder{}
+	e.u32(fid)
+	d, err := fs.conn.rpc(tstat, e)
+	if err != nil {
+		return nil, err
+	}
+
+	// the stat is preceded by the size of the whole field.
+	d.u16()
+	return decodeDir(d, fs.conn.dotu)
+}
+
+func (fs *P9) wstat(fid uint32, d *dir) error {
+	e := &encoder{}
+	e.u32(fid)
+	s := &encoder{}
+	d.encode(s, fs.conn.dotu)
+	e.u16(uint16(len(s.b)))
+	e.b = append(e.b, s.b...)
+
+	_, err := fs.conn.rpc(twstat, e)
+	return err
+}
+
+// lookup returns the stat of the given path.
+func (fs *P9) lookup(p string, follow bool) (*dir, error) {
+	fid, err := fs.walk(p, follow)
+	if err != nil {
+		return nil, err
+	}
+
+	defer fs.clunk(fid)
+	return fs.stat(fid)
+}
+
+// open opens the given fid, returning the maximum size of the data of the
+// reads and writes.
+func (fs *P9) open(fid uint32, mode uint8) (uint32, error) {
+	e := &encoder{}
+	e.u32(fid)
+	e.u8(mode)
+	d, err := fs.conn.rpc(topen, e)
+	if err != nil {
+		return 0, err
+	}
+
+	d.qid()
+	return fs.iounit(d.u32()), d.err
+}
+
+// create creates the file of the given path, returning a fid of it opened
+// and the maximum size of the data of the reads and writes. The extension
+// is the target of the symbolic links.
+func (fs *P9) create(p string, perm uint32, mode uint8, extension string) (uint32, uint32, error) {
+	dir, name := path.Split(path.Clean("/" + filepath.ToSlash(p)))
+	if name == "" {
+		return noFid, 0, os.ErrExist
+	}
+
+	fid, err := fs.walk(dir, true)
+	if err != nil {
+		return noFid, 0, err
+	}
+
+	e := &encoder{}
+	e.u32(fid)
+	e.str(name)
+	e.u32(perm)
+	e.u8(mode)
+	if fs.conn.dotu {
+		e.str(extension)
+	}
+
+	d, err := fs.conn.rpc(tcreate, e)
+	if err != nil {
+		fs.clunk(fid)
+		return noFid, 0, err
+	}
+
+	d.qid()
+	iounit := fs.iounit(d.u32())
+	if d.err != nil {
+		fs.clunk(fid)
+		return noFid, 0, d.err
+	}
+
+	return fid, iounit, nil
+}
+
+func (fs *P9) iounit(n uint32) uint32 {
+	if max := fs.conn.msize - ioHeaderSize; n == 0 || n > max {
+		return max
+	}
+
+	return n
+}
+
+func (fs *P9) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *P9) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file with Topen, or creates it with Tcreate,
+// creating also the missing directories.
+func (fs *P9) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	f, err := fs.openFile(filename, flag, perm)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+	}
+
+	return f, nil
+}
+
+func (fs *P9) openFile(filename string, flag int, perm os.FileMode) (*file, error) {
+	mode := openMode(flag)
+	fid, err := fs.walk(filename, true)
+	var iounit uint32
+	switch {
+	case err == nil:
+		if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+			fs.clunk(fid)
+			return nil, os.ErrExist
+		}
+
+		if iounit, err = fs.open(fid, mode); err != nil {
+			fs.clunk(fid)
+			return nil, err
+		}
+	case os.IsNotExist(err) && flag&os.O_CREATE != 0:
+		if err := fs.mkdirAll(path.Dir(filepath.ToSlash(filename)), 0755); err != nil {
+			return nil, err
+		}
+
+		fid, iounit, err = fs.create(filename, fromFileMode(perm&os.ModePerm), mode, "")
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	return &file{
+		fs:     fs,
+		name:   pathutil.Relative(filename),
+		fid:    fid,
+		iounit: iounit,
+		flag:   flag,
+	}, nil
+}
+
+func openMode(flag int) uint8 {
+	var mode uint8 = oread
+	switch {
+	case flag&os.O_RDWR != 0:
+		mode = ordwr
+	case flag&os.O_WRONLY != 0:
+		mode = owrite
+	}
+
+	if flag&os.O_TRUNC != 0 {
+		mode |= otrunc
+	}
+
+	return mode
+}
+
+func (fs *P9) Stat(filename string) (os.FileInfo, error) {
+	d, err := fs.lookup(filename, true)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: filename, Err: err}
+	}
+
+	return newFileInfo(path.Base(filepath.ToSlash(filename)), d), nil
+}
+
+func (fs *P9) Lstat(filename string) (os.FileInfo, error) {
+	d, err := fs.lookup(filename, false)
+	if err != nil {
+		return nil, &os.PathError{Op: "lstat", Path: filename, Err: err}
+	}
+
+	return newFileInfo(path.Base(filepath.ToSlash(filename)), d), nil
+}
+
+// ReadDir reads the stats of the files in the directory, sorted by name.
+func (fs *P9) ReadDir(filename string) ([]os.FileInfo, error) {
+	entries, err := fs.readDir(filename)
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: filename, Err: err}
+	}
+
+	infos := make([]os.FileInfo, len(entries))
+	for i, d := range entries {
+		infos[i] = newFileInfo(d.name, d)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+
+	return infos, nil
+}
+
+func (fs *P9) readDir(p string) ([]*dir, error) {
+	fid, err := fs.walk(p, true)
+	if err != nil {
+		return nil, err
+	}
+
+	defer fs.clunk(fid)
+	iounit, err := fs.open(fid, oread)
+	if err != nil {
+		return nil, err
+	}
+
+	// the directories are read sequentially, each read returns whole stats.
+	var entries []*dir
+	for offset := uint64(0); ; {
+		e := &encoder{}
+		e.u32(fid)
+		e.u64(offset)
+		e.u32(iounit)
+		d, err := fs.conn.rpc(tread, e)
+		if err != nil {
+			return nil, err
+		}
+
+		data := d.data()
+		if d.err != nil {
+			return nil, d.err
+		}
+
+		if len(data) == 0 {
+			return entries, nil
+		}
+
+		offset += uint64(len(data))
+		for s := (&decoder{b: data}); len(s.b) != 0; {
+			st, err := decodeDir(s, fs.conn.dotu)
+			if err != nil {
+				return nil, err
+			}
+
+			entries = append(entries, st)
+		}
+	}
+}
+
+// MkdirAll creates the directory and any missing parent with Tcreate.
+func (fs *P9) MkdirAll(filename string, perm os.FileMode) error {
+	if err := fs.mkdirAll(filename, perm); err != nil {
+		return &os.PathError{Op: "mkdir", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *P9) mkdirAll(p string, perm os.FileMode) error {
+	names := split(p)
+	for i := range names {
+		current := path.Join(names[:i+1]...)
+		d, err := fs.lookup(current, true)
+		if err == nil {
+			if d.mode&dmDir == 0 {
+				return errNotDir
+			}
+
+			continue
+		}
+
+		if !os.IsNotExist(err) {
+			return err
+		}
+
+		fid, _, err := fs.create(current, dmDir|fromFileMode(perm&os.ModePerm), oread, "")
+		if err != nil {
+			return err
+		}
+
+		fs.clunk(fid)
+	}
+
+	return nil
+}
+
+// Rename renames the file with Twstat if it's kept in the same directory,
+// otherwise it's moved, copying it and removing the original.
+func (fs *P9) Rename(from, to string) error {
+	if err := fs.rename(from, to); err != nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *P9) rename(from, to string) error {
+	from = path.Clean("/" + filepath.ToSlash(from))
+	to = path.Clean("/" + filepath.ToSlash(to))
+	d, err := fs.lookup(from, false)
+	if err != nil {
+		return err
+	}
+
+	if from == to {
+		return nil
+	}
+
+	if err := fs.mkdirAll(path.Dir(to), 0755); err != nil {
+		return err
+	}
+
+	// the existing files are replaced, as in os.Rename.
+	if old, err := fs.lookup(to, false); err == nil && old.mode&dmDir == 0 {
+		if err := fs.remove(to); err != nil {
+			return err
+		}
+	}
+
+	if path.Dir(from) != path.Dir(to) {
+		return fs.move(from, to, d)
+	}
+
+	fid, err := fs.walk(from, false)
+	if err != nil {
+		return err
+	}
+
+	defer fs.clunk(fid)
+	st := newWstat()
+	st.name = path.Base(to)
+	return fs.wstat(fid, st)
+}
+
+// move moves the file with the given stat to another directory.
+func (fs *P9) move(from, to string, d *dir) error {
+	switch {
+	case d.mode&dmDir != 0:
+		if err := fs.mkdirAll(to, toFileMode(d.mode)); err != nil {
+			return err
+		}
+
+		entries, err := fs.readDir(from)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			if err := fs.move(path.Join(from, e.name), path.Join(to, e.name), e); err != nil {
+				return err
+			}
+		}
+	case d.mode&dmSymlink != 0:
+		if err := fs.symlink(d.extension, to); err != nil {
+			return err
+		}
+	default:
+		if err := fs.copy(from, to, toFileMode(d.mode)); err != nil {
+			return err
+		}
+	}
+
+	return fs.remove(from)
+}
+
+func (fs *P9) copy(from, to string, perm os.FileMode) error {
+	src, err := fs.openFile(from, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	defer src.Close()
+	dst, err := fs.openFile(to, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+
+	return dst.Close()
+}
+
+// Remove removes the given file or empty directory with Tremove.
+func (fs *P9) Remove(filename string) error {
+	if err := fs.remove(filename); err != nil {
+		return &os.PathError{Op: "remove", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *P9) remove(p string) error {
+	d, err := fs.lookup(p, false)
+	if err != nil {
+		return err
+	}
+
+	if d.mode&dmDir != 0 {
+		entries, err := fs.readDir(p)
+		if err != nil {
+			return err
+		}
+
+		if len(entries) != 0 {
+			return ErrNotEmpty
+		}
+	}
+
+	fid, err := fs.walk(p, false)
+	if err != nil {
+		return err
+	}
+
+	e := &encoder{}
+	e.u32(fid)
+	_, err = fs.conn.rpc(tremove, e)
+	// Tremove clunks the fid even if the file isn't removed.
+	fs.releaseFid(fid)
+	return err
+}
+
+// Symlink creates a symbolic link with Tcreate, only supported by the
+// 9P2000.u servers.
+func (fs *P9) Symlink(target, link string) error {
+	if err := fs.symlink(target, link); err != nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *P9) symlink(target, link string) error {
+	if !fs.conn.dotu {
+		return billy.ErrNotSupported
+	}
+
+	if _, err := fs.lookup(link, false); err == nil {
+		return os.ErrExist
+	}
+
+	if err := fs.mkdirAll(path.Dir(filepath.ToSlash(link)), 0755); err != nil {
+		return err
+	}
+
+	fid, _, err := fs.create(link, dmSymlink|0777, oread, target)
+	if err != nil {
+		return err
+	}
+
+	return fs.clunk(fid)
+}
+
+// Readlink returns the target of the symbolic link, only supported by the
+// 9P2000.u servers.
+func (fs *P9) Readlink(link string) (string, error) {
+	if !fs.conn.dotu {
+		return "", &os.PathError{Op: "readlink", Path: link, Err: billy.ErrNotSupported}
+	}
+
+	d, err := fs.lookup(link, false)
+	if err == nil && d.mode&dmSymlink == 0 {
+		err = errNotLink
+	}
+
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: link, Err: err}
+	}
+
+	return d.extension, nil
+}
+
+func (fs *P9) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(fs, dir, prefix)
+}
+
+func (fs *P9) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (fs *P9) Chroot(p string) (billy.Filesystem, error) {
+	return chroot.New(fs, fs.Join(fs.Root(), p)), nil
+}
+
+func (fs *P9) Root() string {
+	return string(filepath.Separator)
+}
+
+// Capabilities implements the Capable interface.
+func (fs *P9) Capabilities() billy.Capability {
+	return billy.WriteCapability | billy.ReadCapability |
+		billy.ReadAndWriteCapability | billy.SeekCapability |
+		billy.TruncateCapability
+}
+
+// split returns the names of the elements of the given path, relative to
+// the root.
+func split(p string) []string {
+	p = path.Clean("/" + filepath.ToSlash(p))
+	if p == "/" {
+		return nil
+	}
+
+	return strings.Split(p[1:], "/")
+}
@@ -0,0 +1,191 @@
+package p9fs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/internal/linkutil"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&P9Suite{})
+
+type P9Suite struct {
+	test.FilesystemSuite
+	FS     *P9
+	server *server
+}
+
+func (s *P9Suite) SetUpTest(c *C) {
+	s.server = newServer()
+	s.FS = s.newFS(c, Options{})
+	s.FilesystemSuite = test.NewFilesystemSuite(s.FS)
+}
+
+func (s *P9Suite) TearDownTest(c *C) {
+	s.FS.Close()
+}
+
+func (s *P9Suite) newFS(c *C, opts Options) *P9 {
+	fs, err := New(s.server.connect(), opts)
+	c.Assert(err, IsNil)
+	return fs
+}
+
+func (s *P9Suite) TestVersion(c *C) {
+	c.Assert(s.FS.conn.dotu, Equals, true)
+	c.Assert(s.FS.conn.msize, Equals, uint32(8192))
+	c.Assert(s.server.count(tversion), Equals, 1)
+	c.Assert(s.server.count(tattach), Equals, 1)
+}
+
+func (s *P9Suite) TestNoExtensions(c *C) {
+	s.FS.Close()
+	s.server = newServer()
+	s.server.noDotu = true
+	s.FS = s.newFS(c, Options{})
+	c.Assert(s.FS.conn.dotu, Equals, false)
+
+	c.Assert(util.WriteFile(s.FS, "foo/bar", []byte("foo"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "foo/bar"), Equals, "foo")
+
+	_, err := s.FS.Stat("qux")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	err = s.FS.Symlink("foo/bar", "qux")
+	c.Assert(err.(*os.LinkError).Err, Equals, billy.ErrNotSupported)
+
+	_, err = s.FS.Readlink("foo/bar")
+	c.Assert(err.(*os.PathError).Err, Equals, billy.ErrNotSupported)
+}
+
+func (s *P9Suite) TestLargeFile(c *C) {
+	content := bytes.Repeat([]byte("0123456789"), 3000)
+	c.Assert(util.WriteFile(s.FS, "foo", content, 0644), IsNil)
+	c.Assert(s.server.count(twrite), Equals, 4)
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, string(content))
+
+	f, err := s.FS.Open("foo")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	buf := make([]byte, 10000)
+	n, err := f.ReadAt(buf, 25000)
+	c.Assert(err, NotNil)
+	c.Assert(n, Equals, 5000)
+	c.Assert(buf[:n], DeepEquals, content[25000:])
+}
+
+func (s *P9Suite) TestLongPath(c *C) {
+	p := strings.Repeat("a/", maxWalk*2) + "foo"
+	c.Assert(util.WriteFile(s.FS, p, []byte("foo"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, p), Equals, "foo")
+
+	c.Assert(s.FS.Symlink(strings.Repeat("a/", maxWalk*2), "link"), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "link/foo"), Equals, "foo")
+}
+
+func (s *P9Suite) TestSymlinkLoop(c *C) {
+	c.Assert(s.FS.Symlink("bar", "foo"), IsNil)
+	c.Assert(s.FS.Symlink("foo", "bar"), IsNil)
+
+	_, err := s.FS.Stat("foo")
+	c.Assert(err.(*os.PathError).Err, Equals, linkutil.ErrTooManyLinks)
+
+	fi, err := s.FS.Lstat("foo")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode()&os.ModeSymlink, Not(Equals), os.FileMode(0))
+}
+
+func (s *P9Suite) TestRenameDirAcrossDirs(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar/qux", []byte("foo"), 0644), IsNil)
+	c.Assert(s.FS.Symlink("qux", "foo/bar/link"), IsNil)
+	c.Assert(s.FS.Rename("foo/bar", "baz/bar"), IsNil)
+
+	_, err := s.FS.Stat("foo/bar")
+	c.Assert(os.IsNotExist(err), Equals, true)
+	c.Assert(test.ReadFile(c, s.FS, "baz/bar/qux"), Equals, "foo")
+
+	target, err := s.FS.Readlink("baz/bar/link")
+	c.Assert(err, IsNil)
+	c.Assert(target, Equals, "qux")
+}
+
+func (s *P9Suite) TestRenameReplace(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "bar", []byte("bar"), 0644), IsNil)
+	c.Assert(s.FS.Rename("foo", "bar"), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "bar"), Equals, "foo")
+}
+
+func (s *P9Suite) TestRemoveNotEmpty(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar", nil, 0644), IsNil)
+
+	err := s.FS.Remove("foo")
+	c.Assert(err.(*os.PathError).Err, Equals, ErrNotEmpty)
+}
+
+func (s *P9Suite) TestConcurrent(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f, err := s.FS.Open("foo")
+			if err == nil {
+				_, err = ioutil.ReadAll(f)
+				f.Close()
+			}
+
+			errs <- err
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		c.Assert(err, IsNil)
+	}
+}
+
+func (s *P9Suite) TestClosed(c *C) {
+	c.Assert(s.FS.Close(), IsNil)
+
+	_, err := s.FS.Stat("foo")
+	c.Assert(err.(*os.PathError).Err, Equals, errClosed)
+}
+
+func (s *P9Suite) TestToError(c *C) {
+	c.Assert(toError(&Error{Name: "whatever", Errno: enoent}), Equals, os.ErrNotExist)
+	c.Assert(toError(&Error{Name: "file does not exist"}), Equals, os.ErrNotExist)
+	c.Assert(toError(&Error{Name: "file already exists"}), Equals, os.ErrExist)
+	c.Assert(toError(&Error{Name: "permission denied"}), Equals, os.ErrPermission)
+	c.Assert(toError(&Error{Name: "directory not empty"}), Equals, ErrNotEmpty)
+
+	err := &Error{Name: "i/o error", Errno: eio}
+	c.Assert(toError(err), Equals, err)
+}
+
+func (s *P9Suite) TestFileMode(c *C) {
+	for _, mode := range []os.FileMode{
+		0644,
+		os.ModeDir | 0755,
+		os.ModeSymlink | 0777,
+		os.ModeSetuid | os.ModeSticky | 0700,
+	} {
+		c.Assert(toFileMode(fromFileMode(mode)), Equals, mode)
+	}
+}
@@ -0,0 +1,447 @@
+package p9fs
+
+import (
+	"hash/fnv"
+	"io"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"sync"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+)
+
+// server is a minimal 9P server over a memfs, to test the client. The
+// requests of a single connection are served sequentially.
+type server struct {
+	fs billy.Filesystem
+	// noDotu disables 9P2000.u, serving plain 9P2000.
+	noDotu bool
+	// msize is the maximum size of the messages accepted by the server.
+	msize uint32
+
+	dotu bool
+	fids map[uint32]*serverFid
+
+	mu       sync.Mutex
+	messages map[uint8]int
+}
+
+type serverFid struct {
+	path string
+	file billy.File
+	// dir holds the stats of the opened directories.
+	dir []byte
+}
+
+func newServer() *server {
+	return &server{
+		fs:       memfs.New(),
+		msize:    8192,
+		fids:     make(map[uint32]*serverFid),
+		messages: make(map[uint8]int),
+	}
+}
+
+// connect starts serving a new connection, returning the client side.
+func (s *server) connect() net.Conn {
+	client, conn := net.Pipe()
+	go s.serve(conn)
+	return client
+}
+
+func (s *server) count(typ uint8) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.messages[typ]
+}
+
+func (s *server) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		typ, tag, body, err := readMsg(conn, s.msize)
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.messages[typ]++
+		s.mu.Unlock()
+
+		e := &encoder{}
+		err = s.handle(typ, &decoder{b: body}, e)
+		rtyp := typ + 1
+		if err != nil {
+			rtyp, e = rerror, &encoder{}
+			e.str(err.Error())
+			if s.dotu {
+				e.u32(errno(err))
+			}
+		}
+
+		if err := writeMsg(conn, rtyp, tag, e.b); err != nil {
+			return
+		}
+	}
+}
+
+func errno(err error) uint32 {
+	switch {
+	case os.IsNotExist(err):
+		return enoent
+	case os.IsExist(err):
+		return eexist
+	}
+
+	return eio
+}
+
+func (s *server) fid(d *decoder) (*serverFid, error) {
+	f, ok := s.fids[d.u32()]
+	if !ok {
+		return nil, &Error{Name: "unknown fid"}
+	}
+
+	return f, nil
+}
+
+func (s *server) handle(typ uint8, d *decoder, e *encoder) error {
+	switch typ {
+	case tversion:
+		if msize := d.u32(); msize < s.msize {
+			s.msize = msize
+		}
+
+		s.dotu = d.str() == versionU && !s.noDotu
+		e.u32(s.msize)
+		if s.dotu {
+			e.str(versionU)
+		} else {
+			e.str(version)
+		}
+	case tattach:
+		s.fids[d.u32()] = &serverFid{path: "/"}
+		e.qid(s.qid("/"))
+	case twalk:
+		return s.walk(d, e)
+	case topen:
+		f, err := s.fid(d)
+		if err != nil {
+			return err
+		}
+
+		return s.open(f, f.path, d.u8(), e)
+	case tcreate:
+		f, err := s.fid(d)
+		if err != nil {
+			return err
+		}
+
+		return s.create(f, d, e)
+	case tread:
+		f, err := s.fid(d)
+		if err != nil {
+			return err
+		}
+
+		return s.read(f, int64(d.u64()), d.u32(), e)
+	case twrite:
+		f, err := s.fid(d)
+		if err != nil {
+			return err
+		}
+
+		offset, data := d.u64(), d.data()
+		if _, err := f.file.Seek(int64(offset), io.SeekStart); err != nil {
+			return err
+		}
+
+		n, err := f.file.Write(data)
+		e.u32(uint32(n))
+		return err
+	case tclunk, tremove:
+		fid := d.u32()
+		f, ok := s.fids[fid]
+		if !ok {
+			return &Error{Name: "unknown fid"}
+		}
+
+		delete(s.fids, fid)
+		if f.file != nil {
+			f.file.Close()
+		}
+
+		if typ == tremove {
+			return s.fs.Remove(f.path)
+		}
+	case tstat:
+		f, err := s.fid(d)
+		if err != nil {
+			return err
+		}
+
+		st, err := s.stat(f.path)
+		if err != nil {
+			return err
+		}
+
+		s2 := &encoder{}
+		st.encode(s2, s.dotu)
+		e.u16(uint16(len(s2.b)))
+		e.b = append(e.b, s2.b...)
+	case twstat:
+		f, err := s.fid(d)
+		if err != nil {
+			return err
+		}
+
+		d.u16()
+		st, err := decodeDir(d, s.dotu)
+		if err != nil {
+			return err
+		}
+
+		return s.wstat(f, st)
+	default:
+		return &Error{Name: "not supported"}
+	}
+
+	return nil
+}
+
+func (s *server) walk(d *decoder, e *encoder) error {
+	f, err := s.fid(d)
+	if err != nil {
+		return err
+	}
+
+	newfid := d.u32()
+	names := make([]string, d.u16())
+	for i := range names {
+		names[i] = d.str()
+	}
+
+	p := f.path
+	var qids []qid
+	for _, name := range names {
+		next := path.Join(p, name)
+		if _, err := s.fs.Lstat(next); err != nil {
+			if len(qids) == 0 {
+				return err
+			}
+
+			break
+		}
+
+		p = next
+		qids = append(qids, s.qid(p))
+	}
+
+	if len(qids) == len(names) {
+		s.fids[newfid] = &serverFid{path: p}
+	}
+
+	e.u16(uint16(len(qids)))
+	for _, q := range qids {
+		e.qid(q)
+	}
+
+	return nil
+}
+
+func (s *server) open(f *serverFid, p string, mode uint8, e *encoder) error {
+	fi, err := s.fs.Lstat(p)
+	if err != nil {
+		return err
+	}
+
+	if fi.IsDir() {
+		if mode&^otrunc != oread {
+			return &Error{Name: "is a directory"}
+		}
+
+		infos, err := s.fs.ReadDir(p)
+		if err != nil {
+			return err
+		}
+
+		sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+		dir := &encoder{}
+		for _, fi := range infos {
+			st, err := s.stat(path.Join(p, fi.Name()))
+			if err != nil {
+				return err
+			}
+
+			st.encode(dir, s.dotu)
+		}
+
+		f.dir = dir.b
+	} else {
+		flag := os.O_RDONLY
+		switch mode &^ otrunc {
+		case owrite:
+			flag = os.O_WRONLY
+		case ordwr:
+			flag = os.O_RDWR
+		}
+
+		if mode&otrunc != 0 {
+			flag |= os.O_TRUNC
+		}
+
+		if f.file, err = s.fs.OpenFile(p, flag, 0); err != nil {
+			return err
+		}
+	}
+
+	f.path = p
+	e.qid(s.qid(p))
+	e.u32(0)
+	return nil
+}
+
+func (s *server) create(f *serverFid, d *decoder, e *encoder) error {
+	name, perm, mode := d.str(), d.u32(), d.u8()
+	var extension string
+	if s.dotu {
+		extension = d.str()
+	}
+
+	p := path.Join(f.path, name)
+	if _, err := s.fs.Lstat(p); err == nil {
+		return os.ErrExist
+	}
+
+	var err error
+	switch {
+	case perm&dmDir != 0:
+		err = s.fs.MkdirAll(p, toFileMode(perm).Perm())
+	case perm&dmSymlink != 0:
+		err = s.fs.Symlink(extension, p)
+	default:
+		var file billy.File
+		file, err = s.fs.OpenFile(p, os.O_CREATE, toFileMode(perm))
+		if err == nil {
+			err = file.Close()
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if perm&dmSymlink != 0 {
+		// the symbolic links aren't opened.
+		f.path = p
+		e.qid(s.qid(p))
+		e.u32(0)
+		return nil
+	}
+
+	return s.open(f, p, mode, e)
+}
+
+func (s *server) read(f *serverFid, offset int64, count uint32, e *encoder) error {
+	if f.file == nil {
+		// only whole stats are returned when reading a directory.
+		var n int
+		for d := (&decoder{b: f.dir[offset:]}); len(d.b) != 0; {
+			size := int(d.u16()) + 2
+			if n+size > int(count) {
+				break
+			}
+
+			d.next(size - 2)
+			n += size
+		}
+
+		e.data(f.dir[offset : offset+int64(n)])
+		return nil
+	}
+
+	buf := make([]byte, count)
+	n, err := f.file.ReadAt(buf, offset)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	e.data(buf[:n])
+	return nil
+}
+
+func (s *server) stat(p string) (*dir, error) {
+	fi, err := s.fs.Lstat(p)
+	if err != nil {
+		return nil, err
+	}
+
+	st := &dir{
+		qid:    s.qid(p),
+		mode:   fromFileMode(fi.Mode()),
+		mtime:  uint32(fi.ModTime().Unix()),
+		length: uint64(fi.Size()),
+		name:   path.Base(p),
+		uid:    "nobody",
+		gid:    "nobody",
+	}
+
+	if fi.IsDir() {
+		st.length = 0
+	}
+
+	if fi.Mode()&os.ModeSymlink != 0 {
+		if st.extension, err = s.fs.Readlink(p); err != nil {
+			return nil, err
+		}
+	}
+
+	return st, nil
+}
+
+func (s *server) wstat(f *serverFid, st *dir) error {
+	if st.length != ^uint64(0) {
+		file, err := s.fs.OpenFile(f.path, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+
+		if err := file.Truncate(int64(st.length)); err != nil {
+			return err
+		}
+
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+
+	if st.name != "" {
+		to := path.Join(path.Dir(f.path), st.name)
+		if err := s.fs.Rename(f.path, to); err != nil {
+			return err
+		}
+
+		f.path = to
+	}
+
+	return nil
+}
+
+func (s *server) qid(p string) qid {
+	h := fnv.New64a()
+	h.Write([]byte(p))
+	q := qid{path: h.Sum64()}
+	if fi, err := s.fs.Lstat(p); err == nil {
+		switch {
+		case fi.IsDir():
+			q.typ = qtDir
+		case fi.Mode()&os.ModeSymlink != 0:
+			q.typ = qtSymlink
+		}
+	}
+
+	return q
+}
@@ -0,0 +1,194 @@
+package p9fs
+
+import (
+	"os"
+	"time"
+)
+
+// The bits of the mode of a file, the ones after dmTmp are only defined in
+// 9P2000.u.
+const (
+	dmDir       = 0x80000000
+	dmAppend    = 0x40000000
+	dmExcl      = 0x20000000
+	dmTmp       = 0x04000000
+	dmSymlink   = 0x02000000
+	dmDevice    = 0x00800000
+	dmNamedPipe = 0x00200000
+	dmSocket    = 0x00100000
+	dmSetuid    = 0x00080000
+	dmSetgid    = 0x00040000
+	dmSticky    = 0x00010000
+)
+
+var modes = []struct {
+	dm   uint32
+	mode os.FileMode
+}{
+	{dmDir, os.ModeDir},
+	{dmAppend, os.ModeAppend},
+	{dmExcl, os.ModeExclusive},
+	{dmTmp, os.ModeTemporary},
+	{dmSymlink, os.ModeSymlink},
+	{dmDevice, os.ModeDevice},
+	{dmNamedPipe, os.ModeNamedPipe},
+	{dmSocket, os.ModeSocket},
+	{dmSetuid, os.ModeSetuid},
+	{dmSetgid, os.ModeSetgid},
+	{dmSticky, os.ModeSticky},
+}
+
+func toFileMode(dm uint32) os.FileMode {
+	mode := os.FileMode(dm) & os.ModePerm
+	for _, m := range modes {
+		if dm&m.dm != 0 {
+			mode |= m.mode
+		}
+	}
+
+	return mode
+}
+
+func fromFileMode(mode os.FileMode) uint32 {
+	dm := uint32(mode & os.ModePerm)
+	for _, m := range modes {
+		if mode&m.mode != 0 {
+			dm |= m.dm
+		}
+	}
+
+	return dm
+}
+
+// dir is the stat of a file, as sent in Rstat and Twstat.
+type dir struct {
+	typ    uint16
+	dev    uint32
+	qid    qid
+	mode   uint32
+	atime  uint32
+	mtime  uint32
+	length uint64
+	name   string
+	uid    string
+	gid    string
+	muid   string
+
+	// the fields of 9P2000.u.
+	extension string
+	nuid      uint32
+	ngid      uint32
+	nmuid     uint32
+}
+
+// newWstat returns a dir to be sent in Twstat, with all the fields set to
+// the values that leave them unchanged.
+func newWstat() *dir {
+	return &dir{
+		typ:    ^uint16(0),
+		dev:    ^uint32(0),
+		qid:    qid{typ: ^uint8(0), version: ^uint32(0), path: ^uint64(0)},
+		mode:   ^uint32(0),
+		atime:  ^uint32(0),
+		mtime:  ^uint32(0),
+		length: ^uint64(0),
+		nuid:   ^uint32(0),
+		ngid:   ^uint32(0),
+		nmuid:  ^uint32(0),
+	}
+}
+
+// encode appends the stat to e, preceded by its size.
+func (d *dir) encode(e *encoder, dotu bool) {
+	s := &encoder{}
+	s.u16(d.typ)
+	s.u32(d.dev)
+	s.qid(d.qid)
+	s.u32(d.mode)
+	s.u32(d.atime)
+	s.u32(d.mtime)
+	s.u64(d.length)
+	s.str(d.name)
+	s.str(d.uid)
+	s.str(d.gid)
+	s.str(d.muid)
+	if dotu {
+		s.str(d.extension)
+		s.u32(d.nuid)
+		s.u32(d.ngid)
+		s.u32(d.nmuid)
+	}
+
+	e.u16(uint16(len(s.b)))
+	e.b = append(e.b, s.b...)
+}
+
+// decodeDir decodes a stat, preceded by its size.
+func decodeDir(d *decoder, dotu bool) (*dir, error) {
+	size := d.u16()
+	s := &decoder{b: d.next(int(size))}
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	st := &dir{
+		typ:    s.u16(),
+		dev:    s.u32(),
+		qid:    s.qid(),
+		mode:   s.u32(),
+		atime:  s.u32(),
+		mtime:  s.u32(),
+		length: s.u64(),
+		name:   s.str(),
+		uid:    s.str(),
+		gid:    s.str(),
+		muid:   s.str(),
+	}
+
+	if dotu {
+		st.extension = s.str()
+		st.nuid, st.ngid, st.nmuid = s.u32(), s.u32(), s.u32()
+	}
+
+	return st, s.err
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func newFileInfo(name string, d *dir) *fileInfo {
+	return &fileInfo{
+		name:    name,
+		size:    int64(d.length),
+		mode:    toFileMode(d.mode),
+		modTime: time.Unix(int64(d.mtime), 0),
+	}
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.size
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	return fi.mode
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.mode.IsDir()
+}
+
+func (*fileInfo) Sys() interface{} {
+	return nil
+}
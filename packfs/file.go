@@ -0,0 +1,176 @@
+package packfs
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// file is a billy.File of a Pack filesystem. Files opened for reading read
+// directly from the segment or object, while files opened for writing are
+// buffered in memory and committed on Close.
+type file struct {
+	fs   *Pack
+	name string
+	path string
+	flag int
+	mode os.FileMode
+
+	r    io.ReaderAt
+	size int64
+
+	content  []byte
+	dirty    bool
+	position int64
+	isClosed bool
+}
+
+func (f *file) Name() string {
+	return f.name
+}
+
+func (f *file) Read(b []byte) (int, error) {
+	n, err := f.ReadAt(b, f.position)
+	f.position += int64(n)
+
+	if err == io.EOF && n != 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+func (f *file) ReadAt(b []byte, off int64) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: f.name, Err: errors.New("negative offset")}
+	}
+
+	if f.flag&os.O_WRONLY != 0 {
+		return 0, errors.New("read not supported")
+	}
+
+	if f.r != nil {
+		if off >= f.size {
+			return 0, io.EOF
+		}
+
+		return f.r.ReadAt(b, off)
+	}
+
+	if off >= int64(len(f.content)) {
+		return 0, io.EOF
+	}
+
+	n := copy(b, f.content[off:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		f.position += offset
+	case io.SeekStart:
+		f.position = offset
+	case io.SeekEnd:
+		f.position = f.len() + offset
+	}
+
+	return f.position, nil
+}
+
+func (f *file) len() int64 {
+	if f.r != nil {
+		return f.size
+	}
+
+	return int64(len(f.content))
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	if !isWrite(f.flag) {
+		return 0, errors.New("write not supported")
+	}
+
+	if f.position < 0 {
+		return 0, &os.PathError{Op: "writeat", Path: f.name, Err: errors.New("negative offset")}
+	}
+
+	if f.flag&os.O_APPEND != 0 {
+		f.position = int64(len(f.content))
+	}
+
+	end := f.position + int64(len(p))
+	if end > int64(len(f.content)) {
+		f.content = append(f.content, make([]byte, end-int64(len(f.content)))...)
+	}
+
+	copy(f.content[f.position:], p)
+	f.position = end
+	f.dirty = true
+	return len(p), nil
+}
+
+func (f *file) Truncate(size int64) error {
+	if f.isClosed {
+		return os.ErrClosed
+	}
+
+	if !isWrite(f.flag) {
+		return errors.New("truncate not supported")
+	}
+
+	if size < int64(len(f.content)) {
+		f.content = f.content[:size]
+	} else {
+		f.content = append(f.content, make([]byte, size-int64(len(f.content)))...)
+	}
+
+	f.dirty = true
+	return nil
+}
+
+func (f *file) Close() error {
+	if f.isClosed {
+		return os.ErrClosed
+	}
+
+	f.isClosed = true
+	if c, ok := f.r.(io.Closer); ok {
+		return c.Close()
+	}
+
+	if !f.dirty {
+		return nil
+	}
+
+	f.fs.m.Lock()
+	defer f.fs.m.Unlock()
+
+	return f.fs.write(f.path, f.mode, f.content)
+}
+
+// Lock is a no-op in packfs.
+func (f *file) Lock() error {
+	return nil
+}
+
+// Unlock is a no-op in packfs.
+func (f *file) Unlock() error {
+	return nil
+}
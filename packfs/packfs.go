@@ -0,0 +1,882 @@
+// Package packfs provides a billy filesystem that packs small files into big
+// segment files stored in an underlying filesystem, reducing the number of
+// objects when the underlying storage has a high per-object overhead.
+//
+// The segments are append-only logs, every mutation is recorded as a new
+// record and the index is rebuilt by replaying them when the filesystem is
+// opened. Files bigger than a threshold are stored as individual objects.
+// The space of the overwritten and removed files is reclaimed by Compact.
+package packfs // import "gopkg.in/src-d/go-billy.v4/packfs"
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const (
+	segmentsDir = "segments"
+	objectsDir  = "objects"
+
+	segmentExt = ".seg"
+
+	// DefaultThreshold is the default maximum size of a packed file.
+	DefaultThreshold = 64 * 1024
+	// DefaultSegmentSize is the default size of a segment.
+	DefaultSegmentSize = 16 * 1024 * 1024
+)
+
+// Options holds the configuration of a Pack filesystem.
+type Options struct {
+	// Threshold is the maximum size of a file to be packed into a segment,
+	// bigger files are stored as individual objects. DefaultThreshold is
+	// used if zero.
+	Threshold int64
+	// SegmentSize is the size from which the active segment is sealed and
+	// a new one is started. DefaultSegmentSize is used if zero.
+	SegmentSize int64
+}
+
+// Pack is a filesystem storing small files packed into segments.
+type Pack struct {
+	storage billy.Filesystem
+	opts    Options
+
+	m          sync.Mutex
+	nodes      map[string]*node
+	children   map[string]map[string]bool
+	segments   []int
+	active     *segment
+	readers    map[int]billy.File
+	nextObject uint64
+}
+
+type node struct {
+	mode    os.FileMode
+	modTime time.Time
+	size    int64
+	segment int
+	offset  int64
+	object  string
+}
+
+type segment struct {
+	id   int
+	f    billy.File
+	size int64
+}
+
+// Stats holds the space usage of a Pack filesystem.
+type Stats struct {
+	// Segments is the number of segments.
+	Segments int
+	// SegmentsSize is the total size in bytes of the segments.
+	SegmentsSize int64
+	// LiveSize is the size in bytes of the packed content still reachable,
+	// the difference with SegmentsSize can be reclaimed by Compact.
+	LiveSize int64
+	// Objects is the number of files stored as individual objects.
+	Objects int
+}
+
+// New opens a Pack filesystem stored at the given storage, replaying the
+// existing segments if any.
+func New(storage billy.Filesystem, opts Options) (*Pack, error) {
+	if opts.Threshold == 0 {
+		opts.Threshold = DefaultThreshold
+	}
+
+	if opts.SegmentSize == 0 {
+		opts.SegmentSize = DefaultSegmentSize
+	}
+
+	fs := &Pack{
+		storage: storage,
+		opts:    opts,
+		readers: make(map[int]billy.File),
+	}
+
+	fs.reset()
+	if err := fs.load(); err != nil {
+		return nil, err
+	}
+
+	return fs, nil
+}
+
+func (fs *Pack) reset() {
+	fs.nodes = map[string]*node{"/": {mode: os.ModeDir | 0755, segment: -1}}
+	fs.children = make(map[string]map[string]bool)
+}
+
+func (fs *Pack) load() error {
+	ids, err := fs.listSegments()
+	if err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		if err := fs.replay(id, i == len(ids)-1); err != nil {
+			return err
+		}
+	}
+
+	fs.segments = ids
+	return fs.loadObjects()
+}
+
+func (fs *Pack) listSegments() ([]int, error) {
+	entries, err := fs.storage.ReadDir(segmentsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var ids []int
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), segmentExt) {
+			continue
+		}
+
+		id, err := strconv.Atoi(strings.TrimSuffix(e.Name(), segmentExt))
+		if err != nil {
+			continue
+		}
+
+		ids = append(ids, id)
+	}
+
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func (fs *Pack) replay(id int, last bool) error {
+	f, err := fs.storage.Open(segmentName(id))
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+	rr := newRecordReader(f)
+	for {
+		r, offset, err := rr.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err == errCorrupted && last {
+			// a crash in the middle of a write, the record is discarded.
+			return fs.truncateSegment(id, offset)
+		}
+
+		if err != nil {
+			return fmt.Errorf("segment %d at %d: %s", id, offset, err)
+		}
+
+		fs.apply(r, id, offset+r.contentOffset())
+	}
+}
+
+func (fs *Pack) truncateSegment(id int, size int64) error {
+	f, err := fs.storage.OpenFile(segmentName(id), os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func (fs *Pack) loadObjects() error {
+	entries, err := fs.storage.ReadDir(objectsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, e := range entries {
+		n, err := strconv.ParseUint(e.Name(), 16, 64)
+		if err == nil && n >= fs.nextObject {
+			fs.nextObject = n + 1
+		}
+	}
+
+	return nil
+}
+
+// apply updates the index with the given record, the content of the record
+// was stored in the segment id at offset.
+func (fs *Pack) apply(r *record, id int, offset int64) {
+	switch r.op {
+	case opPut:
+		n := &node{mode: r.mode, modTime: r.modTime, size: r.size, segment: -1}
+		if r.store == storeObject {
+			n.object = r.object
+		} else {
+			n.segment, n.offset = id, offset
+		}
+
+		fs.set(r.path, n)
+	case opMkdir:
+		if n, ok := fs.nodes[r.path]; ok && n.mode.IsDir() {
+			return
+		}
+
+		fs.set(r.path, &node{mode: r.mode | os.ModeDir, modTime: r.modTime, segment: -1})
+	case opDelete:
+		fs.delete(r.path)
+	case opRename:
+		fs.move(r.path, r.target)
+	}
+}
+
+func (fs *Pack) set(p string, n *node) {
+	fs.nodes[p] = n
+	for p != "/" {
+		dir, name := path.Split(p)
+		dir = clean(dir)
+
+		if fs.children[dir] == nil {
+			fs.children[dir] = make(map[string]bool)
+		}
+
+		fs.children[dir][name] = true
+		if _, ok := fs.nodes[dir]; ok {
+			return
+		}
+
+		fs.nodes[dir] = &node{mode: os.ModeDir | 0755, modTime: n.modTime, segment: -1}
+		p = dir
+	}
+}
+
+func (fs *Pack) delete(p string) {
+	delete(fs.nodes, p)
+	delete(fs.children, p)
+
+	dir, name := path.Split(p)
+	delete(fs.children[clean(dir)], name)
+}
+
+func (fs *Pack) move(from, to string) {
+	moves := [][2]string{{from, to}}
+	for p := range fs.nodes {
+		if strings.HasPrefix(p, from+"/") {
+			moves = append(moves, [2]string{p, to + strings.TrimPrefix(p, from)})
+		}
+	}
+
+	// parents first, so the children are registered on the moved parents.
+	sort.Slice(moves, func(i, j int) bool { return moves[i][0] < moves[j][0] })
+
+	nodes := make([]*node, len(moves))
+	for i, m := range moves {
+		nodes[i] = fs.nodes[m[0]]
+	}
+
+	for i := len(moves) - 1; i >= 0; i-- {
+		fs.delete(moves[i][0])
+	}
+
+	for i, m := range moves {
+		fs.set(m[1], nodes[i])
+	}
+}
+
+// commit appends the record to the active segment and applies it to the
+// index. Must be called with the lock held.
+func (fs *Pack) commit(r *record) error {
+	if fs.active == nil || fs.active.size >= fs.opts.SegmentSize {
+		if err := fs.roll(); err != nil {
+			return err
+		}
+	}
+
+	offset := fs.active.size
+	b := r.encode()
+	if _, err := fs.active.f.Write(b); err != nil {
+		return err
+	}
+
+	fs.active.size += int64(len(b))
+
+	old := fs.nodes[r.path]
+	fs.apply(r, fs.active.id, offset+r.contentOffset())
+	if old != nil && old.object != "" && old.object != r.object &&
+		(r.op == opPut || r.op == opDelete) {
+		// best effort, an orphan object is removed by Compact.
+		_ = fs.storage.Remove(old.object)
+	}
+
+	return nil
+}
+
+// roll seals the active segment and starts a new one.
+func (fs *Pack) roll() error {
+	if err := fs.seal(); err != nil {
+		return err
+	}
+
+	id := 1
+	if len(fs.segments) > 0 {
+		id = fs.segments[len(fs.segments)-1] + 1
+	}
+
+	f, err := fs.storage.OpenFile(segmentName(id), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	fi, err := fs.storage.Stat(segmentName(id))
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	fs.segments = append(fs.segments, id)
+	fs.active = &segment{id: id, f: f, size: fi.Size()}
+	return nil
+}
+
+func (fs *Pack) seal() error {
+	if fs.active == nil {
+		return nil
+	}
+
+	err := fs.active.f.Close()
+	fs.active = nil
+	return err
+}
+
+// readerAt returns a reader over the content of the given node.
+func (fs *Pack) readerAt(n *node) (io.ReaderAt, error) {
+	if n.object != "" {
+		return fs.storage.Open(n.object)
+	}
+
+	f, ok := fs.readers[n.segment]
+	if !ok {
+		var err error
+		f, err = fs.storage.Open(segmentName(n.segment))
+		if err != nil {
+			return nil, err
+		}
+
+		fs.readers[n.segment] = f
+	}
+
+	return io.NewSectionReader(f, n.offset, n.size), nil
+}
+
+func (fs *Pack) read(n *node) ([]byte, error) {
+	r, err := fs.readerAt(n)
+	if err != nil {
+		return nil, err
+	}
+
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	content := make([]byte, n.size)
+	if _, err := r.ReadAt(content, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return content, nil
+}
+
+// write stores the given content at p, packed into the active segment or as
+// an object depending on its size. Must be called with the lock held.
+func (fs *Pack) write(p string, mode os.FileMode, content []byte) error {
+	r := &record{
+		op:      opPut,
+		mode:    mode,
+		modTime: time.Now(),
+		path:    p,
+		size:    int64(len(content)),
+		content: content,
+	}
+
+	if r.size > fs.opts.Threshold {
+		r.store = storeObject
+		r.object = path.Join(objectsDir, fmt.Sprintf("%016x", fs.nextObject))
+		fs.nextObject++
+
+		if err := util.WriteFile(fs.storage, r.object, content, 0644); err != nil {
+			return err
+		}
+	}
+
+	return fs.commit(r)
+}
+
+func (fs *Pack) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Pack) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (fs *Pack) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	p, n, err := fs.resolve(clean(filename))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if n == nil {
+		if flag&os.O_CREATE == 0 {
+			return nil, os.ErrNotExist
+		}
+
+		if err := fs.checkParent(p); err != nil {
+			return nil, err
+		}
+
+		if err := fs.write(p, perm.Perm(), nil); err != nil {
+			return nil, err
+		}
+
+		n = fs.nodes[p]
+	} else if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+		return nil, os.ErrExist
+	}
+
+	if n.mode.IsDir() {
+		return nil, fmt.Errorf("cannot open directory: %s", filename)
+	}
+
+	f := &file{fs: fs, name: relative(filename), path: p, flag: flag, mode: n.mode}
+	if !isWrite(flag) {
+		f.r, err = fs.readerAt(n)
+		f.size = n.size
+		return f, err
+	}
+
+	if flag&os.O_TRUNC == 0 {
+		if f.content, err = fs.read(n); err != nil {
+			return nil, err
+		}
+	} else if n.size != 0 {
+		if err := fs.write(p, n.mode, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	if flag&os.O_APPEND != 0 {
+		f.position = int64(len(f.content))
+	}
+
+	return f, nil
+}
+
+// checkParent returns an error if any of the parents of p is not a directory.
+func (fs *Pack) checkParent(p string) error {
+	for p != "/" {
+		p = path.Dir(p)
+		if n, ok := fs.nodes[p]; ok {
+			if !n.mode.IsDir() {
+				return fmt.Errorf("not a directory: %s", p)
+			}
+
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// resolve returns the node at p following the symlinks, and its path. If the
+// node doesn't exist os.ErrNotExist is returned with the resolved path.
+func (fs *Pack) resolve(p string) (string, *node, error) {
+	for i := 0; i < 255; i++ {
+		n, ok := fs.nodes[p]
+		if !ok {
+			return p, nil, os.ErrNotExist
+		}
+
+		if n.mode&os.ModeSymlink == 0 {
+			return p, n, nil
+		}
+
+		target, err := fs.read(n)
+		if err != nil {
+			return p, nil, err
+		}
+
+		t := filepath.ToSlash(string(target))
+		if !path.IsAbs(t) {
+			t = path.Join(path.Dir(p), t)
+		}
+
+		p = clean(t)
+	}
+
+	return p, nil, fmt.Errorf("too many levels of symbolic links")
+}
+
+func (fs *Pack) Stat(filename string) (os.FileInfo, error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	_, n, err := fs.resolve(clean(filename))
+	if err != nil {
+		return nil, err
+	}
+
+	return newFileInfo(path.Base(clean(filename)), n), nil
+}
+
+func (fs *Pack) Lstat(filename string) (os.FileInfo, error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	p := clean(filename)
+	n, ok := fs.nodes[p]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	return newFileInfo(path.Base(p), n), nil
+}
+
+func (fs *Pack) ReadDir(dirname string) ([]os.FileInfo, error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	p, n, err := fs.resolve(clean(dirname))
+	if err != nil {
+		return nil, err
+	}
+
+	if !n.mode.IsDir() {
+		return nil, fmt.Errorf("not a directory: %s", dirname)
+	}
+
+	var names []string
+	for name := range fs.children[p] {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var entries []os.FileInfo
+	for _, name := range names {
+		entries = append(entries, newFileInfo(name, fs.nodes[path.Join(p, name)]))
+	}
+
+	return entries, nil
+}
+
+func (fs *Pack) MkdirAll(filename string, perm os.FileMode) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	p := clean(filename)
+	if n, ok := fs.nodes[p]; ok {
+		if n.mode.IsDir() {
+			return nil
+		}
+
+		return fmt.Errorf("file already exists %q", filename)
+	}
+
+	if err := fs.checkParent(p); err != nil {
+		return err
+	}
+
+	return fs.commit(&record{op: opMkdir, mode: perm.Perm() | os.ModeDir, modTime: time.Now(), path: p})
+}
+
+func (fs *Pack) Rename(from, to string) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	from, to = clean(from), clean(to)
+	if _, ok := fs.nodes[from]; !ok {
+		return os.ErrNotExist
+	}
+
+	if n, ok := fs.nodes[to]; ok {
+		if n.mode.IsDir() {
+			return fmt.Errorf("destination is a directory: %s", to)
+		}
+
+		if n.object != "" {
+			defer fs.storage.Remove(n.object)
+		}
+	}
+
+	if err := fs.checkParent(to); err != nil {
+		return err
+	}
+
+	return fs.commit(&record{op: opRename, modTime: time.Now(), path: from, target: to})
+}
+
+func (fs *Pack) Remove(filename string) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	p := clean(filename)
+	n, ok := fs.nodes[p]
+	if !ok {
+		return os.ErrNotExist
+	}
+
+	if n.mode.IsDir() && len(fs.children[p]) != 0 {
+		return fmt.Errorf("dir: %s contains files", filename)
+	}
+
+	return fs.commit(&record{op: opDelete, modTime: time.Now(), path: p})
+}
+
+func (fs *Pack) Symlink(target, link string) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	p := clean(link)
+	if _, ok := fs.nodes[p]; ok {
+		return os.ErrExist
+	}
+
+	return fs.write(p, os.ModeSymlink|0777, []byte(target))
+}
+
+func (fs *Pack) Readlink(link string) (string, error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	n, ok := fs.nodes[clean(link)]
+	if !ok {
+		return "", os.ErrNotExist
+	}
+
+	if n.mode&os.ModeSymlink == 0 {
+		return "", &os.PathError{Op: "readlink", Path: link, Err: fmt.Errorf("not a symlink")}
+	}
+
+	target, err := fs.read(n)
+	return string(target), err
+}
+
+func (fs *Pack) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(fs, dir, prefix)
+}
+
+func (fs *Pack) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (fs *Pack) Chroot(p string) (billy.Filesystem, error) {
+	return chroot.New(fs, fs.Join(fs.Root(), p)), nil
+}
+
+func (fs *Pack) Root() string {
+	return string(filepath.Separator)
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Pack) Capabilities() billy.Capability {
+	return billy.WriteCapability |
+		billy.ReadCapability |
+		billy.ReadAndWriteCapability |
+		billy.SeekCapability |
+		billy.TruncateCapability
+}
+
+// Stats returns the space usage of the filesystem.
+func (fs *Pack) Stats() (Stats, error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	var s Stats
+	for _, id := range fs.segments {
+		fi, err := fs.storage.Stat(segmentName(id))
+		if err != nil {
+			return s, err
+		}
+
+		s.Segments++
+		s.SegmentsSize += fi.Size()
+	}
+
+	for _, n := range fs.nodes {
+		if n.object != "" {
+			s.Objects++
+		} else if n.segment != -1 {
+			s.LiveSize += n.size
+		}
+	}
+
+	return s, nil
+}
+
+// Compact rewrites the live content into new segments, removing the old
+// segments and any object not referenced anymore. A crash during the
+// compaction is safe, since the new segments hold the whole state and are
+// replayed after the old ones.
+func (fs *Pack) Compact() error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	if err := fs.seal(); err != nil {
+		return err
+	}
+
+	old := fs.segments
+	paths := make([]string, 0, len(fs.nodes))
+	for p := range fs.nodes {
+		paths = append(paths, p)
+	}
+
+	sort.Strings(paths)
+
+	objects := make(map[string]bool)
+	for _, p := range paths {
+		n := fs.nodes[p]
+		r := &record{op: opPut, mode: n.mode, modTime: n.modTime, path: p, size: n.size}
+		switch {
+		case p == "/":
+			continue
+		case n.mode.IsDir():
+			r.op = opMkdir
+		case n.object != "":
+			r.store, r.object = storeObject, n.object
+			objects[n.object] = true
+		default:
+			content, err := fs.read(n)
+			if err != nil {
+				return err
+			}
+
+			r.content = content
+		}
+
+		if err := fs.commit(r); err != nil {
+			return err
+		}
+	}
+
+	if err := fs.seal(); err != nil {
+		return err
+	}
+
+	for _, id := range old {
+		if f, ok := fs.readers[id]; ok {
+			f.Close()
+			delete(fs.readers, id)
+		}
+
+		if err := fs.storage.Remove(segmentName(id)); err != nil {
+			return err
+		}
+	}
+
+	fs.segments = fs.segments[len(old):]
+	return fs.removeOrphans(objects)
+}
+
+func (fs *Pack) removeOrphans(live map[string]bool) error {
+	entries, err := fs.storage.ReadDir(objectsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, e := range entries {
+		name := path.Join(objectsDir, e.Name())
+		if live[name] {
+			continue
+		}
+
+		if err := fs.storage.Remove(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close closes the active segment and all the open segment readers.
+func (fs *Pack) Close() error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	err := fs.seal()
+	for id, f := range fs.readers {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+
+		delete(fs.readers, id)
+	}
+
+	return err
+}
+
+func segmentName(id int) string {
+	return path.Join(segmentsDir, fmt.Sprintf("%08d%s", id, segmentExt))
+}
+
+func clean(p string) string {
+	return path.Clean("/" + filepath.ToSlash(p))
+}
+
+// relative returns the given filename relative to the root.
+func relative(filename string) string {
+	return strings.TrimPrefix(filepath.Join(string(filepath.Separator), filename), string(filepath.Separator))
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR) != 0
+}
+
+type fileInfo struct {
+	name string
+	node *node
+}
+
+func newFileInfo(name string, n *node) os.FileInfo {
+	return &fileInfo{name: name, node: n}
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.node.size
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	return fi.node.mode
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.node.modTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.node.mode.IsDir()
+}
+
+func (fi *fileInfo) Sys() interface{} {
+	return nil
+}
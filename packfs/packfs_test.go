@@ -0,0 +1,157 @@
+package packfs
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type PackSuite struct {
+	test.FilesystemSuite
+	storage billy.Filesystem
+}
+
+var _ = Suite(&PackSuite{})
+
+func (s *PackSuite) SetUpTest(c *C) {
+	s.storage = memfs.New()
+	fs, err := New(s.storage, Options{Threshold: 16, SegmentSize: 1024})
+	c.Assert(err, IsNil)
+
+	s.FilesystemSuite = test.NewFilesystemSuite(fs)
+}
+
+func (s *PackSuite) reopen(c *C) *Pack {
+	c.Assert(s.FS.(*Pack).Close(), IsNil)
+
+	fs, err := New(s.storage, Options{Threshold: 16, SegmentSize: 1024})
+	c.Assert(err, IsNil)
+	return fs
+}
+
+func (s *PackSuite) TestCapabilities(c *C) {
+	caps := billy.Capabilities(s.FS)
+	c.Assert(caps, Equals, billy.DefaultCapabilities&^billy.LockCapability)
+}
+
+func (s *PackSuite) TestPersistence(c *C) {
+	big := bytes.Repeat([]byte("x"), 100)
+	c.Assert(util.WriteFile(s.FS, "foo/small", []byte("small"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "foo/big", big, 0600), IsNil)
+	c.Assert(s.FS.MkdirAll("empty/dir", 0755), IsNil)
+	c.Assert(s.FS.Symlink("foo/small", "link"), IsNil)
+	c.Assert(s.FS.Rename("foo", "bar"), IsNil)
+	c.Assert(util.WriteFile(s.FS, "removed", []byte("foo"), 0644), IsNil)
+	c.Assert(s.FS.Remove("removed"), IsNil)
+
+	fs := s.reopen(c)
+	c.Assert(test.ReadFile(c, fs, "bar/small"), Equals, "small")
+	c.Assert(test.ReadFile(c, fs, "bar/big"), Equals, string(big))
+
+	fi, err := fs.Stat("bar/big")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode(), Equals, os.FileMode(0600))
+
+	fi, err = fs.Stat("empty/dir")
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+
+	target, err := fs.Readlink("link")
+	c.Assert(err, IsNil)
+	c.Assert(target, Equals, "foo/small")
+
+	_, err = fs.Stat("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+	_, err = fs.Stat("removed")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *PackSuite) TestObjects(c *C) {
+	c.Assert(util.WriteFile(s.FS, "small", []byte("small"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "big", bytes.Repeat([]byte("x"), 100), 0644), IsNil)
+
+	objects, err := s.storage.ReadDir(objectsDir)
+	c.Assert(err, IsNil)
+	c.Assert(objects, HasLen, 1)
+
+	c.Assert(s.FS.Remove("big"), IsNil)
+	objects, err = s.storage.ReadDir(objectsDir)
+	c.Assert(err, IsNil)
+	c.Assert(objects, HasLen, 0)
+}
+
+func (s *PackSuite) TestSegmentRoll(c *C) {
+	for i := 0; i < 100; i++ {
+		c.Assert(util.WriteFile(s.FS, "foo", []byte("0123456789"), 0644), IsNil)
+	}
+
+	segments, err := s.storage.ReadDir(segmentsDir)
+	c.Assert(err, IsNil)
+	c.Assert(len(segments) > 1, Equals, true)
+}
+
+func (s *PackSuite) TestCompact(c *C) {
+	fs := s.FS.(*Pack)
+	for i := 0; i < 100; i++ {
+		c.Assert(util.WriteFile(fs, "foo", []byte("0123456789"), 0644), IsNil)
+	}
+
+	c.Assert(util.WriteFile(fs, "bar/qux", []byte("qux"), 0644), IsNil)
+	c.Assert(util.WriteFile(fs, "big", bytes.Repeat([]byte("x"), 100), 0644), IsNil)
+	c.Assert(util.WriteFile(s.storage, "objects/ffffffffffff0000", []byte("orphan"), 0644), IsNil)
+
+	before, err := fs.Stats()
+	c.Assert(err, IsNil)
+	c.Assert(fs.Compact(), IsNil)
+
+	after, err := fs.Stats()
+	c.Assert(err, IsNil)
+	c.Assert(after.Segments, Equals, 1)
+	c.Assert(after.Objects, Equals, 1)
+	c.Assert(after.LiveSize, Equals, before.LiveSize)
+	c.Assert(after.SegmentsSize < before.SegmentsSize, Equals, true)
+
+	objects, err := s.storage.ReadDir(objectsDir)
+	c.Assert(err, IsNil)
+	c.Assert(objects, HasLen, 1)
+
+	c.Assert(test.ReadFile(c, fs, "foo"), Equals, "0123456789")
+	c.Assert(util.WriteFile(fs, "foo", []byte("foo"), 0644), IsNil)
+
+	fs = s.reopen(c)
+	c.Assert(test.ReadFile(c, fs, "foo"), Equals, "foo")
+	c.Assert(test.ReadFile(c, fs, "bar/qux"), Equals, "qux")
+	c.Assert(test.ReadFile(c, fs, "big"), Equals, string(bytes.Repeat([]byte("x"), 100)))
+}
+
+func (s *PackSuite) TestTruncatedRecord(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "bar", []byte("bar"), 0644), IsNil)
+	c.Assert(s.FS.(*Pack).Close(), IsNil)
+
+	name := segmentName(1)
+	fi, err := s.storage.Stat(name)
+	c.Assert(err, IsNil)
+
+	f, err := s.storage.OpenFile(name, os.O_RDWR, 0)
+	c.Assert(err, IsNil)
+	c.Assert(f.Truncate(fi.Size()-2), IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	fs, err := New(s.storage, Options{})
+	c.Assert(err, IsNil)
+	c.Assert(test.ReadFile(c, fs, "foo"), Equals, "foo")
+
+	fi, err = fs.Stat("bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Size(), Equals, int64(0))
+}
@@ -0,0 +1,168 @@
+package packfs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+type opcode uint8
+
+const (
+	opPut opcode = iota + 1
+	opDelete
+	opMkdir
+	opRename
+)
+
+const (
+	storeInline uint8 = iota
+	storeObject
+)
+
+var errCorrupted = errors.New("corrupted record")
+
+// record is the unit stored in the segments, every mutation of the
+// filesystem is appended as a record to the active segment.
+//
+// The on disk layout is:
+//
+//	uint32 crc32 of the rest of the record
+//	uint32 length of the rest of the record
+//	uint8  opcode
+//	uint32 mode
+//	int64  modification time in nanoseconds
+//	uint16 path length, and path
+//	uint16 target length, and target, the new path of a rename
+//	uint8  store kind (inline or object)
+//	uint16 object length, and object name
+//	uint64 size, followed by the content when stored inline
+type record struct {
+	op      opcode
+	mode    os.FileMode
+	modTime time.Time
+	path    string
+	target  string
+	store   uint8
+	object  string
+	size    int64
+	content []byte
+}
+
+const recordHeaderSize = 8
+
+func (r *record) encode() []byte {
+	n := 1 + 4 + 8 + 2 + len(r.path) + 2 + len(r.target) + 1 + 2 + len(r.object) + 8
+	if r.store == storeInline {
+		n += len(r.content)
+	}
+
+	buf := make([]byte, recordHeaderSize+n)
+	b := buf[recordHeaderSize:]
+	b[0] = byte(r.op)
+	binary.BigEndian.PutUint32(b[1:], uint32(r.mode))
+	binary.BigEndian.PutUint64(b[5:], uint64(r.modTime.UnixNano()))
+	b = b[13:]
+	b = putString(b, r.path)
+	b = putString(b, r.target)
+	b[0] = r.store
+	b = putString(b[1:], r.object)
+	binary.BigEndian.PutUint64(b, uint64(r.size))
+	if r.store == storeInline {
+		copy(b[8:], r.content)
+	}
+
+	binary.BigEndian.PutUint32(buf[4:], uint32(n))
+	binary.BigEndian.PutUint32(buf, crc32.ChecksumIEEE(buf[4:]))
+	return buf
+}
+
+// contentOffset returns the offset of the inline content, relative to the
+// beginning of the encoded record.
+func (r *record) contentOffset() int64 {
+	return int64(recordHeaderSize + 1 + 4 + 8 + 2 + len(r.path) + 2 +
+		len(r.target) + 1 + 2 + len(r.object) + 8)
+}
+
+func putString(b []byte, s string) []byte {
+	binary.BigEndian.PutUint16(b, uint16(len(s)))
+	copy(b[2:], s)
+	return b[2+len(s):]
+}
+
+// recordReader reads the records of a segment sequentially. The content of
+// the inline records is skipped, only its offset is returned.
+type recordReader struct {
+	r      *bufio.Reader
+	offset int64
+}
+
+func newRecordReader(r io.Reader) *recordReader {
+	return &recordReader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next record and its offset in the segment. It returns
+// io.EOF at the end of the segment, and errCorrupted when a record is
+// truncated or its checksum doesn't match, usually caused by a crash while
+// it was being written.
+func (rr *recordReader) Next() (*record, int64, error) {
+	offset := rr.offset
+
+	header := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(rr.r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errCorrupted
+		}
+
+		return nil, offset, err
+	}
+
+	body := make([]byte, binary.BigEndian.Uint32(header[4:]))
+	if _, err := io.ReadFull(rr.r, body); err != nil {
+		return nil, offset, errCorrupted
+	}
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(body)
+	if crc.Sum32() != binary.BigEndian.Uint32(header) {
+		return nil, offset, errCorrupted
+	}
+
+	rr.offset += int64(len(header) + len(body))
+
+	r, err := decodeRecord(body)
+	return r, offset, err
+}
+
+func decodeRecord(b []byte) (r *record, err error) {
+	defer func() {
+		// any out of range access means the record is malformed.
+		if recover() != nil {
+			r, err = nil, errCorrupted
+		}
+	}()
+
+	r = &record{
+		op:      opcode(b[0]),
+		mode:    os.FileMode(binary.BigEndian.Uint32(b[1:])),
+		modTime: time.Unix(0, int64(binary.BigEndian.Uint64(b[5:]))),
+	}
+
+	b = b[13:]
+	r.path, b = getString(b)
+	r.target, b = getString(b)
+	r.store = b[0]
+	r.object, b = getString(b[1:])
+	r.size = int64(binary.BigEndian.Uint64(b))
+	return r, nil
+}
+
+func getString(b []byte) (string, []byte) {
+	l := int(binary.BigEndian.Uint16(b))
+	return string(b[2 : 2+l]), b[2+l:]
+}
@@ -0,0 +1,281 @@
+package pgfs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// maxRead is the maximum size read from a large object in a statement.
+const maxRead = 1 << 20
+
+// file is a file of a PostgreSQL filesystem. Its content is read and
+// written in place from its large object. The files opened for writing
+// work on a copy of the large object, replacing the original when the file
+// is closed if modified.
+type file struct {
+	fs   *Postgres
+	name string
+	flag int
+	node *node
+
+	// object is the large object read, or its copy if opened for writing.
+	object   int64
+	copied   bool
+	size     int64
+	modified bool
+
+	position int64
+	isClosed bool
+}
+
+func newFile(fs *Postgres, name string, flag int, n *node) *file {
+	f := &file{fs: fs, name: name, flag: flag, node: n, object: n.object, size: n.size}
+	if isWrite(flag) && flag&os.O_TRUNC != 0 && n.size != 0 {
+		f.object, f.size, f.modified = 0, 0, true
+	}
+
+	return f
+}
+
+func (f *file) Name() string {
+	return f.name
+}
+
+// copy copies the large object, or creates an empty one, to be modified.
+func (f *file) copy() error {
+	if f.copied {
+		return nil
+	}
+
+	query, args := f.fs.q.loCopy, []interface{}{f.object}
+	if f.object == 0 {
+		query, args = f.fs.q.loCreate, nil
+	}
+
+	if err := f.fs.db.QueryRow(query, args...).Scan(&f.object); err != nil {
+		return &os.PathError{Op: "write", Path: f.name, Err: err}
+	}
+
+	f.copied = true
+	return nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.position)
+	f.position += int64(n)
+
+	if err == io.EOF && n != 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	if f.flag&os.O_WRONLY != 0 {
+		return 0, errors.New("read not supported")
+	}
+
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: f.name, Err: errors.New("negative offset")}
+	}
+
+	var n int
+	for n < len(p) && off < f.size {
+		size := int64(len(p) - n)
+		if size > f.size-off {
+			size = f.size - off
+		}
+
+		if size > maxRead {
+			size = maxRead
+		}
+
+		var data []byte
+		if err := f.fs.db.QueryRow(f.fs.q.loGet, f.object, off, size).Scan(&data); err != nil {
+			return n, &os.PathError{Op: "read", Path: f.name, Err: err}
+		}
+
+		if len(data) == 0 {
+			// truncated by other client, since it wasn't copied
+			break
+		}
+
+		c := copy(p[n:], data)
+		n += c
+		off += int64(c)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.position
+	case io.SeekEnd:
+		offset += f.size
+	}
+
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: errors.New("negative offset")}
+	}
+
+	f.position = offset
+	return f.position, nil
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	if !isWrite(f.flag) {
+		return 0, errors.New("write not supported")
+	}
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if err := f.copy(); err != nil {
+		return 0, err
+	}
+
+	if f.flag&os.O_APPEND != 0 {
+		f.position = f.size
+	}
+
+	if _, err := f.fs.db.Exec(f.fs.q.loPut, f.object, f.position, p); err != nil {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: err}
+	}
+
+	f.position += int64(len(p))
+	f.modified = true
+	if f.position > f.size {
+		f.size = f.position
+	}
+
+	return len(p), nil
+}
+
+func (f *file) Truncate(size int64) error {
+	if f.isClosed {
+		return os.ErrClosed
+	}
+
+	if !isWrite(f.flag) {
+		return errors.New("truncate not supported")
+	}
+
+	if size < 0 {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: errors.New("negative size")}
+	}
+
+	if err := f.copy(); err != nil {
+		return err
+	}
+
+	if _, err := f.fs.db.Exec(f.fs.q.loTruncate, f.object, size); err != nil {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: err}
+	}
+
+	f.size, f.modified = size, true
+	return nil
+}
+
+// Close replaces the content of the file, if it was modified.
+func (f *file) Close() error {
+	if f.isClosed {
+		return os.ErrClosed
+	}
+
+	f.isClosed = true
+	if !f.modified && !f.copied {
+		return nil
+	}
+
+	var err error
+	if f.modified {
+		err = f.fs.commit(f.node.id, f.object, f.size)
+	}
+
+	// the copy is discarded if unmodified, or not committed
+	if f.copied && (!f.modified || err != nil) {
+		if _, uerr := f.fs.db.Exec(f.fs.q.loUnlink, f.object); err == nil {
+			err = uerr
+		}
+	}
+
+	if err != nil {
+		return &os.PathError{Op: "close", Path: f.name, Err: err}
+	}
+
+	return nil
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	fi := newFileInfo(filepath.Base(f.name), f.node)
+	fi.size = f.size
+	return fi, nil
+}
+
+// Lock is a no-op in pgfs.
+func (f *file) Lock() error {
+	return nil
+}
+
+// Unlock is a no-op in pgfs.
+func (f *file) Unlock() error {
+	return nil
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func newFileInfo(name string, n *node) *fileInfo {
+	return &fileInfo{name: name, size: n.size, mode: n.mode, modTime: n.modTime}
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.size
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	return fi.mode
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.mode.IsDir()
+}
+
+func (*fileInfo) Sys() interface{} {
+	return nil
+}
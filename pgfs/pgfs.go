@@ -0,0 +1,504 @@
+// Package pgfs provides a billy filesystem stored in a PostgreSQL database,
+// for the applications keeping their state in a relational database, so the
+// files are backed up, replicated and secured with the rest of it.
+//
+// Every file, directory and symlink is a row of a table, holding its path,
+// the path of its directory, its metadata, and the target of the symlinks.
+// The content of the files is stored in large objects, read and written in
+// place, so the files are streamed. A file written is a copy of its large
+// object, replacing it when the file is closed, so the readers never see a
+// partial file. The renames and removals are transactions.
+//
+// The statements are run with database/sql, so any PostgreSQL driver can be
+// used, eg.: github.com/lib/pq or the stdlib package of github.com/jackc/pgx.
+package pgfs // import "gopkg.in/src-d/go-billy.v4/pgfs"
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/internal/linkutil"
+	"gopkg.in/src-d/go-billy.v4/internal/pathutil"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const defaultTable = "billy_files"
+
+var (
+	// ErrNotEmpty is returned when removing a directory with files.
+	ErrNotEmpty = billy.ErrNotEmpty
+
+	errNotDir = errors.New("not a directory")
+
+	validTable = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+)
+
+// Options holds the configuration of a PostgreSQL filesystem.
+type Options struct {
+	// Table is the name of the table of the filesystem, "billy_files" by
+	// default. Several filesystems can be stored in the same database using
+	// different tables. It's created, if it doesn't exist, by New.
+	Table string
+}
+
+// Postgres is a filesystem stored in a PostgreSQL database.
+type Postgres struct {
+	db   *sql.DB
+	opts Options
+	q    *queries
+}
+
+// New returns a new filesystem stored in the given database, creating its
+// table if it doesn't exist.
+func New(db *sql.DB, opts Options) (*Postgres, error) {
+	if opts.Table == "" {
+		opts.Table = defaultTable
+	}
+
+	if !validTable.MatchString(opts.Table) {
+		return nil, fmt.Errorf("invalid table name: %q", opts.Table)
+	}
+
+	fs := &Postgres{db: db, opts: opts, q: newQueries(opts.Table)}
+	for _, query := range []string{fs.q.createTable, fs.q.createIndex} {
+		if _, err := db.Exec(query); err != nil {
+			return nil, err
+		}
+	}
+
+	return fs, nil
+}
+
+// transaction runs the given function in a transaction, committed if it
+// succeeds.
+func (fs *Postgres) transaction(fn func(tx *sql.Tx) error) error {
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (fs *Postgres) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Postgres) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file. The new files are created right away, so
+// they can be found by other clients. The files opened for writing work on
+// a copy of their content, that replaces it when closed if modified.
+func (fs *Postgres) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	p, n, err := fs.follow(fs.db, clean(filename))
+	switch {
+	case os.IsNotExist(err) && flag&os.O_CREATE != 0:
+		// p is the target of the last link, even when it's dangling, so
+		// the target is created.
+		if n, err = fs.create(p, perm.Perm(), ""); err != nil {
+			return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+		}
+	case err != nil:
+		return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+	case flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrExist}
+	case n.mode.IsDir():
+		return nil, &os.PathError{Op: "open", Path: filename, Err: errors.New("is a directory")}
+	}
+
+	f := newFile(fs, pathutil.Relative(filename), flag, n)
+	if isWrite(flag) && f.object != 0 {
+		// the content is kept if the file is removed while open
+		if err := f.copy(); err != nil {
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+// create inserts a new file or symlink, creating its parents. It fails with
+// os.ErrExist if it already exists, even if created by other client in the
+// meantime.
+func (fs *Postgres) create(p string, mode os.FileMode, target string) (*node, error) {
+	dir := path.Dir(p)
+	if err := fs.mkdirAll(fs.db, dir, 0755); err != nil {
+		return nil, err
+	}
+
+	n := &node{mode: mode, modTime: time.Now(), target: target}
+	var nullTarget interface{}
+	if mode&os.ModeSymlink != 0 {
+		nullTarget = target
+	}
+
+	err := fs.db.QueryRow(fs.q.insert, p, dir, int64(mode), n.modTime, nullTarget).Scan(&n.id)
+	if err == sql.ErrNoRows {
+		return nil, os.ErrExist
+	}
+
+	return n, err
+}
+
+// get returns the node of the given path, or nil if it doesn't exist.
+func (fs *Postgres) get(q querier, query, p string) (*node, error) {
+	if p == "/" {
+		return &node{mode: os.ModeDir | 0755}, nil
+	}
+
+	n, err := scanNode(q.QueryRow(query, p))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return n, err
+}
+
+// lookup returns the node in the row of the given path, failing with
+// os.ErrNotExist if there is no such row.
+func (fs *Postgres) lookup(q querier, p string) (*node, error) {
+	n, err := fs.get(q, fs.q.stat, p)
+	if err == nil && n == nil {
+		err = os.ErrNotExist
+	}
+
+	return n, err
+}
+
+// follow returns the node of the given path, following the links with a
+// query for each one, run with q to see the rows of a transaction. The path
+// of the last target is returned even if it doesn't exist.
+func (fs *Postgres) follow(q querier, p string) (string, *node, error) {
+	var n *node
+	isLink := func(p string) (link bool, err error) {
+		n, err = fs.lookup(q, p)
+		return err == nil && n.mode&os.ModeSymlink != 0, err
+	}
+
+	p, err := linkutil.Follow(p, clean, isLink, func(string) (string, error) {
+		return n.target, nil
+	})
+	if err != nil {
+		return p, nil, err
+	}
+
+	return p, n, nil
+}
+
+func (fs *Postgres) Stat(filename string) (os.FileInfo, error) {
+	_, n, err := fs.follow(fs.db, clean(filename))
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: filename, Err: err}
+	}
+
+	// the name is the one of the stated file, even if it's a link.
+	return newFileInfo(filepath.Base(filename), n), nil
+}
+
+func (fs *Postgres) Lstat(filename string) (os.FileInfo, error) {
+	n, err := fs.lookup(fs.db, clean(filename))
+	if err != nil {
+		return nil, &os.PathError{Op: "lstat", Path: filename, Err: err}
+	}
+
+	return newFileInfo(filepath.Base(filename), n), nil
+}
+
+func (fs *Postgres) ReadDir(filename string) ([]os.FileInfo, error) {
+	infos, err := fs.readDir(clean(filename))
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: filename, Err: err}
+	}
+
+	return infos, nil
+}
+
+// readDir returns the children of the given directory, sorted by name.
+func (fs *Postgres) readDir(p string) ([]os.FileInfo, error) {
+	p, n, err := fs.follow(fs.db, p)
+	if err != nil {
+		return nil, err
+	}
+
+	if !n.mode.IsDir() {
+		return nil, errNotDir
+	}
+
+	rows, err := fs.db.Query(fs.q.readDir, p)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var infos []os.FileInfo
+	for rows.Next() {
+		var child string
+		n, err := scanNode(rows, &child)
+		if err != nil {
+			return nil, err
+		}
+
+		infos = append(infos, newFileInfo(path.Base(child), n))
+	}
+
+	return infos, rows.Err()
+}
+
+func (fs *Postgres) MkdirAll(filename string, perm os.FileMode) error {
+	if err := fs.mkdirAll(fs.db, clean(filename), perm); err != nil {
+		return &os.PathError{Op: "mkdir", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Postgres) mkdirAll(q querier, p string, perm os.FileMode) error {
+	dir := "/"
+	for _, name := range strings.Split(strings.Trim(p, "/"), "/") {
+		if name == "" {
+			continue
+		}
+
+		parent := dir
+		dir = path.Join(dir, name)
+
+		n, err := fs.get(q, fs.q.stat, dir)
+		if err != nil {
+			return err
+		}
+
+		if n != nil {
+			if !n.mode.IsDir() {
+				return errNotDir
+			}
+
+			continue
+		}
+
+		// a directory created in the meantime by other client is kept
+		err = q.QueryRow(fs.q.insert, dir, parent, int64(os.ModeDir|perm.Perm()), time.Now(), nil).Scan(new(int64))
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (fs *Postgres) Remove(filename string) error {
+	if err := fs.remove(clean(filename)); err != nil {
+		return &os.PathError{Op: "remove", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Postgres) remove(p string) error {
+	if p == "/" {
+		return errors.New("cannot remove the root")
+	}
+
+	return fs.transaction(func(tx *sql.Tx) error {
+		n, err := fs.get(tx, fs.q.lock, p)
+		if err == nil && n == nil {
+			err = os.ErrNotExist
+		}
+
+		if err != nil {
+			return err
+		}
+
+		return fs.delete(tx, p, n)
+	})
+}
+
+// delete deletes the given node, and its large object. The directories must
+// be empty.
+func (fs *Postgres) delete(tx *sql.Tx, p string, n *node) error {
+	if n.mode.IsDir() {
+		var children bool
+		if err := tx.QueryRow(fs.q.hasChildren, p).Scan(&children); err != nil {
+			return err
+		}
+
+		if children {
+			return ErrNotEmpty
+		}
+	}
+
+	if _, err := tx.Exec(fs.q.delete, n.id); err != nil {
+		return err
+	}
+
+	if n.object == 0 {
+		return nil
+	}
+
+	_, err := tx.Exec(fs.q.loUnlink, n.object)
+	return err
+}
+
+// Rename moves the given file, or directory with all its content, in a
+// single transaction.
+func (fs *Postgres) Rename(from, to string) error {
+	if err := fs.rename(clean(from), clean(to)); err != nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Postgres) rename(from, to string) error {
+	if from == "/" || to == "/" {
+		return errors.New("cannot rename the root")
+	}
+
+	return fs.transaction(func(tx *sql.Tx) error {
+		src, err := fs.get(tx, fs.q.lock, from)
+		if err == nil && src == nil {
+			err = os.ErrNotExist
+		}
+
+		if err != nil || from == to {
+			return err
+		}
+
+		if strings.HasPrefix(to, from+"/") {
+			return errors.New("cannot move a directory into itself")
+		}
+
+		dst, err := fs.get(tx, fs.q.lock, to)
+		if err != nil {
+			return err
+		}
+
+		if dst != nil {
+			if dst.mode.IsDir() != src.mode.IsDir() {
+				return os.ErrExist
+			}
+
+			if err := fs.delete(tx, to, dst); err != nil {
+				return err
+			}
+		}
+
+		dir := path.Dir(to)
+		if err := fs.mkdirAll(tx, dir, 0755); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(fs.q.move, from, to, dir)
+		return err
+	})
+}
+
+// commit replaces the large object of the given file, unlinking the
+// previous one. If the file was removed while open, the new content is
+// discarded.
+func (fs *Postgres) commit(id, object, size int64) error {
+	return fs.transaction(func(tx *sql.Tx) error {
+		var previous sql.NullInt64
+		err := tx.QueryRow(fs.q.lockID, id).Scan(&previous)
+		switch {
+		case err == sql.ErrNoRows:
+			if object == 0 {
+				return nil
+			}
+
+			_, err = tx.Exec(fs.q.loUnlink, object)
+			return err
+		case err != nil:
+			return err
+		}
+
+		if _, err := tx.Exec(fs.q.setContent, id, nullObject(object), size, time.Now()); err != nil {
+			return err
+		}
+
+		if !previous.Valid || previous.Int64 == object {
+			return nil
+		}
+
+		_, err = tx.Exec(fs.q.loUnlink, previous.Int64)
+		return err
+	})
+}
+
+func (fs *Postgres) Symlink(target, link string) error {
+	p := clean(link)
+	n, err := fs.get(fs.db, fs.q.stat, p)
+	if err == nil && n != nil {
+		err = os.ErrExist
+	}
+
+	if err == nil {
+		_, err = fs.create(p, os.ModeSymlink|0777, target)
+	}
+
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Postgres) Readlink(link string) (string, error) {
+	n, err := fs.lookup(fs.db, clean(link))
+	if err == nil && n.mode&os.ModeSymlink == 0 {
+		err = errors.New("not a symlink")
+	}
+
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: link, Err: err}
+	}
+
+	return n.target, nil
+}
+
+func (fs *Postgres) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(fs, dir, prefix)
+}
+
+func (fs *Postgres) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (fs *Postgres) Chroot(p string) (billy.Filesystem, error) {
+	return chroot.New(fs, fs.Join(fs.Root(), p)), nil
+}
+
+func (fs *Postgres) Root() string {
+	return string(filepath.Separator)
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Postgres) Capabilities() billy.Capability {
+	return billy.WriteCapability | billy.ReadCapability |
+		billy.ReadAndWriteCapability | billy.SeekCapability |
+		billy.TruncateCapability
+}
+
+func clean(p string) string {
+	return path.Clean("/" + filepath.ToSlash(p))
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR) != 0
+}
@@ -0,0 +1,193 @@
+package pgfs
+
+import (
+	"database/sql"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&PostgresSuite{})
+
+type PostgresSuite struct {
+	test.FilesystemSuite
+	server *server
+	db     *sql.DB
+	pg     *Postgres
+}
+
+func (s *PostgresSuite) SetUpTest(c *C) {
+	s.server = newServer()
+	s.db = s.server.open()
+	s.pg = s.newFS(c, Options{})
+	s.FilesystemSuite = test.NewFilesystemSuite(s.pg)
+}
+
+func (s *PostgresSuite) TearDownTest(c *C) {
+	s.db.Close()
+}
+
+func (s *PostgresSuite) newFS(c *C, opts Options) *Postgres {
+	fs, err := New(s.db, opts)
+	c.Assert(err, IsNil)
+	return fs
+}
+
+func (s *PostgresSuite) TestNew(c *C) {
+	_, err := New(s.db, Options{Table: "files; DROP TABLE users"})
+	c.Assert(err, ErrorMatches, "invalid table name.*")
+
+	c.Assert(s.pg.opts.Table, Equals, "billy_files")
+	c.Assert(s.server.count("createTable"), Equals, 1)
+	c.Assert(s.server.count("createIndex"), Equals, 1)
+}
+
+func (s *PostgresSuite) TestShared(c *C) {
+	other := s.server.open()
+	defer other.Close()
+
+	fs, err := New(other, Options{})
+	c.Assert(err, IsNil)
+
+	c.Assert(util.WriteFile(s.pg, "foo/bar", []byte("foo"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, fs, "foo/bar"), Equals, "foo")
+
+	c.Assert(fs.Rename("foo", "qux"), IsNil)
+	c.Assert(test.ReadFile(c, s.pg, "qux/bar"), Equals, "foo")
+}
+
+func (s *PostgresSuite) TestTable(c *C) {
+	other := s.newFS(c, Options{Table: "other_files"})
+
+	c.Assert(util.WriteFile(s.pg, "foo", []byte("foo"), 0644), IsNil)
+
+	_, err := other.Stat("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *PostgresSuite) TestLargeObjects(c *C) {
+	c.Assert(util.WriteFile(s.pg, "foo", nil, 0644), IsNil)
+	c.Assert(s.server.objects(), Equals, 0)
+
+	c.Assert(util.WriteFile(s.pg, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(s.server.objects(), Equals, 1)
+
+	f, err := s.pg.OpenFile("foo", os.O_RDWR, 0)
+	c.Assert(err, IsNil)
+	_, err = f.Seek(0, io.SeekEnd)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("bar"))
+	c.Assert(err, IsNil)
+
+	// the copy isn't visible until closed
+	c.Assert(s.server.objects(), Equals, 2)
+	c.Assert(test.ReadFile(c, s.pg, "foo"), Equals, "foo")
+	c.Assert(f.Close(), IsNil)
+
+	c.Assert(s.server.objects(), Equals, 1)
+	c.Assert(test.ReadFile(c, s.pg, "foo"), Equals, "foobar")
+	c.Assert(s.server.count("loCopy"), Equals, 1)
+
+	c.Assert(s.pg.Remove("foo"), IsNil)
+	c.Assert(s.server.objects(), Equals, 0)
+}
+
+func (s *PostgresSuite) TestStreaming(c *C) {
+	content := make([]byte, 3*maxRead+10)
+	for i := range content {
+		content[i] = byte(i)
+	}
+
+	c.Assert(util.WriteFile(s.pg, "foo", content, 0644), IsNil)
+
+	f, err := s.pg.Open("foo")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	read, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(read, DeepEquals, content)
+	c.Assert(s.server.count("loGet") >= 4, Equals, true)
+}
+
+func (s *PostgresSuite) TestTruncate(c *C) {
+	c.Assert(util.WriteFile(s.pg, "foo", []byte("foobar"), 0644), IsNil)
+
+	f, err := s.pg.OpenFile("foo", os.O_RDWR, 0)
+	c.Assert(err, IsNil)
+	c.Assert(f.Truncate(2), IsNil)
+	c.Assert(f.Truncate(4), IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	c.Assert(test.ReadFile(c, s.pg, "foo"), Equals, "fo\x00\x00")
+
+	f, err = s.pg.OpenFile("foo", os.O_WRONLY|os.O_TRUNC, 0)
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	c.Assert(test.ReadFile(c, s.pg, "foo"), Equals, "")
+	c.Assert(s.server.objects(), Equals, 0)
+}
+
+func (s *PostgresSuite) TestRemovedWhileOpen(c *C) {
+	c.Assert(util.WriteFile(s.pg, "foo", []byte("foo"), 0644), IsNil)
+
+	f, err := s.pg.OpenFile("foo", os.O_RDWR, 0)
+	c.Assert(err, IsNil)
+
+	c.Assert(s.pg.Remove("foo"), IsNil)
+	c.Assert(util.WriteFile(s.pg, "foo", []byte("bar"), 0644), IsNil)
+
+	_, err = f.Write([]byte("qux"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	c.Assert(test.ReadFile(c, s.pg, "foo"), Equals, "bar")
+	c.Assert(s.server.objects(), Equals, 1)
+}
+
+func (s *PostgresSuite) TestRenamedWhileOpen(c *C) {
+	c.Assert(util.WriteFile(s.pg, "foo/bar", []byte("foo"), 0644), IsNil)
+
+	f, err := s.pg.OpenFile("foo/bar", os.O_WRONLY|os.O_TRUNC, 0)
+	c.Assert(err, IsNil)
+
+	c.Assert(s.pg.Rename("foo", "qux"), IsNil)
+
+	_, err = f.Write([]byte("bar"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	c.Assert(test.ReadFile(c, s.pg, "qux/bar"), Equals, "bar")
+}
+
+func (s *PostgresSuite) TestRenameRollback(c *C) {
+	c.Assert(util.WriteFile(s.pg, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.pg, "bar", []byte("bar"), 0644), IsNil)
+
+	// the destination is removed before failing to move the source
+	s.server.fail = "move"
+	err := s.pg.Rename("foo", "bar")
+	c.Assert(err, ErrorMatches, ".*canceling statement.*")
+	c.Assert(s.server.count("delete"), Equals, 1)
+
+	s.server.fail = ""
+	c.Assert(test.ReadFile(c, s.pg, "foo"), Equals, "foo")
+	c.Assert(test.ReadFile(c, s.pg, "bar"), Equals, "bar")
+	c.Assert(s.server.objects(), Equals, 2)
+}
+
+func (s *PostgresSuite) TestRemoveNotEmpty(c *C) {
+	c.Assert(util.WriteFile(s.pg, "foo/bar", []byte("foo"), 0644), IsNil)
+
+	err := s.pg.Remove("foo")
+	c.Assert(err.(*os.PathError).Err, Equals, ErrNotEmpty)
+}
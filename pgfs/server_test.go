@@ -0,0 +1,499 @@
+package pgfs
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	sql.Register("pgfstest", &testDriver{})
+}
+
+var (
+	serversMu sync.Mutex
+	servers   = make(map[string]*server)
+	lastDSN   int
+)
+
+// server is a minimal in-memory PostgreSQL database, to test the
+// filesystem, reached with the driver "pgfstest". It only runs the
+// statements of the filesystem, recognized by their text, and the
+// transactions lock the whole database.
+type server struct {
+	mu       sync.Mutex
+	dsn      string
+	handlers map[string]handler
+
+	// tx is locked while a transaction is running.
+	tx       sync.Mutex
+	state    *state
+	commands []string
+	// fail is the name of a statement failing.
+	fail string
+}
+
+type state struct {
+	tables  map[string]*table
+	objects map[int64][]byte
+	lastOID int64
+}
+
+type table struct {
+	rows   map[int64]row
+	lastID int64
+}
+
+type row struct {
+	id      int64
+	path    string
+	parent  string
+	mode    int64
+	modTime time.Time
+	size    int64
+	object  interface{}
+	target  interface{}
+}
+
+func (s *state) clone() *state {
+	c := &state{tables: make(map[string]*table), objects: make(map[int64][]byte), lastOID: s.lastOID}
+	for name, t := range s.tables {
+		rows := make(map[int64]row, len(t.rows))
+		for id, r := range t.rows {
+			rows[id] = r
+		}
+
+		c.tables[name] = &table{rows: rows, lastID: t.lastID}
+	}
+
+	for oid, data := range s.objects {
+		c.objects[oid] = append([]byte(nil), data...)
+	}
+
+	return c
+}
+
+// handler runs a statement, returning its rows.
+type handler struct {
+	name string
+	fn   func(s *state, args []driver.Value) ([][]driver.Value, error)
+}
+
+func newServer() *server {
+	serversMu.Lock()
+	defer serversMu.Unlock()
+
+	lastDSN++
+	s := &server{
+		dsn:      fmt.Sprint("db", lastDSN),
+		handlers: make(map[string]handler),
+		state:    &state{tables: make(map[string]*table), objects: make(map[int64][]byte)},
+	}
+
+	servers[s.dsn] = s
+	return s
+}
+
+func (s *server) open() *sql.DB {
+	db, err := sql.Open("pgfstest", s.dsn)
+	if err != nil {
+		panic(err)
+	}
+
+	return db
+}
+
+func (s *server) count(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int
+	for _, c := range s.commands {
+		if c == name {
+			n++
+		}
+	}
+
+	return n
+}
+
+// objects returns the number of large objects.
+func (s *server) objects() int {
+	s.tx.Lock()
+	defer s.tx.Unlock()
+
+	return len(s.state.objects)
+}
+
+// register adds the handlers of the statements of the given table.
+func (s *server) register(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q := newQueries(name)
+	if _, ok := s.handlers[q.createTable]; ok {
+		return
+	}
+
+	t := func(st *state) *table {
+		return st.tables[name]
+	}
+
+	lookup := func(st *state, p string) ([][]driver.Value, error) {
+		for _, r := range t(st).rows {
+			if r.path == p {
+				return [][]driver.Value{r.values()}, nil
+			}
+		}
+
+		return nil, nil
+	}
+
+	for query, h := range map[string]handler{
+		q.createTable: {"createTable", func(st *state, args []driver.Value) ([][]driver.Value, error) {
+			if st.tables[name] == nil {
+				st.tables[name] = &table{rows: make(map[int64]row)}
+			}
+
+			return nil, nil
+		}},
+		q.createIndex: {"createIndex", func(st *state, args []driver.Value) ([][]driver.Value, error) {
+			return nil, nil
+		}},
+		q.stat: {"stat", func(st *state, args []driver.Value) ([][]driver.Value, error) {
+			return lookup(st, args[0].(string))
+		}},
+		q.lock: {"lock", func(st *state, args []driver.Value) ([][]driver.Value, error) {
+			return lookup(st, args[0].(string))
+		}},
+		q.lockID: {"lockID", func(st *state, args []driver.Value) ([][]driver.Value, error) {
+			r, ok := t(st).rows[args[0].(int64)]
+			if !ok {
+				return nil, nil
+			}
+
+			return [][]driver.Value{{r.object}}, nil
+		}},
+		q.readDir: {"readDir", func(st *state, args []driver.Value) ([][]driver.Value, error) {
+			var rows []row
+			for _, r := range t(st).rows {
+				if r.parent == args[0].(string) {
+					rows = append(rows, r)
+				}
+			}
+
+			sort.Slice(rows, func(i, j int) bool { return rows[i].path < rows[j].path })
+			values := make([][]driver.Value, len(rows))
+			for i, r := range rows {
+				values[i] = append([]driver.Value{r.path}, r.values()...)
+			}
+
+			return values, nil
+		}},
+		q.hasChildren: {"hasChildren", func(st *state, args []driver.Value) ([][]driver.Value, error) {
+			for _, r := range t(st).rows {
+				if r.parent == args[0].(string) {
+					return [][]driver.Value{{true}}, nil
+				}
+			}
+
+			return [][]driver.Value{{false}}, nil
+		}},
+		q.insert: {"insert", func(st *state, args []driver.Value) ([][]driver.Value, error) {
+			if rows, _ := lookup(st, args[0].(string)); len(rows) != 0 {
+				return nil, nil
+			}
+
+			t(st).lastID++
+			r := row{
+				id:      t(st).lastID,
+				path:    args[0].(string),
+				parent:  args[1].(string),
+				mode:    args[2].(int64),
+				modTime: args[3].(time.Time),
+				target:  args[4],
+			}
+
+			t(st).rows[r.id] = r
+			return [][]driver.Value{{r.id}}, nil
+		}},
+		q.delete: {"delete", func(st *state, args []driver.Value) ([][]driver.Value, error) {
+			delete(t(st).rows, args[0].(int64))
+			return nil, nil
+		}},
+		q.move: {"move", func(st *state, args []driver.Value) ([][]driver.Value, error) {
+			from, to, dir := args[0].(string), args[1].(string), args[2].(string)
+			for id, r := range t(st).rows {
+				switch {
+				case r.path == from:
+					r.parent = dir
+				case strings.HasPrefix(r.path, from+"/"):
+					r.parent = to + r.parent[len(from):]
+				default:
+					continue
+				}
+
+				r.path = to + r.path[len(from):]
+				if rows, _ := lookup(st, r.path); len(rows) != 0 {
+					return nil, errors.New("duplicate key value violates unique constraint")
+				}
+
+				t(st).rows[id] = r
+			}
+
+			return nil, nil
+		}},
+		q.setContent: {"setContent", func(st *state, args []driver.Value) ([][]driver.Value, error) {
+			r, ok := t(st).rows[args[0].(int64)]
+			if ok {
+				r.object, r.size, r.modTime = args[1], args[2].(int64), args[3].(time.Time)
+				t(st).rows[r.id] = r
+			}
+
+			return nil, nil
+		}},
+	} {
+		s.handlers[query] = h
+	}
+
+	object := func(st *state, oid driver.Value) ([]byte, error) {
+		data, ok := st.objects[oid.(int64)]
+		if !ok {
+			return nil, fmt.Errorf("large object %d does not exist", oid)
+		}
+
+		return data, nil
+	}
+
+	for query, h := range map[string]handler{
+		q.loCreate: {"loCreate", func(st *state, args []driver.Value) ([][]driver.Value, error) {
+			st.lastOID++
+			st.objects[st.lastOID] = nil
+			return [][]driver.Value{{st.lastOID}}, nil
+		}},
+		q.loCopy: {"loCopy", func(st *state, args []driver.Value) ([][]driver.Value, error) {
+			data, err := object(st, args[0])
+			if err != nil {
+				return nil, err
+			}
+
+			st.lastOID++
+			st.objects[st.lastOID] = append([]byte(nil), data...)
+			return [][]driver.Value{{st.lastOID}}, nil
+		}},
+		q.loGet: {"loGet", func(st *state, args []driver.Value) ([][]driver.Value, error) {
+			data, err := object(st, args[0])
+			if err != nil {
+				return nil, err
+			}
+
+			off, size := args[1].(int64), args[2].(int64)
+			if off > int64(len(data)) {
+				off = int64(len(data))
+			}
+
+			if off+size > int64(len(data)) {
+				size = int64(len(data)) - off
+			}
+
+			return [][]driver.Value{{append([]byte(nil), data[off:off+size]...)}}, nil
+		}},
+		q.loPut: {"loPut", func(st *state, args []driver.Value) ([][]driver.Value, error) {
+			data, err := object(st, args[0])
+			if err != nil {
+				return nil, err
+			}
+
+			off, p := args[1].(int64), args[2].([]byte)
+			if end := off + int64(len(p)); end > int64(len(data)) {
+				data = append(data, make([]byte, end-int64(len(data)))...)
+			}
+
+			copy(data[off:], p)
+			st.objects[args[0].(int64)] = data
+			return [][]driver.Value{{nil}}, nil
+		}},
+		q.loTruncate: {"loTruncate", func(st *state, args []driver.Value) ([][]driver.Value, error) {
+			data, err := object(st, args[0])
+			if err != nil {
+				return nil, err
+			}
+
+			size := args[1].(int64)
+			if size > int64(len(data)) {
+				data = append(data, make([]byte, size-int64(len(data)))...)
+			}
+
+			st.objects[args[0].(int64)] = data[:size]
+			return [][]driver.Value{{int64(0)}}, nil
+		}},
+		q.loUnlink: {"loUnlink", func(st *state, args []driver.Value) ([][]driver.Value, error) {
+			if _, err := object(st, args[0]); err != nil {
+				return nil, err
+			}
+
+			delete(st.objects, args[0].(int64))
+			return [][]driver.Value{{int64(1)}}, nil
+		}},
+	} {
+		s.handlers[query] = h
+	}
+}
+
+func (r row) values() []driver.Value {
+	return []driver.Value{r.id, r.mode, r.modTime, r.size, r.object, r.target}
+}
+
+// exec runs the given statement, in the given state.
+func (s *server) exec(st *state, query string, args []driver.Value) ([][]driver.Value, error) {
+	s.mu.Lock()
+	h, ok := s.handlers[query]
+	if ok {
+		s.commands = append(s.commands, h.name)
+	}
+
+	fail := s.fail
+	s.mu.Unlock()
+
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("unexpected statement: %s", query)
+	case h.name == fail:
+		return nil, errors.New("canceling statement due to user request")
+	}
+
+	return h.fn(st, args)
+}
+
+type testDriver struct{}
+
+func (*testDriver) Open(dsn string) (driver.Conn, error) {
+	serversMu.Lock()
+	s := servers[dsn]
+	serversMu.Unlock()
+
+	if s == nil {
+		return nil, errors.New("unknown database")
+	}
+
+	return &testConn{server: s}, nil
+}
+
+// testConn is a connection, its statements see the state of its
+// transaction, if any.
+type testConn struct {
+	server *server
+	tx     *state
+}
+
+func (c *testConn) Prepare(query string) (driver.Stmt, error) {
+	if strings.HasPrefix(query, "CREATE TABLE IF NOT EXISTS ") {
+		c.server.register(strings.Fields(query)[5])
+	}
+
+	return &testStmt{conn: c, query: query}, nil
+}
+
+func (c *testConn) Close() error {
+	return nil
+}
+
+func (c *testConn) Begin() (driver.Tx, error) {
+	c.server.tx.Lock()
+	c.tx = c.server.state.clone()
+	return c, nil
+}
+
+func (c *testConn) Commit() error {
+	c.server.state, c.tx = c.tx, nil
+	c.server.tx.Unlock()
+	return nil
+}
+
+func (c *testConn) Rollback() error {
+	c.tx = nil
+	c.server.tx.Unlock()
+	return nil
+}
+
+func (c *testConn) run(query string, args []driver.Value) ([][]driver.Value, error) {
+	if c.tx != nil {
+		return c.server.exec(c.tx, query, args)
+	}
+
+	c.server.tx.Lock()
+	defer c.server.tx.Unlock()
+
+	// a statement outside of a transaction is atomic too
+	st := c.server.state.clone()
+	values, err := c.server.exec(st, query, args)
+	if err == nil {
+		c.server.state = st
+	}
+
+	return values, err
+}
+
+type testStmt struct {
+	conn  *testConn
+	query string
+}
+
+func (s *testStmt) Close() error {
+	return nil
+}
+
+func (s *testStmt) NumInput() int {
+	return -1
+}
+
+func (s *testStmt) Exec(args []driver.Value) (driver.Result, error) {
+	values, err := s.conn.run(s.query, args)
+	return driver.RowsAffected(len(values)), err
+}
+
+func (s *testStmt) Query(args []driver.Value) (driver.Rows, error) {
+	values, err := s.conn.run(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return &testRows{values: values}, nil
+}
+
+type testRows struct {
+	values [][]driver.Value
+}
+
+func (r *testRows) Columns() []string {
+	if len(r.values) == 0 {
+		return nil
+	}
+
+	columns := make([]string, len(r.values[0]))
+	for i := range columns {
+		columns[i] = fmt.Sprint("column", i)
+	}
+
+	return columns
+}
+
+func (r *testRows) Close() error {
+	return nil
+}
+
+func (r *testRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
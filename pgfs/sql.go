@@ -0,0 +1,128 @@
+package pgfs
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+)
+
+// columns are the columns of a node, as scanned by scanNode.
+const columns = "id, mode, mod_time, size, object, target"
+
+// queries are the statements used by a filesystem, for its table.
+type queries struct {
+	createTable string
+	createIndex string
+
+	stat        string
+	lock        string
+	lockID      string
+	readDir     string
+	hasChildren string
+	insert      string
+	delete      string
+	move        string
+	setContent  string
+
+	// the functions of the large objects, see "Server-Side Functions" in
+	// the chapter "Large Objects" of the PostgreSQL documentation.
+	loCreate   string
+	loCopy     string
+	loGet      string
+	loPut      string
+	loTruncate string
+	loUnlink   string
+}
+
+func newQueries(table string) *queries {
+	q := &queries{
+		createTable: `CREATE TABLE IF NOT EXISTS %[1]s (
+	id bigserial PRIMARY KEY,
+	path text NOT NULL UNIQUE,
+	parent text NOT NULL,
+	mode bigint NOT NULL,
+	mod_time timestamptz NOT NULL,
+	size bigint NOT NULL DEFAULT 0,
+	object oid,
+	target text
+)`,
+		createIndex: `CREATE INDEX IF NOT EXISTS %[1]s_parent_idx ON %[1]s (parent, path)`,
+
+		stat:        `SELECT ` + columns + ` FROM %[1]s WHERE path = $1`,
+		lock:        `SELECT ` + columns + ` FROM %[1]s WHERE path = $1 FOR UPDATE`,
+		lockID:      `SELECT object FROM %[1]s WHERE id = $1 FOR UPDATE`,
+		readDir:     `SELECT path, ` + columns + ` FROM %[1]s WHERE parent = $1 ORDER BY path`,
+		hasChildren: `SELECT EXISTS (SELECT 1 FROM %[1]s WHERE parent = $1)`,
+		insert: `INSERT INTO %[1]s (path, parent, mode, mod_time, target) VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (path) DO NOTHING RETURNING id`,
+		delete: `DELETE FROM %[1]s WHERE id = $1`,
+		// move renames the node $1 to $2, in the directory $3, and all its
+		// descendants if it's a directory.
+		move: `UPDATE %[1]s SET
+	path = $2::text || substr(path, length($1::text) + 1),
+	parent = CASE WHEN path = $1::text THEN $3::text ELSE $2::text || substr(parent, length($1::text) + 1) END
+	WHERE path = $1::text OR left(path, length($1::text) + 1) = $1::text || '/'`,
+		setContent: `UPDATE %[1]s SET object = $2, size = $3, mod_time = $4 WHERE id = $1`,
+
+		loCreate:   `SELECT lo_create(0)`,
+		loCopy:     `SELECT lo_from_bytea(0, lo_get($1))`,
+		loGet:      `SELECT lo_get($1, $2, $3)`,
+		loPut:      `SELECT lo_put($1, $2, $3)`,
+		loTruncate: `SELECT lo_truncate64(lo_open($1, 131072), $2)`,
+		loUnlink:   `SELECT lo_unlink($1)`,
+	}
+
+	for _, s := range []*string{
+		&q.createTable, &q.createIndex, &q.stat, &q.lock, &q.lockID,
+		&q.readDir, &q.hasChildren, &q.insert, &q.delete, &q.move,
+		&q.setContent,
+	} {
+		*s = fmt.Sprintf(*s, table)
+	}
+
+	return q
+}
+
+// querier runs statements, either in a transaction or not.
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// node is a row of the table, a file, directory or symlink.
+type node struct {
+	id      int64
+	mode    os.FileMode
+	modTime time.Time
+	size    int64
+	// object is the large object with the content of a file, zero if the
+	// file is empty.
+	object int64
+	target string
+}
+
+// scanNode scans the columns of a node, after the given destinations.
+func scanNode(row interface{ Scan(...interface{}) error }, dest ...interface{}) (*node, error) {
+	var n node
+	var mode int64
+	var object sql.NullInt64
+	var target sql.NullString
+	err := row.Scan(append(dest, &n.id, &mode, &n.modTime, &n.size, &object, &target)...)
+	if err != nil {
+		return nil, err
+	}
+
+	n.mode, n.object, n.target = os.FileMode(mode), object.Int64, target.String
+	return &n, nil
+}
+
+// nullObject returns the given large object as a parameter, NULL if zero.
+func nullObject(object int64) interface{} {
+	if object == 0 {
+		return nil
+	}
+
+	return object
+}
@@ -0,0 +1,194 @@
+package ramfs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// file is an open file, reading and writing directly its content, shared by
+// every file open.
+type file struct {
+	fs      *RAM
+	name    string
+	flag    int
+	mode    os.FileMode
+	content *content
+
+	position int64
+	isClosed bool
+}
+
+func (f *file) Name() string {
+	return f.name
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.position)
+	f.position += int64(n)
+
+	if err == io.EOF && n != 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	if f.flag&os.O_WRONLY != 0 {
+		return 0, errors.New("read not supported")
+	}
+
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: f.name, Err: errors.New("negative offset")}
+	}
+
+	return f.content.readAt(p, off)
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.position
+	case io.SeekEnd:
+		size, _ := f.content.stat()
+		offset += size
+	}
+
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: errors.New("negative offset")}
+	}
+
+	f.position = offset
+	return f.position, nil
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	if !isWrite(f.flag) {
+		return 0, errors.New("write not supported")
+	}
+
+	if f.flag&os.O_APPEND != 0 {
+		f.position, _ = f.content.stat()
+	}
+
+	n, err := f.content.writeAt(p, f.position)
+	if err != nil {
+		return n, &os.PathError{Op: "write", Path: f.name, Err: err}
+	}
+
+	f.position += int64(n)
+	return n, nil
+}
+
+func (f *file) Truncate(size int64) error {
+	if f.isClosed {
+		return os.ErrClosed
+	}
+
+	if !isWrite(f.flag) {
+		return errors.New("truncate not supported")
+	}
+
+	if size < 0 {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: errors.New("negative size")}
+	}
+
+	if err := f.content.truncate(size); err != nil {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: err}
+	}
+
+	return nil
+}
+
+// Close releases the content of the file, if it was removed and this was
+// the last file open.
+func (f *file) Close() error {
+	if f.isClosed {
+		return os.ErrClosed
+	}
+
+	f.isClosed = true
+
+	f.fs.m.Lock()
+	f.content.unref()
+	f.fs.m.Unlock()
+	return nil
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	size, modTime := f.content.stat()
+	return &fileInfo{
+		name:    filepath.Base(f.name),
+		size:    size,
+		mode:    f.mode,
+		modTime: modTime,
+	}, nil
+}
+
+// Lock is a no-op in ramfs.
+func (f *file) Lock() error {
+	return nil
+}
+
+// Unlock is a no-op in ramfs.
+func (f *file) Unlock() error {
+	return nil
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func newFileInfo(name string, n *node) *fileInfo {
+	fi := &fileInfo{name: name, mode: n.mode, modTime: n.modTime}
+	switch {
+	case n.content != nil:
+		fi.size, fi.modTime = n.content.stat()
+	case isSymlink(n.mode):
+		fi.size = int64(len(n.target))
+	}
+
+	return fi
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.size
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	return fi.mode
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.mode.IsDir()
+}
+
+func (*fileInfo) Sys() interface{} {
+	return nil
+}
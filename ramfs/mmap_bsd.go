@@ -0,0 +1,25 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package ramfs
+
+import "golang.org/x/sys/unix"
+
+func mmap(size int) ([]byte, error) {
+	return unix.Mmap(-1, 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_ANON|unix.MAP_PRIVATE)
+}
+
+func munmap(b []byte) {
+	unix.Munmap(b)
+}
+
+// discard returns the memory of the given pages to the system, reading as
+// zeros afterwards. The pages freed aren't zeroed until reclaimed, so they
+// are zeroed first.
+func discard(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+
+	unix.Madvise(b, unix.MADV_FREE)
+}
@@ -0,0 +1,20 @@
+//go:build linux
+// +build linux
+
+package ramfs
+
+import "golang.org/x/sys/unix"
+
+func mmap(size int) ([]byte, error) {
+	return unix.Mmap(-1, 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_ANON|unix.MAP_PRIVATE)
+}
+
+func munmap(b []byte) {
+	unix.Munmap(b)
+}
+
+// discard returns the memory of the given pages to the system, reading as
+// zeros afterwards.
+func discard(b []byte) {
+	unix.Madvise(b, unix.MADV_DONTNEED)
+}
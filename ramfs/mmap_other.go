@@ -0,0 +1,18 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package ramfs
+
+// mmap allocates the regions in the heap, where anonymous mappings aren't
+// available.
+func mmap(size int) ([]byte, error) {
+	return make([]byte, size), nil
+}
+
+func munmap(b []byte) {}
+
+func discard(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
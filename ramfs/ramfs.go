@@ -0,0 +1,456 @@
+// Package ramfs provides an in-memory billy filesystem, like memfs, keeping
+// the content of the files in anonymous memory mappings instead of the Go
+// heap. The garbage collector doesn't scan nor account the content, which
+// reduces its pressure on workloads holding many large files in memory.
+//
+// The content of a file is mapped in a region sized as a power of two of
+// pages, moved to a larger one as it grows. The regions of the removed files
+// are returned to the system with madvise, and kept to be reused. On the
+// platforms without anonymous mappings, the content is kept in the heap.
+package ramfs // import "gopkg.in/src-d/go-billy.v4/ramfs"
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/internal/linkutil"
+	"gopkg.in/src-d/go-billy.v4/internal/pathutil"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+var (
+	// ErrNotEmpty is returned when removing a directory with files.
+	ErrNotEmpty = billy.ErrNotEmpty
+
+	errNotDir = errors.New("not a directory")
+)
+
+// RAM is a filesystem keeping the content of the files in memory mappings.
+type RAM struct {
+	a *allocator
+
+	m        sync.Mutex
+	nodes    map[string]*node
+	children map[string]map[string]bool
+}
+
+// node is a file, directory or symlink.
+type node struct {
+	mode    os.FileMode
+	modTime time.Time
+	target  string
+	content *content
+}
+
+// New returns a new empty RAM filesystem. The mappings are released when the
+// files are removed, or when the filesystem and its files are garbage
+// collected.
+func New() *RAM {
+	return &RAM{
+		a:        newAllocator(),
+		nodes:    map[string]*node{"/": {mode: os.ModeDir | 0755, modTime: time.Now()}},
+		children: make(map[string]map[string]bool),
+	}
+}
+
+// set adds the node at p, registering it as a child of its parent, and
+// releasing the content of the node replaced.
+func (fs *RAM) set(p string, n *node) {
+	if old, ok := fs.nodes[p]; ok && old.content != nil {
+		old.content.unref()
+	}
+
+	fs.nodes[p] = n
+	dir := path.Dir(p)
+	if fs.children[dir] == nil {
+		fs.children[dir] = make(map[string]bool)
+	}
+
+	fs.children[dir][path.Base(p)] = true
+}
+
+// delete removes the node at p, without releasing its content.
+func (fs *RAM) delete(p string) {
+	delete(fs.nodes, p)
+	delete(fs.children, p)
+
+	dir := path.Dir(p)
+	delete(fs.children[dir], path.Base(p))
+}
+
+func (fs *RAM) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *RAM) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (fs *RAM) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	f, err := fs.openFile(filename, flag, perm)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+	}
+
+	return f, nil
+}
+
+func (fs *RAM) openFile(filename string, flag int, perm os.FileMode) (*file, error) {
+	p, n, err := fs.follow(clean(filename))
+	switch {
+	case os.IsNotExist(err) && flag&os.O_CREATE != 0:
+		// p is the target of the last link, even when it's dangling, so
+		// the target is created.
+		if err := fs.mkdirAll(path.Dir(p), 0755); err != nil {
+			return nil, err
+		}
+
+		n = &node{mode: perm.Perm(), content: newContent(fs.a)}
+		fs.set(p, n)
+	case err != nil:
+		return nil, err
+	case flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, os.ErrExist
+	case n.mode.IsDir():
+		return nil, fmt.Errorf("cannot open directory: %s", filename)
+	case isWrite(flag) && flag&os.O_TRUNC != 0:
+		if err := n.content.truncate(0); err != nil {
+			return nil, err
+		}
+	}
+
+	n.content.ref()
+	f := &file{
+		fs:      fs,
+		name:    pathutil.Relative(filename),
+		flag:    flag,
+		mode:    n.mode,
+		content: n.content,
+	}
+
+	if flag&os.O_APPEND != 0 {
+		f.position, _ = n.content.stat()
+	}
+
+	return f, nil
+}
+
+// lookup returns the node at p, without following the links.
+func (fs *RAM) lookup(p string) (*node, error) {
+	n, ok := fs.nodes[p]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	return n, nil
+}
+
+// follow returns the node at p following the links, and its path. If the
+// node doesn't exist os.ErrNotExist is returned with the path of the target.
+func (fs *RAM) follow(p string) (string, *node, error) {
+	var n *node
+	isLink := func(p string) (link bool, err error) {
+		n, err = fs.lookup(p)
+		return err == nil && isSymlink(n.mode), err
+	}
+
+	p, err := linkutil.Follow(p, clean, isLink, func(string) (string, error) {
+		return n.target, nil
+	})
+	if err != nil {
+		return p, nil, err
+	}
+
+	return p, n, nil
+}
+
+func (fs *RAM) Stat(filename string) (os.FileInfo, error) {
+	return fs.stat("stat", filename, true)
+}
+
+func (fs *RAM) Lstat(filename string) (os.FileInfo, error) {
+	return fs.stat("lstat", filename, false)
+}
+
+func (fs *RAM) stat(op, filename string, follow bool) (os.FileInfo, error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	var n *node
+	var err error
+	if follow {
+		_, n, err = fs.follow(clean(filename))
+	} else {
+		n, err = fs.lookup(clean(filename))
+	}
+
+	if err != nil {
+		return nil, &os.PathError{Op: op, Path: filename, Err: err}
+	}
+
+	// the name is the one of the stated file, even if it's a link.
+	return newFileInfo(filepath.Base(filename), n), nil
+}
+
+func (fs *RAM) ReadDir(filename string) ([]os.FileInfo, error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	p, n, err := fs.follow(clean(filename))
+	if err == nil && !n.mode.IsDir() {
+		err = errNotDir
+	}
+
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: filename, Err: err}
+	}
+
+	names := make([]string, 0, len(fs.children[p]))
+	for name := range fs.children[p] {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	infos := make([]os.FileInfo, 0, len(names))
+	for _, name := range names {
+		infos = append(infos, newFileInfo(name, fs.nodes[path.Join(p, name)]))
+	}
+
+	return infos, nil
+}
+
+func (fs *RAM) MkdirAll(filename string, perm os.FileMode) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	if err := fs.mkdirAll(clean(filename), perm); err != nil {
+		return &os.PathError{Op: "mkdir", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *RAM) mkdirAll(p string, perm os.FileMode) error {
+	dir := "/"
+	for _, elem := range strings.Split(p, "/") {
+		if elem == "" {
+			continue
+		}
+
+		dir = path.Join(dir, elem)
+		_, n, err := fs.follow(dir)
+		if err == nil {
+			if !n.mode.IsDir() {
+				return errNotDir
+			}
+
+			continue
+		}
+
+		if !os.IsNotExist(err) {
+			return err
+		}
+
+		fs.set(dir, &node{mode: os.ModeDir | perm.Perm(), modTime: time.Now()})
+	}
+
+	return nil
+}
+
+// Rename moves the given file, or directory with all its content.
+func (fs *RAM) Rename(from, to string) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	if err := fs.rename(clean(from), clean(to)); err != nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *RAM) rename(from, to string) error {
+	if from == "/" || to == "/" {
+		return errors.New("cannot rename the root")
+	}
+
+	src, err := fs.lookup(from)
+	if err != nil {
+		return err
+	}
+
+	if from == to {
+		return nil
+	}
+
+	if strings.HasPrefix(to, from+"/") {
+		return errors.New("cannot move a directory into itself")
+	}
+
+	if dst, ok := fs.nodes[to]; ok {
+		if dst.mode.IsDir() != src.mode.IsDir() {
+			return os.ErrExist
+		}
+
+		if len(fs.children[to]) != 0 {
+			return ErrNotEmpty
+		}
+	}
+
+	if err := fs.mkdirAll(path.Dir(to), 0755); err != nil {
+		return err
+	}
+
+	moves := [][2]string{{from, to}}
+	for p := range fs.nodes {
+		if strings.HasPrefix(p, from+"/") {
+			moves = append(moves, [2]string{p, to + strings.TrimPrefix(p, from)})
+		}
+	}
+
+	// parents first, so the children are registered on the moved parents.
+	sort.Slice(moves, func(i, j int) bool { return moves[i][0] < moves[j][0] })
+
+	nodes := make([]*node, len(moves))
+	for i, m := range moves {
+		nodes[i] = fs.nodes[m[0]]
+	}
+
+	for i := len(moves) - 1; i >= 0; i-- {
+		fs.delete(moves[i][0])
+	}
+
+	for i, m := range moves {
+		fs.set(m[1], nodes[i])
+	}
+
+	return nil
+}
+
+// Remove removes the given file or empty directory. The region of a file is
+// released once closed by every file open.
+func (fs *RAM) Remove(filename string) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	if err := fs.remove(clean(filename)); err != nil {
+		return &os.PathError{Op: "remove", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *RAM) remove(p string) error {
+	if p == "/" {
+		return errors.New("cannot remove the root")
+	}
+
+	n, err := fs.lookup(p)
+	if err != nil {
+		return err
+	}
+
+	if n.mode.IsDir() && len(fs.children[p]) != 0 {
+		return ErrNotEmpty
+	}
+
+	fs.delete(p)
+	if n.content != nil {
+		n.content.unref()
+	}
+
+	return nil
+}
+
+func (fs *RAM) Symlink(target, link string) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	if err := fs.symlink(target, clean(link)); err != nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *RAM) symlink(target, p string) error {
+	if _, ok := fs.nodes[p]; ok {
+		return os.ErrExist
+	}
+
+	if err := fs.mkdirAll(path.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	fs.set(p, &node{
+		mode:    os.ModeSymlink | 0777,
+		modTime: time.Now(),
+		target:  target,
+	})
+
+	return nil
+}
+
+func (fs *RAM) Readlink(link string) (string, error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	n, err := fs.lookup(clean(link))
+	if err == nil && !isSymlink(n.mode) {
+		err = errors.New("not a symlink")
+	}
+
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: link, Err: err}
+	}
+
+	return n.target, nil
+}
+
+func (fs *RAM) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(fs, dir, prefix)
+}
+
+func (fs *RAM) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (fs *RAM) Chroot(p string) (billy.Filesystem, error) {
+	return chroot.New(fs, fs.Join(fs.Root(), p)), nil
+}
+
+func (fs *RAM) Root() string {
+	return string(filepath.Separator)
+}
+
+// Capabilities implements the Capable interface.
+func (fs *RAM) Capabilities() billy.Capability {
+	return billy.WriteCapability | billy.ReadCapability |
+		billy.ReadAndWriteCapability | billy.SeekCapability |
+		billy.TruncateCapability
+}
+
+func clean(p string) string {
+	return path.Clean("/" + filepath.ToSlash(p))
+}
+
+func isSymlink(m os.FileMode) bool {
+	return m&os.ModeSymlink != 0
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR) != 0
+}
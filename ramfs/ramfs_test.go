@@ -0,0 +1,184 @@
+package ramfs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/internal/linkutil"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&RAMSuite{})
+
+type RAMSuite struct {
+	test.FilesystemSuite
+	ram *RAM
+}
+
+func (s *RAMSuite) SetUpTest(c *C) {
+	s.ram = New()
+	s.FilesystemSuite = test.NewFilesystemSuite(s.ram)
+}
+
+func (s *RAMSuite) pooled() int {
+	s.ram.a.m.Lock()
+	defer s.ram.a.m.Unlock()
+
+	var count int
+	for _, regions := range s.ram.a.pool {
+		count += len(regions)
+	}
+
+	return count
+}
+
+func (s *RAMSuite) TestSharedContent(c *C) {
+	w, err := s.FS.Create("foo")
+	c.Assert(err, IsNil)
+	r, err := s.FS.Open("foo")
+	c.Assert(err, IsNil)
+
+	_, err = w.Write([]byte("foo"))
+	c.Assert(err, IsNil)
+
+	content, err := ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "foo")
+
+	c.Assert(w.Close(), IsNil)
+	c.Assert(r.Close(), IsNil)
+}
+
+func (s *RAMSuite) TestGrow(c *C) {
+	content := bytes.Repeat([]byte("0123456789"), pageSize)
+	f, err := s.FS.Create("foo")
+	c.Assert(err, IsNil)
+
+	// written in pieces, so the region is moved while growing
+	for i := 0; i < len(content); i += 1000 {
+		end := i + 1000
+		if end > len(content) {
+			end = len(content)
+		}
+
+		_, err = f.Write(content[i:end])
+		c.Assert(err, IsNil)
+	}
+
+	c.Assert(f.Close(), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, string(content))
+
+	fi, err := s.FS.Stat("foo")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Size(), Equals, int64(len(content)))
+
+	// the smaller regions are released while growing
+	c.Assert(s.pooled() > 0, Equals, true)
+}
+
+func (s *RAMSuite) TestHole(c *C) {
+	f, err := s.FS.Create("foo")
+	c.Assert(err, IsNil)
+	_, err = f.Seek(int64(2*pageSize), io.SeekStart)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("foo"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	content := test.ReadFile(c, s.FS, "foo")
+	c.Assert(content, Equals, string(make([]byte, 2*pageSize))+"foo")
+}
+
+func (s *RAMSuite) TestTruncateZeroes(c *C) {
+	content := bytes.Repeat([]byte("x"), 3*pageSize)
+	c.Assert(util.WriteFile(s.FS, "foo", content, 0644), IsNil)
+
+	f, err := s.FS.OpenFile("foo", os.O_RDWR, 0)
+	c.Assert(err, IsNil)
+	c.Assert(f.Truncate(10), IsNil)
+	c.Assert(f.Truncate(int64(3*pageSize)), IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	expected := string(content[:10]) + string(make([]byte, 3*pageSize-10))
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, expected)
+}
+
+func (s *RAMSuite) TestRemoveReleases(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(s.pooled(), Equals, 0)
+
+	c.Assert(s.FS.Remove("foo"), IsNil)
+	c.Assert(s.pooled(), Equals, 1)
+
+	// the region is reused
+	c.Assert(util.WriteFile(s.FS, "bar", []byte("bar"), 0644), IsNil)
+	c.Assert(s.pooled(), Equals, 0)
+	c.Assert(test.ReadFile(c, s.FS, "bar"), Equals, "bar")
+}
+
+func (s *RAMSuite) TestRemoveWhileOpen(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+
+	f, err := s.FS.Open("foo")
+	c.Assert(err, IsNil)
+	c.Assert(s.FS.Remove("foo"), IsNil)
+	c.Assert(s.pooled(), Equals, 0)
+
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "foo")
+
+	c.Assert(f.Close(), IsNil)
+	c.Assert(s.pooled(), Equals, 1)
+}
+
+func (s *RAMSuite) TestRenameReplaceReleases(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "bar", []byte("bar"), 0644), IsNil)
+
+	c.Assert(s.FS.Rename("foo", "bar"), IsNil)
+	c.Assert(s.pooled(), Equals, 1)
+	c.Assert(test.ReadFile(c, s.FS, "bar"), Equals, "foo")
+}
+
+func (s *RAMSuite) TestPoolLimit(c *C) {
+	for i := 0; i < 2*maxPooled; i++ {
+		c.Assert(util.WriteFile(s.FS, fmt.Sprint(i), []byte("foo"), 0644), IsNil)
+	}
+
+	for i := 0; i < 2*maxPooled; i++ {
+		c.Assert(s.FS.Remove(fmt.Sprint(i)), IsNil)
+	}
+
+	c.Assert(s.pooled(), Equals, maxPooled)
+}
+
+func (s *RAMSuite) TestRemoveNotEmpty(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar", []byte("foo"), 0644), IsNil)
+
+	err := s.FS.Remove("foo")
+	c.Assert(err.(*os.PathError).Err, Equals, ErrNotEmpty)
+}
+
+func (s *RAMSuite) TestSymlinkLoop(c *C) {
+	c.Assert(s.FS.Symlink("bar", "foo"), IsNil)
+	c.Assert(s.FS.Symlink("foo", "bar"), IsNil)
+
+	_, err := s.FS.Stat("foo")
+	c.Assert(err.(*os.PathError).Err, Equals, linkutil.ErrTooManyLinks)
+}
+
+func (s *RAMSuite) TestCapabilities(c *C) {
+	c.Assert(billy.CapabilityCheck(s.FS, billy.TruncateCapability), Equals, true)
+	c.Assert(billy.CapabilityCheck(s.FS, billy.LockCapability), Equals, false)
+}
@@ -0,0 +1,227 @@
+package ramfs
+
+import (
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// maxPooled is the maximum number of released regions of every size kept to
+// be reused.
+const maxPooled = 16
+
+var pageSize = os.Getpagesize()
+
+// allocator maps the regions holding the content of the files, sized as a
+// power of two of pages. The regions released are discarded, returning their
+// memory to the system, and kept to be reused, avoiding the cost of mapping
+// them again.
+type allocator struct {
+	m    sync.Mutex
+	pool map[int][][]byte
+}
+
+func newAllocator() *allocator {
+	a := &allocator{pool: make(map[int][][]byte)}
+	runtime.SetFinalizer(a, (*allocator).close)
+	return a
+}
+
+// alloc returns a zeroed region of at least the given size.
+func (a *allocator) alloc(size int64) ([]byte, error) {
+	n := pageSize
+	for int64(n) < size {
+		n *= 2
+	}
+
+	a.m.Lock()
+	if regions := a.pool[n]; len(regions) != 0 {
+		b := regions[len(regions)-1]
+		a.pool[n] = regions[:len(regions)-1]
+		a.m.Unlock()
+		return b, nil
+	}
+
+	a.m.Unlock()
+	return mmap(n)
+}
+
+// free discards the given region, keeping it to be reused if the pool isn't
+// full.
+func (a *allocator) free(b []byte) {
+	discard(b)
+
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	if len(a.pool[len(b)]) < maxPooled {
+		a.pool[len(b)] = append(a.pool[len(b)], b)
+		return
+	}
+
+	munmap(b)
+}
+
+func (a *allocator) close() {
+	for _, regions := range a.pool {
+		for _, b := range regions {
+			munmap(b)
+		}
+	}
+}
+
+// content is the content of a regular file, shared by its node and the files
+// open. It's always zeroed past its size, so the holes and the tails of the
+// truncated files read as zeros.
+type content struct {
+	a *allocator
+
+	m       sync.RWMutex
+	b       []byte
+	size    int64
+	modTime time.Time
+	// refs are the node and the files referencing the content, released
+	// when all of them are gone.
+	refs int
+}
+
+func newContent(a *allocator) *content {
+	c := &content{a: a, modTime: time.Now(), refs: 1}
+	// the region is unmapped when the filesystem and its files are
+	// garbage collected.
+	runtime.SetFinalizer(c, func(c *content) {
+		if c.b != nil {
+			munmap(c.b)
+		}
+	})
+
+	return c
+}
+
+func (c *content) readAt(p []byte, off int64) (int, error) {
+	c.m.RLock()
+	defer c.m.RUnlock()
+
+	if off >= c.size {
+		return 0, io.EOF
+	}
+
+	n := copy(p, c.b[off:c.size])
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (c *content) writeAt(p []byte, off int64) (int, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	end := off + int64(len(p))
+	if err := c.grow(end); err != nil {
+		return 0, err
+	}
+
+	copy(c.b[off:], p)
+	if end > c.size {
+		c.size = end
+	}
+
+	c.modTime = time.Now()
+	return len(p), nil
+}
+
+// grow moves the content to a larger region if it doesn't fit the given
+// size.
+func (c *content) grow(size int64) error {
+	if size <= int64(len(c.b)) {
+		return nil
+	}
+
+	b, err := c.a.alloc(size)
+	if err != nil {
+		return err
+	}
+
+	if c.b != nil {
+		copy(b, c.b[:c.size])
+		c.a.free(c.b)
+	}
+
+	c.b = b
+	return nil
+}
+
+// truncate changes the size of the content, discarding the pages past it.
+func (c *content) truncate(size int64) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.modTime = time.Now()
+	if size == 0 && c.b != nil {
+		c.a.free(c.b)
+		c.b, c.size = nil, 0
+		return nil
+	}
+
+	if size >= c.size {
+		err := c.grow(size)
+		if err == nil {
+			c.size = size
+		}
+
+		return err
+	}
+
+	// the tail of the last page is zeroed, and the next ones discarded.
+	page := (size + int64(pageSize) - 1) / int64(pageSize) * int64(pageSize)
+	if page > c.size {
+		page = c.size
+	}
+
+	for i := size; i < page; i++ {
+		c.b[i] = 0
+	}
+
+	if used := (c.size + int64(pageSize) - 1) / int64(pageSize) * int64(pageSize); used > page {
+		discard(c.b[page:used])
+	}
+
+	c.size = size
+	return nil
+}
+
+func (c *content) stat() (int64, time.Time) {
+	c.m.RLock()
+	defer c.m.RUnlock()
+
+	return c.size, c.modTime
+}
+
+// ref adds a reference to the content. It must be called with the lock of
+// the filesystem held, as unref.
+func (c *content) ref() {
+	c.refs++
+}
+
+// unref removes a reference to the content, releasing its region if it was
+// the last one.
+func (c *content) unref() {
+	c.refs--
+	if c.refs != 0 {
+		return
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.b != nil {
+		c.a.free(c.b)
+		c.b, c.size = nil, 0
+	}
+
+	runtime.SetFinalizer(c, nil)
+}
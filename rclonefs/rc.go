@@ -0,0 +1,264 @@
+package rclonefs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// item is a file or directory returned by operations/stat and
+// operations/list.
+type item struct {
+	Path    string `json:"Path"`
+	Name    string `json:"Name"`
+	Size    int64  `json:"Size"`
+	ModTime string `json:"ModTime"`
+	IsDir   bool   `json:"IsDir"`
+}
+
+// rcError is the body of the errors returned by the remote control API.
+type rcError struct {
+	Error  string `json:"error"`
+	Status int    `json:"status"`
+}
+
+// call performs a request to the given method of the remote control API,
+// with the given parameters as JSON body, decoding the JSON response into
+// v, if not nil.
+func (fs *Rclone) call(method string, params, v interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fs.opts.URL+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	res, err := fs.do(req)
+	if err != nil {
+		return err
+	}
+
+	defer closeBody(res)
+	if v == nil {
+		return nil
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// do performs the given request with the credentials, the response is
+// returned only if succeeded.
+func (fs *Rclone) do(req *http.Request) (*http.Response, error) {
+	if fs.opts.Username != "" {
+		req.SetBasicAuth(fs.opts.Username, fs.opts.Password)
+	}
+
+	res, err := fs.opts.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusOK || res.StatusCode == http.StatusPartialContent {
+		return res, nil
+	}
+
+	defer closeBody(res)
+	return nil, statusError(res)
+}
+
+func statusError(res *http.Response) error {
+	switch res.StatusCode {
+	case http.StatusNotFound:
+		return os.ErrNotExist
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return os.ErrPermission
+	}
+
+	msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+	var e rcError
+	if err := json.Unmarshal(msg, &e); err == nil && e.Error != "" {
+		return fmt.Errorf("rclone: %s", e.Error)
+	}
+
+	if len(bytes.TrimSpace(msg)) == 0 {
+		return fmt.Errorf("rclone: unexpected status: %s", res.Status)
+	}
+
+	return fmt.Errorf("rclone: %s", bytes.TrimSpace(msg))
+}
+
+// closeBody closes the body of the given response, reading first what the
+// decoder left or what wasn't decoded at all, as with the uploads, so the
+// connection to the rc server is reused.
+func closeBody(res *http.Response) {
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+}
+
+// stat returns the item of the given path, with operations/stat.
+func (fs *Rclone) stat(p string) (*item, error) {
+	var res struct {
+		Item *item `json:"item"`
+	}
+
+	params := map[string]string{"fs": fs.opts.Remote, "remote": p}
+	if err := fs.call("operations/stat", params, &res); err != nil {
+		return nil, err
+	}
+
+	if res.Item == nil {
+		return nil, os.ErrNotExist
+	}
+
+	return res.Item, nil
+}
+
+// list returns the items of the given directory, with operations/list.
+func (fs *Rclone) list(p string) ([]*item, error) {
+	var res struct {
+		List []*item `json:"list"`
+	}
+
+	params := map[string]string{"fs": fs.opts.Remote, "remote": p}
+	if err := fs.call("operations/list", params, &res); err != nil {
+		return nil, err
+	}
+
+	return res.List, nil
+}
+
+// download reads the given file, served by the daemon with --rc-serve.
+func (fs *Rclone) download(p string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, fs.objectURL(p), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := fs.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer closeBody(res)
+	buf := bytes.NewBuffer(nil)
+	if res.ContentLength > 0 {
+		buf.Grow(int(res.ContentLength))
+	}
+
+	_, err = buf.ReadFrom(res.Body)
+	return buf.Bytes(), err
+}
+
+// upload writes the given file with operations/uploadfile, replacing it if
+// it exists.
+func (fs *Rclone) upload(p string, content []byte) error {
+	body := bytes.NewBuffer(nil)
+	mw := multipart.NewWriter(body)
+	w, err := mw.CreateFormFile("file0", path.Base(p))
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(content); err != nil {
+		return err
+	}
+
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	dir := path.Dir(p)
+	if dir == "." {
+		dir = ""
+	}
+
+	query := url.Values{"fs": {fs.opts.Remote}, "remote": {dir}}
+	req, err := http.NewRequest(http.MethodPost, fs.opts.URL+"/operations/uploadfile?"+query.Encode(), body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	res, err := fs.do(req)
+	if err != nil {
+		return err
+	}
+
+	closeBody(res)
+	return nil
+}
+
+// objectURL returns the URL of the given file, served by the daemon as
+// /[remote:path]/path/to/file.
+func (fs *Rclone) objectURL(p string) string {
+	names := strings.Split(p, "/")
+	for i, name := range names {
+		names[i] = url.PathEscape(name)
+	}
+
+	return fs.opts.URL + "/" + url.PathEscape("["+fs.opts.Remote+"]") + "/" + strings.Join(names, "/")
+}
+
+// remotePath returns the remote, in the syntax of rclone, of the given
+// path, eg.: "drive:backups/foo".
+func (fs *Rclone) remotePath(p string) string {
+	if p == "" || strings.HasSuffix(fs.opts.Remote, ":") || strings.HasSuffix(fs.opts.Remote, "/") {
+		return fs.opts.Remote + p
+	}
+
+	return fs.opts.Remote + "/" + p
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func newFileInfo(i *item) *fileInfo {
+	fi := &fileInfo{name: i.Name, size: i.Size, mode: 0644}
+	if i.IsDir {
+		fi.size, fi.mode = 0, os.ModeDir|0755
+	}
+
+	fi.modTime, _ = time.Parse(time.RFC3339Nano, i.ModTime)
+	return fi
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.size
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	return fi.mode
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.mode.IsDir()
+}
+
+func (*fileInfo) Sys() interface{} {
+	return nil
+}
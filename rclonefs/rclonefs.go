@@ -0,0 +1,385 @@
+// Package rclonefs provides a billy filesystem over any remote of rclone,
+// through the remote control API of a running rclone daemon, started with:
+//
+//	rclone rcd --rc-serve --rc-user user --rc-pass pass
+//
+// The filesystem is rooted at the given remote, in the syntax of rclone,
+// eg.: "drive:", "s3:bucket/path" or "sftp:/home/user". The files are
+// downloaded from the objects served by the daemon, so --rc-serve is
+// required, and uploaded with operations/uploadfile. Directories are
+// renamed with sync/move, so it's not atomic.
+//
+// The content of the files is kept in memory, it's downloaded on the first
+// read, and uploaded on Close if modified. Depending on the remote, the
+// empty directories may not be kept, and the modes are not supported.
+package rclonefs // import "gopkg.in/src-d/go-billy.v4/rclonefs"
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/internal/pathutil"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const defaultURL = "http://localhost:5572"
+
+var (
+	// ErrNotEmpty is returned when removing a directory with files.
+	ErrNotEmpty = billy.ErrNotEmpty
+
+	errIsDir  = errors.New("is a directory")
+	errNotDir = errors.New("not a directory")
+)
+
+// Options holds the configuration of a rclone filesystem.
+type Options struct {
+	// Client sends the calls to the remote control API and the transfers
+	// of the files to the daemon, http.DefaultClient if nil.
+	Client *http.Client
+	// URL is the base URL of the remote control API of the daemon,
+	// http://localhost:5572 by default.
+	URL string
+	// Username and Password are the credentials of the remote control
+	// API, set with --rc-user and --rc-pass, if any.
+	Username, Password string
+	// Remote is the root of the filesystem, a remote in the syntax of
+	// rclone, eg.: "drive:" or "s3:bucket/path".
+	Remote string
+}
+
+// Rclone is a filesystem over a remote of rclone.
+type Rclone struct {
+	opts Options
+}
+
+// New returns a new filesystem over the given remote of a rclone daemon.
+func New(opts Options) (*Rclone, error) {
+	if opts.Remote == "" {
+		return nil, errors.New("remote is required")
+	}
+
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+
+	if opts.URL == "" {
+		opts.URL = defaultURL
+	}
+
+	opts.URL = strings.TrimSuffix(opts.URL, "/")
+	return &Rclone{opts: opts}, nil
+}
+
+func (fs *Rclone) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Rclone) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file, creating it if needed, and the missing
+// directories. The permissions are ignored.
+func (fs *Rclone) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	f, err := fs.openFile(pathutil.Relative(filename), rclonePath(filename), flag)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+	}
+
+	return f, nil
+}
+
+func (fs *Rclone) openFile(name, p string, flag int) (*file, error) {
+	if p == "" {
+		return nil, errIsDir
+	}
+
+	i, err := fs.stat(p)
+	switch {
+	case err == nil:
+		if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+			return nil, os.ErrExist
+		}
+
+		if i.IsDir {
+			return nil, errIsDir
+		}
+
+		f := newFile(fs, name, p, flag)
+		if flag&os.O_TRUNC != 0 && isWrite(flag) {
+			if i.Size != 0 {
+				if err := fs.upload(p, nil); err != nil {
+					return nil, err
+				}
+			}
+
+			f.Loaded = true
+		}
+
+		return f, nil
+	case os.IsNotExist(err) && flag&os.O_CREATE != 0:
+		if err := fs.mkdirAll(parent(p)); err != nil {
+			return nil, err
+		}
+
+		if err := fs.upload(p, nil); err != nil {
+			return nil, err
+		}
+
+		f := newFile(fs, name, p, flag)
+		f.Loaded = true
+		return f, nil
+	default:
+		return nil, err
+	}
+}
+
+func (fs *Rclone) Stat(filename string) (os.FileInfo, error) {
+	p := rclonePath(filename)
+	if p == "" {
+		return &fileInfo{name: string(filepath.Separator), mode: os.ModeDir | 0755}, nil
+	}
+
+	i, err := fs.stat(p)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: filename, Err: err}
+	}
+
+	return newFileInfo(i), nil
+}
+
+// Lstat is equivalent to Stat, since the symlinks of the remotes are
+// followed, or skipped, by rclone.
+func (fs *Rclone) Lstat(filename string) (os.FileInfo, error) {
+	return fs.Stat(filename)
+}
+
+// ReadDir lists the entries of the given directory, with operations/list.
+func (fs *Rclone) ReadDir(filename string) ([]os.FileInfo, error) {
+	infos, err := fs.readDir(rclonePath(filename))
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: filename, Err: err}
+	}
+
+	return infos, nil
+}
+
+func (fs *Rclone) readDir(p string) ([]os.FileInfo, error) {
+	if p != "" {
+		i, err := fs.stat(p)
+		if err != nil {
+			return nil, err
+		}
+
+		if !i.IsDir {
+			return nil, errNotDir
+		}
+	}
+
+	items, err := fs.list(p)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]os.FileInfo, 0, len(items))
+	for _, i := range items {
+		infos = append(infos, newFileInfo(i))
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+
+	return infos, nil
+}
+
+// Rename moves the file with operations/movefile, replacing the
+// destination if it's a file, or the directory with sync/move, moving its
+// files one by one.
+func (fs *Rclone) Rename(from, to string) error {
+	if err := fs.rename(rclonePath(from), rclonePath(to)); err != nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Rclone) rename(from, to string) error {
+	if from == "" || to == "" {
+		return errors.New("cannot rename the root")
+	}
+
+	src, err := fs.stat(from)
+	if err != nil || from == to {
+		return err
+	}
+
+	if strings.HasPrefix(to, from+"/") {
+		return errors.New("cannot move a directory into itself")
+	}
+
+	target, err := fs.stat(to)
+	switch {
+	case err == nil && (target.IsDir || src.IsDir):
+		return os.ErrExist
+	case err != nil && !os.IsNotExist(err):
+		return err
+	}
+
+	if err := fs.mkdirAll(parent(to)); err != nil {
+		return err
+	}
+
+	if !src.IsDir {
+		return fs.call("operations/movefile", map[string]string{
+			"srcFs": fs.opts.Remote, "srcRemote": from,
+			"dstFs": fs.opts.Remote, "dstRemote": to,
+		}, nil)
+	}
+
+	err = fs.call("sync/move", map[string]interface{}{
+		"srcFs":              fs.remotePath(from),
+		"dstFs":              fs.remotePath(to),
+		"createEmptySrcDirs": true,
+		"deleteEmptySrcDirs": true,
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	// the source directory is kept by sync/move
+	err = fs.call("operations/rmdir", map[string]string{"fs": fs.opts.Remote, "remote": from}, nil)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// Remove deletes the given file or empty directory.
+func (fs *Rclone) Remove(filename string) error {
+	if err := fs.remove(rclonePath(filename)); err != nil {
+		return &os.PathError{Op: "remove", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Rclone) remove(p string) error {
+	if p == "" {
+		return ErrNotEmpty
+	}
+
+	i, err := fs.stat(p)
+	if err != nil {
+		return err
+	}
+
+	params := map[string]string{"fs": fs.opts.Remote, "remote": p}
+	if !i.IsDir {
+		return fs.call("operations/deletefile", params, nil)
+	}
+
+	items, err := fs.list(p)
+	if err != nil {
+		return err
+	}
+
+	if len(items) != 0 {
+		return ErrNotEmpty
+	}
+
+	return fs.call("operations/rmdir", params, nil)
+}
+
+// MkdirAll creates the directory and any missing parent, the permissions
+// are ignored.
+func (fs *Rclone) MkdirAll(filename string, perm os.FileMode) error {
+	if err := fs.mkdirAll(rclonePath(filename)); err != nil {
+		return &os.PathError{Op: "mkdir", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Rclone) mkdirAll(p string) error {
+	if p == "" {
+		return nil
+	}
+
+	i, err := fs.stat(p)
+	switch {
+	case err == nil && !i.IsDir:
+		return errNotDir
+	case err == nil:
+		return nil
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	// operations/mkdir creates the missing parents, unless one is a file
+	if err := fs.mkdirAll(parent(p)); err != nil {
+		return err
+	}
+
+	return fs.call("operations/mkdir", map[string]string{"fs": fs.opts.Remote, "remote": p}, nil)
+}
+
+func (fs *Rclone) Symlink(target, link string) error {
+	return billy.ErrNotSupported
+}
+
+func (fs *Rclone) Readlink(link string) (string, error) {
+	return "", billy.ErrNotSupported
+}
+
+func (fs *Rclone) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(fs, dir, prefix)
+}
+
+func (fs *Rclone) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (fs *Rclone) Chroot(p string) (billy.Filesystem, error) {
+	return chroot.New(fs, fs.Join(fs.Root(), p)), nil
+}
+
+func (fs *Rclone) Root() string {
+	return string(filepath.Separator)
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Rclone) Capabilities() billy.Capability {
+	return billy.WriteCapability | billy.ReadCapability |
+		billy.ReadAndWriteCapability | billy.SeekCapability |
+		billy.TruncateCapability
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR) != 0
+}
+
+// rclonePath returns the path of the given path in the remote, a relative
+// path, or empty for the root.
+func rclonePath(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(p)), "/")
+}
+
+// parent returns the parent directory of the given path in the remote.
+func parent(p string) string {
+	dir := path.Dir(p)
+	if dir == "." {
+		return ""
+	}
+
+	return dir
+}
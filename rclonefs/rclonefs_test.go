@@ -0,0 +1,163 @@
+package rclonefs
+
+import (
+	"os"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&RcloneSuite{})
+
+// RcloneSuite runs the generic suites, except the symlinks one, since the
+// remote control API doesn't support symlinks.
+type RcloneSuite struct {
+	test.BasicSuite
+	test.DirSuite
+	test.TempFileSuite
+	test.ChrootSuite
+
+	FS     *Rclone
+	server *server
+}
+
+func (s *RcloneSuite) SetUpTest(c *C) {
+	s.server = newServer()
+	s.FS = s.newFS(c, Options{Remote: testRemote})
+
+	s.BasicSuite.FS = s.FS
+	s.DirSuite.FS = s.FS
+	s.TempFileSuite.FS = s.FS
+	s.ChrootSuite.FS = s.FS
+
+	// every temporary file takes several calls to the remote control API,
+	// too many to run the default rounds with the race detector.
+	s.TempFileSuite.Rounds = 8
+}
+
+func (s *RcloneSuite) TearDownTest(c *C) {
+	s.server.Close()
+}
+
+func (s *RcloneSuite) newFS(c *C, opts Options) *Rclone {
+	opts.URL = s.server.URL + "/"
+	opts.Username, opts.Password = testUsername, testPassword
+	fs, err := New(opts)
+	c.Assert(err, IsNil)
+	return fs
+}
+
+func (s *RcloneSuite) TestNew(c *C) {
+	_, err := New(Options{})
+	c.Assert(err, ErrorMatches, "remote is required")
+
+	fs, err := New(Options{Remote: "drive:"})
+	c.Assert(err, IsNil)
+	c.Assert(fs.opts.URL, Equals, defaultURL)
+}
+
+func (s *RcloneSuite) TestCredentials(c *C) {
+	fs, err := New(Options{URL: s.server.URL, Remote: testRemote})
+	c.Assert(err, IsNil)
+
+	_, err = fs.Stat("foo")
+	c.Assert(os.IsPermission(err), Equals, true)
+}
+
+func (s *RcloneSuite) TestRemotePath(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar/baz", []byte("foo"), 0644), IsNil)
+
+	fs := s.newFS(c, Options{Remote: testRemote + "foo"})
+	c.Assert(test.ReadFile(c, fs, "bar/baz"), Equals, "foo")
+	c.Assert(fs.remotePath("bar"), Equals, "test:foo/bar")
+	c.Assert(s.FS.remotePath("bar"), Equals, "test:bar")
+
+	c.Assert(fs.Rename("bar", "qux"), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "foo/qux/baz"), Equals, "foo")
+}
+
+func (s *RcloneSuite) TestNonASCIINames(c *C) {
+	name := "ñandú/😀 #1?.txt"
+	c.Assert(util.WriteFile(s.FS, name, []byte("foo"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, name), Equals, "foo")
+
+	fi, err := s.FS.Stat(name)
+	c.Assert(err, IsNil)
+	c.Assert(fi.Name(), Equals, "😀 #1?.txt")
+}
+
+func (s *RcloneSuite) TestUpload(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(s.server.count("operations/uploadfile"), Equals, 2)
+	c.Assert(test.ReadFile(c, s.FS, "foo"), Equals, "foo")
+	c.Assert(s.server.count("serve"), Equals, 1)
+}
+
+func (s *RcloneSuite) TestRenameMove(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar", []byte("bar"), 0644), IsNil)
+	c.Assert(s.FS.Rename("foo/bar", "qux/baz"), IsNil)
+	c.Assert(s.server.count("operations/movefile"), Equals, 1)
+	c.Assert(test.ReadFile(c, s.FS, "qux/baz"), Equals, "bar")
+}
+
+func (s *RcloneSuite) TestRenameDir(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar", []byte("bar"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "foo/qux/baz", []byte("baz"), 0644), IsNil)
+
+	c.Assert(s.FS.Rename("foo", "new/foo"), IsNil)
+	c.Assert(s.server.count("sync/move"), Equals, 1)
+
+	c.Assert(test.ReadFile(c, s.FS, "new/foo/bar"), Equals, "bar")
+	c.Assert(test.ReadFile(c, s.FS, "new/foo/qux/baz"), Equals, "baz")
+
+	_, err := s.FS.Stat("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	err = s.FS.Rename("new", "new/foo/bar")
+	c.Assert(err, NotNil)
+}
+
+func (s *RcloneSuite) TestRenameReplace(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "bar", []byte("bar"), 0644), IsNil)
+	c.Assert(s.FS.Rename("foo", "bar"), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "bar"), Equals, "foo")
+
+	c.Assert(s.FS.MkdirAll("qux", 0755), IsNil)
+	err := s.FS.Rename("bar", "qux")
+	c.Assert(os.IsExist(err.(*os.LinkError).Err), Equals, true)
+}
+
+func (s *RcloneSuite) TestRemoveNotEmpty(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar", nil, 0644), IsNil)
+
+	err := s.FS.Remove("foo")
+	c.Assert(err.(*os.PathError).Err, Equals, ErrNotEmpty)
+}
+
+// TestStat overrides the one of BasicSuite, since the remotes don't
+// support file modes.
+func (s *RcloneSuite) TestStat(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar", []byte("foo"), 0644), IsNil)
+
+	fi, err := s.FS.Stat("foo/bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Name(), Equals, "bar")
+	c.Assert(fi.Size(), Equals, int64(3))
+	c.Assert(fi.ModTime().IsZero(), Equals, false)
+	c.Assert(fi.IsDir(), Equals, false)
+}
+
+func (s *RcloneSuite) TestOpenFileWithModes(c *C) {
+	c.Skip("the remotes don't support file modes")
+}
+
+func (s *RcloneSuite) TestSymlink(c *C) {
+	c.Assert(s.FS.Symlink("foo", "bar"), Equals, billy.ErrNotSupported)
+}
@@ -0,0 +1,285 @@
+package rclonefs
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const (
+	testRemote   = "test:"
+	testUsername = "user"
+	testPassword = "pass"
+)
+
+// server is a minimal remote control API of a rclone daemon, serving the
+// remote "test:" from a memfs, to test the client. It supports the methods
+// and parameters used by the client only.
+type server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	fs       billy.Filesystem
+	requests map[string]int
+}
+
+func newServer() *server {
+	s := &server{
+		fs:       memfs.New(),
+		requests: make(map[string]int),
+	}
+
+	s.fs.MkdirAll("/", 0755)
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// count returns the number of requests to the given method, eg.:
+// "operations/list", or "serve" for the downloads.
+func (s *server) count(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests[method]
+}
+
+type params struct {
+	Fs        string `json:"fs"`
+	Remote    string `json:"remote"`
+	SrcFs     string `json:"srcFs"`
+	SrcRemote string `json:"srcRemote"`
+	DstFs     string `json:"dstFs"`
+	DstRemote string `json:"dstRemote"`
+}
+
+func (s *server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user, pass, ok := r.BasicAuth(); !ok || user != testUsername || pass != testPassword {
+		writeError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		s.requests["serve"]++
+		s.serve(w, r.URL.Path)
+		return
+	}
+
+	method := strings.TrimPrefix(r.URL.Path, "/")
+	s.requests[method]++
+
+	var p params
+	if method == "operations/uploadfile" {
+		p.Fs, p.Remote = r.URL.Query().Get("fs"), r.URL.Query().Get("remote")
+	} else if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	switch method {
+	case "operations/stat":
+		fi, err := s.fs.Stat(s.path(p.Fs, p.Remote))
+		if err != nil {
+			writeJSON(w, map[string]interface{}{"item": nil})
+			return
+		}
+
+		writeJSON(w, map[string]interface{}{"item": newItem(p.Remote, fi)})
+	case "operations/list":
+		dir := s.path(p.Fs, p.Remote)
+		infos, err := s.fs.ReadDir(dir)
+		if err != nil {
+			writeError(w, http.StatusNotFound, "directory not found")
+			return
+		}
+
+		list := make([]*item, 0, len(infos))
+		for _, fi := range infos {
+			list = append(list, newItem(path.Join(p.Remote, fi.Name()), fi))
+		}
+
+		writeJSON(w, map[string]interface{}{"list": list})
+	case "operations/mkdir":
+		s.result(w, s.fs.MkdirAll(s.path(p.Fs, p.Remote), 0755))
+	case "operations/rmdir":
+		fi, err := s.fs.Stat(s.path(p.Fs, p.Remote))
+		if err != nil || !fi.IsDir() {
+			writeError(w, http.StatusNotFound, "directory not found")
+			return
+		}
+
+		s.result(w, s.fs.Remove(s.path(p.Fs, p.Remote)))
+	case "operations/deletefile":
+		fi, err := s.fs.Stat(s.path(p.Fs, p.Remote))
+		if err != nil || fi.IsDir() {
+			writeError(w, http.StatusNotFound, "object not found")
+			return
+		}
+
+		s.result(w, s.fs.Remove(s.path(p.Fs, p.Remote)))
+	case "operations/movefile":
+		src, dst := s.path(p.SrcFs, p.SrcRemote), s.path(p.DstFs, p.DstRemote)
+		if _, err := s.fs.Stat(src); err != nil {
+			writeError(w, http.StatusNotFound, "object not found")
+			return
+		}
+
+		s.fs.Remove(dst)
+		s.result(w, s.fs.Rename(src, dst))
+	case "operations/uploadfile":
+		s.upload(w, r, s.path(p.Fs, p.Remote))
+	case "sync/move":
+		s.move(w, s.path(p.SrcFs, ""), s.path(p.DstFs, ""))
+	default:
+		writeError(w, http.StatusNotFound, "couldn't find method "+method)
+	}
+}
+
+// path returns the path in the memfs of the given remote and path.
+func (s *server) path(fs, remote string) string {
+	return path.Join("/", strings.TrimPrefix(fs, testRemote), remote)
+}
+
+// serve writes the content of the file at /[remote:path]/path/to/file.
+func (s *server) serve(w http.ResponseWriter, p string) {
+	p = strings.TrimPrefix(p, "/")
+	end := strings.Index(p, "]")
+	if !strings.HasPrefix(p, "[") || end < 0 {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	f, err := s.fs.Open(s.path(p[1:end], p[end+1:]))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "object not found")
+		return
+	}
+
+	defer f.Close()
+	io.Copy(w, f)
+}
+
+func (s *server) upload(w http.ResponseWriter, r *http.Request, dir string) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		f, err := s.fs.Create(path.Join(dir, part.FileName()))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		io.Copy(f, part)
+		f.Close()
+	}
+
+	writeJSON(w, struct{}{})
+}
+
+// move moves the files of the directory src to dst, creating the
+// directories, and removing the empty directories of src but src itself.
+func (s *server) move(w http.ResponseWriter, src, dst string) {
+	if _, err := s.fs.Stat(src); err != nil {
+		writeError(w, http.StatusNotFound, "directory not found")
+		return
+	}
+
+	s.result(w, s.moveDir(src, dst))
+}
+
+func (s *server) moveDir(src, dst string) error {
+	if err := s.fs.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+
+	infos, err := s.fs.ReadDir(src)
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range infos {
+		from, to := path.Join(src, fi.Name()), path.Join(dst, fi.Name())
+		if !fi.IsDir() {
+			if err := s.fs.Rename(from, to); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err := s.moveDir(from, to); err != nil {
+			return err
+		}
+
+		if err := util.RemoveAll(s.fs, from); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *server) result(w http.ResponseWriter, err error) {
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, struct{}{})
+}
+
+func newItem(p string, fi os.FileInfo) *item {
+	i := &item{
+		Path:    p,
+		Name:    fi.Name(),
+		Size:    fi.Size(),
+		ModTime: fi.ModTime().Format(time.RFC3339Nano),
+		IsDir:   fi.IsDir(),
+	}
+
+	if i.IsDir {
+		i.Size = -1
+	}
+
+	return i
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":  msg,
+		"status": status,
+	})
+}
@@ -0,0 +1,265 @@
+package redisfs
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Error is an error reply of the Redis server.
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+var errProtocol = errors.New("redis protocol error")
+
+// conn is a connection to a Redis server, speaking the RESP protocol.
+type conn struct {
+	netConn net.Conn
+	r       *bufio.Reader
+	w       *bufio.Writer
+	timeout time.Duration
+}
+
+// dial opens a new connection, authenticating and selecting the database if
+// configured.
+func dial(addr string, opts *Options) (*conn, error) {
+	nc, err := net.DialTimeout("tcp", addr, opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &conn{
+		netConn: nc,
+		r:       bufio.NewReader(nc),
+		w:       bufio.NewWriter(nc),
+		timeout: opts.Timeout,
+	}
+
+	if opts.Password != "" {
+		if _, err := c.do("AUTH", opts.Password); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+
+	if opts.DB != 0 {
+		if _, err := c.do("SELECT", opts.DB); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// do sends the given command, returning its reply. The error replies are
+// returned as an Error.
+func (c *conn) do(args ...interface{}) (interface{}, error) {
+	if err := c.send(args...); err != nil {
+		return nil, err
+	}
+
+	if err := c.flush(); err != nil {
+		return nil, err
+	}
+
+	reply, err := c.receive()
+	if err != nil {
+		return nil, err
+	}
+
+	if e, ok := reply.(Error); ok {
+		return nil, e
+	}
+
+	return reply, nil
+}
+
+// transaction sends the given commands in a MULTI/EXEC block, so they are
+// executed atomically, returning their replies. The first error reply of the
+// commands is returned as an Error, after all of them are executed.
+func (c *conn) transaction(cmds ...[]interface{}) ([]interface{}, error) {
+	if err := c.send("MULTI"); err != nil {
+		return nil, err
+	}
+
+	for _, cmd := range cmds {
+		if err := c.send(cmd...); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.send("EXEC"); err != nil {
+		return nil, err
+	}
+
+	if err := c.flush(); err != nil {
+		return nil, err
+	}
+
+	// the replies of MULTI and the queued commands are read before the one
+	// of EXEC, an error there means the transaction was discarded.
+	var queueErr error
+	for i := 0; i <= len(cmds); i++ {
+		reply, err := c.receive()
+		if err != nil {
+			return nil, err
+		}
+
+		if e, ok := reply.(Error); ok && queueErr == nil {
+			queueErr = e
+		}
+	}
+
+	reply, err := c.receive()
+	if err != nil {
+		return nil, err
+	}
+
+	if queueErr != nil {
+		return nil, queueErr
+	}
+
+	if e, ok := reply.(Error); ok {
+		return nil, e
+	}
+
+	replies, ok := reply.([]interface{})
+	if !ok || len(replies) != len(cmds) {
+		return nil, errProtocol
+	}
+
+	for _, r := range replies {
+		if e, ok := r.(Error); ok {
+			return replies, e
+		}
+	}
+
+	return replies, nil
+}
+
+// send writes the given command to the buffer, as an array of bulk strings.
+func (c *conn) send(args ...interface{}) error {
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		var b []byte
+		switch v := arg.(type) {
+		case string:
+			b = []byte(v)
+		case []byte:
+			b = v
+		case int:
+			b = strconv.AppendInt(nil, int64(v), 10)
+		case int64:
+			b = strconv.AppendInt(nil, v, 10)
+		default:
+			return fmt.Errorf("unsupported argument type %T", arg)
+		}
+
+		fmt.Fprintf(c.w, "$%d\r\n", len(b))
+		c.w.Write(b)
+		c.w.WriteString("\r\n")
+	}
+
+	return nil
+}
+
+func (c *conn) flush() error {
+	if c.timeout > 0 {
+		c.netConn.SetWriteDeadline(time.Now().Add(c.timeout))
+	}
+
+	return c.w.Flush()
+}
+
+// receive reads a reply. The error replies are returned as an Error value,
+// the bulk strings as []byte, the integers as int64, the simple strings as
+// string and the arrays as []interface{}.
+func (c *conn) receive() (interface{}, error) {
+	if c.timeout > 0 {
+		c.netConn.SetReadDeadline(time.Now().Add(c.timeout))
+	}
+
+	return readReply(c.r)
+}
+
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) == 0 {
+		return nil, errProtocol
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return Error(line[1:]), nil
+	case ':':
+		return parseInt(line[1:])
+	case '$':
+		n, err := parseInt(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+
+		return b[:n], nil
+	case '*':
+		n, err := parseInt(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+
+		replies := make([]interface{}, n)
+		for i := range replies {
+			if replies[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+
+		return replies, nil
+	default:
+		return nil, errProtocol
+	}
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", errProtocol
+	}
+
+	return line[:len(line)-2], nil
+}
+
+func parseInt(s string) (int64, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, errProtocol
+	}
+
+	return n, nil
+}
+
+func (c *conn) close() error {
+	return c.netConn.Close()
+}
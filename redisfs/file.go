@@ -0,0 +1,193 @@
+package redisfs
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4/internal/memfile"
+)
+
+// nodeFields are the fields of the hash of a node holding its metadata, the
+// content is stored in the "data" field.
+var nodeFields = [...]string{"mode", "mtime", "size", "ttl"}
+
+// node is the metadata of a file, directory or symlink.
+type node struct {
+	mode    os.FileMode
+	modTime time.Time
+	size    int64
+	// ttl is the time to live of the file, set again on every write.
+	ttl time.Duration
+}
+
+// fields returns the fields and values of the hash of the node, with the
+// given content.
+func (n *node) fields(content []byte) []interface{} {
+	fields := []interface{}{
+		"mode", int64(n.mode),
+		"mtime", n.modTime.UnixNano(),
+		"size", n.size,
+		"ttl", int64(n.ttl / time.Millisecond),
+	}
+
+	if !n.mode.IsDir() {
+		fields = append(fields, "data", content)
+	}
+
+	return fields
+}
+
+// parseNode parses the reply of a HMGET of the nodeFields, returning nil if
+// the node doesn't exist.
+func parseNode(reply interface{}) (*node, error) {
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != len(nodeFields) {
+		return nil, errProtocol
+	}
+
+	if values[0] == nil {
+		return nil, nil
+	}
+
+	var ints [len(nodeFields)]int64
+	for i, v := range values {
+		if v == nil {
+			// the node is being created, with only the mode set.
+			continue
+		}
+
+		b, ok := v.([]byte)
+		if !ok {
+			return nil, errProtocol
+		}
+
+		var err error
+		if ints[i], err = strconv.ParseInt(string(b), 10, 64); err != nil {
+			return nil, errProtocol
+		}
+	}
+
+	return &node{
+		mode:    os.FileMode(ints[0]),
+		modTime: time.Unix(0, ints[1]),
+		size:    ints[2],
+		ttl:     time.Duration(ints[3]) * time.Millisecond,
+	}, nil
+}
+
+func parseStrings(reply interface{}) ([]string, error) {
+	values, ok := reply.([]interface{})
+	if !ok {
+		return nil, errProtocol
+	}
+
+	strs := make([]string, len(values))
+	for i, v := range values {
+		b, ok := v.([]byte)
+		if !ok {
+			return nil, errProtocol
+		}
+
+		strs[i] = string(b)
+	}
+
+	return strs, nil
+}
+
+// file is a file of a Redis filesystem. Its content is read on the first
+// access, and kept in memory until closed, when it's written if modified.
+type file struct {
+	*memfile.File
+
+	fs   *Redis
+	path string
+	node *node
+}
+
+func newFile(fs *Redis, name, p string, flag int, n *node) *file {
+	f := &file{fs: fs, path: p, node: n}
+	f.File = memfile.New(name, flag, f.load)
+	return f
+}
+
+func (f *file) load() ([]byte, error) {
+	content, err := f.fs.load(f.path)
+	if err != nil {
+		return nil, &os.PathError{Op: "read", Path: f.Name(), Err: err}
+	}
+
+	return content, nil
+}
+
+// Close writes the content of the file if it was modified.
+func (f *file) Close() error {
+	if f.Closed {
+		return os.ErrClosed
+	}
+
+	f.Closed = true
+
+	var err error
+	if f.Dirty {
+		err = f.fs.store(f.path, f.Content)
+	}
+
+	f.Content = nil
+	return err
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	fi := newFileInfo(filepath.Base(f.Name()), f.node)
+	if f.Loaded {
+		fi.size = int64(len(f.Content))
+	}
+
+	return fi, nil
+}
+
+// Lock is a no-op in redisfs.
+func (f *file) Lock() error {
+	return nil
+}
+
+// Unlock is a no-op in redisfs.
+func (f *file) Unlock() error {
+	return nil
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func newFileInfo(name string, n *node) *fileInfo {
+	return &fileInfo{name: name, size: n.size, mode: n.mode, modTime: n.modTime}
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.size
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	return fi.mode
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.mode.IsDir()
+}
+
+func (*fileInfo) Sys() interface{} {
+	return nil
+}
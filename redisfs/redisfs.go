@@ -0,0 +1,793 @@
+// Package redisfs provides a billy filesystem stored in a Redis server, to
+// share ephemeral files between several processes or machines, eg.: caches
+// of cloned repositories.
+//
+// Every file, directory and symlink is a hash holding its metadata, and the
+// content of the files or the target of the symlinks, and every directory
+// has a set with the names of its children. The files can have a time to
+// live, after which they are removed by the server.
+package redisfs // import "gopkg.in/src-d/go-billy.v4/redisfs"
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/internal/linkutil"
+	"gopkg.in/src-d/go-billy.v4/internal/pathutil"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const (
+	defaultPrefix       = "billy:"
+	defaultTimeout      = 30 * time.Second
+	defaultMaxIdleConns = 2
+)
+
+var (
+	// ErrNotEmpty is returned when removing a directory with files.
+	ErrNotEmpty = billy.ErrNotEmpty
+
+	errNotDir = errors.New("not a directory")
+)
+
+// Options holds the configuration of a Redis filesystem.
+type Options struct {
+	// Password is used to authenticate, if not empty. It takes precedence
+	// over the password of the URL.
+	Password string
+	// DB is the database selected, it takes precedence over the database
+	// of the URL if not zero.
+	DB int
+	// Prefix is the prefix of the keys of the filesystem, "billy:" by
+	// default. Several filesystems can be stored in the same database
+	// using different prefixes.
+	Prefix string
+	// TTL is the time to live of the new files and symlinks, zero means
+	// they never expire. It can be changed for each file with SetTTL. The
+	// directories never expire.
+	TTL time.Duration
+	// Timeout is the timeout of the network operations, 30 seconds by
+	// default.
+	Timeout time.Duration
+	// MaxIdleConns is the maximum number of idle connections kept open, 2
+	// by default.
+	MaxIdleConns int
+}
+
+// Redis is a filesystem stored in a Redis server.
+type Redis struct {
+	addr string
+	opts Options
+
+	mu   sync.Mutex
+	idle []*conn
+}
+
+// New returns a new filesystem stored in the Redis server of the given URL,
+// eg.: "redis://:password@localhost:6379/0". No connection is made until the
+// first operation.
+func New(rawURL string, opts Options) (*Redis, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("unsupported scheme: %q", u.Scheme)
+	}
+
+	port := "6379"
+	if u.Port() != "" {
+		port = u.Port()
+	}
+
+	fs := &Redis{addr: net.JoinHostPort(u.Hostname(), port), opts: opts}
+	if fs.opts.Password == "" && u.User != nil {
+		fs.opts.Password, _ = u.User.Password()
+	}
+
+	if db := strings.Trim(u.Path, "/"); fs.opts.DB == 0 && db != "" {
+		if fs.opts.DB, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid database: %q", db)
+		}
+	}
+
+	if fs.opts.Prefix == "" {
+		fs.opts.Prefix = defaultPrefix
+	}
+
+	if fs.opts.Timeout <= 0 {
+		fs.opts.Timeout = defaultTimeout
+	}
+
+	if fs.opts.MaxIdleConns <= 0 {
+		fs.opts.MaxIdleConns = defaultMaxIdleConns
+	}
+
+	return fs, nil
+}
+
+// acquire returns an idle connection of the pool, or a new one if none.
+func (fs *Redis) acquire() (c *conn, reused bool, err error) {
+	fs.mu.Lock()
+	if n := len(fs.idle); n != 0 {
+		c = fs.idle[n-1]
+		fs.idle = fs.idle[:n-1]
+	}
+	fs.mu.Unlock()
+
+	if c != nil {
+		return c, true, nil
+	}
+
+	c, err = dial(fs.addr, &fs.opts)
+	return c, false, err
+}
+
+// release returns the connection to the pool, unless the given error, the
+// one of its last operation, means it's broken or the pool is full.
+func (fs *Redis) release(c *conn, err error) {
+	if isBroken(err) {
+		c.close()
+		return
+	}
+
+	fs.mu.Lock()
+	if len(fs.idle) < fs.opts.MaxIdleConns {
+		fs.idle = append(fs.idle, c)
+		c = nil
+	}
+	fs.mu.Unlock()
+
+	if c != nil {
+		c.close()
+	}
+}
+
+// with calls the given function with a connection of the pool. If an idle
+// connection turns out to be broken, eg.: closed by the server after a
+// timeout, the function is called again with a new connection.
+func (fs *Redis) with(fn func(c *conn) error) error {
+	for {
+		c, reused, err := fs.acquire()
+		if err != nil {
+			return err
+		}
+
+		err = fn(c)
+		fs.release(c, err)
+		if reused && isBroken(err) {
+			continue
+		}
+
+		return err
+	}
+}
+
+// do sends the given command, returning its reply.
+func (fs *Redis) do(args ...interface{}) (reply interface{}, err error) {
+	err = fs.with(func(c *conn) error {
+		reply, err = c.do(args...)
+		return err
+	})
+
+	return reply, err
+}
+
+// transaction sends the given commands in a MULTI/EXEC block.
+func (fs *Redis) transaction(cmds ...[]interface{}) (replies []interface{}, err error) {
+	err = fs.with(func(c *conn) error {
+		replies, err = c.transaction(cmds...)
+		return err
+	})
+
+	return replies, err
+}
+
+// isBroken returns true if the given error isn't a reply of the server.
+func isBroken(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	_, ok := err.(Error)
+	return !ok
+}
+
+// Close closes the idle connections of the pool.
+func (fs *Redis) Close() error {
+	fs.mu.Lock()
+	idle := fs.idle
+	fs.idle = nil
+	fs.mu.Unlock()
+
+	var err error
+	for _, c := range idle {
+		if cerr := c.close(); err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+func (fs *Redis) nodeKey(p string) string {
+	return fs.opts.Prefix + "n:" + p
+}
+
+func (fs *Redis) dirKey(p string) string {
+	return fs.opts.Prefix + "d:" + p
+}
+
+func (fs *Redis) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Redis) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file. The content is read on the first access,
+// and kept in memory until closed, when it's written if modified. The new
+// files are created right away, so they can be found by other clients.
+func (fs *Redis) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	p, n, err := fs.follow(clean(filename))
+	switch {
+	case os.IsNotExist(err) && flag&os.O_CREATE != 0:
+		// p is the target of the last link, even when it's dangling, so
+		// the target is created.
+		n = &node{mode: perm.Perm(), ttl: fs.opts.TTL}
+		if err := fs.create(p, n, nil); err != nil {
+			return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+		}
+
+		f := newFile(fs, pathutil.Relative(filename), p, flag, n)
+		f.Loaded = true
+		return f, nil
+	case err != nil:
+		return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+	case flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrExist}
+	case n.mode.IsDir():
+		return nil, &os.PathError{Op: "open", Path: filename, Err: errors.New("is a directory")}
+	}
+
+	f := newFile(fs, pathutil.Relative(filename), p, flag, n)
+	if isWrite(flag) && flag&os.O_TRUNC != 0 {
+		if err := fs.store(p, nil); err != nil {
+			return nil, err
+		}
+
+		f.Loaded = true
+	}
+
+	return f, nil
+}
+
+// create stores a new file or symlink, creating its parents. It fails with
+// os.ErrExist if it already exists, even if created by other client in the
+// meantime.
+func (fs *Redis) create(p string, n *node, content []byte) error {
+	dir, name := path.Split(p)
+	if err := fs.mkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	key := fs.nodeKey(p)
+	reply, err := fs.do("HSETNX", key, "mode", int64(n.mode))
+	if err != nil {
+		return err
+	}
+
+	if reply != int64(1) {
+		return os.ErrExist
+	}
+
+	n.modTime, n.size = time.Now(), int64(len(content))
+	cmds := [][]interface{}{
+		append([]interface{}{"HSET", key}, n.fields(content)...),
+		{"SADD", fs.dirKey(clean(dir)), name},
+	}
+
+	if n.ttl > 0 {
+		cmds = append(cmds, []interface{}{"PEXPIRE", key, int64(n.ttl / time.Millisecond)})
+	}
+
+	_, err = fs.transaction(cmds...)
+	return err
+}
+
+// store writes the content of a file. If the file was removed, or replaced
+// by a directory, while open, the content is discarded. The time to live of
+// the file starts again.
+func (fs *Redis) store(p string, content []byte) error {
+	n, err := fs.get(p)
+	if err != nil || n == nil || n.mode.IsDir() {
+		return err
+	}
+
+	n.modTime, n.size = time.Now(), int64(len(content))
+	key := fs.nodeKey(p)
+	cmds := [][]interface{}{
+		append([]interface{}{"HSET", key}, n.fields(content)...),
+	}
+
+	if n.ttl > 0 {
+		cmds = append(cmds, []interface{}{"PEXPIRE", key, int64(n.ttl / time.Millisecond)})
+	}
+
+	_, err = fs.transaction(cmds...)
+	return err
+}
+
+// load returns the content of a file, or the target of a symlink.
+func (fs *Redis) load(p string) ([]byte, error) {
+	reply, err := fs.do("HGET", fs.nodeKey(p), "data")
+	if err != nil {
+		return nil, err
+	}
+
+	if reply == nil {
+		return nil, os.ErrNotExist
+	}
+
+	content, ok := reply.([]byte)
+	if !ok {
+		return nil, errProtocol
+	}
+
+	return content, nil
+}
+
+// get returns the node of the given path, or nil if it doesn't exist.
+func (fs *Redis) get(p string) (*node, error) {
+	if p == "/" {
+		return &node{mode: os.ModeDir | 0755}, nil
+	}
+
+	reply, err := fs.do("HMGET", fs.nodeKey(p), nodeFields[0], nodeFields[1], nodeFields[2], nodeFields[3])
+	if err != nil {
+		return nil, err
+	}
+
+	return parseNode(reply)
+}
+
+// lookup returns the node in the hash of the given path, failing with
+// os.ErrNotExist if the hash doesn't exist.
+func (fs *Redis) lookup(p string) (*node, error) {
+	n, err := fs.get(p)
+	if err == nil && n == nil {
+		err = os.ErrNotExist
+	}
+
+	return n, err
+}
+
+// follow returns the node of the given path, following the links with a
+// request to Redis for each one. The path of the last target is returned even
+// if it doesn't exist.
+func (fs *Redis) follow(p string) (string, *node, error) {
+	var n *node
+	isLink := func(p string) (link bool, err error) {
+		n, err = fs.lookup(p)
+		return err == nil && n.mode&os.ModeSymlink != 0, err
+	}
+
+	p, err := linkutil.Follow(p, clean, isLink, func(p string) (string, error) {
+		target, err := fs.load(p)
+		return string(target), err
+	})
+	if err != nil {
+		return p, nil, err
+	}
+
+	return p, n, nil
+}
+
+func (fs *Redis) Stat(filename string) (os.FileInfo, error) {
+	_, n, err := fs.follow(clean(filename))
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: filename, Err: err}
+	}
+
+	// the name is the one of the stated file, even if it's a link.
+	return newFileInfo(filepath.Base(filename), n), nil
+}
+
+func (fs *Redis) Lstat(filename string) (os.FileInfo, error) {
+	n, err := fs.lookup(clean(filename))
+	if err != nil {
+		return nil, &os.PathError{Op: "lstat", Path: filename, Err: err}
+	}
+
+	return newFileInfo(filepath.Base(filename), n), nil
+}
+
+func (fs *Redis) ReadDir(filename string) ([]os.FileInfo, error) {
+	infos, err := fs.readDir(clean(filename))
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: filename, Err: err}
+	}
+
+	return infos, nil
+}
+
+// readDir returns the children of the given directory, sorted by name. The
+// names of the expired files are removed from the set of the directory.
+func (fs *Redis) readDir(p string) ([]os.FileInfo, error) {
+	p, n, err := fs.follow(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if !n.mode.IsDir() {
+		return nil, errNotDir
+	}
+
+	reply, err := fs.do("SMEMBERS", fs.dirKey(p))
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := parseStrings(reply)
+	if err != nil || len(names) == 0 {
+		return nil, err
+	}
+
+	sort.Strings(names)
+	cmds := make([][]interface{}, len(names))
+	for i, name := range names {
+		cmds[i] = []interface{}{"HMGET", fs.nodeKey(path.Join(p, name)),
+			nodeFields[0], nodeFields[1], nodeFields[2], nodeFields[3]}
+	}
+
+	replies, err := fs.transaction(cmds...)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []os.FileInfo
+	expired := []interface{}{"SREM", fs.dirKey(p)}
+	for i, reply := range replies {
+		n, err := parseNode(reply)
+		if err != nil {
+			return nil, err
+		}
+
+		if n == nil {
+			expired = append(expired, names[i])
+			continue
+		}
+
+		infos = append(infos, newFileInfo(names[i], n))
+	}
+
+	if len(expired) > 2 {
+		if _, err := fs.do(expired...); err != nil {
+			return nil, err
+		}
+	}
+
+	return infos, nil
+}
+
+func (fs *Redis) MkdirAll(filename string, perm os.FileMode) error {
+	if err := fs.mkdirAll(clean(filename), perm); err != nil {
+		return &os.PathError{Op: "mkdir", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Redis) mkdirAll(p string, perm os.FileMode) error {
+	var dir string
+	for _, name := range strings.Split(strings.Trim(p, "/"), "/") {
+		if name == "" {
+			continue
+		}
+
+		parent := dir
+		dir = dir + "/" + name
+
+		_, n, err := fs.follow(dir)
+		if err == nil {
+			if !n.mode.IsDir() {
+				return errNotDir
+			}
+
+			continue
+		}
+
+		if !os.IsNotExist(err) {
+			return err
+		}
+
+		n = &node{mode: os.ModeDir | perm.Perm(), modTime: time.Now()}
+		_, err = fs.transaction(
+			append([]interface{}{"HSET", fs.nodeKey(dir)}, n.fields(nil)...),
+			[]interface{}{"SADD", fs.dirKey(clean(parent)), name},
+		)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (fs *Redis) Remove(filename string) error {
+	if err := fs.remove(clean(filename)); err != nil {
+		return &os.PathError{Op: "remove", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Redis) remove(p string) error {
+	if p == "/" {
+		return errors.New("cannot remove the root")
+	}
+
+	n, err := fs.lookup(p)
+	if err != nil {
+		return err
+	}
+
+	if n.mode.IsDir() {
+		infos, err := fs.readDir(p)
+		if err != nil {
+			return err
+		}
+
+		if len(infos) != 0 {
+			return ErrNotEmpty
+		}
+	}
+
+	dir, name := path.Split(p)
+	_, err = fs.transaction(
+		[]interface{}{"DEL", fs.nodeKey(p), fs.dirKey(p)},
+		[]interface{}{"SREM", fs.dirKey(clean(dir)), name},
+	)
+
+	return err
+}
+
+// Rename moves the given file, or directory with all its content, in a
+// single transaction. The time to live of the files is kept.
+func (fs *Redis) Rename(from, to string) error {
+	if err := fs.rename(clean(from), clean(to)); err != nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Redis) rename(from, to string) error {
+	if from == "/" || to == "/" {
+		return errors.New("cannot rename the root")
+	}
+
+	src, err := fs.lookup(from)
+	if err != nil {
+		return err
+	}
+
+	if from == to {
+		return nil
+	}
+
+	if strings.HasPrefix(to, from+"/") {
+		return errors.New("cannot move a directory into itself")
+	}
+
+	dst, err := fs.get(to)
+	if err != nil {
+		return err
+	}
+
+	var cmds [][]interface{}
+	if dst != nil {
+		if dst.mode.IsDir() != src.mode.IsDir() {
+			return os.ErrExist
+		}
+
+		if dst.mode.IsDir() {
+			infos, err := fs.readDir(to)
+			if err != nil {
+				return err
+			}
+
+			if len(infos) != 0 {
+				return ErrNotEmpty
+			}
+		}
+
+		cmds = append(cmds, []interface{}{"DEL", fs.nodeKey(to), fs.dirKey(to)})
+	}
+
+	toDir, toName := path.Split(to)
+	if err := fs.mkdirAll(toDir, 0755); err != nil {
+		return err
+	}
+
+	moves, err := fs.moves(from, to, src.mode.IsDir())
+	if err != nil {
+		return err
+	}
+
+	fromDir, fromName := path.Split(from)
+	cmds = append(cmds, moves...)
+	cmds = append(cmds,
+		[]interface{}{"SREM", fs.dirKey(clean(fromDir)), fromName},
+		[]interface{}{"SADD", fs.dirKey(clean(toDir)), toName},
+	)
+
+	_, err = fs.transaction(cmds...)
+	return err
+}
+
+// moves returns the commands moving the given node, and all its content if
+// it's a directory, to the new path.
+func (fs *Redis) moves(from, to string, dir bool) ([][]interface{}, error) {
+	cmds := [][]interface{}{{"RENAME", fs.nodeKey(from), fs.nodeKey(to)}}
+	if !dir {
+		return cmds, nil
+	}
+
+	infos, err := fs.readDir(from)
+	if err != nil {
+		return nil, err
+	}
+
+	cmds = append(cmds, []interface{}{"DEL", fs.dirKey(from)})
+	if len(infos) == 0 {
+		return cmds, nil
+	}
+
+	children := []interface{}{"SADD", fs.dirKey(to)}
+	for _, fi := range infos {
+		children = append(children, fi.Name())
+		childCmds, err := fs.moves(path.Join(from, fi.Name()), path.Join(to, fi.Name()), fi.IsDir())
+		if err != nil {
+			return nil, err
+		}
+
+		cmds = append(cmds, childCmds...)
+	}
+
+	return append(cmds, children), nil
+}
+
+func (fs *Redis) Symlink(target, link string) error {
+	p := clean(link)
+	n, err := fs.get(p)
+	if err == nil && n != nil {
+		err = os.ErrExist
+	}
+
+	if err == nil {
+		n = &node{mode: os.ModeSymlink | 0777, ttl: fs.opts.TTL}
+		err = fs.create(p, n, []byte(target))
+	}
+
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Redis) Readlink(link string) (string, error) {
+	p := clean(link)
+	n, err := fs.lookup(p)
+	if err == nil && n.mode&os.ModeSymlink == 0 {
+		err = errors.New("not a symlink")
+	}
+
+	var target []byte
+	if err == nil {
+		target, err = fs.load(p)
+	}
+
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: link, Err: err}
+	}
+
+	return string(target), nil
+}
+
+// SetTTL sets the time to live of the given file or symlink, after which
+// it's removed, it's started again every time the file is written. Zero
+// means the file never expires.
+func (fs *Redis) SetTTL(filename string, ttl time.Duration) error {
+	p := clean(filename)
+	n, err := fs.lookup(p)
+	if err == nil && n.mode.IsDir() {
+		err = errors.New("directories can't expire")
+	}
+
+	if err != nil {
+		return &os.PathError{Op: "setttl", Path: filename, Err: err}
+	}
+
+	key := fs.nodeKey(p)
+	expire := []interface{}{"PERSIST", key}
+	if ttl > 0 {
+		expire = []interface{}{"PEXPIRE", key, int64(ttl / time.Millisecond)}
+	}
+
+	_, err = fs.transaction(
+		[]interface{}{"HSET", key, "ttl", int64(ttl / time.Millisecond)},
+		expire,
+	)
+
+	return err
+}
+
+// TTL returns the remaining time to live of the given file or symlink, or
+// zero if it never expires.
+func (fs *Redis) TTL(filename string) (time.Duration, error) {
+	p := clean(filename)
+	reply, err := fs.do("PTTL", fs.nodeKey(p))
+	if err != nil {
+		return 0, err
+	}
+
+	ms, ok := reply.(int64)
+	switch {
+	case !ok:
+		return 0, errProtocol
+	case ms == -2:
+		return 0, &os.PathError{Op: "ttl", Path: filename, Err: os.ErrNotExist}
+	case ms < 0:
+		return 0, nil
+	default:
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+}
+
+func (fs *Redis) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(fs, dir, prefix)
+}
+
+func (fs *Redis) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (fs *Redis) Chroot(p string) (billy.Filesystem, error) {
+	return chroot.New(fs, fs.Join(fs.Root(), p)), nil
+}
+
+func (fs *Redis) Root() string {
+	return string(filepath.Separator)
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Redis) Capabilities() billy.Capability {
+	return billy.WriteCapability | billy.ReadCapability |
+		billy.ReadAndWriteCapability | billy.SeekCapability |
+		billy.TruncateCapability
+}
+
+func clean(p string) string {
+	return path.Clean("/" + filepath.ToSlash(p))
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR) != 0
+}
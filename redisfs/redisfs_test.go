@@ -0,0 +1,153 @@
+package redisfs
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&RedisSuite{})
+
+type RedisSuite struct {
+	test.FilesystemSuite
+	server *server
+	redis  *Redis
+}
+
+func (s *RedisSuite) SetUpTest(c *C) {
+	var err error
+	s.server, err = newServer()
+	c.Assert(err, IsNil)
+
+	s.redis = s.newFS(c, Options{})
+	s.FilesystemSuite = test.NewFilesystemSuite(s.redis)
+}
+
+func (s *RedisSuite) TearDownTest(c *C) {
+	s.redis.Close()
+	s.server.Close()
+}
+
+func (s *RedisSuite) newFS(c *C, opts Options) *Redis {
+	fs, err := New(s.server.URL(), opts)
+	c.Assert(err, IsNil)
+	return fs
+}
+
+func (s *RedisSuite) TestNew(c *C) {
+	_, err := New("http://localhost", Options{})
+	c.Assert(err, ErrorMatches, "unsupported scheme.*")
+
+	_, err = New("redis://localhost/foo", Options{})
+	c.Assert(err, ErrorMatches, "invalid database.*")
+
+	fs, err := New("redis://:secret@localhost/2", Options{})
+	c.Assert(err, IsNil)
+	c.Assert(fs.addr, Equals, "localhost:6379")
+	c.Assert(fs.opts.Password, Equals, "secret")
+	c.Assert(fs.opts.DB, Equals, 2)
+}
+
+func (s *RedisSuite) TestAuth(c *C) {
+	s.server.setPassword("secret")
+
+	_, err := s.newFS(c, Options{}).Stat("foo")
+	c.Assert(err, ErrorMatches, ".*NOAUTH.*")
+
+	_, err = s.newFS(c, Options{Password: "wrong"}).Stat("foo")
+	c.Assert(err, ErrorMatches, ".*WRONGPASS.*")
+
+	_, err = s.newFS(c, Options{Password: "secret"}).Stat("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *RedisSuite) TestShared(c *C) {
+	other := s.newFS(c, Options{})
+	defer other.Close()
+
+	c.Assert(util.WriteFile(s.redis, "foo/bar", []byte("foo"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, other, "foo/bar"), Equals, "foo")
+
+	c.Assert(other.Rename("foo", "qux"), IsNil)
+	c.Assert(test.ReadFile(c, s.redis, "qux/bar"), Equals, "foo")
+}
+
+func (s *RedisSuite) TestPrefix(c *C) {
+	other := s.newFS(c, Options{Prefix: "other:"})
+	defer other.Close()
+
+	c.Assert(util.WriteFile(s.redis, "foo", []byte("foo"), 0644), IsNil)
+
+	_, err := other.Stat("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *RedisSuite) TestTTL(c *C) {
+	fs := s.newFS(c, Options{TTL: time.Minute})
+	defer fs.Close()
+
+	c.Assert(util.WriteFile(fs, "foo/bar", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(fs, "foo/qux", []byte("foo"), 0644), IsNil)
+	c.Assert(fs.SetTTL("foo/qux", 0), IsNil)
+
+	ttl, err := fs.TTL("foo/bar")
+	c.Assert(err, IsNil)
+	c.Assert(ttl, Equals, time.Minute)
+
+	s.server.advance(30 * time.Second)
+	c.Assert(util.WriteFile(fs, "foo/bar", []byte("bar"), 0644), IsNil)
+
+	ttl, err = fs.TTL("foo/bar")
+	c.Assert(err, IsNil)
+	c.Assert(ttl, Equals, time.Minute)
+
+	s.server.advance(2 * time.Minute)
+
+	_, err = fs.Stat("foo/bar")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	infos, err := fs.ReadDir("foo")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 1)
+	c.Assert(infos[0].Name(), Equals, "qux")
+	c.Assert(s.server.count("SREM"), Equals, 1)
+
+	ttl, err = fs.TTL("foo/qux")
+	c.Assert(err, IsNil)
+	c.Assert(ttl, Equals, time.Duration(0))
+
+	c.Assert(fs.SetTTL("foo", time.Minute), NotNil)
+}
+
+func (s *RedisSuite) TestTTLKeptOnRename(c *C) {
+	c.Assert(util.WriteFile(s.redis, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(s.redis.SetTTL("foo", time.Minute), IsNil)
+	c.Assert(s.redis.Rename("foo", "bar"), IsNil)
+
+	ttl, err := s.redis.TTL("bar")
+	c.Assert(err, IsNil)
+	c.Assert(ttl, Equals, time.Minute)
+}
+
+func (s *RedisSuite) TestRemoveNotEmpty(c *C) {
+	c.Assert(util.WriteFile(s.redis, "foo/bar", []byte("foo"), 0644), IsNil)
+
+	err := s.redis.Remove("foo")
+	c.Assert(err.(*os.PathError).Err, Equals, ErrNotEmpty)
+}
+
+func (s *RedisSuite) TestPool(c *C) {
+	for i := 0; i < 10; i++ {
+		_, err := s.redis.Stat("foo")
+		c.Assert(os.IsNotExist(err), Equals, true)
+	}
+
+	c.Assert(s.redis.idle, HasLen, 1)
+}
@@ -0,0 +1,370 @@
+package redisfs
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// server is a minimal in-memory Redis server, to test the client. It only
+// supports the hashes and sets, and the commands used by the filesystem.
+type server struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	password string
+	now      time.Time
+	keys     map[string]*value
+	commands []string
+}
+
+// value is the value of a key, either a hash or a set.
+type value struct {
+	hash    map[string]string
+	set     map[string]bool
+	expires time.Time
+}
+
+func newServer() (*server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &server{ln: ln, now: time.Now(), keys: make(map[string]*value)}
+	go s.serve()
+	return s, nil
+}
+
+func (s *server) URL() string {
+	return "redis://" + s.ln.Addr().String()
+}
+
+func (s *server) Close() error {
+	return s.ln.Close()
+}
+
+// setPassword sets the password required to the new sessions, empty if none.
+func (s *server) setPassword(password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.password = password
+}
+
+// auth returns whether password is the one required by the server.
+func (s *server) auth(password string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return password == s.password
+}
+
+// advance moves forward the clock of the server, expiring the keys.
+func (s *server) advance(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.now = s.now.Add(d)
+}
+
+func (s *server) count(command string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int
+	for _, c := range s.commands {
+		if c == command {
+			n++
+		}
+	}
+
+	return n
+}
+
+func (s *server) serve() {
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		go s.session(nc)
+	}
+}
+
+func (s *server) session(nc net.Conn) {
+	defer nc.Close()
+
+	r, w := bufio.NewReader(nc), bufio.NewWriter(nc)
+	authenticated := s.auth("")
+
+	var queue [][]string
+	var multi bool
+	for {
+		reply, err := readReply(r)
+		if err != nil {
+			return
+		}
+
+		args, ok := reply.([]interface{})
+		if !ok || len(args) == 0 {
+			return
+		}
+
+		cmd := make([]string, len(args))
+		for i, arg := range args {
+			cmd[i] = string(arg.([]byte))
+		}
+
+		cmd[0] = strings.ToUpper(cmd[0])
+		s.mu.Lock()
+		s.commands = append(s.commands, cmd[0])
+		s.mu.Unlock()
+
+		switch {
+		case cmd[0] == "AUTH":
+			authenticated = len(cmd) == 2 && s.auth(cmd[1])
+			if authenticated {
+				writeReply(w, "OK")
+			} else {
+				writeReply(w, Error("WRONGPASS invalid password"))
+			}
+		case !authenticated:
+			writeReply(w, Error("NOAUTH Authentication required."))
+		case cmd[0] == "MULTI":
+			multi, queue = true, nil
+			writeReply(w, "OK")
+		case cmd[0] == "EXEC":
+			replies := make([]interface{}, len(queue))
+			s.mu.Lock()
+			for i, cmd := range queue {
+				replies[i] = s.exec(cmd)
+			}
+			s.mu.Unlock()
+
+			multi = false
+			writeReply(w, replies)
+		case multi:
+			queue = append(queue, cmd)
+			writeReply(w, "QUEUED")
+		default:
+			s.mu.Lock()
+			reply := s.exec(cmd)
+			s.mu.Unlock()
+
+			writeReply(w, reply)
+		}
+
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// get returns the value of the given key, nil if it doesn't exist or it
+// expired.
+func (s *server) get(key string) *value {
+	v, ok := s.keys[key]
+	if !ok {
+		return nil
+	}
+
+	if !v.expires.IsZero() && !s.now.Before(v.expires) {
+		delete(s.keys, key)
+		return nil
+	}
+
+	return v
+}
+
+func (s *server) exec(cmd []string) interface{} {
+	args := cmd[1:]
+	switch cmd[0] {
+	case "PING":
+		return "PONG"
+	case "SELECT":
+		return "OK"
+	case "DEL":
+		var n int64
+		for _, key := range args {
+			if s.get(key) != nil {
+				delete(s.keys, key)
+				n++
+			}
+		}
+
+		return n
+	case "RENAME":
+		v := s.get(args[0])
+		if v == nil {
+			return Error("ERR no such key")
+		}
+
+		delete(s.keys, args[0])
+		s.keys[args[1]] = v
+		return "OK"
+	case "PEXPIRE":
+		v := s.get(args[0])
+		if v == nil {
+			return int64(0)
+		}
+
+		ms, _ := strconv.ParseInt(args[1], 10, 64)
+		v.expires = s.now.Add(time.Duration(ms) * time.Millisecond)
+		return int64(1)
+	case "PERSIST":
+		v := s.get(args[0])
+		if v == nil || v.expires.IsZero() {
+			return int64(0)
+		}
+
+		v.expires = time.Time{}
+		return int64(1)
+	case "PTTL":
+		v := s.get(args[0])
+		switch {
+		case v == nil:
+			return int64(-2)
+		case v.expires.IsZero():
+			return int64(-1)
+		default:
+			return int64(v.expires.Sub(s.now) / time.Millisecond)
+		}
+	case "HSET", "HSETNX":
+		v := s.get(args[0])
+		if v == nil {
+			v = &value{hash: make(map[string]string)}
+			s.keys[args[0]] = v
+		}
+
+		if v.hash == nil {
+			return wrongType
+		}
+
+		var n int64
+		for i := 1; i+1 < len(args); i += 2 {
+			if _, ok := v.hash[args[i]]; !ok {
+				n++
+			} else if cmd[0] == "HSETNX" {
+				continue
+			}
+
+			v.hash[args[i]] = args[i+1]
+		}
+
+		return n
+	case "HGET":
+		v := s.get(args[0])
+		if v == nil {
+			return nil
+		}
+
+		if v.hash == nil {
+			return wrongType
+		}
+
+		if field, ok := v.hash[args[1]]; ok {
+			return []byte(field)
+		}
+
+		return nil
+	case "HMGET":
+		v := s.get(args[0])
+		if v != nil && v.hash == nil {
+			return wrongType
+		}
+
+		replies := make([]interface{}, len(args)-1)
+		for i, field := range args[1:] {
+			if v == nil {
+				continue
+			}
+
+			if value, ok := v.hash[field]; ok {
+				replies[i] = []byte(value)
+			}
+		}
+
+		return replies
+	case "SADD", "SREM":
+		v := s.get(args[0])
+		if v == nil {
+			v = &value{set: make(map[string]bool)}
+			s.keys[args[0]] = v
+		}
+
+		if v.set == nil {
+			return wrongType
+		}
+
+		var n int64
+		for _, member := range args[1:] {
+			if v.set[member] == (cmd[0] == "SREM") {
+				n++
+			}
+
+			if cmd[0] == "SADD" {
+				v.set[member] = true
+			} else {
+				delete(v.set, member)
+			}
+		}
+
+		if len(v.set) == 0 {
+			// as in Redis, empty sets don't exist.
+			delete(s.keys, args[0])
+		}
+
+		return n
+	case "SMEMBERS":
+		v := s.get(args[0])
+		if v != nil && v.set == nil {
+			return wrongType
+		}
+
+		var members []string
+		if v != nil {
+			for member := range v.set {
+				members = append(members, member)
+			}
+		}
+
+		sort.Strings(members)
+		replies := make([]interface{}, len(members))
+		for i, member := range members {
+			replies[i] = []byte(member)
+		}
+
+		return replies
+	default:
+		return Error(fmt.Sprintf("ERR unknown command '%s'", cmd[0]))
+	}
+}
+
+var wrongType = Error("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+func writeReply(w *bufio.Writer, reply interface{}) {
+	switch v := reply.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case string:
+		fmt.Fprintf(w, "+%s\r\n", v)
+	case Error:
+		fmt.Fprintf(w, "-%s\r\n", v)
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case []byte:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case []interface{}:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, r := range v {
+			writeReply(w, r)
+		}
+	}
+}
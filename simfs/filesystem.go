@@ -0,0 +1,259 @@
+package simfs
+
+import (
+	"os"
+	"path/filepath"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+)
+
+// Filesystem is the billy.Filesystem given to every actor, all its operations
+// and the operations of its files are scheduled by the Simulation.
+type Filesystem struct {
+	sim   *Simulation
+	actor *actor
+}
+
+func (fs *Filesystem) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Filesystem) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (fs *Filesystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	var f billy.File
+	err := fs.sim.do(fs.actor, "OpenFile", filename, func() error {
+		var err error
+		f, err = fs.sim.fs.OpenFile(filename, flag, perm)
+		if err == nil && flag&(os.O_CREATE|os.O_TRUNC) != 0 {
+			fs.sim.touch(filename)
+		}
+
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &file{File: f, fs: fs, path: filename}, nil
+}
+
+func (fs *Filesystem) Stat(filename string) (os.FileInfo, error) {
+	var fi os.FileInfo
+	err := fs.sim.do(fs.actor, "Stat", filename, func() error {
+		var err error
+		fi, err = fs.sim.fs.Stat(filename)
+		fi = fs.sim.fileInfo(filepath.Dir(filename), fi)
+		return err
+	})
+
+	return fi, err
+}
+
+func (fs *Filesystem) Rename(from, to string) error {
+	return fs.sim.do(fs.actor, "Rename", from, func() error {
+		err := fs.sim.fs.Rename(from, to)
+		if err == nil {
+			fs.sim.touch(to)
+		}
+
+		return err
+	})
+}
+
+func (fs *Filesystem) Remove(filename string) error {
+	return fs.sim.do(fs.actor, "Remove", filename, func() error {
+		return fs.sim.fs.Remove(filename)
+	})
+}
+
+func (fs *Filesystem) Join(elem ...string) string {
+	return fs.sim.fs.Join(elem...)
+}
+
+func (fs *Filesystem) TempFile(dir, prefix string) (billy.File, error) {
+	var f billy.File
+	err := fs.sim.do(fs.actor, "TempFile", dir, func() error {
+		var err error
+		f, err = fs.sim.fs.TempFile(dir, prefix)
+		if err == nil {
+			fs.sim.touch(f.Name())
+		}
+
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &file{File: f, fs: fs, path: f.Name()}, nil
+}
+
+func (fs *Filesystem) ReadDir(path string) ([]os.FileInfo, error) {
+	var entries []os.FileInfo
+	err := fs.sim.do(fs.actor, "ReadDir", path, func() error {
+		var err error
+		entries, err = fs.sim.fs.ReadDir(path)
+		for i, fi := range entries {
+			entries[i] = fs.sim.fileInfo(path, fi)
+		}
+
+		return err
+	})
+
+	return entries, err
+}
+
+func (fs *Filesystem) MkdirAll(filename string, perm os.FileMode) error {
+	return fs.sim.do(fs.actor, "MkdirAll", filename, func() error {
+		err := fs.sim.fs.MkdirAll(filename, perm)
+		if err == nil {
+			fs.sim.touch(filename)
+		}
+
+		return err
+	})
+}
+
+func (fs *Filesystem) Lstat(filename string) (os.FileInfo, error) {
+	var fi os.FileInfo
+	err := fs.sim.do(fs.actor, "Lstat", filename, func() error {
+		var err error
+		fi, err = fs.sim.fs.Lstat(filename)
+		fi = fs.sim.fileInfo(filepath.Dir(filename), fi)
+		return err
+	})
+
+	return fi, err
+}
+
+func (fs *Filesystem) Symlink(target, link string) error {
+	return fs.sim.do(fs.actor, "Symlink", link, func() error {
+		err := fs.sim.fs.Symlink(target, link)
+		if err == nil {
+			fs.sim.touch(link)
+		}
+
+		return err
+	})
+}
+
+func (fs *Filesystem) Readlink(link string) (string, error) {
+	var target string
+	err := fs.sim.do(fs.actor, "Readlink", link, func() error {
+		var err error
+		target, err = fs.sim.fs.Readlink(link)
+		return err
+	})
+
+	return target, err
+}
+
+// Chroot returns a new filesystem, scheduled as the same actor, where the new
+// root is the given path.
+func (fs *Filesystem) Chroot(path string) (billy.Filesystem, error) {
+	return chroot.New(fs, fs.Join(fs.Root(), path)), nil
+}
+
+func (fs *Filesystem) Root() string {
+	return string(filepath.Separator)
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Filesystem) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.sim.fs)
+}
+
+type file struct {
+	billy.File
+	fs   *Filesystem
+	path string
+}
+
+func (f *file) do(name string, fn func() error) error {
+	return f.fs.sim.do(f.fs.actor, "File."+name, f.path, fn)
+}
+
+func (f *file) Read(p []byte) (n int, err error) {
+	derr := f.do("Read", func() error {
+		n, err = f.File.Read(p)
+		return nil
+	})
+
+	if derr != nil {
+		return 0, derr
+	}
+
+	return
+}
+
+func (f *file) ReadAt(p []byte, off int64) (n int, err error) {
+	derr := f.do("ReadAt", func() error {
+		n, err = f.File.ReadAt(p, off)
+		return nil
+	})
+
+	if derr != nil {
+		return 0, derr
+	}
+
+	return
+}
+
+func (f *file) Write(p []byte) (n int, err error) {
+	derr := f.do("Write", func() error {
+		n, err = f.File.Write(p)
+		if n > 0 {
+			f.fs.sim.touch(f.path)
+		}
+
+		return nil
+	})
+
+	if derr != nil {
+		return 0, derr
+	}
+
+	return
+}
+
+func (f *file) Seek(offset int64, whence int) (n int64, err error) {
+	derr := f.do("Seek", func() error {
+		n, err = f.File.Seek(offset, whence)
+		return nil
+	})
+
+	if derr != nil {
+		return 0, derr
+	}
+
+	return
+}
+
+func (f *file) Truncate(size int64) error {
+	return f.do("Truncate", func() error {
+		err := f.File.Truncate(size)
+		if err == nil {
+			f.fs.sim.touch(f.path)
+		}
+
+		return err
+	})
+}
+
+func (f *file) Close() error {
+	return f.do("Close", f.File.Close)
+}
+
+func (f *file) Lock() error {
+	return f.do("Lock", f.File.Lock)
+}
+
+func (f *file) Unlock() error {
+	return f.do("Unlock", f.File.Unlock)
+}
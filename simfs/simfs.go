@@ -0,0 +1,314 @@
+// Package simfs provides a deterministic simulation filesystem, where every
+// operation is held until the test advances a virtual clock. It allows to run
+// reproducible interleaving tests over code sharing a billy filesystem from
+// many goroutines.
+package simfs // import "gopkg.in/src-d/go-billy.v4/simfs"
+
+import (
+	"errors"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+)
+
+var (
+	// ErrStopped is returned by any operation issued after the simulation
+	// was stopped.
+	ErrStopped = errors.New("simulation stopped")
+)
+
+// Epoch is the initial time of the virtual clock.
+var Epoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Tick is the amount of virtual time elapsed on every step.
+const Tick = time.Millisecond
+
+// Op describes an operation executed by the simulation.
+type Op struct {
+	// Actor is the name of the actor that issued the operation.
+	Actor string
+	// Name is the name of the operation, eg.: "Create" or "File.Write".
+	Name string
+	// Path is the path of the file involved in the operation.
+	Path string
+	// Time is the virtual time when the operation was executed.
+	Time time.Time
+}
+
+// Scheduler chooses the next operation to execute between the pending ones.
+// The pending operations are always sorted by actor name, so any Scheduler
+// without other source of entropy is deterministic.
+type Scheduler interface {
+	// Next returns the index of the next operation to execute.
+	Next(pending []Op) int
+}
+
+// RandomScheduler returns a Scheduler that picks a pseudo-random pending
+// operation, based on the given seed.
+func RandomScheduler(seed int64) Scheduler {
+	return &randomScheduler{r: rand.New(rand.NewSource(seed))}
+}
+
+type randomScheduler struct {
+	r *rand.Rand
+}
+
+func (s *randomScheduler) Next(pending []Op) int {
+	return s.r.Intn(len(pending))
+}
+
+// FIFOScheduler is a Scheduler that always picks the first pending operation
+// in actor name order.
+var FIFOScheduler Scheduler = fifoScheduler{}
+
+type fifoScheduler struct{}
+
+func (fifoScheduler) Next(pending []Op) int {
+	return 0
+}
+
+// Simulation holds the state of the simulated filesystem, its actors and its
+// virtual clock.
+type Simulation struct {
+	fs    billy.Filesystem
+	sched Scheduler
+
+	m       sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	mtimes  map[string]time.Time
+	actors  map[string]*actor
+	trace   []Op
+	stopped bool
+}
+
+type actor struct {
+	name    string
+	pending *request
+	done    bool
+}
+
+type request struct {
+	op    Op
+	grant chan struct{}
+}
+
+// New returns a new Simulation backed by an in-memory filesystem, using the
+// given scheduler to choose the order of the operations.
+func New(sched Scheduler) *Simulation {
+	return NewWithFilesystem(memfs.New(), sched)
+}
+
+// NewWithFilesystem returns a new Simulation where the operations, once
+// scheduled, are executed against the given filesystem.
+func NewWithFilesystem(fs billy.Filesystem, sched Scheduler) *Simulation {
+	s := &Simulation{
+		fs:     fs,
+		sched:  sched,
+		now:    Epoch,
+		mtimes: make(map[string]time.Time),
+		actors: make(map[string]*actor),
+	}
+
+	s.cond = sync.NewCond(&s.m)
+	return s
+}
+
+// Go starts a new actor called name, running fn in its own goroutine. The
+// filesystem given to fn blocks every operation until it is chosen by the
+// scheduler on a call to Step. Actor names should be unique.
+func (s *Simulation) Go(name string, fn func(fs billy.Filesystem)) {
+	s.m.Lock()
+	a := &actor{name: name}
+	s.actors[name] = a
+	s.m.Unlock()
+
+	go func() {
+		defer func() {
+			s.m.Lock()
+			a.done = true
+			s.cond.Broadcast()
+			s.m.Unlock()
+		}()
+
+		fn(&Filesystem{sim: s, actor: a})
+	}()
+}
+
+// Now returns the current time of the virtual clock.
+func (s *Simulation) Now() time.Time {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.now
+}
+
+// Trace returns the operations executed so far, in execution order.
+func (s *Simulation) Trace() []Op {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	trace := make([]Op, len(s.trace))
+	copy(trace, s.trace)
+	return trace
+}
+
+// Step waits until every running actor has issued an operation, executes one
+// of them chosen by the scheduler and advances the virtual clock one Tick. It
+// returns false when all the actors are done, or the simulation was stopped.
+//
+// An actor blocked outside of the filesystem (eg.: waiting on a channel
+// written by another actor) blocks Step as well, since the interleaving is
+// only controlled at the filesystem level.
+func (s *Simulation) Step() (Op, bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	pending := s.waitQuiescent()
+	if len(pending) == 0 || s.stopped {
+		return Op{}, false
+	}
+
+	ops := make([]Op, len(pending))
+	for i, r := range pending {
+		ops[i] = r.op
+	}
+
+	r := pending[s.sched.Next(ops)]
+	s.now = s.now.Add(Tick)
+	r.op.Time = s.now
+	s.trace = append(s.trace, r.op)
+	s.actors[r.op.Actor].pending = nil
+	close(r.grant)
+
+	return r.op, true
+}
+
+// Run steps the simulation until all the actors are done, and returns the
+// number of executed operations.
+func (s *Simulation) Run() int {
+	var n int
+	for {
+		if _, ok := s.Step(); !ok {
+			return n
+		}
+
+		n++
+	}
+}
+
+// Stop aborts the simulation, every pending and future operation returns
+// ErrStopped.
+func (s *Simulation) Stop() {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.stopped = true
+	for _, a := range s.actors {
+		if a.pending != nil {
+			close(a.pending.grant)
+			a.pending = nil
+		}
+	}
+
+	s.cond.Broadcast()
+}
+
+// waitQuiescent blocks until every actor is either done or waiting for an
+// operation to be granted. Must be called with the lock held.
+func (s *Simulation) waitQuiescent() (pending []*request) {
+	for {
+		pending = pending[:0]
+		quiescent := true
+		for _, a := range s.actors {
+			if a.done {
+				continue
+			}
+
+			if a.pending == nil {
+				quiescent = false
+				continue
+			}
+
+			pending = append(pending, a.pending)
+		}
+
+		if quiescent || s.stopped {
+			break
+		}
+
+		s.cond.Wait()
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].op.Actor < pending[j].op.Actor
+	})
+
+	return pending
+}
+
+// do blocks until the operation is scheduled, and then executes fn.
+func (s *Simulation) do(a *actor, name, path string, fn func() error) error {
+	r := &request{
+		op:    Op{Actor: a.name, Name: name, Path: path},
+		grant: make(chan struct{}),
+	}
+
+	s.m.Lock()
+	if s.stopped {
+		s.m.Unlock()
+		return ErrStopped
+	}
+
+	a.pending = r
+	s.cond.Broadcast()
+	s.m.Unlock()
+
+	<-r.grant
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.stopped {
+		return ErrStopped
+	}
+
+	// the lock is kept during the execution, this way the operation is
+	// finished before the actor is considered running again.
+	return fn()
+}
+
+func (s *Simulation) touch(path string) {
+	s.mtimes[s.fs.Join("/", path)] = s.now
+}
+
+func (s *Simulation) mtime(path string) (time.Time, bool) {
+	t, ok := s.mtimes[s.fs.Join("/", path)]
+	return t, ok
+}
+
+func (s *Simulation) fileInfo(dir string, fi os.FileInfo) os.FileInfo {
+	if fi == nil {
+		return nil
+	}
+
+	t, ok := s.mtime(s.fs.Join(dir, fi.Name()))
+	if !ok {
+		t = Epoch
+	}
+
+	return &fileInfo{FileInfo: fi, modTime: t}
+}
+
+type fileInfo struct {
+	os.FileInfo
+	modTime time.Time
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.modTime
+}
@@ -0,0 +1,115 @@
+package simfs
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&SimulationSuite{})
+
+type SimulationSuite struct{}
+
+func writers(sim *Simulation) {
+	for _, name := range []string{"a", "b", "c"} {
+		name := name
+		sim.Go(name, func(fs billy.Filesystem) {
+			for i := 0; i < 3; i++ {
+				f, err := fs.OpenFile("log", os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+				if err != nil {
+					return
+				}
+
+				fmt.Fprintf(f, "%s%d\n", name, i)
+				f.Close()
+			}
+		})
+	}
+}
+
+func (s *SimulationSuite) TestDeterministic(c *C) {
+	var traces [][]Op
+	var contents []string
+	for i := 0; i < 2; i++ {
+		sim := New(RandomScheduler(42))
+		writers(sim)
+		c.Assert(sim.Run(), Equals, 27)
+
+		traces = append(traces, sim.Trace())
+		contents = append(contents, test.ReadFile(c, sim.fs, "log"))
+	}
+
+	c.Assert(traces[0], DeepEquals, traces[1])
+	c.Assert(contents[0], Equals, contents[1])
+}
+
+func (s *SimulationSuite) TestFIFOScheduler(c *C) {
+	sim := New(FIFOScheduler)
+	writers(sim)
+	sim.Run()
+
+	c.Assert(test.ReadFile(c, sim.fs, "log"), Equals, "a0\na1\na2\nb0\nb1\nb2\nc0\nc1\nc2\n")
+}
+
+func (s *SimulationSuite) TestStep(c *C) {
+	sim := New(FIFOScheduler)
+	sim.Go("a", func(fs billy.Filesystem) {
+		util.WriteFile(fs, "foo", []byte("foo"), 0644)
+	})
+
+	op, ok := sim.Step()
+	c.Assert(ok, Equals, true)
+	c.Assert(op, DeepEquals, Op{
+		Actor: "a", Name: "OpenFile", Path: "foo", Time: Epoch.Add(Tick),
+	})
+
+	op, ok = sim.Step()
+	c.Assert(ok, Equals, true)
+	c.Assert(op.Name, Equals, "File.Write")
+
+	op, ok = sim.Step()
+	c.Assert(ok, Equals, true)
+	c.Assert(op.Name, Equals, "File.Close")
+	c.Assert(sim.Now(), Equals, Epoch.Add(3*Tick))
+
+	_, ok = sim.Step()
+	c.Assert(ok, Equals, false)
+}
+
+func (s *SimulationSuite) TestModTime(c *C) {
+	var fi os.FileInfo
+	var err error
+
+	sim := New(FIFOScheduler)
+	sim.Go("a", func(fs billy.Filesystem) {
+		util.WriteFile(fs, "foo", []byte("foo"), 0644)
+		fi, err = fs.Stat("foo")
+	})
+
+	c.Assert(sim.Run(), Equals, 4)
+	c.Assert(err, IsNil)
+	c.Assert(fi.ModTime(), Equals, Epoch.Add(2*Tick))
+}
+
+func (s *SimulationSuite) TestStop(c *C) {
+	sim := New(FIFOScheduler)
+	done := make(chan error)
+	sim.Go("a", func(fs billy.Filesystem) {
+		_, err := fs.Create("foo")
+		done <- err
+	})
+
+	sim.Stop()
+	c.Assert(<-done, Equals, ErrStopped)
+
+	_, ok := sim.Step()
+	c.Assert(ok, Equals, false)
+}
@@ -0,0 +1,63 @@
+package smbfs
+
+import (
+	"io"
+	"os"
+)
+
+// file is a file opened in a share, the errors returned hold the billy
+// name of the file.
+type file struct {
+	remoteFile
+	name string
+}
+
+func (f *file) Name() string {
+	return f.name
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	n, err := f.remoteFile.Read(p)
+	return n, f.wrap("read", err)
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.remoteFile.ReadAt(p, off)
+	return n, f.wrap("readat", err)
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	n, err := f.remoteFile.Write(p)
+	return n, f.wrap("write", err)
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	n, err := f.remoteFile.Seek(offset, whence)
+	return n, f.wrap("seek", err)
+}
+
+func (f *file) Truncate(size int64) error {
+	return f.wrap("truncate", f.remoteFile.Truncate(size))
+}
+
+func (f *file) Close() error {
+	return f.wrap("close", f.remoteFile.Close())
+}
+
+// Lock is a no-op in SMB.
+func (f *file) Lock() error {
+	return nil
+}
+
+// Unlock is a no-op in SMB.
+func (f *file) Unlock() error {
+	return nil
+}
+
+func (f *file) wrap(op string, err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+
+	return &os.PathError{Op: op, Path: f.name, Err: underlying(err)}
+}
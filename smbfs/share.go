@@ -0,0 +1,166 @@
+package smbfs
+
+import (
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/hirochachacha/go-smb2"
+)
+
+const defaultPort = "445"
+
+// share is a mounted share, with the methods of smb2.Share used. The paths
+// are relative to the root of the share, separated by backslashes.
+type share interface {
+	OpenFile(name string, flag int, perm os.FileMode) (remoteFile, error)
+	Stat(name string) (os.FileInfo, error)
+	Lstat(name string) (os.FileInfo, error)
+	ReadDir(name string) ([]os.FileInfo, error)
+	Mkdir(name string, perm os.FileMode) error
+	Rename(from, to string) error
+	Remove(name string) error
+	Symlink(target, link string) error
+	Readlink(name string) (string, error)
+}
+
+// remoteFile is a file opened in a share, with the methods of smb2.File
+// used.
+type remoteFile interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.Seeker
+	io.Closer
+	Truncate(size int64) error
+}
+
+// mounter mounts the shares of the servers, the root share and the targets
+// of the referrals.
+type mounter interface {
+	mount(server, name string) (share, error)
+	Close() error
+}
+
+type smb2Share struct {
+	*smb2.Share
+}
+
+func (s smb2Share) OpenFile(name string, flag int, perm os.FileMode) (remoteFile, error) {
+	f, err := s.Share.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+type session struct {
+	conn    net.Conn
+	session *smb2.Session
+}
+
+// client is the mounter of the servers, it keeps a session by server,
+// shared by all the shares mounted from it.
+type client struct {
+	opts Options
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	shares   map[string]*smb2.Share
+}
+
+func newClient(opts Options) *client {
+	return &client{
+		opts:     opts,
+		sessions: make(map[string]*session),
+		shares:   make(map[string]*smb2.Share),
+	}
+}
+
+func (c *client) mount(server, name string) (share, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := strings.ToLower(server + `\` + name)
+	if s, ok := c.shares[key]; ok {
+		return smb2Share{s}, nil
+	}
+
+	sess, err := c.dial(server)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := sess.session.Mount(name)
+	if err != nil {
+		return nil, err
+	}
+
+	c.shares[key] = s
+	return smb2Share{s}, nil
+}
+
+// dial returns the session of the given server, connecting to it if needed.
+func (c *client) dial(server string) (*session, error) {
+	key := strings.ToLower(server)
+	if s, ok := c.sessions[key]; ok {
+		return s, nil
+	}
+
+	addr := server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		addr = net.JoinHostPort(server, defaultPort)
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, c.opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &smb2.Dialer{
+		Initiator: &smb2.NTLMInitiator{
+			User:     c.opts.User,
+			Password: c.opts.Password,
+			Domain:   c.opts.Domain,
+		},
+	}
+
+	s, err := d.Dial(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	sess := &session{conn: conn, session: s}
+	c.sessions[key] = sess
+	return sess, nil
+}
+
+// Close unmounts the shares and logs off the sessions.
+func (c *client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var err error
+	for key, s := range c.shares {
+		if e := s.Umount(); e != nil && err == nil {
+			err = e
+		}
+
+		delete(c.shares, key)
+	}
+
+	for key, s := range c.sessions {
+		if e := s.session.Logoff(); e != nil && err == nil {
+			err = e
+		}
+
+		s.conn.Close()
+		delete(c.sessions, key)
+	}
+
+	return err
+}
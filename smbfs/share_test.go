@@ -0,0 +1,185 @@
+package smbfs
+
+import (
+	"os"
+	"path"
+	"strings"
+
+	"github.com/hirochachacha/go-smb2"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+)
+
+// memShare is a share over a memfs, with the semantics of SMB: the parents
+// aren't created, the renames don't replace the files, and the errors are
+// os.PathError holding the paths of the share.
+type memShare struct {
+	fs billy.Filesystem
+	// notCovered is the prefix of the paths failing with
+	// STATUS_PATH_NOT_COVERED, as a DFS folder without a referral.
+	notCovered string
+}
+
+func newMemShare() *memShare {
+	fs := memfs.New()
+	fs.MkdirAll("/", 0755)
+	return &memShare{fs: fs}
+}
+
+func (s *memShare) path(op, name string) (string, error) {
+	p := "/" + strings.Replace(name, `\`, "/", -1)
+	if s.notCovered != "" && strings.HasPrefix(p, "/"+s.notCovered) {
+		return "", &os.PathError{Op: op, Path: name, Err: &smb2.ResponseError{Code: statusPathNotCovered}}
+	}
+
+	return p, nil
+}
+
+func (s *memShare) OpenFile(name string, flag int, perm os.FileMode) (remoteFile, error) {
+	p, err := s.path("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if fi, err := s.fs.Stat(p); err == nil && fi.IsDir() && flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: &smb2.ResponseError{Code: statusFileIsADirectory}}
+	}
+
+	if _, err := s.fs.Stat(path.Dir(p)); err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	f, err := s.fs.OpenFile(p, flag, perm)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: underlying(err)}
+	}
+
+	return f, nil
+}
+
+func (s *memShare) Stat(name string) (os.FileInfo, error) {
+	p, err := s.path("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.fs.Stat(p)
+}
+
+func (s *memShare) Lstat(name string) (os.FileInfo, error) {
+	p, err := s.path("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.fs.Lstat(p)
+}
+
+func (s *memShare) ReadDir(name string) ([]os.FileInfo, error) {
+	p, err := s.path("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.fs.ReadDir(p)
+}
+
+func (s *memShare) Mkdir(name string, perm os.FileMode) error {
+	p, err := s.path("mkdir", name)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.fs.Lstat(p); err == nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+
+	if _, err := s.fs.Stat(path.Dir(p)); err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrNotExist}
+	}
+
+	return s.fs.MkdirAll(p, perm)
+}
+
+func (s *memShare) Rename(from, to string) error {
+	fromPath, err := s.path("rename", from)
+	if err != nil {
+		return err
+	}
+
+	toPath, err := s.path("rename", to)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.fs.Lstat(toPath); err == nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: os.ErrExist}
+	}
+
+	return s.fs.Rename(fromPath, toPath)
+}
+
+func (s *memShare) Remove(name string) error {
+	p, err := s.path("remove", name)
+	if err != nil {
+		return err
+	}
+
+	if infos, _ := s.fs.ReadDir(p); len(infos) != 0 {
+		return &os.PathError{Op: "remove", Path: name, Err: &smb2.ResponseError{Code: statusDirectoryNotEmpty}}
+	}
+
+	return s.fs.Remove(p)
+}
+
+func (s *memShare) Symlink(target, link string) error {
+	p, err := s.path("symlink", link)
+	if err != nil {
+		return err
+	}
+
+	// the targets are kept with slashes, to be resolved by memfs.
+	return s.fs.Symlink(strings.Replace(target, `\`, "/", -1), p)
+}
+
+func (s *memShare) Readlink(name string) (string, error) {
+	p, err := s.path("readlink", name)
+	if err != nil {
+		return "", err
+	}
+
+	target, err := s.fs.Readlink(p)
+	return strings.Replace(target, "/", `\`, -1), err
+}
+
+// memMounter mounts memShares, by `server\share`.
+type memMounter struct {
+	shares map[string]*memShare
+	mounts []string
+	closed bool
+}
+
+func newMemMounter(names ...string) *memMounter {
+	m := &memMounter{shares: make(map[string]*memShare)}
+	for _, name := range names {
+		m.shares[name] = newMemShare()
+	}
+
+	return m
+}
+
+func (m *memMounter) mount(server, name string) (share, error) {
+	key := server + `\` + name
+	s, ok := m.shares[key]
+	if !ok {
+		return nil, &os.PathError{Op: "mount", Path: key, Err: os.ErrNotExist}
+	}
+
+	m.mounts = append(m.mounts, key)
+	return s, nil
+}
+
+func (m *memMounter) Close() error {
+	m.closed = true
+	return nil
+}
@@ -0,0 +1,523 @@
+// Package smbfs provides a billy filesystem over a SMB2/3 share, eg.: a
+// Windows or Samba network share, with a pure Go client.
+//
+// The billy paths are mapped to paths relative to the root of the share,
+// separated by backslashes, and the errors returned hold the billy paths.
+//
+// The shares of a DFS namespace are supported with referrals, redirecting
+// the folders of the namespace to the shares holding their files, eg.:
+// `\\fs1\projects`. The connections to the targets are established on the
+// first use, sharing a session by server. Paths not covered by the
+// referrals fail with ErrPathNotCovered.
+package smbfs // import "gopkg.in/src-d/go-billy.v4/smbfs"
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hirochachacha/go-smb2"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/internal/pathutil"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const (
+	defaultTimeout = 30 * time.Second
+
+	// The NTSTATUS codes mapped to errors.
+	statusDirectoryNotEmpty = 0xC0000101
+	statusFileIsADirectory  = 0xC00000BA
+	statusNotADirectory     = 0xC0000103
+	statusPathNotCovered    = 0xC0000257
+)
+
+var (
+	// ErrNotEmpty is returned when removing a directory with files.
+	ErrNotEmpty = billy.ErrNotEmpty
+	// ErrPathNotCovered is returned when the path belongs to a DFS folder
+	// without a referral.
+	ErrPathNotCovered = errors.New("path not covered by the referrals")
+	// ErrCrossShare is returned when renaming a file to another share.
+	ErrCrossShare = errors.New("rename across shares")
+
+	errIsDir  = errors.New("is a directory")
+	errNotDir = errors.New("not a directory")
+)
+
+// Options holds the configuration of a SMB filesystem.
+type Options struct {
+	// User, Password and Domain are the NTLM credentials.
+	User, Password, Domain string
+	// Referrals redirects the folders of a DFS namespace to their targets.
+	Referrals []Referral
+	// Timeout of the connections, 30 seconds by default.
+	Timeout time.Duration
+}
+
+// Referral redirects a folder of a DFS namespace to the share holding its
+// files.
+type Referral struct {
+	// Path is the folder redirected, relative to the root of the
+	// filesystem, eg.: "projects/billy".
+	Path string
+	// Target is the UNC path of the folder holding the files, eg.:
+	// `\\fs1\projects\billy` or "//fs1:4445/projects/billy".
+	Target string
+}
+
+// referral is a Referral parsed.
+type referral struct {
+	path   string
+	server string
+	share  string
+	prefix string
+
+	mounted share
+}
+
+// SMB is a filesystem over a SMB share.
+type SMB struct {
+	root      share
+	mounter   mounter
+	referrals []*referral
+
+	mu sync.Mutex
+}
+
+// Dial connects to the SMB server at the given address, eg.:
+// "fs.example.com" or "fs.example.com:445", and mounts the given share.
+func Dial(addr, share string, opts Options) (*SMB, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+
+	c := newClient(opts)
+	fs, err := newSMB(c, addr, share, opts.Referrals)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return fs, nil
+}
+
+func newSMB(m mounter, addr, name string, referrals []Referral) (*SMB, error) {
+	fs := &SMB{mounter: m}
+	for _, r := range referrals {
+		parsed, err := parseReferral(r)
+		if err != nil {
+			return nil, err
+		}
+
+		fs.referrals = append(fs.referrals, parsed)
+	}
+
+	// the longest paths first, to match the most specific referral.
+	sort.Slice(fs.referrals, func(i, j int) bool {
+		return len(fs.referrals[i].path) > len(fs.referrals[j].path)
+	})
+
+	var err error
+	fs.root, err = m.mount(addr, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs, nil
+}
+
+func parseReferral(r Referral) (*referral, error) {
+	target := strings.TrimLeft(strings.Replace(r.Target, `\`, "/", -1), "/")
+	parts := strings.SplitN(target, "/", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid referral target: %q", r.Target)
+	}
+
+	p := &referral{path: clean(r.Path), server: parts[0], share: parts[1]}
+	if len(parts) == 3 {
+		p.prefix = clean(parts[2])
+	}
+
+	if p.path == "" {
+		return nil, fmt.Errorf("invalid referral path: %q", r.Path)
+	}
+
+	return p, nil
+}
+
+// Close unmounts the shares and closes the connections.
+func (fs *SMB) Close() error {
+	return fs.mounter.Close()
+}
+
+// resolve returns the share holding the given path, and its path in the
+// share.
+func (fs *SMB) resolve(filename string) (share, string, error) {
+	p := clean(filename)
+	for _, r := range fs.referrals {
+		if p != r.path && !strings.HasPrefix(p, r.path+"/") {
+			continue
+		}
+
+		s, err := fs.mount(r)
+		if err != nil {
+			return nil, "", err
+		}
+
+		return s, sharePath(path.Join(r.prefix, p[len(r.path):])), nil
+	}
+
+	return fs.root, sharePath(p), nil
+}
+
+// mount returns the share of the given referral, mounting it if needed.
+func (fs *SMB) mount(r *referral) (share, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if r.mounted != nil {
+		return r.mounted, nil
+	}
+
+	s, err := fs.mounter.mount(r.server, r.share)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mounted = s
+	return s, nil
+}
+
+func (fs *SMB) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *SMB) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file, creating the missing directories if needed.
+func (fs *SMB) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	f, err := fs.openFile(filename, flag, perm)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+	}
+
+	return &file{remoteFile: f, name: pathutil.Relative(filename)}, nil
+}
+
+func (fs *SMB) openFile(filename string, flag int, perm os.FileMode) (remoteFile, error) {
+	if flag&os.O_CREATE != 0 {
+		if err := fs.mkdirAll(path.Dir(clean(filename)), 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	s, p, err := fs.resolve(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := s.OpenFile(p, flag, perm)
+	return f, underlying(err)
+}
+
+func (fs *SMB) Stat(filename string) (os.FileInfo, error) {
+	fi, err := fs.stat(filename, true)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: filename, Err: err}
+	}
+
+	return fi, nil
+}
+
+func (fs *SMB) Lstat(filename string) (os.FileInfo, error) {
+	fi, err := fs.stat(filename, false)
+	if err != nil {
+		return nil, &os.PathError{Op: "lstat", Path: filename, Err: err}
+	}
+
+	return fi, nil
+}
+
+func (fs *SMB) stat(filename string, follow bool) (os.FileInfo, error) {
+	s, p, err := fs.resolve(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var fi os.FileInfo
+	if follow {
+		fi, err = s.Stat(p)
+	} else {
+		fi, err = s.Lstat(p)
+	}
+
+	if err != nil {
+		return nil, underlying(err)
+	}
+
+	return &fileInfo{FileInfo: fi, name: path.Base("/" + clean(filename))}, nil
+}
+
+// ReadDir reads the given directory, including the folders redirected by
+// the referrals in it.
+func (fs *SMB) ReadDir(filename string) ([]os.FileInfo, error) {
+	infos, err := fs.readDir(filename)
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: filename, Err: err}
+	}
+
+	return infos, nil
+}
+
+func (fs *SMB) readDir(filename string) ([]os.FileInfo, error) {
+	s, p, err := fs.resolve(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	infos, err := s.ReadDir(p)
+	if err != nil {
+		return nil, underlying(err)
+	}
+
+	names := make(map[string]bool, len(infos))
+	for _, fi := range infos {
+		names[fi.Name()] = true
+	}
+
+	dir := clean(filename)
+	for _, r := range fs.referrals {
+		parent, name := path.Split("/" + r.path)
+		if clean(parent) != dir || names[name] {
+			continue
+		}
+
+		fi, err := fs.stat(r.path, true)
+		if err != nil {
+			return nil, err
+		}
+
+		names[name] = true
+		infos = append(infos, fi)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+
+	return infos, nil
+}
+
+// MkdirAll creates the directory and any missing parent.
+func (fs *SMB) MkdirAll(filename string, perm os.FileMode) error {
+	if err := fs.mkdirAll(clean(filename), perm); err != nil {
+		return &os.PathError{Op: "mkdir", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *SMB) mkdirAll(p string, perm os.FileMode) error {
+	if p == "" || p == "." {
+		return nil
+	}
+
+	names := strings.Split(p, "/")
+	for i := range names {
+		current := strings.Join(names[:i+1], "/")
+		s, sp, err := fs.resolve(current)
+		if err != nil {
+			return err
+		}
+
+		fi, err := s.Stat(sp)
+		if err == nil {
+			if !fi.IsDir() {
+				return errNotDir
+			}
+
+			continue
+		}
+
+		if err := underlying(s.Mkdir(sp, perm)); err != nil && !os.IsExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Rename renames the file, replacing the destination if it's a file. The
+// files can't be renamed to another share.
+func (fs *SMB) Rename(from, to string) error {
+	if err := fs.rename(from, to); err != nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *SMB) rename(from, to string) error {
+	fromShare, fromPath, err := fs.resolve(from)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fromShare.Lstat(fromPath); err != nil {
+		return underlying(err)
+	}
+
+	if err := fs.mkdirAll(path.Dir(clean(to)), 0755); err != nil {
+		return err
+	}
+
+	toShare, toPath, err := fs.resolve(to)
+	if err != nil {
+		return err
+	}
+
+	if toShare != fromShare {
+		return ErrCrossShare
+	}
+
+	// SMB doesn't replace the destination.
+	if fi, err := toShare.Lstat(toPath); err == nil && !fi.IsDir() {
+		if err := toShare.Remove(toPath); err != nil {
+			return underlying(err)
+		}
+	}
+
+	return underlying(toShare.Rename(fromPath, toPath))
+}
+
+func (fs *SMB) Remove(filename string) error {
+	s, p, err := fs.resolve(filename)
+	if err == nil {
+		err = underlying(s.Remove(p))
+	}
+
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+// Symlink creates a symbolic link, as a reparse point. They may not be
+// supported by the server, eg.: Samba.
+func (fs *SMB) Symlink(target, link string) error {
+	if err := fs.symlink(target, link); err != nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *SMB) symlink(target, link string) error {
+	if err := fs.mkdirAll(path.Dir(clean(link)), 0755); err != nil {
+		return err
+	}
+
+	s, p, err := fs.resolve(link)
+	if err != nil {
+		return err
+	}
+
+	// the leading separator is kept, the absolute targets start by one.
+	target = strings.Replace(filepath.ToSlash(target), "/", `\`, -1)
+	return underlying(s.Symlink(target, p))
+}
+
+func (fs *SMB) Readlink(link string) (string, error) {
+	s, p, err := fs.resolve(link)
+	var target string
+	if err == nil {
+		target, err = s.Readlink(p)
+	}
+
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: link, Err: underlying(err)}
+	}
+
+	return filepath.FromSlash(strings.Replace(target, `\`, "/", -1)), nil
+}
+
+func (fs *SMB) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(fs, dir, prefix)
+}
+
+func (fs *SMB) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (fs *SMB) Chroot(p string) (billy.Filesystem, error) {
+	return chroot.New(fs, fs.Join(fs.Root(), p)), nil
+}
+
+func (fs *SMB) Root() string {
+	return string(filepath.Separator)
+}
+
+// Capabilities implements the Capable interface.
+func (fs *SMB) Capabilities() billy.Capability {
+	return billy.WriteCapability | billy.ReadCapability |
+		billy.ReadAndWriteCapability | billy.SeekCapability |
+		billy.TruncateCapability
+}
+
+// underlying returns the error of the os.PathError or os.LinkError returned
+// by go-smb2, holding the paths of the share, mapping the NTSTATUS codes
+// known.
+func underlying(err error) error {
+	switch e := err.(type) {
+	case *os.PathError:
+		err = e.Err
+	case *os.LinkError:
+		err = e.Err
+	}
+
+	if e, ok := err.(*smb2.ResponseError); ok {
+		switch e.Code {
+		case statusDirectoryNotEmpty:
+			return ErrNotEmpty
+		case statusPathNotCovered:
+			return ErrPathNotCovered
+		case statusFileIsADirectory:
+			return errIsDir
+		case statusNotADirectory:
+			return errNotDir
+		}
+	}
+
+	return err
+}
+
+// clean returns the given path cleaned, relative to the root, with slashes,
+// eg.: "foo/bar", or "" for the root.
+func clean(p string) string {
+	return path.Clean("/" + filepath.ToSlash(p))[1:]
+}
+
+// sharePath returns the given clean path with backslashes.
+func sharePath(p string) string {
+	return strings.Replace(strings.TrimPrefix(p, "/"), "/", `\`, -1)
+}
+
+type fileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
@@ -0,0 +1,167 @@
+package smbfs
+
+import (
+	"os"
+	"testing"
+
+	"github.com/hirochachacha/go-smb2"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&SMBSuite{})
+
+type SMBSuite struct {
+	test.FilesystemSuite
+	FS      *SMB
+	mounter *memMounter
+}
+
+func (s *SMBSuite) SetUpTest(c *C) {
+	s.mounter = newMemMounter(`fs1\root`)
+	s.FS = newFS(c, s.mounter, nil)
+	s.FilesystemSuite = test.NewFilesystemSuite(s.FS)
+}
+
+func newFS(c *C, m mounter, referrals []Referral) *SMB {
+	fs, err := newSMB(m, "fs1", "root", referrals)
+	c.Assert(err, IsNil)
+	return fs
+}
+
+func (s *SMBSuite) TestRenameReplace(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "bar", []byte("bar"), 0644), IsNil)
+	c.Assert(s.FS.Rename("foo", "bar"), IsNil)
+	c.Assert(test.ReadFile(c, s.FS, "bar"), Equals, "foo")
+}
+
+func (s *SMBSuite) TestRemoveNotEmpty(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar", nil, 0644), IsNil)
+
+	err := s.FS.Remove("foo")
+	c.Assert(err.(*os.PathError).Err, Equals, ErrNotEmpty)
+}
+
+func (s *SMBSuite) TestReadlinkSeparators(c *C) {
+	c.Assert(s.FS.Symlink("foo/bar", "link"), IsNil)
+
+	target, err := s.mounter.shares[`fs1\root`].Readlink("link")
+	c.Assert(err, IsNil)
+	c.Assert(target, Equals, `foo\bar`)
+
+	target, err = s.FS.Readlink("link")
+	c.Assert(err, IsNil)
+	c.Assert(target, Equals, "foo/bar")
+}
+
+func (s *SMBSuite) TestUnderlying(c *C) {
+	for code, expected := range map[uint32]error{
+		statusDirectoryNotEmpty: ErrNotEmpty,
+		statusPathNotCovered:    ErrPathNotCovered,
+		statusFileIsADirectory:  errIsDir,
+		statusNotADirectory:     errNotDir,
+	} {
+		err := &os.PathError{Op: "open", Path: `foo\bar`, Err: &smb2.ResponseError{Code: code}}
+		c.Assert(underlying(err), Equals, expected)
+	}
+
+	c.Assert(underlying(&os.LinkError{Err: os.ErrExist}), Equals, os.ErrExist)
+}
+
+var _ = Suite(&DFSSuite{})
+
+// DFSSuite tests a filesystem with referrals to two shares of another
+// server.
+type DFSSuite struct {
+	FS      *SMB
+	mounter *memMounter
+}
+
+func (s *DFSSuite) SetUpTest(c *C) {
+	s.mounter = newMemMounter(`fs1\root`, `fs2\projects`, `fs2\archive`)
+	s.FS = newFS(c, s.mounter, []Referral{
+		{Path: "projects", Target: `\\fs2\projects`},
+		{Path: "projects/old", Target: "//fs2/archive/2019"},
+	})
+}
+
+func (s *DFSSuite) TestMountOnFirstUse(c *C) {
+	c.Assert(s.mounter.mounts, DeepEquals, []string{`fs1\root`})
+
+	c.Assert(util.WriteFile(s.FS, "projects/foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "projects/bar", []byte("bar"), 0644), IsNil)
+	c.Assert(s.mounter.mounts, DeepEquals, []string{`fs1\root`, `fs2\projects`})
+
+	c.Assert(s.FS.Close(), IsNil)
+	c.Assert(s.mounter.closed, Equals, true)
+}
+
+func (s *DFSSuite) TestReferral(c *C) {
+	c.Assert(util.WriteFile(s.FS, "projects/foo", []byte("foo"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.mounter.shares[`fs2\projects`].fs, "foo"), Equals, "foo")
+
+	_, err := s.mounter.shares[`fs1\root`].fs.Stat("projects")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *DFSSuite) TestReferralPrefix(c *C) {
+	c.Assert(util.WriteFile(s.FS, "projects/old/foo", []byte("foo"), 0644), IsNil)
+	c.Assert(test.ReadFile(c, s.mounter.shares[`fs2\archive`].fs, "2019/foo"), Equals, "foo")
+
+	fi, err := s.FS.Stat("projects/old/foo")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Name(), Equals, "foo")
+	c.Assert(fi.Size(), Equals, int64(3))
+}
+
+func (s *DFSSuite) TestReadDirReferrals(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", nil, 0644), IsNil)
+	c.Assert(util.WriteFile(s.FS, "projects/bar", nil, 0644), IsNil)
+	c.Assert(s.FS.MkdirAll("projects/old", 0755), IsNil)
+
+	infos, err := s.FS.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 2)
+	c.Assert(infos[0].Name(), Equals, "foo")
+	c.Assert(infos[1].Name(), Equals, "projects")
+	c.Assert(infos[1].IsDir(), Equals, true)
+
+	infos, err = s.FS.ReadDir("projects")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 2)
+	c.Assert(infos[0].Name(), Equals, "bar")
+	c.Assert(infos[1].Name(), Equals, "old")
+}
+
+func (s *DFSSuite) TestRenameCrossShare(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+
+	err := s.FS.Rename("foo", "projects/foo")
+	c.Assert(err.(*os.LinkError).Err, Equals, ErrCrossShare)
+}
+
+func (s *DFSSuite) TestPathNotCovered(c *C) {
+	s.mounter.shares[`fs1\root`].notCovered = "dfs"
+
+	_, err := s.FS.Stat("dfs/foo")
+	c.Assert(err.(*os.PathError).Err, Equals, ErrPathNotCovered)
+	c.Assert(err, ErrorMatches, "stat dfs/foo: path not covered by the referrals")
+}
+
+func (s *DFSSuite) TestErrorPaths(c *C) {
+	_, err := s.FS.Open("projects/old/foo")
+	c.Assert(err, ErrorMatches, "open projects/old/foo: file does not exist")
+}
+
+func (s *DFSSuite) TestInvalidReferral(c *C) {
+	_, err := newSMB(s.mounter, "fs1", "root", []Referral{{Path: "foo", Target: `\\fs2`}})
+	c.Assert(err, ErrorMatches, `invalid referral target: "\\\\\\\\fs2"`)
+
+	_, err = newSMB(s.mounter, "fs1", "root", []Referral{{Path: "/", Target: `\\fs2\projects`}})
+	c.Assert(err, ErrorMatches, `invalid referral path: "/"`)
+}
@@ -0,0 +1,65 @@
+package squashfs
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// decompressor decodes a compressed block, which is never bigger than the
+// given size once decompressed.
+type decompressor func(src []byte, size int) ([]byte, error)
+
+func newDecompressor(compression uint16) (decompressor, error) {
+	switch compression {
+	case gzipCompression:
+		return streamDecompressor(func(r io.Reader) (io.Reader, error) {
+			return zlib.NewReader(r)
+		}), nil
+	case lzmaCompression:
+		return streamDecompressor(func(r io.Reader) (io.Reader, error) {
+			return lzma.NewReader(r)
+		}), nil
+	case xzCompression:
+		return streamDecompressor(func(r io.Reader) (io.Reader, error) {
+			return xz.NewReader(r)
+		}), nil
+	case zstdCompression:
+		d, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+
+		return func(src []byte, size int) ([]byte, error) {
+			data, err := d.DecodeAll(src, make([]byte, 0, size))
+			if err != nil || len(data) > size {
+				return nil, ErrCorrupted
+			}
+
+			return data, nil
+		}, nil
+	default:
+		return nil, ErrUnsupportedCompression
+	}
+}
+
+func streamDecompressor(open func(io.Reader) (io.Reader, error)) decompressor {
+	return func(src []byte, size int) ([]byte, error) {
+		r, err := open(bytes.NewReader(src))
+		if err != nil {
+			return nil, ErrCorrupted
+		}
+
+		data, err := ioutil.ReadAll(io.LimitReader(r, int64(size)+1))
+		if err != nil || len(data) > size {
+			return nil, ErrCorrupted
+		}
+
+		return data, nil
+	}
+}
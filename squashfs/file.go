@@ -0,0 +1,176 @@
+package squashfs
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// file is a read-only billy.File over a SquashFS inode. The content is read
+// one block at a time, keeping the last one decompressed, so sequential
+// reads decompress every block once.
+type file struct {
+	fs    *SquashFS
+	name  string
+	inode *inode
+
+	// offsets holds the position of every data block in the image.
+	offsets []int64
+	block   int
+	data    []byte
+
+	position int64
+	isClosed bool
+}
+
+func newFile(fs *SquashFS, name string, in *inode) billy.File {
+	f := &file{fs: fs, name: name, inode: in, block: -1}
+
+	off := in.start
+	for _, size := range in.blocks {
+		f.offsets = append(f.offsets, off)
+		off += int64(size &^ blockUncompressed)
+	}
+
+	return f
+}
+
+func (f *file) Name() string {
+	return f.name
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.position)
+	f.position += int64(n)
+
+	if err == io.EOF && n != 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: f.name, Err: errors.New("negative offset")}
+	}
+
+	blockSize := int64(f.fs.sb.BlockSize)
+
+	var n int
+	for n < len(p) && off < f.inode.size {
+		block := int(off / blockSize)
+		data, err := f.readBlock(block)
+		if err != nil {
+			return n, &os.PathError{Op: "read", Path: f.name, Err: err}
+		}
+
+		c := copy(p[n:], data[off-int64(block)*blockSize:])
+		n += c
+		off += int64(c)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// readBlock returns the content of the given block of the file, the last
+// one may be stored in a fragment.
+func (f *file) readBlock(block int) ([]byte, error) {
+	if block == f.block {
+		return f.data, nil
+	}
+
+	blockSize := int64(f.fs.sb.BlockSize)
+	size := f.inode.size - int64(block)*blockSize
+	if size > blockSize {
+		size = blockSize
+	}
+
+	var data []byte
+	var err error
+	switch {
+	case block >= len(f.inode.blocks):
+		data, err = f.fs.readFragment(f.inode.fragment)
+		if err == nil {
+			start := int64(f.inode.fragmentOffset)
+			if start+size > int64(len(data)) {
+				return nil, ErrCorrupted
+			}
+
+			data = data[start : start+size]
+		}
+	case f.inode.blocks[block]&^blockUncompressed == 0:
+		data = make([]byte, size)
+	default:
+		data, err = f.fs.readBlock(f.offsets[block], f.inode.blocks[block])
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) < size {
+		return nil, ErrCorrupted
+	}
+
+	f.block, f.data = block, data[:size]
+	return f.data, nil
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.position
+	case io.SeekEnd:
+		offset += f.inode.size
+	}
+
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: errors.New("negative offset")}
+	}
+
+	f.position = offset
+	return f.position, nil
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	return 0, billy.ErrReadOnly
+}
+
+func (f *file) Truncate(size int64) error {
+	return billy.ErrReadOnly
+}
+
+func (f *file) Close() error {
+	if f.isClosed {
+		return os.ErrClosed
+	}
+
+	f.isClosed = true
+	f.data = nil
+	return nil
+}
+
+// Lock is a no-op in squashfs.
+func (f *file) Lock() error {
+	return nil
+}
+
+// Unlock is a no-op in squashfs.
+func (f *file) Unlock() error {
+	return nil
+}
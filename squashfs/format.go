@@ -0,0 +1,499 @@
+package squashfs
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	magic          = 0x73717368
+	superblockSize = 96
+
+	minBlockLog = 12
+	maxBlockLog = 20
+
+	metadataSize         = 8192
+	metadataUncompressed = 0x8000
+	blockUncompressed    = 1 << 24
+	noFragment           = 0xffffffff
+
+	fragmentEntrySize = 16
+	maxDirEntries     = 256
+	maxCachedMetadata = 256
+)
+
+// inode types, the extended ones have the same layout plus extra fields.
+const (
+	dirType = iota + 1
+	fileType
+	symlinkType
+	blockDevType
+	charDevType
+	fifoType
+	socketType
+	extDirType
+	extFileType
+	extSymlinkType
+	extBlockDevType
+	extCharDevType
+	extFifoType
+	extSocketType
+)
+
+// compression ids, as stored in the superblock.
+const (
+	gzipCompression = iota + 1
+	lzmaCompression
+	lzoCompression
+	xzCompression
+	lz4Compression
+	zstdCompression
+)
+
+type superblock struct {
+	Magic          uint32
+	InodeCount     uint32
+	ModTime        uint32
+	BlockSize      uint32
+	FragmentCount  uint32
+	Compression    uint16
+	BlockLog       uint16
+	Flags          uint16
+	IDCount        uint16
+	VersionMajor   uint16
+	VersionMinor   uint16
+	RootInode      uint64
+	BytesUsed      uint64
+	IDTable        uint64
+	XattrTable     uint64
+	InodeTable     uint64
+	DirectoryTable uint64
+	FragmentTable  uint64
+	ExportTable    uint64
+}
+
+func readSuperblock(r io.ReaderAt) (*superblock, error) {
+	var sb superblock
+	err := binary.Read(io.NewSectionReader(r, 0, superblockSize), binary.LittleEndian, &sb)
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+
+	if sb.Magic != magic || sb.VersionMajor != 4 || sb.VersionMinor != 0 {
+		return nil, ErrInvalidImage
+	}
+
+	if sb.BlockLog < minBlockLog || sb.BlockLog > maxBlockLog || sb.BlockSize != 1<<sb.BlockLog {
+		return nil, ErrCorrupted
+	}
+
+	return &sb, nil
+}
+
+type inodeHeader struct {
+	Type    uint16
+	Mode    uint16
+	UID     uint16
+	GID     uint16
+	ModTime uint32
+	Number  uint32
+}
+
+type dirInode struct {
+	BlockIndex  uint32
+	LinkCount   uint32
+	FileSize    uint16
+	BlockOffset uint16
+	ParentInode uint32
+}
+
+type extDirInode struct {
+	LinkCount   uint32
+	FileSize    uint32
+	BlockIndex  uint32
+	ParentInode uint32
+	IndexCount  uint16
+	BlockOffset uint16
+	Xattr       uint32
+}
+
+type fileInode struct {
+	BlocksStart    uint32
+	Fragment       uint32
+	FragmentOffset uint32
+	FileSize       uint32
+}
+
+type extFileInode struct {
+	BlocksStart    uint64
+	FileSize       uint64
+	Sparse         uint64
+	LinkCount      uint32
+	Fragment       uint32
+	FragmentOffset uint32
+	Xattr          uint32
+}
+
+type symlinkInode struct {
+	LinkCount  uint32
+	TargetSize uint32
+}
+
+type dirHeader struct {
+	Count       uint32
+	Start       uint32
+	InodeNumber uint32
+}
+
+type dirEntry struct {
+	Offset      uint16
+	InodeOffset int16
+	Type        uint16
+	NameSize    uint16
+}
+
+type fragment struct {
+	Start  uint64
+	Size   uint32
+	Unused uint32
+}
+
+// inode is a decoded inode, only the fields of its type are set.
+type inode struct {
+	typ     uint16
+	mode    os.FileMode
+	modTime time.Time
+	size    int64
+
+	// directories, the location of the listing in the directory table.
+	dirBlock  uint32
+	dirOffset uint16
+	dirSize   uint32
+
+	// regular files, the location of the data blocks, their sizes, and the
+	// location of the tail end in a fragment block.
+	start          int64
+	blocks         []uint32
+	fragment       uint32
+	fragmentOffset uint32
+
+	// symlinks
+	target string
+}
+
+func (in *inode) isDir() bool {
+	return in.typ == dirType || in.typ == extDirType
+}
+
+func (in *inode) isSymlink() bool {
+	return in.typ == symlinkType || in.typ == extSymlinkType
+}
+
+// readInode decodes the inode at the given reference, the position of its
+// metadata block in the inode table in the upper bits, and the offset inside
+// of the block in the lower 16.
+func (fs *SquashFS) readInode(ref uint64) (*inode, error) {
+	r, err := fs.newMetaReader(int64(fs.sb.InodeTable+ref>>16), int(ref&0xffff))
+	if err != nil {
+		return nil, err
+	}
+
+	var h inodeHeader
+	if err := binary.Read(r, binary.LittleEndian, &h); err != nil {
+		return nil, err
+	}
+
+	in := &inode{
+		typ:     h.Type,
+		mode:    fileMode(h.Type, h.Mode),
+		modTime: time.Unix(int64(h.ModTime), 0),
+	}
+
+	switch h.Type {
+	case dirType:
+		var d dirInode
+		err = binary.Read(r, binary.LittleEndian, &d)
+		in.dirBlock, in.dirOffset, in.dirSize = d.BlockIndex, d.BlockOffset, uint32(d.FileSize)
+	case extDirType:
+		var d extDirInode
+		err = binary.Read(r, binary.LittleEndian, &d)
+		in.dirBlock, in.dirOffset, in.dirSize = d.BlockIndex, d.BlockOffset, d.FileSize
+	case fileType:
+		var f fileInode
+		if err = binary.Read(r, binary.LittleEndian, &f); err == nil {
+			err = fs.readBlockList(r, in, int64(f.BlocksStart), int64(f.FileSize), f.Fragment, f.FragmentOffset)
+		}
+	case extFileType:
+		var f extFileInode
+		if err = binary.Read(r, binary.LittleEndian, &f); err == nil {
+			err = fs.readBlockList(r, in, int64(f.BlocksStart), int64(f.FileSize), f.Fragment, f.FragmentOffset)
+		}
+	case symlinkType, extSymlinkType:
+		var s symlinkInode
+		if err = binary.Read(r, binary.LittleEndian, &s); err == nil {
+			if s.TargetSize > 4096 {
+				return nil, ErrCorrupted
+			}
+
+			target := make([]byte, s.TargetSize)
+			_, err = io.ReadFull(r, target)
+			in.target, in.size = string(target), int64(s.TargetSize)
+		}
+	case blockDevType, charDevType, fifoType, socketType,
+		extBlockDevType, extCharDevType, extFifoType, extSocketType:
+	default:
+		return nil, ErrCorrupted
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return in, nil
+}
+
+func (fs *SquashFS) readBlockList(r io.Reader, in *inode, start, size int64, frag, offset uint32) error {
+	if size < 0 || start < 0 {
+		return ErrCorrupted
+	}
+
+	count := size / int64(fs.sb.BlockSize)
+	if frag == noFragment {
+		if size%int64(fs.sb.BlockSize) != 0 {
+			count++
+		}
+	} else if frag >= uint32(len(fs.fragments)) {
+		return ErrCorrupted
+	}
+
+	if count > int64(fs.sb.BytesUsed) {
+		return ErrCorrupted
+	}
+
+	in.start, in.size = start, size
+	in.fragment, in.fragmentOffset = frag, offset
+	in.blocks = make([]uint32, count)
+	return binary.Read(r, binary.LittleEndian, in.blocks)
+}
+
+// readDir returns the entries of the given directory, sorted by name.
+func (fs *SquashFS) readDir(in *inode) ([]*entry, error) {
+	if in.dirSize <= 3 {
+		return nil, nil
+	}
+
+	r, err := fs.newMetaReader(int64(fs.sb.DirectoryTable)+int64(in.dirBlock), int(in.dirOffset))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*entry
+	remaining := int64(in.dirSize) - 3
+	for remaining > 0 {
+		var h dirHeader
+		if err := binary.Read(r, binary.LittleEndian, &h); err != nil {
+			return nil, err
+		}
+
+		if h.Count >= maxDirEntries {
+			return nil, ErrCorrupted
+		}
+
+		remaining -= int64(binary.Size(h))
+		for i := uint32(0); i <= h.Count; i++ {
+			var e dirEntry
+			if err := binary.Read(r, binary.LittleEndian, &e); err != nil {
+				return nil, err
+			}
+
+			name := make([]byte, int(e.NameSize)+1)
+			if _, err := io.ReadFull(r, name); err != nil {
+				return nil, err
+			}
+
+			remaining -= int64(binary.Size(e) + len(name))
+			entries = append(entries, &entry{
+				name: string(name),
+				ref:  uint64(h.Start)<<16 | uint64(e.Offset),
+			})
+		}
+	}
+
+	return entries, nil
+}
+
+// readFragments reads the fragment table, the locations of the blocks where
+// the tail ends of the files are packed together.
+func (fs *SquashFS) readFragments() error {
+	count := int64(fs.sb.FragmentCount)
+	if count == 0 {
+		return nil
+	}
+
+	if count > int64(fs.sb.BytesUsed)/fragmentEntrySize {
+		return ErrCorrupted
+	}
+
+	blocks := make([]uint64, (count*fragmentEntrySize+metadataSize-1)/metadataSize)
+	sr := io.NewSectionReader(fs.r, int64(fs.sb.FragmentTable), int64(len(blocks)*8))
+	if err := binary.Read(sr, binary.LittleEndian, blocks); err != nil {
+		return ErrCorrupted
+	}
+
+	fs.fragments = make([]fragment, 0, count)
+	for _, block := range blocks {
+		r, err := fs.newMetaReader(int64(block), 0)
+		if err != nil {
+			return err
+		}
+
+		n := count - int64(len(fs.fragments))
+		if n > metadataSize/fragmentEntrySize {
+			n = metadataSize / fragmentEntrySize
+		}
+
+		entries := make([]fragment, n)
+		if err := binary.Read(r, binary.LittleEndian, entries); err != nil {
+			return err
+		}
+
+		fs.fragments = append(fs.fragments, entries...)
+	}
+
+	return nil
+}
+
+// readMetadata returns the content of the metadata block at the given offset
+// of the image, and the offset of the following one.
+func (fs *SquashFS) readMetadata(off int64) ([]byte, int64, error) {
+	fs.mu.Lock()
+	b, ok := fs.metadata[off]
+	fs.mu.Unlock()
+	if ok {
+		return b.data, b.next, nil
+	}
+
+	var header [2]byte
+	if _, err := fs.r.ReadAt(header[:], off); err != nil {
+		return nil, 0, readError(err)
+	}
+
+	h := binary.LittleEndian.Uint16(header[:])
+	data := make([]byte, h&^metadataUncompressed)
+	if _, err := fs.r.ReadAt(data, off+2); err != nil {
+		return nil, 0, readError(err)
+	}
+
+	if h&metadataUncompressed == 0 {
+		var err error
+		if data, err = fs.decompress(data, metadataSize); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	if len(data) == 0 || len(data) > metadataSize {
+		return nil, 0, ErrCorrupted
+	}
+
+	b = &metadataBlock{data: data, next: off + 2 + int64(h&^metadataUncompressed)}
+
+	fs.mu.Lock()
+	if len(fs.metadata) >= maxCachedMetadata {
+		fs.metadata = make(map[int64]*metadataBlock)
+	}
+
+	fs.metadata[off] = b
+	fs.mu.Unlock()
+
+	return b.data, b.next, nil
+}
+
+type metadataBlock struct {
+	data []byte
+	next int64
+}
+
+// metaReader reads the metadata blocks as a stream, since inodes and
+// directory listings may span several of them.
+type metaReader struct {
+	fs   *SquashFS
+	buf  []byte
+	next int64
+}
+
+func (fs *SquashFS) newMetaReader(block int64, offset int) (*metaReader, error) {
+	data, next, err := fs.readMetadata(block)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset > len(data) {
+		return nil, ErrCorrupted
+	}
+
+	return &metaReader{fs: fs, buf: data[offset:], next: next}, nil
+}
+
+func (r *metaReader) Read(p []byte) (int, error) {
+	var n int
+	for n < len(p) {
+		if len(r.buf) == 0 {
+			data, next, err := r.fs.readMetadata(r.next)
+			if err != nil {
+				return n, err
+			}
+
+			r.buf, r.next = data, next
+		}
+
+		c := copy(p[n:], r.buf)
+		r.buf = r.buf[c:]
+		n += c
+	}
+
+	return n, nil
+}
+
+func fileMode(typ, perm uint16) os.FileMode {
+	mode := os.FileMode(perm & 0777)
+	if perm&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+
+	if perm&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+
+	if perm&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+
+	switch typ {
+	case dirType, extDirType:
+		mode |= os.ModeDir
+	case symlinkType, extSymlinkType:
+		mode |= os.ModeSymlink
+	case blockDevType, extBlockDevType:
+		mode |= os.ModeDevice
+	case charDevType, extCharDevType:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case fifoType, extFifoType:
+		mode |= os.ModeNamedPipe
+	case socketType, extSocketType:
+		mode |= os.ModeSocket
+	}
+
+	return mode
+}
+
+// readError turns the short reads into ErrCorrupted, since every structure
+// read is expected to be fully inside of the image.
+func readError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrCorrupted
+	}
+
+	return err
+}
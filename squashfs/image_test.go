@@ -0,0 +1,367 @@
+package squashfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"sort"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+const (
+	testBlockLog = 12
+	testModTime  = 1000000000
+)
+
+// imageEntry is a file, directory or symlink to be written in a test image,
+// the content of the symlinks is their target.
+type imageEntry struct {
+	name    string
+	mode    os.FileMode
+	content string
+}
+
+type imageNode struct {
+	imageEntry
+	children []*imageNode
+
+	number uint32
+	ref    uint64
+
+	start          uint64
+	blocks         []uint32
+	sparse         bool
+	fragment       uint32
+	fragmentOffset uint32
+}
+
+// imageWriter writes a minimal SquashFS image, the way mksquashfs does:
+// the data blocks first, with the tail ends of the files packed in
+// fragments, and then the tables. The blocks are stored uncompressed when
+// compress is nil, or when compressing doesn't make them smaller.
+type imageWriter struct {
+	compression uint16
+	compress    func([]byte) []byte
+
+	data      bytes.Buffer
+	fragment  []byte
+	fragments []fragment
+	count     uint32
+}
+
+func buildImage(c *C, compression uint16, compress func([]byte) []byte, entries ...imageEntry) []byte {
+	w := &imageWriter{compression: compression, compress: compress}
+	root := &imageNode{imageEntry: imageEntry{mode: os.ModeDir | 0755}}
+	for _, e := range entries {
+		w.add(root, e)
+	}
+
+	w.number(root)
+	w.writeData(root)
+	w.flushFragment()
+
+	inodes := &metaWriter{compress: compress}
+	dirs := &metaWriter{compress: compress}
+	w.writeInode(inodes, dirs, root, root.number)
+
+	frags := &metaWriter{compress: compress}
+	for _, f := range w.fragments {
+		frags.write(f)
+	}
+
+	ids := &metaWriter{compress: compress}
+	ids.write(uint32(0))
+
+	image := bytes.NewBuffer(make([]byte, superblockSize))
+	image.Write(w.data.Bytes())
+
+	sb := superblock{
+		Magic:         magic,
+		InodeCount:    w.count,
+		ModTime:       testModTime,
+		BlockSize:     1 << testBlockLog,
+		FragmentCount: uint32(len(w.fragments)),
+		Compression:   compression,
+		BlockLog:      testBlockLog,
+		IDCount:       1,
+		VersionMajor:  4,
+		RootInode:     root.ref,
+		XattrTable:    ^uint64(0),
+		ExportTable:   ^uint64(0),
+	}
+
+	sb.InodeTable = uint64(image.Len())
+	image.Write(inodes.bytes())
+	sb.DirectoryTable = uint64(image.Len())
+	image.Write(dirs.bytes())
+	sb.FragmentTable = writeTable(image, frags)
+	sb.IDTable = writeTable(image, ids)
+	sb.BytesUsed = uint64(image.Len())
+
+	b := image.Bytes()
+	header := bytes.NewBuffer(nil)
+	c.Assert(binary.Write(header, binary.LittleEndian, &sb), IsNil)
+	copy(b, header.Bytes())
+	return b
+}
+
+// writeTable writes the metadata blocks of a lookup table, followed by their
+// locations, returning the location of the latter.
+func writeTable(image *bytes.Buffer, w *metaWriter) uint64 {
+	start := uint64(image.Len())
+	image.Write(w.bytes())
+
+	table := uint64(image.Len())
+	for _, block := range w.blocks {
+		binary.Write(image, binary.LittleEndian, start+uint64(block))
+	}
+
+	return table
+}
+
+func (w *imageWriter) add(root *imageNode, e imageEntry) {
+	dir := root
+	parts := strings.Split(e.name, "/")
+	for _, part := range parts[:len(parts)-1] {
+		dir = dir.child(part, imageEntry{name: part, mode: os.ModeDir | 0755})
+	}
+
+	e.name = parts[len(parts)-1]
+	n := dir.child(e.name, e)
+	n.imageEntry = e
+}
+
+func (n *imageNode) child(name string, e imageEntry) *imageNode {
+	for _, child := range n.children {
+		if child.name == name {
+			return child
+		}
+	}
+
+	child := &imageNode{imageEntry: e}
+	n.children = append(n.children, child)
+	sort.Slice(n.children, func(i, j int) bool {
+		return n.children[i].name < n.children[j].name
+	})
+
+	return child
+}
+
+func (w *imageWriter) number(n *imageNode) {
+	w.count++
+	n.number = w.count
+	for _, child := range n.children {
+		w.number(child)
+	}
+}
+
+func (w *imageWriter) writeData(n *imageNode) {
+	for _, child := range n.children {
+		w.writeData(child)
+	}
+
+	if !n.mode.IsRegular() {
+		return
+	}
+
+	content := []byte(n.content)
+	n.start = superblockSize + uint64(w.data.Len())
+	n.fragment = noFragment
+
+	size := 1 << testBlockLog
+	for ; len(content) >= size; content = content[size:] {
+		if bytes.Count(content[:size], []byte{0}) == size {
+			n.blocks = append(n.blocks, 0)
+			n.sparse = true
+			continue
+		}
+
+		n.blocks = append(n.blocks, w.writeBlock(content[:size]))
+	}
+
+	if len(content) == 0 {
+		return
+	}
+
+	if len(w.fragment)+len(content) > size {
+		w.flushFragment()
+	}
+
+	n.fragment = uint32(len(w.fragments))
+	n.fragmentOffset = uint32(len(w.fragment))
+	w.fragment = append(w.fragment, content...)
+}
+
+func (w *imageWriter) writeBlock(b []byte) uint32 {
+	if w.compress != nil {
+		if compressed := w.compress(b); len(compressed) < len(b) {
+			w.data.Write(compressed)
+			return uint32(len(compressed))
+		}
+	}
+
+	w.data.Write(b)
+	return uint32(len(b)) | blockUncompressed
+}
+
+func (w *imageWriter) flushFragment() {
+	if len(w.fragment) == 0 {
+		return
+	}
+
+	start := superblockSize + uint64(w.data.Len())
+	size := w.writeBlock(w.fragment)
+	w.fragments = append(w.fragments, fragment{Start: start, Size: size})
+	w.fragment = nil
+}
+
+// writeInode writes the inodes of the children of a directory before its
+// listing, since it references them, and the inode of the directory after
+// it for the same reason.
+func (w *imageWriter) writeInode(inodes, dirs *metaWriter, n *imageNode, parent uint32) {
+	perm := uint16(n.mode.Perm())
+	switch {
+	case n.mode.IsDir():
+		var links uint32 = 2
+		for _, child := range n.children {
+			w.writeInode(inodes, dirs, child, n.number)
+			if child.mode.IsDir() {
+				links++
+			}
+		}
+
+		listing := dirs.ref()
+		size := writeListing(dirs, n.children)
+
+		n.ref = inodes.ref()
+		inodes.write(inodeHeader{Type: dirType, Mode: perm, ModTime: testModTime, Number: n.number})
+		inodes.write(dirInode{
+			BlockIndex:  uint32(listing >> 16),
+			LinkCount:   links,
+			FileSize:    uint16(size + 3),
+			BlockOffset: uint16(listing),
+			ParentInode: parent,
+		})
+	case n.mode&os.ModeSymlink != 0:
+		n.ref = inodes.ref()
+		inodes.write(inodeHeader{Type: symlinkType, Mode: perm, ModTime: testModTime, Number: n.number})
+		inodes.write(symlinkInode{LinkCount: 1, TargetSize: uint32(len(n.content))})
+		inodes.write([]byte(n.content))
+	case n.sparse:
+		n.ref = inodes.ref()
+		inodes.write(inodeHeader{Type: extFileType, Mode: perm, ModTime: testModTime, Number: n.number})
+		inodes.write(extFileInode{
+			BlocksStart:    n.start,
+			FileSize:       uint64(len(n.content)),
+			LinkCount:      1,
+			Fragment:       n.fragment,
+			FragmentOffset: n.fragmentOffset,
+			Xattr:          ^uint32(0),
+		})
+		inodes.write(n.blocks)
+	default:
+		n.ref = inodes.ref()
+		inodes.write(inodeHeader{Type: fileType, Mode: perm, ModTime: testModTime, Number: n.number})
+		inodes.write(fileInode{
+			BlocksStart:    uint32(n.start),
+			Fragment:       n.fragment,
+			FragmentOffset: n.fragmentOffset,
+			FileSize:       uint32(len(n.content)),
+		})
+		inodes.write(n.blocks)
+	}
+}
+
+// writeListing writes the listing of a directory, grouping the entries
+// with the inode in the same metadata block under a header.
+func writeListing(dirs *metaWriter, children []*imageNode) int {
+	var size int
+	for len(children) != 0 {
+		group := 1
+		for group < len(children) && group < maxDirEntries &&
+			children[group].ref>>16 == children[0].ref>>16 {
+			group++
+		}
+
+		dirs.write(dirHeader{
+			Count:       uint32(group - 1),
+			Start:       uint32(children[0].ref >> 16),
+			InodeNumber: children[0].number,
+		})
+
+		size += binary.Size(dirHeader{})
+		for _, child := range children[:group] {
+			typ := uint16(fileType)
+			if child.mode.IsDir() {
+				typ = dirType
+			} else if child.mode&os.ModeSymlink != 0 {
+				typ = symlinkType
+			}
+
+			dirs.write(dirEntry{
+				Offset:      uint16(child.ref),
+				InodeOffset: int16(child.number - children[0].number),
+				Type:        typ,
+				NameSize:    uint16(len(child.name) - 1),
+			})
+
+			dirs.write([]byte(child.name))
+			size += binary.Size(dirEntry{}) + len(child.name)
+		}
+
+		children = children[group:]
+	}
+
+	return size
+}
+
+// metaWriter writes a stream of metadata blocks.
+type metaWriter struct {
+	compress func([]byte) []byte
+
+	out    bytes.Buffer
+	cur    []byte
+	blocks []int
+}
+
+// ref returns the reference to the current position: the location of the
+// metadata block in the upper bits, and the offset inside of it.
+func (w *metaWriter) ref() uint64 {
+	return uint64(w.out.Len())<<16 | uint64(len(w.cur))
+}
+
+func (w *metaWriter) write(v interface{}) {
+	buf := bytes.NewBuffer(nil)
+	binary.Write(buf, binary.LittleEndian, v)
+	w.cur = append(w.cur, buf.Bytes()...)
+
+	for len(w.cur) >= metadataSize {
+		w.flush(w.cur[:metadataSize])
+		w.cur = w.cur[metadataSize:]
+	}
+}
+
+func (w *metaWriter) flush(b []byte) {
+	w.blocks = append(w.blocks, w.out.Len())
+
+	header := uint16(len(b)) | metadataUncompressed
+	if w.compress != nil {
+		if compressed := w.compress(b); len(compressed) < len(b) {
+			b, header = compressed, uint16(len(compressed))
+		}
+	}
+
+	binary.Write(&w.out, binary.LittleEndian, header)
+	w.out.Write(b)
+}
+
+func (w *metaWriter) bytes() []byte {
+	if len(w.cur) != 0 {
+		w.flush(w.cur)
+		w.cur = nil
+	}
+
+	return w.out.Bytes()
+}
@@ -0,0 +1,341 @@
+// Package squashfs provides a read-only billy filesystem over a SquashFS 4.0
+// image, without extracting it.
+//
+// The inodes and directory listings are decoded on demand, keeping a bounded
+// cache of the metadata blocks, and the data blocks of the files are
+// decompressed as they are read. The images compressed with gzip, lzma, xz
+// and zstd are supported; lzo and lz4 ones fail with
+// ErrUnsupportedCompression.
+package squashfs // import "gopkg.in/src-d/go-billy.v4/squashfs"
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/internal/linkutil"
+)
+
+var (
+	// ErrInvalidImage is returned when the image isn't a SquashFS 4.0 image.
+	ErrInvalidImage = errors.New("not a squashfs image")
+	// ErrCorrupted is returned when a structure of the image can't be
+	// decoded.
+	ErrCorrupted = errors.New("corrupted squashfs image")
+	// ErrUnsupportedCompression is returned when the image uses a
+	// compression algorithm not supported.
+	ErrUnsupportedCompression = errors.New("unsupported compression")
+
+	errNotDir = errors.New("not a directory")
+)
+
+// SquashFS is a read-only filesystem based on a SquashFS image.
+type SquashFS struct {
+	r          io.ReaderAt
+	sb         *superblock
+	decompress decompressor
+	fragments  []fragment
+	root       *inode
+
+	mu       sync.Mutex
+	metadata map[int64]*metadataBlock
+	// the last fragment block read, shared by the small files.
+	fragmentIndex uint32
+	fragmentData  []byte
+}
+
+// entry is a directory entry, referencing the inode of the child.
+type entry struct {
+	name string
+	ref  uint64
+}
+
+// New returns a new read-only filesystem from the SquashFS image readable
+// from r.
+func New(r io.ReaderAt) (billy.Filesystem, error) {
+	sb, err := readSuperblock(r)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := newDecompressor(sb.Compression)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := &SquashFS{
+		r:             r,
+		sb:            sb,
+		decompress:    d,
+		metadata:      make(map[int64]*metadataBlock),
+		fragmentIndex: noFragment,
+	}
+
+	if err := fs.readFragments(); err != nil {
+		return nil, err
+	}
+
+	if fs.root, err = fs.readInode(sb.RootInode); err != nil {
+		return nil, err
+	}
+
+	if !fs.root.isDir() {
+		return nil, ErrCorrupted
+	}
+
+	return chroot.New(fs, string(filepath.Separator)), nil
+}
+
+func (fs *SquashFS) Create(filename string) (billy.File, error) {
+	return nil, billy.ErrReadOnly
+}
+
+func (fs *SquashFS) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (fs *SquashFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if isWrite(flag) {
+		return nil, billy.ErrReadOnly
+	}
+
+	in, err := fs.resolve(filename, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if in.isDir() {
+		return nil, fmt.Errorf("cannot open directory: %s", filename)
+	}
+
+	return newFile(fs, filename, in), nil
+}
+
+func (fs *SquashFS) Stat(filename string) (os.FileInfo, error) {
+	in, err := fs.resolve(filename, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return newFileInfo(path.Base(clean(filename)), in), nil
+}
+
+func (fs *SquashFS) Lstat(filename string) (os.FileInfo, error) {
+	in, err := fs.resolve(filename, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return newFileInfo(path.Base(clean(filename)), in), nil
+}
+
+func (fs *SquashFS) ReadDir(filename string) ([]os.FileInfo, error) {
+	in, err := fs.resolve(filename, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if !in.isDir() {
+		return nil, &os.PathError{Op: "readdir", Path: filename, Err: errNotDir}
+	}
+
+	children, err := fs.readDir(in)
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: filename, Err: err}
+	}
+
+	var entries []os.FileInfo
+	for _, e := range children {
+		child, err := fs.readInode(e.ref)
+		if err != nil {
+			return nil, &os.PathError{Op: "readdir", Path: filename, Err: err}
+		}
+
+		entries = append(entries, newFileInfo(e.name, child))
+	}
+
+	return entries, nil
+}
+
+func (fs *SquashFS) Readlink(link string) (string, error) {
+	in, err := fs.resolve(link, false)
+	if err != nil {
+		return "", err
+	}
+
+	if !in.isSymlink() {
+		return "", &os.PathError{Op: "readlink", Path: link, Err: errors.New("not a symlink")}
+	}
+
+	return filepath.FromSlash(in.target), nil
+}
+
+func (fs *SquashFS) Rename(from, to string) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *SquashFS) Remove(filename string) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *SquashFS) MkdirAll(filename string, perm os.FileMode) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *SquashFS) Symlink(target, link string) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *SquashFS) TempFile(dir, prefix string) (billy.File, error) {
+	return nil, billy.ErrReadOnly
+}
+
+func (fs *SquashFS) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+// Capabilities implements the Capable interface.
+func (fs *SquashFS) Capabilities() billy.Capability {
+	return billy.ReadCapability | billy.SeekCapability
+}
+
+// resolve returns the inode of the given filename, following the symlinks
+// found in any of the parent directories, and in the file itself if follow
+// is true.
+func (fs *SquashFS) resolve(filename string, follow bool) (*inode, error) {
+	_, in, err := linkutil.Resolve(clean(filename), follow, fs.root, fs.child, linkTarget)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: filename, Err: err}
+	}
+
+	return in.(*inode), nil
+}
+
+// child returns the inode with the given name in the directory dir, and if
+// it's a link, to resolve the paths with linkutil.Resolve.
+func (fs *SquashFS) child(dir interface{}, name string) (interface{}, bool, error) {
+	d := dir.(*inode)
+	if !d.isDir() {
+		return nil, false, errNotDir
+	}
+
+	in, err := fs.lookup(d, name)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return in, in.isSymlink(), nil
+}
+
+func linkTarget(link interface{}) (string, error) {
+	return link.(*inode).target, nil
+}
+
+func (fs *SquashFS) lookup(dir *inode, name string) (*inode, error) {
+	entries, err := fs.readDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		if e.name == name {
+			return fs.readInode(e.ref)
+		}
+	}
+
+	return nil, os.ErrNotExist
+}
+
+// readBlock returns the content of the data block at the given offset, with
+// the size as stored in the block list.
+func (fs *SquashFS) readBlock(off int64, size uint32) ([]byte, error) {
+	data := make([]byte, size&^blockUncompressed)
+	if _, err := fs.r.ReadAt(data, off); err != nil {
+		return nil, readError(err)
+	}
+
+	if size&blockUncompressed != 0 {
+		return data, nil
+	}
+
+	return fs.decompress(data, int(fs.sb.BlockSize))
+}
+
+func (fs *SquashFS) readFragment(index uint32) ([]byte, error) {
+	fs.mu.Lock()
+	if fs.fragmentIndex == index {
+		data := fs.fragmentData
+		fs.mu.Unlock()
+		return data, nil
+	}
+
+	fs.mu.Unlock()
+
+	frag := fs.fragments[index]
+	data, err := fs.readBlock(int64(frag.Start), frag.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.mu.Lock()
+	fs.fragmentIndex, fs.fragmentData = index, data
+	fs.mu.Unlock()
+
+	return data, nil
+}
+
+type fileInfo struct {
+	name  string
+	inode *inode
+}
+
+func newFileInfo(name string, in *inode) os.FileInfo {
+	if name == "" || name == "." {
+		name = string(filepath.Separator)
+	}
+
+	return &fileInfo{name: name, inode: in}
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.inode.size
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	return fi.inode.mode
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.inode.modTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.inode.isDir()
+}
+
+func (fi *fileInfo) Sys() interface{} {
+	return nil
+}
+
+// clean returns the given path relative to the root of the image, using
+// forward slashes as separator, and "" for the root itself.
+func clean(name string) string {
+	name = path.Clean("/" + filepath.ToSlash(name))
+	return strings.TrimPrefix(name, "/")
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_APPEND|os.O_TRUNC) != 0
+}
@@ -0,0 +1,272 @@
+package squashfs
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/test"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&SquashFSSuite{})
+
+type SquashFSSuite struct {
+	FS billy.Filesystem
+}
+
+var (
+	large  = strings.Repeat("0123456789abcdef", 1000)
+	sparse = strings.Repeat("\x00", 2<<testBlockLog) + "end"
+)
+
+var testEntries = []imageEntry{
+	{"foo", 0644, "hello world"},
+	{"qux/bar", 0600, "bar"},
+	{"qux/baz/a", 0644, "a"},
+	{"large", 0644, large},
+	{"sparse", 0644, sparse},
+	{"empty", 0644, ""},
+	{"link", os.ModeSymlink | 0777, "qux/bar"},
+	{"dirlink", os.ModeSymlink | 0777, "qux"},
+	{"abslink", os.ModeSymlink | 0777, "/qux/baz"},
+}
+
+func zlibCompress(b []byte) []byte {
+	buf := bytes.NewBuffer(nil)
+	w := zlib.NewWriter(buf)
+	w.Write(b)
+	w.Close()
+	return buf.Bytes()
+}
+
+func (s *SquashFSSuite) SetUpTest(c *C) {
+	image := buildImage(c, gzipCompression, zlibCompress, testEntries...)
+
+	var err error
+	s.FS, err = New(bytes.NewReader(image))
+	c.Assert(err, IsNil)
+}
+
+func (s *SquashFSSuite) TestOpen(c *C) {
+	f, err := s.FS.Open("foo")
+	c.Assert(err, IsNil)
+	c.Assert(f.Name(), Equals, "foo")
+
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "hello world")
+	c.Assert(f.Close(), IsNil)
+}
+
+func (s *SquashFSSuite) TestOpenNotExists(c *C) {
+	_, err := s.FS.Open("nope")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	_, err = s.FS.Open("foo/bar")
+	c.Assert(err, NotNil)
+}
+
+func (s *SquashFSSuite) TestOpenDir(c *C) {
+	_, err := s.FS.Open("qux")
+	c.Assert(err, NotNil)
+}
+
+func (s *SquashFSSuite) TestReadBlocks(c *C) {
+	c.Assert(test.ReadFile(c, s.FS, "large"), Equals, large)
+	c.Assert(test.ReadFile(c, s.FS, "sparse"), Equals, sparse)
+	c.Assert(test.ReadFile(c, s.FS, "empty"), Equals, "")
+}
+
+func (s *SquashFSSuite) TestSeekAndReadAt(c *C) {
+	f, err := s.FS.Open("large")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	buf := make([]byte, 32)
+	n, err := f.ReadAt(buf, 4090)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf[:n]), Equals, large[4090:4122])
+
+	n, err = f.ReadAt(buf, 0)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf[:n]), Equals, large[:32])
+
+	pos, err := f.Seek(-5, io.SeekEnd)
+	c.Assert(err, IsNil)
+	c.Assert(pos, Equals, int64(len(large)-5))
+
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, large[len(large)-5:])
+
+	n, err = f.ReadAt(buf, int64(len(large)-3))
+	c.Assert(err, Equals, io.EOF)
+	c.Assert(string(buf[:n]), Equals, "def")
+}
+
+func (s *SquashFSSuite) TestStat(c *C) {
+	fi, err := s.FS.Stat("qux/bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Name(), Equals, "bar")
+	c.Assert(fi.Size(), Equals, int64(3))
+	c.Assert(fi.Mode(), Equals, os.FileMode(0600))
+	c.Assert(fi.ModTime(), Equals, time.Unix(testModTime, 0))
+	c.Assert(fi.IsDir(), Equals, false)
+
+	fi, err = s.FS.Stat("qux/baz")
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+	c.Assert(fi.Mode(), Equals, os.ModeDir|0755)
+
+	fi, err = s.FS.Stat("/")
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+}
+
+func (s *SquashFSSuite) TestSymlinks(c *C) {
+	fi, err := s.FS.Lstat("link")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode()&os.ModeSymlink, Not(Equals), os.FileMode(0))
+
+	fi, err = s.FS.Stat("link")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Name(), Equals, "link")
+	c.Assert(fi.Size(), Equals, int64(3))
+
+	target, err := s.FS.Readlink("link")
+	c.Assert(err, IsNil)
+	c.Assert(target, Equals, s.FS.Join("qux", "bar"))
+
+	c.Assert(test.ReadFile(c, s.FS, "dirlink/baz/a"), Equals, "a")
+	c.Assert(test.ReadFile(c, s.FS, "abslink/a"), Equals, "a")
+
+	_, err = s.FS.Readlink("foo")
+	c.Assert(err, NotNil)
+}
+
+func (s *SquashFSSuite) TestReadDir(c *C) {
+	entries, err := s.FS.ReadDir("/")
+	c.Assert(err, IsNil)
+
+	var names []string
+	for _, fi := range entries {
+		names = append(names, fi.Name())
+	}
+
+	c.Assert(names, DeepEquals, []string{
+		"abslink", "dirlink", "empty", "foo", "large", "link", "qux", "sparse",
+	})
+
+	entries, err = s.FS.ReadDir("dirlink")
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 2)
+	c.Assert(entries[0].Name(), Equals, "bar")
+	c.Assert(entries[1].Name(), Equals, "baz")
+	c.Assert(entries[1].IsDir(), Equals, true)
+
+	_, err = s.FS.ReadDir("foo")
+	c.Assert(err, NotNil)
+}
+
+func (s *SquashFSSuite) TestReadDirMany(c *C) {
+	var entries []imageEntry
+	for i := 0; i < 1000; i++ {
+		entries = append(entries, imageEntry{
+			strings.Repeat("x", 10+i%50) + string(rune('a'+i%26)) + string(rune('0'+i/26%10)) + string(rune('0'+i/260)),
+			0644, "",
+		})
+	}
+
+	fs, err := New(bytes.NewReader(buildImage(c, gzipCompression, nil, entries...)))
+	c.Assert(err, IsNil)
+
+	infos, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 1000)
+
+	_, err = fs.Stat(entries[999].name)
+	c.Assert(err, IsNil)
+}
+
+func (s *SquashFSSuite) TestChroot(c *C) {
+	fs, err := s.FS.Chroot("qux")
+	c.Assert(err, IsNil)
+
+	fi, err := fs.Stat("bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Size(), Equals, int64(3))
+}
+
+func (s *SquashFSSuite) TestReadOnly(c *C) {
+	_, err := s.FS.Create("new")
+	c.Assert(err, Equals, billy.ErrReadOnly)
+
+	_, err = s.FS.OpenFile("foo", os.O_RDWR, 0)
+	c.Assert(err, Equals, billy.ErrReadOnly)
+
+	c.Assert(s.FS.Remove("foo"), Equals, billy.ErrReadOnly)
+	c.Assert(s.FS.Rename("foo", "bar"), Equals, billy.ErrReadOnly)
+	c.Assert(s.FS.MkdirAll("dir", 0755), Equals, billy.ErrReadOnly)
+	c.Assert(s.FS.Symlink("foo", "bar"), Equals, billy.ErrReadOnly)
+
+	f, err := s.FS.Open("foo")
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("foo"))
+	c.Assert(err, Equals, billy.ErrReadOnly)
+}
+
+func (s *SquashFSSuite) TestCapabilities(c *C) {
+	c.Assert(billy.CapabilityCheck(s.FS, billy.WriteCapability), Equals, false)
+	c.Assert(billy.CapabilityCheck(s.FS, billy.ReadCapability), Equals, true)
+}
+
+func (s *SquashFSSuite) TestCompressions(c *C) {
+	compressions := map[uint16]func(io.Writer) (io.WriteCloser, error){
+		lzmaCompression: func(w io.Writer) (io.WriteCloser, error) { return lzma.NewWriter(w) },
+		xzCompression:   func(w io.Writer) (io.WriteCloser, error) { return xz.NewWriter(w) },
+		zstdCompression: func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) },
+	}
+
+	for id, open := range compressions {
+		compress := func(b []byte) []byte {
+			buf := bytes.NewBuffer(nil)
+			w, err := open(buf)
+			c.Assert(err, IsNil)
+			_, err = w.Write(b)
+			c.Assert(err, IsNil)
+			c.Assert(w.Close(), IsNil)
+			return buf.Bytes()
+		}
+
+		fs, err := New(bytes.NewReader(buildImage(c, id, compress, testEntries...)))
+		c.Assert(err, IsNil)
+		c.Assert(test.ReadFile(c, fs, "large"), Equals, large)
+		c.Assert(test.ReadFile(c, fs, "qux/bar"), Equals, "bar")
+	}
+}
+
+func (s *SquashFSSuite) TestInvalid(c *C) {
+	_, err := New(bytes.NewReader([]byte("foo")))
+	c.Assert(err, Equals, ErrInvalidImage)
+
+	image := buildImage(c, lz4Compression, nil, testEntries...)
+	_, err = New(bytes.NewReader(image))
+	c.Assert(err, Equals, ErrUnsupportedCompression)
+
+	image = buildImage(c, gzipCompression, zlibCompress, testEntries...)
+	_, err = New(bytes.NewReader(image[:len(image)-64]))
+	c.Assert(err, Equals, ErrCorrupted)
+}
@@ -0,0 +1,57 @@
+package synthfs
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4/internal/memfile"
+)
+
+// file is an open synthetic file, its content is generated when opened, and
+// kept in memory until closed, when it's delivered if written or truncated.
+type file struct {
+	*memfile.File
+
+	node *File
+}
+
+// Close delivers the content of the file if it was written or truncated.
+func (f *file) Close() error {
+	if f.Closed {
+		return os.ErrClosed
+	}
+
+	f.Closed = true
+
+	var err error
+	if f.Dirty || f.Flag()&os.O_TRUNC != 0 && isWrite(f.Flag()) {
+		err = f.node.Write(f.Content)
+	}
+
+	f.Content = nil
+	if err != nil {
+		return &os.PathError{Op: "close", Path: f.Name(), Err: err}
+	}
+
+	return nil
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	return &fileInfo{
+		name:    filepath.Base(f.Name()),
+		size:    int64(len(f.Content)),
+		mode:    f.node.mode(),
+		modTime: time.Now(),
+	}, nil
+}
+
+// Lock is a no-op in synthfs.
+func (f *file) Lock() error {
+	return nil
+}
+
+// Unlock is a no-op in synthfs.
+func (f *file) Unlock() error {
+	return nil
+}
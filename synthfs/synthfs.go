@@ -0,0 +1,401 @@
+// Package synthfs provides a billy filesystem of synthetic files, backed by
+// Go functions, like the synthetic filesystems of Plan 9. The content of a
+// file is generated when opened, and the content written to it is delivered
+// when closed, allowing to expose the state or the configuration of a
+// program through billy.
+//
+// The directories are either static, built with Add, or dynamic, listed by
+// a function every time they are read or traversed, eg.: a directory per
+// connection of a server. The tree can't be changed through billy, files
+// can't be created, removed or renamed.
+package synthfs // import "gopkg.in/src-d/go-billy.v4/synthfs"
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/internal/memfile"
+)
+
+var (
+	errNotDir  = errors.New("not a directory")
+	errIsDir   = errors.New("is a directory")
+	errNotLink = errors.New("not a symlink")
+)
+
+// ReadFunc returns the content of a file, called every time the file is
+// opened for reading.
+type ReadFunc func() ([]byte, error)
+
+// WriteFunc receives the content written to a file, called when the file is
+// closed, if written.
+type WriteFunc func(content []byte) error
+
+// ListFunc returns the entries of a dynamic directory, called every time the
+// directory is read or traversed.
+type ListFunc func() (map[string]Node, error)
+
+// Node is a File or a Dir.
+type Node interface {
+	mode() os.FileMode
+}
+
+// File is a synthetic file. A file without Read is write-only, and a file
+// without Write is read-only.
+type File struct {
+	// Read generates the content of the file.
+	Read ReadFunc
+	// Write handles the content written to the file.
+	Write WriteFunc
+	// Perm are the permissions of the file, by default 0444, 0222 or
+	// 0666, depending on the functions set.
+	Perm os.FileMode
+}
+
+func (f *File) mode() os.FileMode {
+	if f.Perm != 0 {
+		return f.Perm.Perm()
+	}
+
+	var perm os.FileMode
+	if f.Read != nil {
+		perm |= 0444
+	}
+
+	if f.Write != nil {
+		perm |= 0222
+	}
+
+	return perm
+}
+
+// Dir is a synthetic directory, with static entries, or dynamic ones if List
+// is set.
+type Dir struct {
+	// List generates the entries of the directory, the static ones are
+	// ignored if set.
+	List ListFunc
+	// Perm are the permissions of the directory, by default 0555.
+	Perm os.FileMode
+
+	entries map[string]Node
+}
+
+func (d *Dir) mode() os.FileMode {
+	if d.Perm != 0 {
+		return os.ModeDir | d.Perm.Perm()
+	}
+
+	return os.ModeDir | 0555
+}
+
+// children returns the entries of the directory.
+func (d *Dir) children() (map[string]Node, error) {
+	if d.List != nil {
+		return d.List()
+	}
+
+	return d.entries, nil
+}
+
+// Static returns a read-only File with the given content.
+func Static(content []byte) *File {
+	return &File{Read: func() ([]byte, error) {
+		return content, nil
+	}}
+}
+
+// Synth is a filesystem of synthetic files.
+type Synth struct {
+	m    sync.RWMutex
+	root *Dir
+}
+
+// New returns a new empty Synth.
+func New() *Synth {
+	return &Synth{root: &Dir{}}
+}
+
+// Add adds the given node to the tree, creating its parents as needed. It
+// fails if the node exists, or its parent is a file or a dynamic directory.
+// The functions of the nodes must not call Add.
+func (fs *Synth) Add(filename string, n Node) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	p := clean(filename)
+	if p == "" {
+		return &os.PathError{Op: "add", Path: filename, Err: os.ErrExist}
+	}
+
+	dir := fs.root
+	elems := strings.Split(p, "/")
+	for _, name := range elems[:len(elems)-1] {
+		child, ok := dir.entries[name]
+		if !ok {
+			child = &Dir{}
+			dir.add(name, child)
+		}
+
+		d, ok := child.(*Dir)
+		if !ok || d.List != nil {
+			return &os.PathError{Op: "add", Path: filename, Err: errNotDir}
+		}
+
+		dir = d
+	}
+
+	if dir.List != nil {
+		return &os.PathError{Op: "add", Path: filename, Err: errNotDir}
+	}
+
+	name := elems[len(elems)-1]
+	if _, ok := dir.entries[name]; ok {
+		return &os.PathError{Op: "add", Path: filename, Err: os.ErrExist}
+	}
+
+	dir.add(name, n)
+	return nil
+}
+
+func (d *Dir) add(name string, n Node) {
+	if d.entries == nil {
+		d.entries = make(map[string]Node)
+	}
+
+	d.entries[name] = n
+}
+
+func (fs *Synth) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Synth) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file, creating files isn't supported. The
+// content is generated when opened for reading, and when opened for
+// read-write without truncating, so it can be edited.
+func (fs *Synth) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	n, err := fs.lookup(filename)
+	if os.IsNotExist(err) && flag&os.O_CREATE != 0 {
+		return nil, billy.ErrReadOnly
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrExist}
+	}
+
+	sf, ok := n.(*File)
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: errIsDir}
+	}
+
+	read := flag&os.O_WRONLY == 0
+	if read && sf.Read == nil || isWrite(flag) && sf.Write == nil {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrPermission}
+	}
+
+	f := &file{File: memfile.New(filename, flag, nil), node: sf}
+	if read && flag&os.O_TRUNC == 0 {
+		content, err := sf.Read()
+		if err != nil {
+			return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+		}
+
+		f.Content = append([]byte(nil), content...)
+	}
+
+	return f, nil
+}
+
+// Stat returns the FileInfo of the given file. The size of the files is
+// always 0, since it isn't known until their content is generated.
+func (fs *Synth) Stat(filename string) (os.FileInfo, error) {
+	n, err := fs.lookup(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return newFileInfo(path.Base("/"+clean(filename)), n, 0), nil
+}
+
+// Lstat is the same than Stat, since there are no symlinks.
+func (fs *Synth) Lstat(filename string) (os.FileInfo, error) {
+	return fs.Stat(filename)
+}
+
+func (fs *Synth) ReadDir(filename string) ([]os.FileInfo, error) {
+	n, err := fs.lookup(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	d, ok := n.(*Dir)
+	if !ok {
+		return nil, &os.PathError{Op: "readdir", Path: filename, Err: errNotDir}
+	}
+
+	children, err := fs.children(d)
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: filename, Err: err}
+	}
+
+	infos := make([]os.FileInfo, 0, len(children))
+	for name, child := range children {
+		if child == nil {
+			continue
+		}
+
+		infos = append(infos, newFileInfo(name, child, 0))
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+
+	return infos, nil
+}
+
+func (fs *Synth) Readlink(link string) (string, error) {
+	if _, err := fs.lookup(link); err != nil {
+		return "", err
+	}
+
+	return "", &os.PathError{Op: "readlink", Path: link, Err: errNotLink}
+}
+
+func (fs *Synth) Rename(from, to string) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *Synth) Remove(filename string) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *Synth) MkdirAll(filename string, perm os.FileMode) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *Synth) Symlink(target, link string) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *Synth) TempFile(dir, prefix string) (billy.File, error) {
+	return nil, billy.ErrReadOnly
+}
+
+func (fs *Synth) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (fs *Synth) Chroot(p string) (billy.Filesystem, error) {
+	return chroot.New(fs, fs.Join(fs.Root(), p)), nil
+}
+
+func (fs *Synth) Root() string {
+	return string(filepath.Separator)
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Synth) Capabilities() billy.Capability {
+	return billy.WriteCapability |
+		billy.ReadCapability |
+		billy.ReadAndWriteCapability |
+		billy.SeekCapability |
+		billy.TruncateCapability
+}
+
+// lookup returns the node of the given file, listing the dynamic
+// directories traversed.
+func (fs *Synth) lookup(filename string) (Node, error) {
+	var n Node = fs.root
+	p := clean(filename)
+	if p == "" {
+		return n, nil
+	}
+
+	for _, name := range strings.Split(p, "/") {
+		d, ok := n.(*Dir)
+		if !ok {
+			return nil, &os.PathError{Op: "open", Path: filename, Err: errNotDir}
+		}
+
+		children, err := fs.children(d)
+		if err != nil {
+			return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+		}
+
+		if n, ok = children[name]; !ok || n == nil {
+			return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrNotExist}
+		}
+	}
+
+	return n, nil
+}
+
+func (fs *Synth) children(d *Dir) (map[string]Node, error) {
+	fs.m.RLock()
+	defer fs.m.RUnlock()
+
+	return d.children()
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func newFileInfo(name string, n Node, size int64) *fileInfo {
+	return &fileInfo{name: name, size: size, mode: n.mode(), modTime: time.Now()}
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.size
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	return fi.mode
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.mode.IsDir()
+}
+
+func (*fileInfo) Sys() interface{} {
+	return nil
+}
+
+// clean returns the given path relative to the root, using forward slashes
+// as separator, and "" for the root itself.
+func clean(name string) string {
+	name = path.Clean("/" + filepath.ToSlash(name))
+	return strings.TrimPrefix(name, "/")
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0
+}
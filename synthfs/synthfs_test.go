@@ -0,0 +1,236 @@
+package synthfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&SynthSuite{})
+
+type SynthSuite struct {
+	FS *Synth
+
+	opens   int
+	written []string
+	conns   []string
+}
+
+func (s *SynthSuite) SetUpTest(c *C) {
+	s.FS = New()
+	s.opens, s.written, s.conns = 0, nil, []string{"1", "2"}
+
+	c.Assert(s.FS.Add("version", Static([]byte("1.0"))), IsNil)
+	c.Assert(s.FS.Add("stats/opens", &File{Read: func() ([]byte, error) {
+		s.opens++
+		return []byte(fmt.Sprint(s.opens)), nil
+	}}), IsNil)
+
+	config := []byte("debug=false")
+	c.Assert(s.FS.Add("config", &File{
+		Read: func() ([]byte, error) {
+			return config, nil
+		},
+		Write: func(content []byte) error {
+			if len(content) == 0 {
+				return errors.New("empty config")
+			}
+
+			config = content
+			return nil
+		},
+	}), IsNil)
+
+	c.Assert(s.FS.Add("ctl", &File{Write: func(content []byte) error {
+		s.written = append(s.written, string(content))
+		return nil
+	}}), IsNil)
+
+	c.Assert(s.FS.Add("conns", &Dir{List: func() (map[string]Node, error) {
+		entries := make(map[string]Node)
+		for _, id := range s.conns {
+			entries[id] = Static([]byte("conn " + id))
+		}
+
+		return entries, nil
+	}}), IsNil)
+}
+
+func (s *SynthSuite) TestRead(c *C) {
+	c.Assert(test.ReadFile(c, s.FS, "version"), Equals, "1.0")
+	c.Assert(test.ReadFile(c, s.FS, "stats/opens"), Equals, "1")
+	c.Assert(test.ReadFile(c, s.FS, "stats/opens"), Equals, "2")
+}
+
+func (s *SynthSuite) TestReadSnapshot(c *C) {
+	f, err := s.FS.Open("stats/opens")
+	c.Assert(err, IsNil)
+	s.opens = 10
+
+	size, err := f.Seek(0, io.SeekEnd)
+	c.Assert(err, IsNil)
+	c.Assert(size, Equals, int64(1))
+
+	_, err = f.Seek(0, io.SeekStart)
+	c.Assert(err, IsNil)
+
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "1")
+	c.Assert(f.Close(), IsNil)
+}
+
+func (s *SynthSuite) TestReadError(c *C) {
+	c.Assert(s.FS.Add("broken", &File{Read: func() ([]byte, error) {
+		return nil, errors.New("foo")
+	}}), IsNil)
+
+	_, err := s.FS.Open("broken")
+	c.Assert(err, ErrorMatches, "open broken: foo")
+}
+
+func (s *SynthSuite) TestWrite(c *C) {
+	c.Assert(util.WriteFile(s.FS, "ctl", []byte("reload"), 0), IsNil)
+	c.Assert(s.written, DeepEquals, []string{"reload"})
+
+	// nothing is delivered if not written
+	f, err := s.FS.OpenFile("ctl", os.O_WRONLY, 0)
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+	c.Assert(s.written, HasLen, 1)
+
+	f, err = s.FS.OpenFile("ctl", os.O_WRONLY, 0)
+	c.Assert(err, IsNil)
+	_, err = f.Read(make([]byte, 1))
+	c.Assert(err, NotNil)
+	c.Assert(f.Close(), IsNil)
+}
+
+func (s *SynthSuite) TestWriteError(c *C) {
+	f, err := s.FS.OpenFile("config", os.O_WRONLY|os.O_TRUNC, 0)
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), ErrorMatches, "close config: empty config")
+	c.Assert(test.ReadFile(c, s.FS, "config"), Equals, "debug=false")
+}
+
+func (s *SynthSuite) TestReadWrite(c *C) {
+	f, err := s.FS.OpenFile("config", os.O_RDWR, 0)
+	c.Assert(err, IsNil)
+	_, err = f.Seek(6, io.SeekStart)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("true"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Truncate(10), IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	c.Assert(test.ReadFile(c, s.FS, "config"), Equals, "debug=true")
+}
+
+func (s *SynthSuite) TestPermissions(c *C) {
+	_, err := s.FS.OpenFile("version", os.O_RDWR, 0)
+	c.Assert(os.IsPermission(err), Equals, true)
+
+	_, err = s.FS.Open("ctl")
+	c.Assert(os.IsPermission(err), Equals, true)
+
+	for name, mode := range map[string]os.FileMode{
+		"version": 0444,
+		"ctl":     0222,
+		"config":  0666,
+		"stats":   os.ModeDir | 0555,
+	} {
+		fi, err := s.FS.Stat(name)
+		c.Assert(err, IsNil)
+		c.Assert(fi.Mode(), Equals, mode)
+		c.Assert(fi.Size(), Equals, int64(0))
+	}
+}
+
+func (s *SynthSuite) TestDynamicDir(c *C) {
+	c.Assert(test.ReadFile(c, s.FS, "conns/1"), Equals, "conn 1")
+
+	infos, err := s.FS.ReadDir("conns")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 2)
+	c.Assert(infos[0].Name(), Equals, "1")
+	c.Assert(infos[1].Name(), Equals, "2")
+
+	s.conns = []string{"3"}
+	_, err = s.FS.Stat("conns/1")
+	c.Assert(os.IsNotExist(err), Equals, true)
+	c.Assert(test.ReadFile(c, s.FS, "conns/3"), Equals, "conn 3")
+
+	err = s.FS.Add("conns/4", Static(nil))
+	c.Assert(err, ErrorMatches, "add conns/4: not a directory")
+}
+
+func (s *SynthSuite) TestAdd(c *C) {
+	err := s.FS.Add("version", Static(nil))
+	c.Assert(os.IsExist(err), Equals, true)
+
+	err = s.FS.Add("version/foo", Static(nil))
+	c.Assert(err, ErrorMatches, "add version/foo: not a directory")
+
+	err = s.FS.Add("/", Static(nil))
+	c.Assert(os.IsExist(err), Equals, true)
+
+	c.Assert(s.FS.Add("/stats/../stats/uptime", Static(nil)), IsNil)
+	_, err = s.FS.Stat("stats/uptime")
+	c.Assert(err, IsNil)
+}
+
+func (s *SynthSuite) TestReadDir(c *C) {
+	infos, err := s.FS.ReadDir("/")
+	c.Assert(err, IsNil)
+
+	var names []string
+	for _, fi := range infos {
+		names = append(names, fi.Name())
+	}
+
+	c.Assert(names, DeepEquals, []string{"config", "conns", "ctl", "stats", "version"})
+
+	_, err = s.FS.ReadDir("version")
+	c.Assert(err, ErrorMatches, "readdir version: not a directory")
+
+	_, err = s.FS.Open("stats")
+	c.Assert(err, ErrorMatches, "open stats: is a directory")
+}
+
+func (s *SynthSuite) TestReadOnlyTree(c *C) {
+	_, err := s.FS.Create("new")
+	c.Assert(err, Equals, billy.ErrReadOnly)
+
+	_, err = s.FS.OpenFile("config", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	c.Assert(os.IsExist(err), Equals, true)
+
+	c.Assert(s.FS.Remove("version"), Equals, billy.ErrReadOnly)
+	c.Assert(s.FS.Rename("version", "foo"), Equals, billy.ErrReadOnly)
+	c.Assert(s.FS.MkdirAll("dir", 0755), Equals, billy.ErrReadOnly)
+	c.Assert(s.FS.Symlink("version", "link"), Equals, billy.ErrReadOnly)
+
+	_, err = s.FS.Readlink("version")
+	c.Assert(err, ErrorMatches, "readlink version: not a symlink")
+}
+
+func (s *SynthSuite) TestChroot(c *C) {
+	fs, err := s.FS.Chroot("conns")
+	c.Assert(err, IsNil)
+	c.Assert(test.ReadFile(c, fs, "2"), Equals, "conn 2")
+}
+
+func (s *SynthSuite) TestCapabilities(c *C) {
+	c.Assert(billy.CapabilityCheck(s.FS, billy.WriteCapability), Equals, true)
+	c.Assert(billy.CapabilityCheck(s.FS, billy.LockCapability), Equals, false)
+}
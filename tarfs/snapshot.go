@@ -0,0 +1,410 @@
+package tarfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/internal/linkutil"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+// ErrSnapshotClosed is returned by any operation over a closed Snapshot.
+var ErrSnapshotClosed = errors.New("tar snapshot closed")
+
+// Snapshot is an in-memory filesystem loaded from a tar.gz archive, and
+// written back as a new archive on Flush or Close. The archive is replaced
+// atomically, writing a temporary file next to it and renaming it, so it's
+// never left half written.
+type Snapshot struct {
+	billy.Filesystem
+	storage  billy.Filesystem
+	filename string
+
+	m sync.Mutex
+	// modTimes are the modification times of the entries of the archive
+	// not changed since loaded, the rest are stamped when flushed.
+	modTimes map[string]time.Time
+	changed  bool
+	closed   bool
+}
+
+// NewSnapshot returns a new Snapshot of the tar.gz archive with the given
+// name in storage. If the archive doesn't exist, the Snapshot starts empty,
+// and the archive is created on the first Flush or Close.
+func NewSnapshot(storage billy.Filesystem, filename string) (*Snapshot, error) {
+	s := &Snapshot{
+		Filesystem: memfs.New(),
+		storage:    storage,
+		filename:   filename,
+		modTimes:   make(map[string]time.Time),
+	}
+
+	f, err := storage.Open(filename)
+	if os.IsNotExist(err) {
+		s.changed = true
+		return s, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	if err := s.load(f); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// load extracts the archive read from r, keeping the modification times of
+// its entries.
+func (s *Snapshot) load(r io.Reader) error {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+
+	content, err := ioutil.ReadAll(gr)
+	if err != nil {
+		return err
+	}
+
+	if err := util.TarExtract(s.Filesystem, "/", bytes.NewReader(content), nil); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(bytes.NewReader(content))
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		s.modTimes[clean(h.Name)] = h.ModTime
+	}
+}
+
+func (s *Snapshot) Create(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (s *Snapshot) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if !isWrite(flag) {
+		return s.Filesystem.OpenFile(filename, flag, perm)
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if err := s.change(filename); err != nil {
+		return nil, err
+	}
+
+	f, err := s.Filesystem.OpenFile(filename, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &snapshotFile{File: f, s: s}, nil
+}
+
+func (s *Snapshot) Rename(from, to string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for _, name := range []string{from, to} {
+		if err := s.change(name); err != nil {
+			return err
+		}
+	}
+
+	if err := s.Filesystem.Rename(from, to); err != nil {
+		return err
+	}
+
+	// the entries moved are stamped when flushed
+	from, to = clean(from), clean(to)
+	for name := range s.modTimes {
+		if isChild(name, from) || isChild(name, to) {
+			delete(s.modTimes, name)
+		}
+	}
+
+	return nil
+}
+
+func (s *Snapshot) Remove(filename string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if err := s.change(filename); err != nil {
+		return err
+	}
+
+	return s.Filesystem.Remove(filename)
+}
+
+func (s *Snapshot) MkdirAll(filename string, perm os.FileMode) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if err := s.change(filename); err != nil {
+		return err
+	}
+
+	return s.Filesystem.MkdirAll(filename, perm)
+}
+
+func (s *Snapshot) Symlink(target, link string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if err := s.change(link); err != nil {
+		return err
+	}
+
+	return s.Filesystem.Symlink(target, link)
+}
+
+func (s *Snapshot) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(s, dir, prefix)
+}
+
+func (s *Snapshot) Chroot(path string) (billy.Filesystem, error) {
+	return chroot.New(s, s.Join(s.Root(), path)), nil
+}
+
+// Capabilities implements the Capable interface.
+func (s *Snapshot) Capabilities() billy.Capability {
+	return billy.Capabilities(s.Filesystem)
+}
+
+// change marks the given file, and its parents, as changed.
+func (s *Snapshot) change(filename string) error {
+	if s.closed {
+		return ErrSnapshotClosed
+	}
+
+	for name := clean(filename); ; name = linkutil.Parent(name) {
+		delete(s.modTimes, name)
+		if name == "" {
+			break
+		}
+	}
+
+	s.changed = true
+	return nil
+}
+
+// Flush writes the archive if anything changed since loaded or flushed. The
+// files still open for writing are written with the content written so far.
+func (s *Snapshot) Flush() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.closed {
+		return ErrSnapshotClosed
+	}
+
+	return s.flush()
+}
+
+func (s *Snapshot) flush() error {
+	if !s.changed {
+		return nil
+	}
+
+	tmp, err := s.storage.TempFile(filepath.Dir(s.filename), "."+filepath.Base(s.filename))
+	if err != nil {
+		return err
+	}
+
+	if err := s.write(tmp); err != nil {
+		tmp.Close()
+		s.storage.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		s.storage.Remove(tmp.Name())
+		return err
+	}
+
+	if err := s.storage.Rename(tmp.Name(), s.filename); err != nil {
+		s.storage.Remove(tmp.Name())
+		return err
+	}
+
+	s.changed = false
+	return nil
+}
+
+// write writes the archive to w, stamping the entries changed with the
+// current time.
+func (s *Snapshot) write(w io.Writer) error {
+	now := time.Now()
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	err := walk(s.Filesystem, "", func(name string, fi os.FileInfo) error {
+		modTime, ok := s.modTimes[name]
+		if !ok {
+			modTime = now
+		}
+
+		return s.addEntry(tw, name, fi, modTime)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	return gw.Close()
+}
+
+func (s *Snapshot) addEntry(tw *tar.Writer, name string, fi os.FileInfo, modTime time.Time) error {
+	var link string
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := s.Filesystem.Readlink(filepath.FromSlash(name))
+		if err != nil {
+			return err
+		}
+
+		link = filepath.ToSlash(target)
+	}
+
+	h, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return err
+	}
+
+	h.Name, h.ModTime = name, modTime
+	if fi.IsDir() {
+		h.Name += "/"
+	}
+
+	if err := tw.WriteHeader(h); err != nil {
+		return err
+	}
+
+	if !fi.Mode().IsRegular() {
+		return nil
+	}
+
+	f, err := s.Filesystem.Open(filepath.FromSlash(name))
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+	_, err = io.CopyN(tw, f, h.Size)
+	return err
+}
+
+// Close flushes the Snapshot, any operation changing it fails afterwards.
+func (s *Snapshot) Close() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.closed {
+		return ErrSnapshotClosed
+	}
+
+	if err := s.flush(); err != nil {
+		return err
+	}
+
+	s.closed = true
+	return nil
+}
+
+// snapshotFile is a file open for writing, marking the Snapshot as changed
+// when written, even if flushed after opened.
+type snapshotFile struct {
+	billy.File
+	s *Snapshot
+}
+
+func (f *snapshotFile) Write(p []byte) (int, error) {
+	if err := f.s.touch(); err != nil {
+		return 0, err
+	}
+
+	return f.File.Write(p)
+}
+
+func (f *snapshotFile) Truncate(size int64) error {
+	if err := f.s.touch(); err != nil {
+		return err
+	}
+
+	return f.File.Truncate(size)
+}
+
+func (s *Snapshot) touch() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.closed {
+		return ErrSnapshotClosed
+	}
+
+	s.changed = true
+	return nil
+}
+
+// walk calls fn for every entry under dir, sorted by name, parents first.
+func walk(fs billy.Filesystem, dir string, fn func(name string, fi os.FileInfo) error) error {
+	entries, err := fs.ReadDir(filepath.FromSlash("/" + dir))
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	for _, fi := range entries {
+		name := path.Join(dir, fi.Name())
+		if err := fn(name, fi); err != nil {
+			return err
+		}
+
+		if fi.IsDir() {
+			if err := walk(fs, name, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func isChild(name, dir string) bool {
+	return dir == "" || len(name) > len(dir) && name[len(dir)] == '/' && name[:len(dir)] == dir
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0
+}
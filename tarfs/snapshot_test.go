@@ -0,0 +1,194 @@
+package tarfs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&SnapshotSuite{})
+
+type SnapshotSuite struct {
+	test.FilesystemSuite
+	storage  billy.Filesystem
+	snapshot *Snapshot
+}
+
+func (s *SnapshotSuite) SetUpTest(c *C) {
+	s.storage = memfs.New()
+	s.snapshot = s.open(c)
+	s.FilesystemSuite = test.NewFilesystemSuite(s.snapshot)
+}
+
+func (s *SnapshotSuite) open(c *C) *Snapshot {
+	fs, err := NewSnapshot(s.storage, "archive.tar.gz")
+	c.Assert(err, IsNil)
+	return fs
+}
+
+// archive returns the filesystem of the archive written to storage.
+func (s *SnapshotSuite) archive(c *C) billy.Filesystem {
+	f, err := s.storage.Open("archive.tar.gz")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	c.Assert(err, IsNil)
+
+	fs, err := NewFromStream(gr)
+	c.Assert(err, IsNil)
+	return fs
+}
+
+func (s *SnapshotSuite) writeArchive(c *C, content []byte) {
+	buf := bytes.NewBuffer(nil)
+	gw := gzip.NewWriter(buf)
+	_, err := gw.Write(content)
+	c.Assert(err, IsNil)
+	c.Assert(gw.Close(), IsNil)
+	c.Assert(util.WriteFile(s.storage, "archive.tar.gz", buf.Bytes(), 0644), IsNil)
+}
+
+func (s *SnapshotSuite) TestLoad(c *C) {
+	s.writeArchive(c, buildTar(c))
+	fs := s.open(c)
+
+	c.Assert(test.ReadFile(c, fs, "foo"), Equals, "hello world")
+	c.Assert(test.ReadFile(c, fs, "qux/bar"), Equals, "replaced")
+	c.Assert(test.ReadFile(c, fs, "hard"), Equals, "hello world")
+	c.Assert(test.ReadFile(c, fs, longName), Equals, "long")
+
+	target, err := fs.Readlink("link")
+	c.Assert(err, IsNil)
+	c.Assert(target, Equals, "qux/bar")
+}
+
+func (s *SnapshotSuite) TestNotExists(c *C) {
+	c.Assert(util.WriteFile(s.snapshot, "foo", []byte("foo"), 0644), IsNil)
+
+	_, err := s.storage.Stat("archive.tar.gz")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	c.Assert(s.snapshot.Flush(), IsNil)
+	c.Assert(test.ReadFile(c, s.archive(c), "foo"), Equals, "foo")
+}
+
+func (s *SnapshotSuite) TestFlush(c *C) {
+	c.Assert(util.WriteFile(s.snapshot, "qux/bar", []byte("bar"), 0600), IsNil)
+	c.Assert(s.snapshot.MkdirAll("empty", 0755), IsNil)
+	c.Assert(s.snapshot.Symlink("qux/bar", "link"), IsNil)
+	c.Assert(s.snapshot.Flush(), IsNil)
+
+	fs := s.archive(c)
+	c.Assert(test.ReadFile(c, fs, "link"), Equals, "bar")
+
+	fi, err := fs.Stat("qux/bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode(), Equals, os.FileMode(0600))
+
+	fi, err = fs.Stat("empty")
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+
+	// the temporary file is renamed over the archive
+	infos, err := s.storage.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 1)
+
+	c.Assert(s.snapshot.Remove("link"), IsNil)
+	c.Assert(s.snapshot.Flush(), IsNil)
+
+	_, err = s.archive(c).Lstat("link")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *SnapshotSuite) TestFlushNotChanged(c *C) {
+	s.writeArchive(c, buildTar(c))
+	fs := s.open(c)
+
+	c.Assert(s.storage.Remove("archive.tar.gz"), IsNil)
+	c.Assert(test.ReadFile(c, fs, "foo"), Equals, "hello world")
+	c.Assert(fs.Close(), IsNil)
+
+	_, err := s.storage.Stat("archive.tar.gz")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *SnapshotSuite) TestModTime(c *C) {
+	s.writeArchive(c, buildTar(c))
+	fs := s.open(c)
+
+	c.Assert(util.WriteFile(fs, "qux/baz/b", []byte("b"), 0644), IsNil)
+	c.Assert(fs.Close(), IsNil)
+
+	mtime := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	archive := s.archive(c)
+	for _, name := range []string{"foo", "qux/bar"} {
+		fi, err := archive.Stat(name)
+		c.Assert(err, IsNil)
+		c.Assert(fi.ModTime().Equal(mtime), Equals, true)
+	}
+
+	// the entries changed, and their parents, are stamped
+	for _, name := range []string{"qux", "qux/baz", "qux/baz/b"} {
+		fi, err := archive.Stat(name)
+		c.Assert(err, IsNil)
+		c.Assert(fi.ModTime().After(mtime), Equals, true)
+	}
+}
+
+func (s *SnapshotSuite) TestWriteAfterFlush(c *C) {
+	f, err := s.snapshot.Create("foo")
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("foo"))
+	c.Assert(err, IsNil)
+	c.Assert(s.snapshot.Flush(), IsNil)
+	c.Assert(test.ReadFile(c, s.archive(c), "foo"), Equals, "foo")
+
+	_, err = f.Write([]byte("bar"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+	c.Assert(s.snapshot.Flush(), IsNil)
+	c.Assert(test.ReadFile(c, s.archive(c), "foo"), Equals, "foobar")
+}
+
+func (s *SnapshotSuite) TestClose(c *C) {
+	f, err := s.snapshot.Create("foo")
+	c.Assert(err, IsNil)
+	c.Assert(s.snapshot.Close(), IsNil)
+
+	_, err = f.Write([]byte("foo"))
+	c.Assert(err, Equals, ErrSnapshotClosed)
+	_, err = s.snapshot.Create("bar")
+	c.Assert(err, Equals, ErrSnapshotClosed)
+	c.Assert(s.snapshot.Remove("foo"), Equals, ErrSnapshotClosed)
+	c.Assert(s.snapshot.Flush(), Equals, ErrSnapshotClosed)
+	c.Assert(s.snapshot.Close(), Equals, ErrSnapshotClosed)
+
+	// reading is still allowed
+	c.Assert(test.ReadFile(c, s.snapshot, "foo"), Equals, "")
+}
+
+func (s *SnapshotSuite) TestCorrupted(c *C) {
+	c.Assert(util.WriteFile(s.storage, "archive.tar.gz", []byte("foo"), 0644), IsNil)
+
+	_, err := NewSnapshot(s.storage, "archive.tar.gz")
+	c.Assert(err, NotNil)
+}
+
+func (s *SnapshotSuite) TestChrootChanges(c *C) {
+	fs, err := s.snapshot.Chroot("qux")
+	c.Assert(err, IsNil)
+	c.Assert(util.WriteFile(fs, "bar", []byte("bar"), 0644), IsNil)
+	c.Assert(s.snapshot.Flush(), IsNil)
+
+	c.Assert(test.ReadFile(c, s.archive(c), "qux/bar"), Equals, "bar")
+}
@@ -0,0 +1,398 @@
+// Package tarfs provides a read-only billy filesystem over a tar archive.
+//
+// The archive is read once to build an index of its entries, the content of
+// the files is read on demand from the given io.ReaderAt. Hard links,
+// symbolic links and PAX extended headers are supported.
+//
+// Snapshot provides a writable in-memory copy of a tar.gz archive, written
+// back as a new archive when flushed.
+package tarfs // import "gopkg.in/src-d/go-billy.v4/tarfs"
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/internal/linkutil"
+)
+
+// Tar is a read-only filesystem based on a tar archive.
+type Tar struct {
+	entries  map[string]*entry
+	children map[string][]string
+}
+
+type entry struct {
+	name   string
+	header *tar.Header
+	data   io.ReaderAt
+	size   int64
+	dir    bool
+}
+
+// New returns a new read-only filesystem from the tar archive readable from
+// r. The archive is read sequentially once to build the index.
+func New(r io.ReaderAt) (billy.Filesystem, error) {
+	cr := &countingReader{r: io.NewSectionReader(r, 0, math.MaxInt64)}
+	return build(tar.NewReader(cr), func(h *tar.Header, tr *tar.Reader) (io.ReaderAt, error) {
+		if isSparse(h) {
+			return readAll(tr)
+		}
+
+		return io.NewSectionReader(r, cr.n, h.Size), nil
+	})
+}
+
+// NewFromStream returns a new read-only filesystem from a tar archive that
+// can only be read sequentially, eg.: a decompressed stream. The content of
+// the files is kept in memory.
+func NewFromStream(r io.Reader) (billy.Filesystem, error) {
+	return build(tar.NewReader(r), func(h *tar.Header, tr *tar.Reader) (io.ReaderAt, error) {
+		return readAll(tr)
+	})
+}
+
+func build(tr *tar.Reader, data func(*tar.Header, *tar.Reader) (io.ReaderAt, error)) (billy.Filesystem, error) {
+	fs := &Tar{
+		entries:  map[string]*entry{"": {dir: true}},
+		children: make(map[string][]string),
+	}
+
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := fs.add(h, tr, data); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, names := range fs.children {
+		sort.Strings(names)
+	}
+
+	return chroot.New(fs, string(filepath.Separator)), nil
+}
+
+func (fs *Tar) add(h *tar.Header, tr *tar.Reader, data func(*tar.Header, *tar.Reader) (io.ReaderAt, error)) error {
+	name := clean(h.Name)
+	if name == "" {
+		return nil
+	}
+
+	e := &entry{name: name, header: h}
+	switch h.Typeflag {
+	case tar.TypeDir:
+		e.dir = true
+	case tar.TypeReg, tar.TypeRegA, tar.TypeGNUSparse:
+		r, err := data(h, tr)
+		if err != nil {
+			return err
+		}
+
+		e.data, e.size = r, h.Size
+	case tar.TypeLink:
+		target, ok := fs.entries[clean(h.Linkname)]
+		if !ok || target.dir {
+			return fmt.Errorf("invalid hard link %q to %q", h.Name, h.Linkname)
+		}
+
+		e.data, e.size = target.data, target.size
+		e.header = linkHeader(h, target.header)
+	case tar.TypeSymlink:
+	default:
+		// devices, fifos and other special files are not supported.
+		return nil
+	}
+
+	if old, ok := fs.entries[name]; ok {
+		// a later entry replaces the previous one, except for directories
+		// where only the metadata is updated.
+		if old.dir && e.dir {
+			old.header = h
+			return nil
+		}
+
+		fs.entries[name] = e
+		return nil
+	}
+
+	fs.entries[name] = e
+	fs.addParents(name)
+	return nil
+}
+
+// linkHeader returns the header of a hard link, being the one of the target
+// with the name of the link.
+func linkHeader(link, target *tar.Header) *tar.Header {
+	h := *target
+	h.Name = link.Name
+	return &h
+}
+
+func (fs *Tar) addParents(name string) {
+	dir := linkutil.Parent(name)
+	fs.children[dir] = append(fs.children[dir], path.Base(name))
+
+	if _, ok := fs.entries[dir]; ok {
+		return
+	}
+
+	fs.entries[dir] = &entry{name: dir, dir: true}
+	fs.addParents(dir)
+}
+
+func (fs *Tar) Create(filename string) (billy.File, error) {
+	return nil, billy.ErrReadOnly
+}
+
+func (fs *Tar) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (fs *Tar) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, billy.ErrReadOnly
+	}
+
+	e, err := fs.resolve(filename, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.dir {
+		return nil, fmt.Errorf("cannot open directory: %s", filename)
+	}
+
+	return newFile(filename, e), nil
+}
+
+func (fs *Tar) Stat(filename string) (os.FileInfo, error) {
+	e, err := fs.resolve(filename, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return newFileInfo(path.Base(clean(filename)), e), nil
+}
+
+func (fs *Tar) Lstat(filename string) (os.FileInfo, error) {
+	e, err := fs.resolve(filename, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return newFileInfo(path.Base(clean(filename)), e), nil
+}
+
+func (fs *Tar) ReadDir(filename string) ([]os.FileInfo, error) {
+	e, err := fs.resolve(filename, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if !e.dir {
+		return nil, &os.PathError{Op: "readdir", Path: filename, Err: errors.New("not a directory")}
+	}
+
+	var entries []os.FileInfo
+	for _, name := range fs.children[e.name] {
+		child := fs.entries[path.Join(e.name, name)]
+		entries = append(entries, newFileInfo(name, child))
+	}
+
+	return entries, nil
+}
+
+func (fs *Tar) Readlink(link string) (string, error) {
+	e, err := fs.resolve(link, false)
+	if err != nil {
+		return "", err
+	}
+
+	if !e.isSymlink() {
+		return "", &os.PathError{Op: "readlink", Path: link, Err: errors.New("not a symlink")}
+	}
+
+	return filepath.FromSlash(e.header.Linkname), nil
+}
+
+func (fs *Tar) Rename(from, to string) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *Tar) Remove(filename string) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *Tar) MkdirAll(filename string, perm os.FileMode) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *Tar) Symlink(target, link string) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *Tar) TempFile(dir, prefix string) (billy.File, error) {
+	return nil, billy.ErrReadOnly
+}
+
+func (fs *Tar) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Tar) Capabilities() billy.Capability {
+	return billy.ReadCapability | billy.SeekCapability
+}
+
+// resolve returns the entry of the given filename, following the symlinks
+// found in any of the parent directories, and in the file itself if follow
+// is true.
+func (fs *Tar) resolve(filename string, follow bool) (*entry, error) {
+	name, _, err := linkutil.Resolve(clean(filename), follow, "", fs.lookup, fs.readlink)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: filename, Err: err}
+	}
+
+	return fs.entries[name], nil
+}
+
+// lookup returns the path of the entry with the given name in the directory
+// dir, also given by its path, since the entries are indexed by path.
+func (fs *Tar) lookup(dir interface{}, name string) (interface{}, bool, error) {
+	p := path.Join(dir.(string), name)
+	e, ok := fs.entries[p]
+	if !ok {
+		return nil, false, os.ErrNotExist
+	}
+
+	return p, e.isSymlink(), nil
+}
+
+func (fs *Tar) readlink(link interface{}) (string, error) {
+	return fs.entries[link.(string)].header.Linkname, nil
+}
+
+func (e *entry) isSymlink() bool {
+	return e.header != nil && e.header.Typeflag == tar.TypeSymlink
+}
+
+type fileInfo struct {
+	name  string
+	entry *entry
+}
+
+func newFileInfo(name string, e *entry) os.FileInfo {
+	if name == "" || name == "." {
+		name = string(filepath.Separator)
+	}
+
+	return &fileInfo{name: name, entry: e}
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.entry.size
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.entry.header == nil {
+		return os.ModeDir | 0755
+	}
+
+	mode := fi.entry.header.FileInfo().Mode()
+	if fi.entry.dir {
+		mode |= os.ModeDir
+	}
+
+	return mode
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	if fi.entry.header == nil {
+		return time.Time{}
+	}
+
+	return fi.entry.header.ModTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.entry.dir
+}
+
+// Sys returns the *tar.Header of the entry, or nil for the implicit
+// directories.
+func (fi *fileInfo) Sys() interface{} {
+	if fi.entry.header == nil {
+		return nil
+	}
+
+	return fi.entry.header
+}
+
+// countingReader keeps track of the amount of bytes read, used to know the
+// offset of the content of every entry.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// isSparse returns true for GNU sparse files, in both the old GNU and the PAX
+// formats, whose data in the archive doesn't match the content of the file.
+func isSparse(h *tar.Header) bool {
+	if h.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+
+	for k := range h.PAXRecords {
+		if strings.HasPrefix(k, "GNU.sparse.") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func readAll(r io.Reader) (io.ReaderAt, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(content), nil
+}
+
+// clean returns the given path relative to the root of the archive, using
+// forward slashes as separator, and "" for the root itself.
+func clean(name string) string {
+	name = path.Clean("/" + filepath.ToSlash(name))
+	return strings.TrimPrefix(name, "/")
+}
@@ -0,0 +1,238 @@
+package tarfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/test"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&TarSuite{})
+
+type TarSuite struct {
+	FS billy.Filesystem
+}
+
+var longName = "long/" + strings.Repeat("x", 200)
+
+func buildTar(c *C) []byte {
+	buf := bytes.NewBuffer(nil)
+	w := tar.NewWriter(buf)
+
+	mtime := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	add := func(h *tar.Header, content string) {
+		h.ModTime = mtime
+		h.Size = int64(len(content))
+		c.Assert(w.WriteHeader(h), IsNil)
+		_, err := io.WriteString(w, content)
+		c.Assert(err, IsNil)
+	}
+
+	add(&tar.Header{Name: "foo", Mode: 0644, Typeflag: tar.TypeReg}, "hello world")
+	add(&tar.Header{Name: "qux/", Mode: 0755, Typeflag: tar.TypeDir}, "")
+	add(&tar.Header{Name: "qux/bar", Mode: 0600, Typeflag: tar.TypeReg}, "bar")
+	add(&tar.Header{Name: "qux/baz/a", Mode: 0644, Typeflag: tar.TypeReg}, "a")
+	add(&tar.Header{Name: "link", Mode: 0777, Typeflag: tar.TypeSymlink, Linkname: "qux/bar"}, "")
+	add(&tar.Header{Name: "dirlink", Mode: 0777, Typeflag: tar.TypeSymlink, Linkname: "qux"}, "")
+	add(&tar.Header{Name: "hard", Mode: 0644, Typeflag: tar.TypeLink, Linkname: "foo"}, "")
+	add(&tar.Header{
+		Name: longName, Mode: 0644, Typeflag: tar.TypeReg, Format: tar.FormatPAX,
+		PAXRecords: map[string]string{"user.comment": "pax"},
+	}, "long")
+	add(&tar.Header{Name: "./qux/bar", Mode: 0644, Typeflag: tar.TypeReg}, "replaced")
+
+	c.Assert(w.Close(), IsNil)
+	return buf.Bytes()
+}
+
+func (s *TarSuite) SetUpTest(c *C) {
+	var err error
+	s.FS, err = New(bytes.NewReader(buildTar(c)))
+	c.Assert(err, IsNil)
+}
+
+func (s *TarSuite) TestOpen(c *C) {
+	f, err := s.FS.Open("foo")
+	c.Assert(err, IsNil)
+	c.Assert(f.Name(), Equals, "foo")
+
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "hello world")
+	c.Assert(f.Close(), IsNil)
+}
+
+func (s *TarSuite) TestOpenNotExists(c *C) {
+	_, err := s.FS.Open("nope")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *TarSuite) TestOpenDir(c *C) {
+	_, err := s.FS.Open("qux")
+	c.Assert(err, NotNil)
+}
+
+func (s *TarSuite) TestSeekAndReadAt(c *C) {
+	f, err := s.FS.Open("foo")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	buf := make([]byte, 5)
+	n, err := f.ReadAt(buf, 6)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf[:n]), Equals, "world")
+
+	pos, err := f.Seek(-5, io.SeekEnd)
+	c.Assert(err, IsNil)
+	c.Assert(pos, Equals, int64(6))
+
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "world")
+
+	n, err = f.ReadAt(buf, 8)
+	c.Assert(err, Equals, io.EOF)
+	c.Assert(string(buf[:n]), Equals, "rld")
+}
+
+func (s *TarSuite) TestStat(c *C) {
+	fi, err := s.FS.Stat("foo")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Name(), Equals, "foo")
+	c.Assert(fi.Size(), Equals, int64(11))
+	c.Assert(fi.Mode(), Equals, os.FileMode(0644))
+	c.Assert(fi.ModTime().Equal(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)), Equals, true)
+	c.Assert(fi.Sys(), FitsTypeOf, &tar.Header{})
+
+	fi, err = s.FS.Stat("qux/baz")
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+
+	fi, err = s.FS.Stat("/")
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+}
+
+func (s *TarSuite) TestReplacedEntry(c *C) {
+	c.Assert(test.ReadFile(c, s.FS, "qux/bar"), Equals, "replaced")
+
+	fi, err := s.FS.Stat("qux/bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode(), Equals, os.FileMode(0644))
+}
+
+func (s *TarSuite) TestHardLink(c *C) {
+	c.Assert(test.ReadFile(c, s.FS, "hard"), Equals, "hello world")
+
+	fi, err := s.FS.Lstat("hard")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Name(), Equals, "hard")
+	c.Assert(fi.Size(), Equals, int64(11))
+	c.Assert(fi.Mode().IsRegular(), Equals, true)
+}
+
+func (s *TarSuite) TestHardLinkMissingTarget(c *C) {
+	buf := bytes.NewBuffer(nil)
+	w := tar.NewWriter(buf)
+	c.Assert(w.WriteHeader(&tar.Header{Name: "hard", Typeflag: tar.TypeLink, Linkname: "nope"}), IsNil)
+	c.Assert(w.Close(), IsNil)
+
+	_, err := New(bytes.NewReader(buf.Bytes()))
+	c.Assert(err, NotNil)
+}
+
+func (s *TarSuite) TestPAX(c *C) {
+	c.Assert(test.ReadFile(c, s.FS, longName), Equals, "long")
+
+	fi, err := s.FS.Stat(longName)
+	c.Assert(err, IsNil)
+	c.Assert(fi.Sys().(*tar.Header).PAXRecords["user.comment"], Equals, "pax")
+}
+
+func (s *TarSuite) TestSymlinks(c *C) {
+	fi, err := s.FS.Lstat("link")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode()&os.ModeSymlink, Not(Equals), os.FileMode(0))
+
+	fi, err = s.FS.Stat("link")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Name(), Equals, "link")
+	c.Assert(fi.Size(), Equals, int64(8))
+
+	target, err := s.FS.Readlink("link")
+	c.Assert(err, IsNil)
+	c.Assert(target, Equals, s.FS.Join("qux", "bar"))
+
+	c.Assert(test.ReadFile(c, s.FS, "dirlink/baz/a"), Equals, "a")
+}
+
+func (s *TarSuite) TestReadDir(c *C) {
+	entries, err := s.FS.ReadDir("/")
+	c.Assert(err, IsNil)
+
+	var names []string
+	for _, fi := range entries {
+		names = append(names, fi.Name())
+	}
+
+	c.Assert(names, DeepEquals, []string{"dirlink", "foo", "hard", "link", "long", "qux"})
+
+	entries, err = s.FS.ReadDir("dirlink")
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 2)
+	c.Assert(entries[0].Name(), Equals, "bar")
+	c.Assert(entries[1].Name(), Equals, "baz")
+	c.Assert(entries[1].IsDir(), Equals, true)
+
+	_, err = s.FS.ReadDir("foo")
+	c.Assert(err, NotNil)
+}
+
+func (s *TarSuite) TestNewFromStream(c *C) {
+	fs, err := NewFromStream(bytes.NewBuffer(buildTar(c)))
+	c.Assert(err, IsNil)
+
+	c.Assert(test.ReadFile(c, fs, "foo"), Equals, "hello world")
+	c.Assert(test.ReadFile(c, fs, "hard"), Equals, "hello world")
+	c.Assert(test.ReadFile(c, fs, longName), Equals, "long")
+}
+
+func (s *TarSuite) TestChroot(c *C) {
+	fs, err := s.FS.Chroot("qux")
+	c.Assert(err, IsNil)
+	c.Assert(test.ReadFile(c, fs, "baz/a"), Equals, "a")
+}
+
+func (s *TarSuite) TestReadOnly(c *C) {
+	_, err := s.FS.Create("new")
+	c.Assert(err, Equals, billy.ErrReadOnly)
+
+	_, err = s.FS.OpenFile("foo", os.O_RDWR, 0)
+	c.Assert(err, Equals, billy.ErrReadOnly)
+
+	c.Assert(s.FS.Remove("foo"), Equals, billy.ErrReadOnly)
+	c.Assert(s.FS.Rename("foo", "bar"), Equals, billy.ErrReadOnly)
+	c.Assert(s.FS.MkdirAll("dir", 0755), Equals, billy.ErrReadOnly)
+	c.Assert(s.FS.Symlink("foo", "bar"), Equals, billy.ErrReadOnly)
+
+	f, err := s.FS.Open("foo")
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("foo"))
+	c.Assert(err, Equals, billy.ErrReadOnly)
+}
+
+func (s *TarSuite) TestCapabilities(c *C) {
+	c.Assert(billy.CapabilityCheck(s.FS, billy.WriteCapability), Equals, false)
+	c.Assert(billy.CapabilityCheck(s.FS, billy.SeekCapability), Equals, true)
+}
@@ -0,0 +1,19 @@
+package test
+
+import (
+	"io/ioutil"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// ReadFile returns the content of the given file, asserting it's read.
+func ReadFile(c *C, fs billy.Basic, filename string) string {
+	f, err := fs.Open(filename)
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	return string(content)
+}
@@ -15,6 +15,12 @@ type TempFileSuite struct {
 		billy.Basic
 		billy.TempFile
 	}
+
+	// Rounds is the number of rounds of TestTempFileMany and
+	// TestTempFileManyWithUtil, creating and removing 100 files each, 1024
+	// if 0. The filesystems making requests to a server for every file may
+	// run fewer.
+	Rounds int
 }
 
 func (s *TempFileSuite) TestTempFile(c *C) {
@@ -60,28 +66,26 @@ func (s *TempFileSuite) TestRenameTempFile(c *C) {
 }
 
 func (s *TempFileSuite) TestTempFileMany(c *C) {
-	for i := 0; i < 1024; i++ {
-		var fs []billy.File
+	s.tempFileMany(c, s.FS.TempFile)
+}
 
-		for j := 0; j < 100; j++ {
-			f, err := s.FS.TempFile("test-dir", "test-prefix")
-			c.Assert(err, IsNil)
-			fs = append(fs, f)
-		}
+func (s *TempFileSuite) TestTempFileManyWithUtil(c *C) {
+	s.tempFileMany(c, func(dir, prefix string) (billy.File, error) {
+		return util.TempFile(s.FS, dir, prefix)
+	})
+}
 
-		for _, f := range fs {
-			c.Assert(f.Close(), IsNil)
-			c.Assert(s.FS.Remove(f.Name()), IsNil)
-		}
+func (s *TempFileSuite) tempFileMany(c *C, tempFile func(dir, prefix string) (billy.File, error)) {
+	rounds := s.Rounds
+	if rounds == 0 {
+		rounds = 1024
 	}
-}
 
-func (s *TempFileSuite) TestTempFileManyWithUtil(c *C) {
-	for i := 0; i < 1024; i++ {
+	for i := 0; i < rounds; i++ {
 		var fs []billy.File
 
 		for j := 0; j < 100; j++ {
-			f, err := util.TempFile(s.FS, "test-dir", "test-prefix")
+			f, err := tempFile("test-dir", "test-prefix")
 			c.Assert(err, IsNil)
 			fs = append(fs, f)
 		}
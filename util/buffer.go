@@ -0,0 +1,47 @@
+package util
+
+import (
+	"sync"
+)
+
+const defaultBufferSize = 32 * 1024
+
+// StreamOptions holds the configuration of the buffers used by the helpers
+// reading or copying the content of the files.
+type StreamOptions struct {
+	// BufferSize is the size of the buffers, 32KiB by default.
+	BufferSize int
+}
+
+func (o *StreamOptions) bufferSize() int {
+	if o == nil || o.BufferSize <= 0 {
+		return defaultBufferSize
+	}
+
+	return o.BufferSize
+}
+
+// buffers is the pool of the buffers used by the helpers, shared by all the
+// buffer sizes, since usually only one is used.
+var buffers = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, defaultBufferSize)
+		return &b
+	},
+}
+
+// getBuffer returns a buffer of the given size from the pool, allocating a
+// new one if the pooled one is smaller. It must be returned with putBuffer.
+func getBuffer(size int) *[]byte {
+	b := buffers.Get().(*[]byte)
+	if cap(*b) < size {
+		*b = make([]byte, size)
+	}
+
+	*b = (*b)[:size]
+	return b
+}
+
+func putBuffer(b *[]byte) {
+	buffers.Put(b)
+}
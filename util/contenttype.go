@@ -0,0 +1,80 @@
+package util
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// sniffLen is the number of bytes considered by http.DetectContentType.
+const sniffLen = 512
+
+// ContentTyper is implemented by the filesystems storing the content type of
+// the files as metadata, usually object stores.
+type ContentTyper interface {
+	// ContentType returns the content type stored for the given file, or
+	// an empty string if none.
+	ContentType(filename string) (string, error)
+	// SetContentType stores the content type of the given file.
+	SetContentType(filename, contentType string) error
+}
+
+// DetectContentType returns the MIME type of the given file. The type stored
+// by the filesystems implementing ContentTyper is returned if any, otherwise
+// it's sniffed from the first bytes of the file with http.DetectContentType.
+// When the content only tells whether it's text or binary, the type
+// registered for the extension of the file is preferred, if known.
+func DetectContentType(fs billy.Basic, filename string) (string, error) {
+	if ct, ok := fs.(ContentTyper); ok {
+		t, err := ct.ContentType(filename)
+		if err != nil || t != "" {
+			return t, err
+		}
+	}
+
+	f, err := fs.Open(filename)
+	if err != nil {
+		return "", err
+	}
+
+	defer f.Close()
+
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+
+	t := http.DetectContentType(buf[:n])
+	if t != "application/octet-stream" && !strings.HasPrefix(t, "text/plain") {
+		return t, nil
+	}
+
+	if byExt := mime.TypeByExtension(filepath.Ext(filename)); byExt != "" {
+		return byExt, nil
+	}
+
+	return t, nil
+}
+
+// TagContentType stores in the file dst of dstFS the content type of the file
+// src of srcFS, as returned by DetectContentType, if dstFS implements
+// ContentTyper. Otherwise it does nothing. It's called by CopyFile, and so by
+// CopyDir and Sync, and meant to be called after uploading a file.
+func TagContentType(dstFS billy.Basic, dst string, srcFS billy.Basic, src string) error {
+	ct, ok := dstFS.(ContentTyper)
+	if !ok {
+		return nil
+	}
+
+	t, err := DetectContentType(srcFS, src)
+	if err != nil {
+		return err
+	}
+
+	return ct.SetContentType(dst, t)
+}
@@ -0,0 +1,91 @@
+package util_test
+
+import (
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+func (s *UtilSuite) TestDetectContentType(c *C) {
+	fs := memfs.New()
+	for _, t := range []struct {
+		name, content, expected string
+	}{
+		{"foo.png", "\x89PNG\r\n\x1a\n", "image/png"},
+		{"foo.txt", "<html><body>", "text/html; charset=utf-8"},
+		{"foo.css", "body {}", "text/css; charset=utf-8"},
+		{"foo.json", "{}", "application/json"},
+		{"foo", "foo", "text/plain; charset=utf-8"},
+		{"foo.unknown", "\x00\x01", "application/octet-stream"},
+	} {
+		c.Assert(util.WriteFile(fs, t.name, []byte(t.content), 0644), IsNil)
+
+		ct, err := util.DetectContentType(fs, t.name)
+		c.Assert(err, IsNil)
+		c.Assert(ct, Equals, t.expected, Commentf("file %s", t.name))
+	}
+
+	_, err := util.DetectContentType(fs, "missing")
+	c.Assert(err, NotNil)
+}
+
+func (s *UtilSuite) TestTagContentType(c *C) {
+	src := memfs.New()
+	c.Assert(util.WriteFile(src, "foo", []byte("<html>"), 0644), IsNil)
+	c.Assert(util.TagContentType(memfs.New(), "foo", src, "foo"), IsNil)
+
+	dst := &metadataFS{Filesystem: memfs.New(), types: make(map[string]string)}
+	c.Assert(util.TagContentType(dst, "bar", src, "foo"), IsNil)
+	c.Assert(dst.types["bar"], Equals, "text/html; charset=utf-8")
+
+	// the stored type takes precedence over the content.
+	dst.types["bar"] = "text/x-custom"
+	ct, err := util.DetectContentType(dst, "bar")
+	c.Assert(err, IsNil)
+	c.Assert(ct, Equals, "text/x-custom")
+}
+
+func (s *UtilSuite) TestTagContentTypeCopy(c *C) {
+	src := memfs.New()
+	c.Assert(util.WriteFile(src, "dir/foo.css", []byte("body {}"), 0644), IsNil)
+	c.Assert(util.WriteFile(src, "dir/bar", []byte("<html>"), 0644), IsNil)
+
+	dst := &metadataFS{Filesystem: memfs.New(), types: make(map[string]string)}
+	c.Assert(util.CopyFile(dst, "foo", src, "dir/foo.css", 0), IsNil)
+	c.Assert(dst.types["foo"], Equals, "text/css; charset=utf-8")
+
+	c.Assert(util.CopyDir(dst, "copy", src, "dir", nil), IsNil)
+	c.Assert(dst.types["copy/foo.css"], Equals, "text/css; charset=utf-8")
+	c.Assert(dst.types["copy/bar"], Equals, "text/html; charset=utf-8")
+
+	_, err := util.Sync(dst, src, nil)
+	c.Assert(err, IsNil)
+	c.Assert(dst.types["dir/foo.css"], Equals, "text/css; charset=utf-8")
+	c.Assert(dst.types["dir/bar"], Equals, "text/html; charset=utf-8")
+}
+
+// metadataFS is a filesystem implementing util.ContentTyper, storing the
+// content types in memory.
+type metadataFS struct {
+	billy.Filesystem
+	types map[string]string
+}
+
+func (fs *metadataFS) ContentType(filename string) (string, error) {
+	return fs.types[metadataKey(filename)], nil
+}
+
+func (fs *metadataFS) SetContentType(filename, contentType string) error {
+	fs.types[metadataKey(filename)] = contentType
+	return nil
+}
+
+// metadataKey returns the key of the content type of the given file, its
+// clean path without the leading separator.
+func metadataKey(filename string) string {
+	return strings.TrimLeft(filepath.Clean(filename), string(filepath.Separator))
+}
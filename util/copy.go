@@ -0,0 +1,139 @@
+package util
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+var errSameFile = errors.New("source and destination are the same file")
+
+// Copier is implemented by the filesystems able to copy a file without
+// transferring its content, usually remote backends copying server-side. It
+// is used by CopyFile when copying within the same filesystem.
+type Copier interface {
+	// Copy copies the content of the file from to the file to, creating it
+	// if it doesn't exist, or truncating it otherwise.
+	Copy(from, to string) error
+}
+
+// CopyFile copies the content of the file src of srcFS to the file dst of
+// dstFS, creating it with the given permissions if it doesn't exist, or
+// truncating it otherwise. If perm is zero, the permissions of src are used.
+//
+// The mode of dst is set to perm if dstFS implements billy.Change, since the
+// permissions given to OpenFile don't apply to the existing files. The content
+// type of src is stored in dst, with TagContentType, if dstFS implements
+// ContentTyper. The copy is delegated to the filesystem when copying within
+// one implementing Copier.
+//
+// Copying a file onto itself fails. An existing dst is replaced by renaming a
+// temporary file over it, so it's kept as it was if the copy fails, while a
+// new dst is removed.
+func CopyFile(dstFS billy.Basic, dst string, srcFS billy.Basic, src string, perm os.FileMode) error {
+	return CopyFileWithOptions(dstFS, dst, srcFS, src, perm, nil)
+}
+
+// CopyFileWithOptions is like CopyFile, reading the file with a buffer of the
+// size given in the options.
+func CopyFileWithOptions(dstFS billy.Basic, dst string, srcFS billy.Basic, src string, perm os.FileMode, opts *StreamOptions) error {
+	if sameFile(dstFS, dst, srcFS, src) {
+		return &os.LinkError{Op: "copy", Old: src, New: dst, Err: errSameFile}
+	}
+
+	if perm == 0 {
+		fi, err := srcFS.Stat(src)
+		if err != nil {
+			return err
+		}
+
+		perm = fi.Mode().Perm()
+	}
+
+	var err error
+	if c, ok := dstFS.(Copier); ok && sameFS(dstFS, srcFS) {
+		err = c.Copy(src, dst)
+	} else {
+		err = copyContent(dstFS, dst, srcFS, src, perm, opts)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err := TagContentType(dstFS, dst, srcFS, src); err != nil {
+		return err
+	}
+
+	if ch, ok := dstFS.(billy.Change); ok {
+		return ch.Chmod(dst, perm)
+	}
+
+	return nil
+}
+
+func copyContent(dstFS billy.Basic, dst string, srcFS billy.Basic, src string, perm os.FileMode, opts *StreamOptions) error {
+	r, err := srcFS.Open(src)
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	// an existing dst is only replaced once the copy succeeds
+	name, w := dst, billy.File(nil)
+	if _, err = dstFS.Stat(dst); err == nil {
+		w, err = createTemp(dstFS, filepath.Dir(dst), "."+filepath.Base(dst)+".*", perm)
+		if err == nil {
+			name = w.Name()
+		}
+	} else {
+		w, err = dstFS.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	b := getBuffer(opts.bufferSize())
+	defer putBuffer(b)
+
+	// the buffer is used even if the files implement io.ReaderFrom or
+	// io.WriterTo, as the files of most filesystems are thin wrappers
+	_, err = io.CopyBuffer(struct{ io.Writer }{w}, struct{ io.Reader }{r}, *b)
+	if err1 := w.Close(); err == nil {
+		err = err1
+	}
+
+	if err == nil && name != dst {
+		err = dstFS.Rename(name, dst)
+	}
+
+	if err != nil {
+		dstFS.Remove(name)
+	}
+
+	return err
+}
+
+// sameFile reports whether the file a of fsA is the file b of fsB, unwrapping
+// the chrooted filesystems.
+func sameFile(fsA billy.Basic, a string, fsB billy.Basic, b string) bool {
+	fsA, a = getUnderlyingAndPath(fsA, a)
+	fsB, b = getUnderlyingAndPath(fsB, b)
+
+	return sameFS(fsA, fsB) && filepath.Clean(a) == filepath.Clean(b)
+}
+
+// sameFS reports whether a and b are the same filesystem.
+func sameFS(a, b billy.Basic) bool {
+	if !reflect.TypeOf(a).Comparable() {
+		return false
+	}
+
+	return a == b
+}
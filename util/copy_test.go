@@ -0,0 +1,154 @@
+package util_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+func (s *UtilSuite) TestCopyFile(c *C) {
+	src, dst := memfs.New(), memfs.New()
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	c.Assert(util.WriteFile(src, "foo", content, 0600), IsNil)
+	c.Assert(util.WriteFile(dst, "bar/qux", []byte("previous content"), 0644), IsNil)
+
+	err := util.CopyFileWithOptions(dst, "bar/qux", src, "foo", 0, &util.StreamOptions{BufferSize: 1000})
+	c.Assert(err, IsNil)
+
+	equal, err := util.Equal(dst, "bar/qux", src, "foo")
+	c.Assert(err, IsNil)
+	c.Assert(equal, Equals, true)
+
+	c.Assert(util.CopyFile(dst, "new", src, "foo", 0), IsNil)
+	fi, err := dst.Stat("new")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode().Perm(), Equals, os.FileMode(0600))
+
+	err = util.CopyFile(dst, "missing", src, "missing", 0644)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *UtilSuite) TestCopyFileSame(c *C) {
+	fs := memfs.New()
+	c.Assert(util.WriteFile(fs, "dir/foo", []byte("foo"), 0644), IsNil)
+
+	c.Assert(util.CopyFile(fs, "dir/../dir/foo", fs, "dir/foo", 0), NotNil)
+
+	chroot, err := fs.Chroot("dir")
+	c.Assert(err, IsNil)
+	c.Assert(util.CopyFile(chroot, "foo", fs, "dir/foo", 0), NotNil)
+
+	content, err := readFile(fs, "dir/foo")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "foo")
+}
+
+func (s *UtilSuite) TestCopyFileFailed(c *C) {
+	src := &failingReadFS{Filesystem: memfs.New()}
+	c.Assert(util.WriteFile(src, "foo", []byte("foo"), 0644), IsNil)
+
+	dst := memfs.New()
+	c.Assert(util.WriteFile(dst, "bar", []byte("bar"), 0644), IsNil)
+
+	c.Assert(util.CopyFile(dst, "bar", src, "foo", 0), NotNil)
+	c.Assert(util.CopyFile(dst, "qux", src, "foo", 0), NotNil)
+
+	content, err := readFile(dst, "bar")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "bar")
+
+	files, err := dst.ReadDir("")
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 1)
+}
+
+func (s *UtilSuite) TestCopyFileChange(c *C) {
+	fs := &copierFS{Filesystem: memfs.New()}
+	c.Assert(util.WriteFile(fs, "foo", []byte("foo"), 0644), IsNil)
+
+	other := memfs.New()
+	c.Assert(util.CopyFile(other, "bar", fs, "foo", 0755), IsNil)
+	c.Assert(fs.copies, Equals, 0)
+
+	c.Assert(util.CopyFile(fs, "bar", other, "bar", 0755), IsNil)
+	c.Assert(fs.copies, Equals, 0)
+	c.Assert(fs.modes["bar"], Equals, os.FileMode(0755))
+
+	c.Assert(util.CopyFile(fs, "qux", fs, "foo", 0), IsNil)
+	c.Assert(fs.copies, Equals, 1)
+	c.Assert(fs.modes["qux"], Equals, os.FileMode(0644))
+
+	equal, err := util.Equal(fs, "qux", fs, "foo")
+	c.Assert(err, IsNil)
+	c.Assert(equal, Equals, true)
+}
+
+// copierFS is a filesystem implementing util.Copier, that counts the amount
+// of copies requested, and billy.Change, recording the modes set, and denying
+// to create files in the directories whose mode denies writing.
+type copierFS struct {
+	billy.Filesystem
+	copies int
+	modes  map[string]os.FileMode
+}
+
+func (fs *copierFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if mode, ok := fs.modes[filepath.Dir(filename)]; ok && flag&os.O_CREATE != 0 && mode&0200 == 0 {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrPermission}
+	}
+
+	return fs.Filesystem.OpenFile(filename, flag, perm)
+}
+
+func (fs *copierFS) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *copierFS) Copy(from, to string) error {
+	fs.copies++
+	return util.CopyFile(fs.Filesystem, to, fs.Filesystem, from, 0)
+}
+
+func (fs *copierFS) Chmod(name string, mode os.FileMode) error {
+	if fs.modes == nil {
+		fs.modes = make(map[string]os.FileMode)
+	}
+
+	fs.modes[name] = mode
+	return nil
+}
+
+func (fs *copierFS) Lchown(name string, uid, gid int) error { return nil }
+
+func (fs *copierFS) Chown(name string, uid, gid int) error { return nil }
+
+func (fs *copierFS) Chtimes(name string, atime, mtime time.Time) error { return nil }
+
+// failingReadFS is a filesystem whose files fail to be read.
+type failingReadFS struct {
+	billy.Filesystem
+}
+
+func (fs *failingReadFS) Open(filename string) (billy.File, error) {
+	f, err := fs.Filesystem.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return &failingReadFile{File: f}, nil
+}
+
+type failingReadFile struct {
+	billy.File
+}
+
+func (f *failingReadFile) Read(p []byte) (int, error) {
+	return 0, errors.New("read failed")
+}
@@ -0,0 +1,265 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// OverwritePolicy tells what to do with the files already existing in the
+// destination of a copy.
+type OverwritePolicy int
+
+const (
+	// OverwriteAlways replaces the existing files.
+	OverwriteAlways OverwritePolicy = iota
+	// OverwriteNever keeps the existing files.
+	OverwriteNever
+	// OverwriteIfNewer replaces the existing files modified before the ones
+	// copied.
+	OverwriteIfNewer
+	// OverwriteError fails with an error satisfying os.IsExist.
+	OverwriteError
+)
+
+// CopyOptions holds the configuration of a tree copy.
+type CopyOptions struct {
+	// Include are the patterns, as in filepath.Match, of the files copied;
+	// every file is copied if empty. The patterns are matched against the
+	// path of the files relative to the directory copied, or against their
+	// name if they don't contain a separator. The directories are always
+	// walked, and created only if not empty.
+	Include []string
+	// Exclude are the patterns of the files and directories not copied,
+	// matched as Include. The content of the directories excluded isn't
+	// copied either.
+	Exclude []string
+	// Overwrite is the policy for the files already existing in the
+	// destination, OverwriteAlways by default. The existing directories are
+	// always merged.
+	Overwrite OverwritePolicy
+	// Stream configures the buffers used to copy the files.
+	Stream *StreamOptions
+}
+
+// CopyFS copies every file of srcFS to dstFS, as CopyDir does.
+func CopyFS(dstFS billy.Filesystem, srcFS billy.Filesystem, opts *CopyOptions) error {
+	const separator = string(filepath.Separator)
+	return CopyDir(dstFS, separator, srcFS, separator, opts)
+}
+
+// CopyDir copies the directory src of srcFS, and everything below it, to the
+// directory dst of dstFS, creating it if it doesn't exist. The symlinks are
+// copied as symlinks, and the modes and modification times are preserved if
+// dstFS implements billy.Change.
+func CopyDir(dstFS billy.Filesystem, dst string, srcFS billy.Filesystem, src string, opts *CopyOptions) error {
+	if opts == nil {
+		opts = &CopyOptions{}
+	}
+
+	c := &copier{dstFS: dstFS, dst: dst, srcFS: srcFS, src: src, opts: opts}
+	c.change, _ = dstFS.(billy.Change)
+	if err := Walk(srcFS, src, c.walk); err != nil {
+		return err
+	}
+
+	// the modes and times of the directories are set once their content is
+	// copied, since they may deny writing it
+	for i := len(c.dirs) - 1; i >= 0; i-- {
+		d := c.dirs[i]
+		if err := c.change.Chmod(d.path, d.fi.Mode().Perm()); err != nil {
+			return err
+		}
+
+		if err := c.change.Chtimes(d.path, d.fi.ModTime(), d.fi.ModTime()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type copier struct {
+	dstFS, srcFS billy.Filesystem
+	dst, src     string
+	opts         *CopyOptions
+	change       billy.Change
+	// dirs are the directories created, to set their modes and times.
+	dirs []copiedDir
+}
+
+type copiedDir struct {
+	path string
+	fi   os.FileInfo
+}
+
+func (c *copier) walk(p string, fi os.FileInfo, err error) error {
+	if err != nil {
+		return err
+	}
+
+	rel, err := filepath.Rel(c.src, p)
+	if err != nil {
+		return err
+	}
+
+	if rel != "." && c.excluded(rel) {
+		if fi.IsDir() {
+			return filepath.SkipDir
+		}
+
+		return nil
+	}
+
+	if !fi.IsDir() && !c.included(rel) {
+		return nil
+	}
+
+	target := filepath.Join(c.dst, rel)
+	if fi.IsDir() && len(c.opts.Include) != 0 {
+		// the directories are created along with the files included
+		return nil
+	}
+
+	if skip, err := c.makeParent(rel); err != nil || skip {
+		return err
+	}
+
+	skip, err := c.prepare(target, fi)
+	if err != nil || skip {
+		if err == nil && fi.IsDir() {
+			return filepath.SkipDir
+		}
+
+		return err
+	}
+
+	switch {
+	case fi.IsDir():
+		return c.mkdir(target, fi)
+	case fi.Mode()&os.ModeSymlink != 0:
+		link, err := c.srcFS.Readlink(p)
+		if err != nil {
+			return err
+		}
+
+		return c.dstFS.Symlink(link, target)
+	default:
+		err := CopyFileWithOptions(c.dstFS, target, c.srcFS, p, fi.Mode().Perm(), c.opts.Stream)
+		if err != nil || c.change == nil {
+			return err
+		}
+
+		return c.change.Chtimes(target, fi.ModTime(), fi.ModTime())
+	}
+}
+
+// prepare makes room for the given file in the destination, as told by the
+// overwrite policy, reporting whether it must be skipped.
+func (c *copier) prepare(target string, fi os.FileInfo) (bool, error) {
+	cur, err := c.dstFS.Lstat(target)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	if cur.IsDir() && fi.IsDir() {
+		return false, nil
+	}
+
+	switch c.opts.Overwrite {
+	case OverwriteNever:
+		return true, nil
+	case OverwriteIfNewer:
+		if !fi.ModTime().After(cur.ModTime()) {
+			return true, nil
+		}
+	case OverwriteError:
+		return false, &os.PathError{Op: "copy", Path: target, Err: os.ErrExist}
+	}
+
+	// the regular files are truncated, the rest replaced
+	if cur.Mode().IsRegular() && fi.Mode().IsRegular() {
+		return false, nil
+	}
+
+	return false, RemoveAll(c.dstFS, target)
+}
+
+// makeParent creates the directories of the destination containing the given
+// file, relative to the directory copied, when copying only the files
+// included. It reports whether the file must be skipped, since a directory
+// can't be created.
+func (c *copier) makeParent(rel string) (bool, error) {
+	if len(c.opts.Include) == 0 {
+		return false, nil
+	}
+
+	var dir string
+	for _, name := range strings.Split(filepath.Dir(rel), string(filepath.Separator)) {
+		dir = filepath.Join(dir, name)
+		target := filepath.Join(c.dst, dir)
+		if fi, err := c.dstFS.Lstat(target); err == nil && fi.IsDir() {
+			continue
+		}
+
+		fi, err := c.srcFS.Lstat(filepath.Join(c.src, dir))
+		if err != nil {
+			return false, err
+		}
+
+		if skip, err := c.prepare(target, fi); err != nil || skip {
+			return skip, err
+		}
+
+		if err := c.mkdir(target, fi); err != nil {
+			return false, err
+		}
+	}
+
+	return false, nil
+}
+
+func (c *copier) mkdir(target string, fi os.FileInfo) error {
+	if c.change == nil {
+		return c.dstFS.MkdirAll(target, fi.Mode().Perm())
+	}
+
+	// the owner can write the content until the mode is set
+	if err := c.dstFS.MkdirAll(target, fi.Mode().Perm()|0700); err != nil {
+		return err
+	}
+
+	c.dirs = append(c.dirs, copiedDir{path: target, fi: fi})
+	return nil
+}
+
+func (c *copier) included(rel string) bool {
+	return len(c.opts.Include) == 0 || matchAny(c.opts.Include, rel)
+}
+
+func (c *copier) excluded(rel string) bool {
+	return matchAny(c.opts.Exclude, rel)
+}
+
+// matchAny reports whether the given path matches any of the patterns,
+// matching its name if a pattern doesn't contain a separator.
+func matchAny(patterns []string, p string) bool {
+	for _, pattern := range patterns {
+		name := p
+		if !strings.Contains(pattern, string(filepath.Separator)) {
+			name = filepath.Base(p)
+		}
+
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
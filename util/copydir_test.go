@@ -0,0 +1,150 @@
+package util_test
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+func newCopyFS(c *C) billy.Filesystem {
+	fs := memfs.New()
+	c.Assert(util.WriteFile(fs, "src/foo.go", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(fs, "src/bar/qux.go", []byte("qux"), 0600), IsNil)
+	c.Assert(util.WriteFile(fs, "src/bar/qux.txt", []byte("txt"), 0644), IsNil)
+	c.Assert(util.WriteFile(fs, "src/vendor/baz.go", []byte("baz"), 0644), IsNil)
+	c.Assert(fs.Symlink("foo.go", "src/link"), IsNil)
+	return fs
+}
+
+func copiedFiles(c *C, fs billy.Filesystem, root string) []string {
+	var files []string
+	err := util.Walk(fs, root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || p == root {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, p)
+		c.Assert(err, IsNil)
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+
+	c.Assert(err, IsNil)
+	return files
+}
+
+func (s *UtilSuite) TestCopyDir(c *C) {
+	src := newCopyFS(c)
+	dst := memfs.New()
+
+	c.Assert(util.CopyDir(dst, "dst", src, "src", nil), IsNil)
+	c.Assert(copiedFiles(c, dst, "dst"), DeepEquals, []string{
+		"bar", "bar/qux.go", "bar/qux.txt", "foo.go", "link", "vendor", "vendor/baz.go",
+	})
+
+	fi, err := dst.Lstat("dst/bar/qux.go")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode().Perm(), Equals, os.FileMode(0600))
+
+	link, err := dst.Readlink("dst/link")
+	c.Assert(err, IsNil)
+	c.Assert(link, Equals, "foo.go")
+	c.Assert(test.ReadFile(c, dst, "dst/link"), Equals, "foo")
+}
+
+func (s *UtilSuite) TestCopyDirFilters(c *C) {
+	fs := newCopyFS(c)
+
+	err := util.CopyDir(fs, "dst", fs, "src", &util.CopyOptions{
+		Include: []string{"*.go"},
+		Exclude: []string{"vendor"},
+	})
+
+	c.Assert(err, IsNil)
+	c.Assert(copiedFiles(c, fs, "dst"), DeepEquals, []string{
+		"bar", "bar/qux.go", "foo.go",
+	})
+}
+
+func (s *UtilSuite) TestCopyDirOverwrite(c *C) {
+	src := newCopyFS(c)
+	for _, t := range []struct {
+		policy util.OverwritePolicy
+		foo    string
+		link   string
+	}{
+		{util.OverwriteAlways, "foo", "foo"},
+		{util.OverwriteNever, "previous", "previous"},
+		// memfs doesn't keep the modification times
+		{util.OverwriteIfNewer, "previous", "previous"},
+	} {
+		dst := memfs.New()
+		c.Assert(util.WriteFile(dst, "link", []byte("previous"), 0644), IsNil)
+		c.Assert(util.WriteFile(dst, "foo.go", []byte("previous"), 0644), IsNil)
+
+		err := util.CopyDir(dst, "/", src, "src", &util.CopyOptions{Overwrite: t.policy})
+		c.Assert(err, IsNil)
+		c.Assert(test.ReadFile(c, dst, "foo.go"), Equals, t.foo, Commentf("policy %d", t.policy))
+		c.Assert(test.ReadFile(c, dst, "link"), Equals, t.link, Commentf("policy %d", t.policy))
+	}
+
+	dst := memfs.New()
+	c.Assert(util.WriteFile(dst, "src/foo.go", []byte("previous"), 0644), IsNil)
+
+	err := util.CopyFS(dst, src, &util.CopyOptions{Overwrite: util.OverwriteError})
+	c.Assert(os.IsExist(err), Equals, true)
+}
+
+func (s *UtilSuite) TestCopyDirOverwriteIfNewer(c *C) {
+	dir := c.MkDir()
+	fs := osfs.New(dir)
+	c.Assert(util.WriteFile(fs, "src/old", []byte("old"), 0644), IsNil)
+	c.Assert(util.WriteFile(fs, "src/new", []byte("new"), 0644), IsNil)
+	c.Assert(util.WriteFile(fs, "dst/old", []byte("previous"), 0644), IsNil)
+	c.Assert(util.WriteFile(fs, "dst/new", []byte("previous"), 0644), IsNil)
+
+	now := time.Now()
+	c.Assert(os.Chtimes(filepath.Join(dir, "src/old"), now, now.Add(-time.Hour)), IsNil)
+	c.Assert(os.Chtimes(filepath.Join(dir, "dst/new"), now, now.Add(-time.Hour)), IsNil)
+
+	err := util.CopyDir(fs, "dst", fs, "src", &util.CopyOptions{Overwrite: util.OverwriteIfNewer})
+	c.Assert(err, IsNil)
+	c.Assert(test.ReadFile(c, fs, "dst/old"), Equals, "previous")
+	c.Assert(test.ReadFile(c, fs, "dst/new"), Equals, "new")
+}
+
+func (s *UtilSuite) TestCopyDirChange(c *C) {
+	src := newCopyFS(c)
+	dst := &copierFS{Filesystem: memfs.New()}
+
+	c.Assert(util.CopyDir(dst, "dst", src, "src", nil), IsNil)
+	c.Assert(dst.modes, DeepEquals, map[string]os.FileMode{
+		// memfs creates the parents with the mode of the file
+		"dst":               0644,
+		"dst/bar":           0600,
+		"dst/bar/qux.go":    0600,
+		"dst/bar/qux.txt":   0644,
+		"dst/foo.go":        0644,
+		"dst/vendor":        0644,
+		"dst/vendor/baz.go": 0644,
+	})
+}
+
+func (s *UtilSuite) TestCopyDirReadOnly(c *C) {
+	src := memfs.New()
+	c.Assert(src.MkdirAll("src/ro", 0555), IsNil)
+	c.Assert(util.WriteFile(src, "src/ro/foo", []byte("foo"), 0444), IsNil)
+
+	dst := &copierFS{Filesystem: memfs.New()}
+	c.Assert(util.CopyDir(dst, "dst", src, "src", nil), IsNil)
+	c.Assert(test.ReadFile(c, dst, "dst/ro/foo"), Equals, "foo")
+	c.Assert(dst.modes["dst/ro"], Equals, os.FileMode(0555))
+	c.Assert(dst.modes["dst/ro/foo"], Equals, os.FileMode(0444))
+}
@@ -0,0 +1,105 @@
+package util
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// CreateOptions holds the configuration of the creation of an archive.
+type CreateOptions struct {
+	// Filter, if not nil, tells whether the given file or directory,
+	// relative to the directory archived, is added. The content of the
+	// directories filtered out isn't added either.
+	Filter func(path string, fi os.FileInfo) bool
+	// Stream configures the buffers used to read the files.
+	Stream *StreamOptions
+}
+
+// TarCreate writes to w a tar archive of the files below the src directory
+// of fs, named relative to it, preserving the permissions and the symlinks.
+// The files are added in lexical order, the directories before their content,
+// and the special files are skipped. The end of the archive is written, but w
+// isn't closed. The options may be nil, using the defaults.
+func TarCreate(fs billy.Filesystem, src string, w io.Writer, opts *CreateOptions) error {
+	if opts == nil {
+		opts = &CreateOptions{}
+	}
+
+	tw := tar.NewWriter(w)
+	err := Walk(fs, src, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, p)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		if opts.Filter != nil && !opts.Filter(rel, fi) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		return tarEntry(tw, fs, p, filepath.ToSlash(rel), fi, opts)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// tarEntry writes the given file to the archive, with the given name.
+func tarEntry(tw *tar.Writer, fs billy.Filesystem, p, name string, fi os.FileInfo, opts *CreateOptions) error {
+	var link string
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		var err error
+		if link, err = fs.Readlink(p); err != nil {
+			return err
+		}
+	case !fi.IsDir() && !fi.Mode().IsRegular():
+		return nil
+	}
+
+	h, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return err
+	}
+
+	h.Name = name
+	if fi.IsDir() {
+		h.Name += "/"
+	}
+
+	if err := tw.WriteHeader(h); err != nil {
+		return err
+	}
+
+	if !fi.Mode().IsRegular() {
+		return nil
+	}
+
+	f, err := fs.Open(p)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	buf := getBuffer(opts.Stream.bufferSize())
+	defer putBuffer(buf)
+
+	// the size written must be the one in the header
+	_, err = io.CopyBuffer(tw, io.LimitReader(f, h.Size), *buf)
+	return err
+}
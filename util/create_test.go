@@ -0,0 +1,59 @@
+package util_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"strings"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+func (s *UtilSuite) TestTarCreate(c *C) {
+	fs := memfs.New()
+	c.Assert(util.WriteFile(fs, "src/foo/bar", []byte("bar"), 0600), IsNil)
+	c.Assert(util.WriteFile(fs, "src/baz", []byte("baz"), 0644), IsNil)
+	c.Assert(util.WriteFile(fs, "src/.git/config", []byte("config"), 0644), IsNil)
+	c.Assert(fs.Symlink("foo/bar", "src/link"), IsNil)
+
+	buf := bytes.NewBuffer(nil)
+	err := util.TarCreate(fs, "src", buf, &util.CreateOptions{
+		Filter: func(path string, fi os.FileInfo) bool {
+			return !strings.HasPrefix(path, ".git")
+		},
+	})
+
+	c.Assert(err, IsNil)
+
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+
+		c.Assert(err, IsNil)
+		names = append(names, h.Name)
+	}
+
+	c.Assert(names, DeepEquals, []string{"baz", "foo/", "foo/bar", "link"})
+
+	dst := memfs.New()
+	c.Assert(util.TarExtract(dst, "dst", buf, &util.ExtractOptions{Safe: true}), IsNil)
+
+	content, err := readFile(dst, "dst/foo/bar")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "bar")
+
+	fi, err := dst.Stat("dst/foo/bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode(), Equals, os.FileMode(0600))
+
+	target, err := dst.Readlink("dst/link")
+	c.Assert(err, IsNil)
+	c.Assert(target, Equals, "foo/bar")
+}
@@ -0,0 +1,136 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// DiffOptions holds the configuration of DiffTrees.
+type DiffOptions struct {
+	// Compare is the way the files modified are found, CompareSizeAndTime
+	// by default, that considers a file modified if its modification time
+	// is different.
+	Compare CompareMode
+	// ChunkSize is the size of the chunks hashed with CompareContent, 1MiB
+	// if zero.
+	ChunkSize int64
+	// Stream configures the buffers used to compare the files.
+	Stream *StreamOptions
+}
+
+// TreeDiff holds the differences between two trees. The paths are relative
+// to the root of the filesystems, in lexical order, and include the ones
+// below the directories added or removed.
+type TreeDiff struct {
+	// Added are the files, symlinks and directories only found in the
+	// second tree.
+	Added []string
+	// Removed are the files, symlinks and directories only found in the
+	// first tree.
+	Removed []string
+	// Modified are the files and symlinks found in both trees, but
+	// different, and the paths with a different type of file in each tree.
+	Modified []string
+}
+
+// Empty reports whether the trees are equal.
+func (d *TreeDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0
+}
+
+// DiffTrees returns the differences between the trees of a and b. The
+// symlinks aren't followed, but compared by their targets.
+func DiffTrees(a, b billy.Filesystem, opts *DiffOptions) (*TreeDiff, error) {
+	if opts == nil {
+		opts = &DiffOptions{}
+	}
+
+	filesA, err := listTree(a)
+	if err != nil {
+		return nil, err
+	}
+
+	filesB, err := listTree(b)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &TreeDiff{}
+	for p, fa := range filesA {
+		fb, ok := filesB[p]
+		if !ok {
+			d.Removed = append(d.Removed, p)
+			continue
+		}
+
+		modified, err := diffFile(a, b, p, fa, fb, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		if modified {
+			d.Modified = append(d.Modified, p)
+		}
+	}
+
+	for p := range filesB {
+		if _, ok := filesA[p]; !ok {
+			d.Added = append(d.Added, p)
+		}
+	}
+
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Strings(d.Modified)
+	return d, nil
+}
+
+// listTree returns the info of every file of the given filesystem, by their
+// path relative to its root.
+func listTree(fs billy.Filesystem) (map[string]os.FileInfo, error) {
+	const separator = string(filepath.Separator)
+
+	files := make(map[string]os.FileInfo)
+	err := Walk(fs, separator, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || p == separator {
+			return err
+		}
+
+		files[p[len(separator):]] = fi
+		return nil
+	})
+
+	return files, err
+}
+
+// diffFile reports whether the given file is different in both filesystems.
+func diffFile(a, b billy.Filesystem, p string, fa, fb os.FileInfo, opts *DiffOptions) (bool, error) {
+	if fa.Mode()&os.ModeType != fb.Mode()&os.ModeType {
+		return true, nil
+	}
+
+	switch {
+	case fa.IsDir():
+		return false, nil
+	case fa.Mode()&os.ModeSymlink != 0:
+		same, err := sameLink(a, b, p)
+		return !same, err
+	}
+
+	if fa.Size() != fb.Size() {
+		return true, nil
+	}
+
+	switch opts.Compare {
+	case CompareSize:
+		return false, nil
+	case CompareContent:
+		same, err := sameContent(a, b, p, opts.ChunkSize, opts.Stream)
+		return !same, err
+	default:
+		return !fa.ModTime().Equal(fb.ModTime()), nil
+	}
+}
@@ -0,0 +1,64 @@
+package util_test
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+func (s *UtilSuite) TestDiffTrees(c *C) {
+	a, b := memfs.New(), memfs.New()
+	c.Assert(util.WriteFile(a, "same", []byte("same"), 0644), IsNil)
+	c.Assert(util.WriteFile(b, "same", []byte("same"), 0644), IsNil)
+	c.Assert(util.WriteFile(a, "content", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(b, "content", []byte("bar"), 0644), IsNil)
+	c.Assert(util.WriteFile(a, "size", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(b, "size", []byte("foobar"), 0644), IsNil)
+	c.Assert(util.WriteFile(a, "removed/file", nil, 0644), IsNil)
+	c.Assert(util.WriteFile(b, "added", nil, 0644), IsNil)
+	c.Assert(util.WriteFile(a, "type", nil, 0644), IsNil)
+	c.Assert(b.MkdirAll("type", 0755), IsNil)
+	c.Assert(a.Symlink("same", "link"), IsNil)
+	c.Assert(b.Symlink("size", "link"), IsNil)
+
+	// memfs doesn't keep the modification times
+	d, err := util.DiffTrees(a, b, &util.DiffOptions{Compare: util.CompareSize})
+	c.Assert(err, IsNil)
+	c.Assert(d.Empty(), Equals, false)
+	c.Assert(d, DeepEquals, &util.TreeDiff{
+		Added:    []string{"added"},
+		Removed:  []string{"removed", filepath.Join("removed", "file")},
+		Modified: []string{"link", "size", "type"},
+	})
+
+	d, err = util.DiffTrees(a, b, &util.DiffOptions{Compare: util.CompareContent})
+	c.Assert(err, IsNil)
+	c.Assert(d.Modified, DeepEquals, []string{"content", "link", "size", "type"})
+
+	d, err = util.DiffTrees(a, a, &util.DiffOptions{Compare: util.CompareContent})
+	c.Assert(err, IsNil)
+	c.Assert(d.Empty(), Equals, true)
+}
+
+func (s *UtilSuite) TestDiffTreesTime(c *C) {
+	dir := c.MkDir()
+	a, b := osfs.New(filepath.Join(dir, "a")), osfs.New(filepath.Join(dir, "b"))
+	c.Assert(util.WriteFile(a, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(b, "foo", []byte("foo"), 0644), IsNil)
+
+	old := time.Now().Add(-time.Hour)
+	c.Assert(os.Chtimes(filepath.Join(dir, "b", "foo"), old, old), IsNil)
+
+	d, err := util.DiffTrees(a, b, nil)
+	c.Assert(err, IsNil)
+	c.Assert(d.Modified, DeepEquals, []string{"foo"})
+
+	d, err = util.DiffTrees(a, b, &util.DiffOptions{Compare: util.CompareContent})
+	c.Assert(err, IsNil)
+	c.Assert(d.Empty(), Equals, true)
+}
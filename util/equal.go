@@ -0,0 +1,239 @@
+package util
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// ChunkHasher is implemented by the filesystems able to compute the SHA-256
+// hash of a range of a file without transferring its content, usually remote
+// backends. It is used by EqualChunks.
+type ChunkHasher interface {
+	// ChunkHash returns the SHA-256 hash of the length bytes of the given
+	// file starting at offset, or until the end of the file if shorter.
+	ChunkHash(filename string, offset, length int64) ([]byte, error)
+}
+
+// Equal reports whether the file pathA in fsA and the file pathB in fsB have
+// the same content. The sizes are compared first, and then the content is
+// read from both files at the same time, returning at the first difference.
+func Equal(fsA billy.Basic, pathA string, fsB billy.Basic, pathB string) (bool, error) {
+	return EqualWithOptions(fsA, pathA, fsB, pathB, nil)
+}
+
+// EqualWithOptions is like Equal, reading the files with buffers of the size
+// given in the options.
+func EqualWithOptions(fsA billy.Basic, pathA string, fsB billy.Basic, pathB string, opts *StreamOptions) (bool, error) {
+	_, same, err := sameSize(fsA, pathA, fsB, pathB)
+	if err != nil || !same {
+		return false, err
+	}
+
+	a, err := fsA.Open(pathA)
+	if err != nil {
+		return false, err
+	}
+
+	defer a.Close()
+
+	b, err := fsB.Open(pathB)
+	if err != nil {
+		return false, err
+	}
+
+	defer b.Close()
+
+	pa, pb := getBuffer(opts.bufferSize()), getBuffer(opts.bufferSize())
+	defer putBuffer(pa)
+	defer putBuffer(pb)
+
+	bufA, bufB := *pa, *pb
+	for {
+		na, errA := io.ReadFull(a, bufA)
+		nb, errB := io.ReadFull(b, bufB)
+		if !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return false, nil
+		}
+
+		endA, err := isEnd(errA)
+		if err != nil {
+			return false, err
+		}
+
+		endB, err := isEnd(errB)
+		if err != nil {
+			return false, err
+		}
+
+		if endA || endB {
+			return endA == endB, nil
+		}
+	}
+}
+
+// EqualChunks is like Equal, but compares the SHA-256 hash of every chunk of
+// chunkSize bytes of the files. The hashes are requested to the filesystems
+// implementing ChunkHasher, and computed locally reading the chunk for the
+// rest, avoiding to download the whole content of a remote file when the
+// files differ early. A chunkSize of zero or less uses a default size.
+func EqualChunks(fsA billy.Basic, pathA string, fsB billy.Basic, pathB string, chunkSize int64) (bool, error) {
+	return EqualChunksWithOptions(fsA, pathA, fsB, pathB, chunkSize, nil)
+}
+
+// EqualChunksWithOptions is like EqualChunks, reading the chunks hashed
+// locally with buffers of the size given in the options, whatever the size of
+// the chunks.
+func EqualChunksWithOptions(fsA billy.Basic, pathA string, fsB billy.Basic, pathB string, chunkSize int64, opts *StreamOptions) (bool, error) {
+	if chunkSize <= 0 {
+		chunkSize = defaultBufferSize
+	}
+
+	size, same, err := sameSize(fsA, pathA, fsB, pathB)
+	if err != nil || !same {
+		return false, err
+	}
+
+	a := newChunkReader(fsA, pathA, opts.bufferSize())
+	defer a.Close()
+
+	b := newChunkReader(fsB, pathB, opts.bufferSize())
+	defer b.Close()
+
+	for offset := int64(0); offset < size; offset += chunkSize {
+		ha, err := a.hash(offset, chunkSize)
+		if err != nil {
+			return false, err
+		}
+
+		hb, err := b.hash(offset, chunkSize)
+		if err != nil {
+			return false, err
+		}
+
+		if !bytes.Equal(ha, hb) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// sameSize returns the size of the file pathA and if it is the same as the
+// size of pathB.
+func sameSize(fsA billy.Basic, pathA string, fsB billy.Basic, pathB string) (int64, bool, error) {
+	a, err := statFile(fsA, pathA)
+	if err != nil {
+		return 0, false, err
+	}
+
+	b, err := statFile(fsB, pathB)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return a.Size(), a.Size() == b.Size(), nil
+}
+
+func statFile(fs billy.Basic, path string) (os.FileInfo, error) {
+	fi, err := fs.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if fi.IsDir() {
+		return nil, &os.PathError{Op: "compare", Path: path, Err: errors.New("is a directory")}
+	}
+
+	return fi, nil
+}
+
+func isEnd(err error) (bool, error) {
+	switch err {
+	case nil:
+		return false, nil
+	case io.EOF, io.ErrUnexpectedEOF:
+		return true, nil
+	default:
+		return false, err
+	}
+}
+
+// chunkReader returns the hashes of the chunks of a file, using ChunkHasher
+// if available, or reading the file sequentially with buffers of bufSize if
+// not.
+type chunkReader struct {
+	fs     billy.Basic
+	path   string
+	hasher ChunkHasher
+	hpath  string
+
+	f       billy.File
+	buf     *[]byte
+	bufSize int
+}
+
+func newChunkReader(fs billy.Basic, path string, bufSize int) *chunkReader {
+	r := &chunkReader{fs: fs, path: path, bufSize: bufSize}
+	r.hasher, r.hpath = chunkHasher(fs, path)
+	return r
+}
+
+// chunkHasher returns the ChunkHasher of the given filesystem, and the path
+// of the given file in it, or nil if it doesn't implement it. The hasher may
+// be hidden behind a wrapper, such as chroot.
+func chunkHasher(fs billy.Basic, path string) (ChunkHasher, string) {
+	if h, ok := fs.(ChunkHasher); ok {
+		return h, path
+	}
+
+	u, p := getUnderlyingAndPath(fs, path)
+	if h, ok := u.(ChunkHasher); ok {
+		return h, p
+	}
+
+	return nil, ""
+}
+
+func (r *chunkReader) hash(offset, length int64) ([]byte, error) {
+	if r.hasher != nil {
+		return r.hasher.ChunkHash(r.hpath, offset, length)
+	}
+
+	if r.f == nil {
+		f, err := r.fs.Open(r.path)
+		if err != nil {
+			return nil, err
+		}
+
+		r.f = f
+	}
+
+	if r.buf == nil {
+		r.buf = getBuffer(r.bufSize)
+	}
+
+	h := sha256.New()
+	if _, err := io.CopyBuffer(h, io.LimitReader(r.f, length), *r.buf); err != nil {
+		return nil, err
+	}
+
+	return h.Sum(nil), nil
+}
+
+func (r *chunkReader) Close() error {
+	if r.buf != nil {
+		putBuffer(r.buf)
+		r.buf = nil
+	}
+
+	if r.f == nil {
+		return nil
+	}
+
+	return r.f.Close()
+}
@@ -0,0 +1,157 @@
+package util_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+func (s *UtilSuite) TestEqual(c *C) {
+	fs := memfs.New()
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	util.WriteFile(fs, "foo", content, 0644)
+	util.WriteFile(fs, "bar", content, 0644)
+	util.WriteFile(fs, "short", content[1:], 0644)
+
+	other := append([]byte(nil), content...)
+	other[len(other)-1] = 'x'
+	util.WriteFile(fs, "other", other, 0644)
+
+	for _, t := range []struct {
+		name  string
+		equal bool
+	}{
+		{"bar", true},
+		{"short", false},
+		{"other", false},
+	} {
+		equal, err := util.Equal(fs, "foo", fs, t.name)
+		c.Assert(err, IsNil)
+		c.Assert(equal, Equals, t.equal, Commentf("file %s", t.name))
+
+		equal, err = util.EqualChunks(fs, "foo", fs, t.name, 1024)
+		c.Assert(err, IsNil)
+		c.Assert(equal, Equals, t.equal, Commentf("file %s", t.name))
+
+		opts := &util.StreamOptions{BufferSize: 7}
+		equal, err = util.EqualWithOptions(fs, "foo", fs, t.name, opts)
+		c.Assert(err, IsNil)
+		c.Assert(equal, Equals, t.equal, Commentf("file %s", t.name))
+	}
+
+	_, err := util.Equal(fs, "foo", fs, "nope")
+	c.Assert(err, NotNil)
+
+	fs.MkdirAll("dir", 0755)
+	_, err = util.Equal(fs, "foo", fs, "dir")
+	c.Assert(err, NotNil)
+}
+
+func (s *UtilSuite) TestEqualChunksHasher(c *C) {
+	remote := &hasherFS{Filesystem: memfs.New()}
+	local := memfs.New()
+
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	util.WriteFile(remote, "foo", content, 0644)
+	util.WriteFile(local, "foo", content, 0644)
+
+	equal, err := util.EqualChunks(remote, "foo", local, "foo", 1000)
+	c.Assert(err, IsNil)
+	c.Assert(equal, Equals, true)
+	c.Assert(remote.hashes, Equals, 10)
+
+	// the files differ at the first chunk, the rest is not hashed.
+	content[0] = 'x'
+	util.WriteFile(local, "foo", content, 0644)
+
+	remote.hashes = 0
+	equal, err = util.EqualChunks(remote, "foo", local, "foo", 1000)
+	c.Assert(err, IsNil)
+	c.Assert(equal, Equals, false)
+	c.Assert(remote.hashes, Equals, 1)
+}
+
+func (s *UtilSuite) TestEqualChunksWithOptions(c *C) {
+	fs := &readSizeFS{Filesystem: memfs.New()}
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	util.WriteFile(fs, "foo", content, 0644)
+
+	other := append([]byte(nil), content...)
+	other[len(other)-1] = 'x'
+	util.WriteFile(fs, "other", other, 0644)
+
+	opts := &util.StreamOptions{BufferSize: 100}
+	equal, err := util.EqualChunksWithOptions(fs, "foo", fs, "foo", 4096, opts)
+	c.Assert(err, IsNil)
+	c.Assert(equal, Equals, true)
+
+	equal, err = util.EqualChunksWithOptions(fs, "foo", fs, "other", 4096, opts)
+	c.Assert(err, IsNil)
+	c.Assert(equal, Equals, false)
+
+	// the chunks are read with the buffers of the options, not of their size.
+	c.Assert(fs.largest, Equals, 100)
+}
+
+// readSizeFS is a filesystem recording the largest read of its files.
+type readSizeFS struct {
+	billy.Filesystem
+	largest int
+}
+
+func (fs *readSizeFS) Open(filename string) (billy.File, error) {
+	f, err := fs.Filesystem.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return &readSizeFile{File: f, fs: fs}, nil
+}
+
+type readSizeFile struct {
+	billy.File
+	fs *readSizeFS
+}
+
+func (f *readSizeFile) Read(p []byte) (int, error) {
+	if len(p) > f.fs.largest {
+		f.fs.largest = len(p)
+	}
+
+	return f.File.Read(p)
+}
+
+// hasherFS is a filesystem implementing util.ChunkHasher, that counts the
+// amount of hashes requested.
+type hasherFS struct {
+	billy.Filesystem
+	hashes int
+}
+
+func (fs *hasherFS) ChunkHash(filename string, offset, length int64) ([]byte, error) {
+	fs.hashes++
+
+	f, err := fs.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	content, err := ioutil.ReadAll(io.LimitReader(f, length))
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(content)
+	return sum[:], nil
+}
@@ -0,0 +1,70 @@
+package util
+
+import (
+	"errors"
+	"os"
+	"syscall"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// Exists reports whether the given file exists. The symlinks aren't followed
+// if the filesystem supports them, so a dangling symlink exists. The errors
+// telling that the file doesn't exist, including that a parent isn't a
+// directory, result in false with no error.
+func Exists(fs billy.Basic, path string) (bool, error) {
+	_, err := lstat(fs, path)
+	return exists(err)
+}
+
+// DirExists reports whether the given directory exists, following the
+// symlinks. It's false with no error if the file exists but isn't a
+// directory.
+func DirExists(fs billy.Basic, path string) (bool, error) {
+	fi, err := fs.Stat(path)
+	if err != nil {
+		return exists(err)
+	}
+
+	return fi.IsDir(), nil
+}
+
+// IsEmptyDir reports whether the given directory exists and is empty,
+// following the symlinks. It's false with no error if it doesn't exist, and
+// fails if it isn't a directory.
+func IsEmptyDir(fs billy.Filesystem, path string) (bool, error) {
+	fi, err := fs.Stat(path)
+	if err != nil {
+		return exists(err)
+	}
+
+	if !fi.IsDir() {
+		return false, &os.PathError{Op: "readdir", Path: path, Err: syscall.ENOTDIR}
+	}
+
+	fis, err := fs.ReadDir(path)
+	if err != nil {
+		return exists(err)
+	}
+
+	return len(fis) == 0, nil
+}
+
+func exists(err error) (bool, error) {
+	switch {
+	case err == nil:
+		return true, nil
+	case isNotExist(err):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// isNotExist reports whether the given error tells that a file doesn't exist,
+// as the different backends do.
+func isNotExist(err error) bool {
+	return os.IsNotExist(err) ||
+		errors.Is(err, os.ErrNotExist) ||
+		errors.Is(err, syscall.ENOTDIR)
+}
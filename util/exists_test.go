@@ -0,0 +1,67 @@
+package util_test
+
+import (
+	"fmt"
+	"os"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+func (s *UtilSuite) TestExists(c *C) {
+	for _, fs := range []billy.Filesystem{memfs.New(), osfs.New(c.MkDir())} {
+		c.Assert(util.WriteFile(fs, "dir/file", nil, 0644), IsNil)
+		c.Assert(fs.MkdirAll("empty", 0755), IsNil)
+		c.Assert(fs.Symlink("missing", "dangling"), IsNil)
+		c.Assert(fs.Symlink("dir", "link"), IsNil)
+
+		for _, t := range []struct {
+			path            string
+			exists, isDir   bool
+			empty, emptyErr bool
+		}{
+			{"dir", true, true, false, false},
+			{"dir/file", true, false, false, true},
+			{"dir/file/child", false, false, false, false},
+			{"empty", true, true, true, false},
+			{"missing", false, false, false, false},
+			{"dangling", true, false, false, false},
+			{"link", true, true, false, false},
+		} {
+			comment := Commentf("%T %s", fs, t.path)
+
+			exists, err := util.Exists(fs, t.path)
+			c.Assert(err, IsNil, comment)
+			c.Assert(exists, Equals, t.exists, comment)
+
+			isDir, err := util.DirExists(fs, t.path)
+			c.Assert(err, IsNil, comment)
+			c.Assert(isDir, Equals, t.isDir, comment)
+
+			empty, err := util.IsEmptyDir(fs, t.path)
+			c.Assert(err != nil, Equals, t.emptyErr, comment)
+			c.Assert(empty, Equals, t.empty, comment)
+		}
+	}
+}
+
+func (s *UtilSuite) TestExistsWrappedError(c *C) {
+	fs := &failingStatFS{Filesystem: memfs.New()}
+
+	exists, err := util.Exists(fs, "foo")
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+}
+
+// failingStatFS is a filesystem failing to stat any file with a wrapped
+// os.ErrNotExist, as some backends do.
+type failingStatFS struct {
+	billy.Filesystem
+}
+
+func (fs *failingStatFS) Lstat(filename string) (os.FileInfo, error) {
+	return nil, fmt.Errorf("backend: %w", os.ErrNotExist)
+}
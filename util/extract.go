@@ -0,0 +1,411 @@
+package util
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/internal/linkutil"
+)
+
+var (
+	// ErrUnsafePath is returned in safe mode when the path of an entry is
+	// absolute or has ".." elements.
+	ErrUnsafePath = errors.New("unsafe path")
+	// ErrUnsafeLink is returned in safe mode when a link points outside of
+	// the destination.
+	ErrUnsafeLink = errors.New("link points outside of the destination")
+	// ErrSpecialFile is returned in safe mode when an entry is a device,
+	// a named pipe or a socket.
+	ErrSpecialFile = errors.New("special file")
+	// ErrTooLarge is returned when a file exceeds the size limits.
+	ErrTooLarge = errors.New("file too large")
+)
+
+// maxLinkSize is the maximum size of the target of a symlink stored in a zip
+// archive.
+const maxLinkSize = 4096
+
+// ExtractOptions holds the configuration of the extraction of an archive.
+type ExtractOptions struct {
+	// Safe enables the hardened mode, failing on entries with absolute
+	// paths or ".." elements, links pointing outside of the destination and
+	// special files. Otherwise the paths are forced inside of the
+	// destination, the links are created as found and the special files are
+	// skipped.
+	Safe bool
+	// MaxFileSize is the maximum size of a file, without limit if 0.
+	MaxFileSize int64
+	// MaxTotalSize is the maximum size of all the files, without limit if 0.
+	MaxTotalSize int64
+	// Stream configures the buffers used to write the files.
+	Stream *StreamOptions
+}
+
+// TarExtract extracts the tar archive read from r into the dst directory of
+// fs, preserving the permissions and the symlinks. Hard links are extracted
+// as copies. The options may be nil, using the defaults.
+func TarExtract(fs billy.Filesystem, dst string, r io.Reader, opts *ExtractOptions) error {
+	e := newExtractor(fs, dst, opts)
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		mode := h.FileInfo().Mode()
+		switch {
+		case h.Typeflag == tar.TypeLink:
+			err = e.link(h.Name, h.Linkname, mode.Perm())
+		case h.Typeflag == tar.TypeSymlink:
+			err = e.symlink(h.Name, h.Linkname)
+		case h.Typeflag == tar.TypeXGlobalHeader:
+			continue
+		default:
+			err = e.entry(h.Name, mode, h.Size, tr)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ZipExtract extracts the given zip archive into the dst directory of fs,
+// preserving the permissions and the symlinks. The options may be nil,
+// using the defaults.
+func ZipExtract(fs billy.Filesystem, dst string, r *zip.Reader, opts *ExtractOptions) error {
+	e := newExtractor(fs, dst, opts)
+	for _, f := range r.File {
+		if err := e.zipEntry(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type extractor struct {
+	fs   billy.Filesystem
+	dst  string
+	opts ExtractOptions
+
+	total int64
+}
+
+func newExtractor(fs billy.Filesystem, dst string, opts *ExtractOptions) *extractor {
+	e := &extractor{fs: fs, dst: dst}
+	if opts != nil {
+		e.opts = *opts
+	}
+
+	return e
+}
+
+func (e *extractor) zipEntry(f *zip.File) error {
+	mode := f.Mode()
+	if mode&os.ModeSymlink == 0 && !mode.IsRegular() {
+		return e.entry(f.Name, mode, int64(f.UncompressedSize64), nil)
+	}
+
+	if err := e.checkSize(f.Name, int64(f.UncompressedSize64)); err != nil {
+		return err
+	}
+
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	if mode.IsRegular() {
+		return e.entry(f.Name, mode, int64(f.UncompressedSize64), r)
+	}
+
+	target, err := ioutil.ReadAll(io.LimitReader(r, maxLinkSize))
+	if err != nil {
+		return err
+	}
+
+	return e.symlink(f.Name, string(target))
+}
+
+// entry extracts a directory, a regular file or a special file.
+func (e *extractor) entry(name string, mode os.FileMode, size int64, r io.Reader) error {
+	p, err := e.path(name)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case mode.IsDir():
+		if e.opts.Safe {
+			if err := e.replaceLink(p); err != nil {
+				return err
+			}
+		}
+
+		return e.fs.MkdirAll(p, mode.Perm())
+	case mode.IsRegular():
+		return e.file(name, p, mode.Perm(), size, r)
+	case e.opts.Safe:
+		return &os.PathError{Op: "extract", Path: name, Err: ErrSpecialFile}
+	default:
+		return nil
+	}
+}
+
+// file writes the content read from r, failing if it exceeds the limits
+// even if the declared size doesn't.
+func (e *extractor) file(name, p string, perm os.FileMode, size int64, r io.Reader) error {
+	if err := e.checkSize(name, size); err != nil {
+		return err
+	}
+
+	if e.opts.Safe {
+		if err := e.replaceLink(p); err != nil {
+			return err
+		}
+	}
+
+	f, err := e.fs.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	limit := e.limit()
+	if limit >= 0 {
+		r = io.LimitReader(r, limit+1)
+	}
+
+	buf := getBuffer(e.opts.Stream.bufferSize())
+	defer putBuffer(buf)
+
+	n, err := io.CopyBuffer(f, r, *buf)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+
+	if err == nil && limit >= 0 && n > limit {
+		e.fs.Remove(p)
+		err = &os.PathError{Op: "extract", Path: name, Err: ErrTooLarge}
+	}
+
+	e.total += n
+	return err
+}
+
+// link extracts a hard link, as a copy of the target already extracted.
+func (e *extractor) link(name, target string, perm os.FileMode) error {
+	p, err := e.path(name)
+	if err != nil {
+		return err
+	}
+
+	src, err := e.path(target)
+	if err != nil {
+		return err
+	}
+
+	fi, err := e.fs.Lstat(src)
+	if err != nil {
+		return err
+	}
+
+	// the content of a symlink target may be outside of the destination
+	if fi.Mode()&os.ModeSymlink != 0 {
+		if e.opts.Safe {
+			return &os.LinkError{Op: "extract", Old: target, New: name, Err: ErrUnsafeLink}
+		}
+
+		if fi, err = e.fs.Stat(src); err != nil {
+			return err
+		}
+	}
+
+	f, err := e.fs.Open(src)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+	return e.file(name, p, perm, fi.Size(), f)
+}
+
+func (e *extractor) symlink(name, target string) error {
+	p, err := e.path(name)
+	if err != nil {
+		return err
+	}
+
+	if e.opts.Safe && e.escapes(name, target) {
+		return &os.LinkError{Op: "extract", Old: target, New: name, Err: ErrUnsafeLink}
+	}
+
+	return e.fs.Symlink(target, p)
+}
+
+// path returns the path in fs of the given entry. In safe mode, the absolute
+// names and the ones with ".." elements are rejected, otherwise they're
+// cleaned as relative to the destination.
+func (e *extractor) path(name string) (string, error) {
+	slashed := filepath.ToSlash(name)
+	if e.opts.Safe && (path.IsAbs(slashed) || filepath.IsAbs(name) ||
+		filepath.VolumeName(name) != "" || hasDotDot(slashed)) {
+		return "", &os.PathError{Op: "extract", Path: name, Err: ErrUnsafePath}
+	}
+
+	rel := path.Join("/", slashed)[1:]
+	if e.opts.Safe {
+		if err := e.checkParents(name, rel); err != nil {
+			return "", err
+		}
+	}
+
+	return e.fs.Join(e.dst, filepath.FromSlash(rel)), nil
+}
+
+// checkParents fails if any parent of the given entry, relative to the
+// destination, is a symlink, since the entry would be written wherever it
+// points to.
+func (e *extractor) checkParents(name, rel string) error {
+	var dir string
+	for _, elem := range strings.Split(path.Dir(rel), "/") {
+		if elem == "." {
+			return nil
+		}
+
+		dir = path.Join(dir, elem)
+		fi, err := e.fs.Lstat(e.fs.Join(e.dst, filepath.FromSlash(dir)))
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return &os.PathError{Op: "extract", Path: name, Err: ErrUnsafePath}
+		}
+	}
+
+	return nil
+}
+
+// replaceLink removes the symlink found at the given path, so it's replaced
+// by the entry instead of writing it where the symlink points to.
+func (e *extractor) replaceLink(p string) error {
+	fi, err := e.fs.Lstat(p)
+	if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		return nil
+	}
+
+	return e.fs.Remove(p)
+}
+
+func (e *extractor) checkSize(name string, size int64) error {
+	if limit := e.limit(); limit >= 0 && size > limit {
+		return &os.PathError{Op: "extract", Path: name, Err: ErrTooLarge}
+	}
+
+	return nil
+}
+
+// limit returns the maximum size of the next file, or -1 if unlimited.
+func (e *extractor) limit() int64 {
+	limit := int64(-1)
+	if e.opts.MaxFileSize > 0 {
+		limit = e.opts.MaxFileSize
+	}
+
+	if e.opts.MaxTotalSize > 0 {
+		left := e.opts.MaxTotalSize - e.total
+		if left < 0 {
+			left = 0
+		}
+
+		if limit < 0 || left < limit {
+			limit = left
+		}
+	}
+
+	return limit
+}
+
+// escapes returns true if the target of the link with the given name is
+// absolute, or points to a parent of the destination. The symlinks already
+// extracted are followed to find the real location of the target.
+func (e *extractor) escapes(name, target string) bool {
+	rel := path.Join("/", filepath.ToSlash(name))[1:]
+	dir := strings.Split(path.Dir(rel), "/")
+	if dir[0] == "." {
+		dir = nil
+	}
+
+	queue := []string{target}
+	links := 0
+	for len(queue) != 0 {
+		t := filepath.ToSlash(queue[0])
+		queue = queue[1:]
+		if path.IsAbs(t) || filepath.IsAbs(t) || filepath.VolumeName(t) != "" {
+			return true
+		}
+
+		elems := strings.Split(t, "/")
+		for i, elem := range elems {
+			switch elem {
+			case "", ".":
+				continue
+			case "..":
+				if len(dir) == 0 {
+					return true
+				}
+
+				dir = dir[:len(dir)-1]
+				continue
+			}
+
+			p := e.fs.Join(e.dst, filepath.FromSlash(path.Join(append(dir, elem)...)))
+			fi, err := e.fs.Lstat(p)
+			if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+				dir = append(dir, elem)
+				continue
+			}
+
+			link, err := e.fs.Readlink(p)
+			if links++; err != nil || links > linkutil.MaxLinks {
+				return true
+			}
+
+			// the target of the link is resolved before the rest
+			queue = append([]string{link, strings.Join(elems[i+1:], "/")}, queue...)
+			break
+		}
+	}
+
+	return false
+}
+
+func hasDotDot(name string) bool {
+	for _, elem := range strings.Split(name, "/") {
+		if elem == ".." {
+			return true
+		}
+	}
+
+	return false
+}
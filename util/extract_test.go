@@ -0,0 +1,243 @@
+package util_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+func (s *UtilSuite) TestTarExtract(c *C) {
+	archive := newTar(c,
+		&tar.Header{Name: "foo/", Typeflag: tar.TypeDir, Mode: 0700},
+		&tar.Header{Name: "foo/bar", Typeflag: tar.TypeReg, Mode: 0600, Size: 3},
+		&tar.Header{Name: "foo/qux", Typeflag: tar.TypeSymlink, Linkname: "bar"},
+		&tar.Header{Name: "baz", Typeflag: tar.TypeLink, Linkname: "foo/bar"},
+		&tar.Header{Name: "dev", Typeflag: tar.TypeChar},
+	)
+
+	fs := memfs.New()
+	c.Assert(util.TarExtract(fs, "dst", archive, nil), IsNil)
+
+	fi, err := fs.Stat("dst/foo/bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode(), Equals, os.FileMode(0600))
+
+	target, err := fs.Readlink("dst/foo/qux")
+	c.Assert(err, IsNil)
+	c.Assert(target, Equals, "bar")
+
+	content, err := readFile(fs, "dst/baz")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "foo")
+
+	_, err = fs.Lstat("dst/dev")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *UtilSuite) TestTarExtractSanitize(c *C) {
+	archive := newTar(c,
+		&tar.Header{Name: "/foo", Typeflag: tar.TypeReg, Mode: 0644, Size: 3},
+		&tar.Header{Name: "../../bar", Typeflag: tar.TypeReg, Mode: 0644, Size: 3},
+	)
+
+	fs := memfs.New()
+	c.Assert(util.TarExtract(fs, "dst", archive, nil), IsNil)
+
+	for _, name := range []string{"dst/foo", "dst/bar"} {
+		_, err := fs.Stat(name)
+		c.Assert(err, IsNil, Commentf("file %s", name))
+	}
+
+	infos, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 1)
+}
+
+func (s *UtilSuite) TestTarExtractSafe(c *C) {
+	for _, t := range []struct {
+		header *tar.Header
+		err    error
+	}{
+		{&tar.Header{Name: "/foo", Typeflag: tar.TypeReg, Size: 3}, util.ErrUnsafePath},
+		{&tar.Header{Name: "foo/../../bar", Typeflag: tar.TypeReg, Size: 3}, util.ErrUnsafePath},
+		{&tar.Header{Name: "foo", Typeflag: tar.TypeLink, Linkname: "../bar"}, util.ErrUnsafePath},
+		{&tar.Header{Name: "foo", Typeflag: tar.TypeSymlink, Linkname: "/etc"}, util.ErrUnsafeLink},
+		{&tar.Header{Name: "foo/bar", Typeflag: tar.TypeSymlink, Linkname: "../../etc"}, util.ErrUnsafeLink},
+		{&tar.Header{Name: "foo", Typeflag: tar.TypeBlock}, util.ErrSpecialFile},
+		{&tar.Header{Name: "foo", Typeflag: tar.TypeFifo}, util.ErrSpecialFile},
+	} {
+		fs := memfs.New()
+		err := util.TarExtract(fs, "dst", newTar(c, t.header), &util.ExtractOptions{Safe: true})
+		c.Assert(err, NotNil, Commentf("entry %s", t.header.Name))
+		c.Assert(underlyingError(err), Equals, t.err, Commentf("entry %s", t.header.Name))
+	}
+
+	archive := newTar(c,
+		&tar.Header{Name: "foo/bar", Typeflag: tar.TypeReg, Mode: 0644, Size: 3},
+		&tar.Header{Name: "foo/qux", Typeflag: tar.TypeSymlink, Linkname: "../foo/./bar"},
+	)
+
+	fs := memfs.New()
+	c.Assert(util.TarExtract(fs, "dst", archive, &util.ExtractOptions{Safe: true}), IsNil)
+
+	content, err := readFile(fs, "dst/foo/qux")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "foo")
+}
+
+func (s *UtilSuite) TestTarExtractSafeSymlinks(c *C) {
+	for _, headers := range [][]*tar.Header{{
+		// the file is written through the symlinks extracted
+		{Name: "sub/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "sub/up", Typeflag: tar.TypeSymlink, Linkname: ".."},
+		{Name: "sub/up/up2", Typeflag: tar.TypeSymlink, Linkname: ".."},
+		{Name: "sub/up/up2/evil", Typeflag: tar.TypeReg, Mode: 0644, Size: 3},
+	}, {
+		// the target goes through a symlink extracted
+		{Name: "sub/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "sub/up", Typeflag: tar.TypeSymlink, Linkname: ".."},
+		{Name: "escape", Typeflag: tar.TypeSymlink, Linkname: "sub/up/../evil"},
+	}} {
+		dir := c.MkDir()
+		fs := osfs.New(dir)
+		err := util.TarExtract(fs, "dst", newTar(c, headers...), &util.ExtractOptions{Safe: true})
+		c.Assert(err, NotNil)
+
+		_, err = fs.Lstat("evil")
+		c.Assert(os.IsNotExist(err), Equals, true)
+		_, err = fs.Lstat("dst/escape")
+		c.Assert(os.IsNotExist(err), Equals, true)
+	}
+
+	err := util.TarExtract(memfs.New(), "dst", newTar(c,
+		&tar.Header{Name: "sub/", Typeflag: tar.TypeDir, Mode: 0755},
+		&tar.Header{Name: "sub/up", Typeflag: tar.TypeSymlink, Linkname: ".."},
+		&tar.Header{Name: "sub/up/up2", Typeflag: tar.TypeSymlink, Linkname: ".."},
+	), &util.ExtractOptions{Safe: true})
+	c.Assert(underlyingError(err), Equals, util.ErrUnsafePath)
+
+	// a file replaces the symlink found in its place
+	fs := memfs.New()
+	c.Assert(util.WriteFile(fs, "outside", []byte("outside"), 0644), IsNil)
+	c.Assert(fs.Symlink("/outside", "dst/foo"), IsNil)
+	archive := newTar(c, &tar.Header{Name: "foo", Typeflag: tar.TypeReg, Mode: 0644, Size: 3})
+	c.Assert(util.TarExtract(fs, "dst", archive, &util.ExtractOptions{Safe: true}), IsNil)
+
+	content, err := readFile(fs, "outside")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "outside")
+
+	content, err = readFile(fs, "dst/foo")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "foo")
+}
+
+func (s *UtilSuite) TestTarExtractLimits(c *C) {
+	headers := []*tar.Header{
+		{Name: "foo", Typeflag: tar.TypeReg, Mode: 0644, Size: 3},
+		{Name: "bar", Typeflag: tar.TypeReg, Mode: 0644, Size: 3},
+	}
+
+	fs := memfs.New()
+	err := util.TarExtract(fs, "dst", newTar(c, headers...), &util.ExtractOptions{MaxFileSize: 2})
+	c.Assert(underlyingError(err), Equals, util.ErrTooLarge)
+
+	fs = memfs.New()
+	err = util.TarExtract(fs, "dst", newTar(c, headers...), &util.ExtractOptions{MaxTotalSize: 5})
+	c.Assert(underlyingError(err), Equals, util.ErrTooLarge)
+
+	_, err = fs.Stat("dst/foo")
+	c.Assert(err, IsNil)
+
+	fs = memfs.New()
+	err = util.TarExtract(fs, "dst", newTar(c, headers...), &util.ExtractOptions{MaxTotalSize: 6})
+	c.Assert(err, IsNil)
+}
+
+func (s *UtilSuite) TestZipExtract(c *C) {
+	buf := bytes.NewBuffer(nil)
+	zw := zip.NewWriter(buf)
+
+	h := &zip.FileHeader{Name: "foo/bar", Method: zip.Deflate}
+	h.SetMode(0600)
+	w, err := zw.CreateHeader(h)
+	c.Assert(err, IsNil)
+	_, err = w.Write(bytes.Repeat([]byte("0"), 1<<20))
+	c.Assert(err, IsNil)
+
+	h = &zip.FileHeader{Name: "foo/qux"}
+	h.SetMode(os.ModeSymlink | 0777)
+	w, err = zw.CreateHeader(h)
+	c.Assert(err, IsNil)
+	_, err = w.Write([]byte("../../etc"))
+	c.Assert(err, IsNil)
+	c.Assert(zw.Close(), IsNil)
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	c.Assert(err, IsNil)
+
+	fs := memfs.New()
+	c.Assert(util.ZipExtract(fs, "dst", zr, nil), IsNil)
+
+	fi, err := fs.Stat("dst/foo/bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Size(), Equals, int64(1<<20))
+	c.Assert(fi.Mode(), Equals, os.FileMode(0600))
+
+	target, err := fs.Readlink("dst/foo/qux")
+	c.Assert(err, IsNil)
+	c.Assert(target, Equals, "../../etc")
+
+	err = util.ZipExtract(memfs.New(), "dst", zr, &util.ExtractOptions{MaxFileSize: 1 << 10})
+	c.Assert(underlyingError(err), Equals, util.ErrTooLarge)
+
+	err = util.ZipExtract(memfs.New(), "dst", zr, &util.ExtractOptions{Safe: true})
+	c.Assert(underlyingError(err), Equals, util.ErrUnsafeLink)
+}
+
+// newTar returns a tar archive with the given entries, the regular files
+// with "foo" as content.
+func newTar(c *C, headers ...*tar.Header) *bytes.Buffer {
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	for _, h := range headers {
+		c.Assert(tw.WriteHeader(h), IsNil)
+		if h.Typeflag == tar.TypeReg {
+			_, err := tw.Write([]byte(strings.Repeat("foo", int(h.Size)/3)))
+			c.Assert(err, IsNil)
+		}
+	}
+
+	c.Assert(tw.Close(), IsNil)
+	return buf
+}
+
+func underlyingError(err error) error {
+	switch err := err.(type) {
+	case *os.PathError:
+		return err.Err
+	case *os.LinkError:
+		return err.Err
+	}
+
+	return err
+}
+
+func readFile(fs billy.Basic, filename string) ([]byte, error) {
+	f, err := fs.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
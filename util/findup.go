@@ -0,0 +1,33 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// FindUp looks for the given marker, a file or directory name such as ".git"
+// or "go.mod", in start and its ancestors, returning a filesystem chrooted
+// at the first directory containing it. If the marker isn't found up to the
+// root of fs, an error satisfying os.IsNotExist is returned.
+func FindUp(fs billy.Filesystem, start, marker string) (billy.Filesystem, error) {
+	dir := filepath.Clean(start)
+	for {
+		_, err := fs.Stat(fs.Join(dir, marker))
+		if err == nil {
+			return fs.Chroot(dir)
+		}
+
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, &os.PathError{Op: "findup", Path: start, Err: os.ErrNotExist}
+		}
+
+		dir = parent
+	}
+}
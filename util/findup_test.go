@@ -0,0 +1,44 @@
+package util_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+func (s *UtilSuite) TestFindUp(c *C) {
+	fs := memfs.New()
+	c.Assert(util.WriteFile(fs, "foo/go.mod", nil, 0644), IsNil)
+	c.Assert(fs.MkdirAll("foo/.git", 0755), IsNil)
+	c.Assert(fs.MkdirAll("foo/bar/baz", 0755), IsNil)
+	c.Assert(util.WriteFile(fs, "foo/bar/go.mod", nil, 0644), IsNil)
+
+	root, err := util.FindUp(fs, "foo/bar/baz", "go.mod")
+	c.Assert(err, IsNil)
+	c.Assert(root.Root(), Equals, filepath.Join(string(filepath.Separator), "foo", "bar"))
+
+	root, err = util.FindUp(fs, "/foo/bar/baz", ".git")
+	c.Assert(err, IsNil)
+	c.Assert(root.Root(), Equals, filepath.Join(string(filepath.Separator), "foo"))
+
+	_, err = root.Stat("bar/go.mod")
+	c.Assert(err, IsNil)
+
+	root, err = util.FindUp(fs, "foo", "go.mod")
+	c.Assert(err, IsNil)
+	c.Assert(root.Root(), Equals, filepath.Join(string(filepath.Separator), "foo"))
+}
+
+func (s *UtilSuite) TestFindUpNotFound(c *C) {
+	fs := memfs.New()
+	c.Assert(fs.MkdirAll("foo/bar", 0755), IsNil)
+
+	_, err := util.FindUp(fs, "foo/bar", ".git")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	_, err = util.FindUp(fs, "/foo/bar", ".git")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
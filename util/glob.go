@@ -1,6 +1,7 @@
 package util
 
 import (
+	"os"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -8,17 +9,29 @@ import (
 	"gopkg.in/src-d/go-billy.v4"
 )
 
+// doubleStar is the path element matching any number of directories.
+const doubleStar = "**"
+
 // Glob returns the names of all files matching pattern or nil
 // if there is no matching file. The syntax of patterns is the same
 // as in Match. The pattern may describe hierarchical names such as
 // /usr/*/bin/ed (assuming the Separator is '/').
 //
+// A "**" path element matches any number of directories, including none,
+// eg.: "foo/**/*.go" matches "foo/bar.go" and "foo/bar/baz.go". As the last
+// element, it matches every file below the directory. The symlinks aren't
+// followed by "**".
+//
 // Glob ignores file system errors such as I/O errors reading directories.
 // The only possible returned error is ErrBadPattern, when pattern
 // is malformed.
 //
 // Function originally from https://golang.org/src/path/filepath/match_test.go
 func Glob(fs billy.Filesystem, pattern string) (matches []string, err error) {
+	if hasDoubleStar(pattern) {
+		return globDoubleStar(fs, pattern)
+	}
+
 	if !hasMeta(pattern) {
 		if _, err = fs.Lstat(pattern); err != nil {
 			return nil, nil
@@ -46,6 +59,94 @@ func Glob(fs billy.Filesystem, pattern string) (matches []string, err error) {
 	return
 }
 
+// globDoubleStar returns the names of all files matching pattern, expanding
+// its first "**" element to every directory below the ones matching the
+// elements preceding it.
+func globDoubleStar(fs billy.Filesystem, pattern string) ([]string, error) {
+	const separator = string(filepath.Separator)
+
+	elements := strings.Split(pattern, separator)
+	i := 0
+	for elements[i] != doubleStar {
+		i++
+	}
+
+	base := strings.Join(elements[:i], separator)
+	switch {
+	case base == "" && i > 0:
+		base = separator
+	case base == "":
+		base = "."
+	}
+
+	rest := strings.Join(elements[i+1:], separator)
+	if rest != "" {
+		// the pattern is checked even if no directory is found
+		if _, err := filepath.Match(rest, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	bases, err := Glob(fs, base)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var matches []string
+	for _, base := range bases {
+		err := Walk(fs, base, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+
+			if rest == "" {
+				if p != base {
+					matches = append(matches, p)
+				}
+
+				return nil
+			}
+
+			if !fi.IsDir() {
+				return nil
+			}
+
+			m, err := Glob(fs, filepath.Join(p, rest))
+			if err != nil {
+				return err
+			}
+
+			for _, p := range m {
+				if !seen[p] {
+					seen[p] = true
+					matches = append(matches, p)
+				}
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// hasDoubleStar reports whether path contains a "**" element.
+func hasDoubleStar(path string) bool {
+	for _, e := range strings.Split(path, string(filepath.Separator)) {
+		if e == doubleStar {
+			return true
+		}
+	}
+
+	return false
+}
+
 // cleanGlobPath prepares path for glob matching.
 func cleanGlobPath(path string) string {
 	switch path {
@@ -32,3 +32,42 @@ func (s *UtilSuite) TestCreate(c *C) {
 	})
 
 }
+
+func (s *UtilSuite) TestGlobDoubleStar(c *C) {
+	fs := memfs.New()
+	for _, name := range []string{"a.go", "foo/b.go", "foo/bar/c.go", "foo/bar/d.txt", "qux/e.go"} {
+		c.Assert(util.WriteFile(fs, name, nil, 0644), IsNil)
+	}
+
+	names, err := util.Glob(fs, "**/*.go")
+	c.Assert(err, IsNil)
+	c.Assert(names, DeepEquals, []string{
+		"a.go",
+		filepath.Join("foo", "b.go"),
+		filepath.Join("foo", "bar", "c.go"),
+		filepath.Join("qux", "e.go"),
+	})
+
+	names, err = util.Glob(fs, filepath.Join("f*", "**", "*.go"))
+	c.Assert(err, IsNil)
+	c.Assert(names, DeepEquals, []string{
+		filepath.Join("foo", "b.go"),
+		filepath.Join("foo", "bar", "c.go"),
+	})
+
+	names, err = util.Glob(fs, filepath.Join("/foo", "**"))
+	c.Assert(err, IsNil)
+	c.Assert(names, DeepEquals, []string{
+		filepath.Join("/foo", "b.go"),
+		filepath.Join("/foo", "bar"),
+		filepath.Join("/foo", "bar", "c.go"),
+		filepath.Join("/foo", "bar", "d.txt"),
+	})
+
+	names, err = util.Glob(fs, filepath.Join("**", "**", "c.go"))
+	c.Assert(err, IsNil)
+	c.Assert(names, DeepEquals, []string{filepath.Join("foo", "bar", "c.go")})
+
+	_, err = util.Glob(fs, filepath.Join("**", "["))
+	c.Assert(err, Equals, filepath.ErrBadPattern)
+}
@@ -0,0 +1,78 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// ReadDirOptions holds the configuration of ReadDirRecursive.
+type ReadDirOptions struct {
+	// MaxDepth is the maximum depth of the files listed, the files in the
+	// root being at depth 1. There is no limit if zero.
+	MaxDepth int
+	// IncludeDirs lists the directories too, before their content.
+	IncludeDirs bool
+	// Filter, if not nil, tells whether the given file or directory,
+	// relative to the root, is listed. The content of the directories
+	// filtered out isn't listed either.
+	Filter func(path string, fi os.FileInfo) bool
+	// FollowSymlinks lists the content of the directories pointed by
+	// symlinks, as WalkOptions does.
+	FollowSymlinks bool
+}
+
+// FileEntry is a file listed by ReadDirRecursive.
+type FileEntry struct {
+	// Path is the path of the file, relative to the root.
+	Path string
+	// Info is the info of the file, or of the target if a symlink followed.
+	Info os.FileInfo
+}
+
+// ReadDirRecursive returns the files below the given root, in lexical order,
+// as a flat list. The options may be nil, listing every file.
+func ReadDirRecursive(fs billy.Filesystem, root string, opts *ReadDirOptions) ([]FileEntry, error) {
+	if opts == nil {
+		opts = &ReadDirOptions{}
+	}
+
+	var entries []FileEntry
+	err := WalkWithOptions(fs, root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		if opts.Filter != nil && !opts.Filter(rel, fi) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if !fi.IsDir() || opts.IncludeDirs {
+			entries = append(entries, FileEntry{Path: rel, Info: fi})
+		}
+
+		depth := strings.Count(rel, string(filepath.Separator)) + 1
+		if fi.IsDir() && opts.MaxDepth > 0 && depth >= opts.MaxDepth {
+			return filepath.SkipDir
+		}
+
+		return nil
+	}, &WalkOptions{FollowSymlinks: opts.FollowSymlinks})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,52 @@
+package util_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+func entryPaths(entries []util.FileEntry) []string {
+	var paths []string
+	for _, e := range entries {
+		paths = append(paths, filepath.ToSlash(e.Path))
+	}
+
+	return paths
+}
+
+func (s *UtilSuite) TestReadDirRecursive(c *C) {
+	fs := memfs.New()
+	for _, name := range []string{"root/b", "root/a/c", "root/a/d/e", ".git/config"} {
+		c.Assert(util.WriteFile(fs, name, []byte(name), 0644), IsNil)
+	}
+
+	entries, err := util.ReadDirRecursive(fs, "root", nil)
+	c.Assert(err, IsNil)
+	c.Assert(entryPaths(entries), DeepEquals, []string{"a/c", "a/d/e", "b"})
+	c.Assert(entries[0].Info.Size(), Equals, int64(len("root/a/c")))
+
+	entries, err = util.ReadDirRecursive(fs, "root", &util.ReadDirOptions{
+		MaxDepth:    2,
+		IncludeDirs: true,
+	})
+
+	c.Assert(err, IsNil)
+	c.Assert(entryPaths(entries), DeepEquals, []string{"a", "a/c", "a/d", "b"})
+
+	entries, err = util.ReadDirRecursive(fs, "/", &util.ReadDirOptions{
+		Filter: func(path string, fi os.FileInfo) bool {
+			return !strings.HasPrefix(fi.Name(), ".") && fi.Name() != "d"
+		},
+	})
+
+	c.Assert(err, IsNil)
+	c.Assert(entryPaths(entries), DeepEquals, []string{"root/a/c", "root/b"})
+
+	_, err = util.ReadDirRecursive(fs, "missing", nil)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
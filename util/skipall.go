@@ -0,0 +1,9 @@
+//go:build !go1.20
+// +build !go1.20
+
+package util
+
+// isSkipAll returns true if err is SkipAll.
+func isSkipAll(err error) bool {
+	return err == SkipAll
+}
@@ -0,0 +1,11 @@
+//go:build go1.20
+// +build go1.20
+
+package util
+
+import "path/filepath"
+
+// isSkipAll returns true if err is SkipAll, or filepath.SkipAll.
+func isSkipAll(err error) bool {
+	return err == SkipAll || err == filepath.SkipAll
+}
@@ -0,0 +1,287 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// CompareMode tells how Sync finds the files changed.
+type CompareMode int
+
+const (
+	// CompareSizeAndTime considers a file changed if its size is different,
+	// or it was modified after the copy in the destination.
+	CompareSizeAndTime CompareMode = iota
+	// CompareSize considers a file changed if its size is different.
+	CompareSize
+	// CompareContent considers a file changed if its content is different,
+	// reading both files, or hashing their chunks if both filesystems
+	// implement ChunkHasher.
+	CompareContent
+)
+
+// SyncOptions holds the configuration of Sync.
+type SyncOptions struct {
+	// Compare is the way the files changed are found, CompareSizeAndTime by
+	// default.
+	Compare CompareMode
+	// ChunkSize is the size of the chunks hashed with CompareContent, 1MiB
+	// if zero.
+	ChunkSize int64
+	// Delete removes the files of the destination not found in the source.
+	Delete bool
+	// DryRun only reports the changes, without doing them.
+	DryRun bool
+	// Stream configures the buffers used to copy the files.
+	Stream *StreamOptions
+}
+
+// SyncSummary holds the changes done by Sync. The paths are relative to the
+// root of the filesystems.
+type SyncSummary struct {
+	// Created are the files, symlinks and directories copied, not found in
+	// the destination.
+	Created []string
+	// Updated are the files and symlinks copied over the ones of the
+	// destination.
+	Updated []string
+	// Deleted are the files, symlinks and directories removed from the
+	// destination, not the ones below the directories removed.
+	Deleted []string
+	// Unchanged is the number of files and symlinks found unchanged.
+	Unchanged int
+}
+
+const defaultHashChunkSize = 1 << 20
+
+// Sync makes the tree of dst equal to the one of src, copying only the files
+// changed. The symlinks are copied as symlinks, and the modes and times of
+// the files are preserved if dst implements billy.Change.
+func Sync(dst, src billy.Filesystem, opts *SyncOptions) (*SyncSummary, error) {
+	if opts == nil {
+		opts = &SyncOptions{}
+	}
+
+	s := &syncer{dst: dst, src: src, opts: opts, summary: &SyncSummary{}}
+	s.change, _ = dst.(billy.Change)
+
+	const separator = string(filepath.Separator)
+	if err := Walk(src, separator, s.sync); err != nil {
+		return nil, err
+	}
+
+	if opts.Delete {
+		if err := Walk(dst, separator, s.delete); err != nil {
+			return nil, err
+		}
+	}
+
+	// the modes of the directories are set once their content is synced,
+	// since they may deny writing it
+	for i := len(s.dirs) - 1; i >= 0; i-- {
+		d := s.dirs[i]
+		if err := s.change.Chmod(d.path, d.fi.Mode().Perm()); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.summary, nil
+}
+
+type syncer struct {
+	dst, src billy.Filesystem
+	opts     *SyncOptions
+	change   billy.Change
+	summary  *SyncSummary
+	// missing is the last directory not created in a dry run.
+	missing string
+	// dirs are the directories created, to set their modes.
+	dirs []copiedDir
+}
+
+// isMissing reports whether the given file is below a directory not created
+// in a dry run.
+func (s *syncer) isMissing(p string) bool {
+	return s.missing != "" && strings.HasPrefix(p, s.missing+string(filepath.Separator))
+}
+
+func (s *syncer) sync(p string, fi os.FileInfo, err error) error {
+	if err != nil {
+		return err
+	}
+
+	rel, err := filepath.Rel(string(filepath.Separator), p)
+	if err != nil || rel == "." {
+		return err
+	}
+
+	var cur os.FileInfo
+	if !s.isMissing(p) {
+		cur, err = s.dst.Lstat(p)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	created := cur == nil
+	if cur != nil {
+		changed, err := s.changed(p, fi, cur)
+		if err != nil {
+			return err
+		}
+
+		if !changed {
+			if !fi.IsDir() {
+				s.summary.Unchanged++
+			}
+
+			return nil
+		}
+	}
+
+	if created {
+		s.summary.Created = append(s.summary.Created, rel)
+	} else {
+		s.summary.Updated = append(s.summary.Updated, rel)
+	}
+
+	if s.opts.DryRun {
+		if fi.IsDir() {
+			// the files below a directory not created are missing too
+			s.missing = p
+		}
+
+		return nil
+	}
+
+	if cur != nil && (!cur.Mode().IsRegular() || !fi.Mode().IsRegular()) {
+		if err := RemoveAll(s.dst, p); err != nil {
+			return err
+		}
+	}
+
+	return s.copy(p, fi)
+}
+
+// changed reports whether the given file of the source must be copied over
+// the one of the destination.
+func (s *syncer) changed(p string, fi, cur os.FileInfo) (bool, error) {
+	if fi.Mode()&os.ModeType != cur.Mode()&os.ModeType {
+		return true, nil
+	}
+
+	switch {
+	case fi.IsDir():
+		return false, nil
+	case fi.Mode()&os.ModeSymlink != 0:
+		same, err := sameLink(s.src, s.dst, p)
+		return !same, err
+	}
+
+	if fi.Size() != cur.Size() {
+		return true, nil
+	}
+
+	switch s.opts.Compare {
+	case CompareSize:
+		return false, nil
+	case CompareContent:
+		same, err := sameContent(s.src, s.dst, p, s.opts.ChunkSize, s.opts.Stream)
+		return !same, err
+	default:
+		return fi.ModTime().After(cur.ModTime()), nil
+	}
+}
+
+// sameLink reports whether the given symlink has the same target in both
+// filesystems.
+func sameLink(a, b billy.Filesystem, p string) (bool, error) {
+	ta, err := a.Readlink(p)
+	if err != nil {
+		return false, err
+	}
+
+	tb, err := b.Readlink(p)
+	if err != nil {
+		return false, err
+	}
+
+	return ta == tb, nil
+}
+
+// sameContent reports whether the given file has the same content in both
+// filesystems, hashing chunks of the given size if both implement
+// ChunkHasher, even behind a wrapper such as chroot.
+func sameContent(a, b billy.Filesystem, p string, chunkSize int64, opts *StreamOptions) (bool, error) {
+	hashA, _ := chunkHasher(a, p)
+	hashB, _ := chunkHasher(b, p)
+	if hashA == nil || hashB == nil {
+		return EqualWithOptions(a, p, b, p, opts)
+	}
+
+	if chunkSize <= 0 {
+		chunkSize = defaultHashChunkSize
+	}
+
+	return EqualChunksWithOptions(a, p, b, p, chunkSize, opts)
+}
+
+func (s *syncer) copy(p string, fi os.FileInfo) error {
+	switch {
+	case fi.IsDir():
+		if err := s.dst.MkdirAll(p, fi.Mode().Perm()); err != nil {
+			return err
+		}
+
+		if s.change != nil {
+			s.dirs = append(s.dirs, copiedDir{path: p, fi: fi})
+		}
+
+		return nil
+	case fi.Mode()&os.ModeSymlink != 0:
+		target, err := s.src.Readlink(p)
+		if err != nil {
+			return err
+		}
+
+		return s.dst.Symlink(target, p)
+	}
+
+	err := CopyFileWithOptions(s.dst, p, s.src, p, fi.Mode().Perm(), s.opts.Stream)
+	if err != nil || s.change == nil {
+		return err
+	}
+
+	return s.change.Chtimes(p, fi.ModTime(), fi.ModTime())
+}
+
+func (s *syncer) delete(p string, fi os.FileInfo, err error) error {
+	if err != nil {
+		return err
+	}
+
+	rel, err := filepath.Rel(string(filepath.Separator), p)
+	if err != nil || rel == "." {
+		return err
+	}
+
+	if _, err := s.src.Lstat(p); !os.IsNotExist(err) {
+		return err
+	}
+
+	s.summary.Deleted = append(s.summary.Deleted, rel)
+	if !s.opts.DryRun {
+		if err := RemoveAll(s.dst, p); err != nil {
+			return err
+		}
+	}
+
+	if fi.IsDir() {
+		return filepath.SkipDir
+	}
+
+	return nil
+}
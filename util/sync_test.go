@@ -0,0 +1,115 @@
+package util_test
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+func (s *UtilSuite) TestSync(c *C) {
+	src, dst := memfs.New(), memfs.New()
+	c.Assert(util.WriteFile(src, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(src, "bar/baz", []byte("baz"), 0644), IsNil)
+	c.Assert(src.Symlink("foo", "link"), IsNil)
+
+	summary, err := util.Sync(dst, src, nil)
+	c.Assert(err, IsNil)
+	c.Assert(summary, DeepEquals, &util.SyncSummary{
+		Created: []string{"bar", filepath.Join("bar", "baz"), "foo", "link"},
+	})
+
+	c.Assert(test.ReadFile(c, dst, "bar/baz"), Equals, "baz")
+	c.Assert(test.ReadFile(c, dst, "link"), Equals, "foo")
+
+	c.Assert(util.WriteFile(src, "foo", []byte("changed"), 0644), IsNil)
+	c.Assert(util.WriteFile(src, "bar/baz", []byte("qux"), 0644), IsNil)
+	c.Assert(util.WriteFile(dst, "extra/file", []byte("extra"), 0644), IsNil)
+
+	summary, err = util.Sync(dst, src, &util.SyncOptions{Compare: util.CompareContent, Delete: true})
+	c.Assert(err, IsNil)
+	c.Assert(summary, DeepEquals, &util.SyncSummary{
+		Updated:   []string{filepath.Join("bar", "baz"), "foo"},
+		Deleted:   []string{"extra"},
+		Unchanged: 1,
+	})
+
+	c.Assert(test.ReadFile(c, dst, "foo"), Equals, "changed")
+	c.Assert(test.ReadFile(c, dst, "bar/baz"), Equals, "qux")
+	_, err = dst.Lstat("extra")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *UtilSuite) TestSyncReadOnly(c *C) {
+	src := memfs.New()
+	c.Assert(src.MkdirAll("ro", 0555), IsNil)
+	c.Assert(util.WriteFile(src, "ro/foo", []byte("foo"), 0444), IsNil)
+
+	dst := &copierFS{Filesystem: memfs.New()}
+	_, err := util.Sync(dst, src, nil)
+	c.Assert(err, IsNil)
+	c.Assert(test.ReadFile(c, dst, "ro/foo"), Equals, "foo")
+	c.Assert(dst.modes[filepath.Join(string(filepath.Separator), "ro")], Equals, os.FileMode(0555))
+}
+
+func (s *UtilSuite) TestSyncChunkHasherChroot(c *C) {
+	a, b := &hasherFS{Filesystem: memfs.New()}, &hasherFS{Filesystem: memfs.New()}
+	c.Assert(util.WriteFile(a, "src/foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(b, "dst/foo", []byte("foo"), 0644), IsNil)
+
+	src, dst := chroot.New(a, "src"), chroot.New(b, "dst")
+	summary, err := util.Sync(dst, src, &util.SyncOptions{Compare: util.CompareContent})
+	c.Assert(err, IsNil)
+	c.Assert(summary, DeepEquals, &util.SyncSummary{Unchanged: 1})
+	c.Assert(a.hashes, Equals, 1)
+	c.Assert(b.hashes, Equals, 1)
+}
+
+func (s *UtilSuite) TestSyncDryRun(c *C) {
+	src, dst := memfs.New(), memfs.New()
+	c.Assert(util.WriteFile(src, "bar/baz", []byte("baz"), 0644), IsNil)
+	c.Assert(util.WriteFile(dst, "bar", []byte("bar"), 0644), IsNil)
+	c.Assert(util.WriteFile(dst, "extra", []byte("extra"), 0644), IsNil)
+
+	summary, err := util.Sync(dst, src, &util.SyncOptions{Delete: true, DryRun: true})
+	c.Assert(err, IsNil)
+	c.Assert(summary, DeepEquals, &util.SyncSummary{
+		Created: []string{filepath.Join("bar", "baz")},
+		Updated: []string{"bar"},
+		Deleted: []string{"extra"},
+	})
+
+	c.Assert(test.ReadFile(c, dst, "bar"), Equals, "bar")
+	c.Assert(test.ReadFile(c, dst, "extra"), Equals, "extra")
+}
+
+func (s *UtilSuite) TestSyncCompare(c *C) {
+	dir := c.MkDir()
+	src, dst := osfs.New(filepath.Join(dir, "src")), osfs.New(filepath.Join(dir, "dst"))
+	c.Assert(util.WriteFile(src, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(dst, "foo", []byte("bar"), 0644), IsNil)
+
+	old := time.Now().Add(-time.Hour)
+	c.Assert(os.Chtimes(filepath.Join(dir, "src", "foo"), old, old), IsNil)
+
+	summary, err := util.Sync(dst, src, nil)
+	c.Assert(err, IsNil)
+	c.Assert(summary.Unchanged, Equals, 1)
+	c.Assert(test.ReadFile(c, dst, "foo"), Equals, "bar")
+
+	summary, err = util.Sync(dst, src, &util.SyncOptions{Compare: util.CompareSize})
+	c.Assert(err, IsNil)
+	c.Assert(summary.Unchanged, Equals, 1)
+
+	c.Assert(os.Chtimes(filepath.Join(dir, "src", "foo"), time.Now(), time.Now()), IsNil)
+	summary, err = util.Sync(dst, src, nil)
+	c.Assert(err, IsNil)
+	c.Assert(summary.Updated, DeepEquals, []string{"foo"})
+	c.Assert(test.ReadFile(c, dst, "foo"), Equals, "foo")
+}
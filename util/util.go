@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,6 +15,13 @@ import (
 // RemoveAll removes path and any children it contains. It removes everything it
 // can but returns the first error it encounters. If the path does not exist,
 // RemoveAll returns nil (no error).
+//
+// The filesystems implementing RemoveAll themselves are used as they are.
+// Otherwise the children are removed one by one, without following the
+// symlinks. If the filesystem implements billy.Change, the read-only
+// directories are made writable to remove their children, as are the
+// read-only files failing to be removed. The files created concurrently in a
+// directory being removed are removed too, up to a few times.
 func RemoveAll(fs billy.Basic, path string) error {
 	fs, path = getUnderlyingAndPath(fs, path)
 
@@ -28,6 +36,10 @@ type removerAll interface {
 	RemoveAll(string) error
 }
 
+// removeAllPasses is the number of times the content of a directory is
+// removed, if it isn't empty afterwards since files are created concurrently.
+const removeAllPasses = 3
+
 func removeAll(fs billy.Basic, path string) error {
 	// This implementation is adapted from os.RemoveAll.
 
@@ -38,7 +50,7 @@ func removeAll(fs billy.Basic, path string) error {
 	}
 
 	// Otherwise, is this a directory we need to recurse into?
-	dir, serr := fs.Stat(path)
+	dir, serr := lstat(fs, path)
 	if serr != nil {
 		if os.IsNotExist(serr) {
 			return nil
@@ -48,7 +60,12 @@ func removeAll(fs billy.Basic, path string) error {
 	}
 
 	if !dir.IsDir() {
-		// Not a directory; return the error from Remove.
+		// Not a directory; retry if read-only, or return the error from
+		// Remove.
+		if os.IsPermission(err) && makeWritable(fs, path, dir, 0600) {
+			return ignoreNotExist(fs.Remove(path))
+		}
+
 		return err
 	}
 
@@ -57,40 +74,79 @@ func removeAll(fs billy.Basic, path string) error {
 		return billy.ErrNotSupported
 	}
 
-	// Directory.
-	fis, err := dirfs.ReadDir(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			// Race. It was deleted between the Lstat and Open.
-			// Return nil per RemoveAll's docs.
+	// Directory, writable to remove its children.
+	if dir.Mode().Perm()&0700 != 0700 {
+		makeWritable(fs, path, dir, 0700)
+	}
+
+	for pass := 1; ; pass++ {
+		fis, err := dirfs.ReadDir(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// Race. It was deleted between the Lstat and Open.
+				// Return nil per RemoveAll's docs.
+				return nil
+			}
+
+			return err
+		}
+
+		// Remove contents & return first error.
+		err = nil
+		for _, fi := range fis {
+			cpath := fs.Join(path, fi.Name())
+			err1 := removeAll(fs, cpath)
+			if err == nil {
+				err = err1
+			}
+		}
+
+		// Remove directory.
+		err1 := fs.Remove(path)
+		if err1 == nil || os.IsNotExist(err1) {
 			return nil
 		}
 
-		return err
-	}
+		if err != nil {
+			return err
+		}
 
-	// Remove contents & return first error.
-	err = nil
-	for _, fi := range fis {
-		cpath := fs.Join(path, fi.Name())
-		err1 := removeAll(fs, cpath)
-		if err == nil {
-			err = err1
+		// Race. Files may have been created meanwhile, otherwise retrying
+		// won't help.
+		if pass == removeAllPasses || len(fis) == 0 {
+			return err1
 		}
 	}
+}
 
-	// Remove directory.
-	err1 := fs.Remove(path)
-	if err1 == nil || os.IsNotExist(err1) {
-		return nil
+// lstat returns the info of the given file, not following the symlink if the
+// filesystem supports them.
+func lstat(fs billy.Basic, path string) (os.FileInfo, error) {
+	if sl, ok := fs.(billy.Symlink); ok {
+		return sl.Lstat(path)
 	}
 
-	if err == nil {
-		err = err1
+	return fs.Stat(path)
+}
+
+// makeWritable adds the given permissions to the given file, reporting
+// whether they were added, what requires the filesystem to implement
+// billy.Change.
+func makeWritable(fs billy.Basic, path string, fi os.FileInfo, perm os.FileMode) bool {
+	ch, ok := fs.(billy.Change)
+	if !ok || fi.Mode()&os.ModeSymlink != 0 {
+		return false
 	}
 
-	return err
+	return ch.Chmod(path, fi.Mode().Perm()|perm) == nil
+}
+
+func ignoreNotExist(err error) error {
+	if os.IsNotExist(err) {
+		return nil
+	}
 
+	return err
 }
 
 // WriteFile writes data to a file named by filename in the given filesystem.
@@ -137,13 +193,26 @@ func nextSuffix() string {
 	return strconv.Itoa(int(1e9 + r%1e9))[1:]
 }
 
+// tempName returns a random name in the given directory for a temporary file,
+// with the random string replacing the last "*" of the pattern, or appended
+// to it if it has none.
+func tempName(dir, pattern string) string {
+	prefix, suffix := pattern, ""
+	if i := strings.LastIndex(pattern, "*"); i != -1 {
+		prefix, suffix = pattern[:i], pattern[i+1:]
+	}
+
+	return filepath.Join(dir, prefix+nextSuffix()+suffix)
+}
+
 // TempFile creates a new temporary file in the directory dir with a name
 // beginning with prefix, opens the file for reading and writing, and returns
 // the resulting *os.File. If dir is the empty string, TempFile uses the default
 // directory for temporary files (see os.TempDir). Multiple programs calling
 // TempFile simultaneously will not choose the same file. The caller can use
 // f.Name() to find the pathname of the file. It is the caller's responsibility
-// to remove the file when no longer needed.
+// to remove the file when no longer needed. If prefix includes a "*", the
+// random string replaces the last "*".
 func TempFile(fs billy.Basic, dir, prefix string) (f billy.File, err error) {
 	// This implementation is based on stdlib ioutil.TempFile.
 
@@ -151,10 +220,16 @@ func TempFile(fs billy.Basic, dir, prefix string) (f billy.File, err error) {
 		dir = os.TempDir()
 	}
 
+	return createTemp(fs, dir, prefix, 0600)
+}
+
+// createTemp creates a new file in the directory dir, with a random name given
+// the pattern as in TempFile, and the given permissions.
+func createTemp(fs billy.Basic, dir, pattern string, perm os.FileMode) (f billy.File, err error) {
 	nconflict := 0
 	for i := 0; i < 10000; i++ {
-		name := filepath.Join(dir, prefix+nextSuffix())
-		f, err = fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		name := tempName(dir, pattern)
+		f, err = fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
 		if os.IsExist(err) {
 			if nconflict++; nconflict > 10 {
 				randmu.Lock()
@@ -171,10 +246,14 @@ func TempFile(fs billy.Basic, dir, prefix string) (f billy.File, err error) {
 // TempDir creates a new temporary directory in the directory dir
 // with a name beginning with prefix and returns the path of the
 // new directory. If dir is the empty string, TempDir uses the
-// default directory for temporary files (see os.TempDir).
+// default directory for temporary files (see os.TempDir). If prefix
+// includes a "*", the random string replaces the last "*".
 // Multiple programs calling TempDir simultaneously
 // will not choose the same directory. It is the caller's responsibility
 // to remove the directory when no longer needed.
+//
+// Since MkdirAll doesn't fail if the directory exists, the names already
+// taken are only detected on the filesystems implementing Stat.
 func TempDir(fs billy.Dir, dir, prefix string) (name string, err error) {
 	// This implementation is based on stdlib ioutil.TempDir
 
@@ -182,10 +261,22 @@ func TempDir(fs billy.Dir, dir, prefix string) (name string, err error) {
 		dir = os.TempDir()
 	}
 
+	basic, _ := fs.(billy.Basic)
+
 	nconflict := 0
 	for i := 0; i < 10000; i++ {
-		try := filepath.Join(dir, prefix+nextSuffix())
-		err = fs.MkdirAll(try, 0700)
+		try := tempName(dir, prefix)
+		err = nil
+		if basic != nil {
+			if _, serr := basic.Stat(try); serr == nil {
+				err = &os.PathError{Op: "mkdir", Path: try, Err: os.ErrExist}
+			}
+		}
+
+		if err == nil {
+			err = fs.MkdirAll(try, 0700)
+		}
+
 		if os.IsExist(err) {
 			if nconflict++; nconflict > 10 {
 				randmu.Lock()
@@ -194,11 +285,6 @@ func TempDir(fs billy.Dir, dir, prefix string) (name string, err error) {
 			}
 			continue
 		}
-		if os.IsNotExist(err) {
-			if _, err := os.Stat(dir); os.IsNotExist(err) {
-				return "", err
-			}
-		}
 		if err == nil {
 			name = try
 		}
@@ -5,7 +5,9 @@ import (
 	"path/filepath"
 	"regexp"
 	"testing"
+	"time"
 
+	"gopkg.in/src-d/go-billy.v4"
 	"gopkg.in/src-d/go-billy.v4/memfs"
 	"gopkg.in/src-d/go-billy.v4/util"
 )
@@ -41,3 +43,144 @@ func TestTempDir(t *testing.T) {
 		}
 	}
 }
+
+func TestTempDirPattern(t *testing.T) {
+	fs := memfs.New()
+
+	seen := make(map[string]bool)
+	re := regexp.MustCompile("^" + regexp.QuoteMeta(filepath.Join("tmp", "foo")) + "[0-9]+\\.d$")
+	for i := 0; i < 10; i++ {
+		name, err := util.TempDir(fs, "tmp", "foo*.d")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !re.MatchString(name) {
+			t.Errorf("TempDir(tmp, `foo*.d`) created bad name %s", name)
+		}
+
+		if seen[name] {
+			t.Errorf("TempDir(tmp, `foo*.d`) created %s twice", name)
+		}
+
+		seen[name] = true
+	}
+
+	f, err := util.TempFile(fs, "tmp", "bar*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+	if !regexp.MustCompile(`bar[0-9]+\.txt$`).MatchString(f.Name()) {
+		t.Errorf("TempFile(tmp, `bar*.txt`) created bad name %s", f.Name())
+	}
+}
+
+func TestRemoveAll(t *testing.T) {
+	fs := memfs.New()
+	for _, name := range []string{"foo/bar", "foo/baz/qux", "target/file"} {
+		if err := util.WriteFile(fs, name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := fs.Symlink("/target", "foo/link"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := util.RemoveAll(fs, "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fs.Lstat("foo"); !os.IsNotExist(err) {
+		t.Errorf("foo not removed: %v", err)
+	}
+
+	if _, err := fs.Lstat("target/file"); err != nil {
+		t.Errorf("symlink target removed: %v", err)
+	}
+
+	if err := util.RemoveAll(fs, "missing"); err != nil {
+		t.Errorf("RemoveAll(missing) = %v", err)
+	}
+}
+
+func TestRemoveAllReadOnly(t *testing.T) {
+	fs := &restrictedFS{Filesystem: memfs.New(), modes: make(map[string]os.FileMode)}
+	if err := util.WriteFile(fs, "foo/bar/baz", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs.modes["foo/bar"] = 0500
+	if err := util.RemoveAll(fs, "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fs.Lstat("foo"); !os.IsNotExist(err) {
+		t.Errorf("foo not removed: %v", err)
+	}
+
+	if fs.modes["foo/bar"]&0700 != 0700 {
+		t.Errorf("foo/bar mode = %v", fs.modes["foo/bar"])
+	}
+}
+
+func TestRemoveAllConcurrent(t *testing.T) {
+	fs := &restrictedFS{Filesystem: memfs.New(), modes: make(map[string]os.FileMode)}
+	if err := util.WriteFile(fs, "foo/bar", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// a file is created in the directory while its content is removed
+	fs.onRemove = func(name string) {
+		if name == "foo/bar" {
+			fs.onRemove = nil
+			util.WriteFile(fs, "foo/new", nil, 0644)
+		}
+	}
+
+	if err := util.RemoveAll(fs, "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fs.Lstat("foo"); !os.IsNotExist(err) {
+		t.Errorf("foo not removed: %v", err)
+	}
+}
+
+// restrictedFS is a filesystem implementing billy.Change, failing to remove
+// the files of the directories without write permissions, and the non-empty
+// directories.
+type restrictedFS struct {
+	billy.Filesystem
+	modes    map[string]os.FileMode
+	onRemove func(name string)
+}
+
+func (fs *restrictedFS) Remove(name string) error {
+	if mode, ok := fs.modes[filepath.Dir(name)]; ok && mode&0200 == 0 {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrPermission}
+	}
+
+	if fis, err := fs.ReadDir(name); err == nil && len(fis) != 0 {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrExist}
+	}
+
+	if fs.onRemove != nil {
+		fs.onRemove(name)
+	}
+
+	return fs.Filesystem.Remove(name)
+}
+
+func (fs *restrictedFS) Chmod(name string, mode os.FileMode) error {
+	fs.modes[name] = mode
+	return nil
+}
+
+func (fs *restrictedFS) Lchown(name string, uid, gid int) error { return nil }
+
+func (fs *restrictedFS) Chown(name string, uid, gid int) error { return nil }
+
+func (fs *restrictedFS) Chtimes(name string, atime, mtime time.Time) error { return nil }
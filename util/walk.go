@@ -0,0 +1,254 @@
+package util
+
+import (
+	"errors"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/internal/linkutil"
+)
+
+// SkipAll is returned by the functions called by Walk and WalkDir to skip
+// all the remaining files and directories, ending the walk without error.
+// Since Go 1.20, filepath.SkipAll is accepted too.
+var SkipAll = errors.New("skip everything and stop the walk")
+
+// WalkOptions holds the configuration of a walk.
+type WalkOptions struct {
+	// FollowSymlinks walks the directories pointed by symlinks as if they
+	// were in place of the symlinks, which are reported with the info of
+	// their targets. The symlinks to a directory being walked, that would
+	// walk it forever, are reported but not followed.
+	FollowSymlinks bool
+}
+
+// Walk walks the file tree rooted at root of fs, calling fn for each file or
+// directory in the tree, including root, as filepath.Walk does. The files
+// are walked in lexical order, the directories before their content, and the
+// symlinks aren't followed.
+func Walk(fs billy.Filesystem, root string, fn filepath.WalkFunc) error {
+	return WalkWithOptions(fs, root, fn, nil)
+}
+
+// WalkWithOptions is like Walk, configured by the given options, which may be
+// nil, using the defaults.
+func WalkWithOptions(fs billy.Filesystem, root string, fn filepath.WalkFunc, opts *WalkOptions) error {
+	return newWalker(fs, opts, fn).walkRoot(root)
+}
+
+// WalkDir walks the file tree rooted at root of fs, calling fn for each file
+// or directory in the tree, including root, as filepath.WalkDir does. The
+// files are walked in lexical order, the directories before their content,
+// and the symlinks aren't followed.
+func WalkDir(fs billy.Filesystem, root string, fn iofs.WalkDirFunc) error {
+	return WalkDirWithOptions(fs, root, fn, nil)
+}
+
+// WalkDirWithOptions is like WalkDir, configured by the given options, which
+// may be nil, using the defaults.
+func WalkDirWithOptions(fs billy.Filesystem, root string, fn iofs.WalkDirFunc, opts *WalkOptions) error {
+	return newWalker(fs, opts, func(p string, fi os.FileInfo, err error) error {
+		var d iofs.DirEntry
+		if fi != nil {
+			d = dirEntry{fi}
+		}
+
+		return fn(p, d, err)
+	}).walkRoot(root)
+}
+
+// dirEntry is the fs.DirEntry of a file given its info, as the ones returned
+// by fs.FileInfoToDirEntry since Go 1.17.
+type dirEntry struct {
+	fi os.FileInfo
+}
+
+func (d dirEntry) Name() string {
+	return d.fi.Name()
+}
+
+func (d dirEntry) IsDir() bool {
+	return d.fi.IsDir()
+}
+
+func (d dirEntry) Type() iofs.FileMode {
+	return d.fi.Mode().Type()
+}
+
+func (d dirEntry) Info() (iofs.FileInfo, error) {
+	return d.fi, nil
+}
+
+type walker struct {
+	fs     billy.Filesystem
+	follow bool
+	fn     filepath.WalkFunc
+	// stack are the resolved paths of the directories being walked, to
+	// detect the loops of symlinks.
+	stack []string
+}
+
+func newWalker(fs billy.Filesystem, opts *WalkOptions, fn filepath.WalkFunc) *walker {
+	if opts == nil {
+		opts = &WalkOptions{}
+	}
+
+	return &walker{fs: fs, follow: opts.FollowSymlinks, fn: fn}
+}
+
+func (w *walker) walkRoot(root string) error {
+	fi, err := w.fs.Lstat(root)
+	if err == nil && w.follow && fi.Mode()&os.ModeSymlink != 0 {
+		fi, err = w.fs.Stat(root)
+	}
+
+	if err != nil {
+		err = w.fn(root, nil, err)
+	} else {
+		err = w.walk(root, fi)
+	}
+
+	if err == filepath.SkipDir || isSkipAll(err) {
+		return nil
+	}
+
+	return err
+}
+
+// walk walks the given file, whose info is given.
+func (w *walker) walk(p string, fi os.FileInfo) error {
+	if !fi.IsDir() {
+		return w.fn(p, fi, nil)
+	}
+
+	resolved := p
+	if w.follow {
+		var err error
+		if resolved, err = resolve(w.fs, p); err != nil {
+			return w.fn(p, fi, err)
+		}
+	}
+
+	// the error reading the directory is reported along with it, and ends
+	// its walk; the resolved path is read since not every filesystem follows
+	// the symlinks of the parents of a path
+	infos, err := w.fs.ReadDir(resolved)
+	if err := w.fn(p, fi, err); err != nil {
+		return err
+	}
+
+	if err != nil {
+		return nil
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+
+	w.stack = append(w.stack, resolved)
+	defer func() { w.stack = w.stack[:len(w.stack)-1] }()
+
+	for _, fi := range infos {
+		child := filepath.Join(p, fi.Name())
+		if w.follow && fi.Mode()&os.ModeSymlink != 0 {
+			fi, err = w.target(filepath.Join(resolved, fi.Name()), fi)
+			if err != nil {
+				if err := w.fn(child, fi, err); err != nil && err != filepath.SkipDir {
+					return err
+				}
+
+				continue
+			}
+		}
+
+		if err := w.walk(child, fi); err != nil {
+			if err == filepath.SkipDir && !fi.IsDir() {
+				// skipping from a file skips the rest of its directory
+				return nil
+			}
+
+			if err != filepath.SkipDir {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// target returns the info of the target of the given symlink, whose parent is
+// resolved, or the one of the symlink if it would walk a directory being
+// walked.
+func (w *walker) target(link string, fi os.FileInfo) (os.FileInfo, error) {
+	target, err := w.fs.Stat(link)
+	if err != nil {
+		return fi, err
+	}
+
+	if !target.IsDir() {
+		return target, nil
+	}
+
+	resolved, err := resolve(w.fs, link)
+	if err != nil {
+		return fi, err
+	}
+
+	for _, dir := range w.stack {
+		if dir == resolved {
+			return fi, nil
+		}
+	}
+
+	return target, nil
+}
+
+// resolve returns the given path with all the symlinks of its elements
+// resolved.
+func resolve(fs billy.Filesystem, p string) (string, error) {
+	const separator = string(filepath.Separator)
+
+	rest := strings.Split(filepath.Join(separator, p), separator)
+	resolved := separator
+	links := 0
+	for len(rest) != 0 {
+		name := rest[0]
+		rest = rest[1:]
+		if name == "" {
+			continue
+		}
+
+		next := filepath.Join(resolved, name)
+		fi, err := fs.Lstat(next)
+		if err != nil {
+			return "", err
+		}
+
+		if fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		if links++; links > linkutil.MaxLinks {
+			return "", &os.PathError{Op: "walk", Path: p, Err: linkutil.ErrTooManyLinks}
+		}
+
+		target, err := fs.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+
+		if !filepath.IsAbs(target) && !strings.HasPrefix(target, separator) {
+			target = filepath.Join(resolved, target)
+		}
+
+		rest = append(strings.Split(filepath.Join(separator, target), separator), rest...)
+		resolved = separator
+	}
+
+	return resolved, nil
+}
@@ -0,0 +1,150 @@
+package util_test
+
+import (
+	"errors"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+func newWalkFS(c *C) billy.Filesystem {
+	fs := memfs.New()
+	for _, name := range []string{"b/c", "b/a", "a", "c/d/e"} {
+		c.Assert(util.WriteFile(fs, name, nil, 0644), IsNil)
+	}
+
+	return fs
+}
+
+func walkPaths(c *C, fs billy.Filesystem, root string, opts *util.WalkOptions, skip string) []string {
+	var paths []string
+	err := util.WalkWithOptions(fs, root, func(p string, fi os.FileInfo, err error) error {
+		c.Assert(err, IsNil)
+		paths = append(paths, filepath.ToSlash(p))
+		if p == skip {
+			return filepath.SkipDir
+		}
+
+		return nil
+	}, opts)
+
+	c.Assert(err, IsNil)
+	return paths
+}
+
+func (s *UtilSuite) TestWalk(c *C) {
+	fs := newWalkFS(c)
+	c.Assert(walkPaths(c, fs, "/", nil, ""), DeepEquals, []string{
+		"/", "/a", "/b", "/b/a", "/b/c", "/c", "/c/d", "/c/d/e",
+	})
+
+	c.Assert(walkPaths(c, fs, "c", nil, ""), DeepEquals, []string{
+		"c", "c/d", "c/d/e",
+	})
+}
+
+func (s *UtilSuite) TestWalkSkipDir(c *C) {
+	fs := newWalkFS(c)
+	c.Assert(walkPaths(c, fs, "/", nil, "/b"), DeepEquals, []string{
+		"/", "/a", "/b", "/c", "/c/d", "/c/d/e",
+	})
+
+	// skipping from a file skips the rest of its directory
+	c.Assert(walkPaths(c, fs, "/", nil, "/b/a"), DeepEquals, []string{
+		"/", "/a", "/b", "/b/a", "/c", "/c/d", "/c/d/e",
+	})
+}
+
+func (s *UtilSuite) TestWalkSkipAll(c *C) {
+	fs := newWalkFS(c)
+
+	var paths []string
+	err := util.Walk(fs, "/", func(p string, fi os.FileInfo, err error) error {
+		paths = append(paths, filepath.ToSlash(p))
+		if p == "/b/a" {
+			return util.SkipAll
+		}
+
+		return nil
+	})
+
+	c.Assert(err, IsNil)
+	c.Assert(paths, DeepEquals, []string{"/", "/a", "/b", "/b/a"})
+}
+
+func (s *UtilSuite) TestWalkErrors(c *C) {
+	fs := newWalkFS(c)
+
+	var paths []string
+	err := util.Walk(fs, "/missing", func(p string, fi os.FileInfo, err error) error {
+		paths = append(paths, p)
+		c.Assert(os.IsNotExist(err), Equals, true)
+		c.Assert(fi, IsNil)
+		return err
+	})
+
+	c.Assert(os.IsNotExist(err), Equals, true)
+	c.Assert(paths, DeepEquals, []string{"/missing"})
+
+	stop := errors.New("stop")
+	err = util.Walk(fs, "/", func(p string, fi os.FileInfo, err error) error {
+		if p == "/b" {
+			return stop
+		}
+
+		return nil
+	})
+
+	c.Assert(err, Equals, stop)
+}
+
+func (s *UtilSuite) TestWalkSymlinks(c *C) {
+	fs := newWalkFS(c)
+	c.Assert(fs.Symlink("c", "link"), IsNil)
+	c.Assert(fs.Symlink("/c", "c/d/loop"), IsNil)
+
+	c.Assert(walkPaths(c, fs, "/", nil, ""), DeepEquals, []string{
+		"/", "/a", "/b", "/b/a", "/b/c", "/c", "/c/d", "/c/d/e", "/c/d/loop", "/link",
+	})
+
+	follow := &util.WalkOptions{FollowSymlinks: true}
+	c.Assert(walkPaths(c, fs, "/", follow, ""), DeepEquals, []string{
+		"/", "/a", "/b", "/b/a", "/b/c", "/c", "/c/d", "/c/d/e", "/c/d/loop",
+		"/link", "/link/d", "/link/d/e", "/link/d/loop",
+	})
+
+	var mode os.FileMode
+	err := util.WalkWithOptions(fs, "/link", func(p string, fi os.FileInfo, err error) error {
+		if p == "/link" {
+			mode = fi.Mode()
+		}
+
+		return err
+	}, follow)
+
+	c.Assert(err, IsNil)
+	c.Assert(mode.IsDir(), Equals, true)
+}
+
+func (s *UtilSuite) TestWalkDir(c *C) {
+	fs := newWalkFS(c)
+
+	var paths []string
+	err := util.WalkDir(fs, "/", func(p string, d iofs.DirEntry, err error) error {
+		c.Assert(err, IsNil)
+		if d.IsDir() && d.Name() == "c" {
+			return filepath.SkipDir
+		}
+
+		paths = append(paths, filepath.ToSlash(p))
+		return nil
+	})
+
+	c.Assert(err, IsNil)
+	c.Assert(paths, DeepEquals, []string{"/", "/a", "/b", "/b/a", "/b/c"})
+}
@@ -0,0 +1,290 @@
+package vaultfs
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	magic         = "BILLYVLT"
+	formatVersion = 1
+	headerSize    = 32
+	tagSize       = 16
+
+	// superblocks is the number of slots holding the superblocks, written
+	// alternately, so one is always complete.
+	superblocks    = 2
+	superblockSize = 24
+
+	// hole is the slot of the chunks never written, read as zeros. It's a
+	// superblock, so it's never the slot of a chunk.
+	hole = 0
+)
+
+var (
+	// ErrInvalidKey is returned when the superblocks of a vault can't be
+	// decrypted, either because the key is wrong, or they are corrupted.
+	ErrInvalidKey = errors.New("invalid key or corrupted vault")
+	// ErrCorrupted is returned when a chunk fails the authentication, or
+	// the container is malformed.
+	ErrCorrupted = errors.New("corrupted vault")
+)
+
+// header is the plaintext header of the container, authenticated as
+// additional data of every slot, so the chunks can't be moved between
+// vaults.
+type header [headerSize]byte
+
+func newHeader(chunkSize int) (header, error) {
+	var h header
+	copy(h[:], magic)
+	h[8] = formatVersion
+	binary.BigEndian.PutUint32(h[12:], uint32(chunkSize))
+
+	// the id of the vault
+	_, err := io.ReadFull(rand.Reader, h[16:])
+	return h, err
+}
+
+func (h header) chunkSize() int {
+	return int(binary.BigEndian.Uint32(h[12:]))
+}
+
+func (h header) validate() error {
+	if string(h[:8]) != magic {
+		return fmt.Errorf("%s: not a vault", ErrCorrupted)
+	}
+
+	if h[8] != formatVersion {
+		return fmt.Errorf("unsupported vault version: %d", h[8])
+	}
+
+	if cs := h.chunkSize(); cs < minChunkSize || cs > maxChunkSize {
+		return fmt.Errorf("%s: invalid chunk size %d", ErrCorrupted, cs)
+	}
+
+	return nil
+}
+
+// container is the encrypted storage of the vault, split in slots of the
+// same size, each one holding a chunk encrypted with its own random nonce,
+// and authenticated with the header and the number of the slot.
+type container struct {
+	f      fileReadWriterAt
+	aead   cipher.AEAD
+	header header
+	slots  uint32
+}
+
+// fileReadWriterAt is the subset of billy.File used by the container.
+type fileReadWriterAt interface {
+	io.ReaderAt
+	io.WriteSeeker
+}
+
+func (c *container) chunkSize() int {
+	return c.header.chunkSize()
+}
+
+func (c *container) slotSize() int64 {
+	return int64(c.aead.NonceSize() + c.chunkSize() + tagSize)
+}
+
+func (c *container) offset(slot uint32) int64 {
+	return headerSize + int64(slot)*c.slotSize()
+}
+
+func (c *container) additionalData(slot uint32) []byte {
+	ad := make([]byte, headerSize+4)
+	copy(ad, c.header[:])
+	binary.BigEndian.PutUint32(ad[headerSize:], slot)
+	return ad
+}
+
+// read returns the chunk of the given slot.
+func (c *container) read(slot uint32) ([]byte, error) {
+	buf := make([]byte, c.slotSize())
+	if _, err := c.f.ReadAt(buf, c.offset(slot)); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrCorrupted
+		}
+
+		return nil, err
+	}
+
+	ns := c.aead.NonceSize()
+	chunk, err := c.aead.Open(buf[ns:ns], buf[:ns], buf[ns:], c.additionalData(slot))
+	if err != nil {
+		return nil, ErrCorrupted
+	}
+
+	return chunk, nil
+}
+
+// write encrypts the given chunk, padded with zeros, into the given slot.
+func (c *container) write(slot uint32, chunk []byte) error {
+	ns := c.aead.NonceSize()
+	buf := make([]byte, ns, c.slotSize())
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		return err
+	}
+
+	plain := make([]byte, c.chunkSize())
+	copy(plain, chunk)
+	buf = c.aead.Seal(buf, buf[:ns], plain, c.additionalData(slot))
+
+	if _, err := c.f.Seek(c.offset(slot), io.SeekStart); err != nil {
+		return err
+	}
+
+	if _, err := c.f.Write(buf); err != nil {
+		return err
+	}
+
+	if slot >= c.slots {
+		c.slots = slot + 1
+	}
+
+	return nil
+}
+
+// superblock is the root of the vault, pointing to the slots of the index.
+type superblock struct {
+	generation uint64
+	size       uint64
+	index      []uint32
+}
+
+func (s *superblock) encode() []byte {
+	b := make([]byte, superblockSize+4*len(s.index))
+	binary.BigEndian.PutUint64(b, s.generation)
+	binary.BigEndian.PutUint64(b[8:], s.size)
+	binary.BigEndian.PutUint32(b[16:], uint32(len(s.index)))
+	for i, slot := range s.index {
+		binary.BigEndian.PutUint32(b[superblockSize+4*i:], slot)
+	}
+
+	return b
+}
+
+func decodeSuperblock(b []byte) (*superblock, error) {
+	s := &superblock{
+		generation: binary.BigEndian.Uint64(b),
+		size:       binary.BigEndian.Uint64(b[8:]),
+	}
+
+	count := int(binary.BigEndian.Uint32(b[16:]))
+	if count > (len(b)-superblockSize)/4 {
+		return nil, ErrCorrupted
+	}
+
+	for i := 0; i < count; i++ {
+		s.index = append(s.index, binary.BigEndian.Uint32(b[superblockSize+4*i:]))
+	}
+
+	return s, nil
+}
+
+// encodeIndex encodes the nodes, sorted by path, but the root.
+func encodeIndex(nodes map[string]*node) []byte {
+	paths := make([]string, 0, len(nodes))
+	for p := range nodes {
+		if p != "/" {
+			paths = append(paths, p)
+		}
+	}
+
+	sort.Strings(paths)
+
+	var buf bytes.Buffer
+	b := make([]byte, binary.MaxVarintLen64)
+	putUvarint := func(v uint64) {
+		buf.Write(b[:binary.PutUvarint(b, v)])
+	}
+
+	putString := func(s string) {
+		putUvarint(uint64(len(s)))
+		buf.WriteString(s)
+	}
+
+	putUvarint(uint64(len(paths)))
+	for _, p := range paths {
+		n := nodes[p]
+		putString(p)
+		putUvarint(uint64(n.mode))
+		buf.Write(b[:binary.PutVarint(b, n.modTime.UnixNano())])
+		putUvarint(uint64(n.size))
+		putString(n.target)
+		putUvarint(uint64(len(n.chunks)))
+		for _, slot := range n.chunks {
+			putUvarint(uint64(slot))
+		}
+	}
+
+	return buf.Bytes()
+}
+
+func decodeIndex(b []byte) (map[string]*node, error) {
+	r := bytes.NewReader(b)
+	getString := func() (string, error) {
+		l, err := binary.ReadUvarint(r)
+		if err != nil || l > uint64(r.Len()) {
+			return "", ErrCorrupted
+		}
+
+		s := make([]byte, l)
+		_, err = io.ReadFull(r, s)
+		return string(s), err
+	}
+
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, ErrCorrupted
+	}
+
+	nodes := make(map[string]*node)
+	for i := uint64(0); i < count; i++ {
+		p, err := getString()
+		if err != nil {
+			return nil, ErrCorrupted
+		}
+
+		n := &node{}
+		mode, err1 := binary.ReadUvarint(r)
+		modTime, err2 := binary.ReadVarint(r)
+		size, err3 := binary.ReadUvarint(r)
+		target, err4 := getString()
+		chunks, err5 := binary.ReadUvarint(r)
+		for _, err := range []error{err1, err2, err3, err4, err5} {
+			if err != nil {
+				return nil, ErrCorrupted
+			}
+		}
+
+		if chunks > uint64(r.Len()) {
+			return nil, ErrCorrupted
+		}
+
+		n.mode, n.modTime, n.size, n.target = os.FileMode(mode), time.Unix(0, modTime), int64(size), target
+		for j := uint64(0); j < chunks; j++ {
+			slot, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, ErrCorrupted
+			}
+
+			n.chunks = append(n.chunks, uint32(slot))
+		}
+
+		nodes[p] = n
+	}
+
+	return nodes, nil
+}
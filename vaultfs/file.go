@@ -0,0 +1,304 @@
+package vaultfs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// file is a file of a vault. The chunks are decrypted as read, keeping the
+// last one, and the chunks written are kept in memory until the file is
+// closed, when they are written to free slots if modified.
+type file struct {
+	fs   *Vault
+	name string
+	path string
+	flag int
+	mode os.FileMode
+
+	size int64
+	// chunks are the slots of the content when opened, shortened when
+	// truncated, and pinned the ones pinned while the file is open.
+	chunks []uint32
+	pinned []uint32
+	// dirty are the chunks written, by index.
+	dirty    map[int64][]byte
+	modified bool
+
+	cached int64
+	cache  []byte
+
+	position int64
+	isClosed bool
+}
+
+func (f *file) Name() string {
+	return f.name
+}
+
+// chunk returns the chunk with the given index, that must not be modified.
+func (f *file) chunk(j int64) ([]byte, error) {
+	if chunk, ok := f.dirty[j]; ok {
+		return chunk, nil
+	}
+
+	if j == f.cached {
+		return f.cache, nil
+	}
+
+	slot := uint32(hole)
+	if j < int64(len(f.chunks)) {
+		slot = f.chunks[j]
+	}
+
+	chunk, err := f.fs.readChunk(slot)
+	if err != nil {
+		return nil, &os.PathError{Op: "read", Path: f.name, Err: err}
+	}
+
+	f.cached, f.cache = j, chunk
+	return chunk, nil
+}
+
+// dirtyChunk returns the chunk with the given index to be modified.
+func (f *file) dirtyChunk(j int64) ([]byte, error) {
+	if chunk, ok := f.dirty[j]; ok {
+		return chunk, nil
+	}
+
+	chunk, err := f.chunk(j)
+	if err != nil {
+		return nil, err
+	}
+
+	chunk = append([]byte(nil), chunk...)
+	f.dirty[j] = chunk
+	return chunk, nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.position)
+	f.position += int64(n)
+
+	if err == io.EOF && n != 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	if f.flag&os.O_WRONLY != 0 {
+		return 0, errors.New("read not supported")
+	}
+
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: f.name, Err: errors.New("negative offset")}
+	}
+
+	cs := int64(f.fs.c.chunkSize())
+	var n int
+	for n < len(p) && off < f.size {
+		chunk, err := f.chunk(off / cs)
+		if err != nil {
+			return n, err
+		}
+
+		end := cs
+		if rest := f.size - off/cs*cs; rest < end {
+			end = rest
+		}
+
+		c := copy(p[n:], chunk[off%cs:end])
+		n += c
+		off += int64(c)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.position
+	case io.SeekEnd:
+		offset += f.size
+	}
+
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: errors.New("negative offset")}
+	}
+
+	f.position = offset
+	return f.position, nil
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	if !isWrite(f.flag) {
+		return 0, errors.New("write not supported")
+	}
+
+	if f.flag&os.O_APPEND != 0 {
+		f.position = f.size
+	}
+
+	cs := int64(f.fs.c.chunkSize())
+	var n int
+	for n < len(p) {
+		chunk, err := f.dirtyChunk(f.position / cs)
+		if err != nil {
+			return n, err
+		}
+
+		c := copy(chunk[f.position%cs:], p[n:])
+		n += c
+		f.position += int64(c)
+	}
+
+	if f.position > f.size {
+		f.size = f.position
+	}
+
+	f.modified = true
+	return n, nil
+}
+
+// Truncate changes the size of the file. The chunks past the size are
+// discarded, and the end of the last one is zeroed, so the chunks are
+// always zeroed past the size.
+func (f *file) Truncate(size int64) error {
+	if f.isClosed {
+		return os.ErrClosed
+	}
+
+	if !isWrite(f.flag) {
+		return errors.New("truncate not supported")
+	}
+
+	if size < 0 {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: errors.New("negative size")}
+	}
+
+	cs := int64(f.fs.c.chunkSize())
+	if size < f.size {
+		count := (size + cs - 1) / cs
+		if count < int64(len(f.chunks)) {
+			f.chunks = f.chunks[:count]
+		}
+
+		for j := range f.dirty {
+			if j >= count {
+				delete(f.dirty, j)
+			}
+		}
+
+		if size%cs != 0 {
+			chunk, err := f.dirtyChunk(size / cs)
+			if err != nil {
+				return err
+			}
+
+			for i := size % cs; i < cs; i++ {
+				chunk[i] = 0
+			}
+		}
+
+		f.cached, f.cache = -1, nil
+	}
+
+	f.size, f.modified = size, true
+	return nil
+}
+
+// Close writes the chunks modified, replacing the content of the file.
+func (f *file) Close() error {
+	if f.isClosed {
+		return os.ErrClosed
+	}
+
+	f.isClosed = true
+	defer func() { f.dirty, f.cache = nil, nil }()
+	if !f.modified {
+		f.fs.m.Lock()
+		f.fs.unpin(f.pinned)
+		f.fs.m.Unlock()
+		return nil
+	}
+
+	if err := f.fs.store(f.path, f); err != nil {
+		return &os.PathError{Op: "close", Path: f.name, Err: err}
+	}
+
+	return nil
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	return &fileInfo{
+		name: filepath.Base(f.name),
+		size: f.size,
+		mode: f.mode,
+	}, nil
+}
+
+// Lock is a no-op in vaultfs.
+func (f *file) Lock() error {
+	return nil
+}
+
+// Unlock is a no-op in vaultfs.
+func (f *file) Unlock() error {
+	return nil
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func newFileInfo(name string, n *node) *fileInfo {
+	return &fileInfo{name: name, size: n.size, mode: n.mode, modTime: n.modTime}
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.size
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	return fi.mode
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.mode.IsDir()
+}
+
+func (*fileInfo) Sys() interface{} {
+	return nil
+}
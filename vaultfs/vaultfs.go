@@ -0,0 +1,819 @@
+// Package vaultfs provides a billy filesystem stored in a single encrypted
+// file, to ship sensitive fixtures or credentials through the code using
+// billy, without leaving them in plaintext on the disk.
+//
+// The container is split in chunks of the same size, encrypted with
+// AES-256-GCM, each one with its own random nonce, and authenticated with
+// its position and the id of the vault, so the chunks can't be altered,
+// moved or copied between vaults. The content of the files is read and
+// written by chunks, so the files are accessed randomly without decrypting
+// them entirely.
+//
+// The index of the files is stored in chunks too, pointed by one of two
+// superblocks, written alternately. The chunks modified are written to free
+// slots, and the superblock is written last, so an interrupted write never
+// corrupts the vault. The files are written back when closed, and every
+// change rewrites the index, so vaults are meant for small trees.
+package vaultfs // import "gopkg.in/src-d/go-billy.v4/vaultfs"
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/internal/linkutil"
+	"gopkg.in/src-d/go-billy.v4/internal/pathutil"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const (
+	// KeySize is the size of the keys of the vaults, for AES-256.
+	KeySize = 32
+	// DefaultChunkSize is the default size of the chunks of a vault.
+	DefaultChunkSize = 64 * 1024
+
+	minChunkSize = 4 * 1024
+	maxChunkSize = 16 * 1024 * 1024
+)
+
+var (
+	// ErrNotEmpty is returned when removing a directory with files.
+	ErrNotEmpty = billy.ErrNotEmpty
+
+	errNotDir = errors.New("not a directory")
+)
+
+// Options holds the configuration of a vault.
+type Options struct {
+	// ChunkSize is the size of the chunks of a new vault, from 4KiB to
+	// 16MiB, DefaultChunkSize if zero. The existing vaults keep the chunk
+	// size they were created with.
+	ChunkSize int
+}
+
+// Vault is a filesystem stored in a single encrypted file.
+type Vault struct {
+	c    *container
+	file billy.File
+
+	m          sync.Mutex
+	nodes      map[string]*node
+	children   map[string]map[string]bool
+	superblock *superblock
+	// free are the slots not referenced by the index nor the open files,
+	// sorted, and pins the number of open files referencing every slot.
+	free []uint32
+	pins map[uint32]int
+	// err is the error of a failed write to the container, returned by any
+	// later write, since the vault must be opened again.
+	err error
+}
+
+// node is a file, directory or symlink of the vault.
+type node struct {
+	mode    os.FileMode
+	modTime time.Time
+	size    int64
+	target  string
+	// chunks are the slots of the chunks of the content.
+	chunks []uint32
+}
+
+// New opens the vault stored at the given file with the given key, of
+// KeySize bytes, creating it if it doesn't exist or is empty. The vault
+// must be closed after closing every file.
+func New(storage billy.Basic, filename string, key []byte, opts Options) (*Vault, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes", KeySize)
+	}
+
+	if opts.ChunkSize == 0 {
+		opts.ChunkSize = DefaultChunkSize
+	}
+
+	if opts.ChunkSize < minChunkSize || opts.ChunkSize > maxChunkSize {
+		return nil, fmt.Errorf("chunk size must be between %d and %d", minChunkSize, maxChunkSize)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := storage.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := &Vault{
+		c:    &container{f: f, aead: aead},
+		file: f,
+		pins: make(map[uint32]int),
+	}
+
+	fs.reset()
+	if err := fs.load(opts); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return fs, nil
+}
+
+func (fs *Vault) reset() {
+	fs.nodes = map[string]*node{"/": {mode: os.ModeDir | 0755}}
+	fs.children = make(map[string]map[string]bool)
+}
+
+// load reads the index of the vault, or initializes it if it's empty.
+func (fs *Vault) load(opts Options) error {
+	size, err := fs.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	if size == 0 {
+		return fs.init(opts.ChunkSize)
+	}
+
+	if _, err := fs.file.ReadAt(fs.c.header[:], 0); err != nil {
+		return ErrCorrupted
+	}
+
+	if err := fs.c.header.validate(); err != nil {
+		return err
+	}
+
+	fs.c.slots = uint32((size - headerSize) / fs.c.slotSize())
+	for slot := uint32(0); slot < superblocks; slot++ {
+		b, err := fs.c.read(slot)
+		if err != nil {
+			continue
+		}
+
+		s, err := decodeSuperblock(b)
+		if err != nil {
+			continue
+		}
+
+		if fs.superblock == nil || s.generation > fs.superblock.generation {
+			fs.superblock = s
+		}
+	}
+
+	if fs.superblock == nil {
+		return ErrInvalidKey
+	}
+
+	var index []byte
+	for _, slot := range fs.superblock.index {
+		chunk, err := fs.c.read(slot)
+		if err != nil {
+			return err
+		}
+
+		index = append(index, chunk...)
+	}
+
+	if fs.superblock.size > uint64(len(index)) {
+		return ErrCorrupted
+	}
+
+	nodes, err := decodeIndex(index[:fs.superblock.size])
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, 0, len(nodes))
+	for p := range nodes {
+		paths = append(paths, p)
+	}
+
+	// parents first, so their modes are kept.
+	sort.Strings(paths)
+	for _, p := range paths {
+		fs.set(p, nodes[p])
+	}
+
+	fs.collect()
+	return nil
+}
+
+func (fs *Vault) init(chunkSize int) error {
+	h, err := newHeader(chunkSize)
+	if err != nil {
+		return err
+	}
+
+	fs.c.header = h
+	if _, err := fs.file.Write(h[:]); err != nil {
+		return err
+	}
+
+	fs.c.slots = superblocks
+	fs.superblock = &superblock{}
+	return fs.commit()
+}
+
+// commit writes the index to free slots, and the superblock of the next
+// generation pointing to them. Must be called with the lock held.
+func (fs *Vault) commit() error {
+	if fs.err != nil {
+		return fs.err
+	}
+
+	if err := fs.writeIndex(); err != nil {
+		fs.err = err
+		return err
+	}
+
+	fs.collect()
+	return nil
+}
+
+func (fs *Vault) writeIndex() error {
+	index := encodeIndex(fs.nodes)
+	cs := fs.c.chunkSize()
+
+	s := &superblock{generation: fs.superblock.generation + 1, size: uint64(len(index))}
+	if superblockSize+4*((len(index)+cs-1)/cs) > cs {
+		return errors.New("index too big for the chunk size")
+	}
+
+	for len(index) != 0 {
+		chunk := index
+		if len(chunk) > cs {
+			chunk = chunk[:cs]
+		}
+
+		slot := fs.alloc()
+		if err := fs.c.write(slot, chunk); err != nil {
+			return err
+		}
+
+		s.index = append(s.index, slot)
+		index = index[len(chunk):]
+	}
+
+	if err := fs.c.write(uint32(s.generation%superblocks), s.encode()); err != nil {
+		return err
+	}
+
+	fs.superblock = s
+	return nil
+}
+
+// alloc returns a free slot, or a new one at the end of the container.
+func (fs *Vault) alloc() uint32 {
+	if len(fs.free) != 0 {
+		slot := fs.free[0]
+		fs.free = fs.free[1:]
+		return slot
+	}
+
+	slot := fs.c.slots
+	fs.c.slots++
+	return slot
+}
+
+// collect finds the free slots, not referenced by the index nor the open
+// files.
+func (fs *Vault) collect() {
+	used := make([]bool, fs.c.slots)
+	mark := func(slot uint32) {
+		if slot < uint32(len(used)) {
+			used[slot] = true
+		}
+	}
+
+	for _, slot := range fs.superblock.index {
+		mark(slot)
+	}
+
+	for _, n := range fs.nodes {
+		for _, slot := range n.chunks {
+			mark(slot)
+		}
+	}
+
+	for slot := range fs.pins {
+		mark(slot)
+	}
+
+	fs.free = fs.free[:0]
+	for slot := uint32(superblocks); slot < fs.c.slots; slot++ {
+		if !used[slot] {
+			fs.free = append(fs.free, slot)
+		}
+	}
+}
+
+// pin keeps the given slots while a file reading them is open.
+func (fs *Vault) pin(slots []uint32) {
+	for _, slot := range slots {
+		if slot != hole {
+			fs.pins[slot]++
+		}
+	}
+}
+
+func (fs *Vault) unpin(slots []uint32) {
+	for _, slot := range slots {
+		if slot == hole {
+			continue
+		}
+
+		if fs.pins[slot]--; fs.pins[slot] <= 0 {
+			delete(fs.pins, slot)
+		}
+	}
+}
+
+// readChunk returns the chunk of the given slot, zeros if it's a hole.
+func (fs *Vault) readChunk(slot uint32) ([]byte, error) {
+	if slot == hole {
+		return make([]byte, fs.c.chunkSize()), nil
+	}
+
+	fs.m.Lock()
+	defer fs.m.Unlock()
+	return fs.c.read(slot)
+}
+
+// store writes the chunks modified of a file closed, and its new size. If
+// the file was removed or replaced while open, the content is discarded.
+func (fs *Vault) store(p string, f *file) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+	defer fs.unpin(f.pinned)
+
+	if fs.err != nil {
+		return fs.err
+	}
+
+	n, ok := fs.nodes[p]
+	if !ok || !n.mode.IsRegular() {
+		return nil
+	}
+
+	cs := int64(fs.c.chunkSize())
+	chunks := make([]uint32, (f.size+cs-1)/cs)
+	copy(chunks, f.chunks)
+	for j, chunk := range f.dirty {
+		if j >= int64(len(chunks)) {
+			continue
+		}
+
+		slot := fs.alloc()
+		if err := fs.c.write(slot, chunk); err != nil {
+			fs.err = err
+			return err
+		}
+
+		chunks[j] = slot
+	}
+
+	fs.nodes[p] = &node{mode: n.mode, modTime: time.Now(), size: f.size, chunks: chunks}
+	return fs.commit()
+}
+
+// Close closes the container. The vault can't be used afterwards.
+func (fs *Vault) Close() error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	if fs.err == nil {
+		fs.err = os.ErrClosed
+	}
+
+	return fs.file.Close()
+}
+
+func (fs *Vault) set(p string, n *node) {
+	fs.nodes[p] = n
+	for p != "/" {
+		dir, name := path.Split(p)
+		dir = clean(dir)
+
+		if fs.children[dir] == nil {
+			fs.children[dir] = make(map[string]bool)
+		}
+
+		fs.children[dir][name] = true
+		if _, ok := fs.nodes[dir]; ok {
+			return
+		}
+
+		fs.nodes[dir] = &node{mode: os.ModeDir | 0755, modTime: n.modTime}
+		p = dir
+	}
+}
+
+func (fs *Vault) delete(p string) {
+	delete(fs.nodes, p)
+	delete(fs.children, p)
+
+	dir, name := path.Split(p)
+	delete(fs.children[clean(dir)], name)
+}
+
+func (fs *Vault) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Vault) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the given file. The chunks are decrypted as read, and the
+// chunks written are kept in memory until the file is closed.
+func (fs *Vault) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	f, err := fs.openFile(filename, flag, perm)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+	}
+
+	return f, nil
+}
+
+func (fs *Vault) openFile(filename string, flag int, perm os.FileMode) (*file, error) {
+	p, n, err := fs.follow(clean(filename))
+	switch {
+	case os.IsNotExist(err) && flag&os.O_CREATE != 0:
+		// p is the target of the last link, even when it's dangling, so
+		// the target is created.
+		if _, err := fs.mkdirAll(path.Dir(p), 0755); err != nil {
+			return nil, err
+		}
+
+		n = &node{mode: perm.Perm(), modTime: time.Now()}
+		fs.set(p, n)
+		if err := fs.commit(); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	case flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, os.ErrExist
+	case n.mode.IsDir():
+		return nil, fmt.Errorf("cannot open directory: %s", filename)
+	case isWrite(flag) && flag&os.O_TRUNC != 0 && n.size != 0:
+		n = &node{mode: n.mode, modTime: time.Now()}
+		fs.nodes[p] = n
+		if err := fs.commit(); err != nil {
+			return nil, err
+		}
+	}
+
+	f := &file{
+		fs:     fs,
+		name:   pathutil.Relative(filename),
+		path:   p,
+		flag:   flag,
+		mode:   n.mode,
+		size:   n.size,
+		chunks: n.chunks,
+		pinned: n.chunks,
+		dirty:  make(map[int64][]byte),
+		cached: -1,
+	}
+
+	fs.pin(f.pinned)
+	if flag&os.O_APPEND != 0 {
+		f.position = f.size
+	}
+
+	return f, nil
+}
+
+// lookup returns the node at p, without following the links.
+func (fs *Vault) lookup(p string) (*node, error) {
+	n, ok := fs.nodes[p]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	return n, nil
+}
+
+// follow returns the node at p following the links, and its path. If the
+// node doesn't exist os.ErrNotExist is returned with the path of the target.
+func (fs *Vault) follow(p string) (string, *node, error) {
+	var n *node
+	isLink := func(p string) (link bool, err error) {
+		n, err = fs.lookup(p)
+		return err == nil && isSymlink(n.mode), err
+	}
+
+	p, err := linkutil.Follow(p, clean, isLink, func(string) (string, error) {
+		return n.target, nil
+	})
+	if err != nil {
+		return p, nil, err
+	}
+
+	return p, n, nil
+}
+
+func (fs *Vault) Stat(filename string) (os.FileInfo, error) {
+	return fs.stat("stat", filename, true)
+}
+
+func (fs *Vault) Lstat(filename string) (os.FileInfo, error) {
+	return fs.stat("lstat", filename, false)
+}
+
+func (fs *Vault) stat(op, filename string, follow bool) (os.FileInfo, error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	var n *node
+	var err error
+	if follow {
+		_, n, err = fs.follow(clean(filename))
+	} else {
+		n, err = fs.lookup(clean(filename))
+	}
+
+	if err != nil {
+		return nil, &os.PathError{Op: op, Path: filename, Err: err}
+	}
+
+	// the name is the one of the stated file, even if it's a link.
+	return newFileInfo(filepath.Base(filename), n), nil
+}
+
+func (fs *Vault) ReadDir(filename string) ([]os.FileInfo, error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	p, n, err := fs.follow(clean(filename))
+	if err == nil && !n.mode.IsDir() {
+		err = errNotDir
+	}
+
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: filename, Err: err}
+	}
+
+	names := make([]string, 0, len(fs.children[p]))
+	for name := range fs.children[p] {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	infos := make([]os.FileInfo, 0, len(names))
+	for _, name := range names {
+		infos = append(infos, newFileInfo(name, fs.nodes[path.Join(p, name)]))
+	}
+
+	return infos, nil
+}
+
+func (fs *Vault) MkdirAll(filename string, perm os.FileMode) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	created, err := fs.mkdirAll(clean(filename), perm)
+	if err == nil && created {
+		err = fs.commit()
+	}
+
+	if err != nil {
+		return &os.PathError{Op: "mkdir", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+// mkdirAll creates the directory and its parents, returning true if any was
+// created. The index isn't committed.
+func (fs *Vault) mkdirAll(p string, perm os.FileMode) (bool, error) {
+	var created bool
+	dir := "/"
+	for _, elem := range strings.Split(p, "/") {
+		if elem == "" {
+			continue
+		}
+
+		dir = path.Join(dir, elem)
+		_, n, err := fs.follow(dir)
+		if err == nil {
+			if !n.mode.IsDir() {
+				return created, errNotDir
+			}
+
+			continue
+		}
+
+		if !os.IsNotExist(err) {
+			return created, err
+		}
+
+		fs.set(dir, &node{mode: os.ModeDir | perm.Perm(), modTime: time.Now()})
+		created = true
+	}
+
+	return created, nil
+}
+
+// Rename moves the given file, or directory with all its content, in a
+// single commit.
+func (fs *Vault) Rename(from, to string) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	if err := fs.rename(clean(from), clean(to)); err != nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Vault) rename(from, to string) error {
+	if from == "/" || to == "/" {
+		return errors.New("cannot rename the root")
+	}
+
+	src, err := fs.lookup(from)
+	if err != nil {
+		return err
+	}
+
+	if from == to {
+		return nil
+	}
+
+	if strings.HasPrefix(to, from+"/") {
+		return errors.New("cannot move a directory into itself")
+	}
+
+	if dst, ok := fs.nodes[to]; ok {
+		if dst.mode.IsDir() != src.mode.IsDir() {
+			return os.ErrExist
+		}
+
+		if len(fs.children[to]) != 0 {
+			return ErrNotEmpty
+		}
+	}
+
+	if _, err := fs.mkdirAll(path.Dir(to), 0755); err != nil {
+		return err
+	}
+
+	moves := [][2]string{{from, to}}
+	for p := range fs.nodes {
+		if strings.HasPrefix(p, from+"/") {
+			moves = append(moves, [2]string{p, to + strings.TrimPrefix(p, from)})
+		}
+	}
+
+	// parents first, so the children are registered on the moved parents.
+	sort.Slice(moves, func(i, j int) bool { return moves[i][0] < moves[j][0] })
+
+	nodes := make([]*node, len(moves))
+	for i, m := range moves {
+		nodes[i] = fs.nodes[m[0]]
+	}
+
+	for i := len(moves) - 1; i >= 0; i-- {
+		fs.delete(moves[i][0])
+	}
+
+	for i, m := range moves {
+		fs.set(m[1], nodes[i])
+	}
+
+	return fs.commit()
+}
+
+func (fs *Vault) Remove(filename string) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	if err := fs.remove(clean(filename)); err != nil {
+		return &os.PathError{Op: "remove", Path: filename, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Vault) remove(p string) error {
+	if p == "/" {
+		return errors.New("cannot remove the root")
+	}
+
+	n, err := fs.lookup(p)
+	if err != nil {
+		return err
+	}
+
+	if n.mode.IsDir() && len(fs.children[p]) != 0 {
+		return ErrNotEmpty
+	}
+
+	fs.delete(p)
+	return fs.commit()
+}
+
+func (fs *Vault) Symlink(target, link string) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	if err := fs.symlink(target, clean(link)); err != nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *Vault) symlink(target, p string) error {
+	if _, ok := fs.nodes[p]; ok {
+		return os.ErrExist
+	}
+
+	if _, err := fs.mkdirAll(path.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	fs.set(p, &node{
+		mode:    os.ModeSymlink | 0777,
+		modTime: time.Now(),
+		size:    int64(len(target)),
+		target:  target,
+	})
+
+	return fs.commit()
+}
+
+func (fs *Vault) Readlink(link string) (string, error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	n, err := fs.lookup(clean(link))
+	if err == nil && !isSymlink(n.mode) {
+		err = errors.New("not a symlink")
+	}
+
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: link, Err: err}
+	}
+
+	return n.target, nil
+}
+
+func (fs *Vault) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(fs, dir, prefix)
+}
+
+func (fs *Vault) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (fs *Vault) Chroot(p string) (billy.Filesystem, error) {
+	return chroot.New(fs, fs.Join(fs.Root(), p)), nil
+}
+
+func (fs *Vault) Root() string {
+	return string(filepath.Separator)
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Vault) Capabilities() billy.Capability {
+	return billy.WriteCapability | billy.ReadCapability |
+		billy.ReadAndWriteCapability | billy.SeekCapability |
+		billy.TruncateCapability
+}
+
+func clean(p string) string {
+	return path.Clean("/" + filepath.ToSlash(p))
+}
+
+func isSymlink(m os.FileMode) bool {
+	return m&os.ModeSymlink != 0
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR) != 0
+}
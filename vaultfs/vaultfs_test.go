@@ -0,0 +1,228 @@
+package vaultfs
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&VaultSuite{})
+
+var testKey = bytes.Repeat([]byte{42}, KeySize)
+
+type VaultSuite struct {
+	test.FilesystemSuite
+	storage billy.Filesystem
+	vault   *Vault
+}
+
+func (s *VaultSuite) SetUpTest(c *C) {
+	s.storage = memfs.New()
+	s.vault = s.open(c, testKey)
+	s.FilesystemSuite = test.NewFilesystemSuite(s.vault)
+}
+
+func (s *VaultSuite) TearDownTest(c *C) {
+	s.vault.Close()
+}
+
+func (s *VaultSuite) open(c *C, key []byte) *Vault {
+	fs, err := New(s.storage, "vault", key, Options{ChunkSize: minChunkSize})
+	c.Assert(err, IsNil)
+	return fs
+}
+
+// reopen closes the vault, opening it again.
+func (s *VaultSuite) reopen(c *C) *Vault {
+	c.Assert(s.vault.Close(), IsNil)
+	s.vault = s.open(c, testKey)
+	return s.vault
+}
+
+func (s *VaultSuite) TestNew(c *C) {
+	_, err := New(s.storage, "other", []byte("foo"), Options{})
+	c.Assert(err, ErrorMatches, "key must be 32 bytes")
+
+	_, err = New(s.storage, "other", testKey, Options{ChunkSize: 1024})
+	c.Assert(err, ErrorMatches, "chunk size must be between .*")
+
+	// the chunk size of an existing vault is kept
+	fs, err := New(s.storage, "other", testKey, Options{})
+	c.Assert(err, IsNil)
+	c.Assert(fs.Close(), IsNil)
+
+	fs, err = New(s.storage, "other", testKey, Options{ChunkSize: minChunkSize})
+	c.Assert(err, IsNil)
+	c.Assert(fs.c.chunkSize(), Equals, DefaultChunkSize)
+	c.Assert(fs.Close(), IsNil)
+
+	c.Assert(util.WriteFile(s.storage, "other", []byte("foo"), 0644), IsNil)
+	_, err = New(s.storage, "other", testKey, Options{})
+	c.Assert(err, ErrorMatches, "corrupted vault.*")
+}
+
+func (s *VaultSuite) TestPersistence(c *C) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	c.Assert(util.WriteFile(s.FS, "foo/bar", content, 0640), IsNil)
+	c.Assert(s.FS.Symlink("bar", "foo/qux"), IsNil)
+	c.Assert(s.FS.MkdirAll("baz", 0700), IsNil)
+
+	fs := s.reopen(c)
+	c.Assert(test.ReadFile(c, fs, "foo/qux"), Equals, string(content))
+
+	fi, err := fs.Stat("foo/bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode(), Equals, os.FileMode(0640))
+	c.Assert(fi.Size(), Equals, int64(len(content)))
+
+	fi, err = fs.Stat("baz")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode(), Equals, os.ModeDir|0700)
+
+	infos, err := fs.ReadDir("foo")
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 2)
+	c.Assert(infos[1].Mode()&os.ModeSymlink, Not(Equals), os.FileMode(0))
+}
+
+func (s *VaultSuite) TestEncrypted(c *C) {
+	c.Assert(util.WriteFile(s.FS, "secret.txt", []byte("password123"), 0644), IsNil)
+
+	container := test.ReadFile(c, s.storage, "vault")
+	c.Assert(strings.Contains(container, "password123"), Equals, false)
+	c.Assert(strings.Contains(container, "secret.txt"), Equals, false)
+}
+
+func (s *VaultSuite) TestInvalidKey(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(s.vault.Close(), IsNil)
+
+	_, err := New(s.storage, "vault", bytes.Repeat([]byte{1}, KeySize), Options{})
+	c.Assert(err, Equals, ErrInvalidKey)
+
+	s.vault = s.open(c, testKey)
+}
+
+func (s *VaultSuite) TestTampered(c *C) {
+	content := bytes.Repeat([]byte("foo"), 2*minChunkSize)
+	c.Assert(util.WriteFile(s.FS, "foo", content, 0644), IsNil)
+	slot := s.vault.nodes["/foo"].chunks[1]
+	c.Assert(s.vault.Close(), IsNil)
+
+	f, err := s.storage.OpenFile("vault", os.O_RDWR, 0)
+	c.Assert(err, IsNil)
+	_, err = f.Seek(s.vault.c.offset(slot)+100, io.SeekStart)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte{0})
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	s.vault = s.open(c, testKey)
+	r, err := s.vault.Open("foo")
+	c.Assert(err, IsNil)
+	defer r.Close()
+
+	// the first chunk is still readable
+	buf := make([]byte, minChunkSize)
+	_, err = io.ReadFull(r, buf)
+	c.Assert(err, IsNil)
+
+	_, err = r.Read(buf)
+	c.Assert(err.(*os.PathError).Err, Equals, ErrCorrupted)
+}
+
+func (s *VaultSuite) TestRandomAccess(c *C) {
+	content := bytes.Repeat([]byte("0123456789"), minChunkSize)
+	c.Assert(util.WriteFile(s.FS, "foo", content, 0644), IsNil)
+
+	f, err := s.FS.OpenFile("foo", os.O_RDWR, 0)
+	c.Assert(err, IsNil)
+	_, err = f.Seek(int64(len(content)/2), io.SeekStart)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("foo"))
+	c.Assert(err, IsNil)
+
+	before := s.vault.c.slots
+	c.Assert(f.Close(), IsNil)
+
+	// only the chunk modified and the index are written
+	c.Assert(s.vault.c.slots-before <= 2, Equals, true)
+
+	copy(content[len(content)/2:], "foo")
+	c.Assert(test.ReadFile(c, s.reopen(c), "foo"), Equals, string(content))
+}
+
+func (s *VaultSuite) TestReuseSlots(c *C) {
+	content := bytes.Repeat([]byte("foo"), 4*minChunkSize)
+	c.Assert(util.WriteFile(s.FS, "foo", content, 0644), IsNil)
+	slots := s.vault.c.slots
+
+	for i := 0; i < 10; i++ {
+		c.Assert(util.WriteFile(s.FS, "foo", content, 0644), IsNil)
+	}
+
+	c.Assert(s.vault.c.slots <= 2*slots, Equals, true)
+}
+
+func (s *VaultSuite) TestReadWhileReplaced(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", bytes.Repeat([]byte("foo"), minChunkSize), 0644), IsNil)
+
+	f, err := s.FS.Open("foo")
+	c.Assert(err, IsNil)
+
+	// the slots of the open file aren't reused
+	for i := 0; i < 3; i++ {
+		c.Assert(util.WriteFile(s.FS, "foo", bytes.Repeat([]byte("bar"), minChunkSize), 0644), IsNil)
+	}
+
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, string(bytes.Repeat([]byte("foo"), minChunkSize)))
+	c.Assert(f.Close(), IsNil)
+	c.Assert(s.vault.pins, HasLen, 0)
+}
+
+func (s *VaultSuite) TestTruncate(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", bytes.Repeat([]byte("x"), 2*minChunkSize), 0644), IsNil)
+
+	f, err := s.FS.OpenFile("foo", os.O_RDWR, 0)
+	c.Assert(err, IsNil)
+	c.Assert(f.Truncate(10), IsNil)
+	c.Assert(f.Truncate(3*minChunkSize), IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	content := test.ReadFile(c, s.reopen(c), "foo")
+	c.Assert(content, Equals, string(bytes.Repeat([]byte("x"), 10))+string(make([]byte, 3*minChunkSize-10)))
+}
+
+func (s *VaultSuite) TestWriteAfterRemove(c *C) {
+	f, err := s.FS.Create("foo")
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("foo"))
+	c.Assert(err, IsNil)
+
+	c.Assert(s.FS.Remove("foo"), IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	_, err = s.FS.Stat("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *VaultSuite) TestRemoveNotEmpty(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar", []byte("foo"), 0644), IsNil)
+
+	err := s.FS.Remove("foo")
+	c.Assert(err.(*os.PathError).Err, Equals, ErrNotEmpty)
+}
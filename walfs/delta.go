@@ -0,0 +1,145 @@
+package walfs
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// deltaBlock is the size of the blocks of the base indexed to find matches.
+const deltaBlock = 16
+
+const (
+	deltaCopy   = 0x00
+	deltaInsert = 0x01
+)
+
+var errInvalidDelta = errors.New("invalid delta")
+
+// encodeDelta returns a delta that transforms base into target. The layout
+// is:
+//
+//	uvarint size of the target
+//	instructions, until the end of the delta:
+//	  0x00 uvarint offset, uvarint length: copy a range of the base
+//	  0x01 uvarint length, data: insert the given data
+func encodeDelta(base, target []byte) []byte {
+	index := make(map[string]int, len(base)/deltaBlock)
+	for i := 0; i+deltaBlock <= len(base); i += deltaBlock {
+		k := string(base[i : i+deltaBlock])
+		if _, ok := index[k]; !ok {
+			index[k] = i
+		}
+	}
+
+	d := putUvarint(nil, uint64(len(target)))
+	insert := 0
+	for i := 0; i+deltaBlock <= len(target); {
+		offset, ok := index[string(target[i:i+deltaBlock])]
+		if !ok {
+			i++
+			continue
+		}
+
+		// the match is extended as much as possible in both directions.
+		start, end := i, i+deltaBlock
+		for start > insert && offset > 0 && base[offset-1] == target[start-1] {
+			start--
+			offset--
+		}
+
+		for n := offset + end - start; end < len(target) && n < len(base) && base[n] == target[end]; n++ {
+			end++
+		}
+
+		d = appendInsert(d, target[insert:start])
+		d = append(d, deltaCopy)
+		d = putUvarint(d, uint64(offset))
+		d = putUvarint(d, uint64(end-start))
+		i, insert = end, end
+	}
+
+	return appendInsert(d, target[insert:])
+}
+
+func appendInsert(d, data []byte) []byte {
+	if len(data) == 0 {
+		return d
+	}
+
+	d = append(d, deltaInsert)
+	d = putUvarint(d, uint64(len(data)))
+	return append(d, data...)
+}
+
+// applyDelta returns the result of applying the delta to base.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	size, delta, err := getUvarint(delta)
+	if err != nil {
+		return nil, err
+	}
+
+	target := make([]byte, 0, size)
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+
+		var n uint64
+		switch op {
+		case deltaCopy:
+			var offset uint64
+			if offset, delta, err = getUvarint(delta); err != nil {
+				return nil, err
+			}
+
+			if n, delta, err = getUvarint(delta); err != nil {
+				return nil, err
+			}
+
+			if offset+n > uint64(len(base)) {
+				return nil, errInvalidDelta
+			}
+
+			target = append(target, base[offset:offset+n]...)
+		case deltaInsert:
+			if n, delta, err = getUvarint(delta); err != nil {
+				return nil, err
+			}
+
+			if n > uint64(len(delta)) {
+				return nil, errInvalidDelta
+			}
+
+			target = append(target, delta[:n]...)
+			delta = delta[n:]
+		default:
+			return nil, errInvalidDelta
+		}
+	}
+
+	if uint64(len(target)) != size {
+		return nil, errInvalidDelta
+	}
+
+	return target, nil
+}
+
+// deltaSize returns the size of the target of the given delta.
+func deltaSize(delta []byte) (int64, error) {
+	size, _, err := getUvarint(delta)
+	return int64(size), err
+}
+
+func putUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+func getUvarint(b []byte) (uint64, []byte, error) {
+	v, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, nil, errInvalidDelta
+	}
+
+	return v, b[n:], nil
+}
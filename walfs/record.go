@@ -0,0 +1,155 @@
+package walfs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+type opcode uint8
+
+const (
+	opPut opcode = iota + 1
+	opMkdir
+	opSymlink
+	opRemove
+	opRename
+	opDelta
+)
+
+var opNames = map[opcode]string{
+	opPut:     "put",
+	opMkdir:   "mkdir",
+	opSymlink: "symlink",
+	opRemove:  "remove",
+	opRename:  "rename",
+	opDelta:   "delta",
+}
+
+func (op opcode) String() string {
+	return opNames[op]
+}
+
+var errCorrupted = errors.New("corrupted record")
+
+// record is a mutation of the filesystem, as stored in the log and in the
+// snapshots. The on disk layout is:
+//
+//	uint32 crc32 of the rest of the record
+//	uint32 length of the rest of the record
+//	uint8  opcode
+//	uint32 mode
+//	int64  time in nanoseconds
+//	uint16 path length, and path
+//	uint16 target length, and target
+//	uint32 content length, and content
+//
+// The content of a delta record is the uvarint offset of the record holding
+// the previous version of the file, followed by the delta from it.
+type record struct {
+	op      opcode
+	mode    os.FileMode
+	time    time.Time
+	path    string
+	target  string
+	content []byte
+}
+
+const recordHeaderSize = 8
+
+func (r *record) encode() []byte {
+	n := 1 + 4 + 8 + 2 + len(r.path) + 2 + len(r.target) + 4 + len(r.content)
+	buf := make([]byte, recordHeaderSize+n)
+
+	b := buf[recordHeaderSize:]
+	b[0] = byte(r.op)
+	binary.BigEndian.PutUint32(b[1:], uint32(r.mode))
+	binary.BigEndian.PutUint64(b[5:], uint64(r.time.UnixNano()))
+	b = putString16(b[13:], r.path)
+	b = putString16(b, r.target)
+	binary.BigEndian.PutUint32(b, uint32(len(r.content)))
+	copy(b[4:], r.content)
+
+	binary.BigEndian.PutUint32(buf[4:], uint32(n))
+	binary.BigEndian.PutUint32(buf, crc32.ChecksumIEEE(buf[4:]))
+	return buf
+}
+
+func putString16(b []byte, s string) []byte {
+	binary.BigEndian.PutUint16(b, uint16(len(s)))
+	copy(b[2:], s)
+	return b[2+len(s):]
+}
+
+// recordReader reads records sequentially, keeping track of the offset.
+type recordReader struct {
+	r      *bufio.Reader
+	offset int64
+}
+
+func newRecordReader(r io.Reader, offset int64) *recordReader {
+	return &recordReader{r: bufio.NewReader(r), offset: offset}
+}
+
+// Next returns the next record and the offset where it starts. It returns
+// io.EOF at the end of the stream, and errCorrupted when a record is
+// truncated or its checksum doesn't match.
+func (rr *recordReader) Next() (*record, int64, error) {
+	offset := rr.offset
+
+	header := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(rr.r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errCorrupted
+		}
+
+		return nil, offset, err
+	}
+
+	body := make([]byte, binary.BigEndian.Uint32(header[4:]))
+	if _, err := io.ReadFull(rr.r, body); err != nil {
+		return nil, offset, errCorrupted
+	}
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(body)
+	if crc.Sum32() != binary.BigEndian.Uint32(header) {
+		return nil, offset, errCorrupted
+	}
+
+	rr.offset += int64(len(header) + len(body))
+	r, err := decodeRecord(body)
+	return r, offset, err
+}
+
+func decodeRecord(b []byte) (r *record, err error) {
+	defer func() {
+		// any out of range access means the record is malformed.
+		if recover() != nil {
+			r, err = nil, errCorrupted
+		}
+	}()
+
+	r = &record{
+		op:   opcode(b[0]),
+		mode: os.FileMode(binary.BigEndian.Uint32(b[1:])),
+		time: time.Unix(0, int64(binary.BigEndian.Uint64(b[5:]))),
+	}
+
+	b = b[13:]
+	r.path, b = getString16(b)
+	r.target, b = getString16(b)
+	l := binary.BigEndian.Uint32(b)
+	r.content = b[4 : 4+l]
+	return r, nil
+}
+
+func getString16(b []byte) (string, []byte) {
+	l := int(binary.BigEndian.Uint16(b))
+	return string(b[2 : 2+l]), b[2+l:]
+}
@@ -0,0 +1,971 @@
+// Package walfs provides a billy filesystem that records every mutation in an
+// append-only log, stored in an underlying filesystem, with periodic
+// snapshots of the whole state.
+//
+// The current state is kept in memory and rebuilt on startup from the newest
+// snapshot plus the log written after it. Since the log is only rewritten
+// when compacted, the state at any previous offset can be recovered.
+package walfs // import "gopkg.in/src-d/go-billy.v4/walfs"
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const (
+	logName      = "wal.log"
+	compactName  = "wal.log.compact"
+	snapshotsDir = "snapshots"
+	snapshotExt  = ".snap"
+)
+
+// ErrInvalidOffset is returned when the given offset is not the boundary of a
+// record of the log.
+var ErrInvalidOffset = errors.New("offset is not a record boundary")
+
+// Options holds the configuration of a Wal filesystem.
+type Options struct {
+	// SnapshotInterval is the amount of bytes written to the log after which
+	// a new snapshot is taken automatically. Zero disables the automatic
+	// snapshots.
+	SnapshotInterval int64
+	// DeltaChain is the maximum number of consecutive versions of a file
+	// stored as a delta of the previous one, before a full version is
+	// written again. The first version of a file written after opening the
+	// filesystem is always a full one. Zero disables the deltas.
+	DeltaChain int
+}
+
+// Entry describes a mutation recorded in the log.
+type Entry struct {
+	// Offset is the offset of the log right after the mutation, it can be
+	// used with At or RecoverTo to obtain the state after the mutation.
+	Offset int64
+	// Op is the kind of operation: put, delta, mkdir, symlink, remove or
+	// rename. A delta is a put stored as a delta of the previous version.
+	Op string
+	// Path is the path affected by the operation.
+	Path string
+	// Target is the new path of a rename or the target of a symlink.
+	Target string
+	// Time is the time when the operation was recorded.
+	Time time.Time
+}
+
+// Version describes a version of a file recorded in the log.
+type Version struct {
+	// Offset is the offset of the log right after the version was
+	// recorded, as in Entry.
+	Offset int64
+	// Size is the size of the file.
+	Size int64
+	// Time is the time when the version was recorded.
+	Time time.Time
+	// Delta is true if the version is stored as a delta of the previous one.
+	Delta bool
+
+	start int64
+}
+
+// Wal is a filesystem backed by an append-only log.
+type Wal struct {
+	storage billy.Filesystem
+	opts    Options
+
+	m        sync.Mutex
+	state    billy.Filesystem
+	log      billy.File
+	offset   int64
+	snapshot int64
+	chains   map[string]chain
+}
+
+// chain is the last version recorded of a file, and the amount of deltas
+// stored since its last full version.
+type chain struct {
+	start  int64
+	deltas int
+}
+
+// New opens a Wal filesystem stored at the given storage, recovering the
+// state from the latest snapshot and the log.
+func New(storage billy.Filesystem, opts Options) (*Wal, error) {
+	fs := &Wal{storage: storage, opts: opts}
+	if err := fs.load(); err != nil {
+		return nil, err
+	}
+
+	return fs, nil
+}
+
+func (fs *Wal) load() error {
+	size, err := fs.logSize()
+	if err != nil {
+		return err
+	}
+
+	state, offset, err := fs.build(size)
+	if err != nil {
+		return err
+	}
+
+	if offset < size {
+		// a crash in the middle of a write, the torn record is discarded.
+		if err := fs.truncateLog(offset); err != nil {
+			return err
+		}
+	}
+
+	fs.log, err = fs.storage.OpenFile(logName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	snapshots, err := fs.listSnapshots()
+	if err != nil {
+		return err
+	}
+
+	fs.state, fs.offset, fs.snapshot = state, offset, 0
+	fs.chains = make(map[string]chain)
+	if len(snapshots) > 0 {
+		fs.snapshot = snapshots[len(snapshots)-1]
+	}
+
+	return nil
+}
+
+func (fs *Wal) logSize() (int64, error) {
+	fi, err := fs.storage.Stat(logName)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return fi.Size(), nil
+}
+
+// build returns the state at the given offset, and the offset of the last
+// record applied, that may be lower than the requested offset if the log is
+// truncated or corrupted.
+func (fs *Wal) build(offset int64) (billy.Filesystem, int64, error) {
+	snapshots, err := fs.listSnapshots()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	state := memfs.New()
+	start := int64(0)
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if snapshots[i] > offset {
+			continue
+		}
+
+		candidate := memfs.New()
+		if err := fs.loadSnapshot(candidate, snapshots[i]); err != nil {
+			// a broken snapshot, the previous one is tried.
+			continue
+		}
+
+		state, start = candidate, snapshots[i]
+		break
+	}
+
+	end, err := fs.replay(state, start, offset)
+	return state, end, err
+}
+
+// replay applies to state the records of the log between the offsets from
+// and to, returning the offset after the last record applied.
+func (fs *Wal) replay(state billy.Filesystem, from, to int64) (int64, error) {
+	if from == to {
+		return from, nil
+	}
+
+	f, err := fs.storage.Open(logName)
+	if err != nil {
+		return 0, err
+	}
+
+	defer f.Close()
+	if _, err := f.Seek(from, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	rr := newRecordReader(f, from)
+	for rr.offset < to {
+		r, offset, err := rr.Next()
+		if err == io.EOF || err == errCorrupted {
+			return offset, nil
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		if rr.offset > to {
+			return 0, ErrInvalidOffset
+		}
+
+		apply(state, r)
+	}
+
+	return rr.offset, nil
+}
+
+func (fs *Wal) truncateLog(size int64) error {
+	f, err := fs.storage.OpenFile(logName, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// apply performs the mutation described by the record on state. The errors
+// are ignored, since they happen exactly in the same way they did when the
+// mutation was executed for the first time.
+func apply(state billy.Filesystem, r *record) {
+	p := filepath.FromSlash(r.path)
+	switch r.op {
+	case opPut, opDelta:
+		content := r.content
+		if r.op == opDelta {
+			var err error
+			if content, err = patch(state, r); err != nil {
+				return
+			}
+		}
+
+		fi, err := state.Lstat(p)
+		if err == nil && fi.Mode()&os.ModeSymlink == 0 && fi.Mode() != r.mode {
+			state.Remove(p)
+		}
+
+		util.WriteFile(state, p, content, r.mode)
+	case opMkdir:
+		state.MkdirAll(p, r.mode)
+	case opSymlink:
+		state.Symlink(filepath.FromSlash(r.target), p)
+	case opRemove:
+		state.Remove(p)
+	case opRename:
+		state.Rename(p, filepath.FromSlash(r.target))
+	}
+}
+
+// patch returns the content of the file of a delta record, applying the
+// delta to its current content in state.
+func patch(state billy.Filesystem, r *record) ([]byte, error) {
+	base, err := readFile(state, r.path)
+	if err != nil {
+		return nil, err
+	}
+
+	_, delta, err := getUvarint(r.content)
+	if err != nil {
+		return nil, err
+	}
+
+	return applyDelta(base, delta)
+}
+
+// append writes the record to the log, taking a snapshot if needed. Must be
+// called with the lock held.
+func (fs *Wal) append(r *record) error {
+	r.time = time.Now()
+	b := r.encode()
+	if _, err := fs.log.Write(b); err != nil {
+		return err
+	}
+
+	fs.offset += int64(len(b))
+	if fs.opts.SnapshotInterval > 0 && fs.offset-fs.snapshot >= fs.opts.SnapshotInterval {
+		return fs.takeSnapshot()
+	}
+
+	return nil
+}
+
+// Offset returns the current offset of the log.
+func (fs *Wal) Offset() int64 {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	return fs.offset
+}
+
+// Snapshot writes a snapshot of the current state, and returns the offset of
+// the log it belongs to.
+func (fs *Wal) Snapshot() (int64, error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	return fs.offset, fs.takeSnapshot()
+}
+
+func (fs *Wal) takeSnapshot() error {
+	state, err := fs.encodeState()
+	if err != nil {
+		return err
+	}
+
+	name := snapshotName(fs.offset)
+	if err := util.WriteFile(fs.storage, name+".tmp", state, 0644); err != nil {
+		return err
+	}
+
+	if err := fs.storage.Rename(name+".tmp", name); err != nil {
+		return err
+	}
+
+	fs.snapshot = fs.offset
+	return nil
+}
+
+// encodeState returns the records creating the current state, parents first.
+func (fs *Wal) encodeState() ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	err := walk(fs.state, "/", func(p string, fi os.FileInfo) error {
+		r := &record{path: p, mode: fi.Mode(), time: fi.ModTime()}
+		switch {
+		case fi.IsDir():
+			r.op = opMkdir
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := fs.state.Readlink(filepath.FromSlash(p))
+			if err != nil {
+				return err
+			}
+
+			r.op, r.target = opSymlink, filepath.ToSlash(target)
+		default:
+			content, err := readFile(fs.state, p)
+			if err != nil {
+				return err
+			}
+
+			r.op, r.content = opPut, content
+		}
+
+		_, err := buf.Write(r.encode())
+		return err
+	})
+
+	return buf.Bytes(), err
+}
+
+// Compact rewrites the log with only the records needed to create the
+// current state, discarding the history and the snapshots. The offsets
+// returned before, by Offset, History or Versions, are not valid anymore.
+//
+// The new log is written aside and renamed over the old one, so a crash
+// leaves either of them complete.
+func (fs *Wal) Compact() error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	state, err := fs.encodeState()
+	if err != nil {
+		return err
+	}
+
+	if err := util.WriteFile(fs.storage, compactName, state, 0644); err != nil {
+		return err
+	}
+
+	// the snapshots are removed first, since they don't match the new log,
+	// while the old one can still be replayed without them.
+	snapshots, err := fs.listSnapshots()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range snapshots {
+		if err := fs.storage.Remove(snapshotName(s)); err != nil {
+			return err
+		}
+	}
+
+	if err := fs.log.Close(); err != nil {
+		return err
+	}
+
+	// the log is opened again even if the rename fails, keeping the old one.
+	renameErr := fs.storage.Rename(compactName, logName)
+	fs.log, err = fs.storage.OpenFile(logName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if renameErr != nil {
+		return renameErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// the versions are recorded again in full, since the previous ones are
+	// gone.
+	fs.offset, fs.snapshot = int64(len(state)), 0
+	fs.chains = make(map[string]chain)
+	return nil
+}
+
+func (fs *Wal) loadSnapshot(state billy.Filesystem, offset int64) error {
+	f, err := fs.storage.Open(snapshotName(offset))
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+	rr := newRecordReader(f, 0)
+	for {
+		r, _, err := rr.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		apply(state, r)
+	}
+}
+
+func (fs *Wal) listSnapshots() ([]int64, error) {
+	entries, err := fs.storage.ReadDir(snapshotsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var offsets []int64
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), snapshotExt) {
+			continue
+		}
+
+		offset, err := strconv.ParseInt(strings.TrimSuffix(e.Name(), snapshotExt), 16, 64)
+		if err != nil {
+			continue
+		}
+
+		offsets = append(offsets, offset)
+	}
+
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	return offsets, nil
+}
+
+// History returns all the mutations recorded in the log.
+func (fs *Wal) History() ([]Entry, error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	f, err := fs.storage.Open(logName)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	var entries []Entry
+	rr := newRecordReader(io.LimitReader(f, fs.offset), 0)
+	for {
+		r, _, err := rr.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, Entry{
+			Offset: rr.offset,
+			Op:     r.op.String(),
+			Path:   r.path,
+			Target: r.target,
+			Time:   r.time,
+		})
+	}
+}
+
+// At returns a copy of the filesystem as it was at the given offset of the
+// log. The returned filesystem is detached, changes on it are not recorded.
+func (fs *Wal) At(offset int64) (billy.Filesystem, error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	if offset > fs.offset {
+		return nil, ErrInvalidOffset
+	}
+
+	state, end, err := fs.build(offset)
+	if err != nil {
+		return nil, err
+	}
+
+	if end != offset {
+		return nil, ErrInvalidOffset
+	}
+
+	return state, nil
+}
+
+// RecoverTo discards all the mutations after the given offset, restoring the
+// state of the filesystem at that point. Any file open for writing becomes
+// detached from the filesystem.
+func (fs *Wal) RecoverTo(offset int64) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	if offset > fs.offset {
+		return ErrInvalidOffset
+	}
+
+	if _, end, err := fs.build(offset); err != nil || end != offset {
+		if err == nil {
+			err = ErrInvalidOffset
+		}
+
+		return err
+	}
+
+	snapshots, err := fs.listSnapshots()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range snapshots {
+		if s <= offset {
+			continue
+		}
+
+		if err := fs.storage.Remove(snapshotName(s)); err != nil {
+			return err
+		}
+	}
+
+	if err := fs.log.Close(); err != nil {
+		return err
+	}
+
+	if err := fs.truncateLog(offset); err != nil {
+		return err
+	}
+
+	return fs.load()
+}
+
+// Close closes the log.
+func (fs *Wal) Close() error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	return fs.log.Close()
+}
+
+func (fs *Wal) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Wal) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (fs *Wal) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return fs.state.OpenFile(filename, flag, perm)
+	}
+
+	_, err := fs.state.Lstat(filename)
+	created := os.IsNotExist(err) && flag&os.O_CREATE != 0
+
+	f, err := fs.state.OpenFile(filename, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	if created {
+		if err := fs.appendPut(filename); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	// a truncated file is recorded on Close, along with its new content,
+	// keeping the previous version as the base of the delta.
+	truncated := !created && flag&os.O_TRUNC != 0
+	return &file{File: f, fs: fs, path: filename, dirty: truncated}, nil
+}
+
+// appendPut records the current content of the given file. Must be called
+// with the lock held.
+func (fs *Wal) appendPut(filename string) error {
+	fi, err := fs.state.Stat(filename)
+	if err != nil {
+		return err
+	}
+
+	content, err := readFile(fs.state, filename)
+	if err != nil {
+		return err
+	}
+
+	p := clean(filename)
+	r := &record{op: opPut, path: p, mode: fi.Mode(), content: content}
+
+	c, ok := fs.chains[p]
+	if ok && c.deltas < fs.opts.DeltaChain {
+		base, err := fs.readVersion(c.start)
+		if err != nil {
+			return err
+		}
+
+		r.op = opDelta
+		r.content = append(putUvarint(nil, uint64(c.start)), encodeDelta(base, content)...)
+		c.deltas++
+	} else {
+		c.deltas = 0
+	}
+
+	c.start = fs.offset
+	if err := fs.append(r); err != nil {
+		return err
+	}
+
+	if fs.opts.DeltaChain > 0 {
+		fs.chains[p] = c
+	}
+
+	return nil
+}
+
+// readVersion returns the content of the version of a file recorded at the
+// given offset, applying the chain of deltas if needed.
+func (fs *Wal) readVersion(offset int64) ([]byte, error) {
+	r, err := fs.readRecord(offset)
+	if err != nil {
+		return nil, err
+	}
+
+	switch r.op {
+	case opPut:
+		return r.content, nil
+	case opDelta:
+		start, delta, err := getUvarint(r.content)
+		if err != nil {
+			return nil, err
+		}
+
+		if int64(start) >= offset {
+			return nil, errInvalidDelta
+		}
+
+		base, err := fs.readVersion(int64(start))
+		if err != nil {
+			return nil, err
+		}
+
+		return applyDelta(base, delta)
+	default:
+		return nil, ErrInvalidOffset
+	}
+}
+
+func (fs *Wal) readRecord(offset int64) (*record, error) {
+	f, err := fs.storage.Open(logName)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	r, _, err := newRecordReader(f, offset).Next()
+	if err == io.EOF || err == errCorrupted {
+		return nil, ErrInvalidOffset
+	}
+
+	return r, err
+}
+
+// Versions returns the versions of the given file recorded in the log, under
+// the given name.
+func (fs *Wal) Versions(filename string) ([]Version, error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	f, err := fs.storage.Open(logName)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	p := clean(filename)
+	var versions []Version
+	rr := newRecordReader(io.LimitReader(f, fs.offset), 0)
+	for {
+		r, start, err := rr.Next()
+		if err == io.EOF {
+			return versions, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if r.path != p || (r.op != opPut && r.op != opDelta) {
+			continue
+		}
+
+		v := Version{Offset: rr.offset, Time: r.time, Size: int64(len(r.content)), start: start}
+		if r.op == opDelta {
+			_, delta, err := getUvarint(r.content)
+			if err != nil {
+				return nil, err
+			}
+
+			if v.Size, err = deltaSize(delta); err != nil {
+				return nil, err
+			}
+
+			v.Delta = true
+		}
+
+		versions = append(versions, v)
+	}
+}
+
+// ReadVersion returns the content of the given version, as returned by
+// Versions.
+func (fs *Wal) ReadVersion(v Version) ([]byte, error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	return fs.readVersion(v.start)
+}
+
+func (fs *Wal) Stat(filename string) (os.FileInfo, error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	return fs.state.Stat(filename)
+}
+
+func (fs *Wal) Lstat(filename string) (os.FileInfo, error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	return fs.state.Lstat(filename)
+}
+
+func (fs *Wal) ReadDir(dirname string) ([]os.FileInfo, error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	return fs.state.ReadDir(dirname)
+}
+
+func (fs *Wal) Readlink(link string) (string, error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	return fs.state.Readlink(link)
+}
+
+func (fs *Wal) MkdirAll(filename string, perm os.FileMode) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	if err := fs.state.MkdirAll(filename, perm); err != nil {
+		return err
+	}
+
+	return fs.append(&record{op: opMkdir, path: clean(filename), mode: perm})
+}
+
+func (fs *Wal) Symlink(target, link string) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	if err := fs.state.Symlink(target, link); err != nil {
+		return err
+	}
+
+	return fs.append(&record{op: opSymlink, path: clean(link), target: filepath.ToSlash(target)})
+}
+
+func (fs *Wal) Remove(filename string) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	if err := fs.state.Remove(filename); err != nil {
+		return err
+	}
+
+	delete(fs.chains, clean(filename))
+
+	return fs.append(&record{op: opRemove, path: clean(filename)})
+}
+
+func (fs *Wal) Rename(from, to string) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	if err := fs.state.Rename(from, to); err != nil {
+		return err
+	}
+
+	fs.renameChains(clean(from), clean(to))
+
+	return fs.append(&record{op: opRename, path: clean(from), target: clean(to)})
+}
+
+// renameChains moves the chains of from, and any file under it, to the new
+// path. Must be called with the lock held.
+func (fs *Wal) renameChains(from, to string) {
+	moved := make(map[string]chain)
+	for p, c := range fs.chains {
+		switch {
+		case p == from || strings.HasPrefix(p, from+"/"):
+			moved[to+strings.TrimPrefix(p, from)] = c
+		case p == to || strings.HasPrefix(p, to+"/"):
+		default:
+			continue
+		}
+
+		delete(fs.chains, p)
+	}
+
+	for p, c := range moved {
+		fs.chains[p] = c
+	}
+}
+
+func (fs *Wal) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(fs, dir, prefix)
+}
+
+func (fs *Wal) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (fs *Wal) Chroot(p string) (billy.Filesystem, error) {
+	return chroot.New(fs, fs.Join(fs.Root(), p)), nil
+}
+
+func (fs *Wal) Root() string {
+	return string(filepath.Separator)
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Wal) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.state)
+}
+
+// file is a file open for writing, its content is recorded on Close.
+type file struct {
+	billy.File
+	fs    *Wal
+	path  string
+	dirty bool
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	if n > 0 {
+		f.dirty = true
+	}
+
+	return n, err
+}
+
+func (f *file) Truncate(size int64) error {
+	f.dirty = true
+	return f.File.Truncate(size)
+}
+
+func (f *file) Close() error {
+	if err := f.File.Close(); err != nil || !f.dirty {
+		return err
+	}
+
+	f.fs.m.Lock()
+	defer f.fs.m.Unlock()
+
+	return f.fs.appendPut(f.path)
+}
+
+// walk calls fn for every entry under dir, sorted by name, parents first.
+func walk(fs billy.Filesystem, dir string, fn func(p string, fi os.FileInfo) error) error {
+	entries, err := fs.ReadDir(filepath.FromSlash(dir))
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	for _, fi := range entries {
+		p := path.Join(dir, fi.Name())
+		if err := fn(p, fi); err != nil {
+			return err
+		}
+
+		if fi.IsDir() {
+			if err := walk(fs, p, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func readFile(fs billy.Basic, filename string) ([]byte, error) {
+	f, err := fs.Open(filepath.FromSlash(filename))
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+func snapshotName(offset int64) string {
+	return path.Join(snapshotsDir, fmt.Sprintf("%016x%s", offset, snapshotExt))
+}
+
+func clean(p string) string {
+	return path.Clean("/" + filepath.ToSlash(p))
+}
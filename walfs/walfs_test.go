@@ -0,0 +1,310 @@
+package walfs
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type WalSuite struct {
+	test.FilesystemSuite
+	storage billy.Filesystem
+}
+
+var _ = Suite(&WalSuite{})
+
+func (s *WalSuite) SetUpTest(c *C) {
+	s.storage = memfs.New()
+	fs, err := New(s.storage, Options{})
+	c.Assert(err, IsNil)
+
+	s.FilesystemSuite = test.NewFilesystemSuite(fs)
+}
+
+func (s *WalSuite) reopen(c *C, opts Options) *Wal {
+	c.Assert(s.FS.(*Wal).Close(), IsNil)
+
+	fs, err := New(s.storage, opts)
+	c.Assert(err, IsNil)
+
+	s.FS = fs
+	return fs
+}
+
+func (s *WalSuite) TestPersistence(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo/bar", []byte("bar"), 0600), IsNil)
+	c.Assert(s.FS.MkdirAll("empty", 0755), IsNil)
+	c.Assert(s.FS.Symlink("foo/bar", "link"), IsNil)
+	c.Assert(s.FS.Rename("foo", "qux"), IsNil)
+	c.Assert(util.WriteFile(s.FS, "removed", []byte("foo"), 0644), IsNil)
+	c.Assert(s.FS.Remove("removed"), IsNil)
+
+	fs := s.reopen(c, Options{})
+	c.Assert(test.ReadFile(c, fs, "qux/bar"), Equals, "bar")
+
+	fi, err := fs.Stat("qux/bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode(), Equals, os.FileMode(0600))
+
+	fi, err = fs.Stat("empty")
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+
+	target, err := fs.Readlink("link")
+	c.Assert(err, IsNil)
+	c.Assert(target, Equals, "foo/bar")
+
+	_, err = fs.Stat("removed")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *WalSuite) TestHistoryAndAt(c *C) {
+	fs := s.FS.(*Wal)
+	c.Assert(util.WriteFile(fs, "foo", []byte("1"), 0644), IsNil)
+	c.Assert(util.WriteFile(fs, "foo", []byte("2"), 0644), IsNil)
+	c.Assert(fs.Remove("foo"), IsNil)
+
+	history, err := fs.History()
+	c.Assert(err, IsNil)
+	c.Assert(history, HasLen, 4)
+	c.Assert(history[1].Op, Equals, "put")
+	c.Assert(history[1].Path, Equals, "/foo")
+	c.Assert(history[3].Op, Equals, "remove")
+	c.Assert(history[3].Offset, Equals, fs.Offset())
+
+	old, err := fs.At(history[1].Offset)
+	c.Assert(err, IsNil)
+	c.Assert(test.ReadFile(c, old, "foo"), Equals, "1")
+
+	old, err = fs.At(history[2].Offset)
+	c.Assert(err, IsNil)
+	c.Assert(test.ReadFile(c, old, "foo"), Equals, "2")
+
+	_, err = fs.At(history[2].Offset - 1)
+	c.Assert(err, Equals, ErrInvalidOffset)
+
+	_, err = fs.Stat("foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *WalSuite) TestRecoverTo(c *C) {
+	fs := s.FS.(*Wal)
+	c.Assert(util.WriteFile(fs, "foo", []byte("foo"), 0644), IsNil)
+	offset := fs.Offset()
+
+	_, err := fs.Snapshot()
+	c.Assert(err, IsNil)
+
+	c.Assert(util.WriteFile(fs, "bar", []byte("bar"), 0644), IsNil)
+	_, err = fs.Snapshot()
+	c.Assert(err, IsNil)
+	c.Assert(fs.Remove("foo"), IsNil)
+
+	c.Assert(fs.RecoverTo(offset), IsNil)
+	c.Assert(fs.Offset(), Equals, offset)
+	c.Assert(test.ReadFile(c, fs, "foo"), Equals, "foo")
+
+	_, err = fs.Stat("bar")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	snapshots, err := s.storage.ReadDir(snapshotsDir)
+	c.Assert(err, IsNil)
+	c.Assert(snapshots, HasLen, 1)
+}
+
+func (s *WalSuite) TestAutomaticSnapshots(c *C) {
+	fs := s.reopen(c, Options{SnapshotInterval: 100})
+	for i := 0; i < 10; i++ {
+		c.Assert(util.WriteFile(fs, "foo", []byte("0123456789"), 0644), IsNil)
+	}
+
+	snapshots, err := fs.listSnapshots()
+	c.Assert(err, IsNil)
+	c.Assert(len(snapshots) > 1, Equals, true)
+
+	// the snapshots are used on load, even when the log is broken before.
+	f, err := s.storage.OpenFile(logName, os.O_RDWR, 0)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("garbage"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	fs = s.reopen(c, Options{})
+	c.Assert(test.ReadFile(c, fs, "foo"), Equals, "0123456789")
+}
+
+func (s *WalSuite) TestCompact(c *C) {
+	fs := s.reopen(c, Options{SnapshotInterval: 100, DeltaChain: 2})
+	for i := 0; i < 10; i++ {
+		c.Assert(util.WriteFile(fs, "foo/bar", []byte(strings.Repeat("bar", i)), 0600), IsNil)
+	}
+
+	c.Assert(fs.MkdirAll("empty", 0755), IsNil)
+	c.Assert(fs.Symlink("qux/bar", "link"), IsNil)
+	c.Assert(util.WriteFile(fs, "removed", []byte("foo"), 0644), IsNil)
+	c.Assert(fs.Remove("removed"), IsNil)
+	c.Assert(fs.Rename("foo", "qux"), IsNil)
+
+	size := fs.Offset()
+	c.Assert(fs.Compact(), IsNil)
+	c.Assert(fs.Offset() < size, Equals, true)
+
+	snapshots, err := fs.listSnapshots()
+	c.Assert(err, IsNil)
+	c.Assert(snapshots, HasLen, 0)
+
+	history, err := fs.History()
+	c.Assert(err, IsNil)
+
+	var ops []string
+	for _, e := range history {
+		ops = append(ops, e.Op+" "+e.Path)
+	}
+
+	c.Assert(ops, DeepEquals, []string{
+		"mkdir /empty", "symlink /link", "mkdir /qux", "put /qux/bar",
+	})
+
+	// the log keeps growing after compacted
+	c.Assert(util.WriteFile(fs, "qux/bar", []byte("qux"), 0600), IsNil)
+
+	fs = s.reopen(c, Options{})
+	c.Assert(test.ReadFile(c, fs, "link"), Equals, "qux")
+
+	fi, err := fs.Stat("qux/bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode(), Equals, os.FileMode(0600))
+
+	_, err = fs.Stat("empty")
+	c.Assert(err, IsNil)
+	_, err = fs.Stat("removed")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	_, err = s.storage.Stat(compactName)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *WalSuite) TestCompactOpenFile(c *C) {
+	f, err := s.FS.Create("foo")
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("foo"))
+	c.Assert(err, IsNil)
+
+	c.Assert(s.FS.(*Wal).Compact(), IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	fs := s.reopen(c, Options{})
+	c.Assert(test.ReadFile(c, fs, "foo"), Equals, "foo")
+}
+
+func (s *WalSuite) TestTornRecord(c *C) {
+	c.Assert(util.WriteFile(s.FS, "foo", []byte("foo"), 0644), IsNil)
+	offset := s.FS.(*Wal).Offset()
+	c.Assert(util.WriteFile(s.FS, "bar", []byte("bar"), 0644), IsNil)
+	c.Assert(s.FS.(*Wal).Close(), IsNil)
+
+	fi, err := s.storage.Stat(logName)
+	c.Assert(err, IsNil)
+
+	f, err := s.storage.OpenFile(logName, os.O_RDWR, 0)
+	c.Assert(err, IsNil)
+	c.Assert(f.Truncate(fi.Size()-2), IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	fs, err := New(s.storage, Options{})
+	c.Assert(err, IsNil)
+	c.Assert(test.ReadFile(c, fs, "foo"), Equals, "foo")
+	c.Assert(fs.Offset() > offset, Equals, true)
+
+	fi, err = fs.Stat("bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Size(), Equals, int64(0))
+}
+
+func (s *WalSuite) TestDeltaVersions(c *C) {
+	fs := s.reopen(c, Options{DeltaChain: 2})
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1024)
+	var contents [][]byte
+	for i := 0; i < 4; i++ {
+		content = append([]byte(nil), content...)
+		copy(content[i*1000:], "modified")
+		contents = append(contents, content)
+		c.Assert(util.WriteFile(fs, "foo", content, 0644), IsNil)
+	}
+
+	versions, err := fs.Versions("foo")
+	c.Assert(err, IsNil)
+	c.Assert(versions, HasLen, 5)
+
+	// the file is recorded empty on creation, and then once per write.
+	c.Assert(versions[0].Size, Equals, int64(0))
+	var deltas []bool
+	for i, v := range versions[1:] {
+		deltas = append(deltas, v.Delta)
+		c.Assert(v.Size, Equals, int64(len(content)))
+
+		b, err := fs.ReadVersion(v)
+		c.Assert(err, IsNil)
+		c.Assert(bytes.Equal(b, contents[i]), Equals, true)
+	}
+
+	c.Assert(deltas, DeepEquals, []bool{true, true, false, true})
+	c.Assert(fs.Offset() < int64(3*len(content)), Equals, true)
+
+	old, err := fs.At(versions[2].Offset)
+	c.Assert(err, IsNil)
+	c.Assert(test.ReadFile(c, old, "foo"), Equals, string(contents[1]))
+
+	fs = s.reopen(c, Options{DeltaChain: 2})
+	c.Assert(test.ReadFile(c, fs, "foo"), Equals, string(contents[3]))
+}
+
+func (s *WalSuite) TestDeltaRename(c *C) {
+	fs := s.reopen(c, Options{DeltaChain: 8})
+
+	c.Assert(util.WriteFile(fs, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(fs.Rename("foo", "bar"), IsNil)
+	c.Assert(util.WriteFile(fs, "bar", []byte("bar"), 0644), IsNil)
+
+	versions, err := fs.Versions("bar")
+	c.Assert(err, IsNil)
+	c.Assert(versions, HasLen, 1)
+	c.Assert(versions[0].Delta, Equals, true)
+
+	b, err := fs.ReadVersion(versions[0])
+	c.Assert(err, IsNil)
+	c.Assert(string(b), Equals, "bar")
+
+	fs = s.reopen(c, Options{})
+	c.Assert(test.ReadFile(c, fs, "bar"), Equals, "bar")
+}
+
+func (s *WalSuite) TestDelta(c *C) {
+	base := []byte("the quick brown fox jumps over the lazy dog, the quick brown fox")
+	for _, target := range []string{
+		"",
+		"the quick brown fox",
+		"a slow brown fox jumps over the lazy dog, the quick brown fox!",
+		"completely different content without any match at all",
+		string(base),
+	} {
+		d := encodeDelta(base, []byte(target))
+		result, err := applyDelta(base, d)
+		c.Assert(err, IsNil)
+		c.Assert(string(result), Equals, target)
+	}
+
+	_, err := applyDelta(base, []byte{10, deltaCopy, 60, 10})
+	c.Assert(err, Equals, errInvalidDelta)
+}
@@ -0,0 +1,145 @@
+package zipfs
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// file is a read-only billy.File over a zip entry. Since compressed entries
+// can only be read sequentially, the entry is reopened when a backward seek
+// is required, and the data is discarded on forward seeks.
+type file struct {
+	name string
+	zf   *zip.File
+
+	r        io.ReadCloser
+	rpos     int64
+	position int64
+	isClosed bool
+}
+
+func newFile(name string, zf *zip.File) billy.File {
+	return &file{name: name, zf: zf}
+}
+
+func (f *file) Name() string {
+	return f.name
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.position)
+	f.position += int64(n)
+
+	if err == io.EOF && n != 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: f.name, Err: errors.New("negative offset")}
+	}
+
+	if err := f.seekReader(off); err != nil {
+		return 0, err
+	}
+
+	n, err := io.ReadFull(f.r, p)
+	f.rpos += int64(n)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+func (f *file) seekReader(off int64) error {
+	if f.r == nil || off < f.rpos {
+		if err := f.closeReader(); err != nil {
+			return err
+		}
+
+		r, err := f.zf.Open()
+		if err != nil {
+			return err
+		}
+
+		f.r, f.rpos = r, 0
+	}
+
+	n, err := io.CopyN(ioutil.Discard, f.r, off-f.rpos)
+	f.rpos += n
+	if err == io.EOF {
+		return nil
+	}
+
+	return err
+}
+
+func (f *file) closeReader() error {
+	if f.r == nil {
+		return nil
+	}
+
+	err := f.r.Close()
+	f.r = nil
+	return err
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.position
+	case io.SeekEnd:
+		offset += int64(f.zf.UncompressedSize64)
+	}
+
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: errors.New("negative offset")}
+	}
+
+	f.position = offset
+	return f.position, nil
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	return 0, billy.ErrReadOnly
+}
+
+func (f *file) Truncate(size int64) error {
+	return billy.ErrReadOnly
+}
+
+func (f *file) Close() error {
+	if f.isClosed {
+		return os.ErrClosed
+	}
+
+	f.isClosed = true
+	return f.closeReader()
+}
+
+// Lock is a no-op in zipfs.
+func (f *file) Lock() error {
+	return nil
+}
+
+// Unlock is a no-op in zipfs.
+func (f *file) Unlock() error {
+	return nil
+}
@@ -0,0 +1,324 @@
+package zipfs
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+)
+
+var (
+	// ErrFlushed is returned when a file already written to the archive is
+	// modified.
+	ErrFlushed = errors.New("file already flushed to the zip archive")
+	// ErrClosed is returned by any operation over a closed Writer.
+	ErrClosed = errors.New("zip writer closed")
+)
+
+// Writer is a write-only filesystem that builds a zip archive. The files are
+// buffered in memory and written to the archive on Flush or Close, once
+// flushed a file can't be modified anymore.
+type Writer struct {
+	billy.Filesystem
+	zw *zip.Writer
+
+	m       sync.Mutex
+	open    map[string]int
+	flushed map[string]bool
+	closed  bool
+}
+
+// NewWriter returns a new Writer streaming the archive to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		Filesystem: memfs.New(),
+		zw:         zip.NewWriter(w),
+		open:       make(map[string]int),
+		flushed:    make(map[string]bool),
+	}
+}
+
+func (w *Writer) Create(filename string) (billy.File, error) {
+	return w.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (w *Writer) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if !isWrite(flag) {
+		return w.Filesystem.OpenFile(filename, flag, perm)
+	}
+
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	name := clean(filename)
+	if err := w.checkWritable(name); err != nil {
+		return nil, err
+	}
+
+	f, err := w.Filesystem.OpenFile(filename, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	w.open[name]++
+	return &writerFile{File: f, w: w, name: name}, nil
+}
+
+func (w *Writer) Rename(from, to string) error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	for _, name := range []string{clean(from), clean(to)} {
+		if err := w.checkWritable(name); err != nil {
+			return err
+		}
+	}
+
+	if w.hasFlushedChildren(clean(from)) {
+		return ErrFlushed
+	}
+
+	return w.Filesystem.Rename(from, to)
+}
+
+func (w *Writer) Remove(filename string) error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if err := w.checkWritable(clean(filename)); err != nil {
+		return err
+	}
+
+	return w.Filesystem.Remove(filename)
+}
+
+func (w *Writer) MkdirAll(filename string, perm os.FileMode) error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+
+	return w.Filesystem.MkdirAll(filename, perm)
+}
+
+func (w *Writer) Symlink(target, link string) error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if err := w.checkWritable(clean(link)); err != nil {
+		return err
+	}
+
+	return w.Filesystem.Symlink(target, link)
+}
+
+func (w *Writer) TempFile(dir, prefix string) (billy.File, error) {
+	return nil, billy.ErrNotSupported
+}
+
+func (w *Writer) Chroot(path string) (billy.Filesystem, error) {
+	return chroot.New(w, w.Join(w.Root(), path)), nil
+}
+
+// Capabilities implements the Capable interface.
+func (w *Writer) Capabilities() billy.Capability {
+	return billy.Capabilities(w.Filesystem)
+}
+
+func (w *Writer) checkWritable(name string) error {
+	if w.closed {
+		return ErrClosed
+	}
+
+	if w.flushed[name] {
+		return ErrFlushed
+	}
+
+	return nil
+}
+
+func (w *Writer) hasFlushedChildren(dir string) bool {
+	for name := range w.flushed {
+		if strings.HasPrefix(name, dir+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Flush writes to the archive all the files not open for writing and not
+// flushed before.
+func (w *Writer) Flush() error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+
+	return w.flush()
+}
+
+func (w *Writer) flush() error {
+	err := walk(w.Filesystem, "", func(name string, fi os.FileInfo) error {
+		if w.flushed[name] || w.open[name] != 0 {
+			return nil
+		}
+
+		if err := addEntry(w.zw, w.Filesystem, name, fi); err != nil {
+			return err
+		}
+
+		w.flushed[name] = true
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return w.zw.Flush()
+}
+
+// Close flushes all the pending files and writes the central directory of
+// the archive. It does not close the underlying writer. Any file still open
+// for writing is ignored.
+func (w *Writer) Close() error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+
+	if err := w.flush(); err != nil {
+		return err
+	}
+
+	w.closed = true
+	return w.zw.Close()
+}
+
+type writerFile struct {
+	billy.File
+	w      *Writer
+	name   string
+	closed bool
+}
+
+func (f *writerFile) Close() error {
+	f.w.m.Lock()
+	if !f.closed {
+		f.closed = true
+		f.w.open[f.name]--
+	}
+
+	f.w.m.Unlock()
+	return f.File.Close()
+}
+
+// Export writes all the files, directories and symlinks of fs as a zip
+// archive to w.
+func Export(w io.Writer, fs billy.Filesystem) error {
+	zw := zip.NewWriter(w)
+	err := walk(fs, "", func(name string, fi os.FileInfo) error {
+		return addEntry(zw, fs, name, fi)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// walk calls fn for every entry under dir, sorted by name, parents first.
+func walk(fs billy.Filesystem, dir string, fn func(name string, fi os.FileInfo) error) error {
+	entries, err := fs.ReadDir(filepath.FromSlash("/" + dir))
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	for _, fi := range entries {
+		name := fi.Name()
+		if dir != "" {
+			name = dir + "/" + name
+		}
+
+		if err := fn(name, fi); err != nil {
+			return err
+		}
+
+		if fi.IsDir() {
+			if err := walk(fs, name, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func addEntry(zw *zip.Writer, fs billy.Filesystem, name string, fi os.FileInfo) error {
+	h, err := zip.FileInfoHeader(fi)
+	if err != nil {
+		return err
+	}
+
+	h.Name = name
+	switch {
+	case fi.IsDir():
+		h.Name += "/"
+		h.Method = zip.Store
+		_, err = zw.CreateHeader(h)
+		return err
+	case fi.Mode()&os.ModeSymlink != 0:
+		target, err := fs.Readlink(filepath.FromSlash(name))
+		if err != nil {
+			return err
+		}
+
+		h.Method = zip.Store
+		ew, err := zw.CreateHeader(h)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.WriteString(ew, filepath.ToSlash(target))
+		return err
+	}
+
+	h.Method = zip.Deflate
+	ew, err := zw.CreateHeader(h)
+	if err != nil {
+		return err
+	}
+
+	f, err := fs.Open(filepath.FromSlash(name))
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+	_, err = io.Copy(ew, f)
+	return err
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0
+}
@@ -0,0 +1,94 @@
+package zipfs
+
+import (
+	"bytes"
+	"os"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/test"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&WriterSuite{})
+
+type WriterSuite struct{}
+
+func openArchive(c *C, buf *bytes.Buffer) billy.Filesystem {
+	fs, err := New(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	c.Assert(err, IsNil)
+	return fs
+}
+
+func (s *WriterSuite) TestClose(c *C) {
+	buf := bytes.NewBuffer(nil)
+	w := NewWriter(buf)
+
+	c.Assert(util.WriteFile(w, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(w, "qux/bar", []byte("bar"), 0600), IsNil)
+	c.Assert(w.MkdirAll("empty", 0755), IsNil)
+	c.Assert(w.Symlink("foo", "link"), IsNil)
+	c.Assert(buf.Len(), Equals, 0)
+	c.Assert(w.Close(), IsNil)
+
+	fs := openArchive(c, buf)
+	c.Assert(test.ReadFile(c, fs, "foo"), Equals, "foo")
+	c.Assert(test.ReadFile(c, fs, "qux/bar"), Equals, "bar")
+	c.Assert(test.ReadFile(c, fs, "link"), Equals, "foo")
+
+	fi, err := fs.Stat("qux/bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode(), Equals, os.FileMode(0600))
+
+	fi, err = fs.Stat("empty")
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+
+	_, err = w.Create("bar")
+	c.Assert(err, Equals, ErrClosed)
+}
+
+func (s *WriterSuite) TestFlush(c *C) {
+	buf := bytes.NewBuffer(nil)
+	w := NewWriter(buf)
+
+	c.Assert(util.WriteFile(w, "foo", []byte("foo"), 0644), IsNil)
+	open, err := w.Create("bar")
+	c.Assert(err, IsNil)
+	_, err = open.Write([]byte("bar"))
+	c.Assert(err, IsNil)
+
+	c.Assert(w.Flush(), IsNil)
+	c.Assert(buf.Len(), Not(Equals), 0)
+
+	_, err = w.Create("foo")
+	c.Assert(err, Equals, ErrFlushed)
+	c.Assert(w.Remove("foo"), Equals, ErrFlushed)
+	c.Assert(w.Rename("foo", "qux"), Equals, ErrFlushed)
+
+	c.Assert(open.Close(), IsNil)
+	c.Assert(w.Close(), IsNil)
+
+	fs := openArchive(c, buf)
+	c.Assert(test.ReadFile(c, fs, "foo"), Equals, "foo")
+	c.Assert(test.ReadFile(c, fs, "bar"), Equals, "bar")
+
+	entries, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 2)
+}
+
+func (s *WriterSuite) TestExport(c *C) {
+	src := memfs.New()
+	c.Assert(util.WriteFile(src, "foo/bar", []byte("bar"), 0644), IsNil)
+	c.Assert(util.WriteFile(src, "qux", []byte("qux"), 0644), IsNil)
+
+	buf := bytes.NewBuffer(nil)
+	c.Assert(Export(buf, src), IsNil)
+
+	fs := openArchive(c, buf)
+	c.Assert(test.ReadFile(c, fs, "foo/bar"), Equals, "bar")
+	c.Assert(test.ReadFile(c, fs, "qux"), Equals, "qux")
+}
@@ -0,0 +1,312 @@
+// Package zipfs provides a read-only billy filesystem over a zip archive.
+package zipfs // import "gopkg.in/src-d/go-billy.v4/zipfs"
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/internal/linkutil"
+)
+
+// Zip is a read-only filesystem based on a zip archive.
+type Zip struct {
+	entries  map[string]*entry
+	children map[string][]string
+}
+
+type entry struct {
+	name string
+	file *zip.File
+	dir  bool
+}
+
+// New returns a new read-only filesystem from the zip archive readable from
+// r, which is assumed to have the given size in bytes.
+func New(r io.ReaderAt, size int64) (billy.Filesystem, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewFromReader(zr), nil
+}
+
+// NewFromReader returns a new read-only filesystem from an already opened
+// zip.Reader.
+func NewFromReader(r *zip.Reader) billy.Filesystem {
+	fs := &Zip{
+		entries:  map[string]*entry{"": {dir: true}},
+		children: make(map[string][]string),
+	}
+
+	for _, f := range r.File {
+		fs.add(f)
+	}
+
+	for _, names := range fs.children {
+		sort.Strings(names)
+	}
+
+	return chroot.New(fs, string(filepath.Separator))
+}
+
+func (fs *Zip) add(f *zip.File) {
+	name := clean(f.Name)
+	if name == "" {
+		return
+	}
+
+	e, ok := fs.entries[name]
+	if !ok {
+		e = &entry{name: name}
+		fs.entries[name] = e
+		fs.addParents(name)
+	}
+
+	e.file = f
+	e.dir = strings.HasSuffix(f.Name, "/") || f.Mode().IsDir()
+}
+
+func (fs *Zip) addParents(name string) {
+	dir := linkutil.Parent(name)
+	fs.children[dir] = append(fs.children[dir], path.Base(name))
+
+	if _, ok := fs.entries[dir]; ok {
+		return
+	}
+
+	fs.entries[dir] = &entry{name: dir, dir: true}
+	fs.addParents(dir)
+}
+
+func (fs *Zip) Create(filename string) (billy.File, error) {
+	return nil, billy.ErrReadOnly
+}
+
+func (fs *Zip) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (fs *Zip) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if isWrite(flag) {
+		return nil, billy.ErrReadOnly
+	}
+
+	e, err := fs.resolve(filename, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.dir {
+		return nil, fmt.Errorf("cannot open directory: %s", filename)
+	}
+
+	return newFile(filename, e.file), nil
+}
+
+func (fs *Zip) Stat(filename string) (os.FileInfo, error) {
+	e, err := fs.resolve(filename, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return newFileInfo(path.Base(clean(filename)), e), nil
+}
+
+func (fs *Zip) Lstat(filename string) (os.FileInfo, error) {
+	e, err := fs.resolve(filename, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return newFileInfo(path.Base(clean(filename)), e), nil
+}
+
+func (fs *Zip) ReadDir(filename string) ([]os.FileInfo, error) {
+	e, err := fs.resolve(filename, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if !e.dir {
+		return nil, &os.PathError{Op: "readdir", Path: filename, Err: errors.New("not a directory")}
+	}
+
+	var entries []os.FileInfo
+	for _, name := range fs.children[e.name] {
+		child := fs.entries[path.Join(e.name, name)]
+		entries = append(entries, newFileInfo(name, child))
+	}
+
+	return entries, nil
+}
+
+func (fs *Zip) Readlink(link string) (string, error) {
+	e, err := fs.resolve(link, false)
+	if err != nil {
+		return "", err
+	}
+
+	if !e.isSymlink() {
+		return "", &os.PathError{Op: "readlink", Path: link, Err: errors.New("not a symlink")}
+	}
+
+	target, err := e.readlink()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.FromSlash(target), nil
+}
+
+func (fs *Zip) Rename(from, to string) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *Zip) Remove(filename string) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *Zip) MkdirAll(filename string, perm os.FileMode) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *Zip) Symlink(target, link string) error {
+	return billy.ErrReadOnly
+}
+
+func (fs *Zip) TempFile(dir, prefix string) (billy.File, error) {
+	return nil, billy.ErrReadOnly
+}
+
+func (fs *Zip) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+// Capabilities implements the Capable interface.
+func (fs *Zip) Capabilities() billy.Capability {
+	return billy.ReadCapability | billy.SeekCapability
+}
+
+// resolve returns the entry of the given filename, following the symlinks
+// found in any of the parent directories, and in the file itself if follow
+// is true.
+func (fs *Zip) resolve(filename string, follow bool) (*entry, error) {
+	name, _, err := linkutil.Resolve(clean(filename), follow, "", fs.lookup, fs.readlink)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: filename, Err: err}
+	}
+
+	return fs.entries[name], nil
+}
+
+// lookup returns the path of the entry with the given name in the directory
+// dir, also given by its path, since the entries are indexed by path.
+func (fs *Zip) lookup(dir interface{}, name string) (interface{}, bool, error) {
+	p := path.Join(dir.(string), name)
+	e, ok := fs.entries[p]
+	if !ok {
+		return nil, false, os.ErrNotExist
+	}
+
+	return p, e.isSymlink(), nil
+}
+
+func (fs *Zip) readlink(link interface{}) (string, error) {
+	return fs.entries[link.(string)].readlink()
+}
+
+func (e *entry) isSymlink() bool {
+	return e.file != nil && e.file.Mode()&os.ModeSymlink != 0
+}
+
+func (e *entry) readlink() (string, error) {
+	r, err := e.file.Open()
+	if err != nil {
+		return "", err
+	}
+
+	defer r.Close()
+	target, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+
+	return string(target), nil
+}
+
+type fileInfo struct {
+	name  string
+	entry *entry
+}
+
+func newFileInfo(name string, e *entry) os.FileInfo {
+	if name == "" || name == "." {
+		name = string(filepath.Separator)
+	}
+
+	return &fileInfo{name: name, entry: e}
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	if fi.entry.file == nil || fi.entry.dir {
+		return 0
+	}
+
+	return int64(fi.entry.file.UncompressedSize64)
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.entry.file == nil {
+		return os.ModeDir | 0755
+	}
+
+	mode := fi.entry.file.Mode()
+	if fi.entry.dir {
+		mode |= os.ModeDir
+	}
+
+	return mode
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	if fi.entry.file == nil {
+		return time.Time{}
+	}
+
+	return fi.entry.file.Modified
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.entry.dir
+}
+
+func (fi *fileInfo) Sys() interface{} {
+	if fi.entry.file == nil {
+		return nil
+	}
+
+	return &fi.entry.file.FileHeader
+}
+
+// clean returns the given path relative to the root of the archive, using
+// forward slashes as separator, and "" for the root itself.
+func clean(name string) string {
+	name = path.Clean("/" + filepath.ToSlash(name))
+	return strings.TrimPrefix(name, "/")
+}
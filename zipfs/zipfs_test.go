@@ -0,0 +1,191 @@
+package zipfs
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&ZipSuite{})
+
+type ZipSuite struct {
+	FS billy.Filesystem
+}
+
+func buildZip(c *C) *bytes.Reader {
+	buf := bytes.NewBuffer(nil)
+	w := zip.NewWriter(buf)
+
+	add := func(name string, mode os.FileMode, method uint16, content string) {
+		h := &zip.FileHeader{Name: name, Method: method}
+		h.SetMode(mode)
+		f, err := w.CreateHeader(h)
+		c.Assert(err, IsNil)
+		_, err = io.WriteString(f, content)
+		c.Assert(err, IsNil)
+	}
+
+	add("foo", 0644, zip.Deflate, "hello world")
+	add("qux/", os.ModeDir|0755, zip.Store, "")
+	add("qux/bar", 0600, zip.Store, "bar")
+	add("qux/baz/a", 0644, zip.Deflate, "a")
+	add("link", os.ModeSymlink|0777, zip.Store, "qux/bar")
+	add("dirlink", os.ModeSymlink|0777, zip.Store, "qux")
+
+	c.Assert(w.Close(), IsNil)
+	return bytes.NewReader(buf.Bytes())
+}
+
+func (s *ZipSuite) SetUpTest(c *C) {
+	r := buildZip(c)
+
+	var err error
+	s.FS, err = New(r, r.Size())
+	c.Assert(err, IsNil)
+}
+
+func (s *ZipSuite) TestOpen(c *C) {
+	f, err := s.FS.Open("foo")
+	c.Assert(err, IsNil)
+	c.Assert(f.Name(), Equals, "foo")
+
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "hello world")
+	c.Assert(f.Close(), IsNil)
+}
+
+func (s *ZipSuite) TestOpenNotExists(c *C) {
+	_, err := s.FS.Open("nope")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *ZipSuite) TestOpenDir(c *C) {
+	_, err := s.FS.Open("qux")
+	c.Assert(err, NotNil)
+}
+
+func (s *ZipSuite) TestSeekAndReadAt(c *C) {
+	f, err := s.FS.Open("foo")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	buf := make([]byte, 5)
+	n, err := f.ReadAt(buf, 6)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf[:n]), Equals, "world")
+
+	n, err = f.ReadAt(buf, 0)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf[:n]), Equals, "hello")
+
+	pos, err := f.Seek(-5, io.SeekEnd)
+	c.Assert(err, IsNil)
+	c.Assert(pos, Equals, int64(6))
+
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "world")
+
+	n, err = f.ReadAt(buf, 8)
+	c.Assert(err, Equals, io.EOF)
+	c.Assert(string(buf[:n]), Equals, "rld")
+}
+
+func (s *ZipSuite) TestStat(c *C) {
+	fi, err := s.FS.Stat("qux/bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Name(), Equals, "bar")
+	c.Assert(fi.Size(), Equals, int64(3))
+	c.Assert(fi.Mode(), Equals, os.FileMode(0600))
+	c.Assert(fi.IsDir(), Equals, false)
+
+	fi, err = s.FS.Stat("qux/baz")
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+
+	fi, err = s.FS.Stat("/")
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+}
+
+func (s *ZipSuite) TestSymlinks(c *C) {
+	fi, err := s.FS.Lstat("link")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode()&os.ModeSymlink, Not(Equals), os.FileMode(0))
+
+	fi, err = s.FS.Stat("link")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Name(), Equals, "link")
+	c.Assert(fi.Size(), Equals, int64(3))
+
+	target, err := s.FS.Readlink("link")
+	c.Assert(err, IsNil)
+	c.Assert(target, Equals, s.FS.Join("qux", "bar"))
+
+	f, err := s.FS.Open("dirlink/baz/a")
+	c.Assert(err, IsNil)
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "a")
+}
+
+func (s *ZipSuite) TestReadDir(c *C) {
+	entries, err := s.FS.ReadDir("/")
+	c.Assert(err, IsNil)
+
+	var names []string
+	for _, fi := range entries {
+		names = append(names, fi.Name())
+	}
+
+	c.Assert(names, DeepEquals, []string{"dirlink", "foo", "link", "qux"})
+
+	entries, err = s.FS.ReadDir("dirlink")
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 2)
+	c.Assert(entries[0].Name(), Equals, "bar")
+	c.Assert(entries[1].Name(), Equals, "baz")
+	c.Assert(entries[1].IsDir(), Equals, true)
+}
+
+func (s *ZipSuite) TestChroot(c *C) {
+	fs, err := s.FS.Chroot("qux")
+	c.Assert(err, IsNil)
+
+	fi, err := fs.Stat("bar")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Size(), Equals, int64(3))
+}
+
+func (s *ZipSuite) TestReadOnly(c *C) {
+	_, err := s.FS.Create("new")
+	c.Assert(err, Equals, billy.ErrReadOnly)
+
+	_, err = s.FS.OpenFile("foo", os.O_RDWR, 0)
+	c.Assert(err, Equals, billy.ErrReadOnly)
+
+	c.Assert(s.FS.Remove("foo"), Equals, billy.ErrReadOnly)
+	c.Assert(s.FS.Rename("foo", "bar"), Equals, billy.ErrReadOnly)
+	c.Assert(s.FS.MkdirAll("dir", 0755), Equals, billy.ErrReadOnly)
+	c.Assert(s.FS.Symlink("foo", "bar"), Equals, billy.ErrReadOnly)
+
+	f, err := s.FS.Open("foo")
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("foo"))
+	c.Assert(err, Equals, billy.ErrReadOnly)
+}
+
+func (s *ZipSuite) TestCapabilities(c *C) {
+	c.Assert(billy.CapabilityCheck(s.FS, billy.WriteCapability), Equals, false)
+	c.Assert(billy.CapabilityCheck(s.FS, billy.ReadCapability), Equals, true)
+}